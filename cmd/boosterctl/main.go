@@ -0,0 +1,1223 @@
+// cmd/boosterctl/main.go - Operator commands for submissions and payments, for use over SSH
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"sbcbackend/internal/archive"
+	"sbcbackend/internal/backup"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/household"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/preferences"
+	"sbcbackend/internal/privacy"
+	"sbcbackend/internal/reconcile"
+	"sbcbackend/internal/replica"
+)
+
+const usage = `Usage: boosterctl [-db path] <command> [flags]
+
+Commands:
+  list                 list submissions
+  show <form-id>       print one submission in full; a receipt number works too
+  history <form-id>    list every recorded change to a submission's fields
+  export               write submissions as CSV
+  mark-paid <form-id>  record a cash or check payment
+  refund <form-id>     refund a PayPal capture
+  reconcile            compare PayPal's transaction report with the database
+  email resend         send a submission's confirmation or admin email again
+  orders regen-page    rewrite event order pages with the current template
+  prefs show <email>   list a contact's notification preferences
+  prefs set            change which channels a contact gets a category of message on
+  privacy list         list parents' data deletion requests
+  privacy approve <id> anonymize the paid submissions a deletion request waits on
+  privacy reject <id>  keep them, telling the parent why
+  privacy erase <email>
+                       anonymize every submission made with an address, paid ones included
+  households report    compare paying and returning families year over year
+  households show <email>
+                       list a family's submissions across years
+  db status            list schema migrations and when each was applied
+  db migrate           apply pending schema migrations
+  db rollback          undo the latest schema migrations
+  db backup            snapshot a SQLite database, e.g. before migrating it
+  db restore           write the database replicated to DB_REPLICA_URL to a new file
+  db encrypt-pii       encrypt the personal details stored before the encryption key was set
+  db archive <year>    move a past school year's submissions to the archive tables
+  inventory lint <path>
+                       check an inventory.json before deploying it
+
+Run "boosterctl <command> -h" for the flags of each command.
+`
+
+func main() {
+	dbPath := flag.String("db", "./booster/data/booster.db", "SQLite database of the deployment (or tenant) to work on")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) error{
+		"list":       listCommand,
+		"show":       showCommand,
+		"history":    historyCommand,
+		"export":     exportCommand,
+		"mark-paid":  markPaidCommand,
+		"refund":     refundCommand,
+		"reconcile":  reconcileCommand,
+		"email":      emailCommand,
+		"orders":     ordersCommand,
+		"prefs":      prefsCommand,
+		"privacy":    privacyCommand,
+		"households": householdsCommand,
+		"db":         dbCommand,
+		"inventory":  inventoryCommand,
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config.LoadEnv()
+
+	// Show times as the club and the server's logs see them
+	loc, err := config.TimeZone()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	clock.SetLocation(loc)
+	time.Local = loc
+
+	// Inventory files are checked before they reach a server, and a restore writes a new
+	// database, so neither opens one
+	if flag.Arg(0) != "inventory" && !(flag.Arg(0) == "db" && flag.Arg(1) == "restore") {
+		// Only db migrate changes the schema; everything else expects it to be current
+		openDatabase(*dbPath, flag.Arg(0) != "db")
+		defer data.CloseDB()
+	}
+
+	if err := command(flag.Args()[1:]); err != nil {
+		data.CloseDB()
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+// openDatabase opens an existing database, optionally refusing one with pending migrations
+func openDatabase(dbPath string, requireCurrentSchema bool) {
+	settings, err := config.LoadDatabaseSettings()
+	if err != nil {
+		log.Fatalf("Invalid database settings: %v", err)
+	}
+	encryptionKey, err := config.LoadEncryptionKey()
+	if err == nil {
+		err = data.SetPIIKey(encryptionKey)
+	}
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	if settings.Driver == config.DatabasePostgres {
+		// -db names a SQLite file; a PostgreSQL deployment's DATABASE_URL is used instead
+		if err := data.InitDatabase(data.DriverPostgres, settings.URL); err != nil {
+			log.Fatalf("Failed to open PostgreSQL DB: %v", err)
+		}
+	} else {
+		// Unlike the server, never create a database: a wrong -db should fail, not start empty
+		if _, err := os.Stat(dbPath); err != nil {
+			log.Fatalf("Database %s: %v", dbPath, err)
+		}
+		if err := data.InitDB(dbPath); err != nil {
+			log.Fatalf("Failed to open SQLite DB: %v", err)
+		}
+	}
+	if !requireCurrentSchema {
+		return
+	}
+
+	pending, err := data.PendingSchemaMigrations()
+	if err != nil {
+		data.CloseDB()
+		log.Fatalf("Failed to check the database schema: %v", err)
+	}
+	if len(pending) > 0 {
+		data.CloseDB()
+		log.Fatalf("Database schema is out of date; run boosterctl db migrate first")
+	}
+}
+
+// filterFlags registers the flags shared by list and export
+func filterFlags(fs *flag.FlagSet) *data.SubmissionFilter {
+	filter := &data.SubmissionFilter{}
+	fs.StringVar(&filter.FormType, "type", "", "membership, event or fundraiser (default all)")
+	fs.IntVar(&filter.Year, "year", 0, "only submissions from this year")
+	fs.StringVar(&filter.Status, "status", "", "paid, unpaid, or a PayPal status such as REFUNDED")
+	fs.StringVar(&filter.Search, "search", "", "only names or emails containing this text, or this receipt number")
+	fs.StringVar(&filter.Funding, "funding", "", "only payments funded this way: paypal, venmo or card")
+	fs.StringVar(&filter.School, "school", "", "only submissions from this school")
+	fs.Func("from", "only submissions on or after this day, YYYY-MM-DD", func(value string) error {
+		day, err := time.ParseInLocation("2006-01-02", value, clock.Location())
+		filter.From = day
+		return err
+	})
+	fs.Func("to", "only submissions on or before this day, YYYY-MM-DD", func(value string) error {
+		day, err := time.ParseInLocation("2006-01-02", value, clock.Location())
+		filter.To = day.AddDate(0, 0, 1)
+		return err
+	})
+	return filter
+}
+
+// parseWithArg parses flags given before or after a command's single argument
+func parseWithArg(fs *flag.FlagSet, args []string, name string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() == 0 {
+		return "", fmt.Errorf("%s is required", name)
+	}
+	arg := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return "", err
+	}
+	if fs.NArg() > 0 {
+		return "", fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return arg, nil
+}
+
+func listCommand(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	filter := filterFlags(fs)
+	fs.IntVar(&filter.Limit, "limit", 50, "show only the most recent N submissions (0 for all)")
+	fs.IntVar(&filter.Offset, "offset", 0, "skip the most recent N submissions, to page back through older ones")
+	fs.Parse(args)
+
+	submissions, err := data.ListSubmissions(*filter)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORM ID\tRECEIPT\tDATE\tNAME\tEMAIL\tITEM\tAMOUNT\tSTATUS")
+	for _, sub := range submissions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t$%.2f\t%s\n",
+			sub.FormID, sub.ReceiptNumber, sub.SubmissionDate.In(clock.Location()).Format("2006-01-02 15:04"),
+			sub.FullName, sub.Email, sub.Item, sub.CalculatedAmount, displayStatus(sub))
+	}
+	tw.Flush()
+	fmt.Printf("%d submissions\n", len(submissions))
+	return nil
+}
+
+func showCommand(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	formID, err := parseWithArg(fs, args, "a form ID or receipt number")
+	if err != nil {
+		return err
+	}
+	if formID, err = resolveFormID(formID); err != nil {
+		return err
+	}
+
+	sub, err := loadSubmission(formID)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sub)
+}
+
+func historyCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	full := fs.Bool("full", false, "print long values whole instead of cutting them short")
+	formID, err := parseWithArg(fs, args, "a form ID or receipt number")
+	if err != nil {
+		return err
+	}
+	if formID, err = resolveFormID(formID); err != nil {
+		return err
+	}
+
+	entries, err := data.ListAuditLog(formID)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTOR\tACTION\tFIELD\tBEFORE\tAFTER")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.ChangedAt.In(clock.Location()).Format("2006-01-02 15:04:05"), entry.Actor, entry.Action,
+			entry.Field, displayAuditValue(entry.OldValue, *full), displayAuditValue(entry.NewValue, *full))
+	}
+	tw.Flush()
+	fmt.Printf("%d changes\n", len(entries))
+	return nil
+}
+
+// displayAuditValue shows a logged field value on one line, cut to 40 characters
+// unless full
+func displayAuditValue(value *string, full bool) string {
+	if value == nil {
+		return "NULL"
+	}
+	shown := strings.Join(strings.Fields(*value), " ")
+	if runes := []rune(shown); !full && len(runes) > 40 {
+		shown = string(runes[:39]) + "…"
+	}
+	if shown == "" {
+		return `""`
+	}
+	return shown
+}
+
+// resolveFormID takes a form or donation ID or a receipt number and returns the ID
+func resolveFormID(idOrReceipt string) (string, error) {
+	if _, err := data.FormTypeFromID(idOrReceipt); err == nil || strings.HasPrefix(idOrReceipt, "donation-") {
+		return idOrReceipt, nil
+	}
+	return data.FormIDForReceiptNumber(idOrReceipt)
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	filter := filterFlags(fs)
+	output := fs.String("o", "", "file to write (default standard output)")
+	fs.Parse(args)
+
+	submissions, err := data.ListSubmissions(*filter)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := writeCSV(w, submissions); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d submissions to %s\n", len(submissions), *output)
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, submissions []data.SubmissionSummary) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"form_type", "form_id", "submission_date", "full_name", "email", "school",
+		"item", "amount", "net_amount", "round_up", "paypal_status", "funding_source", "paypal_order_id", "submitted_at", "receipt_number"})
+
+	for _, sub := range submissions {
+		submittedAt := ""
+		if sub.SubmittedAt != nil {
+			submittedAt = sub.SubmittedAt.In(clock.Location()).Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			sub.FormType, sub.FormID, sub.SubmissionDate.In(clock.Location()).Format(time.RFC3339),
+			sub.FullName, sub.Email, sub.School, sub.Item,
+			strconv.FormatFloat(sub.CalculatedAmount, 'f', 2, 64), strconv.FormatFloat(sub.NetAmount, 'f', 2, 64),
+			strconv.FormatFloat(sub.RoundUp, 'f', 2, 64), sub.PayPalStatus, sub.FundingSource, sub.PayPalOrderID, submittedAt, sub.ReceiptNumber,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func markPaidCommand(args []string) error {
+	fs := flag.NewFlagSet("mark-paid", flag.ExitOnError)
+	method := fs.String("method", "cash", "how it was paid: cash, check or other")
+	reference := fs.String("reference", "", "check number or receipt reference")
+	note := fs.String("note", "", "note kept with the payment")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	formID, err := parseWithArg(fs, args, "a form ID")
+	if err != nil {
+		return err
+	}
+
+	summary, err := data.GetSubmissionSummary(formID)
+	if err != nil {
+		return err
+	}
+	if summary.PayPalStatus == "COMPLETED" {
+		return fmt.Errorf("%s is already paid", formID)
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Mark %s (%s, $%.2f) as paid by %s?",
+		formID, summary.FullName, summary.CalculatedAmount, *method)) {
+		return fmt.Errorf("cancelled")
+	}
+
+	now := clock.Now()
+	details, err := json.Marshal(map[string]interface{}{
+		"manual_payment": map[string]string{
+			"method":    *method,
+			"reference": *reference,
+			"note":      *note,
+			"marked_by": operator(),
+			"marked_at": now.Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	updated, err := data.MarkSubmissionPaid(summary.FormType, formID, string(details), now)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("%s was paid in the meantime; nothing changed", formID)
+	}
+
+	fmt.Printf("Marked %s as paid. No confirmation email is sent for manual payments.\n", formID)
+	return nil
+}
+
+func refundCommand(args []string) error {
+	fs := flag.NewFlagSet("refund", flag.ExitOnError)
+	amount := fs.Float64("amount", 0, "amount to refund (default the full order amount)")
+	note := fs.String("note", "Refund from the booster club", "note shown to the payer")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	formID, err := parseWithArg(fs, args, "a form ID")
+	if err != nil {
+		return err
+	}
+
+	summary, err := data.GetSubmissionSummary(formID)
+	if err != nil {
+		return err
+	}
+	if summary.PayPalStatus != "COMPLETED" {
+		return fmt.Errorf("%s is not a completed PayPal payment (status %q)", formID, summary.PayPalStatus)
+	}
+
+	details, err := paypalDetails(summary.FormType, formID)
+	if err != nil {
+		return err
+	}
+	captureID := data.ExtractPayPalCaptureID(details, formID)
+	if captureID == "" {
+		return fmt.Errorf("no PayPal capture found for %s; was it paid outside PayPal?", formID)
+	}
+
+	code, err := data.GetCurrency(summary.FormType, formID)
+	if err != nil {
+		return err
+	}
+	refundAmount := *amount
+	if refundAmount == 0 {
+		refundAmount = summary.CalculatedAmount
+	}
+	if refundAmount <= 0 || refundAmount > summary.CalculatedAmount+0.005 {
+		return fmt.Errorf("refund amount %s must be more than zero and at most the order amount %s",
+			currency.Format(refundAmount, code), currency.Format(summary.CalculatedAmount, code))
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Refund %s of %s on capture %s for %s (%s)?",
+		currency.Format(refundAmount, code), currency.Format(summary.CalculatedAmount, code), captureID, formID, summary.FullName)) {
+		return fmt.Errorf("cancelled")
+	}
+
+	if err := config.LoadPayPalConfig(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	token, err := payment.GetPayPalAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	refund, err := payment.RefundPayPalCapture(token, captureID, refundAmount, code, *note)
+	if err != nil {
+		return err
+	}
+	// Record it now rather than waiting on the webhook, under the same rules: a refund
+	// that leaves part of the order paid leaves the status alone
+	refundedTotal := refund.RefundedTotal()
+	if refundedTotal == 0 {
+		refundedTotal = refundAmount
+	}
+	if _, err := data.ApplyPayPalWebhook(summary.FormType, formID, "REFUNDED", string(refund.Raw), refundedTotal, data.ActorAdmin); err != nil {
+		return fmt.Errorf("refund %s succeeded but recording it failed: %w", refund.ID, err)
+	}
+
+	fmt.Printf("Refunded %s for %s: refund %s is %s\n", currency.Format(refundAmount, code), formID, refund.ID, refund.Status)
+	return nil
+}
+
+func reconcileCommand(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fromDate := fs.String("from", "", "first day to reconcile, YYYY-MM-DD")
+	toDate := fs.String("to", "", "last day to reconcile, YYYY-MM-DD (default the -from day)")
+	output := fs.String("o", "", "also write the mismatches as CSV to this file")
+	fs.Parse(args)
+
+	if *fromDate == "" {
+		return fmt.Errorf("usage: boosterctl reconcile -from YYYY-MM-DD [-to YYYY-MM-DD] [-o mismatches.csv]")
+	}
+	if *toDate == "" {
+		*toDate = *fromDate
+	}
+	from, err := time.ParseInLocation("2006-01-02", *fromDate, clock.Location())
+	if err != nil {
+		return fmt.Errorf("invalid -from date: %w", err)
+	}
+	lastDay, err := time.ParseInLocation("2006-01-02", *toDate, clock.Location())
+	if err != nil {
+		return fmt.Errorf("invalid -to date: %w", err)
+	}
+	to := lastDay.AddDate(0, 0, 1)
+	if !to.After(from) {
+		return fmt.Errorf("-to %s is before -from %s", *toDate, *fromDate)
+	}
+
+	if err := config.LoadPayPalConfig(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	report, err := reconcile.Run(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s to %s: %d PayPal transactions, %d submissions paid through PayPal\n",
+		*fromDate, *toDate, report.Transactions, report.Submissions)
+	if len(report.Mismatches) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tFORM ID\tTRANSACTION\tDATABASE\tPAYPAL\tDETAIL")
+		for _, m := range report.Mismatches {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s $%.2f\t%s $%.2f\t%s\n", m.Kind, m.FormID, m.TransactionID,
+				m.DBStatus, m.DBAmount, m.PayPalStatus, m.PayPalAmount, m.Detail)
+		}
+		w.Flush()
+	}
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := writeMismatchesCSV(file, report.Mismatches); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %d mismatches to %s\n", len(report.Mismatches), *output)
+	}
+
+	if len(report.Mismatches) > 0 {
+		return fmt.Errorf("%d mismatches between PayPal and the database", len(report.Mismatches))
+	}
+	fmt.Println("PayPal and the database agree.")
+	return nil
+}
+
+func writeMismatchesCSV(w io.Writer, mismatches []reconcile.Mismatch) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "form_id", "transaction_id", "db_status", "db_amount",
+		"paypal_status", "paypal_amount", "detail"})
+
+	for _, m := range mismatches {
+		cw.Write([]string{
+			m.Kind, m.FormID, m.TransactionID,
+			m.DBStatus, strconv.FormatFloat(m.DBAmount, 'f', 2, 64),
+			m.PayPalStatus, strconv.FormatFloat(m.PayPalAmount, 'f', 2, 64),
+			m.Detail,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func emailCommand(args []string) error {
+	if len(args) == 0 || args[0] != "resend" {
+		return fmt.Errorf("usage: boosterctl email resend --form-id <form-id> [--type confirmation|admin]")
+	}
+
+	fs := flag.NewFlagSet("email resend", flag.ExitOnError)
+	formID := fs.String("form-id", "", "submission to send the email for")
+	kind := fs.String("type", "confirmation", "confirmation (to the payer) or admin (to the club)")
+	fs.Parse(args[1:])
+	if *formID == "" {
+		return fmt.Errorf("--form-id is required")
+	}
+
+	summary, err := data.GetSubmissionSummary(*formID)
+	if err != nil {
+		return err
+	}
+	if summary.PayPalStatus != "COMPLETED" {
+		return fmt.Errorf("%s is not paid (status %q); its emails only go out after payment",
+			*formID, summary.PayPalStatus)
+	}
+
+	if *kind == "confirmation" && !preferences.Allows(summary.Email, preferences.Receipts, preferences.Email) {
+		return fmt.Errorf("%s turned off emailed receipts; see boosterctl prefs show %s", summary.Email, summary.Email)
+	}
+
+	switch *kind {
+	case "confirmation":
+		err = order.ResendConfirmationEmail(*formID)
+	case "admin":
+		err = order.ResendAdminNotification(*formID)
+	default:
+		return fmt.Errorf("unknown email type %q; use confirmation or admin", *kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	if email.LoadEmailConfig().MockMode {
+		fmt.Printf("EMAIL_MOCK_MODE is on: the %s email for %s was logged, not sent\n", *kind, *formID)
+	} else {
+		fmt.Printf("Sent the %s email for %s\n", *kind, *formID)
+	}
+	return nil
+}
+
+func prefsCommand(args []string) error {
+	const usage = "usage: boosterctl prefs show <email> | prefs set --email <email> --category <category> --channels email,sms|none|default"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+
+	switch args[0] {
+	case "show":
+		fs := flag.NewFlagSet("prefs show", flag.ExitOnError)
+		contact, err := parseWithArg(fs, args[1:], "an email address")
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CATEGORY\tCHANNELS\tSOURCE")
+		for _, category := range preferences.Categories {
+			channels, own, err := preferences.Channels(contact, category)
+			if err != nil {
+				return err
+			}
+			shown := strings.Join(channels, ",")
+			if shown == "" {
+				shown = "none"
+			}
+			source := "default"
+			if own {
+				source = "set by contact"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", category, shown, source)
+		}
+		return tw.Flush()
+
+	case "set":
+		fs := flag.NewFlagSet("prefs set", flag.ExitOnError)
+		contact := fs.String("email", "", "contact's email address")
+		category := fs.String("category", "", strings.Join(preferences.Categories, ", "))
+		channels := fs.String("channels", "", `comma separated channels (email, sms), "none", or "default" to clear`)
+		fs.Parse(args[1:])
+		if *contact == "" || *category == "" || *channels == "" {
+			return fmt.Errorf("--email, --category and --channels are required")
+		}
+
+		if err := preferences.Set(*contact, *category, *channels); err != nil {
+			return err
+		}
+		fmt.Printf("Set %s for %s to %s\n", *category, data.NormalizeContact(*contact), strings.ToLower(*channels))
+		return nil
+
+	default:
+		return fmt.Errorf(usage)
+	}
+}
+
+func privacyCommand(args []string) error {
+	const usage = "usage: boosterctl privacy list [--status status] | privacy approve <id> | privacy reject <id> --note <reason> | privacy erase <email>"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("privacy list", flag.ExitOnError)
+		status := fs.String("status", data.PrivacyPendingReview, `open, pending_review, completed, rejected, or "" for every deletion request`)
+		fs.Parse(args[1:])
+
+		requests, err := data.ListPrivacyRequests(*status)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tEMAIL\tREQUESTED\tDELETION ASKED\tSTATUS\tREVIEWED BY\tNOTE")
+		for _, request := range requests {
+			asked := ""
+			if request.DeletionRequestedAt != nil {
+				asked = request.DeletionRequestedAt.In(clock.Location()).Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", request.ID, request.Email,
+				request.CreatedAt.In(clock.Location()).Format("2006-01-02 15:04"), asked, request.Status,
+				request.ReviewedBy, request.ReviewNote)
+		}
+		return tw.Flush()
+
+	case "approve", "reject":
+		fs := flag.NewFlagSet("privacy "+args[0], flag.ExitOnError)
+		note := fs.String("note", "", "reason for rejecting, sent to the parent")
+		arg, err := parseWithArg(fs, args[1:], "a request ID")
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("request ID %q is not a number", arg)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if args[0] == "reject" {
+			if strings.TrimSpace(*note) == "" {
+				return fmt.Errorf("--note is required; it tells the parent why")
+			}
+			if err := privacy.Reject(ctx, id, operator(), *note); err != nil {
+				return err
+			}
+			fmt.Printf("Rejected privacy request %d\n", id)
+			return nil
+		}
+
+		request, err := data.GetPrivacyRequest(id)
+		if err != nil {
+			return err
+		}
+		if request == nil {
+			return fmt.Errorf("no privacy request %d", id)
+		}
+		if !confirm(fmt.Sprintf("Anonymize the submissions of %s? This cannot be undone.", request.Email)) {
+			return fmt.Errorf("cancelled")
+		}
+		if err := privacy.Approve(ctx, id, operator()); err != nil {
+			return err
+		}
+		fmt.Printf("Anonymized the submissions of privacy request %d\n", id)
+		return nil
+
+	case "erase":
+		fs := flag.NewFlagSet("privacy erase", flag.ExitOnError)
+		address, err := parseWithArg(fs, args[1:], "an email address")
+		if err != nil {
+			return err
+		}
+		submissions, err := data.ListSubmissionsByEmail(address)
+		if err != nil {
+			return err
+		}
+		archived, err := data.ListArchivedSubmissionsByEmail(address)
+		if err != nil {
+			return err
+		}
+		if !confirm(fmt.Sprintf("Anonymize all %d submissions of %s? This cannot be undone.", len(submissions)+len(archived), address)) {
+			return fmt.Errorf("cancelled")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		anonymized, err := privacy.Erase(ctx, address, operator())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Anonymized %d submissions of %s\n", anonymized, address)
+		return nil
+
+	default:
+		return fmt.Errorf(usage)
+	}
+}
+
+func householdsCommand(args []string) error {
+	const usage = "usage: boosterctl households report | households show <email>"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+
+	switch args[0] {
+	case "report":
+		report, err := household.Report()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "YEAR\tHOUSEHOLDS\tNEW\tRETURNING\tRETENTION\tMEMBERSHIPS\tEVENTS\tFUNDRAISERS\tTOTAL")
+		for _, year := range report {
+			retention := "-"
+			if year.Previous > 0 {
+				retention = fmt.Sprintf("%.0f%%", year.Retention*100)
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\t%.2f\t%.2f\t%.2f\t%.2f\n", year.Year, year.Households,
+				year.New, year.Returning, retention, year.ByFormType["membership"], year.ByFormType["event"],
+				year.ByFormType["fundraiser"], year.Amount)
+		}
+		return tw.Flush()
+
+	case "show":
+		fs := flag.NewFlagSet("households show", flag.ExitOnError)
+		emailAddress, err := parseWithArg(fs, args[1:], "an email address")
+		if err != nil {
+			return err
+		}
+		found, err := data.GetHouseholdByEmail(emailAddress)
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return fmt.Errorf("no household for %s", emailAddress)
+		}
+		orders, err := data.ListHouseholdOrders(found.ID)
+		if err != nil {
+			return err
+		}
+
+		verified := "not yet"
+		if found.VerifiedAt != nil {
+			verified = found.VerifiedAt.In(clock.Location()).Format("2006-01-02 15:04")
+		}
+		fmt.Printf("Household %d <%s>, since %s, verified %s\n\n", found.ID, found.Email,
+			found.CreatedAt.In(clock.Location()).Format("2006-01-02"), verified)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FORM ID\tTYPE\tDATE\tNAME\tITEM\tAMOUNT\tSTATUS\tRECEIPT")
+		for _, sub := range orders {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.2f\t%s\t%s\n", sub.FormID, sub.FormType,
+				sub.SubmissionDate.In(clock.Location()).Format("2006-01-02"), sub.FullName, sub.Item,
+				sub.CalculatedAmount, sub.PayPalStatus, sub.ReceiptNumber)
+		}
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf(usage)
+	}
+}
+
+func ordersCommand(args []string) error {
+	if len(args) == 0 || args[0] != "regen-page" {
+		return fmt.Errorf("usage: boosterctl orders regen-page --form-id <form-id> | --event <name> --all [--year YYYY]")
+	}
+
+	fs := flag.NewFlagSet("orders regen-page", flag.ExitOnError)
+	formID := fs.String("form-id", "", "event registration whose page to rewrite")
+	eventName := fs.String("event", "", "event whose pages to rewrite (with --all)")
+	all := fs.Bool("all", false, "rewrite the page of every paid registration for --event")
+	year := fs.Int("year", 0, "only registrations submitted in this year (with --event)")
+	fs.Parse(args[1:])
+
+	var formIDs []string
+	switch {
+	case *formID != "" && *eventName == "":
+		formIDs = append(formIDs, *formID)
+	case *formID == "" && *eventName != "" && *all:
+		registrations, err := data.ListSubmissions(data.SubmissionFilter{FormType: "event", Year: *year, Status: "paid"})
+		if err != nil {
+			return err
+		}
+		for _, sub := range registrations {
+			if strings.EqualFold(sub.Item, *eventName) {
+				formIDs = append(formIDs, sub.FormID)
+			}
+		}
+		if len(formIDs) == 0 {
+			return fmt.Errorf("no paid registrations for event %q", *eventName)
+		}
+	case *eventName != "" && !*all:
+		return fmt.Errorf("--event rewrites every page for the event; add --all to confirm")
+	default:
+		return fmt.Errorf("pass either --form-id or --event with --all")
+	}
+
+	rewritten, skipped, failed := 0, 0, 0
+	for _, id := range formIDs {
+		sub, err := data.GetEventByID(id)
+		if err == nil && *all && sub.FoodOrderID == "" {
+			// Registrations without food orders never get a page
+			skipped++
+			continue
+		}
+		if err == nil {
+			var url string
+			if url, err = order.RebuildEventOrderPage(sub); err == nil {
+				fmt.Printf("Rewrote %s: %s\n", id, url)
+				rewritten++
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+		failed++
+	}
+
+	if *all {
+		fmt.Printf("Rewrote %d order pages (%d registrations without food orders skipped)\n", rewritten, skipped)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d order pages failed", failed, len(formIDs))
+	}
+	return nil
+}
+
+// restoreCommand writes the replicated database to a new file. It runs without a
+// database open, since the one it replaces may be gone with the disk it was on.
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("db restore", flag.ExitOnError)
+	output := fs.String("o", "", "path to write the restored database to; it must not exist")
+	generation := fs.String("generation", "", "generation to restore instead of the newest")
+	fs.Parse(args)
+	if *output == "" {
+		return fmt.Errorf("usage: boosterctl db restore -o path [-generation name]")
+	}
+
+	settings, err := config.LoadReplicaSettings()
+	if err != nil {
+		return err
+	}
+	store, err := replica.OpenStore(settings)
+	if err != nil {
+		return err
+	}
+	restored, err := replica.Restore(context.Background(), store, *output, *generation)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored generation %s, replaying %d log segments, to %s (%d bytes)\n",
+		restored.Generation, restored.Segments, *output, restored.Size)
+	fmt.Println("Stop the server, move it over the database (removing any -wal and -shm files beside it) and start the server again")
+	return nil
+}
+
+func dbCommand(args []string) error {
+	const usage = "usage: boosterctl db status | db migrate [-to version] | db rollback [-to version | -steps n] | db backup [-dir path] | db restore -o path [-generation name] | db encrypt-pii [-decrypt] | db archive <year>"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+	if args[0] == "restore" {
+		return restoreCommand(args[1:])
+	}
+
+	conn, err := data.GetDB()
+	if err != nil {
+		return err
+	}
+	states, err := data.MigrationStatus(conn)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		pending := 0
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tMIGRATION\tAPPLIED")
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = state.AppliedAt.In(clock.Location()).Format("2006-01-02 15:04")
+			} else {
+				pending++
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", state.Version, state.Name, applied)
+		}
+		tw.Flush()
+		if pending > 0 {
+			return fmt.Errorf("%d schema migrations pending", pending)
+		}
+		fmt.Println("Schema is up to date")
+		return nil
+
+	case "migrate":
+		fs := flag.NewFlagSet("db migrate", flag.ExitOnError)
+		to := fs.Int("to", data.LatestSchemaVersion(), "version to migrate up to")
+		fs.Parse(args[1:])
+
+		applying := 0
+		for _, state := range states {
+			if state.AppliedAt == nil && state.Version <= *to {
+				fmt.Printf("applying: %d %s\n", state.Version, state.Name)
+				applying++
+			}
+		}
+		if applying == 0 {
+			fmt.Println("Nothing to apply")
+			return nil
+		}
+		if *to >= data.LatestSchemaVersion() {
+			err = data.CreateTables()
+		} else {
+			err = data.MigrateTo(conn, *to)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", applying)
+		return nil
+
+	case "rollback":
+		fs := flag.NewFlagSet("db rollback", flag.ExitOnError)
+		to := fs.Int("to", -1, "version to roll back to; every migration after it is undone")
+		steps := fs.Int("steps", 1, "how many of the latest migrations to undo, without -to")
+		fs.Parse(args[1:])
+
+		var applied []data.MigrationState
+		for _, state := range states {
+			if state.AppliedAt != nil {
+				applied = append(applied, state)
+			}
+		}
+		target := *to
+		if target < 0 {
+			if *steps < 1 || *steps > len(applied) {
+				return fmt.Errorf("-steps must be between 1 and the %d applied migrations", len(applied))
+			}
+			target = 0
+			if keep := len(applied) - *steps; keep > 0 {
+				target = applied[keep-1].Version
+			}
+		}
+
+		undoing := 0
+		for i := len(applied) - 1; i >= 0 && applied[i].Version > target; i-- {
+			fmt.Printf("rolling back: %d %s\n", applied[i].Version, applied[i].Name)
+			undoing++
+		}
+		if undoing == 0 {
+			fmt.Println("Nothing to roll back")
+			return nil
+		}
+		if err := data.RollbackTo(conn, target); err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d migrations; the schema is at version %d\n", undoing, target)
+		return nil
+
+	case "backup":
+		fs := flag.NewFlagSet("db backup", flag.ExitOnError)
+		settings := config.LoadBackupSettings()
+		fs.StringVar(&settings.Directory, "dir", settings.Directory, "directory to write the backup to; older backups beyond DB_BACKUP_KEEP are deleted")
+		fs.Parse(args[1:])
+
+		created, err := backup.Create(context.Background(), settings)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backed up the database to %s (%d bytes)\n", filepath.Join(settings.Directory, created.Name), created.Size)
+		return nil
+
+	case "encrypt-pii":
+		fs := flag.NewFlagSet("db encrypt-pii", flag.ExitOnError)
+		decrypt := fs.Bool("decrypt", false, "decrypt them instead, before unsetting or changing the key")
+		fs.Parse(args[1:])
+
+		for _, state := range states {
+			if state.AppliedAt == nil {
+				return fmt.Errorf("database schema is out of date; run boosterctl db migrate first")
+			}
+		}
+		if !data.PIIEncryptionEnabled() {
+			return fmt.Errorf("no encryption key; set %s or %s", config.EnvSettingName("PII_ENCRYPTION_KEY"),
+				config.EnvSettingName("PII_ENCRYPTION_KEY_FILE"))
+		}
+		rewrite, done := data.EncryptPersonalDetails, "Encrypted"
+		if *decrypt {
+			rewrite, done = data.DecryptPersonalDetails, "Decrypted"
+		}
+		changed, err := rewrite()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s the personal details of %d rows\n", done, changed)
+		return nil
+
+	case "archive":
+		fs := flag.NewFlagSet("db archive", flag.ExitOnError)
+		value, err := parseWithArg(fs, args[1:], "the year a school year starts, such as 2024")
+		if err != nil {
+			return err
+		}
+		year, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid year %q", value)
+		}
+		for _, state := range states {
+			if state.AppliedAt == nil {
+				return fmt.Errorf("database schema is out of date; run boosterctl db migrate first")
+			}
+		}
+		from, to := archive.Bounds(year)
+		if !confirm(fmt.Sprintf("Archive the submissions of school year %s (%s to %s)? They'll be read-only.",
+			archive.Label(year), from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))) {
+			return fmt.Errorf("cancelled")
+		}
+
+		result, err := archive.Archive(year)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Archived %d memberships, %d event registrations and %d fundraiser donations of %s\n",
+			result.Archived["membership"], result.Archived["event"], result.Archived["fundraiser"], archive.Label(year))
+		if result.Kept > 0 {
+			fmt.Printf("Kept %d still open, such as installment plans and disputed payments; archive the year again once they're settled\n", result.Kept)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown db command %q; use status, migrate, rollback, backup, restore, encrypt-pii or archive", args[0])
+	}
+}
+
+func inventoryCommand(args []string) error {
+	if len(args) == 0 || args[0] != "lint" {
+		return fmt.Errorf("usage: boosterctl inventory lint [-against path] <path>")
+	}
+
+	fs := flag.NewFlagSet("inventory lint", flag.ExitOnError)
+	against := fs.String("against", config.GetEnvBasedSetting("INVENTORY_JSON_PATH"),
+		"catalog to diff against (default the server's INVENTORY_JSON_PATH)")
+	path, err := parseWithArg(fs, args[1:], "an inventory file to check")
+	if err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	next, problems := inventory.Lint(raw)
+
+	errorCount := 0
+	for _, problem := range problems {
+		fmt.Println(problem)
+		if !problem.Warning {
+			errorCount++
+		}
+	}
+
+	switch {
+	case *against == "":
+		fmt.Println("No INVENTORY_JSON_PATH configured; pass -against to compare with the live catalog")
+	case *against == path:
+		fmt.Printf("%s is the live catalog; nothing to compare\n", path)
+	default:
+		currentRaw, err := os.ReadFile(*against)
+		if err != nil {
+			return fmt.Errorf("reading the live catalog: %w", err)
+		}
+		var current inventory.InventoryData
+		if err := json.Unmarshal(currentRaw, &current); err != nil {
+			return fmt.Errorf("parsing the live catalog %s: %w", *against, err)
+		}
+
+		changes := inventory.Diff(current, next)
+		fmt.Printf("Changes versus %s: %d\n", *against, len(changes))
+		for _, change := range changes {
+			fmt.Println("  " + change)
+		}
+	}
+
+	fmt.Printf("%d errors, %d warnings\n", errorCount, len(problems)-errorCount)
+	if errorCount > 0 {
+		return fmt.Errorf("%s is not safe to deploy", path)
+	}
+	return nil
+}
+
+// loadSubmission returns the full record of one submission
+func loadSubmission(formID string) (interface{}, error) {
+	formType, err := data.FormTypeFromID(formID)
+	if err != nil {
+		return nil, err
+	}
+	switch formType {
+	case "membership":
+		return data.GetMembershipByID(formID)
+	case "event":
+		return data.GetEventByID(formID)
+	default:
+		return data.GetFundraiserByID(formID)
+	}
+}
+
+func paypalDetails(formType, formID string) (string, error) {
+	sub, err := loadSubmission(formID)
+	if err != nil {
+		return "", err
+	}
+	switch sub := sub.(type) {
+	case *data.MembershipSubmission:
+		return sub.PayPalDetails, nil
+	case *data.EventSubmission:
+		return sub.PayPalDetails, nil
+	case *data.FundraiserSubmission:
+		return sub.PayPalDetails, nil
+	}
+	return "", fmt.Errorf("unexpected %s submission", formType)
+}
+
+func displayStatus(sub data.SubmissionSummary) string {
+	if sub.PayPalStatus != "" {
+		return sub.PayPalStatus
+	}
+	return "unpaid"
+}
+
+// confirm asks a yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// operator names who ran the command, for the record kept with manual payments
+func operator() string {
+	if user := os.Getenv("SUDO_USER"); user != "" {
+		return user
+	}
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "boosterctl"
+}
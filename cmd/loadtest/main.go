@@ -0,0 +1,355 @@
+// cmd/loadtest/main.go - Drives the membership checkout flow over HTTP and reports latency percentiles
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Steps of one checkout, in the order a browser makes them
+var steps = []string{"csrf-token", "submit-form", "save-payment", "create-order", "capture-order"}
+
+// The checkout redirect page hands the form ID and access token to the browser via sessionStorage
+var (
+	accessTokenPattern = regexp.MustCompile(`sessionStorage\.setItem\('accessToken', ("[^"]+")\)`)
+	formIDPattern      = regexp.MustCompile(`sessionStorage\.setItem\('formID', ("[^"]+")\)`)
+)
+
+type options struct {
+	baseURL    string
+	membership string
+	addons     []string
+	stepDelay  time.Duration
+	capture    bool
+}
+
+// results collects per-step latencies and failures from every worker
+type results struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	failures  map[string]int
+	checkouts []time.Duration
+	firstErrs []string
+}
+
+func (r *results) record(step string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.failures[step]++
+		if len(r.firstErrs) < 5 {
+			r.firstErrs = append(r.firstErrs, fmt.Sprintf("%s: %v", step, err))
+		}
+		return
+	}
+	r.latencies[step] = append(r.latencies[step], d)
+}
+
+func (r *results) recordCheckout(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkouts = append(r.checkouts, d)
+}
+
+func main() {
+	target := flag.String("url", "http://localhost:8080", "base URL of the server under test")
+	concurrency := flag.Int("c", 10, "number of checkouts running at once")
+	total := flag.Int("n", 100, "total number of checkouts to run")
+	membership := flag.String("membership", "Basic Membership", "membership name from the target's inventory")
+	addons := flag.String("addons", "", "comma-separated add-on names from the target's inventory")
+	stepDelay := flag.Duration("step-delay", 2100*time.Millisecond, "pause between token-authenticated calls; the server allows one per token every 2s")
+	capture := flag.Bool("capture", true, "capture each order; disable when the target talks to a PayPal that needs buyer approval")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-request timeout")
+	flag.Parse()
+
+	if *concurrency < 1 || *total < 1 {
+		log.Fatalf("-c and -n must be at least 1")
+	}
+
+	opts := options{
+		baseURL:    strings.TrimRight(*target, "/"),
+		membership: *membership,
+		stepDelay:  *stepDelay,
+		capture:    *capture,
+	}
+	if *addons != "" {
+		opts.addons = strings.Split(*addons, ",")
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        *concurrency * 2,
+			MaxIdleConnsPerHost: *concurrency * 2,
+		},
+	}
+	res := &results{latencies: map[string][]time.Duration{}, failures: map[string]int{}}
+
+	log.Printf("Running %d checkouts against %s with concurrency %d", *total, opts.baseURL, *concurrency)
+
+	var next int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i > *total {
+					return
+				}
+				runCheckout(client, opts, i, res)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	failed := report(os.Stdout, res, *total, elapsed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runCheckout walks one family through the whole flow, stopping at the first failed step.
+// Each checkout gets its own X-Forwarded-For address so the per-IP submit limit applies
+// per simulated visitor, as it would in production.
+func runCheckout(client *http.Client, opts options, i int, res *results) {
+	ip := fmt.Sprintf("10.%d.%d.%d", (i>>16)&0xff, (i>>8)&0xff, i&0xff)
+	var busy time.Duration
+
+	timed := func(step string, fn func() error) bool {
+		began := time.Now()
+		err := fn()
+		d := time.Since(began)
+		busy += d
+		res.record(step, d, err)
+		return err == nil
+	}
+
+	var csrf string
+	if !timed("csrf-token", func() (err error) {
+		csrf, err = fetchCSRFToken(client, opts.baseURL, ip)
+		return err
+	}) {
+		return
+	}
+
+	var formID, accessToken string
+	if !timed("submit-form", func() (err error) {
+		formID, accessToken, err = submitForm(client, opts.baseURL, ip, csrf, i)
+		return err
+	}) {
+		return
+	}
+
+	if !timed("save-payment", func() error {
+		return postJSON(client, opts.baseURL+"/api/save-membership-payment", ip, accessToken, map[string]interface{}{
+			"formID":     formID,
+			"membership": opts.membership,
+			"addons":     opts.addons,
+			"fees":       map[string]int{},
+			"cover_fees": true,
+		}, nil)
+	}) {
+		return
+	}
+
+	time.Sleep(opts.stepDelay)
+	var created struct {
+		Data struct {
+			OrderID string `json:"orderID"`
+		} `json:"data"`
+	}
+	if !timed("create-order", func() error {
+		if err := postJSON(client, opts.baseURL+"/api/create-order", ip, accessToken, map[string]string{"formID": formID}, &created); err != nil {
+			return err
+		}
+		if created.Data.OrderID == "" {
+			return fmt.Errorf("no order ID in response")
+		}
+		return nil
+	}) {
+		return
+	}
+
+	if opts.capture {
+		time.Sleep(opts.stepDelay)
+		if !timed("capture-order", func() error {
+			return postJSON(client, opts.baseURL+"/api/capture-order", ip, accessToken,
+				map[string]string{"orderID": created.Data.OrderID, "formID": formID}, nil)
+		}) {
+			return
+		}
+	}
+
+	res.recordCheckout(busy)
+}
+
+func fetchCSRFToken(client *http.Client, baseURL, ip string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/csrf-token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Forwarded-For", ip)
+
+	var body struct {
+		Token string `json:"csrf_token"`
+	}
+	if err := do(client, req, &body); err != nil {
+		return "", err
+	}
+	if body.Token == "" {
+		return "", fmt.Errorf("empty CSRF token")
+	}
+	return body.Token, nil
+}
+
+// submitForm posts a membership form and reads the form ID and access token off the redirect page
+func submitForm(client *http.Client, baseURL, ip, csrf string, i int) (string, string, error) {
+	form := url.Values{
+		"csrf_token":        {csrf},
+		"form_type":         {"membership"},
+		"full_name":         {fmt.Sprintf("Load Test %d", i)},
+		"email":             {fmt.Sprintf("loadtest+%d.%d@example.com", time.Now().Unix(), i)},
+		"school":            {"load-test-elementary"},
+		"describe":          {"parent"},
+		"membership_status": {"new"},
+		"student_count":     {"1"},
+		"student_1_name":    {fmt.Sprintf("Student %d", i)},
+		"student_1_grade":   {"3"},
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/api/submit-form", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", ip)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(page)))
+	}
+
+	token := accessTokenPattern.FindSubmatch(page)
+	formID := formIDPattern.FindSubmatch(page)
+	if token == nil || formID == nil {
+		return "", "", fmt.Errorf("checkout redirect page has no form ID or access token")
+	}
+	// The page writes them as JavaScript string literals
+	var id, accessToken string
+	if err := json.Unmarshal(formID[1], &id); err != nil {
+		return "", "", fmt.Errorf("bad form ID on checkout redirect page: %w", err)
+	}
+	if err := json.Unmarshal(token[1], &accessToken); err != nil {
+		return "", "", fmt.Errorf("bad access token on checkout redirect page: %w", err)
+	}
+	return id, accessToken, nil
+}
+
+func postJSON(client *http.Client, endpoint, ip, accessToken string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", accessToken)
+	req.Header.Set("X-Forwarded-For", ip)
+	return do(client, req, out)
+}
+
+// do sends req and decodes a JSON response into out when out is non-nil
+func do(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return nil
+}
+
+// report prints per-step percentiles and returns the number of failed checkouts
+func report(w io.Writer, res *results, total int, elapsed time.Duration) int {
+	completed := len(res.checkouts)
+	failed := total - completed
+
+	fmt.Fprintf(w, "\n%d checkouts in %v: %d completed, %d failed (%.1f checkouts/sec)\n\n",
+		total, elapsed.Round(time.Millisecond), completed, failed, float64(completed)/elapsed.Seconds())
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "step\tok\tfailed\tp50\tp90\tp95\tp99\tmax\t")
+	for _, step := range steps {
+		writeRow(tw, step, res.latencies[step], res.failures[step])
+	}
+	// Checkout time is the sum of request latencies, excluding -step-delay pauses
+	writeRow(tw, "checkout", res.checkouts, failed)
+	tw.Flush()
+
+	if len(res.firstErrs) > 0 {
+		fmt.Fprintln(w, "\nFirst errors:")
+		for _, e := range res.firstErrs {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+	return failed
+}
+
+func writeRow(w io.Writer, step string, latencies []time.Duration, failures int) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Fprintf(w, "%s\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t\n", step, len(latencies), failures,
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 95),
+		percentile(latencies, 99), percentile(latencies, 100))
+}
+
+// percentile uses the nearest-rank method on sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(100 * time.Microsecond)
+}
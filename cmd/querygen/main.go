@@ -0,0 +1,486 @@
+// cmd/querygen/main.go - Generates typed Go functions for the SQL queries in internal/data/queries
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// A query file holds queries written like this one, each ending in a semicolon:
+//
+//	-- name: GetEventSubmission :one
+//	-- row: eventSubmissionRow
+//	-- column: receipt_number string
+//	-- Loads one event submission
+//	SELECT form_id, COALESCE(receipt_number, '') AS receipt_number
+//	FROM event_submissions WHERE form_id = @form_id;
+//
+// :one returns a row, :many a slice of rows and :exec the sql.Result. @name marks a
+// parameter; one named after a column of the table the query reads or writes takes that
+// column's type, and any other is declared with "-- param: name type". A result column
+// that isn't a plain table column, such as a COALESCE, is declared with "-- column:".
+// Queries naming the same row type must return the same columns. Other comment lines
+// document the generated function.
+//
+// Every query is prepared against the schema file, a dump of the migrated schema kept
+// current by the test suite, so one naming a column that doesn't exist fails here rather
+// than at runtime.
+
+var (
+	directivePattern = regexp.MustCompile(`^--\s*(name|row|param|column):\s*(.*)$`)
+	paramPattern     = regexp.MustCompile(`@([a-z_][a-z0-9_]*)`)
+	tablePattern     = regexp.MustCompile(`(?is)\b(?:FROM|INTO|UPDATE)\s+([a-z_][a-z0-9_]*)`)
+)
+
+// maxParams is the most parameters a generated function takes before taking a struct
+const maxParams = 3
+
+// Words spelled in capitals in Go names, and PayPal's own capitalization
+var initialisms = map[string]string{
+	"id": "ID", "url": "URL", "json": "JSON", "sms": "SMS", "api": "API", "paypal": "PayPal",
+}
+
+type query struct {
+	name, kind, row string
+	doc             []string
+	sql             string
+	params          []param           // in the order the function takes them
+	args            []string          // parameter names in the order the SQL uses them
+	paramTypes      map[string]string // from -- param
+	columnTypes     map[string]string // from -- column
+	columns         []param           // result columns
+	source          string
+}
+
+type param struct {
+	name, goType string
+}
+
+type tableColumn struct {
+	declType string
+	notNull  bool
+}
+
+func main() {
+	schemaPath := flag.String("schema", "queries/schema.sql", "dump of the migrated schema")
+	queryDir := flag.String("queries", "queries", "directory of .sql query files")
+	out := flag.String("out", "queries.gen.go", "Go file to write")
+	pkg := flag.String("package", "data", "package of the generated file")
+	flag.Parse()
+
+	schema, err := os.ReadFile(*schemaPath)
+	if err != nil {
+		log.Fatalf("Failed to read schema: %v", err)
+	}
+	// The data package's SQL functions, which only need to exist for queries to prepare
+	for _, name := range []string{"pii_open", "pii_terms"} {
+		if err := sqlite.RegisterDeterministicScalarFunction(name, 1, passThrough); err != nil {
+			log.Fatalf("Failed to register %s: %v", name, err)
+		}
+	}
+	conn, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		log.Fatalf("Failed to open schema database: %v", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1) // each connection to :memory: is its own database
+	if _, err := conn.Exec(string(schema)); err != nil {
+		log.Fatalf("Failed to load schema %s: %v", *schemaPath, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(*queryDir, "*.sql"))
+	if err != nil {
+		log.Fatalf("Failed to list queries: %v", err)
+	}
+	var queries []*query
+	for _, file := range files {
+		if filepath.Clean(file) == filepath.Clean(*schemaPath) {
+			continue
+		}
+		parsed, err := parseFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		queries = append(queries, parsed...)
+	}
+
+	for _, q := range queries {
+		if err := resolve(conn, q); err != nil {
+			log.Fatalf("%s: %s: %v", q.source, q.name, err)
+		}
+	}
+
+	src, err := generate(*pkg, queries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+}
+
+// passThrough stands in for a SQL function taking one argument, returning it
+func passThrough(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	return args[0], nil
+}
+
+// parseFile splits a query file into its queries
+func parseFile(path string) ([]*query, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var queries []*query
+	var current *query
+	var body strings.Builder
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		current.sql = strings.TrimSuffix(strings.TrimSpace(body.String()), ";")
+		if current.sql == "" {
+			return fmt.Errorf("%s: %s has no SQL", path, current.name)
+		}
+		queries = append(queries, current)
+		body.Reset()
+		return nil
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if match := directivePattern.FindStringSubmatch(trimmed); match != nil {
+			value := strings.TrimSpace(match[2])
+			if match[1] == "name" {
+				if err := finish(); err != nil {
+					return nil, err
+				}
+				fields := strings.Fields(value)
+				if len(fields) != 2 || (fields[1] != ":one" && fields[1] != ":many" && fields[1] != ":exec") {
+					return nil, fmt.Errorf("%s: want \"-- name: Name :one|:many|:exec\", got %q", path, trimmed)
+				}
+				current = &query{name: fields[0], kind: fields[1], source: path,
+					paramTypes: map[string]string{}, columnTypes: map[string]string{}}
+				continue
+			}
+			if current == nil {
+				return nil, fmt.Errorf("%s: %q before the first -- name", path, trimmed)
+			}
+			switch match[1] {
+			case "row":
+				current.row = value
+			case "param", "column":
+				fields := strings.Fields(value)
+				if len(fields) != 2 {
+					return nil, fmt.Errorf("%s: want \"-- %s: name type\", got %q", path, match[1], trimmed)
+				}
+				if match[1] == "param" {
+					current.paramTypes[fields[0]] = fields[1]
+				} else {
+					current.columnTypes[fields[0]] = fields[1]
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--") && body.Len() == 0 {
+			current.doc = append(current.doc, strings.TrimSpace(strings.TrimPrefix(trimmed, "--")))
+			continue
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// resolve types a query's parameters and result columns from the schema
+func resolve(conn *sql.DB, q *query) error {
+	table := ""
+	if match := tablePattern.FindStringSubmatch(q.sql); match != nil {
+		table = match[1]
+	}
+	columns, err := tableColumns(conn, table)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	q.sql = paramPattern.ReplaceAllStringFunc(q.sql, func(match string) string {
+		name := match[1:]
+		q.args = append(q.args, name)
+		if !seen[name] {
+			seen[name] = true
+			q.params = append(q.params, param{name: name})
+		}
+		return "?"
+	})
+	for i, p := range q.params {
+		if goType, ok := q.paramTypes[p.name]; ok {
+			q.params[i].goType = goType
+			continue
+		}
+		column, ok := columns[p.name]
+		if !ok {
+			return fmt.Errorf("parameter @%s is no column of %q; declare its type with -- param", p.name, table)
+		}
+		q.params[i].goType = goType(column.declType, false)
+	}
+
+	stmt, err := conn.Prepare(q.sql)
+	if err != nil {
+		return fmt.Errorf("doesn't match the schema: %w", err)
+	}
+	defer stmt.Close()
+	if q.kind == ":exec" {
+		return nil
+	}
+
+	// Run it with zero values: SQLite rejects NULL where it needs a number, as in LIMIT
+	types := map[string]string{}
+	for _, p := range q.params {
+		types[p.name] = p.goType
+	}
+	args := make([]interface{}, len(q.args))
+	for i, name := range q.args {
+		args[i] = zeroValue(types[name])
+	}
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return fmt.Errorf("doesn't run on the schema: %w", err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	for _, ct := range columnTypes {
+		name := ct.Name()
+		if goType, ok := q.columnTypes[name]; ok {
+			q.columns = append(q.columns, param{name: name, goType: goType})
+			continue
+		}
+		if ct.DatabaseTypeName() == "" {
+			return fmt.Errorf("result column %q isn't a table column; alias it and declare its type with -- column", name)
+		}
+		// Only the table the query names says whether a column can be NULL
+		column, ok := columns[name]
+		q.columns = append(q.columns, param{name: name, goType: goType(ct.DatabaseTypeName(), !ok || !column.notNull)})
+	}
+	return nil
+}
+
+// zeroValue returns a parameter value of goType to run a query with
+func zeroValue(goType string) interface{} {
+	switch goType {
+	case "string":
+		return ""
+	case "int64":
+		return int64(0)
+	case "float64":
+		return float64(0)
+	case "bool":
+		return false
+	}
+	return nil
+}
+
+func tableColumns(conn *sql.DB, table string) (map[string]tableColumn, error) {
+	columns := map[string]tableColumn{}
+	if table == "" {
+		return columns, nil
+	}
+	rows, err := conn.Query(`SELECT name, type, "notnull", pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, declType string
+		var notNull, pk int
+		if err := rows.Scan(&name, &declType, &notNull, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = tableColumn{declType: declType, notNull: notNull == 1 || pk > 0}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s isn't in the schema", table)
+	}
+	return columns, rows.Err()
+}
+
+// goType maps a SQLite column type to the Go type it scans into
+func goType(declType string, nullable bool) string {
+	declType = strings.ToUpper(declType)
+	base, null := "string", "sql.NullString"
+	switch {
+	case strings.Contains(declType, "BOOL"):
+		base, null = "bool", "sql.NullBool"
+	case strings.Contains(declType, "INT"):
+		base, null = "int64", "sql.NullInt64"
+	case strings.Contains(declType, "REAL"), strings.Contains(declType, "FLOA"), strings.Contains(declType, "DOUB"):
+		base, null = "float64", "sql.NullFloat64"
+	case strings.Contains(declType, "BLOB"):
+		base, null = "[]byte", "[]byte"
+	}
+	if nullable {
+		return null
+	}
+	return base
+}
+
+// goName turns a snake_case column into a Go name, exported or not
+func goName(column string, exported bool) string {
+	var b strings.Builder
+	for i, part := range strings.Split(column, "_") {
+		if part == "" {
+			continue
+		}
+		switch {
+		case i == 0 && !exported:
+			b.WriteString(part)
+		case initialisms[part] != "":
+			b.WriteString(initialisms[part])
+		default:
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	name := b.String()
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+func lowerFirst(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// generate writes the Go source for every query
+func generate(pkg string, queries []*query) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by querygen from the queries in queries/*.sql. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	b.WriteString("import \"database/sql\"\n\n")
+
+	rows := map[string][]param{}
+	var rowNames []string
+	for _, q := range queries {
+		if q.kind == ":exec" || len(q.columns) == 1 {
+			continue
+		}
+		if q.row == "" {
+			q.row = lowerFirst(q.name) + "Row"
+		}
+		if existing, ok := rows[q.row]; ok {
+			if fmt.Sprint(existing) != fmt.Sprint(q.columns) {
+				return nil, fmt.Errorf("%s: %s returns different columns than the other queries using %s", q.source, q.name, q.row)
+			}
+			continue
+		}
+		rows[q.row] = q.columns
+		rowNames = append(rowNames, q.row)
+	}
+	sort.Strings(rowNames)
+	for _, name := range rowNames {
+		fmt.Fprintf(&b, "type %s struct {\n", name)
+		for _, c := range rows[name] {
+			fmt.Fprintf(&b, "\t%s %s\n", goName(c.name, true), c.goType)
+		}
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// generatedQueries holds the SQL of every generated query, by name\nvar generatedQueries = map[string]string{\n")
+	for _, q := range queries {
+		fmt.Fprintf(&b, "\t%q: %sSQL,\n", q.name, lowerFirst(q.name))
+	}
+	b.WriteString("}\n\n")
+
+	for _, q := range queries {
+		writeQuery(&b, q)
+	}
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated code doesn't parse: %w\n%s", err, b.Bytes())
+	}
+	return src, nil
+}
+
+func writeQuery(b *bytes.Buffer, q *query) {
+	fn := lowerFirst(q.name)
+	fmt.Fprintf(b, "const %sSQL = `%s`\n\n", fn, q.sql)
+
+	// Past a few parameters, a struct keeps the call sites readable
+	var params, args []string
+	if len(q.params) > maxParams {
+		paramsType := fn + "Params"
+		fmt.Fprintf(b, "type %s struct {\n", paramsType)
+		for _, p := range q.params {
+			fmt.Fprintf(b, "\t%s %s\n", goName(p.name, true), p.goType)
+		}
+		b.WriteString("}\n\n")
+		params = []string{"arg " + paramsType}
+		for _, a := range q.args {
+			args = append(args, "arg."+goName(a, true))
+		}
+	} else {
+		for _, p := range q.params {
+			params = append(params, fmt.Sprintf("%s %s", goName(p.name, false), p.goType))
+		}
+		for _, a := range q.args {
+			args = append(args, goName(a, false))
+		}
+	}
+	signature := strings.Join(append([]string{"conn dbtx"}, params...), ", ")
+	callArgs := strings.Join(append([]string{"conn", fn + "SQL"}, args...), ", ")
+
+	for _, line := range q.doc {
+		fmt.Fprintf(b, "// %s\n", line)
+	}
+
+	if q.kind == ":exec" {
+		fmt.Fprintf(b, "func %s(%s) (sql.Result, error) {\n\treturn execOn(%s)\n}\n\n", fn, signature, callArgs)
+		return
+	}
+
+	result, scan := q.row, "&i"
+	if len(q.columns) == 1 {
+		result = q.columns[0].goType
+	} else {
+		var targets []string
+		for _, c := range q.columns {
+			targets = append(targets, "&i."+goName(c.name, true))
+		}
+		scan = strings.Join(targets, ", ")
+	}
+
+	if q.kind == ":one" {
+		fmt.Fprintf(b, "func %s(%s) (%s, error) {\n", fn, signature, result)
+		fmt.Fprintf(b, "\tvar i %s\n\tif noConn(conn) {\n\t\treturn i, errDBNotInitialized\n\t}\n", result)
+		fmt.Fprintf(b, "\terr := queryRowOn(%s).Scan(%s)\n\treturn i, err\n}\n\n", callArgs, scan)
+		return
+	}
+
+	fmt.Fprintf(b, "func %s(%s) ([]%s, error) {\n", fn, signature, result)
+	fmt.Fprintf(b, "\trows, err := queryOn(%s)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n\tdefer rows.Close()\n\n", callArgs)
+	fmt.Fprintf(b, "\tvar items []%s\n\tfor rows.Next() {\n\t\tvar i %s\n", result, result)
+	fmt.Fprintf(b, "\t\tif err := rows.Scan(%s); err != nil {\n\t\t\treturn nil, err\n\t\t}\n\t\titems = append(items, i)\n\t}\n", scan)
+	b.WriteString("\treturn items, rows.Err()\n}\n\n")
+}
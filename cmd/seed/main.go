@@ -0,0 +1,61 @@
+// cmd/seed/main.go - Populates a local database with fixture data for development and demos
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+)
+
+func main() {
+	dbPath := flag.String("db", "./booster/data/booster.db", "SQLite database to seed")
+	dir := flag.String("dir", "./testdata/seed", "directory containing memberships.json, events.json and fundraisers.json")
+	force := flag.Bool("force", false, "seed even when ENVIRONMENT or APP_ENV looks like production")
+	flag.Parse()
+
+	config.LoadEnv()
+
+	if isProduction() && !*force {
+		log.Fatalf("Refusing to seed a production environment; pass -force if this is really what you want")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*dbPath), 0755); err != nil {
+		log.Fatalf("Failed to create database directory: %v", err)
+	}
+	encryptionKey, err := config.LoadEncryptionKey()
+	if err == nil {
+		err = data.SetPIIKey(encryptionKey)
+	}
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	if err := data.InitDB(*dbPath); err != nil {
+		log.Fatalf("Failed to initialize SQLite DB: %v", err)
+	}
+	defer data.CloseDB()
+
+	if err := data.CreateTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+	}
+
+	summary, err := fixtures.Load(*dir)
+	if err != nil {
+		log.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	log.Printf("Seeded %s: %d memberships, %d events, %d fundraisers (%d captured)",
+		*dbPath, summary.Memberships, summary.Events, summary.Fundraisers, summary.Captured)
+}
+
+func isProduction() bool {
+	env := strings.ToLower(os.Getenv("ENVIRONMENT"))
+	return strings.HasPrefix(env, "prod") || os.Getenv("APP_ENV") == "production"
+}
@@ -0,0 +1,114 @@
+// drain.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/logger"
+)
+
+// requestTracker counts requests from the moment a handler starts until it returns.
+// Handlers keep running after the timeout handler has replied, so this also covers
+// webhook processing and capture writes that outlive their response.
+type requestTracker struct {
+	mu        sync.Mutex
+	nextID    uint64
+	inFlight  map[uint64]trackedRequest
+	started   int64
+	completed int64
+}
+
+type trackedRequest struct {
+	method, path string
+	start        time.Time
+}
+
+// track is middleware registering each request for the duration of its handler
+func (t *requestTracker) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := t.begin(r)
+		defer t.end(id)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (t *requestTracker) begin(r *http.Request) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inFlight == nil {
+		t.inFlight = make(map[uint64]trackedRequest)
+	}
+	t.nextID++
+	t.started++
+	t.inFlight[t.nextID] = trackedRequest{method: r.Method, path: r.URL.Path, start: time.Now()}
+	return t.nextID
+}
+
+func (t *requestTracker) end(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.inFlight, id)
+	t.completed++
+}
+
+// wait blocks until no handler is running or ctx is done, and reports whether
+// every request finished
+func (t *requestTracker) wait(ctx context.Context) bool {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if t.active() == 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return t.active() == 0
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *requestTracker) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inFlight)
+}
+
+// summary describes the requests handled so far and any still running, longest first
+func (t *requestTracker) summary() (started, completed int64, running []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests := make([]trackedRequest, 0, len(t.inFlight))
+	for _, req := range t.inFlight {
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].start.Before(requests[j].start) })
+
+	for _, req := range requests {
+		running = append(running, fmt.Sprintf("%s %s (%v)", req.method, req.path, time.Since(req.start).Round(time.Millisecond)))
+	}
+	return t.started, t.completed, running
+}
+
+// drain waits up to the shutdown deadline for running handlers, then logs the final
+// count; requests still running are named so an interrupted capture can be followed up
+func (a *App) drain(ctx context.Context) {
+	if n := a.requests.active(); n > 0 {
+		logger.LogInfo("Waiting for %d in-flight requests to finish...", n)
+	}
+	finished := a.requests.wait(ctx)
+
+	started, completed, running := a.requests.summary()
+	if !finished {
+		logger.LogWarn("Shutdown deadline reached with %d requests still running: %s",
+			len(running), strings.Join(running, ", "))
+	}
+	logger.LogInfo("Requests handled: %d completed of %d started", completed, started)
+}
@@ -0,0 +1,120 @@
+// Package archive moves the submissions of past school years out of the tables the
+// forms, checkout and reports use, and lets admins read them afterwards.
+package archive
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// defaultLimit is how many archived submissions a page lists unless it asks for more
+const defaultLimit = 100
+
+// SchoolYear returns the school year t falls in, named by the calendar year it starts in
+func SchoolYear(t time.Time) int {
+	t = t.In(clock.Location())
+	if t.Month() < config.SchoolYearStartMonth() {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
+
+// Bounds returns the first moment of a school year and of the next, in the club's time zone
+func Bounds(year int) (from, to time.Time) {
+	from = time.Date(year, config.SchoolYearStartMonth(), 1, 0, 0, 0, 0, clock.Location())
+	return from, from.AddDate(1, 0, 0)
+}
+
+// Label names a school year as families do, such as 2024-25
+func Label(year int) string {
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// Archive moves a past school year's submissions into the archive tables. Submissions
+// still open, such as installment plans and disputed payments, stay where they are; the
+// result counts them so the year can be archived again once they're settled.
+func Archive(year int) (*data.ArchiveResult, error) {
+	if current := SchoolYear(clock.Now()); year >= current {
+		return nil, fmt.Errorf("school year %s isn't over; only years before %s can be archived", Label(year), Label(current))
+	}
+
+	from, to := Bounds(year)
+	result, err := data.ArchiveSubmissions(from, to, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	logger.LogInfo("Archived school year %s: %d memberships, %d event registrations, %d fundraiser donations; %d still open",
+		Label(year), result.Archived["membership"], result.Archived["event"], result.Archived["fundraiser"], result.Kept)
+	return result, nil
+}
+
+// Handler lets an admin read archived submissions: GET with ?year= lists a school
+// year's, and ?form_type=, ?school=, ?status=, ?search=, ?from= and ?to= narrow them
+// as they do the live lists. A page of up to ?limit= is returned, newest first.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	list, err := middleware.ParseListQuery(r, defaultLimit)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", err.Error(), "")
+		return
+	}
+	query := r.URL.Query()
+	filter := data.SubmissionFilter{
+		FormType: strings.TrimSpace(query.Get("form_type")),
+		School:   strings.TrimSpace(query.Get("school")),
+		Status:   strings.TrimSpace(query.Get("status")),
+		Search:   strings.TrimSpace(query.Get("search")),
+		From:     list.From,
+		To:       list.To,
+		Limit:    list.Limit,
+		Offset:   list.Offset,
+	}
+	if filter.FormType != "" && filter.FormType != "membership" && filter.FormType != "event" && filter.FormType != "fundraiser" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_form_type", "form_type must be membership, event or fundraiser", "")
+		return
+	}
+
+	response := map[string]interface{}{}
+	if value := query.Get("year"); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil || year < 2000 || year > 2100 {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_year", "year must be the year a school year starts, such as 2024", "")
+			return
+		}
+		from, to := Bounds(year)
+		if filter.From.Before(from) {
+			filter.From = from
+		}
+		if filter.To.IsZero() || filter.To.After(to) {
+			filter.To = to
+		}
+		response["school_year"] = Label(year)
+	}
+
+	submissions, err := data.ListArchivedSubmissions(filter)
+	if err != nil {
+		logger.LogError("Failed to list archived submissions: %v", err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list archived submissions", "")
+		return
+	}
+	if submissions == nil {
+		submissions = []data.SubmissionSummary{}
+	}
+	response["submissions"] = submissions
+	middleware.WriteAPISuccess(w, r, response)
+}
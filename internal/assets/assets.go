@@ -0,0 +1,59 @@
+// internal/assets/assets.go
+package assets
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// TemplateOverrideDir is checked for a page template before the embedded copy, relative
+// to the working directory, so a deployment can restyle a page without a rebuild
+const TemplateOverrideDir = "templates"
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// Templates returns the page templates. A file in TemplateOverrideDir replaces the
+// embedded template of the same name.
+func Templates() fs.FS {
+	return overlay{
+		primary:  os.DirFS(TemplateOverrideDir),
+		fallback: mustSub(embeddedTemplates, "templates"),
+	}
+}
+
+// Static returns the default assets served under /static when the configured assets
+// directory does not have the requested file
+func Static() fs.FS {
+	return mustSub(embeddedStatic, "static")
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// overlay opens files from primary, falling back to fallback for files primary lacks
+type overlay struct {
+	primary  fs.FS
+	fallback fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	file, err := o.primary.Open(name)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.fallback.Open(name)
+}
@@ -0,0 +1,212 @@
+// Package backup snapshots the SQLite database on a schedule and on demand, keeping
+// the newest few snapshots and deleting older ones.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/scheduler"
+)
+
+// DefaultSchedule backs up nightly, after the submission cleanup has run
+const DefaultSchedule = "0 3 * * *"
+
+// Backup files are named for when they were taken, so they sort oldest first
+const (
+	filePrefix = "booster-"
+	fileSuffix = ".db"
+	nameLayout = "20060102-150405"
+)
+
+// Backup is one snapshot of the database in the backup directory
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Create snapshots the database into the backup directory, then deletes the oldest
+// backups beyond the number settings keep
+func Create(ctx context.Context, settings config.BackupSettings) (*Backup, error) {
+	if err := os.MkdirAll(settings.Directory, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	createdAt := clock.Now().In(clock.Location())
+	name := filePrefix + createdAt.Format(nameLayout) + fileSuffix
+	path := filepath.Join(settings.Directory, name)
+	if err := data.BackupDatabase(ctx, path); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new backup: %w", err)
+	}
+
+	if removed, err := prune(settings.Directory, settings.Keep); err != nil {
+		logger.LogWarn("Failed to delete old backups: %v", err)
+	} else if removed > 0 {
+		logger.LogInfo("Deleted %d old backups", removed)
+	}
+	return &Backup{Name: name, Size: info.Size(), CreatedAt: createdAt}, nil
+}
+
+// List returns the backups in dir, newest first
+func List(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		createdAt, ok := parseName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // deleted while listing
+		}
+		backups = append(backups, Backup{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Path returns where the backup called name is in dir, refusing names that aren't
+// backups so a request can't reach other files
+func Path(dir, name string) (string, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return "", fmt.Errorf("%q is not a backup", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// parseName returns when the backup called name was taken
+func parseName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+	createdAt, err := time.ParseInLocation(nameLayout, stamp, clock.Location())
+	return createdAt, err == nil
+}
+
+// prune deletes all but the newest keep backups in dir
+func prune(dir string, keep int) (int, error) {
+	backups, err := List(dir)
+	if err != nil || len(backups) <= keep {
+		return 0, err
+	}
+	removed := 0
+	for _, backup := range backups[keep:] {
+		if err := os.Remove(filepath.Join(dir, backup.Name)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// NewJob returns a scheduler job that takes a backup
+func NewJob(settings config.BackupSettings) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		backup, err := Create(ctx, settings)
+		if err != nil {
+			return err
+		}
+		scheduler.Report(ctx, "backed up the database to %s (%d bytes)", backup.Name, backup.Size)
+		return nil
+	}
+}
+
+// AdminHandler lets an admin manage database backups. GET lists them newest first, or
+// downloads one (?name=); POST takes a backup now and returns it, or sends the file
+// itself with ?download=true.
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	settings := config.LoadBackupSettings()
+	switch r.Method {
+	case http.MethodGet:
+		if name := r.URL.Query().Get("name"); name != "" {
+			serveBackup(w, r, settings.Directory, name)
+			return
+		}
+		backups, err := List(settings.Directory)
+		if err != nil {
+			logger.LogError("Failed to list backups: %v", err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list backups", "")
+			return
+		}
+		middleware.WriteAPISuccess(w, r, map[string]interface{}{"backups": backups})
+
+	case http.MethodPost:
+		backup, err := Create(r.Context(), settings)
+		if errors.Is(err, data.ErrBackupUnsupported) {
+			middleware.WriteAPIError(w, r, http.StatusNotImplemented, "unsupported", "Only SQLite databases are backed up here", "")
+			return
+		}
+		if err != nil {
+			logger.LogError("Failed to back up the database: %v", err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to back up the database", "")
+			return
+		}
+		logger.LogInfo("Admin backed up the database to %s", backup.Name)
+		if r.URL.Query().Get("download") == "true" {
+			serveBackup(w, r, settings.Directory, backup.Name)
+			return
+		}
+		middleware.WriteAPISuccess(w, r, backup)
+
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+	}
+}
+
+// serveBackup sends the backup called name as a download
+func serveBackup(w http.ResponseWriter, r *http.Request, dir, name string) {
+	path, err := Path(dir, name)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_name", err.Error(), "")
+		return
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Backup not found", "")
+		return
+	}
+	if err != nil {
+		logger.LogError("Failed to open backup %s: %v", name, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to open the backup", "")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		logger.LogError("Failed to read backup %s: %v", name, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read the backup", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
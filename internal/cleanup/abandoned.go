@@ -0,0 +1,148 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/security"
+)
+
+const (
+	// AbandonedCheckoutSchedule runs the sweeper hourly so reminders go out close to the configured age
+	AbandonedCheckoutSchedule = "15 * * * *"
+
+	maxRemindersPerRun = 50
+)
+
+// AbandonedCheckoutPolicy controls when unpaid submissions are reminded and abandoned
+type AbandonedCheckoutPolicy struct {
+	ReminderAge  time.Duration // submission age before the one reminder is sent
+	AbandonAfter time.Duration // time after the reminder before the submission is marked abandoned
+}
+
+// LoadAbandonedCheckoutPolicy reads the sweeper windows from configuration
+func LoadAbandonedCheckoutPolicy() AbandonedCheckoutPolicy {
+	return AbandonedCheckoutPolicy{
+		ReminderAge:  config.CheckoutReminderAge(),
+		AbandonAfter: config.CheckoutAbandonAfter(),
+	}
+}
+
+// NewAbandonedCheckoutJob returns a scheduler job that sends one reminder with a fresh
+// checkout link to unpaid submissions, then marks them abandoned if they stay unpaid.
+func NewAbandonedCheckoutJob(policy AbandonedCheckoutPolicy) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := clock.Now()
+		var failures []string
+
+		// Abandon first so a submission is never reminded and abandoned in the same run
+		toAbandon, err := data.GetCheckoutsToAbandon(now.Add(-policy.AbandonAfter), maxRemindersPerRun)
+		if err != nil {
+			return err
+		}
+		abandoned := 0
+		for _, checkout := range toAbandon {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := data.MarkCheckoutAbandoned(checkout.FormType, checkout.FormID, now); err != nil {
+				logger.LogError("Failed to mark %s abandoned: %v", checkout.FormID, err)
+				failures = append(failures, checkout.FormID)
+				continue
+			}
+			// Credit an abandoned checkout held goes back to the family
+			if released, err := data.ReleaseCredit(checkout.FormType, checkout.FormID, data.ActorSystem); err != nil {
+				logger.LogError("Failed to release credit of abandoned %s: %v", checkout.FormID, err)
+			} else if released > 0 {
+				logger.LogInfo("Released $%.2f credit held by abandoned %s", released, checkout.FormID)
+			}
+			abandoned++
+		}
+
+		toRemind, err := data.GetCheckoutsNeedingReminder(now.Add(-policy.ReminderAge), maxRemindersPerRun)
+		if err != nil {
+			return err
+		}
+		reminded := 0
+		for _, checkout := range toRemind {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := sendCheckoutReminder(ctx, checkout); err != nil {
+				logger.LogError("Failed to send checkout reminder for %s: %v", checkout.FormID, err)
+				failures = append(failures, checkout.FormID)
+				continue
+			}
+			reminded++
+		}
+
+		scheduler.Report(ctx, "reminders sent: %d (age %v)", reminded, policy.ReminderAge)
+		scheduler.Report(ctx, "marked abandoned: %d (%v after reminder)", abandoned, policy.AbandonAfter)
+
+		if len(failures) > 0 {
+			return fmt.Errorf("%d checkouts failed: %s", len(failures), strings.Join(failures, ", "))
+		}
+		return nil
+	}
+}
+
+// checkoutReminder is the reminder with a fresh checkout link
+var checkoutReminder = notification.Template{
+	Name: "checkout reminder",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			subject, body := email.RenderCheckoutReminder(d.(email.CheckoutReminderData))
+			return notification.Message{Subject: subject, Body: body}, nil
+		},
+	},
+}
+
+func sendCheckoutReminder(ctx context.Context, checkout data.PendingCheckout) error {
+	resumeToken, err := security.GenerateResumeToken()
+	if err != nil {
+		return err
+	}
+
+	// Record the reminder before sending so a failed update can't cause a second email.
+	// It is recorded even for payers who turned reminders off and are skipped, so the
+	// submission is still abandoned on schedule.
+	if err := data.MarkCheckoutReminded(checkout.FormType, checkout.FormID, resumeToken, clock.Now()); err != nil {
+		return err
+	}
+	return notification.Send(ctx, notification.Notification{
+		Template: checkoutReminder,
+		Data: email.CheckoutReminderData{
+			FormID:      checkout.FormID,
+			FormType:    checkout.FormType,
+			FirstName:   checkout.FirstName,
+			Email:       checkout.Email,
+			CheckoutURL: resumeCheckoutURL(checkout.FormID, resumeToken),
+		},
+		Category:   preferences.Reminders,
+		Recipients: []notification.Recipient{notification.Payer(checkout.Email)},
+	})
+}
+
+func resumeCheckoutURL(formID, resumeToken string) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://suzuki.nfshost.com"
+	}
+
+	params := url.Values{}
+	params.Set("formID", formID)
+	params.Set("code", resumeToken)
+	return fmt.Sprintf("%s/api/resume-checkout?%s", strings.TrimRight(baseURL, "/"), params.Encode())
+}
@@ -1,105 +1,301 @@
 package cleanup
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
 	"sbcbackend/internal/data"
+	"sbcbackend/internal/form"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/privacy"
+	"sbcbackend/internal/progress"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/security"
 )
 
+// Cleanup target names, also used in CLEANUP_<TARGET>_* settings
 const (
-	cleanupHour       = 2  // 2 AM
-	retentionHours    = 48 // 48 hours
-	maxDeletionPerRun = 25 // Maximum records to delete per run
+	TargetTokens          = "tokens"
+	TargetDrafts          = "drafts"
+	TargetTempFiles       = "temp-files"
+	TargetRateLimits      = "rate-limits"
+	TargetOrderPages      = "order-pages"
+	TargetIdempotencyKeys = "idempotency-keys"
+	TargetPersonalDetails = "personal-details"
 )
 
-// StartCleanupRoutine starts the daily cleanup job
-func StartCleanupRoutine() {
-	go func() {
-		logger.LogInfo("Cleanup routine started - will run daily at %d:00 AM", cleanupHour)
+const (
+	// DefaultSchedule runs the submission cleanup daily at 2 AM
+	DefaultSchedule = "0 2 * * *"
+)
+
+// Target is one kind of data the cleanup routine removes and how long it is kept
+type Target struct {
+	Name      string        `json:"name"`
+	Enabled   bool          `json:"enabled"`
+	Retention time.Duration `json:"retention"`
+	MaxPerRun int           `json:"max_per_run,omitempty"` // drafts, temp-files, order-pages and personal-details; 0 means no cap
+	Directory string        `json:"directory,omitempty"`   // temp-files and order-pages
+	ArchiveTo string        `json:"archive_to,omitempty"`  // order-pages only; empty deletes instead
+}
 
-		for {
-			// Calculate next 2 AM
-			now := time.Now()
-			next2AM := time.Date(now.Year(), now.Month(), now.Day(), cleanupHour, 0, 0, 0, now.Location())
+// TargetResult is what one target removed during a run
+type TargetResult struct {
+	Target  string
+	Removed int
+	Skipped bool
+	Err     error
+}
 
-			// If it's past 2 AM today, schedule for tomorrow
-			if now.After(next2AM) {
-				next2AM = next2AM.Add(24 * time.Hour)
-			}
+// LoadPolicy builds the cleanup targets from configuration, falling back to defaults
+func LoadPolicy() map[string]Target {
+	tempDir := config.CleanupTempDirectory()
 
-			sleepDuration := next2AM.Sub(now)
-			logger.LogInfo("Next cleanup scheduled for %v (in %v)", next2AM.Format("2006-01-02 15:04:05"), sleepDuration)
+	orderPageArchive := ""
+	if config.CleanupArchive(TargetOrderPages, true) {
+		orderPageArchive = filepath.Join(config.DataDirectory(), "archived_order_pages")
+	}
 
-			time.Sleep(sleepDuration)
+	return map[string]Target{
+		TargetTokens: {
+			Name:      TargetTokens,
+			Enabled:   config.CleanupEnabled(TargetTokens, true),
+			Retention: config.CleanupRetention(TargetTokens, 24*time.Hour),
+		},
+		TargetDrafts: {
+			Name:      TargetDrafts,
+			Enabled:   config.CleanupEnabled(TargetDrafts, true),
+			Retention: config.CleanupRetention(TargetDrafts, 7*24*time.Hour),
+			MaxPerRun: config.CleanupMaxPerRun(TargetDrafts, 25),
+		},
+		TargetTempFiles: {
+			Name:      TargetTempFiles,
+			Enabled:   config.CleanupEnabled(TargetTempFiles, tempDir != ""),
+			Retention: config.CleanupRetention(TargetTempFiles, 24*time.Hour),
+			MaxPerRun: config.CleanupMaxPerRun(TargetTempFiles, 500),
+			Directory: tempDir,
+		},
+		TargetRateLimits: {
+			Name:      TargetRateLimits,
+			Enabled:   config.CleanupEnabled(TargetRateLimits, true),
+			Retention: config.CleanupRetention(TargetRateLimits, time.Hour),
+		},
+		TargetOrderPages: {
+			// Retention is a grace period so a page isn't swept before its URL is saved
+			Name:      TargetOrderPages,
+			Enabled:   config.CleanupEnabled(TargetOrderPages, true),
+			Retention: config.CleanupRetention(TargetOrderPages, 24*time.Hour),
+			MaxPerRun: config.CleanupMaxPerRun(TargetOrderPages, 100),
+			Directory: config.EventOrdersPath(),
+			ArchiveTo: orderPageArchive,
+		},
+		TargetIdempotencyKeys: {
+			// Long enough for any client retry; after that a key is just history
+			Name:      TargetIdempotencyKeys,
+			Enabled:   config.CleanupEnabled(TargetIdempotencyKeys, true),
+			Retention: config.CleanupRetention(TargetIdempotencyKeys, 24*time.Hour),
+		},
+		TargetPersonalDetails: {
+			// Anonymizes closed submissions; off until the club settles on how long
+			// families' details are kept, which is then counted from payment
+			Name:      TargetPersonalDetails,
+			Enabled:   config.CleanupEnabled(TargetPersonalDetails, false),
+			Retention: config.CleanupRetention(TargetPersonalDetails, 3*365*24*time.Hour),
+			MaxPerRun: config.CleanupMaxPerRun(TargetPersonalDetails, 100),
+		},
+	}
+}
 
-			// Run cleanup
-			runCleanup()
+// DescribePolicy returns a one-line summary of the policy for startup logs
+func DescribePolicy(policy map[string]Target) string {
+	var parts []string
+	for _, name := range []string{TargetTokens, TargetDrafts, TargetTempFiles, TargetRateLimits, TargetOrderPages,
+		TargetIdempotencyKeys, TargetPersonalDetails} {
+		target := policy[name]
+		if !target.Enabled {
+			parts = append(parts, name+"=off")
+			continue
 		}
-	}()
+		parts = append(parts, fmt.Sprintf("%s=%v", name, target.Retention))
+	}
+	return strings.Join(parts, ", ")
 }
 
-// runCleanup performs the actual cleanup of abandoned records
-func runCleanup() {
-	logger.LogInfo("Starting daily cleanup of abandoned form submissions")
+// NewJob returns a scheduler job function that runs the named targets of the policy
+// and reports what each removed to the job log.
+func NewJob(policy map[string]Target, targets ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var failed []string
+		total := 0
 
-	cutoffTime := time.Now().Add(-retentionHours * time.Hour)
-	logger.LogInfo("Cleaning records older than %v (before %v)",
-		time.Duration(retentionHours)*time.Hour, cutoffTime.Format("2006-01-02 15:04:05"))
+		for _, name := range targets {
+			// Stop between targets on shutdown; each target leaves the data consistent
+			if ctx.Err() != nil {
+				scheduler.Report(ctx, "stopped early: %v", ctx.Err())
+				return ctx.Err()
+			}
+			result := runTarget(ctx, policy[name])
+			switch {
+			case result.Skipped:
+				scheduler.Report(ctx, "%s: disabled", result.Target)
+			case result.Err != nil:
+				scheduler.Report(ctx, "%s: failed after removing %d: %v", result.Target, result.Removed, result.Err)
+				failed = append(failed, result.Target)
+			default:
+				scheduler.Report(ctx, "%s: removed %d (retention %v)", result.Target, result.Removed, policy[name].Retention)
+			}
+			total += result.Removed
+		}
 
-	totalCleaned := 0
+		scheduler.Report(ctx, "total removed: %d", total)
 
-	// Clean membership submissions
-	membershipCleaned, err := cleanupMembershipSubmissions(cutoffTime)
-	if err != nil {
-		logger.LogError("Failed to cleanup membership submissions: %v", err)
-	} else {
-		totalCleaned += membershipCleaned
-		if membershipCleaned > 0 {
-			logger.LogInfo("Cleaned up %d abandoned membership submissions", membershipCleaned)
+		if len(failed) > 0 {
+			return fmt.Errorf("cleanup failed for %s", strings.Join(failed, ", "))
 		}
+		return nil
 	}
+}
 
-	// Clean event submissions
-	eventCleaned, err := cleanupEventSubmissions(cutoffTime)
-	if err != nil {
-		logger.LogError("Failed to cleanup event submissions: %v", err)
-	} else {
-		totalCleaned += eventCleaned
-		if eventCleaned > 0 {
-			logger.LogInfo("Cleaned up %d abandoned event submissions", eventCleaned)
+func runTarget(ctx context.Context, target Target) TargetResult {
+	result := TargetResult{Target: target.Name}
+	if !target.Enabled || target.Name == "" {
+		result.Skipped = true
+		return result
+	}
+
+	switch target.Name {
+	case TargetTokens:
+		csrfRemoved, accessRemoved := security.PruneExpiredTokens(target.Retention)
+		result.Removed = csrfRemoved + accessRemoved
+	case TargetRateLimits:
+		result.Removed = middleware.PruneRateLimits(target.Retention) + form.PruneRateLimits(target.Retention) +
+			progress.PruneRateLimits(target.Retention)
+	case TargetDrafts:
+		result.Removed, result.Err = cleanupDrafts(target)
+	case TargetTempFiles:
+		result.Removed, result.Err = cleanupTempFiles(ctx, target)
+	case TargetOrderPages:
+		result.Removed, result.Err = cleanupOrderPages(ctx, target)
+	case TargetIdempotencyKeys:
+		result.Removed, result.Err = data.PurgeIdempotencyKeys(clock.Now().Add(-target.Retention))
+	case TargetPersonalDetails:
+		result.Removed, result.Err = privacy.AnonymizeExpired(ctx, clock.Now().Add(-target.Retention), perRun(target))
+	default:
+		result.Err = fmt.Errorf("unknown cleanup target %s", target.Name)
+	}
+
+	return result
+}
+
+// perRun is the LIMIT of a target's queries. LoadPolicy always caps the targets taking
+// one, but a target built without a cap removes everything expired rather than nothing.
+func perRun(target Target) int {
+	if target.MaxPerRun > 0 {
+		return target.MaxPerRun
+	}
+	return math.MaxInt32
+}
+
+// cleanupDrafts removes unsubmitted form submissions older than the retention window.
+// Submissions waiting on an abandoned-checkout reminder are kept until they are abandoned.
+func cleanupDrafts(target Target) (int, error) {
+	cutoffTime := clock.Now().Add(-target.Retention)
+	logger.LogInfo("Cleaning abandoned drafts older than %v (before %v)",
+		target.Retention, cutoffTime.Format("2006-01-02 15:04:05"))
+
+	cleaners := []struct {
+		name string
+		fn   func(time.Time, int) (int, error)
+	}{
+		{"membership", cleanupMembershipSubmissions},
+		{"event", cleanupEventSubmissions},
+		{"fundraiser", cleanupFundraiserSubmissions},
+	}
+
+	totalCleaned := 0
+	var failed []string
+	for _, cleaner := range cleaners {
+		cleaned, err := cleaner.fn(cutoffTime, perRun(target))
+		if err != nil {
+			logger.LogError("Failed to cleanup %s submissions: %v", cleaner.name, err)
+			failed = append(failed, cleaner.name)
+			continue
+		}
+		totalCleaned += cleaned
+		if cleaned > 0 {
+			logger.LogInfo("Cleaned up %d abandoned %s submissions", cleaned, cleaner.name)
 		}
 	}
 
-	// Clean fundraiser submissions
-	fundraiserCleaned, err := cleanupFundraiserSubmissions(cutoffTime)
+	if len(failed) > 0 {
+		return totalCleaned, fmt.Errorf("%s submissions", strings.Join(failed, ", "))
+	}
+	return totalCleaned, nil
+}
+
+// cleanupTempFiles removes regular files in the temp directory older than the retention window
+func cleanupTempFiles(ctx context.Context, target Target) (int, error) {
+	if target.Directory == "" {
+		return 0, fmt.Errorf("no temp directory configured")
+	}
+
+	entries, err := os.ReadDir(target.Directory)
 	if err != nil {
-		logger.LogError("Failed to cleanup fundraiser submissions: %v", err)
-	} else {
-		totalCleaned += fundraiserCleaned
-		if fundraiserCleaned > 0 {
-			logger.LogInfo("Cleaned up %d abandoned fundraiser submissions", fundraiserCleaned)
+		if os.IsNotExist(err) {
+			return 0, nil
 		}
+		return 0, err
 	}
 
-	if totalCleaned == 0 {
-		logger.LogInfo("Cleanup completed - no abandoned records found")
-	} else {
-		logger.LogInfo("Cleanup completed - total %d abandoned records removed", totalCleaned)
+	cutoffTime := clock.Now().Add(-target.Retention)
+	removed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return removed, ctx.Err()
+		}
+		if target.MaxPerRun > 0 && removed >= target.MaxPerRun {
+			break
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoffTime) {
+			continue
+		}
+
+		path := filepath.Join(target.Directory, entry.Name())
+		if err := os.Remove(path); err != nil {
+			logger.LogWarn("Failed to remove temp file %s: %v", path, err)
+			continue
+		}
+		removed++
 	}
+
+	return removed, nil
 }
 
-func cleanupMembershipSubmissions(cutoffTime time.Time) (int, error) {
+func cleanupMembershipSubmissions(cutoffTime time.Time, limit int) (int, error) {
 	const stmt = `
 		DELETE FROM membership_submissions 
 		WHERE form_id IN (
 			SELECT form_id FROM membership_submissions 
 			WHERE submitted = 0 
 			AND submission_date < ? 
+			AND (reminder_sent_at IS NULL OR abandoned_at IS NOT NULL)
 			LIMIT ?
 		)`
 
-	result, err := data.ExecDB(stmt, cutoffTime.Format(time.RFC3339), maxDeletionPerRun)
+	result, err := data.ExecDB(stmt, cutoffTime.Format(time.RFC3339), limit)
 	if err != nil {
 		return 0, err
 	}
@@ -112,17 +308,18 @@ func cleanupMembershipSubmissions(cutoffTime time.Time) (int, error) {
 	return int(rowsAffected), nil
 }
 
-func cleanupEventSubmissions(cutoffTime time.Time) (int, error) {
+func cleanupEventSubmissions(cutoffTime time.Time, limit int) (int, error) {
 	const stmt = `
 		DELETE FROM event_submissions 
 		WHERE form_id IN (
 			SELECT form_id FROM event_submissions 
 			WHERE submitted = 0 
 			AND submission_date < ? 
+			AND (reminder_sent_at IS NULL OR abandoned_at IS NOT NULL)
 			LIMIT ?
 		)`
 
-	result, err := data.ExecDB(stmt, cutoffTime.Format(time.RFC3339), maxDeletionPerRun)
+	result, err := data.ExecDB(stmt, cutoffTime.Format(time.RFC3339), limit)
 	if err != nil {
 		return 0, err
 	}
@@ -135,17 +332,18 @@ func cleanupEventSubmissions(cutoffTime time.Time) (int, error) {
 	return int(rowsAffected), nil
 }
 
-func cleanupFundraiserSubmissions(cutoffTime time.Time) (int, error) {
+func cleanupFundraiserSubmissions(cutoffTime time.Time, limit int) (int, error) {
 	const stmt = `
 		DELETE FROM fundraiser_submissions 
 		WHERE form_id IN (
 			SELECT form_id FROM fundraiser_submissions 
 			WHERE submitted = 0 
 			AND submission_date < ? 
+			AND (reminder_sent_at IS NULL OR abandoned_at IS NOT NULL)
 			LIMIT ?
 		)`
 
-	result, err := data.ExecDB(stmt, cutoffTime.Format(time.RFC3339), maxDeletionPerRun)
+	result, err := data.ExecDB(stmt, cutoffTime.Format(time.RFC3339), limit)
 	if err != nil {
 		return 0, err
 	}
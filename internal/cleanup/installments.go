@@ -0,0 +1,97 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/security"
+)
+
+// InstallmentReminderSchedule runs the reminders every morning
+const InstallmentReminderSchedule = "0 9 * * *"
+
+// NewInstallmentReminderJob returns a scheduler job that reminds families of each
+// installment coming due within lead, with a link back to checkout to pay it
+func NewInstallmentReminderJob(lead time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := clock.Now()
+		due, err := data.ListInstallmentsNeedingReminder(now.Add(lead), maxRemindersPerRun)
+		if err != nil {
+			return err
+		}
+
+		var failures []string
+		reminded := 0
+		for _, installment := range due {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := sendInstallmentReminder(ctx, installment); err != nil {
+				logger.LogError("Failed to send installment reminder for %s: %v", installment.FormID, err)
+				failures = append(failures, installment.FormID)
+				continue
+			}
+			reminded++
+		}
+
+		scheduler.Report(ctx, "installment reminders sent: %d (due within %v)", reminded, lead)
+		if len(failures) > 0 {
+			return fmt.Errorf("%d installment reminders failed: %s", len(failures), strings.Join(failures, ", "))
+		}
+		return nil
+	}
+}
+
+// installmentReminder is the reminder of a coming installment
+var installmentReminder = notification.Template{
+	Name: "installment reminder",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			subject, body := email.RenderInstallmentReminder(d.(email.InstallmentReminderData))
+			return notification.Message{Subject: subject, Body: body}, nil
+		},
+	},
+}
+
+func sendInstallmentReminder(ctx context.Context, installment data.InstallmentReminder) error {
+	resumeToken, err := security.GenerateResumeToken()
+	if err != nil {
+		return err
+	}
+
+	code, err := data.GetCurrency(installment.FormType, installment.FormID)
+	if err != nil {
+		return err
+	}
+
+	// Record the reminder before sending so a failed update can't cause a second email
+	if err := data.MarkInstallmentReminded(installment.FormType, installment.FormID, installment.Number,
+		resumeToken, clock.Now()); err != nil {
+		return err
+	}
+	return notification.Send(ctx, notification.Notification{
+		Template: installmentReminder,
+		Data: email.InstallmentReminderData{
+			FormID:     installment.FormID,
+			FirstName:  installment.FirstName,
+			Email:      installment.Email,
+			Number:     installment.Number,
+			Count:      installment.Count,
+			Amount:     installment.Amount,
+			Currency:   code,
+			DueAt:      installment.DueAt.In(clock.Location()),
+			PaymentURL: resumeCheckoutURL(installment.FormID, resumeToken),
+		},
+		Category:   preferences.Reminders,
+		Recipients: []notification.Recipient{notification.Payer(installment.Email)},
+	})
+}
@@ -0,0 +1,140 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// orderPagesURLPrefix is the public path the order pages directory is served under,
+// matching the URLs stored by generateStaticOrderPage
+const orderPagesURLPrefix = "/events"
+
+var errMaxPerRun = errors.New("max per run reached")
+
+// cleanupOrderPages removes (or archives) static order pages that no paid or pending
+// event registration points at: refunded or cancelled orders, deleted submissions,
+// and pages left behind when an order page was regenerated under a new path.
+func cleanupOrderPages(ctx context.Context, target Target) (int, error) {
+	if target.Directory == "" {
+		return 0, fmt.Errorf("no order pages directory configured")
+	}
+	if _, err := os.Stat(target.Directory); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	// Load the active set first; without it every page would look orphaned
+	active, err := data.GetActiveEventOrderPageURLs()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoffTime := clock.Now().Add(-target.Retention)
+	removed := 0
+
+	err = filepath.WalkDir(target.Directory, func(filePath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			logger.LogWarn("Skipping %s while scanning order pages: %v", filePath, walkErr)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != ".html" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(target.Directory, filePath)
+		if err != nil {
+			return nil
+		}
+		// A print-friendly variant lives and goes with its order page
+		pageRel := strings.TrimSuffix(filepath.ToSlash(rel), ".print.html")
+		if pageRel != filepath.ToSlash(rel) {
+			pageRel += ".html"
+		}
+		if active[path.Join(orderPagesURLPrefix, pageRel)] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoffTime) {
+			return nil
+		}
+
+		if target.MaxPerRun > 0 && removed >= target.MaxPerRun {
+			return errMaxPerRun
+		}
+
+		if err := retireOrderPage(target, filePath, rel); err != nil {
+			logger.LogWarn("Failed to clean up order page %s: %v", filePath, err)
+			return nil
+		}
+		removed++
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errMaxPerRun) {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// retireOrderPage moves an orphaned page into the archive, keeping its year/event
+// layout, or deletes it when archiving is off
+func retireOrderPage(target Target, filePath, rel string) error {
+	if target.ArchiveTo == "" {
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+		logger.LogInfo("Removed orphaned order page %s", filePath)
+		return nil
+	}
+
+	archivePath := filepath.Join(target.ArchiveTo, rel)
+	if !strings.HasPrefix(archivePath, filepath.Clean(target.ArchiveTo)+string(os.PathSeparator)) {
+		return fmt.Errorf("archive path escapes archive directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(filePath, archivePath); err != nil {
+		// The archive may be on another filesystem than the public directory
+		if err := copyFile(filePath, archivePath); err != nil {
+			return err
+		}
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+	}
+	logger.LogInfo("Archived orphaned order page %s to %s", filePath, archivePath)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
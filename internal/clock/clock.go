@@ -0,0 +1,156 @@
+// internal/clock/clock.go
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for expiry, rate limiting, caching and scheduling
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var (
+	current  Clock = realClock{}
+	location *time.Location
+	mu       sync.RWMutex
+)
+
+// Set replaces the clock used by the whole server, returning the previous one.
+// Tests install a Fake; nil restores the system clock.
+func Set(c Clock) Clock {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := current
+	if c == nil {
+		c = realClock{}
+	}
+	current = c
+	return previous
+}
+
+func get() Clock {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SetLocation replaces the time zone the club runs in, returning the previous one.
+// main installs the configured zone at startup and tests pin one; nil goes back to
+// the process's local zone.
+func SetLocation(loc *time.Location) *time.Location {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := location
+	if previous == nil {
+		previous = time.Local
+	}
+	location = loc
+	return previous
+}
+
+// Location returns the time zone the club runs in, which dates shown to people and
+// calendar days (schedules, daily reports, cut-offs) are in
+func Location() *time.Location {
+	mu.RLock()
+	defer mu.RUnlock()
+	if location == nil {
+		return time.Local
+	}
+	return location
+}
+
+// Now returns the current time from the installed clock
+func Now() time.Time {
+	return get().Now()
+}
+
+// Since returns the time elapsed since t on the installed clock
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Until returns the duration until t on the installed clock
+func Until(t time.Time) time.Duration {
+	return t.Sub(Now())
+}
+
+// After waits for d to elapse on the installed clock
+func After(d time.Duration) <-chan time.Time {
+	return get().After(d)
+}
+
+// Fake is a manually advanced clock. Channels from After fire when Advance
+// moves the clock past their deadline.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFake returns a Fake stopped at now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake's current time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel that receives once the fake has advanced by d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	at := f.now.Add(d)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{at: at, ch: ch})
+	return ch
+}
+
+// Advance moves the fake forward by d and fires every After channel that is now due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
+
+// Waiters reports how many After channels are still pending, so tests can wait
+// for a goroutine to start sleeping before advancing past its deadline
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
@@ -2,21 +2,25 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	// "strconv"
+	"strconv"
 	"strings"
-	// "time"
+	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
+	"sbcbackend/internal/currency"
 	"sbcbackend/internal/logger"
 )
 
 // Variables available everywhere
 var (
 	clientID, clientSecret, apiBase string
+	paypalMu                        sync.RWMutex // guards the PayPal settings, which tests can swap at runtime
 	baseDir                         string
 	dataDirectory                   string
 	logsDirectory                   string
@@ -43,11 +47,16 @@ var (
 
 // Helper: get a setting based on ENVIRONMENT (dev or prod)
 func GetEnvBasedSetting(base string) string {
+	return os.Getenv(EnvSettingName(base))
+}
+
+// EnvSettingName is the environment variable GetEnvBasedSetting reads for base
+func EnvSettingName(base string) string {
 	env := os.Getenv("ENVIRONMENT")
 	if env == "" {
 		env = "dev"
 	}
-	return os.Getenv(fmt.Sprintf("%s_%s", base, strings.ToUpper(env)))
+	return fmt.Sprintf("%s_%s", base, strings.ToUpper(env))
 }
 
 // Helper: log which environment is running
@@ -103,18 +112,41 @@ func LoggerConfig() logger.Config {
 		logFormat = "./logs/server_%s.log"
 	}
 
-	timezone := os.Getenv("TIME_ZONE")
-	if timezone == "" {
-		timezone = "Local"
-	}
-
 	return logger.Config{
 		LogsDirectory: logDir,
 		LogFileFormat: logFormat,
-		TimeZone:      timezone,
+		TimeZone:      timeZoneName(),
 	}
 }
 
+// DefaultTimeZone is the zone the club runs in unless TIME_ZONE says otherwise
+const DefaultTimeZone = "America/Chicago"
+
+// TimeZone returns the IANA time zone the club runs in, from TIME_ZONE_<ENV> or
+// TIME_ZONE. Form dates, receipts, daily reports and job schedules all use it, so it
+// must name a zone rather than depend on wherever the server happens to run.
+func TimeZone() (*time.Location, error) {
+	name := timeZoneName()
+	if strings.EqualFold(name, "Local") {
+		return nil, fmt.Errorf("TIME_ZONE must name a zone such as %s, not %q", DefaultTimeZone, name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_ZONE %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+func timeZoneName() string {
+	if name := strings.TrimSpace(GetEnvBasedSetting("TIME_ZONE")); name != "" {
+		return name
+	}
+	if name := strings.TrimSpace(os.Getenv("TIME_ZONE")); name != "" {
+		return name
+	}
+	return DefaultTimeZone
+}
+
 // ConfigurePaths sets up folders and paths
 func ConfigurePaths() {
 	wd, err := os.Getwd()
@@ -187,32 +219,928 @@ func ConfigurePaths() {
 	LogFileFormat = filepath.Join(logsDirectory, "server_%s.log")
 }
 
+// JobSchedule returns the schedule for a background job from JOB_<NAME>_SCHEDULE_<ENV>.
+// The value is either a Go duration ("5m") or a five-field cron spec ("0 2 * * *").
+func JobSchedule(name, defaultSchedule string) string {
+	schedule := strings.TrimSpace(GetEnvBasedSetting(jobSettingKey(name, "SCHEDULE")))
+	if schedule == "" {
+		return defaultSchedule
+	}
+	return schedule
+}
+
+// JobJitter returns the random start delay for a background job from JOB_<NAME>_JITTER_<ENV>
+func JobJitter(name string, defaultJitter time.Duration) time.Duration {
+	return durationSetting(jobSettingKey(name, "JITTER"), defaultJitter)
+}
+
+// JobBlackout returns the local-time windows a background job must not start in, from
+// JOB_<NAME>_BLACKOUT_<ENV> (e.g. "18:00-21:00" to stay out of the registration rush)
+func JobBlackout(name, defaultBlackout string) string {
+	blackout := strings.TrimSpace(GetEnvBasedSetting(jobSettingKey(name, "BLACKOUT")))
+	if blackout == "" {
+		return defaultBlackout
+	}
+	return blackout
+}
+
+// CleanupEnabled reports whether a cleanup target is on, from CLEANUP_<TARGET>_ENABLED_<ENV>
+func CleanupEnabled(target string, defaultEnabled bool) bool {
+	return boolSetting(cleanupSettingKey(target, "ENABLED"), defaultEnabled)
+}
+
+// CleanupArchive reports whether a cleanup target moves files to its archive directory
+// instead of deleting them, from CLEANUP_<TARGET>_ARCHIVE_<ENV>
+func CleanupArchive(target string, defaultArchive bool) bool {
+	return boolSetting(cleanupSettingKey(target, "ARCHIVE"), defaultArchive)
+}
+
+// CleanupRetention returns how long a cleanup target keeps data, from CLEANUP_<TARGET>_RETENTION_<ENV>
+func CleanupRetention(target string, defaultRetention time.Duration) time.Duration {
+	return durationSetting(cleanupSettingKey(target, "RETENTION"), defaultRetention)
+}
+
+// CleanupMaxPerRun returns the deletion cap for a cleanup target, from CLEANUP_<TARGET>_MAX_PER_RUN_<ENV>
+func CleanupMaxPerRun(target string, defaultMax int) int {
+	key := cleanupSettingKey(target, "MAX_PER_RUN")
+	value := GetEnvBasedSetting(key)
+	if value == "" {
+		return defaultMax
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		logger.LogWarn("Invalid %s %q, using default %d", key, value, defaultMax)
+		return defaultMax
+	}
+	return n
+}
+
+// CleanupTempDirectory returns the directory swept for old temp files; empty disables it
+func CleanupTempDirectory() string {
+	return GetEnvBasedSetting("CLEANUP_TEMP_DIRECTORY")
+}
+
+// EventOrdersPath is the public directory static event order pages are written to,
+// from EVENT_ORDERS_PATH_<ENV>
+func EventOrdersPath() string {
+	path := GetEnvBasedSetting("EVENT_ORDERS_PATH")
+	if path == "" {
+		return "/home/public/events"
+	}
+	return path
+}
+
+// StaticAssetsPath is the directory served under /static (stylesheets, scripts and images
+// used by the receipt and order pages), from STATIC_ASSETS_PATH_<ENV>
+func StaticAssetsPath() string {
+	path := GetEnvBasedSetting("STATIC_ASSETS_PATH")
+	if path == "" {
+		return "/home/public/static"
+	}
+	return path
+}
+
+// StaticAssetsMaxAge is how long browsers may cache files under /static, from
+// STATIC_ASSETS_MAX_AGE_<ENV>
+func StaticAssetsMaxAge() time.Duration {
+	return durationSetting("STATIC_ASSETS_MAX_AGE", time.Hour)
+}
+
+// CheckoutReminderAge is how old an unpaid submission must be before a reminder is sent,
+// from CHECKOUT_REMINDER_AGE_<ENV>
+func CheckoutReminderAge() time.Duration {
+	return durationSetting("CHECKOUT_REMINDER_AGE", 24*time.Hour)
+}
+
+// CheckoutAbandonAfter is how long after the reminder an unpaid submission is marked
+// abandoned, from CHECKOUT_ABANDON_AFTER_<ENV>
+func CheckoutAbandonAfter() time.Duration {
+	return durationSetting("CHECKOUT_ABANDON_AFTER", 72*time.Hour)
+}
+
+// SchoolYearStartMonth is the month a school year starts in, from
+// SCHOOL_YEAR_START_MONTH_<ENV> (1-12). Archiving moves submissions by school year.
+func SchoolYearStartMonth() time.Month {
+	value := GetEnvBasedSetting("SCHOOL_YEAR_START_MONTH")
+	if value == "" {
+		return time.August
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 12 {
+		logger.LogWarn("Invalid SCHOOL_YEAR_START_MONTH %q, using August", value)
+		return time.August
+	}
+	return time.Month(n)
+}
+
+// StaleOrderAge is how long a PayPal order may go unpaid before the watchdog checks it
+// with PayPal and frees the form for a new one, from STALE_ORDER_AGE_<ENV>
+func StaleOrderAge() time.Duration {
+	return durationSetting("STALE_ORDER_AGE", 24*time.Hour)
+}
+
+// PayPalTokenRefreshLead is how long before the PayPal access token expires the
+// refresher replaces it, from PAYPAL_TOKEN_REFRESH_LEAD_<ENV>. It should be longer
+// than the paypal-token-refresh job's schedule.
+func PayPalTokenRefreshLead() time.Duration {
+	return durationSetting("PAYPAL_TOKEN_REFRESH_LEAD", 30*time.Minute)
+}
+
+// AdvancedCheckoutEnabled reports whether checkout offers PayPal Advanced Checkout's
+// hosted card fields, Apple Pay and Google Pay, from ADVANCED_CHECKOUT_ENABLED_<ENV>.
+// The PayPal account must be approved for them.
+func AdvancedCheckoutEnabled() bool {
+	return boolSetting("ADVANCED_CHECKOUT_ENABLED", false)
+}
+
+// OutboundWebhookURL is where payment events are posted, from OUTBOUND_WEBHOOK_URL_<ENV>;
+// empty disables the outbound webhook
+func OutboundWebhookURL() string {
+	return strings.TrimSpace(GetEnvBasedSetting("OUTBOUND_WEBHOOK_URL"))
+}
+
+// MembershipPlanID is the yearly PayPal billing plan auto-renewing memberships subscribe
+// to, from PAYPAL_MEMBERSHIP_PLAN_ID_<ENV>; each subscription overrides its price with
+// the member's total. Empty turns auto-renewal off.
+func MembershipPlanID() string {
+	return strings.TrimSpace(GetEnvBasedSetting("PAYPAL_MEMBERSHIP_PLAN_ID"))
+}
+
+// ChatWebhookURL is the Slack or Discord incoming webhook that notifications of
+// eventType are posted to. CHAT_WEBHOOK_URL_<TYPE>_<ENV> (e.g. _PAYMENT_COMPLETED)
+// routes one type to its own channel and "off" silences it; other types use
+// CHAT_WEBHOOK_URL_<ENV>. Empty disables chat notifications.
+func ChatWebhookURL(eventType string) string {
+	key := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(eventType))
+	if url := strings.TrimSpace(GetEnvBasedSetting("CHAT_WEBHOOK_URL_" + key)); url != "" {
+		if strings.EqualFold(url, "off") {
+			return ""
+		}
+		return url
+	}
+	return strings.TrimSpace(GetEnvBasedSetting("CHAT_WEBHOOK_URL"))
+}
+
+// ChatErrorRateAlert is how many server errors within window trigger an error-rate
+// chat alert, from CHAT_ERROR_RATE_THRESHOLD_<ENV> and CHAT_ERROR_RATE_WINDOW_<ENV>
+func ChatErrorRateAlert() (threshold int, window time.Duration) {
+	threshold = 10
+	if value := GetEnvBasedSetting("CHAT_ERROR_RATE_THRESHOLD"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.LogWarn("Invalid CHAT_ERROR_RATE_THRESHOLD %q, using default %d", value, threshold)
+		} else {
+			threshold = n
+		}
+	}
+	return threshold, durationSetting("CHAT_ERROR_RATE_WINDOW", 5*time.Minute)
+}
+
+// PushSettings is the ntfy topic that admins subscribe their phones to for urgent
+// notifications
+type PushSettings struct {
+	URL    string          // topic URL, e.g. https://ntfy.sh/booster-admins, from PUSH_NTFY_URL_<ENV>
+	Token  string          // access token for a protected topic, from PUSH_NTFY_TOKEN_<ENV>
+	Events map[string]bool // event types pushed, from PUSH_EVENTS_<ENV> (comma separated)
+}
+
+// Enabled reports whether eventType is pushed to admins
+func (s PushSettings) Enabled(eventType string) bool {
+	return s.URL != "" && s.Events[eventType]
+}
+
+// LoadPushSettings reads the push settings. Without PUSH_EVENTS only disputes,
+// reconciliation mismatches, error-rate alerts and PayPal outages are pushed, since
+// every push buzzes someone's phone.
+func LoadPushSettings() PushSettings {
+	settings := PushSettings{
+		URL:    strings.TrimRight(strings.TrimSpace(GetEnvBasedSetting("PUSH_NTFY_URL")), "/"),
+		Token:  strings.TrimSpace(GetEnvBasedSetting("PUSH_NTFY_TOKEN")),
+		Events: make(map[string]bool),
+	}
+	events := GetEnvBasedSetting("PUSH_EVENTS")
+	if strings.TrimSpace(events) == "" {
+		events = "dispute.opened,reconcile.mismatch,error.rate,paypal.failing"
+	}
+	for _, event := range strings.Split(events, ",") {
+		if event = strings.ToLower(strings.TrimSpace(event)); event != "" {
+			settings.Events[event] = true
+		}
+	}
+	return settings
+}
+
+// TwilioSettings is the Twilio account that sends opt-in SMS confirmations
+type TwilioSettings struct {
+	AccountSID string // from TWILIO_ACCOUNT_SID_<ENV>
+	AuthToken  string // from TWILIO_AUTH_TOKEN_<ENV>
+	From       string // sending number or MG... messaging service SID, from TWILIO_FROM_<ENV>
+}
+
+// Enabled reports whether SMS confirmations can be sent
+func (s TwilioSettings) Enabled() bool {
+	return s.AccountSID != "" && s.AuthToken != "" && s.From != ""
+}
+
+// LoadTwilioSettings reads the Twilio settings; with any of them missing, payers are
+// not offered a text message
+func LoadTwilioSettings() TwilioSettings {
+	return TwilioSettings{
+		AccountSID: strings.TrimSpace(GetEnvBasedSetting("TWILIO_ACCOUNT_SID")),
+		AuthToken:  strings.TrimSpace(GetEnvBasedSetting("TWILIO_AUTH_TOKEN")),
+		From:       strings.TrimSpace(GetEnvBasedSetting("TWILIO_FROM")),
+	}
+}
+
+// PayPalAlertSettings is how repeated PayPal failures escalate: every failure is
+// logged, a run of EmailAfter consecutive failures on one endpoint emails the alert
+// mailbox, and a run of PageAfter posts to chat, pushes and texts SMSTo. Each level
+// repeats for an endpoint at most once per Cooldown.
+type PayPalAlertSettings struct {
+	EmailAfter int           // from PAYPAL_ALERT_EMAIL_AFTER_<ENV>
+	PageAfter  int           // from PAYPAL_ALERT_PAGE_AFTER_<ENV>
+	Cooldown   time.Duration // from PAYPAL_ALERT_COOLDOWN_<ENV>
+	SMSTo      []string      // on-call phone numbers, from PAYPAL_ALERT_SMS_TO_<ENV> (comma separated)
+}
+
+// LoadPayPalAlertSettings reads the PayPal alert settings, defaulting to an email
+// after 3 failures in a row, a page after 6 and a 15 minute cool-down
+func LoadPayPalAlertSettings() PayPalAlertSettings {
+	settings := PayPalAlertSettings{
+		EmailAfter: 3,
+		PageAfter:  6,
+		Cooldown:   durationSetting("PAYPAL_ALERT_COOLDOWN", 15*time.Minute),
+	}
+	for key, target := range map[string]*int{
+		"PAYPAL_ALERT_EMAIL_AFTER": &settings.EmailAfter,
+		"PAYPAL_ALERT_PAGE_AFTER":  &settings.PageAfter,
+	} {
+		value := GetEnvBasedSetting(key)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.LogWarn("Invalid %s %q, using default %d", key, value, *target)
+			continue
+		}
+		*target = n
+	}
+	for _, number := range strings.Split(GetEnvBasedSetting("PAYPAL_ALERT_SMS_TO"), ",") {
+		if number = strings.TrimSpace(number); number != "" {
+			settings.SMSTo = append(settings.SMSTo, number)
+		}
+	}
+	return settings
+}
+
+// PrivacySettings controls parents' requests to export or delete their data
+type PrivacySettings struct {
+	LinkTTL         time.Duration // how long a verification link works, from PRIVACY_LINK_TTL_<ENV>
+	RequestInterval time.Duration // least time between links to one address, from PRIVACY_REQUEST_INTERVAL_<ENV>
+}
+
+// LoadPrivacySettings reads the privacy request settings, defaulting to links that
+// work for a day and at most one link per address every 15 minutes
+func LoadPrivacySettings() PrivacySettings {
+	return PrivacySettings{
+		LinkTTL:         durationSetting("PRIVACY_LINK_TTL", 24*time.Hour),
+		RequestInterval: durationSetting("PRIVACY_REQUEST_INTERVAL", 15*time.Minute),
+	}
+}
+
+// HouseholdSettings controls the sign-in links families use to see their orders
+type HouseholdSettings struct {
+	LinkTTL         time.Duration // how long a sign-in link works, from HOUSEHOLD_LINK_TTL_<ENV>
+	RequestInterval time.Duration // least time between links to one address, from HOUSEHOLD_REQUEST_INTERVAL_<ENV>
+}
+
+// LoadHouseholdSettings reads the household settings, defaulting to links that work
+// for a week so a family can come back to prefill next year's forms from the same email
+func LoadHouseholdSettings() HouseholdSettings {
+	return HouseholdSettings{
+		LinkTTL:         durationSetting("HOUSEHOLD_LINK_TTL", 7*24*time.Hour),
+		RequestInterval: durationSetting("HOUSEHOLD_REQUEST_INTERVAL", 15*time.Minute),
+	}
+}
+
+// ProgressSettings describe the fundraiser behind the public progress endpoint and
+// how the endpoint is protected
+type ProgressSettings struct {
+	FundraiserName string        // shown with the progress bar, from FUNDRAISER_NAME_<ENV>
+	Goal           float64       // from FUNDRAISER_GOAL_<ENV>; without a goal no fundraiser is shown
+	Start          string        // first day of the fundraiser (2006-01-02), from FUNDRAISER_START_<ENV>
+	End            string        // last day of the fundraiser (2006-01-02), from FUNDRAISER_END_<ENV>
+	CacheTTL       time.Duration // how long numbers are reused, from PROGRESS_CACHE_TTL_<ENV>
+	RateLimit      int           // requests per minute per client, from PROGRESS_RATE_LIMIT_<ENV>
+}
+
+// LoadProgressSettings reads the progress endpoint settings, defaulting to numbers
+// refreshed every 5 minutes and 30 requests a minute per client
+func LoadProgressSettings() ProgressSettings {
+	settings := ProgressSettings{
+		FundraiserName: strings.TrimSpace(GetEnvBasedSetting("FUNDRAISER_NAME")),
+		Start:          strings.TrimSpace(GetEnvBasedSetting("FUNDRAISER_START")),
+		End:            strings.TrimSpace(GetEnvBasedSetting("FUNDRAISER_END")),
+		CacheTTL:       durationSetting("PROGRESS_CACHE_TTL", 5*time.Minute),
+		RateLimit:      30,
+	}
+	if value := strings.TrimSpace(GetEnvBasedSetting("FUNDRAISER_GOAL")); value != "" {
+		goal, err := strconv.ParseFloat(value, 64)
+		if err != nil || goal < 0 {
+			logger.LogWarn("Invalid FUNDRAISER_GOAL %q, showing no fundraiser", value)
+		} else {
+			settings.Goal = goal
+		}
+	}
+	if value := GetEnvBasedSetting("PROGRESS_RATE_LIMIT"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.LogWarn("Invalid PROGRESS_RATE_LIMIT %q, using default %d", value, settings.RateLimit)
+		} else {
+			settings.RateLimit = n
+		}
+	}
+	return settings
+}
+
+// Payment providers checkout can take payments through
+const (
+	PaymentProviderPayPal = "paypal"
+	PaymentProviderStripe = "stripe"
+)
+
+// PaymentProvider is the processor checkout takes payments through, from
+// PAYMENT_PROVIDER_<ENV>, defaulting to PayPal
+func PaymentProvider() string {
+	switch provider := strings.ToLower(strings.TrimSpace(GetEnvBasedSetting("PAYMENT_PROVIDER"))); provider {
+	case "", PaymentProviderPayPal:
+		return PaymentProviderPayPal
+	case PaymentProviderStripe:
+		return PaymentProviderStripe
+	default:
+		logger.LogWarn("Unknown PAYMENT_PROVIDER %q, using PayPal", provider)
+		return PaymentProviderPayPal
+	}
+}
+
+// Currency is the ISO 4217 code the deployment charges in, from CURRENCY_<ENV>,
+// defaulting to US dollars. Inventory items can name their own.
+func Currency() string {
+	code := currency.Normalize(GetEnvBasedSetting("CURRENCY"))
+	switch {
+	case code == "":
+		return currency.USD
+	case !currency.Supported(code):
+		logger.LogWarn("Unsupported CURRENCY %q, using %s", code, currency.USD)
+		return currency.USD
+	}
+	return code
+}
+
+// StripeSettings hold the Stripe account checkout uses when PAYMENT_PROVIDER is stripe
+type StripeSettings struct {
+	SecretKey string // from STRIPE_SECRET_KEY_<ENV>
+	APIBase   string // from STRIPE_API_BASE_<ENV>, for a local mock or proxy
+	Currency  string // lowercase ISO code, from STRIPE_CURRENCY_<ENV>
+}
+
+// LoadStripeSettings reads the Stripe settings, defaulting to Stripe's API in the
+// deployment's currency
+func LoadStripeSettings() StripeSettings {
+	settings := StripeSettings{
+		SecretKey: strings.TrimSpace(GetEnvBasedSetting("STRIPE_SECRET_KEY")),
+		APIBase:   "https://api.stripe.com",
+		Currency:  strings.ToLower(Currency()),
+	}
+	if base := strings.TrimSpace(GetEnvBasedSetting("STRIPE_API_BASE")); base != "" {
+		settings.APIBase = strings.TrimRight(base, "/")
+	}
+	if currency := strings.TrimSpace(GetEnvBasedSetting("STRIPE_CURRENCY")); currency != "" {
+		settings.Currency = strings.ToLower(currency)
+	}
+	return settings
+}
+
+// BankTransferSettings control paying fundraiser donations by ACH bank transfer,
+// which goes through the Stripe account whatever PAYMENT_PROVIDER is
+type BankTransferSettings struct {
+	Enabled       bool    // from BANK_TRANSFER_ENABLED_<ENV>; needs STRIPE_SECRET_KEY_<ENV>
+	MinimumAmount float64 // smallest donation offered it, from BANK_TRANSFER_MINIMUM_<ENV>
+}
+
+// LoadBankTransferSettings reads the bank transfer settings, defaulting to off and,
+// once on, offered for donations of $250 or more, where card fees add up
+func LoadBankTransferSettings() BankTransferSettings {
+	settings := BankTransferSettings{
+		Enabled:       boolSetting("BANK_TRANSFER_ENABLED", false),
+		MinimumAmount: 250,
+	}
+	if value := strings.TrimSpace(GetEnvBasedSetting("BANK_TRANSFER_MINIMUM")); value != "" {
+		minimum, err := strconv.ParseFloat(value, 64)
+		if err != nil || minimum < 0 {
+			logger.LogWarn("Invalid BANK_TRANSFER_MINIMUM %q, using default %.2f", value, settings.MinimumAmount)
+		} else {
+			settings.MinimumAmount = minimum
+		}
+	}
+	return settings
+}
+
+// InstallmentSettings control splitting large event registrations into scheduled
+// installments, the first paid at checkout and the rest as they come due
+type InstallmentSettings struct {
+	Enabled         bool          // from INSTALLMENTS_ENABLED_<ENV>
+	MinimumAmount   float64       // smallest registration offered a plan, from INSTALLMENT_MINIMUM_<ENV>
+	MaxInstallments int           // from INSTALLMENTS_MAX_<ENV>
+	Interval        time.Duration // between due dates, from INSTALLMENT_INTERVAL_<ENV>
+	ReminderLead    time.Duration // how long before a due date the reminder goes out, from INSTALLMENT_REMINDER_LEAD_<ENV>
+}
+
+// LoadInstallmentSettings reads the installment settings, defaulting to off and, once
+// on, up to four monthly installments for registrations of $200 or more
+func LoadInstallmentSettings() InstallmentSettings {
+	settings := InstallmentSettings{
+		Enabled:         boolSetting("INSTALLMENTS_ENABLED", false),
+		MinimumAmount:   200,
+		MaxInstallments: 4,
+		Interval:        durationSetting("INSTALLMENT_INTERVAL", 30*24*time.Hour),
+		ReminderLead:    durationSetting("INSTALLMENT_REMINDER_LEAD", 72*time.Hour),
+	}
+	if value := strings.TrimSpace(GetEnvBasedSetting("INSTALLMENT_MINIMUM")); value != "" {
+		minimum, err := strconv.ParseFloat(value, 64)
+		if err != nil || minimum < 0 {
+			logger.LogWarn("Invalid INSTALLMENT_MINIMUM %q, using default %.2f", value, settings.MinimumAmount)
+		} else {
+			settings.MinimumAmount = minimum
+		}
+	}
+	if value := strings.TrimSpace(GetEnvBasedSetting("INSTALLMENTS_MAX")); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 2 {
+			logger.LogWarn("Invalid INSTALLMENTS_MAX %q, using default %d", value, settings.MaxInstallments)
+		} else {
+			settings.MaxInstallments = max
+		}
+	}
+	return settings
+}
+
+// QuickDonationSettings control the donation-only checkout for general-fund giving
+type QuickDonationSettings struct {
+	Enabled       bool    // from QUICK_DONATIONS_ENABLED_<ENV>
+	MinimumAmount float64 // from QUICK_DONATION_MINIMUM_<ENV>
+	MaximumAmount float64 // from QUICK_DONATION_MAXIMUM_<ENV>; larger gifts go through the board
+}
+
+// LoadQuickDonationSettings reads the quick donation settings, defaulting to on for
+// donations from $1 to $10,000
+func LoadQuickDonationSettings() QuickDonationSettings {
+	settings := QuickDonationSettings{
+		Enabled:       boolSetting("QUICK_DONATIONS_ENABLED", true),
+		MinimumAmount: 1,
+		MaximumAmount: 10000,
+	}
+	for _, limit := range []struct {
+		key   string
+		value *float64
+	}{
+		{"QUICK_DONATION_MINIMUM", &settings.MinimumAmount},
+		{"QUICK_DONATION_MAXIMUM", &settings.MaximumAmount},
+	} {
+		value := strings.TrimSpace(GetEnvBasedSetting(limit.key))
+		if value == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil || amount <= 0 {
+			logger.LogWarn("Invalid %s %q, using default %.2f", limit.key, value, *limit.value)
+			continue
+		}
+		*limit.value = amount
+	}
+	return settings
+}
+
+// MaintenanceMode reports whether the server starts in maintenance mode, from
+// MAINTENANCE_MODE_<ENV>. Admins can also toggle it at runtime.
+func MaintenanceMode() bool {
+	return boolSetting("MAINTENANCE_MODE", false)
+}
+
+// MaintenanceRetryAfter is the Retry-After sent with maintenance responses, from
+// MAINTENANCE_RETRY_AFTER_<ENV>
+func MaintenanceRetryAfter() time.Duration {
+	return durationSetting("MAINTENANCE_RETRY_AFTER", 5*time.Minute)
+}
+
+// MaintenanceMessage is shown on the maintenance page, from MAINTENANCE_MESSAGE_<ENV>
+func MaintenanceMessage() string {
+	if message := strings.TrimSpace(GetEnvBasedSetting("MAINTENANCE_MESSAGE")); message != "" {
+		return message
+	}
+	return "We're making some improvements and will be back shortly."
+}
+
+// AutoMigrate reports whether the server applies schema migrations at startup, from
+// DB_AUTO_MIGRATE_<ENV>. When off, it refuses to start on an out-of-date schema and
+// migrations are applied with boosterctl db migrate.
+func AutoMigrate() bool {
+	return boolSetting("DB_AUTO_MIGRATE", true)
+}
+
+// Databases DB_DRIVER selects between
+const (
+	DatabaseSQLite   = "sqlite"
+	DatabasePostgres = "postgres"
+)
+
+// DatabaseSettings say where the server keeps its data: the SQLite file under
+// booster/data by default, or PostgreSQL for deployments busy enough that SQLite's
+// single writer holds them up
+type DatabaseSettings struct {
+	Driver string // DatabaseSQLite or DatabasePostgres, from DB_DRIVER_<ENV>
+	URL    string // PostgreSQL connection URL, from DATABASE_URL_<ENV>
+}
+
+// LoadDatabaseSettings reads the database settings; PostgreSQL needs a connection URL
+func LoadDatabaseSettings() (DatabaseSettings, error) {
+	settings := DatabaseSettings{
+		Driver: strings.ToLower(strings.TrimSpace(GetEnvBasedSetting("DB_DRIVER"))),
+		URL:    strings.TrimSpace(GetEnvBasedSetting("DATABASE_URL")),
+	}
+	switch settings.Driver {
+	case "", DatabaseSQLite:
+		settings.Driver = DatabaseSQLite
+	case DatabasePostgres:
+		if settings.URL == "" {
+			return settings, fmt.Errorf("DB_DRIVER is postgres but %s is not set", EnvSettingName("DATABASE_URL"))
+		}
+	default:
+		return settings, fmt.Errorf("unknown DB_DRIVER %q (use sqlite or postgres)", settings.Driver)
+	}
+	return settings, nil
+}
+
+// BackupSettings say where snapshots of the SQLite database go and how many are kept
+type BackupSettings struct {
+	Directory string // from DB_BACKUP_DIRECTORY_<ENV>
+	Keep      int    // newest backups kept, older ones deleted, from DB_BACKUP_KEEP_<ENV>
+}
+
+// LoadBackupSettings reads the backup settings, defaulting to two weeks of nightly
+// backups in booster/data/backups
+func LoadBackupSettings() BackupSettings {
+	settings := BackupSettings{
+		Directory: "./booster/data/backups",
+		Keep:      14,
+	}
+	if dir := strings.TrimSpace(GetEnvBasedSetting("DB_BACKUP_DIRECTORY")); dir != "" {
+		settings.Directory = dir
+	}
+	if value := strings.TrimSpace(GetEnvBasedSetting("DB_BACKUP_KEEP")); value != "" {
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			logger.LogWarn("Invalid DB_BACKUP_KEEP %q, using default %d", value, settings.Keep)
+		} else {
+			settings.Keep = keep
+		}
+	}
+	return settings
+}
+
+// ReplicaSettings say where the SQLite database is continuously replicated to, so a
+// lost disk loses seconds of payments rather than everything since the last backup
+type ReplicaSettings struct {
+	URL              string        // s3://bucket/prefix or a directory, from DB_REPLICA_URL_<ENV>; empty turns replication off
+	Endpoint         string        // S3-compatible API such as Backblaze B2's, from DB_REPLICA_ENDPOINT_<ENV>; empty for AWS
+	Region           string        // from DB_REPLICA_REGION_<ENV>
+	AccessKeyID      string        // from DB_REPLICA_ACCESS_KEY_ID_<ENV>
+	SecretAccessKey  string        // from DB_REPLICA_SECRET_ACCESS_KEY_<ENV>
+	SnapshotInterval time.Duration // how often a fresh snapshot starts a new generation, from DB_REPLICA_SNAPSHOT_INTERVAL_<ENV>
+	Retain           int           // generations kept, older ones deleted, from DB_REPLICA_RETAIN_<ENV>
+}
+
+// Enabled reports whether the database is replicated
+func (s ReplicaSettings) Enabled() bool {
+	return s.URL != ""
+}
+
+// LoadReplicaSettings reads the replica settings, defaulting to a daily snapshot with
+// three days of generations kept. Each tenant replicates under its own ID, so tenants
+// can share one bucket.
+func LoadReplicaSettings() (ReplicaSettings, error) {
+	settings := ReplicaSettings{
+		URL:              strings.TrimRight(strings.TrimSpace(GetEnvBasedSetting("DB_REPLICA_URL")), "/"),
+		Endpoint:         strings.TrimRight(strings.TrimSpace(GetEnvBasedSetting("DB_REPLICA_ENDPOINT")), "/"),
+		Region:           strings.TrimSpace(GetEnvBasedSetting("DB_REPLICA_REGION")),
+		AccessKeyID:      strings.TrimSpace(GetEnvBasedSetting("DB_REPLICA_ACCESS_KEY_ID")),
+		SecretAccessKey:  strings.TrimSpace(GetEnvBasedSetting("DB_REPLICA_SECRET_ACCESS_KEY")),
+		SnapshotInterval: durationSetting("DB_REPLICA_SNAPSHOT_INTERVAL", 24*time.Hour),
+		Retain:           3,
+	}
+	if !settings.Enabled() {
+		return settings, nil
+	}
+	if value := strings.TrimSpace(GetEnvBasedSetting("DB_REPLICA_RETAIN")); value != "" {
+		retain, err := strconv.Atoi(value)
+		if err != nil || retain < 1 {
+			logger.LogWarn("Invalid DB_REPLICA_RETAIN %q, using default %d", value, settings.Retain)
+		} else {
+			settings.Retain = retain
+		}
+	}
+	if settings.SnapshotInterval <= 0 {
+		logger.LogWarn("DB_REPLICA_SNAPSHOT_INTERVAL must be positive, using 24h")
+		settings.SnapshotInterval = 24 * time.Hour
+	}
+	if id := TenantID(); id != "" {
+		settings.URL += "/" + id
+	}
+
+	if strings.HasPrefix(settings.URL, "s3://") {
+		if settings.AccessKeyID == "" || settings.SecretAccessKey == "" {
+			return settings, fmt.Errorf("DB_REPLICA_URL is an S3 bucket but %s or %s is not set",
+				EnvSettingName("DB_REPLICA_ACCESS_KEY_ID"), EnvSettingName("DB_REPLICA_SECRET_ACCESS_KEY"))
+		}
+		if settings.Region == "" {
+			settings.Region = "us-east-1"
+		}
+	}
+	return settings, nil
+}
+
+// LoadEncryptionKey reads the key the personal details in submissions are encrypted
+// with at rest: base64 in PII_ENCRYPTION_KEY_<ENV>, or in the file named by
+// PII_ENCRYPTION_KEY_FILE_<ENV>. `openssl rand -base64 32` makes one. It returns nil
+// when neither is set, and details are stored in plaintext.
+func LoadEncryptionKey() ([]byte, error) {
+	encoded := strings.TrimSpace(GetEnvBasedSetting("PII_ENCRYPTION_KEY"))
+	if file := strings.TrimSpace(GetEnvBasedSetting("PII_ENCRYPTION_KEY_FILE")); file != "" {
+		if encoded != "" {
+			return nil, fmt.Errorf("set either PII_ENCRYPTION_KEY or PII_ENCRYPTION_KEY_FILE, not both")
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PII_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		encoded = strings.TrimSpace(string(content))
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	return key, nil
+}
+
+// TenantIDVar names the tenant a backend process serves; the tenant supervisor sets it
+// for each process it starts
+const TenantIDVar = "TENANT_ID"
+
+// TenantsFile is the JSON file listing the booster organizations this deployment
+// serves, from TENANTS_FILE_<ENV>. When set, the server supervises one backend per
+// tenant instead of serving a single organization itself.
+func TenantsFile() string {
+	return strings.TrimSpace(GetEnvBasedSetting("TENANTS_FILE"))
+}
+
+// TenantID returns the tenant this process serves, or "" outside a multi-tenant deployment
+func TenantID() string {
+	return os.Getenv(TenantIDVar)
+}
+
+// ServerSettings tunes the HTTP server's timeouts, keep-alives and protocols
+type ServerSettings struct {
+	ReadHeaderTimeout time.Duration // from SERVER_READ_HEADER_TIMEOUT_<ENV>
+	ReadTimeout       time.Duration // whole request including the body, from SERVER_READ_TIMEOUT_<ENV>
+	WriteTimeout      time.Duration // from SERVER_WRITE_TIMEOUT_<ENV>
+	IdleTimeout       time.Duration // keep-alive idle time, from SERVER_IDLE_TIMEOUT_<ENV>
+	RequestTimeout    time.Duration // handler deadline, from SERVER_REQUEST_TIMEOUT_<ENV>
+	ShutdownTimeout   time.Duration // grace for in-flight requests and jobs on exit, from SERVER_SHUTDOWN_TIMEOUT_<ENV>
+	MaxHeaderBytes    int           // from SERVER_MAX_HEADER_BYTES_<ENV>
+	KeepAlives        bool          // from SERVER_KEEP_ALIVES_<ENV>
+	HTTP2             bool          // HTTP/2 over TLS, from SERVER_HTTP2_<ENV>
+}
+
+// LoadServerSettings reads the server settings. The defaults match the old fixed
+// values; deployments with slow uploads raise the read, write and request timeouts
+// together, since a multipart body is read within all three.
+func LoadServerSettings() ServerSettings {
+	settings := ServerSettings{
+		ReadHeaderTimeout: durationSetting("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       durationSetting("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      durationSetting("SERVER_WRITE_TIMEOUT", 15*time.Second),
+		IdleTimeout:       durationSetting("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		RequestTimeout:    durationSetting("SERVER_REQUEST_TIMEOUT", 15*time.Second),
+		ShutdownTimeout:   durationSetting("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+		MaxHeaderBytes:    1 << 20,
+		KeepAlives:        boolSetting("SERVER_KEEP_ALIVES", true),
+		HTTP2:             boolSetting("SERVER_HTTP2", true),
+	}
+
+	if value := GetEnvBasedSetting("SERVER_MAX_HEADER_BYTES"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.LogWarn("Invalid SERVER_MAX_HEADER_BYTES %q, using default %d", value, settings.MaxHeaderBytes)
+		} else {
+			settings.MaxHeaderBytes = n
+		}
+	}
+
+	if settings.ShutdownTimeout <= 0 {
+		logger.LogWarn("SERVER_SHUTDOWN_TIMEOUT must be positive, using 30s")
+		settings.ShutdownTimeout = 30 * time.Second
+	}
+	if settings.WriteTimeout > 0 && settings.WriteTimeout < settings.ReadTimeout {
+		logger.LogWarn("SERVER_WRITE_TIMEOUT %v is shorter than SERVER_READ_TIMEOUT %v; slow uploads will be cut off before the response", settings.WriteTimeout, settings.ReadTimeout)
+	}
+	return settings
+}
+
+// TLSSettings describes how the server terminates HTTPS itself when there is no
+// fronting proxy. Manual certificates and autocert are mutually exclusive.
+type TLSSettings struct {
+	CertFile         string   // PEM certificate chain, from TLS_CERT_FILE_<ENV>
+	KeyFile          string   // PEM private key, from TLS_KEY_FILE_<ENV>
+	AutocertDomains  []string // Let's Encrypt hosts, from TLS_AUTOCERT_DOMAINS_<ENV>
+	AutocertCacheDir string   // where issued certificates are kept, from TLS_AUTOCERT_CACHE_<ENV>
+	AutocertEmail    string   // ACME account contact, from TLS_AUTOCERT_EMAIL_<ENV>
+	HTTPAddr         string   // plain HTTP listener for ACME challenges and redirects, from TLS_HTTP_ADDR_<ENV>
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (s TLSSettings) Enabled() bool {
+	return s.CertFile != "" || len(s.AutocertDomains) > 0
+}
+
+// Autocert reports whether certificates are obtained from Let's Encrypt
+func (s TLSSettings) Autocert() bool {
+	return len(s.AutocertDomains) > 0
+}
+
+// LoadTLSSettings reads the TLS settings; with none set the server stays on plain HTTP
+func LoadTLSSettings() (TLSSettings, error) {
+	settings := TLSSettings{
+		CertFile:         strings.TrimSpace(GetEnvBasedSetting("TLS_CERT_FILE")),
+		KeyFile:          strings.TrimSpace(GetEnvBasedSetting("TLS_KEY_FILE")),
+		AutocertCacheDir: strings.TrimSpace(GetEnvBasedSetting("TLS_AUTOCERT_CACHE")),
+		AutocertEmail:    strings.TrimSpace(GetEnvBasedSetting("TLS_AUTOCERT_EMAIL")),
+		HTTPAddr:         strings.TrimSpace(GetEnvBasedSetting("TLS_HTTP_ADDR")),
+	}
+	for _, domain := range strings.Split(GetEnvBasedSetting("TLS_AUTOCERT_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			settings.AutocertDomains = append(settings.AutocertDomains, domain)
+		}
+	}
+
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		return settings, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if settings.CertFile != "" && settings.Autocert() {
+		return settings, fmt.Errorf("set either TLS_CERT_FILE/TLS_KEY_FILE or TLS_AUTOCERT_DOMAINS, not both")
+	}
+
+	if settings.Autocert() {
+		if settings.AutocertCacheDir == "" {
+			settings.AutocertCacheDir = filepath.Join(".", "booster", "autocert")
+		}
+		// Let's Encrypt validates on port 80, so autocert always needs the HTTP listener
+		if settings.HTTPAddr == "" {
+			settings.HTTPAddr = ":80"
+		}
+	}
+	return settings, nil
+}
+
+func boolSetting(key string, defaultValue bool) bool {
+	value := strings.ToLower(strings.TrimSpace(GetEnvBasedSetting(key)))
+	switch value {
+	case "":
+		return defaultValue
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	}
+	logger.LogWarn("Invalid %s %q, using default %v", key, value, defaultValue)
+	return defaultValue
+}
+
+func durationSetting(key string, defaultValue time.Duration) time.Duration {
+	value := GetEnvBasedSetting(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		logger.LogWarn("Invalid %s %q, using default %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func cleanupSettingKey(target, setting string) string {
+	key := strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(target))
+	return fmt.Sprintf("CLEANUP_%s_%s", key, setting)
+}
+
+func jobSettingKey(name, setting string) string {
+	key := strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+	return fmt.Sprintf("JOB_%s_%s", key, setting)
+}
+
 // LoadPayPalConfig sets up PayPal info
 func LoadPayPalConfig() error {
-	clientID = os.Getenv("PAYPAL_CLIENT_ID")
-	clientSecret = os.Getenv("PAYPAL_CLIENT_SECRET")
+	id := os.Getenv("PAYPAL_CLIENT_ID")
+	secret := os.Getenv("PAYPAL_CLIENT_SECRET")
 
-	if clientID == "" || clientSecret == "" {
+	if PayPalSimulatorEnabled() {
+		// main points the API base at the simulator once it is listening
+		if os.Getenv("ENVIRONMENT") == "prod" {
+			return fmt.Errorf("PAYPAL_MODE=simulator is not allowed in prod")
+		}
+		SetPayPalAPI("", "simulator", "simulator")
+		PayPalWebhookID = os.Getenv("PAYPAL_WEBHOOK_ID")
+		if PayPalWebhookID == "" {
+			PayPalWebhookID = "SIMULATOR"
+		}
+		logger.LogWarn("Using the built-in PayPal simulator; no real payments will be taken")
+		return nil
+	}
+
+	if id == "" || secret == "" {
 		return fmt.Errorf("PayPal credentials are missing or incomplete")
 	}
 
+	base := ""
 	mode := os.Getenv("PAYPAL_MODE")
-	if mode == "live" {
-		apiBase = "https://api.paypal.com"
+	if override := os.Getenv("PAYPAL_API_BASE"); override != "" {
+		// Lets a local mock or proxy stand in for PayPal
+		base = strings.TrimRight(override, "/")
+		logger.LogInfo("Using PayPal API base override %s", base)
+	} else if mode == "live" {
+		base = "https://api.paypal.com"
 		logger.LogInfo("Using PayPal Live environment")
 	} else {
-		apiBase = "https://api.sandbox.paypal.com"
+		base = "https://api.sandbox.paypal.com"
 		logger.LogInfo("Using PayPal Sandbox environment")
 	}
+	SetPayPalAPI(base, id, secret)
 
 	PayPalWebhookID = os.Getenv("PAYPAL_WEBHOOK_ID")
 	if PayPalWebhookID == "" {
 		logger.LogWarn("PAYPAL_WEBHOOK_ID is not set in environment")
 	}
+	// USE_MOCK_WEBHOOK is for sandbox testing; live webhooks are always verified
+	if UseMockWebhookVerification && mode == "live" {
+		logger.LogError("USE_MOCK_WEBHOOK is ignored with PAYPAL_MODE=live; webhook signatures will be verified")
+		UseMockWebhookVerification = false
+	}
 
 	return nil
 }
 
+// PayPalModeSimulator is the PAYPAL_MODE that runs checkout against the built-in
+// PayPal simulator instead of PayPal
+const PayPalModeSimulator = "simulator"
+
+// PayPalSimulatorEnabled reports whether PAYPAL_MODE selects the PayPal simulator
+func PayPalSimulatorEnabled() bool {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("PAYPAL_MODE"))) == PayPalModeSimulator
+}
+
+// PayPalSimulatorSettings configure the built-in PayPal simulator
+type PayPalSimulatorSettings struct {
+	Addr         string        // where its API listens, from PAYPAL_SIMULATOR_ADDR_<ENV>
+	PublicURL    string        // its address as browsers reach it, from PAYPAL_SIMULATOR_PUBLIC_URL_<ENV>; Addr if unset
+	AutoApprove  bool          // approve orders as they are created, from PAYPAL_SIMULATOR_AUTO_APPROVE_<ENV>
+	Failures     []string      // failures to start with, from PAYPAL_SIMULATOR_FAILURES_<ENV> (comma separated)
+	WebhookDelay time.Duration // how long after a capture or refund its webhook arrives, from PAYPAL_SIMULATOR_WEBHOOK_DELAY_<ENV>
+}
+
+// LoadPayPalSimulatorSettings reads the simulator settings, defaulting to listening on
+// 127.0.0.1:5052 with webhooks two seconds after the change they report
+func LoadPayPalSimulatorSettings() PayPalSimulatorSettings {
+	settings := PayPalSimulatorSettings{
+		Addr:         strings.TrimSpace(GetEnvBasedSetting("PAYPAL_SIMULATOR_ADDR")),
+		PublicURL:    strings.TrimRight(strings.TrimSpace(GetEnvBasedSetting("PAYPAL_SIMULATOR_PUBLIC_URL")), "/"),
+		AutoApprove:  boolSetting("PAYPAL_SIMULATOR_AUTO_APPROVE", false),
+		WebhookDelay: durationSetting("PAYPAL_SIMULATOR_WEBHOOK_DELAY", 2*time.Second),
+	}
+	if settings.Addr == "" {
+		settings.Addr = "127.0.0.1:5052"
+	}
+	for _, failure := range strings.Split(GetEnvBasedSetting("PAYPAL_SIMULATOR_FAILURES"), ",") {
+		if failure = strings.ToLower(strings.TrimSpace(failure)); failure != "" {
+			settings.Failures = append(settings.Failures, failure)
+		}
+	}
+	return settings
+}
+
 // LoadCORSConfig loads CORS settings
 func LoadCORSConfig() {
 	AllowedOrigin = GetEnvBasedSetting("ALLOWED_ORIGIN")
@@ -263,17 +1191,33 @@ func LogsDirectory() string {
 }
 
 func APIBase() string {
+	paypalMu.RLock()
+	defer paypalMu.RUnlock()
 	return apiBase
 }
 
 func ClientID() string {
+	paypalMu.RLock()
+	defer paypalMu.RUnlock()
 	return clientID
 }
 
 func ClientSecret() string {
+	paypalMu.RLock()
+	defer paypalMu.RUnlock()
 	return clientSecret
 }
 
+// SetPayPalAPI replaces the PayPal base URL and credentials. LoadPayPalConfig uses it at
+// startup; tests use it to point the real payment handlers at a mock PayPal server.
+func SetPayPalAPI(base, id, secret string) {
+	paypalMu.Lock()
+	defer paypalMu.Unlock()
+	apiBase = base
+	clientID = id
+	clientSecret = secret
+}
+
 func GetFormsDataDirectory() string {
 	return formsDataDirectory
 }
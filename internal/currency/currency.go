@@ -0,0 +1,76 @@
+// internal/currency/currency.go
+package currency
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// USD is the currency a deployment charges in unless CURRENCY says otherwise
+const USD = "USD"
+
+type info struct {
+	symbol   string
+	decimals int // minor units PayPal accepts
+}
+
+// Currencies checkout can charge in, all of which PayPal supports. Symbols that other
+// currencies share carry a prefix so a receipt is never ambiguous.
+var currencies = map[string]info{
+	"USD": {"$", 2},
+	"CAD": {"CA$", 2},
+	"AUD": {"A$", 2},
+	"NZD": {"NZ$", 2},
+	"MXN": {"MX$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"CHF": {"CHF ", 2},
+	"JPY": {"¥", 0},
+}
+
+// Normalize upper-cases an ISO 4217 code, as inventory files and settings may not
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Supported reports whether checkout can charge in code
+func Supported(code string) bool {
+	_, ok := currencies[Normalize(code)]
+	return ok
+}
+
+// Decimals is how many minor-unit digits amounts in code have; 2 for unknown codes
+func Decimals(code string) int {
+	if c, ok := currencies[Normalize(code)]; ok {
+		return c.decimals
+	}
+	return 2
+}
+
+// Round rounds amount to the smallest unit of code
+func Round(amount float64, code string) float64 {
+	scale := math.Pow(10, float64(Decimals(code)))
+	return math.Round(amount*scale) / scale
+}
+
+// Value is amount as the decimal string payment APIs expect, e.g. "12.50" or "1250"
+// for yen
+func Value(amount float64, code string) string {
+	return fmt.Sprintf("%.*f", Decimals(code), amount)
+}
+
+// Format shows amount to a family, e.g. "$12.50" or "€12.50". Codes without a symbol
+// are written after the amount.
+func Format(amount float64, code string) string {
+	code = Normalize(code)
+	c, ok := currencies[code]
+	if !ok {
+		return fmt.Sprintf("%s %s", Value(amount, code), code)
+	}
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return sign + c.symbol + Value(amount, code)
+}
@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// SCHOOL-YEAR ARCHIVE
+// =============================================================================
+
+// The submissions of past school years are moved out of the submission tables, which
+// the forms, checkout and reports query, into archive tables with the same columns and
+// archived_at. The archived_submissions view reads them as the submissions view reads
+// the live tables. Archived rows keep their form IDs, so the audit log, refunds and
+// installments recorded against them still find them; they are only read, except to
+// anonymize them.
+
+// archiveTables maps each form type to the table its archived submissions are kept in
+var archiveTables = map[string]string{
+	"membership": "archived_membership_submissions",
+	"event":      "archived_event_submissions",
+	"fundraiser": "archived_fundraiser_submissions",
+}
+
+// archivedSubmissionsView is the view of every archived submission, as submissions is
+// of the live ones
+const archivedSubmissionsView = "archived_submissions"
+
+// archiveHeld selects the submissions kept live however old they are, because they're
+// still open: payments in installments, disputed or waiting on a bank transfer, due
+// installments, auto-renewing memberships, and emails or order pages still queued
+func archiveHeld(formType string) string {
+	held := `paypal_status IN ('` + InstallmentsStatus + `', '` + DisputedStatus + `', '` + PaymentPendingStatus + `')
+		OR form_id IN (SELECT form_id FROM installments WHERE status = '` + InstallmentDue + `')
+		OR form_id IN (SELECT form_id FROM outbox_tasks WHERE status = '` + OutboxPending + `')`
+	if formType == "membership" {
+		held += " OR COALESCE(auto_renew, 0) = 1"
+	}
+	return held
+}
+
+// ArchiveResult is what archiving a date range moved, by form type, and how many of
+// its submissions were kept live because they're still open
+type ArchiveResult struct {
+	Archived map[string]int
+	Kept     int
+}
+
+// ArchiveSubmissions moves the submissions made from from up to to into the archive
+// tables, in one transaction, leaving the ones archiveHeld keeps
+func ArchiveSubmissions(from, to, archivedAt time.Time) (*ArchiveResult, error) {
+	conn, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	formTypes := make([]string, 0, len(checkoutTables))
+	columns := make(map[string][]string, len(checkoutTables))
+	for formType, table := range checkoutTables {
+		if columns[formType], err = archivedColumns(conn, table, archiveTables[formType]); err != nil {
+			return nil, err
+		}
+		formTypes = append(formTypes, formType)
+	}
+	sort.Strings(formTypes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin archiving: %w", err)
+	}
+	defer tx.Rollback()
+
+	start, end := formatTime(from.UTC()), formatTime(to.UTC())
+	result := &ArchiveResult{Archived: make(map[string]int)}
+	for _, formType := range formTypes {
+		table, archive := checkoutTables[formType], archiveTables[formType]
+		list := strings.Join(columns[formType], ", ")
+
+		moved, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %[1]s (%[3]s, archived_at)
+			SELECT %[3]s, ? FROM %[2]s
+			WHERE submission_date >= ? AND submission_date < ? AND NOT (%[4]s)
+				AND form_id NOT IN (SELECT form_id FROM %[1]s)`, archive, table, list, archiveHeld(formType)),
+			formatTime(archivedAt), start, end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to archive %s submissions: %w", formType, err)
+		}
+		archived, _ := moved.RowsAffected()
+		result.Archived[formType] = int(archived)
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			DELETE FROM %s WHERE submission_date >= ? AND submission_date < ?
+				AND form_id IN (SELECT form_id FROM %s)`, table, archive), start, end); err != nil {
+			return nil, fmt.Errorf("failed to remove archived %s submissions: %w", formType, err)
+		}
+
+		var kept int
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf(`
+			SELECT COUNT(*) FROM %s WHERE submission_date >= ? AND submission_date < ?`, table),
+			start, end).Scan(&kept); err != nil {
+			return nil, fmt.Errorf("failed to count open %s submissions: %w", formType, err)
+		}
+		result.Kept += kept
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit archive: %w", err)
+	}
+	return result, nil
+}
+
+// archivedColumns lists the columns of table in a stable order, checking archive has
+// every one of them
+func archivedColumns(conn *sql.DB, table, archive string) ([]string, error) {
+	live, err := tableColumns(conn, table)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := tableColumns(conn, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(live))
+	for name := range live {
+		if !archived[name] {
+			return nil, fmt.Errorf("%s has no %s column; run the pending migrations first", archive, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListArchivedSubmissions returns the archived submissions matching filter, oldest
+// first, or a page of them newest first when the filter has a limit or offset
+func ListArchivedSubmissions(filter SubmissionFilter) ([]SubmissionSummary, error) {
+	where, args := filter.conditions()
+	if filter.FormType != "" {
+		if _, ok := archiveTables[filter.FormType]; !ok {
+			return nil, fmt.Errorf("unknown form type %s", filter.FormType)
+		}
+		where += " AND form_type = ?"
+		args = append(args, filter.FormType)
+	}
+	return querySummaries(archivedSubmissionsView, where, args, filter.Limit, filter.Offset)
+}
+
+// ListArchivedSubmissionsByEmail returns the archived submissions made with an email
+// address, oldest first
+func ListArchivedSubmissionsByEmail(emailAddress string) ([]SubmissionSummary, error) {
+	contact := NormalizeContact(emailAddress)
+	if contact == "" {
+		return nil, nil
+	}
+	return querySummaries(archivedSubmissionsView, "LOWER(TRIM(pii_open(email))) = ?", []interface{}{contact}, 0, 0)
+}
+
+// ArchivedContact is who made an archived submission, and for which students
+type ArchivedContact struct {
+	FirstName string
+	LastName  string
+	Students  []Student
+}
+
+// GetArchivedContact returns the payer's names and the students of an archived
+// submission, or sql.ErrNoRows
+func GetArchivedContact(formType, formID string) (*ArchivedContact, error) {
+	archive, ok := archiveTables[formType]
+	if !ok {
+		return nil, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	var contact ArchivedContact
+	var firstName, lastName, studentsJSON sql.NullString
+	err := QueryRowDB(fmt.Sprintf(`SELECT first_name, last_name, students_json FROM %s WHERE form_id = ?`, archive),
+		formID).Scan(&firstName, &lastName, &studentsJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archived %s: %w", formID, err)
+	}
+	if err := openPIIFields(&firstName.String, &lastName.String, &studentsJSON.String); err != nil {
+		return nil, fmt.Errorf("failed to read archived %s: %w", formID, err)
+	}
+	contact.FirstName, contact.LastName = firstName.String, lastName.String
+	if studentsJSON.String != "" {
+		if err := unmarshalJSON(studentsJSON.String, &contact.Students); err != nil {
+			return nil, fmt.Errorf("failed to parse students of archived %s: %w", formID, err)
+		}
+	}
+	return &contact, nil
+}
+
+// submissionTableOf returns the table holding formID: its form type's submission
+// table, or the archive once the submission has been archived
+func submissionTableOf(ctx context.Context, tx *sql.Tx, formType, formID string) (string, error) {
+	table := checkoutTables[formType]
+	var live int
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE form_id = ?`, table), formID).Scan(&live)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", formID, err)
+	}
+	if live == 0 {
+		return archiveTables[formType], nil
+	}
+	return table, nil
+}
+
+// =============================================================================
+// ARCHIVE MIGRATION
+// =============================================================================
+
+// migrateArchiveTables creates each submission table's archive with the columns the
+// table has now; addCheckoutColumns keeps them in step from then on
+func migrateArchiveTables(conn *sql.DB, logf logFunc) error {
+	for formType, table := range checkoutTables {
+		archive := archiveTables[formType]
+		if _, err := conn.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s AS SELECT * FROM %s WHERE 1 = 0`,
+			archive, table)); err != nil {
+			return fmt.Errorf("failed to create %s table: %w", archive, err)
+		}
+		if err := addColumns(archive, column{"archived_at", "TEXT"})(conn, logf); err != nil {
+			return err
+		}
+		for _, index := range []string{
+			fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS idx_%[1]s_form_id ON %[1]s (form_id)", archive),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%[1]s_date ON %[1]s (submission_date)", archive),
+		} {
+			if _, err := conn.Exec(index); err != nil {
+				return fmt.Errorf("failed to index %s: %w", archive, err)
+			}
+		}
+	}
+	return steps(dropViews(archivedSubmissionsView),
+		createViews(submissionsViewOver(archivedSubmissionsView, archiveTables)))(conn, logf)
+}
+
+// rollbackArchiveTables moves archived submissions back to the submission tables
+// before dropping the archives, so rolling back loses nothing
+func rollbackArchiveTables(conn *sql.DB, logf logFunc) error {
+	if err := dropViews(archivedSubmissionsView)(conn, logf); err != nil {
+		return err
+	}
+	objects, err := schemaObjects(conn)
+	if err != nil {
+		return err
+	}
+	for formType, table := range checkoutTables {
+		archive := archiveTables[formType]
+		if objects[archive] != "table" {
+			continue
+		}
+		columns, err := archivedColumns(conn, table, archive)
+		if err != nil {
+			return err
+		}
+		list := strings.Join(columns, ", ")
+		result, err := conn.Exec(fmt.Sprintf(`
+			INSERT INTO %[1]s (%[3]s) SELECT %[3]s FROM %[2]s
+			WHERE form_id NOT IN (SELECT form_id FROM %[1]s)`, table, archive, list))
+		if err != nil {
+			return fmt.Errorf("failed to restore archived %s submissions: %w", formType, err)
+		}
+		if restored, _ := result.RowsAffected(); restored > 0 {
+			logf("Restored %d archived %s submissions", restored, formType)
+		}
+		if err := dropTables(archive)(conn, logf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// existingArchiveTables lists the archive tables created so far; migrations before the
+// archive's have none
+func existingArchiveTables(conn *sql.DB) ([]string, error) {
+	objects, err := schemaObjects(conn)
+	if err != nil {
+		return nil, err
+	}
+	var archives []string
+	for _, archive := range archiveTables {
+		if objects[archive] == "table" {
+			archives = append(archives, archive)
+		}
+	}
+	return archives, nil
+}
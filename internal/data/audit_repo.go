@@ -0,0 +1,247 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// Actor is who changed a submission, as its audit log records it
+type Actor string
+
+const (
+	ActorFamily  Actor = "family"  // the family, through the forms and checkout
+	ActorWebhook Actor = "webhook" // a payment provider, through its webhooks
+	ActorAdmin   Actor = "admin"   // a treasurer, through the admin API or boosterctl
+	ActorSystem  Actor = "system"  // the server's own background work
+)
+
+// What changed a submission, as its audit log records it
+const (
+	AuditPaymentUpdate = "payment_update" // the family changed what they're paying, or credit or a coupon did
+	AuditPayPalOrder   = "paypal_order"   // a checkout's order was created or released
+	AuditCapture       = "capture"        // a payment was captured, by whatever route
+	AuditInstallment   = "installment"    // one installment of a payment plan was paid
+	AuditPayPalStatus  = "paypal_status"  // a failed capture was recorded
+	AuditWebhook       = "webhook"        // a payment provider's webhook moved the payment's status
+	AuditDisputeWon    = "dispute_won"    // a dispute the club won restored the payment
+	AuditMarkPaid      = "mark_paid"      // an admin recorded a payment taken outside PayPal
+	AuditRefund        = "refund"         // an admin refunded the payment
+	AuditEmailStatus   = "email_status"   // a confirmation or admin notification email was sent
+)
+
+// AuditEntry is one field of a submission changed by one update. A nil value is a
+// field that was NULL.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	FormID    string    `json:"formID"`
+	Field     string    `json:"field"`
+	OldValue  *string   `json:"oldValue"`
+	NewValue  *string   `json:"newValue"`
+	Action    string    `json:"action"`
+	Actor     Actor     `json:"actor"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// unauditedColumns are left out of the audit log: they hold the secrets in the family's
+// links, not details of the submission
+var unauditedColumns = map[string]bool{"access_token": true, "resume_token": true}
+
+// =============================================================================
+// RECORDING CHANGES
+// =============================================================================
+
+// rowSnapshot is a row's audited fields as text, in column order
+type rowSnapshot struct {
+	columns []string
+	values  map[string]sql.NullString
+}
+
+// changedColumns lists the fields that differ between before and after
+func changedColumns(before, after rowSnapshot) []string {
+	var changed []string
+	for _, column := range after.columns {
+		if before.values[column] != after.values[column] {
+			changed = append(changed, column)
+		}
+	}
+	return changed
+}
+
+// snapshotRow reads formID's row of table inside tx. A missing row has no fields.
+func snapshotRow(ctx context.Context, tx *sql.Tx, table, formID string) (rowSnapshot, error) {
+	snapshot := rowSnapshot{values: make(map[string]sql.NullString)}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s WHERE form_id = ?`, table), formID)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read %s for the audit log: %w", formID, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read %s for the audit log: %w", formID, err)
+	}
+	if !rows.Next() {
+		return snapshot, rows.Err()
+	}
+
+	values := make([]interface{}, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return snapshot, fmt.Errorf("failed to read %s for the audit log: %w", formID, err)
+	}
+
+	for i, column := range columns {
+		if unauditedColumns[column] {
+			continue
+		}
+		snapshot.columns = append(snapshot.columns, column)
+		snapshot.values[column] = auditValue(values[i])
+	}
+	return snapshot, rows.Err()
+}
+
+// auditValue is a column's value as the audit log stores it
+func auditValue(value interface{}) sql.NullString {
+	switch v := value.(type) {
+	case nil:
+		return sql.NullString{}
+	case []byte:
+		return sql.NullString{String: string(v), Valid: true}
+	case time.Time:
+		return sql.NullString{String: formatTime(v), Valid: true}
+	default:
+		return sql.NullString{String: fmt.Sprint(v), Valid: true}
+	}
+}
+
+// recordChanges adds an audit log entry for each field of formID's row of table that
+// differs from before, inside the transaction that changed it, and moves the row's
+// version on if its payment state changed
+func recordChanges(ctx context.Context, tx *sql.Tx, table, formID, action string, actor Actor, before rowSnapshot) error {
+	after, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return err
+	}
+
+	changedAt := formatTime(clock.Now())
+	changed := changedColumns(before, after)
+	for _, column := range changed {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO audit_log (form_id, field, old_value, new_value, action, actor, changed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			formID, column, before.values[column], after.values[column], action, string(actor), changedAt); err != nil {
+			return fmt.Errorf("failed to record %s change to %s: %w", column, formID, err)
+		}
+	}
+	return bumpVersion(ctx, tx, table, formID, before, changed)
+}
+
+// audited runs update in a transaction on conn and records the fields it changed in
+// formID's row of table, so a change is never saved without its audit entries
+func audited(conn *sql.DB, table, formID, action string, actor Actor,
+	update func(ctx context.Context, tx *sql.Tx) (sql.Result, error)) (sql.Result, error) {
+	if conn == nil {
+		return nil, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin %s of %s: %w", action, formID, err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := update(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordChanges(ctx, tx, table, formID, action, actor, before); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit %s of %s: %w", action, formID, err)
+	}
+	return result, nil
+}
+
+// auditedExec runs stmt, an update of formID's row of table, recording what it changed
+func auditedExec(conn *sql.DB, table, formID, action string, actor Actor, stmt string, args ...interface{}) (sql.Result, error) {
+	return audited(conn, table, formID, action, actor, func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+		return execOn(tx, stmt, args...)
+	})
+}
+
+// forgetAuditValues clears the logged values of every field of formID's row of table
+// that differs from before. Anonymizing a submission calls it once the row is scrubbed,
+// so the audit log doesn't keep the personal details the row no longer has.
+func forgetAuditValues(ctx context.Context, tx *sql.Tx, table, formID string, before rowSnapshot) error {
+	after, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range changedColumns(before, after) {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE audit_log SET old_value = NULL, new_value = NULL WHERE form_id = ? AND field = ?`,
+			formID, column); err != nil {
+			return fmt.Errorf("failed to clear audited %s of %s: %w", column, formID, err)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// READING THE LOG
+// =============================================================================
+
+// ListAuditLog returns every change recorded against formID, oldest first
+func ListAuditLog(formID string) ([]AuditEntry, error) {
+	rows, err := QueryDB(`
+		SELECT id, form_id, field, old_value, new_value, action, actor, changed_at
+		FROM audit_log WHERE form_id = ? ORDER BY id`, formID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log of %s: %w", formID, err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var oldValue, newValue sql.NullString
+		var changedAt string
+		if err := rows.Scan(&entry.ID, &entry.FormID, &entry.Field, &oldValue, &newValue,
+			&entry.Action, &entry.Actor, &changedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		// Personal details are logged as they're stored, sealed while encryption is on
+		if err := openPIIFields(&oldValue.String, &newValue.String); err != nil {
+			return nil, fmt.Errorf("failed to read audit entry %d: %w", entry.ID, err)
+		}
+		if oldValue.Valid {
+			entry.OldValue = &oldValue.String
+		}
+		if newValue.Valid {
+			entry.NewValue = &newValue.String
+		}
+		if entry.ChangedAt, err = parseTime(changedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse audit entry time: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
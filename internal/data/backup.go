@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	sqlite "modernc.org/sqlite"
+)
+
+// =============================================================================
+// BACKUPS
+// =============================================================================
+
+// ErrBackupUnsupported is returned backing up a database that isn't SQLite.
+// PostgreSQL is backed up with its own tools, such as pg_dump.
+var ErrBackupUnsupported = errors.New("only SQLite databases can be backed up this way")
+
+// sqliteBackuper is the SQLite driver's connection, which can copy its database
+type sqliteBackuper interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+}
+
+// BackupDatabase copies the global SQLite database to path with SQLite's online backup
+// API, which takes a consistent snapshot, write-ahead log included, while the server
+// carries on writing. The copy is made beside path and renamed into place, so path is
+// never left half written.
+func BackupDatabase(ctx context.Context, path string) error {
+	conn, err := GetDB()
+	if err != nil {
+		return err
+	}
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		return ErrBackupUnsupported
+	}
+
+	partial := path + ".partial"
+	if err := os.Remove(partial); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear an earlier partial backup: %w", err)
+	}
+	if err := copyDatabase(ctx, conn, partial); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("failed to move backup into place: %w", err)
+	}
+	return nil
+}
+
+// copyDatabase runs a backup of conn's database into a new database at path
+func copyDatabase(ctx context.Context, conn *sql.DB, path string) error {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection to back up: %w", err)
+	}
+	defer c.Close()
+
+	return c.Raw(func(driverConn interface{}) error {
+		backuper, ok := driverConn.(sqliteBackuper)
+		if !ok {
+			return ErrBackupUnsupported
+		}
+		backup, err := backuper.NewBackup(path)
+		if err != nil {
+			return fmt.Errorf("failed to start backup: %w", err)
+		}
+		// A negative count copies every page in one step, under a single read transaction
+		if _, err := backup.Step(-1); err != nil {
+			backup.Finish()
+			return fmt.Errorf("failed to copy database: %w", err)
+		}
+		if err := backup.Finish(); err != nil {
+			return fmt.Errorf("failed to finish backup: %w", err)
+		}
+		return nil
+	})
+}
+
+// =============================================================================
+// WRITE-AHEAD LOG
+// =============================================================================
+
+// HoldWAL opens a read transaction on the SQLite database and leaves it open. While it
+// is, no checkpoint can restart the write-ahead log, so every commit stays in the log
+// until the holder, such as the replicator, has copied it. Rolling the transaction
+// back lets the log be checkpointed again.
+func HoldWAL(ctx context.Context) (*sql.Tx, error) {
+	conn, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		return nil, ErrBackupUnsupported
+	}
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin WAL read transaction: %w", err)
+	}
+	// SQLite only takes the read lock at the transaction's first read
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master`).Scan(&count); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to lock the WAL: %w", err)
+	}
+	return tx, nil
+}
+
+// CheckpointWAL copies the write-ahead log's commits into the SQLite database without
+// waiting on readers or writers. It returns how many frames the log held and how many
+// of them are now in the database; when the two match, the next write restarts the log.
+func CheckpointWAL(ctx context.Context) (logFrames, checkpointed int, err error) {
+	conn, err := GetDB()
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		return 0, 0, ErrBackupUnsupported
+	}
+
+	var busy int
+	if err := conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(PASSIVE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return 0, 0, fmt.Errorf("failed to checkpoint the WAL: %w", err)
+	}
+	if busy != 0 {
+		return logFrames, 0, nil
+	}
+	return logFrames, checkpointed, nil
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Bank transfer states, kept in bank_transfer_status. A transfer is pending from when
+// the family authorizes the debit until their bank settles or returns it, which takes
+// a few business days.
+const (
+	BankTransferPending = "pending"
+	BankTransferSettled = "settled"
+	BankTransferFailed  = "failed"
+)
+
+// PaymentPendingStatus is the paypal_status of a submission whose bank transfer hasn't
+// settled; it only becomes COMPLETED, with its receipt and emails, once it does
+const PaymentPendingStatus = "PENDING"
+
+// PendingBankTransfer is a bank transfer waiting to settle
+type PendingBankTransfer struct {
+	FormType string
+	FormID   string
+	OrderID  string
+	Since    time.Time
+}
+
+// RecordBankTransferPending records an authorized bank transfer with the provider's
+// details. A submission already paid is left alone.
+func RecordBankTransferPending(formType, formID, details string, at time.Time) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET
+			paypal_details = ?, paypal_status = ?, submitted = 1,
+			submitted_at = COALESCE(submitted_at, ?),
+			bank_transfer_status = ?,
+			bank_transfer_updated_at = CASE WHEN bank_transfer_status = ? THEN bank_transfer_updated_at ELSE ? END
+		WHERE form_id = ? AND COALESCE(paypal_status, '') != 'COMPLETED'`, table)
+	if _, err := ExecDB(stmt, details, PaymentPendingStatus, formatTime(at), BankTransferPending,
+		BankTransferPending, formatTime(at), formID); err != nil {
+		return fmt.Errorf("failed to record pending bank transfer for %s: %w", formID, err)
+	}
+	return nil
+}
+
+// FailBankTransfer records a bank transfer the bank returned. The submission goes
+// back to unpaid, without its order, so the family can pay another way.
+func FailBankTransfer(formType, formID string, at time.Time) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET
+			paypal_status = 'FAILED', paypal_order_id = '', submitted = 0,
+			bank_transfer_status = ?, bank_transfer_updated_at = ?
+		WHERE form_id = ? AND bank_transfer_status = ?`, table)
+	if _, err := ExecDB(stmt, BankTransferFailed, formatTime(at), formID, BankTransferPending); err != nil {
+		return fmt.Errorf("failed to record failed bank transfer for %s: %w", formID, err)
+	}
+	return nil
+}
+
+// ListPendingBankTransfers returns the bank transfers waiting to settle, oldest first
+func ListPendingBankTransfers() ([]PendingBankTransfer, error) {
+	var pending []PendingBankTransfer
+	for formType, table := range checkoutTables {
+		rows, err := QueryDB(fmt.Sprintf(`
+			SELECT form_id, COALESCE(paypal_order_id, ''), bank_transfer_updated_at FROM %s
+			WHERE bank_transfer_status = ?
+			ORDER BY bank_transfer_updated_at`, table), BankTransferPending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pending %s bank transfers: %w", formType, err)
+		}
+
+		for rows.Next() {
+			transfer := PendingBankTransfer{FormType: formType}
+			var since sql.NullString
+			if err := rows.Scan(&transfer.FormID, &transfer.OrderID, &since); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan pending bank transfer: %w", err)
+			}
+			if since.Valid {
+				if transfer.Since, err = parseTime(since.String); err != nil {
+					rows.Close()
+					return nil, fmt.Errorf("failed to parse bank transfer time for %s: %w", transfer.FormID, err)
+				}
+			}
+			pending = append(pending, transfer)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pending %s bank transfers: %w", formType, err)
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// =============================================================================
+// CAPTURES
+// =============================================================================
+
+// Capture is a completed payment and everything recorded with it. The flags are only
+// ever set, never cleared: a false or empty one leaves the submission as it is.
+type Capture struct {
+	Details     string // the provider's capture response, or how an offline payment was made
+	Status      string
+	SubmittedAt *time.Time
+	Tasks       []OutboxTask
+	Version     int // the payment state's version as the caller read it, or 0 to record the capture over anything
+
+	FundingSource         string // how it was paid, when Details doesn't say, such as credit or check
+	ConfirmationEmailSent bool   // the family already has their receipt
+	AdminNotificationSent bool   // the committee already knows; memberships and fundraisers only
+	OrderPageURL          string // an order page already generated; events only
+}
+
+// ApplyCapture records capture against formID with its receipt number, flags and outbox
+// tasks in one transaction, so either all of it is saved or none of it is. It fails if
+// formID doesn't exist, a flag doesn't apply to its form type or, with ErrVersionConflict,
+// its payment changed after capture.Version was read.
+func ApplyCapture(formType, formID string, capture Capture, actor Actor) error {
+	repo := NewOutboxRepository()
+	return repo.ApplyCapture(formType, formID, capture, actor)
+}
+
+func (r *OutboxRepository) ApplyCapture(formType, formID string, capture Capture, actor Actor) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type: %s", formType)
+	}
+	if capture.AdminNotificationSent && formType == "event" {
+		return fmt.Errorf("event registrations have no admin notification")
+	}
+	if capture.OrderPageURL != "" && formType != "event" {
+		return fmt.Errorf("only event registrations have an order page")
+	}
+
+	if r.db == nil {
+		return errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin capture transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(before, capture.Version); err != nil {
+		return err
+	}
+
+	source := capture.FundingSource
+	if source == "" {
+		source = FundingSource(capture.Details)
+	}
+	instrument := PaymentInstrument(capture.Details)
+	settledAt := formatTime(clock.Now())
+	sets := []string{
+		"paypal_details = ?", "paypal_status = ?", "submitted = 1", "submitted_at = ?",
+		"funding_source = CASE WHEN ? != '' THEN ? ELSE funding_source END",
+		"payment_instrument = CASE WHEN ? != '' THEN ? ELSE payment_instrument END",
+		"bank_transfer_status = CASE WHEN bank_transfer_status = ? THEN ? ELSE bank_transfer_status END",
+		"bank_transfer_updated_at = CASE WHEN bank_transfer_status = ? THEN ? ELSE bank_transfer_updated_at END",
+	}
+	args := []interface{}{
+		capture.Details, capture.Status, formatNullableTime(capture.SubmittedAt),
+		source, source,
+		instrument, instrument,
+		BankTransferPending, BankTransferSettled,
+		BankTransferPending, settledAt,
+	}
+	flagSets, flagArgs := emailSentColumns(formType, capture.ConfirmationEmailSent, capture.AdminNotificationSent, clock.Now())
+	sets, args = append(sets, flagSets...), append(args, flagArgs...)
+	if capture.OrderPageURL != "" {
+		sets, args = append(sets, "order_page_url = ?"), append(args, capture.OrderPageURL)
+	}
+
+	updateStmt := fmt.Sprintf(`UPDATE %s SET %s WHERE form_id = ?`, table, strings.Join(sets, ", "))
+	result, err := tx.ExecContext(ctx, updateStmt, append(args, formID)...)
+	if err != nil {
+		return fmt.Errorf("failed to update PayPal capture: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("no %s submission %s to capture", formType, formID)
+	}
+
+	paidAt := clock.Now()
+	if capture.SubmittedAt != nil {
+		paidAt = *capture.SubmittedAt
+	}
+	if _, err := assignReceiptNumber(ctx, tx, table, formID, paidAt); err != nil {
+		return err
+	}
+	if err := recordChanges(ctx, tx, table, formID, AuditCapture, actor, before); err != nil {
+		return err
+	}
+
+	if err := queueOutboxTasks(ctx, tx, formID, capture.Tasks); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit capture transaction: %w", err)
+	}
+	return nil
+}
+
+// MarkEmailSent records that formID's confirmation email, admin notification or both
+// went out. Only the ones passed as true are set, so marking one never undoes the
+// other when both are sent at once.
+func MarkEmailSent(formType, formID string, confirmation, adminNotification bool) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type: %s", formType)
+	}
+	if adminNotification && formType == "event" {
+		return fmt.Errorf("event registrations have no admin notification")
+	}
+
+	sets, args := emailSentColumns(formType, confirmation, adminNotification, clock.Now())
+	if len(sets) == 0 {
+		return nil
+	}
+	stmt := fmt.Sprintf(`UPDATE %s SET %s WHERE form_id = ?`, table, strings.Join(sets, ", "))
+	if _, err := auditedExec(currentDB(), table, formID, AuditEmailStatus, ActorSystem, stmt, append(args, formID)...); err != nil {
+		return fmt.Errorf("failed to update email status: %w", err)
+	}
+	return nil
+}
+
+// emailSentColumns sets the sent flags passed as true. Event registrations only keep
+// whether their confirmation went out, not when.
+func emailSentColumns(formType string, confirmation, adminNotification bool, sentAt time.Time) ([]string, []interface{}) {
+	var sets []string
+	var args []interface{}
+	if confirmation {
+		sets = append(sets, "confirmation_email_sent = 1")
+		if formType != "event" {
+			sets, args = append(sets, "confirmation_email_sent_at = ?"), append(args, formatTime(sentAt))
+		}
+	}
+	if adminNotification {
+		sets = append(sets, "admin_notification_sent = 1", "admin_notification_sent_at = ?")
+		args = append(args, formatTime(sentAt))
+	}
+	return sets, args
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// checkoutTables maps form types to the tables that hold their submissions
+var checkoutTables = map[string]string{
+	"membership": "membership_submissions",
+	"event":      "event_submissions",
+	"fundraiser": "fundraiser_submissions",
+}
+
+// PendingCheckout is an unpaid submission the abandoned-checkout sweeper acts on
+type PendingCheckout struct {
+	FormID         string
+	FormType       string
+	FullName       string
+	FirstName      string
+	Email          string
+	SubmissionDate time.Time
+	ReminderSentAt *time.Time
+}
+
+// =============================================================================
+// ABANDONED CHECKOUT QUERIES
+// =============================================================================
+
+// GetCheckoutsNeedingReminder returns unpaid submissions created before the cutoff
+// that have not been reminded or abandoned yet
+func GetCheckoutsNeedingReminder(createdBefore time.Time, limit int) ([]PendingCheckout, error) {
+	return queryPendingCheckouts(`
+		submitted = 0 AND COALESCE(paypal_status, '') != 'COMPLETED'
+		AND reminder_sent_at IS NULL AND abandoned_at IS NULL
+		AND email != '' AND anonymized_at IS NULL AND submission_date < ?`, formatTime(createdBefore), limit)
+}
+
+// GetCheckoutsToAbandon returns reminded submissions that still haven't been paid
+// and whose reminder was sent before the cutoff
+func GetCheckoutsToAbandon(remindedBefore time.Time, limit int) ([]PendingCheckout, error) {
+	return queryPendingCheckouts(`
+		submitted = 0 AND COALESCE(paypal_status, '') != 'COMPLETED'
+		AND reminder_sent_at IS NOT NULL AND abandoned_at IS NULL
+		AND reminder_sent_at < ?`, formatTime(remindedBefore), limit)
+}
+
+func queryPendingCheckouts(where, cutoff string, limit int) ([]PendingCheckout, error) {
+	var checkouts []PendingCheckout
+
+	for formType, table := range checkoutTables {
+		query := fmt.Sprintf(`
+			SELECT form_id, full_name, first_name, email, submission_date, reminder_sent_at
+			FROM %s WHERE %s
+			ORDER BY submission_date LIMIT ?`, table, where)
+
+		rows, err := QueryDB(query, cutoff, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query pending %s checkouts: %w", formType, err)
+		}
+
+		for rows.Next() {
+			checkout := PendingCheckout{FormType: formType}
+			var firstName, reminderSentAt sql.NullString
+			var submissionDate string
+
+			if err := rows.Scan(&checkout.FormID, &checkout.FullName, &firstName, &checkout.Email,
+				&submissionDate, &reminderSentAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan pending %s checkout: %w", formType, err)
+			}
+			if err := openPIIFields(&checkout.FullName, &firstName.String, &checkout.Email); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read pending checkout %s: %w", checkout.FormID, err)
+			}
+
+			checkout.FirstName = firstName.String
+			if checkout.SubmissionDate, err = parseTime(submissionDate); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to parse submission date for %s: %w", checkout.FormID, err)
+			}
+			if checkout.ReminderSentAt, err = parseNullableTime(reminderSentAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to parse reminder time for %s: %w", checkout.FormID, err)
+			}
+
+			checkouts = append(checkouts, checkout)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pending %s checkouts: %w", formType, err)
+		}
+	}
+
+	return checkouts, nil
+}
+
+// =============================================================================
+// ABANDONED CHECKOUT UPDATES
+// =============================================================================
+
+// MarkCheckoutReminded records the reminder and the resume token its link carries
+func MarkCheckoutReminded(formType, formID, resumeToken string, sentAt time.Time) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %s SET reminder_sent_at = ?, resume_token = ? WHERE form_id = ?`, table)
+	if _, err := ExecDB(stmt, formatTime(sentAt), resumeToken, formID); err != nil {
+		return fmt.Errorf("failed to mark checkout reminded: %w", err)
+	}
+	return nil
+}
+
+// ResumeCheckout swaps in a new access token for an unpaid, non-abandoned submission,
+// or one part way through its installments, when the resume token from its reminder
+// matches. It reports whether a row matched.
+func ResumeCheckout(formType, formID, resumeToken, accessToken string) (bool, error) {
+	table, ok := checkoutTables[formType]
+	if !ok || resumeToken == "" {
+		return false, nil
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET access_token = ?
+		WHERE form_id = ? AND resume_token = ? AND abandoned_at IS NULL
+			AND (submitted = 0 OR paypal_status = ?)`, table)
+	result, err := ExecDB(stmt, accessToken, formID, resumeToken, InstallmentsStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to resume checkout: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to resume checkout: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// MarkCheckoutAbandoned flags an unpaid submission as abandoned
+func MarkCheckoutAbandoned(formType, formID string, abandonedAt time.Time) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %s SET abandoned_at = ? WHERE form_id = ? AND submitted = 0`, table)
+	if _, err := ExecDB(stmt, formatTime(abandonedAt), formID); err != nil {
+		return fmt.Errorf("failed to mark checkout abandoned: %w", err)
+	}
+	return nil
+}
+
+// ReleaseOrder forgets an unpaid submission's order, along with a failed status it left,
+// so the next checkout creates a new one. It reports false, changing nothing, when the
+// submission was paid or moved to another order in the meantime.
+func ReleaseOrder(formType, formID, orderID string, actor Actor) (bool, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return false, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET paypal_order_id = '', paypal_order_created_at = NULL, paypal_status = ''
+		WHERE form_id = ? AND paypal_order_id = ? AND submitted = 0
+			AND (COALESCE(paypal_status, '') = '' OR paypal_status LIKE 'FAILED%%')`, table)
+	result, err := auditedExec(currentDB(), table, formID, AuditPayPalOrder, actor, stmt, formID, orderID)
+	if err != nil {
+		return false, fmt.Errorf("failed to release order %s of %s: %w", orderID, formID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to release order %s of %s: %w", orderID, formID, err)
+	}
+	return rows > 0, nil
+}
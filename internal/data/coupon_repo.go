@@ -0,0 +1,114 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// couponTables are the submission tables whose checkouts take coupons
+var couponTables = []string{"membership_submissions", "event_submissions"}
+
+// couponClaimHold is how long a claim counts as a use before its payment is recorded.
+// A claim left by a capture that never finished stops counting after it; a bank
+// transfer still settling counts as a use on its own for as long as it takes.
+const couponClaimHold = time.Hour
+
+// ErrCouponUsedUp is returned claiming a use of a coupon that has none left
+var ErrCouponUsedUp = errors.New("coupon has been used up")
+
+// GetCoupon returns the coupon code recorded on a submission and the dollars it took
+// off, or "" and 0 when none was used
+func GetCoupon(formType, formID string) (string, float64, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	var code sql.NullString
+	var discount sql.NullFloat64
+	err := QueryRowDB(fmt.Sprintf(`SELECT coupon_code, coupon_discount FROM %s WHERE form_id = ?`, table), formID).
+		Scan(&code, &discount)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to load coupon of %s: %w", formID, err)
+	}
+	return code.String, discount.Float64, nil
+}
+
+// SetCoupon records the coupon a family applied to a submission and its discount; an
+// empty code clears it
+func SetCoupon(formType, formID, code string, discount float64) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := auditedExec(currentDB(), table, formID, AuditPaymentUpdate, ActorFamily,
+		fmt.Sprintf(`UPDATE %s SET coupon_code = ?, coupon_discount = ? WHERE form_id = ?`, table),
+		code, discount, formID); err != nil {
+		return fmt.Errorf("failed to record coupon of %s: %w", formID, err)
+	}
+	return nil
+}
+
+// CountCouponUses counts the uses of a coupon code: the paid submissions that used it,
+// those whose payment is still settling, and the checkouts that claimed it for a
+// capture still going on. Checkouts that were never paid don't use up a limited coupon.
+func CountCouponUses(code string) (int, error) {
+	stmt, args := couponUses(code, "")
+	var count int
+	if err := QueryRowDB(`SELECT COUNT(*) FROM (`+stmt+`) uses`, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count uses of coupon %s: %w", code, err)
+	}
+	return count, nil
+}
+
+// ClaimCouponUse takes one of a limited coupon's maxUses for formID just before its
+// payment is captured. The count and the claim are one statement, so two checkouts
+// can't both take the last use; ErrCouponUsedUp means other checkouts have them all.
+// Claiming again for the same form is allowed.
+func ClaimCouponUse(code, formID string, maxUses int) error {
+	stmt, args := couponUses(code, formID)
+	now := formatTime(clock.Now())
+	args = append([]interface{}{formID, code, now}, append(args, maxUses)...)
+	result, err := ExecDB(`
+		INSERT INTO coupon_claims (form_id, code, claimed_at)
+		SELECT ?, ?, ? WHERE (SELECT COUNT(*) FROM (`+stmt+`) uses) < ?
+		ON CONFLICT (form_id) DO UPDATE SET code = excluded.code, claimed_at = excluded.claimed_at`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to claim coupon %s for %s: %w", code, formID, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrCouponUsedUp
+	}
+	return nil
+}
+
+// ReleaseCouponUse gives back formID's claim on a coupon when its capture failed
+func ReleaseCouponUse(formID string) error {
+	if _, err := ExecDB(`DELETE FROM coupon_claims WHERE form_id = ?`, formID); err != nil {
+		return fmt.Errorf("failed to release the coupon claim of %s: %w", formID, err)
+	}
+	return nil
+}
+
+// couponUses returns a query listing the form IDs that used code, paid, settling or
+// claimed in the last couponClaimHold, leaving out exceptFormID, with its arguments
+func couponUses(code, exceptFormID string) (string, []interface{}) {
+	var selects []string
+	var args []interface{}
+	for _, table := range couponTables {
+		selects = append(selects, fmt.Sprintf(`
+			SELECT form_id FROM %s
+			WHERE coupon_code = ? AND paypal_status IN ('COMPLETED', ?, ?, ?) AND form_id <> ?`, table))
+		args = append(args, code, DisputedStatus, InstallmentsStatus, PaymentPendingStatus, exceptFormID)
+	}
+	selects = append(selects, `
+			SELECT form_id FROM coupon_claims WHERE code = ? AND claimed_at >= ? AND form_id <> ?`)
+	args = append(args, code, formatTime(clock.Now().Add(-couponClaimHold)), exceptFormID)
+	return strings.Join(selects, " UNION"), args
+}
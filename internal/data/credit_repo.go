@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// Kinds of credit ledger entries. Issued credit adds to a family's balance, a redemption
+// takes it off for a checkout and a release gives back what an unpaid checkout held.
+const (
+	CreditIssued   = "issued"
+	CreditRedeemed = "redeemed"
+	CreditReleased = "released"
+)
+
+// ErrPaymentStarted is returned when credit is applied to a form whose payment has
+// already begun; the PayPal order would no longer match what is owed
+var ErrPaymentStarted = errors.New("payment has already started for this form")
+
+// CreditEntry is one line of a family's credit ledger. Amount is positive for credit
+// issued or released and negative for credit redeemed.
+type CreditEntry struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Kind      string    `json:"kind"`
+	Amount    float64   `json:"amount"`
+	FormID    string    `json:"formID,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreditApplication is the result of applying credit to a form
+type CreditApplication struct {
+	Applied   float64 `json:"applied"`   // credit now held by the form
+	AmountDue float64 `json:"amountDue"` // what is left to pay through PayPal
+	Balance   float64 `json:"balance"`   // credit the family has left
+}
+
+// IssueCredit gives a family credit, as from a cancelled event or a gift certificate
+func IssueCredit(email string, amount float64, reason string) (*CreditEntry, error) {
+	email = NormalizeContact(email)
+	amount = math.Round(amount*100) / 100
+	if email == "" {
+		return nil, fmt.Errorf("credit needs an email")
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("credit amount %.2f must be more than zero", amount)
+	}
+
+	entry := CreditEntry{Email: email, Kind: CreditIssued, Amount: amount, Reason: reason, CreatedAt: clock.Now()}
+	if err := QueryRowDB(`
+		INSERT INTO credits (email, email_index, kind, amount, form_id, reason, created_at)
+		VALUES (?, ?, ?, ?, '', ?, ?)
+		RETURNING id`,
+		sealContact(email), contactIndex(email), entry.Kind, entry.Amount, entry.Reason, formatTime(entry.CreatedAt)).Scan(&entry.ID); err != nil {
+		return nil, fmt.Errorf("failed to issue credit to %s: %w", email, err)
+	}
+	return &entry, nil
+}
+
+// CreditBalance returns the credit a family has left to spend
+func CreditBalance(email string) (float64, error) {
+	var balance float64
+	if err := QueryRowDB(`SELECT COALESCE(SUM(amount), 0) FROM credits WHERE email_index = ?`,
+		contactIndex(email)).Scan(&balance); err != nil {
+		return 0, fmt.Errorf("failed to load credit balance: %w", err)
+	}
+	return math.Round(balance*100) / 100, nil
+}
+
+// ListCredits returns a family's credit ledger, or every family's when email is
+// empty, oldest first
+func ListCredits(email string) ([]CreditEntry, error) {
+	index := contactIndex(email)
+	rows, err := QueryDB(`
+		SELECT id, email, kind, amount, form_id, reason, created_at
+		FROM credits
+		WHERE ? = '' OR email_index = ?
+		ORDER BY created_at, id`, index, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list credits: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []CreditEntry
+	for rows.Next() {
+		entry, err := scanCreditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credits: %w", err)
+	}
+	return entries, nil
+}
+
+// ApplyCredit spends the credit of a form's payer on it, up to what the form costs,
+// and lowers the form's calculated_amount so the PayPal order is only for the rest.
+// The redemption is logged in the ledger. Credit already applied to the form stays
+// as it is, so applying twice is safe.
+func ApplyCredit(formType, formID string) (*CreditApplication, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return nil, fmt.Errorf("unknown form type %s", formType)
+	}
+	conn := currentDB()
+	if conn == nil {
+		return nil, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin applying credit: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return nil, err
+	}
+
+	var email string
+	var amount float64
+	var applied sql.NullFloat64
+	var orderID sql.NullString
+	var submitted sql.NullBool
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT email, calculated_amount, credit_applied, paypal_order_id, submitted FROM %s WHERE form_id = ?`, table),
+		formID).Scan(&email, &amount, &applied, &orderID, &submitted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s for credit: %w", formID, err)
+	}
+	if err := openPIIFields(&email); err != nil {
+		return nil, fmt.Errorf("failed to read %s for credit: %w", formID, err)
+	}
+	index := contactIndex(email)
+
+	var balance float64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM credits WHERE email_index = ?`,
+		index).Scan(&balance); err != nil {
+		return nil, fmt.Errorf("failed to load credit balance: %w", err)
+	}
+	balance = math.Round(balance*100) / 100
+
+	application := &CreditApplication{Applied: applied.Float64, AmountDue: amount, Balance: balance}
+	if applied.Float64 > 0 || balance <= 0 {
+		return application, nil
+	}
+	if submitted.Bool || orderID.String != "" {
+		return nil, ErrPaymentStarted
+	}
+
+	redeem := math.Min(balance, amount)
+	if redeem <= 0 {
+		return application, nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO credits (email, email_index, kind, amount, form_id, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, '', ?)`,
+		sealContact(email), index, CreditRedeemed, -redeem, formID, formatTime(clock.Now())); err != nil {
+		return nil, fmt.Errorf("failed to redeem credit for %s: %w", formID, err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET calculated_amount = ROUND(calculated_amount - ?, 2), credit_applied = ? WHERE form_id = ?`, table),
+		redeem, redeem, formID); err != nil {
+		return nil, fmt.Errorf("failed to apply credit to %s: %w", formID, err)
+	}
+	if err := recordChanges(ctx, tx, table, formID, AuditPaymentUpdate, ActorFamily, before); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to apply credit to %s: %w", formID, err)
+	}
+
+	return &CreditApplication{
+		Applied:   redeem,
+		AmountDue: math.Round((amount-redeem)*100) / 100,
+		Balance:   math.Round((balance-redeem)*100) / 100,
+	}, nil
+}
+
+// ReleaseCredit gives back the credit an unpaid form holds, as when the family changes
+// their selections or abandons the checkout, and restores its calculated_amount. It
+// reports how much was released.
+func ReleaseCredit(formType, formID string, actor Actor) (float64, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return 0, fmt.Errorf("unknown form type %s", formType)
+	}
+	conn := currentDB()
+	if conn == nil {
+		return 0, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin releasing credit: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return 0, err
+	}
+
+	var email string
+	var applied sql.NullFloat64
+	var submitted sql.NullBool
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT email, credit_applied, submitted FROM %s WHERE form_id = ?`, table),
+		formID).Scan(&email, &applied, &submitted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load %s for credit: %w", formID, err)
+	}
+	if applied.Float64 <= 0 || submitted.Bool {
+		return 0, nil
+	}
+	if err := openPIIFields(&email); err != nil {
+		return 0, fmt.Errorf("failed to read %s for credit: %w", formID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO credits (email, email_index, kind, amount, form_id, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, '', ?)`,
+		sealContact(email), contactIndex(email), CreditReleased, applied.Float64, formID, formatTime(clock.Now())); err != nil {
+		return 0, fmt.Errorf("failed to release credit of %s: %w", formID, err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET calculated_amount = ROUND(calculated_amount + credit_applied, 2), credit_applied = 0 WHERE form_id = ?`, table),
+		formID); err != nil {
+		return 0, fmt.Errorf("failed to release credit of %s: %w", formID, err)
+	}
+	if err := recordChanges(ctx, tx, table, formID, AuditPaymentUpdate, actor, before); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to release credit of %s: %w", formID, err)
+	}
+	return applied.Float64, nil
+}
+
+// GetCreditApplied returns the credit a form's payment used
+func GetCreditApplied(formType, formID string) (float64, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return 0, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	var applied sql.NullFloat64
+	err := QueryRowDB(fmt.Sprintf(`SELECT credit_applied FROM %s WHERE form_id = ?`, table), formID).Scan(&applied)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load credit of %s: %w", formID, err)
+	}
+	return applied.Float64, nil
+}
+
+func scanCreditEntry(row interface{ Scan(...interface{}) error }) (*CreditEntry, error) {
+	var entry CreditEntry
+	var formID, reason sql.NullString
+	var createdAt string
+	if err := row.Scan(&entry.ID, &entry.Email, &entry.Kind, &entry.Amount, &formID, &reason, &createdAt); err != nil {
+		return nil, fmt.Errorf("failed to load credit: %w", err)
+	}
+	if err := openPIIFields(&entry.Email); err != nil {
+		return nil, fmt.Errorf("failed to read credit %d: %w", entry.ID, err)
+	}
+	entry.FormID, entry.Reason = formID.String, reason.String
+
+	var err error
+	if entry.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse credit time: %w", err)
+	}
+	return &entry, nil
+}
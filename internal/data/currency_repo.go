@@ -0,0 +1,44 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+)
+
+// GetCurrency returns the currency a submission is charged in, which is the
+// deployment's unless its items were priced in another
+func GetCurrency(formType, formID string) (string, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return "", fmt.Errorf("unknown form type %s", formType)
+	}
+	if currentDB() == nil {
+		return "", errDBNotInitialized
+	}
+
+	var code sql.NullString
+	if err := QueryRowDB(fmt.Sprintf(`SELECT currency FROM %s WHERE form_id = ?`, table), formID).Scan(&code); err != nil {
+		return "", fmt.Errorf("failed to load currency of %s: %w", formID, err)
+	}
+	if code.String == "" {
+		return config.Currency(), nil
+	}
+	return code.String, nil
+}
+
+// SetCurrency records the currency a submission's total was calculated in
+func SetCurrency(formType, formID, code string) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := ExecDB(fmt.Sprintf(`UPDATE %s SET currency = ? WHERE form_id = ?`, table),
+		currency.Normalize(code), formID); err != nil {
+		return fmt.Errorf("failed to record currency of %s: %w", formID, err)
+	}
+	return nil
+}
@@ -6,12 +6,12 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"sbcbackend/internal/config"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
 )
 
 type MembershipSummary struct {
@@ -221,6 +221,12 @@ func ComputeMembershipSummary(entries []MembershipSubmission) (MembershipSummary
 	return summary, extras
 }
 
+// ExtractPayPalCaptureID returns the first capture ID from stored PayPal details
+func ExtractPayPalCaptureID(paypalDetailsJSON, formID string) string {
+	_, captureID, _, _ := extractPayPalDataFromJSON(paypalDetailsJSON, formID)
+	return captureID
+}
+
 // Add this enhanced PayPal data extraction function to your data.go:
 func extractPayPalDataFromJSON(paypalDetailsJSON, formID string) (email, captureID, captureURL string, fee float64) {
 	// Return zeros/empty strings for empty data - this is normal
@@ -229,78 +235,21 @@ func extractPayPalDataFromJSON(paypalDetailsJSON, formID string) (email, capture
 		return "", "", "", 0.0
 	}
 
-	var paypalData map[string]interface{}
-	if err := json.Unmarshal([]byte(paypalDetailsJSON), &paypalData); err != nil {
+	order, err := paypal.ParseOrder([]byte(paypalDetailsJSON))
+	if err != nil {
 		logger.LogWarn("Failed to parse PayPal details JSON for %s: %v", formID, err)
 		return "", "", "", 0.0
 	}
+	email = order.PayerEmail()
 
-	// Extract PayPal email from payer info
-	if payer, ok := paypalData["payer"].(map[string]interface{}); ok {
-		if emailAddr, ok := payer["email_address"].(string); ok {
-			email = emailAddr
-		}
-	}
-
-	// Navigate to capture data: purchase_units[0].payments.captures[0]
-	purchaseUnits, ok := paypalData["purchase_units"].([]interface{})
-	if !ok || len(purchaseUnits) == 0 {
-		logger.LogWarn("No purchase_units found in PayPal data for %s", formID)
-		return email, "", "", 0.0
-	}
-
-	firstUnit, ok := purchaseUnits[0].(map[string]interface{})
-	if !ok {
-		logger.LogWarn("Invalid purchase_units[0] structure for %s", formID)
-		return email, "", "", 0.0
-	}
-
-	payments, ok := firstUnit["payments"].(map[string]interface{})
-	if !ok {
-		logger.LogWarn("No payments found in PayPal data for %s", formID)
-		return email, "", "", 0.0
-	}
-
-	captures, ok := payments["captures"].([]interface{})
-	if !ok || len(captures) == 0 {
+	// The capture sits at purchase_units[0].payments.captures[0]
+	capture := order.FirstCapture()
+	if capture == nil {
 		logger.LogWarn("No captures found in PayPal data for %s", formID)
 		return email, "", "", 0.0
 	}
 
-	firstCapture, ok := captures[0].(map[string]interface{})
-	if !ok {
-		logger.LogWarn("Invalid captures[0] structure for %s", formID)
-		return email, "", "", 0.0
-	}
-
-	// Extract capture ID
-	if capID, ok := firstCapture["id"].(string); ok {
-		captureID = capID
-	}
-
-	// Extract capture URL from links
-	if links, ok := firstCapture["links"].([]interface{}); ok {
-		for _, link := range links {
-			if linkMap, ok := link.(map[string]interface{}); ok {
-				if rel, ok := linkMap["rel"].(string); ok && rel == "self" {
-					if href, ok := linkMap["href"].(string); ok {
-						captureURL = href
-					}
-				}
-			}
-		}
-	}
-
-	// Extract PayPal fee
-	if sellerBreakdown, ok := firstCapture["seller_receivable_breakdown"].(map[string]interface{}); ok {
-		if paypalFee, ok := sellerBreakdown["paypal_fee"].(map[string]interface{}); ok {
-			if feeValue, ok := paypalFee["value"].(string); ok {
-				if parsedFee, err := strconv.ParseFloat(feeValue, 64); err == nil {
-					fee = parsedFee
-				}
-			}
-		}
-	}
+	captureID, captureURL, fee = capture.ID, capture.SelfURL(), capture.Fee()
 
 	// logger.LogInfo("Extracted PayPal data for %s - Email: %s, Capture: %s, Fee: $%.2f",
 	// 		formID, email, captureID, fee)
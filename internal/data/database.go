@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -35,6 +36,8 @@ const (
 
 const TimeFormat = time.RFC3339
 
+var errDBNotInitialized = errors.New("database not initialized")
+
 // =============================================================================
 // STRUCT DEFINITIONS (ALL TYPES)
 // =============================================================================
@@ -46,6 +49,14 @@ type Student struct {
 	Grade string `json:"grade"`
 }
 
+// DietaryNote holds a student's dietary restrictions captured with food selections.
+// Allergy marks notes that must be called out to the kitchen.
+type DietaryNote struct {
+	StudentName string `json:"student_name"`
+	Notes       string `json:"notes"`
+	Allergy     bool   `json:"allergy"`
+}
+
 // Form submission types
 
 type MembershipSubmission struct {
@@ -74,6 +85,8 @@ type MembershipSubmission struct {
 	PayPalDetails        string
 	Submitted            bool
 	SubmittedAt          *time.Time
+	ReceiptNumber        string // sequential per year, assigned when the payment completes
+	Version              int    // of the payment state, as read; see ErrVersionConflict
 
 	// ADD these new computed fields for PayPal data:
 	PayPalEmail      string  `json:"paypal_email,omitempty"`
@@ -110,9 +123,24 @@ type EventSubmission struct {
 	CalculatedAmount     float64
 	CoverFees            bool
 	PayPalOrderID        string
-	PayPalOrderCreatedAt *time.Time // ADD THIS LINE
+	PayPalOrderCreatedAt *time.Time
 	PayPalStatus         string
-	PayPalDetails        string // ADD THIS LINE
+	PayPalDetails        string
+	ReceiptNumber        string
+	Version              int // of the payment state, as read; see ErrVersionConflict
+
+	// Dietary notes keyed by student index ("0", "1", ...), same as student selections
+	DietaryNotes map[string]DietaryNote
+}
+
+// HasAllergies reports whether any student on the submission has an allergy flagged
+func (s EventSubmission) HasAllergies() bool {
+	for _, note := range s.DietaryNotes {
+		if note.Allergy {
+			return true
+		}
+	}
+	return false
 }
 
 type FundraiserSubmission struct {
@@ -138,6 +166,8 @@ type FundraiserSubmission struct {
 	PayPalDetails        string
 	Submitted            bool
 	SubmittedAt          *time.Time
+	ReceiptNumber        string
+	Version              int // of the payment state, as read; see ErrVersionConflict
 
 	// Email tracking fields
 	ConfirmationEmailSent   bool
@@ -146,6 +176,95 @@ type FundraiserSubmission struct {
 	AdminNotificationSentAt *time.Time
 }
 
+// Submission is a submission of any checkout form type: a *MembershipSubmission,
+// *EventSubmission or *FundraiserSubmission. Code that only needs what every form has
+// reads Fields; code that needs more switches on the type.
+type Submission interface {
+	FormType() string
+	Fields() SubmissionFields
+}
+
+// SubmissionFields are the fields every checkout form type has
+type SubmissionFields struct {
+	FormID           string
+	AccessToken      string
+	FirstName        string
+	LastName         string
+	Email            string
+	Students         []Student
+	CalculatedAmount float64
+	CoverFees        bool
+	PayPalOrderID    string
+	PayPalStatus     string
+	Submitted        bool
+	ReceiptNumber    string
+}
+
+func (s *MembershipSubmission) FormType() string { return "membership" }
+func (s *EventSubmission) FormType() string      { return "event" }
+func (s *FundraiserSubmission) FormType() string { return "fundraiser" }
+
+func (s *MembershipSubmission) Fields() SubmissionFields {
+	return SubmissionFields{
+		FormID: s.FormID, AccessToken: s.AccessToken, FirstName: s.FirstName, LastName: s.LastName,
+		Email: s.Email, Students: s.Students, CalculatedAmount: s.CalculatedAmount, CoverFees: s.CoverFees,
+		PayPalOrderID: s.PayPalOrderID, PayPalStatus: s.PayPalStatus, Submitted: s.Submitted,
+		ReceiptNumber: s.ReceiptNumber,
+	}
+}
+
+func (s *EventSubmission) Fields() SubmissionFields {
+	return SubmissionFields{
+		FormID: s.FormID, AccessToken: s.AccessToken, FirstName: s.FirstName, LastName: s.LastName,
+		Email: s.Email, Students: s.Students, CalculatedAmount: s.CalculatedAmount, CoverFees: s.CoverFees,
+		PayPalOrderID: s.PayPalOrderID, PayPalStatus: s.PayPalStatus, Submitted: s.Submitted,
+		ReceiptNumber: s.ReceiptNumber,
+	}
+}
+
+func (s *FundraiserSubmission) Fields() SubmissionFields {
+	return SubmissionFields{
+		FormID: s.FormID, AccessToken: s.AccessToken, FirstName: s.FirstName, LastName: s.LastName,
+		Email: s.Email, Students: s.Students, CalculatedAmount: s.CalculatedAmount, CoverFees: s.CoverFees,
+		PayPalOrderID: s.PayPalOrderID, PayPalStatus: s.PayPalStatus, Submitted: s.Submitted,
+		ReceiptNumber: s.ReceiptNumber,
+	}
+}
+
+// EventOrderChange records a post-payment change to an event's food selections
+// and the difference collected or refunded for it.
+type EventOrderChange struct {
+	ID                     int64
+	FormID                 string
+	CreatedAt              time.Time
+	PreviousSelectionsJSON string
+	NewSelectionsJSON      string
+	HasFoodOrders          bool
+	PreviousAmount         float64
+	NewAmount              float64
+	Delta                  float64
+	PayPalOrderID          string
+	PayPalRefundID         string
+	Status                 string
+	AppliedAt              *time.Time
+}
+
+// OutboxTask is a side effect of a payment (email, order page, outbound webhook) queued in
+// the same transaction as the capture and processed by the outbox worker.
+type OutboxTask struct {
+	ID          int64
+	Kind        string
+	FormID      string
+	PayloadJSON string
+	Status      string
+	Attempts    int
+	LastError   string
+	AvailableAt time.Time
+	CreatedAt   time.Time
+	ClaimedAt   *time.Time
+	CompletedAt *time.Time
+}
+
 type StudentDonation struct {
 	StudentName string  `json:"student_name"`
 	Amount      float64 `json:"amount"`
@@ -155,8 +274,21 @@ type StudentDonation struct {
 // DATABASE CONNECTION AND SETUP
 // =============================================================================
 
-// InitDB initializes the database with connection pooling and resilience
+// InitDB initializes the SQLite database at dataSourceName
 func InitDB(dataSourceName string) error {
+	return InitDatabase(DriverSQLite, dataSourceName)
+}
+
+// InitDatabase initializes the database with connection pooling and resilience:
+// dataSourceName is a SQLite file for DriverSQLite or a connection URL for DriverPostgres
+func InitDatabase(driverName, dataSourceName string) error {
+	if driverName != DriverSQLite && driverName != DriverPostgres {
+		return fmt.Errorf("unknown database driver %q", driverName)
+	}
+	// Encrypted details are opened by SQLite's pii_open; PostgreSQL's only passes plaintext through
+	if driverName == DriverPostgres && PIIEncryptionEnabled() {
+		return fmt.Errorf("personal details can only be encrypted in SQLite; unset the encryption key")
+	}
 	var initErr error
 
 	dbMu.Lock()
@@ -168,15 +300,15 @@ func InitDB(dataSourceName string) error {
 	}
 
 	// Initialize new connection with retry logic
-	initErr = initDBWithRetry(dataSourceName, 3)
+	initErr = initDBWithRetry(driverName, dataSourceName, 3)
 	return initErr
 }
 
-func initDBWithRetry(dataSourceName string, maxRetries int) error {
+func initDBWithRetry(driverName, dataSourceName string, maxRetries int) error {
 	var err error
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		db, err = sql.Open("sqlite", dataSourceName)
+		db, err = openDatabase(driverName, dataSourceName)
 		if err != nil {
 			logger.LogWarn("Database connection attempt %d failed: %v", attempt, err)
 			if attempt < maxRetries {
@@ -220,18 +352,17 @@ func initDBWithRetry(dataSourceName string, maxRetries int) error {
 	return fmt.Errorf("failed to initialize database after %d attempts", maxRetries)
 }
 
-func enablePragmasWithRetry(conn *sql.DB) error {
-	pragmas := []string{
-		"PRAGMA foreign_keys = ON",
-		"PRAGMA journal_mode = WAL",
-		"PRAGMA synchronous = NORMAL",
-		"PRAGMA cache_size = -64000",
-		"PRAGMA temp_store = MEMORY",
-		"PRAGMA mmap_size = 268435456",
+// openDatabase opens, without connecting yet, a database of the given driver
+func openDatabase(driverName, dataSourceName string) (*sql.DB, error) {
+	if driverName == DriverPostgres {
+		return openPostgres(dataSourceName)
 	}
+	return sql.Open("sqlite", dataSourceName)
+}
 
+func enablePragmasWithRetry(conn *sql.DB) error {
 	var lastErr error
-	for _, pragma := range pragmas {
+	for _, pragma := range dialectOf(conn).setup() {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		_, err := conn.ExecContext(ctx, pragma)
 		cancel()
@@ -250,7 +381,7 @@ func GetDB() (*sql.DB, error) {
 	defer dbMu.RUnlock()
 
 	if db == nil {
-		return nil, fmt.Errorf("database not initialized")
+		return nil, errDBNotInitialized
 	}
 
 	// Quick health check
@@ -265,6 +396,14 @@ func GetDB() (*sql.DB, error) {
 	return db, nil
 }
 
+// currentDB returns the global connection without a health check; repositories
+// built from it report errDBNotInitialized when it is nil
+func currentDB() *sql.DB {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	return db
+}
+
 // CloseDB closes the database connection gracefully
 func CloseDB() error {
 	dbMu.Lock()
@@ -340,7 +479,8 @@ const eventTableSchema = `
         calculated_amount REAL DEFAULT 0,
         cover_fees BOOLEAN DEFAULT 0,
         paypal_order_id TEXT,
-        paypal_status TEXT
+        paypal_status TEXT,
+        dietary_notes_json TEXT DEFAULT '{}'
     );
     CREATE INDEX IF NOT EXISTS idx_event_submission_date ON event_submissions(submission_date);
     CREATE INDEX IF NOT EXISTS idx_event_email ON event_submissions(email);`
@@ -378,52 +518,306 @@ const fundraiserTableSchema = `
 	CREATE INDEX IF NOT EXISTS idx_fundraiser_email ON fundraiser_submissions(email);
 	CREATE INDEX IF NOT EXISTS idx_fundraiser_submitted ON fundraiser_submissions(submitted);`
 
+const eventOrderChangeTableSchema = `
+	CREATE TABLE IF NOT EXISTS event_order_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		previous_selections_json TEXT DEFAULT '{}',
+		new_selections_json TEXT DEFAULT '{}',
+		has_food_orders BOOLEAN DEFAULT 0,
+		previous_amount REAL DEFAULT 0,
+		new_amount REAL DEFAULT 0,
+		delta REAL DEFAULT 0,
+		paypal_order_id TEXT DEFAULT '',
+		paypal_refund_id TEXT DEFAULT '',
+		status TEXT NOT NULL,
+		applied_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_event_order_changes_form_id ON event_order_changes(form_id);`
+
+const outboxTableSchema = `
+	CREATE TABLE IF NOT EXISTS outbox_tasks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		form_id TEXT NOT NULL,
+		payload_json TEXT DEFAULT '{}',
+		status TEXT NOT NULL,
+		attempts INTEGER DEFAULT 0,
+		last_error TEXT DEFAULT '',
+		available_at TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		claimed_at TEXT,
+		completed_at TEXT,
+		UNIQUE(kind, form_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_outbox_tasks_status ON outbox_tasks(status, available_at);`
+
+const notificationPreferencesTableSchema = `
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		contact TEXT NOT NULL,
+		category TEXT NOT NULL,
+		channels TEXT NOT NULL DEFAULT '',
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (contact, category)
+	);`
+
+// receiptSequencesTableSchema holds the last receipt number issued each year
+const receiptSequencesTableSchema = `
+	CREATE TABLE IF NOT EXISTS receipt_sequences (
+		year INTEGER PRIMARY KEY,
+		last_number INTEGER NOT NULL
+	);`
+
+// privacyRequestsTableSchema holds parents' requests to export or delete their data
+const privacyRequestsTableSchema = `
+	CREATE TABLE IF NOT EXISTS privacy_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		token TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		expires_at TEXT NOT NULL,
+		verified_at TEXT,
+		deletion_requested_at TEXT,
+		status TEXT NOT NULL,
+		reviewed_by TEXT DEFAULT '',
+		reviewed_at TEXT,
+		review_note TEXT DEFAULT '',
+		completed_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_privacy_requests_email ON privacy_requests(email);
+	CREATE INDEX IF NOT EXISTS idx_privacy_requests_status ON privacy_requests(status);`
+
+// householdsTableSchema holds the families linking submissions by email address
+const householdsTableSchema = `
+	CREATE TABLE IF NOT EXISTS households (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		verified_at TEXT,
+		link_token TEXT DEFAULT '',
+		link_sent_at TEXT,
+		link_expires_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_households_link_token ON households(link_token);`
+
+// refundsTableSchema holds each refund made against a submission's payment
+const refundsTableSchema = `
+	CREATE TABLE IF NOT EXISTS refunds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		refund_id TEXT DEFAULT '',
+		amount REAL NOT NULL,
+		reason TEXT DEFAULT '',
+		note TEXT DEFAULT '',
+		refunded_at TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_refunds_form_id ON refunds(form_id);`
+
+// renewalsTableSchema holds each yearly payment of an auto-renewing membership
+const renewalsTableSchema = `
+	CREATE TABLE IF NOT EXISTS renewals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		subscription_id TEXT NOT NULL,
+		cycle INTEGER NOT NULL,
+		sale_id TEXT NOT NULL UNIQUE,
+		amount REAL DEFAULT 0,
+		paid_at TEXT NOT NULL,
+		covers_through TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_renewals_form_id ON renewals(form_id);`
+
+// installmentsTableSchema holds the payment schedule of registrations paid in installments
+const installmentsTableSchema = `
+	CREATE TABLE IF NOT EXISTS installments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		amount REAL NOT NULL,
+		due_at TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'due',
+		order_id TEXT DEFAULT '',
+		paid_at TEXT,
+		details TEXT DEFAULT '',
+		reminder_sent_at TEXT,
+		UNIQUE(form_id, number)
+	);`
+
+// unmatchedPaymentsTableSchema holds PayPal captures that arrived for no known submission,
+// until an admin links each to the form it paid for
+const unmatchedPaymentsTableSchema = `
+	CREATE TABLE IF NOT EXISTS unmatched_payments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		capture_id TEXT NOT NULL UNIQUE,
+		order_id TEXT DEFAULT '',
+		invoice_id TEXT DEFAULT '',
+		event_type TEXT DEFAULT '',
+		status TEXT DEFAULT '',
+		amount REAL DEFAULT 0,
+		currency TEXT DEFAULT '',
+		details TEXT DEFAULT '',
+		received_at TEXT NOT NULL,
+		linked_form_id TEXT DEFAULT '',
+		linked_at TEXT
+	);`
+
+// webhookEventsTableSchema holds every verified PayPal webhook as it arrived and what
+// processing it did, for replaying, debugging and skipping redeliveries
+const webhookEventsTableSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT UNIQUE,
+		transmission_id TEXT DEFAULT '',
+		event_type TEXT DEFAULT '',
+		form_id TEXT DEFAULT '',
+		payload TEXT NOT NULL,
+		result TEXT NOT NULL DEFAULT 'received',
+		error_message TEXT DEFAULT '',
+		deliveries INTEGER NOT NULL DEFAULT 1,
+		replays INTEGER NOT NULL DEFAULT 0,
+		received_at TEXT NOT NULL,
+		processed_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_events_received_at ON webhook_events(received_at);`
+
+// disputesTableSchema holds the PayPal disputes and chargebacks raised against
+// submissions' payments, updated as PayPal reports on each case
+const disputesTableSchema = `
+	CREATE TABLE IF NOT EXISTS disputes (
+		dispute_id TEXT PRIMARY KEY,
+		form_id TEXT NOT NULL,
+		form_type TEXT NOT NULL,
+		reason TEXT DEFAULT '',
+		status TEXT DEFAULT '',
+		stage TEXT DEFAULT '',
+		outcome TEXT DEFAULT '',
+		amount REAL DEFAULT 0,
+		currency TEXT DEFAULT '',
+		respond_by TEXT DEFAULT '',
+		details TEXT DEFAULT '',
+		opened_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		resolved_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_disputes_form_id ON disputes(form_id);`
+
+// creditsTableSchema is the ledger of family credit: credit issued (from a cancelled
+// event or a gift certificate), redeemed at checkout and released by unpaid checkouts.
+// A family's balance is the sum of its entries.
+const creditsTableSchema = `
+	CREATE TABLE IF NOT EXISTS credits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		amount REAL NOT NULL,
+		form_id TEXT DEFAULT '',
+		reason TEXT DEFAULT '',
+		created_at TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_credits_email ON credits(email);`
+
+// donationsTableSchema holds quick donations to the general fund, made without a form.
+// They are keyed by a form-style ID ("donation-...") so receipt numbers and outbox
+// tasks treat them like submissions.
+const donationsTableSchema = `
+	CREATE TABLE IF NOT EXISTS donations (
+		form_id TEXT PRIMARY KEY,
+		email TEXT NOT NULL,
+		note TEXT DEFAULT '',
+		amount REAL NOT NULL,
+		currency TEXT DEFAULT '',
+		paypal_order_id TEXT DEFAULT '',
+		paypal_status TEXT DEFAULT '',
+		paypal_details TEXT DEFAULT '',
+		receipt_number TEXT,
+		thank_you_sent BOOLEAN DEFAULT 0,
+		created_at TEXT NOT NULL,
+		captured_at TEXT
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_donations_receipt_number ON donations(receipt_number);
+	CREATE INDEX IF NOT EXISTS idx_donations_paypal_order_id ON donations(paypal_order_id);`
+
+// couponClaimsTableSchema holds the use of a limited coupon each checkout claimed just
+// before its payment was captured, so two families can't both take the last one
+const couponClaimsTableSchema = `
+	CREATE TABLE IF NOT EXISTS coupon_claims (
+		form_id TEXT PRIMARY KEY,
+		code TEXT NOT NULL,
+		claimed_at TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_coupon_claims_code ON coupon_claims(code);`
+
+// contactIndexesSchema indexes the blind index each table keeping a contact address is
+// looked up by; households and preferences keep one row per address
+const contactIndexesSchema = `
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_households_email_index ON households(email_index);
+	CREATE INDEX IF NOT EXISTS idx_credits_email_index ON credits(email_index);
+	CREATE INDEX IF NOT EXISTS idx_privacy_requests_email_index ON privacy_requests(email_index);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_preferences_contact_index ON notification_preferences(contact_index, category);`
+
+// serviceTokensTableSchema holds access tokens issued by outside APIs, so a restart
+// reuses one still valid instead of waiting on a new one
+const serviceTokensTableSchema = `
+	CREATE TABLE IF NOT EXISTS service_tokens (
+		name TEXT PRIMARY KEY,
+		token TEXT NOT NULL,
+		issued_for TEXT DEFAULT '',
+		expires_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	);`
+
+// idempotencyKeysTableSchema holds the responses to checkout requests that carried an
+// Idempotency-Key, so a retried request gets the first response instead of a second order
+const idempotencyKeysTableSchema = `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		endpoint TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER DEFAULT 0,
+		content_type TEXT DEFAULT '',
+		response BLOB,
+		created_at TEXT NOT NULL,
+		completed_at TEXT,
+		PRIMARY KEY (endpoint, key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);`
+
+// auditLogTableSchema holds every change made to a submission's fields: one row per
+// field, with its value before and after, what changed it and who
+const auditLogTableSchema = `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		old_value TEXT,
+		new_value TEXT,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		changed_at TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_form_id ON audit_log(form_id);`
+
 // =============================================================================
 // TABLE CREATION AND MIGRATIONS
 // =============================================================================
 
+// CreateTables creates and migrates the schema on the global database
 func CreateTables() error {
-	tables := []struct {
-		name string
-		fn   func() error
-	}{
-		{"membership", createMembershipTable},
-		{"event", createEventTable},
-		{"fundraiser", createFundraiserTable},
-	}
-
-	for _, table := range tables {
-		if err := table.fn(); err != nil {
-			return fmt.Errorf("failed to create %s table: %w", table.name, err)
-		}
-	}
-
-	// Run migrations
-	if err := migrateEventTable(); err != nil {
-		return fmt.Errorf("failed to migrate event table: %w", err)
+	conn := currentDB()
+	if conn == nil {
+		return errDBNotInitialized
 	}
-
-	return nil
+	return Migrate(conn)
 }
 
-func createMembershipTable() error {
-	_, err := db.Exec(membershipTableSchema)
-	return err
-}
+// legacyEventColumns are the food columns event_submissions had before food choices
+// moved to JSON; migrateEventTable rebuilds the table without them
+var legacyEventColumns = []string{"student_meal_provided", "additional_meal", "festival_lunch", "show_food_options"}
 
-func createEventTable() error {
-	_, err := db.Exec(eventTableSchema)
-	return err
-}
-
-func migrateEventTable() error {
+func migrateEventTable(conn *sql.DB) error {
 	// First, check if we need to migrate from old schema to new schema
-	var oldColumnCount int
-	err := db.QueryRow(`
-		SELECT COUNT(*) FROM pragma_table_info('event_submissions') 
-		WHERE name IN ('student_meal_provided', 'additional_meal', 'festival_lunch', 'show_food_options')
-	`).Scan(&oldColumnCount)
-
+	oldColumnCount, err := countColumns(conn, "event_submissions", legacyEventColumns...)
 	if err != nil {
 		return fmt.Errorf("failed to check for old columns: %w", err)
 	}
@@ -457,7 +851,7 @@ func migrateEventTable() error {
 				paypal_status TEXT
 			)`
 
-		_, err = db.Exec(createNewTableSQL)
+		_, err = conn.Exec(createNewTableSQL)
 		if err != nil {
 			return fmt.Errorf("failed to create new event_submissions table: %w", err)
 		}
@@ -487,29 +881,29 @@ func migrateEventTable() error {
 				paypal_order_id, paypal_status
 			FROM event_submissions`
 
-		_, err = db.Exec(copyDataSQL)
+		_, err = conn.Exec(copyDataSQL)
 		if err != nil {
 			return fmt.Errorf("failed to copy data to new table: %w", err)
 		}
 
 		// Drop old table and rename new table
-		_, err = db.Exec(`DROP TABLE event_submissions`)
+		_, err = conn.Exec(`DROP TABLE event_submissions`)
 		if err != nil {
 			return fmt.Errorf("failed to drop old table: %w", err)
 		}
 
-		_, err = db.Exec(`ALTER TABLE event_submissions_new RENAME TO event_submissions`)
+		_, err = conn.Exec(`ALTER TABLE event_submissions_new RENAME TO event_submissions`)
 		if err != nil {
 			return fmt.Errorf("failed to rename new table: %w", err)
 		}
 
 		// Recreate indexes
-		_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_event_submission_date ON event_submissions(submission_date)`)
+		_, err = conn.Exec(`CREATE INDEX IF NOT EXISTS idx_event_submission_date ON event_submissions(submission_date)`)
 		if err != nil {
 			return fmt.Errorf("failed to create submission_date index: %w", err)
 		}
 
-		_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_event_email ON event_submissions(email)`)
+		_, err = conn.Exec(`CREATE INDEX IF NOT EXISTS idx_event_email ON event_submissions(email)`)
 		if err != nil {
 			return fmt.Errorf("failed to create email index: %w", err)
 		}
@@ -517,19 +911,14 @@ func migrateEventTable() error {
 		logger.LogInfo("Successfully migrated event_submissions table to new schema")
 	} else {
 		// Check if order_page_url column exists (for newer installations)
-		var count int
-		err := db.QueryRow(`
-			SELECT COUNT(*) FROM pragma_table_info('event_submissions') 
-			WHERE name='order_page_url'
-		`).Scan(&count)
-
+		count, err := countColumns(conn, "event_submissions", "order_page_url")
 		if err != nil {
 			return fmt.Errorf("failed to check for order_page_url column: %w", err)
 		}
 
 		// If column doesn't exist, add it
 		if count == 0 {
-			_, err = db.Exec(`ALTER TABLE event_submissions ADD COLUMN order_page_url TEXT DEFAULT ''`)
+			_, err = conn.Exec(`ALTER TABLE event_submissions ADD COLUMN order_page_url TEXT DEFAULT ''`)
 			if err != nil {
 				return fmt.Errorf("failed to add order_page_url column: %w", err)
 			}
@@ -540,9 +929,22 @@ func migrateEventTable() error {
 	return nil
 }
 
-func createFundraiserTable() error {
-	_, err := db.Exec(fundraiserTableSchema)
-	return err
+// addColumnIfMissing adds a column to an existing table when older databases don't have it yet
+func addColumnIfMissing(conn *sql.DB, logf func(string, ...interface{}), table, column, definition string) error {
+	count, err := countColumns(conn, table, column)
+	if err != nil {
+		return fmt.Errorf("failed to check for %s column: %w", column, err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s column: %w", column, err)
+	}
+	logf("Added %s column to %s table", column, table)
+	return nil
 }
 
 // =============================================================================
@@ -667,11 +1069,49 @@ func ExecDB(query string, args ...interface{}) (sql.Result, error) {
 	if err != nil {
 		return nil, err
 	}
+	return execOn(dbConn, query, args...)
+}
+
+// QueryDB executes a query with timeout and returns rows
+func QueryDB(query string, args ...interface{}) (*sql.Rows, error) {
+	dbConn, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	return queryOn(dbConn, query, args...)
+}
+
+// QueryRowDB executes a query that returns a single row
+func QueryRowDB(query string, args ...interface{}) *sql.Row {
+	dbConn, _ := GetDB() // We'll let the query fail if DB is unavailable
+	return queryRowOn(dbConn, query, args...)
+}
+
+// dbtx is a database or a transaction on one
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// noConn reports whether conn is missing, as the global database is before it's opened
+func noConn(conn dbtx) bool {
+	db, ok := conn.(*sql.DB)
+	return conn == nil || ok && db == nil
+}
+
+// execOn runs a statement on a specific connection. Repositories use execOn, queryOn
+// and queryRowOn so they work against whichever database they were built with, or
+// inside a transaction on it.
+func execOn(conn dbtx, query string, args ...interface{}) (sql.Result, error) {
+	if noConn(conn) {
+		return nil, errDBNotInitialized
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
 	defer cancel()
 
-	result, err := dbConn.ExecContext(ctx, query, args...)
+	result, err := conn.ExecContext(ctx, query, args...)
 	if err != nil {
 		logger.LogError("Database exec failed: query=%s, error=%v", query, err)
 		return nil, fmt.Errorf("database execution failed: %w", err)
@@ -680,17 +1120,13 @@ func ExecDB(query string, args ...interface{}) (sql.Result, error) {
 	return result, nil
 }
 
-// QueryDB executes a query with timeout and returns rows
-func QueryDB(query string, args ...interface{}) (*sql.Rows, error) {
-	dbConn, err := GetDB()
-	if err != nil {
-		return nil, err
+// queryOn runs a query on a specific connection
+func queryOn(conn dbtx, query string, args ...interface{}) (*sql.Rows, error) {
+	if noConn(conn) {
+		return nil, errDBNotInitialized
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
-	defer cancel()
-
-	rows, err := dbConn.QueryContext(ctx, query, args...)
+	rows, err := conn.QueryContext(readContext(), query, args...)
 	if err != nil {
 		logger.LogError("Database query failed: query=%s, error=%v", query, err)
 		return nil, fmt.Errorf("database query failed: %w", err)
@@ -699,12 +1135,16 @@ func QueryDB(query string, args ...interface{}) (*sql.Rows, error) {
 	return rows, nil
 }
 
-// QueryRowDB executes a query that returns a single row
-func QueryRowDB(query string, args ...interface{}) *sql.Row {
-	dbConn, _ := GetDB() // We'll let the query fail if DB is unavailable
+// queryRowOn runs a single-row query on a specific connection
+func queryRowOn(conn dbtx, query string, args ...interface{}) *sql.Row {
+	return conn.QueryRowContext(readContext(), query, args...)
+}
 
+// readContext bounds a query whose rows are read after the call running it returns.
+// Cancelling it on return would close the rows before they're read, so it ends with
+// its timeout instead.
+func readContext() context.Context {
 	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
-	defer cancel()
-
-	return dbConn.QueryRowContext(ctx, query, args...)
+	time.AfterFunc(queryTimeout, cancel)
+	return ctx
 }
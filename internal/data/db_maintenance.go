@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/logger"
+)
+
+// =============================================================================
+// DATABASE MAINTENANCE
+// =============================================================================
+
+// autoVacuumIncremental is PRAGMA auto_vacuum's value once free pages are kept for
+// PRAGMA incremental_vacuum to give back
+const autoVacuumIncremental = 2
+
+// MaintenanceRun is what one run of MaintainDatabase did
+type MaintenanceRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	// Converted is a database created before incremental vacuuming, rebuilt once with a
+	// full VACUUM so later runs can give free pages back a few at a time
+	Converted       bool   `json:"converted"`
+	FreePagesBefore int64  `json:"free_pages_before"`
+	FreePagesAfter  int64  `json:"free_pages_after"`
+	WALFrames       int    `json:"wal_frames"`
+	Checkpointed    int    `json:"checkpointed"`
+	CheckpointBusy  bool   `json:"checkpoint_busy"` // a reader, such as the replicator, kept the log from being truncated
+	Error           string `json:"error,omitempty"`
+}
+
+var (
+	maintenanceMu   sync.Mutex
+	lastMaintenance *MaintenanceRun
+)
+
+// LastMaintenance returns the most recent run of MaintainDatabase since the server
+// started, or nil before the first
+func LastMaintenance() *MaintenanceRun {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	if lastMaintenance == nil {
+		return nil
+	}
+	run := *lastMaintenance
+	return &run
+}
+
+// MaintainDatabase refreshes the query planner's statistics with ANALYZE, gives the
+// database file's free pages back to the disk with an incremental vacuum, and folds the
+// write-ahead log into the database, truncating it. Each step waits on other writers,
+// so it is meant for off-hours. The run is kept for the database stats.
+func MaintainDatabase(ctx context.Context) (*MaintenanceRun, error) {
+	conn, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		return nil, ErrBackupUnsupported
+	}
+
+	run := &MaintenanceRun{StartedAt: clock.Now()}
+	err = maintain(ctx, conn, run)
+	run.DurationMS = clock.Since(run.StartedAt).Milliseconds()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	maintenanceMu.Lock()
+	recorded := *run
+	lastMaintenance = &recorded
+	maintenanceMu.Unlock()
+	return run, err
+}
+
+func maintain(ctx context.Context, db *sql.DB, run *MaintenanceRun) error {
+	// auto_vacuum only changes for the connection that runs the VACUUM
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection for maintenance: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ANALYZE`); err != nil {
+		return fmt.Errorf("failed to analyze the database: %w", err)
+	}
+
+	if err := conn.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&run.FreePagesBefore); err != nil {
+		return fmt.Errorf("failed to read the free pages: %w", err)
+	}
+	var autoVacuum int
+	if err := conn.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&autoVacuum); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum: %w", err)
+	}
+	if autoVacuum != autoVacuumIncremental {
+		logger.LogInfo("Rebuilding the database with VACUUM so free pages can be given back incrementally")
+		if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+			return fmt.Errorf("failed to set auto_vacuum: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+			return fmt.Errorf("failed to vacuum the database: %w", err)
+		}
+		run.Converted = true
+	} else if run.FreePagesBefore > 0 {
+		// The pragma frees a page each step, so all its rows are read
+		rows, err := conn.QueryContext(ctx, `PRAGMA incremental_vacuum`)
+		if err != nil {
+			return fmt.Errorf("failed to vacuum the database: %w", err)
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("failed to vacuum the database: %w", err)
+		}
+	}
+	if err := conn.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&run.FreePagesAfter); err != nil {
+		return fmt.Errorf("failed to read the free pages: %w", err)
+	}
+
+	var busy int
+	if err := conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &run.WALFrames, &run.Checkpointed); err != nil {
+		return fmt.Errorf("failed to checkpoint the WAL: %w", err)
+	}
+	run.CheckpointBusy = busy != 0
+	return nil
+}
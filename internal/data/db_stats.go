@@ -0,0 +1,162 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// =============================================================================
+// DATABASE STATS
+// =============================================================================
+
+// walWarnBytes is the write-ahead log size past which checkpoints aren't keeping up:
+// readers hold it open faster than SQLite can fold it back into the database
+const walWarnBytes = 64 << 20
+
+// DatabaseStats is a snapshot of how loaded and how large the database is
+type DatabaseStats struct {
+	Driver            string            `json:"driver"`
+	Pool              sql.DBStats       `json:"pool"`
+	Tables            map[string]int64  `json:"tables"`         // rows in each table
+	File              *DatabaseFile     `json:"file,omitempty"` // SQLite only
+	LastMigration     *AppliedMigration `json:"last_migration,omitempty"`
+	PendingMigrations int               `json:"pending_migrations"`
+	LastMaintenance   *MaintenanceRun   `json:"last_maintenance,omitempty"` // SQLite only, since the server started
+	Warnings          []string          `json:"warnings"`
+}
+
+// AppliedMigration is a migration applied to the database, and when
+type AppliedMigration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// DatabaseFile is the size of a SQLite database on disk
+type DatabaseFile struct {
+	Path        string `json:"path"`
+	Bytes       int64  `json:"bytes"`
+	WALBytes    int64  `json:"wal_bytes"`
+	PageSize    int64  `json:"page_size"`
+	PageCount   int64  `json:"page_count"`
+	FreePages   int64  `json:"free_pages"` // reclaimed by VACUUM
+	JournalMode string `json:"journal_mode"`
+}
+
+// GetDatabaseStats reports the global database's connection pool, the rows in each
+// table, the last migration applied and, for SQLite, the file and write-ahead log
+// sizes and the last maintenance run, with warnings for the signs of pressure that come before SQLITE_BUSY errors
+func GetDatabaseStats() (*DatabaseStats, error) {
+	conn, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DatabaseStats{Driver: DriverSQLite, Pool: conn.Stats(), Tables: make(map[string]int64)}
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		stats.Driver = DriverPostgres
+	}
+
+	objects, err := schemaObjects(conn)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(objects))
+	for name, kind := range objects {
+		if kind == "table" {
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		var rows int64
+		if err := conn.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s`, table)).Scan(&rows); err != nil {
+			return nil, fmt.Errorf("failed to count %s rows: %w", table, err)
+		}
+		stats.Tables[table] = rows
+	}
+
+	states, err := MigrationStatus(conn)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			stats.PendingMigrations++
+		} else if stats.LastMigration == nil || state.Version > stats.LastMigration.Version {
+			stats.LastMigration = &AppliedMigration{Version: state.Version, Name: state.Name, AppliedAt: *state.AppliedAt}
+		}
+	}
+
+	if stats.Driver == DriverSQLite {
+		if stats.File, err = sqliteFile(conn); err != nil {
+			return nil, err
+		}
+		stats.LastMaintenance = LastMaintenance()
+	}
+	stats.Warnings = databaseWarnings(stats)
+	return stats, nil
+}
+
+// sqliteFile reads the page counts of conn's main database and the sizes of its file
+// and write-ahead log; an in-memory database has neither
+func sqliteFile(conn *sql.DB) (*DatabaseFile, error) {
+	var file DatabaseFile
+	var seq int
+	var name string
+	if err := conn.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file.Path); err != nil {
+		return nil, fmt.Errorf("failed to find the database file: %w", err)
+	}
+	for pragma, value := range map[string]interface{}{
+		"page_size":      &file.PageSize,
+		"page_count":     &file.PageCount,
+		"freelist_count": &file.FreePages,
+		"journal_mode":   &file.JournalMode,
+	} {
+		if err := conn.QueryRow("PRAGMA " + pragma).Scan(value); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pragma, err)
+		}
+	}
+
+	if file.Path == "" {
+		return &file, nil
+	}
+	for path, size := range map[string]*int64{file.Path: &file.Bytes, file.Path + "-wal": &file.WALBytes} {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the size of %s: %w", path, err)
+		}
+		*size = info.Size()
+	}
+	return &file, nil
+}
+
+// databaseWarnings lists what in stats points to a database under pressure
+func databaseWarnings(stats *DatabaseStats) []string {
+	warnings := []string{}
+	if pool := stats.Pool; pool.MaxOpenConnections > 0 && pool.InUse >= pool.MaxOpenConnections {
+		warnings = append(warnings, fmt.Sprintf("all %d connections are in use", pool.MaxOpenConnections))
+	}
+	if stats.Pool.WaitCount > 0 {
+		warnings = append(warnings, fmt.Sprintf("queries have waited %v for a connection %d times",
+			stats.Pool.WaitDuration, stats.Pool.WaitCount))
+	}
+	if stats.File != nil && stats.File.WALBytes > walWarnBytes {
+		warnings = append(warnings, fmt.Sprintf("the write-ahead log is %d MiB; checkpoints aren't keeping up",
+			stats.File.WALBytes>>20))
+	}
+	if run := stats.LastMaintenance; run != nil && run.Error != "" {
+		warnings = append(warnings, fmt.Sprintf("the last database maintenance failed: %s", run.Error))
+	}
+	if stats.PendingMigrations > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d schema migrations are pending", stats.PendingMigrations))
+	}
+	return warnings
+}
@@ -0,0 +1,147 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// =============================================================================
+// SQL DIALECTS
+// =============================================================================
+
+// Databases the data layer runs on
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// dialect covers what differs between the databases beyond the SQL itself. Queries and
+// the schema are written once, in SQLite's flavor; on PostgreSQL they are rewritten as
+// they're sent (see postgres.go), leaving connection tuning and schema introspection.
+type dialect interface {
+	// setup lists the statements that tune, or define functions on, a newly opened database
+	setup() []string
+	// schemaObjects maps the name of every table and index to its kind
+	schemaObjects(conn *sql.DB) (map[string]string, error)
+	// tableColumns lists the columns of table
+	tableColumns(conn *sql.DB, table string) (map[string]bool, error)
+}
+
+// dialectOf returns the dialect of the database conn was opened on
+func dialectOf(conn *sql.DB) dialect {
+	if _, ok := conn.Driver().(*postgresDriver); ok {
+		return postgresDialect{}
+	}
+	return sqliteDialect{}
+}
+
+// schemaObjects maps the name of every table and index on conn to its kind
+func schemaObjects(conn *sql.DB) (map[string]string, error) {
+	return dialectOf(conn).schemaObjects(conn)
+}
+
+// tableColumns lists the columns of table on conn
+func tableColumns(conn *sql.DB, table string) (map[string]bool, error) {
+	return dialectOf(conn).tableColumns(conn, table)
+}
+
+// countColumns counts how many of names are columns of table
+func countColumns(conn *sql.DB, table string, names ...string) (int, error) {
+	columns, err := tableColumns(conn, table)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, name := range names {
+		if columns[name] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) setup() []string {
+	return []string{
+		// Only takes effect on a new database; MaintainDatabase converts older ones
+		"PRAGMA auto_vacuum = INCREMENTAL",
+		"PRAGMA foreign_keys = ON",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA cache_size = -64000",
+		"PRAGMA temp_store = MEMORY",
+		"PRAGMA mmap_size = 268435456",
+	}
+}
+
+func (sqliteDialect) schemaObjects(conn *sql.DB) (map[string]string, error) {
+	return scanSchemaObjects(conn.Query(`
+		SELECT name, type FROM sqlite_master
+		WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'`))
+}
+
+func (sqliteDialect) tableColumns(conn *sql.DB, table string) (map[string]bool, error) {
+	rows, err := conn.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	return scanColumns(table, rows, err)
+}
+
+type postgresDialect struct{}
+
+// setup has nothing to tune, as PostgreSQL settings belong to the server, not the
+// client. It defines pii_open, which queries shared with SQLite call on the columns
+// that may be encrypted there; details are never encrypted on PostgreSQL.
+func (postgresDialect) setup() []string {
+	return []string{
+		"CREATE OR REPLACE FUNCTION pii_open(value TEXT) RETURNS TEXT LANGUAGE SQL IMMUTABLE AS 'SELECT value'",
+	}
+}
+
+func (postgresDialect) schemaObjects(conn *sql.DB) (map[string]string, error) {
+	return scanSchemaObjects(conn.Query(`
+		SELECT table_name, 'table' FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		UNION ALL
+		SELECT indexname, 'index' FROM pg_indexes WHERE schemaname = current_schema()`))
+}
+
+func (postgresDialect) tableColumns(conn *sql.DB, table string) (map[string]bool, error) {
+	rows, err := conn.Query(`
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ?`, table)
+	return scanColumns(table, rows, err)
+}
+
+func scanSchemaObjects(rows *sql.Rows, err error) (map[string]string, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema objects: %w", err)
+	}
+	defer rows.Close()
+
+	objects := make(map[string]string)
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, fmt.Errorf("failed to scan schema object: %w", err)
+		}
+		objects[name] = kind
+	}
+	return objects, rows.Err()
+}
+
+func scanColumns(table string, rows *sql.Rows, err error) (map[string]bool, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s columns: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan %s column: %w", table, err)
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
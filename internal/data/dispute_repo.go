@@ -0,0 +1,145 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DisputedStatus is the paypal_status of a paid submission while a buyer's dispute or
+// chargeback against its payment is open. A dispute the buyer wins moves it on to
+// REFUNDED; one the club wins puts it back to COMPLETED.
+const DisputedStatus = "DISPUTED"
+
+// Dispute is a PayPal dispute against a submission's payment
+type Dispute struct {
+	DisputeID  string     `json:"disputeID"`
+	FormID     string     `json:"formID"`
+	FormType   string     `json:"formType"`
+	Reason     string     `json:"reason,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Stage      string     `json:"stage,omitempty"`   // INQUIRY or CHARGEBACK
+	Outcome    string     `json:"outcome,omitempty"` // set once resolved
+	Amount     float64    `json:"amount"`
+	Currency   string     `json:"currency,omitempty"`
+	RespondBy  string     `json:"respondBy,omitempty"` // PayPal's deadline for the club's response
+	Details    string     `json:"details,omitempty"`   // the latest dispute resource
+	OpenedAt   time.Time  `json:"openedAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// RecordDispute saves a dispute as PayPal last reported it, reporting whether it is
+// new. A dispute already recorded keeps when it was opened and takes the rest from
+// dispute.
+func RecordDispute(dispute Dispute) (bool, error) {
+	var resolvedAt interface{}
+	if dispute.ResolvedAt != nil {
+		resolvedAt = formatTime(*dispute.ResolvedAt)
+	}
+
+	result, err := ExecDB(`
+		INSERT INTO disputes
+			(dispute_id, form_id, form_type, reason, status, stage, outcome, amount, currency,
+			 respond_by, details, opened_at, updated_at, resolved_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (dispute_id) DO NOTHING`,
+		dispute.DisputeID, dispute.FormID, dispute.FormType, dispute.Reason, dispute.Status, dispute.Stage,
+		dispute.Outcome, dispute.Amount, dispute.Currency, dispute.RespondBy, dispute.Details,
+		formatTime(dispute.OpenedAt), formatTime(dispute.UpdatedAt), resolvedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record dispute %s: %w", dispute.DisputeID, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record dispute %s: %w", dispute.DisputeID, err)
+	}
+	if rows > 0 {
+		return true, nil
+	}
+
+	if _, err := ExecDB(`
+		UPDATE disputes SET
+			reason = ?, status = ?, stage = ?, outcome = ?, amount = ?, currency = ?, respond_by = ?,
+			details = ?, updated_at = ?, resolved_at = COALESCE(?, resolved_at)
+		WHERE dispute_id = ?`,
+		dispute.Reason, dispute.Status, dispute.Stage, dispute.Outcome, dispute.Amount, dispute.Currency,
+		dispute.RespondBy, dispute.Details, formatTime(dispute.UpdatedAt), resolvedAt, dispute.DisputeID); err != nil {
+		return false, fmt.Errorf("failed to update dispute %s: %w", dispute.DisputeID, err)
+	}
+	return false, nil
+}
+
+// ListDisputes returns the disputes against a submission's payments, or against all
+// submissions when formID is empty, newest first
+func ListDisputes(formID string) ([]Dispute, error) {
+	rows, err := QueryDB(`
+		SELECT dispute_id, form_id, form_type, reason, status, stage, outcome, amount, currency,
+			respond_by, details, opened_at, updated_at, resolved_at
+		FROM disputes
+		WHERE ? = '' OR form_id = ?
+		ORDER BY opened_at DESC, dispute_id`, formID, formID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disputes: %w", err)
+	}
+	defer rows.Close()
+
+	var disputes []Dispute
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, *dispute)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read disputes: %w", err)
+	}
+	return disputes, nil
+}
+
+// RestoreDisputedPayment puts a DISPUTED submission back to COMPLETED once the club
+// wins the dispute, reporting whether it was still disputed
+func RestoreDisputedPayment(formType, formID string) (bool, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return false, fmt.Errorf("unknown form type %s", formType)
+	}
+	result, err := auditedExec(currentDB(), table, formID, AuditDisputeWon, ActorWebhook,
+		fmt.Sprintf(`UPDATE %s SET paypal_status = 'COMPLETED' WHERE form_id = ? AND paypal_status = ?`, table),
+		formID, DisputedStatus)
+	if err != nil {
+		return false, fmt.Errorf("failed to restore disputed payment of %s: %w", formID, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to restore disputed payment of %s: %w", formID, err)
+	}
+	return rows > 0, nil
+}
+
+func scanDispute(row interface{ Scan(...interface{}) error }) (*Dispute, error) {
+	var dispute Dispute
+	var reason, status, stage, outcome, currency, respondBy, details sql.NullString
+	var openedAt, updatedAt string
+	var resolvedAt sql.NullString
+	if err := row.Scan(&dispute.DisputeID, &dispute.FormID, &dispute.FormType, &reason, &status, &stage,
+		&outcome, &dispute.Amount, &currency, &respondBy, &details, &openedAt, &updatedAt, &resolvedAt); err != nil {
+		return nil, fmt.Errorf("failed to load dispute: %w", err)
+	}
+	dispute.Reason, dispute.Status, dispute.Stage = reason.String, status.String, stage.String
+	dispute.Outcome, dispute.Currency, dispute.RespondBy = outcome.String, currency.String, respondBy.String
+	dispute.Details = details.String
+
+	var err error
+	if dispute.OpenedAt, err = parseTime(openedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse dispute open time: %w", err)
+	}
+	if dispute.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse dispute update time: %w", err)
+	}
+	if dispute.ResolvedAt, err = parseNullableTime(resolvedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse dispute resolution time: %w", err)
+	}
+	return &dispute, nil
+}
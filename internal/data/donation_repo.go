@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// donationsTable is where quick donations are kept; receipt numbers and the outbox
+// use it like a checkout table
+const donationsTable = "donations"
+
+// Donation is a quick donation to the general fund: an amount, the donor's email and
+// an optional note, paid through PayPal without a form
+type Donation struct {
+	FormID        string     `json:"formID"` // "donation-...", the PayPal invoice ID
+	Email         string     `json:"email"`
+	Note          string     `json:"note,omitempty"`
+	Amount        float64    `json:"amount"`
+	Currency      string     `json:"currency"`
+	PayPalOrderID string     `json:"paypalOrderID,omitempty"`
+	PayPalStatus  string     `json:"paypalStatus,omitempty"`
+	PayPalDetails string     `json:"-"`
+	ReceiptNumber string     `json:"receiptNumber,omitempty"`
+	ThankYouSent  bool       `json:"thankYouSent"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	CapturedAt    *time.Time `json:"capturedAt,omitempty"`
+}
+
+// CreateDonation stores a donation before its PayPal order is created
+func CreateDonation(donation Donation) error {
+	if _, err := ExecDB(`
+		INSERT INTO donations (form_id, email, note, amount, currency, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		donation.FormID, sealPII(donation.Email), donation.Note, donation.Amount, donation.Currency,
+		formatTime(donation.CreatedAt)); err != nil {
+		return fmt.Errorf("failed to create donation %s: %w", donation.FormID, err)
+	}
+	return nil
+}
+
+// SetDonationOrder records the PayPal order created for a donation
+func SetDonationOrder(formID, orderID string) error {
+	if _, err := ExecDB(`UPDATE donations SET paypal_order_id = ? WHERE form_id = ?`, orderID, formID); err != nil {
+		return fmt.Errorf("failed to store PayPal order of donation %s: %w", formID, err)
+	}
+	return nil
+}
+
+// GetDonation loads a donation; sql.ErrNoRows means there is none with the ID
+func GetDonation(formID string) (*Donation, error) {
+	row := QueryRowDB(`
+		SELECT form_id, email, note, amount, currency, paypal_order_id, paypal_status, paypal_details,
+			receipt_number, thank_you_sent, created_at, captured_at
+		FROM donations WHERE form_id = ?`, formID)
+	return scanDonation(row)
+}
+
+// RecordDonationCapture marks a donation paid, gives it a receipt number and queues its
+// tasks in one transaction, reporting whether it was still unpaid. Recording the same
+// capture twice, as from the capture response and its webhook, changes nothing.
+func RecordDonationCapture(formID, paypalDetails string, capturedAt time.Time, tasks []OutboxTask, actor Actor) (bool, error) {
+	conn := currentDB()
+	if conn == nil {
+		return false, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin donation capture transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, donationsTable, formID)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE donations SET paypal_status = 'COMPLETED', paypal_details = ?, captured_at = ?
+		WHERE form_id = ? AND COALESCE(paypal_status, '') != 'COMPLETED'`,
+		paypalDetails, formatTime(capturedAt), formID)
+	if err != nil {
+		return false, fmt.Errorf("failed to record capture of donation %s: %w", formID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if _, err := assignReceiptNumber(ctx, tx, donationsTable, formID, capturedAt); err != nil {
+		return false, err
+	}
+	if err := recordChanges(ctx, tx, donationsTable, formID, AuditCapture, actor, before); err != nil {
+		return false, err
+	}
+	if err := queueOutboxTasks(ctx, tx, formID, tasks); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit donation capture: %w", err)
+	}
+	return true, nil
+}
+
+// MarkDonationThankYouSent records that the donor was thanked
+func MarkDonationThankYouSent(formID string) error {
+	if _, err := ExecDB(`UPDATE donations SET thank_you_sent = 1 WHERE form_id = ?`, formID); err != nil {
+		return fmt.Errorf("failed to mark thank-you of donation %s sent: %w", formID, err)
+	}
+	return nil
+}
+
+func scanDonation(row interface{ Scan(...interface{}) error }) (*Donation, error) {
+	var donation Donation
+	var note, currency, orderID, status, details, receiptNumber sql.NullString
+	var createdAt string
+	var capturedAt sql.NullString
+	if err := row.Scan(&donation.FormID, &donation.Email, &note, &donation.Amount, &currency, &orderID,
+		&status, &details, &receiptNumber, &donation.ThankYouSent, &createdAt, &capturedAt); err != nil {
+		return nil, fmt.Errorf("failed to load donation: %w", err)
+	}
+	if err := openPIIFields(&donation.Email); err != nil {
+		return nil, fmt.Errorf("failed to read donation %s: %w", donation.FormID, err)
+	}
+	donation.Note, donation.Currency, donation.PayPalOrderID = note.String, currency.String, orderID.String
+	donation.PayPalStatus, donation.PayPalDetails, donation.ReceiptNumber = status.String, details.String, receiptNumber.String
+
+	var err error
+	if donation.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse donation time: %w", err)
+	}
+	if donation.CapturedAt, err = parseNullableTime(capturedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse donation capture time: %w", err)
+	}
+	return &donation, nil
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Event order change statuses
+const (
+	EventChangePendingPayment = "pending_payment"
+	EventChangeApplied        = "applied"
+	EventChangeFailed         = "failed"
+)
+
+// =============================================================================
+// EVENT ORDER CHANGE REPOSITORY
+// =============================================================================
+
+type EventChangeRepository struct {
+	db *sql.DB
+}
+
+func NewEventChangeRepository() *EventChangeRepository {
+	return &EventChangeRepository{db: currentDB()}
+}
+
+// NewEventChangeRepositoryWithDB binds the repository to conn instead of the global database
+func NewEventChangeRepositoryWithDB(conn *sql.DB) *EventChangeRepository {
+	return &EventChangeRepository{db: conn}
+}
+
+// =============================================================================
+// CORE CRUD OPERATIONS
+// =============================================================================
+
+// Insert stores a new change and returns its ID
+func (r *EventChangeRepository) Insert(change EventOrderChange) (int64, error) {
+	const stmt = `
+		INSERT INTO event_order_changes (
+			form_id, created_at, previous_selections_json, new_selections_json, has_food_orders,
+			previous_amount, new_amount, delta, paypal_order_id, paypal_refund_id, status, applied_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`
+
+	if r.db == nil {
+		return 0, errDBNotInitialized
+	}
+	var id int64
+	err := queryRowOn(r.db, stmt,
+		change.FormID, formatTime(change.CreatedAt), change.PreviousSelectionsJSON, change.NewSelectionsJSON,
+		change.HasFoodOrders, change.PreviousAmount, change.NewAmount, change.Delta,
+		change.PayPalOrderID, change.PayPalRefundID, change.Status, formatNullableTime(change.AppliedAt),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert event order change: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *EventChangeRepository) GetByID(id int64) (*EventOrderChange, error) {
+	const stmt = `
+		SELECT id, form_id, created_at, previous_selections_json, new_selections_json, has_food_orders,
+			previous_amount, new_amount, delta, paypal_order_id, paypal_refund_id, status, applied_at
+		FROM event_order_changes WHERE id = ?`
+
+	var change EventOrderChange
+	var createdAt string
+	var paypalOrderID, paypalRefundID, appliedAt sql.NullString
+
+	err := queryRowOn(r.db, stmt, id).Scan(
+		&change.ID, &change.FormID, &createdAt, &change.PreviousSelectionsJSON, &change.NewSelectionsJSON,
+		&change.HasFoodOrders, &change.PreviousAmount, &change.NewAmount, &change.Delta,
+		&paypalOrderID, &paypalRefundID, &change.Status, &appliedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if change.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse created at: %w", err)
+	}
+	if change.AppliedAt, err = parseNullableTime(appliedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse applied at: %w", err)
+	}
+	change.PayPalOrderID = paypalOrderID.String
+	change.PayPalRefundID = paypalRefundID.String
+
+	return &change, nil
+}
+
+// =============================================================================
+// UPDATE OPERATIONS
+// =============================================================================
+
+func (r *EventChangeRepository) UpdatePayPalOrder(id int64, orderID string) error {
+	const stmt = `UPDATE event_order_changes SET paypal_order_id = ? WHERE id = ?`
+
+	if _, err := execOn(r.db, stmt, orderID, id); err != nil {
+		return fmt.Errorf("failed to update event order change PayPal order: %w", err)
+	}
+	return nil
+}
+
+func (r *EventChangeRepository) UpdateStatus(id int64, status, refundID string, appliedAt *time.Time) error {
+	const stmt = `
+		UPDATE event_order_changes
+		SET status = ?, paypal_refund_id = COALESCE(NULLIF(?, ''), paypal_refund_id), applied_at = ?
+		WHERE id = ?`
+
+	if _, err := execOn(r.db, stmt, status, refundID, formatNullableTime(appliedAt), id); err != nil {
+		return fmt.Errorf("failed to update event order change status: %w", err)
+	}
+	return nil
+}
+
+// =============================================================================
+// LEGACY BACKWARD COMPATIBILITY FUNCTIONS
+// =============================================================================
+
+func InsertEventOrderChange(change EventOrderChange) (int64, error) {
+	repo := NewEventChangeRepository()
+	return repo.Insert(change)
+}
+
+func GetEventOrderChangeByID(id int64) (*EventOrderChange, error) {
+	repo := NewEventChangeRepository()
+	return repo.GetByID(id)
+}
+
+func UpdateEventOrderChangePayPalOrder(id int64, orderID string) error {
+	repo := NewEventChangeRepository()
+	return repo.UpdatePayPalOrder(id, orderID)
+}
+
+func UpdateEventOrderChangeStatus(id int64, status, refundID string, appliedAt *time.Time) error {
+	repo := NewEventChangeRepository()
+	return repo.UpdateStatus(id, status, refundID, appliedAt)
+}
@@ -1,9 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -17,7 +20,12 @@ type EventRepository struct {
 }
 
 func NewEventRepository() *EventRepository {
-	return &EventRepository{db: db}
+	return &EventRepository{db: currentDB()}
+}
+
+// NewEventRepositoryWithDB binds the repository to conn instead of the global database
+func NewEventRepositoryWithDB(conn *sql.DB) *EventRepository {
+	return &EventRepository{db: conn}
 }
 
 // =============================================================================
@@ -25,77 +33,84 @@ func NewEventRepository() *EventRepository {
 // =============================================================================
 
 func (r *EventRepository) Insert(sub EventSubmission) error {
+	return insertEvent(r.db, sub)
+}
+
+// insertEvent inserts an event registration on conn, which may be a transaction
+func insertEvent(conn dbtx, sub EventSubmission) error {
+	if sub.FormID == "" {
+		return fmt.Errorf("form ID is required")
+	}
+
 	studentsJSON, err := marshalJSON(sub.Students)
 	if err != nil {
 		return fmt.Errorf("failed to marshal students: %w", err)
 	}
 
-	const stmt = `
-		INSERT INTO event_submissions (
-			form_id, access_token, submission_date, event, full_name, first_name, last_name, email, school,
-			student_count, students_json, submitted, submitted_at, food_choices_json, food_order_id, 
-			order_page_url, calculated_amount, cover_fees, paypal_order_id, paypal_status
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	_, err = ExecDB(stmt,
-		sub.FormID, sub.AccessToken, formatTime(sub.SubmissionDate), sub.Event,
-		sub.FullName, sub.FirstName, sub.LastName, sub.Email, sub.School,
-		sub.StudentCount, studentsJSON, sub.Submitted,
-		formatNullableTime(sub.SubmittedAt),
-		sub.FoodChoicesJSON, sub.FoodOrderID, sub.OrderPageURL,
-		sub.CalculatedAmount, sub.CoverFees, sub.PayPalOrderID, sub.PayPalStatus,
-	)
-
+	dietaryNotesJSON, err := marshalDietaryNotes(sub.DietaryNotes)
+	if err != nil {
+		return err
+	}
+
+	// Personal details are stored sealed while encryption is on
+	stored := sub
+	sealPIIFields(&stored.FullName, &stored.FirstName, &stored.LastName, &stored.Email, &studentsJSON, &dietaryNotesJSON)
+
+	_, err = insertEventSubmission(conn, insertEventSubmissionParams{
+		FormID: sub.FormID, AccessToken: sub.AccessToken, SubmissionDate: formatTime(sub.SubmissionDate),
+		Event: sub.Event, FullName: stored.FullName, FirstName: stored.FirstName, LastName: stored.LastName,
+		Email: stored.Email, School: sub.School, StudentCount: int64(sub.StudentCount), StudentsJSON: studentsJSON,
+		Submitted: sub.Submitted, SubmittedAt: nullTime(sub.SubmittedAt), HasFoodOrders: sub.HasFoodOrders,
+		FoodChoicesJSON: sub.FoodChoicesJSON, FoodOrderID: sub.FoodOrderID, OrderPageURL: sub.OrderPageURL,
+		CalculatedAmount: sub.CalculatedAmount, CoverFees: sub.CoverFees, PayPalOrderID: sub.PayPalOrderID,
+		PayPalOrderCreatedAt: nullTime(sub.PayPalOrderCreatedAt), PayPalStatus: sub.PayPalStatus,
+		PayPalDetails: sub.PayPalDetails, DietaryNotesJSON: dietaryNotesJSON,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to insert event submission: %w", err)
 	}
 
+	linkHousehold(conn, "event", sub.FormID, sub.Email, sub.SubmissionDate)
+
 	return nil
 }
 
 func (r *EventRepository) GetByID(formID string) (*EventSubmission, error) {
-	const stmt = `
-		SELECT form_id, access_token, submission_date, event, full_name, first_name, last_name, email, school,
-			student_count, students_json, submitted, submitted_at, has_food_orders, food_choices_json, food_order_id, 
-			order_page_url, calculated_amount, cover_fees, paypal_order_id, paypal_order_created_at, 
-			paypal_status, paypal_details
-		FROM event_submissions WHERE form_id = ?`
-
-	row := QueryRowDB(stmt, formID)
-	return r.scanEventRow(row)
+	row, err := getEventSubmission(r.db, formID)
+	if err != nil {
+		return nil, err
+	}
+	return eventFromRow(row)
 }
+
 func (r *EventRepository) GetByYear(year int) ([]EventSubmission, error) {
-	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(1, 0, 0)
-
-	const stmt = `
-		SELECT form_id, access_token, submission_date, event, full_name, first_name, last_name, email, school,
-			student_count, students_json, submitted, submitted_at, has_food_orders, food_choices_json, food_order_id, 
-			order_page_url, calculated_amount, cover_fees, paypal_order_id, paypal_order_created_at, 
-			paypal_status, paypal_details
-		FROM event_submissions
-		WHERE submission_date >= ? AND submission_date < ? AND submitted = 1
-		ORDER BY submission_date`
-
-	rows, err := QueryDB(stmt, formatTime(start), formatTime(end))
+	return r.List(SubmissionFilter{Year: year})
+}
+
+// List returns the submitted event registrations matching filter, oldest first
+func (r *EventRepository) List(filter SubmissionFilter) ([]EventSubmission, error) {
+	from, to := filter.dateRange()
+	limit, offset := filter.page()
+	rows, err := listEventSubmissions(r.db, listEventSubmissionsParams{
+		From: formatTime(from), To: formatTime(to), School: filter.School, Status: filter.status(),
+		Search: strings.TrimSpace(filter.Search), Pattern: filter.searchPattern(),
+		FundingSource: strings.ToLower(filter.Funding), Limit: limit, Offset: offset,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query events by year: %w", err)
+		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-	defer rows.Close()
 
 	var result []EventSubmission
-	for rows.Next() {
-		event, err := r.scanEventRows(rows)
+	for _, row := range rows {
+		event, err := eventFromRow(row)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan event rows: %w", err)
+			return nil, fmt.Errorf("failed to read event row: %w", err)
 		}
 		result = append(result, *event)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating event rows: %w", err)
-	}
-
+	// The page was taken newest first
+	slices.Reverse(result)
 	return result, nil
 }
 
@@ -103,152 +118,75 @@ func (r *EventRepository) GetByYear(year int) ([]EventSubmission, error) {
 // SCANNING AND POPULATION HELPERS
 // =============================================================================
 
-func (r *EventRepository) scanEventRow(row *sql.Row) (*EventSubmission, error) {
-	var sub EventSubmission
-	var submissionDate, submittedAt sql.NullString
-	var studentsJSON, foodChoicesJSON, foodOrderID, orderPageURL sql.NullString
-	var calculatedAmount sql.NullFloat64
-	var coverFees, hasFoodOrders sql.NullBool
-	var paypalOrderID, paypalOrderCreatedAt, paypalStatus, paypalDetails sql.NullString
-
-	err := row.Scan(
-		&sub.FormID, &sub.AccessToken, &submissionDate, &sub.Event, &sub.FullName, &sub.FirstName,
-		&sub.LastName, &sub.Email, &sub.School, &sub.StudentCount, &studentsJSON,
-		&sub.Submitted, &submittedAt, &hasFoodOrders, &foodChoicesJSON, &foodOrderID, &orderPageURL,
-		&calculatedAmount, &coverFees, &paypalOrderID, &paypalOrderCreatedAt, &paypalStatus, &paypalDetails,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	// Handle nullable fields
-	if foodChoicesJSON.Valid && foodChoicesJSON.String != "" {
-		sub.FoodChoicesJSON = foodChoicesJSON.String
-		_ = json.Unmarshal([]byte(foodChoicesJSON.String), &sub.FoodChoices)
+// eventFromRow converts a generated event_submissions row into an EventSubmission
+func eventFromRow(row eventSubmissionRow) (*EventSubmission, error) {
+	if err := openPIIFields(&row.FullName, &row.FirstName.String, &row.LastName.String, &row.Email,
+		&row.StudentsJSON.String, &row.DietaryNotesJSON.String); err != nil {
+		return nil, fmt.Errorf("failed to read event %s: %w", row.FormID, err)
+	}
+
+	sub := EventSubmission{
+		FormID:           row.FormID,
+		AccessToken:      row.AccessToken.String,
+		Event:            row.Event,
+		FullName:         row.FullName,
+		FirstName:        row.FirstName.String,
+		LastName:         row.LastName.String,
+		Email:            row.Email,
+		School:           row.School.String,
+		StudentCount:     int(row.StudentCount.Int64),
+		Submitted:        row.Submitted.Bool,
+		HasFoodOrders:    row.HasFoodOrders.Bool,
+		FoodOrderID:      row.FoodOrderID.String,
+		OrderPageURL:     row.OrderPageURL.String,
+		CalculatedAmount: row.CalculatedAmount.Float64,
+		CoverFees:        row.CoverFees.Bool,
+		PayPalOrderID:    row.PayPalOrderID.String,
+		PayPalStatus:     row.PayPalStatus.String,
+		PayPalDetails:    row.PayPalDetails.String,
+		ReceiptNumber:    row.ReceiptNumber,
+		Version:          int(row.Version),
+	}
+
+	if row.FoodChoicesJSON.String != "" {
+		sub.FoodChoicesJSON = row.FoodChoicesJSON.String
+		_ = json.Unmarshal([]byte(row.FoodChoicesJSON.String), &sub.FoodChoices)
 	} else {
 		sub.FoodChoices = make(map[string]string)
 	}
 
-	if foodOrderID.Valid {
-		sub.FoodOrderID = foodOrderID.String
-	}
-
-	if orderPageURL.Valid {
-		sub.OrderPageURL = orderPageURL.String
-	}
-
-	if calculatedAmount.Valid {
-		sub.CalculatedAmount = calculatedAmount.Float64
-	}
-
-	if coverFees.Valid {
-		sub.CoverFees = coverFees.Bool
-	}
-
-	if hasFoodOrders.Valid {
-		sub.HasFoodOrders = hasFoodOrders.Bool
-	}
-
-	if paypalOrderID.Valid {
-		sub.PayPalOrderID = paypalOrderID.String
-	}
-
-	if paypalOrderCreatedAt.Valid {
-		if parsedTime, err := parseTime(paypalOrderCreatedAt.String); err == nil {
+	if row.PayPalOrderCreatedAt.Valid {
+		if parsedTime, err := parseTime(row.PayPalOrderCreatedAt.String); err == nil {
 			sub.PayPalOrderCreatedAt = &parsedTime
 		}
 	}
 
-	if paypalStatus.Valid {
-		sub.PayPalStatus = paypalStatus.String
-	}
-
-	if paypalDetails.Valid {
-		sub.PayPalDetails = paypalDetails.String
+	if err := unmarshalNullableJSON(row.DietaryNotesJSON, &sub.DietaryNotes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dietary notes: %w", err)
 	}
 
 	// Parse dates and other fields
-	if err := r.populateEventFromJSON(&sub, submissionDate, submittedAt, studentsJSON.String); err != nil {
+	submissionDate := sql.NullString{String: row.SubmissionDate, Valid: true}
+	if err := populateEventFromJSON(&sub, submissionDate, row.SubmittedAt, row.StudentsJSON.String); err != nil {
 		return nil, err
 	}
 
 	return &sub, nil
 }
 
-// Add scanEventRows for multiple rows
-func (r *EventRepository) scanEventRows(rows *sql.Rows) (*EventSubmission, error) {
-	var sub EventSubmission
-	var submissionDate, submittedAt sql.NullString
-	var studentsJSON, foodChoicesJSON, foodOrderID, orderPageURL sql.NullString
-	var calculatedAmount sql.NullFloat64
-	var coverFees, hasFoodOrders sql.NullBool
-	var paypalOrderID, paypalOrderCreatedAt, paypalStatus, paypalDetails sql.NullString
-
-	err := rows.Scan(
-		&sub.FormID, &sub.AccessToken, &submissionDate, &sub.Event, &sub.FullName, &sub.FirstName,
-		&sub.LastName, &sub.Email, &sub.School, &sub.StudentCount, &studentsJSON,
-		&sub.Submitted, &submittedAt, &hasFoodOrders, &foodChoicesJSON, &foodOrderID, &orderPageURL,
-		&calculatedAmount, &coverFees, &paypalOrderID, &paypalOrderCreatedAt, &paypalStatus, &paypalDetails,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	// Handle nullable fields (same as scanEventRow)
-	if foodChoicesJSON.Valid && foodChoicesJSON.String != "" {
-		sub.FoodChoicesJSON = foodChoicesJSON.String
-		_ = json.Unmarshal([]byte(foodChoicesJSON.String), &sub.FoodChoices)
-	} else {
-		sub.FoodChoices = make(map[string]string)
-	}
-
-	if foodOrderID.Valid {
-		sub.FoodOrderID = foodOrderID.String
-	}
-
-	if orderPageURL.Valid {
-		sub.OrderPageURL = orderPageURL.String
-	}
-
-	if calculatedAmount.Valid {
-		sub.CalculatedAmount = calculatedAmount.Float64
-	}
-
-	if coverFees.Valid {
-		sub.CoverFees = coverFees.Bool
-	}
-
-	if hasFoodOrders.Valid {
-		sub.HasFoodOrders = hasFoodOrders.Bool
+// marshalDietaryNotes stores missing notes as an empty object rather than "null"
+func marshalDietaryNotes(notes map[string]DietaryNote) (string, error) {
+	if notes == nil {
+		notes = map[string]DietaryNote{}
 	}
-
-	if paypalOrderID.Valid {
-		sub.PayPalOrderID = paypalOrderID.String
-	}
-
-	if paypalOrderCreatedAt.Valid {
-		if parsedTime, err := parseTime(paypalOrderCreatedAt.String); err == nil {
-			sub.PayPalOrderCreatedAt = &parsedTime
-		}
-	}
-
-	if paypalStatus.Valid {
-		sub.PayPalStatus = paypalStatus.String
-	}
-
-	if paypalDetails.Valid {
-		sub.PayPalDetails = paypalDetails.String
-	}
-
-	// Parse dates and other fields
-	if err := r.populateEventFromJSON(&sub, submissionDate, submittedAt, studentsJSON.String); err != nil {
-		return nil, err
+	notesJSON, err := marshalJSON(notes)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal dietary notes: %w", err)
 	}
-
-	return &sub, nil
+	return notesJSON, nil
 }
 
-func (r *EventRepository) populateEventFromJSON(sub *EventSubmission,
+func populateEventFromJSON(sub *EventSubmission,
 	submissionDate, submittedAt sql.NullString, studentsJSON string) error {
 
 	// Parse submission date
@@ -282,26 +220,51 @@ func (r *EventRepository) populateEventFromJSON(sub *EventSubmission,
 // Payment updates
 
 func (r *EventRepository) UpdatePayment(sub EventSubmission) error {
-	const stmt = `
-		UPDATE event_submissions 
-		SET food_choices_json = ?, has_food_orders=?, food_order_id=?, calculated_amount = ?, cover_fees = ?
-		WHERE form_id = ?`
-
-	_, err := ExecDB(stmt,
-		sub.FoodChoicesJSON, sub.HasFoodOrders, sub.FoodOrderID, sub.CalculatedAmount, sub.CoverFees, sub.FormID,
-	)
-
+	dietaryNotesJSON, err := marshalDietaryNotes(sub.DietaryNotes)
+	if err != nil {
+		return err
+	}
+	dietaryNotesJSON = sealPII(dietaryNotesJSON)
+
+	result, err := audited(r.db, "event_submissions", sub.FormID, AuditPaymentUpdate, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateEventPayment(tx, updateEventPaymentParams{
+				FoodChoicesJSON: sub.FoodChoicesJSON, HasFoodOrders: sub.HasFoodOrders, FoodOrderID: sub.FoodOrderID,
+				CalculatedAmount: sub.CalculatedAmount, CoverFees: sub.CoverFees, DietaryNotesJSON: dietaryNotesJSON,
+				FormID: sub.FormID, Version: int64(sub.Version),
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update event payment: %w", err)
 	}
 
-	return nil
+	return versionConflict(result, sub.Version)
 }
 
-func (r *EventRepository) UpdateOrderPageURL(formID, orderPageURL string) error {
-	const stmt = `UPDATE event_submissions SET order_page_url = ? WHERE form_id = ?`
+// ApplyOrderChange saves sub's new food selections and total over a paid order, but
+// only while the order is still at sub.Version and previousAmount, the total the change
+// was priced from. Anything else is ErrVersionConflict: another change, a refund or a
+// webhook got there first. The version moves on, so sub.Version is stale afterwards.
+func (r *EventRepository) ApplyOrderChange(sub EventSubmission, previousAmount float64) error {
+	result, err := audited(r.db, "event_submissions", sub.FormID, AuditPaymentUpdate, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return applyEventOrderChange(tx, applyEventOrderChangeParams{
+				FoodChoicesJSON: sub.FoodChoicesJSON, HasFoodOrders: sub.HasFoodOrders, FoodOrderID: sub.FoodOrderID,
+				CalculatedAmount: sub.CalculatedAmount, FormID: sub.FormID, Version: int64(sub.Version),
+				PreviousAmount: previousAmount,
+			})
+		})
+	if err != nil {
+		return fmt.Errorf("failed to apply event order change: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return ErrVersionConflict
+}
 
-	_, err := ExecDB(stmt, orderPageURL, formID)
+func (r *EventRepository) UpdateOrderPageURL(formID, orderPageURL string) error {
+	_, err := updateEventOrderPageURL(r.db, orderPageURL, formID)
 	if err != nil {
 		return fmt.Errorf("failed to update order page URL: %w", err)
 	}
@@ -309,6 +272,22 @@ func (r *EventRepository) UpdateOrderPageURL(formID, orderPageURL string) error
 	return nil
 }
 
+// GetActiveOrderPageURLs returns the order page URLs of every event registration that
+// is paid or still being paid: completed, pending, in installments or disputed. Any
+// generated page not in this set belongs to a refunded, cancelled or deleted order.
+func (r *EventRepository) GetActiveOrderPageURLs() (map[string]bool, error) {
+	rows, err := listActiveEventOrderPageURLs(r.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order page URLs: %w", err)
+	}
+
+	urls := make(map[string]bool)
+	for _, url := range rows {
+		urls[url.String] = true
+	}
+	return urls, nil
+}
+
 // =============================================================================
 // LEGACY BACKWARD COMPATIBILITY FUNCTIONS
 // =============================================================================
@@ -328,14 +307,29 @@ func GetEventsByYear(year int) ([]EventSubmission, error) {
 	return repo.GetByYear(year)
 }
 
+// ListEvents returns the submitted event registrations matching filter, oldest first
+func ListEvents(filter SubmissionFilter) ([]EventSubmission, error) {
+	repo := NewEventRepository()
+	return repo.List(filter)
+}
+
 func UpdateEventPayment(sub EventSubmission) error {
 	repo := NewEventRepository()
 	return repo.UpdatePayment(sub)
 }
 
+// ApplyEventOrderChange saves a change to a paid event order; see
+// EventRepository.ApplyOrderChange
+func ApplyEventOrderChange(sub EventSubmission, previousAmount float64) error {
+	repo := NewEventRepository()
+	return repo.ApplyOrderChange(sub, previousAmount)
+}
+
 func UpdateEventPayPalOrder(formID, orderID string, createdAt *time.Time) error {
-	const stmt = `UPDATE event_submissions SET paypal_order_id = ?, paypal_order_created_at = ? WHERE form_id = ?`
-	_, err := ExecDB(stmt, orderID, formatNullableTime(createdAt), formID)
+	_, err := audited(currentDB(), "event_submissions", formID, AuditPayPalOrder, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateEventPayPalOrder(tx, orderID, nullTime(createdAt), formID)
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal order: %w", err)
 	}
@@ -343,18 +337,50 @@ func UpdateEventPayPalOrder(formID, orderID string, createdAt *time.Time) error
 }
 
 func UpdateEventPayPalCapture(formID, paypalDetails, status string, submittedAt *time.Time) error {
-	const stmt = `
-        UPDATE event_submissions
-        SET paypal_details = ?, paypal_status = ?, submitted = 1, submitted_at = ?
-        WHERE form_id = ?`
-	_, err := ExecDB(stmt, paypalDetails, status, formatNullableTime(submittedAt), formID)
+	_, err := audited(currentDB(), "event_submissions", formID, AuditCapture, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateEventPayPalCapture(tx, updateEventPayPalCaptureParams{
+				PayPalDetails: paypalDetails, PayPalStatus: status, SubmittedAt: nullTime(submittedAt), FormID: formID,
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal capture: %w", err)
 	}
 	return nil
 }
 
+// ClaimEventConfirmationEmail marks the event confirmation as sent before it goes out,
+// returning false if it was already claimed so the email is only sent once.
+func ClaimEventConfirmationEmail(formID string) (bool, error) {
+	result, err := audited(currentDB(), "event_submissions", formID, AuditEmailStatus, ActorSystem,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return claimEventConfirmationEmail(tx, formID)
+		})
+	if err != nil {
+		return false, fmt.Errorf("failed to claim confirmation email: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ReleaseEventConfirmationEmail clears the claim after a failed send so it can be retried
+func ReleaseEventConfirmationEmail(formID string) error {
+	_, err := audited(currentDB(), "event_submissions", formID, AuditEmailStatus, ActorSystem,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return releaseEventConfirmationEmail(tx, formID)
+		})
+	if err != nil {
+		return fmt.Errorf("failed to release confirmation email: %w", err)
+	}
+	return nil
+}
+
 func UpdateEventOrderPageURL(formID, orderPageURL string) error {
 	repo := NewEventRepository()
 	return repo.UpdateOrderPageURL(formID, orderPageURL)
 }
+
+func GetActiveEventOrderPageURLs() (map[string]bool, error) {
+	repo := NewEventRepository()
+	return repo.GetActiveOrderPageURLs()
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+
+	"sbcbackend/internal/paypal"
+)
+
+// FundingSource returns how a payment was funded (paypal, venmo, card...) from the
+// payment_source of stored PayPal details, or "" when they don't say
+func FundingSource(paymentDetailsJSON string) string {
+	if paymentDetailsJSON == "" {
+		return ""
+	}
+	order, err := paypal.ParseOrder([]byte(paymentDetailsJSON))
+	if err != nil {
+		return ""
+	}
+	return order.FundingSource()
+}
+
+// PaymentInstrument describes the card that paid from stored PayPal details, such as
+// "VISA ending 4242", or "" for PayPal and Venmo payments
+func PaymentInstrument(paymentDetailsJSON string) string {
+	if paymentDetailsJSON == "" {
+		return ""
+	}
+	order, err := paypal.ParseOrder([]byte(paymentDetailsJSON))
+	if err != nil {
+		return ""
+	}
+	return order.Instrument()
+}
+
+// GetFundingSource returns the funding source recorded for a submission: the one the
+// family chose until the payment completes, then the one that paid
+func GetFundingSource(formType, formID string) (string, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return "", fmt.Errorf("unknown form type %s", formType)
+	}
+
+	var source sql.NullString
+	err := QueryRowDB(fmt.Sprintf(`SELECT funding_source FROM %s WHERE form_id = ?`, table), formID).Scan(&source)
+	if err != nil {
+		return "", fmt.Errorf("failed to load funding source of %s: %w", formID, err)
+	}
+	return source.String, nil
+}
+
+// SetFundingSource records the funding source a family chose for a submission's order
+func SetFundingSource(formType, formID, source string) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := ExecDB(fmt.Sprintf(`UPDATE %s SET funding_source = ? WHERE form_id = ?`, table), source, formID); err != nil {
+		return fmt.Errorf("failed to record funding source of %s: %w", formID, err)
+	}
+	return nil
+}
+
+// SetPaymentInstrument records the card or wallet a family chose for a submission's
+// order, before it is paid
+func SetPaymentInstrument(formType, formID, instrument string) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := ExecDB(fmt.Sprintf(`UPDATE %s SET payment_instrument = ? WHERE form_id = ?`, table), instrument, formID); err != nil {
+		return fmt.Errorf("failed to record payment instrument of %s: %w", formID, err)
+	}
+	return nil
+}
+
+// fillFundingSources reads the funding source of payments taken before it was recorded
+// out of their stored PayPal details
+func fillFundingSources(conn *sql.DB, logf func(string, ...interface{})) error {
+	for _, table := range checkoutTables {
+		rows, err := conn.Query(fmt.Sprintf(`
+			SELECT form_id, paypal_details FROM %s
+			WHERE COALESCE(funding_source, '') = '' AND paypal_details LIKE '%%"payment_source"%%'`, table))
+		if err != nil {
+			return fmt.Errorf("failed to find payments without a funding source in %s: %w", table, err)
+		}
+		sources := make(map[string]string)
+		for rows.Next() {
+			var formID, details string
+			if err := rows.Scan(&formID, &details); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan payment details: %w", err)
+			}
+			if source := FundingSource(details); source != "" {
+				sources[formID] = source
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read payments in %s: %w", table, err)
+		}
+
+		for formID, source := range sources {
+			if _, err := conn.Exec(fmt.Sprintf(`UPDATE %s SET funding_source = ? WHERE form_id = ?`, table), source, formID); err != nil {
+				return fmt.Errorf("failed to record funding source of %s: %w", formID, err)
+			}
+		}
+		if len(sources) > 0 {
+			logf("Recorded funding sources of %d earlier payments in %s", len(sources), table)
+		}
+	}
+	return nil
+}
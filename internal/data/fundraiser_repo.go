@@ -1,8 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -17,7 +20,12 @@ type FundraiserRepository struct {
 }
 
 func NewFundraiserRepository() *FundraiserRepository {
-	return &FundraiserRepository{db: db}
+	return &FundraiserRepository{db: currentDB()}
+}
+
+// NewFundraiserRepositoryWithDB binds the repository to conn instead of the global database
+func NewFundraiserRepositoryWithDB(conn *sql.DB) *FundraiserRepository {
+	return &FundraiserRepository{db: conn}
 }
 
 // =============================================================================
@@ -25,6 +33,15 @@ func NewFundraiserRepository() *FundraiserRepository {
 // =============================================================================
 
 func (r *FundraiserRepository) Insert(sub FundraiserSubmission) error {
+	return insertFundraiser(r.db, sub)
+}
+
+// insertFundraiser inserts a fundraiser donation on conn, which may be a transaction
+func insertFundraiser(conn dbtx, sub FundraiserSubmission) error {
+	if sub.FormID == "" {
+		return fmt.Errorf("form ID is required")
+	}
+
 	studentsJSON, err := marshalJSON(sub.Students)
 	if err != nil {
 		return fmt.Errorf("failed to marshal students: %w", err)
@@ -35,74 +52,64 @@ func (r *FundraiserRepository) Insert(sub FundraiserSubmission) error {
 		return fmt.Errorf("failed to marshal donation items: %w", err)
 	}
 
-	const stmt = `
-		INSERT INTO fundraiser_submissions (
-			form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
-			describe, donor_status, student_count, students_json, donation_items_json, total_amount,
-			cover_fees, calculated_amount, paypal_order_id, paypal_order_created_at, paypal_status,
-			paypal_details, submitted, submitted_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	_, err = ExecDB(stmt,
-		sub.FormID, sub.AccessToken, formatTime(sub.SubmissionDate),
-		sub.FullName, sub.FirstName, sub.LastName, sub.Email, sub.School,
-		sub.Describe, sub.DonorStatus, sub.StudentCount, studentsJSON,
-		donationItemsJSON, sub.TotalAmount, sub.CoverFees, sub.CalculatedAmount,
-		sub.PayPalOrderID, formatNullableTime(sub.PayPalOrderCreatedAt),
-		sub.PayPalStatus, sub.PayPalDetails, sub.Submitted,
-		formatNullableTime(sub.SubmittedAt),
-	)
-
+	// Personal details are stored sealed while encryption is on
+	stored := sub
+	sealPIIFields(&stored.FullName, &stored.FirstName, &stored.LastName, &stored.Email, &studentsJSON, &donationItemsJSON)
+
+	_, err = insertFundraiserSubmission(conn, insertFundraiserSubmissionParams{
+		FormID: sub.FormID, AccessToken: sub.AccessToken, SubmissionDate: formatTime(sub.SubmissionDate),
+		FullName: stored.FullName, FirstName: stored.FirstName, LastName: stored.LastName, Email: stored.Email,
+		School: sub.School, Describe: sub.Describe, DonorStatus: sub.DonorStatus, StudentCount: int64(sub.StudentCount),
+		StudentsJSON: studentsJSON, DonationItemsJSON: donationItemsJSON, TotalAmount: sub.TotalAmount,
+		CoverFees: sub.CoverFees, CalculatedAmount: sub.CalculatedAmount, PayPalOrderID: sub.PayPalOrderID,
+		PayPalOrderCreatedAt: nullTime(sub.PayPalOrderCreatedAt), PayPalStatus: sub.PayPalStatus,
+		PayPalDetails: sub.PayPalDetails, Submitted: sub.Submitted, SubmittedAt: nullTime(sub.SubmittedAt),
+	})
 	if err != nil {
 		return fmt.Errorf("failed to insert fundraiser submission: %w", err)
 	}
 
+	linkHousehold(conn, "fundraiser", sub.FormID, sub.Email, sub.SubmissionDate)
+
 	return nil
 }
 
 func (r *FundraiserRepository) GetByID(formID string) (*FundraiserSubmission, error) {
-	const stmt = `
-		SELECT form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
-			describe, donor_status, student_count, students_json, donation_items_json, total_amount,
-			cover_fees, calculated_amount, paypal_order_id, paypal_order_created_at, paypal_status,
-			paypal_details, submitted, submitted_at
-		FROM fundraiser_submissions WHERE form_id = ?`
-
-	row := QueryRowDB(stmt, formID)
-	return r.scanFundraiserRow(row)
+	row, err := getFundraiserSubmission(r.db, formID)
+	if err != nil {
+		return nil, err
+	}
+	return fundraiserFromRow(row)
 }
+
 func (r *FundraiserRepository) GetByYear(year int) ([]FundraiserSubmission, error) {
-	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(1, 0, 0)
-
-	const stmt = `
-		SELECT form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
-			describe, donor_status, student_count, students_json, donation_items_json, total_amount,
-			cover_fees, calculated_amount, paypal_order_id, paypal_order_created_at, paypal_status,
-			paypal_details, submitted, submitted_at
-		FROM fundraiser_submissions
-		WHERE submission_date >= ? AND submission_date < ?
-		ORDER BY submission_date`
-
-	rows, err := QueryDB(stmt, formatTime(start), formatTime(end))
+	return r.List(SubmissionFilter{Year: year})
+}
+
+// List returns the fundraisers matching filter, oldest first
+func (r *FundraiserRepository) List(filter SubmissionFilter) ([]FundraiserSubmission, error) {
+	from, to := filter.dateRange()
+	limit, offset := filter.page()
+	rows, err := listFundraiserSubmissions(r.db, listFundraiserSubmissionsParams{
+		From: formatTime(from), To: formatTime(to), School: filter.School, Status: filter.status(),
+		Search: strings.TrimSpace(filter.Search), Pattern: filter.searchPattern(),
+		FundingSource: strings.ToLower(filter.Funding), Limit: limit, Offset: offset,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query fundraisers by year: %w", err)
+		return nil, fmt.Errorf("failed to query fundraisers: %w", err)
 	}
-	defer rows.Close()
 
 	var result []FundraiserSubmission
-	for rows.Next() {
-		fundraiser, err := r.scanFundraiserRows(rows)
+	for _, row := range rows {
+		fundraiser, err := fundraiserFromRow(row)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan fundraiser rows: %w", err)
+			return nil, fmt.Errorf("failed to read fundraiser row: %w", err)
 		}
 		result = append(result, *fundraiser)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating fundraiser rows: %w", err)
-	}
-
+	// The page was taken newest first
+	slices.Reverse(result)
 	return result, nil
 }
 
@@ -110,53 +117,45 @@ func (r *FundraiserRepository) GetByYear(year int) ([]FundraiserSubmission, erro
 // SCANNING AND POPULATION HELPERS
 // =============================================================================
 
-func (r *FundraiserRepository) scanFundraiserRow(row *sql.Row) (*FundraiserSubmission, error) {
-	var sub FundraiserSubmission
-	var submissionDate, paypalOrderCreatedAt, submittedAt sql.NullString
-	var studentsJSON, donationItemsJSON sql.NullString
-
-	err := row.Scan(
-		&sub.FormID, &sub.AccessToken, &submissionDate, &sub.FullName, &sub.FirstName, &sub.LastName,
-		&sub.Email, &sub.School, &sub.Describe, &sub.DonorStatus, &sub.StudentCount,
-		&studentsJSON, &donationItemsJSON, &sub.TotalAmount, &sub.CoverFees, &sub.CalculatedAmount,
-		&sub.PayPalOrderID, &paypalOrderCreatedAt, &sub.PayPalStatus, &sub.PayPalDetails,
-		&sub.Submitted, &submittedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan fundraiser: %w", err)
-	}
-
-	if err := r.populateFundraiserFromJSON(&sub, submissionDate, paypalOrderCreatedAt, submittedAt,
-		studentsJSON, donationItemsJSON); err != nil {
-		return nil, fmt.Errorf("failed to populate fundraiser from JSON: %w", err)
+// fundraiserFromRow converts a generated fundraiser_submissions row into a FundraiserSubmission
+func fundraiserFromRow(row fundraiserSubmissionRow) (*FundraiserSubmission, error) {
+	if err := openPIIFields(&row.FullName, &row.FirstName.String, &row.LastName.String, &row.Email,
+		&row.StudentsJSON.String, &row.DonationItemsJSON.String); err != nil {
+		return nil, fmt.Errorf("failed to read fundraiser %s: %w", row.FormID, err)
 	}
 
-	return &sub, nil
-}
-func (r *FundraiserRepository) scanFundraiserRows(rows *sql.Rows) (*FundraiserSubmission, error) {
-	var sub FundraiserSubmission
-	var submissionDate, paypalOrderCreatedAt, submittedAt sql.NullString
-	var studentsJSON, donationItemsJSON sql.NullString
-
-	err := rows.Scan(
-		&sub.FormID, &sub.AccessToken, &submissionDate, &sub.FullName, &sub.FirstName, &sub.LastName,
-		&sub.Email, &sub.School, &sub.Describe, &sub.DonorStatus, &sub.StudentCount,
-		&studentsJSON, &donationItemsJSON, &sub.TotalAmount, &sub.CoverFees, &sub.CalculatedAmount,
-		&sub.PayPalOrderID, &paypalOrderCreatedAt, &sub.PayPalStatus, &sub.PayPalDetails,
-		&sub.Submitted, &submittedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan fundraiser: %w", err)
+	sub := FundraiserSubmission{
+		FormID:           row.FormID,
+		AccessToken:      row.AccessToken.String,
+		FullName:         row.FullName,
+		FirstName:        row.FirstName.String,
+		LastName:         row.LastName.String,
+		Email:            row.Email,
+		School:           row.School.String,
+		Describe:         row.Describe.String,
+		DonorStatus:      row.DonorStatus.String,
+		StudentCount:     int(row.StudentCount.Int64),
+		TotalAmount:      row.TotalAmount.Float64,
+		CoverFees:        row.CoverFees.Bool,
+		CalculatedAmount: row.CalculatedAmount.Float64,
+		PayPalOrderID:    row.PayPalOrderID.String,
+		PayPalStatus:     row.PayPalStatus.String,
+		PayPalDetails:    row.PayPalDetails.String,
+		Submitted:        row.Submitted.Bool,
+		ReceiptNumber:    row.ReceiptNumber,
+		Version:          int(row.Version),
 	}
 
-	if err := r.populateFundraiserFromJSON(&sub, submissionDate, paypalOrderCreatedAt, submittedAt,
-		studentsJSON, donationItemsJSON); err != nil {
+	submissionDate := sql.NullString{String: row.SubmissionDate, Valid: true}
+	if err := populateFundraiserFromJSON(&sub, submissionDate, row.PayPalOrderCreatedAt, row.SubmittedAt,
+		row.StudentsJSON, row.DonationItemsJSON); err != nil {
 		return nil, fmt.Errorf("failed to populate fundraiser from JSON: %w", err)
 	}
 
 	return &sub, nil
 }
-func (r *FundraiserRepository) populateFundraiserFromJSON(sub *FundraiserSubmission,
+
+func populateFundraiserFromJSON(sub *FundraiserSubmission,
 	submissionDate, paypalOrderCreatedAt, submittedAt sql.NullString,
 	studentsJSON, donationItemsJSON sql.NullString) error {
 
@@ -200,9 +199,10 @@ func (r *FundraiserRepository) populateFundraiserFromJSON(sub *FundraiserSubmiss
 // PayPal updates
 
 func (r *FundraiserRepository) UpdatePayPalOrder(formID, orderID string, createdAt *time.Time) error {
-	const stmt = `UPDATE fundraiser_submissions SET paypal_order_id = ?, paypal_order_created_at = ? WHERE form_id = ?`
-
-	_, err := ExecDB(stmt, orderID, formatNullableTime(createdAt), formID)
+	_, err := audited(r.db, "fundraiser_submissions", formID, AuditPayPalOrder, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateFundraiserPayPalOrder(tx, orderID, nullTime(createdAt), formID)
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal order: %w", err)
 	}
@@ -211,12 +211,12 @@ func (r *FundraiserRepository) UpdatePayPalOrder(formID, orderID string, created
 }
 
 func (r *FundraiserRepository) UpdatePayPalCapture(formID, paypalDetails, status string, submittedAt *time.Time) error {
-	const stmt = `
-		UPDATE fundraiser_submissions
-		SET paypal_details = ?, paypal_status = ?, submitted = 1, submitted_at = ?
-		WHERE form_id = ?`
-
-	_, err := ExecDB(stmt, paypalDetails, status, formatNullableTime(submittedAt), formID)
+	_, err := audited(r.db, "fundraiser_submissions", formID, AuditCapture, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateFundraiserPayPalCapture(tx, updateFundraiserPayPalCaptureParams{
+				PayPalDetails: paypalDetails, PayPalStatus: status, SubmittedAt: nullTime(submittedAt), FormID: formID,
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal capture: %w", err)
 	}
@@ -232,39 +232,32 @@ func (r *FundraiserRepository) UpdatePayment(sub FundraiserSubmission) error {
 		return fmt.Errorf("failed to marshal donation items: %w", err)
 	}
 
-	const stmt = `
-		UPDATE fundraiser_submissions 
-		SET donation_items_json = ?, total_amount = ?, cover_fees = ?, calculated_amount = ?, 
-			submitted = ?, submitted_at = ? 
-		WHERE form_id = ?`
-
-	_, err = ExecDB(stmt,
-		donationItemsJSON, sub.TotalAmount, sub.CoverFees, sub.CalculatedAmount,
-		sub.Submitted, formatNullableTime(sub.SubmittedAt), sub.FormID,
-	)
-
+	result, err := audited(r.db, "fundraiser_submissions", sub.FormID, AuditPaymentUpdate, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateFundraiserPayment(tx, updateFundraiserPaymentParams{
+				DonationItemsJSON: sealPII(donationItemsJSON), TotalAmount: sub.TotalAmount, CoverFees: sub.CoverFees,
+				CalculatedAmount: sub.CalculatedAmount, Submitted: sub.Submitted, SubmittedAt: nullTime(sub.SubmittedAt),
+				FormID: sub.FormID, Version: int64(sub.Version),
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update fundraiser payment: %w", err)
 	}
 
-	return nil
+	return versionConflict(result, sub.Version)
 }
 
 // Email updates
 
 func (r *FundraiserRepository) UpdateEmailStatus(formID string, confirmationSent, adminNotificationSent bool) error {
-	now := time.Now()
-	const stmt = `
-        UPDATE fundraiser_submissions 
-        SET confirmation_email_sent = ?, confirmation_email_sent_at = ?,
-            admin_notification_sent = ?, admin_notification_sent_at = ?
-        WHERE form_id = ?`
-
-	_, err := ExecDB(stmt,
-		confirmationSent, formatNullableTime(&now),
-		adminNotificationSent, formatNullableTime(&now),
-		formID)
-
+	now := formatTime(time.Now())
+	_, err := audited(r.db, "fundraiser_submissions", formID, AuditEmailStatus, ActorSystem,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateFundraiserEmailStatus(tx, updateFundraiserEmailStatusParams{
+				ConfirmationEmailSent: confirmationSent, SentAt: now,
+				AdminNotificationSent: adminNotificationSent, FormID: formID,
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update email status: %w", err)
 	}
@@ -291,6 +284,12 @@ func GetFundraisersByYear(year int) ([]FundraiserSubmission, error) {
 	return repo.GetByYear(year)
 }
 
+// ListFundraisers returns the fundraisers matching filter, oldest first
+func ListFundraisers(filter SubmissionFilter) ([]FundraiserSubmission, error) {
+	repo := NewFundraiserRepository()
+	return repo.List(filter)
+}
+
 func UpdateFundraiserPayPalOrder(formID, orderID string, createdAt *time.Time) error {
 	repo := NewFundraiserRepository()
 	return repo.UpdatePayPalOrder(formID, orderID, createdAt)
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// SUBMISSION HISTORY
+// =============================================================================
+
+// Payment states of a submission in a family's history, from its paypal_status, its
+// refunds and its installments
+const (
+	PaymentStatePaid           = "paid"
+	PaymentStatePartlyRefunded = "partly_refunded"
+	PaymentStateRefunded       = "refunded"
+	PaymentStateReversed       = "reversed"
+	PaymentStateDisputed       = "disputed"
+	PaymentStateInstallments   = "installments" // paying in installments, some still due
+	PaymentStatePending        = "pending"      // waiting on a bank transfer
+	PaymentStateUnpaid         = "unpaid"
+)
+
+// HistoryEntry is one submission in a family's history, with what was paid for it
+type HistoryEntry struct {
+	SubmissionSummary
+	PaymentState string
+	Paid         float64 // what the family paid, less refunds
+	Refunded     float64
+	Due          float64 // installments still due, or a bank transfer still expected
+}
+
+// SubmissionHistory is every membership, event registration and fundraiser donation
+// made with an email address, past school years' included, oldest first
+type SubmissionHistory struct {
+	Email       string
+	Submissions []HistoryEntry
+	Paid        float64
+	Refunded    float64
+	Due         float64
+}
+
+// GetSubmissionsByEmail returns the history of an email address, whatever its case:
+// each submission with its payment state, and the totals paid, refunded and due
+func GetSubmissionsByEmail(emailAddress string) (*SubmissionHistory, error) {
+	history := &SubmissionHistory{Email: NormalizeContact(emailAddress), Submissions: []HistoryEntry{}}
+	if history.Email == "" {
+		return history, nil
+	}
+
+	archived, err := ListArchivedSubmissionsByEmail(history.Email)
+	if err != nil {
+		return nil, err
+	}
+	live, err := ListSubmissionsByEmail(history.Email)
+	if err != nil {
+		return nil, err
+	}
+	summaries := append(archived, live...)
+	if len(summaries) == 0 {
+		return history, nil
+	}
+
+	formIDs := make([]interface{}, len(summaries))
+	for i, summary := range summaries {
+		formIDs[i] = summary.FormID
+	}
+	refunded, err := sumByFormID(`SELECT form_id, SUM(amount), 0 FROM refunds WHERE form_id IN (%s) GROUP BY form_id`, formIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total refunds: %w", err)
+	}
+	installments, err := sumByFormID(`
+		SELECT form_id, SUM(CASE WHEN status = '`+InstallmentPaid+`' THEN amount ELSE 0 END),
+			SUM(CASE WHEN status = '`+InstallmentDue+`' THEN amount ELSE 0 END)
+		FROM installments WHERE form_id IN (%s) GROUP BY form_id`, formIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total installments: %w", err)
+	}
+
+	for _, summary := range summaries {
+		entry := historyEntry(summary, refunded[summary.FormID][0], installments[summary.FormID])
+		history.Submissions = append(history.Submissions, entry)
+		history.Paid += entry.Paid
+		history.Refunded += entry.Refunded
+		history.Due += entry.Due
+	}
+	return history, nil
+}
+
+// historyEntry works out a submission's payment state from its status, the refunds
+// made against it and its installments paid and due
+func historyEntry(summary SubmissionSummary, refunded float64, installments [2]float64) HistoryEntry {
+	entry := HistoryEntry{SubmissionSummary: summary, Refunded: refunded}
+	switch summary.PayPalStatus {
+	case "COMPLETED":
+		entry.PaymentState, entry.Paid = PaymentStatePaid, summary.CalculatedAmount-refunded
+		if refunded > 0 {
+			entry.PaymentState = PaymentStatePartlyRefunded
+		}
+	case "REFUNDED":
+		entry.PaymentState, entry.Paid = PaymentStateRefunded, max(summary.CalculatedAmount-refunded, 0)
+	case "REVERSED":
+		// The bank took the payment back; nothing was refunded
+		entry.PaymentState, entry.Refunded = PaymentStateReversed, 0
+	case DisputedStatus:
+		entry.PaymentState, entry.Paid = PaymentStateDisputed, summary.CalculatedAmount-refunded
+	case InstallmentsStatus:
+		entry.PaymentState, entry.Paid, entry.Due = PaymentStateInstallments, installments[0]-refunded, installments[1]
+	case PaymentPendingStatus:
+		entry.PaymentState, entry.Due = PaymentStatePending, summary.CalculatedAmount
+	default:
+		entry.PaymentState = PaymentStateUnpaid
+	}
+	return entry
+}
+
+// sumByFormID runs a query selecting a form ID and two sums for each of formIDs,
+// which fill its IN (%s), and returns the sums by form ID
+func sumByFormID(query string, formIDs []interface{}) (map[string][2]float64, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(formIDs)), ", ")
+	rows, err := QueryDB(fmt.Sprintf(query, placeholders), formIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := make(map[string][2]float64)
+	for rows.Next() {
+		var formID string
+		var first, second float64
+		if err := rows.Scan(&formID, &first, &second); err != nil {
+			return nil, err
+		}
+		sums[formID] = [2]float64{first, second}
+	}
+	return sums, rows.Err()
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/logger"
+)
+
+// Household links a family's submissions of every form type across years by the email
+// address they were made with. It isn't an account: a family proves the address is
+// theirs by opening a link sent to it, which verifies the household.
+type Household struct {
+	ID            int64
+	Email         string // lower-cased, as preferences are kept
+	CreatedAt     time.Time
+	VerifiedAt    *time.Time
+	LinkSentAt    *time.Time
+	LinkExpiresAt *time.Time
+}
+
+// HouseholdOrder is one of a household's submissions with what its links need
+type HouseholdOrder struct {
+	SubmissionSummary
+	OrderPageURL string // events' food order page, relative to the site
+	Archived     bool   // from a past school year, read from the archive
+}
+
+// HouseholdPayment is one paid submission, for household reports
+type HouseholdPayment struct {
+	HouseholdID int64
+	FormType    string
+	FormID      string
+	PaidAt      time.Time // when the payment completed, or the submission date for older rows
+	Amount      float64
+}
+
+// orderPageColumns names the column holding each form type's order page
+var orderPageColumns = map[string]string{
+	"membership": "''",
+	"event":      "order_page_url",
+	"fundraiser": "''",
+}
+
+const householdColumns = `id, email, created_at, verified_at, link_sent_at, link_expires_at`
+
+// =============================================================================
+// HOUSEHOLD QUERIES
+// =============================================================================
+
+// GetHouseholdByEmail returns the household of an email address, or nil
+func GetHouseholdByEmail(emailAddress string) (*Household, error) {
+	return queryHousehold(`email_index = ?`, contactIndex(emailAddress))
+}
+
+// GetHouseholdByLinkToken returns the household a sign-in link was sent to, or nil
+func GetHouseholdByLinkToken(token string) (*Household, error) {
+	if token == "" {
+		return nil, nil
+	}
+	return queryHousehold(`link_token = ?`, token)
+}
+
+func queryHousehold(where string, arg interface{}) (*Household, error) {
+	var household Household
+	var createdAt string
+	var verifiedAt, linkSentAt, linkExpiresAt sql.NullString
+
+	err := QueryRowDB(fmt.Sprintf(`SELECT %s FROM households WHERE %s`, householdColumns, where), arg).
+		Scan(&household.ID, &household.Email, &createdAt, &verifiedAt, &linkSentAt, &linkExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load household: %w", err)
+	}
+	if err := openPIIFields(&household.Email); err != nil {
+		return nil, fmt.Errorf("failed to read household %d: %w", household.ID, err)
+	}
+
+	if household.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse household %d creation time: %w", household.ID, err)
+	}
+	if household.VerifiedAt, err = parseNullableTime(verifiedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse household %d verification time: %w", household.ID, err)
+	}
+	if household.LinkSentAt, err = parseNullableTime(linkSentAt); err != nil {
+		return nil, fmt.Errorf("failed to parse household %d link time: %w", household.ID, err)
+	}
+	if household.LinkExpiresAt, err = parseNullableTime(linkExpiresAt); err != nil {
+		return nil, fmt.Errorf("failed to parse household %d link expiry: %w", household.ID, err)
+	}
+	return &household, nil
+}
+
+// ListHouseholdOrders returns a household's submissions, archived ones included,
+// oldest first
+func ListHouseholdOrders(householdID int64) ([]HouseholdOrder, error) {
+	var orders []HouseholdOrder
+	for _, view := range []string{archivedSubmissionsView, "submissions"} {
+		rows, err := QueryDB(`SELECT form_id, COALESCE(order_page_url, '') FROM `+view+` WHERE household_id = ?`, householdID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query household orders: %w", err)
+		}
+		pages := make(map[string]string)
+		for rows.Next() {
+			var formID, orderPageURL string
+			if err := rows.Scan(&formID, &orderPageURL); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan household order: %w", err)
+			}
+			pages[formID] = orderPageURL
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read household orders: %w", err)
+		}
+
+		summaries, err := querySummaries(view, "household_id = ?", []interface{}{householdID}, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range summaries {
+			orders = append(orders, HouseholdOrder{
+				SubmissionSummary: summary,
+				OrderPageURL:      pages[summary.FormID],
+				Archived:          view == archivedSubmissionsView,
+			})
+		}
+	}
+	return orders, nil
+}
+
+// ListHouseholdPayments returns every completed payment linked to a household,
+// archived ones included, so reports compare this year with past ones
+func ListHouseholdPayments() ([]HouseholdPayment, error) {
+	rows, err := QueryDB(`
+		SELECT form_type, household_id, form_id, COALESCE(submitted_at, submission_date), calculated_amount
+		FROM submissions WHERE household_id IS NOT NULL AND paypal_status = 'COMPLETED'
+		UNION ALL
+		SELECT form_type, household_id, form_id, COALESCE(submitted_at, submission_date), calculated_amount
+		FROM ` + archivedSubmissionsView + ` WHERE household_id IS NOT NULL AND paypal_status = 'COMPLETED'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query household payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []HouseholdPayment
+	for rows.Next() {
+		var payment HouseholdPayment
+		var paidAt string
+		if err := rows.Scan(&payment.FormType, &payment.HouseholdID, &payment.FormID, &paidAt, &payment.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan household payment: %w", err)
+		}
+		if payment.PaidAt, err = parseTime(paidAt); err != nil {
+			return nil, fmt.Errorf("failed to parse payment time of %s: %w", payment.FormID, err)
+		}
+		payments = append(payments, payment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read household payments: %w", err)
+	}
+	return payments, nil
+}
+
+// =============================================================================
+// HOUSEHOLD UPDATES
+// =============================================================================
+
+// SetHouseholdLink records the sign-in link last sent to a household, replacing any
+// earlier one
+func SetHouseholdLink(id int64, token string, sentAt, expiresAt time.Time) error {
+	_, err := ExecDB(`UPDATE households SET link_token = ?, link_sent_at = ?, link_expires_at = ? WHERE id = ?`,
+		token, formatTime(sentAt), formatTime(expiresAt), id)
+	if err != nil {
+		return fmt.Errorf("failed to save household link: %w", err)
+	}
+	return nil
+}
+
+// MarkHouseholdVerified records the first time a household opened a link sent to it
+func MarkHouseholdVerified(id int64, verifiedAt time.Time) error {
+	_, err := ExecDB(`UPDATE households SET verified_at = ? WHERE id = ? AND verified_at IS NULL`,
+		formatTime(verifiedAt), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark household verified: %w", err)
+	}
+	return nil
+}
+
+// DeleteHousehold removes an email address's household once no submission links to it
+func DeleteHousehold(emailAddress string) error {
+	var linked []string
+	for formType, table := range checkoutTables {
+		for _, from := range []string{table, archiveTables[formType]} {
+			linked = append(linked, fmt.Sprintf(`SELECT household_id FROM %s WHERE household_id IS NOT NULL`, from))
+		}
+	}
+	_, err := ExecDB(fmt.Sprintf(`DELETE FROM households WHERE email_index = ? AND id NOT IN (%s)`,
+		strings.Join(linked, " UNION ")), contactIndex(emailAddress))
+	if err != nil {
+		return fmt.Errorf("failed to delete household: %w", err)
+	}
+	return nil
+}
+
+// linkHousehold links a new submission to the household of its email address,
+// creating the household for a family's first submission. A submission that can't be
+// linked now is linked by the next migration.
+func linkHousehold(conn dbtx, formType, formID, emailAddress string, submittedAt time.Time) {
+	if err := linkToHousehold(conn, checkoutTables[formType], formID, emailAddress, formatTime(submittedAt)); err != nil {
+		logger.LogWarn("Failed to link %s to its household: %v", formID, err)
+	}
+}
+
+// linkToHousehold sets the household of one submission in table, creating it when
+// the address has none yet
+func linkToHousehold(conn dbtx, table, formID, emailAddress, submittedAt string) error {
+	index := contactIndex(emailAddress)
+	if index == "" {
+		return nil
+	}
+	if _, err := execOn(conn, `
+		INSERT INTO households (email, email_index, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (email_index) DO NOTHING`,
+		sealContact(emailAddress), index, submittedAt); err != nil {
+		return fmt.Errorf("failed to create household: %w", err)
+	}
+	if _, err := execOn(conn, fmt.Sprintf(`
+		UPDATE %s SET household_id = (SELECT id FROM households WHERE email_index = ?) WHERE form_id = ?`,
+		table), index, formID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// linkUnlinkedSubmissions gives submissions made before households existed, or that
+// failed to link, the household of their email address. Each family's household is
+// created by its first submission.
+func linkUnlinkedSubmissions(conn *sql.DB, logf func(string, ...interface{})) error {
+	type unlinked struct{ formID, email, submittedAt string }
+	linked := 0
+	for _, table := range checkoutTables {
+		rows, err := conn.Query(fmt.Sprintf(`
+			SELECT form_id, email, submission_date FROM %s
+			WHERE household_id IS NULL AND TRIM(email) != ''
+			ORDER BY submission_date`, table))
+		if err != nil {
+			return fmt.Errorf("failed to find %s without households: %w", table, err)
+		}
+		var submissions []unlinked
+		for rows.Next() {
+			var sub unlinked
+			if err := rows.Scan(&sub.formID, &sub.email, &sub.submittedAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s without household: %w", table, err)
+			}
+			if err := openPIIFields(&sub.email); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read %s: %w", sub.formID, err)
+			}
+			submissions = append(submissions, sub)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read %s without households: %w", table, err)
+		}
+
+		for _, sub := range submissions {
+			if err := linkToHousehold(conn, table, sub.formID, sub.email, sub.submittedAt); err != nil {
+				return fmt.Errorf("failed to link %s to its household: %w", sub.formID, err)
+			}
+			linked++
+		}
+	}
+	if linked > 0 {
+		logf("Linked %d submissions to their households", linked)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IdempotentRequest is a request made under a client's Idempotency-Key and, once it has
+// been answered, the response to replay for retries of it
+type IdempotentRequest struct {
+	Endpoint    string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Response    []byte
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+// Completed reports whether the request has a stored response to replay
+func (r *IdempotentRequest) Completed() bool {
+	return r.CompletedAt != nil
+}
+
+// ClaimIdempotencyKey claims key on endpoint for a request with requestHash, reporting
+// whether this request now owns it. When it doesn't, the request already made under the
+// key is returned. A claim made before staleBefore that never completed is taken over,
+// so a crash mid-request doesn't lock the key until it is purged.
+func ClaimIdempotencyKey(endpoint, key, requestHash string, now, staleBefore time.Time) (bool, *IdempotentRequest, error) {
+	result, err := ExecDB(`
+		INSERT INTO idempotency_keys (endpoint, key, request_hash, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (endpoint, key) DO UPDATE SET
+			request_hash = excluded.request_hash, created_at = excluded.created_at
+		WHERE idempotency_keys.completed_at IS NULL AND idempotency_keys.created_at < ?`,
+		endpoint, key, requestHash, formatTime(now.UTC()), formatTime(staleBefore.UTC()))
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if rows > 0 {
+		return true, nil, nil
+	}
+
+	existing, err := GetIdempotentRequest(endpoint, key)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, existing, nil
+}
+
+// GetIdempotentRequest loads the request made under key on endpoint
+func GetIdempotentRequest(endpoint, key string) (*IdempotentRequest, error) {
+	request := IdempotentRequest{Endpoint: endpoint, Key: key}
+	var createdAt string
+	var completedAt sql.NullString
+	err := QueryRowDB(`
+		SELECT request_hash, status_code, content_type, response, created_at, completed_at
+		FROM idempotency_keys WHERE endpoint = ? AND key = ?`, endpoint, key).Scan(
+		&request.RequestHash, &request.StatusCode, &request.ContentType, &request.Response,
+		&createdAt, &completedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	if request.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse idempotency key time: %w", err)
+	}
+	if request.CompletedAt, err = parseNullableTime(completedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse idempotency key time: %w", err)
+	}
+	return &request, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request that claimed key
+func CompleteIdempotencyKey(endpoint, key string, statusCode int, contentType string, response []byte, completedAt time.Time) error {
+	_, err := ExecDB(`
+		UPDATE idempotency_keys SET status_code = ?, content_type = ?, response = ?, completed_at = ?
+		WHERE endpoint = ? AND key = ?`,
+		statusCode, contentType, response, formatTime(completedAt.UTC()), endpoint, key)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey gives up an unfinished claim so the request can be retried
+// under the same key, as after a server error
+func ReleaseIdempotencyKey(endpoint, key string) error {
+	_, err := ExecDB(`DELETE FROM idempotency_keys WHERE endpoint = ? AND key = ? AND completed_at IS NULL`,
+		endpoint, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys removes keys claimed before cutoff, returning how many went
+func PurgeIdempotencyKeys(cutoff time.Time) (int, error) {
+	result, err := ExecDB(`DELETE FROM idempotency_keys WHERE created_at < ?`, formatTime(cutoff.UTC()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return int(rows), nil
+}
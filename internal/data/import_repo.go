@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// BATCH INSERTS
+// =============================================================================
+
+// batchTimeout bounds one batch insert; imports of a few thousand rows take seconds
+const batchTimeout = 5 * time.Minute
+
+// BatchError is the submission a batch insert stopped at. The batch is inserted in one
+// transaction, so none of it was saved.
+type BatchError struct {
+	Index  int // of the submission in the batch, from 0
+	FormID string
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("submission %d (%s): %v", e.Index+1, e.FormID, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// InsertMembershipsBatch inserts every membership of subs or, if one fails, none of
+// them, linking each to its family's household as InsertMembership does
+func InsertMembershipsBatch(subs []MembershipSubmission) error {
+	return insertBatch(len(subs), func(tx *sql.Tx, i int) (string, error) {
+		return subs[i].FormID, insertMembership(tx, subs[i])
+	})
+}
+
+// InsertEventsBatch inserts every event registration of subs or none of them
+func InsertEventsBatch(subs []EventSubmission) error {
+	return insertBatch(len(subs), func(tx *sql.Tx, i int) (string, error) {
+		return subs[i].FormID, insertEvent(tx, subs[i])
+	})
+}
+
+// InsertFundraisersBatch inserts every fundraiser donation of subs or none of them
+func InsertFundraisersBatch(subs []FundraiserSubmission) error {
+	return insertBatch(len(subs), func(tx *sql.Tx, i int) (string, error) {
+		return subs[i].FormID, insertFundraiser(tx, subs[i])
+	})
+}
+
+// insertBatch runs insert for each of n submissions in one transaction, committing
+// only if every one succeeds
+func insertBatch(n int, insert func(tx *sql.Tx, i int) (string, error)) error {
+	conn := currentDB()
+	if conn == nil {
+		return errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin batch insert: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < n; i++ {
+		if formID, err := insert(tx, i); err != nil {
+			return &BatchError{Index: i, FormID: formID, Err: err}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch insert: %w", err)
+	}
+	return nil
+}
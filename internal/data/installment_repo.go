@@ -0,0 +1,357 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Installment states
+const (
+	InstallmentDue  = "due"
+	InstallmentPaid = "paid"
+)
+
+// InstallmentsStatus is the paypal_status of a registration with some but not all of
+// its installments paid. It only becomes COMPLETED, with its receipt and emails, once
+// the last one is.
+const InstallmentsStatus = "INSTALLMENTS"
+
+// Installment is one scheduled payment of a registration paid in installments
+type Installment struct {
+	FormID         string
+	Number         int // 1-based; the first is paid at checkout
+	Amount         float64
+	DueAt          time.Time
+	Status         string
+	OrderID        string
+	PaidAt         *time.Time
+	ReminderSentAt *time.Time
+}
+
+// InstallmentReminder is a coming installment whose payer hasn't been reminded yet
+type InstallmentReminder struct {
+	Installment
+	FormType  string
+	FirstName string
+	Email     string
+	Count     int // installments in the plan
+}
+
+// InstallmentInvoiceID is the invoice ID an installment's order is created with. PayPal
+// refuses a second payment on one invoice ID, so each installment gets its own; the
+// separator can't appear in a form ID.
+func InstallmentInvoiceID(formID string, number int) string {
+	return fmt.Sprintf("%s/%d", formID, number)
+}
+
+// ParseInstallmentInvoiceID splits an invoice ID into its form ID and installment
+// number, which is 0 for an order paying the whole amount
+func ParseInstallmentInvoiceID(invoiceID string) (string, int) {
+	formID, number, found := strings.Cut(invoiceID, "/")
+	if !found {
+		return invoiceID, 0
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return invoiceID, 0
+	}
+	return formID, n
+}
+
+// splitInstallments divides total into count amounts to the cent, the first taking
+// any odd cents
+func splitInstallments(total float64, count int) []float64 {
+	cents := int64(math.Round(total * 100))
+	each := cents / int64(count)
+	amounts := make([]float64, count)
+	for i := range amounts {
+		amounts[i] = float64(each) / 100
+	}
+	amounts[0] = float64(cents-each*int64(count-1)) / 100
+	return amounts
+}
+
+// CreateInstallmentPlan schedules a registration's total as count installments, the
+// first due at firstDue and each later one interval after the last. A plan with an
+// installment already paid, or the same plan asked for again, is kept as it is; an
+// unpaid plan is otherwise replaced.
+func CreateInstallmentPlan(formID string, total float64, count int, firstDue time.Time, interval time.Duration) ([]Installment, error) {
+	if count < 2 {
+		return nil, fmt.Errorf("an installment plan needs at least 2 installments, not %d", count)
+	}
+
+	conn := currentDB()
+	if conn == nil {
+		return nil, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin installment plan: %w", err)
+	}
+	defer tx.Rollback()
+
+	var paid, scheduled int
+	var scheduledTotal float64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(CASE WHEN status = ? THEN 1 END), COUNT(*), COALESCE(SUM(amount), 0)
+		FROM installments WHERE form_id = ?`, InstallmentPaid, formID).Scan(&paid, &scheduled, &scheduledTotal); err != nil {
+		return nil, fmt.Errorf("failed to check installments of %s: %w", formID, err)
+	}
+	if paid > 0 || (scheduled == count && math.Abs(scheduledTotal-total) < 0.005) {
+		tx.Rollback()
+		return ListInstallments(formID)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM installments WHERE form_id = ?`, formID); err != nil {
+		return nil, fmt.Errorf("failed to replace installments of %s: %w", formID, err)
+	}
+	plan := make([]Installment, count)
+	for i, amount := range splitInstallments(total, count) {
+		plan[i] = Installment{
+			FormID: formID,
+			Number: i + 1,
+			Amount: amount,
+			DueAt:  firstDue.Add(time.Duration(i) * interval),
+			Status: InstallmentDue,
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO installments (form_id, number, amount, due_at, status) VALUES (?, ?, ?, ?, ?)`,
+			formID, plan[i].Number, plan[i].Amount, formatTime(plan[i].DueAt), InstallmentDue); err != nil {
+			return nil, fmt.Errorf("failed to schedule installment %d of %s: %w", plan[i].Number, formID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit installment plan of %s: %w", formID, err)
+	}
+	return plan, nil
+}
+
+// ListInstallments returns a registration's installment plan in order, or nothing
+// when it is paid at once
+func ListInstallments(formID string) ([]Installment, error) {
+	rows, err := QueryDB(`
+		SELECT form_id, number, amount, due_at, status, COALESCE(order_id, ''), paid_at, reminder_sent_at
+		FROM installments WHERE form_id = ? ORDER BY number`, formID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installments of %s: %w", formID, err)
+	}
+	defer rows.Close()
+
+	var plan []Installment
+	for rows.Next() {
+		installment, err := scanInstallment(rows)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, *installment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read installments of %s: %w", formID, err)
+	}
+	return plan, nil
+}
+
+// NextInstallment returns the first unpaid installment of a registration, or nil when
+// it has no plan or the plan is paid
+func NextInstallment(formID string) (*Installment, error) {
+	plan, err := ListInstallments(formID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range plan {
+		if plan[i].Status == InstallmentDue {
+			return &plan[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// SetInstallmentOrder records the order an installment is being paid with
+func SetInstallmentOrder(formID string, number int, orderID string) error {
+	if _, err := ExecDB(`UPDATE installments SET order_id = ? WHERE form_id = ? AND number = ?`,
+		orderID, formID, number); err != nil {
+		return fmt.Errorf("failed to record order of installment %d of %s: %w", number, formID, err)
+	}
+	return nil
+}
+
+// InstallmentForOrder returns the installment an order pays, or nil when the order
+// isn't an installment's
+func InstallmentForOrder(formID, orderID string) (*Installment, error) {
+	if orderID == "" {
+		return nil, nil
+	}
+	row := QueryRowDB(`
+		SELECT form_id, number, amount, due_at, status, COALESCE(order_id, ''), paid_at, reminder_sent_at
+		FROM installments WHERE form_id = ? AND order_id = ?`, formID, orderID)
+	installment, err := scanInstallment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return installment, err
+}
+
+// RecordInstallmentPayment marks an installment paid with the provider's details and
+// returns how many are left. Until the last is paid the registration stands as
+// INSTALLMENTS; the caller records the final one as a normal capture. recorded is false
+// when the installment was already paid.
+func RecordInstallmentPayment(formType, formID string, number int, details string, paidAt time.Time, actor Actor) (remaining int, recorded bool, err error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return 0, false, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	conn := currentDB()
+	if conn == nil {
+		return 0, false, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin installment payment: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE installments SET status = ?, paid_at = ?, details = ?
+		WHERE form_id = ? AND number = ? AND status = ?`,
+		InstallmentPaid, formatTime(paidAt), details, formID, number, InstallmentDue)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to record installment %d of %s: %w", number, formID, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to record installment %d of %s: %w", number, formID, err)
+	}
+	recorded = rows > 0
+
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM installments WHERE form_id = ? AND status = ?`,
+		formID, InstallmentDue).Scan(&remaining); err != nil {
+		return 0, false, fmt.Errorf("failed to count installments of %s: %w", formID, err)
+	}
+	if remaining > 0 {
+		stmt := fmt.Sprintf(`
+			UPDATE %s SET paypal_status = ?, paypal_details = ?, submitted = 1,
+				submitted_at = COALESCE(submitted_at, ?)
+			WHERE form_id = ? AND COALESCE(paypal_status, '') != 'COMPLETED'`, table)
+		if _, err := tx.ExecContext(ctx, stmt, InstallmentsStatus, details, formatTime(paidAt), formID); err != nil {
+			return 0, false, fmt.Errorf("failed to record installments of %s: %w", formID, err)
+		}
+	}
+	if err := recordChanges(ctx, tx, table, formID, AuditInstallment, actor, before); err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit installment payment of %s: %w", formID, err)
+	}
+	return remaining, recorded, nil
+}
+
+// ListInstallmentsNeedingReminder returns unpaid installments after the first that
+// fall due before dueBefore and haven't been reminded, with who to remind
+func ListInstallmentsNeedingReminder(dueBefore time.Time, limit int) ([]InstallmentReminder, error) {
+	var reminders []InstallmentReminder
+	for formType, table := range checkoutTables {
+		rows, err := QueryDB(fmt.Sprintf(`
+			SELECT i.form_id, i.number, i.amount, i.due_at, i.status, COALESCE(i.order_id, ''), i.paid_at, i.reminder_sent_at,
+				COALESCE(s.first_name, ''), s.email,
+				(SELECT COUNT(*) FROM installments c WHERE c.form_id = i.form_id)
+			FROM installments i JOIN %s s ON s.form_id = i.form_id
+			WHERE i.status = ? AND i.number > 1 AND i.reminder_sent_at IS NULL AND i.due_at <= ?
+				AND s.paypal_status = ? AND s.email != '' AND s.anonymized_at IS NULL
+			ORDER BY i.due_at LIMIT ?`, table),
+			InstallmentDue, formatTime(dueBefore), InstallmentsStatus, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find %s installments to remind: %w", formType, err)
+		}
+
+		for rows.Next() {
+			reminder := InstallmentReminder{FormType: formType}
+			var dueAt string
+			var paidAt, remindedAt sql.NullString
+			if err := rows.Scan(&reminder.FormID, &reminder.Number, &reminder.Amount, &dueAt, &reminder.Status,
+				&reminder.OrderID, &paidAt, &remindedAt,
+				&reminder.FirstName, &reminder.Email, &reminder.Count); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan installment reminder: %w", err)
+			}
+			if err := openPIIFields(&reminder.FirstName, &reminder.Email); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read installment reminder of %s: %w", reminder.FormID, err)
+			}
+			if reminder.DueAt, err = parseTime(dueAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to parse due date for %s: %w", reminder.FormID, err)
+			}
+			reminders = append(reminders, reminder)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s installments to remind: %w", formType, err)
+		}
+	}
+	return reminders, nil
+}
+
+// MarkInstallmentReminded records that the payer was reminded of an installment and
+// the resume token the reminder's payment link carries
+func MarkInstallmentReminded(formType, formID string, number int, resumeToken string, sentAt time.Time) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := ExecDB(`UPDATE installments SET reminder_sent_at = ? WHERE form_id = ? AND number = ?`,
+		formatTime(sentAt), formID, number); err != nil {
+		return fmt.Errorf("failed to mark installment %d of %s reminded: %w", number, formID, err)
+	}
+	if _, err := ExecDB(fmt.Sprintf(`UPDATE %s SET resume_token = ? WHERE form_id = ?`, table), resumeToken, formID); err != nil {
+		return fmt.Errorf("failed to record resume token of %s: %w", formID, err)
+	}
+	return nil
+}
+
+func scanInstallment(row interface{ Scan(...interface{}) error }) (*Installment, error) {
+	var installment Installment
+	var dueAt string
+	var paidAt, remindedAt sql.NullString
+	if err := row.Scan(&installment.FormID, &installment.Number, &installment.Amount, &dueAt, &installment.Status,
+		&installment.OrderID, &paidAt, &remindedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan installment: %w", err)
+	}
+
+	var err error
+	if installment.DueAt, err = parseTime(dueAt); err != nil {
+		return nil, fmt.Errorf("failed to parse due date of installment %d of %s: %w", installment.Number, installment.FormID, err)
+	}
+	if installment.PaidAt, err = parseNullableTime(paidAt); err != nil {
+		return nil, fmt.Errorf("failed to parse payment of installment %d of %s: %w", installment.Number, installment.FormID, err)
+	}
+	if installment.ReminderSentAt, err = parseNullableTime(remindedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse reminder of installment %d of %s: %w", installment.Number, installment.FormID, err)
+	}
+	return &installment, nil
+}
@@ -1,8 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -17,7 +20,12 @@ type MembershipRepository struct {
 }
 
 func NewMembershipRepository() *MembershipRepository {
-	return &MembershipRepository{db: db}
+	return &MembershipRepository{db: currentDB()}
+}
+
+// NewMembershipRepositoryWithDB binds the repository to conn instead of the global database
+func NewMembershipRepositoryWithDB(conn *sql.DB) *MembershipRepository {
+	return &MembershipRepository{db: conn}
 }
 
 // =============================================================================
@@ -25,6 +33,15 @@ func NewMembershipRepository() *MembershipRepository {
 // =============================================================================
 
 func (r *MembershipRepository) Insert(sub MembershipSubmission) error {
+	return insertMembership(r.db, sub)
+}
+
+// insertMembership inserts a membership on conn, which may be a transaction
+func insertMembership(conn dbtx, sub MembershipSubmission) error {
+	if sub.FormID == "" {
+		return fmt.Errorf("form ID is required")
+	}
+
 	studentsJSON, err := marshalJSON(sub.Students)
 	if err != nil {
 		return fmt.Errorf("failed to marshal students: %w", err)
@@ -45,75 +62,65 @@ func (r *MembershipRepository) Insert(sub MembershipSubmission) error {
 		return fmt.Errorf("failed to marshal fees: %w", err)
 	}
 
-	const stmt = `
-		INSERT INTO membership_submissions (
-			form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
-			membership, membership_status, describe, student_count, students_json, interests_json, 
-			addons_json, fees_json, donation, calculated_amount, cover_fees, paypal_order_id, 
-			paypal_order_created_at, paypal_status, paypal_details, submitted, submitted_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	_, err = ExecDB(stmt,
-		sub.FormID, sub.AccessToken, formatTime(sub.SubmissionDate),
-		sub.FullName, sub.FirstName, sub.LastName, sub.Email, sub.School,
-		sub.Membership, sub.MembershipStatus, sub.Describe, sub.StudentCount,
-		studentsJSON, interestsJSON, addonsJSON, feesJSON, sub.Donation,
-		sub.CalculatedAmount, sub.CoverFees, sub.PayPalOrderID,
-		formatNullableTime(sub.PayPalOrderCreatedAt),
-		sub.PayPalStatus, sub.PayPalDetails, sub.Submitted,
-		formatNullableTime(sub.SubmittedAt),
-	)
-
+	// Personal details are stored sealed while encryption is on
+	stored := sub
+	sealPIIFields(&stored.FullName, &stored.FirstName, &stored.LastName, &stored.Email, &studentsJSON)
+
+	_, err = insertMembershipSubmission(conn, insertMembershipSubmissionParams{
+		FormID: sub.FormID, AccessToken: sub.AccessToken, SubmissionDate: formatTime(sub.SubmissionDate),
+		FullName: stored.FullName, FirstName: stored.FirstName, LastName: stored.LastName, Email: stored.Email,
+		School: sub.School, Membership: sub.Membership, MembershipStatus: sub.MembershipStatus, Describe: sub.Describe,
+		StudentCount: int64(sub.StudentCount), StudentsJSON: studentsJSON, InterestsJSON: interestsJSON,
+		AddonsJSON: addonsJSON, FeesJSON: feesJSON, Donation: sub.Donation, CalculatedAmount: sub.CalculatedAmount,
+		CoverFees: sub.CoverFees, PayPalOrderID: sub.PayPalOrderID, PayPalOrderCreatedAt: nullTime(sub.PayPalOrderCreatedAt),
+		PayPalStatus: sub.PayPalStatus, PayPalDetails: sub.PayPalDetails, Submitted: sub.Submitted,
+		SubmittedAt: nullTime(sub.SubmittedAt),
+	})
 	if err != nil {
 		return fmt.Errorf("failed to insert membership submission: %w", err)
 	}
 
+	linkHousehold(conn, "membership", sub.FormID, sub.Email, sub.SubmissionDate)
+
 	return nil
 }
 
 func (r *MembershipRepository) GetByID(formID string) (*MembershipSubmission, error) {
-	const stmt = `
-		SELECT form_id, access_token, submission_date, full_name, first_name, last_name, email, school, 
-			membership, membership_status, describe, student_count, students_json, interests_json, 
-			addons_json, fees_json, donation, calculated_amount, cover_fees, paypal_order_id, 
-			paypal_order_created_at, paypal_status, paypal_details, submitted, submitted_at
-		FROM membership_submissions WHERE form_id = ?`
-
-	row := QueryRowDB(stmt, formID)
-	return r.scanMembershipRow(row)
+	row, err := getMembershipSubmission(r.db, formID)
+	if err != nil {
+		return nil, err
+	}
+	return membershipFromRow(row)
 }
+
 func (r *MembershipRepository) GetByYear(year int) ([]MembershipSubmission, error) {
-	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(1, 0, 0)
-
-	const stmt = `
-		SELECT form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
-			membership, membership_status, describe, student_count, students_json, interests_json,
-			addons_json, fees_json, donation, calculated_amount, cover_fees, paypal_order_id, 
-			paypal_order_created_at, paypal_status, paypal_details, submitted, submitted_at
-		FROM membership_submissions
-		WHERE submission_date >= ? AND submission_date < ?
-		ORDER BY submission_date`
-
-	rows, err := QueryDB(stmt, formatTime(start), formatTime(end))
+	return r.List(SubmissionFilter{Year: year})
+}
+
+// List returns the memberships matching filter, oldest first
+func (r *MembershipRepository) List(filter SubmissionFilter) ([]MembershipSubmission, error) {
+	from, to := filter.dateRange()
+	limit, offset := filter.page()
+	rows, err := listMembershipSubmissions(r.db, listMembershipSubmissionsParams{
+		From: formatTime(from), To: formatTime(to), School: filter.School, Status: filter.status(),
+		Search: strings.TrimSpace(filter.Search), Pattern: filter.searchPattern(),
+		FundingSource: strings.ToLower(filter.Funding), Limit: limit, Offset: offset,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query memberships by year: %w", err)
+		return nil, fmt.Errorf("failed to query memberships: %w", err)
 	}
-	defer rows.Close()
 
 	var result []MembershipSubmission
-	for rows.Next() {
-		membership, err := r.scanMembershipRows(rows)
+	for _, row := range rows {
+		membership, err := membershipFromRow(row)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan membership rows: %w", err)
+			return nil, fmt.Errorf("failed to read membership row: %w", err)
 		}
 		result = append(result, *membership)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating membership rows: %w", err)
-	}
-
+	// The page was taken newest first
+	slices.Reverse(result)
 	return result, nil
 }
 
@@ -121,55 +128,46 @@ func (r *MembershipRepository) GetByYear(year int) ([]MembershipSubmission, erro
 // SCANNING AND POPULATION HELPERS
 // =============================================================================
 
-func (r *MembershipRepository) scanMembershipRow(row *sql.Row) (*MembershipSubmission, error) {
-	var sub MembershipSubmission
-	var submissionDate, paypalOrderCreatedAt, submittedAt sql.NullString
-	var studentsJSON, interestsJSON, addonsJSON, feesJSON sql.NullString
-
-	err := row.Scan(
-		&sub.FormID, &sub.AccessToken, &submissionDate, &sub.FullName, &sub.FirstName, &sub.LastName,
-		&sub.Email, &sub.School, &sub.Membership, &sub.MembershipStatus, &sub.Describe, &sub.StudentCount,
-		&studentsJSON, &interestsJSON, &addonsJSON, &feesJSON, &sub.Donation, &sub.CalculatedAmount,
-		&sub.CoverFees, &sub.PayPalOrderID, &paypalOrderCreatedAt, &sub.PayPalStatus, &sub.PayPalDetails,
-		&sub.Submitted, &submittedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan membership: %w", err)
+// membershipFromRow converts a generated membership_submissions row into a MembershipSubmission
+func membershipFromRow(row membershipSubmissionRow) (*MembershipSubmission, error) {
+	if err := openPIIFields(&row.FullName, &row.FirstName.String, &row.LastName.String, &row.Email,
+		&row.StudentsJSON.String); err != nil {
+		return nil, fmt.Errorf("failed to read membership %s: %w", row.FormID, err)
 	}
 
-	if err := r.populateMembershipFromJSON(&sub, submissionDate, paypalOrderCreatedAt, submittedAt,
-		studentsJSON, interestsJSON, addonsJSON, feesJSON); err != nil {
-		return nil, fmt.Errorf("failed to populate membership from JSON: %w", err)
+	sub := MembershipSubmission{
+		FormID:           row.FormID,
+		AccessToken:      row.AccessToken.String,
+		FullName:         row.FullName,
+		FirstName:        row.FirstName.String,
+		LastName:         row.LastName.String,
+		Email:            row.Email,
+		School:           row.School.String,
+		Membership:       row.Membership.String,
+		MembershipStatus: row.MembershipStatus.String,
+		Describe:         row.Describe.String,
+		StudentCount:     int(row.StudentCount.Int64),
+		Donation:         row.Donation.Float64,
+		CalculatedAmount: row.CalculatedAmount.Float64,
+		CoverFees:        row.CoverFees.Bool,
+		PayPalOrderID:    row.PayPalOrderID.String,
+		PayPalStatus:     row.PayPalStatus.String,
+		PayPalDetails:    row.PayPalDetails.String,
+		Submitted:        row.Submitted.Bool,
+		ReceiptNumber:    row.ReceiptNumber,
+		Version:          int(row.Version),
 	}
 
-	return &sub, nil
-}
-
-func (r *MembershipRepository) scanMembershipRows(rows *sql.Rows) (*MembershipSubmission, error) {
-	var sub MembershipSubmission
-	var submissionDate, paypalOrderCreatedAt, submittedAt sql.NullString
-	var studentsJSON, interestsJSON, addonsJSON, feesJSON sql.NullString
-
-	err := rows.Scan(
-		&sub.FormID, &sub.AccessToken, &submissionDate, &sub.FullName, &sub.FirstName, &sub.LastName,
-		&sub.Email, &sub.School, &sub.Membership, &sub.MembershipStatus, &sub.Describe, &sub.StudentCount,
-		&studentsJSON, &interestsJSON, &addonsJSON, &feesJSON, &sub.Donation, &sub.CalculatedAmount,
-		&sub.CoverFees, &sub.PayPalOrderID, &paypalOrderCreatedAt, &sub.PayPalStatus, &sub.PayPalDetails,
-		&sub.Submitted, &submittedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan membership: %w", err)
-	}
-
-	if err := r.populateMembershipFromJSON(&sub, submissionDate, paypalOrderCreatedAt, submittedAt,
-		studentsJSON, interestsJSON, addonsJSON, feesJSON); err != nil {
+	submissionDate := sql.NullString{String: row.SubmissionDate, Valid: true}
+	if err := populateMembershipFromJSON(&sub, submissionDate, row.PayPalOrderCreatedAt, row.SubmittedAt,
+		row.StudentsJSON, row.InterestsJSON, row.AddonsJSON, row.FeesJSON); err != nil {
 		return nil, fmt.Errorf("failed to populate membership from JSON: %w", err)
 	}
 
 	return &sub, nil
 }
 
-func (r *MembershipRepository) populateMembershipFromJSON(sub *MembershipSubmission,
+func populateMembershipFromJSON(sub *MembershipSubmission,
 	submissionDate, paypalOrderCreatedAt, submittedAt sql.NullString,
 	studentsJSON, interestsJSON, addonsJSON, feesJSON sql.NullString) error {
 
@@ -221,9 +219,10 @@ func (r *MembershipRepository) populateMembershipFromJSON(sub *MembershipSubmiss
 // PayPal updates
 
 func (r *MembershipRepository) UpdatePayPalOrder(formID, orderID string, createdAt *time.Time) error {
-	const stmt = `UPDATE membership_submissions SET paypal_order_id = ?, paypal_order_created_at = ? WHERE form_id = ?`
-
-	_, err := ExecDB(stmt, orderID, formatNullableTime(createdAt), formID)
+	_, err := audited(r.db, "membership_submissions", formID, AuditPayPalOrder, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateMembershipPayPalOrder(tx, orderID, nullTime(createdAt), formID)
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal order: %w", err)
 	}
@@ -232,12 +231,12 @@ func (r *MembershipRepository) UpdatePayPalOrder(formID, orderID string, created
 }
 
 func (r *MembershipRepository) UpdatePayPalCapture(formID, paypalDetails, status string, submittedAt *time.Time) error {
-	const stmt = `
-		UPDATE membership_submissions
-		SET paypal_details = ?, paypal_status = ?, submitted = 1, submitted_at = ?
-		WHERE form_id = ?`
-
-	_, err := ExecDB(stmt, paypalDetails, status, formatNullableTime(submittedAt), formID)
+	_, err := audited(r.db, "membership_submissions", formID, AuditCapture, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateMembershipPayPalCapture(tx, updateMembershipPayPalCaptureParams{
+				PayPalDetails: paypalDetails, PayPalStatus: status, SubmittedAt: nullTime(submittedAt), FormID: formID,
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal capture: %w", err)
 	}
@@ -246,9 +245,10 @@ func (r *MembershipRepository) UpdatePayPalCapture(formID, paypalDetails, status
 }
 
 func (r *MembershipRepository) UpdatePayPalDetails(formID, payPalStatus, payPalWebhook string) error {
-	const stmt = `UPDATE membership_submissions SET paypal_status = ?, paypal_webhook = ? WHERE form_id = ?`
-
-	_, err := ExecDB(stmt, payPalStatus, payPalWebhook, formID)
+	_, err := audited(r.db, "membership_submissions", formID, AuditPayPalStatus, ActorSystem,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateMembershipPayPalDetails(tx, payPalStatus, payPalWebhook, formID)
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update PayPal details: %w", err)
 	}
@@ -269,40 +269,32 @@ func (r *MembershipRepository) UpdatePayment(sub MembershipSubmission) error {
 		return fmt.Errorf("failed to marshal fees: %w", err)
 	}
 
-	const stmt = `
-		UPDATE membership_submissions 
-		SET membership = ?, addons_json = ?, fees_json = ?, donation = ?, 
-			cover_fees = ?, calculated_amount = ?, submitted = ?, submitted_at = ? 
-		WHERE form_id = ?`
-
-	_, err = ExecDB(stmt,
-		sub.Membership, addonsJSON, feesJSON, sub.Donation,
-		sub.CoverFees, sub.CalculatedAmount, sub.Submitted,
-		formatNullableTime(sub.SubmittedAt), sub.FormID,
-	)
-
+	result, err := audited(r.db, "membership_submissions", sub.FormID, AuditPaymentUpdate, ActorFamily,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateMembershipPayment(tx, updateMembershipPaymentParams{
+				Membership: sub.Membership, AddonsJSON: addonsJSON, FeesJSON: feesJSON, Donation: sub.Donation,
+				CoverFees: sub.CoverFees, CalculatedAmount: sub.CalculatedAmount, Submitted: sub.Submitted,
+				SubmittedAt: nullTime(sub.SubmittedAt), FormID: sub.FormID, Version: int64(sub.Version),
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update membership payment: %w", err)
 	}
 
-	return nil
+	return versionConflict(result, sub.Version)
 }
 
 // Email updates
 
 func (r *MembershipRepository) UpdateEmailStatus(formID string, confirmationSent, adminNotificationSent bool) error {
-	now := time.Now()
-	const stmt = `
-        UPDATE membership_submissions 
-        SET confirmation_email_sent = ?, confirmation_email_sent_at = ?,
-            admin_notification_sent = ?, admin_notification_sent_at = ?
-        WHERE form_id = ?`
-
-	_, err := ExecDB(stmt,
-		confirmationSent, formatNullableTime(&now),
-		adminNotificationSent, formatNullableTime(&now),
-		formID)
-
+	now := formatTime(time.Now())
+	_, err := audited(r.db, "membership_submissions", formID, AuditEmailStatus, ActorSystem,
+		func(_ context.Context, tx *sql.Tx) (sql.Result, error) {
+			return updateMembershipEmailStatus(tx, updateMembershipEmailStatusParams{
+				ConfirmationEmailSent: confirmationSent, SentAt: now,
+				AdminNotificationSent: adminNotificationSent, FormID: formID,
+			})
+		})
 	if err != nil {
 		return fmt.Errorf("failed to update email status: %w", err)
 	}
@@ -361,3 +353,9 @@ func GetMembershipsByYear(year int) ([]MembershipSubmission, error) {
 	repo := NewMembershipRepository()
 	return repo.GetByYear(year)
 }
+
+// ListMemberships returns the memberships matching filter, oldest first
+func ListMemberships(filter SubmissionFilter) ([]MembershipSubmission, error) {
+	repo := NewMembershipRepository()
+	return repo.List(filter)
+}
@@ -0,0 +1,541 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"sbcbackend/internal/logger"
+)
+
+// =============================================================================
+// SCHEMA MIGRATIONS
+// =============================================================================
+
+// logFunc reports what a migration changed
+type logFunc = func(format string, args ...interface{})
+
+// Migration is one numbered change to the schema. Up applies it and Down undoes it; a
+// migration without a Down can't be rolled back. Migrations don't run in a transaction,
+// so each step skips what is already done and one that fails part way can simply run
+// again. That also lets the migrations from before versioning bring databases migrated
+// the old way up to date.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(conn *sql.DB, logf logFunc) error
+	Down    func(conn *sql.DB, logf logFunc) error
+}
+
+// MigrationState is a migration and when it was applied to a database, nil while pending
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// ErrIrreversibleMigration is returned rolling back past a migration without a Down
+var ErrIrreversibleMigration = errors.New("migration can't be rolled back")
+
+// schemaMigrationsTableSchema records the migrations applied to a database
+const schemaMigrationsTableSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);`
+
+// migrations is the schema's history, oldest first. Add new migrations at the end with
+// the next version; never renumber or change one that has shipped.
+var migrations = []Migration{
+	{1, "initial_schema", migrateInitialSchema, nil},
+	{2, "event_order_changes", createTables(eventOrderChangeTableSchema), dropTables("event_order_changes")},
+	{3, "outbox", createTables(outboxTableSchema), dropTables("outbox_tasks")},
+	{4, "notification_preferences", createTables(notificationPreferencesTableSchema), dropTables("notification_preferences")},
+	{5, "receipt_numbers", migrateReceiptNumbers, rollbackReceiptNumbers},
+	{6, "privacy_requests",
+		steps(createTables(privacyRequestsTableSchema), addCheckoutColumns(column{"anonymized_at", "TEXT"})),
+		steps(dropCheckoutColumns("anonymized_at"), dropTables("privacy_requests"))},
+	{7, "households", migrateHouseholds, rollbackHouseholds},
+	{8, "refunds",
+		steps(createTables(refundsTableSchema), addCheckoutColumns(column{"net_amount", "REAL"})),
+		steps(dropCheckoutColumns("net_amount"), dropTables("refunds"))},
+	{9, "auto_renew", steps(addColumns("membership_submissions", autoRenewColumns...), createTables(renewalsTableSchema)),
+		steps(dropTables("renewals"), dropColumns("membership_submissions", columnNames(autoRenewColumns)...))},
+	{10, "installments", createTables(installmentsTableSchema), dropTables("installments")},
+	{11, "idempotency_keys", createTables(idempotencyKeysTableSchema), dropTables("idempotency_keys")},
+	{12, "unmatched_payments", createTables(unmatchedPaymentsTableSchema), dropTables("unmatched_payments")},
+	{13, "disputes", createTables(disputesTableSchema), dropTables("disputes")},
+	{14, "credits",
+		steps(createTables(creditsTableSchema), addCheckoutColumns(column{"credit_applied", "REAL DEFAULT 0"})),
+		steps(dropCheckoutColumns("credit_applied"), dropTables("credits"))},
+	{15, "donations", createTables(donationsTableSchema), dropTables("donations")},
+	{16, "service_tokens", createTables(serviceTokensTableSchema), dropTables("service_tokens")},
+	// Abandoned-checkout reminders and the links that resume a checkout
+	{17, "abandoned_checkouts",
+		addCheckoutColumns(column{"reminder_sent_at", "TEXT"}, column{"abandoned_at", "TEXT"}, column{"resume_token", "TEXT DEFAULT ''"}),
+		dropCheckoutColumns("reminder_sent_at", "abandoned_at", "resume_token")},
+	// Opt-in text message confirmations
+	{18, "sms_confirmations",
+		addCheckoutColumns(column{"sms_phone", "TEXT DEFAULT ''"}, column{"sms_consent_at", "TEXT"}, column{"sms_confirmation_sent_at", "TEXT"}),
+		dropCheckoutColumns("sms_phone", "sms_consent_at", "sms_confirmation_sent_at")},
+	// paypal, venmo, card... for reconciling payouts by funding source
+	{19, "funding_source",
+		steps(addCheckoutColumns(column{"funding_source", "TEXT DEFAULT ''"}), fillFundingSources),
+		dropCheckoutColumns("funding_source")},
+	// The card that paid, such as "Apple Pay VISA ending 4242", for card and wallet payments
+	{20, "payment_instrument", addCheckoutColumns(column{"payment_instrument", "TEXT DEFAULT ''"}), dropCheckoutColumns("payment_instrument")},
+	// Pending, settled or failed, for payments made by ACH bank transfer
+	{21, "bank_transfers",
+		addCheckoutColumns(column{"bank_transfer_status", "TEXT DEFAULT ''"}, column{"bank_transfer_updated_at", "TEXT"}),
+		dropCheckoutColumns("bank_transfer_status", "bank_transfer_updated_at")},
+	// The "round up for the program" donation, kept apart from the donation the family entered
+	{22, "round_up", addCheckoutColumns(column{"round_up", "REAL DEFAULT 0"}), dropCheckoutColumns("round_up")},
+	// Coupon code entered at checkout and the dollars it took off
+	{23, "coupons",
+		addCheckoutColumns(column{"coupon_code", "TEXT DEFAULT ''"}, column{"coupon_discount", "REAL DEFAULT 0"}),
+		dropCheckoutColumns("coupon_code", "coupon_discount")},
+	// ISO 4217 code the submission is charged in; empty for the deployment's
+	{24, "currency", addCheckoutColumns(column{"currency", "TEXT DEFAULT ''"}), dropCheckoutColumns("currency")},
+	// One view over every form type's submissions, for queries across them
+	{25, "submissions_view", steps(dropViews("submissions"), createViews(submissionsViewSchema())), dropViews("submissions")},
+	{26, "audit_log", createTables(auditLogTableSchema), dropTables("audit_log")},
+	// Full-text index of names, emails, schools and students, for admin search
+	{27, "submission_search", migrateSubmissionSearch, rollbackSubmissionSearch},
+	// Search triggers that open personal details encrypted at rest. Rolling back keeps
+	// them: they index plaintext just as the triggers before them did.
+	{28, "encrypted_search", migrateSearchTriggers, func(*sql.DB, logFunc) error { return nil }},
+	// Tables past school years' submissions are moved to, with a view to read them by
+	{29, "school_year_archive", migrateArchiveTables, rollbackArchiveTables},
+	// Counts changes to each submission's payment state, so no writer overwrites a
+	// change it hasn't seen
+	{30, "submission_versions", addCheckoutColumns(column{"version", "INTEGER NOT NULL DEFAULT 1"}), dropCheckoutColumns("version")},
+	{31, "webhook_events", createTables(webhookEventsTableSchema), dropTables("webhook_events")},
+	{32, "coupon_claims", createTables(couponClaimsTableSchema), dropTables("coupon_claims")},
+	// Blind indexes contact addresses are looked up by, so they can be sealed like the
+	// submissions' details
+	{33, "contact_indexes", migrateContactIndexes, rollbackContactIndexes},
+}
+
+// Auto-renewing memberships pay through a PayPal subscription
+var autoRenewColumns = []column{
+	{"auto_renew", "BOOLEAN DEFAULT 0"},
+	{"paypal_subscription_id", "TEXT DEFAULT ''"},
+	{"subscription_status", "TEXT DEFAULT ''"},
+	{"renewed_through", "TEXT"},
+}
+
+// Migrations returns the schema's migrations, oldest first
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+// LatestSchemaVersion is the version the migrations bring a database up to
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies every pending migration to conn, then repairs rows that missed a
+// backfill. It is idempotent, so tests can run it against their own database.
+func Migrate(conn *sql.DB) error {
+	if err := MigrateTo(conn, LatestSchemaVersion()); err != nil {
+		return err
+	}
+	return repairRows(conn, logger.LogInfo)
+}
+
+// MigrateTo applies the pending migrations on conn up to and including version
+func MigrateTo(conn *sql.DB, version int) error {
+	states, err := MigrationStatus(conn)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if state.AppliedAt != nil || state.Version > version {
+			continue
+		}
+		logger.LogInfo("Applying schema migration %d %s", state.Version, state.Name)
+		if err := state.Up(conn, logger.LogInfo); err != nil {
+			return fmt.Errorf("migration %d %s failed: %w", state.Version, state.Name, err)
+		}
+		if _, err := conn.Exec(`
+			INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)
+			ON CONFLICT (version) DO NOTHING`, state.Version, state.Name, formatTime(time.Now())); err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", state.Version, err)
+		}
+	}
+	return nil
+}
+
+// RollbackTo undoes the migrations applied to conn after version, newest first. It
+// stops at the first migration without a Down, returning ErrIrreversibleMigration.
+func RollbackTo(conn *sql.DB, version int) error {
+	states, err := MigrationStatus(conn)
+	if err != nil {
+		return err
+	}
+	for i := len(states) - 1; i >= 0; i-- {
+		state := states[i]
+		if state.AppliedAt == nil || state.Version <= version {
+			continue
+		}
+		if state.Down == nil {
+			return fmt.Errorf("migration %d %s: %w", state.Version, state.Name, ErrIrreversibleMigration)
+		}
+		logger.LogInfo("Rolling back schema migration %d %s", state.Version, state.Name)
+		if err := state.Down(conn, logger.LogInfo); err != nil {
+			return fmt.Errorf("rolling back migration %d %s failed: %w", state.Version, state.Name, err)
+		}
+		if _, err := conn.Exec(`DELETE FROM schema_migrations WHERE version = ?`, state.Version); err != nil {
+			return fmt.Errorf("failed to unrecord migration %d: %w", state.Version, err)
+		}
+	}
+	return nil
+}
+
+// MigrationStatus lists every migration and when it was applied to conn
+func MigrationStatus(conn *sql.DB) ([]MigrationState, error) {
+	if _, err := conn.Exec(schemaMigrationsTableSchema); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	rows, err := conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		at, _ := parseTime(appliedAt)
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if at, ok := applied[migration.Version]; ok {
+			state.AppliedAt = &at
+			delete(applied, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for version := range applied {
+		logger.LogWarn("Database has schema migration %d, which this build doesn't know; it is newer than the code", version)
+	}
+	return states, nil
+}
+
+// repairRows fills in what a backfill missed on rows written since, such as a receipt
+// number lost to a crash or a household that failed to link
+func repairRows(conn *sql.DB, logf logFunc) error {
+	if err := numberUnreceiptedPayments(conn, logf); err != nil {
+		return err
+	}
+	if err := linkUnlinkedSubmissions(conn, logf); err != nil {
+		return err
+	}
+	return fillFundingSources(conn, logf)
+}
+
+// migrateInitialSchema creates the submission tables, rebuilding an event table from
+// before food choices moved to JSON, and adds the columns they gained before migrations
+// were numbered
+func migrateInitialSchema(conn *sql.DB, logf logFunc) error {
+	if err := createTables(membershipTableSchema, eventTableSchema, fundraiserTableSchema)(conn, logf); err != nil {
+		return err
+	}
+	if err := migrateEventTable(conn); err != nil {
+		return fmt.Errorf("failed to migrate event table: %w", err)
+	}
+	// Read and written by the event repository but missing from the fresh-install schema
+	if err := addColumns("event_submissions",
+		column{"dietary_notes_json", "TEXT DEFAULT '{}'"},
+		column{"has_food_orders", "BOOLEAN DEFAULT 0"},
+		column{"paypal_order_created_at", "TEXT"},
+		column{"paypal_details", "TEXT"},
+		// Lets the outbox worker and the success page agree on who sends the event confirmation
+		column{"confirmation_email_sent", "BOOLEAN DEFAULT 0"},
+	)(conn, logf); err != nil {
+		return err
+	}
+	// Last verified webhook resource; membership_submissions has always had it
+	return addCheckoutColumns(column{"paypal_webhook", "TEXT"})(conn, logf)
+}
+
+// migrateReceiptNumbers adds sequential receipt numbers for phone support and
+// bookkeeping, numbering the payments already taken
+func migrateReceiptNumbers(conn *sql.DB, logf logFunc) error {
+	if err := createTables(receiptSequencesTableSchema)(conn, logf); err != nil {
+		return err
+	}
+	if err := addCheckoutColumns(column{"receipt_number", "TEXT"})(conn, logf); err != nil {
+		return err
+	}
+	for _, table := range checkoutTables {
+		if _, err := conn.Exec(fmt.Sprintf(
+			"CREATE UNIQUE INDEX IF NOT EXISTS idx_%s_receipt_number ON %s(receipt_number)", table, table)); err != nil {
+			return fmt.Errorf("failed to index %s receipt numbers: %w", table, err)
+		}
+	}
+	return numberUnreceiptedPayments(conn, logf)
+}
+
+func rollbackReceiptNumbers(conn *sql.DB, logf logFunc) error {
+	for _, table := range checkoutTables {
+		if _, err := conn.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_%s_receipt_number", table)); err != nil {
+			return fmt.Errorf("failed to drop %s receipt number index: %w", table, err)
+		}
+	}
+	return steps(dropCheckoutColumns("receipt_number"), dropTables("receipt_sequences"))(conn, logf)
+}
+
+// migrateHouseholds links a family's submissions across form types and years
+func migrateHouseholds(conn *sql.DB, logf logFunc) error {
+	if err := createTables(householdsTableSchema)(conn, logf); err != nil {
+		return err
+	}
+	if err := addCheckoutColumns(column{"household_id", "INTEGER"})(conn, logf); err != nil {
+		return err
+	}
+	for _, table := range checkoutTables {
+		if _, err := conn.Exec(fmt.Sprintf(
+			"CREATE INDEX IF NOT EXISTS idx_%s_household ON %s(household_id)", table, table)); err != nil {
+			return fmt.Errorf("failed to index %s households: %w", table, err)
+		}
+	}
+	// Linked by address as stored then; the contact_indexes migration indexes them
+	for _, table := range checkoutTables {
+		if _, err := conn.Exec(fmt.Sprintf(`
+			INSERT INTO households (email, created_at)
+			SELECT LOWER(TRIM(pii_open(email))), MIN(submission_date) FROM %s
+			WHERE household_id IS NULL AND TRIM(email) != ''
+			GROUP BY LOWER(TRIM(pii_open(email)))
+			ON CONFLICT (email) DO NOTHING`, table)); err != nil {
+			return fmt.Errorf("failed to create households for %s: %w", table, err)
+		}
+		if _, err := conn.Exec(fmt.Sprintf(`
+			UPDATE %s SET household_id = (SELECT id FROM households WHERE email = LOWER(TRIM(pii_open(%s.email))))
+			WHERE household_id IS NULL AND TRIM(email) != ''`, table, table)); err != nil {
+			return fmt.Errorf("failed to link %s to households: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func rollbackHouseholds(conn *sql.DB, logf logFunc) error {
+	for _, table := range checkoutTables {
+		if _, err := conn.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_%s_household", table)); err != nil {
+			return fmt.Errorf("failed to drop %s household index: %w", table, err)
+		}
+	}
+	return steps(dropCheckoutColumns("household_id"), dropTables("households"))(conn, logf)
+}
+
+// migrateContactIndexes adds the blind index of each table looked up by contact
+// address and fills it in for the rows already there
+func migrateContactIndexes(conn *sql.DB, logf logFunc) error {
+	for _, c := range contactColumns {
+		if c.index == "" {
+			continue
+		}
+		if err := addColumns(c.table, column{c.index, "TEXT"})(conn, logf); err != nil {
+			return err
+		}
+	}
+	if err := indexContacts(conn, logf); err != nil {
+		return err
+	}
+	return createTables(contactIndexesSchema)(conn, logf)
+}
+
+// rollbackContactIndexes opens the sealed contact addresses, which the code before
+// the blind indexes looks up as they're stored, and drops the indexes
+func rollbackContactIndexes(conn *sql.DB, logf logFunc) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin opening contact addresses: %w", err)
+	}
+	defer tx.Rollback()
+	for _, c := range contactColumns {
+		if _, err := rewriteContacts(ctx, tx, c, openPII); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to open contact addresses: %w", err)
+	}
+
+	for _, c := range contactColumns {
+		if c.index == "" {
+			continue
+		}
+		if _, err := conn.Exec(fmt.Sprintf("DROP INDEX IF EXISTS idx_%s_%s", c.table, c.index)); err != nil {
+			return fmt.Errorf("failed to drop %s index: %w", c.table, err)
+		}
+		if err := dropColumns(c.table, c.index)(conn, logf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// MIGRATION STEPS
+// =============================================================================
+
+// column is a column a migration adds and its definition
+type column struct{ name, definition string }
+
+func columnNames(columns []column) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// steps runs migration steps in order, stopping at the first to fail
+func steps(fns ...func(*sql.DB, logFunc) error) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		for _, fn := range fns {
+			if err := fn(conn, logf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// createTables runs CREATE TABLE IF NOT EXISTS schemas and their indexes
+func createTables(schemas ...string) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		for _, schema := range schemas {
+			if _, err := conn.Exec(schema); err != nil {
+				return fmt.Errorf("failed to create table: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// dropTables drops tables, with their indexes, that exist
+func dropTables(names ...string) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		for _, name := range names {
+			if _, err := conn.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", name)); err != nil {
+				return fmt.Errorf("failed to drop %s table: %w", name, err)
+			}
+			logf("Dropped %s table", name)
+		}
+		return nil
+	}
+}
+
+// createViews runs CREATE VIEW schemas; a view being replaced is dropped first
+func createViews(schemas ...string) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		for _, schema := range schemas {
+			if _, err := conn.Exec(schema); err != nil {
+				return fmt.Errorf("failed to create view: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// dropViews drops views that exist
+func dropViews(names ...string) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		for _, name := range names {
+			if _, err := conn.Exec(fmt.Sprintf("DROP VIEW IF EXISTS %s", name)); err != nil {
+				return fmt.Errorf("failed to drop %s view: %w", name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns adds the columns table doesn't have yet
+func addColumns(table string, columns ...column) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		for _, c := range columns {
+			if err := addColumnIfMissing(conn, logf, table, c.name, c.definition); err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", table, err)
+			}
+		}
+		return nil
+	}
+}
+
+// dropColumns drops the columns table has
+func dropColumns(table string, names ...string) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		existing, err := tableColumns(conn, table)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !existing[name] {
+				continue
+			}
+			if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, name)); err != nil {
+				return fmt.Errorf("failed to drop %s.%s: %w", table, name, err)
+			}
+			logf("Dropped %s column from %s table", name, table)
+		}
+		return nil
+	}
+}
+
+// addCheckoutColumns adds columns to every submission table and to the archives of
+// them, once there are archives
+func addCheckoutColumns(columns ...column) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		tables, err := submissionTables(conn)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err := addColumns(table, columns...)(conn, logf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// dropCheckoutColumns drops columns from every submission table and its archive
+func dropCheckoutColumns(names ...string) func(*sql.DB, logFunc) error {
+	return func(conn *sql.DB, logf logFunc) error {
+		tables, err := submissionTables(conn)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if err := dropColumns(table, names...)(conn, logf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// submissionTables lists the submission tables and the archive tables created so far
+func submissionTables(conn *sql.DB) ([]string, error) {
+	archives, err := existingArchiveTables(conn)
+	if err != nil {
+		return nil, err
+	}
+	return append(searchTables(), archives...), nil
+}
@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// Outbox task statuses
+const (
+	OutboxPending    = "pending"
+	OutboxProcessing = "processing"
+	OutboxDone       = "done"
+	OutboxFailed     = "failed"
+)
+
+// =============================================================================
+// OUTBOX REPOSITORY
+// =============================================================================
+
+type OutboxRepository struct {
+	db *sql.DB
+}
+
+func NewOutboxRepository() *OutboxRepository {
+	return &OutboxRepository{db: currentDB()}
+}
+
+// NewOutboxRepositoryWithDB binds the repository to conn instead of the global database
+func NewOutboxRepositoryWithDB(conn *sql.DB) *OutboxRepository {
+	return &OutboxRepository{db: conn}
+}
+
+// RecordPayPalCapture stores a completed capture, its receipt number and its side effects
+// in a single transaction, so a crash can never leave a paid submission without its
+// follow-up work.
+// Tasks are unique per kind and form, so recording the same capture twice queues nothing new.
+// A pending bank transfer it completes is marked settled.
+func (r *OutboxRepository) RecordPayPalCapture(formType, formID, paypalDetails, status string, submittedAt *time.Time, tasks []OutboxTask, actor Actor) error {
+	return r.ApplyCapture(formType, formID, Capture{
+		Details: paypalDetails, Status: status, SubmittedAt: submittedAt, Tasks: tasks,
+	}, actor)
+}
+
+// queueOutboxTasks adds formID's tasks to the outbox inside the caller's transaction,
+// skipping any already queued
+func queueOutboxTasks(ctx context.Context, tx *sql.Tx, formID string, tasks []OutboxTask) error {
+	const insertStmt = `
+		INSERT INTO outbox_tasks (kind, form_id, payload_json, status, attempts, available_at, created_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?)
+		ON CONFLICT DO NOTHING`
+	now := time.Now()
+	for _, task := range tasks {
+		payload := task.PayloadJSON
+		if payload == "" {
+			payload = "{}"
+		}
+		if _, err := tx.ExecContext(ctx, insertStmt, task.Kind, formID, payload, OutboxPending, formatTime(now), formatTime(now)); err != nil {
+			return fmt.Errorf("failed to queue %s task: %w", task.Kind, err)
+		}
+	}
+	return nil
+}
+
+// Queue adds a task for formID outside any capture transaction. A finished task of the
+// same kind is replaced, so a later failure gets a fresh run; one still pending keeps its
+// place but takes the new payload.
+func (r *OutboxRepository) Queue(formID string, task OutboxTask) error {
+	const stmt = `
+		INSERT INTO outbox_tasks (kind, form_id, payload_json, status, attempts, available_at, created_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?)
+		ON CONFLICT(kind, form_id) DO UPDATE SET
+			payload_json = excluded.payload_json,
+			status = CASE WHEN status = ? THEN status ELSE excluded.status END,
+			attempts = CASE WHEN status IN (?, ?) THEN 0 ELSE attempts END,
+			last_error = CASE WHEN status IN (?, ?) THEN '' ELSE last_error END,
+			available_at = CASE WHEN status IN (?, ?) THEN excluded.available_at ELSE available_at END`
+
+	payload := task.PayloadJSON
+	if payload == "" {
+		payload = "{}"
+	}
+	now := formatTime(clock.Now())
+	if _, err := execOn(r.db, stmt, task.Kind, formID, payload, OutboxPending, now, now,
+		OutboxProcessing,
+		OutboxDone, OutboxFailed,
+		OutboxDone, OutboxFailed,
+		OutboxDone, OutboxFailed); err != nil {
+		return fmt.Errorf("failed to queue %s task: %w", task.Kind, err)
+	}
+	return nil
+}
+
+// Claim marks up to limit due tasks as processing and returns them, oldest first
+func (r *OutboxRepository) Claim(now time.Time, limit int) ([]OutboxTask, error) {
+	const stmt = `
+		SELECT id, kind, form_id, payload_json, status, attempts, last_error, available_at, created_at, claimed_at, completed_at
+		FROM outbox_tasks
+		WHERE status = ? AND available_at <= ?
+		ORDER BY id
+		LIMIT ?`
+
+	rows, err := queryOn(r.db, stmt, OutboxPending, formatTime(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox tasks: %w", err)
+	}
+
+	var due []OutboxTask
+	for rows.Next() {
+		task, err := scanOutboxTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox tasks: %w", err)
+	}
+
+	const claimStmt = `
+		UPDATE outbox_tasks SET status = ?, attempts = attempts + 1, claimed_at = ?
+		WHERE id = ? AND status = ?`
+
+	var claimed []OutboxTask
+	for _, task := range due {
+		result, err := execOn(r.db, claimStmt, OutboxProcessing, formatTime(now), task.ID, OutboxPending)
+		if err != nil {
+			return claimed, fmt.Errorf("failed to claim outbox task %d: %w", task.ID, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		task.Status = OutboxProcessing
+		task.Attempts++
+		claimedAt := now
+		task.ClaimedAt = &claimedAt
+		claimed = append(claimed, task)
+	}
+
+	return claimed, nil
+}
+
+// Complete marks a task done
+func (r *OutboxRepository) Complete(id int64, completedAt time.Time) error {
+	const stmt = `UPDATE outbox_tasks SET status = ?, last_error = '', completed_at = ? WHERE id = ?`
+
+	if _, err := execOn(r.db, stmt, OutboxDone, formatTime(completedAt), id); err != nil {
+		return fmt.Errorf("failed to complete outbox task: %w", err)
+	}
+	return nil
+}
+
+// Retry returns a task to the queue, to be picked up again at availableAt
+func (r *OutboxRepository) Retry(id int64, lastError string, availableAt time.Time) error {
+	const stmt = `UPDATE outbox_tasks SET status = ?, last_error = ?, available_at = ? WHERE id = ?`
+
+	if _, err := execOn(r.db, stmt, OutboxPending, lastError, formatTime(availableAt), id); err != nil {
+		return fmt.Errorf("failed to reschedule outbox task: %w", err)
+	}
+	return nil
+}
+
+// Fail gives up on a task; it stays in the table for an admin to inspect
+func (r *OutboxRepository) Fail(id int64, lastError string) error {
+	const stmt = `UPDATE outbox_tasks SET status = ?, last_error = ? WHERE id = ?`
+
+	if _, err := execOn(r.db, stmt, OutboxFailed, lastError, id); err != nil {
+		return fmt.Errorf("failed to mark outbox task failed: %w", err)
+	}
+	return nil
+}
+
+// RequeueStale returns tasks claimed before cutoff to the queue. A task is only left in
+// processing if the server stopped while working on it.
+func (r *OutboxRepository) RequeueStale(cutoff time.Time) (int, error) {
+	const stmt = `UPDATE outbox_tasks SET status = ? WHERE status = ? AND claimed_at < ?`
+
+	result, err := execOn(r.db, stmt, OutboxPending, OutboxProcessing, formatTime(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue stale outbox tasks: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// CountByStatus returns how many tasks are in each status
+func (r *OutboxRepository) CountByStatus() (map[string]int, error) {
+	rows, err := queryOn(r.db, `SELECT status, COUNT(*) FROM outbox_tasks GROUP BY status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count outbox tasks: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox count: %w", err)
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+func scanOutboxTask(rows *sql.Rows) (OutboxTask, error) {
+	var task OutboxTask
+	var availableAt, createdAt string
+	var lastError, claimedAt, completedAt sql.NullString
+
+	err := rows.Scan(&task.ID, &task.Kind, &task.FormID, &task.PayloadJSON, &task.Status, &task.Attempts,
+		&lastError, &availableAt, &createdAt, &claimedAt, &completedAt)
+	if err != nil {
+		return task, fmt.Errorf("failed to scan outbox task: %w", err)
+	}
+
+	if task.AvailableAt, err = parseTime(availableAt); err != nil {
+		return task, fmt.Errorf("failed to parse available at: %w", err)
+	}
+	if task.CreatedAt, err = parseTime(createdAt); err != nil {
+		return task, fmt.Errorf("failed to parse created at: %w", err)
+	}
+	if task.ClaimedAt, err = parseNullableTime(claimedAt); err != nil {
+		return task, fmt.Errorf("failed to parse claimed at: %w", err)
+	}
+	if task.CompletedAt, err = parseNullableTime(completedAt); err != nil {
+		return task, fmt.Errorf("failed to parse completed at: %w", err)
+	}
+	task.LastError = lastError.String
+
+	return task, nil
+}
+
+// =============================================================================
+// LEGACY BACKWARD COMPATIBILITY FUNCTIONS
+// =============================================================================
+
+func RecordPayPalCapture(formType, formID, paypalDetails, status string, submittedAt *time.Time, tasks []OutboxTask, actor Actor) error {
+	repo := NewOutboxRepository()
+	return repo.RecordPayPalCapture(formType, formID, paypalDetails, status, submittedAt, tasks, actor)
+}
+
+func QueueOutboxTask(formID string, task OutboxTask) error {
+	repo := NewOutboxRepository()
+	return repo.Queue(formID, task)
+}
+
+func ClaimOutboxTasks(now time.Time, limit int) ([]OutboxTask, error) {
+	repo := NewOutboxRepository()
+	return repo.Claim(now, limit)
+}
+
+func CompleteOutboxTask(id int64, completedAt time.Time) error {
+	repo := NewOutboxRepository()
+	return repo.Complete(id, completedAt)
+}
+
+func RetryOutboxTask(id int64, lastError string, availableAt time.Time) error {
+	repo := NewOutboxRepository()
+	return repo.Retry(id, lastError, availableAt)
+}
+
+func FailOutboxTask(id int64, lastError string) error {
+	repo := NewOutboxRepository()
+	return repo.Fail(id, lastError)
+}
+
+func RequeueStaleOutboxTasks(cutoff time.Time) (int, error) {
+	repo := NewOutboxRepository()
+	return repo.RequeueStale(cutoff)
+}
+
+func CountOutboxTasksByStatus() (map[string]int, error) {
+	repo := NewOutboxRepository()
+	return repo.CountByStatus()
+}
@@ -0,0 +1,549 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"modernc.org/sqlite"
+)
+
+// =============================================================================
+// PERSONAL DETAILS ENCRYPTION
+// =============================================================================
+
+// A submission's names, email address, phone number, students, dietary notes and
+// donations are sealed with AES-GCM before they are written when an encryption key is
+// set, and opened again as rows are read, so nothing outside this package sees the
+// difference. A sealed value is sealedPrefix followed by the base64 of its nonce and
+// ciphertext; a value without the prefix is plaintext, written before encryption was
+// turned on, and reads as it is.
+//
+// The nonce is derived from the plaintext, so the same details always seal to the same
+// value: rewriting an unchanged field doesn't show up as a change in the audit log.
+//
+// The tables keeping a family's contact address (households, credits, preferences,
+// privacy requests and donations) seal it the same way. Those looked up by address
+// also keep a blind index of it, an HMAC of the normalized address, and are queried by
+// that instead; see contactIndex. EncryptPersonalDetails and DecryptPersonalDetails
+// rewrite both, so the lookups keep finding rows written before the key changed.
+
+// PIIKeySize is the length of the encryption key, in bytes
+const PIIKeySize = 32
+
+// sealedPrefix marks a sealed value and the version of the scheme that sealed it
+const sealedPrefix = "pii1:"
+
+// maxTermPrefix is the longest word prefix blinded for the search index; longer
+// searches match on their first maxTermPrefix characters
+const maxTermPrefix = 24
+
+// ErrPIIKeyMissing is returned reading sealed details without the key that sealed them
+var ErrPIIKeyMissing = errors.New("personal details are encrypted but no encryption key is set")
+
+// piiColumns are the columns of each submission table holding personal details
+var piiColumns = map[string][]string{
+	"membership_submissions": {"full_name", "first_name", "last_name", "email", "students_json", "sms_phone"},
+	"event_submissions":      {"full_name", "first_name", "last_name", "email", "students_json", "sms_phone", "dietary_notes_json"},
+	"fundraiser_submissions": {"full_name", "first_name", "last_name", "email", "students_json", "sms_phone", "donation_items_json"},
+}
+
+// contactColumn is a table's column holding a contact address, and the column the
+// blind index it is looked up by is kept in; index is empty for a table never
+// looked up by address
+type contactColumn struct{ table, address, index string }
+
+// contactColumns are the contact addresses kept outside the submission tables
+var contactColumns = []contactColumn{
+	{"households", "email", "email_index"},
+	{"credits", "email", "email_index"},
+	{"privacy_requests", "email", "email_index"},
+	{"notification_preferences", "contact", "contact_index"},
+	{"donations", "email", ""},
+}
+
+// piiKeys are the keys derived from the encryption key: one seals values, one derives
+// their nonces, one blinds the words in the search index and one the contact addresses
+// looked up by
+type piiKeys struct {
+	aead       cipher.AEAD
+	nonceKey   []byte
+	indexKey   []byte
+	contactKey []byte
+}
+
+var (
+	piiMu  sync.RWMutex
+	piiKey *piiKeys
+)
+
+func init() {
+	// The search index triggers and the email lookups open sealed values inside SQLite
+	if err := sqlite.RegisterDeterministicScalarFunction("pii_open", 1, sqlitePIIOpen); err != nil {
+		panic(err)
+	}
+	if err := sqlite.RegisterDeterministicScalarFunction("pii_terms", 1, sqlitePIITerms); err != nil {
+		panic(err)
+	}
+}
+
+// SetPIIKey sets the key personal details are encrypted with, or with nil stops
+// encrypting new details; sealed ones then can't be read. Set it before opening the
+// database.
+func SetPIIKey(key []byte) error {
+	if key == nil {
+		piiMu.Lock()
+		piiKey = nil
+		piiMu.Unlock()
+		return nil
+	}
+	if len(key) != PIIKeySize {
+		return fmt.Errorf("encryption key must be %d bytes, not %d", PIIKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(deriveKey(key, "seal"))
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	piiMu.Lock()
+	piiKey = &piiKeys{aead: aead, nonceKey: deriveKey(key, "nonce"), indexKey: deriveKey(key, "index"),
+		contactKey: deriveKey(key, "contact")}
+	piiMu.Unlock()
+	return nil
+}
+
+// PIIEncryptionEnabled reports whether personal details are encrypted as they're written
+func PIIEncryptionEnabled() bool {
+	return currentPIIKeys() != nil
+}
+
+func currentPIIKeys() *piiKeys {
+	piiMu.RLock()
+	defer piiMu.RUnlock()
+	return piiKey
+}
+
+// deriveKey derives the key for one purpose from the encryption key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sbcbackend pii " + purpose))
+	return mac.Sum(nil)
+}
+
+// sealPII seals plaintext with the encryption key. Empty and already sealed values are
+// returned as they are, and so is everything when no key is set.
+func sealPII(plaintext string) string {
+	keys := currentPIIKeys()
+	if keys == nil || plaintext == "" || strings.HasPrefix(plaintext, sealedPrefix) {
+		return plaintext
+	}
+
+	mac := hmac.New(sha256.New, keys.nonceKey)
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:keys.aead.NonceSize()]
+	sealed := keys.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// openPII returns the plaintext of a value sealed by sealPII; plaintext is returned as it is
+func openPII(value string) (string, error) {
+	encoded, sealed := strings.CutPrefix(value, sealedPrefix)
+	if !sealed {
+		return value, nil
+	}
+	keys := currentPIIKeys()
+	if keys == nil {
+		return "", ErrPIIKeyMissing
+	}
+
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	nonceSize := keys.aead.NonceSize()
+	if err != nil || len(raw) < nonceSize {
+		return "", fmt.Errorf("failed to decrypt personal details: malformed value")
+	}
+	plaintext, err := keys.aead.Open(nil, raw[:nonceSize], raw[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt personal details: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// sealPIIFields seals each field in place
+func sealPIIFields(fields ...*string) {
+	for _, field := range fields {
+		*field = sealPII(*field)
+	}
+}
+
+// openPIIFields opens each field in place, stopping at the first that can't be opened
+func openPIIFields(fields ...*string) error {
+	for _, field := range fields {
+		plaintext, err := openPII(*field)
+		if err != nil {
+			return err
+		}
+		*field = plaintext
+	}
+	return nil
+}
+
+// searchTerms returns the words of text as the search index holds them. With a key set
+// each prefix of each word is blinded, so the index matches the words searched for,
+// and the starts of them, without holding them; otherwise text is indexed as it is.
+func searchTerms(text string) string {
+	keys := currentPIIKeys()
+	if keys == nil {
+		return text
+	}
+
+	var terms []string
+	for _, word := range searchWords(text) {
+		runes := []rune(word)
+		for n := 1; n <= len(runes) && n <= maxTermPrefix; n++ {
+			terms = append(terms, blindTerm(keys, string(runes[:n])))
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchWords splits text into lower-cased words at anything but a letter or digit, as
+// the search index's tokenizer does
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// blindTerm returns the index term standing for word
+func blindTerm(keys *piiKeys, word string) string {
+	if runes := []rune(word); len(runes) > maxTermPrefix {
+		word = string(runes[:maxTermPrefix])
+	}
+	mac := hmac.New(sha256.New, keys.indexKey)
+	mac.Write([]byte(word))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// contactIndex returns what a contact address is looked up by. With a key set it is a
+// blind index, an HMAC of the normalized address, so the tables match an address
+// without holding it; otherwise it is the normalized address.
+func contactIndex(contact string) string {
+	contact = NormalizeContact(contact)
+	keys := currentPIIKeys()
+	if keys == nil || contact == "" {
+		return contact
+	}
+	mac := hmac.New(sha256.New, keys.contactKey)
+	mac.Write([]byte(contact))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealContact returns the normalized contact address as it is stored
+func sealContact(contact string) string {
+	return sealPII(NormalizeContact(contact))
+}
+
+// sqliteText returns a SQL function argument as text, reporting false for NULL
+func sqliteText(value driver.Value) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// sqlitePIIOpen implements pii_open(value), the plaintext of a sealed value
+func sqlitePIIOpen(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	text, ok := sqliteText(args[0])
+	if !ok {
+		return nil, nil
+	}
+	return openPII(text)
+}
+
+// sqlitePIITerms implements pii_terms(value), the search index terms of a value,
+// sealed or not
+func sqlitePIITerms(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	text, ok := sqliteText(args[0])
+	if !ok {
+		return "", nil
+	}
+	plaintext, err := openPII(text)
+	if err != nil {
+		return nil, err
+	}
+	return searchTerms(plaintext), nil
+}
+
+// =============================================================================
+// ENCRYPTING EXISTING ROWS
+// =============================================================================
+
+// EncryptPersonalDetails seals the personal details of the submissions and the contact
+// addresses written before encryption was turned on, and the audit log's record of
+// them, then rebuilds the search index and the contact addresses' blind index. It returns how many rows it changed; run again, it changes none.
+func EncryptPersonalDetails() (int, error) {
+	if !PIIEncryptionEnabled() {
+		return 0, fmt.Errorf("no encryption key is set")
+	}
+	return rewritePersonalDetails(func(value string) (string, error) {
+		return sealPII(value), nil
+	}, false)
+}
+
+// DecryptPersonalDetails opens every sealed personal detail, then stops encrypting and
+// rebuilds the search index and the contact addresses' index in plaintext. Changing the key is decrypting with the old
+// one and encrypting with the new.
+func DecryptPersonalDetails() (int, error) {
+	if !PIIEncryptionEnabled() {
+		return 0, fmt.Errorf("no encryption key is set")
+	}
+	return rewritePersonalDetails(openPII, true)
+}
+
+// rewritePersonalDetails replaces every personal detail with transform's result in one
+// transaction. It isn't audited: the details are the same, only how they're stored
+// changes. With clearKey the key is dropped before the search index is rebuilt.
+func rewritePersonalDetails(transform func(string) (string, error), clearKey bool) (int, error) {
+	conn, err := GetDB()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		return 0, fmt.Errorf("personal details can only be encrypted in SQLite")
+	}
+
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin rewriting personal details: %w", err)
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for formType, live := range checkoutTables {
+		// Archived submissions hold the same details under the same columns
+		for _, table := range []string{live, archiveTables[formType]} {
+			n, err := rewriteRows(ctx, tx, table, "form_id", piiColumns[live], transform)
+			if err != nil {
+				return 0, err
+			}
+			changed += n
+		}
+	}
+
+	// The audit log holds the values of changed fields as they were stored
+	var fields []string
+	for _, columns := range piiColumns {
+		fields = append(fields, columns...)
+	}
+	slices.Sort(fields)
+	fields = slices.Compact(fields)
+	auditWhere := "field IN ('" + strings.Join(fields, "', '") + "')"
+	n, err := rewriteRows(ctx, tx, "audit_log WHERE "+auditWhere, "id", []string{"old_value", "new_value"}, transform)
+	if err != nil {
+		return 0, err
+	}
+	changed += n
+
+	for _, c := range contactColumns {
+		n, err := rewriteContacts(ctx, tx, c, transform)
+		if err != nil {
+			return 0, err
+		}
+		changed += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit personal details: %w", err)
+	}
+
+	if clearKey {
+		SetPIIKey(nil)
+	}
+	if err := indexContacts(conn, func(string, ...interface{}) {}); err != nil {
+		return changed, err
+	}
+	if err := rebuildSearchIndex(conn, func(string, ...interface{}) {}); err != nil {
+		return changed, err
+	}
+	return changed, nil
+}
+
+// rewriteRows updates the columns of each row of from, a table and optional WHERE
+// clause, whose value transform changes. NULLs are left alone.
+func rewriteRows(ctx context.Context, tx *sql.Tx, from, key string, columns []string,
+	transform func(string) (string, error)) (int, error) {
+	table, _, _ := strings.Cut(from, " ")
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, %s FROM %s", key, strings.Join(columns, ", "), from))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	type update struct {
+		key    interface{}
+		values []sql.NullString
+	}
+	var updates []update
+	for rows.Next() {
+		var rowKey interface{}
+		values := make([]sql.NullString, len(columns))
+		targets := []interface{}{&rowKey}
+		for i := range values {
+			targets = append(targets, &values[i])
+		}
+		if err := rows.Scan(targets...); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read %s: %w", table, err)
+		}
+
+		dirty := false
+		for i, value := range values {
+			if !value.Valid {
+				continue
+			}
+			rewritten, err := transform(value.String)
+			if err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to rewrite %s of %s %v: %w", columns[i], table, rowKey, err)
+			}
+			if rewritten != value.String {
+				values[i].String, dirty = rewritten, true
+			}
+		}
+		if dirty {
+			updates = append(updates, update{rowKey, values})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = column + " = ?"
+	}
+	stmt := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", table, strings.Join(sets, ", "), key)
+	for _, u := range updates {
+		args := make([]interface{}, 0, len(columns)+1)
+		for _, value := range u.values {
+			args = append(args, value)
+		}
+		if _, err := tx.ExecContext(ctx, stmt, append(args, u.key)...); err != nil {
+			return 0, fmt.Errorf("failed to rewrite %s %v: %w", table, u.key, err)
+		}
+	}
+	return len(updates), nil
+}
+
+// rewriteContacts replaces each contact address in one of contactColumns with
+// transform's result. Rows are matched by the address itself, as preferences have no
+// single key; every row of an address holds the same value.
+func rewriteContacts(ctx context.Context, tx *sql.Tx, c contactColumn,
+	transform func(string) (string, error)) (int, error) {
+	addresses, err := distinctContacts(ctx, tx, c)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	stmt := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", c.table, c.address, c.address)
+	for _, address := range addresses {
+		rewritten, err := transform(address)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rewrite %s of %s: %w", c.address, c.table, err)
+		}
+		if rewritten == address {
+			continue
+		}
+		result, err := tx.ExecContext(ctx, stmt, rewritten, address)
+		if err != nil {
+			return 0, fmt.Errorf("failed to rewrite %s of %s: %w", c.address, c.table, err)
+		}
+		rows, _ := result.RowsAffected()
+		changed += int(rows)
+	}
+	return changed, nil
+}
+
+// indexContacts brings the blind index of every contact address up to date with the
+// current key, as after a migration or encrypting with another key
+func indexContacts(conn *sql.DB, logf logFunc) error {
+	ctx := context.Background()
+	for _, c := range contactColumns {
+		if c.index == "" {
+			continue
+		}
+		addresses, err := distinctContacts(ctx, conn, c)
+		if err != nil {
+			return err
+		}
+
+		indexed := int64(0)
+		stmt := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND COALESCE(%s, '') != ?",
+			c.table, c.index, c.address, c.index)
+		for _, address := range addresses {
+			plaintext, err := openPII(address)
+			if err != nil {
+				return fmt.Errorf("failed to index %s of %s: %w", c.address, c.table, err)
+			}
+			index := contactIndex(plaintext)
+			result, err := conn.ExecContext(ctx, stmt, index, address, index)
+			if err != nil {
+				return fmt.Errorf("failed to index %s of %s: %w", c.address, c.table, err)
+			}
+			rows, _ := result.RowsAffected()
+			indexed += rows
+		}
+		if indexed > 0 {
+			logf("Indexed the contact addresses of %d %s rows", indexed, c.table)
+		}
+	}
+	return nil
+}
+
+// distinctContacts returns the contact addresses a table holds, as stored
+func distinctContacts(ctx context.Context, conn dbtx, c contactColumn) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL",
+		c.address, c.table, c.address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.table, err)
+	}
+	defer rows.Close()
+
+	var addresses []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", c.table, err)
+		}
+		addresses = append(addresses, address)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.table, err)
+	}
+	return addresses, nil
+}
@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// POSTGRESQL
+// =============================================================================
+
+// postgresDriverName is the database/sql driver PostgreSQL connections go through. It is
+// registered by github.com/jackc/pgx/v5/stdlib, which only builds with the postgres tag
+// link in (see postgres_pgx.go).
+const postgresDriverName = "pgx"
+
+// openPostgres opens a PostgreSQL database, wrapping its driver so the data layer's
+// SQLite-flavored statements run unchanged
+func openPostgres(dataSourceName string) (*sql.DB, error) {
+	probe, err := sql.Open(postgresDriverName, dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("PostgreSQL support isn't built in (build with -tags postgres): %w", err)
+	}
+	base := probe.Driver()
+	probe.Close()
+
+	return sql.OpenDB(postgresConnector{driver: &postgresDriver{base}, dsn: dataSourceName}), nil
+}
+
+// PostgresQuery rewrites a statement written for SQLite the way it is sent to
+// PostgreSQL: ? placeholders become $1, $2 and so on, and the column types of CREATE
+// TABLE and ALTER TABLE statements become their PostgreSQL equivalents. Booleans are
+// stored as 0 and 1 on both, as the queries compare them.
+func PostgresQuery(query string) string {
+	query = numberPlaceholders(query)
+
+	ddl := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(ddl, "CREATE TABLE") && !strings.HasPrefix(ddl, "ALTER TABLE") {
+		return query
+	}
+	for _, rule := range postgresTypes {
+		query = rule.pattern.ReplaceAllString(query, rule.replacement)
+	}
+	return query
+}
+
+// postgresTypes maps the SQLite column types the schema uses to PostgreSQL's
+var postgresTypes = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\bINTEGER\s+PRIMARY\s+KEY\s+AUTOINCREMENT\b`), "BIGSERIAL PRIMARY KEY"},
+	{regexp.MustCompile(`(?i)\bBOOLEAN\b`), "INTEGER"},
+	{regexp.MustCompile(`(?i)\bREAL\b`), "DOUBLE PRECISION"},
+	{regexp.MustCompile(`(?i)\bDATETIME\b`), "TEXT"},
+	{regexp.MustCompile(`(?i)\bBLOB\b`), "BYTEA"},
+}
+
+// numberPlaceholders replaces each ? outside a quoted string with its position
+func numberPlaceholders(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// postgresArgs sends booleans as the 0 and 1 their INTEGER columns hold
+func postgresArgs(args []driver.NamedValue) []driver.NamedValue {
+	for i, arg := range args {
+		if b, ok := arg.Value.(bool); ok {
+			args[i].Value = int64(0)
+			if b {
+				args[i].Value = int64(1)
+			}
+		}
+	}
+	return args
+}
+
+type postgresConnector struct {
+	driver *postgresDriver
+	dsn    string
+}
+
+func (c postgresConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c postgresConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// postgresDriver wraps the PostgreSQL driver, rewriting each statement with
+// PostgresQuery on its way through
+type postgresDriver struct {
+	driver.Driver
+}
+
+func (d *postgresDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresConn{conn}, nil
+}
+
+type postgresConn struct {
+	driver.Conn
+}
+
+func (c *postgresConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(PostgresQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	return &postgresStmt{stmt}, nil
+}
+
+func (c *postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	preparer, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := preparer.PrepareContext(ctx, PostgresQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	return &postgresStmt{stmt}, nil
+}
+
+func (c *postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return execer.ExecContext(ctx, PostgresQuery(query), postgresArgs(args))
+}
+
+func (c *postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return queryer.QueryContext(ctx, PostgresQuery(query), postgresArgs(args))
+}
+
+func (c *postgresConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *postgresConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *postgresConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *postgresConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *postgresConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+type postgresStmt struct {
+	driver.Stmt
+}
+
+func (s *postgresStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, postgresArgs(args))
+	}
+	return s.Stmt.Exec(namedValues(postgresArgs(args)))
+}
+
+func (s *postgresStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, postgresArgs(args))
+	}
+	return s.Stmt.Query(namedValues(postgresArgs(args)))
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
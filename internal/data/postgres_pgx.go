@@ -0,0 +1,7 @@
+//go:build postgres
+
+package data
+
+// PostgreSQL support adds the pgx driver, so only builds that may use it carry it:
+// go build -tags postgres
+import _ "github.com/jackc/pgx/v5/stdlib"
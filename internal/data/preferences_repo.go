@@ -0,0 +1,122 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NotificationPreference is the channels one contact accepts for one category of
+// message. An empty Channels means the contact wants none.
+type NotificationPreference struct {
+	Contact   string // lower-cased email address
+	Category  string
+	Channels  []string
+	UpdatedAt time.Time
+}
+
+// NormalizeContact is the form an email address is stored and looked up in, so a
+// family's details follow them however they capitalize it
+func NormalizeContact(emailAddress string) string {
+	return strings.ToLower(strings.TrimSpace(emailAddress))
+}
+
+// =============================================================================
+// NOTIFICATION PREFERENCE QUERIES
+// =============================================================================
+
+// ListNotificationPreferences returns the categories a contact has set preferences
+// for, ordered by category. Categories without a row use the senders' defaults.
+func ListNotificationPreferences(contact string) ([]NotificationPreference, error) {
+	rows, err := QueryDB(`
+		SELECT contact, category, channels, updated_at FROM notification_preferences
+		WHERE contact_index = ? ORDER BY category`, contactIndex(contact))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	var preferences []NotificationPreference
+	for rows.Next() {
+		var preference NotificationPreference
+		var channels, updatedAt string
+		if err := rows.Scan(&preference.Contact, &preference.Category, &channels, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification preference: %w", err)
+		}
+		if err := openPIIFields(&preference.Contact); err != nil {
+			return nil, fmt.Errorf("failed to read notification preference: %w", err)
+		}
+		preference.Channels = splitChannels(channels)
+		if preference.UpdatedAt, err = parseTime(updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse notification preference time: %w", err)
+		}
+		preferences = append(preferences, preference)
+	}
+	return preferences, rows.Err()
+}
+
+// GetNotificationPreference returns a contact's preference for category, or nil when
+// they haven't set one
+func GetNotificationPreference(contact, category string) (*NotificationPreference, error) {
+	preferences, err := ListNotificationPreferences(contact)
+	if err != nil {
+		return nil, err
+	}
+	for i := range preferences {
+		if preferences[i].Category == category {
+			return &preferences[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// =============================================================================
+// NOTIFICATION PREFERENCE UPDATES
+// =============================================================================
+
+// SetNotificationPreference stores the channels a contact accepts for category,
+// replacing any earlier preference
+func SetNotificationPreference(contact, category string, channels []string, updatedAt time.Time) error {
+	index := contactIndex(contact)
+	if index == "" {
+		return fmt.Errorf("notification preference needs a contact")
+	}
+
+	_, err := ExecDB(`
+		INSERT INTO notification_preferences (contact, contact_index, category, channels, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(contact_index, category) DO UPDATE SET channels = excluded.channels, updated_at = excluded.updated_at`,
+		sealContact(contact), index, category, strings.Join(channels, ","), formatTime(updatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save notification preference: %w", err)
+	}
+	return nil
+}
+
+// DeleteNotificationPreference returns a contact to the default for category
+func DeleteNotificationPreference(contact, category string) error {
+	_, err := ExecDB(`DELETE FROM notification_preferences WHERE contact_index = ? AND category = ?`,
+		contactIndex(contact), category)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification preference: %w", err)
+	}
+	return nil
+}
+
+// DeleteNotificationPreferences returns a contact to the defaults for every category
+func DeleteNotificationPreferences(contact string) error {
+	if _, err := ExecDB(`DELETE FROM notification_preferences WHERE contact_index = ?`, contactIndex(contact)); err != nil {
+		return fmt.Errorf("failed to delete notification preferences: %w", err)
+	}
+	return nil
+}
+
+func splitChannels(channels string) []string {
+	var split []string
+	for _, channel := range strings.Split(channels, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			split = append(split, channel)
+		}
+	}
+	return split
+}
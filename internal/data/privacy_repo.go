@@ -0,0 +1,471 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Privacy request statuses
+const (
+	PrivacyOpen          = "open"           // verification link sent; the parent may export their data
+	PrivacyPendingReview = "pending_review" // deletion asked for records the club's books need
+	PrivacyCompleted     = "completed"      // the parent's submissions were anonymized
+	PrivacyRejected      = "rejected"       // an admin declined the deletion
+)
+
+// PrivacyRequest is a parent's request to see or delete the data held under their
+// email address. Its token is the credential of the verification link.
+type PrivacyRequest struct {
+	ID                  int64
+	Email               string // lower-cased, as preferences are kept
+	Token               string
+	CreatedAt           time.Time
+	ExpiresAt           time.Time
+	VerifiedAt          *time.Time
+	DeletionRequestedAt *time.Time
+	Status              string
+	ReviewedBy          string
+	ReviewedAt          *time.Time
+	ReviewNote          string
+	CompletedAt         *time.Time
+}
+
+// privacyRequestColumns is the column list scanned by scanPrivacyRequest
+const privacyRequestColumns = `id, email, token, created_at, expires_at, verified_at, deletion_requested_at,
+	status, reviewed_by, reviewed_at, review_note, completed_at`
+
+// =============================================================================
+// PRIVACY REQUEST QUERIES
+// =============================================================================
+
+// GetPrivacyRequestByToken returns the request a verification link belongs to, or nil
+func GetPrivacyRequestByToken(token string) (*PrivacyRequest, error) {
+	if token == "" {
+		return nil, nil
+	}
+	return queryPrivacyRequest(`token = ?`, token)
+}
+
+// GetPrivacyRequest returns a request by ID, or nil
+func GetPrivacyRequest(id int64) (*PrivacyRequest, error) {
+	return queryPrivacyRequest(`id = ?`, id)
+}
+
+func queryPrivacyRequest(where string, arg interface{}) (*PrivacyRequest, error) {
+	row := QueryRowDB(fmt.Sprintf(`SELECT %s FROM privacy_requests WHERE %s`, privacyRequestColumns, where), arg)
+	request, err := scanPrivacyRequest(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// LatestPrivacyRequestAt returns when the newest request for an email address was
+// made, or nil when there is none
+func LatestPrivacyRequestAt(emailAddress string) (*time.Time, error) {
+	var createdAt sql.NullString
+	err := QueryRowDB(`SELECT MAX(created_at) FROM privacy_requests WHERE email_index = ?`,
+		contactIndex(emailAddress)).Scan(&createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latest privacy request: %w", err)
+	}
+	return parseNullableTime(createdAt)
+}
+
+// ListPrivacyRequests returns requests with status, or every request that asked for a
+// deletion when status is empty, oldest first
+func ListPrivacyRequests(status string) ([]PrivacyRequest, error) {
+	where, args := `deletion_requested_at IS NOT NULL`, []interface{}{}
+	if status != "" {
+		where, args = `status = ?`, append(args, status)
+	}
+
+	rows, err := QueryDB(fmt.Sprintf(`SELECT %s FROM privacy_requests WHERE %s ORDER BY created_at, id`,
+		privacyRequestColumns, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query privacy requests: %w", err)
+	}
+	defer rows.Close()
+
+	var requests []PrivacyRequest
+	for rows.Next() {
+		request, err := scanPrivacyRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read privacy requests: %w", err)
+	}
+	return requests, nil
+}
+
+func scanPrivacyRequest(row interface{ Scan(...interface{}) error }) (*PrivacyRequest, error) {
+	var request PrivacyRequest
+	var createdAt, expiresAt string
+	var verifiedAt, deletionRequestedAt, reviewedAt, completedAt sql.NullString
+
+	err := row.Scan(&request.ID, &request.Email, &request.Token, &createdAt, &expiresAt, &verifiedAt,
+		&deletionRequestedAt, &request.Status, &request.ReviewedBy, &reviewedAt, &request.ReviewNote, &completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan privacy request: %w", err)
+	}
+	if err := openPIIFields(&request.Email); err != nil {
+		return nil, fmt.Errorf("failed to read privacy request %d: %w", request.ID, err)
+	}
+
+	if request.CreatedAt, err = parseTime(createdAt); err != nil {
+		return nil, fmt.Errorf("failed to parse privacy request time: %w", err)
+	}
+	if request.ExpiresAt, err = parseTime(expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to parse privacy request expiry: %w", err)
+	}
+	for _, field := range []struct {
+		target **time.Time
+		value  sql.NullString
+	}{
+		{&request.VerifiedAt, verifiedAt},
+		{&request.DeletionRequestedAt, deletionRequestedAt},
+		{&request.ReviewedAt, reviewedAt},
+		{&request.CompletedAt, completedAt},
+	} {
+		if *field.target, err = parseNullableTime(field.value); err != nil {
+			return nil, fmt.Errorf("failed to parse privacy request %d: %w", request.ID, err)
+		}
+	}
+	return &request, nil
+}
+
+// =============================================================================
+// PRIVACY REQUEST UPDATES
+// =============================================================================
+
+// CreatePrivacyRequest records a request whose verification link carries token
+func CreatePrivacyRequest(emailAddress, token string, createdAt, expiresAt time.Time) (int64, error) {
+	var id int64
+	if err := QueryRowDB(`
+		INSERT INTO privacy_requests (email, email_index, token, created_at, expires_at, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		sealContact(emailAddress), contactIndex(emailAddress), token, formatTime(createdAt), formatTime(expiresAt), PrivacyOpen).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to save privacy request: %w", err)
+	}
+	return id, nil
+}
+
+// MarkPrivacyRequestVerified records the first use of a request's link
+func MarkPrivacyRequestVerified(id int64, verifiedAt time.Time) error {
+	_, err := ExecDB(`UPDATE privacy_requests SET verified_at = ? WHERE id = ? AND verified_at IS NULL`,
+		formatTime(verifiedAt), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark privacy request verified: %w", err)
+	}
+	return nil
+}
+
+// RequestPrivacyDeletion records that the parent asked for their data to be deleted,
+// with status saying whether it waits for an admin. It reports whether the request
+// was still open.
+func RequestPrivacyDeletion(id int64, status string, requestedAt time.Time) (bool, error) {
+	result, err := ExecDB(`
+		UPDATE privacy_requests SET deletion_requested_at = ?, status = ?
+		WHERE id = ? AND status = ?`, formatTime(requestedAt), status, id, PrivacyOpen)
+	if err != nil {
+		return false, fmt.Errorf("failed to record deletion request: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// ReviewPrivacyRequest records an admin's decision on a deletion waiting for review,
+// reporting whether it was still waiting
+func ReviewPrivacyRequest(id int64, status, reviewer, note string, reviewedAt time.Time) (bool, error) {
+	result, err := ExecDB(`
+		UPDATE privacy_requests SET status = ?, reviewed_by = ?, review_note = ?, reviewed_at = ?
+		WHERE id = ? AND status = ?`, status, reviewer, note, formatTime(reviewedAt), id, PrivacyPendingReview)
+	if err != nil {
+		return false, fmt.Errorf("failed to record privacy review: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// CompletePrivacyRequest records that a request's deletion was carried out
+func CompletePrivacyRequest(id int64, completedAt time.Time) error {
+	_, err := ExecDB(`UPDATE privacy_requests SET status = ?, completed_at = ? WHERE id = ?`,
+		PrivacyCompleted, formatTime(completedAt), id)
+	if err != nil {
+		return fmt.Errorf("failed to complete privacy request: %w", err)
+	}
+	return nil
+}
+
+// =============================================================================
+// RETENTION
+// =============================================================================
+
+// ExpiredSubmission is a closed submission kept past the retention period
+type ExpiredSubmission struct {
+	FormType string
+	FormID   string
+	ClosedAt time.Time
+	Archived bool
+}
+
+// retentionHeld keeps each form type's submissions that are still running out of
+// retention, beyond the payment statuses every table shares
+var retentionHeld = map[string]string{
+	"membership": " AND COALESCE(auto_renew, 0) = 0",
+}
+
+// ListExpiredSubmissions returns up to limit submissions per form type, live and
+// archived, closed before the cutoff that aren't anonymized yet, oldest first. A
+// submission is closed once its payment is settled: paid, refunded or reversed.
+// Disputed payments, installment plans and auto-renewing memberships are still open;
+// unpaid drafts are the cleanup's.
+func ListExpiredSubmissions(closedBefore time.Time, limit int) ([]ExpiredSubmission, error) {
+	var expired []ExpiredSubmission
+	for formType, live := range checkoutTables {
+		for _, table := range []string{live, archiveTables[formType]} {
+			found, err := listExpired(formType, table, closedBefore, limit)
+			if err != nil {
+				return nil, err
+			}
+			expired = append(expired, found...)
+		}
+	}
+	return expired, nil
+}
+
+// listExpired lists the expired submissions of one submission or archive table
+func listExpired(formType, table string, closedBefore time.Time, limit int) ([]ExpiredSubmission, error) {
+	rows, err := QueryDB(fmt.Sprintf(`
+		SELECT form_id, COALESCE(submitted_at, submission_date) AS closed_at FROM %s
+		WHERE anonymized_at IS NULL AND submitted = 1
+			AND paypal_status IN ('COMPLETED', 'REFUNDED', 'REVERSED')
+			AND COALESCE(submitted_at, submission_date) < ?%s
+		ORDER BY closed_at LIMIT ?`, table, retentionHeld[formType]), formatTime(closedBefore), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired %s submissions: %w", formType, err)
+	}
+	defer rows.Close()
+
+	var expired []ExpiredSubmission
+	for rows.Next() {
+		sub := ExpiredSubmission{FormType: formType, Archived: table == archiveTables[formType]}
+		var closedAt string
+		if err := rows.Scan(&sub.FormID, &closedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan expired submission: %w", err)
+		}
+		if sub.ClosedAt, err = parseTime(closedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse closing time of %s: %w", sub.FormID, err)
+		}
+		expired = append(expired, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read expired %s submissions: %w", formType, err)
+	}
+	return expired, nil
+}
+
+// =============================================================================
+// ANONYMIZATION
+// =============================================================================
+
+// anonymizedName replaces the payer's name on an anonymized submission
+const anonymizedName = "Anonymized"
+
+// anonymizedEmailPrefix starts the hash that replaces an anonymized submission's email
+const anonymizedEmailPrefix = "anon-"
+
+// anonymizeColumns clears each form type's own personal columns, beyond the ones
+// every submission table has
+var anonymizeColumns = map[string]string{
+	"membership": ", describe = '', interests_json = '[]'",
+	"event":      ", dietary_notes_json = '{}'",
+	"fundraiser": ", describe = ''",
+}
+
+// AnonymizeSubmission removes the payer's and students' personal details from a
+// submission, live or archived. What the club's books need stays: amounts, dates, the receipt number,
+// the items paid for, the students' grades, and the PayPal order and capture IDs. The
+// email address is replaced by a hash of it, so a family's anonymized submissions can
+// still be counted together. The audit log keeps which fields changed and when, but no
+// longer the values anonymizing removed.
+func AnonymizeSubmission(formType, formID string, anonymizedAt time.Time) error {
+	if _, ok := checkoutTables[formType]; !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	conn := currentDB()
+	if conn == nil {
+		return errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin anonymization: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Submissions of past school years are anonymized where they're archived
+	table, err := submissionTableOf(ctx, tx, formType, formID)
+	if err != nil {
+		return err
+	}
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return err
+	}
+
+	var details, webhook, emailAddress, studentsJSON sql.NullString
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT paypal_details, paypal_webhook, email, students_json FROM %s WHERE form_id = ?`, table),
+		formID).Scan(&details, &webhook, &emailAddress, &studentsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to load %s for anonymization: %w", formID, err)
+	}
+	if err := openPIIFields(&emailAddress.String, &studentsJSON.String); err != nil {
+		return fmt.Errorf("failed to read %s for anonymization: %w", formID, err)
+	}
+
+	// Students are kept for the counts by grade, without their names
+	students := []Student{}
+	if studentsJSON.String != "" {
+		if err := json.Unmarshal([]byte(studentsJSON.String), &students); err != nil {
+			return fmt.Errorf("failed to parse students of %s: %w", formID, err)
+		}
+	}
+	for i := range students {
+		students[i].Name = ""
+	}
+	studentsValue, err := marshalJSON(students)
+	if err != nil {
+		return err
+	}
+
+	name, hashedEmail := anonymizedName, anonymizedEmail(emailAddress.String)
+	sealPIIFields(&name, &hashedEmail, &studentsValue)
+
+	sets := `full_name = ?, first_name = '', last_name = '', email = ?, school = '', students_json = ?,
+		access_token = '', resume_token = '', sms_phone = '', sms_consent_at = NULL, household_id = NULL,
+		paypal_details = ?, paypal_webhook = ?, anonymized_at = ?` + anonymizeColumns[formType]
+	args := []interface{}{name, hashedEmail, studentsValue, scrubPayPalJSON(details), scrubPayPalJSON(webhook),
+		formatTime(anonymizedAt)}
+
+	// Donations are recorded per student; keep the amounts without the names
+	if formType == "fundraiser" {
+		var itemsJSON sql.NullString
+		err := tx.QueryRowContext(ctx, `SELECT donation_items_json FROM fundraiser_submissions WHERE form_id = ?`,
+			formID).Scan(&itemsJSON)
+		if err != nil {
+			return fmt.Errorf("failed to load donations of %s: %w", formID, err)
+		}
+		if err := openPIIFields(&itemsJSON.String); err != nil {
+			return fmt.Errorf("failed to read donations of %s: %w", formID, err)
+		}
+		var items []StudentDonation
+		if itemsJSON.String != "" {
+			if err := json.Unmarshal([]byte(itemsJSON.String), &items); err != nil {
+				return fmt.Errorf("failed to parse donations of %s: %w", formID, err)
+			}
+		}
+		for i := range items {
+			items[i].StudentName = ""
+		}
+		donationItems, err := marshalJSON(items)
+		if err != nil {
+			return err
+		}
+		sealPIIFields(&donationItems)
+		sets += ", donation_items_json = ?"
+		args = append(args, donationItems)
+	}
+
+	args = append(args, formID)
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s WHERE form_id = ?`, table, sets), args...); err != nil {
+		return fmt.Errorf("failed to anonymize %s: %w", formID, err)
+	}
+	if err := forgetAuditValues(ctx, tx, table, formID, before); err != nil {
+		return err
+	}
+
+	// Queued emails and texts would otherwise reload what's left and go nowhere
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE outbox_tasks SET status = ?, last_error = 'submission anonymized'
+		WHERE form_id = ? AND status = ?`, OutboxFailed, formID, OutboxPending); err != nil {
+		return fmt.Errorf("failed to cancel queued tasks for %s: %w", formID, err)
+	}
+
+	return tx.Commit()
+}
+
+// anonymizedEmail is the hash of an email address an anonymized submission keeps in
+// its place. It can't be read back or matched by the lookups by email, and doesn't
+// parse as an address, so nothing is ever sent to it.
+func anonymizedEmail(emailAddress string) string {
+	contact := NormalizeContact(emailAddress)
+	if contact == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(contact))
+	return anonymizedEmailPrefix + hex.EncodeToString(sum[:16])
+}
+
+// payPalPersonalKeys hold the payer's name, email, address and card or account details
+// in PayPal's order, capture and webhook resources
+var payPalPersonalKeys = map[string]bool{"payer": true, "shipping": true, "payment_source": true}
+
+// scrubPayPalJSON drops the payer's details from stored PayPal JSON, keeping the IDs,
+// amounts and fees. JSON that can't be read is dropped whole.
+func scrubPayPalJSON(stored sql.NullString) interface{} {
+	if !stored.Valid {
+		return nil
+	}
+	if stored.String == "" || stored.String == "null" {
+		return stored.String
+	}
+
+	var resource interface{}
+	if err := json.Unmarshal([]byte(stored.String), &resource); err != nil {
+		return ""
+	}
+	scrubbed, err := json.Marshal(scrubPayPalValue(resource))
+	if err != nil {
+		return ""
+	}
+	return string(scrubbed)
+}
+
+func scrubPayPalValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if payPalPersonalKeys[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = scrubPayPalValue(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = scrubPayPalValue(child)
+		}
+	}
+	return value
+}
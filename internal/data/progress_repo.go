@@ -0,0 +1,50 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// GetFundraiserTotals adds up the fundraiser donations completed for submissions made
+// in [from, to). Covered processing fees aren't counted as raised, and partial refunds
+// come out of what was.
+func GetFundraiserTotals(from, to time.Time) (float64, int, error) {
+	var raised float64
+	var donations int
+	err := QueryRowDB(`
+		SELECT COALESCE(SUM(total_amount - calculated_amount + COALESCE(net_amount, calculated_amount)), 0), COUNT(*)
+		FROM fundraiser_submissions
+		WHERE paypal_status = 'COMPLETED' AND submission_date >= ? AND submission_date < ?`,
+		formatTime(from), formatTime(to)).Scan(&raised, &donations)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to total fundraiser donations: %w", err)
+	}
+	return raised, donations, nil
+}
+
+// CountPaidMembershipsByLevel counts the paid memberships submitted in [from, to) by
+// membership level
+func CountPaidMembershipsByLevel(from, to time.Time) (map[string]int, error) {
+	rows, err := QueryDB(`
+		SELECT COALESCE(membership, ''), COUNT(*) FROM membership_submissions
+		WHERE paypal_status = 'COMPLETED' AND submission_date >= ? AND submission_date < ?
+		GROUP BY COALESCE(membership, '')`, formatTime(from), formatTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count memberships: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var level string
+		var count int
+		if err := rows.Scan(&level, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan membership count: %w", err)
+		}
+		counts[level] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read membership counts: %w", err)
+	}
+	return counts, nil
+}
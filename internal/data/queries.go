@@ -0,0 +1,61 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// GENERATED QUERIES
+// =============================================================================
+
+// Queries in queries/*.sql are turned into typed functions in queries.gen.go, checked
+// against queries/schema.sql, a dump of the migrated schema. After changing either the
+// queries or the migrations, refresh the dump with
+// go test ./internal/testing -run QuerySchema -update and then regenerate:
+//go:generate go run ../../cmd/querygen -schema queries/schema.sql -queries queries -out queries.gen.go
+
+// GeneratedQueries returns the SQL of every generated query, by name
+func GeneratedQueries() map[string]string {
+	queries := make(map[string]string, len(generatedQueries))
+	for name, query := range generatedQueries {
+		queries[name] = query
+	}
+	return queries
+}
+
+// SchemaSQL dumps the schema of a SQLite database as the statements that recreate it:
+// tables, then the views over them, then indexes and triggers. The tables a full-text
+// index keeps its data in are left out, since creating the index creates them.
+func SchemaSQL(conn *sql.DB) (string, error) {
+	rows, err := conn.Query(`
+		SELECT sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+			AND name NOT IN (SELECT name FROM pragma_table_list WHERE type = 'shadow')
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'view' THEN 1 ELSE 2 END, name`)
+	if err != nil {
+		return "", fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return "", fmt.Errorf("failed to scan schema: %w", err)
+		}
+		b.WriteString(strings.TrimSpace(stmt))
+		b.WriteString(";\n\n")
+	}
+	return b.String(), rows.Err()
+}
+
+// nullTime is formatNullableTime for generated queries' nullable parameters
+func nullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTime(*t), Valid: true}
+}
@@ -0,0 +1,91 @@
+-- Event registrations. Regenerate queries.gen.go after changing this file: go generate ./internal/data
+
+-- name: InsertEventSubmission :exec
+-- param: submitted_at sql.NullString
+-- param: paypal_order_created_at sql.NullString
+INSERT INTO event_submissions (
+	form_id, access_token, submission_date, event, full_name, first_name, last_name, email, school,
+	student_count, students_json, submitted, submitted_at, has_food_orders, food_choices_json, food_order_id,
+	order_page_url, calculated_amount, cover_fees, paypal_order_id, paypal_order_created_at,
+	paypal_status, paypal_details, dietary_notes_json
+) VALUES (
+	@form_id, @access_token, @submission_date, @event, @full_name, @first_name, @last_name, @email, @school,
+	@student_count, @students_json, @submitted, @submitted_at, @has_food_orders, @food_choices_json, @food_order_id,
+	@order_page_url, @calculated_amount, @cover_fees, @paypal_order_id, @paypal_order_created_at,
+	@paypal_status, @paypal_details, @dietary_notes_json
+);
+
+-- name: GetEventSubmission :one
+-- row: eventSubmissionRow
+-- column: receipt_number string
+SELECT form_id, access_token, submission_date, event, full_name, first_name, last_name, email, school,
+	student_count, students_json, submitted, submitted_at, has_food_orders, food_choices_json, food_order_id,
+	order_page_url, calculated_amount, cover_fees, paypal_order_id, paypal_order_created_at,
+	paypal_status, paypal_details, dietary_notes_json, COALESCE(receipt_number, '') AS receipt_number, version
+FROM event_submissions WHERE form_id = @form_id;
+
+-- name: ListEventSubmissions :many
+-- row: eventSubmissionRow
+-- column: receipt_number string
+-- param: from string
+-- param: to string
+-- param: status string
+-- param: search string
+-- param: pattern string
+-- param: limit int64
+-- param: offset int64
+SELECT form_id, access_token, submission_date, event, full_name, first_name, last_name, email, school,
+	student_count, students_json, submitted, submitted_at, has_food_orders, food_choices_json, food_order_id,
+	order_page_url, calculated_amount, cover_fees, paypal_order_id, paypal_order_created_at,
+	paypal_status, paypal_details, dietary_notes_json, COALESCE(receipt_number, '') AS receipt_number, version
+FROM event_submissions
+WHERE submission_date >= @from AND submission_date < @to AND submitted = 1
+	AND (@school = '' OR school = @school)
+	AND (@status = ''
+		OR (@status = 'paid' AND paypal_status = 'COMPLETED')
+		OR (@status = 'unpaid' AND COALESCE(paypal_status, '') NOT IN ('COMPLETED', 'REFUNDED', 'REVERSED', 'DISPUTED'))
+		OR paypal_status = @status)
+	AND (@search = '' OR LOWER(pii_open(full_name)) LIKE @pattern OR LOWER(pii_open(email)) LIKE @pattern OR receipt_number = @search)
+	AND (@funding_source = '' OR funding_source = @funding_source)
+ORDER BY submission_date DESC
+LIMIT @limit OFFSET @offset;
+
+-- name: UpdateEventPayment :exec
+UPDATE event_submissions
+SET food_choices_json = @food_choices_json, has_food_orders = @has_food_orders, food_order_id = @food_order_id,
+	calculated_amount = @calculated_amount, cover_fees = @cover_fees, dietary_notes_json = @dietary_notes_json
+WHERE form_id = @form_id AND (@version = 0 OR version = @version);
+
+-- name: ApplyEventOrderChange :exec
+-- param: previous_amount float64
+-- Saves a change to a paid order's food only if the order is still as it was priced,
+-- moving its version on so a second change priced alongside it conflicts
+UPDATE event_submissions
+SET food_choices_json = @food_choices_json, has_food_orders = @has_food_orders, food_order_id = @food_order_id,
+	calculated_amount = @calculated_amount, version = version + 1
+WHERE form_id = @form_id AND version = @version AND ABS(calculated_amount - @previous_amount) < 0.005;
+
+-- name: UpdateEventOrderPageURL :exec
+UPDATE event_submissions SET order_page_url = @order_page_url WHERE form_id = @form_id;
+
+-- name: ListActiveEventOrderPageURLs :many
+SELECT order_page_url FROM event_submissions
+WHERE paypal_status IN ('COMPLETED', 'PENDING', 'INSTALLMENTS', 'DISPUTED')
+	AND order_page_url IS NOT NULL AND order_page_url != '';
+
+-- name: UpdateEventPayPalOrder :exec
+-- param: paypal_order_created_at sql.NullString
+UPDATE event_submissions SET paypal_order_id = @paypal_order_id, paypal_order_created_at = @paypal_order_created_at
+WHERE form_id = @form_id;
+
+-- name: UpdateEventPayPalCapture :exec
+-- param: submitted_at sql.NullString
+UPDATE event_submissions
+SET paypal_details = @paypal_details, paypal_status = @paypal_status, submitted = 1, submitted_at = @submitted_at
+WHERE form_id = @form_id;
+
+-- name: ClaimEventConfirmationEmail :exec
+UPDATE event_submissions SET confirmation_email_sent = 1 WHERE form_id = @form_id AND confirmation_email_sent = 0;
+
+-- name: ReleaseEventConfirmationEmail :exec
+UPDATE event_submissions SET confirmation_email_sent = 0 WHERE form_id = @form_id;
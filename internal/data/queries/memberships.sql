@@ -0,0 +1,82 @@
+-- Membership submissions. Regenerate queries.gen.go after changing this file: go generate ./internal/data
+
+-- name: InsertMembershipSubmission :exec
+-- param: submitted_at sql.NullString
+-- param: paypal_order_created_at sql.NullString
+INSERT INTO membership_submissions (
+	form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
+	membership, membership_status, describe, student_count, students_json, interests_json,
+	addons_json, fees_json, donation, calculated_amount, cover_fees, paypal_order_id,
+	paypal_order_created_at, paypal_status, paypal_details, submitted, submitted_at
+) VALUES (
+	@form_id, @access_token, @submission_date, @full_name, @first_name, @last_name, @email, @school,
+	@membership, @membership_status, @describe, @student_count, @students_json, @interests_json,
+	@addons_json, @fees_json, @donation, @calculated_amount, @cover_fees, @paypal_order_id,
+	@paypal_order_created_at, @paypal_status, @paypal_details, @submitted, @submitted_at
+);
+
+-- name: GetMembershipSubmission :one
+-- row: membershipSubmissionRow
+-- column: receipt_number string
+SELECT form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
+	membership, membership_status, describe, student_count, students_json, interests_json,
+	addons_json, fees_json, donation, calculated_amount, cover_fees, paypal_order_id,
+	paypal_order_created_at, paypal_status, paypal_details, submitted, submitted_at,
+	COALESCE(receipt_number, '') AS receipt_number, version
+FROM membership_submissions WHERE form_id = @form_id;
+
+-- name: ListMembershipSubmissions :many
+-- row: membershipSubmissionRow
+-- column: receipt_number string
+-- param: from string
+-- param: to string
+-- param: status string
+-- param: search string
+-- param: pattern string
+-- param: limit int64
+-- param: offset int64
+SELECT form_id, access_token, submission_date, full_name, first_name, last_name, email, school,
+	membership, membership_status, describe, student_count, students_json, interests_json,
+	addons_json, fees_json, donation, calculated_amount, cover_fees, paypal_order_id,
+	paypal_order_created_at, paypal_status, paypal_details, submitted, submitted_at,
+	COALESCE(receipt_number, '') AS receipt_number, version
+FROM membership_submissions
+WHERE submission_date >= @from AND submission_date < @to
+	AND (@school = '' OR school = @school)
+	AND (@status = ''
+		OR (@status = 'paid' AND paypal_status = 'COMPLETED')
+		OR (@status = 'unpaid' AND COALESCE(paypal_status, '') NOT IN ('COMPLETED', 'REFUNDED', 'REVERSED', 'DISPUTED'))
+		OR paypal_status = @status)
+	AND (@search = '' OR LOWER(pii_open(full_name)) LIKE @pattern OR LOWER(pii_open(email)) LIKE @pattern OR receipt_number = @search)
+	AND (@funding_source = '' OR funding_source = @funding_source)
+ORDER BY submission_date DESC
+LIMIT @limit OFFSET @offset;
+
+-- name: UpdateMembershipPayPalOrder :exec
+-- param: paypal_order_created_at sql.NullString
+UPDATE membership_submissions SET paypal_order_id = @paypal_order_id, paypal_order_created_at = @paypal_order_created_at
+WHERE form_id = @form_id;
+
+-- name: UpdateMembershipPayPalCapture :exec
+-- param: submitted_at sql.NullString
+UPDATE membership_submissions
+SET paypal_details = @paypal_details, paypal_status = @paypal_status, submitted = 1, submitted_at = @submitted_at
+WHERE form_id = @form_id;
+
+-- name: UpdateMembershipPayPalDetails :exec
+UPDATE membership_submissions SET paypal_status = @paypal_status, paypal_webhook = @paypal_webhook
+WHERE form_id = @form_id;
+
+-- name: UpdateMembershipPayment :exec
+-- param: submitted_at sql.NullString
+UPDATE membership_submissions
+SET membership = @membership, addons_json = @addons_json, fees_json = @fees_json, donation = @donation,
+	cover_fees = @cover_fees, calculated_amount = @calculated_amount, submitted = @submitted, submitted_at = @submitted_at
+WHERE form_id = @form_id AND (@version = 0 OR version = @version);
+
+-- name: UpdateMembershipEmailStatus :exec
+-- param: sent_at string
+UPDATE membership_submissions
+SET confirmation_email_sent = @confirmation_email_sent, confirmation_email_sent_at = @sent_at,
+	admin_notification_sent = @admin_notification_sent, admin_notification_sent_at = @sent_at
+WHERE form_id = @form_id;
@@ -0,0 +1,646 @@
+CREATE TABLE archived_event_submissions(
+  form_id TEXT,
+  access_token TEXT,
+  submission_date TEXT,
+  event TEXT,
+  full_name TEXT,
+  first_name TEXT,
+  last_name TEXT,
+  email TEXT,
+  school TEXT,
+  student_count INT,
+  students_json TEXT,
+  submitted NUM,
+  submitted_at TEXT,
+  food_choices_json TEXT,
+  food_order_id TEXT,
+  order_page_url TEXT,
+  calculated_amount REAL,
+  cover_fees NUM,
+  paypal_order_id TEXT,
+  paypal_status TEXT,
+  dietary_notes_json TEXT,
+  has_food_orders NUM,
+  paypal_order_created_at TEXT,
+  paypal_details TEXT,
+  confirmation_email_sent NUM,
+  paypal_webhook TEXT,
+  receipt_number TEXT,
+  anonymized_at TEXT,
+  household_id INT,
+  net_amount REAL,
+  credit_applied REAL,
+  reminder_sent_at TEXT,
+  abandoned_at TEXT,
+  resume_token TEXT,
+  sms_phone TEXT,
+  sms_consent_at TEXT,
+  sms_confirmation_sent_at TEXT,
+  funding_source TEXT,
+  payment_instrument TEXT,
+  bank_transfer_status TEXT,
+  bank_transfer_updated_at TEXT,
+  round_up REAL,
+  coupon_code TEXT,
+  coupon_discount REAL,
+  currency TEXT
+, archived_at TEXT, version INTEGER NOT NULL DEFAULT 1);
+
+CREATE TABLE archived_fundraiser_submissions(
+  form_id TEXT,
+  access_token TEXT,
+  submission_date TEXT,
+  full_name TEXT,
+  first_name TEXT,
+  last_name TEXT,
+  email TEXT,
+  school TEXT,
+  describe TEXT,
+  donor_status TEXT,
+  student_count INT,
+  students_json TEXT,
+  donation_items_json TEXT,
+  total_amount REAL,
+  cover_fees NUM,
+  calculated_amount REAL,
+  paypal_order_id TEXT,
+  paypal_order_created_at TEXT,
+  paypal_status TEXT,
+  paypal_details TEXT,
+  submitted NUM,
+  submitted_at TEXT,
+  confirmation_email_sent NUM,
+  confirmation_email_sent_at TEXT,
+  admin_notification_sent NUM,
+  admin_notification_sent_at TEXT,
+  paypal_webhook TEXT,
+  receipt_number TEXT,
+  anonymized_at TEXT,
+  household_id INT,
+  net_amount REAL,
+  credit_applied REAL,
+  reminder_sent_at TEXT,
+  abandoned_at TEXT,
+  resume_token TEXT,
+  sms_phone TEXT,
+  sms_consent_at TEXT,
+  sms_confirmation_sent_at TEXT,
+  funding_source TEXT,
+  payment_instrument TEXT,
+  bank_transfer_status TEXT,
+  bank_transfer_updated_at TEXT,
+  round_up REAL,
+  coupon_code TEXT,
+  coupon_discount REAL,
+  currency TEXT
+, archived_at TEXT, version INTEGER NOT NULL DEFAULT 1);
+
+CREATE TABLE archived_membership_submissions(
+  form_id TEXT,
+  access_token TEXT,
+  submission_date TEXT,
+  full_name TEXT,
+  first_name TEXT,
+  last_name TEXT,
+  email TEXT,
+  school TEXT,
+  membership TEXT,
+  membership_status TEXT,
+  describe TEXT,
+  student_count INT,
+  students_json TEXT,
+  interests_json TEXT,
+  addons_json TEXT,
+  fees_json TEXT,
+  donation REAL,
+  calculated_amount REAL,
+  cover_fees NUM,
+  paypal_order_id TEXT,
+  paypal_order_created_at TEXT,
+  paypal_status TEXT,
+  paypal_details TEXT,
+  paypal_webhook TEXT,
+  submitted NUM,
+  submitted_at TEXT,
+  confirmation_email_sent NUM,
+  confirmation_email_sent_at TEXT,
+  admin_notification_sent NUM,
+  admin_notification_sent_at TEXT,
+  receipt_number TEXT,
+  anonymized_at TEXT,
+  household_id INT,
+  net_amount REAL,
+  auto_renew NUM,
+  paypal_subscription_id TEXT,
+  subscription_status TEXT,
+  renewed_through TEXT,
+  credit_applied REAL,
+  reminder_sent_at TEXT,
+  abandoned_at TEXT,
+  resume_token TEXT,
+  sms_phone TEXT,
+  sms_consent_at TEXT,
+  sms_confirmation_sent_at TEXT,
+  funding_source TEXT,
+  payment_instrument TEXT,
+  bank_transfer_status TEXT,
+  bank_transfer_updated_at TEXT,
+  round_up REAL,
+  coupon_code TEXT,
+  coupon_discount REAL,
+  currency TEXT
+, archived_at TEXT, version INTEGER NOT NULL DEFAULT 1);
+
+CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		old_value TEXT,
+		new_value TEXT,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		changed_at TEXT NOT NULL
+	);
+
+CREATE TABLE coupon_claims (
+		form_id TEXT PRIMARY KEY,
+		code TEXT NOT NULL,
+		claimed_at TEXT NOT NULL
+	);
+
+CREATE TABLE credits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		amount REAL NOT NULL,
+		form_id TEXT DEFAULT '',
+		reason TEXT DEFAULT '',
+		created_at TEXT NOT NULL
+	, email_index TEXT);
+
+CREATE TABLE disputes (
+		dispute_id TEXT PRIMARY KEY,
+		form_id TEXT NOT NULL,
+		form_type TEXT NOT NULL,
+		reason TEXT DEFAULT '',
+		status TEXT DEFAULT '',
+		stage TEXT DEFAULT '',
+		outcome TEXT DEFAULT '',
+		amount REAL DEFAULT 0,
+		currency TEXT DEFAULT '',
+		respond_by TEXT DEFAULT '',
+		details TEXT DEFAULT '',
+		opened_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		resolved_at TEXT
+	);
+
+CREATE TABLE donations (
+		form_id TEXT PRIMARY KEY,
+		email TEXT NOT NULL,
+		note TEXT DEFAULT '',
+		amount REAL NOT NULL,
+		currency TEXT DEFAULT '',
+		paypal_order_id TEXT DEFAULT '',
+		paypal_status TEXT DEFAULT '',
+		paypal_details TEXT DEFAULT '',
+		receipt_number TEXT,
+		thank_you_sent BOOLEAN DEFAULT 0,
+		created_at TEXT NOT NULL,
+		captured_at TEXT
+	);
+
+CREATE TABLE event_order_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		previous_selections_json TEXT DEFAULT '{}',
+		new_selections_json TEXT DEFAULT '{}',
+		has_food_orders BOOLEAN DEFAULT 0,
+		previous_amount REAL DEFAULT 0,
+		new_amount REAL DEFAULT 0,
+		delta REAL DEFAULT 0,
+		paypal_order_id TEXT DEFAULT '',
+		paypal_refund_id TEXT DEFAULT '',
+		status TEXT NOT NULL,
+		applied_at TEXT
+	);
+
+CREATE TABLE event_submissions (
+        form_id TEXT PRIMARY KEY,
+        access_token TEXT,
+        submission_date TEXT NOT NULL,
+        event TEXT NOT NULL,
+        full_name TEXT NOT NULL,
+        first_name TEXT,
+        last_name TEXT,
+        email TEXT NOT NULL,
+        school TEXT,
+        student_count INTEGER DEFAULT 0,
+        students_json TEXT DEFAULT '[]',
+        submitted BOOLEAN DEFAULT 0,
+        submitted_at TEXT,
+        food_choices_json TEXT DEFAULT '{}',
+        food_order_id TEXT DEFAULT '',
+        order_page_url TEXT DEFAULT '',
+        calculated_amount REAL DEFAULT 0,
+        cover_fees BOOLEAN DEFAULT 0,
+        paypal_order_id TEXT,
+        paypal_status TEXT,
+        dietary_notes_json TEXT DEFAULT '{}'
+    , has_food_orders BOOLEAN DEFAULT 0, paypal_order_created_at TEXT, paypal_details TEXT, confirmation_email_sent BOOLEAN DEFAULT 0, paypal_webhook TEXT, receipt_number TEXT, anonymized_at TEXT, household_id INTEGER, net_amount REAL, credit_applied REAL DEFAULT 0, reminder_sent_at TEXT, abandoned_at TEXT, resume_token TEXT DEFAULT '', sms_phone TEXT DEFAULT '', sms_consent_at TEXT, sms_confirmation_sent_at TEXT, funding_source TEXT DEFAULT '', payment_instrument TEXT DEFAULT '', bank_transfer_status TEXT DEFAULT '', bank_transfer_updated_at TEXT, round_up REAL DEFAULT 0, coupon_code TEXT DEFAULT '', coupon_discount REAL DEFAULT 0, currency TEXT DEFAULT '', version INTEGER NOT NULL DEFAULT 1);
+
+CREATE TABLE fundraiser_submissions (
+		form_id TEXT PRIMARY KEY,
+		access_token TEXT,
+		submission_date TEXT NOT NULL,
+		full_name TEXT NOT NULL,
+		first_name TEXT,
+		last_name TEXT,
+		email TEXT NOT NULL,
+		school TEXT,
+		describe TEXT,
+		donor_status TEXT,
+		student_count INTEGER DEFAULT 0,
+		students_json TEXT DEFAULT '[]',
+		donation_items_json TEXT DEFAULT '[]',
+		total_amount REAL DEFAULT 0,
+		cover_fees BOOLEAN DEFAULT 0,
+		calculated_amount REAL DEFAULT 0,
+		paypal_order_id TEXT,
+		paypal_order_created_at TEXT,
+		paypal_status TEXT,
+		paypal_details TEXT,
+		submitted BOOLEAN DEFAULT 0,
+		submitted_at TEXT,
+		confirmation_email_sent BOOLEAN DEFAULT 0,
+		confirmation_email_sent_at TEXT,
+		admin_notification_sent BOOLEAN DEFAULT 0,
+		admin_notification_sent_at TEXT
+	, paypal_webhook TEXT, receipt_number TEXT, anonymized_at TEXT, household_id INTEGER, net_amount REAL, credit_applied REAL DEFAULT 0, reminder_sent_at TEXT, abandoned_at TEXT, resume_token TEXT DEFAULT '', sms_phone TEXT DEFAULT '', sms_consent_at TEXT, sms_confirmation_sent_at TEXT, funding_source TEXT DEFAULT '', payment_instrument TEXT DEFAULT '', bank_transfer_status TEXT DEFAULT '', bank_transfer_updated_at TEXT, round_up REAL DEFAULT 0, coupon_code TEXT DEFAULT '', coupon_discount REAL DEFAULT 0, currency TEXT DEFAULT '', version INTEGER NOT NULL DEFAULT 1);
+
+CREATE TABLE households (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		verified_at TEXT,
+		link_token TEXT DEFAULT '',
+		link_sent_at TEXT,
+		link_expires_at TEXT
+	, email_index TEXT);
+
+CREATE TABLE idempotency_keys (
+		endpoint TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER DEFAULT 0,
+		content_type TEXT DEFAULT '',
+		response BLOB,
+		created_at TEXT NOT NULL,
+		completed_at TEXT,
+		PRIMARY KEY (endpoint, key)
+	);
+
+CREATE TABLE installments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		amount REAL NOT NULL,
+		due_at TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'due',
+		order_id TEXT DEFAULT '',
+		paid_at TEXT,
+		details TEXT DEFAULT '',
+		reminder_sent_at TEXT,
+		UNIQUE(form_id, number)
+	);
+
+CREATE TABLE membership_submissions (
+        form_id TEXT PRIMARY KEY,
+        access_token TEXT,
+        submission_date TEXT NOT NULL,
+        full_name TEXT NOT NULL,
+        first_name TEXT,
+        last_name TEXT,
+        email TEXT NOT NULL,
+        school TEXT,
+        membership TEXT,
+        membership_status TEXT,
+        describe TEXT,
+        student_count INTEGER DEFAULT 0,
+        students_json TEXT DEFAULT '[]',
+        interests_json TEXT DEFAULT '[]',
+        addons_json TEXT DEFAULT '[]',
+        fees_json TEXT DEFAULT '{}',
+        donation REAL DEFAULT 0,
+        calculated_amount REAL DEFAULT 0,
+        cover_fees BOOLEAN DEFAULT 0,
+        paypal_order_id TEXT,
+        paypal_order_created_at TEXT,
+        paypal_status TEXT,
+        paypal_details TEXT,
+        paypal_webhook TEXT,
+        submitted BOOLEAN DEFAULT 0,
+        submitted_at TEXT,
+        confirmation_email_sent BOOLEAN DEFAULT 0,
+        confirmation_email_sent_at TEXT,
+        admin_notification_sent BOOLEAN DEFAULT 0,
+        admin_notification_sent_at TEXT
+    , receipt_number TEXT, anonymized_at TEXT, household_id INTEGER, net_amount REAL, auto_renew BOOLEAN DEFAULT 0, paypal_subscription_id TEXT DEFAULT '', subscription_status TEXT DEFAULT '', renewed_through TEXT, credit_applied REAL DEFAULT 0, reminder_sent_at TEXT, abandoned_at TEXT, resume_token TEXT DEFAULT '', sms_phone TEXT DEFAULT '', sms_consent_at TEXT, sms_confirmation_sent_at TEXT, funding_source TEXT DEFAULT '', payment_instrument TEXT DEFAULT '', bank_transfer_status TEXT DEFAULT '', bank_transfer_updated_at TEXT, round_up REAL DEFAULT 0, coupon_code TEXT DEFAULT '', coupon_discount REAL DEFAULT 0, currency TEXT DEFAULT '', version INTEGER NOT NULL DEFAULT 1);
+
+CREATE TABLE notification_preferences (
+		contact TEXT NOT NULL,
+		category TEXT NOT NULL,
+		channels TEXT NOT NULL DEFAULT '',
+		updated_at TEXT NOT NULL, contact_index TEXT,
+		PRIMARY KEY (contact, category)
+	);
+
+CREATE TABLE outbox_tasks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		form_id TEXT NOT NULL,
+		payload_json TEXT DEFAULT '{}',
+		status TEXT NOT NULL,
+		attempts INTEGER DEFAULT 0,
+		last_error TEXT DEFAULT '',
+		available_at TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		claimed_at TEXT,
+		completed_at TEXT,
+		UNIQUE(kind, form_id)
+	);
+
+CREATE TABLE privacy_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		token TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		expires_at TEXT NOT NULL,
+		verified_at TEXT,
+		deletion_requested_at TEXT,
+		status TEXT NOT NULL,
+		reviewed_by TEXT DEFAULT '',
+		reviewed_at TEXT,
+		review_note TEXT DEFAULT '',
+		completed_at TEXT
+	, email_index TEXT);
+
+CREATE TABLE receipt_sequences (
+		year INTEGER PRIMARY KEY,
+		last_number INTEGER NOT NULL
+	);
+
+CREATE TABLE refunds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		refund_id TEXT DEFAULT '',
+		amount REAL NOT NULL,
+		reason TEXT DEFAULT '',
+		note TEXT DEFAULT '',
+		refunded_at TEXT NOT NULL
+	);
+
+CREATE TABLE renewals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		form_id TEXT NOT NULL,
+		subscription_id TEXT NOT NULL,
+		cycle INTEGER NOT NULL,
+		sale_id TEXT NOT NULL UNIQUE,
+		amount REAL DEFAULT 0,
+		paid_at TEXT NOT NULL,
+		covers_through TEXT NOT NULL
+	);
+
+CREATE TABLE schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);
+
+CREATE TABLE service_tokens (
+		name TEXT PRIMARY KEY,
+		token TEXT NOT NULL,
+		issued_for TEXT DEFAULT '',
+		expires_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	);
+
+CREATE VIRTUAL TABLE submission_search USING fts5(
+		form_id UNINDEXED,
+		full_name,
+		email,
+		school,
+		students,
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+
+CREATE TABLE unmatched_payments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		capture_id TEXT NOT NULL UNIQUE,
+		order_id TEXT DEFAULT '',
+		invoice_id TEXT DEFAULT '',
+		event_type TEXT DEFAULT '',
+		status TEXT DEFAULT '',
+		amount REAL DEFAULT 0,
+		currency TEXT DEFAULT '',
+		details TEXT DEFAULT '',
+		received_at TEXT NOT NULL,
+		linked_form_id TEXT DEFAULT '',
+		linked_at TEXT
+	);
+
+CREATE TABLE webhook_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT UNIQUE,
+		transmission_id TEXT DEFAULT '',
+		event_type TEXT DEFAULT '',
+		form_id TEXT DEFAULT '',
+		payload TEXT NOT NULL,
+		result TEXT NOT NULL DEFAULT 'received',
+		error_message TEXT DEFAULT '',
+		deliveries INTEGER NOT NULL DEFAULT 1,
+		replays INTEGER NOT NULL DEFAULT 0,
+		received_at TEXT NOT NULL,
+		processed_at TEXT
+	);
+
+CREATE VIEW archived_submissions AS
+		SELECT 'event' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			event AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, order_page_url AS order_page_url
+		FROM archived_event_submissions
+		UNION ALL
+		SELECT 'fundraiser' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			'' AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, '' AS order_page_url
+		FROM archived_fundraiser_submissions
+		UNION ALL
+		SELECT 'membership' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			membership AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, '' AS order_page_url
+		FROM archived_membership_submissions;
+
+CREATE VIEW submissions AS
+		SELECT 'event' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			event AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, order_page_url AS order_page_url
+		FROM event_submissions
+		UNION ALL
+		SELECT 'fundraiser' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			'' AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, '' AS order_page_url
+		FROM fundraiser_submissions
+		UNION ALL
+		SELECT 'membership' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			membership AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, '' AS order_page_url
+		FROM membership_submissions;
+
+CREATE TRIGGER event_submissions_search_delete AFTER DELETE ON event_submissions BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+		END;
+
+CREATE TRIGGER event_submissions_search_insert AFTER INSERT ON event_submissions BEGIN
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (NEW.form_id, pii_terms(NEW.full_name), pii_terms(NEW.email), pii_terms(NEW.school),
+			pii_terms((SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(NEW.students_json)) THEN pii_open(NEW.students_json) ELSE '[]' END))));
+		END;
+
+CREATE TRIGGER event_submissions_search_update AFTER UPDATE OF form_id, full_name, email, school, students_json ON event_submissions BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (NEW.form_id, pii_terms(NEW.full_name), pii_terms(NEW.email), pii_terms(NEW.school),
+			pii_terms((SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(NEW.students_json)) THEN pii_open(NEW.students_json) ELSE '[]' END))));
+		END;
+
+CREATE TRIGGER fundraiser_submissions_search_delete AFTER DELETE ON fundraiser_submissions BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+		END;
+
+CREATE TRIGGER fundraiser_submissions_search_insert AFTER INSERT ON fundraiser_submissions BEGIN
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (NEW.form_id, pii_terms(NEW.full_name), pii_terms(NEW.email), pii_terms(NEW.school),
+			pii_terms((SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(NEW.students_json)) THEN pii_open(NEW.students_json) ELSE '[]' END))));
+		END;
+
+CREATE TRIGGER fundraiser_submissions_search_update AFTER UPDATE OF form_id, full_name, email, school, students_json ON fundraiser_submissions BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (NEW.form_id, pii_terms(NEW.full_name), pii_terms(NEW.email), pii_terms(NEW.school),
+			pii_terms((SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(NEW.students_json)) THEN pii_open(NEW.students_json) ELSE '[]' END))));
+		END;
+
+CREATE INDEX idx_archived_event_submissions_date ON archived_event_submissions (submission_date);
+
+CREATE UNIQUE INDEX idx_archived_event_submissions_form_id ON archived_event_submissions (form_id);
+
+CREATE INDEX idx_archived_fundraiser_submissions_date ON archived_fundraiser_submissions (submission_date);
+
+CREATE UNIQUE INDEX idx_archived_fundraiser_submissions_form_id ON archived_fundraiser_submissions (form_id);
+
+CREATE INDEX idx_archived_membership_submissions_date ON archived_membership_submissions (submission_date);
+
+CREATE UNIQUE INDEX idx_archived_membership_submissions_form_id ON archived_membership_submissions (form_id);
+
+CREATE INDEX idx_audit_log_form_id ON audit_log(form_id);
+
+CREATE INDEX idx_coupon_claims_code ON coupon_claims(code);
+
+CREATE INDEX idx_credits_email ON credits(email);
+
+CREATE INDEX idx_credits_email_index ON credits(email_index);
+
+CREATE INDEX idx_disputes_form_id ON disputes(form_id);
+
+CREATE INDEX idx_donations_paypal_order_id ON donations(paypal_order_id);
+
+CREATE UNIQUE INDEX idx_donations_receipt_number ON donations(receipt_number);
+
+CREATE INDEX idx_event_email ON event_submissions(email);
+
+CREATE INDEX idx_event_order_changes_form_id ON event_order_changes(form_id);
+
+CREATE INDEX idx_event_submission_date ON event_submissions(submission_date);
+
+CREATE INDEX idx_event_submissions_household ON event_submissions(household_id);
+
+CREATE UNIQUE INDEX idx_event_submissions_receipt_number ON event_submissions(receipt_number);
+
+CREATE INDEX idx_fundraiser_email ON fundraiser_submissions(email);
+
+CREATE INDEX idx_fundraiser_submission_date ON fundraiser_submissions(submission_date);
+
+CREATE INDEX idx_fundraiser_submissions_household ON fundraiser_submissions(household_id);
+
+CREATE UNIQUE INDEX idx_fundraiser_submissions_receipt_number ON fundraiser_submissions(receipt_number);
+
+CREATE INDEX idx_fundraiser_submitted ON fundraiser_submissions(submitted);
+
+CREATE UNIQUE INDEX idx_households_email_index ON households(email_index);
+
+CREATE INDEX idx_households_link_token ON households(link_token);
+
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+CREATE INDEX idx_membership_email ON membership_submissions(email);
+
+CREATE INDEX idx_membership_submission_date ON membership_submissions(submission_date);
+
+CREATE INDEX idx_membership_submissions_household ON membership_submissions(household_id);
+
+CREATE UNIQUE INDEX idx_membership_submissions_receipt_number ON membership_submissions(receipt_number);
+
+CREATE INDEX idx_membership_submitted ON membership_submissions(submitted);
+
+CREATE UNIQUE INDEX idx_notification_preferences_contact_index ON notification_preferences(contact_index, category);
+
+CREATE INDEX idx_outbox_tasks_status ON outbox_tasks(status, available_at);
+
+CREATE INDEX idx_privacy_requests_email ON privacy_requests(email);
+
+CREATE INDEX idx_privacy_requests_email_index ON privacy_requests(email_index);
+
+CREATE INDEX idx_privacy_requests_status ON privacy_requests(status);
+
+CREATE INDEX idx_refunds_form_id ON refunds(form_id);
+
+CREATE INDEX idx_renewals_form_id ON renewals(form_id);
+
+CREATE INDEX idx_webhook_events_received_at ON webhook_events(received_at);
+
+CREATE TRIGGER membership_submissions_search_delete AFTER DELETE ON membership_submissions BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+		END;
+
+CREATE TRIGGER membership_submissions_search_insert AFTER INSERT ON membership_submissions BEGIN
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (NEW.form_id, pii_terms(NEW.full_name), pii_terms(NEW.email), pii_terms(NEW.school),
+			pii_terms((SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(NEW.students_json)) THEN pii_open(NEW.students_json) ELSE '[]' END))));
+		END;
+
+CREATE TRIGGER membership_submissions_search_update AFTER UPDATE OF form_id, full_name, email, school, students_json ON membership_submissions BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (NEW.form_id, pii_terms(NEW.full_name), pii_terms(NEW.email), pii_terms(NEW.school),
+			pii_terms((SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(NEW.students_json)) THEN pii_open(NEW.students_json) ELSE '[]' END))));
+		END;
+
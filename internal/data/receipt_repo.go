@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/logger"
+)
+
+// FormatReceiptNumber is the n-th receipt issued in year, e.g. 2025-000123
+func FormatReceiptNumber(year, n int) string {
+	return fmt.Sprintf("%d-%06d", year, n)
+}
+
+// =============================================================================
+// RECEIPT NUMBERS
+// =============================================================================
+
+// AssignReceiptNumber gives a paid submission the next receipt number for the year it
+// was paid in, the club's time zone deciding the year. A submission keeps the number
+// it already has, and unpaid ones get none. It returns the submission's number.
+func AssignReceiptNumber(formType, formID string, paidAt time.Time) (string, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return "", fmt.Errorf("unknown form type: %s", formType)
+	}
+
+	conn := currentDB()
+	if conn == nil {
+		return "", errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin receipt number transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	number, err := assignReceiptNumber(ctx, tx, table, formID, paidAt)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit receipt number: %w", err)
+	}
+	return number, nil
+}
+
+// assignReceiptNumber is AssignReceiptNumber inside the caller's transaction, so a
+// capture and its receipt number are stored together
+func assignReceiptNumber(ctx context.Context, tx *sql.Tx, table, formID string, paidAt time.Time) (string, error) {
+	var number, status sql.NullString
+	err := tx.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT receipt_number, paypal_status FROM %s WHERE form_id = ?`, table), formID).
+		Scan(&number, &status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read receipt number for %s: %w", formID, err)
+	}
+	if number.String != "" || status.String != "COMPLETED" {
+		return number.String, nil
+	}
+
+	return setNextReceiptNumber(ctx, tx, table, formID, paidAt)
+}
+
+func setNextReceiptNumber(ctx context.Context, tx *sql.Tx, table, formID string, paidAt time.Time) (string, error) {
+	year := paidAt.In(clock.Location()).Year()
+	var n int
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO receipt_sequences (year, last_number) VALUES (?, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = receipt_sequences.last_number + 1
+		RETURNING last_number`, year).Scan(&n); err != nil {
+		return "", fmt.Errorf("failed to take a %d receipt number: %w", year, err)
+	}
+
+	number := FormatReceiptNumber(year, n)
+	if _, err := tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE %s SET receipt_number = ? WHERE form_id = ?`, table), number, formID); err != nil {
+		return "", fmt.Errorf("failed to store receipt number for %s: %w", formID, err)
+	}
+	return number, nil
+}
+
+// numberUnreceiptedPayments gives every payment taken before receipt numbers existed,
+// or whose number was lost to a crash, a number in the order the payments were made.
+// Refunded and reversed payments were paid once, so they are numbered too.
+func numberUnreceiptedPayments(conn *sql.DB, logf func(string, ...interface{})) error {
+	type payment struct {
+		table, formID string
+		paidAt        time.Time
+	}
+	var unnumbered []payment
+	for _, table := range checkoutTables {
+		rows, err := conn.Query(fmt.Sprintf(`
+			SELECT form_id, COALESCE(submitted_at, submission_date) FROM %s
+			WHERE COALESCE(receipt_number, '') = '' AND paypal_status IN ('COMPLETED', 'REFUNDED', 'REVERSED', 'DISPUTED')`, table))
+		if err != nil {
+			return fmt.Errorf("failed to find unnumbered payments in %s: %w", table, err)
+		}
+		for rows.Next() {
+			p := payment{table: table}
+			var paidAt string
+			if err := rows.Scan(&p.formID, &paidAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan unnumbered payment: %w", err)
+			}
+			if p.paidAt, err = parseTime(paidAt); err != nil {
+				logger.LogWarn("Not numbering %s, which has an unreadable payment time: %v", p.formID, err)
+				continue
+			}
+			unnumbered = append(unnumbered, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read unnumbered payments in %s: %w", table, err)
+		}
+	}
+	if len(unnumbered) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(unnumbered, func(i, j int) bool {
+		return unnumbered[i].paidAt.Before(unnumbered[j].paidAt)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin receipt numbering: %w", err)
+	}
+	defer tx.Rollback()
+	for _, p := range unnumbered {
+		if _, err := setNextReceiptNumber(ctx, tx, p.table, p.formID, p.paidAt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit receipt numbering: %w", err)
+	}
+	logf("Numbered %d earlier payments without receipt numbers", len(unnumbered))
+	return nil
+}
+
+// FormIDForReceiptNumber returns the form ID of the submission with receiptNumber, or
+// sql.ErrNoRows
+func FormIDForReceiptNumber(receiptNumber string) (string, error) {
+	receiptNumber = strings.TrimSpace(receiptNumber)
+	for _, table := range checkoutTables {
+		var formID string
+		err := QueryRowDB(fmt.Sprintf(`SELECT form_id FROM %s WHERE receipt_number = ?`, table), receiptNumber).Scan(&formID)
+		if err == nil {
+			return formID, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", fmt.Errorf("failed to look up receipt %s: %w", receiptNumber, err)
+		}
+	}
+	return "", fmt.Errorf("no submission has receipt number %s: %w", receiptNumber, sql.ErrNoRows)
+}
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Refund is one refund made against a submission's captured payment. Each refund is
+// kept in the refunds table and also in the submission's paypal_details under
+// "refunds", next to the capture it returns.
+type Refund struct {
+	ID         string    `json:"id"`
+	Amount     float64   `json:"amount"`
+	Reason     string    `json:"reason,omitempty"` // why it was refunded, e.g. a student dropped
+	Note       string    `json:"note,omitempty"`   // shown to the payer
+	RefundedAt time.Time `json:"refunded_at"`
+}
+
+// GetPaymentDetails returns the stored paypal_details of a submission
+func GetPaymentDetails(formType, formID string) (string, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return "", fmt.Errorf("unknown form type %s", formType)
+	}
+
+	var details sql.NullString
+	err := QueryRowDB(fmt.Sprintf(`SELECT paypal_details FROM %s WHERE form_id = ?`, table), formID).Scan(&details)
+	if err != nil {
+		return "", fmt.Errorf("failed to load payment details of %s: %w", formID, err)
+	}
+	return details.String, nil
+}
+
+// ListRefunds returns the refunds made against a submission, oldest first
+func ListRefunds(formID string) ([]Refund, error) {
+	rows, err := QueryDB(`
+		SELECT refund_id, amount, reason, note, refunded_at FROM refunds
+		WHERE form_id = ? ORDER BY refunded_at, id`, formID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refunds of %s: %w", formID, err)
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		var refund Refund
+		var refundedAt string
+		if err := rows.Scan(&refund.ID, &refund.Amount, &refund.Reason, &refund.Note, &refundedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan refund of %s: %w", formID, err)
+		}
+		if refund.RefundedAt, err = parseTime(refundedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse refund time of %s: %w", formID, err)
+		}
+		refunds = append(refunds, refund)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read refunds of %s: %w", formID, err)
+	}
+	return refunds, nil
+}
+
+// RecomputeNetAmount sets a refunded submission's net_amount from its current total,
+// for when the total changes after a refund. Submissions never refunded keep it unset.
+func RecomputeNetAmount(formType, formID string) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := ExecDB(fmt.Sprintf(`UPDATE %s SET net_amount = %s WHERE form_id = ? AND net_amount IS NOT NULL`,
+		table, netAmountExpr(table)), formID); err != nil {
+		return fmt.Errorf("failed to recompute net amount of %s: %w", formID, err)
+	}
+	return nil
+}
+
+// netAmountExpr computes a row's total less its refunds
+func netAmountExpr(table string) string {
+	return fmt.Sprintf(`ROUND(calculated_amount - COALESCE((SELECT SUM(amount) FROM refunds WHERE refunds.form_id = %s.form_id), 0), 2)`, table)
+}
+
+// RecordRefund saves refund against a submission, adding it to paypal_details and
+// recomputing net_amount, and returns the submission's new paypal_status with the total
+// refunded so far. As with refund webhooks, the order is only marked REFUNDED once
+// refunds cover its total; a partial refund leaves it paid.
+func RecordRefund(formType, formID string, refund Refund) (string, float64, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	conn := currentDB()
+	if conn == nil {
+		return "", 0, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to begin refund: %w", err)
+	}
+	defer tx.Rollback()
+
+	before, err := snapshotRow(ctx, tx, table, formID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var details, status sql.NullString
+	var amount float64
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT paypal_details, paypal_status, calculated_amount FROM %s WHERE form_id = ?`, table),
+		formID).Scan(&details, &status, &amount)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to load %s for refund: %w", formID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO refunds (form_id, refund_id, amount, reason, note, refunded_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		formID, refund.ID, refund.Amount, refund.Reason, refund.Note, formatTime(refund.RefundedAt)); err != nil {
+		return "", 0, fmt.Errorf("failed to record refund of %s: %w", formID, err)
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if details.String != "" {
+		if err := json.Unmarshal([]byte(details.String), &fields); err != nil {
+			return "", 0, fmt.Errorf("failed to parse payment details of %s: %w", formID, err)
+		}
+	}
+	var refunds []Refund
+	if raw, ok := fields["refunds"]; ok {
+		if err := json.Unmarshal(raw, &refunds); err != nil {
+			return "", 0, fmt.Errorf("failed to parse refunds of %s: %w", formID, err)
+		}
+	}
+	refunds = append(refunds, refund)
+	raw, err := json.Marshal(refunds)
+	if err != nil {
+		return "", 0, err
+	}
+	fields["refunds"] = raw
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var refunded float64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE form_id = ?`,
+		formID).Scan(&refunded); err != nil {
+		return "", 0, fmt.Errorf("failed to total refunds of %s: %w", formID, err)
+	}
+	newStatus := status.String
+	if refunded >= amount-0.005 {
+		newStatus = "REFUNDED"
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET paypal_details = ?, paypal_status = ?, net_amount = %s WHERE form_id = ?`,
+		table, netAmountExpr(table)), string(updated), newStatus, formID); err != nil {
+		return "", 0, fmt.Errorf("failed to record refund of %s: %w", formID, err)
+	}
+	if err := recordChanges(ctx, tx, table, formID, AuditRefund, ActorAdmin, before); err != nil {
+		return "", 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", 0, fmt.Errorf("failed to record refund of %s: %w", formID, err)
+	}
+	return newStatus, refunded, nil
+}
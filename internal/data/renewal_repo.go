@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Renewal is one yearly payment of an auto-renewing membership. The first cycle is
+// the payment made when the member signed up.
+type Renewal struct {
+	FormID         string
+	SubscriptionID string
+	Cycle          int
+	SaleID         string
+	Amount         float64
+	PaidAt         time.Time
+	CoversThrough  time.Time
+}
+
+// StartMembershipSubscription records the PayPal subscription a membership is paying
+// through. The subscription ID stands in for the order ID until it is activated.
+func StartMembershipSubscription(formID, subscriptionID string, createdAt time.Time) error {
+	_, err := ExecDB(`
+		UPDATE membership_submissions SET
+			auto_renew = 1, paypal_subscription_id = ?, subscription_status = 'APPROVAL_PENDING',
+			paypal_order_id = ?, paypal_order_created_at = ?
+		WHERE form_id = ?`, subscriptionID, subscriptionID, formatTime(createdAt), formID)
+	if err != nil {
+		return fmt.Errorf("failed to record subscription for %s: %w", formID, err)
+	}
+	return nil
+}
+
+// ClearMembershipSubscription forgets a subscription the member never approved, when
+// they choose to pay once instead
+func ClearMembershipSubscription(formID string) error {
+	_, err := ExecDB(`
+		UPDATE membership_submissions SET auto_renew = 0, paypal_subscription_id = '', subscription_status = ''
+		WHERE form_id = ? AND COALESCE(paypal_status, '') != 'COMPLETED'`, formID)
+	if err != nil {
+		return fmt.Errorf("failed to clear subscription of %s: %w", formID, err)
+	}
+	return nil
+}
+
+// MembershipSubscriptionID returns the PayPal subscription a membership pays through,
+// or "" for a one-time payment
+func MembershipSubscriptionID(formID string) (string, error) {
+	var subscriptionID sql.NullString
+	err := QueryRowDB(`SELECT paypal_subscription_id FROM membership_submissions WHERE form_id = ?`,
+		formID).Scan(&subscriptionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load subscription of %s: %w", formID, err)
+	}
+	return subscriptionID.String, nil
+}
+
+// UpdateSubscriptionStatus records a subscription's PayPal status (ACTIVE, SUSPENDED,
+// CANCELLED, EXPIRED), reporting whether a membership pays through it
+func UpdateSubscriptionStatus(subscriptionID, status string) (bool, error) {
+	result, err := ExecDB(`UPDATE membership_submissions SET subscription_status = ? WHERE paypal_subscription_id = ?`,
+		status, subscriptionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update subscription %s: %w", subscriptionID, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update subscription %s: %w", subscriptionID, err)
+	}
+	return rows > 0, nil
+}
+
+// RecordRenewal records a subscription payment as the membership's next cycle and
+// extends the membership a year from when it was paid. The returned renewal is nil
+// when the payment was already recorded; sql.ErrNoRows means no membership has the
+// subscription.
+func RecordRenewal(subscriptionID, saleID string, amount float64, paidAt time.Time) (*Renewal, error) {
+	conn := currentDB()
+	if conn == nil {
+		return nil, errDBNotInitialized
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin renewal: %w", err)
+	}
+	defer tx.Rollback()
+
+	renewal := &Renewal{
+		SubscriptionID: subscriptionID,
+		SaleID:         saleID,
+		Amount:         amount,
+		PaidAt:         paidAt,
+		CoversThrough:  paidAt.AddDate(1, 0, 0),
+	}
+	err = tx.QueryRowContext(ctx, `SELECT form_id FROM membership_submissions WHERE paypal_subscription_id = ?`,
+		subscriptionID).Scan(&renewal.FormID)
+	if err != nil {
+		return nil, fmt.Errorf("no membership for subscription %s: %w", subscriptionID, err)
+	}
+
+	var recorded int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM renewals WHERE sale_id = ?`, saleID).Scan(&recorded); err != nil {
+		return nil, fmt.Errorf("failed to check renewal %s: %w", saleID, err)
+	}
+	if recorded > 0 {
+		return nil, nil
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) + 1 FROM renewals WHERE form_id = ?`,
+		renewal.FormID).Scan(&renewal.Cycle); err != nil {
+		return nil, fmt.Errorf("failed to count renewals of %s: %w", renewal.FormID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO renewals (form_id, subscription_id, cycle, sale_id, amount, paid_at, covers_through)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		renewal.FormID, subscriptionID, renewal.Cycle, saleID, amount,
+		formatTime(paidAt), formatTime(renewal.CoversThrough)); err != nil {
+		return nil, fmt.Errorf("failed to record renewal of %s: %w", renewal.FormID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE membership_submissions SET renewed_through = ?, subscription_status = 'ACTIVE'
+		WHERE form_id = ?`, formatTime(renewal.CoversThrough), renewal.FormID); err != nil {
+		return nil, fmt.Errorf("failed to renew %s: %w", renewal.FormID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to record renewal of %s: %w", renewal.FormID, err)
+	}
+	return renewal, nil
+}
+
+// ListRenewals returns a membership's subscription payments, first cycle first
+func ListRenewals(formID string) ([]Renewal, error) {
+	rows, err := QueryDB(`
+		SELECT form_id, subscription_id, cycle, sale_id, amount, paid_at, covers_through
+		FROM renewals WHERE form_id = ? ORDER BY cycle`, formID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list renewals of %s: %w", formID, err)
+	}
+	defer rows.Close()
+
+	var renewals []Renewal
+	for rows.Next() {
+		var renewal Renewal
+		var paidAt, coversThrough string
+		if err := rows.Scan(&renewal.FormID, &renewal.SubscriptionID, &renewal.Cycle, &renewal.SaleID,
+			&renewal.Amount, &paidAt, &coversThrough); err != nil {
+			return nil, fmt.Errorf("failed to scan renewal of %s: %w", formID, err)
+		}
+		if renewal.PaidAt, err = parseTime(paidAt); err != nil {
+			return nil, fmt.Errorf("failed to parse renewal time of %s: %w", formID, err)
+		}
+		if renewal.CoversThrough, err = parseTime(coversThrough); err != nil {
+			return nil, fmt.Errorf("failed to parse renewal period of %s: %w", formID, err)
+		}
+		renewals = append(renewals, renewal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read renewals of %s: %w", formID, err)
+	}
+	return renewals, nil
+}
+
+// GetMembershipRenewal returns how long a membership is paid through and its
+// subscription's status; the time is nil until the first subscription payment
+func GetMembershipRenewal(formID string) (*time.Time, string, error) {
+	var renewedThrough, status sql.NullString
+	err := QueryRowDB(`SELECT renewed_through, subscription_status FROM membership_submissions WHERE form_id = ?`,
+		formID).Scan(&renewedThrough, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("no membership %s: %w", formID, err)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load renewal of %s: %w", formID, err)
+	}
+	through, err := parseNullableTime(renewedThrough)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse renewal of %s: %w", formID, err)
+	}
+	return through, status.String, nil
+}
@@ -0,0 +1,35 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetRoundUp returns the round-up donation a family added at checkout, or 0
+func GetRoundUp(formType, formID string) (float64, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return 0, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	var roundUp sql.NullFloat64
+	err := QueryRowDB(fmt.Sprintf(`SELECT round_up FROM %s WHERE form_id = ?`, table), formID).Scan(&roundUp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load round-up of %s: %w", formID, err)
+	}
+	return roundUp.Float64, nil
+}
+
+// SetRoundUp records the round-up donation included in a submission's total; 0 clears it
+func SetRoundUp(formType, formID string, amount float64) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	if _, err := auditedExec(currentDB(), table, formID, AuditPaymentUpdate, ActorFamily,
+		fmt.Sprintf(`UPDATE %s SET round_up = ? WHERE form_id = ?`, table), amount, formID); err != nil {
+		return fmt.Errorf("failed to record round-up of %s: %w", formID, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// =============================================================================
+// SCHEMA STATUS
+// =============================================================================
+
+// PendingSchemaMigrations lists the migrations CreateTables would still apply to the
+// global database
+func PendingSchemaMigrations() ([]string, error) {
+	conn := currentDB()
+	if conn == nil {
+		return nil, errDBNotInitialized
+	}
+	return PendingMigrations(conn)
+}
+
+// PendingMigrations lists the migrations Migrate would still apply to conn, oldest
+// first. A database from before migrations were numbered has every one pending; applying
+// them only adds what it lacks.
+func PendingMigrations(conn *sql.DB) ([]string, error) {
+	states, err := MigrationStatus(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%d %s", state.Version, state.Name))
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// =============================================================================
+// SUBMISSION SEARCH
+// =============================================================================
+
+// submissionSearchTableSchema creates the full-text index of every submission's name,
+// email, school and student names. Triggers on the submission tables keep it current,
+// anonymizing included, so it never holds details the tables no longer do.
+const submissionSearchTableSchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS submission_search USING fts5(
+		form_id UNINDEXED,
+		full_name,
+		email,
+		school,
+		students,
+		tokenize = 'unicode61 remove_diacritics 2'
+	);`
+
+// searchWeights weigh a match in each submission_search column for bm25, form_id
+// first: a name or a student's name says more than a school
+const searchWeights = "0, 10.0, 5.0, 1.0, 8.0"
+
+// searchedColumns are the columns whose changes are reindexed
+const searchedColumns = "full_name, email, school, students_json"
+
+// studentNamesSQL selects the names in a row's students_json, space separated
+const studentNamesSQL = `(SELECT COALESCE(group_concat(json_extract(value, '$.name'), ' '), '')
+			FROM json_each(CASE WHEN json_valid(pii_open(%[1]s.students_json)) THEN pii_open(%[1]s.students_json) ELSE '[]' END))`
+
+// searchRowSQL selects the submission_search row of a submission row. pii_terms opens
+// the sealed details and, while they're being encrypted, blinds the words it indexes.
+const searchRowSQL = `%[1]s.form_id, pii_terms(%[1]s.full_name), pii_terms(%[1]s.email), pii_terms(%[1]s.school),
+			pii_terms(` + studentNamesSQL + `)`
+
+// searchTriggerSchemas create the triggers keeping submission_search in step with each
+// submission table
+func searchTriggerSchemas() []string {
+	var schemas []string
+	for _, table := range searchTables() {
+		insert := fmt.Sprintf(`INSERT INTO submission_search (form_id, full_name, email, school, students)
+			VALUES (`+searchRowSQL+`);`, "NEW")
+		schemas = append(schemas,
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_search_insert AFTER INSERT ON %[1]s BEGIN
+			%[2]s
+		END`, table, insert),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_search_update AFTER UPDATE OF form_id, %[2]s ON %[1]s BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+			%[3]s
+		END`, table, searchedColumns, insert),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_search_delete AFTER DELETE ON %[1]s BEGIN
+			DELETE FROM submission_search WHERE form_id = OLD.form_id;
+		END`, table),
+		)
+	}
+	return schemas
+}
+
+// searchTables lists the submission tables in a fixed order, so the schema dump is stable
+func searchTables() []string {
+	tables := make([]string, 0, len(checkoutTables))
+	for _, table := range checkoutTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// migrateSubmissionSearch creates the search index and its triggers and indexes the
+// submissions already there. PostgreSQL has no FTS5; searches there match substrings.
+func migrateSubmissionSearch(conn *sql.DB, logf logFunc) error {
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		logf("Skipped the full-text search index, which needs SQLite")
+		return nil
+	}
+	if err := createTables(submissionSearchTableSchema)(conn, logf); err != nil {
+		return err
+	}
+	return migrateSearchTriggers(conn, logf)
+}
+
+// migrateSearchTriggers replaces the search triggers with the current ones and
+// reindexes every submission through them
+func migrateSearchTriggers(conn *sql.DB, logf logFunc) error {
+	if _, ok := dialectOf(conn).(sqliteDialect); !ok {
+		return nil
+	}
+	if err := createSearchTriggers(conn, logf); err != nil {
+		return err
+	}
+	return rebuildSearchIndex(conn, logf)
+}
+
+// createSearchTriggers creates the triggers of searchTriggerSchemas, replacing any an
+// earlier version created
+func createSearchTriggers(conn *sql.DB, logf logFunc) error {
+	if err := dropSearchTriggers(conn, logf); err != nil {
+		return err
+	}
+	for _, schema := range searchTriggerSchemas() {
+		if _, err := conn.Exec(schema); err != nil {
+			return fmt.Errorf("failed to create search trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+func dropSearchTriggers(conn *sql.DB, logf logFunc) error {
+	for _, table := range searchTables() {
+		for _, event := range []string{"insert", "update", "delete"} {
+			if _, err := conn.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s_search_%s", table, event)); err != nil {
+				return fmt.Errorf("failed to drop search trigger: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildSearchIndex indexes every submission afresh, as the triggers would
+func rebuildSearchIndex(conn *sql.DB, logf logFunc) error {
+	if _, err := conn.Exec("DELETE FROM submission_search"); err != nil {
+		return fmt.Errorf("failed to clear search index: %w", err)
+	}
+	for _, table := range searchTables() {
+		result, err := conn.Exec(fmt.Sprintf(`
+			INSERT INTO submission_search (form_id, full_name, email, school, students)
+			SELECT `+searchRowSQL+`
+			FROM %[1]s`, table))
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", table, err)
+		}
+		if indexed, _ := result.RowsAffected(); indexed > 0 {
+			logf("Indexed %d %s for search", indexed, table)
+		}
+	}
+	return nil
+}
+
+func rollbackSubmissionSearch(conn *sql.DB, logf logFunc) error {
+	if err := dropSearchTriggers(conn, logf); err != nil {
+		return err
+	}
+	return dropTables("submission_search")(conn, logf)
+}
+
+// SearchSubmissions returns the submissions of every form type whose name, email,
+// school or student names contain all the words of query, most relevant first. Words
+// match as prefixes, so "sm" finds Smith; at most limit are returned, all when zero.
+func SearchSubmissions(query string, limit int) ([]SubmissionSummary, error) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	pageLimit, _ := pageBounds(limit, 0)
+
+	conn, err := GetDB()
+	if err != nil {
+		return nil, err
+	}
+	var formIDs []string
+	if _, ok := dialectOf(conn).(sqliteDialect); ok {
+		formIDs, err = searchFullText(words, pageLimit)
+	} else {
+		formIDs, err = searchBySubstring(words, pageLimit)
+	}
+	if err != nil || len(formIDs) == 0 {
+		return nil, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(formIDs)), ", ")
+	args := make([]interface{}, len(formIDs))
+	for i, formID := range formIDs {
+		args[i] = formID
+	}
+	found, err := querySubmissionSummaries("form_id IN ("+placeholders+")", args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// The summaries come back by date; put them back in order of relevance
+	rank := make(map[string]int, len(formIDs))
+	for i, formID := range formIDs {
+		rank[formID] = i
+	}
+	sort.SliceStable(found, func(i, j int) bool { return rank[found[i].FormID] < rank[found[j].FormID] })
+	return found, nil
+}
+
+// searchFullText returns the form IDs matching every word in submission_search, best first
+func searchFullText(words []string, limit int64) ([]string, error) {
+	// Each word is a quoted prefix, so nothing typed is read as FTS5 syntax
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+	}
+	if keys := currentPIIKeys(); keys != nil {
+		// The index holds blinded prefixes of the words rather than the words
+		terms = terms[:0]
+		for _, word := range searchWords(strings.Join(words, " ")) {
+			terms = append(terms, `"`+blindTerm(keys, word)+`"`)
+		}
+		if len(terms) == 0 {
+			return nil, nil
+		}
+	}
+	return queryFormIDs(`
+		SELECT form_id FROM submission_search
+		WHERE submission_search MATCH ?
+		ORDER BY bm25(submission_search, `+searchWeights+`)
+		LIMIT ?`, strings.Join(terms, " "), limit)
+}
+
+// searchBySubstring returns the form IDs whose searched columns contain every word,
+// newest first, for databases without FTS5
+func searchBySubstring(words []string, limit int64) ([]string, error) {
+	var selects []string
+	var args []interface{}
+	for _, table := range searchTables() {
+		var where []string
+		for _, word := range words {
+			where = append(where, `LOWER(COALESCE(full_name, '') || ' ' || COALESCE(email, '') || ' ' ||
+				COALESCE(school, '') || ' ' || COALESCE(students_json, '')) LIKE ?`)
+			args = append(args, "%"+strings.ToLower(word)+"%")
+		}
+		selects = append(selects, fmt.Sprintf("SELECT form_id, submission_date FROM %s WHERE %s", table, strings.Join(where, " AND ")))
+	}
+	args = append(args, limit)
+	return queryFormIDs(`SELECT form_id FROM (`+strings.Join(selects, " UNION ALL ")+`) AS matches
+		ORDER BY submission_date DESC LIMIT ?`, args...)
+}
+
+func queryFormIDs(query string, args ...interface{}) ([]string, error) {
+	rows, err := QueryDB(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search submissions: %w", err)
+	}
+	defer rows.Close()
+
+	var formIDs []string
+	for rows.Next() {
+		var formID string
+		if err := rows.Scan(&formID); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		formIDs = append(formIDs, formID)
+	}
+	return formIDs, rows.Err()
+}
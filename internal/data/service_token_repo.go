@@ -0,0 +1,71 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// ServiceToken is an access token an outside API issued us, kept across restarts
+type ServiceToken struct {
+	Name      string // which API, such as paypal
+	Token     string // as sent in the Authorization header
+	IssuedFor string // the API and client it was issued for; it's no good for others
+	ExpiresAt time.Time
+}
+
+// SaveServiceToken stores token in place of any earlier one with its name. The token is
+// a credential, so it's sealed with the personal details key; without a key it isn't
+// stored at all and any earlier one is deleted, leaving a restart to fetch a new one.
+func SaveServiceToken(token ServiceToken) error {
+	sealed := sealPII(token.Token)
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		if _, err := ExecDB(`DELETE FROM service_tokens WHERE name = ?`, token.Name); err != nil {
+			return fmt.Errorf("failed to delete %s token: %w", token.Name, err)
+		}
+		return nil
+	}
+
+	if _, err := ExecDB(`
+		INSERT INTO service_tokens (name, token, issued_for, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			token = excluded.token, issued_for = excluded.issued_for,
+			expires_at = excluded.expires_at, updated_at = excluded.updated_at`,
+		token.Name, sealed, token.IssuedFor, formatTime(token.ExpiresAt), formatTime(clock.Now())); err != nil {
+		return fmt.Errorf("failed to save %s token: %w", token.Name, err)
+	}
+	return nil
+}
+
+// GetServiceToken loads the stored token with name, or nil when there is none. A token
+// stored in the clear before tokens were sealed is ignored, as one that can't be opened
+// with the current key is.
+func GetServiceToken(name string) (*ServiceToken, error) {
+	token := ServiceToken{Name: name}
+	var issuedFor sql.NullString
+	var sealed, expiresAt string
+	err := QueryRowDB(`SELECT token, issued_for, expires_at FROM service_tokens WHERE name = ?`, name).
+		Scan(&sealed, &issuedFor, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s token: %w", name, err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return nil, nil
+	}
+	if token.Token, err = openPII(sealed); err != nil {
+		return nil, fmt.Errorf("failed to open %s token: %w", name, err)
+	}
+	token.IssuedFor = issuedFor.String
+	if token.ExpiresAt, err = parseTime(expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to parse %s token expiry: %w", name, err)
+	}
+	return &token, nil
+}
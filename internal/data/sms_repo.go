@@ -0,0 +1,125 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SMSRecipient is a payer who opted in to a text message confirmation
+type SMSRecipient struct {
+	FormID           string
+	FormType         string
+	FirstName        string
+	Email            string // whose notification preferences apply
+	Phone            string // E.164, e.g. +15125550100
+	AccessToken      string // carried by the receipt link
+	Item             string // membership level or event name
+	CalculatedAmount float64
+	PayPalStatus     string
+	ConsentAt        time.Time
+	SentAt           *time.Time
+}
+
+// =============================================================================
+// SMS CONFIRMATION QUERIES
+// =============================================================================
+
+// GetSMSRecipient returns the opt-in for a submission, or nil when the payer did not
+// ask for a text message
+func GetSMSRecipient(formID string) (*SMSRecipient, error) {
+	formType, err := FormTypeFromID(formID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT first_name, email, sms_phone, access_token, %s, calculated_amount, paypal_status,
+			sms_consent_at, sms_confirmation_sent_at
+		FROM %s WHERE form_id = ? AND sms_consent_at IS NOT NULL AND sms_phone != ''`,
+		itemColumns[formType], checkoutTables[formType])
+
+	recipient := SMSRecipient{FormID: formID, FormType: formType}
+	var firstName, emailAddress, accessToken, item, status, sentAt sql.NullString
+	var consentAt string
+	err = QueryRowDB(query, formID).Scan(&firstName, &emailAddress, &recipient.Phone, &accessToken, &item,
+		&recipient.CalculatedAmount, &status, &consentAt, &sentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SMS opt-in for %s: %w", formID, err)
+	}
+	if err := openPIIFields(&firstName.String, &emailAddress.String, &recipient.Phone); err != nil {
+		return nil, fmt.Errorf("failed to read SMS opt-in for %s: %w", formID, err)
+	}
+
+	recipient.FirstName, recipient.Email = firstName.String, emailAddress.String
+	recipient.AccessToken = accessToken.String
+	recipient.Item, recipient.PayPalStatus = item.String, status.String
+	if recipient.ConsentAt, err = parseTime(consentAt); err != nil {
+		return nil, fmt.Errorf("failed to parse SMS consent time for %s: %w", formID, err)
+	}
+	if recipient.SentAt, err = parseNullableTime(sentAt); err != nil {
+		return nil, fmt.Errorf("failed to parse SMS sent time for %s: %w", formID, err)
+	}
+	return &recipient, nil
+}
+
+// =============================================================================
+// SMS CONFIRMATION UPDATES
+// =============================================================================
+
+// RecordSMSConsent stores the mobile number a payer agreed to receive a confirmation
+// text at, and when they agreed
+func RecordSMSConsent(formType, formID, phone string, consentAt time.Time) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %s SET sms_phone = ?, sms_consent_at = ? WHERE form_id = ?`, table)
+	if _, err := ExecDB(stmt, sealPII(phone), formatTime(consentAt), formID); err != nil {
+		return fmt.Errorf("failed to record SMS consent: %w", err)
+	}
+	return nil
+}
+
+// ClaimSMSConfirmation marks the confirmation text as sent, reporting false when it
+// already was, so retries and a second worker never text twice
+func ClaimSMSConfirmation(formType, formID string, sentAt time.Time) (bool, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return false, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET sms_confirmation_sent_at = ?
+		WHERE form_id = ? AND sms_confirmation_sent_at IS NULL`, table)
+	result, err := ExecDB(stmt, formatTime(sentAt), formID)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim SMS confirmation: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim SMS confirmation: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// ReleaseSMSConfirmation undoes a claim after the text failed to send, so a retry can
+// send it
+func ReleaseSMSConfirmation(formType, formID string) error {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %s SET sms_confirmation_sent_at = NULL WHERE form_id = ?`, table)
+	if _, err := ExecDB(stmt, formID); err != nil {
+		return fmt.Errorf("failed to release SMS confirmation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StaleOrder is an order created for an unpaid submission that never got a status,
+// typically because the family closed the PayPal window
+type StaleOrder struct {
+	FormType  string
+	FormID    string
+	OrderID   string
+	CreatedAt time.Time
+}
+
+// ListStaleOrders returns up to limit orders per form type created before the cutoff
+// whose submission is still unpaid, oldest first
+func ListStaleOrders(createdBefore time.Time, limit int) ([]StaleOrder, error) {
+	var stale []StaleOrder
+	for formType, table := range checkoutTables {
+		rows, err := QueryDB(fmt.Sprintf(`
+			SELECT form_id, paypal_order_id, paypal_order_created_at FROM %s
+			WHERE submitted = 0 AND COALESCE(paypal_status, '') = ''
+				AND COALESCE(paypal_order_id, '') != ''
+				AND paypal_order_created_at IS NOT NULL AND paypal_order_created_at < ?
+			ORDER BY paypal_order_created_at LIMIT ?`, table), formatTime(createdBefore), limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stale %s orders: %w", formType, err)
+		}
+
+		for rows.Next() {
+			order := StaleOrder{FormType: formType}
+			var createdAt sql.NullString
+			if err := rows.Scan(&order.FormID, &order.OrderID, &createdAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan stale order: %w", err)
+			}
+			if order.CreatedAt, err = parseTime(createdAt.String); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to parse order time for %s: %w", order.FormID, err)
+			}
+			stale = append(stale, order)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stale %s orders: %w", formType, err)
+		}
+	}
+	return stale, nil
+}
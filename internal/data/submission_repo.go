@@ -0,0 +1,379 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// itemColumns names the column that says what each form type was for; fundraisers
+// have no single item
+var itemColumns = map[string]string{
+	"membership": "membership",
+	"event":      "event",
+	"fundraiser": "''",
+}
+
+// submissionsViewSchema creates the submissions view: every submission table's rows
+// under the columns they share, with the form type each came from, so a query across
+// form types is one query. Writes still go to the tables.
+func submissionsViewSchema() string {
+	return submissionsViewOver("submissions", checkoutTables)
+}
+
+// submissionsViewOver creates a view like the submissions view over the tables of
+// each form type in tables
+func submissionsViewOver(view string, tables map[string]string) string {
+	formTypes := make([]string, 0, len(tables))
+	for formType := range tables {
+		formTypes = append(formTypes, formType)
+	}
+	sort.Strings(formTypes)
+
+	selects := make([]string, 0, len(formTypes))
+	for _, formType := range formTypes {
+		selects = append(selects, fmt.Sprintf(`
+		SELECT '%s' AS form_type, form_id, access_token, submission_date, full_name, email, school,
+			%s AS item, calculated_amount, COALESCE(net_amount, calculated_amount) AS net_amount,
+			COALESCE(round_up, 0) AS round_up, paypal_order_id, paypal_status,
+			COALESCE(funding_source, '') AS funding_source, COALESCE(payment_instrument, '') AS payment_instrument,
+			submitted, submitted_at, receipt_number, household_id, %s AS order_page_url
+		FROM %s`, formType, itemColumns[formType], orderPageColumns[formType], tables[formType]))
+	}
+	return "CREATE VIEW " + view + " AS" + strings.Join(selects, "\n\t\tUNION ALL")
+}
+
+// SubmissionSummary is one submission of any form type, as listed and exported by operators
+type SubmissionSummary struct {
+	FormType         string
+	FormID           string
+	AccessToken      string `json:"-"` // credential of the submission's links
+	SubmissionDate   time.Time
+	FullName         string
+	Email            string
+	School           string
+	Item             string // membership level or event name
+	CalculatedAmount float64
+	NetAmount        float64 // what was paid less refunds
+	RoundUp          float64 // the round-up donation included in CalculatedAmount
+	PayPalOrderID    string
+	PayPalStatus     string
+	FundingSource    string // paypal, venmo, card...
+	Instrument       string // the card that paid, for card and wallet payments
+	Submitted        bool
+	SubmittedAt      *time.Time
+	ReceiptNumber    string
+	Archived         bool // moved to the archive tables with its school year
+}
+
+// SubmissionFilter narrows ListSubmissions and the lists of each form type; zero values
+// match everything. Limit and Offset page through the matches newest first: Limit
+// keeps that many of the most recent, after skipping the Offset most recent.
+type SubmissionFilter struct {
+	FormType string    // membership, event or fundraiser; only ListSubmissions mixes them
+	Year     int       // year of the submission date
+	From     time.Time // submitted at or after
+	To       time.Time // submitted before
+	School   string
+	Status   string // "paid", "unpaid" (never paid or refunded), or a PayPal status such as REFUNDED
+	Search   string // case-insensitive substring of the name or email, or a receipt number
+	Funding  string // funding source, such as venmo
+	Limit    int
+	Offset   int
+}
+
+// latestDate bounds date ranges left open at the end
+var latestDate = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// dateRange returns the submission dates the filter covers, from inclusive and to
+// exclusive, in UTC as they're stored
+func (f SubmissionFilter) dateRange() (from, to time.Time) {
+	if f.Year != 0 {
+		from = time.Date(f.Year, 1, 1, 0, 0, 0, 0, time.UTC)
+		to = from.AddDate(1, 0, 0)
+	}
+	if f.From.After(from) {
+		from = f.From
+	}
+	if !f.To.IsZero() && (to.IsZero() || f.To.Before(to)) {
+		to = f.To
+	}
+	return dateBounds(from, to)
+}
+
+// dateBounds returns the range from inclusive to to exclusive as stored times compare,
+// with zero ends left open
+func dateBounds(from, to time.Time) (time.Time, time.Time) {
+	if to.IsZero() {
+		to = latestDate
+	}
+	return from.UTC(), to.UTC()
+}
+
+// status returns the status the filter asks for as the queries compare it: paid,
+// unpaid, a PayPal status, or empty for any
+func (f SubmissionFilter) status() string {
+	status := strings.ToLower(strings.TrimSpace(f.Status))
+	if status == "" || status == "paid" || status == "unpaid" {
+		return status
+	}
+	return strings.ToUpper(status)
+}
+
+// conditions returns the WHERE clause matching the filter, with its arguments
+func (f SubmissionFilter) conditions() (string, []interface{}) {
+	from, to := f.dateRange()
+	where := []string{"submission_date >= ? AND submission_date < ?"}
+	args := []interface{}{formatTime(from), formatTime(to)}
+
+	if f.School != "" {
+		where = append(where, "school = ?")
+		args = append(args, f.School)
+	}
+	switch status := f.status(); status {
+	case "":
+	case "paid":
+		where = append(where, "paypal_status = 'COMPLETED'")
+	case "unpaid":
+		// submitted only means the payment step was saved; the status says whether money moved
+		where = append(where, "COALESCE(paypal_status, '') NOT IN ('COMPLETED', 'REFUNDED', 'REVERSED', 'DISPUTED')")
+	default:
+		where = append(where, "paypal_status = ?")
+		args = append(args, status)
+	}
+	if f.Search != "" {
+		where = append(where, "(LOWER(pii_open(full_name)) LIKE ? OR LOWER(pii_open(email)) LIKE ? OR receipt_number = ?)")
+		args = append(args, f.searchPattern(), f.searchPattern(), strings.TrimSpace(f.Search))
+	}
+	if f.Funding != "" {
+		where = append(where, "funding_source = ?")
+		args = append(args, strings.ToLower(f.Funding))
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// searchPattern returns the LIKE pattern matching names and emails containing Search
+func (f SubmissionFilter) searchPattern() string {
+	return "%" + strings.ToLower(f.Search) + "%"
+}
+
+// page returns the LIMIT and OFFSET of the filter's page of newest-first rows
+func (f SubmissionFilter) page() (limit, offset int64) {
+	return pageBounds(f.Limit, f.Offset)
+}
+
+// pageBounds returns the LIMIT and OFFSET of a page, with no limit when limit is zero
+func pageBounds(limit, offset int) (int64, int64) {
+	if limit <= 0 {
+		return math.MaxInt64, int64(max(offset, 0))
+	}
+	return int64(limit), int64(max(offset, 0))
+}
+
+// FormTypeFromID returns the form type encoded in a form ID's prefix
+func FormTypeFromID(formID string) (string, error) {
+	formType, _, _ := strings.Cut(formID, "-")
+	if _, ok := checkoutTables[formType]; !ok {
+		return "", fmt.Errorf("form ID %q does not start with a known form type", formID)
+	}
+	return formType, nil
+}
+
+// =============================================================================
+// SUBMISSION QUERIES
+// =============================================================================
+
+// ListSubmissions returns submissions across form types, oldest first
+func ListSubmissions(filter SubmissionFilter) ([]SubmissionSummary, error) {
+	where, args := filter.conditions()
+	if filter.FormType != "" {
+		if _, ok := checkoutTables[filter.FormType]; !ok {
+			return nil, fmt.Errorf("unknown form type %s", filter.FormType)
+		}
+		where += " AND form_type = ?"
+		args = append(args, filter.FormType)
+	}
+	return querySubmissionSummaries(where, args, filter.Limit, filter.Offset)
+}
+
+// GetSubmissionSummary returns the summary of one submission, or sql.ErrNoRows
+func GetSubmissionSummary(formID string) (*SubmissionSummary, error) {
+	formType, err := FormTypeFromID(formID)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := querySubmissionSummaries("form_id = ?", []interface{}{formID}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no %s submission %s: %w", formType, formID, sql.ErrNoRows)
+	}
+	return &found[0], nil
+}
+
+// GetSubmissionByFormID loads formID's submission from the table of the form type its
+// prefix names
+func GetSubmissionByFormID(formID string) (Submission, error) {
+	formType, err := FormTypeFromID(formID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch formType {
+	case "membership":
+		return asSubmission(GetMembershipByID(formID))
+	case "event":
+		return asSubmission(GetEventByID(formID))
+	default:
+		return asSubmission(GetFundraiserByID(formID))
+	}
+}
+
+// asSubmission returns a loaded submission as a Submission, or a nil one with err, so
+// a failed load never hands back a non-nil interface holding a nil pointer
+func asSubmission[T Submission](sub T, err error) (Submission, error) {
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// querySubmissionSummaries returns the submissions matching where, oldest first. A
+// limit or offset pages through them newest first, keeping the limit most recent after
+// skipping the offset most recent.
+func querySubmissionSummaries(where string, args []interface{}, limit, offset int) ([]SubmissionSummary, error) {
+	return querySummaries("submissions", where, args, limit, offset)
+}
+
+// querySummaries is querySubmissionSummaries over view, submissions or archived_submissions
+func querySummaries(view, where string, args []interface{}, limit, offset int) ([]SubmissionSummary, error) {
+	paged := limit > 0 || offset > 0
+	order := "ORDER BY submission_date"
+	if paged {
+		order = "ORDER BY submission_date DESC LIMIT ? OFFSET ?"
+		pageLimit, pageOffset := pageBounds(limit, offset)
+		args = append(slices.Clip(args), pageLimit, pageOffset)
+	}
+	query := `
+		SELECT form_type, form_id, COALESCE(access_token, ''), submission_date, full_name, email, school, item,
+			calculated_amount, net_amount, round_up, paypal_order_id, paypal_status, funding_source,
+			payment_instrument, submitted, submitted_at, COALESCE(receipt_number, '')
+		FROM ` + view + ` WHERE ` + where + `
+		` + order
+
+	rows, err := QueryDB(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query submissions: %w", err)
+	}
+	defer rows.Close()
+
+	var submissions []SubmissionSummary
+	for rows.Next() {
+		var sub SubmissionSummary
+		var school, item, orderID, status, submittedAt sql.NullString
+		var submitted sql.NullBool
+		var submissionDate string
+
+		if err := rows.Scan(&sub.FormType, &sub.FormID, &sub.AccessToken, &submissionDate, &sub.FullName, &sub.Email, &school, &item,
+			&sub.CalculatedAmount, &sub.NetAmount, &sub.RoundUp, &orderID, &status, &sub.FundingSource, &sub.Instrument, &submitted, &submittedAt, &sub.ReceiptNumber); err != nil {
+			return nil, fmt.Errorf("failed to scan submission: %w", err)
+		}
+		if err := openPIIFields(&sub.FullName, &sub.Email); err != nil {
+			return nil, fmt.Errorf("failed to read submission %s: %w", sub.FormID, err)
+		}
+
+		sub.School, sub.Item, sub.Submitted = school.String, item.String, submitted.Bool
+		sub.Archived = view == archivedSubmissionsView
+		sub.PayPalOrderID, sub.PayPalStatus = orderID.String, status.String
+		if sub.SubmissionDate, err = parseTime(submissionDate); err != nil {
+			return nil, fmt.Errorf("failed to parse submission date for %s: %w", sub.FormID, err)
+		}
+		if sub.SubmittedAt, err = parseNullableTime(submittedAt); err != nil {
+			return nil, fmt.Errorf("failed to parse submitted time for %s: %w", sub.FormID, err)
+		}
+
+		submissions = append(submissions, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read submissions: %w", err)
+	}
+
+	if paged {
+		slices.Reverse(submissions)
+	}
+	return submissions, nil
+}
+
+// =============================================================================
+// SUBMISSION UPDATES
+// =============================================================================
+
+// MarkSubmissionPaid records a payment taken outside PayPal (cash, check) as completed,
+// reporting whether the submission was found unpaid. detailsJSON describes the payment
+// and is stored only when there are no PayPal details to preserve.
+func MarkSubmissionPaid(formType, formID, detailsJSON string, paidAt time.Time) (bool, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return false, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET
+			paypal_status = 'COMPLETED', submitted = 1, submitted_at = ?,
+			paypal_details = CASE
+				WHEN COALESCE(paypal_details, '') IN ('', 'null') THEN ?
+				ELSE paypal_details
+			END
+		WHERE form_id = ? AND COALESCE(paypal_status, '') != 'COMPLETED'`, table)
+
+	var rows int64
+	_, err := audited(currentDB(), table, formID, AuditMarkPaid, ActorAdmin, func(ctx context.Context, tx *sql.Tx) (sql.Result, error) {
+		result, err := tx.ExecContext(ctx, stmt, formatTime(paidAt), detailsJSON, formID)
+		if err != nil {
+			return nil, err
+		}
+		if rows, err = result.RowsAffected(); err != nil || rows == 0 {
+			return result, err
+		}
+		_, err = assignReceiptNumber(ctx, tx, table, formID, paidAt)
+		return result, err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark submission paid: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// SetPayPalOrder records the order a submission of any form type is paying through
+func SetPayPalOrder(formID, orderID string, createdAt *time.Time) error {
+	formType, err := FormTypeFromID(formID)
+	if err != nil {
+		return err
+	}
+
+	table := checkoutTables[formType]
+	stmt := fmt.Sprintf(`UPDATE %s SET paypal_order_id = ?, paypal_order_created_at = ? WHERE form_id = ?`, table)
+	if _, err := auditedExec(currentDB(), table, formID, AuditPayPalOrder, ActorFamily, stmt,
+		orderID, formatNullableTime(createdAt), formID); err != nil {
+		return fmt.Errorf("failed to record %s order of %s: %w", formType, formID, err)
+	}
+	return nil
+}
+
+// ListSubmissionsByEmail returns every submission made with emailAddress, whatever
+// its case, oldest first
+func ListSubmissionsByEmail(emailAddress string) ([]SubmissionSummary, error) {
+	contact := NormalizeContact(emailAddress)
+	if contact == "" {
+		return nil, nil
+	}
+
+	return querySubmissionSummaries("LOWER(TRIM(pii_open(email))) = ?", []interface{}{contact}, 0, 0)
+}
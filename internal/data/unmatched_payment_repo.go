@@ -0,0 +1,144 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UnmatchedPayment is a PayPal capture whose invoice ID matched no submission, such as
+// a payment made through a PayPal button outside checkout or for a deleted form. It
+// waits for an admin to link it to the submission it paid for.
+type UnmatchedPayment struct {
+	ID           int64      `json:"id"`
+	CaptureID    string     `json:"captureID"`
+	OrderID      string     `json:"orderID,omitempty"`
+	InvoiceID    string     `json:"invoiceID,omitempty"`
+	EventType    string     `json:"eventType,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Amount       float64    `json:"amount"`
+	Currency     string     `json:"currency,omitempty"`
+	Details      string     `json:"details,omitempty"` // the capture resource; only loaded for one payment
+	ReceivedAt   time.Time  `json:"receivedAt"`
+	LinkedFormID string     `json:"linkedFormID,omitempty"`
+	LinkedAt     *time.Time `json:"linkedAt,omitempty"`
+}
+
+// RecordUnmatchedPayment keeps a capture no submission claimed, reporting whether it is
+// new. PayPal redelivers webhooks, so a capture already recorded is left alone.
+func RecordUnmatchedPayment(payment UnmatchedPayment) (bool, error) {
+	result, err := ExecDB(`
+		INSERT INTO unmatched_payments
+			(capture_id, order_id, invoice_id, event_type, status, amount, currency, details, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (capture_id) DO NOTHING`,
+		payment.CaptureID, payment.OrderID, payment.InvoiceID, payment.EventType, payment.Status,
+		payment.Amount, payment.Currency, payment.Details, formatTime(payment.ReceivedAt))
+	if err != nil {
+		return false, fmt.Errorf("failed to record unmatched payment %s: %w", payment.CaptureID, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record unmatched payment %s: %w", payment.CaptureID, err)
+	}
+	return rows > 0, nil
+}
+
+// UnmatchedPaymentFilter narrows ListUnmatchedPayments; the zero value lists every
+// payment still waiting to be linked
+type UnmatchedPaymentFilter struct {
+	IncludeLinked bool      // also list payments already linked to a submission
+	From          time.Time // received at or after
+	To            time.Time // received before
+	Limit         int
+	Offset        int
+}
+
+// ListUnmatchedPayments returns the unmatched payments matching filter without their
+// details, newest first
+func ListUnmatchedPayments(filter UnmatchedPaymentFilter) ([]UnmatchedPayment, error) {
+	from, to := dateBounds(filter.From, filter.To)
+	limit, offset := pageBounds(filter.Limit, filter.Offset)
+	rows, err := QueryDB(`
+		SELECT id, capture_id, order_id, invoice_id, event_type, status, amount, currency, '',
+			received_at, linked_form_id, linked_at
+		FROM unmatched_payments
+		WHERE (? OR COALESCE(linked_form_id, '') = '') AND received_at >= ? AND received_at < ?
+		ORDER BY received_at DESC, id DESC
+		LIMIT ? OFFSET ?`, filter.IncludeLinked, formatTime(from), formatTime(to), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unmatched payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []UnmatchedPayment
+	for rows.Next() {
+		payment, err := scanUnmatchedPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read unmatched payments: %w", err)
+	}
+	return payments, nil
+}
+
+// GetUnmatchedPayment loads one unmatched payment with its details; sql.ErrNoRows means
+// there is none with the ID
+func GetUnmatchedPayment(id int64) (*UnmatchedPayment, error) {
+	row := QueryRowDB(`
+		SELECT id, capture_id, order_id, invoice_id, event_type, status, amount, currency, details,
+			received_at, linked_form_id, linked_at
+		FROM unmatched_payments WHERE id = ?`, id)
+	return scanUnmatchedPayment(row)
+}
+
+// LinkUnmatchedPayment marks an unmatched payment as paying for formID, reporting
+// whether it was still unlinked so two admins can't link it twice
+func LinkUnmatchedPayment(id int64, formID string, linkedAt time.Time) (bool, error) {
+	result, err := ExecDB(`
+		UPDATE unmatched_payments SET linked_form_id = ?, linked_at = ?
+		WHERE id = ? AND COALESCE(linked_form_id, '') = ''`, formID, formatTime(linkedAt), id)
+	if err != nil {
+		return false, fmt.Errorf("failed to link unmatched payment %d: %w", id, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to link unmatched payment %d: %w", id, err)
+	}
+	return rows > 0, nil
+}
+
+// UnlinkUnmatchedPayment undoes LinkUnmatchedPayment when the payment couldn't be
+// recorded on the submission
+func UnlinkUnmatchedPayment(id int64) error {
+	if _, err := ExecDB(`UPDATE unmatched_payments SET linked_form_id = '', linked_at = NULL WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to unlink unmatched payment %d: %w", id, err)
+	}
+	return nil
+}
+
+func scanUnmatchedPayment(row interface{ Scan(...interface{}) error }) (*UnmatchedPayment, error) {
+	var payment UnmatchedPayment
+	var orderID, invoiceID, eventType, status, currency, details, linkedFormID sql.NullString
+	var receivedAt string
+	var linkedAt sql.NullString
+	if err := row.Scan(&payment.ID, &payment.CaptureID, &orderID, &invoiceID, &eventType, &status,
+		&payment.Amount, &currency, &details, &receivedAt, &linkedFormID, &linkedAt); err != nil {
+		return nil, fmt.Errorf("failed to load unmatched payment: %w", err)
+	}
+	payment.OrderID, payment.InvoiceID, payment.EventType = orderID.String, invoiceID.String, eventType.String
+	payment.Status, payment.Currency, payment.Details = status.String, currency.String, details.String
+	payment.LinkedFormID = linkedFormID.String
+
+	var err error
+	if payment.ReceivedAt, err = parseTime(receivedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse unmatched payment time: %w", err)
+	}
+	if payment.LinkedAt, err = parseNullableTime(linkedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse unmatched payment link time: %w", err)
+	}
+	return &payment, nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// =============================================================================
+// PAYMENT STATE VERSIONS
+// =============================================================================
+
+// ErrVersionConflict is an update refused because the submission's payment state
+// changed after the caller read it, such as a webhook recording the payment while the
+// capture handler waited on PayPal. The caller reloads the submission and decides again
+// rather than writing over what changed.
+var ErrVersionConflict = errors.New("submission's payment changed since it was read")
+
+// paymentStateColumns are the fields that make up a submission's payment state. A
+// change to any of them moves its version on; other fields, such as the coupon or the
+// email flags, change without conflicting with anyone.
+var paymentStateColumns = map[string]bool{
+	"paypal_status":  true,
+	"paypal_details": true,
+	"submitted":      true,
+	"submitted_at":   true,
+}
+
+// GetSubmissionVersion returns the version of formID's payment state, to pass back with
+// an update that must not write over a change made in the meantime
+func GetSubmissionVersion(formID string) (int, error) {
+	formType, err := FormTypeFromID(formID)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = QueryRowDB(fmt.Sprintf(`SELECT COALESCE(version, 1) FROM %s WHERE form_id = ?`, checkoutTables[formType]),
+		formID).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the version of %s: %w", formID, err)
+	}
+	return version, nil
+}
+
+// versionOf is the version in a row's snapshot, or 0 for a table without versions
+func versionOf(snapshot rowSnapshot) int {
+	version, _ := strconv.Atoi(snapshot.values["version"].String)
+	return version
+}
+
+// checkVersion refuses an update expecting version when the row read in before has
+// moved on. An expected version of 0 is a caller that didn't read one and checks nothing.
+func checkVersion(before rowSnapshot, expected int) error {
+	if expected > 0 && versionOf(before) != expected {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// versionConflict reports an update made only if its row was still at version as a
+// conflict when it matched no row
+func versionConflict(result sql.Result, version int) error {
+	if version == 0 {
+		return nil
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// bumpVersion moves formID's version on when changed holds part of its payment state.
+// It only moves on from the version in before, so a second writer that read the same
+// version fails here instead of committing over the first.
+func bumpVersion(ctx context.Context, tx *sql.Tx, table, formID string, before rowSnapshot, changed []string) error {
+	version := versionOf(before)
+	if version == 0 {
+		return nil // donations have no versions
+	}
+	for _, column := range changed {
+		if !paymentStateColumns[column] {
+			continue
+		}
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET version = version + 1 WHERE form_id = ? AND version = ?`, table),
+			formID, version)
+		if err != nil {
+			return fmt.Errorf("failed to move the version of %s on: %w", formID, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+	return nil
+}
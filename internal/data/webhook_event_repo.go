@@ -0,0 +1,169 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// What processing a webhook event did
+const (
+	WebhookReceived  = "received"  // recorded, not processed yet, or processing never finished
+	WebhookProcessed = "processed" // applied to the submission, donation or subscription it names
+	WebhookIgnored   = "ignored"   // nothing to apply, such as an event without a resource
+	WebhookUnmatched = "unmatched" // names no submission we know
+	WebhookFailed    = "failed"    // couldn't be applied; a redelivery or replay tries again
+)
+
+// WebhookEvent is a verified PayPal webhook as it arrived and what processing it did
+type WebhookEvent struct {
+	ID             int64      `json:"id"`
+	EventID        string     `json:"eventID,omitempty"` // PayPal's WH- ID, unique across redeliveries
+	TransmissionID string     `json:"transmissionID,omitempty"`
+	EventType      string     `json:"eventType,omitempty"`
+	FormID         string     `json:"formID,omitempty"`
+	Payload        string     `json:"payload,omitempty"` // the whole event; only loaded for one event
+	Result         string     `json:"result"`
+	Error          string     `json:"error,omitempty"`
+	Deliveries     int        `json:"deliveries"` // times PayPal sent it
+	Replays        int        `json:"replays"`    // times an admin ran it again
+	ReceivedAt     time.Time  `json:"receivedAt"`
+	ProcessedAt    *time.Time `json:"processedAt,omitempty"`
+}
+
+// Done reports whether the event needs no more processing: a redelivery of it is skipped
+func (e *WebhookEvent) Done() bool {
+	return e.Result != WebhookReceived && e.Result != WebhookFailed
+}
+
+// RecordWebhookEvent keeps a webhook before it is processed, reporting whether it is
+// new. A redelivery of an event already recorded counts another delivery and returns
+// the earlier record, so the caller can skip one already processed.
+func RecordWebhookEvent(event WebhookEvent) (*WebhookEvent, bool, error) {
+	event.Result, event.Deliveries = WebhookReceived, 1
+	eventID := sql.NullString{String: event.EventID, Valid: event.EventID != ""} // events without one are never deduplicated
+	err := QueryRowDB(`
+		INSERT INTO webhook_events (event_id, transmission_id, event_type, form_id, payload, result, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (event_id) DO NOTHING
+		RETURNING id`,
+		eventID, event.TransmissionID, event.EventType, event.FormID, event.Payload, event.Result,
+		formatTime(event.ReceivedAt)).Scan(&event.ID)
+	if err == nil {
+		return &event, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to record webhook event %s: %w", event.EventID, err)
+	}
+
+	if _, err := ExecDB(`UPDATE webhook_events SET deliveries = deliveries + 1 WHERE event_id = ?`, event.EventID); err != nil {
+		return nil, false, fmt.Errorf("failed to count redelivery of webhook event %s: %w", event.EventID, err)
+	}
+	earlier, err := scanWebhookEvent(QueryRowDB(`
+		SELECT id, event_id, transmission_id, event_type, form_id, payload, result, error_message,
+			deliveries, replays, received_at, processed_at
+		FROM webhook_events WHERE event_id = ?`, event.EventID))
+	if err != nil {
+		return nil, false, err
+	}
+	return earlier, false, nil
+}
+
+// FinishWebhookEvent records what processing the webhook event id did, counting a
+// replay when an admin asked for it
+func FinishWebhookEvent(id int64, result string, processErr error, replay bool) error {
+	message := ""
+	if processErr != nil {
+		message = processErr.Error()
+	}
+	replays := 0
+	if replay {
+		replays = 1
+	}
+	if _, err := ExecDB(`
+		UPDATE webhook_events SET result = ?, error_message = ?, processed_at = ?, replays = replays + ?
+		WHERE id = ?`, result, message, formatTime(clock.Now()), replays, id); err != nil {
+		return fmt.Errorf("failed to record the result of webhook event %d: %w", id, err)
+	}
+	return nil
+}
+
+// WebhookEventFilter narrows ListWebhookEvents; the zero value lists every event
+type WebhookEventFilter struct {
+	EventType string
+	FormID    string
+	Result    string
+	From      time.Time // received at or after
+	To        time.Time // received before
+	Limit     int
+	Offset    int
+}
+
+// ListWebhookEvents returns the webhook events matching filter without their payloads,
+// newest first
+func ListWebhookEvents(filter WebhookEventFilter) ([]WebhookEvent, error) {
+	from, to := dateBounds(filter.From, filter.To)
+	limit, offset := pageBounds(filter.Limit, filter.Offset)
+	rows, err := QueryDB(`
+		SELECT id, event_id, transmission_id, event_type, form_id, '', result, error_message,
+			deliveries, replays, received_at, processed_at
+		FROM webhook_events
+		WHERE (? = '' OR event_type = ?) AND (? = '' OR form_id = ?) AND (? = '' OR result = ?)
+			AND received_at >= ? AND received_at < ?
+		ORDER BY received_at DESC, id DESC
+		LIMIT ? OFFSET ?`,
+		filter.EventType, filter.EventType, filter.FormID, filter.FormID, filter.Result, filter.Result,
+		formatTime(from), formatTime(to), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []WebhookEvent
+	for rows.Next() {
+		event, err := scanWebhookEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook events: %w", err)
+	}
+	return events, nil
+}
+
+// GetWebhookEvent loads one webhook event with its payload; sql.ErrNoRows means there
+// is none with the ID
+func GetWebhookEvent(id int64) (*WebhookEvent, error) {
+	row := QueryRowDB(`
+		SELECT id, event_id, transmission_id, event_type, form_id, payload, result, error_message,
+			deliveries, replays, received_at, processed_at
+		FROM webhook_events WHERE id = ?`, id)
+	return scanWebhookEvent(row)
+}
+
+func scanWebhookEvent(row interface{ Scan(...interface{}) error }) (*WebhookEvent, error) {
+	var event WebhookEvent
+	var eventID, transmissionID, eventType, formID, message sql.NullString
+	var receivedAt string
+	var processedAt sql.NullString
+	if err := row.Scan(&event.ID, &eventID, &transmissionID, &eventType, &formID, &event.Payload, &event.Result,
+		&message, &event.Deliveries, &event.Replays, &receivedAt, &processedAt); err != nil {
+		return nil, fmt.Errorf("failed to load webhook event: %w", err)
+	}
+	event.EventID, event.TransmissionID, event.EventType = eventID.String, transmissionID.String, eventType.String
+	event.FormID, event.Error = formID.String, message.String
+
+	var err error
+	if event.ReceivedAt, err = parseTime(receivedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook event time: %w", err)
+	}
+	if event.ProcessedAt, err = parseNullableTime(processedAt); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook event processing time: %w", err)
+	}
+	return &event, nil
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"sbcbackend/internal/clock"
+)
+
+// ApplyPayPalWebhook records a verified PayPal webhook against a submission and moves
+// its paypal_status, reporting whether a submission matched. The status is left alone when:
+//   - status is empty (events we only record, such as dispute updates)
+//   - a refund covers less than the order total (a partial refund leaves the order paid)
+//   - a dispute opens on an order that isn't COMPLETED (one already refunded stays so)
+//   - a late capture webhook arrives after the order was refunded, reversed or disputed
+func ApplyPayPalWebhook(formType, formID, status, webhookJSON string, refundedTotal float64, actor Actor) (bool, error) {
+	table, ok := checkoutTables[formType]
+	if !ok {
+		return false, fmt.Errorf("unknown form type %s", formType)
+	}
+
+	stmt := fmt.Sprintf(`
+		UPDATE %s SET
+			paypal_status = CASE
+				WHEN ? = '' THEN paypal_status
+				WHEN ? = 'REFUNDED' AND ? > 0 AND ? < calculated_amount - 0.005 THEN paypal_status
+				WHEN ? = 'DISPUTED' AND COALESCE(paypal_status, '') <> 'COMPLETED' THEN paypal_status
+				WHEN ? = 'COMPLETED' AND paypal_status IN ('REFUNDED', 'REVERSED', 'DISPUTED') THEN paypal_status
+				ELSE ?
+			END,
+			paypal_webhook = ?
+		WHERE form_id = ?`, table)
+
+	var rows int64
+	_, err := audited(currentDB(), table, formID, AuditWebhook, actor, func(ctx context.Context, tx *sql.Tx) (sql.Result, error) {
+		result, err := tx.ExecContext(ctx, stmt,
+			status,
+			status, refundedTotal, refundedTotal,
+			status,
+			status,
+			status,
+			webhookJSON, formID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if rows, err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+		// A capture completed only by webhook still needs its receipt number
+		if rows > 0 && status == "COMPLETED" {
+			_, err = assignReceiptNumber(ctx, tx, table, formID, clock.Now())
+		}
+		return result, err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to apply PayPal webhook: %w", err)
+	}
+	return rows > 0, nil
+}
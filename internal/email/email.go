@@ -8,8 +8,11 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/logger"
 )
@@ -41,6 +44,17 @@ func LoadEmailConfig() EmailConfig {
 	}
 }
 
+// formatCurrency shows an amount in the currency with ISO code, or in the
+// deployment's when code is empty
+func formatCurrency(amount float64, code string) string {
+	if code == "" {
+		code = config.Currency()
+	}
+	return currency.Format(amount, code)
+}
+
+var templateFuncs = template.FuncMap{"formatCurrency": formatCurrency}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -61,8 +75,10 @@ type MembershipConfirmationData struct {
 	Fees             map[string]int
 	Donation         float64
 	CalculatedAmount float64
+	Currency         string // ISO 4217 code the amounts are in; empty for the deployment's
 	CoverFees        bool
 	PayPalOrderID    string
+	ReceiptNumber    string
 	SubmittedAt      *time.Time
 	Year             int
 }
@@ -80,8 +96,10 @@ type FundraiserConfirmationData struct {
 	DonationItems    []data.StudentDonation
 	TotalAmount      float64
 	CalculatedAmount float64
+	Currency         string // ISO 4217 code the amounts are in; empty for the deployment's
 	CoverFees        bool
 	PayPalOrderID    string
+	ReceiptNumber    string
 	SubmittedAt      *time.Time
 	Year             int
 }
@@ -106,11 +124,12 @@ Thank you for your membership submission! We have successfully received your pay
 {{end}}
 {{end}}
 {{if gt .Donation 0.0}}
-**Donation:** ${{printf "%.2f" .Donation}}
+**Donation:** {{formatCurrency .Donation .Currency}}
 {{end}}
 
-**Total Amount:** ${{printf "%.2f" .CalculatedAmount}}
-**Payment ID:** {{.PayPalOrderID}}
+**Total Amount:** {{formatCurrency .CalculatedAmount .Currency}}
+{{if .ReceiptNumber}}**Receipt Number:** {{.ReceiptNumber}}
+{{end}}**Payment ID:** {{.PayPalOrderID}}
 **Submitted:** {{.SubmittedAt.Format "January 2, 2006 at 3:04 PM"}}
 
 If you have any questions, please don't hesitate to contact us.
@@ -135,13 +154,14 @@ Thank you for your Practice-a-thon donation to the HEBISD Suzuki Booster Club fo
 {{end}}{{end}}
 {{if .DonationItems}}
 - Donations:
-{{range .DonationItems}}  • {{.StudentName}}: ${{printf "%.2f" .Amount}}
+{{range .DonationItems}}  • {{.StudentName}}: {{formatCurrency .Amount $.Currency}}
 {{end}}{{end}}
-**Total Amount:** ${{printf "%.2f" .TotalAmount}}
+**Total Amount:** {{formatCurrency .TotalAmount .Currency}}
 {{if .CoverFees}}
 You generously covered the transaction fees—thank you!
 {{end}}
-**Payment ID:** {{.PayPalOrderID}}
+{{if .ReceiptNumber}}**Receipt Number:** {{.ReceiptNumber}}
+{{end}}**Payment ID:** {{.PayPalOrderID}}
 **Submitted:** {{if .SubmittedAt}}{{.SubmittedAt.Format "January 2, 2006 at 3:04 PM"}}{{end}}
 
 If you have any questions, please contact us.
@@ -150,13 +170,277 @@ Best regards,
 The Booster Club Team
 `
 
-// SendMembershipConfirmation sends a confirmation email for a membership submission
-func SendMembershipConfirmation(config EmailConfig, data MembershipConfirmationData) error {
+// CheckoutReminderData holds data for abandoned-checkout reminder emails
+type CheckoutReminderData struct {
+	FormID      string
+	FormType    string
+	FirstName   string
+	Email       string
+	CheckoutURL string
+}
+
+// RenderCheckoutReminder builds the subject and body of a checkout reminder
+func RenderCheckoutReminder(data CheckoutReminderData) (string, string) {
+	greeting := data.FirstName
+	if greeting == "" {
+		greeting = "friend"
+	}
+
+	subject := fmt.Sprintf("Finish your %s checkout", data.FormType)
+
+	// Built with Sprintf rather than html/template so the link's query string isn't escaped
+	body := fmt.Sprintf(`Dear %s,
+
+We noticed you started a %s form with the Booster Club but didn't finish checking out.
+
+You can pick up where you left off here:
+%s
+
+This link is just for you, so please don't share it. If you've decided not to continue, you can ignore this email.
+
+Best regards,
+The Booster Club Team
+`,
+		greeting,
+		data.FormType,
+		data.CheckoutURL,
+	)
+	return subject, body
+}
+
+// SendCheckoutReminder sends a one-time reminder with a fresh checkout link
+func SendCheckoutReminder(config EmailConfig, data CheckoutReminderData) error {
 	if !config.SendConfirmations {
-		logger.LogInfo("Confirmation emails disabled, skipping email for %s", data.FormID)
+		logger.LogInfo("Confirmation emails disabled, skipping checkout reminder for %s", data.FormID)
 		return nil
 	}
 
+	subject, body := RenderCheckoutReminder(data)
+
+	logger.LogInfo("Sending checkout reminder to %s for form %s", data.Email, data.FormID)
+	if err := SendMail(data.Email, config.ConfirmationSender, subject, body); err != nil {
+		return fmt.Errorf("failed to send checkout reminder: %w", err)
+	}
+	return nil
+}
+
+// InstallmentReminderData holds data for the email reminding a family of a coming
+// installment of their registration
+type InstallmentReminderData struct {
+	FormID     string
+	FirstName  string
+	Email      string
+	Number     int
+	Count      int
+	Amount     float64
+	Currency   string // empty for the deployment's
+	DueAt      time.Time
+	PaymentURL string
+}
+
+// RenderInstallmentReminder builds the subject and body of an installment reminder
+func RenderInstallmentReminder(data InstallmentReminderData) (string, string) {
+	greeting := data.FirstName
+	if greeting == "" {
+		greeting = "friend"
+	}
+
+	subject := fmt.Sprintf("Installment %d of %d is due %s", data.Number, data.Count, data.DueAt.Format("January 2"))
+
+	// Built with Sprintf rather than html/template so the link's query string isn't escaped
+	body := fmt.Sprintf(`Dear %s,
+
+This is a reminder that installment %d of %d for your registration, %s, is due on %s.
+
+You can pay it here:
+%s
+
+This link is just for you, so please don't share it. Thank you for your support!
+
+Best regards,
+The Booster Club Team
+`,
+		greeting,
+		data.Number, data.Count, formatCurrency(data.Amount, data.Currency), data.DueAt.Format("January 2, 2006"),
+		data.PaymentURL,
+	)
+	return subject, body
+}
+
+// PrivacyVerificationData holds data for the email that verifies a data request
+type PrivacyVerificationData struct {
+	Email     string
+	LinkURL   string
+	ExpiresAt time.Time
+}
+
+// RenderPrivacyVerification builds the subject and body of the email that lets a
+// parent open their data request
+func RenderPrivacyVerification(data PrivacyVerificationData) (string, string) {
+	subject := "Your Booster Club data request"
+
+	body := fmt.Sprintf(`Hello,
+
+Someone asked to see or delete the information the Booster Club holds for %s.
+
+If that was you, open this link to download a copy of your submissions or ask us to delete them:
+%s
+
+The link works until %s. It is just for you, so please don't share it. If you didn't ask for this, you can ignore this email; nothing will change.
+
+Best regards,
+The Booster Club Team
+`,
+		data.Email,
+		data.LinkURL,
+		data.ExpiresAt.Format("January 2, 2006 at 3:04 PM"),
+	)
+	return subject, body
+}
+
+// HouseholdSignInData holds data for the email with a family's sign-in link
+type HouseholdSignInData struct {
+	Email     string
+	LinkURL   string
+	ExpiresAt time.Time
+}
+
+// RenderHouseholdSignIn builds the subject and body of the email that lets a family
+// see their orders and prefill new forms
+func RenderHouseholdSignIn(data HouseholdSignInData) (string, string) {
+	subject := "Your Booster Club orders"
+
+	body := fmt.Sprintf(`Hello,
+
+Here is your link to the memberships, event orders and fundraiser donations made with %s:
+%s
+
+From there you can open your receipts and event order pages, and fill in new forms with your family's details.
+
+The link works until %s. It is just for you, so please don't share it. If you didn't ask for this, you can ignore this email.
+
+Best regards,
+The Booster Club Team
+`,
+		data.Email,
+		data.LinkURL,
+		data.ExpiresAt.Format("January 2, 2006 at 3:04 PM"),
+	)
+	return subject, body
+}
+
+// PrivacyReviewData holds data for the admin email about a deletion that needs review
+type PrivacyReviewData struct {
+	RequestID   int64
+	Email       string
+	Submissions []string // one line per paid submission kept until the review
+	Anonymized  int      // submissions without payments, already anonymized
+}
+
+// RenderPrivacyReview builds the subject and body of the email asking an admin to
+// review a deletion of submissions with payment records
+func RenderPrivacyReview(data PrivacyReviewData) (string, string) {
+	subject := fmt.Sprintf("Deletion request #%d needs review", data.RequestID)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s asked for their data to be deleted.\n\n", data.Email)
+	if data.Anonymized > 0 {
+		fmt.Fprintf(&b, "%d submission(s) without payments were anonymized right away.\n\n", data.Anonymized)
+	}
+	b.WriteString("These submissions have payment records and wait for your review:\n")
+	for _, line := range data.Submissions {
+		fmt.Fprintf(&b, "- %s\n", line)
+	}
+	fmt.Fprintf(&b, `
+Approving anonymizes them, keeping amounts, dates, receipt numbers and PayPal IDs for the books:
+  boosterctl privacy approve %d
+
+Rejecting keeps them, for example while a refund or dispute is open:
+  boosterctl privacy reject %d --note "reason"
+`, data.RequestID, data.RequestID)
+	return subject, b.String()
+}
+
+// PrivacyOutcomeData holds data for the email telling a parent how their deletion
+// request was decided
+type PrivacyOutcomeData struct {
+	Email    string
+	Approved bool
+	Note     string // the admin's reason, for a rejection
+}
+
+// RenderPrivacyOutcome builds the subject and body of the email telling a parent
+// whether their submissions were deleted
+func RenderPrivacyOutcome(data PrivacyOutcomeData) (string, string) {
+	if data.Approved {
+		return "Your Booster Club data was deleted", `Hello,
+
+As you asked, we removed your name, contact details and your students' details from your Booster Club submissions.
+
+We keep the amount, date and receipt number of each payment, without anything that identifies you, because our bookkeeping requires it.
+
+Best regards,
+The Booster Club Team
+`
+	}
+
+	reason := ""
+	if data.Note != "" {
+		reason = fmt.Sprintf("\nThe reason given was: %s\n", data.Note)
+	}
+	return "About your Booster Club data request", fmt.Sprintf(`Hello,
+
+We weren't able to delete the submissions with payments made under %s yet.
+%s
+Please reply to this email if you have questions, or ask again later.
+
+Best regards,
+The Booster Club Team
+`, data.Email, reason)
+}
+
+// DonationThankYouData holds data for the email thanking a quick donor
+type DonationThankYouData struct {
+	FormID        string
+	Email         string
+	Note          string
+	Amount        float64
+	Currency      string // ISO 4217 code Amount is in; empty for the deployment's
+	PayPalOrderID string
+	ReceiptNumber string
+	CapturedAt    *time.Time
+}
+
+// RenderDonationThankYou builds the subject and body of the email thanking a donor
+// for a quick donation to the general fund
+func RenderDonationThankYou(data DonationThankYouData) (string, string) {
+	subject := "Thank you for your donation"
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Hello,
+
+Thank you for your donation of %s to the HEBISD Suzuki Booster Club's general fund! Gifts like yours keep our programs going.
+
+`, formatCurrency(data.Amount, data.Currency))
+	if data.Note != "" {
+		fmt.Fprintf(&b, "Your note: %s\n\n", data.Note)
+	}
+	b.WriteString(receiptLine(data.ReceiptNumber))
+	fmt.Fprintf(&b, "Payment ID: %s\n", data.PayPalOrderID)
+	if data.CapturedAt != nil {
+		fmt.Fprintf(&b, "Date: %s\n", data.CapturedAt.Format("January 2, 2006"))
+	}
+	b.WriteString(`
+Please keep this email for your records. If you have any questions, just reply to it.
+
+Best regards,
+The Booster Club Team
+`)
+	return subject, b.String()
+}
+
+// RenderMembershipConfirmation builds the subject and body of a membership confirmation
+func RenderMembershipConfirmation(data MembershipConfirmationData) (string, string, error) {
 	// Add student count for template
 	templateData := struct {
 		MembershipConfirmationData
@@ -166,25 +450,41 @@ func SendMembershipConfirmation(config EmailConfig, data MembershipConfirmationD
 		StudentCount:               len(data.Students),
 	}
 
-	tmpl, err := template.New("confirmation").Parse(confirmationTemplate)
+	tmpl, err := template.New("confirmation").Funcs(templateFuncs).Parse(confirmationTemplate)
 	if err != nil {
-		return fmt.Errorf("failed to parse confirmation template: %w", err)
+		return "", "", fmt.Errorf("failed to parse confirmation template: %w", err)
 	}
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, templateData); err != nil {
-		return fmt.Errorf("failed to execute confirmation template: %w", err)
+		return "", "", fmt.Errorf("failed to execute confirmation template: %w", err)
 	}
+	return splitSubject(buf.String())
+}
 
-	// Extract subject from template output
-	content := buf.String()
+// splitSubject separates the "Subject: " line a template starts with from the body
+func splitSubject(content string) (string, string, error) {
 	lines := strings.Split(content, "\n")
 	if len(lines) < 2 || !strings.HasPrefix(lines[0], "Subject: ") {
-		return fmt.Errorf("invalid template format: missing subject line")
+		return "", "", fmt.Errorf("invalid template format: missing subject line")
 	}
 
 	subject := strings.TrimPrefix(lines[0], "Subject: ")
 	body := strings.Join(lines[2:], "\n") // Skip subject and empty line
+	return subject, body, nil
+}
+
+// SendMembershipConfirmation sends a confirmation email for a membership submission
+func SendMembershipConfirmation(config EmailConfig, data MembershipConfirmationData) error {
+	if !config.SendConfirmations {
+		logger.LogInfo("Confirmation emails disabled, skipping email for %s", data.FormID)
+		return nil
+	}
+
+	subject, body, err := RenderMembershipConfirmation(data)
+	if err != nil {
+		return err
+	}
 
 	logger.LogInfo("Sending confirmation email to %s for form %s", data.Email, data.FormID)
 
@@ -197,31 +497,31 @@ func SendMembershipConfirmation(config EmailConfig, data MembershipConfirmationD
 	return nil
 }
 
-// SendFundraiserConfirmation sends a confirmation email for a fundraiser submission
-func SendFundraiserConfirmation(config EmailConfig, data FundraiserConfirmationData) error {
-	if !config.SendConfirmations {
-		logger.LogInfo("Fundraiser confirmation emails disabled, skipping email for %s", data.FormID)
-		return nil
-	}
-
-	tmpl, err := template.New("fundraiserConfirmation").Parse(fundraiserConfirmationTemplate)
+// RenderFundraiserConfirmation builds the subject and body of a fundraiser confirmation
+func RenderFundraiserConfirmation(data FundraiserConfirmationData) (string, string, error) {
+	tmpl, err := template.New("fundraiserConfirmation").Funcs(templateFuncs).Parse(fundraiserConfirmationTemplate)
 	if err != nil {
-		return fmt.Errorf("failed to parse fundraiser confirmation template: %w", err)
+		return "", "", fmt.Errorf("failed to parse fundraiser confirmation template: %w", err)
 	}
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		return fmt.Errorf("failed to execute fundraiser confirmation template: %w", err)
+		return "", "", fmt.Errorf("failed to execute fundraiser confirmation template: %w", err)
 	}
+	return splitSubject(buf.String())
+}
 
-	content := buf.String()
-	lines := strings.Split(content, "\n")
-	if len(lines) < 2 || !strings.HasPrefix(lines[0], "Subject: ") {
-		return fmt.Errorf("invalid template format: missing subject line")
+// SendFundraiserConfirmation sends a confirmation email for a fundraiser submission
+func SendFundraiserConfirmation(config EmailConfig, data FundraiserConfirmationData) error {
+	if !config.SendConfirmations {
+		logger.LogInfo("Fundraiser confirmation emails disabled, skipping email for %s", data.FormID)
+		return nil
 	}
 
-	subject := strings.TrimPrefix(lines[0], "Subject: ")
-	body := strings.Join(lines[2:], "\n") // Skip subject and empty line
+	subject, body, err := RenderFundraiserConfirmation(data)
+	if err != nil {
+		return err
+	}
 
 	logger.LogInfo("Sending fundraiser confirmation email to %s for form %s", data.Email, data.FormID)
 
@@ -240,8 +540,33 @@ func SendAlertEmail(subject, body string) error {
 	return SendMail(config.AlertRecipient, config.AlertSender, subject, body)
 }
 
+// Sender delivers one plain-text email
+type Sender func(to, from, subject, body string) error
+
+var (
+	senderOverride Sender
+	senderMu       sync.RWMutex
+)
+
+// SetSender routes all outgoing mail through sender instead of sendmail, returning the
+// previous override. Tests use it to capture emails; nil restores normal delivery.
+func SetSender(sender Sender) Sender {
+	senderMu.Lock()
+	defer senderMu.Unlock()
+	previous := senderOverride
+	senderOverride = sender
+	return previous
+}
+
 // SendMail sends an email using sendmail or logs it in mock mode
 func SendMail(to, from, subject, body string) error {
+	senderMu.RLock()
+	sender := senderOverride
+	senderMu.RUnlock()
+	if sender != nil {
+		return sender(to, from, subject, body)
+	}
+
 	config := LoadEmailConfig()
 
 	// Mock mode - just log to console with nice formatting
@@ -297,17 +622,23 @@ func SendMail(to, from, subject, body string) error {
 
 // SendAdminNotification sends a notification to admins about new submissions
 func SendAdminNotification(config EmailConfig, data MembershipConfirmationData) error {
+	subject, body := RenderAdminNotification(data)
+	return SendMail(config.AlertRecipient, config.AlertSender, subject, body)
+}
+
+// RenderAdminNotification builds the subject and body of the admin notice for a membership
+func RenderAdminNotification(data MembershipConfirmationData) (string, string) {
 	subject := fmt.Sprintf("New Membership: %s - %s", data.FullName, data.School)
 
 	body := fmt.Sprintf(`New membership submission received:
 
 Form ID: %s
-Name: %s
+%sName: %s
 Email: %s
 School: %s
 Membership: %s
 Students: %d
-Amount: $%.2f
+Amount: %s
 Payment ID: %s
 Submitted: %s
 
@@ -317,32 +648,39 @@ Students:
 Dashboard: https://yourdomain.com/info?year=%d
 `,
 		data.FormID,
+		receiptLine(data.ReceiptNumber),
 		data.FullName,
 		data.Email,
 		data.School,
 		data.Membership,
 		len(data.Students),
-		data.CalculatedAmount,
+		formatCurrency(data.CalculatedAmount, data.Currency),
 		data.PayPalOrderID,
 		data.SubmittedAt.Format("January 2, 2006 at 3:04 PM"),
 		formatStudentsList(data.Students),
 		data.Year,
 	)
+	return subject, body
+}
 
+func SendFundraiserAdminNotification(config EmailConfig, data FundraiserConfirmationData) error {
+	subject, body := RenderFundraiserAdminNotification(data)
 	return SendMail(config.AlertRecipient, config.AlertSender, subject, body)
 }
 
-func SendFundraiserAdminNotification(config EmailConfig, data FundraiserConfirmationData) error {
+// RenderFundraiserAdminNotification builds the subject and body of the admin notice for
+// a fundraiser donation
+func RenderFundraiserAdminNotification(data FundraiserConfirmationData) (string, string) {
 	subject := fmt.Sprintf("New Fundraiser Donation: %s - %s", data.FullName, data.School)
 
 	body := fmt.Sprintf(`New fundraiser donation received:
 
 Form ID: %s
-Name: %s
+%sName: %s
 Email: %s
 School: %s
 Status: %s
-Amount: $%.2f
+Amount: %s
 Payment ID: %s
 Submitted: %s
 
@@ -352,11 +690,12 @@ Students:
 Dashboard: https://yourdomain.com/info?year=%d
 `,
 		data.FormID,
+		receiptLine(data.ReceiptNumber),
 		data.FullName,
 		data.Email,
 		data.School,
 		data.DonorStatus,
-		data.TotalAmount,
+		formatCurrency(data.TotalAmount, data.Currency),
 		data.PayPalOrderID,
 		func() string {
 			if data.SubmittedAt != nil {
@@ -367,8 +706,15 @@ Dashboard: https://yourdomain.com/info?year=%d
 		formatStudentsList(data.Students),
 		data.Year,
 	)
+	return subject, body
+}
 
-	return SendMail(config.AlertRecipient, config.AlertSender, subject, body)
+// receiptLine is the admin notices' receipt number line, when the payment has one
+func receiptLine(receiptNumber string) string {
+	if receiptNumber == "" {
+		return ""
+	}
+	return "Receipt Number: " + receiptNumber + "\n"
 }
 
 func formatStudentsList(students []data.Student) string {
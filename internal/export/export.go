@@ -0,0 +1,357 @@
+// Package export writes each form type's submissions as a CSV for the treasurer, one
+// row per submission with its students, fees and add-ons spread across columns, and
+// the board's year-end Excel workbook.
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/xlsx"
+)
+
+// Prefix is where the export endpoints are mounted; the file name after it picks the
+// export
+const Prefix = "/admin/export/"
+
+// exporter writes the rows matching a filter in one file format, returning how many
+type exporter struct {
+	contentType string
+	write       func(io.Writer, data.SubmissionFilter) (int, error)
+}
+
+// exports are found by the file name an admin asks for
+var exports = map[string]exporter{
+	"memberships.csv": {csvContentType, WriteMemberships},
+	"events.csv":      {csvContentType, WriteEvents},
+	"fundraisers.csv": {csvContentType, WriteFundraisers},
+	"workbook.xlsx":   {xlsx.ContentType, WriteWorkbook},
+}
+
+const csvContentType = "text/csv; charset=utf-8"
+
+// Handler lets an admin download submissions: GET memberships.csv, events.csv or
+// fundraisers.csv for a form type's rows oldest first, or workbook.xlsx for the
+// year-end workbook. All are filtered by year, school, status (paid, unpaid or a
+// PayPal status) and from/to days.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, Prefix)
+	export, ok := exports[name]
+	if !ok {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Export memberships.csv, events.csv, fundraisers.csv or workbook.xlsx", "")
+		return
+	}
+	filter, err := parseFilter(r)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_filter", err.Error(), "")
+		return
+	}
+
+	filename := name
+	if filter.Year != 0 {
+		ext := path.Ext(name)
+		filename = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), filter.Year, ext)
+	}
+	w.Header().Set("Content-Type", export.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Headers are sent with the first row, so a failure after that can only be logged
+	rows, err := export.write(w, filter)
+	if err != nil {
+		logger.LogError("Failed to export %s: %v", name, err)
+		return
+	}
+	logger.LogInfo("Admin exported %d rows to %s from %s", rows, filename, logger.GetClientIP(r))
+}
+
+// parseFilter reads the year, school, status and from/to days an export asks for
+func parseFilter(r *http.Request) (data.SubmissionFilter, error) {
+	query := r.URL.Query()
+	filter := data.SubmissionFilter{
+		School: strings.TrimSpace(query.Get("school")),
+		Status: strings.TrimSpace(query.Get("status")),
+	}
+	if value := query.Get("year"); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil || year < 2000 || year > 2100 {
+			return filter, fmt.Errorf("year must be a year such as 2025")
+		}
+		filter.Year = year
+	}
+	// Only the days are used; an export has every matching row, not a page
+	list, err := middleware.ParseListQuery(r, 0)
+	if err != nil {
+		return filter, err
+	}
+	filter.From, filter.To = list.From, list.To
+	return filter, nil
+}
+
+// WriteMemberships writes the memberships matching filter as CSV, returning how many
+func WriteMemberships(w io.Writer, filter data.SubmissionFilter) (int, error) {
+	entries, err := data.ListMemberships(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	students, fees, addons := 0, map[string]bool{}, map[string]bool{}
+	for _, entry := range entries {
+		students = max(students, len(entry.Students))
+		for fee := range entry.Fees {
+			fees[fee] = true
+		}
+		for _, addon := range entry.Addons {
+			addons[addon] = true
+		}
+	}
+	feeNames, addonNames := sortedKeys(fees), sortedKeys(addons)
+
+	header := append(commonHeader(), "membership", "membership_status", "describe", "interests", "donation")
+	header = append(header, studentHeader(students)...)
+	for _, fee := range feeNames {
+		header = append(header, "fee_"+fee)
+	}
+	for _, addon := range addonNames {
+		header = append(header, "addon_"+addon)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, entry := range entries {
+		row := commonRow(submission{
+			FormID: entry.FormID, SubmissionDate: entry.SubmissionDate, FullName: entry.FullName,
+			FirstName: entry.FirstName, LastName: entry.LastName, Email: entry.Email, School: entry.School,
+			CalculatedAmount: entry.CalculatedAmount, CoverFees: entry.CoverFees, PayPalStatus: entry.PayPalStatus,
+			PayPalOrderID: entry.PayPalOrderID, SubmittedAt: entry.SubmittedAt, ReceiptNumber: entry.ReceiptNumber,
+		})
+		row = append(row, text(entry.Membership), text(entry.MembershipStatus), text(entry.Describe),
+			text(strings.Join(entry.Interests, "; ")), amount(entry.Donation))
+		row = append(row, studentCells(entry.Students, students)...)
+		for _, fee := range feeNames {
+			quantity := ""
+			if n, ok := entry.Fees[fee]; ok {
+				quantity = strconv.Itoa(n)
+			}
+			row = append(row, quantity)
+		}
+		for _, addon := range addonNames {
+			row = append(row, yes(slices.Contains(entry.Addons, addon)))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return len(entries), cw.Error()
+}
+
+// WriteEvents writes the event registrations matching filter as CSV, returning how
+// many. Each student's food choices and dietary notes follow their name and grade.
+func WriteEvents(w io.Writer, filter data.SubmissionFilter) (int, error) {
+	entries, err := data.ListEvents(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	// Food choices are keyed by meal and student index, such as lunch_0
+	students, meals := 0, map[string]bool{}
+	for _, entry := range entries {
+		students = max(students, len(entry.Students))
+		for key := range entry.FoodChoices {
+			if meal, ok := mealOf(key); ok {
+				meals[meal] = true
+			}
+		}
+	}
+	mealNames := sortedKeys(meals)
+
+	header := append(commonHeader(), "event", "has_food_orders", "order_page_url", "student_count")
+	for i := 1; i <= students; i++ {
+		header = append(header, fmt.Sprintf("student_%d_name", i), fmt.Sprintf("student_%d_grade", i))
+		for _, meal := range mealNames {
+			header = append(header, fmt.Sprintf("student_%d_%s", i, meal))
+		}
+		header = append(header, fmt.Sprintf("student_%d_dietary_notes", i), fmt.Sprintf("student_%d_allergy", i))
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, entry := range entries {
+		row := commonRow(submission{
+			FormID: entry.FormID, SubmissionDate: entry.SubmissionDate, FullName: entry.FullName,
+			FirstName: entry.FirstName, LastName: entry.LastName, Email: entry.Email, School: entry.School,
+			CalculatedAmount: entry.CalculatedAmount, CoverFees: entry.CoverFees, PayPalStatus: entry.PayPalStatus,
+			PayPalOrderID: entry.PayPalOrderID, SubmittedAt: entry.SubmittedAt, ReceiptNumber: entry.ReceiptNumber,
+		})
+		row = append(row, text(entry.Event), yes(entry.HasFoodOrders), entry.OrderPageURL, strconv.Itoa(len(entry.Students)))
+		for i := 0; i < students; i++ {
+			if i >= len(entry.Students) {
+				row = append(row, make([]string, 4+len(mealNames))...)
+				continue
+			}
+			row = append(row, text(entry.Students[i].Name), text(entry.Students[i].Grade))
+			for _, meal := range mealNames {
+				row = append(row, text(entry.FoodChoices[fmt.Sprintf("%s_%d", meal, i)]))
+			}
+			note := entry.DietaryNotes[strconv.Itoa(i)]
+			row = append(row, text(note.Notes), yes(note.Allergy))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return len(entries), cw.Error()
+}
+
+// WriteFundraisers writes the fundraiser donations matching filter as CSV, returning
+// how many. Each student's donation follows their name and grade.
+func WriteFundraisers(w io.Writer, filter data.SubmissionFilter) (int, error) {
+	entries, err := data.ListFundraisers(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	students, donations := 0, 0
+	for _, entry := range entries {
+		students = max(students, len(entry.Students))
+		donations = max(donations, len(entry.DonationItems))
+	}
+
+	header := append(commonHeader(), "describe", "donor_status", "total_amount")
+	header = append(header, studentHeader(students)...)
+	for i := 1; i <= donations; i++ {
+		header = append(header, fmt.Sprintf("donation_%d_student", i), fmt.Sprintf("donation_%d_amount", i))
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, entry := range entries {
+		row := commonRow(submission{
+			FormID: entry.FormID, SubmissionDate: entry.SubmissionDate, FullName: entry.FullName,
+			FirstName: entry.FirstName, LastName: entry.LastName, Email: entry.Email, School: entry.School,
+			CalculatedAmount: entry.CalculatedAmount, CoverFees: entry.CoverFees, PayPalStatus: entry.PayPalStatus,
+			PayPalOrderID: entry.PayPalOrderID, SubmittedAt: entry.SubmittedAt, ReceiptNumber: entry.ReceiptNumber,
+		})
+		row = append(row, text(entry.Describe), text(entry.DonorStatus), amount(entry.TotalAmount))
+		row = append(row, studentCells(entry.Students, students)...)
+		for i := 0; i < donations; i++ {
+			if i >= len(entry.DonationItems) {
+				row = append(row, "", "")
+				continue
+			}
+			row = append(row, text(entry.DonationItems[i].StudentName), amount(entry.DonationItems[i].Amount))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return len(entries), cw.Error()
+}
+
+// submission holds the fields every form type exports in the same leading columns
+type submission struct {
+	FormID           string
+	SubmissionDate   time.Time
+	FullName         string
+	FirstName        string
+	LastName         string
+	Email            string
+	School           string
+	CalculatedAmount float64
+	CoverFees        bool
+	PayPalStatus     string
+	PayPalOrderID    string
+	SubmittedAt      *time.Time
+	ReceiptNumber    string
+}
+
+func commonHeader() []string {
+	return []string{"form_id", "submission_date", "full_name", "first_name", "last_name", "email", "school",
+		"amount", "cover_fees", "paypal_status", "paypal_order_id", "submitted_at", "receipt_number"}
+}
+
+func commonRow(sub submission) []string {
+	submittedAt := ""
+	if sub.SubmittedAt != nil {
+		submittedAt = sub.SubmittedAt.In(clock.Location()).Format(time.RFC3339)
+	}
+	return []string{
+		sub.FormID, sub.SubmissionDate.In(clock.Location()).Format(time.RFC3339),
+		text(sub.FullName), text(sub.FirstName), text(sub.LastName), text(sub.Email), text(sub.School),
+		amount(sub.CalculatedAmount), yes(sub.CoverFees), sub.PayPalStatus, sub.PayPalOrderID, submittedAt, sub.ReceiptNumber,
+	}
+}
+
+// studentHeader names the count and name and grade columns of up to n students
+func studentHeader(n int) []string {
+	header := []string{"student_count"}
+	for i := 1; i <= n; i++ {
+		header = append(header, fmt.Sprintf("student_%d_name", i), fmt.Sprintf("student_%d_grade", i))
+	}
+	return header
+}
+
+// studentCells fills the columns of studentHeader(n), leaving those past the last
+// student empty
+func studentCells(students []data.Student, n int) []string {
+	cells := []string{strconv.Itoa(len(students))}
+	for i := 0; i < n; i++ {
+		if i < len(students) {
+			cells = append(cells, text(students[i].Name), text(students[i].Grade))
+		} else {
+			cells = append(cells, "", "")
+		}
+	}
+	return cells
+}
+
+// mealOf returns the meal of a food choice key such as lunch_0, which ends in the
+// index of the student choosing
+func mealOf(key string) (string, bool) {
+	cut := strings.LastIndex(key, "_")
+	if cut <= 0 {
+		return "", false
+	}
+	_, err := strconv.Atoi(key[cut+1:])
+	return key[:cut], err == nil
+}
+
+// text keeps a family's answer from being read as a formula when the CSV is opened in
+// a spreadsheet
+func text(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func amount(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+func yes(value bool) string {
+	if value {
+		return "yes"
+	}
+	return ""
+}
+
+func sortedKeys(set map[string]bool) []string {
+	return slices.Sorted(maps.Keys(set))
+}
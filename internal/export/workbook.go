@@ -0,0 +1,153 @@
+package export
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/xlsx"
+)
+
+// WriteWorkbook writes the board's year-end workbook for the submissions matching
+// filter: memberships, the fee roster, add-on purchases, fundraiser donations and a
+// financial summary, each on its own sheet. It returns how many submissions it covers.
+// Totals add up everything the filter matches, so ?status=paid leaves out unpaid forms.
+func WriteWorkbook(w io.Writer, filter data.SubmissionFilter) (int, error) {
+	memberships, err := data.ListMemberships(filter)
+	if err != nil {
+		return 0, err
+	}
+	events, err := data.ListEvents(filter)
+	if err != nil {
+		return 0, err
+	}
+	fundraisers, err := data.ListFundraisers(filter)
+	if err != nil {
+		return 0, err
+	}
+	// Fills in each membership's PayPal fee as it totals them
+	summary, extras := data.ComputeMembershipSummary(memberships)
+
+	wb := xlsx.New()
+	membershipSheet(wb, memberships)
+	feeRosterSheet(wb, extras.FeePurchases)
+	addonSheet(wb, extras.AddOnPurchases)
+	fundraiserSheet(wb, fundraisers)
+	summarySheet(wb, summary, events, fundraisers)
+
+	if err := wb.Write(w); err != nil {
+		return 0, err
+	}
+	return len(memberships) + len(events) + len(fundraisers), nil
+}
+
+func membershipSheet(wb *xlsx.Workbook, entries []data.MembershipSubmission) {
+	sheet := wb.AddSheet("Memberships")
+	sheet.Header("Receipt", "Date", "Name", "Email", "School", "Membership", "Status", "Describe",
+		"Students", "Student Names", "Donation", "Amount", "PayPal Fee", "Payment Status")
+	for _, entry := range entries {
+		names := make([]string, 0, len(entry.Students))
+		for _, student := range entry.Students {
+			names = append(names, student.Name)
+		}
+		sheet.Row(entry.ReceiptNumber, day(entry.SubmissionDate), entry.FullName, entry.Email, entry.School,
+			entry.Membership, entry.MembershipStatus, entry.Describe, len(entry.Students), strings.Join(names, ", "),
+			xlsx.Money(entry.Donation), xlsx.Money(entry.CalculatedAmount), xlsx.Money(entry.PayPalFee), entry.PayPalStatus)
+	}
+}
+
+// feeRosterSheet lists who paid each fee, by fee and then family, as the coaches and
+// teachers collecting them need it
+func feeRosterSheet(wb *xlsx.Workbook, purchases []data.FeePurchase) {
+	sorted := slices.Clone(purchases)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].FeeName != sorted[j].FeeName {
+			return sorted[i].FeeName < sorted[j].FeeName
+		}
+		return sorted[i].FullName < sorted[j].FullName
+	})
+
+	sheet := wb.AddSheet("Fee Roster")
+	sheet.Header("Fee", "Name", "School", "Students", "Quantity", "Amount Paid", "PayPal Order", "PayPal Capture")
+	for _, purchase := range sorted {
+		sheet.Row(purchase.FeeName, purchase.FullName, purchase.School, purchase.StudentNames, purchase.Quantity,
+			xlsx.Money(purchase.AmountPaid), purchase.PayPalOrderID, purchase.PayPalCaptureID)
+	}
+}
+
+func addonSheet(wb *xlsx.Workbook, purchases []data.AddOnPurchase) {
+	sorted := slices.Clone(purchases)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Item < sorted[j].Item })
+
+	sheet := wb.AddSheet("Add-on Purchases")
+	sheet.Header("Item", "Name", "School", "Date")
+	for _, purchase := range sorted {
+		sheet.Row(purchase.Item, purchase.FullName, purchase.School, purchase.Date)
+	}
+}
+
+// fundraiserSheet lists each student a donation was made for; a donation with no
+// students is one row for its total
+func fundraiserSheet(wb *xlsx.Workbook, entries []data.FundraiserSubmission) {
+	sheet := wb.AddSheet("Fundraiser Donations")
+	sheet.Header("Receipt", "Date", "Donor", "Email", "School", "Donor Status", "Student", "Amount", "Payment Status")
+	for _, entry := range entries {
+		date := day(entry.SubmissionDate)
+		if len(entry.DonationItems) == 0 {
+			sheet.Row(entry.ReceiptNumber, date, entry.FullName, entry.Email, entry.School, entry.DonorStatus,
+				nil, xlsx.Money(entry.TotalAmount), entry.PayPalStatus)
+			continue
+		}
+		for _, item := range entry.DonationItems {
+			sheet.Row(entry.ReceiptNumber, date, entry.FullName, entry.Email, entry.School, entry.DonorStatus,
+				item.StudentName, xlsx.Money(item.Amount), entry.PayPalStatus)
+		}
+	}
+}
+
+func summarySheet(wb *xlsx.Workbook, summary data.MembershipSummary, events []data.EventSubmission, fundraisers []data.FundraiserSubmission) {
+	var eventRevenue, fundraiserRevenue float64
+	for _, event := range events {
+		eventRevenue += event.CalculatedAmount
+	}
+	for _, fundraiser := range fundraisers {
+		fundraiserRevenue += fundraiser.CalculatedAmount
+	}
+	financial := summary.FinancialSummary
+
+	sheet := wb.AddSheet("Financial Summary")
+	sheet.Header("", "Count", "Amount")
+	sheet.Row("Memberships", summary.TotalSubmissions, xlsx.Money(financial.TotalAmount))
+	sheet.Row("  of which donations", nil, xlsx.Money(financial.TotalDonation))
+	sheet.Row("  PayPal fees", nil, xlsx.Money(financial.TotalPayPalFees))
+	sheet.Row("Event registrations", len(events), xlsx.Money(eventRevenue))
+	sheet.Row("Fundraiser donations", len(fundraisers), xlsx.Money(fundraiserRevenue))
+	sheet.Row("Total", summary.TotalSubmissions+len(events)+len(fundraisers),
+		xlsx.Money(financial.TotalAmount+eventRevenue+fundraiserRevenue))
+	sheet.Row("Members' students", summary.StudentSummary.TotalStudents)
+
+	counts := func(title string, byName map[string]int) {
+		sheet.Row()
+		sheet.Row(title)
+		for _, name := range slices.Sorted(maps.Keys(byName)) {
+			label := name
+			if label == "" {
+				label = "(none)"
+			}
+			sheet.Row("  "+label, byName[name])
+		}
+	}
+	counts("Memberships by level", summary.MembershipLevelCounts)
+	counts("Memberships by school", summary.SchoolCounts)
+	counts("Memberships by status", summary.MembershipStatusCounts)
+}
+
+// day returns the day of t in the club's time zone
+func day(t time.Time) string {
+	return t.In(clock.Location()).Format("2006-01-02")
+}
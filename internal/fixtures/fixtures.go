@@ -0,0 +1,357 @@
+// internal/fixtures/fixtures.go
+package fixtures
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/security"
+)
+
+// Fixture files read from a seed directory; any of them may be missing
+const (
+	MembershipsFile = "memberships.json"
+	EventsFile      = "events.json"
+	FundraisersFile = "fundraisers.json"
+)
+
+// Family holds the contact fields shared by every form type
+type Family struct {
+	FormID    string         `json:"form_id,omitempty"`
+	FirstName string         `json:"first_name"`
+	LastName  string         `json:"last_name"`
+	Email     string         `json:"email"`
+	School    string         `json:"school"`
+	Describe  string         `json:"describe,omitempty"`
+	Students  []data.Student `json:"students"`
+
+	// DaysAgo dates the submission relative to load time so seeded data stays recent
+	DaysAgo   int     `json:"days_ago"`
+	Amount    float64 `json:"amount"`
+	CoverFees bool    `json:"cover_fees"`
+
+	// Captured fixtures get a synthetic PayPal order and capture
+	Captured bool `json:"captured"`
+}
+
+type Membership struct {
+	Family
+	Membership       string         `json:"membership"`
+	MembershipStatus string         `json:"membership_status"`
+	Interests        []string       `json:"interests,omitempty"`
+	Addons           []string       `json:"addons,omitempty"`
+	Fees             map[string]int `json:"fees,omitempty"`
+	Donation         float64        `json:"donation,omitempty"`
+}
+
+type Event struct {
+	Family
+	Event       string            `json:"event"`
+	FoodChoices map[string]string `json:"food_choices,omitempty"`
+}
+
+type Fundraiser struct {
+	Family
+	DonorStatus   string                 `json:"donor_status"`
+	DonationItems []data.StudentDonation `json:"donation_items"`
+}
+
+// Summary counts what Load inserted
+type Summary struct {
+	Memberships int
+	Events      int
+	Fundraisers int
+	Captured    int
+}
+
+// Load inserts every fixture found in dir. Captures are recorded without outbox
+// tasks, so seeding never sends email or builds order pages.
+func Load(dir string) (Summary, error) {
+	var summary Summary
+	now := time.Now()
+
+	var memberships []Membership
+	if err := readFixtures(filepath.Join(dir, MembershipsFile), &memberships); err != nil {
+		return summary, err
+	}
+	for i, m := range memberships {
+		sub, err := m.submission(i, now)
+		if err != nil {
+			return summary, err
+		}
+		if err := data.InsertMembership(sub); err != nil {
+			return summary, fmt.Errorf("membership fixture %s: %w", sub.FormID, err)
+		}
+		summary.Memberships++
+		if err := capture("membership", sub.FormID, m.Family, sub.CalculatedAmount, sub.SubmissionDate); err != nil {
+			return summary, err
+		}
+		if m.Captured {
+			summary.Captured++
+		}
+	}
+
+	var events []Event
+	if err := readFixtures(filepath.Join(dir, EventsFile), &events); err != nil {
+		return summary, err
+	}
+	for i, e := range events {
+		sub, err := e.submission(i, now)
+		if err != nil {
+			return summary, err
+		}
+		if err := data.InsertEvent(sub); err != nil {
+			return summary, fmt.Errorf("event fixture %s: %w", sub.FormID, err)
+		}
+		summary.Events++
+		if err := capture("event", sub.FormID, e.Family, sub.CalculatedAmount, sub.SubmissionDate); err != nil {
+			return summary, err
+		}
+		if e.Captured {
+			summary.Captured++
+		}
+	}
+
+	var fundraisers []Fundraiser
+	if err := readFixtures(filepath.Join(dir, FundraisersFile), &fundraisers); err != nil {
+		return summary, err
+	}
+	for i, f := range fundraisers {
+		sub, err := f.submission(i, now)
+		if err != nil {
+			return summary, err
+		}
+		if err := data.InsertFundraiser(sub); err != nil {
+			return summary, fmt.Errorf("fundraiser fixture %s: %w", sub.FormID, err)
+		}
+		summary.Fundraisers++
+		if err := capture("fundraiser", sub.FormID, f.Family, sub.CalculatedAmount, sub.SubmissionDate); err != nil {
+			return summary, err
+		}
+		if f.Captured {
+			summary.Captured++
+		}
+	}
+
+	logger.LogInfo("Loaded fixtures from %s: %d memberships, %d events, %d fundraisers (%d captured)",
+		dir, summary.Memberships, summary.Events, summary.Fundraisers, summary.Captured)
+	return summary, nil
+}
+
+func readFixtures(path string, v interface{}) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func (m Membership) submission(index int, now time.Time) (data.MembershipSubmission, error) {
+	formID, token, submitted, err := m.Family.identity("membership", index, now)
+	if err != nil {
+		return data.MembershipSubmission{}, err
+	}
+
+	fees := m.Fees
+	if fees == nil {
+		fees = map[string]int{}
+	}
+
+	return data.MembershipSubmission{
+		FormID:           formID,
+		AccessToken:      token,
+		SubmissionDate:   submitted,
+		FullName:         m.fullName(),
+		FirstName:        m.FirstName,
+		LastName:         m.LastName,
+		Email:            m.Email,
+		School:           m.School,
+		Membership:       m.Membership,
+		MembershipStatus: m.MembershipStatus,
+		Interests:        m.Interests,
+		Describe:         m.Describe,
+		StudentCount:     len(m.Students),
+		Students:         m.Students,
+		Fees:             fees,
+		Addons:           m.Addons,
+		Donation:         m.Donation,
+		CalculatedAmount: m.Amount,
+		CoverFees:        m.CoverFees,
+		Submitted:        true,
+		SubmittedAt:      &submitted,
+	}, nil
+}
+
+func (e Event) submission(index int, now time.Time) (data.EventSubmission, error) {
+	formID, token, submitted, err := e.Family.identity("event", index, now)
+	if err != nil {
+		return data.EventSubmission{}, err
+	}
+
+	foodChoices := e.FoodChoices
+	if foodChoices == nil {
+		foodChoices = map[string]string{}
+	}
+	foodChoicesJSON, err := json.Marshal(foodChoices)
+	if err != nil {
+		return data.EventSubmission{}, fmt.Errorf("event fixture %s: %w", formID, err)
+	}
+
+	return data.EventSubmission{
+		FormID:           formID,
+		AccessToken:      token,
+		SubmissionDate:   submitted,
+		Event:            e.Event,
+		FullName:         e.fullName(),
+		FirstName:        e.FirstName,
+		LastName:         e.LastName,
+		Email:            e.Email,
+		School:           e.School,
+		StudentCount:     len(e.Students),
+		Students:         e.Students,
+		HasFoodOrders:    len(foodChoices) > 0,
+		FoodChoices:      foodChoices,
+		FoodChoicesJSON:  string(foodChoicesJSON),
+		CalculatedAmount: e.Amount,
+		CoverFees:        e.CoverFees,
+		Submitted:        true,
+		SubmittedAt:      &submitted,
+	}, nil
+}
+
+func (f Fundraiser) submission(index int, now time.Time) (data.FundraiserSubmission, error) {
+	formID, token, submitted, err := f.Family.identity("fundraiser", index, now)
+	if err != nil {
+		return data.FundraiserSubmission{}, err
+	}
+
+	total := 0.0
+	for _, item := range f.DonationItems {
+		total += item.Amount
+	}
+	amount := f.Amount
+	if amount == 0 {
+		amount = total
+	}
+
+	return data.FundraiserSubmission{
+		FormID:           formID,
+		AccessToken:      token,
+		SubmissionDate:   submitted,
+		FullName:         f.fullName(),
+		FirstName:        f.FirstName,
+		LastName:         f.LastName,
+		Email:            f.Email,
+		School:           f.School,
+		Describe:         f.Describe,
+		DonorStatus:      f.DonorStatus,
+		StudentCount:     len(f.Students),
+		Students:         f.Students,
+		DonationItems:    f.DonationItems,
+		TotalAmount:      total,
+		CoverFees:        f.CoverFees,
+		CalculatedAmount: amount,
+		Submitted:        true,
+		SubmittedAt:      &submitted,
+	}, nil
+}
+
+// identity returns the form ID, a fresh access token and the submission time for a fixture.
+// Fixtures without a form_id get a stable "<type>-seed-<n>" ID.
+func (f Family) identity(formType string, index int, now time.Time) (string, string, time.Time, error) {
+	formID := f.FormID
+	if formID == "" {
+		formID = fmt.Sprintf("%s-seed-%03d", formType, index+1)
+	}
+	token, err := security.GenerateAccessToken()
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("failed to generate access token for %s: %w", formID, err)
+	}
+	return formID, token, now.AddDate(0, 0, -f.DaysAgo), nil
+}
+
+func (f Family) fullName() string {
+	return strings.TrimSpace(f.FirstName + " " + f.LastName)
+}
+
+// capture records a synthetic PayPal capture for captured fixtures
+func capture(formType, formID string, f Family, amount float64, submitted time.Time) error {
+	if !f.Captured {
+		return nil
+	}
+
+	orderID := "SEED" + strings.ToUpper(strings.ReplaceAll(formID, "-", ""))
+	createdAt := submitted.Add(2 * time.Minute)
+	if err := data.SetPayPalOrder(formID, orderID, &createdAt); err != nil {
+		return fmt.Errorf("failed to record seed order for %s: %w", formID, err)
+	}
+
+	capturedAt := createdAt.Add(time.Minute)
+	details, err := json.Marshal(captureDetails(orderID, f, amount, capturedAt))
+	if err != nil {
+		return err
+	}
+	if err := data.RecordPayPalCapture(formType, formID, string(details), "COMPLETED", &capturedAt, nil, data.ActorSystem); err != nil {
+		return fmt.Errorf("failed to record seed capture for %s: %w", formID, err)
+	}
+	return nil
+}
+
+// captureDetails mirrors the fields of a PayPal capture response that the admin
+// views and reports read: payer email, capture ID and link, and the PayPal fee
+func captureDetails(orderID string, f Family, total float64, capturedAt time.Time) map[string]interface{} {
+	captureID := "CAP" + orderID
+	fee := total*0.0199 + 0.49
+	amount := fmt.Sprintf("%.2f", total)
+
+	return map[string]interface{}{
+		"id":     orderID,
+		"status": "COMPLETED",
+		"payer": map[string]interface{}{
+			"email_address": f.Email,
+			"name": map[string]string{
+				"given_name": f.FirstName,
+				"surname":    f.LastName,
+			},
+		},
+		"purchase_units": []interface{}{
+			map[string]interface{}{
+				"payments": map[string]interface{}{
+					"captures": []interface{}{
+						map[string]interface{}{
+							"id":          captureID,
+							"status":      "COMPLETED",
+							"create_time": capturedAt.UTC().Format(time.RFC3339),
+							"amount":      map[string]string{"currency_code": "USD", "value": amount},
+							"seller_receivable_breakdown": map[string]interface{}{
+								"gross_amount": map[string]string{"currency_code": "USD", "value": amount},
+								"paypal_fee":   map[string]string{"currency_code": "USD", "value": fmt.Sprintf("%.2f", fee)},
+								"net_amount":   map[string]string{"currency_code": "USD", "value": fmt.Sprintf("%.2f", total-fee)},
+							},
+							"links": []interface{}{
+								map[string]string{
+									"href":   "https://api.sandbox.paypal.com/v2/payments/captures/" + captureID,
+									"rel":    "self",
+									"method": "GET",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
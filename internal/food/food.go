@@ -3,11 +3,50 @@ package food
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"math/big"
+	"sort"
+	"strconv"
 	"strings"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
 )
 
+// maxDietaryNoteLength keeps free-text notes short enough to print on a kitchen sheet
+const maxDietaryNoteLength = 300
+
+// allergyKeywords flag notes as allergies even when the family didn't tick the allergy box
+var allergyKeywords = []string{"allerg", "anaphyla", "epipen", "epi-pen", "epi pen"}
+
+// KitchenItem is the total quantity of one food option across an event's paid orders
+type KitchenItem struct {
+	Key      string
+	Label    string
+	Quantity int
+}
+
+// KitchenDietaryEntry is one student's dietary note as printed on the kitchen report
+type KitchenDietaryEntry struct {
+	FoodOrderID string
+	FamilyName  string
+	School      string
+	StudentName string
+	Notes       string
+	Allergy     bool
+}
+
+// KitchenReport aggregates food totals and dietary notes for one event
+type KitchenReport struct {
+	Event        string
+	OrderCount   int
+	Items        []KitchenItem
+	DietaryNotes []KitchenDietaryEntry
+	AllergyCount int
+}
+
 // GenerateFoodOrderID returns "L-12345" where L is the (uppercased) first letter of the school.
 func GenerateFoodOrderID(school string) (string, error) {
 	letter := "X"
@@ -21,3 +60,116 @@ func GenerateFoodOrderID(school string) (string, error) {
 	}
 	return fmt.Sprintf("%s-%05d", letter, n.Int64()), nil
 }
+
+// NormalizeDietaryNotes trims notes, drops empty ones, fills in student names and
+// flags anything that reads like an allergy so it can't be missed in the kitchen.
+func NormalizeDietaryNotes(notes map[string]data.DietaryNote, students []data.Student) (map[string]data.DietaryNote, error) {
+	normalized := make(map[string]data.DietaryNote)
+
+	for studentIndex, note := range notes {
+		idx, err := strconv.Atoi(studentIndex)
+		if err != nil || idx < 0 || idx >= len(students) {
+			return nil, fmt.Errorf("dietary notes for unknown student %s", studentIndex)
+		}
+
+		note.Notes = strings.TrimSpace(note.Notes)
+		if note.Notes == "" && !note.Allergy {
+			continue
+		}
+		if len(note.Notes) > maxDietaryNoteLength {
+			return nil, fmt.Errorf("dietary notes for %s exceed %d characters", students[idx].Name, maxDietaryNoteLength)
+		}
+
+		note.StudentName = students[idx].Name
+		if !note.Allergy && mentionsAllergy(note.Notes) {
+			note.Allergy = true
+		}
+
+		normalized[studentIndex] = note
+	}
+
+	return normalized, nil
+}
+
+func mentionsAllergy(notes string) bool {
+	lower := strings.ToLower(notes)
+	for _, keyword := range allergyKeywords {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildKitchenReport totals the food options and collects dietary notes from the
+// paid submissions for one event. Allergies are listed ahead of other notes.
+func BuildKitchenReport(eventName string, eventConfig inventory.EventConfig, subs []data.EventSubmission) KitchenReport {
+	report := KitchenReport{Event: eventName}
+	quantities := make(map[string]int)
+	labels := make(map[string]string)
+
+	for _, sub := range subs {
+		if sub.Event != eventName || sub.PayPalStatus != "COMPLETED" {
+			continue
+		}
+
+		if sub.HasFoodOrders {
+			report.OrderCount++
+
+			var selections struct {
+				StudentSelections map[string]map[string]bool `json:"student_selections"`
+				SharedSelections  map[string]int             `json:"shared_selections"`
+			}
+			if err := json.Unmarshal([]byte(sub.FoodChoicesJSON), &selections); err != nil {
+				logger.LogWarn("Skipping unreadable food selections for %s: %v", sub.FormID, err)
+				continue
+			}
+
+			for _, studentSelections := range selections.StudentSelections {
+				for optionKey, selected := range studentSelections {
+					if option, ok := eventConfig.PerStudentOptions[optionKey]; ok && selected && option.IsFood {
+						quantities[optionKey]++
+						labels[optionKey] = option.Label
+					}
+				}
+			}
+
+			for optionKey, quantity := range selections.SharedSelections {
+				if option, ok := eventConfig.SharedOptions[optionKey]; ok && quantity > 0 && option.IsFood {
+					quantities[optionKey] += quantity
+					labels[optionKey] = option.Label
+				}
+			}
+		}
+
+		for _, note := range sub.DietaryNotes {
+			report.DietaryNotes = append(report.DietaryNotes, KitchenDietaryEntry{
+				FoodOrderID: sub.FoodOrderID,
+				FamilyName:  sub.FullName,
+				School:      sub.School,
+				StudentName: note.StudentName,
+				Notes:       note.Notes,
+				Allergy:     note.Allergy,
+			})
+			if note.Allergy {
+				report.AllergyCount++
+			}
+		}
+	}
+
+	for key, quantity := range quantities {
+		report.Items = append(report.Items, KitchenItem{Key: key, Label: labels[key], Quantity: quantity})
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		return report.Items[i].Label < report.Items[j].Label
+	})
+
+	sort.SliceStable(report.DietaryNotes, func(i, j int) bool {
+		if report.DietaryNotes[i].Allergy != report.DietaryNotes[j].Allergy {
+			return report.DietaryNotes[i].Allergy
+		}
+		return report.DietaryNotes[i].StudentName < report.DietaryNotes[j].StudentName
+	})
+
+	return report
+}
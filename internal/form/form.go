@@ -6,7 +6,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"html/template"
+	"math"
 	"math/rand"
 	"net/http"
 	"regexp"
@@ -15,13 +16,15 @@ import (
 	"sync"
 	"time"
 
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/clock"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/logger"
 	"sbcbackend/internal/security"
+	"sbcbackend/internal/sms"
 )
 
 var (
-	timeZone           *time.Location
 	recentSubmissions  = make(map[string]time.Time)
 	submissionMu       sync.Mutex
 	duplicateThreshold = time.Minute * 3
@@ -30,6 +33,9 @@ var (
 	rateLimiterMu      sync.Mutex
 )
 
+// maxStudents caps student_count so a crafted post can't make the parsers loop unbounded
+const maxStudents = 20
+
 var (
 	formStatsMu           sync.Mutex
 	totalSubmissions      int
@@ -40,14 +46,6 @@ var (
 	validationFailures    int
 )
 
-func init() {
-	var err error
-	timeZone, err = time.LoadLocation("America/Chicago")
-	if err != nil {
-		log.Fatalf("Error loading time zone: %v", err)
-	}
-}
-
 func logAndIncrement(stat *int, label string) {
 	formStatsMu.Lock()
 	*stat++
@@ -115,8 +113,15 @@ func SubmitFormHandler(w http.ResponseWriter, r *http.Request) {
 		formType = "membership"
 	}
 
+	smsPhone, err := parseSMSConsent(r)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusBadRequest, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	formID := generateFormID(formType)
-	submissionDate := time.Now().In(timeZone)
+	submissionDate := clock.Now().In(clock.Location())
 	accessToken, err := security.GenerateAccessToken()
 	if err != nil {
 		logger.LogHTTPError(r, http.StatusInternalServerError, err)
@@ -126,7 +131,7 @@ func SubmitFormHandler(w http.ResponseWriter, r *http.Request) {
 	security.StoreAccessToken(accessToken, formID, "membership")
 
 	submissionKey := generateSubmissionKey(r.FormValue("email"), r.FormValue("school"), r.FormValue("full_name"))
-	now := time.Now()
+	now := clock.Now()
 
 	submissionMu.Lock()
 	lastSubmit, exists := recentSubmissions[submissionKey]
@@ -143,7 +148,7 @@ func SubmitFormHandler(w http.ResponseWriter, r *http.Request) {
 	// Unified form processing - each uses its specific parser and database function
 	switch formType {
 	case "membership":
-		sub, err := parseMembershipSubmission(r, formID, accessToken, submissionDate)
+		sub, err := ParseMembershipSubmission(r, formID, accessToken, submissionDate)
 		if err != nil {
 			logger.LogHTTPError(r, http.StatusBadRequest, err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -156,7 +161,7 @@ func SubmitFormHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "event":
-		sub, err := parseEventSubmission(r, formID, accessToken, submissionDate)
+		sub, err := ParseEventSubmission(r, formID, accessToken, submissionDate)
 		if err != nil {
 			logger.LogHTTPError(r, http.StatusBadRequest, err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -169,7 +174,7 @@ func SubmitFormHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "fundraiser":
-		handleFundraiserSubmission(w, r, formID, accessToken, submissionDate)
+		handleFundraiserSubmission(w, r, formID, accessToken, submissionDate, smsPhone)
 		return // handleFundraiserSubmission manages its own response
 
 	default:
@@ -177,6 +182,8 @@ func SubmitFormHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordSMSConsent(formType, formID, smsPhone)
+
 	logger.LogInfo("Form %s accepted and saved successfully", formID)
 	logAndIncrement(&successfulSubmissions, "successful_submissions")
 	logFormSubmissionStats(formType, r, formID)
@@ -255,7 +262,7 @@ func validateFormData(r *http.Request) (map[string]interface{}, error) {
 }
 
 func generateFormID(formType string) string {
-	now := time.Now().In(timeZone)
+	now := clock.Now().In(clock.Location())
 	timestamp := now.Format("2006-01-02_15-04-05")
 
 	randomBytes := make([]byte, 4)
@@ -276,27 +283,53 @@ func isRateLimited(ip string) bool {
 	rateLimiterMu.Lock()
 	defer rateLimiterMu.Unlock()
 	last, ok := rateLimiter[ip]
-	return ok && time.Since(last) < rateLimitDuration
+	return ok && clock.Since(last) < rateLimitDuration
 }
 
 func setRateLimit(ip string) {
 	rateLimiterMu.Lock()
 	defer rateLimiterMu.Unlock()
-	rateLimiter[ip] = time.Now()
+	rateLimiter[ip] = clock.Now()
+}
+
+// PruneRateLimits drops per-IP rate limit and duplicate-submission entries older than maxAge
+func PruneRateLimits(maxAge time.Duration) int {
+	removed := 0
+
+	rateLimiterMu.Lock()
+	for ip, last := range rateLimiter {
+		if clock.Since(last) > maxAge {
+			delete(rateLimiter, ip)
+			removed++
+		}
+	}
+	rateLimiterMu.Unlock()
+
+	submissionMu.Lock()
+	for key, last := range recentSubmissions {
+		if clock.Since(last) > maxAge {
+			delete(recentSubmissions, key)
+			removed++
+		}
+	}
+	submissionMu.Unlock()
+
+	return removed
 }
 
 func logFormSubmissionStats(formType string, r *http.Request, formID string) {
 	ip := logger.GetClientIP(r)
-	timestamp := time.Now().In(timeZone).Format("2006-01-02 15:04:05")
+	timestamp := clock.Now().In(clock.Location()).Format("2006-01-02 15:04:05")
 	logger.LogInfo("Form submitted: type=%s, formID=%s, ip=%s, time=%s", formType, formID, ip, timestamp)
 }
 
 // db additions
 
-func parseMembershipSubmission(r *http.Request, formID, accessToken string, submissionDate time.Time) (data.MembershipSubmission, error) {
+// ParseMembershipSubmission parses a posted membership form. The request form must already be parsed.
+func ParseMembershipSubmission(r *http.Request, formID, accessToken string, submissionDate time.Time) (data.MembershipSubmission, error) {
 	fullName := r.FormValue("full_name")
 	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
-	studentCount, _ := strconv.Atoi(r.FormValue("student_count"))
+	studentCount := parseStudentCount(r.FormValue("student_count"))
 	firstName, lastName := parseFirstLastName(fullName)
 	interests := r.Form["interests"]
 	addons := r.Form["addons"]
@@ -339,10 +372,11 @@ func parseMembershipSubmission(r *http.Request, formID, accessToken string, subm
 	return sub, nil
 }
 
-func parseEventSubmission(r *http.Request, formID, accessToken string, submissionDate time.Time) (data.EventSubmission, error) {
+// ParseEventSubmission parses a posted event registration form
+func ParseEventSubmission(r *http.Request, formID, accessToken string, submissionDate time.Time) (data.EventSubmission, error) {
 	fullName := r.FormValue("full_name")
 	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
-	studentCount, _ := strconv.Atoi(r.FormValue("student_count"))
+	studentCount := parseStudentCount(r.FormValue("student_count"))
 	firstName, lastName := parseFirstLastName(fullName)
 	students := parseStudents(r, studentCount)
 
@@ -385,18 +419,18 @@ func parseEventSubmission(r *http.Request, formID, accessToken string, submissio
 	return sub, nil
 }
 
-// parseFundraiserSubmission parses form data into a FundraiserSubmission
-func parseFundraiserSubmission(r *http.Request, formID, accessToken string, submissionDate time.Time) (data.FundraiserSubmission, error) {
+// ParseFundraiserSubmission parses form data into a FundraiserSubmission
+func ParseFundraiserSubmission(r *http.Request, formID, accessToken string, submissionDate time.Time) (data.FundraiserSubmission, error) {
 	fullName := r.FormValue("full_name")
 	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
-	studentCount, _ := strconv.Atoi(r.FormValue("student_count"))
+	studentCount := parseStudentCount(r.FormValue("student_count"))
 	firstName, lastName := parseFirstLastName(fullName)
 
 	// Parse students (same as membership)
 	students := parseStudents(r, studentCount)
 
 	// Parse donation items - this is fundraiser-specific
-	donationItems, totalDonation, err := parseDonationItems(r, studentCount)
+	donationItems, totalDonation, err := ParseDonationItems(r, studentCount)
 	if err != nil {
 		return data.FundraiserSubmission{}, fmt.Errorf("failed to parse donation items: %w", err)
 	}
@@ -436,8 +470,8 @@ func parseFundraiserSubmission(r *http.Request, formID, accessToken string, subm
 	return sub, nil
 }
 
-// parseDonationItems extracts donation amounts per student from form data
-func parseDonationItems(r *http.Request, studentCount int) ([]data.StudentDonation, float64, error) {
+// ParseDonationItems extracts donation amounts per student from form data
+func ParseDonationItems(r *http.Request, studentCount int) ([]data.StudentDonation, float64, error) {
 	var donationItems []data.StudentDonation
 	var totalDonation float64
 
@@ -450,7 +484,7 @@ func parseDonationItems(r *http.Request, studentCount int) ([]data.StudentDonati
 		}
 
 		amount, err := strconv.ParseFloat(amountStr, 64)
-		if err != nil || amount <= 0 {
+		if err != nil || !(amount > 0) || math.IsInf(amount, 0) {
 			return nil, 0, fmt.Errorf("invalid donation amount for student %d (%s): %s", i, studentName, amountStr)
 		}
 
@@ -553,9 +587,9 @@ func validateFundraiserSubmission(sub data.FundraiserSubmission) error {
 }
 
 // handleFundraiserSubmission processes a complete fundraiser form submission
-func handleFundraiserSubmission(w http.ResponseWriter, r *http.Request, formID, accessToken string, submissionDate time.Time) {
+func handleFundraiserSubmission(w http.ResponseWriter, r *http.Request, formID, accessToken string, submissionDate time.Time, smsPhone string) {
 	// Parse the submission
-	sub, err := parseFundraiserSubmission(r, formID, accessToken, submissionDate)
+	sub, err := ParseFundraiserSubmission(r, formID, accessToken, submissionDate)
 	if err != nil {
 		logger.LogHTTPError(r, http.StatusBadRequest, err)
 		http.Error(w, fmt.Sprintf("Failed to parse fundraiser submission: %v", err), http.StatusBadRequest)
@@ -575,6 +609,7 @@ func handleFundraiserSubmission(w http.ResponseWriter, r *http.Request, formID,
 		http.Error(w, "Failed to save fundraiser data", http.StatusInternalServerError)
 		return
 	}
+	recordSMSConsent("fundraiser", formID, smsPhone)
 
 	// NEW: Process payment data (equivalent to /save-payment-data for fundraisers)
 	if err := data.ProcessFundraiserPayment(&sub); err != nil {
@@ -587,6 +622,34 @@ func handleFundraiserSubmission(w http.ResponseWriter, r *http.Request, formID,
 		formID, sub.Email, sub.CalculatedAmount)
 }
 
+// parseSMSConsent returns the normalized mobile number when the payer ticked the box
+// asking for a confirmation text, or "" when they didn't or SMS isn't set up
+func parseSMSConsent(r *http.Request) (string, error) {
+	consent := strings.ToLower(r.FormValue("sms_consent"))
+	if consent != "on" && consent != "true" && consent != "1" {
+		return "", nil
+	}
+	if !sms.Enabled() {
+		return "", nil
+	}
+	phone, err := sms.NormalizePhone(r.FormValue("phone"))
+	if err != nil {
+		return "", fmt.Errorf("please enter a valid mobile number for the text confirmation: %w", err)
+	}
+	return phone, nil
+}
+
+// recordSMSConsent stores the opt-in once the submission is saved. The payer still gets
+// the email, so a failure here is logged rather than failing the submission.
+func recordSMSConsent(formType, formID, phone string) {
+	if phone == "" {
+		return
+	}
+	if err := data.RecordSMSConsent(formType, formID, phone, clock.Now()); err != nil {
+		logger.LogError("Failed to record SMS consent for %s: %v", formID, err)
+	}
+}
+
 // Helper function for absolute value (since math.Abs works with float64)
 func abs(x float64) float64 {
 	if x < 0 {
@@ -628,72 +691,107 @@ func parseIntOrZero(s string) int {
 	return n
 }
 
+// parseFloatOrZero returns 0 for anything that isn't a finite number, including "NaN" and "Inf"
 func parseFloatOrZero(s string) float64 {
-	f, _ := strconv.ParseFloat(s, 64)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
 	return f
 }
 
+// parseStudentCount bounds the posted student count; the parsers loop over it
+func parseStudentCount(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	if n < 0 {
+		return 0
+	}
+	if n > maxStudents {
+		return maxStudents
+	}
+	return n
+}
+
+// ResumeCheckoutHandler is the target of abandoned-checkout reminder links. It trades
+// the long-lived resume token for a fresh access token and sends the family back to checkout.
+func ResumeCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	formID := r.URL.Query().Get("formID")
+	resumeToken := r.URL.Query().Get("code")
+	if formID == "" || resumeToken == "" {
+		http.Error(w, "Missing formID or code", http.StatusBadRequest)
+		return
+	}
+
+	formType := strings.SplitN(formID, "-", 2)[0]
+
+	accessToken, err := security.GenerateAccessToken()
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to generate access token", http.StatusInternalServerError)
+		return
+	}
+
+	resumed, err := data.ResumeCheckout(formType, formID, resumeToken, accessToken)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to resume checkout", http.StatusInternalServerError)
+		return
+	}
+	if !resumed {
+		http.Error(w, "This checkout link is no longer valid", http.StatusGone)
+		return
+	}
+
+	security.StoreAccessToken(accessToken, formID, formType)
+	logger.LogInfo("Checkout resumed from reminder for %s", formID)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(generateCheckoutRedirect(formID, accessToken, formType)))
+}
+
+// CheckoutPath returns the checkout page a form type continues to after submission
+func CheckoutPath(formType string) string {
+	switch formType {
+	case "membership":
+		return "/member-checkout.html"
+	case "event":
+		return "/event-checkout.html"
+	default:
+		return "/donate.html"
+	}
+}
+
+var checkoutRedirectTmpl = template.Must(template.New("checkout_redirect.html.tmpl").
+	ParseFS(assets.Templates(), "checkout_redirect.html.tmpl"))
+
 func generateCheckoutRedirect(formID, accessToken, formType string) string {
-	var action, title, message string
+	var title, message string
+	action := CheckoutPath(formType)
 
 	switch formType {
 	case "membership":
-		action = "/member-checkout.html"
 		title = "Processing your membership..."
 		message = "Please wait while we prepare your membership options."
 	case "event":
-		action = "/event-checkout.html"
 		title = "Processing your registration..."
 		message = "Please wait while we prepare your event options."
 	default:
-		action = "/donate.html"
 		title = "Processing..."
 		message = "Please wait..."
 	}
 
-	return fmt.Sprintf(`
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>Processing...</title>
-			<style>
-				body { 
-					font-family: system-ui, sans-serif; 
-					text-align: center; 
-					padding: 2rem;
-					background-color: #f5f7ff;
-				}
-				.spinner {
-					border: 4px solid #f3f3f3;
-					border-top: 4px solid #663399;
-					border-radius: 50%%;
-					width: 40px;
-					height: 40px;
-					animation: spin 1s linear infinite;
-					margin: 20px auto;
-				}
-				@keyframes spin {
-					0%% { transform: rotate(0deg); }
-					100%% { transform: rotate(360deg); }
-				}
-			</style>
-		</head>
-		<body>
-			<h2>%s</h2>
-			<div class="spinner"></div>
-			<p>%s</p>
-		
-			<script>
-			// Store data in sessionStorage (following existing pattern)
-			sessionStorage.setItem('accessToken', '%s');
-			sessionStorage.setItem('formID', '%s');
-			
-			// Navigate to checkout page
-			setTimeout(function() {
-				window.location.href = '%s';
-			}, 2000);
-			</script>
-		</body>
-		</html>
-	`, title, message, accessToken, formID, action)
+	var page strings.Builder
+	err := checkoutRedirectTmpl.Execute(&page, struct {
+		Title, Message, AccessToken, FormID, Action string
+	}{title, message, accessToken, formID, action})
+	if err != nil {
+		logger.LogError("Failed to render checkout redirect for %s: %v", formID, err)
+	}
+	return page.String()
 }
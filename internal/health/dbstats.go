@@ -0,0 +1,33 @@
+package health
+
+import (
+	"net/http"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// DBStatsHandler lets an admin see how loaded the database is: GET returns the
+// connection pool's stats, the rows in each table, the last migration applied and,
+// for SQLite, the file and write-ahead log sizes and the last maintenance run, with
+// warnings for signs of pressure
+func DBStatsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	stats, err := data.GetDatabaseStats()
+	if err != nil {
+		logger.LogError("Failed to read database stats: %v", err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read database stats", "")
+		return
+	}
+	for _, warning := range stats.Warnings {
+		logger.LogWarn("Database under pressure: %s", warning)
+	}
+	middleware.WriteAPISuccess(w, r, stats)
+}
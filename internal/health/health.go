@@ -0,0 +1,97 @@
+// internal/health/health.go
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Check reports why a dependency is not usable yet, or nil when it is
+type Check func() error
+
+var (
+	mu     sync.RWMutex
+	ready  bool
+	checks = make(map[string]Check)
+)
+
+// Register adds a readiness check under name, returning the check it replaces.
+// A nil check removes the name.
+func Register(name string, check Check) Check {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := checks[name]
+	if check == nil {
+		delete(checks, name)
+	} else {
+		checks[name] = check
+	}
+	return previous
+}
+
+// SetReady marks startup as finished (or the process as going away), returning the
+// previous value. Readiness also requires every registered check to pass.
+func SetReady(value bool) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := ready
+	ready = value
+	return previous
+}
+
+// Status is the body of a readiness response
+type Status struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Ready runs every check and reports the result
+func Ready() Status {
+	mu.RLock()
+	started := ready
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	run := make([]Check, len(names))
+	for i, name := range names {
+		run[i] = checks[name]
+	}
+	mu.RUnlock()
+
+	status := Status{Ready: started, Checks: make(map[string]string, len(names))}
+	if !started {
+		status.Checks["startup"] = "not finished"
+	}
+	for i, name := range names {
+		if err := run[i](); err != nil {
+			status.Ready = false
+			status.Checks[name] = err.Error()
+		} else {
+			status.Checks[name] = "ok"
+		}
+	}
+	return status
+}
+
+// LiveHandler answers as long as the process can serve HTTP at all. Supervisors
+// restart the process when it fails; it never looks at dependencies.
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// ReadyHandler answers 200 once the instance can take traffic and 503 until then,
+// listing each check so a failed deployment shows what is missing
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	status := Ready()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
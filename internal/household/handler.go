@@ -0,0 +1,197 @@
+package household
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/security"
+)
+
+// signInRequested is the answer to every valid sign-in request, whether or not a link was sent
+const signInRequested = "If we have orders for that address, we've emailed it a link to them."
+
+/*
+SignInHandler takes a family's email address and emails it a link to their orders.
+It needs a CSRF token like the forms do, and answers the same whether or not the
+address has a household.
+*/
+func SignInHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	csrfToken := r.FormValue("csrf_token")
+	if csrfToken == "" || !security.ValidateCSRFToken(csrfToken) {
+		err := fmt.Errorf("missing or invalid CSRF token")
+		logger.LogHTTPError(r, http.StatusForbidden, err)
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "invalid_csrf_token", "Please reload the page and try again", "")
+		return
+	}
+
+	err := RequestSignIn(r.Context(), r.FormValue("email"))
+	if errors.Is(err, ErrInvalidEmail) {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_email", "Please enter a valid email address", "")
+		return
+	}
+	if err != nil {
+		// Failing only for addresses with households would tell the caller they have one
+		logger.LogError("Failed to handle household sign-in from %s: %v", logger.GetClientIP(r), err)
+	}
+	middleware.WriteAPISuccess(w, r, map[string]string{"message": signInRequested})
+}
+
+var householdPageTmpl = template.Must(template.New("household.html.tmpl").
+	ParseFS(assets.Templates(), "household.html.tmpl"))
+
+// pageOrder is one row of the orders page
+type pageOrder struct {
+	FormType      string
+	Date          string
+	Item          string
+	Amount        float64
+	Status        string
+	ReceiptNumber string
+	ReceiptURL    string
+	OrderPageURL  string
+}
+
+// pageYear is a year's orders on the orders page, newest year first
+type pageYear struct {
+	Year   int
+	Orders []pageOrder
+}
+
+/*
+PageHandler is the target of the sign-in link: the family's paid orders of every form
+type, grouped by year, with their receipts and event order pages. The link's code is
+the credential, so the page is kept out of caches and search results.
+*/
+func PageHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	household, ok := openHousehold(w, r)
+	if !ok {
+		return
+	}
+	orders, err := data.ListHouseholdOrders(household.ID)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to load your orders", http.StatusInternalServerError)
+		return
+	}
+
+	var years []pageYear
+	for i := len(orders) - 1; i >= 0; i-- {
+		sub := orders[i]
+		// Forms left before paying aren't orders
+		switch sub.PayPalStatus {
+		case "COMPLETED", "REFUNDED", "REVERSED", data.DisputedStatus:
+		default:
+			continue
+		}
+
+		row := pageOrder{
+			FormType:      sub.FormType,
+			Date:          sub.SubmissionDate.In(clock.Location()).Format("Jan 2, 2006"),
+			Item:          sub.Item,
+			Amount:        sub.CalculatedAmount,
+			Status:        sub.PayPalStatus,
+			ReceiptNumber: sub.ReceiptNumber,
+			OrderPageURL:  sub.OrderPageURL,
+		}
+		// Receipts are served from the live tables only
+		if sub.PayPalStatus == "COMPLETED" && sub.AccessToken != "" && !sub.Archived {
+			row.ReceiptURL = order.ReceiptURL(sub.FormID, sub.AccessToken)
+		}
+
+		year := sub.SubmissionDate.In(clock.Location()).Year()
+		if len(years) == 0 || years[len(years)-1].Year != year {
+			years = append(years, pageYear{Year: year})
+		}
+		years[len(years)-1].Orders = append(years[len(years)-1].Orders, row)
+	}
+
+	var buf bytes.Buffer
+	err = householdPageTmpl.Execute(&buf, map[string]interface{}{
+		"Email": household.Email,
+		"Years": years,
+	})
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to render the page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// PrefillHandler returns the details of a signed-in family's newest submission as
+// JSON, for the forms to fill themselves in
+func PrefillHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	household, ok := openHousehold(w, r)
+	if !ok {
+		return
+	}
+	prefill, err := BuildPrefill(household)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "prefill_failed", "Failed to load your details", "")
+		return
+	}
+	if prefill == nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "no_submissions", "We no longer hold any of your details", "")
+		return
+	}
+	middleware.WriteAPISuccess(w, r, prefill)
+}
+
+// openHousehold checks a sign-in link's code, answering the request itself when it
+// isn't valid. The code is in the URL, so responses are kept out of caches, other
+// sites' logs and search results.
+func openHousehold(w http.ResponseWriter, r *http.Request) (*data.Household, bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Missing code", http.StatusBadRequest)
+		return nil, false
+	}
+	household, err := Open(code)
+	if errors.Is(err, ErrLinkExpired) {
+		logger.LogWarn("Household link refused from %s", logger.GetClientIP(r))
+		http.Error(w, "This link is no longer valid. You can ask for a new one.", http.StatusGone)
+		return nil, false
+	}
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to load your orders", http.StatusInternalServerError)
+		return nil, false
+	}
+	return household, true
+}
@@ -0,0 +1,248 @@
+// Package household groups a family's memberships, event orders and fundraiser
+// donations by the email address they were made with, without user accounts. A family
+// signs in with a link emailed to that address to see their orders across years and
+// to prefill new forms; admins get year-over-year reports of returning families.
+package household
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/security"
+)
+
+var (
+	// ErrInvalidEmail is returned for a sign-in request that isn't for an email address
+	ErrInvalidEmail = errors.New("not a valid email address")
+	// ErrLinkExpired is returned for a sign-in link that is unknown, replaced or too old
+	ErrLinkExpired = errors.New("this link is no longer valid")
+)
+
+var signInEmail = notification.Template{
+	Name: "household sign-in",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			subject, body := email.RenderHouseholdSignIn(d.(email.HouseholdSignInData))
+			return notification.Message{Subject: subject, Body: body}, nil
+		},
+	},
+}
+
+// =============================================================================
+// SIGN-IN
+// =============================================================================
+
+// RequestSignIn emails a sign-in link to emailAddress when a household has it. The
+// caller isn't told whether one does, so the form can't be used to find out who has
+// registered. Sending a link replaces the previous one.
+func RequestSignIn(ctx context.Context, emailAddress string) error {
+	contact := data.NormalizeContact(emailAddress)
+	if address, err := mail.ParseAddress(contact); err != nil || address.Address != contact {
+		return ErrInvalidEmail
+	}
+
+	household, err := data.GetHouseholdByEmail(contact)
+	if err != nil {
+		return err
+	}
+	if household == nil {
+		logger.LogInfo("Household sign-in for an address with no submissions, not sending a link")
+		return nil
+	}
+
+	settings := config.LoadHouseholdSettings()
+	now := clock.Now()
+	if household.LinkSentAt != nil && now.Sub(*household.LinkSentAt) < settings.RequestInterval {
+		logger.LogInfo("Household %d link sent %v ago, not sending another", household.ID,
+			now.Sub(*household.LinkSentAt).Round(time.Second))
+		return nil
+	}
+
+	token, err := security.GenerateResumeToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := now.Add(settings.LinkTTL)
+	if err := data.SetHouseholdLink(household.ID, token, now, expiresAt); err != nil {
+		return err
+	}
+
+	logger.LogInfo("Sending household %d a sign-in link", household.ID)
+	return notification.Send(ctx, notification.Notification{
+		Template: signInEmail,
+		Data: email.HouseholdSignInData{
+			Email:     contact,
+			LinkURL:   LinkURL(token),
+			ExpiresAt: expiresAt.In(clock.Location()),
+		},
+		Recipients: []notification.Recipient{notification.Payer(contact)},
+	})
+}
+
+// LinkURL is the sign-in link with token
+func LinkURL(token string) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://suzuki.nfshost.com"
+	}
+
+	params := url.Values{}
+	params.Set("code", token)
+	return fmt.Sprintf("%s/api/household?%s", strings.TrimRight(baseURL, "/"), params.Encode())
+}
+
+// Open returns the household a sign-in link belongs to, recording that the family
+// proved they read the email sent to the address
+func Open(token string) (*data.Household, error) {
+	household, err := data.GetHouseholdByLinkToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if household == nil || household.LinkExpiresAt == nil || !clock.Now().Before(*household.LinkExpiresAt) {
+		return nil, ErrLinkExpired
+	}
+	if household.VerifiedAt == nil {
+		now := clock.Now()
+		if err := data.MarkHouseholdVerified(household.ID, now); err != nil {
+			return nil, err
+		}
+		household.VerifiedAt = &now
+	}
+	return household, nil
+}
+
+// =============================================================================
+// PREFILL
+// =============================================================================
+
+// Prefill is what a family's newest submission says about them, to fill in new forms
+type Prefill struct {
+	FullName  string         `json:"full_name"`
+	FirstName string         `json:"first_name"`
+	LastName  string         `json:"last_name"`
+	Email     string         `json:"email"`
+	School    string         `json:"school"`
+	Students  []data.Student `json:"students"`
+}
+
+// BuildPrefill returns the details of a household's newest submission, or nil when
+// every submission was anonymized
+func BuildPrefill(household *data.Household) (*Prefill, error) {
+	orders, err := data.ListHouseholdOrders(household.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	newest := orders[len(orders)-1]
+	prefill := &Prefill{FullName: newest.FullName, Email: household.Email, School: newest.School}
+	if newest.Archived {
+		// A family's first form of a new school year is prefilled from the last one
+		contact, err := data.GetArchivedContact(newest.FormType, newest.FormID)
+		if err != nil {
+			return nil, err
+		}
+		prefill.FirstName, prefill.LastName, prefill.Students = contact.FirstName, contact.LastName, contact.Students
+	} else if err := prefillFromSubmission(prefill, newest.FormID); err != nil {
+		return nil, err
+	}
+	if prefill.Students == nil {
+		prefill.Students = []data.Student{}
+	}
+	return prefill, nil
+}
+
+// prefillFromSubmission fills in the names and students of a live submission
+func prefillFromSubmission(prefill *Prefill, formID string) error {
+	sub, err := data.GetSubmissionByFormID(formID)
+	if err != nil {
+		return err
+	}
+	fields := sub.Fields()
+	prefill.FirstName, prefill.LastName, prefill.Students = fields.FirstName, fields.LastName, fields.Students
+	return nil
+}
+
+// =============================================================================
+// REPORTS
+// =============================================================================
+
+// YearSummary is one year of the year-over-year household report
+type YearSummary struct {
+	Year       int
+	Households int     // households with a completed payment that year
+	New        int     // of those, households paying for the first time
+	Returning  int     // of those, households that also paid the year before
+	Previous   int     // households that paid the year before
+	Retention  float64 // share of the previous year's households that paid again
+	Amount     float64
+	ByFormType map[string]float64
+}
+
+// Report summarizes households' completed payments by year, oldest first. Years are
+// counted in the club's time zone.
+func Report() ([]YearSummary, error) {
+	payments, err := data.ListHouseholdPayments()
+	if err != nil {
+		return nil, err
+	}
+
+	paidIn := make(map[int]map[int64]bool)
+	summaries := make(map[int]*YearSummary)
+	for _, payment := range payments {
+		year := payment.PaidAt.In(clock.Location()).Year()
+		summary := summaries[year]
+		if summary == nil {
+			summary = &YearSummary{Year: year, ByFormType: make(map[string]float64)}
+			summaries[year] = summary
+			paidIn[year] = make(map[int64]bool)
+		}
+		summary.Amount += payment.Amount
+		summary.ByFormType[payment.FormType] += payment.Amount
+		paidIn[year][payment.HouseholdID] = true
+	}
+
+	years := make([]int, 0, len(summaries))
+	for year := range summaries {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+
+	report := make([]YearSummary, 0, len(years))
+	seen := make(map[int64]bool)
+	for _, year := range years {
+		summary := summaries[year]
+		summary.Households = len(paidIn[year])
+		for id := range paidIn[year] {
+			if !seen[id] {
+				summary.New++
+			}
+			if paidIn[year-1][id] {
+				summary.Returning++
+			}
+		}
+		for id := range paidIn[year] {
+			seen[id] = true
+		}
+		if summary.Previous = len(paidIn[year-1]); summary.Previous > 0 {
+			summary.Retention = float64(summary.Returning) / float64(summary.Previous)
+		}
+		report = append(report, *summary)
+	}
+	return report, nil
+}
@@ -0,0 +1,78 @@
+package importer
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// maxImportBytes bounds an import's body; the old spreadsheets' few thousand rows
+// are well under it
+const maxImportBytes = 10 << 20
+
+// Handler lets an admin import historical submissions: POST
+// /api/admin/import?type=membership|event|fundraiser with a CSV (text/csv) or JSON
+// (application/json) body inserts them all, or none if any is invalid. With
+// dry_run=true the records are only checked.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	query := r.URL.Query()
+	formType := query.Get("type")
+	if formType != "membership" && formType != "event" && formType != "fundraiser" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_form_type",
+			"type must be membership, event or fundraiser", "")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var records []Record
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		records, err = ReadCSV(body)
+	case "application/json":
+		records, err = ReadJSON(body)
+	default:
+		middleware.WriteAPIError(w, r, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			"Send records as text/csv or application/json", "")
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		middleware.WriteAPIError(w, r, http.StatusRequestEntityTooLarge, "too_large",
+			"Imports are limited to 10 MB; split the file", "")
+		return
+	}
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_records", "Failed to read the records", err.Error())
+		return
+	}
+
+	result, err := Import(formType, records, dryRun)
+	var recordErr *RecordError
+	if errors.As(err, &recordErr) || len(records) == 0 {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_records", "Nothing was imported", err.Error())
+		return
+	}
+	if err != nil {
+		logger.LogError("Failed to import %d %s submissions: %v", len(records), formType, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to import the records", "")
+		return
+	}
+	if !dryRun {
+		logger.LogInfo("Admin from %s imported %d %s submissions", logger.GetClientIP(r), result.Imported, formType)
+	}
+	middleware.WriteAPISuccess(w, r, result)
+}
@@ -0,0 +1,513 @@
+// Package importer brings submissions kept outside the site, such as the spreadsheets
+// the club used before it, into the database. Records are read from CSV in the columns
+// the CSV export writes, or from JSON, and each import is inserted all at once or not
+// at all.
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/security"
+)
+
+// Record is one imported submission. Fields that don't apply to its form type are
+// ignored: membership ones for events, for instance.
+type Record struct {
+	FormID         string         `json:"form_id,omitempty"` // generated when empty
+	SubmissionDate string         `json:"submission_date"`   // 2006-01-02, 1/2/2006 or RFC 3339
+	FullName       string         `json:"full_name,omitempty"`
+	FirstName      string         `json:"first_name,omitempty"`
+	LastName       string         `json:"last_name,omitempty"`
+	Email          string         `json:"email"`
+	School         string         `json:"school,omitempty"`
+	Amount         float64        `json:"amount"`
+	CoverFees      bool           `json:"cover_fees,omitempty"`
+	PaymentStatus  string         `json:"paypal_status,omitempty"` // COMPLETED unless REFUNDED, REVERSED or UNPAID
+	PayPalOrderID  string         `json:"paypal_order_id,omitempty"`
+	SubmittedAt    string         `json:"submitted_at,omitempty"` // when it was paid; the submission date if empty
+	Students       []data.Student `json:"students,omitempty"`
+	Describe       string         `json:"describe,omitempty"`
+
+	// Memberships
+	Membership       string         `json:"membership,omitempty"`
+	MembershipStatus string         `json:"membership_status,omitempty"`
+	Interests        []string       `json:"interests,omitempty"`
+	Addons           []string       `json:"addons,omitempty"`
+	Fees             map[string]int `json:"fees,omitempty"`
+	Donation         float64        `json:"donation,omitempty"`
+
+	// Event registrations
+	Event        string                      `json:"event,omitempty"`
+	FoodChoices  map[string]string           `json:"food_choices,omitempty"`  // by meal and student index, such as lunch_0
+	DietaryNotes map[string]data.DietaryNote `json:"dietary_notes,omitempty"` // by student index
+
+	// Fundraiser donations
+	DonorStatus   string                 `json:"donor_status,omitempty"`
+	TotalAmount   float64                `json:"total_amount,omitempty"`
+	DonationItems []data.StudentDonation `json:"donation_items,omitempty"`
+}
+
+// RecordError is a record that can't be imported, numbered from 1 in the order read
+type RecordError struct {
+	Record int
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.Record, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Result is what an import inserted, or would have on a dry run
+type Result struct {
+	FormType string   `json:"form_type"`
+	Imported int      `json:"imported"`
+	FormIDs  []string `json:"form_ids"`
+	DryRun   bool     `json:"dry_run,omitempty"`
+}
+
+// Import checks every record and inserts them as submissions of formType in one
+// transaction. A dry run only checks them. Imported submissions are already paid,
+// so no emails are sent and no receipts numbered.
+func Import(formType string, records []Record, dryRun bool) (*Result, error) {
+	if formType != "membership" && formType != "event" && formType != "fundraiser" {
+		return nil, fmt.Errorf("unknown form type %q", formType)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("nothing to import")
+	}
+
+	result := &Result{FormType: formType, FormIDs: make([]string, 0, len(records)), DryRun: dryRun}
+	var memberships []data.MembershipSubmission
+	var events []data.EventSubmission
+	var fundraisers []data.FundraiserSubmission
+	for i, record := range records {
+		common, err := record.common(formType, i)
+		if err != nil {
+			return nil, &RecordError{Record: i + 1, Err: err}
+		}
+		result.FormIDs = append(result.FormIDs, common.FormID)
+
+		switch formType {
+		case "membership":
+			memberships = append(memberships, record.membership(common))
+		case "event":
+			sub, err := record.event(common)
+			if err != nil {
+				return nil, &RecordError{Record: i + 1, Err: err}
+			}
+			events = append(events, sub)
+		case "fundraiser":
+			fundraisers = append(fundraisers, record.fundraiser(common))
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	var err error
+	switch formType {
+	case "membership":
+		err = data.InsertMembershipsBatch(memberships)
+	case "event":
+		err = data.InsertEventsBatch(events)
+	case "fundraiser":
+		err = data.InsertFundraisersBatch(fundraisers)
+	}
+	var batchErr *data.BatchError
+	if errors.As(err, &batchErr) {
+		return nil, &RecordError{Record: batchErr.Index + 1, Err: batchErr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Imported = len(records)
+	return result, nil
+}
+
+// submission is what every form type has in common
+type submission struct {
+	FormID         string
+	AccessToken    string
+	SubmissionDate time.Time
+	FullName       string
+	Email          string
+	Status         string
+	Submitted      bool
+	SubmittedAt    *time.Time
+}
+
+// common checks the fields every form type has and fills in the ones left empty
+func (r Record) common(formType string, index int) (submission, error) {
+	var sub submission
+	var err error
+	if sub.SubmissionDate, err = parseDate(r.SubmissionDate); err != nil {
+		return sub, fmt.Errorf("submission_date: %w", err)
+	}
+
+	sub.Email = data.NormalizeContact(r.Email)
+	if address, err := mail.ParseAddress(sub.Email); err != nil || address.Address != sub.Email {
+		return sub, fmt.Errorf("email %q isn't an email address", r.Email)
+	}
+	sub.FullName = strings.TrimSpace(r.FullName)
+	if sub.FullName == "" {
+		sub.FullName = strings.TrimSpace(strings.TrimSpace(r.FirstName) + " " + strings.TrimSpace(r.LastName))
+	}
+	if sub.FullName == "" {
+		return sub, errors.New("a full_name or first_name and last_name are required")
+	}
+	if r.Amount < 0 {
+		return sub, fmt.Errorf("amount %.2f is negative", r.Amount)
+	}
+
+	switch status := strings.ToUpper(strings.TrimSpace(r.PaymentStatus)); status {
+	case "", "COMPLETED", "PAID":
+		sub.Status = "COMPLETED"
+	case "REFUNDED", "REVERSED":
+		sub.Status = status
+	case "UNPAID":
+	default:
+		return sub, fmt.Errorf("paypal_status %q isn't COMPLETED, REFUNDED, REVERSED or UNPAID", r.PaymentStatus)
+	}
+	if sub.Status != "" {
+		sub.Submitted = true
+		paidAt := sub.SubmissionDate
+		if r.SubmittedAt != "" {
+			if paidAt, err = parseDate(r.SubmittedAt); err != nil {
+				return sub, fmt.Errorf("submitted_at: %w", err)
+			}
+		}
+		sub.SubmittedAt = &paidAt
+	}
+
+	sub.FormID = strings.TrimSpace(r.FormID)
+	if sub.FormID == "" {
+		sub.FormID = importedFormID(formType, index, r)
+	} else if owner, err := data.FormTypeFromID(sub.FormID); err != nil || owner != formType {
+		return sub, fmt.Errorf("form_id %q must start with %s-", sub.FormID, formType)
+	}
+	if sub.AccessToken, err = security.GenerateAccessToken(); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+// importedFormID names a record imported without a form ID after its place in the
+// import and its contents, so importing the same file twice is refused as duplicates
+// rather than adding every record again
+func importedFormID(formType string, index int, r Record) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s|%.2f", index, r.SubmissionDate,
+		data.NormalizeContact(r.Email), r.FullName+r.FirstName+r.LastName, r.Amount)))
+	return fmt.Sprintf("%s-import-%s", formType, hex.EncodeToString(hash[:6]))
+}
+
+func (r Record) membership(sub submission) data.MembershipSubmission {
+	fees := r.Fees
+	if fees == nil {
+		fees = map[string]int{}
+	}
+	return data.MembershipSubmission{
+		FormID: sub.FormID, AccessToken: sub.AccessToken, SubmissionDate: sub.SubmissionDate,
+		FullName: sub.FullName, FirstName: strings.TrimSpace(r.FirstName), LastName: strings.TrimSpace(r.LastName),
+		Email: sub.Email, School: strings.TrimSpace(r.School),
+		Membership: r.Membership, MembershipStatus: r.MembershipStatus, Interests: r.Interests, Describe: r.Describe,
+		StudentCount: len(r.Students), Students: r.Students, Fees: fees, Addons: r.Addons, Donation: r.Donation,
+		CalculatedAmount: r.Amount, CoverFees: r.CoverFees, PayPalOrderID: r.PayPalOrderID,
+		PayPalStatus: sub.Status, Submitted: sub.Submitted, SubmittedAt: sub.SubmittedAt,
+	}
+}
+
+func (r Record) event(sub submission) (data.EventSubmission, error) {
+	if strings.TrimSpace(r.Event) == "" {
+		return data.EventSubmission{}, errors.New("event is required")
+	}
+	foodChoices := r.FoodChoices
+	if foodChoices == nil {
+		foodChoices = map[string]string{}
+	}
+	foodChoicesJSON, err := json.Marshal(foodChoices)
+	if err != nil {
+		return data.EventSubmission{}, err
+	}
+	return data.EventSubmission{
+		FormID: sub.FormID, AccessToken: sub.AccessToken, SubmissionDate: sub.SubmissionDate, Event: strings.TrimSpace(r.Event),
+		FullName: sub.FullName, FirstName: strings.TrimSpace(r.FirstName), LastName: strings.TrimSpace(r.LastName),
+		Email: sub.Email, School: strings.TrimSpace(r.School),
+		StudentCount: len(r.Students), Students: r.Students,
+		HasFoodOrders: len(foodChoices) > 0, FoodChoices: foodChoices, FoodChoicesJSON: string(foodChoicesJSON),
+		DietaryNotes:     r.DietaryNotes,
+		CalculatedAmount: r.Amount, CoverFees: r.CoverFees, PayPalOrderID: r.PayPalOrderID,
+		PayPalStatus: sub.Status, Submitted: sub.Submitted, SubmittedAt: sub.SubmittedAt,
+	}, nil
+}
+
+func (r Record) fundraiser(sub submission) data.FundraiserSubmission {
+	total := r.TotalAmount
+	if total == 0 {
+		for _, item := range r.DonationItems {
+			total += item.Amount
+		}
+	}
+	amount := r.Amount
+	if amount == 0 {
+		amount = total
+	}
+	return data.FundraiserSubmission{
+		FormID: sub.FormID, AccessToken: sub.AccessToken, SubmissionDate: sub.SubmissionDate,
+		FullName: sub.FullName, FirstName: strings.TrimSpace(r.FirstName), LastName: strings.TrimSpace(r.LastName),
+		Email: sub.Email, School: strings.TrimSpace(r.School),
+		Describe: r.Describe, DonorStatus: r.DonorStatus,
+		StudentCount: len(r.Students), Students: r.Students, DonationItems: r.DonationItems,
+		TotalAmount: total, CoverFees: r.CoverFees, CalculatedAmount: amount, PayPalOrderID: r.PayPalOrderID,
+		PayPalStatus: sub.Status, Submitted: sub.Submitted, SubmittedAt: sub.SubmittedAt,
+	}
+}
+
+// dateLayouts are the dates records may carry: the export's RFC 3339, ISO days and
+// the US dates spreadsheets write. Days without a time are the start of the day in
+// the club's time zone.
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", "1/2/2006 15:04:05", "1/2/2006 15:04", "1/2/2006"}
+
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, errors.New("a date is required")
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, clock.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a date such as 2024-09-01", value)
+}
+
+// =============================================================================
+// READING RECORDS
+// =============================================================================
+
+// ReadJSON reads records from a JSON array
+func ReadJSON(r io.Reader) ([]Record, error) {
+	var records []Record
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&records); err != nil {
+		return nil, fmt.Errorf("invalid JSON records: %w", err)
+	}
+	return records, nil
+}
+
+// ReadCSV reads records from CSV with a header row naming the columns the CSV export
+// writes: the common ones, student_N_name and student_N_grade, and each form type's
+// own, such as fee_<name> and addon_<name> for memberships, student_N_<meal> for
+// events and donation_N_student and donation_N_amount for fundraisers. Columns it
+// doesn't know, such as receipt_number, are skipped.
+func ReadCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("the CSV has no header row")
+	}
+
+	header := make([]string, len(rows[0]))
+	for i, name := range rows[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	}
+	records := make([]Record, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		cells := make(map[string]string, len(header))
+		blank := true
+		for i, name := range header {
+			if i < len(row) {
+				cells[name] = cell(row[i])
+				blank = blank && cells[name] == ""
+			}
+		}
+		if blank {
+			continue
+		}
+		record, err := csvRecord(cells)
+		if err != nil {
+			return nil, &RecordError{Record: n + 1, Err: err}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// cell undoes the quote the export puts before answers a spreadsheet would read as
+// formulas
+func cell(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func csvRecord(cells map[string]string) (Record, error) {
+	record := Record{
+		FormID: cells["form_id"], SubmissionDate: cells["submission_date"], FullName: cells["full_name"],
+		FirstName: cells["first_name"], LastName: cells["last_name"], Email: cells["email"], School: cells["school"],
+		CoverFees: yes(cells["cover_fees"]), PaymentStatus: cells["paypal_status"], PayPalOrderID: cells["paypal_order_id"],
+		SubmittedAt: cells["submitted_at"], Describe: cells["describe"],
+		Membership: cells["membership"], MembershipStatus: cells["membership_status"],
+		Event: cells["event"], DonorStatus: cells["donor_status"],
+	}
+	for name, target := range map[string]*float64{"amount": &record.Amount, "donation": &record.Donation, "total_amount": &record.TotalAmount} {
+		if value := strings.TrimPrefix(cells[name], "$"); value != "" {
+			parsed, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+			if err != nil {
+				return record, fmt.Errorf("%s %q isn't an amount", name, cells[name])
+			}
+			*target = parsed
+		}
+	}
+	if interests := cells["interests"]; interests != "" {
+		for _, interest := range strings.Split(interests, ";") {
+			record.Interests = append(record.Interests, strings.TrimSpace(interest))
+		}
+	}
+
+	// Numbered columns, read in order so students and donations keep theirs
+	names := make([]string, 0, len(cells))
+	for name := range cells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := cells[name]
+		if value == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, "fee_"):
+			quantity, err := strconv.Atoi(value)
+			if err != nil {
+				return record, fmt.Errorf("%s %q isn't a quantity", name, value)
+			}
+			if record.Fees == nil {
+				record.Fees = map[string]int{}
+			}
+			record.Fees[strings.TrimPrefix(name, "fee_")] = quantity
+		case strings.HasPrefix(name, "addon_"):
+			if yes(value) {
+				record.Addons = append(record.Addons, strings.TrimPrefix(name, "addon_"))
+			}
+		case strings.HasPrefix(name, "student_"):
+			if err := record.studentCell(name, value); err != nil {
+				return record, err
+			}
+		case strings.HasPrefix(name, "donation_"):
+			if err := record.donationCell(name, value); err != nil {
+				return record, err
+			}
+		}
+	}
+	for key, note := range record.DietaryNotes {
+		if index, _ := strconv.Atoi(key); index < len(record.Students) {
+			note.StudentName = record.Students[index].Name
+			record.DietaryNotes[key] = note
+		}
+	}
+	return record, nil
+}
+
+// studentCell reads a student_N_<field> column: the student's name or grade, or for
+// events their dietary notes, allergy or choice of a meal
+func (r *Record) studentCell(name, value string) error {
+	index, field, ok := numbered(name, "student_")
+	if !ok {
+		return nil // student_count
+	}
+	for len(r.Students) <= index {
+		r.Students = append(r.Students, data.Student{})
+	}
+	key := strconv.Itoa(index)
+	switch field {
+	case "name":
+		r.Students[index].Name = value
+	case "grade":
+		r.Students[index].Grade = value
+	case "dietary_notes", "allergy":
+		if field == "allergy" && !yes(value) {
+			return nil
+		}
+		if r.DietaryNotes == nil {
+			r.DietaryNotes = map[string]data.DietaryNote{}
+		}
+		note := r.DietaryNotes[key]
+		if field == "allergy" {
+			note.Allergy = true
+		} else {
+			note.Notes = value
+		}
+		r.DietaryNotes[key] = note
+	default:
+		if r.FoodChoices == nil {
+			r.FoodChoices = map[string]string{}
+		}
+		r.FoodChoices[field+"_"+key] = value
+	}
+	return nil
+}
+
+// donationCell reads a donation_N_student or donation_N_amount column
+func (r *Record) donationCell(name, value string) error {
+	index, field, ok := numbered(name, "donation_")
+	if !ok {
+		return nil
+	}
+	for len(r.DonationItems) <= index {
+		r.DonationItems = append(r.DonationItems, data.StudentDonation{})
+	}
+	switch field {
+	case "student":
+		r.DonationItems[index].StudentName = value
+	case "amount":
+		amount, err := strconv.ParseFloat(strings.TrimPrefix(value, "$"), 64)
+		if err != nil {
+			return fmt.Errorf("%s %q isn't an amount", name, value)
+		}
+		r.DonationItems[index].Amount = amount
+	}
+	return nil
+}
+
+// numbered splits a column such as student_2_grade into the 0-based index 1 and grade
+func numbered(name, prefix string) (int, string, bool) {
+	number, field, ok := strings.Cut(strings.TrimPrefix(name, prefix), "_")
+	n, err := strconv.Atoi(number)
+	if !ok || err != nil || n < 1 || n > 50 {
+		return 0, "", false
+	}
+	return n - 1, field, true
+}
+
+// yes reads the export's "yes" and the other ways spreadsheets say true
+func yes(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "y", "true", "1", "x":
+		return true
+	}
+	return false
+}
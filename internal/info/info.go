@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"sbcbackend/internal/assets"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
 	"sbcbackend/internal/security"
 )
 
@@ -24,7 +27,7 @@ var infoPageTmpl = template.Must(template.New("info.tmpl").Funcs(template.FuncMa
 	"formatDate":        formatDate,
 	"formatDisplayName": formatDisplayName,
 	"lower":             strings.ToLower,
-}).ParseFiles("templates/info.tmpl"))
+}).ParseFS(assets.Templates(), "info.tmpl"))
 
 // Update InfoPageData struct to include events
 type InfoPageData struct {
@@ -38,11 +41,58 @@ type InfoPageData struct {
 	EventEntries       []data.EventSubmission      // Add this
 	FundraiserSummary  data.FundraiserSummary      // Add this if you want
 	FundraiserEntries  []data.FundraiserSubmission // Add this if you want
+	Filter             InfoFilter
+	TotalEntries       int // memberships matching the filter; Entries holds a page of them
+	TotalEventEntries  int
+	HasNewer           bool
+	HasOlder           bool
 	AdminToken         string
 	LastUpdated        time.Time
 	ProcessingDuration string
 }
 
+// infoPageSize is how many rows each submission table shows by default
+const infoPageSize = 200
+
+// InfoFilter narrows the info page to a school, payment status or range of days, and
+// pages back through its submission tables from the most recent
+type InfoFilter struct {
+	Year   int
+	School string
+	Status string // paid or unpaid
+	From   string // YYYY-MM-DD
+	To     string
+	Limit  int
+	Offset int
+}
+
+// PageURL links to the info page with the same filter, skipping offset submissions
+func (f InfoFilter) PageURL(offset int) string {
+	query := url.Values{"year": {strconv.Itoa(f.Year)}}
+	for key, value := range map[string]string{"school": f.School, "status": f.Status, "from": f.From, "to": f.To} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if f.Limit != infoPageSize {
+		query.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	return "/info?" + query.Encode()
+}
+
+// NewerOffset is the offset of the page before this one
+func (f InfoFilter) NewerOffset() int {
+	return max(f.Offset-f.Limit, 0)
+}
+
+// OlderOffset is the offset of the page after this one
+func (f InfoFilter) OlderOffset() int {
+	return f.Offset + f.Limit
+}
+
 type InterestSchoolRow struct {
 	School   string
 	Interest string
@@ -74,8 +124,23 @@ func InfoPageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get fresh data from database
-	entries, err := data.GetMembershipsByYear(year)
+	list, err := middleware.ParseListQuery(r, infoPageSize)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusBadRequest, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	pageFilter := InfoFilter{
+		Year: year, School: query.Get("school"), Status: query.Get("status"),
+		From: query.Get("from"), To: query.Get("to"), Limit: list.Limit, Offset: list.Offset,
+	}
+	filter := data.SubmissionFilter{
+		Year: year, School: pageFilter.School, Status: pageFilter.Status, From: list.From, To: list.To,
+	}
+
+	// The summaries cover everything the filter matches; the tables show a page of it
+	entries, err := data.ListMemberships(filter)
 	if err != nil {
 		logger.LogHTTPError(r, http.StatusInternalServerError, err)
 		http.Error(w, "Failed to load membership data", http.StatusInternalServerError)
@@ -83,19 +148,22 @@ func InfoPageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get event data
-	eventEntries, err := data.GetEventsByYear(year)
+	eventEntries, err := data.ListEvents(filter)
 	if err != nil {
 		logger.LogError("Failed to load event data: %v", err)
 		eventEntries = []data.EventSubmission{} // Continue with empty list
 	}
 
 	// Get fundraiser data
-	fundraiserEntries, err := data.GetFundraisersByYear(year)
+	fundraiserEntries, err := data.ListFundraisers(filter)
 	if err != nil {
 		logger.LogError("Failed to load fundraiser data: %v", err)
 		fundraiserEntries = []data.FundraiserSubmission{}
 	}
 
+	// Count renamed items under their current names
+	resolveItemNames(entries)
+
 	// Compute summaries
 	summary, extras := data.ComputeMembershipSummary(entries)
 	eventSummary := computeEventSummary(eventEntries)
@@ -128,14 +196,19 @@ func InfoPageHandler(w http.ResponseWriter, r *http.Request) {
 	pageData := InfoPageData{
 		Year:               year,
 		Summary:            summary,
-		Entries:            entries,
+		Entries:            newestPage(entries, list.Limit, list.Offset),
 		InterestBySchool:   interestBySchool,
 		Extras:             extras,
 		SortedFeePurchases: sortedFeePurchases,
 		EventSummary:       eventSummary,
-		EventEntries:       eventEntries,
+		EventEntries:       newestPage(eventEntries, list.Limit, list.Offset),
 		FundraiserSummary:  fundraiserSummary,
 		FundraiserEntries:  fundraiserEntries,
+		Filter:             pageFilter,
+		TotalEntries:       len(entries),
+		TotalEventEntries:  len(eventEntries),
+		HasNewer:           list.Offset > 0,
+		HasOlder:           list.Offset+list.Limit < max(len(entries), len(eventEntries)),
 		AdminToken:         adminToken,
 		LastUpdated:        time.Now(),
 		ProcessingDuration: time.Since(startTime).String(),
@@ -155,6 +228,35 @@ func InfoPageHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // Helper functions (kept simple)
+
+// newestPage returns a page of entries, oldest first: the limit most recent after
+// skipping the offset most recent
+func newestPage[T any](entries []T, limit, offset int) []T {
+	end := max(len(entries)-offset, 0)
+	return entries[max(end-limit, 0):end]
+}
+
+// resolveItemNames replaces the item names entries recorded with the inventory's
+// current names, so a renamed membership, add-on or fee isn't split across two rows
+func resolveItemNames(entries []data.MembershipSubmission) {
+	if inventoryService == nil {
+		return
+	}
+	for i := range entries {
+		entry := &entries[i]
+		entry.Membership = inventoryService.ResolveName(entry.Membership)
+		for j, addon := range entry.Addons {
+			entry.Addons[j] = inventoryService.ResolveName(addon)
+		}
+		if len(entry.Fees) > 0 {
+			fees := make(map[string]int, len(entry.Fees))
+			for name, quantity := range entry.Fees {
+				fees[inventoryService.ResolveName(name)] += quantity
+			}
+			entry.Fees = fees
+		}
+	}
+}
 func parseYear(r *http.Request) (int, error) {
 	yearStr := r.URL.Query().Get("year")
 	if yearStr == "" {
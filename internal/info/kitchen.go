@@ -0,0 +1,86 @@
+package info
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/food"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
+)
+
+// Global inventory service for looking up event food options
+var inventoryService *inventory.Service
+
+// SetInventoryService injects the inventory service
+func SetInventoryService(service *inventory.Service) {
+	inventoryService = service
+}
+
+var kitchenReportTmpl = template.Must(template.New("kitchen_report.tmpl").Funcs(template.FuncMap{
+	"formatDate":        formatDate,
+	"formatDisplayName": formatDisplayName,
+}).ParseFS(assets.Templates(), "kitchen_report.tmpl"))
+
+type KitchenReportPageData struct {
+	Year        int
+	Report      food.KitchenReport
+	LastUpdated time.Time
+}
+
+// KitchenReportHandler renders food totals and dietary notes for a single event
+func KitchenReportHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	year, err := parseYear(r)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusBadRequest, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	eventName := strings.TrimSpace(r.URL.Query().Get("event"))
+	if eventName == "" {
+		http.Error(w, "Missing event parameter", http.StatusBadRequest)
+		return
+	}
+
+	if inventoryService == nil {
+		logger.LogError("Inventory service not available for kitchen report")
+		http.Error(w, "Inventory service not available", http.StatusInternalServerError)
+		return
+	}
+
+	eventConfig, exists := inventoryService.GetEventConfig(eventName)
+	if !exists {
+		logger.LogHTTPError(r, http.StatusNotFound, fmt.Errorf("unknown event %s", eventName))
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+
+	eventEntries, err := data.GetEventsByYear(year)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to load event data", http.StatusInternalServerError)
+		return
+	}
+
+	report := food.BuildKitchenReport(eventName, eventConfig, eventEntries)
+	logger.LogInfo("Kitchen report generated for %s %d (orders: %d, allergies: %d)",
+		eventName, year, report.OrderCount, report.AllergyCount)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := kitchenReportTmpl.Execute(w, KitchenReportPageData{
+		Year:        year,
+		Report:      report,
+		LastUpdated: time.Now(),
+	}); err != nil {
+		logger.LogError("Failed to render kitchen report template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
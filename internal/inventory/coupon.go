@@ -0,0 +1,155 @@
+package inventory
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/currency"
+)
+
+// Coupon types
+const (
+	CouponFixed   = "fixed"
+	CouponPercent = "percent"
+)
+
+// Form types a coupon can apply to
+var couponFormTypes = map[string]bool{"membership": true, "event": true}
+
+// NormalizeCouponCode is the form a code is looked up and recorded in: codes are
+// case-insensitive and families paste them with stray spaces
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// SetCouponCounter sets how the service counts a coupon's uses for MaxUses, usually
+// data.CountCouponUses. Without one, usage limits are not enforced.
+func (s *Service) SetCouponCounter(counter func(code string) (int, error)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.couponUses = counter
+}
+
+// ValidateCoupon checks that code can be used on a formType checkout right now
+func (s *Service) ValidateCoupon(code, formType string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, err := s.coupon(code, formType)
+	return err
+}
+
+// CouponMaxUses returns how many paid checkouts may use code, 0 when there's no limit
+// or no such coupon
+func (s *Service) CouponMaxUses(code string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.coupons[NormalizeCouponCode(code)].MaxUses
+}
+
+// coupon finds a usable coupon for a formType checkout. Callers hold the lock.
+func (s *Service) coupon(code, formType string) (Coupon, error) {
+	code = NormalizeCouponCode(code)
+	coupon, exists := s.coupons[code]
+	if !exists || !coupon.Available {
+		return Coupon{}, fmt.Errorf("coupon %s is not valid", code)
+	}
+	if !coupon.appliesTo(formType) {
+		return Coupon{}, fmt.Errorf("coupon %s can't be used on %s orders", code, formType)
+	}
+
+	now := clock.Now()
+	if from, ok := couponDate(coupon.ValidFrom, false); ok && now.Before(from) {
+		return Coupon{}, fmt.Errorf("coupon %s is not valid yet", code)
+	}
+	if until, ok := couponDate(coupon.ValidUntil, true); ok && now.After(until) {
+		return Coupon{}, fmt.Errorf("coupon %s has expired", code)
+	}
+
+	if coupon.MaxUses > 0 && s.couponUses != nil {
+		uses, err := s.couponUses(code)
+		if err != nil {
+			return Coupon{}, fmt.Errorf("failed to check uses of coupon %s: %w", code, err)
+		}
+		if uses >= coupon.MaxUses {
+			return Coupon{}, fmt.Errorf("coupon %s has been used up", code)
+		}
+	}
+	return coupon, nil
+}
+
+// applyCoupon validates code for a formType checkout and returns its discount on
+// subtotal, which never takes the subtotal below zero. No code is no discount.
+// Callers hold the lock.
+func (s *Service) applyCoupon(code, formType string, subtotal float64) (float64, error) {
+	if strings.TrimSpace(code) == "" {
+		return 0, nil
+	}
+	coupon, err := s.coupon(code, formType)
+	if err != nil {
+		return 0, err
+	}
+
+	discount := coupon.Amount
+	if coupon.Type == CouponPercent {
+		discount = subtotal * coupon.Amount / 100
+	}
+	discount = math.Min(math.Max(discount, 0), subtotal)
+	return roundCents(discount), nil
+}
+
+func (c Coupon) appliesTo(formType string) bool {
+	if len(c.AppliesTo) == 0 {
+		return true
+	}
+	for _, t := range c.AppliesTo {
+		if t == formType {
+			return true
+		}
+	}
+	return false
+}
+
+// couponDate parses a coupon's valid_from or valid_until. A date-only valid_until
+// lasts through the end of that day.
+func couponDate(value string, endOfDay bool) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, clock.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Add(-time.Second), true
+	}
+	return day, true
+}
+
+// AddProcessingFees adds what PayPal charges to total when the family chose to cover
+// it, rounded to cents
+func AddProcessingFees(total float64, coverFees bool) float64 {
+	if coverFees {
+		total = total*1.02 + 0.49
+	}
+	return roundCents(total)
+}
+
+// RoundUpDonation is what rounding total up to the next whole unit of the currency with
+// ISO code adds, given to the program as a donation; 0 when total is already whole
+func RoundUpDonation(total float64, code string) float64 {
+	total = currency.Round(total, code)
+	return currency.Round(math.Ceil(total)-total, code)
+}
+
+// roundCents rounds to 2 decimal places to prevent floating point issues
+func roundCents(amount float64) float64 {
+	return float64(int(amount*100+0.5)) / 100
+}
@@ -0,0 +1,82 @@
+// internal/inventory/currency.go
+package inventory
+
+import (
+	"fmt"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+)
+
+// Currency is what the catalog is priced in: the file's currency, or the
+// deployment's CURRENCY when it names none
+func (s *Service) Currency() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.defaultCurrency()
+}
+
+// defaultCurrency is Currency for callers that hold the lock
+func (s *Service) defaultCurrency() string {
+	if s.currency != "" {
+		return s.currency
+	}
+	return config.Currency()
+}
+
+// itemCurrency is the currency of an item priced in code, which is the catalog's
+// unless the item names its own
+func (s *Service) itemCurrency(code string) string {
+	if code = currency.Normalize(code); code != "" {
+		return code
+	}
+	return s.defaultCurrency()
+}
+
+// MembershipCurrency returns the currency a membership checkout is charged in. A
+// single PayPal order has one currency, so items priced in different ones can't
+// be bought together.
+func (s *Service) MembershipCurrency(membership string, addons []string, fees map[string]int) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.membershipCurrency(membership, addons, fees)
+}
+
+// membershipCurrency is MembershipCurrency for callers that hold the lock
+func (s *Service) membershipCurrency(membership string, addons []string, fees map[string]int) (string, error) {
+	code := s.itemCurrency(s.memberships[membership].Currency)
+	check := func(name, itemCode string) error {
+		if itemCode = s.itemCurrency(itemCode); itemCode != code {
+			return fmt.Errorf("%s is priced in %s but %s is priced in %s", name, itemCode, membership, code)
+		}
+		return nil
+	}
+	for _, addon := range addons {
+		if err := check(addon, s.products[addon].Currency); err != nil {
+			return "", err
+		}
+	}
+	for feeName, quantity := range fees {
+		if quantity <= 0 {
+			continue
+		}
+		if err := check(feeName, s.fees[feeName].Currency); err != nil {
+			return "", err
+		}
+	}
+	return code, nil
+}
+
+// EventCurrency returns the currency an event's orders are charged in
+func (s *Service) EventCurrency(eventName string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.itemCurrency(s.events[eventName].Currency)
+}
+
+// lintCurrency checks that code, when set, is one checkout can charge in
+func lintCurrency(add addProblem, path, code string) {
+	if code != "" && !currency.Supported(code) {
+		add(false, path, "currency %q is not supported", code)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/currency"
 	"sbcbackend/internal/logger"
 )
 
@@ -16,6 +18,12 @@ type Service struct {
 	products    map[string]ProductItem
 	fees        map[string]FeeItem
 	events      map[string]EventConfig
+	aliases     map[string]string
+	coupons     map[string]Coupon // by normalized code
+	currency    string            // the file's currency, empty for the deployment's
+
+	// couponUses counts a coupon's paid uses for MaxUses
+	couponUses func(code string) (int, error)
 
 	// Quick lookup maps (for performance and backward compatibility)
 	membershipPrices map[string]float64
@@ -33,6 +41,8 @@ func NewService() *Service {
 		products:         make(map[string]ProductItem),
 		fees:             make(map[string]FeeItem),
 		events:           make(map[string]EventConfig),
+		aliases:          make(map[string]string),
+		coupons:          make(map[string]Coupon),
 		membershipPrices: make(map[string]float64),
 		productPrices:    make(map[string]float64),
 		feePrices:        make(map[string]float64),
@@ -125,6 +135,13 @@ func (s *Service) IsStale(maxAge time.Duration) bool {
 	return time.Since(s.lastLoaded) > maxAge
 }
 
+// Loaded reports whether inventory has been loaded successfully at least once
+func (s *Service) Loaded() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return !s.lastLoaded.IsZero()
+}
+
 // Get cache age for debugging
 func (s *Service) CacheAge() time.Duration {
 	s.mutex.RLock()
@@ -169,6 +186,18 @@ func (s *Service) populateFromUnified(inventory InventoryData) {
 
 	// Populate events
 	s.events = inventory.Events
+
+	s.aliases = make(map[string]string)
+	for oldName, name := range inventory.Aliases {
+		s.aliases[oldName] = name
+	}
+
+	s.coupons = make(map[string]Coupon)
+	for _, coupon := range inventory.Coupons {
+		s.coupons[NormalizeCouponCode(coupon.Code)] = coupon
+	}
+
+	s.currency = currency.Normalize(inventory.Currency)
 }
 
 // Populate from legacy file data
@@ -178,6 +207,9 @@ func (s *Service) populateFromLegacy(memberships, products, fees []LegacyItem, e
 	s.products = make(map[string]ProductItem)
 	s.fees = make(map[string]FeeItem)
 	s.events = make(map[string]EventConfig)
+	s.aliases = make(map[string]string)
+	s.coupons = make(map[string]Coupon)
+	s.currency = ""
 	s.membershipPrices = make(map[string]float64)
 	s.productPrices = make(map[string]float64)
 	s.feePrices = make(map[string]float64)
@@ -337,14 +369,20 @@ func (s *Service) ValidateAllSelections(membership string, addons []string, fees
 	return nil
 }
 
-// CalculateMembershipTotal calculates the total cost with tamper protection
-func (s *Service) CalculateMembershipTotal(membership string, addons []string, fees map[string]int, donation float64, coverFees bool) (float64, error) {
+// CalculateMembershipTotal calculates the total cost with tamper protection, along
+// with the discount a coupon took off it. The coupon applies to the membership, add-ons
+// and fees but not the donation; pass "" for none. The total is in MembershipCurrency.
+func (s *Service) CalculateMembershipTotal(membership string, addons []string, fees map[string]int, donation float64, coverFees bool, coupon string) (float64, float64, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	// Validate all selections first
 	if err := s.ValidateAllSelections(membership, addons, fees); err != nil {
-		return 0, fmt.Errorf("validation failed: %w", err)
+		return 0, 0, fmt.Errorf("validation failed: %w", err)
+	}
+	code, err := s.membershipCurrency(membership, addons, fees)
+	if err != nil {
+		return 0, 0, fmt.Errorf("validation failed: %w", err)
 	}
 
 	// Calculate base total
@@ -362,49 +400,74 @@ func (s *Service) CalculateMembershipTotal(membership string, addons []string, f
 		}
 	}
 
+	discount, err := s.applyCoupon(coupon, "membership", total)
+	if err != nil {
+		return 0, 0, err
+	}
+	total -= discount
+
 	// Add donation
 	if donation > 0 {
 		total += donation
 	}
 
 	// Apply processing fees if requested
-	if coverFees {
-		total = total*1.02 + 0.49
-	}
-
-	// Round to 2 decimal places to prevent floating point issues
-	total = float64(int(total*100+0.5)) / 100
-
-	return total, nil
+	return currency.Round(AddProcessingFees(total, coverFees), code), discount, nil
 }
 
-// GetMembershipPrice returns the price for a specific membership
+// GetMembershipPrice returns the price for a specific membership, following an alias
+// when it has since been renamed
 func (s *Service) GetMembershipPrice(name string) (float64, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	price, exists := s.membershipPrices[name]
+	price, exists := s.membershipPrices[s.resolve(name)]
 	return price, exists
 }
 
-// GetProductPrice returns the price for a specific product
+// GetProductPrice returns the price for a specific product, following an alias when
+// it has since been renamed
 func (s *Service) GetProductPrice(name string) (float64, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	price, exists := s.productPrices[name]
+	price, exists := s.productPrices[s.resolve(name)]
 	return price, exists
 }
 
-// GetFeePrice returns the price for a specific fee
+// GetFeePrice returns the price for a specific fee, following an alias when it has
+// since been renamed
 func (s *Service) GetFeePrice(name string) (float64, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	price, exists := s.feePrices[name]
+	price, exists := s.feePrices[s.resolve(name)]
 	return price, exists
 }
 
+// ResolveName returns the current name of an item a submission recorded, which is
+// the name itself unless the catalog has renamed it
+func (s *Service) ResolveName(name string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.resolve(name)
+}
+
+// resolve follows aliases to the current name. Aliases can chain through several
+// renames; a loop stops at the last name before it repeats. Callers hold the lock.
+func (s *Service) resolve(name string) string {
+	seen := map[string]bool{name: true}
+	for {
+		next, ok := s.aliases[name]
+		if !ok || seen[next] {
+			return name
+		}
+		seen[next] = true
+		name = next
+	}
+}
+
 // =============================================================================
 // EVENT METHODS (for future integration)
 // =============================================================================
@@ -418,6 +481,28 @@ func (s *Service) GetEventConfig(eventName string) (EventConfig, bool) {
 	return config, exists
 }
 
+// GetEventChangeCutoff returns when post-payment changes close for an event.
+// A date-only cutoff stays open through the end of that day.
+func (s *Service) GetEventChangeCutoff(eventName string) (time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	eventConfig, exists := s.events[eventName]
+	if !exists || eventConfig.ChangeCutoff == "" {
+		return time.Time{}, false
+	}
+
+	if cutoff, err := time.Parse(time.RFC3339, eventConfig.ChangeCutoff); err == nil {
+		return cutoff, true
+	}
+	if day, err := time.ParseInLocation("2006-01-02", eventConfig.ChangeCutoff, clock.Location()); err == nil {
+		return day.AddDate(0, 0, 1).Add(-time.Second), true
+	}
+
+	logger.LogWarn("Invalid change_cutoff %q for event %s", eventConfig.ChangeCutoff, eventName)
+	return time.Time{}, false
+}
+
 // ValidateEventSelection validates event selections
 func (s *Service) ValidateEventSelection(eventName string, studentSelections map[string]map[string]bool, sharedSelections map[string]int) error {
 	s.mutex.RLock()
@@ -453,14 +538,56 @@ func (s *Service) ValidateEventSelection(eventName string, studentSelections map
 	return nil
 }
 
-// CalculateEventTotal calculates total cost for event selections
-func (s *Service) CalculateEventTotal(eventName string, studentSelections map[string]map[string]bool, sharedSelections map[string]int, coverFees bool) (float64, error) {
+// ValidateFoodOnlyChange ensures a change to paid event selections only touches food options
+func (s *Service) ValidateFoodOnlyChange(eventName string, oldStudent, newStudent map[string]map[string]bool, oldShared, newShared map[string]int) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	eventConfig, exists := s.events[eventName]
+	if !exists {
+		return fmt.Errorf("event not found: %s", eventName)
+	}
+
+	for optionKey, option := range eventConfig.PerStudentOptions {
+		if option.IsFood {
+			continue
+		}
+		for studentIndex := range mergeStudentKeys(oldStudent, newStudent) {
+			if oldStudent[studentIndex][optionKey] != newStudent[studentIndex][optionKey] {
+				return fmt.Errorf("option %s for student %s cannot be changed after payment", optionKey, studentIndex)
+			}
+		}
+	}
+
+	for optionKey, option := range eventConfig.SharedOptions {
+		if !option.IsFood && oldShared[optionKey] != newShared[optionKey] {
+			return fmt.Errorf("option %s cannot be changed after payment", optionKey)
+		}
+	}
+
+	return nil
+}
+
+func mergeStudentKeys(a, b map[string]map[string]bool) map[string]struct{} {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// CalculateEventTotal calculates total cost for event selections, in the event's
+// currency, along with the discount a coupon took off it; pass "" for no coupon
+func (s *Service) CalculateEventTotal(eventName string, studentSelections map[string]map[string]bool, sharedSelections map[string]int, coverFees bool, coupon string) (float64, float64, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	// Validate selections first
 	if err := s.ValidateEventSelection(eventName, studentSelections, sharedSelections); err != nil {
-		return 0, fmt.Errorf("validation failed: %w", err)
+		return 0, 0, fmt.Errorf("validation failed: %w", err)
 	}
 
 	eventConfig := s.events[eventName]
@@ -484,15 +611,14 @@ func (s *Service) CalculateEventTotal(eventName string, studentSelections map[st
 		}
 	}
 
-	// Apply processing fees if requested
-	if coverFees {
-		total = total*1.02 + 0.49
+	discount, err := s.applyCoupon(coupon, "event", total)
+	if err != nil {
+		return 0, 0, err
 	}
+	total -= discount
 
-	// Round to 2 decimal places
-	total = float64(int(total*100+0.5)) / 100
-
-	return total, nil
+	// Apply processing fees if requested
+	return currency.Round(AddProcessingFees(total, coverFees), s.itemCurrency(eventConfig.Currency)), discount, nil
 }
 
 // =============================================================================
@@ -554,6 +680,9 @@ func (s *Service) GetStats() map[string]interface{} {
 		"products_count":    len(s.products),
 		"fees_count":        len(s.fees),
 		"events_count":      len(s.events),
+		"aliases_count":     len(s.aliases),
+		"coupons_count":     len(s.coupons),
+		"currency":          s.defaultCurrency(),
 		"last_loaded":       s.lastLoaded,
 		"cache_age":         time.Since(s.lastLoaded).String(),
 	}
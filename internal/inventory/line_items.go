@@ -0,0 +1,77 @@
+// internal/inventory/line_items.go
+package inventory
+
+// LineItem is one priced line of a checkout, as the summary page and the payer's
+// receipt list it
+type LineItem struct {
+	Name     string
+	Price    float64 // of one
+	Quantity int
+	Donation bool
+}
+
+// MembershipLineItems lists the catalog items a membership checkout charges for, at
+// their current prices. Names a submission recorded before a rename are followed to
+// the current item; ones no longer in the catalog are left out.
+func (s *Service) MembershipLineItems(membership string, addons []string, fees map[string]int) []LineItem {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var items []LineItem
+	if price, ok := s.membershipPrices[s.resolve(membership)]; ok {
+		items = append(items, LineItem{Name: s.resolve(membership), Price: price, Quantity: 1})
+	}
+	for _, addon := range addons {
+		if price, ok := s.productPrices[s.resolve(addon)]; ok {
+			items = append(items, LineItem{Name: s.resolve(addon), Price: price, Quantity: 1})
+		}
+	}
+	for _, name := range sortedKeys(fees) {
+		if price, ok := s.feePrices[s.resolve(name)]; ok && fees[name] > 0 {
+			items = append(items, LineItem{Name: s.resolve(name), Price: price, Quantity: fees[name]})
+		}
+	}
+	return items
+}
+
+// EventLineItems lists the options an event checkout charges for, each per-student
+// option once with the number of students who chose it
+func (s *Service) EventLineItems(eventName string, studentSelections map[string]map[string]bool, sharedSelections map[string]int) []LineItem {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	eventConfig, ok := s.events[eventName]
+	if !ok {
+		return nil
+	}
+
+	chosen := make(map[string]int)
+	for _, selections := range studentSelections {
+		for optionKey, isSelected := range selections {
+			if isSelected {
+				chosen[optionKey]++
+			}
+		}
+	}
+
+	var items []LineItem
+	for _, key := range sortedKeys(chosen) {
+		if option, ok := eventConfig.PerStudentOptions[key]; ok {
+			items = append(items, LineItem{Name: optionName(key, option), Price: option.Price, Quantity: chosen[key]})
+		}
+	}
+	for _, key := range sortedKeys(sharedSelections) {
+		if option, ok := eventConfig.SharedOptions[key]; ok && sharedSelections[key] > 0 {
+			items = append(items, LineItem{Name: optionName(key, option), Price: option.Price, Quantity: sharedSelections[key]})
+		}
+	}
+	return items
+}
+
+// optionName is how an event option is shown: its label, or its key without one
+func optionName(key string, option EventOption) string {
+	if option.Label != "" {
+		return option.Label
+	}
+	return key
+}
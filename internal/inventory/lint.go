@@ -0,0 +1,481 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Problem is one issue Lint found in an inventory file. Errors would break checkout
+// or silently drop items; warnings are worth a look before deploying.
+type Problem struct {
+	Path    string // where in the file, e.g. events.spring-festival.shared_options.program
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", level, p.Path, p.Message)
+}
+
+// Event names end up in order page paths and form values, so they should be URL-safe
+var eventNamePattern = regexp.MustCompile(`^[a-z0-9]+([-_][a-z0-9]+)*$`)
+
+// Top-level sections of inventory.json; processing_fees is read by the checkout pages
+var inventorySections = map[string]bool{
+	"memberships": true, "products": true, "fees": true, "events": true, "processing_fees": true,
+	"aliases": true, "coupons": true, "currency": true,
+}
+
+// Lint validates a unified inventory file and returns what it parsed along with every
+// problem found. Unlike LoadFromUnifiedFile, which accepts anything that parses, it
+// rejects unknown fields so a misspelled "available" can't quietly hide an item.
+func Lint(raw []byte) (InventoryData, []Problem) {
+	var inventory InventoryData
+	var problems []Problem
+	add := func(warning bool, path, format string, args ...interface{}) {
+		problems = append(problems, Problem{Path: path, Message: fmt.Sprintf(format, args...), Warning: warning})
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sections); err != nil {
+		add(false, "file", "not valid JSON: %v", err)
+		return inventory, problems
+	}
+	for _, name := range sortedKeys(sections) {
+		if !inventorySections[name] {
+			add(true, name, "unknown section is ignored")
+		}
+	}
+
+	decode := func(section string, v interface{}) bool {
+		body, ok := sections[section]
+		if !ok {
+			add(true, section, "section is missing")
+			return false
+		}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(v); err != nil {
+			add(false, section, "%v", err)
+			return false
+		}
+		return true
+	}
+
+	if decode("memberships", &inventory.Memberships) {
+		for i, item := range inventory.Memberships {
+			lintItem(add, fmt.Sprintf("memberships[%d]", i), item.ID, item.Name, item.Price, item.Available)
+			lintCurrency(add, fmt.Sprintf("memberships[%d].currency", i), item.Currency)
+		}
+		lintUnique(add, "memberships", len(inventory.Memberships), func(i int) (string, string) {
+			return inventory.Memberships[i].ID, inventory.Memberships[i].Name
+		})
+	}
+	if decode("products", &inventory.Products) {
+		for i, item := range inventory.Products {
+			lintItem(add, fmt.Sprintf("products[%d]", i), item.ID, item.Name, item.Price, item.Available)
+			lintCurrency(add, fmt.Sprintf("products[%d].currency", i), item.Currency)
+		}
+		lintUnique(add, "products", len(inventory.Products), func(i int) (string, string) {
+			return inventory.Products[i].ID, inventory.Products[i].Name
+		})
+	}
+	decode("events", &inventory.Events)
+	if decode("fees", &inventory.Fees) {
+		for i, item := range inventory.Fees {
+			path := fmt.Sprintf("fees[%d]", i)
+			lintItem(add, path, item.ID, item.Name, item.Price, item.Available)
+			lintCurrency(add, path+".currency", item.Currency)
+			// Fees tied to an event must name one that is configured
+			if item.Event != "" {
+				if _, ok := inventory.Events[item.Event]; !ok {
+					add(false, path, "event %q is not configured under events", item.Event)
+				}
+			}
+		}
+		lintUnique(add, "fees", len(inventory.Fees), func(i int) (string, string) {
+			return inventory.Fees[i].ID, inventory.Fees[i].Name
+		})
+	}
+
+	for _, name := range sortedKeys(inventory.Events) {
+		lintEvent(add, "events."+name, name, inventory.Events[name])
+	}
+
+	// Aliases are optional; most catalogs have never renamed anything
+	if _, ok := sections["aliases"]; ok && decode("aliases", &inventory.Aliases) {
+		lintAliases(add, inventory)
+	}
+
+	// So are coupons
+	if _, ok := sections["coupons"]; ok && decode("coupons", &inventory.Coupons) {
+		lintCoupons(add, inventory.Coupons)
+	}
+
+	// And a currency, for catalogs not priced in the deployment's
+	if _, ok := sections["currency"]; ok && decode("currency", &inventory.Currency) {
+		lintCurrency(add, "currency", inventory.Currency)
+	}
+
+	return inventory, problems
+}
+
+type addProblem func(warning bool, path, format string, args ...interface{})
+
+func lintItem(add addProblem, path, id, name string, price float64, available bool) {
+	if strings.TrimSpace(id) == "" {
+		add(false, path, "id is required")
+	}
+	if strings.TrimSpace(name) == "" {
+		add(false, path, "name is required")
+	}
+	lintPrice(add, path, price)
+	if !available {
+		add(true, path, "%q is not available and won't be offered", name)
+	}
+}
+
+func lintPrice(add addProblem, path string, price float64) {
+	if price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+		add(false, path, "price %v must be zero or more", price)
+	} else if math.Abs(price*100-math.Round(price*100)) > 1e-6 {
+		add(false, path, "price %v has fractions of a cent", price)
+	}
+}
+
+// lintUnique reports IDs or names used twice in a section; items are looked up by name,
+// so a duplicate silently replaces the earlier one
+func lintUnique(add addProblem, section string, n int, item func(int) (id, name string)) {
+	ids := make(map[string]int)
+	names := make(map[string]int)
+	for i := 0; i < n; i++ {
+		id, name := item(i)
+		if first, ok := ids[id]; ok && id != "" {
+			add(false, fmt.Sprintf("%s[%d]", section, i), "id %q is also used by %s[%d]", id, section, first)
+		} else {
+			ids[id] = i
+		}
+		if first, ok := names[name]; ok && name != "" {
+			add(false, fmt.Sprintf("%s[%d]", section, i), "name %q is also used by %s[%d]", name, section, first)
+		} else {
+			names[name] = i
+		}
+	}
+}
+
+// lintAliases checks that each old name leads to an item that exists. An alias for a
+// name still in the catalog would redirect that item's orders, so it is an error too.
+func lintAliases(add addProblem, inventory InventoryData) {
+	available := make(map[string]bool)
+	for _, item := range inventory.Memberships {
+		available[item.Name] = item.Available
+	}
+	for _, item := range inventory.Products {
+		available[item.Name] = item.Available
+	}
+	for _, item := range inventory.Fees {
+		available[item.Name] = item.Available
+	}
+
+	for _, oldName := range sortedKeys(inventory.Aliases) {
+		path := fmt.Sprintf("aliases[%q]", oldName)
+		if _, ok := available[oldName]; ok {
+			add(false, path, "%q is still an item name, so its orders would be shown as another item", oldName)
+			continue
+		}
+
+		name, seen, looped := oldName, map[string]bool{oldName: true}, false
+		for next, ok := inventory.Aliases[name]; ok; next, ok = inventory.Aliases[name] {
+			if looped = seen[next]; looped {
+				add(false, path, "aliases loop back to %q", next)
+				break
+			}
+			seen[next] = true
+			name = next
+		}
+		if looped {
+			continue
+		}
+		if isAvailable, ok := available[name]; !ok {
+			add(false, path, "no membership, product or fee is named %q", name)
+		} else if !isAvailable {
+			add(true, path, "%q is not available, so old orders won't show its price", name)
+		}
+	}
+}
+
+// lintCoupons checks each coupon can be applied as written. Codes are matched without
+// regard to case, so two codes differing only in case collide.
+func lintCoupons(add addProblem, coupons []Coupon) {
+	codes := make(map[string]int)
+	for i, coupon := range coupons {
+		path := fmt.Sprintf("coupons[%d]", i)
+		code := NormalizeCouponCode(coupon.Code)
+		if code == "" {
+			add(false, path, "code is required")
+		} else if first, ok := codes[code]; ok {
+			add(false, path, "code %q is also used by coupons[%d]", coupon.Code, first)
+		} else {
+			codes[code] = i
+		}
+
+		switch coupon.Type {
+		case CouponFixed:
+			if coupon.Amount <= 0 {
+				add(false, path, "amount %v must be more than zero", coupon.Amount)
+			} else {
+				lintPrice(add, path, coupon.Amount)
+			}
+		case CouponPercent:
+			if coupon.Amount <= 0 || coupon.Amount > 100 {
+				add(false, path, "percent %v must be more than 0 and at most 100", coupon.Amount)
+			}
+		default:
+			add(false, path, "type %q must be %q or %q", coupon.Type, CouponFixed, CouponPercent)
+		}
+
+		for _, formType := range coupon.AppliesTo {
+			if !couponFormTypes[formType] {
+				add(false, path, "applies_to %q must be membership or event", formType)
+			}
+		}
+		from, fromOK := couponDate(coupon.ValidFrom, false)
+		if coupon.ValidFrom != "" && !fromOK {
+			add(false, path+".valid_from", "%q is neither a date (2006-01-02) nor RFC3339", coupon.ValidFrom)
+		}
+		until, untilOK := couponDate(coupon.ValidUntil, true)
+		if coupon.ValidUntil != "" && !untilOK {
+			add(false, path+".valid_until", "%q is neither a date (2006-01-02) nor RFC3339", coupon.ValidUntil)
+		}
+		if fromOK && untilOK && until.Before(from) {
+			add(false, path, "valid_until is before valid_from, so the coupon never works")
+		}
+		if coupon.MaxUses < 0 {
+			add(false, path, "max_uses %d must be zero (no limit) or more", coupon.MaxUses)
+		}
+		if !coupon.Available {
+			add(true, path, "coupon %q is not available and won't be accepted", coupon.Code)
+		}
+	}
+}
+
+func lintEvent(add addProblem, path, name string, event EventConfig) {
+	if !eventNamePattern.MatchString(name) {
+		add(true, path, "event name should be lowercase words joined by hyphens")
+	}
+	if len(event.PerStudentOptions) == 0 && len(event.SharedOptions) == 0 {
+		add(true, path, "event has no options")
+	}
+
+	lintCurrency(add, path+".currency", event.Currency)
+
+	if event.ChangeCutoff != "" {
+		_, errRFC := time.Parse(time.RFC3339, event.ChangeCutoff)
+		_, errDay := time.Parse("2006-01-02", event.ChangeCutoff)
+		if errRFC != nil && errDay != nil {
+			add(false, path+".change_cutoff", "%q is neither a date (2006-01-02) nor RFC3339", event.ChangeCutoff)
+		}
+	}
+
+	for _, tmpl := range []struct{ field, name string }{
+		{"order_page_template", event.OrderPageTemplate},
+		{"print_template", event.PrintTemplate},
+	} {
+		if tmpl.name != "" && (!fs.ValidPath(tmpl.name) || strings.Contains(tmpl.name, "/")) {
+			add(false, path+"."+tmpl.field, "%q should be the name of a file in the template directory", tmpl.name)
+		}
+	}
+
+	groups := make(map[string]int)
+	for _, kind := range []struct {
+		section string
+		options map[string]EventOption
+	}{{"per_student_options", event.PerStudentOptions}, {"shared_options", event.SharedOptions}} {
+		for _, key := range sortedKeys(kind.options) {
+			option := kind.options[key]
+			optionPath := fmt.Sprintf("%s.%s.%s", path, kind.section, key)
+
+			if strings.TrimSpace(option.Label) == "" {
+				add(false, optionPath, "label is required")
+			}
+			lintPrice(add, optionPath, option.Price)
+			if option.MaxQuantity < 0 {
+				add(false, optionPath, "max_quantity %d must be zero (no limit) or more", option.MaxQuantity)
+			}
+			if option.MaxQuantity > 0 && kind.section == "per_student_options" {
+				add(true, optionPath, "max_quantity only applies to shared options")
+			}
+			if _, ok := event.PerStudentOptions[key]; ok && kind.section == "shared_options" {
+				add(true, optionPath, "key is also a per-student option, which makes orders hard to read")
+			}
+			if option.ExclusiveGroup != "" {
+				groups[option.ExclusiveGroup]++
+			}
+		}
+	}
+	for _, group := range sortedKeys(groups) {
+		if groups[group] < 2 {
+			add(true, path, "exclusive_group %q has only one option", group)
+		}
+	}
+}
+
+// Diff describes how next differs from current, one line per added, removed or
+// changed item or event option
+func Diff(current, next InventoryData) []string {
+	var changes []string
+	if current.Currency != next.Currency {
+		changes = append(changes, fmt.Sprintf("~ currency %q -> %q", current.Currency, next.Currency))
+	}
+
+	type item struct {
+		price     float64
+		available bool
+		currency  string
+	}
+	diffItems := func(section string, before, after map[string]item) {
+		for _, name := range sortedKeys(before) {
+			if _, ok := after[name]; !ok {
+				changes = append(changes, fmt.Sprintf("- %s %q", section, name))
+			}
+		}
+		for _, name := range sortedKeys(after) {
+			was, ok := before[name]
+			now := after[name]
+			switch {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("+ %s %q at $%.2f", section, name, now.price))
+			case was.price != now.price:
+				changes = append(changes, fmt.Sprintf("~ %s %q price $%.2f -> $%.2f", section, name, was.price, now.price))
+			}
+			if ok && was.available != now.available {
+				changes = append(changes, fmt.Sprintf("~ %s %q available %v -> %v", section, name, was.available, now.available))
+			}
+			if ok && was.currency != now.currency {
+				changes = append(changes, fmt.Sprintf("~ %s %q currency %q -> %q", section, name, was.currency, now.currency))
+			}
+		}
+	}
+
+	memberships := func(inv InventoryData) map[string]item {
+		items := make(map[string]item)
+		for _, m := range inv.Memberships {
+			items[m.Name] = item{m.Price, m.Available, m.Currency}
+		}
+		return items
+	}
+	products := func(inv InventoryData) map[string]item {
+		items := make(map[string]item)
+		for _, p := range inv.Products {
+			items[p.Name] = item{p.Price, p.Available, p.Currency}
+		}
+		return items
+	}
+	fees := func(inv InventoryData) map[string]item {
+		items := make(map[string]item)
+		for _, f := range inv.Fees {
+			items[f.Name] = item{f.Price, f.Available, f.Currency}
+		}
+		return items
+	}
+	diffItems("membership", memberships(current), memberships(next))
+	diffItems("product", products(current), products(next))
+	diffItems("fee", fees(current), fees(next))
+
+	for _, oldName := range sortedKeys(current.Aliases) {
+		if _, ok := next.Aliases[oldName]; !ok {
+			changes = append(changes, fmt.Sprintf("- alias %q -> %q", oldName, current.Aliases[oldName]))
+		}
+	}
+	for _, oldName := range sortedKeys(next.Aliases) {
+		was, ok := current.Aliases[oldName]
+		switch now := next.Aliases[oldName]; {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ alias %q -> %q", oldName, now))
+		case was != now:
+			changes = append(changes, fmt.Sprintf("~ alias %q -> %q, was %q", oldName, now, was))
+		}
+	}
+
+	diffCoupons := func(inv InventoryData) map[string]Coupon {
+		coupons := make(map[string]Coupon)
+		for _, c := range inv.Coupons {
+			coupons[NormalizeCouponCode(c.Code)] = c
+		}
+		return coupons
+	}
+	wasCoupons, nowCoupons := diffCoupons(current), diffCoupons(next)
+	for _, code := range sortedKeys(wasCoupons) {
+		if _, ok := nowCoupons[code]; !ok {
+			changes = append(changes, fmt.Sprintf("- coupon %q", code))
+		}
+	}
+	for _, code := range sortedKeys(nowCoupons) {
+		was, ok := wasCoupons[code]
+		now := nowCoupons[code]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ coupon %q", code))
+		case !reflect.DeepEqual(was, now):
+			changes = append(changes, fmt.Sprintf("~ coupon %q", code))
+		}
+	}
+
+	for _, name := range sortedKeys(current.Events) {
+		if _, ok := next.Events[name]; !ok {
+			changes = append(changes, fmt.Sprintf("- event %q", name))
+		}
+	}
+	for _, name := range sortedKeys(next.Events) {
+		was, ok := current.Events[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ event %q", name))
+			continue
+		}
+		now := next.Events[name]
+		if was.Currency != now.Currency {
+			changes = append(changes, fmt.Sprintf("~ event %q currency %q -> %q", name, was.Currency, now.Currency))
+		}
+		if was.ChangeCutoff != now.ChangeCutoff {
+			changes = append(changes, fmt.Sprintf("~ event %q change_cutoff %q -> %q", name, was.ChangeCutoff, now.ChangeCutoff))
+		}
+		if was.OrderPageTemplate != now.OrderPageTemplate || was.PrintTemplate != now.PrintTemplate {
+			changes = append(changes, fmt.Sprintf("~ event %q order page templates %q/%q -> %q/%q", name,
+				was.OrderPageTemplate, was.PrintTemplate, now.OrderPageTemplate, now.PrintTemplate))
+		}
+		options := func(event EventConfig) map[string]item {
+			items := make(map[string]item)
+			for key, option := range event.PerStudentOptions {
+				items["per-student "+key] = item{price: option.Price, available: true}
+			}
+			for key, option := range event.SharedOptions {
+				items["shared "+key] = item{price: option.Price, available: true}
+			}
+			return items
+		}
+		diffItems(fmt.Sprintf("event %q option", name), options(was), options(now))
+	}
+
+	return changes
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
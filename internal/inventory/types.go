@@ -6,6 +6,13 @@ type InventoryData struct {
 	Products    []ProductItem          `json:"products"`
 	Fees        []FeeItem              `json:"fees"`
 	Events      map[string]EventConfig `json:"events"`
+	// Aliases maps an item's old name to its current one, so submissions made before
+	// a rename still find their membership, product or fee
+	Aliases map[string]string `json:"aliases,omitempty"`
+	Coupons []Coupon          `json:"coupons,omitempty"`
+	// Currency is the ISO 4217 code prices are in; empty for the deployment's CURRENCY.
+	// Items and events can name their own.
+	Currency string `json:"currency,omitempty"`
 }
 
 // Individual item types
@@ -14,6 +21,7 @@ type MembershipItem struct {
 	Name        string  `json:"name"`
 	Price       float64 `json:"price"`
 	Description string  `json:"description,omitempty"`
+	Currency    string  `json:"currency,omitempty"`
 	Available   bool    `json:"available"`
 }
 
@@ -22,6 +30,7 @@ type ProductItem struct {
 	Name      string  `json:"name"`
 	Price     float64 `json:"price"`
 	Category  string  `json:"category,omitempty"`
+	Currency  string  `json:"currency,omitempty"`
 	Available bool    `json:"available"`
 }
 
@@ -30,6 +39,7 @@ type FeeItem struct {
 	Name      string  `json:"name"`
 	Price     float64 `json:"price"`
 	Event     string  `json:"event,omitempty"`
+	Currency  string  `json:"currency,omitempty"`
 	Available bool    `json:"available"`
 }
 
@@ -46,6 +56,27 @@ type EventOption struct {
 type EventConfig struct {
 	PerStudentOptions map[string]EventOption `json:"per_student_options"`
 	SharedOptions     map[string]EventOption `json:"shared_options"`
+	ChangeCutoff      string                 `json:"change_cutoff,omitempty"` // last day paid orders can be changed (2006-01-02 or RFC3339)
+	Currency          string                 `json:"currency,omitempty"`      // ISO 4217 code; empty for the catalog's
+
+	// Template files for the event's order page and a print-friendly variant, looked up
+	// in the template override directory. Without them the built-in page is used alone.
+	OrderPageTemplate string `json:"order_page_template,omitempty"`
+	PrintTemplate     string `json:"print_template,omitempty"`
+}
+
+// Coupon is a discount code families enter at checkout. It takes Amount dollars, or
+// Amount percent, off the items of the form types in AppliesTo; donations and
+// processing fees are never discounted.
+type Coupon struct {
+	Code       string   `json:"code"`
+	Type       string   `json:"type"` // "fixed" or "percent"
+	Amount     float64  `json:"amount"`
+	AppliesTo  []string `json:"applies_to,omitempty"`  // "membership", "event"; empty for both
+	ValidFrom  string   `json:"valid_from,omitempty"`  // first day it works (2006-01-02 or RFC3339)
+	ValidUntil string   `json:"valid_until,omitempty"` // last day it works (2006-01-02 or RFC3339)
+	MaxUses    int      `json:"max_uses,omitempty"`    // paid submissions that may use it; 0 for no limit
+	Available  bool     `json:"available"`
 }
 
 // Legacy format structures (for loading existing files)
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// IdempotencyKeyKey holds the request's Idempotency-Key in its context
+const IdempotencyKeyKey contextKey = "idempotency_key"
+
+const (
+	// IdempotencyKeyHeader is the header a client retries a request under; PayPal's
+	// PayPal-Request-Id is accepted in its place
+	IdempotencyKeyHeader  = "Idempotency-Key"
+	payPalRequestIDHeader = "PayPal-Request-Id"
+
+	// IdempotentReplayedHeader marks a response replayed from an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+
+	// idempotencyClaimTimeout is how long a request may hold its key before a retry can
+	// take it over; it outlasts a capture's PayPal retries
+	idempotencyClaimTimeout = 5 * time.Minute
+)
+
+// IdempotentAPIMiddleware is APIMiddleware for endpoints that create or capture orders.
+// A request carrying an Idempotency-Key is answered once: retries with the same key and
+// body get the stored response, even within the per-token rate limit, instead of
+// reaching the handler again.
+func IdempotentAPIMiddleware(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return RequestID(
+		Logging(
+			TokenValidation(
+				Idempotency(endpoint,
+					TokenRateLimit(
+						ErrorHandling(next),
+					),
+				),
+			),
+		),
+	)
+}
+
+// IdempotencyKey returns the request's Idempotency-Key, or "" if it had none
+func IdempotencyKey(ctx context.Context) string {
+	if key, ok := ctx.Value(IdempotencyKeyKey).(string); ok {
+		return key
+	}
+	return ""
+}
+
+// Idempotency stores the response to each request made with an Idempotency-Key on
+// endpoint and replays it for retries. Reusing a key for a different request is refused,
+// as is a retry while the first request is still running. Server errors and rate limiting
+// aren't stored, so the client can retry them under the same key.
+func Idempotency(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+		if key == "" {
+			key = strings.TrimSpace(r.Header.Get(payPalRequestIDHeader))
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			WriteAPIError(w, r, http.StatusBadRequest, "invalid_idempotency_key",
+				"Idempotency key is too long", "")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Failed to read request", "")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The token is part of the hash so one family's key can't replay another's response
+		sum := sha256.Sum256(append([]byte(getToken(r.Context())+"\n"), body...))
+		requestHash := hex.EncodeToString(sum[:])
+
+		now := clock.Now()
+		claimed, existing, err := data.ClaimIdempotencyKey(endpoint, key, requestHash, now, now.Add(-idempotencyClaimTimeout))
+		if err != nil {
+			logger.LogError("Failed to claim idempotency key on %s: %v", endpoint, err)
+			WriteAPIError(w, r, http.StatusInternalServerError, "internal_error",
+				"An internal error occurred", "")
+			return
+		}
+
+		if !claimed {
+			switch {
+			case existing.RequestHash != requestHash:
+				WriteAPIError(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused",
+					"This idempotency key was already used for a different request", "")
+			case !existing.Completed():
+				WriteAPIError(w, r, http.StatusConflict, "idempotency_key_in_use",
+					"This request is already being processed", "")
+			default:
+				logger.LogInfo("Replaying %s response (HTTP %d) for request %s",
+					endpoint, existing.StatusCode, getRequestID(r.Context()))
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.Response)
+			}
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), IdempotencyKeyKey, key)))
+
+		if rec.statusCode >= http.StatusInternalServerError || rec.statusCode == http.StatusTooManyRequests {
+			if err := data.ReleaseIdempotencyKey(endpoint, key); err != nil {
+				logger.LogError("Failed to release idempotency key on %s: %v", endpoint, err)
+			}
+			return
+		}
+		if err := data.CompleteIdempotencyKey(endpoint, key, rec.statusCode, w.Header().Get("Content-Type"),
+			rec.body.Bytes(), clock.Now()); err != nil {
+			logger.LogError("Failed to store idempotent response on %s: %v", endpoint, err)
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the response on its way to the client
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.statusCode = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
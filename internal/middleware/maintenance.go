@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/logger"
+)
+
+// MaintenanceState describes whether public endpoints are currently turned away
+type MaintenanceState struct {
+	Enabled           bool      `json:"enabled"`
+	Since             time.Time `json:"since,omitempty"`
+	Message           string    `json:"message"`
+	RetryAfterSeconds int       `json:"retry_after_seconds"`
+}
+
+var (
+	maintenanceMu         sync.RWMutex
+	maintenanceEnabled    bool
+	maintenanceSince      time.Time
+	maintenanceMessage    = "We're making some improvements and will be back shortly."
+	maintenanceRetryAfter = 5 * time.Minute
+)
+
+// Paths that stay up during maintenance: health checks for the load balancer, the
+// admin pages and API used to turn maintenance off again, and the stylesheet the
+// maintenance page itself links to
+var maintenanceExempt = []string{"/healthz", "/readyz", "/info", "/api/admin", "/static"}
+
+var maintenanceTmpl = template.Must(template.New("maintenance.html.tmpl").
+	ParseFS(assets.Templates(), "maintenance.html.tmpl"))
+
+// ConfigureMaintenance sets the message and Retry-After used while in maintenance mode
+func ConfigureMaintenance(message string, retryAfter time.Duration) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	maintenanceMessage = message
+	maintenanceRetryAfter = retryAfter
+}
+
+// SetMaintenance turns maintenance mode on or off, returning the previous setting.
+// The toggle lives in memory; a restart goes back to MAINTENANCE_MODE.
+func SetMaintenance(enabled bool) bool {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	previous := maintenanceEnabled
+	if enabled && !previous {
+		maintenanceSince = clock.Now()
+	}
+	if !enabled {
+		maintenanceSince = time.Time{}
+	}
+	maintenanceEnabled = enabled
+	return previous
+}
+
+// Maintenance returns the current maintenance mode state
+func Maintenance() MaintenanceState {
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+	return MaintenanceState{
+		Enabled:           maintenanceEnabled,
+		Since:             maintenanceSince,
+		Message:           maintenanceMessage,
+		RetryAfterSeconds: int(maintenanceRetryAfter.Round(time.Second) / time.Second),
+	}
+}
+
+// MaintenanceMode answers public requests with a 503 while maintenance mode is on.
+// API clients get the standard JSON error; everything else gets the branded page.
+func MaintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := Maintenance()
+		if !state.Enabled || maintenanceExemptPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if state.RetryAfterSeconds > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			WriteAPIError(w, r, http.StatusServiceUnavailable, "maintenance", state.Message, "")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := maintenanceTmpl.Execute(w, state); err != nil {
+			logger.LogError("Failed to render maintenance page: %v", err)
+		}
+	})
+}
+
+func maintenanceExemptPath(path string) bool {
+	for _, prefix := range maintenanceExempt {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminMaintenanceHandler reports maintenance mode on GET and switches it on POST
+// (?enabled=true|false). It requires an admin token issued by the info page.
+func AdminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		WriteAPISuccess(w, r, Maintenance())
+
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			WriteAPIError(w, r, http.StatusBadRequest, "invalid_enabled", "The enabled parameter must be true or false", "")
+			return
+		}
+
+		if previous := SetMaintenance(enabled); previous != enabled {
+			logger.LogInfo("Maintenance mode turned %s from %s", onOff(enabled), logger.GetClientIP(r))
+		}
+		WriteAPISuccess(w, r, Maintenance())
+
+	default:
+		WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+	}
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -7,10 +7,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"sbcbackend/internal/clock"
 	"sbcbackend/internal/logger"
 	"sbcbackend/internal/security"
 )
@@ -75,7 +77,7 @@ func Logging(next http.HandlerFunc) http.HandlerFunc {
 		start := time.Now()
 		requestID := getRequestID(r.Context())
 
-		logger.LogInfo("API request started", map[string]interface{}{
+		logger.LogInfo("API request started %v", map[string]interface{}{
 			"request_id": requestID,
 			"method":     r.Method,
 			"path":       r.URL.Path,
@@ -89,7 +91,7 @@ func Logging(next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(rw, r)
 
 		duration := time.Since(start)
-		logger.LogInfo("API request completed", map[string]interface{}{
+		logger.LogInfo("API request completed %v", map[string]interface{}{
 			"request_id":      requestID,
 			"status_code":     rw.statusCode,
 			"processing_time": duration.String(),
@@ -127,6 +129,24 @@ func TokenValidation(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// RequireAdmin lets a request through to next only with an admin token issued by the
+// info page, sent in the X-Admin-Token header or the adminToken query parameter. Any
+// other request is refused with 403 and logged as an attempt to reach area.
+func RequireAdmin(area string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken := r.Header.Get("X-Admin-Token")
+		if adminToken == "" {
+			adminToken = r.URL.Query().Get("adminToken")
+		}
+		if !security.ValidateAdminToken(adminToken, true, r.Referer()) {
+			logger.LogWarn("Invalid admin token access attempt to %s from %s", area, logger.GetClientIP(r))
+			WriteAPIError(w, r, http.StatusForbidden, "access_denied", "Invalid admin access", "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 // TokenRateLimit implements rate limiting per access token
 func TokenRateLimit(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -138,7 +158,7 @@ func TokenRateLimit(next http.HandlerFunc) http.HandlerFunc {
 
 		tokenRateMu.Lock()
 		lastRequest, exists := tokenRateLimiter[token]
-		now := time.Now()
+		now := clock.Now()
 
 		if exists && now.Sub(lastRequest) < tokenRateLimit {
 			tokenRateMu.Unlock()
@@ -154,13 +174,38 @@ func TokenRateLimit(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// SetTokenRateLimit changes the minimum interval between requests per token, returning
+// the previous value. Integration tests lower it to drive a whole checkout with one token.
+func SetTokenRateLimit(interval time.Duration) time.Duration {
+	tokenRateMu.Lock()
+	defer tokenRateMu.Unlock()
+	previous := tokenRateLimit
+	tokenRateLimit = interval
+	return previous
+}
+
+// PruneRateLimits drops per-token rate limit entries older than maxAge
+func PruneRateLimits(maxAge time.Duration) int {
+	tokenRateMu.Lock()
+	defer tokenRateMu.Unlock()
+
+	removed := 0
+	for token, lastRequest := range tokenRateLimiter {
+		if clock.Since(lastRequest) > maxAge {
+			delete(tokenRateLimiter, token)
+			removed++
+		}
+	}
+	return removed
+}
+
 // ErrorHandling middleware provides panic recovery and consistent error responses
 func ErrorHandling(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
 				requestID := getRequestID(r.Context())
-				logger.LogError("Panic in API handler", map[string]interface{}{
+				logger.LogError("Panic in API handler %v", map[string]interface{}{
 					"request_id": requestID,
 					"error":      fmt.Sprintf("%v", err),
 					"path":       r.URL.Path,
@@ -247,6 +292,65 @@ func ParseJSONRequest(r *http.Request, v interface{}) error {
 	return decoder.Decode(v)
 }
 
+// MaxListLimit caps how many rows one list request returns
+const MaxListLimit = 500
+
+// ListQuery is the page and date range a list request asks for
+type ListQuery struct {
+	From   time.Time // start of the first day, in the club's time zone
+	To     time.Time // end of the last day
+	Limit  int
+	Offset int
+}
+
+// ParseListQuery reads a list request's limit and offset, and its from and to days
+// (YYYY-MM-DD, both included). The limit defaults to defaultLimit and is capped at
+// MaxListLimit.
+func ParseListQuery(r *http.Request, defaultLimit int) (ListQuery, error) {
+	query := ListQuery{Limit: defaultLimit}
+	values := r.URL.Query()
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("limit must be a positive number")
+		}
+		query.Limit = limit
+	}
+	query.Limit = min(query.Limit, MaxListLimit)
+	if value := values.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return query, fmt.Errorf("offset must be a number of rows to skip")
+		}
+		query.Offset = offset
+	}
+
+	var err error
+	if query.From, err = parseQueryDay(values.Get("from"), "from"); err != nil {
+		return query, err
+	}
+	if query.To, err = parseQueryDay(values.Get("to"), "to"); err != nil {
+		return query, err
+	}
+	if !query.To.IsZero() {
+		query.To = query.To.AddDate(0, 0, 1)
+	}
+	return query, nil
+}
+
+// parseQueryDay parses a YYYY-MM-DD query parameter as the start of that day
+func parseQueryDay(value, key string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, clock.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date such as 2024-09-01", key)
+	}
+	return day, nil
+}
+
 // ValidateFormIDAccess validates that the token has access to the specified form ID
 func ValidateFormIDAccess(ctx context.Context, formID, token string) error {
 	tokenInfo := security.GetTokenInfo(token)
@@ -0,0 +1,188 @@
+// Package notification sends one message to several people over whichever channels
+// reach them. Business code builds a Notification from a template, its data and the
+// recipients; the router renders it for each channel, checks the recipients'
+// preferences and hands it to that channel's backend.
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notify"
+	"sbcbackend/internal/preferences"
+	"sbcbackend/internal/sms"
+)
+
+// Channels. Email and SMS match the preference channels payers choose between.
+const (
+	ChannelEmail = preferences.Email
+	ChannelSMS   = preferences.SMS
+	ChannelChat  = "chat" // Slack or Discord, addressed by notify event type
+	ChannelPush  = "push" // admins' ntfy topic, addressed by notify event type
+)
+
+// Message is a notification rendered for one channel. Only email uses Subject.
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// RenderFunc renders a notification's data for one channel
+type RenderFunc func(data interface{}) (Message, error)
+
+// Template renders a kind of notification for the channels it supports. Push uses
+// the chat rendering when it has none of its own.
+type Template struct {
+	Name     string
+	Channels map[string]RenderFunc
+}
+
+// Recipient is one address on one channel
+type Recipient struct {
+	Channel string
+	Address string // email address, E.164 phone number, or notify event type
+	Contact string // payer whose preferences apply; empty for the club's own staff
+}
+
+// Notification is one message for several recipients
+type Notification struct {
+	Template   Template
+	Data       interface{}
+	Category   string // preferences category, for recipients with a Contact
+	Recipients []Recipient
+}
+
+// Payer addresses the payer at their email address
+func Payer(emailAddress string) Recipient {
+	return Recipient{Channel: ChannelEmail, Address: emailAddress, Contact: emailAddress}
+}
+
+// PayerSMS addresses a payer who opted in to texts at phone; contact is their email
+// address, which their preferences are kept under
+func PayerSMS(phone, contact string) Recipient {
+	return Recipient{Channel: ChannelSMS, Address: phone, Contact: contact}
+}
+
+// Staff addresses the club's alert mailbox
+func Staff() Recipient {
+	return Recipient{Channel: ChannelEmail, Address: email.LoadEmailConfig().AlertRecipient}
+}
+
+// StaffSMS addresses an on-call board member's phone, in E.164 form
+func StaffSMS(phone string) Recipient {
+	return Recipient{Channel: ChannelSMS, Address: phone}
+}
+
+// Chat addresses the chat channel eventType is routed to
+func Chat(eventType string) Recipient {
+	return Recipient{Channel: ChannelChat, Address: eventType}
+}
+
+// Push addresses admins' devices subscribed to eventType
+func Push(eventType string) Recipient {
+	return Recipient{Channel: ChannelPush, Address: eventType}
+}
+
+// Backend delivers a rendered message to one recipient
+type Backend func(ctx context.Context, to Recipient, msg Message) error
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{
+		ChannelEmail: sendEmail,
+		ChannelSMS:   sendSMS,
+		ChannelChat:  sendChat,
+		ChannelPush:  sendPush,
+	}
+)
+
+// SetBackend replaces the backend for channel and returns the previous one. Tests use
+// it to capture messages; nil removes the channel.
+func SetBackend(channel string, backend Backend) Backend {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	previous := backends[channel]
+	if backend == nil {
+		delete(backends, channel)
+	} else {
+		backends[channel] = backend
+	}
+	return previous
+}
+
+// Send delivers n to every recipient, skipping payers whose preferences turn the
+// category off on that channel. Every recipient is tried and their failures returned
+// together, so a caller that retries on error may repeat the recipients that
+// succeeded; notifications that are retried should have one recipient.
+func Send(ctx context.Context, n Notification) error {
+	var errs []error
+	for _, to := range n.Recipients {
+		if err := sendTo(ctx, n, to); err != nil {
+			errs = append(errs, fmt.Errorf("%s to %s: %w", n.Template.Name, to.Channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sendTo(ctx context.Context, n Notification, to Recipient) error {
+	if to.Address == "" {
+		return fmt.Errorf("recipient has no address")
+	}
+
+	render := n.Template.Channels[to.Channel]
+	if render == nil && to.Channel == ChannelPush {
+		render = n.Template.Channels[ChannelChat]
+	}
+	if render == nil {
+		return fmt.Errorf("template has no %s rendering", to.Channel)
+	}
+
+	if to.Contact != "" && n.Category != "" && !preferences.Allows(to.Contact, n.Category, to.Channel) {
+		logger.LogInfo("Skipping %s %s to %s: turned off in their preferences", n.Template.Name, to.Channel, to.Contact)
+		return nil
+	}
+
+	backendsMu.RLock()
+	backend := backends[to.Channel]
+	backendsMu.RUnlock()
+	if backend == nil {
+		return fmt.Errorf("no backend for channel %s", to.Channel)
+	}
+
+	msg, err := render(n.Data)
+	if err != nil {
+		return err
+	}
+	return backend(ctx, to, msg)
+}
+
+// sendEmail mails payers from the confirmation sender and staff from the alert sender.
+// Payer email follows SEND_CONFIRMATION_EMAILS, as it always has.
+func sendEmail(ctx context.Context, to Recipient, msg Message) error {
+	config := email.LoadEmailConfig()
+	from := config.AlertSender
+	if to.Contact != "" {
+		if !config.SendConfirmations {
+			logger.LogInfo("Confirmation emails disabled, skipping %q to %s", msg.Subject, to.Address)
+			return nil
+		}
+		from = config.ConfirmationSender
+	}
+	return email.SendMail(to.Address, from, msg.Subject, msg.Body)
+}
+
+func sendSMS(ctx context.Context, to Recipient, msg Message) error {
+	return sms.Send(ctx, to.Address, msg.Body)
+}
+
+func sendChat(ctx context.Context, to Recipient, msg Message) error {
+	return notify.Post(ctx, to.Address, msg.Body)
+}
+
+func sendPush(ctx context.Context, to Recipient, msg Message) error {
+	return notify.Push(ctx, to.Address, msg.Body)
+}
@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/config"
+)
+
+// ErrorRateMonitor counts server error responses and posts an error-rate alert when
+// too many land within the configured window
+type ErrorRateMonitor struct {
+	threshold int
+	window    time.Duration
+
+	mu     sync.Mutex
+	errors []time.Time // times of recent 5xx responses, oldest first
+}
+
+// NewErrorRateMonitor reads the alert threshold and window from configuration
+func NewErrorRateMonitor() *ErrorRateMonitor {
+	threshold, window := config.ChatErrorRateAlert()
+	return &ErrorRateMonitor{threshold: threshold, window: window}
+}
+
+// Middleware records the status of every response passing through h
+func (m *ErrorRateMonitor) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		if sw.status >= 500 {
+			m.record(time.Now(), r.Method+" "+r.URL.Path, sw.status)
+		}
+	})
+}
+
+func (m *ErrorRateMonitor) record(now time.Time, request string, status int) {
+	m.mu.Lock()
+	cutoff := now.Add(-m.window)
+	kept := m.errors[:0]
+	for _, at := range m.errors {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	m.errors = append(kept, now)
+	count := len(m.errors)
+	if count >= m.threshold {
+		// Start counting afresh so the next alert needs another full threshold
+		m.errors = m.errors[:0]
+	}
+	m.mu.Unlock()
+
+	if count >= m.threshold {
+		Notify(EventErrorRate, fmt.Sprintf("High error rate: %d server errors in the last %v (latest %d on %s)",
+			count, m.window, status, request))
+	}
+}
+
+// statusWriter remembers the status code written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush passes through so streamed responses keep working
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,200 @@
+// Package notify posts short messages about payments and problems to the board's
+// Slack or Discord channels through incoming webhooks, and pushes the urgent ones to
+// admins' phones through ntfy.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// Event types, each of which can be routed to its own channel with
+// CHAT_WEBHOOK_URL_<TYPE>
+const (
+	EventPaymentCompleted  = "payment.completed"
+	EventPaymentRefunded   = "payment.refunded"
+	EventWebhookFailed     = "webhook.failed"
+	EventErrorRate         = "error.rate"
+	EventDisputeOpened     = "dispute.opened"
+	EventReconcileMismatch = "reconcile.mismatch"
+	EventPayPalFailing     = "paypal.failing"
+	EventMembershipRenewed = "membership.renewed"
+)
+
+// Failure notifications of one type are sent at most this often, so a burst of bad
+// webhooks is one message rather than a flood
+const failureInterval = time.Minute
+
+var (
+	client = &http.Client{Timeout: 10 * time.Second}
+
+	throttleMu sync.Mutex
+	lastSent   = make(map[string]time.Time)
+	suppressed = make(map[string]int)
+)
+
+// Enabled reports whether eventType has a chat webhook configured
+func Enabled(eventType string) bool {
+	return config.ChatWebhookURL(eventType) != ""
+}
+
+// Notify posts text for eventType to chat and, for the event types admins subscribe
+// to, pushes it to their devices. Both happen in the background, logging rather than
+// returning failures; it is for callers that must not wait on or fail because of them.
+func Notify(eventType, text string) {
+	webhookURL := config.ChatWebhookURL(eventType)
+	pushed := pushEnabled(eventType)
+	if webhookURL == "" && !pushed {
+		return
+	}
+
+	if eventType == EventWebhookFailed || eventType == EventErrorRate {
+		throttleMu.Lock()
+		if time.Since(lastSent[eventType]) < failureInterval {
+			suppressed[eventType]++
+			throttleMu.Unlock()
+			return
+		}
+		if n := suppressed[eventType]; n > 0 {
+			text += fmt.Sprintf(" (%d more since the last message)", n)
+		}
+		lastSent[eventType] = time.Now()
+		suppressed[eventType] = 0
+		throttleMu.Unlock()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+		defer cancel()
+		if webhookURL != "" {
+			if err := post(ctx, webhookURL, text); err != nil {
+				logger.LogWarn("Failed to post %s chat notification: %v", eventType, err)
+			}
+		}
+		if pushed {
+			if err := Push(ctx, eventType, text); err != nil {
+				logger.LogWarn("Failed to push %s notification: %v", eventType, err)
+			}
+		}
+	}()
+}
+
+// Post sends text for eventType and waits for the chat service to accept it. It does
+// nothing when eventType has no webhook.
+func Post(ctx context.Context, eventType, text string) error {
+	webhookURL := config.ChatWebhookURL(eventType)
+	if webhookURL == "" {
+		return nil
+	}
+	return post(ctx, webhookURL, text)
+}
+
+func post(ctx context.Context, webhookURL, text string) error {
+	// Slack takes "text"; Discord takes "content" and ignores unknown fields
+	field := "text"
+	if isDiscord(webhookURL) {
+		field = "content"
+	}
+	body, err := json.Marshal(map[string]string{field: text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build chat request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("chat request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("chat webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func isDiscord(webhookURL string) bool {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	return host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")
+}
+
+// PaymentCompletedTask posts a completed payment to chat. It is the outbox handler for
+// chat notifications queued with each capture, so a chat outage is retried.
+func PaymentCompletedTask(ctx context.Context, task data.OutboxTask) error {
+	summary, err := data.GetSubmissionSummary(task.FormID)
+	if err != nil {
+		return err
+	}
+	return Post(ctx, EventPaymentCompleted, fmt.Sprintf("Payment received: $%.2f %s from %s (%s)",
+		summary.CalculatedAmount, describeItem(summary), summary.FullName, summary.FormID))
+}
+
+// PaymentRefunded posts a refund recorded from PayPal; refundedTotal is the amount
+// refunded on the order so far
+func PaymentRefunded(formID string, refundedTotal float64) {
+	if !Enabled(EventPaymentRefunded) {
+		return
+	}
+	text := fmt.Sprintf("Refund recorded for %s", formID)
+	if summary, err := data.GetSubmissionSummary(formID); err == nil {
+		text = fmt.Sprintf("Refund recorded: %s (%s, %s)", summary.FullName, describeItem(summary), formID)
+		if refundedTotal > 0 {
+			text += fmt.Sprintf(", $%.2f of $%.2f refunded", refundedTotal, summary.CalculatedAmount)
+		}
+	}
+	Notify(EventPaymentRefunded, text)
+}
+
+// DisputeOpened alerts admins to a new PayPal dispute, which has a deadline to respond
+func DisputeOpened(formID, summary string) {
+	text := fmt.Sprintf("PayPal dispute opened for %s", formID)
+	if sub, err := data.GetSubmissionSummary(formID); err == nil {
+		text = fmt.Sprintf("PayPal dispute opened: %s, $%.2f %s (%s)", sub.FullName, sub.CalculatedAmount, describeItem(sub), formID)
+	}
+	if summary != "" {
+		text += ". " + summary
+	}
+	Notify(EventDisputeOpened, text)
+}
+
+// MembershipRenewed posts a yearly payment of an auto-renewing membership
+func MembershipRenewed(renewal data.Renewal) {
+	if !Enabled(EventMembershipRenewed) {
+		return
+	}
+	name := renewal.FormID
+	if summary, err := data.GetSubmissionSummary(renewal.FormID); err == nil {
+		name = fmt.Sprintf("%s (%s)", summary.FullName, renewal.FormID)
+	}
+	Notify(EventMembershipRenewed, fmt.Sprintf("Membership renewed: $%.2f from %s, year %d, paid through %s",
+		renewal.Amount, name, renewal.Cycle, renewal.CoversThrough.Format("Jan 2, 2006")))
+}
+
+func describeItem(summary *data.SubmissionSummary) string {
+	if summary.Item != "" {
+		return "for " + summary.Item
+	}
+	return "for a " + summary.FormType
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sbcbackend/internal/config"
+)
+
+// Push titles and ntfy priorities by event type; anything not listed is pushed at the
+// default priority under a generic title
+var pushTitles = map[string]string{
+	EventPaymentCompleted:  "Payment received",
+	EventPaymentRefunded:   "Refund recorded",
+	EventWebhookFailed:     "PayPal webhook problem",
+	EventErrorRate:         "Server errors",
+	EventDisputeOpened:     "PayPal dispute opened",
+	EventReconcileMismatch: "Reconciliation mismatch",
+	EventPayPalFailing:     "PayPal failing",
+	EventMembershipRenewed: "Membership renewed",
+}
+
+var pushPriorities = map[string]string{
+	EventErrorRate:         "urgent",
+	EventPayPalFailing:     "urgent",
+	EventDisputeOpened:     "high",
+	EventReconcileMismatch: "high",
+	EventWebhookFailed:     "high",
+}
+
+// pushEnabled reports whether eventType is pushed to admins' devices
+func pushEnabled(eventType string) bool {
+	return config.LoadPushSettings().Enabled(eventType)
+}
+
+// Push publishes text for eventType to the admins' ntfy topic and waits for ntfy to
+// accept it. It does nothing when eventType isn't pushed.
+func Push(ctx context.Context, eventType, text string) error {
+	settings := config.LoadPushSettings()
+	if !settings.Enabled(eventType) {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.URL, strings.NewReader(text))
+	if err != nil {
+		return fmt.Errorf("failed to build push request: %w", err)
+	}
+	title := pushTitles[eventType]
+	if title == "" {
+		title = "Booster notification"
+	}
+	req.Header.Set("Title", title)
+	if priority := pushPriorities[eventType]; priority != "" {
+		req.Header.Set("Priority", priority)
+	}
+	req.Header.Set("Tags", strings.ReplaceAll(eventType, ".", "_"))
+	if settings.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("push request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ntfy returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,17 +2,21 @@
 package order
 
 import (
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
 )
 
 // Variables
@@ -38,12 +42,10 @@ var eventOrderSummaryTmpl = template.Must(template.New("event_order_summary.html
 			if t == nil {
 				return ""
 			}
-			return t.Local().Format("Jan 2, 2006 3:04pm")
+			return t.In(clock.Location()).Format("Jan 2, 2006 3:04pm")
 		},
 		"formatDisplayName": formatDisplayName,
-		"formatCurrency": func(amount float64) string {
-			return fmt.Sprintf("$%.2f", amount)
-		},
+		"formatCurrency":    formatCurrency,
 		"getenv": func(key string) string {
 			return os.Getenv(key)
 		},
@@ -54,7 +56,7 @@ var eventOrderSummaryTmpl = template.Must(template.New("event_order_summary.html
 			return a - b
 		},
 		"lower": strings.ToLower,
-	}).ParseFiles("templates/event_order_summary.html.tmpl"))
+	}).ParseFS(assets.Templates(), "event_order_summary.html.tmpl"))
 
 var eventSuccessTmpl = template.Must(template.New("event_success.html.tmpl").
 	Funcs(template.FuncMap{
@@ -69,16 +71,14 @@ var eventSuccessTmpl = template.Must(template.New("event_success.html.tmpl").
 			if t == nil {
 				return ""
 			}
-			return t.Local().Format("Jan 2, 2006 3:04pm")
+			return t.In(clock.Location()).Format("Jan 2, 2006 3:04pm")
 		},
 		"currentYear": func() int { // ADD THIS LINE
 			return time.Now().Year()
 		},
-		"formatCurrency": func(amount float64) string {
-			return fmt.Sprintf("$%.2f", amount)
-		},
-		"lower": strings.ToLower,
-	}).ParseFiles("templates/event_success.html.tmpl"))
+		"formatCurrency": formatCurrency,
+		"lower":          strings.ToLower,
+	}).ParseFS(assets.Templates(), "event_success.html.tmpl"))
 
 var orderSummaryTmpl = template.Must(template.New("order_summary.html.tmpl").
 	Funcs(template.FuncMap{
@@ -93,12 +93,13 @@ var orderSummaryTmpl = template.Must(template.New("order_summary.html.tmpl").
 			if t == nil {
 				return ""
 			}
-			return t.Local().Format("Jan 2, 2006 3:04pm")
+			return t.In(clock.Location()).Format("Jan 2, 2006 3:04pm")
 		},
 		"currentYear": func() int { // ADD THIS LINE
 			return time.Now().Year()
 		},
-	}).ParseFiles("templates/order_summary.html.tmpl"))
+		"formatCurrency": formatCurrency,
+	}).ParseFS(assets.Templates(), "order_summary.html.tmpl"))
 
 var successPageTmpl = template.Must(template.New("success.html.tmpl").
 	Funcs(template.FuncMap{
@@ -113,15 +114,13 @@ var successPageTmpl = template.Must(template.New("success.html.tmpl").
 			if t == nil {
 				return ""
 			}
-			return t.Local().Format("Jan 2, 2006 3:04pm")
+			return t.In(clock.Location()).Format("Jan 2, 2006 3:04pm")
 		},
 		"currentYear": func() int { // ADD THIS LINE
 			return time.Now().Year()
 		},
-		"formatCurrency": func(amount float64) string {
-			return fmt.Sprintf("$%.2f", amount)
-		},
-	}).ParseFiles("templates/success.html.tmpl"))
+		"formatCurrency": formatCurrency,
+	}).ParseFS(assets.Templates(), "success.html.tmpl"))
 
 var fundraiserSummaryTmpl = template.Must(template.New("fundraiser_order_summary.html.tmpl").
 	Funcs(template.FuncMap{
@@ -136,15 +135,13 @@ var fundraiserSummaryTmpl = template.Must(template.New("fundraiser_order_summary
 			if t == nil {
 				return ""
 			}
-			return t.Local().Format("Jan 2, 2006 3:04pm")
+			return t.In(clock.Location()).Format("Jan 2, 2006 3:04pm")
 		},
 		"currentYear": func() int { // ADD THIS LINE
 			return time.Now().Year()
 		},
-		"formatCurrency": func(amount float64) string {
-			return fmt.Sprintf("$%.2f", amount)
-		},
-	}).ParseFiles("templates/fundraiser_order_summary.html.tmpl"))
+		"formatCurrency": formatCurrency,
+	}).ParseFS(assets.Templates(), "fundraiser_order_summary.html.tmpl"))
 
 var fundraisersuccessTmpl = template.Must(template.New("fundraiser_success.html.tmpl").
 	Funcs(template.FuncMap{
@@ -158,20 +155,40 @@ var fundraisersuccessTmpl = template.Must(template.New("fundraiser_success.html.
 			if t == nil {
 				return ""
 			}
-			return t.Local().Format("Jan 2, 2006 3:04pm")
+			return t.In(clock.Location()).Format("Jan 2, 2006 3:04pm")
 		},
 		"currentYear": func() int { // ADD THIS LINE
 			return time.Now().Year()
 		},
-		"formatCurrency": func(amount float64) string {
-			return fmt.Sprintf("$%.2f", amount)
-		},
-	}).ParseFiles("templates/fundraiser_success.html.tmpl"))
+		"formatCurrency": formatCurrency,
+	}).ParseFS(assets.Templates(), "fundraiser_success.html.tmpl"))
 
 // Types
 
 // Helper functions
 
+// formatCurrency shows an amount in the currency with ISO code, or in the
+// deployment's when a template passes none
+func formatCurrency(amount float64, code ...string) string {
+	if len(code) == 0 || code[0] == "" {
+		return currency.Format(amount, config.Currency())
+	}
+	return currency.Format(amount, code[0])
+}
+
+// submissionCurrency is the currency a submission was charged in, for its pages
+func submissionCurrency(formType, formID string) string {
+	code, err := data.GetCurrency(formType, formID)
+	if err != nil {
+		logger.LogWarn("Failed to load currency of %s: %v", formID, err)
+		return config.Currency()
+	}
+	return code
+}
+
+var tokenExpiredTmpl = template.Must(template.New("token_expired.html.tmpl").
+	ParseFS(assets.Templates(), "token_expired.html.tmpl"))
+
 // showTokenExpiredPage displays a user-friendly token expiration page for any form type
 func showTokenExpiredPage(w http.ResponseWriter, formType string) {
 	var newFormLink string
@@ -194,26 +211,10 @@ func showTokenExpiredPage(w http.ResponseWriter, formType string) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusForbidden)
-	html := fmt.Sprintf(`<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Session Expired</title>
-    <link rel="stylesheet" href="/static/css/simple.css">
-</head>
-<body>
-    <div class="container">
-        <h1>Session Expired</h1>
-        <p>Your session has expired for security reasons. Sessions are limited to 15 minutes to protect your personal information and payment data.</p>
-        <p>If you completed your payment, a confirmation email should have been sent to you.</p>
-        <p>Please return to the homepage to begin a new registration if needed.</p>
-        <a href="/" class="button">🏠 Return to Homepage</a>
-        <a href="%s" class="button">%s</a>
-    </div>
-</body>
-</html>`, newFormLink, newFormText)
-	w.Write([]byte(html))
+	tokenExpiredTmpl.Execute(w, struct {
+		NewFormLink string
+		NewFormText string
+	}{newFormLink, newFormText})
 }
 
 // getFormTypeFromID extracts form type from formID prefix
@@ -246,6 +247,15 @@ func formatDisplayName(input string) string {
 	return strings.Join(words, " ")
 }
 
+// displayReceiptID is what payers are told to quote: the receipt number once the
+// payment has one, otherwise a shortened form ID
+func displayReceiptID(receiptNumber, formID string) string {
+	if receiptNumber != "" {
+		return receiptNumber
+	}
+	return formatReceiptID(formID)
+}
+
 func formatReceiptID(formID string) string {
 	// Convert "membership-2025-05-24_14-25-12-8I_VFQ" to something readable
 	parts := strings.Split(formID, "-")
@@ -272,6 +282,36 @@ func formatStudentList(students []data.Student) string {
 	return strings.Join(names, ", ")
 }
 
+// resolveItemName returns the current name of an item renamed since it was ordered
+func resolveItemName(name string) string {
+	if inventoryService == nil {
+		return name
+	}
+	return inventoryService.ResolveName(name)
+}
+
+// resolveItemNames resolves each of names, returning an empty list rather than nil
+func resolveItemNames(names []string) []string {
+	resolved := make([]string, 0, len(names))
+	for _, name := range names {
+		resolved = append(resolved, resolveItemName(name))
+	}
+	return resolved
+}
+
+// resolveFeeNames resolves the fee names in fees, adding up quantities of fees that
+// now share a name
+func resolveFeeNames(fees map[string]int) map[string]int {
+	if fees == nil {
+		return nil
+	}
+	resolved := make(map[string]int, len(fees))
+	for name, quantity := range fees {
+		resolved[resolveItemName(name)] += quantity
+	}
+	return resolved
+}
+
 func formatList(items []string) string {
 	if len(items) == 0 {
 		return "None"
@@ -327,30 +367,14 @@ func extractPayPalFee(paypalDetailsJSON string) float64 {
 		return 0.0
 	}
 
-	var paypalData map[string]interface{}
-	if err := json.Unmarshal([]byte(paypalDetailsJSON), &paypalData); err != nil {
+	order, err := paypal.ParseOrder([]byte(paypalDetailsJSON))
+	if err != nil {
 		return 0.0
 	}
 
-	// Navigate to the fee: purchase_units[0].payments.captures[0].seller_receivable_breakdown.paypal_fee.value
-	if purchaseUnits, ok := paypalData["purchase_units"].([]interface{}); ok && len(purchaseUnits) > 0 {
-		if unit, ok := purchaseUnits[0].(map[string]interface{}); ok {
-			if payments, ok := unit["payments"].(map[string]interface{}); ok {
-				if captures, ok := payments["captures"].([]interface{}); ok && len(captures) > 0 {
-					if capture, ok := captures[0].(map[string]interface{}); ok {
-						if breakdown, ok := capture["seller_receivable_breakdown"].(map[string]interface{}); ok {
-							if fee, ok := breakdown["paypal_fee"].(map[string]interface{}); ok {
-								if value, ok := fee["value"].(string); ok {
-									if amount, err := strconv.ParseFloat(value, 64); err == nil {
-										return amount
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-		}
+	// The fee is on purchase_units[0].payments.captures[0].seller_receivable_breakdown
+	if capture := order.FirstCapture(); capture != nil {
+		return capture.Fee()
 	}
 
 	return 0.0
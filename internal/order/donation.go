@@ -0,0 +1,50 @@
+// internal/order/donation.go
+package order
+
+import (
+	"context"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
+)
+
+// sendDonationThankYouIfNeeded thanks a quick donor once their donation is captured
+func sendDonationThankYouIfNeeded(donation *data.Donation) error {
+	if donation.ThankYouSent {
+		logger.LogInfo("Thank-you for donation %s already sent, skipping", donation.FormID)
+		return nil
+	}
+
+	capturedAt := donation.CapturedAt
+	if capturedAt != nil {
+		local := capturedAt.In(clock.Location())
+		capturedAt = &local
+	}
+	err := notification.Send(context.Background(), notification.Notification{
+		Template: donationThankYou,
+		Data: email.DonationThankYouData{
+			FormID:        donation.FormID,
+			Email:         donation.Email,
+			Note:          donation.Note,
+			Amount:        donation.Amount,
+			Currency:      donation.Currency,
+			PayPalOrderID: donation.PayPalOrderID,
+			ReceiptNumber: donation.ReceiptNumber,
+			CapturedAt:    capturedAt,
+		},
+		Category:   preferences.Receipts,
+		Recipients: []notification.Recipient{notification.Payer(donation.Email)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := data.MarkDonationThankYouSent(donation.FormID); err != nil {
+		logger.LogWarn("Failed to record thank-you sent for donation %s: %v", donation.FormID, err)
+	}
+	return nil
+}
@@ -2,20 +2,25 @@
 package order
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"sbcbackend/internal/assets"
 	"sbcbackend/internal/config"
 	"sbcbackend/internal/data"
-	"sbcbackend/internal/email"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
 	"sbcbackend/internal/security"
 )
 
@@ -54,21 +59,8 @@ func handleEventOrderDetails(w http.ResponseWriter, r *http.Request, formID, tok
 	// Parse event selections for display
 	eventSelections, eventItemsDisplay, totalFromSelections := parseEventSelectionsForDisplay(sub.FoodChoicesJSON, sub.Event)
 
-	// Get student names for better display
-	studentNames := make(map[string]string)
-	for i, student := range sub.Students {
-		studentNames[fmt.Sprintf("%d", i)] = student.Name
-	}
-
-	// Update student names in display items
-	for i := range eventItemsDisplay {
-		if !eventItemsDisplay[i].IsShared {
-			studentIndex := eventItemsDisplay[i].StudentName // This should be "0", "1", etc.
-			if realName, exists := studentNames[studentIndex]; exists {
-				eventItemsDisplay[i].StudentName = realName
-			}
-		}
-	}
+	// Replace student indexes with names for display
+	applyStudentNames(eventItemsDisplay, sub.Students)
 
 	// Compose the struct for template
 	resp := struct {
@@ -90,6 +82,7 @@ func handleEventOrderDetails(w http.ResponseWriter, r *http.Request, formID, tok
 		FoodOrderID         string
 		SubmittedAt         *time.Time
 		TotalFromSelections float64
+		Currency            string
 	}{
 		FormID:              sub.FormID,
 		FormType:            "event",
@@ -109,6 +102,7 @@ func handleEventOrderDetails(w http.ResponseWriter, r *http.Request, formID, tok
 		FoodOrderID:         sub.FoodOrderID,
 		SubmittedAt:         sub.SubmittedAt,
 		TotalFromSelections: totalFromSelections,
+		Currency:            submissionCurrency("event", sub.FormID),
 	}
 
 	logger.LogInfo("Event order details accessed for form %s", formID)
@@ -209,9 +203,35 @@ func parseEventSelectionsForDisplay(foodChoicesJSON, eventName string) (interfac
 		}
 	}
 
+	// Map iteration order is random; keep receipts stable between page loads
+	sort.SliceStable(itemsDisplay, func(i, j int) bool {
+		a, b := itemsDisplay[i], itemsDisplay[j]
+		if a.IsShared != b.IsShared {
+			return !a.IsShared
+		}
+		if a.StudentName != b.StudentName {
+			ai, _ := strconv.Atoi(a.StudentName)
+			bi, _ := strconv.Atoi(b.StudentName)
+			return ai < bi
+		}
+		return a.ItemName < b.ItemName
+	})
+
 	return eventSelections, itemsDisplay, total
 }
 
+// applyStudentNames replaces the student index ("0", "1", ...) on per-student items with the student's name
+func applyStudentNames(items []EventItemDisplay, students []data.Student) {
+	for i := range items {
+		if items[i].IsShared {
+			continue
+		}
+		if index, err := strconv.Atoi(items[i].StudentName); err == nil && index >= 0 && index < len(students) {
+			items[i].StudentName = students[index].Name
+		}
+	}
+}
+
 // loadEventOptionsForDisplay loads the event options JSON for display purposes
 func loadEventOptionsForDisplay(eventName string) map[string]interface{} {
 	eventOptionsPath := config.GetEnvBasedSetting("EVENT_OPTIONS_PATH")
@@ -326,30 +346,23 @@ func handleEventSuccessPage(w http.ResponseWriter, r *http.Request, formID, toke
 		}
 	}
 
-	// 4. Parse event selections for display
+	// 4. Render the event success template
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := RenderEventSuccessPage(w, sub, isAdminView); err != nil {
+		logger.LogError("Failed to render event success template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// RenderEventSuccessPage writes the event registration receipt page for sub
+func RenderEventSuccessPage(w io.Writer, sub *data.EventSubmission, isAdminView bool) error {
+	// Parse event selections for display
 	eventSelections, eventItemsDisplay, totalFromSelections := parseEventSelectionsForDisplay(sub.FoodChoicesJSON, sub.Event)
 
-	// 5. Get student names for better display
-	studentNames := make(map[string]string)
-	for i, student := range sub.Students {
-		studentNames[fmt.Sprintf("%d", i)] = student.Name
-	}
-
-	// Update student names in display items
-	for i := range eventItemsDisplay {
-		if !eventItemsDisplay[i].IsShared {
-			// Extract student index from StudentName (e.g., "Student 0" -> "0")
-			parts := strings.Split(eventItemsDisplay[i].StudentName, " ")
-			if len(parts) > 1 {
-				studentIndex := parts[1]
-				if realName, exists := studentNames[studentIndex]; exists {
-					eventItemsDisplay[i].StudentName = realName
-				}
-			}
-		}
-	}
+	// Replace student indexes with names for display
+	applyStudentNames(eventItemsDisplay, sub.Students)
 
-	// 6. Prepare template data
+	// Prepare template data
 	resp := struct {
 		FormID              string
 		FormattedID         string
@@ -375,9 +388,10 @@ func handleEventSuccessPage(w http.ResponseWriter, r *http.Request, formID, toke
 		IsCompleted         bool
 		IsAdminView         bool
 		Year                int
+		Currency            string
 	}{
 		FormID:              sub.FormID,
-		FormattedID:         formatReceiptID(sub.FormID),
+		FormattedID:         displayReceiptID(sub.ReceiptNumber, sub.FormID),
 		Event:               formatDisplayName(sub.Event),
 		FullName:            sub.FullName,
 		FirstName:           sub.FirstName,
@@ -400,70 +414,20 @@ func handleEventSuccessPage(w http.ResponseWriter, r *http.Request, formID, toke
 		IsCompleted:         sub.PayPalStatus == "COMPLETED",
 		IsAdminView:         isAdminView,
 		Year:                time.Now().Year(),
+		Currency:            submissionCurrency("event", sub.FormID),
 	}
 
-	// 7. Render the event success template
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := eventSuccessTmpl.Execute(w, resp); err != nil {
-		logger.LogError("Failed to render event success template: %v", err)
-		http.Error(w, "Error rendering page", http.StatusInternalServerError)
-	}
+	return eventSuccessTmpl.Execute(w, resp)
 }
 
-// special event flow: create the static page for links to food orders
-// generateStaticOrderPage creates a static HTML page for the event order
-func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
-	logger.LogInfo("DEBUG: generateStaticOrderPage called for form %s", sub.FormID)
-	logger.LogInfo("DEBUG: FoodOrderID: '%s'", sub.FoodOrderID)
-	logger.LogInfo("DEBUG: HasFoodOrders: %v", sub.HasFoodOrders)
-	logger.LogInfo("DEBUG: Event: '%s'", sub.Event)
-
-	// ... rest of existing function
-	// Get base path from environment
-	basePathEnv := config.GetEnvBasedSetting("EVENT_ORDERS_PATH")
-	if basePathEnv == "" {
-		basePathEnv = "/home/public/events"
-	}
-
-	// Create directory structure: /base/YEAR/event_name/
-	year := time.Now().Year()
-	eventName := strings.ReplaceAll(sub.Event, " ", "-")
-	dirPath := filepath.Join(basePathEnv, strconv.Itoa(year), eventName)
-
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	// Generate filename using food order ID
-	filename := fmt.Sprintf("%s.html", sub.FoodOrderID)
-	filePath := filepath.Join(dirPath, filename)
-
-	// Parse event selections for display (using our new function)
-	_, eventItemsDisplay, totalFromSelections := parseEventSelectionsForDisplay(sub.FoodChoicesJSON, sub.Event)
-
-	// Get student names for better display
-	studentNames := make(map[string]string)
-	for i, student := range sub.Students {
-		studentNames[fmt.Sprintf("%d", i)] = student.Name
-	}
-
-	// Update student names in display items
-	for i := range eventItemsDisplay {
-		if !eventItemsDisplay[i].IsShared {
-			// Extract student index from StudentName (e.g., "Student 0" -> "0")
-			parts := strings.Split(eventItemsDisplay[i].StudentName, " ")
-			if len(parts) > 1 {
-				studentIndex := parts[1]
-				if realName, exists := studentNames[studentIndex]; exists {
-					eventItemsDisplay[i].StudentName = realName
-				}
-			}
-		}
-	}
+// orderPageFuncs are available to the built-in order page and to events' own templates
+var orderPageFuncs = template.FuncMap{
+	"formatCurrency": formatCurrency,
+}
 
-	// Create the HTML content with the new structure
-	tmpl := `<!DOCTYPE html>
+// eventOrderPageTmpl renders the static order page families and the kitchen look up by
+// food order ID, for events that don't name their own template
+var eventOrderPageTmpl = template.Must(template.New("orderPage").Funcs(orderPageFuncs).Parse(`<!DOCTYPE html>
 <html lang="en">
 <head>
     <meta charset="UTF-8">
@@ -475,19 +439,15 @@ func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
     <header>
         <h1>{{.Event}} - Food Order</h1>
         <p>Order ID: <strong>{{.FoodOrderID}}</strong></p>
-        <p>For: <strong>{{.FullName}}</strong></p>
+        {{if .HasAllergies}}
+        <p class="allergy-alert" role="alert"><strong>⚠️ ALLERGY ALERT:</strong> See dietary notes below.</p>
+        {{end}}
     </header>
     
     <main>
         <section aria-labelledby="registration-heading">
             <h2 id="registration-heading">Registration Details</h2>
             <dl>
-                <dt>Parent/Guardian:</dt>
-                <dd>{{.FullName}}</dd>
-                
-                <dt>Email:</dt>
-                <dd><a href="mailto:{{.Email}}">{{.Email}}</a></dd>
-                
                 <dt>School:</dt>
                 <dd>{{.School}}</dd>
                 
@@ -496,6 +456,10 @@ func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
                 
                 <dt>Payment ID:</dt>
                 <dd>{{.PayPalOrderID}}</dd>
+                {{if .ReceiptNumber}}
+                <dt>Receipt Number:</dt>
+                <dd>{{.ReceiptNumber}}</dd>
+                {{end}}
             </dl>
         </section>
         
@@ -508,6 +472,19 @@ func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
             </ul>
         </section>
         
+        {{if .DietaryNotesDisplay}}
+        <section aria-labelledby="dietary-heading">
+            <h2 id="dietary-heading">Dietary Notes</h2>
+            <ul>
+                {{range .DietaryNotesDisplay}}
+                <li{{if .Allergy}} class="allergy"{{end}}>
+                    {{if .Allergy}}<strong>⚠️ ALLERGY</strong> - {{end}}<strong>{{.StudentName}}</strong>{{if .Notes}}: {{.Notes}}{{end}}
+                </li>
+                {{end}}
+            </ul>
+        </section>
+        {{end}}
+        
         {{if .EventItemsDisplay}}
         <section aria-labelledby="selections-heading">
             <h2 id="selections-heading">Selected Options</h2>
@@ -539,7 +516,7 @@ func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
                           {{if not .IsShared}}
                           <tr>
                               <td><strong>{{.StudentName}}</strong> - {{.ItemLabel}}</td>
-                              <td>${{printf "%.2f" .TotalPrice}}</td>
+                              <td>{{formatCurrency .TotalPrice $.Currency}}</td>
                           </tr>
                           {{end}}
                         {{end}}
@@ -563,7 +540,7 @@ func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
                           {{if .IsShared}}
                           <tr>
                               <td>{{.ItemLabel}} {{if gt .Quantity 1}}(×{{.Quantity}}){{end}}</td>
-                              <td>${{printf "%.2f" .TotalPrice}}</td>
+                              <td>{{formatCurrency .TotalPrice $.Currency}}</td>
                           </tr>
                           {{end}}
                         {{end}}
@@ -576,73 +553,316 @@ func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
         
         <aside class="total-summary" aria-labelledby="total-heading">
             <h2 id="total-heading">Total Amount</h2>
-            <p class="total-amount">${{printf "%.2f" .CalculatedAmount}}</p>
+            <p class="total-amount">{{formatCurrency .CalculatedAmount .Currency}}</p>
         </aside>
     </main>
     
     <footer>
         <h2>Thank you for your registration!</h2>
-        <p>Please print or save this page for your records.</p>
+        <p>Please print or save this page for your records.{{if .PrintURL}} <a href="{{.PrintURL}}">Print-friendly version</a>{{end}}</p>
         <p>If you have questions, contact us at <a href="mailto:info@hebstrings.org">info@hebstrings.org</a></p>
     </footer>
 </body>
-</html>`
-
-	// Parse and execute template
-	t, err := template.New("orderPage").Funcs(template.FuncMap{
-		"formatCurrency": func(amount float64) string {
-			return fmt.Sprintf("$%.2f", amount)
-		},
-	}).Parse(tmpl)
+</html>`))
+
+// special event flow: create the static page for links to food orders
+// generateStaticOrderPage creates a static HTML page for the event order
+func generateStaticOrderPage(sub *data.EventSubmission) (string, error) {
+	logger.LogInfo("DEBUG: generateStaticOrderPage called for form %s", sub.FormID)
+	logger.LogInfo("DEBUG: FoodOrderID: '%s'", sub.FoodOrderID)
+	logger.LogInfo("DEBUG: HasFoodOrders: %v", sub.HasFoodOrders)
+	logger.LogInfo("DEBUG: Event: '%s'", sub.Event)
+
+	// ... rest of existing function
+	// Get base path from environment
+	basePathEnv := config.EventOrdersPath()
+
+	// Create directory structure: /base/YEAR/event_name/
+	year := time.Now().Year()
+	eventName := strings.ReplaceAll(sub.Event, " ", "-")
+	dirPath := filepath.Join(basePathEnv, strconv.Itoa(year), eventName)
+
+	// Create directory if it doesn't exist
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Generate filename using food order ID
+	filename := fmt.Sprintf("%s.html", sub.FoodOrderID)
+	filePath := filepath.Join(dirPath, filename)
+
+	if err := writeOrderPageFile(filePath, sub); err != nil {
+		return "", err
+	}
+
+	// Return the relative URL path
+	publicURL := fmt.Sprintf("/events/%d/%s/%s", year, eventName, filename)
+	return publicURL, nil
+}
+
+// writeOrderPageFile renders the order page to filePath, with the print-friendly
+// variant next to it when the event has one
+func writeOrderPageFile(filePath string, sub *data.EventSubmission) error {
+	page, printPage, err := eventOrderPageTemplates(sub.Event)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		return err
+	}
+
+	printPath := printPagePath(filePath)
+	printURL := ""
+	if printPage != nil {
+		printURL = filepath.Base(printPath)
+		if err := writePageFile(printPath, func(w io.Writer) error {
+			return renderOrderPage(w, printPage, sub, "")
+		}); err != nil {
+			return err
+		}
+	} else if err := os.Remove(printPath); err != nil && !os.IsNotExist(err) {
+		logger.LogWarn("Failed to remove print page %s the event no longer has: %v", printPath, err)
 	}
 
-	// Create the file
-	file, err := os.Create(filePath)
+	return writePageFile(filePath, func(w io.Writer) error {
+		return renderOrderPage(w, page, sub, printURL)
+	})
+}
+
+// printPagePath is where the print-friendly variant of the order page at filePath goes
+func printPagePath(filePath string) string {
+	return strings.TrimSuffix(filePath, ".html") + ".print.html"
+}
+
+// writePageFile renders a page next to filePath and renames it into place, so a page
+// being rewritten is never served half-written
+func writePageFile(filePath string, render func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".order-page-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := render(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to execute template: %w", err)
 	}
-	defer file.Close()
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+// eventOrderPageTemplates loads the order page and print-friendly templates an event
+// names in its configuration from the template directory. An event without its own
+// page template gets the built-in one; printPage is nil unless the event names one.
+func eventOrderPageTemplates(eventName string) (page, printPage *template.Template, err error) {
+	page = eventOrderPageTmpl
+	if inventoryService == nil {
+		return page, nil, nil
+	}
+	eventConfig, _ := inventoryService.GetEventConfig(eventName)
+
+	load := func(name string) (*template.Template, error) {
+		tmpl, err := template.New(name).Funcs(orderPageFuncs).ParseFS(assets.Templates(), name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load order page template %s of event %s: %w", name, eventName, err)
+		}
+		return tmpl, nil
+	}
+	if eventConfig.OrderPageTemplate != "" {
+		if page, err = load(eventConfig.OrderPageTemplate); err != nil {
+			return nil, nil, err
+		}
+	}
+	if eventConfig.PrintTemplate != "" {
+		if printPage, err = load(eventConfig.PrintTemplate); err != nil {
+			return nil, nil, err
+		}
+	}
+	return page, printPage, nil
+}
+
+// RenderEventOrderPage writes the static food order page for a paid event registration,
+// with the template its event names
+func RenderEventOrderPage(w io.Writer, sub *data.EventSubmission) error {
+	page, _, err := eventOrderPageTemplates(sub.Event)
+	if err != nil {
+		return err
+	}
+	return renderOrderPage(w, page, sub, "")
+}
+
+// renderOrderPage executes an order page template. printURL links the page to its
+// print-friendly variant, relative to the page.
+func renderOrderPage(w io.Writer, tmpl *template.Template, sub *data.EventSubmission, printURL string) error {
+	sub = orderPageDetails(sub)
+
+	// Parse event selections for display (using our new function)
+	_, eventItemsDisplay, totalFromSelections := parseEventSelectionsForDisplay(sub.FoodChoicesJSON, sub.Event)
+
+	// Replace student indexes with names for display
+	applyStudentNames(eventItemsDisplay, sub.Students)
 
-	// Execute template to file
 	templateData := struct {
 		*data.EventSubmission
 		Event               string
 		EventItemsDisplay   []EventItemDisplay
+		DietaryNotesDisplay []data.DietaryNote
 		TotalFromSelections float64
+		PrintURL            string
+		Currency            string
 	}{
 		EventSubmission:     sub,
 		Event:               formatDisplayName(sub.Event),
 		EventItemsDisplay:   eventItemsDisplay,
+		DietaryNotesDisplay: sortedDietaryNotes(sub.DietaryNotes),
 		TotalFromSelections: totalFromSelections,
+		PrintURL:            printURL,
+		Currency:            submissionCurrency("event", sub.FormID),
 	}
 
-	if err := t.Execute(file, templateData); err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+	return tmpl.Execute(w, templateData)
+}
+
+// RegenerateEventOrderPage rewrites the static order page after a paid order changes
+func RegenerateEventOrderPage(sub *data.EventSubmission) (string, error) {
+	if sub.FoodOrderID == "" {
+		return sub.OrderPageURL, nil
 	}
 
-	// Return the relative URL path
-	publicURL := fmt.Sprintf("/events/%d/%s/%s", year, eventName, filename)
-	return publicURL, nil
+	orderPagePath, err := generateStaticOrderPage(sub)
+	if err != nil {
+		return "", err
+	}
+
+	if orderPagePath != sub.OrderPageURL {
+		if err := data.UpdateEventOrderPageURL(sub.FormID, orderPagePath); err != nil {
+			return "", err
+		}
+		sub.OrderPageURL = orderPagePath
+	}
+
+	return orderPagePath, nil
+}
+
+// RebuildEventOrderPage re-renders a paid registration's order page with the current
+// template. A page that already exists is rewritten at its URL, which families have
+// in their confirmation email, even when it was generated in an earlier year.
+func RebuildEventOrderPage(sub *data.EventSubmission) (string, error) {
+	if sub.PayPalStatus != "COMPLETED" {
+		return "", fmt.Errorf("%s is not paid (status %q)", sub.FormID, sub.PayPalStatus)
+	}
+	if sub.FoodOrderID == "" {
+		return "", fmt.Errorf("%s has no food order, so it has no order page", sub.FormID)
+	}
+	if sub.OrderPageURL == "" {
+		return RegenerateEventOrderPage(sub)
+	}
+
+	rel, ok := strings.CutPrefix(sub.OrderPageURL, "/events/")
+	filePath := filepath.Join(config.EventOrdersPath(), filepath.FromSlash(rel))
+	if !ok || !strings.HasPrefix(filePath, filepath.Clean(config.EventOrdersPath())+string(os.PathSeparator)) {
+		return "", fmt.Errorf("order page URL %q is outside the order pages directory", sub.OrderPageURL)
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := writeOrderPageFile(filePath, sub); err != nil {
+		return "", err
+	}
+	return sub.OrderPageURL, nil
+}
+
+// orderPageDetails is the copy of a registration its order page is rendered from. The
+// page is a static file anyone with its URL can read, so it leaves off the payer's name,
+// email address and phone and the students' names, calling students by their place on
+// the registration. Of the dietary notes only the allergy flags stay; the kitchen
+// report has the notes in full.
+func orderPageDetails(sub *data.EventSubmission) *data.EventSubmission {
+	page := *sub
+	page.FullName, page.FirstName, page.LastName, page.Email = "", "", "", ""
+
+	page.Students = make([]data.Student, len(sub.Students))
+	for i, student := range sub.Students {
+		student.Name = orderPageStudent(i)
+		page.Students[i] = student
+	}
+	page.DietaryNotes = make(map[string]data.DietaryNote)
+	for key, note := range sub.DietaryNotes {
+		index, err := strconv.Atoi(key)
+		if err != nil || !note.Allergy {
+			continue
+		}
+		page.DietaryNotes[key] = data.DietaryNote{StudentName: orderPageStudent(index), Allergy: true}
+	}
+	return &page
+}
+
+// orderPageStudent is what an order page calls the student at index
+func orderPageStudent(index int) string {
+	return fmt.Sprintf("Student %d", index+1)
+}
+
+// sortedDietaryNotes orders notes by student index so they match the student list
+func sortedDietaryNotes(notes map[string]data.DietaryNote) []data.DietaryNote {
+	keys := make([]string, 0, len(notes))
+	for key := range notes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+
+	sorted := make([]data.DietaryNote, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, notes[key])
+	}
+	return sorted
 }
 
 // emails and other notifications
 
 // sendEventConfirmationEmailIfNeeded sends confirmation email for events
 func sendEventConfirmationEmailIfNeeded(sub *data.EventSubmission) error {
-	// For now, we'll use a simple approach - you can enhance this later
-	config := email.LoadEmailConfig()
+	// Skip if the outbox worker or an earlier page load already sent it
+	claimed, err := data.ClaimEventConfirmationEmail(sub.FormID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		logger.LogInfo("Event confirmation email already sent for form %s, skipping", sub.FormID)
+		return nil
+	}
 
+	err = notification.Send(context.Background(), notification.Notification{
+		Template:   eventReceipt,
+		Data:       sub,
+		Category:   preferences.Receipts,
+		Recipients: []notification.Recipient{notification.Payer(sub.Email)},
+	})
+	if err != nil {
+		if releaseErr := data.ReleaseEventConfirmationEmail(sub.FormID); releaseErr != nil {
+			logger.LogError("Failed to release confirmation email claim for %s: %v", sub.FormID, releaseErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// RenderEventConfirmationEmail builds the subject and body of the registration confirmation
+func RenderEventConfirmationEmail(sub *data.EventSubmission) (string, string) {
 	subject := fmt.Sprintf("Event Registration Confirmation - %s", formatDisplayName(sub.Event))
 
 	orderLink := ""
 	if sub.OrderPageURL != "" {
-		baseURL := os.Getenv("PUBLIC_BASE_URL")
-		if baseURL == "" {
-			baseURL = "https://suzuki.nfshost.com"
-		}
-		orderLink = fmt.Sprintf("%s%s", baseURL, sub.OrderPageURL)
+		orderLink = publicBaseURL() + sub.OrderPageURL
+	}
+	receiptNumberLine := ""
+	if sub.ReceiptNumber != "" {
+		receiptNumberLine = "\n- Receipt Number: " + sub.ReceiptNumber
 	}
 
 	body := fmt.Sprintf(`Dear %s,
@@ -653,8 +873,8 @@ Event Details:
 - Order ID: %s
 - School: %s
 - Students Registered: %d
-- Total Amount: $%.2f
-- Payment ID: %s
+- Total Amount: %s
+- Payment ID: %s%s
 
 View your order details: %s
 
@@ -667,10 +887,11 @@ The Event Team`,
 		sub.FoodOrderID,
 		formatDisplayName(sub.School),
 		sub.StudentCount,
-		sub.CalculatedAmount,
+		formatCurrency(sub.CalculatedAmount, submissionCurrency("event", sub.FormID)),
 		sub.PayPalOrderID,
+		receiptNumberLine,
 		orderLink,
 	)
 
-	return email.SendMail(sub.Email, config.ConfirmationSender, subject, body)
+	return subject, body
 }
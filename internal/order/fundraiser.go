@@ -2,7 +2,9 @@
 package order
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -10,6 +12,8 @@ import (
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/email"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
 	"sbcbackend/internal/security"
 )
 
@@ -59,6 +63,7 @@ func handleFundraiserOrderDetails(w http.ResponseWriter, r *http.Request, formID
 		CoverFees        bool
 		ProcessingFee    float64
 		SubmittedAt      *time.Time
+		Currency         string
 	}{
 		FormID:           sub.FormID,
 		FormType:         "fundraiser",
@@ -77,6 +82,7 @@ func handleFundraiserOrderDetails(w http.ResponseWriter, r *http.Request, formID
 		ProcessingFee:    sub.CalculatedAmount - sub.TotalAmount,
 		CoverFees:        sub.CoverFees,
 		SubmittedAt:      sub.SubmittedAt,
+		Currency:         submissionCurrency("fundraiser", sub.FormID),
 	}
 
 	logger.LogInfo("Fundraiser order details accessed for form %s", formID)
@@ -173,9 +179,20 @@ func handleFundraiserSuccessPage(w http.ResponseWriter, r *http.Request, formID,
 		}
 	}
 
-	// 4. Prepare response for template
+	// 4. Render template
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := RenderFundraiserSuccessPage(w, sub, isAdminView); err != nil {
+		logger.LogError("Failed to render fundraiser success template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// RenderFundraiserSuccessPage writes the donation receipt page for sub
+func RenderFundraiserSuccessPage(w io.Writer, sub *data.FundraiserSubmission, isAdminView bool) error {
+	// Prepare response for template
 	resp := struct {
 		FormID             string
+		ReceiptNumber      string
 		FullName           string
 		FirstName          string
 		LastName           string
@@ -200,8 +217,10 @@ func handleFundraiserSuccessPage(w http.ResponseWriter, r *http.Request, formID,
 		IsCompleted        bool
 		IsAdminView        bool
 		Year               int
+		Currency           string
 	}{
 		FormID:             sub.FormID,
+		ReceiptNumber:      sub.ReceiptNumber,
 		FullName:           sub.FullName,
 		FirstName:          sub.FirstName,
 		LastName:           sub.LastName,
@@ -226,14 +245,10 @@ func handleFundraiserSuccessPage(w http.ResponseWriter, r *http.Request, formID,
 		IsCompleted:        sub.PayPalStatus == "COMPLETED",
 		IsAdminView:        isAdminView,
 		Year:               time.Now().Year(),
+		Currency:           submissionCurrency("fundraiser", sub.FormID),
 	}
 
-	// 5. Render template (create a new one, or reuse fundraiserSummaryTmpl for now)
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := fundraisersuccessTmpl.Execute(w, resp); err != nil {
-		logger.LogError("Failed to render fundraiser success template: %v", err)
-		http.Error(w, "Error rendering page", http.StatusInternalServerError)
-	}
+	return fundraisersuccessTmpl.Execute(w, resp)
 }
 
 // emails and other notifications
@@ -244,7 +259,6 @@ func sendFundraiserConfirmationEmailIfNeeded(sub *data.FundraiserSubmission) err
 		return nil
 	}
 
-	config := email.LoadEmailConfig()
 	emaildata := email.FundraiserConfirmationData{
 		FormID:           sub.FormID,
 		FullName:         sub.FullName,
@@ -257,18 +271,26 @@ func sendFundraiserConfirmationEmailIfNeeded(sub *data.FundraiserSubmission) err
 		DonationItems:    sub.DonationItems,
 		TotalAmount:      sub.TotalAmount,
 		CalculatedAmount: sub.CalculatedAmount,
+		Currency:         submissionCurrency("fundraiser", sub.FormID),
 		CoverFees:        sub.CoverFees,
 		PayPalOrderID:    sub.PayPalOrderID,
+		ReceiptNumber:    sub.ReceiptNumber,
 		SubmittedAt:      sub.SubmittedAt,
 		Year:             time.Now().Year(),
 	}
 
-	if err := email.SendFundraiserConfirmation(config, emaildata); err != nil {
+	err := notification.Send(context.Background(), notification.Notification{
+		Template:   fundraiserReceipt,
+		Data:       emaildata,
+		Category:   preferences.Receipts,
+		Recipients: []notification.Recipient{notification.Payer(sub.Email)},
+	})
+	if err != nil {
 		return err
 	}
 
 	// Mark as sent in the database
-	if err := data.UpdateFundraiserEmailStatus(sub.FormID, true, sub.AdminNotificationSent); err != nil {
+	if err := data.MarkEmailSent("fundraiser", sub.FormID, true, false); err != nil {
 		logger.LogWarn("Failed to update fundraiser confirmation email status for %s: %v", sub.FormID, err)
 	}
 	return nil
@@ -280,7 +302,6 @@ func sendFundraiserAdminNotificationIfNeeded(sub *data.FundraiserSubmission) err
 		return nil
 	}
 
-	config := email.LoadEmailConfig()
 	emaildata := email.FundraiserConfirmationData{
 		FormID:           sub.FormID,
 		FullName:         sub.FullName,
@@ -293,18 +314,25 @@ func sendFundraiserAdminNotificationIfNeeded(sub *data.FundraiserSubmission) err
 		DonationItems:    sub.DonationItems,
 		TotalAmount:      sub.TotalAmount,
 		CalculatedAmount: sub.CalculatedAmount,
+		Currency:         submissionCurrency("fundraiser", sub.FormID),
 		CoverFees:        sub.CoverFees,
 		PayPalOrderID:    sub.PayPalOrderID,
+		ReceiptNumber:    sub.ReceiptNumber,
 		SubmittedAt:      sub.SubmittedAt,
 		Year:             time.Now().Year(),
 	}
 
-	if err := email.SendFundraiserAdminNotification(config, emaildata); err != nil {
+	err := notification.Send(context.Background(), notification.Notification{
+		Template:   fundraiserAdminNotice,
+		Data:       emaildata,
+		Recipients: []notification.Recipient{notification.Staff()},
+	})
+	if err != nil {
 		return err
 	}
 
 	// Mark as sent in the database
-	if err := data.UpdateFundraiserEmailStatus(sub.FormID, sub.ConfirmationEmailSent, true); err != nil {
+	if err := data.MarkEmailSent("fundraiser", sub.FormID, false, true); err != nil {
 		logger.LogWarn("Failed to update fundraiser admin notification status for %s: %v", sub.FormID, err)
 	}
 	return nil
@@ -2,8 +2,10 @@
 package order
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -11,6 +13,8 @@ import (
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/email"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
 	"sbcbackend/internal/security"
 )
 
@@ -86,6 +90,7 @@ func handleMembershipOrderDetails(w http.ResponseWriter, r *http.Request, formID
 		ProcessingFee       float64
 		SubmittedAt         *time.Time
 		TotalFromSelections float64
+		Currency            string
 	}{
 		FormID:                 sub.FormID,
 		FormType:               "membership",
@@ -96,11 +101,11 @@ func handleMembershipOrderDetails(w http.ResponseWriter, r *http.Request, formID
 		School:                 formatDisplayName(sub.School),
 		StudentCount:           sub.StudentCount,
 		Students:               sub.Students,
-		Membership:             sub.Membership,
+		Membership:             resolveItemName(sub.Membership),
 		MembershipStatus:       sub.MembershipStatus,
 		Describe:               formatDisplayName(sub.Describe),
-		Addons:                 addons,
-		Fees:                   sub.Fees,
+		Addons:                 resolveItemNames(addons),
+		Fees:                   resolveFeeNames(sub.Fees),
 		Donation:               sub.Donation,
 		MembershipItemsDisplay: membershipItemsDisplay,
 		CalculatedAmount:       sub.CalculatedAmount,
@@ -108,6 +113,7 @@ func handleMembershipOrderDetails(w http.ResponseWriter, r *http.Request, formID
 		ProcessingFee:          calculateProcessingFee(sub.CalculatedAmount, sub.CoverFees),
 		SubmittedAt:            sub.SubmittedAt,
 		TotalFromSelections:    totalFromSelections,
+		Currency:               submissionCurrency("membership", sub.FormID),
 	}
 
 	logger.LogInfo("Membership order details accessed for form %s", formID)
@@ -221,14 +227,30 @@ loadSuccessData:
 		logger.LogInfo("Skipping email sending for admin view of formID %s", formID)
 	}
 
+	// Log successful access
+	if isAdminView {
+		logger.LogInfo("Admin success page accessed for form %s", formID)
+	} else if tokenInfo != nil {
+		logger.LogInfo("User success page accessed for form %s (token age: %v)", formID, time.Since(tokenInfo.CreatedAt))
+	} else {
+		logger.LogInfo("Success page accessed for form %s", formID)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := RenderMembershipSuccessPage(w, sub, isAdminView); err != nil {
+		logger.LogError("Failed to render success template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// RenderMembershipSuccessPage writes the membership receipt page for sub
+func RenderMembershipSuccessPage(w io.Writer, sub *data.MembershipSubmission, isAdminView bool) error {
 	// Extract enhanced PayPal fee info
 	paypalFee := extractPayPalFee(sub.PayPalDetails)
 
-	// Prepare enhanced data for template
-	addons := sub.Addons
-	if addons == nil {
-		addons = []string{}
-	}
+	// Prepare enhanced data for template, naming items as the catalog does now
+	addons := resolveItemNames(sub.Addons)
+	fees := resolveFeeNames(sub.Fees)
 
 	resp := struct {
 		// Order identification
@@ -280,14 +302,15 @@ loadSuccessData:
 		IsCompleted bool
 		IsAdminView bool
 		Year        int
+		Currency    string
 	}{
 		FormID:             sub.FormID,
-		FormattedID:        formatReceiptID(sub.FormID),
+		FormattedID:        displayReceiptID(sub.ReceiptNumber, sub.FormID),
 		FullName:           formatDisplayName(sub.FullName),
 		FirstName:          formatDisplayName(sub.FirstName),
 		Email:              sub.Email,
 		School:             formatDisplayName(sub.School),
-		Membership:         formatDisplayName(sub.Membership),
+		Membership:         formatDisplayName(resolveItemName(sub.Membership)),
 		MembershipStatus:   formatDisplayName(sub.MembershipStatus),
 		Describe:           formatDisplayName(sub.Describe),
 		Students:           sub.Students,
@@ -295,8 +318,8 @@ loadSuccessData:
 		StudentList:        formatStudentList(sub.Students),
 		Addons:             addons,
 		AddonsList:         formatList(addons),
-		Fees:               sub.Fees,
-		FeesList:           formatFeesMap(sub.Fees),
+		Fees:               fees,
+		FeesList:           formatFeesMap(fees),
 		Donation:           float64(sub.Donation),
 		CalculatedAmount:   sub.CalculatedAmount,
 		CoverFees:          sub.CoverFees,
@@ -315,22 +338,10 @@ loadSuccessData:
 		IsCompleted:        sub.PayPalStatus == "COMPLETED",
 		IsAdminView:        isAdminView,
 		Year:               time.Now().Year(),
+		Currency:           submissionCurrency("membership", sub.FormID),
 	}
 
-	// Log successful access
-	if isAdminView {
-		logger.LogInfo("Admin success page accessed for form %s", formID)
-	} else if tokenInfo != nil {
-		logger.LogInfo("User success page accessed for form %s (token age: %v)", formID, time.Since(tokenInfo.CreatedAt))
-	} else {
-		logger.LogInfo("Success page accessed for form %s", formID)
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := successPageTmpl.Execute(w, resp); err != nil {
-		logger.LogError("Failed to render success template: %v", err)
-		http.Error(w, "Error rendering page", http.StatusInternalServerError)
-	}
+	return successPageTmpl.Execute(w, resp)
 }
 
 // formatMembershipItemsForDisplay converts membership selections into display items
@@ -344,12 +355,14 @@ func formatMembershipItemsForDisplay(sub *data.MembershipSubmission) ([]Membersh
 		return itemsDisplay, total
 	}
 
+	// Items renamed since the order was placed are labeled with their current names
+
 	// 1. Add membership
 	if sub.Membership != "" {
 		if price, exists := inventoryService.GetMembershipPrice(sub.Membership); exists {
 			itemsDisplay = append(itemsDisplay, MembershipItemDisplay{
 				ItemName:   sub.Membership,
-				ItemLabel:  sub.Membership,
+				ItemLabel:  inventoryService.ResolveName(sub.Membership),
 				Quantity:   1,
 				UnitPrice:  price,
 				TotalPrice: price,
@@ -369,7 +382,7 @@ func formatMembershipItemsForDisplay(sub *data.MembershipSubmission) ([]Membersh
 
 				itemsDisplay = append(itemsDisplay, MembershipItemDisplay{
 					ItemName:   feeName,
-					ItemLabel:  feeName,
+					ItemLabel:  inventoryService.ResolveName(feeName),
 					Quantity:   quantity,
 					UnitPrice:  unitPrice,
 					TotalPrice: totalPrice,
@@ -388,7 +401,7 @@ func formatMembershipItemsForDisplay(sub *data.MembershipSubmission) ([]Membersh
 			if price, exists := inventoryService.GetProductPrice(addon); exists {
 				itemsDisplay = append(itemsDisplay, MembershipItemDisplay{
 					ItemName:   addon,
-					ItemLabel:  addon,
+					ItemLabel:  inventoryService.ResolveName(addon),
 					Quantity:   1,
 					UnitPrice:  price,
 					TotalPrice: price,
@@ -428,8 +441,6 @@ func sendConfirmationEmailIfNeeded(sub *data.MembershipSubmission) error {
 		return nil
 	}
 
-	config := email.LoadEmailConfig()
-
 	// Create email data
 	emailData := email.MembershipConfirmationData{
 		FormID:           sub.FormID,
@@ -443,19 +454,26 @@ func sendConfirmationEmailIfNeeded(sub *data.MembershipSubmission) error {
 		Fees:             sub.Fees,
 		Donation:         sub.Donation,
 		CalculatedAmount: sub.CalculatedAmount,
+		Currency:         submissionCurrency("membership", sub.FormID),
 		CoverFees:        sub.CoverFees,
 		PayPalOrderID:    sub.PayPalOrderID,
+		ReceiptNumber:    sub.ReceiptNumber,
 		SubmittedAt:      sub.SubmittedAt,
 		Year:             time.Now().Year(),
 	}
 
-	// Send the email
-	if err := email.SendMembershipConfirmation(config, emailData); err != nil {
+	err := notification.Send(context.Background(), notification.Notification{
+		Template:   membershipReceipt,
+		Data:       emailData,
+		Category:   preferences.Receipts,
+		Recipients: []notification.Recipient{notification.Payer(sub.Email)},
+	})
+	if err != nil {
 		return fmt.Errorf("failed to send confirmation email: %w", err)
 	}
 
 	// Update database to mark email as sent
-	if err := data.UpdateMembershipEmailStatus(sub.FormID, true, sub.AdminNotificationSent); err != nil {
+	if err := data.MarkEmailSent("membership", sub.FormID, true, false); err != nil {
 		logger.LogError("Failed to update confirmation email status in database for %s: %v", sub.FormID, err)
 		// Don't return error here - email was sent successfully
 	}
@@ -471,8 +489,6 @@ func sendAdminNotificationIfNeeded(sub *data.MembershipSubmission) error {
 		return nil
 	}
 
-	config := email.LoadEmailConfig()
-
 	emailData := email.MembershipConfirmationData{
 		FormID:           sub.FormID,
 		FullName:         sub.FullName,
@@ -485,19 +501,25 @@ func sendAdminNotificationIfNeeded(sub *data.MembershipSubmission) error {
 		Fees:             sub.Fees,
 		Donation:         sub.Donation,
 		CalculatedAmount: sub.CalculatedAmount,
+		Currency:         submissionCurrency("membership", sub.FormID),
 		CoverFees:        sub.CoverFees,
 		PayPalOrderID:    sub.PayPalOrderID,
+		ReceiptNumber:    sub.ReceiptNumber,
 		SubmittedAt:      sub.SubmittedAt,
 		Year:             time.Now().Year(),
 	}
 
-	// Send the notification
-	if err := email.SendAdminNotification(config, emailData); err != nil {
+	err := notification.Send(context.Background(), notification.Notification{
+		Template:   membershipAdminNotice,
+		Data:       emailData,
+		Recipients: []notification.Recipient{notification.Staff()},
+	})
+	if err != nil {
 		return fmt.Errorf("failed to send admin notification: %w", err)
 	}
 
 	// Update database to mark notification as sent
-	if err := data.UpdateMembershipEmailStatus(sub.FormID, sub.ConfirmationEmailSent, true); err != nil {
+	if err := data.MarkEmailSent("membership", sub.FormID, false, true); err != nil {
 		logger.LogError("Failed to update admin notification status in database for %s: %v", sub.FormID, err)
 		// Don't return error here - email was sent successfully
 	}
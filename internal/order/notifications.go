@@ -0,0 +1,82 @@
+// internal/order/notifications.go
+package order
+
+import (
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/notification"
+)
+
+// Templates for the notifications sent about an order. The email text itself lives
+// with the email package's renderers and RenderEventConfirmationEmail.
+var (
+	membershipReceipt = notification.Template{
+		Name: "membership receipt",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				subject, body, err := email.RenderMembershipConfirmation(d.(email.MembershipConfirmationData))
+				return notification.Message{Subject: subject, Body: body}, err
+			},
+		},
+	}
+
+	fundraiserReceipt = notification.Template{
+		Name: "fundraiser receipt",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				subject, body, err := email.RenderFundraiserConfirmation(d.(email.FundraiserConfirmationData))
+				return notification.Message{Subject: subject, Body: body}, err
+			},
+		},
+	}
+
+	donationThankYou = notification.Template{
+		Name: "donation thank-you",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				subject, body := email.RenderDonationThankYou(d.(email.DonationThankYouData))
+				return notification.Message{Subject: subject, Body: body}, nil
+			},
+		},
+	}
+
+	eventReceipt = notification.Template{
+		Name: "event receipt",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				subject, body := RenderEventConfirmationEmail(d.(*data.EventSubmission))
+				return notification.Message{Subject: subject, Body: body}, nil
+			},
+		},
+	}
+
+	// The text receipt is short and links to the full one
+	smsReceipt = notification.Template{
+		Name: "text receipt",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelSMS: func(d interface{}) (notification.Message, error) {
+				return notification.Message{Body: RenderSMSConfirmation(d.(*data.SMSRecipient))}, nil
+			},
+		},
+	}
+
+	membershipAdminNotice = notification.Template{
+		Name: "membership admin notice",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				subject, body := email.RenderAdminNotification(d.(email.MembershipConfirmationData))
+				return notification.Message{Subject: subject, Body: body}, nil
+			},
+		},
+	}
+
+	fundraiserAdminNotice = notification.Template{
+		Name: "fundraiser admin notice",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				subject, body := email.RenderFundraiserAdminNotification(d.(email.FundraiserConfirmationData))
+				return notification.Message{Subject: subject, Body: body}, nil
+			},
+		},
+	}
+)
@@ -0,0 +1,215 @@
+// internal/order/outbox.go
+package order
+
+import (
+	"context"
+	"fmt"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
+)
+
+// Outbox task handlers. Each one reloads the submission and relies on the same
+// "IfNeeded" checks as the success pages, so a task that runs twice (or races the
+// success page) doesn't send a second email.
+
+// ConfirmationEmailTask sends the payer's confirmation email for a captured submission
+func ConfirmationEmailTask(ctx context.Context, task data.OutboxTask) error {
+	switch formType := getFormTypeFromID(task.FormID); formType {
+	case "membership":
+		sub, err := data.GetMembershipByID(task.FormID)
+		if err != nil {
+			return err
+		}
+		return sendConfirmationEmailIfNeeded(sub)
+	case "fundraiser":
+		sub, err := data.GetFundraiserByID(task.FormID)
+		if err != nil {
+			return err
+		}
+		return sendFundraiserConfirmationEmailIfNeeded(sub)
+	case "event":
+		sub, err := data.GetEventByID(task.FormID)
+		if err != nil {
+			return err
+		}
+		// The event email links to the order page, so wait for that task to finish
+		if sub.OrderPageURL == "" {
+			return fmt.Errorf("order page for %s not generated yet", task.FormID)
+		}
+		return sendEventConfirmationEmailIfNeeded(sub)
+	case "donation":
+		donation, err := data.GetDonation(task.FormID)
+		if err != nil {
+			return err
+		}
+		return sendDonationThankYouIfNeeded(donation)
+	default:
+		return fmt.Errorf("unknown form type: %s", formType)
+	}
+}
+
+// AdminNotificationTask sends the admin notification for a captured submission
+func AdminNotificationTask(ctx context.Context, task data.OutboxTask) error {
+	switch formType := getFormTypeFromID(task.FormID); formType {
+	case "membership":
+		sub, err := data.GetMembershipByID(task.FormID)
+		if err != nil {
+			return err
+		}
+		return sendAdminNotificationIfNeeded(sub)
+	case "fundraiser":
+		sub, err := data.GetFundraiserByID(task.FormID)
+		if err != nil {
+			return err
+		}
+		return sendFundraiserAdminNotificationIfNeeded(sub)
+	default:
+		return fmt.Errorf("no admin notification for form type: %s", formType)
+	}
+}
+
+// ResendConfirmationEmail sends the payer's confirmation email again, even if it went
+// out before, through the same path as the outbox task
+func ResendConfirmationEmail(formID string) error {
+	switch formType := getFormTypeFromID(formID); formType {
+	case "membership":
+		sub, err := data.GetMembershipByID(formID)
+		if err != nil {
+			return err
+		}
+		sub.ConfirmationEmailSent = false
+		return sendConfirmationEmailIfNeeded(sub)
+	case "fundraiser":
+		sub, err := data.GetFundraiserByID(formID)
+		if err != nil {
+			return err
+		}
+		sub.ConfirmationEmailSent = false
+		return sendFundraiserConfirmationEmailIfNeeded(sub)
+	case "event":
+		sub, err := data.GetEventByID(formID)
+		if err != nil {
+			return err
+		}
+		// The event email is claimed in the database rather than checked on the struct
+		if err := data.ReleaseEventConfirmationEmail(formID); err != nil {
+			return err
+		}
+		return sendEventConfirmationEmailIfNeeded(sub)
+	case "donation":
+		donation, err := data.GetDonation(formID)
+		if err != nil {
+			return err
+		}
+		donation.ThankYouSent = false
+		return sendDonationThankYouIfNeeded(donation)
+	default:
+		return fmt.Errorf("unknown form type: %s", formType)
+	}
+}
+
+// ResendAdminNotification sends the admin notification for a submission again
+func ResendAdminNotification(formID string) error {
+	switch formType := getFormTypeFromID(formID); formType {
+	case "membership":
+		sub, err := data.GetMembershipByID(formID)
+		if err != nil {
+			return err
+		}
+		sub.AdminNotificationSent = false
+		return sendAdminNotificationIfNeeded(sub)
+	case "fundraiser":
+		sub, err := data.GetFundraiserByID(formID)
+		if err != nil {
+			return err
+		}
+		sub.AdminNotificationSent = false
+		return sendFundraiserAdminNotificationIfNeeded(sub)
+	default:
+		return fmt.Errorf("no admin notification for form type: %s", formType)
+	}
+}
+
+// OrderPageTask generates the static order page for a captured event registration
+func OrderPageTask(ctx context.Context, task data.OutboxTask) error {
+	sub, err := data.GetEventByID(task.FormID)
+	if err != nil {
+		return err
+	}
+
+	if sub.OrderPageURL != "" {
+		logger.LogInfo("Order page already generated for %s, skipping", task.FormID)
+		return nil
+	}
+
+	orderPagePath, err := generateStaticOrderPage(sub)
+	if err != nil {
+		return fmt.Errorf("failed to generate static order page: %w", err)
+	}
+	return data.UpdateEventOrderPageURL(task.FormID, orderPagePath)
+}
+
+// SMSConfirmationTask texts the receipt link to a payer who opted in. The send is
+// claimed in the database first, so a retried task never texts twice.
+func SMSConfirmationTask(ctx context.Context, task data.OutboxTask) error {
+	recipient, err := data.GetSMSRecipient(task.FormID)
+	if err != nil {
+		return err
+	}
+	if recipient == nil || recipient.SentAt != nil {
+		return nil
+	}
+	if recipient.PayPalStatus != "COMPLETED" {
+		logger.LogInfo("Skipping SMS confirmation for %s in status %s", task.FormID, recipient.PayPalStatus)
+		return nil
+	}
+
+	claimed, err := data.ClaimSMSConfirmation(recipient.FormType, task.FormID, clock.Now())
+	if err != nil || !claimed {
+		return err
+	}
+
+	err = notification.Send(ctx, notification.Notification{
+		Template:   smsReceipt,
+		Data:       recipient,
+		Category:   preferences.Receipts,
+		Recipients: []notification.Recipient{notification.PayerSMS(recipient.Phone, recipient.Email)},
+	})
+	if err != nil {
+		if releaseErr := data.ReleaseSMSConfirmation(recipient.FormType, task.FormID); releaseErr != nil {
+			logger.LogError("Failed to release SMS confirmation for %s: %v", task.FormID, releaseErr)
+		}
+		return fmt.Errorf("failed to send SMS confirmation: %w", err)
+	}
+
+	logger.LogInfo("SMS confirmation sent for %s", task.FormID)
+	return nil
+}
+
+// RenderSMSConfirmation builds the confirmation text, kept short enough for a couple
+// of SMS segments
+func RenderSMSConfirmation(recipient *data.SMSRecipient) string {
+	var what string
+	switch recipient.FormType {
+	case "event":
+		what = "registration for " + formatDisplayName(recipient.Item)
+	case "membership":
+		what = "membership"
+		if recipient.Item != "" {
+			what = recipient.Item
+		}
+	default:
+		what = "donation"
+	}
+
+	greeting := "Thank you"
+	if recipient.FirstName != "" {
+		greeting += ", " + recipient.FirstName
+	}
+	return fmt.Sprintf("%s! Your %s %s is confirmed. Receipt: %s Reply STOP to opt out.",
+		greeting, formatCurrency(recipient.CalculatedAmount, submissionCurrency(recipient.FormType, recipient.FormID)), what, ReceiptURL(recipient.FormID, recipient.AccessToken))
+}
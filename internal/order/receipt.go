@@ -0,0 +1,91 @@
+// internal/order/receipt.go
+package order
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// publicBaseURL is where payers reach the site, from PUBLIC_BASE_URL
+func publicBaseURL() string {
+	if baseURL := os.Getenv("PUBLIC_BASE_URL"); baseURL != "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+	return "https://suzuki.nfshost.com"
+}
+
+// ReceiptURL is a link to the receipt for a paid submission that works without the
+// checkout session, for messages sent after the payer has left the site
+func ReceiptURL(formID, accessToken string) string {
+	query := url.Values{}
+	query.Set("formID", formID)
+	query.Set("token", accessToken)
+	return publicBaseURL() + "/api/receipt?" + query.Encode()
+}
+
+/*
+ReceiptHandler shows the success page of a completed payment to whoever holds the
+link from ReceiptURL. The submission's stored access token is the credential, as in
+the success page's fallback for completed payments, so the link keeps working after
+the in-memory token has expired.
+*/
+func ReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	formID := r.URL.Query().Get("formID")
+	token := r.URL.Query().Get("token")
+	if formID == "" || token == "" {
+		http.Error(w, "Missing formID or token", http.StatusBadRequest)
+		return
+	}
+
+	var render func(w http.ResponseWriter) error
+	allowed := false
+	if sub, err := data.GetSubmissionByFormID(formID); err == nil {
+		fields := sub.Fields()
+		allowed = receiptAllowed(fields.PayPalStatus, fields.AccessToken, token)
+		switch sub := sub.(type) {
+		case *data.MembershipSubmission:
+			render = func(w http.ResponseWriter) error { return RenderMembershipSuccessPage(w, sub, false) }
+		case *data.EventSubmission:
+			render = func(w http.ResponseWriter) error { return RenderEventSuccessPage(w, sub, false) }
+		case *data.FundraiserSubmission:
+			render = func(w http.ResponseWriter) error { return RenderFundraiserSuccessPage(w, sub, false) }
+		}
+	}
+	if !allowed {
+		logger.LogWarn("Receipt link for %s refused from %s", formID, logger.GetClientIP(r))
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	}
+
+	// The token is in the URL, so keep the page out of caches, logs of other sites and
+	// search results
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w); err != nil {
+		logger.LogError("Failed to render receipt for %s: %v", formID, err)
+	}
+}
+
+// receiptAllowed reports whether token opens the receipt of a submission; only paid
+// submissions have one
+func receiptAllowed(status, storedToken, token string) bool {
+	if status != "COMPLETED" || storedToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(storedToken), []byte(token)) == 1
+}
@@ -0,0 +1,212 @@
+// internal/outbox/outbox.go
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notify"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/sms"
+)
+
+// Task kinds queued when a payment is captured
+const (
+	KindConfirmationEmail = "confirmation_email"
+	KindAdminNotification = "admin_notification"
+	KindOrderPage         = "order_page"
+	KindWebhook           = "outbound_webhook"
+	KindChatNotification  = "chat_notification"
+	KindSMSConfirmation   = "sms_confirmation"
+	// KindRecordCapture retries recording a capture whose database update failed
+	KindRecordCapture = "record_capture"
+)
+
+// CapturePayload is a record_capture task's payload: the capture to record as it came
+// back from the payment provider
+type CapturePayload struct {
+	FormType   string    `json:"form_type"`
+	Details    string    `json:"details"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+const (
+	// DefaultSchedule drains the outbox often so emails go out shortly after payment
+	DefaultSchedule = "30s"
+
+	batchSize   = 25
+	maxAttempts = 8
+	// staleAfter is how long a task can sit in processing before it's assumed orphaned by a crash
+	staleAfter = 10 * time.Minute
+)
+
+// Handler performs one task. Handlers must be safe to run more than once for the same
+// task, since a crash between the work and the completion update repeats it.
+type Handler func(ctx context.Context, task data.OutboxTask) error
+
+// Worker drains the outbox, dispatching each task to the handler for its kind
+type Worker struct {
+	mutex    sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewWorker creates a worker with no handlers
+func NewWorker() *Worker {
+	return &Worker{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for a task kind
+func (w *Worker) Handle(kind string, handler Handler) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.handlers[kind] = handler
+}
+
+// CaptureTasks returns the side effects to queue alongside a completed capture
+func CaptureTasks(formType, formID string, capturedAt time.Time) []data.OutboxTask {
+	tasks := []data.OutboxTask{}
+
+	// The event confirmation links to the order page, so the page goes first
+	if formType == "event" {
+		tasks = append(tasks, data.OutboxTask{Kind: KindOrderPage})
+	}
+	tasks = append(tasks, data.OutboxTask{Kind: KindConfirmationEmail})
+	if formType == "membership" || formType == "fundraiser" {
+		tasks = append(tasks, data.OutboxTask{Kind: KindAdminNotification})
+	}
+
+	if notify.Enabled(notify.EventPaymentCompleted) {
+		tasks = append(tasks, data.OutboxTask{Kind: KindChatNotification})
+	}
+	// Queued whenever Twilio is set up; the task does nothing for payers who didn't opt in
+	if sms.Enabled() {
+		tasks = append(tasks, data.OutboxTask{Kind: KindSMSConfirmation})
+	}
+
+	if config.OutboundWebhookURL() != "" {
+		payload, err := json.Marshal(WebhookEvent{
+			Event:      "payment.captured",
+			FormID:     formID,
+			FormType:   formType,
+			OccurredAt: capturedAt,
+		})
+		if err != nil {
+			logger.LogError("Failed to build outbound webhook payload for %s: %v", formID, err)
+		} else {
+			tasks = append(tasks, data.OutboxTask{Kind: KindWebhook, PayloadJSON: string(payload)})
+		}
+	}
+
+	return tasks
+}
+
+// Run processes due tasks until the outbox is drained or ctx is cancelled. It is
+// registered as a scheduler job.
+func (w *Worker) Run(ctx context.Context) error {
+	if requeued, err := data.RequeueStaleOutboxTasks(clock.Now().Add(-staleAfter)); err != nil {
+		return err
+	} else if requeued > 0 {
+		logger.LogWarn("Requeued %d outbox tasks left in processing", requeued)
+		scheduler.Report(ctx, "requeued stale: %d", requeued)
+	}
+
+	var completed, retried, failed int
+	var failures []string
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		tasks, err := data.ClaimOutboxTasks(clock.Now(), batchSize)
+		if err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			break
+		}
+
+		for i, task := range tasks {
+			if ctx.Err() != nil {
+				// Hand the rest back so the next run picks them up without waiting to go stale
+				for _, unstarted := range tasks[i:] {
+					if err := data.RetryOutboxTask(unstarted.ID, unstarted.LastError, clock.Now()); err != nil {
+						logger.LogError("Failed to return outbox task %d to the queue: %v", unstarted.ID, err)
+					}
+				}
+				return ctx.Err()
+			}
+
+			runErr := w.process(ctx, task)
+			switch {
+			case runErr == nil:
+				if err := data.CompleteOutboxTask(task.ID, clock.Now()); err != nil {
+					logger.LogError("Failed to complete outbox task %d: %v", task.ID, err)
+				}
+				completed++
+			case task.Attempts >= maxAttempts:
+				logger.LogError("Outbox task %d (%s for %s) failed permanently after %d attempts: %v",
+					task.ID, task.Kind, task.FormID, task.Attempts, runErr)
+				if err := data.FailOutboxTask(task.ID, runErr.Error()); err != nil {
+					logger.LogError("Failed to mark outbox task %d failed: %v", task.ID, err)
+				}
+				failed++
+				failures = append(failures, fmt.Sprintf("%s/%s", task.Kind, task.FormID))
+			default:
+				logger.LogWarn("Outbox task %d (%s for %s) attempt %d failed: %v",
+					task.ID, task.Kind, task.FormID, task.Attempts, runErr)
+				if err := data.RetryOutboxTask(task.ID, runErr.Error(), clock.Now().Add(backoff(task.Attempts))); err != nil {
+					logger.LogError("Failed to reschedule outbox task %d: %v", task.ID, err)
+				}
+				retried++
+			}
+		}
+
+		if len(tasks) < batchSize {
+			break
+		}
+	}
+
+	scheduler.Report(ctx, "completed: %d, retrying: %d, failed: %d", completed, retried, failed)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d outbox tasks failed permanently: %s", len(failures), strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// process runs a task's handler, turning a panic into an error so one bad task
+// doesn't stop the rest of the batch
+func (w *Worker) process(ctx context.Context, task data.OutboxTask) (err error) {
+	w.mutex.RLock()
+	handler, ok := w.handlers[task.Kind]
+	w.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("no handler registered for %s tasks", task.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, task)
+}
+
+// backoff grows the retry delay with each attempt, capped at an hour
+func backoff(attempts int) time.Duration {
+	delay := time.Duration(attempts*attempts) * time.Minute
+	if delay > time.Hour {
+		return time.Hour
+	}
+	return delay
+}
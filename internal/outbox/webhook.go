@@ -0,0 +1,49 @@
+// internal/outbox/webhook.go
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"sbcbackend/internal/data"
+)
+
+// WebhookEvent is the JSON body posted to the outbound webhook
+type WebhookEvent struct {
+	Event      string    `json:"event"`
+	FormID     string    `json:"form_id"`
+	FormType   string    `json:"form_type"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// NewWebhookHandler returns a handler that posts each task's payload to url. Receivers
+// should treat the X-Outbox-Task-ID header as an idempotency key, since a delivery is
+// retried until it gets a 2xx response.
+func NewWebhookHandler(url string) Handler {
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	return func(ctx context.Context, task data.OutboxTask) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBufferString(task.PayloadJSON))
+		if err != nil {
+			return fmt.Errorf("failed to build webhook request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Outbox-Task-ID", fmt.Sprintf("%d", task.ID))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("webhook request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
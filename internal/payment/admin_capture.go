@@ -0,0 +1,128 @@
+// internal/payment/admin_capture.go
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/paypal"
+)
+
+// AdminCaptureRequest names the form to capture. OrderID defaults to the form's
+// current order; another order is accepted only if PayPal shows it was made for the form.
+type AdminCaptureRequest struct {
+	FormID  string `json:"formID"`
+	OrderID string `json:"orderID,omitempty"`
+}
+
+// AdminCaptureResponse reports the form once its payment is recorded
+type AdminCaptureResponse struct {
+	FormID        string `json:"formID"`
+	OrderID       string `json:"orderID"`
+	Status        string `json:"status"`
+	ReceiptNumber string `json:"receiptNumber"`
+}
+
+// AdminCaptureOrderHandler lets an admin finish a payment the family approved on PayPal
+// when their browser never came back to capture it. It goes through the same recovery
+// as checkout: an approved order is captured with retries and one PayPal already
+// completed is just recorded, with the receipt and emails either way.
+func AdminCaptureOrderHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only POST requests are supported", "")
+		return
+	}
+
+	var req AdminCaptureRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+		return
+	}
+	req.FormID, req.OrderID = strings.TrimSpace(req.FormID), strings.TrimSpace(req.OrderID)
+	if req.FormID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_form_id", "Missing formID", "")
+		return
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "form_not_found", "Form not found", "")
+		return
+	}
+	if summary.PayPalStatus == "COMPLETED" {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_paid", "This form is already paid", "")
+		return
+	}
+	if req.OrderID == "" {
+		req.OrderID = summary.PayPalOrderID
+	}
+	if req.OrderID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "no_order", "This form has no order to capture", "")
+		return
+	}
+	if provider := submissionProvider(summary.FormType, req.FormID); provider.Name() != config.PaymentProviderPayPal {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "unsupported_provider",
+			fmt.Sprintf("Orders paid through %s can't be captured here", provider.Name()), "")
+		return
+	}
+
+	accessToken, err := GetPayPalAccessToken(r.Context())
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, "paypal_error", "Payment service unavailable", err.Error())
+		return
+	}
+	order, err := recoveryService.getOrderDetailsWithRetry(r.Context(), req.OrderID, accessToken)
+	if errors.Is(err, ErrOrderNotFound) {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "order_not_found", "PayPal has no such order", "")
+		return
+	}
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, "paypal_error", "Failed to look up the order", err.Error())
+		return
+	}
+	if order.InvoiceID() != req.FormID {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "order_mismatch", "The order was made for another form",
+			fmt.Sprintf("order invoice is %q", order.InvoiceID()))
+		return
+	}
+	if req.OrderID != summary.PayPalOrderID {
+		logger.LogWarn("Admin capturing order %s for %s, whose current order is %q", req.OrderID, req.FormID, summary.PayPalOrderID)
+	}
+
+	switch order.Status {
+	case paypal.StatusCompleted:
+		err = recoveryService.syncCompletedOrder(req.FormID, order)
+	case paypal.StatusApproved:
+		err = recoveryService.attemptCapture(r.Context(), req.FormID, req.OrderID, accessToken)
+	default:
+		middleware.WriteAPIError(w, r, http.StatusConflict, "not_approved",
+			fmt.Sprintf("The order is %s; the family hasn't approved the payment", order.Status), "")
+		return
+	}
+	if err != nil {
+		logger.LogError("Admin capture of order %s for %s failed: %v", req.OrderID, req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, "capture_failed", "Capture failed", err.Error())
+		return
+	}
+
+	paid, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		logger.LogError("Failed to reload %s after capturing it: %v", req.FormID, err)
+		paid = summary
+	}
+	logger.LogInfo("Admin captured %s order %s for %s from %s", order.Status, req.OrderID, req.FormID, logger.GetClientIP(r))
+	middleware.WriteAPISuccess(w, r, AdminCaptureResponse{
+		FormID:        req.FormID,
+		OrderID:       req.OrderID,
+		Status:        paid.PayPalStatus,
+		ReceiptNumber: paid.ReceiptNumber,
+	})
+}
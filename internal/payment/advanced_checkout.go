@@ -0,0 +1,201 @@
+// internal/payment/advanced_checkout.go
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/paypal"
+)
+
+// Wallets PayPal Advanced Checkout offers alongside hosted card fields
+const (
+	FundingApplePay  = "apple_pay"
+	FundingGooglePay = "google_pay"
+)
+
+// ErrPaymentSourceRejected is returned when PayPal turns down the payment source a
+// family confirmed, such as a declined card or an expired wallet token
+var ErrPaymentSourceRejected = errors.New("payment source rejected")
+
+// ConfirmPaymentSourceRequest names the card or wallet a family chose for an order
+// created with advanced checkout. PaymentSource is what the hosted card fields or the
+// Apple Pay or Google Pay sheet handed the page, passed on to PayPal as it is.
+type ConfirmPaymentSourceRequest struct {
+	FormID        string          `json:"formID"`
+	OrderID       string          `json:"orderID"`
+	FundingSource string          `json:"fundingSource"`
+	PaymentSource json.RawMessage `json:"paymentSource,omitempty"`
+}
+
+// ConfirmPaymentSourceResponse is where the order stands once its payment source is
+// confirmed: APPROVED and ready to capture, or PAYER_ACTION_REQUIRED with the 3-D
+// Secure page to send the family to first
+type ConfirmPaymentSourceResponse struct {
+	OrderID        string `json:"orderID"`
+	Status         string `json:"status"`
+	PayerActionURL string `json:"payerActionURL,omitempty"`
+	Instrument     string `json:"instrument,omitempty"`
+}
+
+// ConfirmPaymentSourceHandler confirms the card or wallet a family chose for a form's
+// PayPal order, the step advanced checkout adds between creating and capturing it, and
+// records it on the submission.
+func ConfirmPaymentSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only POST requests are supported", "")
+		return
+	}
+
+	var req ConfirmPaymentSourceRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request",
+			"Invalid JSON request", err.Error())
+		return
+	}
+	req.FormID, req.OrderID = strings.TrimSpace(req.FormID), strings.TrimSpace(req.OrderID)
+	if req.FormID == "" || req.OrderID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_fields",
+			"FormID and orderID are required", "")
+		return
+	}
+
+	req.FundingSource = strings.ToLower(strings.TrimSpace(req.FundingSource))
+	switch req.FundingSource {
+	case FundingCard, FundingApplePay, FundingGooglePay:
+	default:
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_funding_source",
+			"Unsupported funding source", req.FundingSource)
+		return
+	}
+	if !config.AdvancedCheckoutEnabled() || Provider().Name() != config.PaymentProviderPayPal {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "advanced_checkout_unavailable",
+			"Card and wallet payments aren't available", "")
+		return
+	}
+
+	source := map[string]json.RawMessage{req.FundingSource: json.RawMessage(`{}`)}
+	if len(req.PaymentSource) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(req.PaymentSource, &fields); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_payment_source",
+				"The payment source must be a JSON object", "")
+			return
+		}
+		source[req.FundingSource] = req.PaymentSource
+	}
+
+	if err := middleware.ValidateFormIDAccess(r.Context(), req.FormID, middleware.GetToken(r.Context())); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "access_denied",
+			"Access denied to this form", "")
+		return
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		logger.LogWarn("Failed to load %s to confirm its payment source: %v", req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "form_not_found", "Form not found", "")
+		return
+	}
+	if summary.PayPalOrderID != req.OrderID {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "order_mismatch",
+			"The order isn't this form's current order", "")
+		return
+	}
+	if summary.PayPalStatus == paypal.StatusCompleted {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_paid", "This form is already paid", "")
+		return
+	}
+
+	accessToken, err := getPayPalAccessTokenWithRetry(r.Context(), 3)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "paypal_error",
+			"Payment service unavailable", err.Error())
+		return
+	}
+	order, err := ConfirmPayPalPaymentSource(r.Context(), accessToken, req.OrderID,
+		paypal.ConfirmPaymentSourceRequest{PaymentSource: source})
+	if errors.Is(err, ErrPaymentSourceRejected) {
+		middleware.WriteAPIError(w, r, http.StatusUnprocessableEntity, "payment_source_rejected",
+			"The card or wallet was not accepted", err.Error())
+		return
+	}
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "paypal_error",
+			"Failed to confirm the payment source", err.Error())
+		return
+	}
+
+	instrument := order.Instrument()
+	if err := data.SetFundingSource(summary.FormType, req.FormID, req.FundingSource); err != nil {
+		logger.LogError("Failed to record funding source for %s: %v", req.FormID, err)
+	}
+	if err := data.SetPaymentInstrument(summary.FormType, req.FormID, instrument); err != nil {
+		logger.LogError("Failed to record payment instrument for %s: %v", req.FormID, err)
+	}
+	logger.LogInfo("Confirmed %s payment source for %s order %s: %s", req.FundingSource, req.FormID, req.OrderID, order.Status)
+
+	response := ConfirmPaymentSourceResponse{
+		OrderID:    order.ID,
+		Status:     order.Status,
+		Instrument: instrument,
+	}
+	if order.Status == paypal.StatusPayerActionRequired {
+		response.PayerActionURL = paypal.FindLink(order.Links, "payer-action")
+	}
+	middleware.WriteAPISuccess(w, r, response)
+}
+
+// ConfirmPayPalPaymentSource attaches the payment source in request to an order. The
+// payment data it carries isn't logged.
+func ConfirmPayPalPaymentSource(ctx context.Context, accessToken, orderID string, request paypal.ConfirmPaymentSourceRequest) (*paypal.Order, error) {
+	url := fmt.Sprintf("%s/v2/checkout/orders/%s/confirm-payment-source", config.APIBase(), orderID)
+
+	bodyBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payment source: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(bodyBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create confirm payment source request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", accessToken)
+	req.Header.Set("PayPal-Request-Id", payPalRequestID(ctx, "confirm", orderID))
+
+	logger.LogInfo("Confirming payment source for PayPal order %s", orderID)
+	resp, err := NewPayPalClient(0).Do(req)
+	if err != nil {
+		logger.LogError("Failed to execute PayPal confirm payment source request: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read confirm payment source response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.LogError("PayPal API error confirming payment source for order %s (HTTP %d): %s", orderID, resp.StatusCode, body)
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity {
+			return nil, fmt.Errorf("%w: %s", ErrPaymentSourceRejected, body)
+		}
+		return nil, fmt.Errorf("failed to confirm payment source: %s", body)
+	}
+
+	order, err := paypal.ParseOrder(body)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
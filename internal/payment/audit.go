@@ -0,0 +1,36 @@
+// internal/payment/audit.go
+package payment
+
+import (
+	"net/http"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// AdminAuditLogHandler lets an admin see how a submission came to be as it is: GET
+// lists every recorded change to its fields (?formID=), oldest first, with the values
+// before and after, what made the change and who. Quick donations have one too.
+func AdminAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	formID := r.URL.Query().Get("formID")
+	if formID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_form_id", "formID is required", "")
+		return
+	}
+
+	entries, err := data.ListAuditLog(formID)
+	if err != nil {
+		logger.LogError("Failed to list audit log of %s: %v", formID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the audit log", "")
+		return
+	}
+	middleware.WriteAPISuccess(w, r, map[string]interface{}{"formID": formID, "changes": entries})
+}
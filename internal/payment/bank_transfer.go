@@ -0,0 +1,240 @@
+// internal/payment/bank_transfer.go
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/outbox"
+)
+
+// FundingBank is the funding source of a donation paid by ACH bank transfer
+const FundingBank = "bank"
+
+// Settlement states of a bank transfer's Checkout Session
+const (
+	transferOpen    = "open"    // the family hasn't authorized the debit yet
+	transferPending = "pending" // authorized, waiting on the bank
+	transferSettled = "settled"
+	transferFailed  = "failed" // returned by the bank or canceled
+	transferExpired = "expired"
+)
+
+// BankTransferProvider takes large fundraiser donations by ACH debit through Stripe
+// Checkout, which costs far less than card or PayPal fees. The family links their
+// bank account on Stripe's page and comes back to the checkout page like any Stripe
+// payment, but the debit takes a few business days to settle: until then the donation
+// is recorded as pending, and SettleBankTransfers completes or fails it.
+type BankTransferProvider struct {
+	*StripeProvider
+}
+
+// NewBankTransferProvider returns a provider debiting bank accounts through the Stripe
+// account in settings
+func NewBankTransferProvider(settings config.StripeSettings) *BankTransferProvider {
+	return &BankTransferProvider{StripeProvider: NewStripeProvider(settings)}
+}
+
+func (p *BankTransferProvider) Name() string { return "bank_transfer" }
+
+func (p *BankTransferProvider) CreateOrder(ctx context.Context, order CheckoutOrder) (*ProviderOrder, error) {
+	return p.createSession(ctx, order, url.Values{
+		"payment_method_types[0]": {"us_bank_account"},
+	})
+}
+
+// ResumeOrder records a transfer authorized or settled without the family coming back
+// to the checkout page. A new order is needed once a session expires or its transfer fails.
+func (p *BankTransferProvider) ResumeOrder(ctx context.Context, formID, orderID string) (*ProviderOrder, error) {
+	summary, err := data.GetSubmissionSummary(formID)
+	if err != nil {
+		return nil, err
+	}
+	state, session, body, err := p.settlement(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	logger.LogInfo("Bank transfer session %s for %s is %s", orderID, formID, state)
+	if err := checkSessionForm(session, formID); err != nil {
+		return nil, err
+	}
+	amountErr := checkSessionAmount(session, summary.CalculatedAmount)
+
+	formType := getFormTypeFromID(formID)
+	switch state {
+	case transferOpen:
+		if amountErr != nil {
+			logger.LogInfo("%v; a new session is needed", amountErr)
+			return nil, nil
+		}
+		return &ProviderOrder{ID: session.ID, ApproveURL: session.URL}, nil
+	case transferPending:
+		if amountErr != nil {
+			return nil, amountErr
+		}
+		if err := data.RecordBankTransferPending(formType, formID, string(body), time.Now()); err != nil {
+			return nil, err
+		}
+		return &ProviderOrder{ID: session.ID}, nil
+	case transferSettled:
+		if amountErr != nil {
+			return nil, amountErr
+		}
+		if err := recordSettledTransfer(formType, formID, string(body)); err != nil {
+			return nil, err
+		}
+		return &ProviderOrder{ID: session.ID}, nil
+	case transferFailed:
+		if err := data.FailBankTransfer(formType, formID, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// CaptureOrder returns the session once the transfer has settled. A transfer that is
+// still settling is recorded as pending and reported with ErrPaymentPending.
+func (p *BankTransferProvider) CaptureOrder(ctx context.Context, order OrderCapture) (string, error) {
+	state, session, body, err := p.settlement(ctx, order.OrderID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkSession(session, order); err != nil {
+		return "", err
+	}
+	switch state {
+	case transferSettled:
+		return string(body), nil
+	case transferPending:
+		if err := data.RecordBankTransferPending(getFormTypeFromID(order.FormID), order.FormID, string(body), time.Now()); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("bank transfer %s for %s: %w", order.OrderID, order.FormID, ErrPaymentPending)
+	}
+	return "", fmt.Errorf("bank transfer session %s is %s", order.OrderID, state)
+}
+
+// settlement reports where a bank transfer's session stands. Stripe only marks the
+// session paid some time after its payment succeeds, so the payment is asked directly.
+func (p *BankTransferProvider) settlement(ctx context.Context, sessionID string) (string, *stripeSession, []byte, error) {
+	session, body, err := p.session(ctx, sessionID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	switch {
+	case session.PaymentStatus == "paid":
+		return transferSettled, session, body, nil
+	case session.Status == "open":
+		return transferOpen, session, body, nil
+	case session.Status != "complete" || session.PaymentIntent == "":
+		return transferExpired, session, body, nil
+	}
+
+	intentBody, err := p.call(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(session.PaymentIntent), nil)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var intent struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(intentBody, &intent); err != nil {
+		return "", nil, nil, fmt.Errorf("parsing Stripe payment intent: %w", err)
+	}
+	switch intent.Status {
+	case "succeeded":
+		return transferSettled, session, body, nil
+	case "processing":
+		return transferPending, session, body, nil
+	default: // requires_payment_method after a return, or canceled
+		return transferFailed, session, body, nil
+	}
+}
+
+// SettleBankTransfers checks every pending bank transfer with Stripe, completing the
+// ones that settled, with their receipts and emails, and failing the ones returned
+func SettleBankTransfers(ctx context.Context) (settled, failed int, err error) {
+	pending, err := data.ListPendingBankTransfers()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(pending) == 0 {
+		return 0, 0, nil
+	}
+
+	provider := NewBankTransferProvider(config.LoadStripeSettings())
+	for _, transfer := range pending {
+		state, _, body, err := provider.settlement(ctx, transfer.OrderID)
+		if err != nil {
+			logger.LogWarn("Failed to check bank transfer %s for %s: %v", transfer.OrderID, transfer.FormID, err)
+			continue
+		}
+
+		switch state {
+		case transferSettled:
+			if err := recordSettledTransfer(transfer.FormType, transfer.FormID, string(body)); err != nil {
+				logger.LogError("Bank transfer for %s settled but recording it failed: %v", transfer.FormID, err)
+				continue
+			}
+			logger.LogInfo("Bank transfer for %s settled after %s", transfer.FormID, time.Since(transfer.Since).Round(time.Hour))
+			settled++
+		case transferFailed, transferExpired:
+			if err := data.FailBankTransfer(transfer.FormType, transfer.FormID, time.Now()); err != nil {
+				logger.LogError("Failed to record returned bank transfer for %s: %v", transfer.FormID, err)
+				continue
+			}
+			logger.LogWarn("Bank transfer for %s was returned; the donation is unpaid again", transfer.FormID)
+			failed++
+		}
+	}
+	return settled, failed, nil
+}
+
+// NewSettlementJob returns the scheduler job that settles pending bank transfers
+func NewSettlementJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		settled, failed, err := SettleBankTransfers(ctx)
+		if settled > 0 || failed > 0 {
+			logger.LogInfo("Bank transfers: %d settled, %d returned", settled, failed)
+		}
+		return err
+	}
+}
+
+// recordSettledTransfer completes a donation whose transfer settled, queueing the
+// receipt and emails every completed payment gets
+func recordSettledTransfer(formType, formID, details string) error {
+	now := time.Now()
+	tasks := outbox.CaptureTasks(formType, formID, now)
+	return data.RecordPayPalCapture(formType, formID, details, "COMPLETED", &now, tasks, data.ActorSystem)
+}
+
+// bankTransferOffered reports whether a donation of amount on formType may be paid by
+// bank transfer
+func bankTransferOffered(formType string, amount float64) bool {
+	settings := config.LoadBankTransferSettings()
+	return settings.Enabled && formType == "fundraiser" && amount >= settings.MinimumAmount
+}
+
+// checkoutProvider is the provider a new order for fundingSource goes through
+func checkoutProvider(fundingSource string) PaymentProvider {
+	if fundingSource == FundingBank {
+		return NewBankTransferProvider(config.LoadStripeSettings())
+	}
+	return Provider()
+}
+
+// submissionProvider is the provider a submission's existing order went through
+func submissionProvider(formType, formID string) PaymentProvider {
+	source, err := data.GetFundingSource(formType, formID)
+	if err != nil {
+		logger.LogWarn("Failed to look up how %s is paid: %v", formID, err)
+	}
+	return checkoutProvider(source)
+}
@@ -0,0 +1,126 @@
+// internal/payment/cancel.go
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/paypal"
+)
+
+// CancelOrderRequest is the form whose order the family is giving up on
+type CancelOrderRequest struct {
+	FormID string `json:"formID"`
+}
+
+// CancelOrderResponse reports whether an order was dropped; false when the form had none
+type CancelOrderResponse struct {
+	FormID    string `json:"formID"`
+	OrderID   string `json:"orderID,omitempty"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// CancelOrderHandler lets a family drop the order checkout created for their form, so
+// they can change their selections and start over with a new one. The order is checked
+// with its provider first: one that was paid after all is recorded and left in place.
+// PayPal has no way to void an uncaptured order; it is never captured and lapses.
+func CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only POST requests are supported", "")
+		return
+	}
+
+	var req CancelOrderRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request",
+			"Invalid JSON request", err.Error())
+		return
+	}
+	if err := middleware.ValidateFormIDAccess(r.Context(), req.FormID, middleware.GetToken(r.Context())); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "access_denied",
+			"Access denied to this form", "")
+		return
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "form_not_found", "Form not found", "")
+		return
+	}
+	if summary.Submitted {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_paid", "This form is already paid", "")
+		return
+	}
+	if summary.PayPalOrderID == "" {
+		middleware.WriteAPISuccess(w, r, CancelOrderResponse{FormID: req.FormID})
+		return
+	}
+	orderID := summary.PayPalOrderID
+
+	provider := submissionProvider(summary.FormType, req.FormID)
+	if provider.Name() == config.PaymentProviderPayPal {
+		err = syncPayPalOrderBeforeCancel(r.Context(), req.FormID, orderID)
+	} else {
+		// Records a session that was paid while the family was away
+		_, err = provider.ResumeOrder(r.Context(), req.FormID, orderID)
+	}
+	if err != nil {
+		logger.LogWarn("Failed to check %s order %s before cancelling it for %s: %v", provider.Name(), orderID, req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, provider.Name()+"_error",
+			"Couldn't confirm the order is unpaid; please try again", "")
+		return
+	}
+
+	if summary.FormType == "membership" {
+		if subscriptionID, err := data.MembershipSubscriptionID(req.FormID); err == nil && subscriptionID == orderID {
+			if err := data.ClearMembershipSubscription(req.FormID); err != nil {
+				logger.LogError("Failed to clear subscription of %s: %v", req.FormID, err)
+			}
+		}
+	}
+	cancelled, err := data.ReleaseOrder(summary.FormType, req.FormID, orderID, data.ActorFamily)
+	if err != nil {
+		logger.LogError("Failed to cancel order %s of %s: %v", orderID, req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to cancel the order", "")
+		return
+	}
+	if !cancelled {
+		// Paid, found paid just now, or moved to another order while we were checking
+		if current, err := data.GetSubmissionSummary(req.FormID); err == nil && current.Submitted {
+			middleware.WriteAPIError(w, r, http.StatusConflict, "already_paid", "This form is already paid", "")
+			return
+		}
+		middleware.WriteAPIError(w, r, http.StatusConflict, "order_in_progress",
+			"This order can no longer be cancelled", "")
+		return
+	}
+
+	logger.LogInfo("Family cancelled %s order %s for %s", provider.Name(), orderID, req.FormID)
+	middleware.WriteAPISuccess(w, r, CancelOrderResponse{FormID: req.FormID, OrderID: orderID, Cancelled: true})
+}
+
+// syncPayPalOrderBeforeCancel records orderID if PayPal shows it paid, so cancelling
+// never loses a payment. An approved order is not captured: the family chose to start over.
+func syncPayPalOrderBeforeCancel(ctx context.Context, formID, orderID string) error {
+	accessToken, err := GetPayPalAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	order, err := recoveryService.getOrderDetailsWithRetry(ctx, orderID, accessToken)
+	if errors.Is(err, ErrOrderNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if order.Status == paypal.StatusCompleted {
+		return recoveryService.syncCompletedOrder(formID, order)
+	}
+	return nil
+}
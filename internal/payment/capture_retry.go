@@ -0,0 +1,139 @@
+// internal/payment/capture_retry.go
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/paypal"
+)
+
+// captureConflictAttempts is how many times recordCapture applies a capture whose
+// payment state keeps changing under it before leaving it to the outbox worker
+const captureConflictAttempts = 3
+
+// recordCapture records a capture the payer has just been charged for, with its
+// receipt number and follow-up tasks. If the database update fails it is queued for
+// the outbox worker to retry, so the money taken and the form never stay apart; only
+// failing to queue it as well is returned.
+// A version read before the capture keeps it from writing over a payment state that
+// changed meanwhile. One a webhook already completed is left as it is; one refunded or
+// disputed is returned as data.ErrVersionConflict for an admin to reconcile; any other
+// change, such as a webhook marking the order approved, is read again and the capture
+// applied over it.
+func recordCapture(formType, formID, details string, capturedAt time.Time, version int) error {
+	var err error
+	if formType == "donation" {
+		_, err = data.RecordDonationCapture(formID, details, capturedAt,
+			[]data.OutboxTask{{Kind: outbox.KindConfirmationEmail}}, data.ActorFamily)
+	} else {
+		for attempt := 1; ; attempt++ {
+			err = data.ApplyCapture(formType, formID, data.Capture{
+				Details: details, Status: paypal.StatusCompleted, SubmittedAt: &capturedAt, Version: version,
+				Tasks: outbox.CaptureTasks(formType, formID, capturedAt),
+			}, data.ActorFamily)
+			if !errors.Is(err, data.ErrVersionConflict) || attempt == captureConflictAttempts {
+				break
+			}
+
+			summary, loadErr := data.GetSubmissionSummary(formID)
+			if loadErr != nil {
+				err = loadErr
+				break
+			}
+			if capturedStatus(summary.PayPalStatus) {
+				if summary.PayPalStatus == paypal.StatusCompleted {
+					logger.LogInfo("Capture of %s was already recorded, leaving it as it is", formID)
+					return nil
+				}
+				logger.LogError("Payment of %s became %s during its capture; the capture is not recorded",
+					formID, summary.PayPalStatus)
+				return err
+			}
+			logger.LogInfo("Payment state of %s changed to %s during its capture, applying it again",
+				formID, summary.PayPalStatus)
+			if version, err = data.GetSubmissionVersion(formID); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	logger.LogError("Failed to record %s capture for %s, queueing a retry: %v", formType, formID, err)
+	if queueErr := queueCaptureRetry(formType, formID, details, capturedAt); queueErr != nil {
+		logger.LogError("Failed to queue capture retry for %s; its payment is not recorded: %v", formID, queueErr)
+		return fmt.Errorf("%w (and queueing a retry failed: %v)", err, queueErr)
+	}
+	return nil
+}
+
+// capturedStatus reports whether a payment in status has already been taken, so a
+// capture arriving after it must not be applied over it
+func capturedStatus(status string) bool {
+	switch status {
+	case paypal.StatusCompleted, "REFUNDED", data.DisputedStatus:
+		return true
+	}
+	return false
+}
+
+func queueCaptureRetry(formType, formID, details string, capturedAt time.Time) error {
+	payload, err := json.Marshal(outbox.CapturePayload{FormType: formType, Details: details, CapturedAt: capturedAt})
+	if err != nil {
+		return fmt.Errorf("failed to encode capture: %w", err)
+	}
+	return data.QueueOutboxTask(formID, data.OutboxTask{Kind: outbox.KindRecordCapture, PayloadJSON: string(payload)})
+}
+
+// RecordCaptureTask is the outbox handler that records a capture whose first attempt
+// failed. A form the webhook or recovery has marked paid, refunded or disputed since is
+// left as it is.
+func RecordCaptureTask(ctx context.Context, task data.OutboxTask) error {
+	var capture outbox.CapturePayload
+	if err := json.Unmarshal([]byte(task.PayloadJSON), &capture); err != nil {
+		return fmt.Errorf("invalid capture payload: %w", err)
+	}
+
+	if capture.FormType == "donation" {
+		recorded, err := data.RecordDonationCapture(task.FormID, capture.Details, capture.CapturedAt,
+			[]data.OutboxTask{{Kind: outbox.KindConfirmationEmail}}, data.ActorSystem)
+		if err == nil && recorded {
+			logger.LogInfo("Recorded capture of donation %s on retry", task.FormID)
+		}
+		return err
+	}
+
+	// The version is read first so a refund or dispute recorded after the status is
+	// checked makes the capture conflict rather than be written over
+	version, err := data.GetSubmissionVersion(task.FormID)
+	if err != nil {
+		return err
+	}
+	summary, err := data.GetSubmissionSummary(task.FormID)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", task.FormID, err)
+	}
+	if capturedStatus(summary.PayPalStatus) {
+		if summary.PayPalStatus != paypal.StatusCompleted {
+			logger.LogWarn("Not recording retried capture of %s over its %s payment", task.FormID, summary.PayPalStatus)
+		}
+		return nil
+	}
+	if err := data.ApplyCapture(capture.FormType, task.FormID, data.Capture{
+		Details: capture.Details, Status: paypal.StatusCompleted, SubmittedAt: &capture.CapturedAt, Version: version,
+		Tasks: outbox.CaptureTasks(capture.FormType, task.FormID, capture.CapturedAt),
+	}, data.ActorSystem); err != nil {
+		// A conflict is retried, and the next attempt sees what changed
+		return err
+	}
+	logger.LogInfo("Recorded %s capture for %s on retry", capture.FormType, task.FormID)
+	return nil
+}
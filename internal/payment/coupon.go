@@ -0,0 +1,31 @@
+package payment
+
+import (
+	"fmt"
+
+	"sbcbackend/internal/data"
+)
+
+// claimCoupon takes a use of the limited coupon formID's order was priced with, just
+// before its payment is captured. Other families may have used it up since the order
+// was priced; data.ErrCouponUsedUp means they have, and the order mustn't be captured.
+func claimCoupon(formType, formID string) error {
+	if inventoryService == nil || (formType != "membership" && formType != "event") {
+		return nil
+	}
+	code, _, err := data.GetCoupon(formType, formID)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		return nil
+	}
+	maxUses := inventoryService.CouponMaxUses(code)
+	if maxUses == 0 {
+		return nil
+	}
+	if err := data.ClaimCouponUse(code, formID, maxUses); err != nil {
+		return fmt.Errorf("coupon %s: %w", code, err)
+	}
+	return nil
+}
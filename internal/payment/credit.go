@@ -0,0 +1,148 @@
+// internal/payment/credit.go
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/outbox"
+)
+
+// FundingCredit is the funding source of a checkout paid entirely with family credit
+const FundingCredit = "credit"
+
+// ApplyCreditRequest asks to spend the payer's credit on a form before its order is made
+type ApplyCreditRequest struct {
+	FormID string `json:"formID"`
+}
+
+// ApplyCreditResponse says how much credit the form now holds and what is left to pay.
+// Paid is set when the credit covered everything and no PayPal order is needed.
+type ApplyCreditResponse struct {
+	FormID string `json:"formID"`
+	data.CreditApplication
+	Paid bool `json:"paid"`
+}
+
+// IssueCreditRequest gives a family credit
+type IssueCreditRequest struct {
+	Email  string  `json:"email"`
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+// ApplyCreditHandler spends the credit of a form's payer on it before create-order, so
+// the PayPal order is only for what is left. Credit that covers the whole form pays it
+// outright, with the usual receipt and emails.
+func ApplyCreditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only POST requests are supported", "")
+		return
+	}
+
+	var req ApplyCreditRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request",
+			"Invalid JSON request", err.Error())
+		return
+	}
+	if err := middleware.ValidateFormIDAccess(r.Context(), req.FormID, middleware.GetToken(r.Context())); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "access_denied",
+			"Access denied to this form", "")
+		return
+	}
+
+	formType := getFormTypeFromID(req.FormID)
+	application, err := data.ApplyCredit(formType, req.FormID)
+	if errors.Is(err, data.ErrPaymentStarted) {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "payment_started",
+			"Credit must be applied before starting payment", "")
+		return
+	}
+	if err != nil {
+		logger.LogError("Failed to apply credit to %s: %v", req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error",
+			"Failed to apply credit", "")
+		return
+	}
+
+	response := ApplyCreditResponse{FormID: req.FormID, CreditApplication: *application}
+	if application.Applied > 0 {
+		logger.LogInfo("Applied $%.2f credit to %s, $%.2f left to pay", application.Applied, req.FormID, application.AmountDue)
+	}
+
+	// Nothing left for PayPal: the credit paid for the form
+	if application.Applied > 0 && application.AmountDue <= 0 {
+		now := time.Now()
+		if err := data.ApplyCapture(formType, req.FormID, data.Capture{
+			Status: "COMPLETED", SubmittedAt: &now, FundingSource: FundingCredit,
+			Tasks: outbox.CaptureTasks(formType, req.FormID, now),
+		}, data.ActorFamily); err != nil {
+			logger.LogError("Failed to record credit payment of %s: %v", req.FormID, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error",
+				"Failed to record the payment", "")
+			return
+		}
+		logger.LogInfo("%s paid in full with $%.2f credit", req.FormID, application.Applied)
+		response.Paid = true
+	}
+
+	middleware.WriteAPISuccess(w, r, response)
+}
+
+// AdminCreditsHandler lets an admin manage family credit. GET lists a family's ledger
+// and balance (?email=), or every entry without one; POST issues credit.
+func AdminCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		email := r.URL.Query().Get("email")
+		entries, err := data.ListCredits(email)
+		if err != nil {
+			logger.LogError("Failed to list credits: %v", err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list credits", "")
+			return
+		}
+		response := map[string]interface{}{"credits": entries}
+		if email != "" {
+			balance, err := data.CreditBalance(email)
+			if err != nil {
+				logger.LogError("Failed to load credit balance: %v", err)
+				middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the balance", "")
+				return
+			}
+			response["balance"] = balance
+		}
+		middleware.WriteAPISuccess(w, r, response)
+
+	case http.MethodPost:
+		var req IssueCreditRequest
+		if err := middleware.ParseJSONRequest(r, &req); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+			return
+		}
+		if strings.TrimSpace(req.Email) == "" || req.Amount <= 0 {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_fields", "An email and a positive amount are required", "")
+			return
+		}
+		entry, err := data.IssueCredit(req.Email, req.Amount, strings.TrimSpace(req.Reason))
+		if err != nil {
+			logger.LogError("Failed to issue credit to %s: %v", req.Email, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to issue credit", "")
+			return
+		}
+		logger.LogInfo("Issued $%.2f credit to %s: %s", entry.Amount, entry.Email, entry.Reason)
+		middleware.WriteAPISuccess(w, r, entry)
+
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+	}
+}
@@ -0,0 +1,458 @@
+// internal/payment/event_change.go
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/food"
+	"sbcbackend/internal/form"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/order"
+)
+
+// eventSelections mirrors the event_options JSON stored in FoodChoicesJSON
+type eventSelections struct {
+	StudentSelections map[string]map[string]bool `json:"student_selections"`
+	SharedSelections  map[string]int             `json:"shared_selections"`
+	CoverFees         bool                       `json:"cover_fees"`
+	HasFoodOrders     bool                       `json:"has_food_orders"`
+}
+
+// ChangeEventOrderHandler swaps or adds food items on a paid event order before the
+// event's change cutoff. Price increases return a PayPal order for the difference;
+// decreases are refunded against the original capture and applied immediately.
+func ChangeEventOrderHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	accessToken := r.Header.Get("X-Access-Token")
+	if accessToken == "" {
+		accessToken = r.URL.Query().Get("token")
+	}
+	if accessToken == "" {
+		http.Error(w, "Missing access token", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		FormID       string          `json:"formID"`
+		EventOptions eventSelections `json:"event_options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if input.FormID == "" {
+		http.Error(w, "Missing form ID", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := data.GetEventByID(input.FormID)
+	if err != nil {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if sub.AccessToken != accessToken {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Only paid orders go through the change flow; unpaid ones use save-event-payment
+	if sub.PayPalStatus != "COMPLETED" {
+		http.Error(w, "This event has not been paid yet", http.StatusConflict)
+		return
+	}
+
+	if inventoryService == nil {
+		logger.LogError("Inventory service not available for event change %s", input.FormID)
+		http.Error(w, "Inventory service not available", http.StatusInternalServerError)
+		return
+	}
+
+	cutoff, ok := inventoryService.GetEventChangeCutoff(sub.Event)
+	if !ok {
+		http.Error(w, "Changes are not available for this event", http.StatusConflict)
+		return
+	}
+	if clock.Now().After(cutoff) {
+		http.Error(w, "The change deadline for this event has passed", http.StatusConflict)
+		return
+	}
+
+	var previous eventSelections
+	if err := json.Unmarshal([]byte(sub.FoodChoicesJSON), &previous); err != nil {
+		logger.LogError("Failed to parse existing selections for %s: %v", input.FormID, err)
+		http.Error(w, "Existing order could not be read", http.StatusInternalServerError)
+		return
+	}
+
+	selections := input.EventOptions
+	// The fee choice was settled at checkout; keep it so the delta only reflects food
+	selections.CoverFees = previous.CoverFees
+
+	if err := inventoryService.ValidateEventSelection(sub.Event, selections.StudentSelections, selections.SharedSelections); err != nil {
+		logger.LogError("Event change validation failed for %s: %v", input.FormID, err)
+		http.Error(w, fmt.Sprintf("Invalid event selections: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := inventoryService.ValidateFoodOnlyChange(sub.Event,
+		previous.StudentSelections, selections.StudentSelections,
+		previous.SharedSelections, selections.SharedSelections); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid event change: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// A coupon used at checkout keeps its dollar discount; it may have expired or been
+	// used up since, and the change only prices the food
+	items, _, err := inventoryService.CalculateEventTotal(sub.Event, selections.StudentSelections, selections.SharedSelections, false, "")
+	if err != nil {
+		logger.LogError("Event change total calculation failed for %s: %v", input.FormID, err)
+		http.Error(w, fmt.Sprintf("Calculation failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	_, discount, err := data.GetCoupon("event", input.FormID)
+	if err != nil {
+		logger.LogError("Event change coupon lookup failed for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to load event payment", http.StatusInternalServerError)
+		return
+	}
+	// So does family credit, which came off the total after processing fees
+	credit, err := data.GetCreditApplied("event", input.FormID)
+	if err != nil {
+		logger.LogError("Event change credit lookup failed for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to load event payment", http.StatusInternalServerError)
+		return
+	}
+	// The round-up given at checkout stays a donation
+	roundUp, err := data.GetRoundUp("event", input.FormID)
+	if err != nil {
+		logger.LogError("Event change round-up lookup failed for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to load event payment", http.StatusInternalServerError)
+		return
+	}
+	newTotal := inventory.AddProcessingFees(math.Max(items-discount, 0), selections.CoverFees) + roundUp
+	newTotal = math.Max(math.Round((newTotal-credit)*100)/100, 0)
+
+	selectionsJSON, err := json.Marshal(selections)
+	if err != nil {
+		http.Error(w, "Failed to serialize selections", http.StatusInternalServerError)
+		return
+	}
+
+	delta := math.Round((newTotal-sub.CalculatedAmount)*100) / 100
+	change := data.EventOrderChange{
+		FormID:                 sub.FormID,
+		CreatedAt:              clock.Now(),
+		PreviousSelectionsJSON: sub.FoodChoicesJSON,
+		NewSelectionsJSON:      string(selectionsJSON),
+		HasFoodOrders:          selections.HasFoodOrders,
+		PreviousAmount:         sub.CalculatedAmount,
+		NewAmount:              newTotal,
+		Delta:                  delta,
+		Status:                 data.EventChangePendingPayment,
+	}
+
+	changeID, err := data.InsertEventOrderChange(change)
+	if err != nil {
+		logger.LogError("Failed to record event change for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save change", http.StatusInternalServerError)
+		return
+	}
+	change.ID = changeID
+
+	logger.LogInfo("Event change %d for %s: $%.2f -> $%.2f (delta $%.2f)",
+		changeID, input.FormID, sub.CalculatedAmount, newTotal, delta)
+
+	// Nothing owed either way, e.g. swapping one meal for another at the same price
+	if delta == 0 {
+		if !applyEventChange(w, sub, &change) {
+			return
+		}
+		respondEventChangeApplied(w, sub, &change, "")
+		return
+	}
+
+	provider := Provider()
+
+	if delta < 0 {
+		captureID := provider.CaptureID(sub.PayPalDetails, sub.FormID)
+		if captureID == "" {
+			logger.LogError("No %s capture found to refund for %s", provider.Name(), input.FormID)
+			failEventChange(change.ID)
+			http.Error(w, "Original payment could not be found for refund", http.StatusInternalServerError)
+			return
+		}
+
+		// The change is saved before the refund, so a second change or refund racing
+		// this one conflicts instead of refunding the same difference twice
+		original := *sub
+		if !applyEventChange(w, sub, &change) {
+			return
+		}
+
+		refundID, err := provider.Refund(r.Context(), captureID, -delta, checkoutCurrency("event", sub.FormID),
+			fmt.Sprintf("%s food order change", sub.Event))
+		if err != nil {
+			revertEventChange(original, sub, &change)
+			failEventChange(change.ID)
+			if errors.Is(err, ErrProviderUnavailable) {
+				logger.LogError("%s unavailable for event change %d refund: %v", provider.Name(), change.ID, err)
+				http.Error(w, "Payment service unavailable", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "Refund failed", http.StatusInternalServerError)
+			return
+		}
+
+		respondEventChangeApplied(w, sub, &change, refundID)
+		return
+	}
+
+	created, err := provider.CreateOrder(r.Context(), CheckoutOrder{
+		FormID:      sub.FormID,
+		InvoiceID:   fmt.Sprintf("%s-change-%d", sub.FormID, change.ID),
+		Description: fmt.Sprintf("%s Food Order Change", sub.Event),
+		Amount:      delta,
+		Currency:    checkoutCurrency("event", sub.FormID),
+		ReturnPath:  fmt.Sprintf("%s?change_id=%d", form.CheckoutPath("event"), change.ID),
+	})
+	if errors.Is(err, ErrProviderUnavailable) {
+		logger.LogError("%s unavailable for event change %d: %v", provider.Name(), change.ID, err)
+		failEventChange(change.ID)
+		http.Error(w, "Payment service unavailable", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		failEventChange(change.ID)
+		http.Error(w, "Failed to create payment order", http.StatusInternalServerError)
+		return
+	}
+	orderID := created.ID
+
+	if err := data.UpdateEventOrderChangePayPalOrder(change.ID, orderID); err != nil {
+		logger.LogError("Failed to store PayPal order for event change %d: %v", change.ID, err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"formID":     sub.FormID,
+		"changeID":   change.ID,
+		"orderID":    orderID,
+		"provider":   provider.Name(),
+		"approveURL": created.ApproveURL,
+		"amount":     fmt.Sprintf("%.2f", delta),
+		"status":     data.EventChangePendingPayment,
+	})
+}
+
+// CaptureEventChangeHandler captures the PayPal order for a price increase and
+// applies the pending change once the difference has been collected.
+func CaptureEventChangeHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	accessToken := r.Header.Get("X-Access-Token")
+	if accessToken == "" {
+		accessToken = r.URL.Query().Get("token")
+	}
+	if accessToken == "" {
+		http.Error(w, "Missing access token", http.StatusForbidden)
+		return
+	}
+
+	var input struct {
+		FormID   string `json:"formID"`
+		ChangeID int64  `json:"changeID"`
+		OrderID  string `json:"orderID"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if input.FormID == "" || input.ChangeID == 0 || input.OrderID == "" {
+		http.Error(w, "Missing formID, changeID or orderID", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := data.GetEventByID(input.FormID)
+	if err != nil {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if sub.AccessToken != accessToken {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	change, err := data.GetEventOrderChangeByID(input.ChangeID)
+	if err != nil || change.FormID != sub.FormID || change.PayPalOrderID != input.OrderID {
+		http.Error(w, "Change not found", http.StatusNotFound)
+		return
+	}
+
+	// Idempotency check
+	if change.Status == data.EventChangeApplied {
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  data.EventChangeApplied,
+			"message": "Change already processed",
+		})
+		return
+	}
+	if change.Status != data.EventChangePendingPayment {
+		http.Error(w, "This change can no longer be completed", http.StatusConflict)
+		return
+	}
+
+	// Another change may have been applied since this one was priced
+	if math.Abs(sub.CalculatedAmount-change.PreviousAmount) > 0.01 || sub.FoodChoicesJSON != change.PreviousSelectionsJSON {
+		failEventChange(change.ID)
+		http.Error(w, "Order changed since this update was started", http.StatusConflict)
+		return
+	}
+
+	provider := Provider()
+	details, err := provider.CaptureOrder(r.Context(), OrderCapture{
+		FormID: sub.FormID, OrderID: input.OrderID, Amount: change.Delta,
+	})
+	if err != nil {
+		logger.LogError("%s capture failed for event change %d (%s): %v", provider.Name(), change.ID, input.FormID, err)
+		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
+		return
+	}
+
+	// The order was created for the difference; anything else taken goes back
+	if captured, ok := provider.CapturedAmount(details); !ok || math.Abs(captured-change.Delta) > 0.005 {
+		logger.LogError("Event change %d for %s captured %.2f (reported: %v), not the %.2f owed",
+			change.ID, sub.FormID, captured, ok, change.Delta)
+		refundEventChangeCapture(r, provider, sub, change, details, captured)
+		failEventChange(change.ID)
+		http.Error(w, "The payment did not match this change", http.StatusConflict)
+		return
+	}
+
+	if !applyEventChange(w, sub, change) {
+		// The difference was collected for a change that can no longer be made
+		refundEventChangeCapture(r, provider, sub, change, details, change.Delta)
+		return
+	}
+	respondEventChangeApplied(w, sub, change, "")
+}
+
+// applyEventChange saves the change's selections over sub, provided the order is still
+// as the change was priced from, and reports whether it did. A change overtaken by
+// another, a refund or a webhook is marked failed with a 409.
+func applyEventChange(w http.ResponseWriter, sub *data.EventSubmission, change *data.EventOrderChange) bool {
+	updated := *sub
+	updated.FoodChoicesJSON = change.NewSelectionsJSON
+	updated.HasFoodOrders = change.HasFoodOrders
+	updated.CalculatedAmount = change.NewAmount
+	updated.FoodChoices = map[string]string{
+		"type":  "event_checkout_v2",
+		"total": fmt.Sprintf("%.2f", change.NewAmount),
+	}
+
+	if updated.HasFoodOrders && updated.FoodOrderID == "" {
+		foodOrderID, err := food.GenerateFoodOrderID(updated.School)
+		if err != nil {
+			logger.LogError("Failed to generate food order ID for %s: %v", updated.FormID, err)
+		} else {
+			updated.FoodOrderID = foodOrderID
+		}
+	}
+
+	err := data.ApplyEventOrderChange(updated, change.PreviousAmount)
+	if errors.Is(err, data.ErrVersionConflict) {
+		logger.LogWarn("Event %s changed while event change %d was made; not applying it", sub.FormID, change.ID)
+		failEventChange(change.ID)
+		http.Error(w, "Order changed since this update was started", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		logger.LogError("Failed to apply event change %d for %s: %v", change.ID, sub.FormID, err)
+		failEventChange(change.ID)
+		http.Error(w, "Failed to save change", http.StatusInternalServerError)
+		return false
+	}
+
+	updated.Version++
+	*sub = updated
+	if err := data.RecomputeNetAmount("event", sub.FormID); err != nil {
+		logger.LogWarn("Failed to update net amount of %s after event change %d: %v", sub.FormID, change.ID, err)
+	}
+	return true
+}
+
+// revertEventChange puts the order back as it was before a change whose refund failed.
+// Should the order have moved on again meanwhile it is left for an admin.
+func revertEventChange(original data.EventSubmission, sub *data.EventSubmission, change *data.EventOrderChange) {
+	original.Version = sub.Version
+	if err := data.ApplyEventOrderChange(original, change.NewAmount); err != nil {
+		logger.LogError("Failed to undo event change %d for %s after its refund failed; the order shows $%.2f unrefunded: %v",
+			change.ID, sub.FormID, change.NewAmount, err)
+		return
+	}
+	if err := data.RecomputeNetAmount("event", sub.FormID); err != nil {
+		logger.LogWarn("Failed to update net amount of %s after undoing event change %d: %v", sub.FormID, change.ID, err)
+	}
+}
+
+// refundEventChangeCapture gives back what an event change's order collected when the
+// change isn't applied
+func refundEventChangeCapture(r *http.Request, provider PaymentProvider, sub *data.EventSubmission,
+	change *data.EventOrderChange, details string, amount float64) {
+	captureID := provider.CaptureID(details, sub.FormID)
+	if captureID == "" || amount <= 0 {
+		logger.LogError("Event change %d for %s collected a payment that can't be refunded automatically", change.ID, sub.FormID)
+		return
+	}
+	refundID, err := provider.Refund(r.Context(), captureID, amount, checkoutCurrency("event", sub.FormID),
+		fmt.Sprintf("%s food order change not applied", sub.Event))
+	if err != nil {
+		logger.LogError("Failed to refund the %.2f collected for event change %d of %s: %v", amount, change.ID, sub.FormID, err)
+		return
+	}
+	logger.LogInfo("Refunded %.2f collected for event change %d of %s (%s)", amount, change.ID, sub.FormID, refundID)
+}
+
+// respondEventChangeApplied marks an applied change so, rebuilds the static order page
+// and answers with the new total. Kitchen totals are read from the saved selections.
+func respondEventChangeApplied(w http.ResponseWriter, sub *data.EventSubmission, change *data.EventOrderChange, refundID string) {
+	now := clock.Now()
+	if err := data.UpdateEventOrderChangeStatus(change.ID, data.EventChangeApplied, refundID, &now); err != nil {
+		logger.LogError("Failed to mark event change %d applied: %v", change.ID, err)
+	}
+
+	orderPageURL, err := order.RegenerateEventOrderPage(sub)
+	if err != nil {
+		logger.LogError("Failed to regenerate order page for %s: %v", sub.FormID, err)
+	}
+
+	logger.LogInfo("Event change %d applied for %s: Total=$%.2f", change.ID, sub.FormID, change.NewAmount)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"formID":       sub.FormID,
+		"changeID":     change.ID,
+		"total":        fmt.Sprintf("%.2f", change.NewAmount),
+		"delta":        fmt.Sprintf("%.2f", change.Delta),
+		"orderPageURL": orderPageURL,
+		"status":       data.EventChangeApplied,
+	})
+}
+
+func failEventChange(changeID int64) {
+	if err := data.UpdateEventOrderChangeStatus(changeID, data.EventChangeFailed, "", nil); err != nil {
+		logger.LogError("Failed to mark event change %d failed: %v", changeID, err)
+	}
+}
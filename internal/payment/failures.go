@@ -0,0 +1,254 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/notify"
+	"sbcbackend/internal/sms"
+)
+
+// Escalation levels for a run of consecutive failures on one PayPal endpoint
+const (
+	alertLog = iota
+	alertEmail
+	alertPage
+)
+
+// payPalFailures counts consecutive failures per PayPal endpoint, so a sustained
+// outage during registration night pages someone instead of only filling the log
+type payPalFailures struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointFailures
+}
+
+type endpointFailures struct {
+	consecutive int
+	since       time.Time // first failure of the current run
+	lastError   string
+	escalated   int               // highest level alerted during the current run
+	alertedAt   map[int]time.Time // last alert per level, kept across runs for the cool-down
+}
+
+var failures = &payPalFailures{endpoints: make(map[string]*endpointFailures)}
+
+// payPalAlert is the data rendered by the payPalFailing template
+type payPalAlert struct {
+	Endpoint  string
+	Failures  int
+	Since     time.Time
+	LastError string
+	Recovered bool
+}
+
+var payPalFailing = notification.Template{
+	Name: "paypal_failing",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(data interface{}) (notification.Message, error) {
+			alert := data.(payPalAlert)
+			if alert.Recovered {
+				return notification.Message{
+					Subject: fmt.Sprintf("PayPal %s recovered", alert.Endpoint),
+					Body: fmt.Sprintf("PayPal %s is answering again after %d consecutive failures starting %s.\n",
+						alert.Endpoint, alert.Failures, alert.Since.Format("Jan 2 3:04 PM")),
+				}, nil
+			}
+			return notification.Message{
+				Subject: fmt.Sprintf("PayPal %s failing: %d consecutive failures", alert.Endpoint, alert.Failures),
+				Body: fmt.Sprintf("PayPal %s has failed %d times in a row since %s.\n\nLast error: %s\n\n"+
+					"Payers may be unable to check out. Check https://www.paypal-status.com and the server log.\n",
+					alert.Endpoint, alert.Failures, alert.Since.Format("Jan 2 3:04 PM"), alert.LastError),
+			}, nil
+		},
+		notification.ChannelChat: renderPayPalAlertText,
+		notification.ChannelSMS:  renderPayPalAlertText,
+	},
+}
+
+func renderPayPalAlertText(data interface{}) (notification.Message, error) {
+	alert := data.(payPalAlert)
+	if alert.Recovered {
+		return notification.Message{Body: fmt.Sprintf("PayPal %s recovered after %d consecutive failures",
+			alert.Endpoint, alert.Failures)}, nil
+	}
+	return notification.Message{Body: fmt.Sprintf("PayPal %s failing: %d consecutive failures since %s. Last error: %s",
+		alert.Endpoint, alert.Failures, alert.Since.Format("3:04 PM"), alert.LastError)}, nil
+}
+
+// recordFailure counts a failed call to endpoint and escalates the run when it crosses
+// a threshold whose cool-down has passed
+func (f *payPalFailures) recordFailure(endpoint string, callErr error) {
+	settings := config.LoadPayPalAlertSettings()
+	now := clock.Now()
+
+	f.mu.Lock()
+	state := f.endpoints[endpoint]
+	if state == nil {
+		state = &endpointFailures{alertedAt: make(map[int]time.Time)}
+		f.endpoints[endpoint] = state
+	}
+	if state.consecutive == 0 {
+		state.since = now.In(clock.Location())
+		state.escalated = alertLog
+	}
+	state.consecutive++
+	state.lastError = callErr.Error()
+
+	level := alertLog
+	switch {
+	case state.consecutive >= settings.PageAfter:
+		level = alertPage
+	case state.consecutive >= settings.EmailAfter:
+		level = alertEmail
+	}
+	if level > alertLog {
+		if last, ok := state.alertedAt[level]; ok && now.Sub(last) < settings.Cooldown {
+			level = alertLog
+		} else {
+			state.alertedAt[level] = now
+			if level > state.escalated {
+				state.escalated = level
+			}
+		}
+	}
+	alert := payPalAlert{Endpoint: endpoint, Failures: state.consecutive, Since: state.since, LastError: state.lastError}
+	f.mu.Unlock()
+
+	logger.LogWarn("PayPal %s failed (%d in a row): %v", endpoint, alert.Failures, callErr)
+	if level > alertLog {
+		logger.LogError("PayPal %s has failed %d times in a row, alerting", endpoint, alert.Failures)
+		go sendPayPalAlert(alert, payPalAlertRecipients(level, settings))
+	}
+}
+
+// recordSuccess ends endpoint's run of failures, telling everyone who was alerted
+// during it that the endpoint has recovered
+func (f *payPalFailures) recordSuccess(endpoint string) {
+	f.mu.Lock()
+	state := f.endpoints[endpoint]
+	if state == nil || state.consecutive == 0 {
+		f.mu.Unlock()
+		return
+	}
+	alert := payPalAlert{Endpoint: endpoint, Failures: state.consecutive, Since: state.since, Recovered: true}
+	escalated := state.escalated
+	state.consecutive = 0
+	state.escalated = alertLog
+	f.mu.Unlock()
+
+	logger.LogInfo("PayPal %s recovered after %d consecutive failures", endpoint, alert.Failures)
+	var recipients []notification.Recipient
+	settings := config.LoadPayPalAlertSettings()
+	for level := alertEmail; level <= escalated; level++ {
+		recipients = append(recipients, payPalAlertRecipients(level, settings)...)
+	}
+	if len(recipients) > 0 {
+		go sendPayPalAlert(alert, recipients)
+	}
+}
+
+// payPalAlertRecipients is who hears about level: the alert mailbox for an email, and
+// chat, push and the on-call phones for a page
+func payPalAlertRecipients(level int, settings config.PayPalAlertSettings) []notification.Recipient {
+	if level < alertPage {
+		return []notification.Recipient{notification.Staff()}
+	}
+	recipients := []notification.Recipient{
+		notification.Chat(notify.EventPayPalFailing),
+		notification.Push(notify.EventPayPalFailing),
+	}
+	if sms.Enabled() {
+		for _, number := range settings.SMSTo {
+			phone, err := sms.NormalizePhone(number)
+			if err != nil {
+				logger.LogWarn("Skipping PAYPAL_ALERT_SMS_TO entry: %v", err)
+				continue
+			}
+			recipients = append(recipients, notification.StaffSMS(phone))
+		}
+	}
+	return recipients
+}
+
+func sendPayPalAlert(alert payPalAlert, recipients []notification.Recipient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := notification.Send(ctx, notification.Notification{
+		Template:   payPalFailing,
+		Data:       alert,
+		Recipients: recipients,
+	})
+	if err != nil {
+		logger.LogError("Failed to send PayPal %s alert: %v", alert.Endpoint, err)
+	}
+}
+
+// monitoredTransport records the outcome of every PayPal call against its endpoint
+type monitoredTransport struct {
+	base http.RoundTripper
+}
+
+// NewPayPalClient returns an HTTP client for PayPal API calls whose failures count
+// towards the outage alerts
+func NewPayPalClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: monitorPayPal(nil)}
+}
+
+// monitorPayPal wraps base, or the default transport when base is nil
+func monitorPayPal(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &monitoredTransport{base: base}
+}
+
+func (t *monitoredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := payPalEndpoint(req.URL.Path)
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		// A caller giving up isn't PayPal's fault; timing out is
+		if !errors.Is(req.Context().Err(), context.Canceled) {
+			failures.recordFailure(endpoint, err)
+		}
+	case payPalUnavailable(resp.StatusCode):
+		failures.recordFailure(endpoint, fmt.Errorf("HTTP %d", resp.StatusCode))
+	default:
+		failures.recordSuccess(endpoint)
+	}
+	return resp, err
+}
+
+// payPalUnavailable reports whether status means PayPal couldn't serve the call, as
+// opposed to refusing it, the way a declined card or an already captured order does
+func payPalUnavailable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusUnauthorized
+}
+
+// payPalEndpoint names the PayPal API path is part of, for alerts
+func payPalEndpoint(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/oauth2/token"):
+		return "authentication"
+	case strings.Contains(path, "/checkout/orders") && strings.HasSuffix(path, "/capture"):
+		return "capture"
+	case strings.Contains(path, "/checkout/orders"):
+		return "orders"
+	case strings.HasSuffix(path, "/refund"):
+		return "refunds"
+	case strings.Contains(path, "/reporting/"):
+		return "reporting"
+	case strings.HasSuffix(path, "/verify-webhook-signature"):
+		return "webhook verification"
+	}
+	return "api"
+}
@@ -0,0 +1,152 @@
+// internal/payment/installments.go
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/form"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/outbox"
+)
+
+// installmentsOffered reports whether a registration of amount on formType may be split
+// into count installments
+func installmentsOffered(formType string, amount float64, count int) bool {
+	settings := config.LoadInstallmentSettings()
+	return settings.Enabled && formType == "event" && amount >= settings.MinimumAmount &&
+		count >= 2 && count <= settings.MaxInstallments
+}
+
+// createInstallmentOrder creates the order for a registration's next installment,
+// scheduling the plan first if the family is just starting it. The first installment
+// is due now and paid at checkout; reminders bring the family back for the rest.
+func createInstallmentOrder(w http.ResponseWriter, r *http.Request, req CreateOrderRequest, formType, description string, total float64, started bool) {
+	if !started {
+		if req.FundingSource == FundingBank || !installmentsOffered(formType, total, req.Installments) {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "installments_unavailable",
+				"Paying in installments isn't available for this payment", "")
+			return
+		}
+		settings := config.LoadInstallmentSettings()
+		if _, err := data.CreateInstallmentPlan(req.FormID, total, req.Installments, clock.Now(), settings.Interval); err != nil {
+			logger.LogError("Failed to schedule installments for %s: %v", req.FormID, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error",
+				"Failed to schedule installments", "")
+			return
+		}
+	}
+
+	plan, err := data.ListInstallments(req.FormID)
+	if err != nil {
+		logger.LogError("Failed to load installments for %s: %v", req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error",
+			"Failed to load installments", "")
+		return
+	}
+	next, err := data.NextInstallment(req.FormID)
+	if err != nil || next == nil {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "installments_paid",
+			"Every installment has been paid", "")
+		return
+	}
+
+	provider := checkoutProvider(req.FundingSource)
+	response := CreateOrderResponse{
+		OrderID:      next.OrderID,
+		FormID:       req.FormID,
+		Provider:     provider.Name(),
+		Installment:  next.Number,
+		Installments: len(plan),
+		Amount:       next.Amount,
+	}
+	if next.OrderID != "" && !fundingSourceChanged(formType, req.FormID, req.FundingSource) {
+		logger.LogInfo("Existing order found for installment %d of %s: %s", next.Number, req.FormID, next.OrderID)
+		middleware.WriteAPISuccess(w, r, response)
+		return
+	}
+
+	logger.LogInfo("Creating %s order for installment %d of %d of %s: %.2f",
+		provider.Name(), next.Number, len(plan), req.FormID, next.Amount)
+	created, err := provider.CreateOrder(r.Context(), CheckoutOrder{
+		FormID:        req.FormID,
+		InvoiceID:     data.InstallmentInvoiceID(req.FormID, next.Number),
+		Description:   fmt.Sprintf("%s (installment %d of %d)", description, next.Number, len(plan)),
+		Amount:        next.Amount,
+		Currency:      checkoutCurrency(formType, req.FormID),
+		ReturnPath:    form.CheckoutPath(formType),
+		FundingSource: req.FundingSource,
+	})
+	if errors.Is(err, ErrProviderUnavailable) {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, provider.Name()+"_error",
+			"Payment service unavailable", err.Error())
+		return
+	}
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "order_creation_failed",
+			"Failed to create payment order", err.Error())
+		return
+	}
+
+	if err := data.SetInstallmentOrder(req.FormID, next.Number, created.ID); err != nil {
+		logger.LogError("Failed to record order of installment %d of %s: %v", next.Number, req.FormID, err)
+	}
+	if err := data.SetFundingSource(formType, req.FormID, req.FundingSource); err != nil {
+		logger.LogError("Failed to record funding source for %s: %v", req.FormID, err)
+	}
+
+	response.OrderID = created.ID
+	response.ApproveURL = created.ApproveURL
+	middleware.WriteAPISuccess(w, r, response)
+}
+
+// captureInstallment captures the order paying one installment
+func captureInstallment(w http.ResponseWriter, r *http.Request, formType, formID string, installment *data.Installment) {
+	if installment.Status == data.InstallmentPaid {
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "COMPLETED",
+			"message": fmt.Sprintf("Installment %d already processed", installment.Number),
+		})
+		return
+	}
+
+	provider := submissionProvider(formType, formID)
+	captureResult, err := provider.CaptureOrder(r.Context(), OrderCapture{
+		FormID: formID, OrderID: installment.OrderID, Amount: installment.Amount,
+	})
+	if err != nil {
+		logger.LogError("%s capture failed for installment %d of %s: %v", provider.Name(), installment.Number, formID, err)
+		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
+		return
+	}
+
+	remaining, err := RecordInstallmentCapture(formType, formID, installment.Number, captureResult, data.ActorFamily)
+	if err != nil {
+		logger.LogError("Failed to record installment %d of %s: %v", installment.Number, formID, err)
+	} else {
+		logger.LogInfo("Installment %d of %s captured, %d left", installment.Number, formID, remaining)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(captureResult))
+}
+
+// RecordInstallmentCapture records a captured installment and returns how many are
+// left. The last one completes the registration like any capture, queueing its receipt
+// and emails.
+func RecordInstallmentCapture(formType, formID string, number int, details string, actor data.Actor) (int, error) {
+	now := time.Now()
+	remaining, recorded, err := data.RecordInstallmentPayment(formType, formID, number, details, now, actor)
+	if err != nil || !recorded || remaining > 0 {
+		return remaining, err
+	}
+	tasks := outbox.CaptureTasks(formType, formID, now)
+	return 0, data.RecordPayPalCapture(formType, formID, details, "COMPLETED", &now, tasks, actor)
+}
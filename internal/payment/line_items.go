@@ -0,0 +1,148 @@
+// internal/payment/line_items.go
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"sbcbackend/internal/currency"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
+)
+
+// maxItemName is the longest item name PayPal accepts
+const maxItemName = 127
+
+// membershipLineItems itemizes a membership checkout the way its summary page does
+func membershipLineItems(sub *data.MembershipSubmission) []inventory.LineItem {
+	if inventoryService == nil {
+		return nil
+	}
+	items := inventoryService.MembershipLineItems(sub.Membership, sub.Addons, sub.Fees)
+	if sub.Donation > 0 {
+		items = append(items, inventory.LineItem{Name: "Extra Donation", Price: sub.Donation, Quantity: 1, Donation: true})
+	}
+	return items
+}
+
+// eventLineItems itemizes an event checkout from its saved selections
+func eventLineItems(sub *data.EventSubmission) []inventory.LineItem {
+	if inventoryService == nil {
+		return nil
+	}
+	var selections eventSelections
+	if err := json.Unmarshal([]byte(sub.FoodChoicesJSON), &selections); err != nil {
+		logger.LogWarn("Failed to read selections of %s to itemize its order: %v", sub.FormID, err)
+		return nil
+	}
+	return inventoryService.EventLineItems(sub.Event, selections.StudentSelections, selections.SharedSelections)
+}
+
+// fundraiserLineItems lists a fundraiser checkout's donation to each student
+func fundraiserLineItems(sub *data.FundraiserSubmission) []inventory.LineItem {
+	var items []inventory.LineItem
+	for _, donation := range sub.DonationItems {
+		if donation.Amount > 0 {
+			items = append(items, inventory.LineItem{
+				Name: fmt.Sprintf("Donation for %s", donation.StudentName), Price: donation.Amount, Quantity: 1, Donation: true,
+			})
+		}
+	}
+	return items
+}
+
+// finishLineItems adds the processing fee a family chose to cover and any round-up to a
+// form's items and returns them with the discount its coupon and credit take off.
+// Coupons come off before the fee is worked out and credit after, as when the total was
+// calculated.
+func finishLineItems(formType, formID, code string, items []inventory.LineItem, coverFees bool) ([]inventory.LineItem, float64) {
+	if len(items) == 0 {
+		return nil, 0
+	}
+	_, coupon, err := data.GetCoupon(formType, formID)
+	if err != nil {
+		logger.LogWarn("Failed to load coupon of %s to itemize its order: %v", formID, err)
+		return nil, 0
+	}
+	credit, err := data.GetCreditApplied(formType, formID)
+	if err != nil {
+		logger.LogWarn("Failed to load credit of %s to itemize its order: %v", formID, err)
+		return nil, 0
+	}
+	roundUp, err := data.GetRoundUp(formType, formID)
+	if err != nil {
+		logger.LogWarn("Failed to load round-up of %s to itemize its order: %v", formID, err)
+		return nil, 0
+	}
+
+	subtotal := -coupon
+	for _, item := range items {
+		subtotal += item.Price * float64(item.Quantity)
+	}
+	if coverFees {
+		fee := currency.Round(inventory.AddProcessingFees(subtotal, true), code) - subtotal
+		if fee > 0 {
+			items = append(items, inventory.LineItem{Name: "Processing Fees", Price: fee, Quantity: 1})
+		}
+	}
+	if roundUp > 0 {
+		items = append(items, inventory.LineItem{Name: "Round-up for the Program", Price: roundUp, Quantity: 1, Donation: true})
+	}
+	return items, coupon + credit
+}
+
+// WithItems lists items on the order so the payer's PayPal receipt matches the checkout
+// summary, with discount taken off them. PayPal rejects an order whose items don't add
+// up to its amount, so when they don't, as when prices changed after the form was
+// saved, the order is left as the one amount.
+func WithItems(orderData paypal.OrderRequest, items []inventory.LineItem, discount float64) paypal.OrderRequest {
+	if len(items) == 0 || len(orderData.PurchaseUnits) != 1 || orderData.PurchaseUnits[0].Amount == nil {
+		return orderData
+	}
+	unit := &orderData.PurchaseUnits[0]
+	code := unit.Amount.CurrencyCode
+
+	lines := make([]paypal.Item, 0, len(items))
+	itemTotal := 0.0
+	for _, item := range items {
+		price := currency.Round(item.Price, code)
+		if item.Quantity <= 0 || price < 0 {
+			return orderData
+		}
+		category := paypal.CategoryDigitalGoods
+		if item.Donation {
+			category = paypal.CategoryDonation
+		}
+		lines = append(lines, paypal.Item{
+			Name:       truncateItemName(item.Name),
+			Quantity:   strconv.Itoa(item.Quantity),
+			UnitAmount: paypal.NewMoney(code, price),
+			Category:   category,
+		})
+		itemTotal += price * float64(item.Quantity)
+	}
+	itemTotal, discount = currency.Round(itemTotal, code), currency.Round(discount, code)
+	if discount < 0 || currency.Value(itemTotal-discount, code) != unit.Amount.Value {
+		logger.LogInfo("Items of %s add up to %s less %s, not the %s charged; sending the order unitemized",
+			unit.InvoiceID, currency.Value(itemTotal, code), currency.Value(discount, code), unit.Amount.Value)
+		return orderData
+	}
+
+	unit.Items = lines
+	unit.Amount.Breakdown = &paypal.AmountBreakdown{ItemTotal: paypal.NewMoney(code, itemTotal)}
+	if discount > 0 {
+		unit.Amount.Breakdown.Discount = paypal.NewMoney(code, discount)
+	}
+	return orderData
+}
+
+func truncateItemName(name string) string {
+	if utf8.RuneCountInString(name) <= maxItemName {
+		return name
+	}
+	return string([]rune(name)[:maxItemName])
+}
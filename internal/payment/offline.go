@@ -0,0 +1,137 @@
+// internal/payment/offline.go
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/outbox"
+)
+
+// Funding sources of payments handed in at school rather than made online
+const (
+	FundingCheck = "check"
+	FundingCash  = "cash"
+)
+
+// OfflinePaymentRequest records a check or cash payment for a form. ReceivedDate is
+// the day the money came in (2006-01-02), today when empty.
+type OfflinePaymentRequest struct {
+	FormID       string `json:"formID"`
+	Method       string `json:"method"`
+	CheckNumber  string `json:"checkNumber,omitempty"`
+	ReceivedDate string `json:"receivedDate,omitempty"`
+	Note         string `json:"note,omitempty"`
+}
+
+// OfflinePaymentResponse is the form as recorded paid
+type OfflinePaymentResponse struct {
+	FormID        string    `json:"formID"`
+	FormType      string    `json:"formType"`
+	Method        string    `json:"method"`
+	Amount        float64   `json:"amount"`
+	ReceivedAt    time.Time `json:"receivedAt"`
+	ReceiptNumber string    `json:"receiptNumber"`
+}
+
+// AdminOfflinePaymentHandler lets an admin mark a form paid by check or cash. PayPal is
+// skipped entirely, but the form gets the receipt number, confirmation emails and
+// order page an online payment would, and counts in the summaries.
+func AdminOfflinePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only POST requests are supported", "")
+		return
+	}
+
+	var req OfflinePaymentRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+		return
+	}
+	req.Method = strings.ToLower(strings.TrimSpace(req.Method))
+	req.CheckNumber = strings.TrimSpace(req.CheckNumber)
+	if req.Method != FundingCheck && req.Method != FundingCash {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_method", "Method must be check or cash", "")
+		return
+	}
+	if req.Method == FundingCheck && req.CheckNumber == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_fields", "A check number is required for checks", "")
+		return
+	}
+
+	receivedAt := clock.Now()
+	if req.ReceivedDate != "" {
+		day, err := time.ParseInLocation("2006-01-02", req.ReceivedDate, clock.Location())
+		if err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_date",
+				"receivedDate must be a date like 2006-01-02", "")
+			return
+		}
+		if day.After(receivedAt) {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_date", "receivedDate can't be in the future", "")
+			return
+		}
+		receivedAt = day
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "form_not_found", "Form not found", "")
+		return
+	}
+	if summary.Submitted {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_paid", "This form is already paid", "")
+		return
+	}
+	if summary.PayPalOrderID != "" {
+		logger.LogWarn("Recording %s payment for %s, which has an open order %s; it can no longer be paid online",
+			req.Method, req.FormID, summary.PayPalOrderID)
+	}
+
+	details, err := json.Marshal(map[string]interface{}{
+		"manual_payment": map[string]string{
+			"method":        req.Method,
+			"reference":     req.CheckNumber,
+			"received_date": receivedAt.Format("2006-01-02"),
+			"note":          strings.TrimSpace(req.Note),
+			"marked_by":     "admin",
+			"marked_at":     clock.Now().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to record the payment", "")
+		return
+	}
+
+	if err := data.ApplyCapture(summary.FormType, req.FormID, data.Capture{
+		Details: string(details), Status: "COMPLETED", SubmittedAt: &receivedAt, FundingSource: req.Method,
+		Tasks: outbox.CaptureTasks(summary.FormType, req.FormID, clock.Now()),
+	}, data.ActorAdmin); err != nil {
+		logger.LogError("Failed to record %s payment of %s: %v", req.Method, req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "record_failed", "Failed to record the payment", "")
+		return
+	}
+
+	paid, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		logger.LogError("Failed to reload %s after recording its payment: %v", req.FormID, err)
+		paid = summary
+	}
+	logger.LogInfo("Recorded %s payment of $%.2f for %s from %s", req.Method, summary.CalculatedAmount, req.FormID, logger.GetClientIP(r))
+	middleware.WriteAPISuccess(w, r, OfflinePaymentResponse{
+		FormID:        req.FormID,
+		FormType:      summary.FormType,
+		Method:        req.Method,
+		Amount:        summary.CalculatedAmount,
+		ReceivedAt:    receivedAt,
+		ReceiptNumber: paid.ReceiptNumber,
+	})
+}
@@ -3,25 +3,31 @@ package payment
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"sbcbackend/internal/clock"
 	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/food"
+	"sbcbackend/internal/form"
 	"sbcbackend/internal/inventory"
 	"sbcbackend/internal/logger"
 	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/paypal"
 )
 
 const (
@@ -29,15 +35,10 @@ const (
 	paypalAPIBaseLive    = "https://api.paypal.com"         // Not used, in config
 )
 
-const (
-	unmatchedPaymentsDir = "/home/protected/boosterbackend/data/unmatched-payments"
-)
-
-var timeZone *time.Location
-
 var (
 	cachedPayPalToken     string
 	cachedPayPalExpiresAt time.Time
+	cachedPayPalTokenFor  string // API base and client ID the cached token was issued for
 	tokenMu               sync.Mutex
 )
 
@@ -58,15 +59,38 @@ type PaymentDetails struct {
 	// Add more fields if needed
 }
 
-// CreateOrderRequest represents the standardized request for creating orders
+// CreateOrderRequest represents the standardized request for creating orders. The
+// funding source is the PayPal button the family clicked; AutoRenew asks for a yearly
+// PayPal subscription instead of a one-time membership payment, and Installments splits
+// a registration into that many scheduled payments.
 type CreateOrderRequest struct {
-	FormID string `json:"formID" validate:"required"`
+	FormID        string `json:"formID" validate:"required"`
+	FundingSource string `json:"fundingSource,omitempty"`
+	AutoRenew     bool   `json:"autoRenew,omitempty"`
+	Installments  int    `json:"installments,omitempty"`
 }
 
-// CreateOrderResponse represents the standardized response for creating orders
+// Funding sources the PayPal buttons offer
+const (
+	FundingPayPal = "paypal"
+	FundingVenmo  = "venmo"
+	FundingCard   = "card"
+)
+
+// CreateOrderResponse represents the standardized response for creating orders. With
+// a provider that redirects, the checkout page sends the family to ApproveURL. For a
+// subscription OrderID is the subscription's ID, which the PayPal buttons approve with
+// createSubscription instead of createOrder. An order for one installment of a plan
+// says which, and its amount.
 type CreateOrderResponse struct {
-	OrderID string `json:"orderID"`
-	FormID  string `json:"formID"`
+	OrderID      string  `json:"orderID"`
+	FormID       string  `json:"formID"`
+	Provider     string  `json:"provider"`
+	ApproveURL   string  `json:"approveURL,omitempty"`
+	Subscription bool    `json:"subscription,omitempty"`
+	Installment  int     `json:"installment,omitempty"`
+	Installments int     `json:"installments,omitempty"`
+	Amount       float64 `json:"amount,omitempty"`
 }
 
 type SavePaymentInput struct {
@@ -77,6 +101,8 @@ type SavePaymentInput struct {
 	Fees       map[string]int `json:"fees"` // Changed to map for quantity
 	Donation   float64        `json:"donation"`
 	CoverFees  bool           `json:"cover_fees"`
+	RoundUp    bool           `json:"round_up,omitempty"` // round the total up for the program
+	Coupon     string         `json:"coupon,omitempty"`
 }
 
 type PayPalTokenResponse struct {
@@ -99,41 +125,26 @@ type PayPalError struct {
 }
 
 func init() {
-	var err error
-	timeZone, err = time.LoadLocation("America/Chicago")
-	if err != nil {
-		log.Fatalf("Error loading time zone: %v", err)
-	}
 	recoveryService = NewPayPalRecoveryService()
 }
 
-func getIntField(data map[string]interface{}, key string) int {
-	if v, ok := data[key]; ok {
-		switch val := v.(type) {
-		case float64:
-			return int(val)
-		case int:
-			return val
-		case string:
-			i, _ := strconv.Atoi(val)
-			return i
-		}
-	}
-	return 0
-}
-
+// GetPayPalAccessToken returns a PayPal access token, reusing the cached one or, after a
+// restart, the one stored in the database while it is valid
 func GetPayPalAccessToken(ctx context.Context) (string, error) {
-	// Check cache first
-	tokenMu.Lock()
-	if cachedPayPalToken != "" && time.Now().Before(cachedPayPalExpiresAt) {
-		token := cachedPayPalToken
-		tokenMu.Unlock()
-		logger.LogInfo("Using cached PayPal access token (expires at %v)", cachedPayPalExpiresAt)
+	// A token is only valid for the API and client it was issued by
+	tokenKey := payPalTokenKey()
+	if token, expiresAt, ok := cachedPayPalAccessToken(tokenKey); ok {
+		logger.LogInfo("Using cached PayPal access token (expires at %v)", expiresAt)
 		return token, nil
 	}
-	tokenMu.Unlock()
+	if token, ok := loadStoredPayPalToken(tokenKey); ok {
+		return token, nil
+	}
+	return fetchPayPalAccessToken(ctx, tokenKey)
+}
 
-	// Not cached or expired; fetch new token
+// fetchPayPalAccessToken asks PayPal for a new access token and caches and stores it
+func fetchPayPalAccessToken(ctx context.Context, tokenKey string) (string, error) {
 	authURL := fmt.Sprintf("%s/v1/oauth2/token", config.APIBase())
 	formData := url.Values{}
 	formData.Set("grant_type", "client_credentials")
@@ -147,13 +158,13 @@ func GetPayPalAccessToken(ctx context.Context) (string, error) {
 
 	client := &http.Client{
 		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
+		Transport: monitorPayPal(&http.Transport{
 			TLSClientConfig:     &tls.Config{MinVersion: tls.VersionTLS12},
 			MaxIdleConns:        10,
 			IdleConnTimeout:     90 * time.Second,
 			DisableCompression:  false,
 			MaxIdleConnsPerHost: 5,
-		},
+		}),
 	}
 
 	logger.LogInfo("Requesting new PayPal access token")
@@ -183,29 +194,28 @@ func GetPayPalAccessToken(ctx context.Context) (string, error) {
 	}
 
 	// Cache the token and its expiry time (renew 1 minute before actual expiry)
-	tokenMu.Lock()
-	cachedPayPalToken = fmt.Sprintf("%s %s", result.TokenType, result.AccessToken)
-	cachedPayPalExpiresAt = time.Now().Add(time.Duration(result.ExpiresIn-60) * time.Second)
-	token := cachedPayPalToken
-	tokenMu.Unlock()
+	token := fmt.Sprintf("%s %s", result.TokenType, result.AccessToken)
+	expiresAt := clock.Now().Add(time.Duration(result.ExpiresIn-60) * time.Second)
+	cachePayPalAccessToken(tokenKey, token, expiresAt)
+	storePayPalToken(tokenKey, token, expiresAt)
 
-	logger.LogInfo("Fetched and cached new PayPal access token (expires at %v)", cachedPayPalExpiresAt)
+	logger.LogInfo("Fetched and cached new PayPal access token (expires at %v)", expiresAt)
 	return token, nil
 }
 
 // GetPayPalOrderDetails fetches order details using the order ID.
-func GetPayPalOrderDetails(orderID, accessToken string) (map[string]interface{}, error) {
+func GetPayPalOrderDetails(orderID, accessToken string) (*paypal.Order, error) {
 	url := fmt.Sprintf("%s/v2/checkout/orders/%s", config.APIBase(), orderID) // Use config
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		logger.LogError("Failed to create PayPal order details request: %v", err)
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	// accessToken already carries its type ("Bearer ..."), as returned by GetPayPalAccessToken
+	req.Header.Set("Authorization", accessToken)
 
 	logger.LogInfo("Fetching PayPal order details for order %s", orderID)
-	client := &http.
-		Client{}
+	client := NewPayPalClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError("Failed to execute PayPal order details request: %v", err)
@@ -216,12 +226,19 @@ func GetPayPalOrderDetails(orderID, accessToken string) (map[string]interface{},
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		err := fmt.Errorf("failed to fetch order details: %s", string(body))
+		if resp.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %s", ErrOrderNotFound, string(body))
+		}
 		logger.LogError("PayPal API error for order %s: %v (HTTP %d)", orderID, err, resp.StatusCode)
 		return nil, err
 	}
 
-	var orderDetails map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&orderDetails)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.LogError("Failed to read PayPal order details for order %s: %v", orderID, err)
+		return nil, err
+	}
+	orderDetails, err := paypal.ParseOrder(body)
 	if err != nil {
 		logger.LogError("Failed to decode PayPal order details for order %s: %v", orderID, err)
 		return nil, err
@@ -231,8 +248,51 @@ func GetPayPalOrderDetails(orderID, accessToken string) (map[string]interface{},
 	return orderDetails, nil
 }
 
+// NewOrderRequest builds the v2 create-order body for a form, charging amount in
+// currencyCode. The form ID travels as invoice_id so captures and webhooks can be
+// matched back to the submission.
+func NewOrderRequest(formID, description string, amount float64, currencyCode string) paypal.OrderRequest {
+	return paypal.OrderRequest{
+		Intent: "CAPTURE",
+		PurchaseUnits: []paypal.PurchaseUnit{{
+			Amount:      paypal.NewMoney(currencyCode, amount),
+			Description: description,
+			InvoiceID:   formID,
+		}},
+	}
+}
+
+// WithFundingSource has PayPal take an order through fundingSource, so the Venmo button
+// opens Venmo instead of a PayPal login. Guest card payments are entered in PayPal's own
+// card form and need no payment_source; with advanced checkout they are entered in
+// hosted card fields and verified by 3-D Secure when the issuer asks. Apple Pay and
+// Google Pay name their payment source when it is confirmed.
+func WithFundingSource(orderData paypal.OrderRequest, fundingSource string) paypal.OrderRequest {
+	if fundingSource == FundingCard && config.AdvancedCheckoutEnabled() {
+		orderData.PaymentSource = map[string]paypal.PaymentSource{
+			FundingCard: {Attributes: &paypal.CardAttributes{
+				Verification: &paypal.CardVerification{Method: "SCA_WHEN_REQUIRED"},
+			}},
+		}
+		return orderData
+	}
+	if fundingSource != FundingPayPal && fundingSource != FundingVenmo {
+		return orderData
+	}
+	orderData.PaymentSource = map[string]paypal.PaymentSource{
+		fundingSource: {ExperienceContext: &paypal.ExperienceContext{ShippingPreference: "NO_SHIPPING"}},
+	}
+	return orderData
+}
+
 // CreatePayPalOrder creates a new PayPal order with given purchase details using the API.
-func CreatePayPalOrder(accessToken string, orderData map[string]interface{}) (map[string]interface{}, error) {
+func CreatePayPalOrder(accessToken string, orderData paypal.OrderRequest) (*paypal.Order, error) {
+	return CreatePayPalOrderWithRequestID(accessToken, "", orderData)
+}
+
+// CreatePayPalOrderWithRequestID creates an order under a PayPal-Request-Id, so PayPal
+// answers a repeat of the request with the order it already created
+func CreatePayPalOrderWithRequestID(accessToken, requestID string, orderData paypal.OrderRequest) (*paypal.Order, error) {
 	url := fmt.Sprintf("%s/v2/checkout/orders", config.APIBase())
 
 	bodyBytes, err := json.Marshal(orderData)
@@ -248,9 +308,12 @@ func CreatePayPalOrder(accessToken string, orderData map[string]interface{}) (ma
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", accessToken)
+	if requestID != "" {
+		req.Header.Set("PayPal-Request-Id", requestID)
+	}
 
 	logger.LogInfo("Creating PayPal order")
-	client := &http.Client{}
+	client := NewPayPalClient(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError("Failed to execute PayPal order creation request: %v", err)
@@ -265,8 +328,13 @@ func CreatePayPalOrder(accessToken string, orderData map[string]interface{}) (ma
 		return nil, err
 	}
 
-	var orderResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&orderResponse); err != nil {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.LogError("Failed to read PayPal order creation response: %v", err)
+		return nil, err
+	}
+	orderResponse, err := paypal.ParseOrder(body)
+	if err != nil {
 		logger.LogError("Failed to decode PayPal order creation response: %v", err)
 		return nil, err
 	}
@@ -275,6 +343,59 @@ func CreatePayPalOrder(accessToken string, orderData map[string]interface{}) (ma
 	return orderResponse, nil
 }
 
+// RefundPayPalCapture refunds part or all of a captured payment using the API. The
+// amount must be in the currency the payment was made in.
+func RefundPayPalCapture(accessToken, captureID string, amount float64, currencyCode, note string) (*paypal.Refund, error) {
+	url := fmt.Sprintf("%s/v2/payments/captures/%s/refund", config.APIBase(), captureID)
+
+	bodyBytes, err := json.Marshal(paypal.RefundRequest{
+		Amount:      paypal.NewMoney(currencyCode, amount),
+		NoteToPayer: note,
+	})
+	if err != nil {
+		logger.LogError("Failed to marshal refund data: %v", err)
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(string(bodyBytes)))
+	if err != nil {
+		logger.LogError("Failed to create PayPal refund request: %v", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", accessToken)
+
+	logger.LogInfo("Refunding $%.2f on PayPal capture %s", amount, captureID)
+	client := NewPayPalClient(30 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.LogError("Failed to execute PayPal refund request: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("failed to refund capture: %s", string(body))
+		logger.LogError("PayPal API error: %v (HTTP %d)", err, resp.StatusCode)
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.LogError("Failed to read PayPal refund response: %v", err)
+		return nil, err
+	}
+	refundResponse, err := paypal.ParseRefund(body)
+	if err != nil {
+		logger.LogError("Failed to decode PayPal refund response: %v", err)
+		return nil, err
+	}
+
+	logger.LogInfo("Successfully refunded PayPal capture %s", captureID)
+	return refundResponse, nil
+}
+
 // CreatePayPalOrderHandler reads formID from query, builds order, and creates PayPal order
 type OrderRequest struct {
 	FormID string `json:"formID"`
@@ -290,6 +411,21 @@ func CreatePayPalOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req.FundingSource = strings.ToLower(strings.TrimSpace(req.FundingSource))
+	switch req.FundingSource {
+	case "", FundingPayPal, FundingVenmo, FundingCard, FundingBank:
+	case FundingApplePay, FundingGooglePay:
+		if !config.AdvancedCheckoutEnabled() {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_funding_source",
+				"Unsupported funding source", req.FundingSource)
+			return
+		}
+	default:
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_funding_source",
+			"Unsupported funding source", req.FundingSource)
+		return
+	}
+
 	token := middleware.GetToken(r.Context())
 
 	// Validate access to form
@@ -299,157 +435,160 @@ func CreatePayPalOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use existing form type detection
 	formType := getFormTypeFromID(req.FormID)
-
-	var calculatedAmount float64
-	var description string
-	var existingOrderID string
-
-	// Load data using existing functions
-	switch formType {
-	case "membership":
-		sub, err := data.GetMembershipByID(req.FormID)
-		if err != nil {
-			logger.LogError("Membership not found for formID %s: %v", req.FormID, err)
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-		if sub.AccessToken != token {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+	sub, err := data.GetSubmissionByFormID(req.FormID)
+	if err != nil {
+		if _, typeErr := data.FormTypeFromID(req.FormID); typeErr != nil {
+			http.Error(w, "Unknown form type", http.StatusBadRequest)
 			return
 		}
-		calculatedAmount = sub.CalculatedAmount
-		description = sub.Membership
-		existingOrderID = sub.PayPalOrderID
+		logger.LogError("%s not found for formID %s: %v", formType, req.FormID, err)
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	fields := sub.Fields()
+	if fields.AccessToken != token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	calculatedAmount := fields.CalculatedAmount
+	existingOrderID := fields.PayPalOrderID
+	paymentStatus := fields.PayPalStatus
+	coverFees := fields.CoverFees
 
-	case "fundraiser":
-		sub, err := data.GetFundraiserByID(req.FormID)
-		if err != nil {
-			logger.LogError("Fundraiser not found for formID %s: %v", req.FormID, err)
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-		if sub.AccessToken != token {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		calculatedAmount = sub.CalculatedAmount
+	var description string
+	var lineItems []inventory.LineItem
+	switch sub := sub.(type) {
+	case *data.MembershipSubmission:
+		description, lineItems = sub.Membership, membershipLineItems(sub)
+	case *data.FundraiserSubmission:
 		description = fmt.Sprintf("Practice-a-Thon Donation (%d students)", len(sub.DonationItems))
-		existingOrderID = sub.PayPalOrderID
+		lineItems = fundraiserLineItems(sub)
+	case *data.EventSubmission:
+		description, lineItems = fmt.Sprintf("%s Registration", sub.Event), eventLineItems(sub)
+	}
 
-	case "event":
-		sub, err := data.GetEventByID(req.FormID)
-		if err != nil {
-			logger.LogError("Event not found for formID %s: %v", req.FormID, err)
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-		if sub.AccessToken != token {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+	if paymentStatus == data.PaymentPendingStatus {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "payment_pending",
+			"A bank transfer for this form is still settling", "")
+		return
+	}
+	if req.FundingSource == FundingBank && !bankTransferOffered(formType, calculatedAmount) {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "bank_transfer_unavailable",
+			"Bank transfer isn't available for this payment", "")
+		return
+	}
+
+	if req.AutoRenew && paymentStatus != "COMPLETED" {
+		if formType != "membership" || req.FundingSource == FundingBank || !autoRenewOffered() {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "auto_renew_unavailable",
+				"Automatic renewal isn't available for this payment", "")
 			return
 		}
-		calculatedAmount = sub.CalculatedAmount
-		description = fmt.Sprintf("%s Registration", sub.Event)
-		existingOrderID = sub.PayPalOrderID
-
-	default:
-		http.Error(w, "Unknown form type", http.StatusBadRequest)
+		createMembershipSubscription(w, r, req, description, calculatedAmount)
 		return
 	}
+	if started := paymentStatus == data.InstallmentsStatus; started || req.Installments > 1 {
+		createInstallmentOrder(w, r, req, formType, description, calculatedAmount, started)
+		return
+	}
+	if formType == "membership" && existingOrderID != "" && paymentStatus != "COMPLETED" {
+		// The member switched to paying once; the unapproved subscription lapses on its own
+		if subscriptionID, err := data.MembershipSubscriptionID(req.FormID); err == nil && subscriptionID == existingOrderID {
+			if err := data.ClearMembershipSubscription(req.FormID); err != nil {
+				logger.LogError("Failed to clear subscription of %s: %v", req.FormID, err)
+			}
+			existingOrderID = ""
+		}
+	}
 
-	// NEW: Check if order already exists and attempt recovery if needed
+	provider := checkoutProvider(req.FundingSource)
+
+	// Check if order already exists and bring it up to date with the provider it went through
 	if existingOrderID != "" {
-		logger.LogInfo("Existing PayPal order found for %s: %s", req.FormID, existingOrderID)
+		previous := submissionProvider(formType, req.FormID)
+		logger.LogInfo("Existing %s order found for %s: %s", previous.Name(), req.FormID, existingOrderID)
 
-		// Attempt recovery to sync the order status
-		if err := recoveryService.RecoverPayPalOrder(r.Context(), req.FormID, existingOrderID); err != nil {
-			logger.LogWarn("PayPal recovery failed for %s: %v", req.FormID, err)
-			// Continue with existing order - recovery failure shouldn't block user
+		existing, err := previous.ResumeOrder(r.Context(), req.FormID, existingOrderID)
+		if err != nil {
+			// Recovery failure shouldn't block the family; keep the existing order
+			logger.LogWarn("%s recovery failed for %s: %v", previous.Name(), req.FormID, err)
+			existing = &ProviderOrder{ID: existingOrderID}
 		}
-
-		response := CreateOrderResponse{
-			OrderID: existingOrderID,
-			FormID:  req.FormID,
+		// An order made for one PayPal button can't be paid through another
+		if existing != nil && fundingSourceChanged(formType, req.FormID, req.FundingSource) {
+			logger.LogInfo("%s order %s for %s was made for another funding source, creating one for %s",
+				provider.Name(), existingOrderID, req.FormID, req.FundingSource)
+			existing = nil
 		}
-		middleware.WriteAPISuccess(w, r, response)
-		return
+		if existing != nil {
+			middleware.WriteAPISuccess(w, r, CreateOrderResponse{
+				OrderID:    existing.ID,
+				FormID:     req.FormID,
+				Provider:   previous.Name(),
+				ApproveURL: existing.ApproveURL,
+			})
+			return
+		}
+		logger.LogInfo("%s order %s for %s can no longer be paid, creating a new one", previous.Name(), existingOrderID, req.FormID)
 	}
 
 	// Validate amount
 	if calculatedAmount <= 0 {
-		logger.LogError("Attempt to create PayPal order with zero/negative amount for formID %s (%.2f)",
-			req.FormID, calculatedAmount)
-		http.Error(w, "Invalid order amount. Cannot create PayPal order.", http.StatusBadRequest)
+		logger.LogError("Attempt to create %s order with zero/negative amount for formID %s (%.2f)",
+			provider.Name(), req.FormID, calculatedAmount)
+		http.Error(w, "Invalid order amount. Cannot create payment order.", http.StatusBadRequest)
 		return
 	}
 
-	logger.LogInfo("Creating PayPal order for %s (%s): %.2f", req.FormID, formType, calculatedAmount)
-
-	// Create PayPal order data
-	orderData := map[string]interface{}{
-		"intent": "CAPTURE",
-		"purchase_units": []map[string]interface{}{
-			{
-				"amount": map[string]interface{}{
-					"currency_code": "USD",
-					"value":         fmt.Sprintf("%.2f", calculatedAmount),
-				},
-				"description": description,
-				"invoice_id":  req.FormID,
-			},
-		},
-	}
-
-	// NEW: Get PayPal access token with retry
-	accessToken, err := getPayPalAccessTokenWithRetry(r.Context(), 3)
-	if err != nil {
-		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "paypal_error",
-			"PayPal service unavailable", err.Error())
+	logger.LogInfo("Creating %s order for %s (%s): %.2f", provider.Name(), req.FormID, formType, calculatedAmount)
+
+	currencyCode := checkoutCurrency(formType, req.FormID)
+	lineItems, discount := finishLineItems(formType, req.FormID, currencyCode, lineItems, coverFees)
+	created, err := provider.CreateOrder(r.Context(), CheckoutOrder{
+		FormID:        req.FormID,
+		InvoiceID:     req.FormID,
+		Description:   description,
+		Amount:        calculatedAmount,
+		Currency:      currencyCode,
+		Items:         lineItems,
+		Discount:      discount,
+		ReturnPath:    form.CheckoutPath(formType),
+		FundingSource: req.FundingSource,
+	})
+	if errors.Is(err, ErrProviderUnavailable) {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, provider.Name()+"_error",
+			"Payment service unavailable", err.Error())
 		return
 	}
-
-	// NEW: Create order with retry
-	orderResponse, err := createPayPalOrderWithRetry(r.Context(), accessToken, orderData, 3)
 	if err != nil {
 		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "order_creation_failed",
-			"Failed to create PayPal order", err.Error())
+			"Failed to create payment order", err.Error())
 		return
 	}
+	orderID := created.ID
 
-	orderID, ok := orderResponse["id"].(string)
-	if !ok || orderID == "" {
-		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "invalid_paypal_response",
-			"Invalid PayPal response", "")
-		return
+	now := time.Now()
+	if err := data.SetPayPalOrder(req.FormID, orderID, &now); err != nil {
+		logger.LogError("Failed to update PayPal order: %v", err)
 	}
 
-	// Update the appropriate form type with PayPal order ID using existing functions
-	now := time.Now()
-	switch formType {
-	case "membership":
-		if err := data.UpdateMembershipPayPalOrder(req.FormID, orderID, &now); err != nil {
-			logger.LogError("Failed to update membership PayPal order: %v", err)
-		}
-	case "fundraiser":
-		if err := data.UpdateFundraiserPayPalOrder(req.FormID, orderID, &now); err != nil {
-			logger.LogError("Failed to update fundraiser PayPal order: %v", err)
-		}
-	case "event":
-		if err := data.UpdateEventPayPalOrder(req.FormID, orderID, &now); err != nil {
-			logger.LogError("Failed to update event PayPal order: %v", err)
-		}
+	if err := data.SetFundingSource(formType, req.FormID, req.FundingSource); err != nil {
+		logger.LogError("Failed to record funding source for %s: %v", req.FormID, err)
 	}
 
 	response := CreateOrderResponse{
-		OrderID: orderID,
-		FormID:  req.FormID,
+		OrderID:    orderID,
+		FormID:     req.FormID,
+		Provider:   provider.Name(),
+		ApproveURL: created.ApproveURL,
 	}
 
 	middleware.WriteAPISuccess(w, r, response)
 }
 
-// CapturePayPalOrderHandler captures a PayPal order for any form type
+// CapturePayPalOrderHandler captures an order with the configured payment provider for
+// any form type; the name predates providers other than PayPal
 func CapturePayPalOrderHandler(w http.ResponseWriter, r *http.Request) {
 	logger.LogHTTPRequest(r)
 	if r.Method != http.MethodPost {
@@ -479,141 +618,124 @@ func CapturePayPalOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use existing form type detection
-	formType := getFormTypeFromID(input.FormID)
+	formType, err := data.FormTypeFromID(input.FormID)
+	if err != nil {
+		http.Error(w, "Unknown form type", http.StatusBadRequest)
+		return
+	}
 
-	// Validate access and check if already captured using existing functions
-	switch formType {
-	case "membership":
-		sub, err := data.GetMembershipByID(input.FormID)
-		if err != nil {
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-		if sub.AccessToken != accessToken {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		// Idempotency check
-		if sub.PayPalStatus == "COMPLETED" {
-			json.NewEncoder(w).Encode(map[string]string{
-				"status":  "COMPLETED",
-				"message": "Order already processed",
-			})
-			return
-		}
+	// Validate access and check if already captured
+	sub, err := data.GetSubmissionSummary(input.FormID)
+	if err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if sub.AccessToken != accessToken {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// Idempotency check
+	if sub.PayPalStatus == "COMPLETED" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "COMPLETED",
+			"message": "Order already processed",
+		})
+		return
+	}
 
-	case "fundraiser":
-		sub, err := data.GetFundraiserByID(input.FormID)
-		if err != nil {
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-		if sub.AccessToken != accessToken {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		// Idempotency check
-		if sub.PayPalStatus == "COMPLETED" {
-			json.NewEncoder(w).Encode(map[string]string{
-				"status":  "COMPLETED",
-				"message": "Order already processed",
-			})
+	if installment, err := data.InstallmentForOrder(input.FormID, input.OrderID); err != nil {
+		logger.LogWarn("Failed to look up installment order %s of %s: %v", input.OrderID, input.FormID, err)
+	} else if installment != nil {
+		captureInstallment(w, r, formType, input.FormID, installment)
+		return
+	}
+	if formType == "membership" {
+		if subscriptionID, err := data.MembershipSubscriptionID(input.FormID); err == nil && subscriptionID == input.OrderID {
+			activateMembershipSubscription(w, r, input.FormID, subscriptionID)
 			return
 		}
+	}
 
-	case "event":
-		sub, err := data.GetEventByID(input.FormID)
-		if err != nil {
-			http.Error(w, "Order not found", http.StatusNotFound)
-			return
-		}
-		if sub.AccessToken != accessToken {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		// Idempotency check
-		if sub.PayPalStatus == "COMPLETED" {
+	// Only the order made for this form can pay for it
+	if input.OrderID != sub.PayPalOrderID {
+		logger.LogWarn("Refusing to capture %s for %s, whose order is %q", input.OrderID, input.FormID, sub.PayPalOrderID)
+		http.Error(w, "This order is not for this form", http.StatusConflict)
+		return
+	}
+
+	provider := submissionProvider(formType, input.FormID)
+
+	// First bring the order up to date in case it was already captured
+	logger.LogInfo("Attempting %s recovery before capture for formID=%s, orderID=%s", provider.Name(), input.FormID, input.OrderID)
+	if _, err := provider.ResumeOrder(r.Context(), input.FormID, input.OrderID); err != nil {
+		logger.LogWarn("%s recovery failed, proceeding with capture: %v", provider.Name(), err)
+	} else {
+		// Recovery might have found the order was already captured
+		// Check again if it's now completed
+		if sub, err := data.GetSubmissionSummary(input.FormID); err == nil && sub.PayPalStatus == "COMPLETED" {
 			json.NewEncoder(w).Encode(map[string]string{
 				"status":  "COMPLETED",
-				"message": "Order already processed",
+				"message": "Order was already captured (recovered)",
 			})
 			return
 		}
+	}
 
-	default:
-		http.Error(w, "Unknown form type", http.StatusBadRequest)
+	// A webhook may record the payment while PayPal captures it; the version read now
+	// keeps the capture from writing over whatever it recorded
+	version, err := data.GetSubmissionVersion(input.FormID)
+	if err != nil {
+		logger.LogError("Failed to read the version of %s: %v", input.FormID, err)
+		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
 		return
 	}
 
-	// NEW: First attempt recovery to see if the order was already captured
-	logger.LogInfo("Attempting PayPal recovery before capture for formID=%s, orderID=%s", input.FormID, input.OrderID)
-	if err := recoveryService.RecoverPayPalOrder(r.Context(), input.FormID, input.OrderID); err != nil {
-		logger.LogWarn("PayPal recovery failed, proceeding with capture: %v", err)
-	} else {
-		// Recovery might have found the order was already captured
-		// Check again if it's now completed
-		switch formType {
-		case "membership":
-			if sub, err := data.GetMembershipByID(input.FormID); err == nil && sub.PayPalStatus == "COMPLETED" {
-				json.NewEncoder(w).Encode(map[string]string{
-					"status":  "COMPLETED",
-					"message": "Order was already captured (recovered)",
-				})
-				return
-			}
-		case "fundraiser":
-			if sub, err := data.GetFundraiserByID(input.FormID); err == nil && sub.PayPalStatus == "COMPLETED" {
-				json.NewEncoder(w).Encode(map[string]string{
-					"status":  "COMPLETED",
-					"message": "Order was already captured (recovered)",
-				})
-				return
-			}
-		case "event":
-			if sub, err := data.GetEventByID(input.FormID); err == nil && sub.PayPalStatus == "COMPLETED" {
-				json.NewEncoder(w).Encode(map[string]string{
-					"status":  "COMPLETED",
-					"message": "Order was already captured (recovered)",
-				})
-				return
-			}
-		}
+	// A limited coupon is claimed before any money moves, so checkouts priced with its
+	// last use can't all be captured. From here on, a capture that fails gives it back.
+	if err := claimCoupon(formType, input.FormID); errors.Is(err, data.ErrCouponUsedUp) {
+		logger.LogWarn("Not capturing %s for %s: %v", input.OrderID, input.FormID, err)
+		http.Error(w, "Your coupon has been used up; remove it and check out again", http.StatusConflict)
+		return
+	} else if err != nil {
+		logger.LogError("Failed to claim the coupon of %s: %v", input.FormID, err)
+		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
+		return
 	}
 
 	// Proceed with capture with retry logic
-	ppToken, err := getPayPalAccessTokenWithRetry(r.Context(), 3)
-	if err != nil {
-		logger.LogError("PayPal access token error: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	captureResult, err := provider.CaptureOrder(r.Context(), OrderCapture{
+		FormID: input.FormID, OrderID: input.OrderID, Amount: sub.CalculatedAmount,
+	})
+	if errors.Is(err, ErrPaymentPending) {
+		// The pending payment holds the coupon's use until it settles or fails
+		logger.LogInfo("%s payment for %s is pending settlement", provider.Name(), input.FormID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  data.PaymentPendingStatus,
+			"message": "Bank transfer authorized; your receipt will be emailed once it settles",
+		})
 		return
 	}
-
-	// NEW: Capture with retry
-	captureResult, err := capturePayPalOrderWithRetry(r.Context(), input.OrderID, ppToken, 3)
 	if err != nil {
-		logger.LogError("PayPal capture failed for %s (%s): %v", input.FormID, formType, err)
+		logger.LogError("%s capture failed for %s (%s): %v", provider.Name(), input.FormID, formType, err)
+		if err := data.ReleaseCouponUse(input.FormID); err != nil {
+			logger.LogWarn("%v", err)
+		}
+		if errors.Is(err, ErrOrderMismatch) {
+			http.Error(w, "This order is not for this form", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
 		return
 	}
 
-	logger.LogInfo("PayPal order %s captured successfully for %s (%s)", input.OrderID, input.FormID, formType)
+	logger.LogInfo("%s order %s captured successfully for %s (%s)", provider.Name(), input.OrderID, input.FormID, formType)
 
-	// Update the appropriate form type with capture details using existing functions
-	now := time.Now()
-	switch formType {
-	case "membership":
-		if err := data.UpdateMembershipPayPalCapture(input.FormID, captureResult, "COMPLETED", &now); err != nil {
-			logger.LogError("Failed to update membership PayPal capture: %v", err)
-		}
-	case "fundraiser":
-		if err := data.UpdateFundraiserPayPalCapture(input.FormID, captureResult, "COMPLETED", &now); err != nil {
-			logger.LogError("Failed to update fundraiser PayPal capture: %v", err)
-		}
-	case "event":
-		if err := data.UpdateEventPayPalCapture(input.FormID, captureResult, "COMPLETED", &now); err != nil {
-			logger.LogError("Failed to update event PayPal capture: %v", err)
-		}
+	// Record the capture and queue its emails/order page in the same transaction; a
+	// failed update is retried by the outbox worker
+	if err := recordCapture(formType, input.FormID, captureResult, time.Now(), version); err != nil {
+		logger.LogError("Failed to update %s PayPal capture: %v", formType, err)
 	}
 
 	// Return the capture result to the frontend
@@ -621,35 +743,6 @@ func CapturePayPalOrderHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(captureResult))
 }
 
-func updatePayPalDetails(formData map[string]interface{}, eventType string, resource map[string]interface{}) {
-	details, _ := formData["paypal_details"].(map[string]interface{})
-	if details == nil {
-		details = make(map[string]interface{})
-	}
-
-	details["last_event"] = eventType
-	details["last_update"] = time.Now().Format(time.RFC3339)
-
-	// Save useful fields from resource
-	if id, _ := resource["id"].(string); id != "" {
-		details["resource_id"] = id
-	}
-	if status, _ := resource["status"].(string); status != "" {
-		details["status"] = status
-	}
-	if amountObj, ok := resource["amount"].(map[string]interface{}); ok {
-		details["amount"] = amountObj
-	}
-	if payer, ok := resource["payer"].(map[string]interface{}); ok {
-		details["payer"] = payer
-	}
-	if shipping, ok := resource["shipping"].(map[string]interface{}); ok {
-		details["shipping"] = shipping
-	}
-
-	formData["paypal_details"] = details
-}
-
 // ProcessMembershipPayment processes and validates membership payment data using inventory service
 func ProcessMembershipPayment(sub *data.MembershipSubmission, input SavePaymentInput) error {
 	// Check if inventory service is available
@@ -663,12 +756,22 @@ func ProcessMembershipPayment(sub *data.MembershipSubmission, input SavePaymentI
 	}
 
 	// Calculate total with tamper protection
-	calculatedTotal, err := inventoryService.CalculateMembershipTotal(
-		input.Membership, input.Addons, input.Fees, input.Donation, input.CoverFees,
+	coupon := inventory.NormalizeCouponCode(input.Coupon)
+	calculatedTotal, discount, err := inventoryService.CalculateMembershipTotal(
+		input.Membership, input.Addons, input.Fees, input.Donation, input.CoverFees, coupon,
 	)
 	if err != nil {
 		return fmt.Errorf("total calculation failed: %w", err)
 	}
+	code, err := inventoryService.MembershipCurrency(input.Membership, input.Addons, input.Fees)
+	if err != nil {
+		return fmt.Errorf("total calculation failed: %w", err)
+	}
+	roundUp := 0.0
+	if input.RoundUp {
+		roundUp = inventory.RoundUpDonation(calculatedTotal, code)
+		calculatedTotal = currency.Round(calculatedTotal+roundUp, code)
+	}
 
 	// Verify client-submitted total matches server calculation (tamper protection)
 	if input.Amount > 0 && math.Abs(calculatedTotal-input.Amount) > 0.01 {
@@ -684,10 +787,24 @@ func ProcessMembershipPayment(sub *data.MembershipSubmission, input SavePaymentI
 	sub.CoverFees = input.CoverFees
 	sub.CalculatedAmount = calculatedTotal
 
+	// Credit held for the old total is given back; the family applies it again
+	if _, err := data.ReleaseCredit("membership", sub.FormID, data.ActorFamily); err != nil {
+		return err
+	}
+
 	// Save to database
 	if err := data.UpdateMembershipPayment(*sub); err != nil {
 		return fmt.Errorf("failed to update membership payment: %w", err)
 	}
+	if err := data.SetCoupon("membership", sub.FormID, coupon, discount); err != nil {
+		return err
+	}
+	if err := data.SetRoundUp("membership", sub.FormID, roundUp); err != nil {
+		return err
+	}
+	if err := data.SetCurrency("membership", sub.FormID, code); err != nil {
+		return err
+	}
 
 	logger.LogInfo("Membership payment processed for %s: Total=$%.2f", sub.FormID, calculatedTotal)
 	return nil
@@ -713,11 +830,14 @@ func SaveEventPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		FormID       string `json:"formID"`
 		EventOptions struct {
-			StudentSelections map[string]map[string]bool `json:"student_selections"`
-			SharedSelections  map[string]int             `json:"shared_selections"`
-			CoverFees         bool                       `json:"cover_fees"`
-			HasFoodOrders     bool                       `json:"has_food_orders"`
+			StudentSelections map[string]map[string]bool  `json:"student_selections"`
+			SharedSelections  map[string]int              `json:"shared_selections"`
+			CoverFees         bool                        `json:"cover_fees"`
+			RoundUp           bool                        `json:"round_up,omitempty"`
+			HasFoodOrders     bool                        `json:"has_food_orders"`
+			DietaryNotes      map[string]data.DietaryNote `json:"dietary_notes,omitempty"`
 		} `json:"event_options"`
+		Coupon string `json:"coupon,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -762,13 +882,39 @@ func SaveEventPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Coupons are checked before the total so a bad code is the family's to fix
+	coupon := inventory.NormalizeCouponCode(input.Coupon)
+	if coupon != "" {
+		if err := inventoryService.ValidateCoupon(coupon, "event"); err != nil {
+			logger.LogInfo("Coupon rejected for %s: %v", input.FormID, err)
+			http.Error(w, fmt.Sprintf("Invalid coupon: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Calculate total using inventory service
-	total, err := inventoryService.CalculateEventTotal(sub.Event, input.EventOptions.StudentSelections, input.EventOptions.SharedSelections, input.EventOptions.CoverFees)
+	total, discount, err := inventoryService.CalculateEventTotal(sub.Event, input.EventOptions.StudentSelections, input.EventOptions.SharedSelections, input.EventOptions.CoverFees, coupon)
 	if err != nil {
 		logger.LogError("Event total calculation failed for %s: %v", input.FormID, err)
 		http.Error(w, fmt.Sprintf("Calculation failed: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// The round-up is worked out here from the total, never taken from the client
+	code := inventoryService.EventCurrency(sub.Event)
+	roundUp := 0.0
+	if input.EventOptions.RoundUp {
+		roundUp = inventory.RoundUpDonation(total, code)
+		total = currency.Round(total+roundUp, code)
+	}
+
+	// Dietary notes live in their own column, not in the selections JSON
+	dietaryNotes, err := food.NormalizeDietaryNotes(input.EventOptions.DietaryNotes, sub.Students)
+	if err != nil {
+		logger.LogError("Dietary notes validation failed for %s: %v", input.FormID, err)
+		http.Error(w, fmt.Sprintf("Invalid dietary notes: %v", err), http.StatusBadRequest)
+		return
+	}
+	input.EventOptions.DietaryNotes = nil
 
 	// Store the selections as JSON in FoodChoicesJSON field
 	selectionsJSON, err := json.Marshal(input.EventOptions)
@@ -803,15 +949,45 @@ func SaveEventPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	sub.CalculatedAmount = total
 	sub.CoverFees = input.EventOptions.CoverFees
+	sub.DietaryNotes = dietaryNotes
 
-	// Save to database using existing update function
-	if err := data.UpdateEventPayment(*sub); err != nil {
+	// Credit held for the old total is given back; the family applies it again
+	if _, err := data.ReleaseCredit("event", input.FormID, data.ActorFamily); err != nil {
+		logger.LogError("Failed to release credit of %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
+
+	// Save to database using existing update function; a payment recorded since the
+	// registration was loaded wins over the family's stale selections
+	err = data.UpdateEventPayment(*sub)
+	if errors.Is(err, data.ErrVersionConflict) {
+		logger.LogWarn("Event %s was paid or changed while its selections were saved", input.FormID)
+		http.Error(w, "This registration's payment changed; reload the page", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		logger.LogError("Failed to update event payment: %v", err)
 		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
 		return
 	}
+	if err := data.SetCoupon("event", input.FormID, coupon, discount); err != nil {
+		logger.LogError("Failed to save coupon for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
+	if err := data.SetRoundUp("event", input.FormID, roundUp); err != nil {
+		logger.LogError("Failed to save round-up for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
+	if err := data.SetCurrency("event", input.FormID, code); err != nil {
+		logger.LogError("Failed to save currency for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
 
-	logger.LogInfo("Event payment data saved for %s using inventory service: Total=$%.2f", input.FormID, total)
+	logger.LogInfo("Event payment data saved for %s using inventory service: Total=$%.2f, coupon %q -$%.2f, round-up $%.2f", input.FormID, total, coupon, discount, roundUp)
 
 	// Return success
 	json.NewEncoder(w).Encode(map[string]string{
@@ -845,6 +1021,8 @@ func SaveMembershipPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		Fees       map[string]int `json:"fees"`
 		Donation   float64        `json:"donation"`
 		CoverFees  bool           `json:"cover_fees"`
+		RoundUp    bool           `json:"round_up,omitempty"`
+		Coupon     string         `json:"coupon,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -888,15 +1066,33 @@ func SaveMembershipPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Coupons are checked before the total so a bad code is the family's to fix
+	coupon := inventory.NormalizeCouponCode(input.Coupon)
+	if coupon != "" {
+		if err := inventoryService.ValidateCoupon(coupon, "membership"); err != nil {
+			logger.LogInfo("Coupon rejected for %s: %v", input.FormID, err)
+			http.Error(w, fmt.Sprintf("Invalid coupon: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Calculate total with tamper protection using inventory service
-	calculatedTotal, err := inventoryService.CalculateMembershipTotal(
-		input.Membership, input.Addons, input.Fees, input.Donation, input.CoverFees,
+	calculatedTotal, discount, err := inventoryService.CalculateMembershipTotal(
+		input.Membership, input.Addons, input.Fees, input.Donation, input.CoverFees, coupon,
 	)
 	if err != nil {
 		logger.LogError("Total calculation failed for %s: %v", input.FormID, err)
 		http.Error(w, fmt.Sprintf("Calculation failed: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// Calculating the total already checked the items share a currency
+	code, _ := inventoryService.MembershipCurrency(input.Membership, input.Addons, input.Fees)
+	// The round-up is worked out here from the total, never taken from the client
+	roundUp := 0.0
+	if input.RoundUp {
+		roundUp = inventory.RoundUpDonation(calculatedTotal, code)
+		calculatedTotal = currency.Round(calculatedTotal+roundUp, code)
+	}
 
 	// Update the submission with validated data
 	sub.Membership = input.Membership
@@ -906,14 +1102,43 @@ func SaveMembershipPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	sub.CoverFees = input.CoverFees
 	sub.CalculatedAmount = calculatedTotal
 
-	// Save to database using existing update function
-	if err := data.UpdateMembershipPayment(*sub); err != nil {
+	// Credit held for the old total is given back; the family applies it again
+	if _, err := data.ReleaseCredit("membership", input.FormID, data.ActorFamily); err != nil {
+		logger.LogError("Failed to release credit of %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
+
+	// Save to database using existing update function; a payment recorded since the
+	// membership was loaded wins over the family's stale selections
+	err = data.UpdateMembershipPayment(*sub)
+	if errors.Is(err, data.ErrVersionConflict) {
+		logger.LogWarn("Membership %s was paid or changed while its selections were saved", input.FormID)
+		http.Error(w, "This membership's payment changed; reload the page", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		logger.LogError("Failed to update membership payment: %v", err)
 		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
 		return
 	}
+	if err := data.SetCoupon("membership", input.FormID, coupon, discount); err != nil {
+		logger.LogError("Failed to save coupon for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
+	if err := data.SetRoundUp("membership", input.FormID, roundUp); err != nil {
+		logger.LogError("Failed to save round-up for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
+	if err := data.SetCurrency("membership", input.FormID, code); err != nil {
+		logger.LogError("Failed to save currency for %s: %v", input.FormID, err)
+		http.Error(w, "Failed to save payment data", http.StatusInternalServerError)
+		return
+	}
 
-	logger.LogInfo("Membership payment data saved for %s: Total=$%.2f", input.FormID, calculatedTotal)
+	logger.LogInfo("Membership payment data saved for %s: Total=$%.2f, coupon %q -$%.2f, round-up $%.2f", input.FormID, calculatedTotal, coupon, discount, roundUp)
 
 	// Return success (same format as event handler)
 	json.NewEncoder(w).Encode(map[string]string{
@@ -924,6 +1149,24 @@ func SaveMembershipPaymentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // getFormTypeFromID extracts form type from formID prefix
+// fundingSourceChanged reports whether the family chose a different funding source than
+// the one the form's unpaid order was made for
+func fundingSourceChanged(formType, formID, fundingSource string) bool {
+	if fundingSource == "" {
+		return false
+	}
+	// Recovery may have just found the order paid
+	if summary, err := data.GetSubmissionSummary(formID); err == nil && summary.PayPalStatus == "COMPLETED" {
+		return false
+	}
+	previous, err := data.GetFundingSource(formType, formID)
+	if err != nil {
+		logger.LogWarn("Keeping the existing order for %s: %v", formID, err)
+		return false
+	}
+	return previous != fundingSource
+}
+
 func getFormTypeFromID(formID string) string {
 	parts := strings.Split(formID, "-")
 	if len(parts) > 0 {
@@ -960,11 +1203,11 @@ func getPayPalAccessTokenWithRetry(ctx context.Context, maxRetries int) (string,
 }
 
 // NEW: Helper function for creating PayPal order with retry
-func createPayPalOrderWithRetry(ctx context.Context, accessToken string, orderData map[string]interface{}, maxRetries int) (map[string]interface{}, error) {
+func createPayPalOrderWithRetry(ctx context.Context, accessToken, requestID string, orderData paypal.OrderRequest, maxRetries int) (*paypal.Order, error) {
 	var lastErr error
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		orderResponse, err := CreatePayPalOrder(accessToken, orderData)
+		orderResponse, err := CreatePayPalOrderWithRequestID(accessToken, requestID, orderData)
 		if err == nil {
 			return orderResponse, nil
 		}
@@ -985,8 +1228,28 @@ func createPayPalOrderWithRetry(ctx context.Context, accessToken string, orderDa
 	return nil, fmt.Errorf("failed to create PayPal order after %d attempts: %w", maxRetries, lastErr)
 }
 
+// payPalRequestID returns the PayPal-Request-Id for one operation on subject. Every
+// retry of the operation sends the same ID so PayPal carries it out once; when the
+// client sent an Idempotency-Key the ID is derived from it, covering the client's
+// retries as well as ours.
+func payPalRequestID(ctx context.Context, operation, subject string) string {
+	if key := middleware.IdempotencyKey(ctx); key != "" {
+		sum := sha256.Sum256([]byte(operation + "\n" + subject + "\n" + key))
+		return operation + "-" + hex.EncodeToString(sum[:16])
+	}
+	random := make([]byte, 16)
+	rand.Read(random)
+	return operation + "-" + hex.EncodeToString(random)
+}
+
+// CapturePayPalOrder makes a single capture attempt and returns the raw capture response
+func CapturePayPalOrder(ctx context.Context, orderID, accessToken string) (string, error) {
+	return capturePayPalOrderWithRetry(ctx, orderID, accessToken, 1)
+}
+
 func capturePayPalOrderWithRetry(ctx context.Context, orderID, accessToken string, maxRetries int) (string, error) {
 	captureURL := fmt.Sprintf("%s/v2/checkout/orders/%s/capture", config.APIBase(), orderID)
+	requestID := payPalRequestID(ctx, "capture", orderID)
 
 	var lastErr error
 
@@ -998,8 +1261,9 @@ func capturePayPalOrderWithRetry(ctx context.Context, orderID, accessToken strin
 
 		req.Header.Set("Authorization", accessToken)
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("PayPal-Request-Id", requestID)
 
-		client := &http.Client{Timeout: time.Second * 30}
+		client := NewPayPalClient(30 * time.Second)
 		resp, err := client.Do(req)
 		if err != nil {
 			lastErr = err
@@ -1038,16 +1302,18 @@ func capturePayPalOrderWithRetry(ctx context.Context, orderID, accessToken strin
 		}
 
 		// Validate the capture was successful
-		var captureData struct {
-			Status string `json:"status"`
-		}
-		if err := json.Unmarshal(body, &captureData); err == nil && captureData.Status == "COMPLETED" {
+		captureData, err := paypal.ParseOrder(body)
+		if err == nil && captureData.Status == paypal.StatusCompleted {
 			logger.LogInfo("Successfully captured PayPal order %s on attempt %d", orderID, attempt)
 			return string(body), nil
 		}
 
-		lastErr = fmt.Errorf("capture completed but status was not COMPLETED: %s", captureData.Status)
-		logger.LogWarn("PayPal capture attempt %d completed but status was: %s", attempt, captureData.Status)
+		status := ""
+		if captureData != nil {
+			status = captureData.Status
+		}
+		lastErr = fmt.Errorf("capture completed but status was not COMPLETED: %s", status)
+		logger.LogWarn("PayPal capture attempt %d completed but status was: %s", attempt, status)
 
 		if attempt < maxRetries {
 			time.Sleep(time.Duration(attempt) * time.Second)
@@ -0,0 +1,93 @@
+// internal/payment/paypal_token.go
+package payment
+
+import (
+	"context"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/scheduler"
+)
+
+// payPalTokenName is the PayPal access token's row in service_tokens
+const payPalTokenName = "paypal"
+
+// payPalTokenKey identifies the API and client a token is issued for
+func payPalTokenKey() string {
+	return config.APIBase() + "|" + config.ClientID()
+}
+
+// cachedPayPalAccessToken returns the cached token when it was issued for tokenKey
+// and hasn't expired
+func cachedPayPalAccessToken(tokenKey string) (string, time.Time, bool) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	if cachedPayPalToken == "" || cachedPayPalTokenFor != tokenKey || !clock.Now().Before(cachedPayPalExpiresAt) {
+		return "", time.Time{}, false
+	}
+	return cachedPayPalToken, cachedPayPalExpiresAt, true
+}
+
+func cachePayPalAccessToken(tokenKey, token string, expiresAt time.Time) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	cachedPayPalToken, cachedPayPalExpiresAt, cachedPayPalTokenFor = token, expiresAt, tokenKey
+}
+
+// loadStoredPayPalToken caches the token stored before a restart, if it was issued
+// for tokenKey and is still valid
+func loadStoredPayPalToken(tokenKey string) (string, bool) {
+	stored, err := data.GetServiceToken(payPalTokenName)
+	if err != nil {
+		logger.LogWarn("Failed to load stored PayPal access token: %v", err)
+		return "", false
+	}
+	if stored == nil || stored.IssuedFor != tokenKey || !clock.Now().Before(stored.ExpiresAt) {
+		return "", false
+	}
+	cachePayPalAccessToken(tokenKey, stored.Token, stored.ExpiresAt)
+	logger.LogInfo("Using stored PayPal access token (expires at %v)", stored.ExpiresAt)
+	return stored.Token, true
+}
+
+// storePayPalToken keeps a new token for the next restart. Failing to is only logged;
+// the token still works from the cache.
+func storePayPalToken(tokenKey, token string, expiresAt time.Time) {
+	err := data.SaveServiceToken(data.ServiceToken{
+		Name: payPalTokenName, Token: token, IssuedFor: tokenKey, ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		logger.LogWarn("Failed to store PayPal access token: %v", err)
+	}
+}
+
+// RefreshPayPalAccessToken gets a new PayPal access token when the current one expires
+// within lead, so checkout never waits on PayPal's auth endpoint. It reports whether
+// it fetched one.
+func RefreshPayPalAccessToken(ctx context.Context, lead time.Duration) (bool, error) {
+	tokenKey := payPalTokenKey()
+	if _, _, ok := cachedPayPalAccessToken(tokenKey); !ok {
+		loadStoredPayPalToken(tokenKey)
+	}
+	if _, expiresAt, ok := cachedPayPalAccessToken(tokenKey); ok && clock.Now().Add(lead).Before(expiresAt) {
+		return false, nil
+	}
+	if _, err := fetchPayPalAccessToken(ctx, tokenKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewPayPalTokenJob returns the scheduler job that keeps the PayPal access token fresh
+func NewPayPalTokenJob(lead time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		refreshed, err := RefreshPayPalAccessToken(ctx, lead)
+		if refreshed {
+			scheduler.Report(ctx, "refreshed PayPal access token")
+		}
+		return err
+	}
+}
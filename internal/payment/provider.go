@@ -0,0 +1,196 @@
+// internal/payment/provider.go
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
+)
+
+// PaymentProvider is a payment processor checkout can take payments through. Whichever
+// provider is used, its order ID, status and details are kept in a submission's
+// paypal_* columns and a paid order is recorded as COMPLETED.
+type PaymentProvider interface {
+	// Name identifies the provider, as PAYMENT_PROVIDER names it
+	Name() string
+	// CreateOrder starts a payment the family still has to approve
+	CreateOrder(ctx context.Context, order CheckoutOrder) (*ProviderOrder, error)
+	// ResumeOrder brings a form's existing order up to date, recording it if it was
+	// paid, and returns nil when it can no longer be paid and a new one is needed
+	ResumeOrder(ctx context.Context, formID, orderID string) (*ProviderOrder, error)
+	// CaptureOrder collects an approved order and returns the provider's response
+	// to store as the submission's payment details. Providers whose orders the family
+	// can carry from one form to another refuse one not made for order's form and amount.
+	CaptureOrder(ctx context.Context, order OrderCapture) (string, error)
+	// CaptureID finds the payment a refund is made against in stored payment details
+	CaptureID(details, formID string) string
+	// CapturedAmount reads how much a capture took from the provider's response, and
+	// whether the response said
+	CapturedAmount(details string) (float64, bool)
+	// Refund returns amount, in the payment's currency, of a captured payment and the
+	// refund's ID
+	Refund(ctx context.Context, captureID string, amount float64, currencyCode, note string) (string, error)
+}
+
+// CheckoutOrder is what a provider needs to start a payment
+type CheckoutOrder struct {
+	FormID        string
+	InvoiceID     string // shown to the provider so payments can be matched to the form
+	Description   string
+	Amount        float64
+	Currency      string               // ISO 4217 code Amount is in
+	Items         []inventory.LineItem // what Amount is for, when the form can be itemized
+	Discount      float64              // coupon and credit taken off Items to make Amount
+	ReturnPath    string               // page the family comes back to after paying on the provider's site
+	FundingSource string               // how the family chose to pay; providers with their own payment page ignore it
+}
+
+// OrderCapture is an order to collect and what it was created to pay for
+type OrderCapture struct {
+	FormID  string
+	OrderID string
+	Amount  float64 // the total the order was created for
+}
+
+// checkoutCurrency is the currency a form is charged in, falling back to the
+// deployment's when the form can't be read
+func checkoutCurrency(formType, formID string) string {
+	code, err := data.GetCurrency(formType, formID)
+	if err != nil {
+		logger.LogWarn("Failed to load currency of %s, using %s: %v", formID, config.Currency(), err)
+		return config.Currency()
+	}
+	return code
+}
+
+// ProviderOrder is an order as the provider reports it
+type ProviderOrder struct {
+	ID         string
+	ApproveURL string // where to send the family to pay, for providers that redirect
+}
+
+// ErrProviderUnavailable wraps failures to reach a provider at all, as opposed to it
+// turning down a request
+var ErrProviderUnavailable = errors.New("payment provider unavailable")
+
+// ErrPaymentPending is returned capturing a payment the family authorized that hasn't
+// settled yet, such as a bank transfer
+var ErrPaymentPending = errors.New("payment pending settlement")
+
+// ErrOrderNotFound is returned looking up an order the provider doesn't know, such as
+// a PayPal order purged some time after it expired
+var ErrOrderNotFound = errors.New("order not found")
+
+// ErrOrderMismatch is returned for an order made to pay for another form or another
+// amount than the one it is captured or recorded for
+var ErrOrderMismatch = errors.New("order is for another form or amount")
+
+var (
+	providerMu       sync.RWMutex
+	providerOverride PaymentProvider
+)
+
+// SetProvider makes checkout use provider instead of the configured one until it is
+// called with nil, returning the previous override. Tests use it to plug in fakes.
+func SetProvider(provider PaymentProvider) PaymentProvider {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	previous := providerOverride
+	providerOverride = provider
+	return previous
+}
+
+// Provider returns the payment provider selected by PAYMENT_PROVIDER
+func Provider() PaymentProvider {
+	providerMu.RLock()
+	override := providerOverride
+	providerMu.RUnlock()
+	if override != nil {
+		return override
+	}
+
+	switch config.PaymentProvider() {
+	case config.PaymentProviderStripe:
+		return NewStripeProvider(config.LoadStripeSettings())
+	default:
+		return payPalProvider{}
+	}
+}
+
+// payPalProvider takes payments through PayPal's orders API with the JS SDK approving
+// them on the checkout page
+type payPalProvider struct{}
+
+func (payPalProvider) Name() string { return config.PaymentProviderPayPal }
+
+func (payPalProvider) CreateOrder(ctx context.Context, order CheckoutOrder) (*ProviderOrder, error) {
+	accessToken, err := getPayPalAccessTokenWithRetry(ctx, 3)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	orderResponse, err := createPayPalOrderWithRetry(ctx, accessToken, payPalRequestID(ctx, "order", order.InvoiceID),
+		WithFundingSource(WithItems(NewOrderRequest(order.InvoiceID, order.Description, order.Amount, order.Currency),
+			order.Items, order.Discount), order.FundingSource), 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if orderResponse.ID == "" {
+		return nil, fmt.Errorf("PayPal order response has no id")
+	}
+	return &ProviderOrder{ID: orderResponse.ID, ApproveURL: orderResponse.ApproveURL()}, nil
+}
+
+// ResumeOrder syncs the order with PayPal. The existing order is kept even when that
+// fails, so a PayPal hiccup doesn't stop the family from paying.
+func (payPalProvider) ResumeOrder(ctx context.Context, formID, orderID string) (*ProviderOrder, error) {
+	if err := recoveryService.RecoverPayPalOrder(ctx, formID, orderID); err != nil {
+		logger.LogWarn("PayPal recovery failed for %s: %v", formID, err)
+	}
+	return &ProviderOrder{ID: orderID}, nil
+}
+
+// CaptureOrder captures the order PayPal holds for the amount it was created with; the
+// checkout handler only passes a form's own order
+func (payPalProvider) CaptureOrder(ctx context.Context, order OrderCapture) (string, error) {
+	accessToken, err := getPayPalAccessTokenWithRetry(ctx, 3)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	return capturePayPalOrderWithRetry(ctx, order.OrderID, accessToken, 3)
+}
+
+func (payPalProvider) CaptureID(details, formID string) string {
+	return data.ExtractPayPalCaptureID(details, formID)
+}
+
+func (payPalProvider) CapturedAmount(details string) (float64, bool) {
+	order, err := paypal.ParseOrder([]byte(details))
+	if err != nil {
+		return 0, false
+	}
+	capture := order.FirstCapture()
+	if capture == nil || capture.Amount == nil {
+		return 0, false
+	}
+	return capture.Amount.Amount(), true
+}
+
+func (payPalProvider) Refund(ctx context.Context, captureID string, amount float64, currencyCode, note string) (string, error) {
+	accessToken, err := getPayPalAccessTokenWithRetry(ctx, 3)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	refund, err := RefundPayPalCapture(accessToken, captureID, amount, currencyCode, note)
+	if err != nil {
+		return "", err
+	}
+	return refund.ID, nil
+}
@@ -0,0 +1,270 @@
+// internal/payment/quick_donate.go
+package payment
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/paypal"
+	"sbcbackend/internal/security"
+)
+
+// maxDonationNote is the longest note a donor can leave
+const maxDonationNote = 500
+
+// QuickDonateRequest starts a quick donation
+type QuickDonateRequest struct {
+	Amount    float64 `json:"amount"`
+	Email     string  `json:"email"`
+	Note      string  `json:"note,omitempty"`
+	CSRFToken string  `json:"csrfToken"`
+}
+
+// QuickDonateResponse is the PayPal order the donor approves
+type QuickDonateResponse struct {
+	DonationID string  `json:"donationID"`
+	OrderID    string  `json:"orderID"`
+	Amount     float64 `json:"amount"`
+	Currency   string  `json:"currency"`
+}
+
+// QuickDonateCaptureRequest collects an approved quick donation
+type QuickDonateCaptureRequest struct {
+	DonationID string `json:"donationID"`
+	OrderID    string `json:"orderID"`
+}
+
+// QuickDonateCaptureResponse reports a donation once it is paid
+type QuickDonateCaptureResponse struct {
+	DonationID    string `json:"donationID"`
+	Status        string `json:"status"`
+	ReceiptNumber string `json:"receiptNumber,omitempty"`
+}
+
+/*
+QuickDonateHandler starts a donation to the general fund without a form or students:
+just an amount, the donor's email and an optional note. It needs a CSRF token like the
+forms do, stores the donation and returns a PayPal order for the page's PayPal buttons
+to approve; QuickDonateCaptureHandler collects it. Quick donations always go through
+PayPal, whatever PAYMENT_PROVIDER is.
+*/
+func QuickDonateHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST requests are supported", "")
+		return
+	}
+	settings := config.LoadQuickDonationSettings()
+	if !settings.Enabled {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_available", "Quick donations are not available", "")
+		return
+	}
+
+	var req QuickDonateRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+		return
+	}
+	if req.CSRFToken == "" || !security.ValidateCSRFToken(req.CSRFToken) {
+		logger.LogHTTPError(r, http.StatusForbidden, fmt.Errorf("missing or invalid CSRF token"))
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "invalid_csrf_token", "Please reload the page and try again", "")
+		return
+	}
+
+	code := config.Currency()
+	donation := data.Donation{
+		Email:     data.NormalizeContact(req.Email),
+		Note:      strings.TrimSpace(req.Note),
+		Amount:    currency.Round(req.Amount, code),
+		Currency:  code,
+		CreatedAt: clock.Now(),
+	}
+	if address, err := mail.ParseAddress(donation.Email); err != nil || address.Address != donation.Email {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_email", "Please enter a valid email address", "")
+		return
+	}
+	if math.IsNaN(donation.Amount) || donation.Amount < settings.MinimumAmount || donation.Amount > settings.MaximumAmount {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_amount",
+			fmt.Sprintf("Please enter an amount from %s to %s", currency.Format(settings.MinimumAmount, code),
+				currency.Format(settings.MaximumAmount, code)), "")
+		return
+	}
+	if utf8.RuneCountInString(donation.Note) > maxDonationNote {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_note",
+			fmt.Sprintf("Please keep your note under %d characters", maxDonationNote), "")
+		return
+	}
+
+	var err error
+	if donation.FormID, err = newDonationID(); err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to start your donation", "")
+		return
+	}
+	if err := data.CreateDonation(donation); err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to start your donation", "")
+		return
+	}
+
+	order, err := payPalProvider{}.CreateOrder(r.Context(), CheckoutOrder{
+		FormID:      donation.FormID,
+		InvoiceID:   donation.FormID,
+		Description: "General fund donation",
+		Amount:      donation.Amount,
+		Currency:    code,
+		Items:       []inventory.LineItem{{Name: "General Fund Donation", Price: donation.Amount, Quantity: 1, Donation: true}},
+	})
+	if err != nil {
+		logger.LogError("Failed to create PayPal order for donation %s: %v", donation.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, "paypal_error", "Failed to reach PayPal; please try again", "")
+		return
+	}
+	if err := data.SetDonationOrder(donation.FormID, order.ID); err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to start your donation", "")
+		return
+	}
+
+	logger.LogInfo("Created PayPal order %s for donation %s of %s", order.ID, donation.FormID,
+		currency.Format(donation.Amount, code))
+	middleware.WriteAPISuccess(w, r, QuickDonateResponse{
+		DonationID: donation.FormID,
+		OrderID:    order.ID,
+		Amount:     donation.Amount,
+		Currency:   code,
+	})
+}
+
+/*
+QuickDonateCaptureHandler collects a quick donation the donor approved on PayPal,
+records it with a receipt number and queues the thank-you email. The donation and
+order IDs together identify it, and capturing only takes money the donor already
+approved, so it needs no other credential. Calling it again for a paid donation just
+reports it.
+*/
+func QuickDonateCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodPost {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Only POST requests are supported", "")
+		return
+	}
+
+	var req QuickDonateCaptureRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+		return
+	}
+	req.DonationID, req.OrderID = strings.TrimSpace(req.DonationID), strings.TrimSpace(req.OrderID)
+	if req.DonationID == "" || req.OrderID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_fields", "donationID and orderID are required", "")
+		return
+	}
+
+	donation, err := data.GetDonation(req.DonationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Donation not found", "")
+		return
+	}
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the donation", "")
+		return
+	}
+	if donation.PayPalOrderID == "" || donation.PayPalOrderID != req.OrderID {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "order_mismatch", "This order is not for this donation", "")
+		return
+	}
+
+	if donation.PayPalStatus != paypal.StatusCompleted {
+		details, err := captureDonation(r, donation)
+		if err != nil {
+			logger.LogError("Failed to capture donation %s (order %s): %v", donation.FormID, donation.PayPalOrderID, err)
+			middleware.WriteAPIError(w, r, http.StatusBadGateway, "capture_failed", "Payment capture failed", "")
+			return
+		}
+		if err := recordCapture("donation", donation.FormID, details, time.Now(), 0); err != nil {
+			logger.LogError("Failed to record capture of donation %s: %v", donation.FormID, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error",
+				"Your donation was received but could not be recorded; we'll follow up by email", "")
+			return
+		}
+		if recorded, err := data.GetDonation(donation.FormID); err == nil && recorded.PayPalStatus == paypal.StatusCompleted {
+			donation = recorded
+		} else {
+			// The record is queued for a retry; the receipt number comes with the thank-you
+			donation.PayPalStatus, donation.ReceiptNumber = paypal.StatusCompleted, ""
+		}
+	}
+
+	middleware.WriteAPISuccess(w, r, QuickDonateCaptureResponse{
+		DonationID:    donation.FormID,
+		Status:        donation.PayPalStatus,
+		ReceiptNumber: donation.ReceiptNumber,
+	})
+}
+
+// captureDonation captures a donation's PayPal order and returns what PayPal sent back.
+// An order PayPal already completed, as when the first capture's response was lost,
+// is returned as it stands.
+func captureDonation(r *http.Request, donation *data.Donation) (string, error) {
+	details, captureErr := payPalProvider{}.CaptureOrder(r.Context(), OrderCapture{
+		FormID: donation.FormID, OrderID: donation.PayPalOrderID, Amount: donation.Amount,
+	})
+	if captureErr == nil {
+		return details, nil
+	}
+
+	accessToken, err := getPayPalAccessTokenWithRetry(r.Context(), 3)
+	if err != nil {
+		return "", captureErr
+	}
+	order, err := recoveryService.getOrderDetailsWithRetry(r.Context(), donation.PayPalOrderID, accessToken)
+	if err != nil || order.Status != paypal.StatusCompleted || len(order.Raw) == 0 {
+		return "", captureErr
+	}
+	logger.LogInfo("PayPal order %s of donation %s was already captured", donation.PayPalOrderID, donation.FormID)
+	return string(order.Raw), nil
+}
+
+// RecordDonationCapture marks a quick donation paid and queues the donor's thank-you,
+// reporting whether it was still unpaid. The capture handler and PayPal's webhook
+// both record captures, whichever arrives first.
+func RecordDonationCapture(donationID, details string, actor data.Actor) (bool, error) {
+	recorded, err := data.RecordDonationCapture(donationID, details, time.Now(),
+		[]data.OutboxTask{{Kind: outbox.KindConfirmationEmail}}, actor)
+	if err == nil && recorded {
+		logger.LogInfo("Donation %s captured", donationID)
+	}
+	return recorded, err
+}
+
+// newDonationID makes a donation's ID in the form IDs' style, so it can stand in for
+// one as the PayPal invoice ID and outbox key
+func newDonationID() (string, error) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate donation ID: %w", err)
+	}
+	timestamp := clock.Now().In(clock.Location()).Format("2006-01-02_15-04-05")
+	return fmt.Sprintf("donation-%s-%s", timestamp, base64.RawURLEncoding.EncodeToString(random)), nil
+}
@@ -4,7 +4,9 @@ package payment
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -12,6 +14,8 @@ import (
 	"sbcbackend/internal/config"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/paypal"
 )
 
 // PayPalRecoveryService handles stuck/failed PayPal operations
@@ -43,8 +47,8 @@ func (s *PayPalRecoveryService) RecoverPayPalOrder(ctx context.Context, formID,
 		return fmt.Errorf("failed to get order details during recovery: %w", err)
 	}
 
-	status, ok := orderDetails["status"].(string)
-	if !ok {
+	status := orderDetails.Status
+	if status == "" {
 		return fmt.Errorf("invalid order status in PayPal response")
 	}
 
@@ -52,14 +56,14 @@ func (s *PayPalRecoveryService) RecoverPayPalOrder(ctx context.Context, formID,
 
 	// Handle different order states
 	switch status {
-	case "COMPLETED":
+	case paypal.StatusCompleted:
 		return s.syncCompletedOrder(formID, orderDetails)
-	case "APPROVED":
+	case paypal.StatusApproved:
 		return s.attemptCapture(ctx, formID, orderID, accessToken)
-	case "CREATED", "SAVED":
+	case paypal.StatusCreated, paypal.StatusSaved:
 		logger.LogInfo("Order %s is still pending customer approval", orderID)
 		return nil // Nothing to recover, customer hasn't approved yet
-	case "CANCELLED", "EXPIRED":
+	case paypal.StatusCancelled, paypal.StatusExpired:
 		return s.handleFailedOrder(formID, status)
 	default:
 		logger.LogWarn("Unknown PayPal order status for %s: %s", orderID, status)
@@ -67,7 +71,7 @@ func (s *PayPalRecoveryService) RecoverPayPalOrder(ctx context.Context, formID,
 	}
 }
 
-func (s *PayPalRecoveryService) getOrderDetailsWithRetry(ctx context.Context, orderID, accessToken string) (map[string]interface{}, error) {
+func (s *PayPalRecoveryService) getOrderDetailsWithRetry(ctx context.Context, orderID, accessToken string) (*paypal.Order, error) {
 	var lastErr error
 
 	for attempt := 1; attempt <= s.maxRetries; attempt++ {
@@ -75,6 +79,9 @@ func (s *PayPalRecoveryService) getOrderDetailsWithRetry(ctx context.Context, or
 		if err == nil {
 			return orderDetails, nil
 		}
+		if errors.Is(err, ErrOrderNotFound) {
+			return nil, err // asking again won't bring it back
+		}
 
 		lastErr = err
 		logger.LogWarn("PayPal order details attempt %d failed: %v", attempt, err)
@@ -92,35 +99,31 @@ func (s *PayPalRecoveryService) getOrderDetailsWithRetry(ctx context.Context, or
 	return nil, fmt.Errorf("failed to get order details after %d attempts: %w", s.maxRetries, lastErr)
 }
 
-func (s *PayPalRecoveryService) syncCompletedOrder(formID string, orderDetails map[string]interface{}) error {
+func (s *PayPalRecoveryService) syncCompletedOrder(formID string, orderDetails *paypal.Order) error {
 	logger.LogInfo("Syncing already completed PayPal order for formID=%s", formID)
 
-	// Convert order details to JSON for storage
-	detailsJSON, err := json.Marshal(orderDetails)
-	if err != nil {
-		return fmt.Errorf("failed to marshal order details: %w", err)
+	// Store the order as PayPal sent it
+	detailsJSON := []byte(orderDetails.Raw)
+	if len(detailsJSON) == 0 {
+		var err error
+		if detailsJSON, err = json.Marshal(orderDetails); err != nil {
+			return fmt.Errorf("failed to marshal order details: %w", err)
+		}
 	}
 
 	now := time.Now()
 	formType := getFormTypeFromID(formID)
 
-	// Update the appropriate form type
-	switch formType {
-	case "membership":
-		return data.UpdateMembershipPayPalCapture(formID, string(detailsJSON), "COMPLETED", &now)
-	case "fundraiser":
-		return data.UpdateFundraiserPayPalCapture(formID, string(detailsJSON), "COMPLETED", &now)
-	case "event":
-		return data.UpdateEventPayPalCapture(formID, string(detailsJSON), "COMPLETED", &now)
-	default:
-		return fmt.Errorf("unknown form type: %s", formType)
-	}
+	// Record the capture along with the side effects the success page may never trigger
+	tasks := outbox.CaptureTasks(formType, formID, now)
+	return data.RecordPayPalCapture(formType, formID, string(detailsJSON), "COMPLETED", &now, tasks, data.ActorSystem)
 }
 
 func (s *PayPalRecoveryService) attemptCapture(ctx context.Context, formID, orderID, accessToken string) error {
 	logger.LogInfo("Attempting to capture approved PayPal order %s for formID=%s", orderID, formID)
 
 	captureURL := fmt.Sprintf("%s/v2/checkout/orders/%s/capture", config.APIBase(), orderID)
+	requestID := payPalRequestID(ctx, "capture", orderID)
 
 	for attempt := 1; attempt <= s.maxRetries; attempt++ {
 		req, err := http.NewRequestWithContext(ctx, "POST", captureURL, strings.NewReader("{}"))
@@ -130,8 +133,9 @@ func (s *PayPalRecoveryService) attemptCapture(ctx context.Context, formID, orde
 
 		req.Header.Set("Authorization", accessToken)
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("PayPal-Request-Id", requestID)
 
-		client := &http.Client{Timeout: time.Second * 30}
+		client := NewPayPalClient(30 * time.Second)
 		resp, err := client.Do(req)
 		if err != nil {
 			logger.LogWarn("PayPal capture attempt %d failed: %v", attempt, err)
@@ -145,12 +149,13 @@ func (s *PayPalRecoveryService) attemptCapture(ctx context.Context, formID, orde
 
 		if resp.StatusCode == http.StatusCreated {
 			logger.LogInfo("Successfully captured PayPal order %s on attempt %d", orderID, attempt)
-			return s.syncCompletedOrder(formID, map[string]interface{}{
-				"id":               orderID,
-				"status":           "COMPLETED",
-				"recovered":        true,
-				"recovery_attempt": attempt,
-			})
+			body, _ := io.ReadAll(resp.Body)
+			captured, err := paypal.ParseOrder(body)
+			if err != nil {
+				// The capture went through; keep what we know so the form shows as paid
+				captured = &paypal.Order{ID: orderID, Status: paypal.StatusCompleted}
+			}
+			return s.syncCompletedOrder(formID, captured)
 		}
 
 		logger.LogWarn("PayPal capture attempt %d returned status %d", attempt, resp.StatusCode)
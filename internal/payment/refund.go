@@ -0,0 +1,133 @@
+// internal/payment/refund.go
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// RefundOrderRequest is the body of a refund: the whole remaining amount unless an
+// amount is given. The reason is kept for the club's records; the note is shown to
+// the payer.
+type RefundOrderRequest struct {
+	FormID string  `json:"formID"`
+	Amount float64 `json:"amount,omitempty"`
+	Reason string  `json:"reason,omitempty"`
+	Note   string  `json:"note,omitempty"`
+}
+
+// RefundOrderResponse reports a refund and where the order stands after it
+type RefundOrderResponse struct {
+	FormID        string  `json:"formID"`
+	RefundID      string  `json:"refundID"`
+	Amount        float64 `json:"amount"`
+	RefundedTotal float64 `json:"refundedTotal"`
+	NetAmount     float64 `json:"netAmount"`
+	Status        string  `json:"status"`
+}
+
+// RefundOrderHandler refunds part or all of a captured order for an admin and records
+// the refund on the submission, so the database doesn't keep showing it COMPLETED
+func RefundOrderHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RefundOrderRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+		return
+	}
+	if req.FormID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_form_id", "Missing formID", "")
+		return
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Order not found", "")
+		return
+	}
+	if summary.PayPalStatus != "COMPLETED" {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "not_refundable",
+			"Only completed payments can be refunded", fmt.Sprintf("status is %q", summary.PayPalStatus))
+		return
+	}
+
+	details, err := data.GetPaymentDetails(summary.FormType, req.FormID)
+	if err != nil {
+		logger.LogError("Failed to load payment details for refund of %s: %v", req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the payment", "")
+		return
+	}
+	remaining := math.Round(summary.NetAmount*100) / 100
+	amount := math.Round(req.Amount*100) / 100
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_amount",
+			fmt.Sprintf("Refund must be between $0.01 and the $%.2f not yet refunded", remaining), "")
+		return
+	}
+
+	provider := submissionProvider(summary.FormType, req.FormID)
+	captureID := provider.CaptureID(details, req.FormID)
+	if captureID == "" {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "no_capture",
+			fmt.Sprintf("No %s payment found to refund; was it paid another way?", provider.Name()), "")
+		return
+	}
+
+	note := req.Note
+	if note == "" {
+		note = fmt.Sprintf("Refund for %s", summary.Item)
+	}
+	refundID, err := provider.Refund(r.Context(), captureID, amount, checkoutCurrency(summary.FormType, req.FormID), note)
+	if errors.Is(err, ErrProviderUnavailable) {
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, provider.Name()+"_error",
+			"Payment service unavailable", err.Error())
+		return
+	}
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadGateway, "refund_failed", "Refund failed", err.Error())
+		return
+	}
+
+	status, refunded, err := data.RecordRefund(summary.FormType, req.FormID, data.Refund{
+		ID:         refundID,
+		Amount:     amount,
+		Reason:     strings.TrimSpace(req.Reason),
+		Note:       note,
+		RefundedAt: time.Now(),
+	})
+	if err != nil {
+		// The money has moved; the refund webhook will still update the status
+		logger.LogError("Refund %s of %s succeeded but recording it failed: %v", refundID, req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "record_failed",
+			"Refund succeeded but could not be recorded", refundID)
+		return
+	}
+
+	netAmount := math.Round((summary.CalculatedAmount-refunded)*100) / 100
+	logger.LogInfo("Refunded $%.2f of %s (%s) for %q, $%.2f refunded in all, status %s",
+		amount, req.FormID, refundID, req.Reason, refunded, status)
+	middleware.WriteAPISuccess(w, r, RefundOrderResponse{
+		FormID:        req.FormID,
+		RefundID:      refundID,
+		Amount:        amount,
+		RefundedTotal: refunded,
+		NetAmount:     netAmount,
+		Status:        status,
+	})
+}
@@ -0,0 +1,151 @@
+// internal/payment/reporting.go
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/logger"
+)
+
+const (
+	// The transaction search API refuses ranges longer than 31 days
+	maxTransactionSearchRange = 31 * 24 * time.Hour
+	transactionSearchPageSize = 500
+	transactionSearchTime     = "2006-01-02T15:04:05-0700"
+)
+
+// PayPalTransaction is one entry from PayPal's transaction report. Payments have a
+// positive amount; refunds and reversals are separate entries with a negative amount.
+type PayPalTransaction struct {
+	ID          string
+	EventCode   string // T0006 checkout payment, T1107 refund, ...
+	Status      string // S success, P pending, V reversed, D denied
+	InvoiceID   string // the form ID, set by NewOrderRequest
+	ReferenceID string // for refunds, the payment they refund
+	Amount      float64
+	Fee         float64
+	Date        time.Time
+}
+
+type transactionSearchResponse struct {
+	TransactionDetails []struct {
+		TransactionInfo struct {
+			TransactionID     string `json:"transaction_id"`
+			EventCode         string `json:"transaction_event_code"`
+			Status            string `json:"transaction_status"`
+			InvoiceID         string `json:"invoice_id"`
+			PayPalReferenceID string `json:"paypal_reference_id"`
+			InitiationDate    string `json:"transaction_initiation_date"`
+			Amount            struct {
+				Value string `json:"value"`
+			} `json:"transaction_amount"`
+			Fee struct {
+				Value string `json:"value"`
+			} `json:"fee_amount"`
+		} `json:"transaction_info"`
+	} `json:"transaction_details"`
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+}
+
+// ListPayPalTransactions returns every transaction PayPal reports between from and to,
+// splitting the range into windows the API accepts and following its pages. PayPal
+// takes up to three hours to add new transactions to the report.
+func ListPayPalTransactions(ctx context.Context, accessToken string, from, to time.Time) ([]PayPalTransaction, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("transaction search end %v is not after start %v", to, from)
+	}
+
+	var transactions []PayPalTransaction
+	client := NewPayPalClient(30 * time.Second)
+	for start := from; start.Before(to); start = start.Add(maxTransactionSearchRange) {
+		end := start.Add(maxTransactionSearchRange)
+		if end.After(to) {
+			end = to
+		}
+		for page, totalPages := 1, 1; page <= totalPages; page++ {
+			result, err := searchTransactions(ctx, client, accessToken, start, end, page)
+			if err != nil {
+				return nil, err
+			}
+			for _, detail := range result.TransactionDetails {
+				info := detail.TransactionInfo
+				transaction := PayPalTransaction{
+					ID:          info.TransactionID,
+					EventCode:   info.EventCode,
+					Status:      info.Status,
+					InvoiceID:   info.InvoiceID,
+					ReferenceID: info.PayPalReferenceID,
+				}
+				if transaction.Amount, err = parseReportAmount(info.Amount.Value); err != nil {
+					return nil, fmt.Errorf("transaction %s: %w", info.TransactionID, err)
+				}
+				if transaction.Fee, err = parseReportAmount(info.Fee.Value); err != nil {
+					return nil, fmt.Errorf("transaction %s: %w", info.TransactionID, err)
+				}
+				if transaction.Date, err = time.Parse(transactionSearchTime, info.InitiationDate); err != nil {
+					transaction.Date, _ = time.Parse(time.RFC3339, info.InitiationDate)
+				}
+				transactions = append(transactions, transaction)
+			}
+			totalPages = result.TotalPages
+		}
+	}
+
+	logger.LogInfo("Fetched %d PayPal transactions between %s and %s",
+		len(transactions), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	return transactions, nil
+}
+
+func searchTransactions(ctx context.Context, client *http.Client, accessToken string, start, end time.Time, page int) (*transactionSearchResponse, error) {
+	query := url.Values{}
+	query.Set("start_date", start.Format(transactionSearchTime))
+	query.Set("end_date", end.Format(transactionSearchTime))
+	query.Set("fields", "transaction_info")
+	query.Set("page_size", strconv.Itoa(transactionSearchPageSize))
+	query.Set("page", strconv.Itoa(page))
+
+	reportURL := fmt.Sprintf("%s/v1/reporting/transactions?%s", config.APIBase(), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reportURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transaction search request: %w", err)
+	}
+	req.Header.Set("Authorization", accessToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute transaction search request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		logger.LogError("PayPal transaction search failed (HTTP %d): %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("transaction search failed: HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result transactionSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction search response: %w", err)
+	}
+	return &result, nil
+}
+
+func parseReportAmount(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", value, err)
+	}
+	return amount, nil
+}
@@ -0,0 +1,117 @@
+// internal/payment/stale_orders.go
+package payment
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
+	"sbcbackend/internal/scheduler"
+)
+
+// maxStaleOrdersPerRun caps the PayPal lookups one watchdog run makes per form type
+const maxStaleOrdersPerRun = 50
+
+// StaleOrderResult counts what the watchdog did with the orders it checked
+type StaleOrderResult struct {
+	Checked   int
+	Recovered int // paid or approved on PayPal without the family coming back
+	Expired   int // freed for a new checkout
+}
+
+// CheckStaleOrders asks PayPal about every order created more than age ago that never
+// got a status. Orders paid or approved behind our back are recorded like any capture.
+// Orders PayPal cancelled, voided, expired or no longer knows, and ones the family
+// never approved, are forgotten so the next checkout creates a fresh order; an
+// unapproved order can't be voided through the API and lapses on PayPal's side.
+func CheckStaleOrders(ctx context.Context, age time.Duration) (StaleOrderResult, error) {
+	var result StaleOrderResult
+	stale, err := data.ListStaleOrders(clock.Now().Add(-age), maxStaleOrdersPerRun)
+	if err != nil {
+		return result, err
+	}
+	if len(stale) == 0 {
+		return result, nil
+	}
+
+	accessToken, err := GetPayPalAccessToken(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, order := range stale {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		// Stripe sessions expire on their own and checkout replaces them
+		if provider := submissionProvider(order.FormType, order.FormID); provider.Name() != config.PaymentProviderPayPal {
+			continue
+		}
+		// An unapproved subscription sits in the order column too and lapses on its own
+		if order.FormType == "membership" {
+			if subscriptionID, err := data.MembershipSubscriptionID(order.FormID); err == nil && subscriptionID == order.OrderID {
+				continue
+			}
+		}
+		result.Checked++
+
+		status := ""
+		details, err := GetPayPalOrderDetails(order.OrderID, accessToken)
+		switch {
+		case errors.Is(err, ErrOrderNotFound):
+			status = paypal.StatusExpired
+		case err != nil:
+			logger.LogWarn("Failed to check stale PayPal order %s for %s: %v", order.OrderID, order.FormID, err)
+			continue
+		default:
+			status = details.Status
+		}
+
+		switch status {
+		case paypal.StatusCompleted:
+			err = recoveryService.syncCompletedOrder(order.FormID, details)
+		case paypal.StatusApproved:
+			err = recoveryService.attemptCapture(ctx, order.FormID, order.OrderID, accessToken)
+		case paypal.StatusCreated, paypal.StatusSaved, paypal.StatusPayerActionRequired,
+			paypal.StatusCancelled, paypal.StatusVoided, paypal.StatusExpired:
+			cleared, err := data.ReleaseOrder(order.FormType, order.FormID, order.OrderID, data.ActorSystem)
+			if err != nil {
+				logger.LogError("Failed to free %s from stale order %s: %v", order.FormID, order.OrderID, err)
+			} else if cleared {
+				logger.LogInfo("Freed %s from %s PayPal order %s created %s ago", order.FormID, status,
+					order.OrderID, clock.Now().Sub(order.CreatedAt).Round(time.Minute))
+				result.Expired++
+			}
+			continue
+		default:
+			logger.LogWarn("Stale PayPal order %s for %s has unknown status %s", order.OrderID, order.FormID, status)
+			continue
+		}
+
+		if err != nil {
+			logger.LogError("Stale PayPal order %s for %s is %s but recording it failed: %v",
+				order.OrderID, order.FormID, status, err)
+			continue
+		}
+		logger.LogInfo("Recovered %s PayPal order %s for %s", status, order.OrderID, order.FormID)
+		result.Recovered++
+	}
+	return result, nil
+}
+
+// NewStaleOrderJob returns the scheduler job that checks orders left unpaid for longer
+// than age
+func NewStaleOrderJob(age time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		result, err := CheckStaleOrders(ctx, age)
+		if result.Checked > 0 {
+			scheduler.Report(ctx, "checked %d stale orders: %d recovered, %d freed",
+				result.Checked, result.Recovered, result.Expired)
+		}
+		return err
+	}
+}
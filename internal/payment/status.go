@@ -0,0 +1,98 @@
+// internal/payment/status.go
+package payment
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/paypal"
+)
+
+// PaymentStatusRequest names the form to report on
+type PaymentStatusRequest struct {
+	FormID string `json:"formID"`
+}
+
+// PaymentStatusResponse is where a form's payment stands. Status is the stored PayPal
+// status, empty until the order is captured, or PENDING while a bank transfer settles.
+type PaymentStatusResponse struct {
+	FormID        string     `json:"formID"`
+	FormType      string     `json:"formType"`
+	Status        string     `json:"status"`
+	Paid          bool       `json:"paid"`
+	Amount        float64    `json:"amount"`
+	Currency      string     `json:"currency"`
+	OrderID       string     `json:"orderID,omitempty"`
+	CapturedAt    *time.Time `json:"capturedAt,omitempty"`
+	ReceiptNumber string     `json:"receiptNumber,omitempty"`
+	Instrument    string     `json:"instrument,omitempty"` // the card that paid, such as "VISA ending 4242"
+}
+
+// capturedStatuses are the statuses of forms whose payment was captured, including
+// ones refunded or disputed since
+var capturedStatuses = map[string]bool{
+	paypal.StatusCompleted: true,
+	"REFUNDED":             true,
+	"REVERSED":             true,
+	data.DisputedStatus:    true,
+}
+
+// PaymentStatusHandler lets the checkout page ask whether a form is paid yet, as after
+// the return from PayPal went wrong, for any form type. It reports what is stored and
+// doesn't call PayPal, so the page can poll it; captures that never reached us are
+// picked up by the webhook and the stale order watchdog. The form ID comes from the
+// query string or, for POST, the JSON body.
+func PaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var req PaymentStatusRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.FormID = r.URL.Query().Get("formID")
+	case http.MethodPost:
+		if err := middleware.ParseJSONRequest(r, &req); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request",
+				"Invalid JSON request", err.Error())
+			return
+		}
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+		return
+	}
+	req.FormID = strings.TrimSpace(req.FormID)
+	if req.FormID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_form_id", "FormID is required", "")
+		return
+	}
+	if err := middleware.ValidateFormIDAccess(r.Context(), req.FormID, middleware.GetToken(r.Context())); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "access_denied",
+			"Access denied to this form", "")
+		return
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		logger.LogWarn("Failed to load payment status of %s: %v", req.FormID, err)
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "form_not_found", "Form not found", "")
+		return
+	}
+
+	response := PaymentStatusResponse{
+		FormID:        summary.FormID,
+		FormType:      summary.FormType,
+		Status:        summary.PayPalStatus,
+		Paid:          summary.PayPalStatus == paypal.StatusCompleted,
+		Amount:        summary.CalculatedAmount,
+		Currency:      checkoutCurrency(summary.FormType, summary.FormID),
+		OrderID:       summary.PayPalOrderID,
+		ReceiptNumber: summary.ReceiptNumber,
+		Instrument:    summary.Instrument,
+	}
+	if capturedStatuses[summary.PayPalStatus] {
+		response.CapturedAt = summary.SubmittedAt
+	}
+	middleware.WriteAPISuccess(w, r, response)
+}
@@ -0,0 +1,305 @@
+// internal/payment/stripe.go
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// StripeProvider takes payments through Stripe Checkout. Creating an order starts a
+// Checkout Session; the family pays on Stripe's page and is sent back to the checkout
+// page with the session ID, which it captures like a PayPal order. Stripe collects the
+// payment itself, so capturing only confirms the session was paid.
+type StripeProvider struct {
+	settings config.StripeSettings
+	client   *http.Client
+}
+
+// NewStripeProvider returns a provider for the Stripe account in settings
+func NewStripeProvider(settings config.StripeSettings) *StripeProvider {
+	return &StripeProvider{
+		settings: settings,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// stripeSession is the part of a Checkout Session checkout reads
+type stripeSession struct {
+	ID                string `json:"id"`
+	ClientReferenceID string `json:"client_reference_id"` // the form ID
+	URL               string `json:"url"`
+	Status            string `json:"status"`         // open, complete or expired
+	PaymentStatus     string `json:"payment_status"` // unpaid, paid or no_payment_required
+	PaymentIntent     string `json:"payment_intent"`
+	AmountTotal       int64  `json:"amount_total"` // in the currency's smallest unit
+	Currency          string `json:"currency"`
+}
+
+// stripeError is the body of an error response from the Stripe API
+type stripeError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *StripeProvider) Name() string { return config.PaymentProviderStripe }
+
+func (p *StripeProvider) CreateOrder(ctx context.Context, order CheckoutOrder) (*ProviderOrder, error) {
+	return p.createSession(ctx, order, nil)
+}
+
+// createSession starts a Checkout Session for order, with extra setting anything the
+// default session doesn't, such as its payment methods
+func (p *StripeProvider) createSession(ctx context.Context, order CheckoutOrder, extra url.Values) (*ProviderOrder, error) {
+	returnURL := stripeReturnURL(order.ReturnPath)
+	separator := "?"
+	if strings.Contains(returnURL, "?") {
+		separator = "&"
+	}
+
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", order.FormID)
+	form.Set("success_url", returnURL+separator+"session_id={CHECKOUT_SESSION_ID}")
+	form.Set("cancel_url", returnURL)
+	form.Set("line_items[0][quantity]", "1")
+	code := p.settings.Currency
+	if order.Currency != "" {
+		code = strings.ToLower(order.Currency)
+	}
+	form.Set("line_items[0][price_data][currency]", code)
+	form.Set("line_items[0][price_data][unit_amount]", stripeAmount(order.Amount, code))
+	form.Set("line_items[0][price_data][product_data][name]", order.Description)
+	form.Set("metadata[form_id]", order.FormID)
+	form.Set("payment_intent_data[metadata][form_id]", order.FormID)
+	form.Set("payment_intent_data[metadata][invoice_id]", order.InvoiceID)
+	for key, values := range extra {
+		form[key] = values
+	}
+
+	logger.LogInfo("Creating Stripe checkout session for %s", order.InvoiceID)
+	body, err := p.call(ctx, http.MethodPost, "/v1/checkout/sessions", form)
+	if err != nil {
+		return nil, err
+	}
+	var session stripeSession
+	if err := json.Unmarshal(body, &session); err != nil {
+		return nil, fmt.Errorf("parsing Stripe checkout session: %w", err)
+	}
+	if session.ID == "" || session.URL == "" {
+		return nil, fmt.Errorf("Stripe checkout session response has no id or url")
+	}
+	return &ProviderOrder{ID: session.ID, ApproveURL: session.URL}, nil
+}
+
+// ResumeOrder records a session that was paid without the family coming back to the
+// checkout page, through the same version-checked path as a capture. An expired
+// session can't be paid, and an open one priced before the form's total changed
+// shouldn't be, so either needs a new one.
+func (p *StripeProvider) ResumeOrder(ctx context.Context, formID, orderID string) (*ProviderOrder, error) {
+	summary, err := data.GetSubmissionSummary(formID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := data.GetSubmissionVersion(formID)
+	if err != nil {
+		return nil, err
+	}
+	session, body, err := p.session(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	logger.LogInfo("Stripe checkout session %s for %s is %s/%s", orderID, formID, session.Status, session.PaymentStatus)
+	if err := checkSessionForm(session, formID); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case session.PaymentStatus == "paid":
+		if err := checkSessionAmount(session, summary.CalculatedAmount); err != nil {
+			return nil, err
+		}
+		if err := recordCapture(getFormTypeFromID(formID), formID, string(body), clock.Now(), version); err != nil {
+			return nil, fmt.Errorf("recording paid Stripe session: %w", err)
+		}
+		return &ProviderOrder{ID: session.ID}, nil
+	case session.Status == "open":
+		if err := checkSessionAmount(session, summary.CalculatedAmount); err != nil {
+			logger.LogInfo("%v; a new session is needed", err)
+			return nil, nil
+		}
+		return &ProviderOrder{ID: session.ID, ApproveURL: session.URL}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// CaptureOrder confirms the session was paid, for order's form and amount
+func (p *StripeProvider) CaptureOrder(ctx context.Context, order OrderCapture) (string, error) {
+	session, body, err := p.session(ctx, order.OrderID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkSession(session, order); err != nil {
+		return "", err
+	}
+	if session.PaymentStatus != "paid" {
+		return "", fmt.Errorf("Stripe checkout session %s is %s and %s", order.OrderID, session.Status, session.PaymentStatus)
+	}
+	logger.LogInfo("Stripe checkout session %s was paid by payment %s", order.OrderID, session.PaymentIntent)
+	return string(body), nil
+}
+
+// checkSession makes sure a session was made for order's form and amount. Sessions are
+// looked up by ID alone, so without it a session paid for one form could be passed off
+// as paying for another.
+func checkSession(session *stripeSession, order OrderCapture) error {
+	if err := checkSessionForm(session, order.FormID); err != nil {
+		return err
+	}
+	return checkSessionAmount(session, order.Amount)
+}
+
+func checkSessionForm(session *stripeSession, formID string) error {
+	if session.ClientReferenceID != formID {
+		return fmt.Errorf("%w: Stripe checkout session %s is for %q, not %s",
+			ErrOrderMismatch, session.ID, session.ClientReferenceID, formID)
+	}
+	return nil
+}
+
+func checkSessionAmount(session *stripeSession, amount float64) error {
+	if want := stripeAmount(amount, session.Currency); strconv.FormatInt(session.AmountTotal, 10) != want {
+		return fmt.Errorf("%w: Stripe checkout session %s is for %d %s, not %s",
+			ErrOrderMismatch, session.ID, session.AmountTotal, session.Currency, want)
+	}
+	return nil
+}
+
+// CaptureID is the session's payment intent, which refunds are made against
+func (p *StripeProvider) CaptureID(details, formID string) string {
+	var session stripeSession
+	if err := json.Unmarshal([]byte(details), &session); err != nil {
+		logger.LogWarn("Failed to parse Stripe payment details for %s: %v", formID, err)
+		return ""
+	}
+	return session.PaymentIntent
+}
+
+// CapturedAmount is the session's total, which Stripe gives in the smallest unit of
+// its currency
+func (p *StripeProvider) CapturedAmount(details string) (float64, bool) {
+	var session stripeSession
+	if err := json.Unmarshal([]byte(details), &session); err != nil || session.Currency == "" {
+		return 0, false
+	}
+	scale := math.Pow(10, float64(currency.Decimals(session.Currency)))
+	return float64(session.AmountTotal) / scale, true
+}
+
+func (p *StripeProvider) Refund(ctx context.Context, captureID string, amount float64, currencyCode, note string) (string, error) {
+	form := url.Values{}
+	form.Set("payment_intent", captureID)
+	form.Set("amount", stripeAmount(amount, currencyCode))
+	form.Set("metadata[note]", note)
+
+	logger.LogInfo("Refunding $%.2f on Stripe payment %s", amount, captureID)
+	body, err := p.call(ctx, http.MethodPost, "/v1/refunds", form)
+	if err != nil {
+		return "", err
+	}
+	var refund struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &refund); err != nil {
+		return "", fmt.Errorf("parsing Stripe refund: %w", err)
+	}
+	return refund.ID, nil
+}
+
+// stripeAmount is amount in the smallest unit of currencyCode, which Stripe takes
+// amounts in: cents for dollars, whole yen for yen
+func stripeAmount(amount float64, currencyCode string) string {
+	scale := math.Pow(10, float64(currency.Decimals(currencyCode)))
+	return fmt.Sprintf("%d", int64(math.Round(amount*scale)))
+}
+
+func (p *StripeProvider) session(ctx context.Context, sessionID string) (*stripeSession, []byte, error) {
+	body, err := p.call(ctx, http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(sessionID), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var session stripeSession
+	if err := json.Unmarshal(body, &session); err != nil {
+		return nil, nil, fmt.Errorf("parsing Stripe checkout session: %w", err)
+	}
+	return &session, body, nil
+}
+
+// call makes a form-encoded request to the Stripe API and returns the response body
+func (p *StripeProvider) call(ctx context.Context, method, path string, form url.Values) ([]byte, error) {
+	if p.settings.SecretKey == "" {
+		return nil, fmt.Errorf("%w: STRIPE_SECRET_KEY is not set", ErrProviderUnavailable)
+	}
+
+	var reader io.Reader
+	if form != nil {
+		reader = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.settings.APIBase+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("creating Stripe request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+p.settings.SecretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: executing Stripe request: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading Stripe response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr stripeError
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+			err = fmt.Errorf("Stripe API returned status %d: %s", resp.StatusCode, apiErr.Error.Message)
+		} else {
+			err = fmt.Errorf("Stripe API returned status %d: %s", resp.StatusCode, string(body))
+		}
+		logger.LogError("%v", err)
+		return nil, err
+	}
+	return body, nil
+}
+
+// stripeReturnURL is the page on the public site a family returns to from Stripe
+func stripeReturnURL(path string) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://suzuki.nfshost.com"
+	}
+	return strings.TrimRight(baseURL, "/") + path
+}
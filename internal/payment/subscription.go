@@ -0,0 +1,215 @@
+// internal/payment/subscription.go
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// Subscription states PayPal reports that mean the member approved it and is paying
+const (
+	subscriptionApproved = "APPROVED"
+	subscriptionActive   = "ACTIVE"
+)
+
+// PayPalSubscription is the part of a PayPal billing subscription checkout uses
+type PayPalSubscription struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	CustomID string `json:"custom_id"`
+	Links    []struct {
+		Href string `json:"href"`
+		Rel  string `json:"rel"`
+	} `json:"links"`
+}
+
+// ApproveURL is where the member approves the subscription on PayPal's site
+func (s *PayPalSubscription) ApproveURL() string {
+	for _, link := range s.Links {
+		if link.Rel == "approve" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// NewSubscriptionRequest builds the create-subscription body for a membership. The
+// plan is billed yearly; its price is overridden with the member's total so one plan
+// covers every membership level, so the plan must be priced in currencyCode. The form
+// ID travels as custom_id so webhooks can be matched back to the submission.
+func NewSubscriptionRequest(planID, formID string, amount float64, currencyCode string) map[string]interface{} {
+	return map[string]interface{}{
+		"plan_id":   planID,
+		"custom_id": formID,
+		"plan": map[string]interface{}{
+			"billing_cycles": []map[string]interface{}{
+				{
+					"sequence":     1,
+					"total_cycles": 0, // renew until cancelled
+					"pricing_scheme": map[string]interface{}{
+						"fixed_price": map[string]interface{}{
+							"currency_code": currency.Normalize(currencyCode),
+							"value":         currency.Value(amount, currencyCode),
+						},
+					},
+				},
+			},
+		},
+		"application_context": map[string]interface{}{
+			"shipping_preference": "NO_SHIPPING",
+			"user_action":         "SUBSCRIBE_NOW",
+		},
+	}
+}
+
+// CreatePayPalSubscription creates a subscription awaiting the member's approval
+func CreatePayPalSubscription(ctx context.Context, accessToken string, subscriptionData map[string]interface{}) (*PayPalSubscription, error) {
+	bodyBytes, err := json.Marshal(subscriptionData)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling subscription: %w", err)
+	}
+
+	logger.LogInfo("Creating PayPal subscription")
+	body, err := callSubscriptionsAPI(ctx, accessToken, http.MethodPost, "", strings.NewReader(string(bodyBytes)))
+	if err != nil {
+		return nil, err
+	}
+	var subscription PayPalSubscription
+	if err := json.Unmarshal(body, &subscription); err != nil {
+		return nil, fmt.Errorf("decoding PayPal subscription: %w", err)
+	}
+	logger.LogInfo("Successfully created PayPal subscription %s", subscription.ID)
+	return &subscription, nil
+}
+
+// GetPayPalSubscription returns a subscription and PayPal's full response, which is
+// stored as the membership's payment details
+func GetPayPalSubscription(ctx context.Context, accessToken, subscriptionID string) (*PayPalSubscription, []byte, error) {
+	body, err := callSubscriptionsAPI(ctx, accessToken, http.MethodGet, "/"+url.PathEscape(subscriptionID), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var subscription PayPalSubscription
+	if err := json.Unmarshal(body, &subscription); err != nil {
+		return nil, nil, fmt.Errorf("decoding PayPal subscription: %w", err)
+	}
+	return &subscription, body, nil
+}
+
+func callSubscriptionsAPI(ctx context.Context, accessToken, method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, config.APIBase()+"/v1/billing/subscriptions"+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("building PayPal subscription request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", accessToken)
+
+	resp, err := NewPayPalClient(30 * time.Second).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading PayPal response: %v", ErrProviderUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		logger.LogError("PayPal subscription API error (HTTP %d): %s", resp.StatusCode, respBody)
+		return nil, fmt.Errorf("PayPal subscription request failed (HTTP %d): %s", resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
+
+// autoRenewOffered reports whether memberships may be paid by a yearly subscription,
+// which needs PayPal and a billing plan
+func autoRenewOffered() bool {
+	return Provider().Name() == config.PaymentProviderPayPal && config.MembershipPlanID() != ""
+}
+
+// createMembershipSubscription starts an auto-renewing membership: a PayPal subscription
+// the member approves in place of a one-time order
+func createMembershipSubscription(w http.ResponseWriter, r *http.Request, req CreateOrderRequest, description string, amount float64) {
+	if amount <= 0 {
+		logger.LogError("Attempt to create subscription with zero/negative amount for formID %s (%.2f)", req.FormID, amount)
+		http.Error(w, "Invalid order amount. Cannot create payment order.", http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := getPayPalAccessTokenWithRetry(r.Context(), 3)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "paypal_error",
+			"Payment service unavailable", err.Error())
+		return
+	}
+
+	logger.LogInfo("Creating PayPal subscription for %s (%s): %.2f a year", req.FormID, description, amount)
+	subscription, err := CreatePayPalSubscription(r.Context(), accessToken,
+		NewSubscriptionRequest(config.MembershipPlanID(), req.FormID, amount, checkoutCurrency("membership", req.FormID)))
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "order_creation_failed",
+			"Failed to create payment order", err.Error())
+		return
+	}
+
+	if err := data.StartMembershipSubscription(req.FormID, subscription.ID, time.Now()); err != nil {
+		logger.LogError("Failed to record PayPal subscription for %s: %v", req.FormID, err)
+	}
+	if err := data.SetFundingSource("membership", req.FormID, req.FundingSource); err != nil {
+		logger.LogError("Failed to record funding source for %s: %v", req.FormID, err)
+	}
+
+	middleware.WriteAPISuccess(w, r, CreateOrderResponse{
+		OrderID:      subscription.ID,
+		FormID:       req.FormID,
+		Provider:     config.PaymentProviderPayPal,
+		ApproveURL:   subscription.ApproveURL(),
+		Subscription: true,
+	})
+}
+
+// activateMembershipSubscription completes a membership once the member has approved
+// its subscription. PayPal collects each year's payment itself, reporting it by webhook,
+// so there is nothing to capture.
+func activateMembershipSubscription(w http.ResponseWriter, r *http.Request, formID, subscriptionID string) {
+	accessToken, err := getPayPalAccessTokenWithRetry(r.Context(), 3)
+	if err != nil {
+		logger.LogError("PayPal subscription activation failed for %s: %v", formID, err)
+		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
+		return
+	}
+	subscription, details, err := GetPayPalSubscription(r.Context(), accessToken, subscriptionID)
+	if err != nil {
+		logger.LogError("PayPal subscription activation failed for %s: %v", formID, err)
+		http.Error(w, "Payment capture failed", http.StatusInternalServerError)
+		return
+	}
+	if subscription.Status != subscriptionActive && subscription.Status != subscriptionApproved {
+		logger.LogWarn("PayPal subscription %s for %s is %s, not approved", subscriptionID, formID, subscription.Status)
+		http.Error(w, "Subscription not approved", http.StatusConflict)
+		return
+	}
+
+	if err := recordCapture("membership", formID, string(details), time.Now(), 0); err != nil {
+		logger.LogError("Failed to record membership subscription for %s: %v", formID, err)
+	}
+	if _, err := data.UpdateSubscriptionStatus(subscriptionID, subscription.Status); err != nil {
+		logger.LogError("Failed to record subscription status for %s: %v", formID, err)
+	}
+	logger.LogInfo("PayPal subscription %s for %s is %s", subscriptionID, formID, subscription.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(details)
+}
@@ -0,0 +1,189 @@
+// internal/payment/unmatched.go
+package payment
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/outbox"
+)
+
+// LinkUnmatchedPaymentRequest links an unmatched capture to the submission it paid
+// for. A capture for a different amount than the submission is refused unless Force
+// is set, for when the family paid a price that has since changed.
+type LinkUnmatchedPaymentRequest struct {
+	ID     int64  `json:"id"`
+	FormID string `json:"formID"`
+	Force  bool   `json:"force,omitempty"`
+}
+
+// unmatchedPageSize is how many unmatched payments a list request returns by default
+const unmatchedPageSize = 100
+
+// UnmatchedPaymentsHandler lets an admin work through PayPal captures that matched no
+// submission. GET lists the payments not yet linked (?all=true includes linked ones),
+// newest first and a page at a time (limit, offset, and from/to days received), or
+// shows one with its PayPal details (?id=<id>); POST links one to a form, recording it
+// as that form's payment with the usual receipt and emails.
+func UnmatchedPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		if idParam := r.URL.Query().Get("id"); idParam != "" {
+			id, err := strconv.ParseInt(idParam, 10, 64)
+			if err != nil {
+				middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_id", "The id parameter must be a number", "")
+				return
+			}
+			unmatched, err := data.GetUnmatchedPayment(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Unmatched payment not found", "")
+				return
+			}
+			if err != nil {
+				logger.LogError("Failed to load unmatched payment %d: %v", id, err)
+				middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the payment", "")
+				return
+			}
+			middleware.WriteAPISuccess(w, r, unmatched)
+			return
+		}
+
+		list, err := middleware.ParseListQuery(r, unmatchedPageSize)
+		if err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_query", err.Error(), "")
+			return
+		}
+		includeLinked, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+		payments, err := data.ListUnmatchedPayments(data.UnmatchedPaymentFilter{
+			IncludeLinked: includeLinked,
+			From:          list.From,
+			To:            list.To,
+			Limit:         list.Limit,
+			Offset:        list.Offset,
+		})
+		if err != nil {
+			logger.LogError("Failed to list unmatched payments: %v", err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list payments", "")
+			return
+		}
+		middleware.WriteAPISuccess(w, r, map[string]interface{}{
+			"payments": payments,
+			"limit":    list.Limit,
+			"offset":   list.Offset,
+		})
+
+	case http.MethodPost:
+		linkUnmatchedPayment(w, r)
+
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+	}
+}
+
+func linkUnmatchedPayment(w http.ResponseWriter, r *http.Request) {
+	var req LinkUnmatchedPaymentRequest
+	if err := middleware.ParseJSONRequest(r, &req); err != nil {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+		return
+	}
+	if req.ID == 0 || req.FormID == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_fields", "Missing id or formID", "")
+		return
+	}
+
+	unmatched, err := data.GetUnmatchedPayment(req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Unmatched payment not found", "")
+		return
+	}
+	if err != nil {
+		logger.LogError("Failed to load unmatched payment %d: %v", req.ID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the payment", "")
+		return
+	}
+	if unmatched.LinkedFormID != "" {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_linked",
+			"This payment is already linked", unmatched.LinkedFormID)
+		return
+	}
+
+	summary, err := data.GetSubmissionSummary(req.FormID)
+	if err != nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "form_not_found", "Form not found", "")
+		return
+	}
+	if summary.PayPalStatus == "COMPLETED" {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_paid",
+			"This form is already paid", "")
+		return
+	}
+	if math.Abs(summary.CalculatedAmount-unmatched.Amount) > 0.005 && !req.Force {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "amount_mismatch",
+			fmt.Sprintf("The payment of $%.2f doesn't match the form's $%.2f; send force to link it anyway",
+				unmatched.Amount, summary.CalculatedAmount), "")
+		return
+	}
+
+	now := time.Now()
+	linked, err := data.LinkUnmatchedPayment(unmatched.ID, req.FormID, now)
+	if err != nil {
+		logger.LogError("Failed to link unmatched payment %d: %v", unmatched.ID, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to link the payment", "")
+		return
+	}
+	if !linked {
+		middleware.WriteAPIError(w, r, http.StatusConflict, "already_linked", "This payment is already linked", "")
+		return
+	}
+
+	paidAt := unmatched.ReceivedAt
+	tasks := outbox.CaptureTasks(summary.FormType, req.FormID, now)
+	if err := data.RecordPayPalCapture(summary.FormType, req.FormID, linkedCaptureDetails(unmatched, req.FormID),
+		"COMPLETED", &paidAt, tasks, data.ActorAdmin); err != nil {
+		logger.LogError("Failed to record unmatched payment %d on %s: %v", unmatched.ID, req.FormID, err)
+		if err := data.UnlinkUnmatchedPayment(unmatched.ID); err != nil {
+			logger.LogError("Failed to unlink unmatched payment %d: %v", unmatched.ID, err)
+		}
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "record_failed",
+			"Failed to record the payment on the form", "")
+		return
+	}
+
+	logger.LogInfo("Linked unmatched PayPal capture %s ($%.2f) to %s from %s",
+		unmatched.CaptureID, unmatched.Amount, req.FormID, logger.GetClientIP(r))
+	unmatched.LinkedFormID, unmatched.LinkedAt = req.FormID, &now
+	middleware.WriteAPISuccess(w, r, unmatched)
+}
+
+// linkedCaptureDetails wraps a webhook's capture resource in the shape of a capture
+// response, which is what paypal_details holds, so refunds and receipts find the capture
+func linkedCaptureDetails(unmatched *data.UnmatchedPayment, formID string) string {
+	capture := json.RawMessage(unmatched.Details)
+	if !json.Valid(capture) {
+		capture = json.RawMessage(`{}`)
+	}
+	details, _ := json.Marshal(map[string]interface{}{
+		"id":     unmatched.OrderID,
+		"status": "COMPLETED",
+		"purchase_units": []map[string]interface{}{{
+			"invoice_id": formID,
+			"payments": map[string]interface{}{
+				"captures": []json.RawMessage{capture},
+			},
+		}},
+		"unmatched_payment_id": unmatched.ID,
+	})
+	return string(details)
+}
@@ -0,0 +1,474 @@
+// Package paypal holds typed shapes of the PayPal REST API requests and responses the
+// backend works with: orders and their captures, refunds, and the money, payer and
+// fee breakdowns inside them. Only the fields we read or send are modeled; decoders
+// keep the raw JSON so stored payment details lose nothing PayPal sent.
+package paypal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"sbcbackend/internal/currency"
+)
+
+// Order statuses
+const (
+	StatusCreated   = "CREATED"
+	StatusSaved     = "SAVED"
+	StatusApproved  = "APPROVED"
+	StatusCompleted = "COMPLETED"
+	StatusCancelled = "CANCELLED"
+	StatusExpired   = "EXPIRED"
+	StatusVoided    = "VOIDED"
+
+	StatusPayerActionRequired = "PAYER_ACTION_REQUIRED"
+)
+
+// Money is an amount in a currency; PayPal sends the value as a decimal string. An
+// order's amount may carry the breakdown of its items.
+type Money struct {
+	CurrencyCode string           `json:"currency_code"`
+	Value        string           `json:"value"`
+	Breakdown    *AmountBreakdown `json:"breakdown,omitempty"`
+}
+
+// AmountBreakdown splits an order's amount into the total of its items less the
+// discount; PayPal rejects orders whose breakdown doesn't add up to the amount
+type AmountBreakdown struct {
+	ItemTotal *Money `json:"item_total,omitempty"`
+	Discount  *Money `json:"discount,omitempty"`
+}
+
+// NewMoney is amount in the currency with ISO code, rounded to its smallest unit
+func NewMoney(code string, amount float64) *Money {
+	code = currency.Normalize(code)
+	return &Money{CurrencyCode: code, Value: currency.Value(amount, code)}
+}
+
+// USD is amount in US dollars, rounded to cents
+func USD(amount float64) *Money {
+	return NewMoney(currency.USD, amount)
+}
+
+// Amount returns the value as a number, or 0 when it is missing or not a number
+func (m *Money) Amount() float64 {
+	if m == nil {
+		return 0
+	}
+	value, err := strconv.ParseFloat(m.Value, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	return value
+}
+
+// Link is a HATEOAS link on a PayPal resource
+type Link struct {
+	Href   string `json:"href"`
+	Rel    string `json:"rel"`
+	Method string `json:"method,omitempty"`
+}
+
+// FindLink returns the href of the link with rel, or ""
+func FindLink(links []Link, rel string) string {
+	for _, link := range links {
+		if link.Rel == rel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// Name is a payer's name
+type Name struct {
+	GivenName string `json:"given_name,omitempty"`
+	Surname   string `json:"surname,omitempty"`
+}
+
+// Payer is the PayPal account holder who approved an order
+type Payer struct {
+	PayerID      string `json:"payer_id,omitempty"`
+	EmailAddress string `json:"email_address,omitempty"`
+	Name         *Name  `json:"name,omitempty"`
+}
+
+// SellerReceivableBreakdown splits a capture into what the buyer paid, PayPal's fee
+// and what reaches the club
+type SellerReceivableBreakdown struct {
+	GrossAmount *Money `json:"gross_amount,omitempty"`
+	PayPalFee   *Money `json:"paypal_fee,omitempty"`
+	NetAmount   *Money `json:"net_amount,omitempty"`
+}
+
+// RelatedIDs ties a capture back to the order it paid
+type RelatedIDs struct {
+	OrderID string `json:"order_id,omitempty"`
+}
+
+// SupplementaryData carries a capture's related IDs
+type SupplementaryData struct {
+	RelatedIDs RelatedIDs `json:"related_ids"`
+}
+
+// Capture is money taken on an approved order
+type Capture struct {
+	ID                        string                     `json:"id"`
+	Status                    string                     `json:"status"`
+	Amount                    *Money                     `json:"amount,omitempty"`
+	FinalCapture              bool                       `json:"final_capture,omitempty"`
+	InvoiceID                 string                     `json:"invoice_id,omitempty"`
+	CustomID                  string                     `json:"custom_id,omitempty"`
+	SellerReceivableBreakdown *SellerReceivableBreakdown `json:"seller_receivable_breakdown,omitempty"`
+	SupplementaryData         *SupplementaryData         `json:"supplementary_data,omitempty"`
+	Links                     []Link                     `json:"links,omitempty"`
+	CreateTime                string                     `json:"create_time,omitempty"`
+	UpdateTime                string                     `json:"update_time,omitempty"`
+}
+
+// SelfURL is the API link to the capture, shown to admins next to the payment
+func (c *Capture) SelfURL() string {
+	return FindLink(c.Links, "self")
+}
+
+// Fee is PayPal's fee on the capture, or 0 when PayPal didn't break it down
+func (c *Capture) Fee() float64 {
+	if c.SellerReceivableBreakdown == nil {
+		return 0
+	}
+	return c.SellerReceivableBreakdown.PayPalFee.Amount()
+}
+
+// OrderID is the order the capture paid, when PayPal included it
+func (c *Capture) OrderID() string {
+	if c.SupplementaryData == nil {
+		return ""
+	}
+	return c.SupplementaryData.RelatedIDs.OrderID
+}
+
+// ParseCapture decodes a capture, as a PAYMENT.CAPTURE.* webhook's resource
+func ParseCapture(data []byte) (*Capture, error) {
+	var capture Capture
+	if err := json.Unmarshal(data, &capture); err != nil {
+		return nil, fmt.Errorf("invalid PayPal capture: %w", err)
+	}
+	return &capture, nil
+}
+
+// Payments are the captures and refunds made on a purchase unit
+type Payments struct {
+	Captures []Capture `json:"captures,omitempty"`
+	Refunds  []Refund  `json:"refunds,omitempty"`
+}
+
+// PurchaseUnit is what an order is for. The form ID travels as InvoiceID.
+type PurchaseUnit struct {
+	ReferenceID string    `json:"reference_id,omitempty"`
+	Description string    `json:"description,omitempty"`
+	InvoiceID   string    `json:"invoice_id,omitempty"`
+	CustomID    string    `json:"custom_id,omitempty"`
+	Amount      *Money    `json:"amount,omitempty"`
+	Items       []Item    `json:"items,omitempty"`
+	Payments    *Payments `json:"payments,omitempty"`
+}
+
+// CheckBreakdown checks an itemized purchase unit the way PayPal does: the items add
+// up to item_total, and item_total less the discount is the amount. The error names
+// the issue PayPal would report.
+func (u *PurchaseUnit) CheckBreakdown() error {
+	if len(u.Items) == 0 {
+		return nil
+	}
+	if u.Amount == nil || u.Amount.Breakdown == nil || u.Amount.Breakdown.ItemTotal == nil {
+		return fmt.Errorf("ITEM_TOTAL_REQUIRED")
+	}
+	breakdown := u.Amount.Breakdown
+	sum := 0.0
+	for _, item := range u.Items {
+		quantity, err := strconv.Atoi(item.Quantity)
+		if err != nil || quantity <= 0 {
+			return fmt.Errorf("INVALID_PARAMETER_VALUE: quantity %q", item.Quantity)
+		}
+		sum += item.UnitAmount.Amount() * float64(quantity)
+	}
+	if math.Abs(sum-breakdown.ItemTotal.Amount()) > 0.001 {
+		return fmt.Errorf("ITEM_TOTAL_MISMATCH: items add up to %.2f, item_total is %s", sum, breakdown.ItemTotal.Value)
+	}
+	if math.Abs(breakdown.ItemTotal.Amount()-breakdown.Discount.Amount()-u.Amount.Amount()) > 0.001 {
+		return fmt.Errorf("AMOUNT_MISMATCH: breakdown is %s less %.2f, amount is %s",
+			breakdown.ItemTotal.Value, breakdown.Discount.Amount(), u.Amount.Value)
+	}
+	return nil
+}
+
+// Item categories
+const (
+	CategoryDigitalGoods = "DIGITAL_GOODS"
+	CategoryDonation     = "DONATION"
+)
+
+// Item is a line of a purchase unit, listed on the payer's PayPal receipt
+type Item struct {
+	Name       string `json:"name"`
+	Quantity   string `json:"quantity"`
+	UnitAmount *Money `json:"unit_amount"`
+	Category   string `json:"category,omitempty"`
+}
+
+// Order is a v2 checkout order, as created, fetched or returned by a capture
+type Order struct {
+	ID            string                     `json:"id"`
+	Status        string                     `json:"status"`
+	Intent        string                     `json:"intent,omitempty"`
+	Payer         *Payer                     `json:"payer,omitempty"`
+	PurchaseUnits []PurchaseUnit             `json:"purchase_units,omitempty"`
+	PaymentSource map[string]json.RawMessage `json:"payment_source,omitempty"`
+	Links         []Link                     `json:"links,omitempty"`
+
+	// Raw is the JSON the order was decoded from
+	Raw json.RawMessage `json:"-"`
+}
+
+// ParseOrder decodes an order, keeping the JSON it came from in Raw
+func ParseOrder(data []byte) (*Order, error) {
+	var order Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, fmt.Errorf("invalid PayPal order: %w", err)
+	}
+	order.Raw = append(json.RawMessage(nil), data...)
+	return &order, nil
+}
+
+// FirstCapture returns the order's first capture, or nil before it is captured
+func (o *Order) FirstCapture() *Capture {
+	for _, unit := range o.PurchaseUnits {
+		if unit.Payments != nil && len(unit.Payments.Captures) > 0 {
+			return &unit.Payments.Captures[0]
+		}
+	}
+	return nil
+}
+
+// InvoiceID returns the invoice ID of the order's first purchase unit
+func (o *Order) InvoiceID() string {
+	if len(o.PurchaseUnits) == 0 {
+		return ""
+	}
+	return o.PurchaseUnits[0].InvoiceID
+}
+
+// ApproveURL is where the buyer approves the order: the approve link, or payer-action
+// for orders created with a payment source
+func (o *Order) ApproveURL() string {
+	if href := FindLink(o.Links, "approve"); href != "" {
+		return href
+	}
+	return FindLink(o.Links, "payer-action")
+}
+
+// FundingSource names what paid the order (paypal, venmo, card...); PayPal names
+// exactly one payment source
+func (o *Order) FundingSource() string {
+	for source := range o.PaymentSource {
+		return source
+	}
+	return ""
+}
+
+// instrumentLabels prefix the card behind each payment source that pays by card
+var instrumentLabels = map[string]string{
+	"card":       "",
+	"apple_pay":  "Apple Pay ",
+	"google_pay": "Google Pay ",
+}
+
+// Instrument describes the card that paid the order, such as "VISA ending 4242" or
+// "Apple Pay VISA ending 4242", or "" for PayPal and Venmo accounts
+func (o *Order) Instrument() string {
+	source := o.FundingSource()
+	label, ok := instrumentLabels[source]
+	if !ok {
+		return ""
+	}
+	var details struct {
+		CardDetails
+		Card *CardDetails `json:"card,omitempty"` // the card inside a wallet
+	}
+	if err := json.Unmarshal(o.PaymentSource[source], &details); err != nil {
+		return ""
+	}
+	card := details.CardDetails
+	if details.Card != nil {
+		card = *details.Card
+	}
+	if card.LastDigits == "" {
+		return strings.TrimSpace(label)
+	}
+	brand := card.Brand
+	if brand == "" {
+		brand = "Card"
+	}
+	return fmt.Sprintf("%s%s ending %s", label, brand, card.LastDigits)
+}
+
+// PayerEmail is the email of the PayPal account that paid, or ""
+func (o *Order) PayerEmail() string {
+	if o.Payer == nil {
+		return ""
+	}
+	return o.Payer.EmailAddress
+}
+
+// SellerPayableBreakdown is where a capture stands after a refund
+type SellerPayableBreakdown struct {
+	TotalRefundedAmount *Money `json:"total_refunded_amount,omitempty"`
+}
+
+// Refund is money returned on a capture
+type Refund struct {
+	ID                     string                  `json:"id"`
+	Status                 string                  `json:"status"`
+	Amount                 *Money                  `json:"amount,omitempty"`
+	NoteToPayer            string                  `json:"note_to_payer,omitempty"`
+	SellerPayableBreakdown *SellerPayableBreakdown `json:"seller_payable_breakdown,omitempty"`
+	Links                  []Link                  `json:"links,omitempty"`
+
+	// Raw is the JSON the refund was decoded from
+	Raw json.RawMessage `json:"-"`
+}
+
+// ParseRefund decodes a refund, keeping the JSON it came from in Raw
+func ParseRefund(data []byte) (*Refund, error) {
+	var refund Refund
+	if err := json.Unmarshal(data, &refund); err != nil {
+		return nil, fmt.Errorf("invalid PayPal refund: %w", err)
+	}
+	refund.Raw = append(json.RawMessage(nil), data...)
+	return &refund, nil
+}
+
+// RefundedTotal is the running total refunded on the capture, or 0 when PayPal didn't
+// include the breakdown
+func (r *Refund) RefundedTotal() float64 {
+	if r.SellerPayableBreakdown == nil {
+		return 0
+	}
+	return r.SellerPayableBreakdown.TotalRefundedAmount.Amount()
+}
+
+// ExperienceContext shapes the buyer's approval flow for a payment source
+type ExperienceContext struct {
+	ShippingPreference string `json:"shipping_preference,omitempty"`
+}
+
+// CardVerification asks PayPal to verify a card, as with 3-D Secure when the issuer
+// requires it (SCA_WHEN_REQUIRED)
+type CardVerification struct {
+	Method string `json:"method,omitempty"`
+}
+
+// CardAttributes are options for paying by card
+type CardAttributes struct {
+	Verification *CardVerification `json:"verification,omitempty"`
+}
+
+// PaymentSource is how an order asks to be paid: a wallet with its experience context,
+// or card details and how to verify them
+type PaymentSource struct {
+	ExperienceContext *ExperienceContext `json:"experience_context,omitempty"`
+	Number            string             `json:"number,omitempty"`
+	Expiry            string             `json:"expiry,omitempty"`
+	Name              string             `json:"name,omitempty"`
+	Attributes        *CardAttributes    `json:"attributes,omitempty"`
+}
+
+// CardDetails is the card PayPal reports paid an order, directly or through a wallet
+type CardDetails struct {
+	Name       string `json:"name,omitempty"`
+	LastDigits string `json:"last_digits,omitempty"`
+	Brand      string `json:"brand,omitempty"`
+	Type       string `json:"type,omitempty"` // CREDIT, DEBIT...
+}
+
+// OrderRequest is the body of a create-order request
+type OrderRequest struct {
+	Intent        string                   `json:"intent"`
+	PurchaseUnits []PurchaseUnit           `json:"purchase_units"`
+	PaymentSource map[string]PaymentSource `json:"payment_source,omitempty"`
+}
+
+// ConfirmPaymentSourceRequest is the body of a confirm-payment-source request, naming
+// the payment source the buyer chose after the order was created: hosted card fields'
+// card, or the payment data of an Apple Pay or Google Pay sheet
+type ConfirmPaymentSourceRequest struct {
+	PaymentSource map[string]json.RawMessage `json:"payment_source"`
+}
+
+// RefundRequest is the body of a refund request; no amount refunds the whole capture
+type RefundRequest struct {
+	Amount      *Money `json:"amount,omitempty"`
+	NoteToPayer string `json:"note_to_payer,omitempty"`
+}
+
+// Dispute outcomes
+const (
+	OutcomeBuyerFavour  = "RESOLVED_BUYER_FAVOUR"
+	OutcomeSellerFavour = "RESOLVED_SELLER_FAVOUR"
+)
+
+// DisputedTransaction is a payment a dispute is about; InvoiceNumber is the invoice ID
+// of the order it paid
+type DisputedTransaction struct {
+	SellerTransactionID string `json:"seller_transaction_id,omitempty"`
+	InvoiceNumber       string `json:"invoice_number,omitempty"`
+	GrossAmount         *Money `json:"gross_amount,omitempty"`
+}
+
+// DisputeOutcome is how a resolved dispute ended
+type DisputeOutcome struct {
+	OutcomeCode    string `json:"outcome_code,omitempty"`
+	AmountRefunded *Money `json:"amount_refunded,omitempty"`
+}
+
+// Dispute is a buyer's claim or chargeback against a payment, as a CUSTOMER.DISPUTE.*
+// webhook's resource
+type Dispute struct {
+	DisputeID             string                `json:"dispute_id"`
+	Reason                string                `json:"reason,omitempty"`
+	Status                string                `json:"status,omitempty"`
+	DisputeAmount         *Money                `json:"dispute_amount,omitempty"`
+	DisputeOutcome        *DisputeOutcome       `json:"dispute_outcome,omitempty"`
+	DisputeLifeCycleStage string                `json:"dispute_life_cycle_stage,omitempty"`
+	SellerResponseDueDate string                `json:"seller_response_due_date,omitempty"`
+	DisputedTransactions  []DisputedTransaction `json:"disputed_transactions,omitempty"`
+	CreateTime            string                `json:"create_time,omitempty"`
+	UpdateTime            string                `json:"update_time,omitempty"`
+}
+
+// ParseDispute decodes a dispute
+func ParseDispute(data []byte) (*Dispute, error) {
+	var dispute Dispute
+	if err := json.Unmarshal(data, &dispute); err != nil {
+		return nil, fmt.Errorf("invalid PayPal dispute: %w", err)
+	}
+	return &dispute, nil
+}
+
+// InvoiceID is the invoice ID of the first disputed transaction, or ""
+func (d *Dispute) InvoiceID() string {
+	if len(d.DisputedTransactions) == 0 {
+		return ""
+	}
+	return d.DisputedTransactions[0].InvoiceNumber
+}
+
+// Outcome is the outcome code of a resolved dispute, or "" while it is open
+func (d *Dispute) Outcome() string {
+	if d.DisputeOutcome == nil {
+		return ""
+	}
+	return d.DisputeOutcome.OutcomeCode
+}
@@ -0,0 +1,45 @@
+// internal/paypalsim/admin.go
+package paypalsim
+
+import (
+	"net/http"
+	"strconv"
+
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// AdminHandler lists the simulator's injected failures on GET and switches one on or
+// off on POST (?failure=<name>&on=true|false). It requires an admin token and answers
+// 404 when the simulator isn't running.
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	sim := Active()
+	if sim == nil {
+		middleware.WriteAPIError(w, r, http.StatusNotFound, "not_running", "The PayPal simulator is not running", "")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_on", "on must be true or false", "")
+			return
+		}
+		if err := sim.SetFailure(r.URL.Query().Get("failure"), on); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "unknown_failure", "Unknown failure", err.Error())
+			return
+		}
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+		return
+	}
+
+	middleware.WriteAPISuccess(w, r, map[string]interface{}{
+		"failures": sim.Failures(),
+	})
+}
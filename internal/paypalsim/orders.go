@@ -0,0 +1,503 @@
+// internal/paypalsim/orders.go
+package paypalsim
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
+)
+
+// The simulated processing fee on each capture, a typical PayPal rate
+const (
+	feePercent = 2.99
+	feeFixed   = 0.49
+)
+
+// tokenLifetime is how long an access token lasts, as PayPal's do
+const tokenLifetime = 9 * time.Hour
+
+// transaction is one entry of the transaction search report
+type transaction struct {
+	ID          string
+	EventCode   string
+	InvoiceID   string
+	ReferenceID string
+	Amount      *paypal.Money // negative for refunds
+	Fee         *paypal.Money
+	Date        time.Time
+}
+
+// newID makes an ID in the style of PayPal's
+func newID(prefix string) string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return prefix + strings.ToUpper(hex.EncodeToString(random))
+}
+
+func (s *Simulator) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_SUPPORTED", "")
+		return
+	}
+	if s.failing(FailAuth) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error":             "invalid_client",
+			"error_description": "Client Authentication failed",
+		})
+		return
+	}
+
+	token := newID("SIM-TOKEN-")
+	s.mu.Lock()
+	s.tokens[token] = clock.Now().Add(tokenLifetime)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenLifetime.Seconds()),
+		"app_id":       "APP-SIMULATOR",
+	})
+}
+
+// authorized turns away requests without a live access token from handleToken
+func (s *Simulator) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		expiresAt, ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok || !clock.Now().Before(expiresAt) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{
+				"error":             "invalid_token",
+				"error_description": "Token signature verification failed",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Simulator) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_SUPPORTED", "")
+		return
+	}
+	requestID := r.Header.Get("PayPal-Request-Id")
+	s.mu.Lock()
+	if existing, ok := s.orders[s.requestIDs["create:"+requestID]]; ok && requestID != "" {
+		response, _ := json.Marshal(existing)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, json.RawMessage(response))
+		return
+	}
+	s.mu.Unlock()
+
+	if s.failing(FailCreate) {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", "")
+		return
+	}
+
+	var request paypal.OrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+	if len(request.PurchaseUnits) != 1 || request.PurchaseUnits[0].Amount == nil || request.PurchaseUnits[0].Amount.Amount() <= 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "INVALID_PARAMETER_VALUE")
+		return
+	}
+	if err := request.PurchaseUnits[0].CheckBreakdown(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", err.Error())
+		return
+	}
+
+	order := &paypal.Order{
+		ID:            newID("SIM"),
+		Status:        paypal.StatusCreated,
+		Intent:        request.Intent,
+		PurchaseUnits: request.PurchaseUnits,
+	}
+	order.Links = []paypal.Link{
+		{Href: fmt.Sprintf("%s/v2/checkout/orders/%s", s.opts.PublicURL, order.ID), Rel: "self", Method: "GET"},
+		{Href: fmt.Sprintf("%s/checkoutnow?token=%s", s.opts.PublicURL, order.ID), Rel: "approve", Method: "GET"},
+		{Href: fmt.Sprintf("%s/v2/checkout/orders/%s/capture", s.opts.PublicURL, order.ID), Rel: "capture", Method: "POST"},
+	}
+	for source := range request.PaymentSource {
+		if source != "paypal" && source != "venmo" {
+			// Cards are named when confirm-payment-source brings the card fields' details
+			continue
+		}
+		// Wallets named up front send the buyer to approve with them
+		order.Status = paypal.StatusPayerActionRequired
+		order.Links[1].Rel = "payer-action"
+		order.PaymentSource = map[string]json.RawMessage{source: json.RawMessage(`{}`)}
+	}
+	if s.opts.AutoApprove {
+		approve(order)
+	}
+
+	s.mu.Lock()
+	s.orders[order.ID] = order
+	if requestID != "" {
+		s.requestIDs["create:"+requestID] = order.ID
+	}
+	response, _ := json.Marshal(order)
+	s.mu.Unlock()
+
+	logger.LogInfo("PayPal simulator created order %s for %s (%s %s)", order.ID, order.InvoiceID(),
+		order.PurchaseUnits[0].Amount.Value, order.PurchaseUnits[0].Amount.CurrencyCode)
+	writeJSON(w, http.StatusCreated, json.RawMessage(response))
+}
+
+// approve marks an order approved by a simulated buyer. The caller holds the lock or
+// owns the order.
+func approve(order *paypal.Order) {
+	order.Status = paypal.StatusApproved
+	order.Payer = &paypal.Payer{
+		PayerID:      "SIMBUYER",
+		EmailAddress: "buyer@example.com",
+		Name:         &paypal.Name{GivenName: "Simulated", Surname: "Buyer"},
+	}
+	if len(order.PaymentSource) == 0 {
+		order.PaymentSource = map[string]json.RawMessage{
+			"paypal": json.RawMessage(`{"email_address":"buyer@example.com","account_id":"SIMBUYER"}`),
+		}
+	}
+}
+
+// handleOrder serves GET /v2/checkout/orders/{id} and POST /v2/checkout/orders/{id}/capture
+// and /confirm-payment-source
+func (s *Simulator) handleOrder(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/checkout/orders/"), "/")
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.mu.Lock()
+		order, ok := s.orders[parts[0]]
+		response, _ := json.Marshal(order)
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+			return
+		}
+		writeJSON(w, http.StatusOK, json.RawMessage(response))
+	case len(parts) == 2 && parts[1] == "capture" && r.Method == http.MethodPost:
+		s.handleCapture(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "confirm-payment-source" && r.Method == http.MethodPost:
+		s.handleConfirmPaymentSource(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "")
+	}
+}
+
+// simulatedCard is the card every confirmed card or wallet pays with, PayPal's test Visa
+const simulatedCard = `{"name":"Simulated Buyer","last_digits":"1111","brand":"VISA","type":"CREDIT"}`
+
+// handleConfirmPaymentSource attaches the card or wallet chosen for an order and approves
+// it, as for a card its issuer doesn't ask to verify
+func (s *Simulator) handleConfirmPaymentSource(w http.ResponseWriter, r *http.Request, orderID string) {
+	var request paypal.ConfirmPaymentSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.PaymentSource) != 1 {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+	if s.failing(FailDecline) {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "CARD_DECLINED")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	if order.Status != paypal.StatusCreated && order.Status != paypal.StatusPayerActionRequired {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_ALREADY_APPROVED")
+		return
+	}
+	for source := range request.PaymentSource {
+		details := json.RawMessage(simulatedCard)
+		if source != "card" {
+			details = json.RawMessage(`{"card":` + simulatedCard + `}`)
+		}
+		order.PaymentSource = map[string]json.RawMessage{source: details}
+	}
+	order.Status = paypal.StatusApproved
+	response, _ := json.Marshal(order)
+
+	logger.LogInfo("PayPal simulator confirmed %s for order %s", order.FundingSource(), order.ID)
+	writeJSON(w, http.StatusOK, json.RawMessage(response))
+}
+
+func (s *Simulator) handleCapture(w http.ResponseWriter, r *http.Request, orderID string) {
+	requestID := r.Header.Get("PayPal-Request-Id")
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	if ok && requestID != "" && s.requestIDs["capture:"+requestID] == orderID && order.Status == paypal.StatusCompleted {
+		// A retried capture gets the response the first one lost
+		response, _ := json.Marshal(order)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusCreated, json.RawMessage(response))
+		return
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	case s.failing(FailCapture):
+		writeError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", "")
+		return
+	case s.failing(FailDecline):
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INSTRUMENT_DECLINED")
+		return
+	}
+
+	s.mu.Lock()
+	switch order.Status {
+	case paypal.StatusCompleted:
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_ALREADY_CAPTURED")
+		return
+	case paypal.StatusApproved:
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED")
+		return
+	}
+
+	now := clock.Now().UTC()
+	unit := &order.PurchaseUnits[0]
+	code := unit.Amount.CurrencyCode
+	fee := unit.Amount.Amount()*feePercent/100 + feeFixed
+	capture := paypal.Capture{
+		ID:           newID("SIMCAP"),
+		Status:       paypal.StatusCompleted,
+		Amount:       &paypal.Money{CurrencyCode: code, Value: unit.Amount.Value},
+		FinalCapture: true,
+		InvoiceID:    unit.InvoiceID,
+		CustomID:     unit.CustomID,
+		SellerReceivableBreakdown: &paypal.SellerReceivableBreakdown{
+			GrossAmount: &paypal.Money{CurrencyCode: code, Value: unit.Amount.Value},
+			PayPalFee:   paypal.NewMoney(code, fee),
+			NetAmount:   paypal.NewMoney(code, unit.Amount.Amount()-fee),
+		},
+		SupplementaryData: &paypal.SupplementaryData{RelatedIDs: paypal.RelatedIDs{OrderID: order.ID}},
+		CreateTime:        now.Format(time.RFC3339),
+		UpdateTime:        now.Format(time.RFC3339),
+	}
+	capture.Links = []paypal.Link{
+		{Href: fmt.Sprintf("%s/v2/payments/captures/%s", s.opts.PublicURL, capture.ID), Rel: "self", Method: "GET"},
+		{Href: fmt.Sprintf("%s/v2/payments/captures/%s/refund", s.opts.PublicURL, capture.ID), Rel: "refund", Method: "POST"},
+	}
+	unit.Payments = &paypal.Payments{Captures: []paypal.Capture{capture}}
+	order.Status = paypal.StatusCompleted
+	s.captures[capture.ID] = order.ID
+	if requestID != "" {
+		s.requestIDs["capture:"+requestID] = order.ID
+	}
+	s.transactions = append(s.transactions, transaction{
+		ID: capture.ID, EventCode: "T0006", InvoiceID: unit.InvoiceID,
+		Amount: capture.Amount, Fee: paypal.NewMoney(code, -fee), Date: now,
+	})
+	response, _ := json.Marshal(order)
+	s.mu.Unlock()
+
+	logger.LogInfo("PayPal simulator captured order %s (capture %s)", order.ID, capture.ID)
+	s.sendWebhook("PAYMENT.CAPTURE.COMPLETED", "capture",
+		fmt.Sprintf("Payment completed for %s %s", capture.Amount.Value, code), capture)
+
+	if s.failing(FailCaptureResponse) {
+		writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "")
+		return
+	}
+	writeJSON(w, http.StatusCreated, json.RawMessage(response))
+}
+
+// refundResource is a refund as a PAYMENT.CAPTURE.REFUNDED webhook carries it
+type refundResource struct {
+	paypal.Refund
+	InvoiceID string `json:"invoice_id,omitempty"`
+}
+
+// handleRefund serves POST /v2/payments/captures/{id}/refund
+func (s *Simulator) handleRefund(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/payments/captures/"), "/")
+	if len(parts) != 2 || parts[1] != "refund" || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "")
+		return
+	}
+	if s.failing(FailRefund) {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", "")
+		return
+	}
+	var request paypal.RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+
+	s.mu.Lock()
+	order, ok := s.orders[s.captures[parts[0]]]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	unit := &order.PurchaseUnits[0]
+	captured := unit.Payments.Captures[0].Amount
+	refunded := 0.0
+	for _, refund := range unit.Payments.Refunds {
+		refunded += refund.Amount.Amount()
+	}
+	amount := request.Amount
+	if amount == nil {
+		amount = paypal.NewMoney(captured.CurrencyCode, captured.Amount()-refunded)
+	}
+	if amount.Amount() <= 0 || amount.Amount() > captured.Amount()-refunded+0.001 {
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "REFUND_AMOUNT_EXCEEDED")
+		return
+	}
+
+	now := clock.Now().UTC()
+	refunded += amount.Amount()
+	refund := paypal.Refund{
+		ID:          newID("SIMREF"),
+		Status:      paypal.StatusCompleted,
+		Amount:      amount,
+		NoteToPayer: request.NoteToPayer,
+		SellerPayableBreakdown: &paypal.SellerPayableBreakdown{
+			TotalRefundedAmount: paypal.NewMoney(captured.CurrencyCode, refunded),
+		},
+		Links: []paypal.Link{
+			{Href: fmt.Sprintf("%s/v2/payments/captures/%s", s.opts.PublicURL, parts[0]), Rel: "up", Method: "GET"},
+		},
+	}
+	unit.Payments.Refunds = append(unit.Payments.Refunds, refund)
+	unit.Payments.Captures[0].Status = "PARTIALLY_REFUNDED"
+	if refunded >= captured.Amount()-0.001 {
+		unit.Payments.Captures[0].Status = "REFUNDED"
+	}
+	s.transactions = append(s.transactions, transaction{
+		ID: refund.ID, EventCode: "T1107", InvoiceID: unit.InvoiceID, ReferenceID: parts[0],
+		Amount: paypal.NewMoney(amount.CurrencyCode, -amount.Amount()), Date: now,
+	})
+	invoiceID := unit.InvoiceID
+	s.mu.Unlock()
+
+	logger.LogInfo("PayPal simulator refunded %s %s on capture %s", amount.Value, amount.CurrencyCode, parts[0])
+	s.sendWebhook("PAYMENT.CAPTURE.REFUNDED", "refund",
+		fmt.Sprintf("A %s %s capture payment was refunded", amount.Value, amount.CurrencyCode),
+		refundResource{Refund: refund, InvoiceID: invoiceID})
+	writeJSON(w, http.StatusCreated, refund)
+}
+
+// reportTime is the transaction search's date format
+const reportTime = "2006-01-02T15:04:05-0700"
+
+func (s *Simulator) handleTransactionSearch(w http.ResponseWriter, r *http.Request) {
+	start, errStart := time.Parse(reportTime, r.URL.Query().Get("start_date"))
+	end, errEnd := time.Parse(reportTime, r.URL.Query().Get("end_date"))
+	if errStart != nil || errEnd != nil || end.Sub(start) > 31*24*time.Hour {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "INVALID_DATE_RANGE")
+		return
+	}
+
+	details := []map[string]interface{}{}
+	s.mu.Lock()
+	for _, t := range s.transactions {
+		if t.Date.Before(start) || !t.Date.Before(end) {
+			continue
+		}
+		info := map[string]interface{}{
+			"transaction_id":              t.ID,
+			"transaction_event_code":      t.EventCode,
+			"transaction_status":          "S",
+			"invoice_id":                  t.InvoiceID,
+			"paypal_reference_id":         t.ReferenceID,
+			"transaction_initiation_date": t.Date.Format(reportTime),
+			"transaction_amount":          t.Amount,
+		}
+		if t.Fee != nil {
+			info["fee_amount"] = t.Fee
+		}
+		details = append(details, map[string]interface{}{"transaction_info": info})
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"transaction_details": details,
+		"page":                1,
+		"total_pages":         1,
+	})
+}
+
+var checkoutPage = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>PayPal simulator</title></head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 3em auto">
+<h1>PayPal simulator</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Order}}
+<p>Order <code>{{.Order.ID}}</code> for <strong>{{.Amount}}</strong>{{with .Order.InvoiceID}} (invoice <code>{{.}}</code>){{end}} is {{.Order.Status}}.</p>
+{{if .Approvable}}
+<form method="post">
+<input type="hidden" name="token" value="{{.Order.ID}}">
+<button name="action" value="approve">Approve payment</button>
+<button name="action" value="cancel">Cancel</button>
+</form>
+{{end}}
+{{end}}
+<p><small>No real payment is taken. Failures injected: {{if .Failures}}{{range .Failures}}{{.}} {{end}}{{else}}none{{end}}.</small></p>
+</body></html>`))
+
+// handleCheckoutPage stands in for PayPal's approval page: the buyer approves or cancels
+// the order named by ?token=, then returns to the checkout page to capture it
+func (s *Simulator) handleCheckoutPage(w http.ResponseWriter, r *http.Request) {
+	orderID := r.FormValue("token")
+	page := struct {
+		Order      *paypal.Order
+		Amount     string
+		Approvable bool
+		Message    string
+		Failures   []string
+	}{Failures: s.Failures()}
+
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	if ok {
+		if r.Method == http.MethodPost && (order.Status == paypal.StatusCreated || order.Status == paypal.StatusPayerActionRequired) {
+			if r.FormValue("action") == "approve" {
+				approve(order)
+				page.Message = "Payment approved. Return to the checkout page to finish."
+				logger.LogInfo("PayPal simulator order %s approved", order.ID)
+			} else {
+				page.Message = "Payment cancelled. Return to the checkout page to try again."
+			}
+		}
+		copied := *order
+		copied.PurchaseUnits = append([]paypal.PurchaseUnit(nil), order.PurchaseUnits...)
+		page.Order = &copied
+		page.Amount = order.PurchaseUnits[0].Amount.Value + " " + order.PurchaseUnits[0].Amount.CurrencyCode
+		page.Approvable = order.Status == paypal.StatusCreated || order.Status == paypal.StatusPayerActionRequired
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		page.Message = "No such order."
+	}
+	checkoutPage.Execute(w, page)
+}
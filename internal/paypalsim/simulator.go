@@ -0,0 +1,202 @@
+// Package paypalsim is a stand-in for the parts of PayPal's REST API the backend uses,
+// so staging can run the whole checkout without sandbox credentials. PAYPAL_MODE=simulator
+// starts it in place of PayPal: orders are created, approved on its own checkout page
+// (or automatically), captured and refunded, and each capture or refund is followed by a
+// signed webhook that our webhook handler verifies through the simulator like it would
+// through PayPal. Failures can be switched on to see how checkout copes. Subscriptions
+// aren't simulated.
+package paypalsim
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/paypal"
+)
+
+// Failures the simulator can be told to inject
+const (
+	FailAuth            = "auth"             // token requests are refused
+	FailCreate          = "create"           // order creation fails with a server error
+	FailCapture         = "capture"          // capture fails with a server error, taking nothing
+	FailDecline         = "decline"          // capture or card confirmation is declined as PayPal does a bad card
+	FailCaptureResponse = "capture_response" // capture succeeds but its response is lost
+	FailRefund          = "refund"           // refunds fail with a server error
+	FailWebhook         = "webhook"          // webhooks are never delivered
+)
+
+var knownFailures = map[string]bool{
+	FailAuth: true, FailCreate: true, FailCapture: true, FailDecline: true,
+	FailCaptureResponse: true, FailRefund: true, FailWebhook: true,
+}
+
+// webhookPath is where webhooks are delivered on the Webhooks handler
+const webhookPath = "/api/paypal-webhook"
+
+// Options configure a simulator
+type Options struct {
+	PublicURL    string        // base of the checkout page links handed to browsers
+	AutoApprove  bool          // approve orders as they are created
+	Failures     []string      // failures to start with
+	WebhookDelay time.Duration // how long after a capture or refund its webhook is sent
+	WebhookID    string        // the webhook ID verification requests must name
+	Webhooks     http.Handler  // receives webhooks at /api/paypal-webhook; nil to send none
+}
+
+// Simulator serves the PayPal API from memory
+type Simulator struct {
+	opts   Options
+	secret []byte // signs webhook transmissions
+	mux    *http.ServeMux
+
+	mu           sync.Mutex
+	failures     map[string]bool
+	tokens       map[string]time.Time
+	orders       map[string]*paypal.Order
+	captures     map[string]string // capture ID to order ID
+	requestIDs   map[string]string // PayPal-Request-Id to order ID
+	transactions []transaction
+}
+
+// New creates a simulator; it serves requests through ServeHTTP
+func New(opts Options) *Simulator {
+	s := &Simulator{
+		opts:       opts,
+		secret:     make([]byte, 32),
+		failures:   make(map[string]bool),
+		tokens:     make(map[string]time.Time),
+		orders:     make(map[string]*paypal.Order),
+		captures:   make(map[string]string),
+		requestIDs: make(map[string]string),
+	}
+	if _, err := rand.Read(s.secret); err != nil {
+		panic(fmt.Sprintf("paypalsim: failed to generate webhook secret: %v", err))
+	}
+	for _, failure := range opts.Failures {
+		if err := s.SetFailure(failure, true); err != nil {
+			logger.LogWarn("PayPal simulator: %v", err)
+		}
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/v1/oauth2/token", s.handleToken)
+	s.mux.HandleFunc("/v2/checkout/orders", s.authorized(s.handleCreateOrder))
+	s.mux.HandleFunc("/v2/checkout/orders/", s.authorized(s.handleOrder))
+	s.mux.HandleFunc("/v2/payments/captures/", s.authorized(s.handleRefund))
+	s.mux.HandleFunc("/v1/notifications/verify-webhook-signature", s.authorized(s.handleVerifyWebhookSignature))
+	s.mux.HandleFunc("/v1/reporting/transactions", s.authorized(s.handleTransactionSearch))
+	s.mux.HandleFunc("/checkoutnow", s.handleCheckoutPage)
+	return s
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SetFailure switches an injected failure on or off
+func (s *Simulator) SetFailure(failure string, on bool) error {
+	failure = strings.ToLower(strings.TrimSpace(failure))
+	if !knownFailures[failure] {
+		return fmt.Errorf("unknown failure %q", failure)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if on {
+		s.failures[failure] = true
+	} else {
+		delete(s.failures, failure)
+	}
+	logger.LogInfo("PayPal simulator failure %s: %v", failure, on)
+	return nil
+}
+
+// Failures lists the failures switched on, sorted
+func (s *Simulator) Failures() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures := []string{}
+	for failure := range s.failures {
+		failures = append(failures, failure)
+	}
+	sort.Strings(failures)
+	return failures
+}
+
+func (s *Simulator) failing(failure string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures[failure]
+}
+
+var (
+	activeMu sync.RWMutex
+	active   *Simulator
+)
+
+// Active returns the simulator Start is running, or nil
+func Active() *Simulator {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active
+}
+
+// Start runs a simulator on the configured address and points the PayPal API base at
+// it. Webhooks go to webhooks in-process, through the same routes PayPal's would.
+func Start(settings config.PayPalSimulatorSettings, webhooks http.Handler) (*Simulator, error) {
+	listener, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the PayPal simulator: %w", err)
+	}
+	base := "http://" + listener.Addr().String()
+	publicURL := settings.PublicURL
+	if publicURL == "" {
+		publicURL = base
+	}
+
+	sim := New(Options{
+		PublicURL:    publicURL,
+		AutoApprove:  settings.AutoApprove,
+		Failures:     settings.Failures,
+		WebhookDelay: settings.WebhookDelay,
+		WebhookID:    config.PayPalWebhookID,
+		Webhooks:     webhooks,
+	})
+	server := &http.Server{Handler: sim, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.LogError("PayPal simulator stopped: %v", err)
+		}
+	}()
+
+	config.SetPayPalAPI(base, config.ClientID(), config.ClientSecret())
+	activeMu.Lock()
+	active = sim
+	activeMu.Unlock()
+	logger.LogInfo("PayPal simulator listening on %s, checkout page at %s/checkoutnow", base, publicURL)
+	return sim, nil
+}
+
+// writeJSON sends v with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends an error shaped like PayPal's
+func writeError(w http.ResponseWriter, status int, name, issue string) {
+	body := map[string]interface{}{"name": name, "message": strings.ReplaceAll(strings.ToLower(name), "_", " ")}
+	if issue != "" {
+		body["details"] = []map[string]string{{"issue": issue}}
+	}
+	writeJSON(w, status, body)
+}
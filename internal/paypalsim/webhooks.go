@@ -0,0 +1,123 @@
+// internal/paypalsim/webhooks.go
+package paypalsim
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/logger"
+)
+
+// webhookAttempts is how many times a webhook is sent before the simulator gives up;
+// each retry waits one more WebhookDelay than the last
+const webhookAttempts = 3
+
+// authAlgo names how the simulator signs webhooks; PayPal uses SHA256withRSA
+const authAlgo = "HMACSHA256"
+
+// sendWebhook delivers an event about resource after WebhookDelay, as PayPal does
+// shortly after the change it reports
+func (s *Simulator) sendWebhook(eventType, resourceType, summary string, resource interface{}) {
+	if s.opts.Webhooks == nil {
+		return
+	}
+	if s.failing(FailWebhook) {
+		logger.LogInfo("PayPal simulator dropping %s webhook", eventType)
+		return
+	}
+
+	event, err := json.Marshal(map[string]interface{}{
+		"id":               newID("WH-SIM-"),
+		"event_version":    "1.0",
+		"create_time":      clock.Now().UTC().Format(time.RFC3339),
+		"resource_type":    resourceType,
+		"resource_version": "2.0",
+		"event_type":       eventType,
+		"summary":          summary,
+		"resource":         resource,
+	})
+	if err != nil {
+		logger.LogError("PayPal simulator failed to build %s webhook: %v", eventType, err)
+		return
+	}
+
+	go func() {
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			time.Sleep(time.Duration(attempt) * s.opts.WebhookDelay)
+			status := s.deliver(event)
+			if status >= 200 && status < 300 {
+				return
+			}
+			logger.LogWarn("PayPal simulator %s webhook attempt %d got HTTP %d", eventType, attempt, status)
+		}
+		logger.LogError("PayPal simulator gave up on %s webhook after %d attempts", eventType, webhookAttempts)
+	}()
+}
+
+// deliver posts one signed transmission of event to the Webhooks handler and returns
+// the status it answered with
+func (s *Simulator) deliver(event []byte) int {
+	transmissionID := newID("SIM-TX-")
+	transmissionTime := clock.Now().UTC().Format(time.RFC3339)
+
+	req, err := http.NewRequest(http.MethodPost, webhookPath, bytes.NewReader(event))
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Paypal-Transmission-Id", transmissionID)
+	req.Header.Set("Paypal-Transmission-Time", transmissionTime)
+	req.Header.Set("Paypal-Transmission-Sig", s.sign(transmissionID, transmissionTime, s.opts.WebhookID, event))
+	req.Header.Set("Paypal-Cert-Url", s.opts.PublicURL+"/v1/notifications/certs/simulator")
+	req.Header.Set("Paypal-Auth-Algo", authAlgo)
+
+	rec := httptest.NewRecorder()
+	s.opts.Webhooks.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// sign signs a transmission over the fields PayPal's signatures cover
+func (s *Simulator) sign(transmissionID, transmissionTime, webhookID string, event []byte) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, event); err != nil {
+		compact.Write(event)
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s|%s|%s|%d", transmissionID, transmissionTime, webhookID, crc32.ChecksumIEEE(compact.Bytes()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// handleVerifyWebhookSignature checks a transmission the simulator signed, for the
+// webhook ID it was configured with
+func (s *Simulator) handleVerifyWebhookSignature(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		AuthAlgo         string          `json:"auth_algo"`
+		TransmissionID   string          `json:"transmission_id"`
+		TransmissionSig  string          `json:"transmission_sig"`
+		TransmissionTime string          `json:"transmission_time"`
+		WebhookID        string          `json:"webhook_id"`
+		WebhookEvent     json.RawMessage `json:"webhook_event"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+
+	status := "FAILURE"
+	expected := s.sign(request.TransmissionID, request.TransmissionTime, request.WebhookID, request.WebhookEvent)
+	if request.AuthAlgo == authAlgo && request.WebhookID == s.opts.WebhookID &&
+		hmac.Equal([]byte(request.TransmissionSig), []byte(expected)) {
+		status = "SUCCESS"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"verification_status": status})
+}
@@ -0,0 +1,115 @@
+// Package preferences decides whether a contact may be sent a message of a given
+// category over a given channel. Every sender that writes to payers asks it first.
+package preferences
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// Message categories
+const (
+	Receipts  = "receipts"  // payment confirmations
+	Reminders = "reminders" // unpaid checkout reminders
+	Marketing = "marketing" // announcements and fundraising appeals
+)
+
+// Channels
+const (
+	Email = "email"
+	SMS   = "sms"
+)
+
+// Categories lists every category in display order
+var Categories = []string{Receipts, Reminders, Marketing}
+
+// defaults apply to contacts who haven't said otherwise. Marketing is opt-in; SMS
+// receipts still need the consent given on the form.
+var defaults = map[string][]string{
+	Receipts:  {Email, SMS},
+	Reminders: {Email},
+	Marketing: {},
+}
+
+// Allows reports whether contact accepts category messages over channel. When the
+// preference can't be read the default applies, so a database problem doesn't
+// silently stop receipts.
+func Allows(contact, category, channel string) bool {
+	channels, _, err := Channels(contact, category)
+	if err != nil {
+		logger.LogWarn("Failed to read %s preference for %s, using the default: %v", category, contact, err)
+		channels = defaults[category]
+	}
+	for _, allowed := range channels {
+		if allowed == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels returns the channels contact accepts for category and whether they come
+// from the contact's own preference rather than the default
+func Channels(contact, category string) ([]string, bool, error) {
+	if _, ok := defaults[category]; !ok {
+		return nil, false, fmt.Errorf("unknown category %q", category)
+	}
+	preference, err := data.GetNotificationPreference(contact, category)
+	if err != nil {
+		return nil, false, err
+	}
+	if preference == nil {
+		return defaults[category], false, nil
+	}
+	return preference.Channels, true, nil
+}
+
+// Set records the channels contact accepts for category. "none" turns the category
+// off and "default" removes the contact's own preference.
+func Set(contact, category, channels string) error {
+	if _, ok := defaults[category]; !ok {
+		return fmt.Errorf("unknown category %q; use %s", category, strings.Join(Categories, ", "))
+	}
+
+	switch channels = strings.ToLower(strings.TrimSpace(channels)); channels {
+	case "default":
+		return data.DeleteNotificationPreference(contact, category)
+	case "none":
+		return data.SetNotificationPreference(contact, category, nil, clock.Now())
+	}
+
+	parsed, err := ParseChannels(channels)
+	if err != nil {
+		return err
+	}
+	return data.SetNotificationPreference(contact, category, parsed, clock.Now())
+}
+
+// ParseChannels reads a comma separated list of channels, sorted and without repeats
+func ParseChannels(channels string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, channel := range strings.Split(channels, ",") {
+		switch channel = strings.ToLower(strings.TrimSpace(channel)); channel {
+		case Email, SMS:
+			seen[channel] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown channel %q; use email, sms, none or default", channel)
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("no channels given; use email, sms, none or default")
+	}
+
+	parsed := make([]string, 0, len(seen))
+	for channel := range seen {
+		parsed = append(parsed, channel)
+	}
+	sort.Strings(parsed)
+	return parsed, nil
+}
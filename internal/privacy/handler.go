@@ -0,0 +1,293 @@
+package privacy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/security"
+)
+
+// requestReceived is the answer to every valid request, whether or not a link was sent
+const requestReceived = "If we have submissions for that address, we've emailed it a link to see or delete them. " +
+	"The link works for a day."
+
+/*
+RequestHandler takes the email address of a parent asking to see or delete their
+data and emails it a verification link. It needs a CSRF token like the forms do, and
+answers the same whether or not the address has submissions.
+*/
+func RequestHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	csrfToken := r.FormValue("csrf_token")
+	if csrfToken == "" || !security.ValidateCSRFToken(csrfToken) {
+		err := fmt.Errorf("missing or invalid CSRF token")
+		logger.LogHTTPError(r, http.StatusForbidden, err)
+		middleware.WriteAPIError(w, r, http.StatusForbidden, "invalid_csrf_token", "Please reload the page and try again", "")
+		return
+	}
+
+	err := Request(r.Context(), r.FormValue("email"))
+	if errors.Is(err, ErrInvalidEmail) {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_email", "Please enter a valid email address", "")
+		return
+	}
+	if err != nil {
+		// Failing only for addresses with submissions would tell the caller they have some
+		logger.LogError("Failed to handle privacy request from %s: %v", logger.GetClientIP(r), err)
+	}
+	middleware.WriteAPISuccess(w, r, map[string]string{"message": requestReceived})
+}
+
+var privacyPageTmpl = template.Must(template.New("privacy.html.tmpl").
+	ParseFS(assets.Templates(), "privacy.html.tmpl"))
+
+// pageSubmission is one row of the privacy page's submission table
+type pageSubmission struct {
+	FormType      string
+	Date          string
+	Item          string
+	Amount        float64
+	ReceiptNumber string
+}
+
+/*
+PageHandler is the target of the verification link. GET shows the parent's
+submissions with links to download them; POST, from the page's delete button, asks
+for them to be deleted. The link's code is the credential, so the page is kept out of
+caches and search results.
+*/
+func PageHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	code := r.FormValue("code")
+	request, ok := openRequest(w, r, code)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		csrfToken := r.FormValue("csrf_token")
+		if csrfToken == "" || !security.ValidateCSRFToken(csrfToken) {
+			logger.LogHTTPError(r, http.StatusForbidden, fmt.Errorf("missing or invalid CSRF token"))
+			http.Error(w, "Please go back, reload the page and try again", http.StatusForbidden)
+			return
+		}
+
+		pending, err := Delete(r.Context(), request)
+		switch {
+		case errors.Is(err, ErrAlreadyRequested):
+			message = "You already asked us to delete your data."
+		case err != nil:
+			logger.LogHTTPError(r, http.StatusInternalServerError, err)
+			http.Error(w, "Failed to delete your data; please try again later", http.StatusInternalServerError)
+			return
+		case pending:
+			message = "Submissions without payments were deleted. A board member will review the rest and email you."
+		default:
+			message = "Your data was deleted."
+		}
+		if request, err = data.GetPrivacyRequest(request.ID); err != nil || request == nil {
+			logger.LogHTTPError(r, http.StatusInternalServerError, fmt.Errorf("failed to reload privacy request: %v", err))
+			http.Error(w, "Failed to load your request", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	submissions, err := submissionsOf(request.Email)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to load your submissions", http.StatusInternalServerError)
+		return
+	}
+	rows := make([]pageSubmission, 0, len(submissions))
+	for _, sub := range submissions {
+		rows = append(rows, pageSubmission{
+			FormType:      sub.FormType,
+			Date:          sub.SubmissionDate.In(clock.Location()).Format("Jan 2, 2006"),
+			Item:          sub.Item,
+			Amount:        sub.CalculatedAmount,
+			ReceiptNumber: sub.ReceiptNumber,
+		})
+	}
+
+	var buf bytes.Buffer
+	err = privacyPageTmpl.Execute(&buf, map[string]interface{}{
+		"Email":       request.Email,
+		"Message":     message,
+		"Submissions": rows,
+		"Status":      request.Status,
+		"ReviewNote":  request.ReviewNote,
+		"Code":        code,
+		"CSRFToken":   security.GenerateCSRFToken(),
+		"JSONURL":     exportURL(code, "json"),
+		"PDFURL":      exportURL(code, "pdf"),
+	})
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to render the page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// ExportHandler downloads a verified parent's data as JSON or, with format=pdf, as a PDF
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, ok := openRequest(w, r, r.URL.Query().Get("code"))
+	if !ok {
+		return
+	}
+	export, err := BuildExport(request)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to collect your data", http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	filename := "booster-club-data-" + clock.Now().In(clock.Location()).Format("2006-01-02")
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(export)
+		w.Header().Set("Content-Type", "application/json")
+		filename += ".json"
+	case "pdf":
+		err = export.WritePDF(&buf)
+		w.Header().Set("Content-Type", "application/pdf")
+		filename += ".pdf"
+	default:
+		http.Error(w, "Unknown format; use json or pdf", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to write your data", http.StatusInternalServerError)
+		return
+	}
+
+	logger.LogInfo("Privacy request %d downloaded its data", request.ID)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	buf.WriteTo(w)
+}
+
+// openRequest checks a verification link's code, answering the request itself when
+// it isn't valid. The code is in the URL, so responses are kept out of caches, other
+// sites' logs and search results.
+func openRequest(w http.ResponseWriter, r *http.Request, code string) (*data.PrivacyRequest, bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	if code == "" {
+		http.Error(w, "Missing code", http.StatusBadRequest)
+		return nil, false
+	}
+	request, err := Open(code)
+	if errors.Is(err, ErrLinkExpired) {
+		logger.LogWarn("Privacy link refused from %s", logger.GetClientIP(r))
+		http.Error(w, "This link is no longer valid. You can ask for a new one.", http.StatusGone)
+		return nil, false
+	}
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to load your request", http.StatusInternalServerError)
+		return nil, false
+	}
+	return request, true
+}
+
+func exportURL(code, format string) string {
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("format", format)
+	return "/api/privacy/export?" + params.Encode()
+}
+
+// EraseRequest is the body of an admin's POST to AdminEraseHandler
+type EraseRequest struct {
+	Email string `json:"email"`
+}
+
+/*
+AdminEraseHandler lets an admin carry out a deletion request keyed by email address.
+GET with ?email= lists the submissions held under it; POST with {"email": ...}
+anonymizes all of them, paid ones included, keeping the amounts the club's books need.
+*/
+func AdminEraseHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		contact := data.NormalizeContact(r.URL.Query().Get("email"))
+		if contact == "" {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_email", "email is required", "")
+			return
+		}
+		submissions, err := submissionsOf(contact)
+		if err != nil {
+			logger.LogError("Failed to list submissions of %s: %v", contact, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list submissions", "")
+			return
+		}
+		if submissions == nil {
+			submissions = []data.SubmissionSummary{}
+		}
+		middleware.WriteAPISuccess(w, r, map[string]interface{}{"email": contact, "submissions": submissions})
+
+	case http.MethodPost:
+		var req EraseRequest
+		if err := middleware.ParseJSONRequest(r, &req); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON request", err.Error())
+			return
+		}
+		anonymized, err := Erase(r.Context(), req.Email, string(data.ActorAdmin))
+		if errors.Is(err, ErrInvalidEmail) {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_email", "A valid email address is required", "")
+			return
+		}
+		if err != nil {
+			logger.LogError("Failed to erase %s: %v", req.Email, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to erase the address's data", "")
+			return
+		}
+		middleware.WriteAPISuccess(w, r, map[string]interface{}{
+			"email":      data.NormalizeContact(req.Email),
+			"anonymized": anonymized,
+		})
+
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+	}
+}
@@ -0,0 +1,142 @@
+package privacy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page layout of the PDF export, in points on a US Letter page
+const (
+	pdfPageWidth    = 612
+	pdfPageHeight   = 792
+	pdfMargin       = 54
+	pdfFontSize     = 10
+	pdfLeading      = 13
+	pdfTitleSize    = 14
+	pdfWrapAt       = 95 // characters of Helvetica 10 that fit between the margins
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+)
+
+// writePDF writes title and lines as a text-only PDF. It uses the standard Helvetica
+// fonts, which every viewer has, so nothing needs embedding; characters outside
+// Latin-1 print as "?".
+func writePDF(w io.Writer, title string, lines []string) error {
+	var wrapped []string
+	for _, line := range lines {
+		wrapped = append(wrapped, wrapPDFLine(line, pdfWrapAt)...)
+	}
+
+	// The title takes two lines of the first page
+	var pages [][]string
+	perPage := pdfLinesPerPage - 2
+	for len(wrapped) > perPage {
+		pages = append(pages, wrapped[:perPage])
+		wrapped = wrapped[perPage:]
+		perPage = pdfLinesPerPage
+	}
+	pages = append(pages, wrapped)
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes two, the
+	// page and its content stream
+	const firstPage = 5
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range pages {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n%d TL\n%d %d Td\n", pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+		if i == 0 {
+			fmt.Fprintf(&content, "/F2 %d Tf\n(%s) Tj\nT* T*\n", pdfTitleSize, pdfString(title))
+		}
+		fmt.Fprintf(&content, "/F1 %d Tf\n", pdfFontSize)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfString(line))
+		}
+		content.WriteString("ET")
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// wrapPDFLine breaks line at spaces so no piece is longer than width characters,
+// splitting words that are longer on their own
+func wrapPDFLine(line string, width int) []string {
+	if utf8.RuneCountInString(line) <= width {
+		return []string{line}
+	}
+
+	var wrapped []string
+	var current []rune
+	for _, word := range strings.Split(line, " ") {
+		runes := []rune(word)
+		for len(runes) > width {
+			if len(current) > 0 {
+				wrapped = append(wrapped, string(current))
+				current = nil
+			}
+			wrapped = append(wrapped, string(runes[:width]))
+			runes = runes[width:]
+		}
+		if len(current) > 0 && len(current)+1+len(runes) > width {
+			wrapped = append(wrapped, string(current))
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, ' ')
+		}
+		current = append(current, runes...)
+	}
+	return append(wrapped, string(current))
+}
+
+// pdfString escapes s for a PDF literal string in WinAnsi encoding
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '\t':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
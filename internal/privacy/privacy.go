@@ -0,0 +1,574 @@
+// Package privacy lets parents see and delete what the club holds under their email
+// address. A request is verified by a link emailed to that address. A verified parent
+// can download their submissions at once; deleting submissions with payments waits
+// for an admin, since the club's books need them. Past the retention period, closed
+// submissions are anonymized whether or not anyone asked.
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/security"
+)
+
+var (
+	// ErrInvalidEmail is returned for a request that isn't for an email address
+	ErrInvalidEmail = errors.New("not a valid email address")
+	// ErrLinkExpired is returned for a verification link that is unknown or too old
+	ErrLinkExpired = errors.New("this link is no longer valid")
+	// ErrAlreadyRequested is returned when the parent already asked for a deletion
+	ErrAlreadyRequested = errors.New("a deletion was already requested with this link")
+)
+
+var verificationEmail = notification.Template{
+	Name: "privacy verification",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			subject, body := email.RenderPrivacyVerification(d.(email.PrivacyVerificationData))
+			return notification.Message{Subject: subject, Body: body}, nil
+		},
+	},
+}
+
+var reviewEmail = notification.Template{
+	Name: "privacy review",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			subject, body := email.RenderPrivacyReview(d.(email.PrivacyReviewData))
+			return notification.Message{Subject: subject, Body: body}, nil
+		},
+	},
+}
+
+var outcomeEmail = notification.Template{
+	Name: "privacy outcome",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			subject, body := email.RenderPrivacyOutcome(d.(email.PrivacyOutcomeData))
+			return notification.Message{Subject: subject, Body: body}, nil
+		},
+	},
+}
+
+// =============================================================================
+// REQUESTS
+// =============================================================================
+
+// Request emails a verification link to emailAddress when the club holds submissions
+// made with it. The caller isn't told whether it does, so the form can't be used to
+// find out who has registered.
+func Request(ctx context.Context, emailAddress string) error {
+	contact := data.NormalizeContact(emailAddress)
+	if address, err := mail.ParseAddress(contact); err != nil || address.Address != contact {
+		return ErrInvalidEmail
+	}
+
+	submissions, err := submissionsOf(contact)
+	if err != nil {
+		return err
+	}
+	if len(submissions) == 0 {
+		logger.LogInfo("Privacy request for an address with no submissions, not sending a link")
+		return nil
+	}
+
+	settings := config.LoadPrivacySettings()
+	now := clock.Now()
+	latest, err := data.LatestPrivacyRequestAt(contact)
+	if err != nil {
+		return err
+	}
+	if latest != nil && now.Sub(*latest) < settings.RequestInterval {
+		logger.LogInfo("Privacy link for %s sent %v ago, not sending another", contact, now.Sub(*latest).Round(time.Second))
+		return nil
+	}
+
+	token, err := security.GenerateResumeToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := now.Add(settings.LinkTTL)
+	id, err := data.CreatePrivacyRequest(contact, token, now, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	logger.LogInfo("Sending privacy request %d link to %s", id, contact)
+	return notification.Send(ctx, notification.Notification{
+		Template: verificationEmail,
+		Data: email.PrivacyVerificationData{
+			Email:     contact,
+			LinkURL:   LinkURL(token),
+			ExpiresAt: expiresAt.In(clock.Location()),
+		},
+		Recipients: []notification.Recipient{notification.Payer(contact)},
+	})
+}
+
+// LinkURL is the verification link of the request with token
+func LinkURL(token string) string {
+	baseURL := os.Getenv("PUBLIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://suzuki.nfshost.com"
+	}
+
+	params := url.Values{}
+	params.Set("code", token)
+	return fmt.Sprintf("%s/api/privacy?%s", strings.TrimRight(baseURL, "/"), params.Encode())
+}
+
+// Open returns the request a verification link belongs to, recording that the parent
+// proved they read the email sent to the address
+func Open(token string) (*data.PrivacyRequest, error) {
+	request, err := data.GetPrivacyRequestByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil || !clock.Now().Before(request.ExpiresAt) {
+		return nil, ErrLinkExpired
+	}
+	if request.VerifiedAt == nil {
+		now := clock.Now()
+		if err := data.MarkPrivacyRequestVerified(request.ID, now); err != nil {
+			return nil, err
+		}
+		request.VerifiedAt = &now
+	}
+	return request, nil
+}
+
+// hasPaymentRecord reports whether money moved for a submission, so the club's books
+// refer to it
+func hasPaymentRecord(sub data.SubmissionSummary) bool {
+	switch sub.PayPalStatus {
+	case "COMPLETED", "REFUNDED", "REVERSED", data.DisputedStatus:
+		return true
+	}
+	return sub.ReceiptNumber != ""
+}
+
+// =============================================================================
+// DELETION
+// =============================================================================
+
+// Delete carries out a verified parent's request to delete their data. Submissions
+// without payments and the parent's notification preferences go at once; submissions
+// with payments are kept until an admin approves, and the admins are asked to review.
+// It reports whether anything waits for review.
+func Delete(ctx context.Context, request *data.PrivacyRequest) (bool, error) {
+	submissions, err := submissionsOf(request.Email)
+	if err != nil {
+		return false, err
+	}
+
+	var held, unpaid []data.SubmissionSummary
+	for _, sub := range submissions {
+		if hasPaymentRecord(sub) {
+			held = append(held, sub)
+		} else {
+			unpaid = append(unpaid, sub)
+		}
+	}
+
+	status := data.PrivacyCompleted
+	if len(held) > 0 {
+		status = data.PrivacyPendingReview
+	}
+	now := clock.Now()
+	open, err := data.RequestPrivacyDeletion(request.ID, status, now)
+	if err != nil {
+		return false, err
+	}
+	if !open {
+		return false, ErrAlreadyRequested
+	}
+
+	for _, sub := range unpaid {
+		if err := data.AnonymizeSubmission(sub.FormType, sub.FormID, now); err != nil {
+			return false, err
+		}
+	}
+	if err := data.DeleteNotificationPreferences(request.Email); err != nil {
+		return false, err
+	}
+	// Kept while submissions waiting for review still link to it
+	if err := data.DeleteHousehold(request.Email); err != nil {
+		return false, err
+	}
+	logger.LogInfo("Privacy request %d: anonymized %d unpaid submissions, %d with payments wait for review",
+		request.ID, len(unpaid), len(held))
+
+	if len(held) == 0 {
+		return false, data.CompletePrivacyRequest(request.ID, now)
+	}
+
+	review := email.PrivacyReviewData{RequestID: request.ID, Email: request.Email, Anonymized: len(unpaid)}
+	for _, sub := range held {
+		line := fmt.Sprintf("%s %s, %s, $%.2f, %s", sub.FormType, sub.FormID,
+			sub.SubmissionDate.In(clock.Location()).Format("2006-01-02"), sub.CalculatedAmount, sub.PayPalStatus)
+		if sub.ReceiptNumber != "" {
+			line += ", receipt " + sub.ReceiptNumber
+		}
+		review.Submissions = append(review.Submissions, line)
+	}
+	if err := notification.Send(ctx, notification.Notification{
+		Template:   reviewEmail,
+		Data:       review,
+		Recipients: []notification.Recipient{notification.Staff()},
+	}); err != nil {
+		// The request is recorded and listed by boosterctl privacy list either way
+		logger.LogError("Failed to ask admins to review privacy request %d: %v", request.ID, err)
+	}
+	return true, nil
+}
+
+// Approve anonymizes the submissions a deletion request was waiting on and tells the
+// parent. Event order pages are rewritten without the names they showed.
+func Approve(ctx context.Context, id int64, reviewer string) error {
+	request, err := pendingRequest(id)
+	if err != nil {
+		return err
+	}
+
+	submissions, err := submissionsOf(request.Email)
+	if err != nil {
+		return err
+	}
+	now := clock.Now()
+	anonymized := 0
+	for _, sub := range submissions {
+		// Submissions made since the parent asked aren't part of the request
+		if request.DeletionRequestedAt != nil && sub.SubmissionDate.After(*request.DeletionRequestedAt) {
+			continue
+		}
+		anonymized++
+		if err := anonymize(sub.FormType, sub.FormID, sub.Archived, now); err != nil {
+			return err
+		}
+	}
+
+	if err := data.DeleteHousehold(request.Email); err != nil {
+		return err
+	}
+	if _, err := data.ReviewPrivacyRequest(id, data.PrivacyCompleted, reviewer, "", now); err != nil {
+		return err
+	}
+	if err := data.CompletePrivacyRequest(id, now); err != nil {
+		return err
+	}
+	logger.LogInfo("Privacy request %d approved by %s: anonymized %d submissions", id, reviewer, anonymized)
+
+	if err := sendOutcome(ctx, request, email.PrivacyOutcomeData{Email: request.Email, Approved: true}); err != nil {
+		return fmt.Errorf("approved, but failed to tell %s: %w", request.Email, err)
+	}
+	return nil
+}
+
+// Reject keeps the submissions a deletion request was waiting on, telling the parent why
+func Reject(ctx context.Context, id int64, reviewer, note string) error {
+	request, err := pendingRequest(id)
+	if err != nil {
+		return err
+	}
+	updated, err := data.ReviewPrivacyRequest(id, data.PrivacyRejected, reviewer, note, clock.Now())
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("privacy request %d is no longer waiting for review", id)
+	}
+	logger.LogInfo("Privacy request %d rejected by %s: %s", id, reviewer, note)
+
+	if err := sendOutcome(ctx, request, email.PrivacyOutcomeData{Email: request.Email, Note: note}); err != nil {
+		return fmt.Errorf("rejected, but failed to tell %s: %w", request.Email, err)
+	}
+	return nil
+}
+
+func pendingRequest(id int64) (*data.PrivacyRequest, error) {
+	request, err := data.GetPrivacyRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, fmt.Errorf("no privacy request %d", id)
+	}
+	if request.Status != data.PrivacyPendingReview {
+		return nil, fmt.Errorf("privacy request %d is %s, not waiting for review", id, request.Status)
+	}
+	return request, nil
+}
+
+// rebuildOrderPage rewrites a paid event's order page from the anonymized submission.
+// Pages of unpaid or refunded orders are left to the order page cleanup.
+func rebuildOrderPage(formID string) {
+	sub, err := data.GetEventByID(formID)
+	if err != nil {
+		logger.LogError("Failed to reload %s to rewrite its order page: %v", formID, err)
+		return
+	}
+	if sub.PayPalStatus != "COMPLETED" || sub.OrderPageURL == "" {
+		return
+	}
+	if _, err := order.RebuildEventOrderPage(sub); err != nil {
+		logger.LogError("Failed to rewrite the order page of anonymized %s: %v", formID, err)
+	}
+}
+
+func sendOutcome(ctx context.Context, request *data.PrivacyRequest, outcome email.PrivacyOutcomeData) error {
+	return notification.Send(ctx, notification.Notification{
+		Template:   outcomeEmail,
+		Data:       outcome,
+		Recipients: []notification.Recipient{notification.Payer(request.Email)},
+	})
+}
+
+// =============================================================================
+// EXPORT
+// =============================================================================
+
+// Export is everything the club holds under a parent's email address
+type Export struct {
+	Email       string               `json:"email"`
+	GeneratedAt time.Time            `json:"generated_at"`
+	Submissions []ExportedSubmission `json:"submissions"`
+	Preferences []ExportedPreference `json:"notification_preferences"`
+}
+
+// ExportedSubmission is one submission as its payer sees it. Credentials such as
+// access tokens are left out.
+type ExportedSubmission struct {
+	FormType       string                 `json:"form_type"`
+	FormID         string                 `json:"form_id"`
+	SubmissionDate time.Time              `json:"submission_date"`
+	FullName       string                 `json:"full_name"`
+	Email          string                 `json:"email"`
+	School         string                 `json:"school,omitempty"`
+	Students       []data.Student         `json:"students,omitempty"`
+	Details        map[string]interface{} `json:"details,omitempty"`
+	Amount         float64                `json:"amount"`
+	PaymentStatus  string                 `json:"payment_status,omitempty"`
+	PaidAt         *time.Time             `json:"paid_at,omitempty"`
+	ReceiptNumber  string                 `json:"receipt_number,omitempty"`
+	PayPalOrderID  string                 `json:"paypal_order_id,omitempty"`
+	Archived       bool                   `json:"archived,omitempty"` // a past school year's, without form details
+	SMSPhone       string                 `json:"sms_phone,omitempty"`
+	PayPal         json.RawMessage        `json:"paypal,omitempty"` // PayPal's record of the payment
+}
+
+// ExportedPreference is a notification preference the parent set
+type ExportedPreference struct {
+	Category string   `json:"category"`
+	Channels []string `json:"channels"`
+}
+
+// BuildExport collects what the club holds for request's email address
+func BuildExport(request *data.PrivacyRequest) (*Export, error) {
+	export := &Export{Email: request.Email, GeneratedAt: clock.Now().In(clock.Location())}
+
+	submissions, err := submissionsOf(request.Email)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range submissions {
+		exported, err := exportSubmission(sub)
+		if err != nil {
+			return nil, err
+		}
+		export.Submissions = append(export.Submissions, *exported)
+	}
+
+	preferences, err := data.ListNotificationPreferences(request.Email)
+	if err != nil {
+		return nil, err
+	}
+	for _, preference := range preferences {
+		export.Preferences = append(export.Preferences, ExportedPreference{
+			Category: preference.Category,
+			Channels: preference.Channels,
+		})
+	}
+	return export, nil
+}
+
+func exportSubmission(summary data.SubmissionSummary) (*ExportedSubmission, error) {
+	exported := &ExportedSubmission{
+		FormType:       summary.FormType,
+		FormID:         summary.FormID,
+		SubmissionDate: summary.SubmissionDate,
+		FullName:       summary.FullName,
+		Email:          summary.Email,
+		School:         summary.School,
+		Amount:         summary.CalculatedAmount,
+		PaymentStatus:  summary.PayPalStatus,
+		PaidAt:         summary.SubmittedAt,
+		ReceiptNumber:  summary.ReceiptNumber,
+		PayPalOrderID:  summary.PayPalOrderID,
+		Archived:       summary.Archived,
+	}
+
+	var payPalDetails string
+	switch {
+	case summary.Archived:
+		// The form repositories read this school year's tables only
+		contact, err := data.GetArchivedContact(summary.FormType, summary.FormID)
+		if err != nil {
+			return nil, err
+		}
+		exported.Students = contact.Students
+	case summary.FormType == "membership":
+		sub, err := data.GetMembershipByID(summary.FormID)
+		if err != nil {
+			return nil, err
+		}
+		exported.Students, payPalDetails = sub.Students, sub.PayPalDetails
+		exported.Details = map[string]interface{}{
+			"membership":        sub.Membership,
+			"membership_status": sub.MembershipStatus,
+			"describe":          sub.Describe,
+			"interests":         sub.Interests,
+			"addons":            sub.Addons,
+			"fees":              sub.Fees,
+			"donation":          sub.Donation,
+			"cover_fees":        sub.CoverFees,
+		}
+	case summary.FormType == "event":
+		sub, err := data.GetEventByID(summary.FormID)
+		if err != nil {
+			return nil, err
+		}
+		exported.Students, payPalDetails = sub.Students, sub.PayPalDetails
+		exported.Details = map[string]interface{}{
+			"event":         sub.Event,
+			"food_order_id": sub.FoodOrderID,
+			"food_choices":  sub.FoodChoices,
+			"dietary_notes": sub.DietaryNotes,
+			"cover_fees":    sub.CoverFees,
+		}
+	case summary.FormType == "fundraiser":
+		sub, err := data.GetFundraiserByID(summary.FormID)
+		if err != nil {
+			return nil, err
+		}
+		exported.Students, payPalDetails = sub.Students, sub.PayPalDetails
+		exported.Details = map[string]interface{}{
+			"describe":       sub.Describe,
+			"donor_status":   sub.DonorStatus,
+			"donation_items": sub.DonationItems,
+			"cover_fees":     sub.CoverFees,
+		}
+	}
+
+	if payPalDetails != "" && json.Valid([]byte(payPalDetails)) {
+		exported.PayPal = json.RawMessage(payPalDetails)
+	}
+
+	recipient, err := data.GetSMSRecipient(summary.FormID)
+	if err != nil {
+		return nil, err
+	}
+	if recipient != nil {
+		exported.SMSPhone = recipient.Phone
+	}
+	return exported, nil
+}
+
+// lines renders the export as text for the PDF. PayPal's records are left to the
+// JSON download, which keeps them whole.
+func (e *Export) lines() []string {
+	lines := []string{
+		"Email address: " + e.Email,
+		"Generated: " + e.GeneratedAt.Format("January 2, 2006 at 3:04 PM"),
+		fmt.Sprintf("Submissions: %d", len(e.Submissions)),
+	}
+
+	for _, sub := range e.Submissions {
+		lines = append(lines, "",
+			fmt.Sprintf("%s%s submission %s", strings.ToUpper(sub.FormType[:1]), sub.FormType[1:], sub.FormID),
+			"  Submitted: "+sub.SubmissionDate.In(clock.Location()).Format("January 2, 2006 at 3:04 PM"),
+			"  Name: "+sub.FullName,
+			"  Email: "+sub.Email,
+		)
+		if sub.School != "" {
+			lines = append(lines, "  School: "+sub.School)
+		}
+		for _, student := range sub.Students {
+			lines = append(lines, fmt.Sprintf("  Student: %s, grade %s", student.Name, student.Grade))
+		}
+		for _, key := range sortedKeys(sub.Details) {
+			lines = append(lines, fmt.Sprintf("  %s: %s", strings.ReplaceAll(key, "_", " "), detailText(sub.Details[key])))
+		}
+		lines = append(lines, fmt.Sprintf("  Amount: $%.2f", sub.Amount))
+		if sub.PaymentStatus != "" {
+			lines = append(lines, "  Payment status: "+sub.PaymentStatus)
+		}
+		if sub.ReceiptNumber != "" {
+			lines = append(lines, "  Receipt number: "+sub.ReceiptNumber)
+		}
+		if sub.PayPalOrderID != "" {
+			lines = append(lines, "  PayPal order: "+sub.PayPalOrderID)
+		}
+		if sub.SMSPhone != "" {
+			lines = append(lines, "  Text messages to: "+sub.SMSPhone)
+		}
+	}
+
+	lines = append(lines, "", "Notification preferences:")
+	if len(e.Preferences) == 0 {
+		lines = append(lines, "  None set; the club's defaults apply")
+	}
+	for _, preference := range e.Preferences {
+		channels := strings.Join(preference.Channels, ", ")
+		if channels == "" {
+			channels = "none"
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", preference.Category, channels))
+	}
+	return lines
+}
+
+// WritePDF writes the export as a PDF document
+func (e *Export) WritePDF(w io.Writer) error {
+	return writePDF(w, "Booster Club data for "+e.Email, e.lines())
+}
+
+// detailText shows a detail value on one line, lists and maps as JSON
+func detailText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool, float64, int:
+		return fmt.Sprint(v)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package privacy
+
+import (
+	"context"
+	"net/mail"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/logger"
+)
+
+// =============================================================================
+// RETENTION
+// =============================================================================
+
+// AnonymizeExpired anonymizes up to limit submissions of each form type closed before
+// closedBefore, reporting how many it anonymized. Amounts, receipts and PayPal IDs stay,
+// so the club's totals for past years don't change.
+func AnonymizeExpired(ctx context.Context, closedBefore time.Time, limit int) (int, error) {
+	expired, err := data.ListExpiredSubmissions(closedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	now := clock.Now()
+	anonymized := 0
+	for _, sub := range expired {
+		if ctx.Err() != nil {
+			return anonymized, ctx.Err()
+		}
+		if err := anonymize(sub.FormType, sub.FormID, sub.Archived, now); err != nil {
+			return anonymized, err
+		}
+		anonymized++
+	}
+	return anonymized, nil
+}
+
+// Erase anonymizes every submission made with emailAddress, paid or not, and deletes
+// the address's household and notification preferences. It is how an admin carries
+// out a deletion request that reached the club some other way than the privacy page.
+// Requests from the address waiting for review are completed, and the parent told.
+func Erase(ctx context.Context, emailAddress, admin string) (int, error) {
+	contact := data.NormalizeContact(emailAddress)
+	if address, err := mail.ParseAddress(contact); err != nil || address.Address != contact {
+		return 0, ErrInvalidEmail
+	}
+
+	submissions, err := submissionsOf(contact)
+	if err != nil {
+		return 0, err
+	}
+	now := clock.Now()
+	for _, sub := range submissions {
+		if err := anonymize(sub.FormType, sub.FormID, sub.Archived, now); err != nil {
+			return 0, err
+		}
+	}
+	if err := data.DeleteNotificationPreferences(contact); err != nil {
+		return 0, err
+	}
+	if err := data.DeleteHousehold(contact); err != nil {
+		return 0, err
+	}
+	logger.LogInfo("%s erased %s: anonymized %d submissions", admin, contact, len(submissions))
+
+	waiting, err := data.ListPrivacyRequests(data.PrivacyPendingReview)
+	if err != nil {
+		return len(submissions), err
+	}
+	for _, request := range waiting {
+		if request.Email != contact {
+			continue
+		}
+		if _, err := data.ReviewPrivacyRequest(request.ID, data.PrivacyCompleted, admin, "", now); err != nil {
+			return len(submissions), err
+		}
+		if err := data.CompletePrivacyRequest(request.ID, now); err != nil {
+			return len(submissions), err
+		}
+		if err := sendOutcome(ctx, &request, email.PrivacyOutcomeData{Email: contact, Approved: true}); err != nil {
+			logger.LogError("Erased %s, but failed to tell them privacy request %d is done: %v", contact, request.ID, err)
+		}
+	}
+	return len(submissions), nil
+}
+
+// anonymize anonymizes a submission and rewrites its event order page without the
+// names it showed. Archived events have no order page left to rewrite.
+func anonymize(formType, formID string, archived bool, now time.Time) error {
+	if err := data.AnonymizeSubmission(formType, formID, now); err != nil {
+		return err
+	}
+	if formType == "event" && !archived {
+		rebuildOrderPage(formID)
+	}
+	return nil
+}
+
+// submissionsOf returns the submissions made with an email address in past school
+// years and this one, oldest first
+func submissionsOf(contact string) ([]data.SubmissionSummary, error) {
+	archived, err := data.ListArchivedSubmissionsByEmail(contact)
+	if err != nil {
+		return nil, err
+	}
+	live, err := data.ListSubmissionsByEmail(contact)
+	if err != nil {
+		return nil, err
+	}
+	return append(archived, live...), nil
+}
@@ -0,0 +1,111 @@
+package progress
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/logger"
+)
+
+// rateWindow counts one client's requests in the current minute
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	rateLimiterMu sync.Mutex
+	rateLimiter   = make(map[string]*rateWindow)
+)
+
+/*
+Handler serves the progress snapshot as JSON to any site. Responses may be cached by
+browsers and proxies for the cache TTL and carry an ETag, and each client is limited
+to the configured number of requests a minute.
+*/
+func Handler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	// The numbers are public, so any page may embed them
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings := config.LoadProgressSettings()
+	if retryAfter, ok := allow(logger.GetClientIP(r), settings.RateLimit); !ok {
+		logger.LogHTTPError(r, http.StatusTooManyRequests, fmt.Errorf("progress rate limit exceeded"))
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.999)))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	body, updatedAt, err := Current(settings)
+	if err != nil {
+		logger.LogHTTPError(r, http.StatusInternalServerError, err)
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	maxAge := settings.CacheTTL - clock.Since(updatedAt)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// allow counts a request from ip, reporting how long until the next is allowed when
+// the client has used up this minute's requests
+func allow(ip string, limit int) (time.Duration, bool) {
+	rateLimiterMu.Lock()
+	defer rateLimiterMu.Unlock()
+
+	now := clock.Now()
+	window := rateLimiter[ip]
+	if window == nil || now.Sub(window.start) >= time.Minute {
+		window = &rateWindow{start: now}
+		rateLimiter[ip] = window
+	}
+	if window.count >= limit {
+		return window.start.Add(time.Minute).Sub(now), false
+	}
+	window.count++
+	return 0, true
+}
+
+// PruneRateLimits drops per-client rate limit windows older than maxAge
+func PruneRateLimits(maxAge time.Duration) int {
+	rateLimiterMu.Lock()
+	defer rateLimiterMu.Unlock()
+
+	removed := 0
+	for ip, window := range rateLimiter {
+		if clock.Since(window.start) > maxAge {
+			delete(rateLimiter, ip)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,131 @@
+// Package progress serves the live numbers behind the public website's fundraising
+// progress bar: what the active fundraiser has raised against its goal and how many
+// memberships were paid this year. Only totals leave the server, never who gave.
+package progress
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+)
+
+// Snapshot is the body of the progress endpoint
+type Snapshot struct {
+	Fundraiser  *Fundraiser `json:"fundraiser"` // null when no fundraiser has a goal
+	Memberships Memberships `json:"memberships"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Fundraiser is the active fundraiser's progress towards its goal
+type Fundraiser struct {
+	Name      string  `json:"name,omitempty"`
+	Goal      float64 `json:"goal"`
+	Raised    float64 `json:"raised"`
+	Percent   float64 `json:"percent"` // of the goal, one decimal, may pass 100
+	Donations int     `json:"donations"`
+	Starts    string  `json:"starts"`
+	Ends      string  `json:"ends"`
+}
+
+// Memberships counts this year's paid memberships
+type Memberships struct {
+	Year    int          `json:"year"`
+	Total   int          `json:"total"`
+	ByLevel []LevelCount `json:"by_level"`
+}
+
+// LevelCount is the number of memberships of one level
+type LevelCount struct {
+	Level string `json:"level"`
+	Count int    `json:"count"`
+}
+
+var (
+	cacheMu       sync.Mutex
+	cachedBody    []byte
+	cachedAt      time.Time
+	cachedFor     config.ProgressSettings
+	cachedExpires time.Time
+)
+
+// Current returns the encoded snapshot, reusing it until the cache TTL runs out so a
+// busy page doesn't query the database on every view
+func Current(settings config.ProgressSettings) ([]byte, time.Time, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	now := clock.Now()
+	if cachedBody != nil && cachedFor == settings && now.Before(cachedExpires) {
+		return cachedBody, cachedAt, nil
+	}
+
+	snapshot, err := Build(settings, now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cachedBody, cachedAt, cachedFor, cachedExpires = body, now, settings, now.Add(settings.CacheTTL)
+	return body, now, nil
+}
+
+// Build computes the snapshot at now. The fundraiser runs from its start through its
+// end day in the club's time zone, this calendar year unless configured.
+func Build(settings config.ProgressSettings, now time.Time) (*Snapshot, error) {
+	local := now.In(clock.Location())
+	yearStart := time.Date(local.Year(), time.January, 1, 0, 0, 0, 0, clock.Location())
+	snapshot := &Snapshot{UpdatedAt: local}
+
+	if settings.Goal > 0 {
+		from := parseDay(settings.Start, "FUNDRAISER_START", yearStart)
+		to := parseDay(settings.End, "FUNDRAISER_END", yearStart.AddDate(1, 0, -1)).AddDate(0, 0, 1)
+		raised, donations, err := data.GetFundraiserTotals(from, to)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Fundraiser = &Fundraiser{
+			Name:      settings.FundraiserName,
+			Goal:      settings.Goal,
+			Raised:    math.Round(raised*100) / 100,
+			Percent:   math.Round(raised/settings.Goal*1000) / 10,
+			Donations: donations,
+			Starts:    from.Format("2006-01-02"),
+			Ends:      to.AddDate(0, 0, -1).Format("2006-01-02"),
+		}
+	}
+
+	counts, err := data.CountPaidMembershipsByLevel(yearStart, yearStart.AddDate(1, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Memberships = Memberships{Year: local.Year(), ByLevel: []LevelCount{}}
+	for level, count := range counts {
+		snapshot.Memberships.Total += count
+		snapshot.Memberships.ByLevel = append(snapshot.Memberships.ByLevel, LevelCount{Level: level, Count: count})
+	}
+	sort.Slice(snapshot.Memberships.ByLevel, func(i, j int) bool {
+		return snapshot.Memberships.ByLevel[i].Level < snapshot.Memberships.ByLevel[j].Level
+	})
+	return snapshot, nil
+}
+
+func parseDay(value, key string, defaultDay time.Time) time.Time {
+	if value == "" {
+		return defaultDay
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, clock.Location())
+	if err != nil {
+		logger.LogWarn("Invalid %s %q, using %s", key, value, defaultDay.Format("2006-01-02"))
+		return defaultDay
+	}
+	return day
+}
@@ -0,0 +1,406 @@
+// Package reconcile compares PayPal's transaction report with the payments recorded in
+// the database, so money PayPal moved but the database missed (or the other way round)
+// is found before the books are closed.
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/notify"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/scheduler"
+)
+
+const (
+	// DefaultSchedule reconciles the previous day early in the morning, once PayPal's
+	// report has caught up
+	DefaultSchedule = "30 4 * * *"
+
+	// Amounts closer than this are the same number of cents
+	amountTolerance = 0.005
+)
+
+// Mismatch kinds
+const (
+	MissingInPayPal = "missing_in_paypal" // paid in the database, no PayPal payment
+	MissingInDB     = "missing_in_db"     // PayPal payment with no matching submission
+	UnpaidInDB      = "unpaid_in_db"      // PayPal payment for a submission not marked paid
+	AmountMismatch  = "amount"            // paid amounts differ
+	StatusMismatch  = "status"            // refunded or failed on one side only
+)
+
+// Mismatch is one disagreement between PayPal and the database
+type Mismatch struct {
+	Kind          string
+	FormID        string
+	TransactionID string
+	DBAmount      float64
+	PayPalAmount  float64
+	DBStatus      string
+	PayPalStatus  string
+	Detail        string
+}
+
+// Report is the result of reconciling one date range
+type Report struct {
+	From, To     time.Time
+	Transactions int // PayPal transactions in the range
+	Submissions  int // submissions paid through PayPal in the range
+	Mismatches   []Mismatch
+}
+
+// Run fetches PayPal's transactions between from and to and compares them with the
+// submissions paid through PayPal in the same range
+func Run(ctx context.Context, from, to time.Time) (*Report, error) {
+	accessToken, err := payment.GetPayPalAccessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PayPal access token: %w", err)
+	}
+	transactions, err := payment.ListPayPalTransactions(ctx, accessToken, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	submissions, err := paidSubmissions(from, to)
+	if err != nil {
+		return nil, err
+	}
+	inRange := len(submissions)
+
+	// A payment near either end of the range, or a refund of an older payment, belongs
+	// to a submission paid outside it; look those up rather than reporting them missing
+	known := make(map[string]bool, len(submissions))
+	for _, sub := range submissions {
+		known[sub.FormID] = true
+	}
+	for _, transaction := range transactions {
+		formID, _ := data.ParseInstallmentInvoiceID(transaction.InvoiceID)
+		if formID == "" || known[formID] {
+			continue
+		}
+		known[formID] = true
+		sub, err := data.GetSubmissionSummary(formID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			// An invoice ID that isn't one of our form IDs is reported as missing
+			logger.LogWarn("Reconciliation could not look up %s: %v", formID, err)
+			continue
+		}
+		submissions = append(submissions, *sub)
+	}
+
+	return &Report{
+		From:         from,
+		To:           to,
+		Transactions: len(transactions),
+		Submissions:  inRange,
+		Mismatches:   Compare(from, to, submissions, transactions),
+	}, nil
+}
+
+// paidSubmissions returns the submissions whose PayPal payment was recorded between
+// from and to. Manual payments have no PayPal order and are left out.
+func paidSubmissions(from, to time.Time) ([]data.SubmissionSummary, error) {
+	var paid []data.SubmissionSummary
+	for _, status := range []string{"COMPLETED", "REFUNDED", data.DisputedStatus} {
+		submissions, err := data.ListSubmissions(data.SubmissionFilter{Status: status})
+		if err != nil {
+			return nil, err
+		}
+		for _, sub := range submissions {
+			if sub.PayPalOrderID != "" && paidBetween(sub, from, to) {
+				paid = append(paid, sub)
+			}
+		}
+	}
+	return paid, nil
+}
+
+// paidBetween reports whether sub's payment was recorded in [from, to); older rows
+// without a payment time fall back to the submission date
+func paidBetween(sub data.SubmissionSummary, from, to time.Time) bool {
+	paidAt := sub.SubmissionDate
+	if sub.SubmittedAt != nil {
+		paidAt = *sub.SubmittedAt
+	}
+	return !paidAt.Before(from) && paidAt.Before(to)
+}
+
+// recordedPaid reports whether a submission's status says PayPal took its payment,
+// including one since refunded or under dispute
+func recordedPaid(status string) bool {
+	return status == "COMPLETED" || status == "REFUNDED" || status == data.DisputedStatus
+}
+
+// Compare matches PayPal transactions between from and to with submissions by invoice
+// ID, which is the form ID, and returns every mismatch ordered by form ID. Submissions
+// may include ones paid outside the range; only those paid through PayPal within it
+// are reported missing when PayPal has no payment for them.
+func Compare(from, to time.Time, submissions []data.SubmissionSummary, transactions []payment.PayPalTransaction) []Mismatch {
+	var mismatches []Mismatch
+
+	byFormID := make(map[string]data.SubmissionSummary, len(submissions))
+	for _, sub := range submissions {
+		byFormID[sub.FormID] = sub
+	}
+
+	payments := make(map[string]payment.PayPalTransaction)
+	paymentInvoices := make(map[string]string) // payment transaction ID -> form ID
+	refunded := make(map[string]float64)
+	var refunds, installments []payment.PayPalTransaction
+	for _, transaction := range transactions {
+		if formID, number := data.ParseInstallmentInvoiceID(transaction.InvoiceID); number > 0 {
+			transaction.InvoiceID = formID
+			if transaction.Amount > 0 {
+				installments = append(installments, transaction)
+				paymentInvoices[transaction.ID] = formID
+				continue
+			}
+		}
+		switch {
+		case transaction.Amount > 0:
+			if transaction.InvoiceID == "" {
+				mismatches = append(mismatches, Mismatch{
+					Kind:          MissingInDB,
+					TransactionID: transaction.ID,
+					PayPalAmount:  transaction.Amount,
+					PayPalStatus:  transaction.Status,
+					Detail:        "PayPal payment has no invoice ID",
+				})
+				continue
+			}
+			payments[transaction.InvoiceID] = transaction
+			paymentInvoices[transaction.ID] = transaction.InvoiceID
+		case transaction.Amount < 0:
+			refunds = append(refunds, transaction)
+		}
+	}
+	for _, refund := range refunds {
+		formID := refund.InvoiceID
+		if formID == "" {
+			formID = paymentInvoices[refund.ReferenceID]
+		}
+		if formID != "" && refund.Status == "S" {
+			refunded[formID] += -refund.Amount
+		}
+	}
+
+	for _, formID := range sortedKeys(payments) {
+		transaction := payments[formID]
+		sub, ok := byFormID[formID]
+		mismatch := Mismatch{
+			FormID:        formID,
+			TransactionID: transaction.ID,
+			PayPalAmount:  transaction.Amount,
+			PayPalStatus:  transaction.Status,
+		}
+		if ok {
+			mismatch.DBAmount, mismatch.DBStatus = sub.CalculatedAmount, sub.PayPalStatus
+		}
+
+		switch {
+		case !ok:
+			mismatch.Kind, mismatch.Detail = MissingInDB, "no submission with this form ID"
+		case !recordedPaid(sub.PayPalStatus):
+			mismatch.Kind, mismatch.Detail = UnpaidInDB, "PayPal took the payment but the submission is not marked paid"
+		case transaction.Status != "S":
+			mismatch.Kind, mismatch.Detail = StatusMismatch, "PayPal payment is "+describeStatus(transaction.Status)
+		case math.Abs(transaction.Amount-sub.CalculatedAmount) > amountTolerance:
+			mismatch.Kind = AmountMismatch
+			mismatch.Detail = fmt.Sprintf("PayPal received $%.2f, submission is for $%.2f", transaction.Amount, sub.CalculatedAmount)
+		default:
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	// An installment is a part of the registration's total, so only its standing is checked
+	for _, transaction := range installments {
+		sub, ok := byFormID[transaction.InvoiceID]
+		mismatch := Mismatch{
+			FormID:        transaction.InvoiceID,
+			TransactionID: transaction.ID,
+			PayPalAmount:  transaction.Amount,
+			PayPalStatus:  transaction.Status,
+		}
+		if ok {
+			mismatch.DBAmount, mismatch.DBStatus = sub.CalculatedAmount, sub.PayPalStatus
+		}
+		switch {
+		case !ok:
+			mismatch.Kind, mismatch.Detail = MissingInDB, "no submission for this installment"
+		case !recordedPaid(sub.PayPalStatus) && sub.PayPalStatus != data.InstallmentsStatus:
+			mismatch.Kind, mismatch.Detail = UnpaidInDB, "PayPal took an installment but the submission is not marked paid"
+		case transaction.Status != "S":
+			mismatch.Kind, mismatch.Detail = StatusMismatch, "PayPal installment is "+describeStatus(transaction.Status)
+		default:
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	for _, sub := range submissions {
+		if _, ok := payments[sub.FormID]; ok || sub.PayPalOrderID == "" || !paidBetween(sub, from, to) {
+			continue
+		}
+		if !recordedPaid(sub.PayPalStatus) {
+			continue
+		}
+		// Paid by check, cash or credit after an order was started; PayPal never saw it
+		switch sub.FundingSource {
+		case payment.FundingCheck, payment.FundingCash, payment.FundingCredit:
+			continue
+		}
+		mismatches = append(mismatches, Mismatch{
+			Kind:     MissingInPayPal,
+			FormID:   sub.FormID,
+			DBAmount: sub.CalculatedAmount,
+			DBStatus: sub.PayPalStatus,
+			Detail:   "no PayPal payment for order " + sub.PayPalOrderID,
+		})
+	}
+
+	// Partial refunds leave a submission COMPLETED; only a full refund should show REFUNDED
+	for _, formID := range sortedKeys(refunded) {
+		sub, ok := byFormID[formID]
+		if !ok || sub.PayPalStatus != "COMPLETED" || refunded[formID] < sub.CalculatedAmount-amountTolerance {
+			continue
+		}
+		mismatches = append(mismatches, Mismatch{
+			Kind:         StatusMismatch,
+			FormID:       formID,
+			DBAmount:     sub.CalculatedAmount,
+			PayPalAmount: -refunded[formID],
+			DBStatus:     sub.PayPalStatus,
+			PayPalStatus: "REFUNDED",
+			Detail:       fmt.Sprintf("PayPal refunded $%.2f but the submission is still COMPLETED", refunded[formID]),
+		})
+	}
+
+	sort.SliceStable(mismatches, func(i, j int) bool {
+		return mismatches[i].FormID < mismatches[j].FormID
+	})
+	return mismatches
+}
+
+// NewJob returns a scheduler job that reconciles the previous local day and logs
+// each mismatch as a warning
+func NewJob() func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := clock.Now().In(clock.Location())
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		from := to.AddDate(0, 0, -1)
+
+		report, err := Run(ctx, from, to)
+		if err != nil {
+			return err
+		}
+
+		for _, mismatch := range report.Mismatches {
+			logger.LogWarn("Reconciliation mismatch (%s) for %s: %s", mismatch.Kind, describeForm(mismatch), mismatch.Detail)
+		}
+		if len(report.Mismatches) > 0 {
+			err := notification.Send(ctx, notification.Notification{
+				Template: mismatchReport,
+				Data:     report,
+				Recipients: []notification.Recipient{
+					notification.Staff(),
+					notification.Chat(notify.EventReconcileMismatch),
+					notification.Push(notify.EventReconcileMismatch),
+				},
+			})
+			if err != nil {
+				logger.LogWarn("Failed to report reconciliation mismatches: %v", err)
+			}
+		}
+		scheduler.Report(ctx, "reconciled %s: %d transactions, %d submissions, %d mismatches",
+			from.Format("2006-01-02"), report.Transactions, report.Submissions, len(report.Mismatches))
+		return nil
+	}
+}
+
+// mismatchReport tells the treasurer about a night's mismatches: every one by email,
+// and a short summary in chat and on admins' phones
+var mismatchReport = notification.Template{
+	Name: "reconciliation mismatches",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			report := d.(*Report)
+			var body strings.Builder
+			fmt.Fprintf(&body, "PayPal reconciliation for %s to %s found %d mismatches:\n\n",
+				report.From.Format("2006-01-02 15:04"), report.To.Format("2006-01-02 15:04"), len(report.Mismatches))
+			for _, mismatch := range report.Mismatches {
+				fmt.Fprintf(&body, "- %s (%s): %s\n", describeForm(mismatch), mismatch.Kind, mismatch.Detail)
+			}
+			fmt.Fprintf(&body, "\nRun boosterctl reconcile -from %s for the full report.\n", report.From.Format("2006-01-02"))
+			return notification.Message{
+				Subject: fmt.Sprintf("PayPal reconciliation: %d mismatches for %s", len(report.Mismatches), report.From.Format("2006-01-02")),
+				Body:    body.String(),
+			}, nil
+		},
+		notification.ChannelChat: func(d interface{}) (notification.Message, error) {
+			report := d.(*Report)
+			return notification.Message{Body: summarize(report.From, report.Mismatches)}, nil
+		},
+	},
+}
+
+// summarize describes the mismatches in a few lines, short enough for a push
+// notification; boosterctl reconcile lists them all
+func summarize(day time.Time, mismatches []Mismatch) string {
+	const shown = 3
+	noun := "mismatches"
+	if len(mismatches) == 1 {
+		noun = "mismatch"
+	}
+	text := fmt.Sprintf("Reconciliation of %s found %d %s", day.Format("2006-01-02"), len(mismatches), noun)
+	for i, mismatch := range mismatches {
+		if i == shown {
+			text += fmt.Sprintf("\n...and %d more", len(mismatches)-shown)
+			break
+		}
+		text += fmt.Sprintf("\n%s: %s", describeForm(mismatch), mismatch.Detail)
+	}
+	return text
+}
+
+func describeForm(mismatch Mismatch) string {
+	if mismatch.FormID != "" {
+		return mismatch.FormID
+	}
+	return "transaction " + mismatch.TransactionID
+}
+
+func describeStatus(status string) string {
+	switch status {
+	case "P":
+		return "pending"
+	case "V":
+		return "reversed"
+	case "D":
+		return "denied"
+	}
+	return "in status " + status
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,382 @@
+// Package replica continuously replicates the SQLite database to S3-compatible storage
+// or another directory, the way Litestream does, and restores it from there.
+//
+// A replica is a series of generations. Each starts with a snapshot of the database
+// and goes on with segments of the write-ahead log, every commit since, copied within
+// seconds of it being made. Restoring writes the newest snapshot and replays the
+// segments after it. While replicating, the replicator holds a read transaction open
+// so SQLite can't restart the log before its frames are copied, and checkpoints the
+// log itself once they are.
+package replica
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/scheduler"
+)
+
+// DefaultSchedule copies new commits every ten seconds
+const DefaultSchedule = "10s"
+
+// CheckpointFrames is how long the log grows before the replicator checkpoints it,
+// SQLite's own default
+const CheckpointFrames = 1000
+
+// abandonFrames is how long the log may grow while the replica can't be reached
+// before the replicator checkpoints it anyway, starting a new generation once the
+// replica is back rather than slowing every query down
+const abandonFrames = 10 * CheckpointFrames
+
+// closeTimeout bounds copying the last commits on shutdown
+const closeTimeout = 30 * time.Second
+
+// Replica object names. Generations are named for when they started, so they sort
+// oldest first.
+const (
+	generationsPrefix = "generations/"
+	snapshotName      = "snapshot.db.gz"
+	segmentsDir       = "wal/"
+	generationLayout  = "20060102T150405Z"
+)
+
+// errGenerationLost is a log restarted before all its commits were copied
+var errGenerationLost = errors.New("write-ahead log restarted before it was copied")
+
+// Replicator copies one SQLite database to a replica store
+type Replicator struct {
+	path             string // of the database; the log is beside it
+	store            Store
+	snapshotInterval time.Duration
+	retain           int
+
+	mu         sync.Mutex
+	hold       *sql.Tx // the read transaction keeping the log from restarting
+	generation string
+	startedAt  time.Time
+	segment    int       // number of the next segment
+	header     walHeader // of the log being copied; a zero page size before one exists
+	offset     int64     // copied up to here
+	checksum   [2]uint32 // running checksum at offset
+	restarted  bool      // the last checkpoint took exactly what was copied, so the log may restart
+}
+
+// New returns a replicator of the database at path into store. The first Sync starts
+// a generation.
+func New(path string, store Store, settings config.ReplicaSettings) *Replicator {
+	return &Replicator{
+		path:             path,
+		store:            store,
+		snapshotInterval: settings.SnapshotInterval,
+		retain:           max(settings.Retain, 1),
+	}
+}
+
+// Generation returns the name of the generation being replicated, if any
+func (r *Replicator) Generation() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// Sync copies the commits made since the last sync to the replica, starting a new
+// generation on the first sync, once the snapshot interval has passed, or when the
+// log restarted before it was copied. It is the replication job's run.
+func (r *Replicator) Sync(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generation != "" && clock.Since(r.startedAt) >= r.snapshotInterval {
+		if err := r.copyLog(ctx); err != nil {
+			logger.LogWarn("Failed to copy the end of replica generation %s: %v", r.generation, err)
+		}
+		r.generation = ""
+	}
+	if r.generation == "" {
+		return r.startGeneration(ctx)
+	}
+
+	err := r.copyLog(ctx)
+	if errors.Is(err, errGenerationLost) {
+		logger.LogWarn("Replica generation %s lost commits (%v); starting a new one", r.generation, err)
+		r.generation = ""
+		return r.startGeneration(ctx)
+	}
+	if err != nil {
+		if waiting := r.logFrames(); waiting >= abandonFrames {
+			logger.LogError("Replica unreachable with %d log frames waiting; checkpointing and starting a new generation once it is back", waiting)
+			r.generation = ""
+			r.checkpoint(ctx)
+		}
+		return err
+	}
+	if r.frames() >= CheckpointFrames {
+		r.checkpoint(ctx)
+	}
+	return nil
+}
+
+// Close copies the last commits and lets SQLite checkpoint the log again. Main calls
+// it after the background jobs stop and before the database closes.
+func (r *Replicator) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	if r.generation != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		err = r.copyLog(ctx)
+		cancel()
+	}
+	r.release()
+	return err
+}
+
+// startGeneration snapshots the database into a new generation, then copies the log
+// the snapshot was taken from, whose frames the replay after the snapshot starts with
+func (r *Replicator) startGeneration(ctx context.Context) error {
+	if err := r.holdLog(ctx); err != nil {
+		return err
+	}
+
+	name, err := newGenerationName()
+	if err != nil {
+		return err
+	}
+	snapshot, err := r.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	if err := r.store.Put(ctx, generationsPrefix+name+"/"+snapshotName, snapshot); err != nil {
+		return err
+	}
+
+	r.generation, r.startedAt, r.segment = name, clock.Now(), 1
+	r.header, r.restarted = walHeader{}, false
+	if err := r.copyLog(ctx); err != nil {
+		return err
+	}
+	logger.LogInfo("Started replica generation %s (%d byte snapshot)", name, len(snapshot))
+	scheduler.Report(ctx, "started replica generation %s", name)
+
+	if removed, err := r.prune(ctx); err != nil {
+		logger.LogWarn("Failed to delete old replica generations: %v", err)
+	} else if removed > 0 {
+		logger.LogInfo("Deleted %d old replica generations", removed)
+	}
+	return nil
+}
+
+// snapshot returns a compressed copy of the database, taken with SQLite's online
+// backup so the server carries on writing meanwhile
+func (r *Replicator) snapshot(ctx context.Context) ([]byte, error) {
+	path := r.path + ".replica-snapshot"
+	defer os.Remove(path)
+	if err := data.BackupDatabase(ctx, path); err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the snapshot: %w", err)
+	}
+	return compress(raw)
+}
+
+// copyLog uploads the log's commits after the copied offset as the generation's next
+// segment: the log's header, then the frames
+func (r *Replicator) copyLog(ctx context.Context) error {
+	walPath := r.path + "-wal"
+	header, err := readWALHeader(walPath)
+	if errors.Is(err, errNoWAL) {
+		return nil // nothing written since the last checkpoint
+	}
+	if err != nil {
+		return err
+	}
+
+	// A log that restarted is copied from its start, if nothing of the last was missed
+	if r.header.pageSize == 0 || !r.header.sameLog(header) {
+		if r.header.pageSize != 0 && !r.restarted {
+			return errGenerationLost
+		}
+		r.header, r.offset, r.checksum, r.restarted = header, walHeaderSize, header.checksum, false
+	}
+
+	read, err := readCommittedFrames(walPath, r.header, r.offset, r.checksum)
+	if err != nil || len(read.frames) == 0 {
+		return err
+	}
+	segment, err := compress(append(r.header.raw[:], read.frames...))
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s/%s%08d.wal.gz", generationsPrefix, r.generation, segmentsDir, r.segment)
+	if err := r.store.Put(ctx, key, segment); err != nil {
+		return err
+	}
+	r.segment++
+	r.offset, r.checksum, r.restarted = read.end, read.checksum, false
+	return nil
+}
+
+// frames returns how many frames of the log have been copied
+func (r *Replicator) frames() int64 {
+	if r.header.pageSize == 0 {
+		return 0
+	}
+	return (r.offset - walHeaderSize) / r.header.frameSize()
+}
+
+// logFrames returns how many frames the log file has room for, copied or not
+func (r *Replicator) logFrames() int64 {
+	info, err := os.Stat(r.path + "-wal")
+	if err != nil || r.header.pageSize == 0 {
+		return 0
+	}
+	return (info.Size() - walHeaderSize) / r.header.frameSize()
+}
+
+// checkpoint lets go of the log long enough to checkpoint it. When the checkpoint
+// took every frame and only those copied, the log may restart without losing any.
+func (r *Replicator) checkpoint(ctx context.Context) {
+	r.release()
+	logFrames, checkpointed, err := data.CheckpointWAL(ctx)
+	if err != nil {
+		logger.LogWarn("Failed to checkpoint the replicated database: %v", err)
+	}
+	r.restarted = err == nil && logFrames == checkpointed && int64(logFrames) == r.frames()
+	if err := r.holdLog(ctx); err != nil {
+		logger.LogWarn("Failed to hold the write-ahead log after a checkpoint: %v", err)
+	}
+}
+
+// holdLog opens the read transaction that keeps the log from restarting, if it isn't
+func (r *Replicator) holdLog(ctx context.Context) error {
+	if r.hold != nil {
+		return nil
+	}
+	hold, err := data.HoldWAL(ctx)
+	if err != nil {
+		return err
+	}
+	r.hold = hold
+	return nil
+}
+
+func (r *Replicator) release() {
+	if r.hold != nil {
+		r.hold.Rollback()
+		r.hold = nil
+	}
+}
+
+// prune deletes all but the newest generations the settings retain
+func (r *Replicator) prune(ctx context.Context) (int, error) {
+	generations, err := listGenerations(ctx, r.store)
+	if err != nil || len(generations) <= r.retain {
+		return 0, err
+	}
+	removed := 0
+	for _, generation := range generations[:len(generations)-r.retain] {
+		for _, key := range generation.keys {
+			if err := r.store.Delete(ctx, key); err != nil {
+				return removed, err
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// generation is one generation found in a replica
+type generation struct {
+	name     string
+	keys     []string // every object of it
+	snapshot bool     // whether its snapshot was uploaded; restores need one
+	segments []string // its log segments, in order
+}
+
+// listGenerations returns the generations in store, oldest first
+func listGenerations(ctx context.Context, store Store) ([]generation, error) {
+	keys, err := store.List(ctx, generationsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*generation)
+	var names []string
+	for _, key := range keys {
+		name, rest, ok := strings.Cut(strings.TrimPrefix(key, generationsPrefix), "/")
+		if !ok {
+			continue
+		}
+		g, seen := byName[name]
+		if !seen {
+			g = &generation{name: name}
+			byName[name] = g
+			names = append(names, name)
+		}
+		g.keys = append(g.keys, key)
+		switch {
+		case rest == snapshotName:
+			g.snapshot = true
+		case strings.HasPrefix(rest, segmentsDir) && strings.HasSuffix(rest, ".wal.gz"):
+			g.segments = append(g.segments, key)
+		}
+	}
+	sort.Strings(names)
+
+	generations := make([]generation, 0, len(names))
+	for _, name := range names {
+		g := byName[name]
+		sort.Strings(g.segments)
+		generations = append(generations, *g)
+	}
+	return generations, nil
+}
+
+// newGenerationName names a generation for when it starts, with a random suffix so
+// two servers briefly replicating during a restart don't share one
+func newGenerationName() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to name the replica generation: %w", err)
+	}
+	return clock.Now().UTC().Format(generationLayout) + "-" + hex.EncodeToString(suffix), nil
+}
+
+func compress(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(compressed []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,153 @@
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Restored describes a database written by Restore
+type Restored struct {
+	Generation string
+	Segments   int // log segments replayed after the snapshot
+	Size       int64
+}
+
+// Restore writes the database replicated in store to path, which must not exist yet:
+// the snapshot of the newest generation, or of the one named, with every commit copied
+// after it. The database is checked before it is moved to path, so a path that
+// exists afterwards holds a whole database.
+func Restore(ctx context.Context, store Store, path, generationName string) (*Restored, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists; restore to a new path and move it into place once the server is stopped", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	chosen, err := chooseGeneration(ctx, store, generationName)
+	if err != nil {
+		return nil, err
+	}
+
+	partial := path + ".partial"
+	removePartial := func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(partial + suffix)
+		}
+	}
+	removePartial()
+	if err := writeRestore(ctx, store, chosen, partial); err != nil {
+		removePartial()
+		return nil, err
+	}
+	if err := checkIntegrity(partial); err != nil {
+		removePartial()
+		return nil, err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		removePartial()
+		return nil, fmt.Errorf("failed to move the restored database into place: %w", err)
+	}
+	removePartial()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Restored{Generation: chosen.name, Segments: len(chosen.segments), Size: info.Size()}, nil
+}
+
+// chooseGeneration returns the generation called name, or the newest with a snapshot
+func chooseGeneration(ctx context.Context, store Store, name string) (generation, error) {
+	generations, err := listGenerations(ctx, store)
+	if err != nil {
+		return generation{}, err
+	}
+	for i := len(generations) - 1; i >= 0; i-- {
+		g := generations[i]
+		if name != "" && g.name != name {
+			continue
+		}
+		if !g.snapshot {
+			if name != "" {
+				return generation{}, fmt.Errorf("replica generation %s has no snapshot", name)
+			}
+			continue // started but never finished uploading its snapshot
+		}
+		return g, nil
+	}
+	if name != "" {
+		return generation{}, fmt.Errorf("no replica generation %s", name)
+	}
+	return generation{}, fmt.Errorf("the replica has no generation to restore")
+}
+
+// writeRestore writes g's snapshot to path and replays its segments over it
+func writeRestore(ctx context.Context, store Store, g generation, path string) error {
+	compressed, err := store.Get(ctx, generationsPrefix+g.name+"/"+snapshotName)
+	if err != nil {
+		return err
+	}
+	snapshot, err := decompress(compressed)
+	if err != nil {
+		return fmt.Errorf("failed to decompress the snapshot: %w", err)
+	}
+	if len(snapshot) < 100 {
+		return fmt.Errorf("snapshot of generation %s is not a database", g.name)
+	}
+	pageSize := int(binary.BigEndian.Uint16(snapshot[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create the restored database: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(snapshot); err != nil {
+		return fmt.Errorf("failed to write the snapshot: %w", err)
+	}
+
+	for _, key := range g.segments {
+		compressed, err := store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		segment, err := decompress(compressed)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", key, err)
+		}
+		if len(segment) >= walHeaderSize && int(binary.BigEndian.Uint32(segment[8:])) != pageSize {
+			return fmt.Errorf("%s has pages of %d bytes but the snapshot %d", key, binary.BigEndian.Uint32(segment[8:]), pageSize)
+		}
+		if err := applyFrames(file, segment); err != nil {
+			return fmt.Errorf("failed to replay %s: %w", key, err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write the restored database: %w", err)
+	}
+	return file.Close()
+}
+
+// checkIntegrity opens the database at path and runs SQLite's integrity check on it
+func checkIntegrity(path string) error {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open the restored database: %w", err)
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("failed to check the restored database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("restored database failed its integrity check: %s", result)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,121 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sbcbackend/internal/config"
+)
+
+// Store is where a replica's objects are kept. Keys are slash-separated paths below
+// the store's own prefix.
+type Store interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error) // an error wrapping fs.ErrNotExist for a missing key
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// OpenStore returns the store settings name: an S3-compatible bucket for s3:// URLs,
+// otherwise a directory, such as a mounted network drive
+func OpenStore(settings config.ReplicaSettings) (Store, error) {
+	if !settings.Enabled() {
+		return nil, fmt.Errorf("no replica is configured; set %s", config.EnvSettingName("DB_REPLICA_URL"))
+	}
+	if rest, ok := strings.CutPrefix(settings.URL, "s3://"); ok {
+		return newS3Store(rest, settings)
+	}
+	return DirStore(strings.TrimPrefix(settings.URL, "file://")), nil
+}
+
+// DirStore keeps a replica's objects as files below a directory
+type DirStore string
+
+func (d DirStore) path(key string) (string, error) {
+	if !fs.ValidPath(key) {
+		return "", fmt.Errorf("invalid replica key %q", key)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(key)), nil
+}
+
+func (d DirStore) Put(ctx context.Context, key string, body []byte) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create replica directory: %w", err)
+	}
+	// Written beside the key and renamed, so a key is never seen half written
+	partial := path + ".partial"
+	if err := os.WriteFile(partial, body, 0640); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+func (d DirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return body, nil
+}
+
+func (d DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(string(d), func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() || strings.HasSuffix(path, ".partial") {
+			return err
+		}
+		rel, err := filepath.Rel(string(d), path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the replica: %w", err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (d DirStore) Delete(ctx context.Context, key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	// Directories left empty go too, so deleted generations leave nothing behind
+	root := filepath.Clean(string(d))
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
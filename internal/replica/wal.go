@@ -0,0 +1,188 @@
+package replica
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SQLite's write-ahead log is a 32-byte header followed by frames, each a 24-byte
+// header and one page. See https://www.sqlite.org/fileformat.html#the_write_ahead_log.
+const (
+	walHeaderSize   = 32
+	frameHeaderSize = 24
+
+	walMagicLittleEndian = 0x377f0682 // checksums read the log as little-endian words
+	walMagicBigEndian    = 0x377f0683
+)
+
+// errNoWAL is a log that is missing, empty or still having its header written
+var errNoWAL = errors.New("no write-ahead log")
+
+// walHeader is the header of the write-ahead log SQLite is writing. The salts change
+// each time the log restarts, which is how a copied position is known to still be in
+// the same log.
+type walHeader struct {
+	raw      [walHeaderSize]byte
+	pageSize uint32
+	salt1    uint32
+	salt2    uint32
+	checksum [2]uint32 // of the header, which the first frame's checksum continues from
+}
+
+func (h walHeader) bigEndian() bool {
+	return binary.BigEndian.Uint32(h.raw[0:]) == walMagicBigEndian
+}
+
+func (h walHeader) frameSize() int64 {
+	return frameHeaderSize + int64(h.pageSize)
+}
+
+func (h walHeader) sameLog(other walHeader) bool {
+	return h.salt1 == other.salt1 && h.salt2 == other.salt2
+}
+
+// readWALHeader reads and checks the header of the log at path
+func readWALHeader(path string) (walHeader, error) {
+	var h walHeader
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, errNoWAL
+	}
+	if err != nil {
+		return h, fmt.Errorf("failed to open the WAL: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.ReadFull(file, h.raw[:]); err != nil {
+		return h, errNoWAL
+	}
+	return parseWALHeader(h.raw[:])
+}
+
+// parseWALHeader parses a log header, refusing one whose checksum doesn't match
+func parseWALHeader(raw []byte) (walHeader, error) {
+	var h walHeader
+	if len(raw) < walHeaderSize {
+		return h, errNoWAL
+	}
+	copy(h.raw[:], raw)
+	magic := binary.BigEndian.Uint32(raw[0:])
+	if magic != walMagicLittleEndian && magic != walMagicBigEndian {
+		return h, errNoWAL
+	}
+	h.pageSize = binary.BigEndian.Uint32(raw[8:])
+	h.salt1 = binary.BigEndian.Uint32(raw[16:])
+	h.salt2 = binary.BigEndian.Uint32(raw[20:])
+	h.checksum = [2]uint32{binary.BigEndian.Uint32(raw[24:]), binary.BigEndian.Uint32(raw[28:])}
+	if h.pageSize < 512 || h.pageSize&(h.pageSize-1) != 0 {
+		return h, fmt.Errorf("WAL header has invalid page size %d", h.pageSize)
+	}
+	if walChecksum(h.bigEndian(), raw[:24], [2]uint32{}) != h.checksum {
+		return h, errNoWAL // a header SQLite hasn't finished writing
+	}
+	return h, nil
+}
+
+// walChecksum continues SQLite's running checksum over b, a multiple of 8 bytes
+func walChecksum(bigEndian bool, b []byte, sum [2]uint32) [2]uint32 {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		sum[0] += order.Uint32(b[i:]) + sum[1]
+		sum[1] += order.Uint32(b[i+4:]) + sum[0]
+	}
+	return sum
+}
+
+// walRead is the committed frames read from a log, ending at its last commit
+type walRead struct {
+	frames   []byte
+	end      int64     // offset after the last committed frame
+	checksum [2]uint32 // running checksum at end
+}
+
+// readCommittedFrames reads the frames of the log at path after offset, where the
+// running checksum was sum, up to the last one that commits a transaction. A frame from
+// an earlier log or one still being written ends the read.
+func readCommittedFrames(path string, h walHeader, offset int64, sum [2]uint32) (walRead, error) {
+	read := walRead{end: offset, checksum: sum}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return read, nil
+	}
+	if err != nil {
+		return read, fmt.Errorf("failed to open the WAL: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return read, fmt.Errorf("failed to read the WAL: %w", err)
+	}
+	if info.Size() <= offset {
+		return read, nil
+	}
+	rest := make([]byte, info.Size()-offset)
+	n, err := file.ReadAt(rest, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return read, fmt.Errorf("failed to read the WAL: %w", err)
+	}
+	rest = rest[:n]
+
+	frameSize := h.frameSize()
+	committed := 0
+	for pos := int64(0); pos+frameSize <= int64(len(rest)); pos += frameSize {
+		frame := rest[pos : pos+frameSize]
+		if binary.BigEndian.Uint32(frame[8:]) != h.salt1 || binary.BigEndian.Uint32(frame[12:]) != h.salt2 {
+			break
+		}
+		sum = walChecksum(h.bigEndian(), frame[:8], sum)
+		sum = walChecksum(h.bigEndian(), frame[frameHeaderSize:], sum)
+		if sum != [2]uint32{binary.BigEndian.Uint32(frame[16:]), binary.BigEndian.Uint32(frame[20:])} {
+			break
+		}
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			committed = int(pos + frameSize)
+			read.checksum = sum
+		}
+	}
+	read.frames = rest[:committed]
+	read.end = offset + int64(committed)
+	return read, nil
+}
+
+// applyFrames writes the pages of a log segment, its header then frames, into the
+// database file, resizing it to each commit's size as SQLite's checkpoint would
+func applyFrames(db *os.File, segment []byte) error {
+	if len(segment) < walHeaderSize {
+		return fmt.Errorf("segment is too short for a WAL header")
+	}
+	h, err := parseWALHeader(segment[:walHeaderSize])
+	if err != nil {
+		return fmt.Errorf("segment has no valid WAL header: %w", err)
+	}
+	frames := segment[walHeaderSize:]
+	frameSize := h.frameSize()
+	if int64(len(frames))%frameSize != 0 {
+		return fmt.Errorf("segment holds a partial frame")
+	}
+
+	for pos := int64(0); pos < int64(len(frames)); pos += frameSize {
+		frame := frames[pos : pos+frameSize]
+		page := int64(binary.BigEndian.Uint32(frame[0:]))
+		if _, err := db.WriteAt(frame[frameHeaderSize:], (page-1)*int64(h.pageSize)); err != nil {
+			return fmt.Errorf("failed to write page %d: %w", page, err)
+		}
+		if pages := int64(binary.BigEndian.Uint32(frame[4:])); pages != 0 {
+			if err := db.Truncate(pages * int64(h.pageSize)); err != nil {
+				return fmt.Errorf("failed to resize the database: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package restart
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+
+	"sbcbackend/internal/logger"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to an activated service
+const listenFDsStart = 3
+
+// Listen opens the socket the server accepts connections on. A socket handed over by
+// a restarting process wins, then one passed in by systemd (LISTEN_FDS), then a unix
+// socket at SERVER_SOCKET, then TCP on addr.
+func Listen(addr string) (net.Listener, error) {
+	ln, err := InheritedListener(EnvListenFD)
+	if err != nil || ln != nil {
+		return ln, err
+	}
+	ln, err = SystemdListener()
+	if err != nil || ln != nil {
+		return ln, err
+	}
+	if path := os.Getenv("SERVER_SOCKET"); path != "" {
+		return UnixListener(path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ListenRedirect opens the plain HTTP listener for TLS redirects, reusing the one a
+// restarting process handed over
+func ListenRedirect(addr string) (net.Listener, error) {
+	ln, err := InheritedListener(EnvHTTPListenFD)
+	if err != nil || ln != nil {
+		return ln, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+// SystemdListener returns the first socket systemd activated the service with, or nil
+// when the process was not socket activated
+func SystemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+
+	// Child processes must not think the sockets are meant for them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if count > 1 {
+		logger.LogWarn("systemd passed %d sockets; only the first is used", count)
+	}
+	syscall.CloseOnExec(listenFDsStart)
+	file := os.NewFile(uintptr(listenFDsStart), "systemd-socket")
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use systemd socket: %w", err)
+	}
+	logger.LogInfo("Using socket from systemd activation: %s", ln.Addr())
+	return ln, nil
+}
+
+// UnixListener listens on a unix socket at path, replacing a stale socket left by an
+// unclean exit. SERVER_SOCKET_MODE sets its permissions (octal, default 0660) so the
+// proxy's group can connect.
+func UnixListener(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	mode := os.FileMode(0660)
+	if value := os.Getenv("SERVER_SOCKET_MODE"); value != "" {
+		parsed, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SERVER_SOCKET_MODE %q: %w", value, err)
+		}
+		mode = os.FileMode(parsed)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,224 @@
+// Package restart hands a server's listening sockets to a new copy of its binary, so
+// it can be replaced without refusing a connection
+package restart
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/logger"
+)
+
+// Environment variables a restarting process uses to hand its sockets to the new one
+const (
+	EnvListenFD     = "SERVER_LISTEN_FD"
+	EnvHTTPListenFD = "SERVER_HTTP_LISTEN_FD"
+	EnvReadyFD      = "SERVER_READY_FD"
+	EnvParentFD     = "SERVER_PARENT_FD"
+)
+
+// Timeout is how long the new process has to start serving before the restart is
+// abandoned and the old process carries on
+const Timeout = 30 * time.Second
+
+var (
+	parentMu sync.Mutex
+	// parentW is held open until this process exits once it has handed off, so the
+	// new process sees the read end close when it does
+	parentW *os.File
+)
+
+// Handoff starts a new copy of the binary serving on the same listeners and waits
+// until it accepts connections. Both processes serve until the caller drains and
+// exits, so no connection is refused and in-flight captures finish on the old binary.
+// Under systemd the unit needs KillMode=process so the new process outlives the old.
+func Handoff(main, redirect net.Listener) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	exitR, exitW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	defer exitR.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = Env()
+
+	// ExtraFiles[i] becomes fd 3+i in the child
+	inherit := func(key string, file *os.File) {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", key, 3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+	}
+	for _, ln := range []struct {
+		key string
+		ln  net.Listener
+	}{{EnvListenFD, main}, {EnvHTTPListenFD, redirect}} {
+		if ln.ln == nil {
+			continue
+		}
+		file, err := listenerFile(ln.ln)
+		if err != nil {
+			readyW.Close()
+			exitW.Close()
+			return err
+		}
+		defer file.Close()
+		inherit(ln.key, file)
+	}
+	inherit(EnvReadyFD, readyW)
+	inherit(EnvParentFD, exitR)
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		exitW.Close()
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	logger.LogInfo("Started new process %d, waiting for it to serve", cmd.Process.Pid)
+
+	// The pipe closes without a message if the new process dies during startup
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			exitW.Close()
+			cmd.Wait()
+			return fmt.Errorf("new process exited before serving")
+		}
+	case <-time.After(Timeout):
+		exitW.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new process was not serving after %v", Timeout)
+	}
+
+	// The socket file now belongs to the new process
+	if ul, ok := main.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	parentMu.Lock()
+	parentW = exitW
+	parentMu.Unlock()
+	logger.LogInfo("New process %d is serving", cmd.Process.Pid)
+	return nil
+}
+
+// Env is this process's environment without the handoff variables from an earlier
+// restart, for starting other processes of the binary
+func Env() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case EnvListenFD, EnvHTTPListenFD, EnvReadyFD, EnvParentFD:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+func listenerFile(ln net.Listener) (*os.File, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("cannot hand off %T listener", ln)
+	}
+	return filer.File()
+}
+
+// InheritedListener returns the listener a restarting parent passed in the fd named by
+// the environment variable key, or nil when there is none
+func InheritedListener(key string) (net.Listener, error) {
+	fd, ok, err := inheritedFD(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	file := os.NewFile(fd, key)
+	defer file.Close()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use inherited socket: %w", err)
+	}
+	// The socket file is this process's to remove now, unless it hands it on again
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+	logger.LogInfo("Using socket inherited from previous process: %s", ln.Addr())
+	return ln, nil
+}
+
+// SignalReady tells the parent that handed over the listeners that this process is
+// serving, so it can drain and exit
+func SignalReady() {
+	fd, ok, err := inheritedFD(EnvReadyFD)
+	if err != nil || !ok {
+		return
+	}
+	file := os.NewFile(fd, EnvReadyFD)
+	defer file.Close()
+	if _, err := file.Write([]byte{1}); err != nil {
+		logger.LogWarn("Failed to signal readiness to previous process: %v", err)
+	}
+}
+
+// ParentExited returns a channel closed once the parent that handed over the listeners
+// has exited, or nil when this process wasn't started by a restart. Work only one
+// process may do at a time, such as background jobs, waits for it.
+func ParentExited() <-chan struct{} {
+	fd, ok, err := inheritedFD(EnvParentFD)
+	if err != nil {
+		logger.LogWarn("Ignoring the previous process's exit pipe: %v", err)
+	}
+	if !ok || err != nil {
+		return nil
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		// The parent never writes; the read returns once its end closes as it exits
+		file := os.NewFile(fd, EnvParentFD)
+		io.Copy(io.Discard, file)
+		file.Close()
+		close(exited)
+	}()
+	return exited
+}
+
+func inheritedFD(key string) (uintptr, bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false, nil
+	}
+	// Anything this process starts must not think the descriptor is meant for it
+	os.Unsetenv(key)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return 0, false, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return uintptr(fd), true, nil
+}
@@ -0,0 +1,230 @@
+// internal/router/router.go
+package router
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/archive"
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/backup"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/export"
+	"sbcbackend/internal/form"
+	"sbcbackend/internal/health"
+	"sbcbackend/internal/household"
+	"sbcbackend/internal/importer"
+	"sbcbackend/internal/info"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypalsim"
+	"sbcbackend/internal/privacy"
+	"sbcbackend/internal/progress"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/search"
+	"sbcbackend/internal/security"
+	"sbcbackend/internal/static"
+	"sbcbackend/internal/webhook"
+)
+
+// Routes sets up all API routes with appropriate middleware
+func Routes(jobs *scheduler.Scheduler) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", health.LiveHandler)
+	mux.HandleFunc("/readyz", health.ReadyHandler)
+
+	apiMux := http.NewServeMux()
+
+	// Protected endpoints - require full API middleware (token validation, rate limiting, etc.)
+	apiMux.Handle("/order-details", middleware.APIMiddleware(order.GetPaymentDetailsHandler))
+	apiMux.Handle("/save-event-payment", middleware.APIMiddleware(payment.SaveEventPaymentHandler))
+	apiMux.Handle("/save-membership-payment", middleware.APIMiddleware(payment.SaveMembershipPaymentHandler))
+	apiMux.Handle("/create-order", middleware.IdempotentAPIMiddleware("create-order", payment.CreatePayPalOrderHandler))
+	apiMux.Handle("/capture-order", middleware.IdempotentAPIMiddleware("capture-order", payment.CapturePayPalOrderHandler))
+	apiMux.Handle("/confirm-payment-source", middleware.IdempotentAPIMiddleware("confirm-payment-source", payment.ConfirmPaymentSourceHandler))
+	apiMux.Handle("/apply-credit", middleware.APIMiddleware(payment.ApplyCreditHandler))
+	apiMux.Handle("/cancel-order", middleware.APIMiddleware(payment.CancelOrderHandler))
+	apiMux.Handle("/payment-status", middleware.APIMiddleware(payment.PaymentStatusHandler))
+	apiMux.Handle("/change-event-order", middleware.APIMiddleware(payment.ChangeEventOrderHandler))
+	apiMux.Handle("/capture-event-change", middleware.APIMiddleware(payment.CaptureEventChangeHandler))
+	apiMux.Handle("/success", middleware.APIMiddleware(order.GetSuccessPageHandler))
+	apiMux.Handle("/token-info", middleware.APIMiddleware(security.AccessTokenInfoHandler))
+
+	// Special endpoints - keep existing behavior
+	apiMux.HandleFunc("/submit-form", form.SubmitFormHandler)                     // Has its own validation
+	apiMux.HandleFunc("/resume-checkout", form.ResumeCheckoutHandler)             // Validates the reminder's resume token
+	apiMux.HandleFunc("/receipt", order.ReceiptHandler)                           // Validates the receipt link's token
+	apiMux.HandleFunc("/privacy-request", privacy.RequestHandler)                 // Has its own CSRF check
+	apiMux.HandleFunc("/privacy", privacy.PageHandler)                            // Validates the verification link's code
+	apiMux.HandleFunc("/privacy/export", privacy.ExportHandler)                   // Validates the verification link's code
+	apiMux.HandleFunc("/household/sign-in", household.SignInHandler)              // Has its own CSRF check
+	apiMux.HandleFunc("/household", household.PageHandler)                        // Validates the sign-in link's code
+	apiMux.HandleFunc("/household/prefill", household.PrefillHandler)             // Validates the sign-in link's code
+	apiMux.HandleFunc("/paypal-webhook", webhook.PayPalWebhookHandler)            // External webhook
+	apiMux.HandleFunc("/csrf-token", security.CSRFTokenHandler)                   // Public endpoint
+	apiMux.HandleFunc("/quick-donate", payment.QuickDonateHandler)                // Has its own CSRF check
+	apiMux.HandleFunc("/quick-donate/capture", payment.QuickDonateCaptureHandler) // Needs the donation's PayPal order
+	apiMux.HandleFunc("/progress", progress.Handler)                              // Public, cached and rate limited
+
+	// Admin endpoints - require an admin token issued by the info page
+	apiMux.HandleFunc("/admin/jobs", middleware.RequireAdmin("job status", jobs.AdminJobsHandler))
+	apiMux.HandleFunc("/refund-order", middleware.RequireAdmin("refunds", payment.RefundOrderHandler))
+	apiMux.HandleFunc("/admin/maintenance", middleware.RequireAdmin("maintenance mode", middleware.AdminMaintenanceHandler))
+	apiMux.HandleFunc("/admin/unmatched-payments", middleware.RequireAdmin("unmatched payments", payment.UnmatchedPaymentsHandler))
+	apiMux.HandleFunc("/admin/webhook-events", middleware.RequireAdmin("webhook events", webhook.AdminEventsHandler))
+	apiMux.HandleFunc("/admin/credits", middleware.RequireAdmin("credits", payment.AdminCreditsHandler))
+	apiMux.HandleFunc("/admin/audit-log", middleware.RequireAdmin("audit log", payment.AdminAuditLogHandler))
+	apiMux.HandleFunc("/admin/backups", middleware.RequireAdmin("backups", backup.AdminHandler))
+	apiMux.HandleFunc("/admin/db-stats", middleware.RequireAdmin("database stats", health.DBStatsHandler))
+	apiMux.HandleFunc(export.Prefix, middleware.RequireAdmin("export", export.Handler))
+	apiMux.HandleFunc("/admin/search", middleware.RequireAdmin("search", search.Handler))
+	apiMux.HandleFunc("/admin/history", middleware.RequireAdmin("submission history", search.HistoryHandler))
+	apiMux.HandleFunc("/admin/privacy/erase", middleware.RequireAdmin("privacy erase", privacy.AdminEraseHandler))
+	apiMux.HandleFunc("/admin/archive", middleware.RequireAdmin("the archive", archive.Handler))
+	apiMux.HandleFunc("/admin/import", middleware.RequireAdmin("import", importer.Handler))
+	apiMux.HandleFunc("/admin/offline-payment", middleware.RequireAdmin("offline payments", payment.AdminOfflinePaymentHandler))
+	apiMux.HandleFunc("/admin/capture-order", middleware.RequireAdmin("order capture", payment.AdminCaptureOrderHandler))
+	apiMux.HandleFunc("/admin/paypal-simulator", middleware.RequireAdmin("the PayPal simulator", paypalsim.AdminHandler))
+
+	// Test endpoint with basic middleware (no token required)
+	apiMux.Handle("/test-email", middleware.RequestID(middleware.Logging(func(w http.ResponseWriter, r *http.Request) {
+		if err := email.TestEmailFunctionality(); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "email_test_failed",
+				"Email test failed", err.Error())
+			return
+		}
+		middleware.WriteAPISuccess(w, r, map[string]string{
+			"message": "✅ Email tests completed successfully! Check your application logs to see the mock emails.",
+		})
+	})))
+
+	mux.Handle("/api/", http.StripPrefix("/api", apiMux))
+
+	// Generated order pages change when an order does, so they are revalidated on
+	// every visit; they carry customer details and are kept out of search engines
+	mux.Handle("/events/", http.StripPrefix("/events", static.Handler(config.EventOrdersPath(), static.Options{
+		CacheControl: "private, no-cache",
+		NoIndex:      true,
+	})))
+	mux.Handle("/static/", http.StripPrefix("/static", static.Handler(config.StaticAssetsPath(), static.Options{
+		CacheControl: static.CacheFor(config.StaticAssetsMaxAge()),
+		Fallback:     assets.Static(),
+	})))
+	mux.HandleFunc("/info", info.InfoPageHandler)
+	mux.HandleFunc("/info/kitchen", info.KitchenReportHandler)
+
+	return mux
+}
+
+// Handler assembles all middleware around the mux. Extra middleware (such as the
+// server's connection tracking) runs inside request logging and the timeout.
+func Handler(mux http.Handler, extra ...func(http.Handler) http.Handler) http.Handler {
+	handler := mux
+	handler = security.AddCORSHeaders(handler)
+	handler = withCustom404(handler)
+	handler = middleware.MaintenanceMode(handler)
+	for _, wrap := range extra {
+		handler = wrap(handler)
+	}
+	handler = logRequests(handler)
+	handler = withTimeout(handler, RequestTimeout())
+
+	return handler
+}
+
+var (
+	requestTimeoutMu sync.Mutex
+	requestTimeout   = 15 * time.Second
+)
+
+// SetRequestTimeout changes how long a handler may run before the client gets a
+// timeout, returning the previous value. It applies to handlers built afterwards.
+func SetRequestTimeout(timeout time.Duration) time.Duration {
+	requestTimeoutMu.Lock()
+	defer requestTimeoutMu.Unlock()
+	previous := requestTimeout
+	requestTimeout = timeout
+	return previous
+}
+
+// RequestTimeout returns the handler deadline used by Handler
+func RequestTimeout() time.Duration {
+	requestTimeoutMu.Lock()
+	defer requestTimeoutMu.Unlock()
+	return requestTimeout
+}
+
+// Middleware: timeout handler
+func withTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+	return http.TimeoutHandler(h, timeout, "Request timed out")
+}
+
+// Middleware: log requests
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		h.ServeHTTP(w, r)
+
+		duration := time.Since(start)
+		logger.LogInfo("%s %s took %v", r.Method, r.URL.Path, duration)
+	})
+}
+
+var notFoundTmpl = template.Must(template.New("not_found.html.tmpl").
+	ParseFS(assets.Templates(), "not_found.html.tmpl"))
+
+// Middleware: custom 404 page
+func withCustom404(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Use a custom response writer to capture the status code
+		crw := &captureResponseWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		// Let the handler chain process the request
+		h.ServeHTTP(crw, r)
+
+		// Check if a 404 was encountered
+		if crw.statusCode == http.StatusNotFound {
+			logger.LogInfo("404 not found: %s", r.URL.Path)
+
+			// Reset headers to avoid conflicts
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			notFoundTmpl.Execute(w, nil)
+		}
+	})
+}
+
+// captureResponseWriter tracks status code without writing to the underlying response writer
+type captureResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	written    bool
+}
+
+func (crw *captureResponseWriter) WriteHeader(code int) {
+	if !crw.written {
+		crw.statusCode = code
+		crw.written = true
+		crw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (crw *captureResponseWriter) Write(b []byte) (int, error) {
+	if !crw.written {
+		crw.WriteHeader(http.StatusOK)
+	}
+	return crw.ResponseWriter.Write(b)
+}
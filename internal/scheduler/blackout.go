@@ -0,0 +1,126 @@
+// internal/scheduler/blackout.go
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/clock"
+)
+
+// blackoutWindow is a daily time range, in local time, during which a job must not start
+type blackoutWindow struct {
+	start int // minutes after midnight
+	end   int // minutes after midnight; less than start when the window crosses midnight
+}
+
+// parseBlackout parses a comma-separated list of "HH:MM-HH:MM" windows, e.g.
+// "18:00-21:00" or "18:00-21:00,23:30-00:30". An empty spec means no blackout.
+func parseBlackout(spec string) ([]blackoutWindow, error) {
+	var windows []blackoutWindow
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.Split(part, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("blackout window %q must be HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("blackout window %q: %w", part, err)
+		}
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("blackout window %q: %w", part, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("blackout window %q is empty", part)
+		}
+
+		windows = append(windows, blackoutWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// endAfter returns when the window containing t ends, or the zero time if t is outside it
+func (w blackoutWindow) endAfter(t time.Time) time.Time {
+	minute := t.Hour()*60 + t.Minute()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	if w.start < w.end {
+		if minute >= w.start && minute < w.end {
+			return midnight.Add(time.Duration(w.end) * time.Minute)
+		}
+		return time.Time{}
+	}
+
+	// Window crosses midnight
+	switch {
+	case minute >= w.start:
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(w.end) * time.Minute)
+	case minute < w.end:
+		return midnight.Add(time.Duration(w.end) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// deferPastBlackout moves t to the end of any blackout window it falls in. Windows
+// that overlap or abut are followed until t lands outside all of them.
+func deferPastBlackout(t time.Time, windows []blackoutWindow) time.Time {
+	// Bounded so a misconfiguration covering the whole day can't loop forever
+	for i := 0; i < 2*len(windows)+1; i++ {
+		moved := false
+		for _, w := range windows {
+			if end := w.endAfter(t.In(clock.Location())); !end.IsZero() {
+				t = end
+				moved = true
+			}
+		}
+		if !moved {
+			return t
+		}
+	}
+	return t
+}
+
+// coversWholeDay reports whether the windows leave no minute of the day free
+func coversWholeDay(windows []blackoutWindow) bool {
+	if len(windows) == 0 {
+		return false
+	}
+	day := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for minute := 0; minute < 24*60; minute++ {
+		t := day.Add(time.Duration(minute) * time.Minute)
+		blocked := false
+		for _, w := range windows {
+			if !w.endAfter(t).IsZero() {
+				blocked = true
+				break
+			}
+		}
+		if !blocked {
+			return false
+		}
+	}
+	return true
+}
+
+func formatBlackout(windows []blackoutWindow) string {
+	parts := make([]string, len(windows))
+	for i, w := range windows {
+		parts[i] = fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+	}
+	return strings.Join(parts, ",")
+}
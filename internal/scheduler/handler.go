@@ -0,0 +1,52 @@
+// internal/scheduler/handler.go
+package scheduler
+
+import (
+	"errors"
+	"net/http"
+
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// AdminJobsHandler lists job status on GET and queues a manual run on POST (?job=<name>).
+// It requires an admin token issued by the info page.
+func (s *Scheduler) AdminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		middleware.WriteAPISuccess(w, r, map[string]interface{}{
+			"jobs": s.Status(),
+		})
+
+	case http.MethodPost:
+		name := r.URL.Query().Get("job")
+		if name == "" {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_job", "Missing job parameter", "")
+			return
+		}
+
+		if err := s.RunNow(name); err != nil {
+			switch {
+			case errors.Is(err, ErrJobNotFound):
+				middleware.WriteAPIError(w, r, http.StatusNotFound, "job_not_found", "Job not found", name)
+			case errors.Is(err, ErrJobQueued):
+				middleware.WriteAPIError(w, r, http.StatusConflict, "job_queued", "Job is already queued to run", name)
+			default:
+				middleware.WriteAPIError(w, r, http.StatusInternalServerError, "run_failed", "Failed to queue job", err.Error())
+			}
+			return
+		}
+
+		logger.LogInfo("Manual run of job %s requested from %s", name, logger.GetClientIP(r))
+		middleware.WriteAPISuccess(w, r, map[string]string{
+			"job":    name,
+			"status": "queued",
+		})
+
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+	}
+}
@@ -0,0 +1,443 @@
+// internal/scheduler/scheduler.go
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/logger"
+)
+
+// Job is a unit of periodic work registered with the scheduler
+type Job struct {
+	Name     string
+	Schedule string        // Go duration ("5m") or five-field cron spec ("0 2 * * *")
+	Jitter   time.Duration // random delay added to each run so jobs don't pile up
+	Blackout string        // local-time windows when scheduled runs are deferred ("18:00-21:00,23:30-00:30")
+	Run      func(ctx context.Context) error
+}
+
+type registeredJob struct {
+	Job
+	interval  time.Duration
+	cron      *cronSpec
+	blackouts []blackoutWindow
+	trigger   chan struct{}
+
+	// Run state, guarded by the scheduler's mutex
+	running      bool
+	nextRun      time.Time
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastErr      error
+	runCount     int
+}
+
+// JobStatus is a snapshot of one job's schedule and most recent run
+type JobStatus struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	Blackout     string     `json:"blackout,omitempty"`
+	Running      bool       `json:"running"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastOutcome  string     `json:"last_outcome,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	RunCount     int        `json:"run_count"`
+}
+
+// ErrJobNotFound is returned when a job name isn't registered
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobQueued is returned when a manual run is already waiting for a job
+var ErrJobQueued = errors.New("job already queued to run")
+
+// Scheduler runs registered jobs on their schedules, one goroutine per job
+type Scheduler struct {
+	mutex    sync.Mutex
+	jobs     map[string]*registeredJob
+	order    []string
+	cancel   context.CancelFunc
+	stopping chan struct{} // closed to stop scheduling new runs
+	wg       sync.WaitGroup
+	started  bool
+
+	jobLogMu   sync.Mutex
+	jobLogPath string
+}
+
+type summaryKey struct{}
+
+// runSummary collects the lines a job reports during one run
+type runSummary struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+// Report adds a line to the current run's summary, which is written to the job log
+// when the run finishes. It is a no-op outside a scheduled run.
+func Report(ctx context.Context, format string, args ...interface{}) {
+	summary, ok := ctx.Value(summaryKey{}).(*runSummary)
+	if !ok {
+		return
+	}
+	summary.mutex.Lock()
+	summary.lines = append(summary.lines, fmt.Sprintf(format, args...))
+	summary.mutex.Unlock()
+}
+
+// New creates an empty scheduler
+func New() *Scheduler {
+	return &Scheduler{jobs: make(map[string]*registeredJob)}
+}
+
+// Register adds a job. Jobs must be registered before Start.
+func (s *Scheduler) Register(job Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.started {
+		return fmt.Errorf("cannot register job %s after scheduler has started", job.Name)
+	}
+	if job.Name == "" || job.Run == nil {
+		return fmt.Errorf("job must have a name and a run function")
+	}
+	if _, exists := s.jobs[job.Name]; exists {
+		return fmt.Errorf("job %s already registered", job.Name)
+	}
+
+	rj := &registeredJob{Job: job, trigger: make(chan struct{}, 1)}
+	if interval, err := time.ParseDuration(strings.TrimSpace(job.Schedule)); err == nil {
+		if interval <= 0 {
+			return fmt.Errorf("job %s has non-positive interval %s", job.Name, job.Schedule)
+		}
+		rj.interval = interval
+	} else {
+		spec, err := parseCron(job.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %s has invalid schedule: %w", job.Name, err)
+		}
+		rj.cron = spec
+	}
+
+	blackouts, err := parseBlackout(job.Blackout)
+	if err != nil {
+		return fmt.Errorf("job %s has invalid blackout: %w", job.Name, err)
+	}
+	if coversWholeDay(blackouts) {
+		return fmt.Errorf("job %s blackout %q leaves no time to run", job.Name, job.Blackout)
+	}
+	rj.blackouts = blackouts
+
+	s.jobs[job.Name] = rj
+	s.order = append(s.order, job.Name)
+	if len(blackouts) > 0 {
+		logger.LogInfo("Registered job %s (schedule %q, jitter %v, blackout %s)", job.Name, job.Schedule, job.Jitter, formatBlackout(blackouts))
+	} else {
+		logger.LogInfo("Registered job %s (schedule %q, jitter %v)", job.Name, job.Schedule, job.Jitter)
+	}
+	return nil
+}
+
+// SetJobLog sets the file each run's outcome and summary are appended to
+func (s *Scheduler) SetJobLog(path string) {
+	s.jobLogMu.Lock()
+	defer s.jobLogMu.Unlock()
+	s.jobLogPath = path
+}
+
+// Start launches every registered job
+func (s *Scheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.stopping = make(chan struct{})
+
+	for _, name := range s.order {
+		job := s.jobs[name]
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+
+	logger.LogInfo("Scheduler started with %d jobs", len(s.order))
+}
+
+// Status returns a snapshot of every job in registration order
+func (s *Scheduler) Status() []JobStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make([]JobStatus, 0, len(s.order))
+	for _, name := range s.order {
+		job := s.jobs[name]
+		status := JobStatus{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Blackout: formatBlackout(job.blackouts),
+			Running:  job.running,
+			RunCount: job.runCount,
+		}
+		if !job.nextRun.IsZero() {
+			next := job.nextRun
+			status.NextRun = &next
+		}
+		if !job.lastRun.IsZero() {
+			last := job.lastRun
+			status.LastRun = &last
+			status.LastDuration = job.lastDuration.Round(time.Millisecond).String()
+			status.LastOutcome = "ok"
+			if job.lastErr != nil {
+				status.LastOutcome = "error"
+				status.LastError = job.lastErr.Error()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// RunNow queues an immediate run of the named job. The run happens on the job's own
+// goroutine, so it never overlaps a scheduled run of the same job. Manual runs are an
+// explicit admin decision, so they ignore the job's blackout windows.
+func (s *Scheduler) RunNow(name string) error {
+	s.mutex.Lock()
+	job, exists := s.jobs[name]
+	s.mutex.Unlock()
+
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	select {
+	case job.trigger <- struct{}{}:
+		logger.LogInfo("Manual run queued for job %s", name)
+		return nil
+	default:
+		return ErrJobQueued
+	}
+}
+
+// Stop cancels all jobs and waits for running ones to return
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	cancel := s.cancel
+	s.mutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	s.beginStopping()
+	cancel()
+	s.wg.Wait()
+	logger.LogInfo("Scheduler stopped")
+}
+
+// Shutdown stops scheduling new runs and lets in-flight jobs finish. If ctx expires
+// first, running jobs are cancelled so they can checkpoint at their next item
+// boundary, and Shutdown waits for them to return before reporting the timeout.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	cancel := s.cancel
+	s.mutex.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	s.beginStopping()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		cancel()
+		logger.LogInfo("Scheduler shut down, all jobs finished")
+		return nil
+	case <-ctx.Done():
+		logger.LogWarn("Scheduler shutdown deadline reached, cancelling running jobs: %s", strings.Join(s.runningJobs(), ", "))
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) beginStopping() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
+	}
+}
+
+func (s *Scheduler) runningJobs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var names []string
+	for _, name := range s.order {
+		if s.jobs[name].running {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *Scheduler) loop(ctx context.Context, job *registeredJob) {
+	defer s.wg.Done()
+
+	for {
+		next := job.nextRunAfter(clock.Now())
+		if next.IsZero() {
+			logger.LogWarn("Job %s has no future run time, disabling", job.Name)
+			return
+		}
+
+		s.mutex.Lock()
+		job.nextRun = next
+		s.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopping:
+			return
+		case <-job.trigger:
+		case <-clock.After(clock.Until(next)):
+		}
+
+		// The timer and shutdown can fire together; don't start work once stopping
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+
+		s.runJob(ctx, job)
+	}
+}
+
+// runJob runs a job once, isolating panics so one bad job can't take down the server
+func (s *Scheduler) runJob(ctx context.Context, job *registeredJob) {
+	start := clock.Now()
+	s.mutex.Lock()
+	job.running = true
+	s.mutex.Unlock()
+
+	summary := &runSummary{}
+	runCtx := context.WithValue(ctx, summaryKey{}, summary)
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				logger.LogError("Job %s panicked: %v\n%s", job.Name, r, debug.Stack())
+			}
+		}()
+		return job.Run(runCtx)
+	}()
+
+	duration := clock.Since(start)
+
+	s.mutex.Lock()
+	job.running = false
+	job.lastRun = start
+	job.lastDuration = duration
+	job.lastErr = err
+	job.runCount++
+	s.mutex.Unlock()
+
+	if err != nil {
+		logger.LogError("Job %s failed after %v: %v", job.Name, duration, err)
+	} else {
+		logger.LogInfo("Job %s completed in %v", job.Name, duration)
+	}
+
+	s.writeJobLog(job.Name, start, duration, err, summary.lines)
+}
+
+// writeJobLog appends one entry per run to the job log, if one is configured
+func (s *Scheduler) writeJobLog(name string, start time.Time, duration time.Duration, runErr error, lines []string) {
+	s.jobLogMu.Lock()
+	defer s.jobLogMu.Unlock()
+
+	if s.jobLogPath == "" {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.jobLogPath), 0755); err != nil {
+		logger.LogError("Failed to create job log directory: %v", err)
+		return
+	}
+	f, err := os.OpenFile(s.jobLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.LogError("Failed to open job log %s: %v", s.jobLogPath, err)
+		return
+	}
+	defer f.Close()
+
+	outcome := "ok"
+	if runErr != nil {
+		outcome = "error: " + runErr.Error()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s job=%s duration=%v outcome=%s\n", start.Format(time.RFC3339), name, duration.Round(time.Millisecond), outcome)
+	for _, line := range lines {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+
+	if _, err := f.WriteString(b.String()); err != nil {
+		logger.LogError("Failed to write job log: %v", err)
+	}
+}
+
+// nextRunAfter returns when the job should next run after now, including jitter and
+// any deferral out of a blackout window
+func (j *registeredJob) nextRunAfter(now time.Time) time.Time {
+	var next time.Time
+	if j.cron != nil {
+		next = j.cron.next(now.In(clock.Location()))
+		if next.IsZero() {
+			return next
+		}
+	} else {
+		next = now.Add(j.interval)
+	}
+
+	if j.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(j.Jitter))))
+	}
+
+	if len(j.blackouts) > 0 {
+		if deferred := deferPastBlackout(next, j.blackouts); !deferred.Equal(next) {
+			logger.LogInfo("Job %s run at %s falls in a blackout window, deferring to %s",
+				j.Name, next.Format("15:04"), deferred.Format("15:04"))
+			next = deferred
+		}
+	}
+	return next
+}
@@ -0,0 +1,81 @@
+// Package search finds submissions of every form type by name, email, school or
+// student name, and a family's whole history by email, for admins answering a
+// family's question.
+package search
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// defaultLimit is how many results a search returns unless it asks for more
+const defaultLimit = 50
+
+// Handler lets an admin search submissions: GET with ?q= returns the memberships,
+// event registrations and fundraiser donations matching every word, most relevant
+// first, up to ?limit= of them.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_query", "q is required", "")
+		return
+	}
+	limit := defaultLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_limit", "limit must be a positive number", "")
+			return
+		}
+		limit = min(parsed, middleware.MaxListLimit)
+	}
+
+	results, err := data.SearchSubmissions(query, limit)
+	if err != nil {
+		logger.LogError("Failed to search submissions for %q: %v", query, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to search submissions", "")
+		return
+	}
+	if results == nil {
+		results = []data.SubmissionSummary{}
+	}
+	middleware.WriteAPISuccess(w, r, map[string]interface{}{"query": query, "results": results})
+}
+
+// HistoryHandler lets an admin look up a family: GET with ?email= returns every
+// membership, event registration and fundraiser donation made with the address, past
+// school years' included, with what was paid, refunded and is still due for each.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	if r.Method != http.MethodGet {
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", "")
+		return
+	}
+
+	emailAddress := strings.TrimSpace(r.URL.Query().Get("email"))
+	if emailAddress == "" {
+		middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_email", "email is required", "")
+		return
+	}
+
+	history, err := data.GetSubmissionsByEmail(emailAddress)
+	if err != nil {
+		logger.LogError("Failed to load the submission history of %s: %v", emailAddress, err)
+		middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load submission history", "")
+		return
+	}
+	middleware.WriteAPISuccess(w, r, history)
+}
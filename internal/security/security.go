@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"sbcbackend/internal/clock"
 	"sbcbackend/internal/config"
 	"sbcbackend/internal/logger"
 )
@@ -50,7 +51,7 @@ func GenerateAccessToken() (string, error) {
 	}
 
 	// Create timestamp (Unix timestamp as string)
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	timestamp := strconv.FormatInt(clock.Now().Unix(), 10)
 
 	// Combine timestamp + random data
 	tokenData := timestamp + ":" + base64.URLEncoding.EncodeToString(randomBytes)
@@ -83,7 +84,7 @@ func ValidateAccessToken(token string, maxAge time.Duration) bool {
 
 	// Check if token has expired
 	tokenTime := time.Unix(timestamp, 0)
-	if time.Since(tokenTime) > maxAge {
+	if clock.Since(tokenTime) > maxAge {
 		return false
 	}
 
@@ -108,7 +109,7 @@ func GetAccessTokenAge(token string) (time.Duration, error) {
 	}
 
 	tokenTime := time.Unix(timestamp, 0)
-	return time.Since(tokenTime), nil
+	return clock.Since(tokenTime), nil
 }
 
 // Store access token info for one-time use validation
@@ -119,7 +120,7 @@ func StoreAccessToken(token, formID, formType string) {
 	accessTokenManager.tokens[token] = &TokenInfo{
 		FormID:    formID,
 		FormType:  formType,
-		CreatedAt: time.Now(),
+		CreatedAt: clock.Now(),
 		Used:      false,
 	}
 }
@@ -164,6 +165,16 @@ func ValidateAndUseAccessToken(token, formID string, maxAge time.Duration) (*Tok
 	return tokenInfo, nil
 }
 
+// GenerateResumeToken returns a random URL-safe token for reminder links. Unlike access
+// tokens it carries no timestamp; it stays valid until the submission is paid or abandoned.
+func GenerateResumeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 // GenerateCSRFToken generates a new CSRF token.
 func GenerateCSRFToken() string {
 	b := make([]byte, 32)
@@ -174,7 +185,7 @@ func GenerateCSRFToken() string {
 	token := base64.StdEncoding.EncodeToString(b)
 
 	csrfTokensMu.Lock()
-	csrfTokens[token] = time.Now().Add(csrfTokenTTL)
+	csrfTokens[token] = clock.Now().Add(csrfTokenTTL)
 	csrfTokensMu.Unlock()
 
 	return token
@@ -186,7 +197,7 @@ func ValidateCSRFToken(token string) bool {
 	defer csrfTokensMu.Unlock()
 
 	expiry, ok := csrfTokens[token]
-	if !ok || time.Now().After(expiry) {
+	if !ok || clock.Now().After(expiry) {
 		return false
 	}
 	delete(csrfTokens, token) // Consume the token
@@ -213,16 +224,19 @@ func CSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // Cleanup expired access tokens
-func cleanupExpiredAccessTokens(maxAge time.Duration) {
+func cleanupExpiredAccessTokens(maxAge time.Duration) int {
 	accessTokenManager.mutex.Lock()
 	defer accessTokenManager.mutex.Unlock()
 
-	now := time.Now()
+	removed := 0
+	now := clock.Now()
 	for token, info := range accessTokenManager.tokens {
 		if now.Sub(info.CreatedAt) > maxAge {
 			delete(accessTokenManager.tokens, token)
+			removed++
 		}
 	}
+	return removed
 }
 
 // ValidateAdminToken checks if a token is a valid admin token with optional referer check
@@ -255,32 +269,22 @@ func ValidateAdminToken(token string, requireReferer bool, referer string) bool
 	return true
 }
 
-// CleanExpiredTokens periodically cleans up expired CSRF and access tokens.
-func CleanExpiredTokens() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	tickCount := 0
+// PruneExpiredTokens removes expired CSRF tokens and access tokens older than maxAge,
+// returning how many of each were removed.
+func PruneExpiredTokens(maxAge time.Duration) (csrfRemoved, accessRemoved int) {
+	now := clock.Now()
 
-	for range ticker.C {
-		tickCount++
-
-		// Clean CSRF tokens
-		csrfTokensMu.Lock()
-		for token, expiry := range csrfTokens {
-			if time.Now().After(expiry) {
-				delete(csrfTokens, token)
-			}
-		}
-		csrfTokensMu.Unlock()
-
-		// Clean expired access tokens (keep for 24 hours for potential logging)
-		cleanupExpiredAccessTokens(24 * time.Hour)
-
-		if tickCount%6 == 0 {
-			logger.LogInfo("Token cleanup ran (CSRF and access tokens)")
+	csrfTokensMu.Lock()
+	for token, expiry := range csrfTokens {
+		if now.After(expiry) {
+			delete(csrfTokens, token)
+			csrfRemoved++
 		}
 	}
+	csrfTokensMu.Unlock()
+
+	accessRemoved = cleanupExpiredAccessTokens(maxAge)
+	return csrfRemoved, accessRemoved
 }
 
 // AddCORSHeaders adds CORS headers to allow requests from your frontend.
@@ -0,0 +1,119 @@
+// Package sms sends short text messages through Twilio, for payers who asked for a
+// confirmation by text because the email is easy to miss.
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/config"
+)
+
+var (
+	client = &http.Client{Timeout: 10 * time.Second}
+
+	apiMu   sync.RWMutex
+	apiBase = "https://api.twilio.com"
+)
+
+// SetAPIBase points the sender at a different Twilio API host and returns the previous
+// one. Tests use it to send to a local server.
+func SetAPIBase(base string) string {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	previous := apiBase
+	apiBase = strings.TrimRight(base, "/")
+	return previous
+}
+
+func currentAPIBase() string {
+	apiMu.RLock()
+	defer apiMu.RUnlock()
+	return apiBase
+}
+
+// Enabled reports whether Twilio is configured, so payers can be offered a text
+func Enabled() bool {
+	return config.LoadTwilioSettings().Enabled()
+}
+
+// NormalizePhone returns number in E.164 form. Ten digit numbers are taken to be North
+// American, which is where every family we serve is.
+func NormalizePhone(number string) (string, error) {
+	number = strings.TrimSpace(number)
+	var digits strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-', r == '.', r == '(', r == ')':
+		default:
+			return "", fmt.Errorf("phone number %q has unexpected characters", number)
+		}
+	}
+
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(number, "+") && len(d) >= 8 && len(d) <= 15:
+		return "+" + d, nil
+	case len(d) == 10:
+		return "+1" + d, nil
+	case len(d) == 11 && d[0] == '1':
+		return "+" + d, nil
+	}
+	return "", fmt.Errorf("phone number %q is not a valid mobile number", number)
+}
+
+// Send texts body to the E.164 number to and waits for Twilio to accept it
+func Send(ctx context.Context, to, body string) error {
+	settings := config.LoadTwilioSettings()
+	if !settings.Enabled() {
+		return fmt.Errorf("twilio is not configured")
+	}
+
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("Body", body)
+	// A messaging service picks the sending number itself
+	if strings.HasPrefix(settings.From, "MG") {
+		form.Set("MessagingServiceSid", settings.From)
+	} else {
+		form.Set("From", settings.From)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json",
+		currentAPIBase(), url.PathEscape(settings.AccountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build Twilio request: %w", err)
+	}
+	req.SetBasicAuth(settings.AccountSID, settings.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("twilio request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var twilioErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &twilioErr) == nil && twilioErr.Message != "" {
+			return fmt.Errorf("twilio returned status %d: %s (code %d)", resp.StatusCode, twilioErr.Message, twilioErr.Code)
+		}
+		return fmt.Errorf("twilio returned status %d", resp.StatusCode)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
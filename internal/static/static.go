@@ -0,0 +1,160 @@
+// internal/static/static.go
+package static
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/logger"
+)
+
+// Options controls how a directory is served
+type Options struct {
+	// CacheControl is sent with every file; empty sends none
+	CacheControl string
+	// NoIndex asks search engines not to index the files, for pages with customer details
+	NoIndex bool
+	// Fallback supplies files the directory does not have, such as built-in defaults
+	Fallback fs.FS
+}
+
+// CacheFor returns a Cache-Control value letting browsers and proxies keep a file for maxAge
+func CacheFor(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+}
+
+// Handler serves the regular files under dir. Request paths are relative to dir, so
+// mount it with http.StripPrefix. Hidden files, directory listings and anything that
+// resolves outside dir (through ".." or a symlink) are answered with 404. Files dir
+// lacks are looked up in opts.Fallback when set.
+func Handler(dir string, opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cleaned, ok := cleanPath(r.URL.Path)
+		if !ok {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+
+		if serveFile(w, r, dir, cleaned, opts) {
+			return
+		}
+		if opts.Fallback != nil && serveFallback(w, r, opts.Fallback, cleaned, opts) {
+			return
+		}
+		http.Error(w, "File not found", http.StatusNotFound)
+	})
+}
+
+// serveFile serves cleaned from dir, reporting false when there is no such file
+func serveFile(w http.ResponseWriter, r *http.Request, dir, cleaned string, opts Options) bool {
+	filePath, ok := resolve(dir, cleaned)
+	if !ok {
+		return false
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	etag := fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+	serveContent(w, r, info.Name(), info.ModTime(), etag, file, opts)
+	return true
+}
+
+// serveFallback serves cleaned from fsys, reporting false when there is no such file
+func serveFallback(w http.ResponseWriter, r *http.Request, fsys fs.FS, cleaned string, opts Options) bool {
+	name := strings.TrimPrefix(cleaned, "/")
+	if name == "" {
+		return false
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return false
+	}
+
+	// Embedded files have no modification time, so the ETag comes from the content
+	etag := fmt.Sprintf(`W/"%x-%x"`, len(content), crc32.ChecksumIEEE(content))
+	serveContent(w, r, info.Name(), info.ModTime(), etag, bytes.NewReader(content), opts)
+	return true
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, etag string, content io.ReadSeeker, opts Options) {
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("X-Content-Type-Options", "nosniff")
+	if opts.CacheControl != "" {
+		header.Set("Cache-Control", opts.CacheControl)
+	}
+	if opts.NoIndex {
+		header.Set("X-Robots-Tag", "noindex, nofollow")
+	}
+
+	// ServeContent handles conditional requests, ranges and the content type
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+// cleanPath normalizes a request path, rejecting anything that could expose hidden
+// files or be read differently by the filesystem
+func cleanPath(requestPath string) (string, bool) {
+	if strings.ContainsAny(requestPath, "\\\x00") {
+		return "", false
+	}
+
+	cleaned := path.Clean("/" + requestPath)
+	for _, segment := range strings.Split(cleaned, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	return cleaned, true
+}
+
+// resolve maps a cleaned request path to a file inside dir, rejecting anything that
+// resolves outside it
+func resolve(dir, cleaned string) (string, bool) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(cleaned)))
+	if err != nil {
+		return "", false
+	}
+	if resolved == root {
+		return "", false
+	}
+	if !strings.HasPrefix(resolved, root+string(os.PathSeparator)) {
+		logger.LogWarn("Refused static path %q resolving outside %s", cleaned, dir)
+		return "", false
+	}
+	return resolved, true
+}
@@ -0,0 +1,113 @@
+// internal/tenant/router.go
+package tenant
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"sbcbackend/internal/logger"
+)
+
+// Router sends each request to the backend of the tenant it belongs to, matching the
+// Host header first and then the path prefix. A matched prefix is stripped and passed
+// on in X-Forwarded-Prefix.
+type Router struct {
+	byHost   map[string]*Tenant
+	prefixed []*Tenant // longest prefix first
+	proxies  map[string]http.Handler
+
+	// NotFound handles requests that belong to no tenant; nil replies 404
+	NotFound http.Handler
+}
+
+// NewRouter proxies each tenant to the unix socket its backend listens on
+func NewRouter(tenants []Tenant, sockets map[string]string) *Router {
+	r := &Router{
+		byHost:  make(map[string]*Tenant),
+		proxies: make(map[string]http.Handler),
+	}
+	for i := range tenants {
+		t := &tenants[i]
+		for _, host := range t.Hosts {
+			r.byHost[host] = t
+		}
+		if t.PathPrefix != "" {
+			r.prefixed = append(r.prefixed, t)
+		}
+		r.proxies[t.ID] = newProxy(t, sockets[t.ID])
+	}
+	sort.SliceStable(r.prefixed, func(i, j int) bool {
+		return len(r.prefixed[i].PathPrefix) > len(r.prefixed[j].PathPrefix)
+	})
+	return r
+}
+
+// Resolve returns the tenant a request belongs to and the path within the tenant,
+// or nil when no tenant claims it
+func (r *Router) Resolve(req *http.Request) (*Tenant, string) {
+	if t, ok := r.byHost[normalizeHost(req.Host)]; ok {
+		return t, req.URL.Path
+	}
+	for _, t := range r.prefixed {
+		if req.URL.Path == t.PathPrefix {
+			return t, "/"
+		}
+		if rest, ok := strings.CutPrefix(req.URL.Path, t.PathPrefix+"/"); ok {
+			return t, "/" + rest
+		}
+	}
+	return nil, ""
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t, path := r.Resolve(req)
+	if t == nil {
+		if r.NotFound != nil {
+			r.NotFound.ServeHTTP(w, req)
+			return
+		}
+		http.NotFound(w, req)
+		return
+	}
+
+	// Only this router may tell a backend it is mounted under a prefix
+	out := req.Clone(req.Context())
+	out.Header.Del("X-Forwarded-Prefix")
+	if path != req.URL.Path {
+		out.URL.Path = path
+		out.URL.RawPath = ""
+		out.Header.Set("X-Forwarded-Prefix", t.PathPrefix)
+	}
+	r.proxies[t.ID].ServeHTTP(w, out)
+}
+
+func newProxy(t *Tenant, socket string) http.Handler {
+	// The host is never resolved; every connection goes to the tenant's socket
+	target := &url.URL{Scheme: "http", Host: t.ID}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// Keep the chain from a fronting proxy so the backend sees the real client
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+			MaxIdleConnsPerHost: 32,
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.LogError("Tenant %s backend unavailable for %s %s: %v", t.ID, req.Method, req.URL.Path, err)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Service temporarily unavailable", http.StatusBadGateway)
+		},
+	}
+}
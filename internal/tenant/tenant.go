@@ -0,0 +1,181 @@
+// internal/tenant/tenant.go
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sbcbackend/internal/config"
+)
+
+// Tenant is one booster organization served by a shared deployment. Each tenant runs
+// in its own backend process with its own working directory, so its database, logs,
+// order pages and template overrides never mix with another tenant's.
+type Tenant struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Hosts      []string `json:"hosts"`       // requests for these hosts go to the tenant
+	PathPrefix string   `json:"path_prefix"` // or requests under this prefix, e.g. "/lincoln"
+	Dir        string   `json:"dir"`         // working directory, default tenants/<id>
+
+	// Settings are environment variables for the tenant's process, written as they
+	// would be in .env (e.g. PAYPAL_CLIENT_ID or INVENTORY_JSON_PATH_PROD). They
+	// override the shared environment.
+	Settings map[string]string `json:"settings"`
+}
+
+type tenantsFile struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Load reads and validates the tenants file. Relative tenant directories are resolved
+// against the directory the file is in.
+func Load(path string) ([]Tenant, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	var file tenantsFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants file: %w", err)
+	}
+	if len(file.Tenants) == 0 {
+		return nil, fmt.Errorf("tenants file %s lists no tenants", path)
+	}
+
+	base := filepath.Dir(path)
+	ids := make(map[string]bool)
+	hosts := make(map[string]string)
+	prefixes := make(map[string]string)
+
+	for i := range file.Tenants {
+		t := &file.Tenants[i]
+		if !validID.MatchString(t.ID) {
+			return nil, fmt.Errorf("tenant %d: id %q must be lowercase letters, digits and dashes", i+1, t.ID)
+		}
+		if ids[t.ID] {
+			return nil, fmt.Errorf("tenant %s is listed twice", t.ID)
+		}
+		ids[t.ID] = true
+
+		if t.Name == "" {
+			t.Name = t.ID
+		}
+
+		for j, host := range t.Hosts {
+			host = normalizeHost(host)
+			if host == "" {
+				return nil, fmt.Errorf("tenant %s: empty host", t.ID)
+			}
+			if other, ok := hosts[host]; ok {
+				return nil, fmt.Errorf("tenants %s and %s both claim host %s", other, t.ID, host)
+			}
+			hosts[host] = t.ID
+			t.Hosts[j] = host
+		}
+
+		if t.PathPrefix != "" {
+			prefix := "/" + strings.Trim(t.PathPrefix, "/")
+			if prefix == "/" || strings.ContainsAny(prefix, "?#") {
+				return nil, fmt.Errorf("tenant %s: invalid path prefix %q", t.ID, t.PathPrefix)
+			}
+			if other, ok := prefixes[prefix]; ok {
+				return nil, fmt.Errorf("tenants %s and %s both claim path prefix %s", other, t.ID, prefix)
+			}
+			prefixes[prefix] = t.ID
+			t.PathPrefix = prefix
+		}
+
+		if len(t.Hosts) == 0 && t.PathPrefix == "" {
+			return nil, fmt.Errorf("tenant %s needs at least one host or a path prefix", t.ID)
+		}
+
+		if t.Dir == "" {
+			t.Dir = filepath.Join("tenants", t.ID)
+		}
+		if !filepath.IsAbs(t.Dir) {
+			t.Dir = filepath.Join(base, t.Dir)
+		}
+		if t.Dir, err = filepath.Abs(t.Dir); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+	}
+
+	return file.Tenants, nil
+}
+
+// Per-tenant defaults for path settings, relative to the tenant directory. Without
+// them, a path set once in the shared .env would be shared by every tenant.
+var partitionedPaths = map[string]string{
+	"DATA_DIRECTORY":         "data",
+	"FORMS_DATA_DIRECTORY":   "data",
+	"FORMS_BACKUP_DIRECTORY": "data/backup",
+	"WEBHOOK_DIRECTORY":      "webhook",
+	"LOGS_DIRECTORY":         "logs",
+	"LOG_FILE_FORMAT":        "logs/server_%s.log",
+	"EVENT_ORDERS_PATH":      "events",
+	"INVENTORY_JSON_PATH":    "inventory.json",
+}
+
+// Env builds the environment for the tenant's process from the shared environment:
+// partitioned path defaults first, then the tenant's own settings, then the identity
+// variables the process uses to know it is serving a tenant.
+func (t Tenant) Env(shared []string) []string {
+	env := make(map[string]string, len(shared))
+	for _, kv := range shared {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+
+	for base, rel := range partitionedPaths {
+		env[config.EnvSettingName(base)] = filepath.Join(t.Dir, rel)
+	}
+	for key, value := range t.Settings {
+		env[key] = value
+	}
+	env[config.TenantIDVar] = t.ID
+	env["TENANT_NAME"] = t.Name
+	env["TENANT_PATH_PREFIX"] = t.PathPrefix
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, key+"="+env[key])
+	}
+	return result
+}
+
+// SharedSettings lists the settings the tenant inherits from the shared environment
+// that normally belong to a single organization
+func (t Tenant) SharedSettings() []string {
+	var shared []string
+	for _, key := range []string{"PAYPAL_CLIENT_ID", "PAYPAL_WEBHOOK_ID", "EMAIL_CONFIRMATION_SENDER", "EMAIL_ALERT_RECIPIENT"} {
+		if _, ok := t.Settings[key]; !ok && os.Getenv(key) != "" {
+			shared = append(shared, key)
+		}
+	}
+	return shared
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
@@ -0,0 +1,104 @@
+package testing
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/data"
+)
+
+// TestAbandonedCheckoutSweep runs the sweeper over unpaid and paid submissions as the
+// clock moves through its two windows: an unpaid one gets exactly one reminder, then is
+// marked abandoned once the second window has passed; a paid one is never touched
+func TestAbandonedCheckoutSweep(t *testing.T) {
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	policy := cleanup.AbandonedCheckoutPolicy{ReminderAge: 24 * time.Hour, AbandonAfter: 48 * time.Hour}
+	sweep := cleanup.NewAbandonedCheckoutJob(policy)
+
+	insert := func(formID, email string, age time.Duration, paid bool) {
+		t.Helper()
+		sub := h.GenerateTestMembership().ToMembershipSubmission()
+		sub.FormID, sub.Email, sub.SubmissionDate = formID, email, fake.Now().Add(-age)
+		if paid {
+			sub.Submitted, sub.PayPalStatus = true, "COMPLETED"
+		}
+		h.AssertNoError(t, data.InsertMembership(sub))
+	}
+	const unpaidEmail, freshEmail, paidEmail = "unpaid@example.com", "fresh@example.com", "paid@example.com"
+	insert("membership-abandon-unpaid", unpaidEmail, 25*time.Hour, false)
+	insert("membership-abandon-fresh", freshEmail, time.Hour, false)
+	insert("membership-abandon-paid", paidEmail, 30*24*time.Hour, true)
+
+	conn, err := data.GetDB()
+	h.AssertNoError(t, err)
+	abandoned := func(formID string) bool {
+		t.Helper()
+		var abandonedAt sql.NullString
+		h.AssertNoError(t, conn.QueryRow(`SELECT abandoned_at FROM membership_submissions WHERE form_id = ?`,
+			formID).Scan(&abandonedAt))
+		return abandonedAt.Valid
+	}
+	run := func() {
+		t.Helper()
+		h.AssertNoError(t, sweep(context.Background()))
+	}
+
+	// Only the submission older than the reminder age is reminded, with a link to resume
+	run()
+	sent := h.Mailer.SentTo(unpaidEmail)
+	if len(sent) != 1 {
+		t.Fatalf("expected one reminder, got %d", len(sent))
+	}
+	if !strings.Contains(sent[0].Body, "/api/resume-checkout?") {
+		t.Errorf("expected the reminder to link to the checkout, got %q", sent[0].Body)
+	}
+	if got := len(h.Mailer.SentTo(freshEmail)); got != 0 {
+		t.Errorf("expected no reminder before the reminder age, got %d", got)
+	}
+
+	// Later runs never send a second one
+	run()
+	fake.Advance(47 * time.Hour)
+	run()
+	if got := len(h.Mailer.SentTo(unpaidEmail)); got != 1 {
+		t.Errorf("expected exactly one reminder, got %d", got)
+	}
+	if abandoned("membership-abandon-unpaid") {
+		t.Error("expected the submission kept until the abandon window has passed")
+	}
+
+	// Past the second window it is abandoned, still without another reminder
+	fake.Advance(2 * time.Hour)
+	run()
+	if !abandoned("membership-abandon-unpaid") {
+		t.Error("expected the submission marked abandoned after the second window")
+	}
+	if got := len(h.Mailer.SentTo(unpaidEmail)); got != 1 {
+		t.Errorf("expected no reminder with the abandonment, got %d", got)
+	}
+	// The fresh submission reached the reminder age along the way, but isn't abandoned yet
+	if got := len(h.Mailer.SentTo(freshEmail)); got != 1 {
+		t.Errorf("expected the later submission reminded once, got %d", got)
+	}
+	if abandoned("membership-abandon-fresh") {
+		t.Error("expected the later submission kept until its own abandon window has passed")
+	}
+
+	// A completed submission is neither reminded nor abandoned
+	fake.Advance(30 * 24 * time.Hour)
+	run()
+	if got := len(h.Mailer.SentTo(paidEmail)); got != 0 {
+		t.Errorf("expected no reminder for a completed submission, got %d", got)
+	}
+	if abandoned("membership-abandon-paid") {
+		t.Error("expected a completed submission never marked abandoned")
+	}
+	if !abandoned("membership-abandon-fresh") {
+		t.Error("expected the later submission abandoned after its own window")
+	}
+}
@@ -0,0 +1,83 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/security"
+)
+
+func TestAdminCaptureOrder(t *testing.T) {
+	h := NewHarness(t)
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	capture := func(req payment.AdminCaptureRequest) (int, payment.AdminCaptureResponse) {
+		t.Helper()
+		raw, _ := json.Marshal(req)
+		httpReq, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/admin/capture-order", bytes.NewReader(raw))
+		h.AssertNoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("X-Admin-Token", adminToken)
+		httpReq.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(httpReq)
+		h.AssertNoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var captured struct {
+			Data payment.AdminCaptureResponse `json:"data"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			h.AssertNoError(t, json.Unmarshal(body, &captured))
+		}
+		return resp.StatusCode, captured.Data
+	}
+
+	member := h.GenerateTestMembership().ToMembershipSubmission()
+	member.CalculatedAmount = 25
+	h.AssertNoError(t, data.InsertMembership(member))
+	order, err := h.PayPal.CreateOrder(member.FormID, "25.00")
+	h.AssertNoError(t, err)
+	now := time.Now()
+	h.AssertNoError(t, data.UpdateMembershipPayPalOrder(member.FormID, order.ID, &now))
+
+	if status, _ := capture(payment.AdminCaptureRequest{FormID: member.FormID}); status != http.StatusConflict {
+		t.Errorf("expected 409 capturing an order the family never approved, got %d", status)
+	}
+
+	other, err := h.PayPal.CreateOrder("membership-someone-else", "25.00")
+	h.AssertNoError(t, err)
+	other.Status = "APPROVED"
+	if status, _ := capture(payment.AdminCaptureRequest{FormID: member.FormID, OrderID: other.ID}); status != http.StatusBadRequest {
+		t.Errorf("expected 400 capturing another form's order, got %d", status)
+	}
+	if status, _ := capture(payment.AdminCaptureRequest{FormID: member.FormID, OrderID: "NO-SUCH-ORDER"}); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an order PayPal doesn't know, got %d", status)
+	}
+
+	// The family approved, then their browser died before coming back
+	order.Status = "APPROVED"
+	status, captured := capture(payment.AdminCaptureRequest{FormID: member.FormID})
+	if status != http.StatusOK || captured.OrderID != order.ID || captured.Status != "COMPLETED" || captured.ReceiptNumber == "" {
+		t.Fatalf("expected the order captured with a receipt, got %d %+v", status, captured)
+	}
+	if paypalOrder, _ := h.PayPal.GetOrder(order.ID); paypalOrder.Status != "COMPLETED" {
+		t.Errorf("expected PayPal to have captured the order, got %s", paypalOrder.Status)
+	}
+	summary, err := data.GetSubmissionSummary(member.FormID)
+	h.AssertNoError(t, err)
+	if !summary.Submitted || summary.PayPalStatus != "COMPLETED" {
+		t.Errorf("expected the form paid, got %+v", summary)
+	}
+
+	if status, _ := capture(payment.AdminCaptureRequest{FormID: member.FormID}); status != http.StatusConflict {
+		t.Errorf("expected 409 capturing a paid form, got %d", status)
+	}
+}
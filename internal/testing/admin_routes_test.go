@@ -0,0 +1,42 @@
+package testing
+
+import (
+	"net/http"
+	"testing"
+
+	"sbcbackend/internal/security"
+)
+
+func TestAdminRoutesRequireAdminToken(t *testing.T) {
+	h := NewHarness(t)
+
+	familyToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(familyToken, "membership-admin-routes", "membership")
+
+	paths := []string{
+		"/admin/jobs", "/refund-order", "/admin/maintenance", "/admin/unmatched-payments",
+		"/admin/webhook-events", "/admin/credits", "/admin/audit-log", "/admin/backups",
+		"/admin/db-stats", "/admin/export/memberships.csv", "/admin/search", "/admin/history",
+		"/admin/privacy/erase", "/admin/archive", "/admin/import", "/admin/offline-payment",
+		"/admin/capture-order", "/admin/paypal-simulator",
+	}
+	for _, path := range paths {
+		for _, token := range []string{"", familyToken} {
+			for _, method := range []string{http.MethodGet, http.MethodPost} {
+				req, err := http.NewRequest(method, h.Server.URL+"/api"+path, nil)
+				h.AssertNoError(t, err)
+				if token != "" {
+					req.Header.Set("X-Admin-Token", token)
+				}
+				req.Header.Set("Referer", h.Server.URL+"/info")
+				resp, err := h.Client.Do(req)
+				h.AssertNoError(t, err)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusForbidden {
+					t.Errorf("%s %s with token %q: expected 403, got %d", method, path, token, resp.StatusCode)
+				}
+			}
+		}
+	}
+}
@@ -2,11 +2,15 @@
 package testing
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"sbcbackend/internal/clock"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/security"
 )
@@ -50,7 +54,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 			return
 		}
 
-		if !security.ValidateAccessToken(token) {
+		if !security.ValidateAccessToken(token, 30*time.Minute) {
 			http.Error(w, "Invalid access token", http.StatusForbidden)
 			return
 		}
@@ -107,7 +111,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 		}
 
 		token := r.Header.Get("X-Access-Token")
-		if !security.ValidateAccessToken(token) {
+		if !security.ValidateAccessToken(token, 30*time.Minute) {
 			http.Error(w, "Invalid access token", http.StatusForbidden)
 			return
 		}
@@ -155,7 +159,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 		}
 
 		// Calculate total
-		total, err := suite.Inventory.CalculateMembershipTotal(membershipType, addons, fees, donation, coverFees)
+		total, _, err := suite.Inventory.CalculateMembershipTotal(membershipType, addons, fees, donation, coverFees, "")
 		if err != nil {
 			http.Error(w, "Invalid membership configuration", http.StatusBadRequest)
 			return
@@ -191,7 +195,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 		}
 
 		token := r.Header.Get("X-Access-Token")
-		if !security.ValidateAccessToken(token) {
+		if !security.ValidateAccessToken(token, 30*time.Minute) {
 			http.Error(w, "Invalid access token", http.StatusForbidden)
 			return
 		}
@@ -247,7 +251,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 		}
 
 		// Calculate total
-		total, err := suite.Inventory.CalculateEventTotal(event.Event, convertedStudentSelections, convertedSharedSelections, coverFees)
+		total, _, err := suite.Inventory.CalculateEventTotal(event.Event, convertedStudentSelections, convertedSharedSelections, coverFees, "")
 		if err != nil {
 			http.Error(w, "Invalid event configuration", http.StatusBadRequest)
 			return
@@ -315,7 +319,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 		}
 
 		token := r.Header.Get("X-Access-Token")
-		if !security.ValidateAccessToken(token) {
+		if !security.ValidateAccessToken(token, 30*time.Minute) {
 			http.Error(w, "Invalid access token", http.StatusForbidden)
 			return
 		}
@@ -370,7 +374,7 @@ func createTestServer(suite *TestSuite) *httptest.Server {
 		}
 
 		token := r.Header.Get("X-Access-Token")
-		if !security.ValidateAccessToken(token) {
+		if !security.ValidateAccessToken(token, 30*time.Minute) {
 			http.Error(w, "Invalid access token", http.StatusForbidden)
 			return
 		}
@@ -565,8 +569,8 @@ func testSavePaymentEndpoints(t *testing.T, suite *TestSuite) {
 
 		// Verify total calculation
 		if total, ok := response["total"].(float64); ok {
-			expectedTotal, _ := suite.Inventory.CalculateMembershipTotal(
-				testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees,
+			expectedTotal, _, _ := suite.Inventory.CalculateMembershipTotal(
+				testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees, "",
 			)
 			// Allow small variance for floating point calculations
 			variance := 0.01
@@ -860,8 +864,8 @@ func testCreateOrderEndpoint(t *testing.T, suite *TestSuite) {
 	submission := testData.ToMembershipSubmission()
 
 	// Set calculated amount
-	total, _ := suite.Inventory.CalculateMembershipTotal(
-		testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees,
+	total, _, _ := suite.Inventory.CalculateMembershipTotal(
+		testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees, "",
 	)
 	submission.CalculatedAmount = total
 
@@ -957,13 +961,13 @@ func testCaptureOrderEndpoint(t *testing.T, suite *TestSuite) {
 
 // Helper function to generate an expired token for testing
 func generateExpiredToken(t *testing.T) string {
-	// Create a token that's already expired
+	// Issue the token on a clock set back past the 30 minute token lifetime
+	previous := clock.Set(clock.NewFake(time.Now().Add(-31 * time.Minute)))
+	defer clock.Set(previous)
+
 	token, err := security.GenerateAccessToken()
 	if err != nil {
 		t.Fatalf("Failed to generate test token: %v", err)
 	}
-
-	// Since we can't easily create an expired token without modifying the security package,
-	// we'll just return an invalid format token
-	return "expired-token-" + token[:10]
+	return token
 }
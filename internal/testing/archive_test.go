@@ -0,0 +1,115 @@
+package testing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/archive"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/privacy"
+	"sbcbackend/internal/security"
+)
+
+func TestSchoolYearArchive(t *testing.T) {
+	t.Setenv("SCHOOL_YEAR_START_MONTH_DEV", "8")
+	h := NewHarness(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	if year := archive.SchoolYear(time.Date(2024, time.July, 31, 12, 0, 0, 0, clock.Location())); year != 2023 {
+		t.Errorf("expected July 2024 in school year 2023, got %d", year)
+	}
+	if from, to := archive.Bounds(2023); from.Month() != time.August || to.Year() != 2024 || archive.Label(2023) != "2023-24" {
+		t.Errorf("expected school year 2023-24 to run from August 2023, got %v to %v as %s", from, to, archive.Label(2023))
+	}
+
+	// Everyone but Priya signed up in the fall of 2023; Mary's donation is still disputed
+	conn, err := data.GetDB()
+	h.AssertNoError(t, err)
+	lastYear := time.Date(2023, time.October, 1, 12, 0, 0, 0, time.UTC).Format(data.TimeFormat)
+	for _, table := range []string{"membership_submissions", "event_submissions", "fundraiser_submissions"} {
+		_, err = conn.Exec(`UPDATE `+table+` SET submission_date = ? WHERE email <> ?`, lastYear, "priya.patel@example.com")
+		h.AssertNoError(t, err)
+	}
+	_, err = conn.Exec(`UPDATE fundraiser_submissions SET paypal_status = ? WHERE email = ?`,
+		data.DisputedStatus, "mary.johnson@example.com")
+	h.AssertNoError(t, err)
+
+	if _, err := archive.Archive(archive.SchoolYear(clock.Now())); err == nil {
+		t.Error("expected the current school year to be refused")
+	}
+	result, err := archive.Archive(2023)
+	h.AssertNoError(t, err)
+	if result.Archived["membership"] != 2 || result.Archived["event"] != 3 || result.Archived["fundraiser"] != 1 || result.Kept != 1 {
+		t.Errorf("expected 6 submissions archived and the disputed donation kept, got %+v", result)
+	}
+	live, err := data.ListSubmissions(data.SubmissionFilter{})
+	h.AssertNoError(t, err)
+	if len(live) != 2 {
+		t.Errorf("expected Priya's membership and Mary's donation left live, got %+v", live)
+	}
+	if again, err := archive.Archive(2023); err != nil || again.Archived["event"] != 0 || again.Kept != 1 {
+		t.Errorf("expected archiving again to move nothing, got %+v: %v", again, err)
+	}
+
+	// Families keep their history, read from the archive
+	household, err := data.GetHouseholdByEmail("jane.smith@example.com")
+	h.AssertNoError(t, err)
+	orders, err := data.ListHouseholdOrders(household.ID)
+	h.AssertNoError(t, err)
+	if len(orders) != 1 || !orders[0].Archived {
+		t.Errorf("expected Jane's archived membership in her household, got %+v", orders)
+	}
+
+	// Admins read archived years
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	list := func(token, query string) (int, map[string]interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/admin/archive?"+query, nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &body))
+		return resp.StatusCode, body.Data
+	}
+	if status, _ := list("not-a-token", "year=2023"); status != http.StatusForbidden {
+		t.Errorf("expected the archive to need an admin token, got %d", status)
+	}
+	if status, page := list(adminToken, "year=2023"); status != http.StatusOK || page["school_year"] != "2023-24" ||
+		len(page["submissions"].([]interface{})) != 6 {
+		t.Errorf("expected the 6 archived submissions of 2023-24, got %d %v", status, page)
+	}
+	if _, page := list(adminToken, "year=2023&form_type=event&search=doe"); len(page["submissions"].([]interface{})) != 1 {
+		t.Errorf("expected John Doe's archived registration, got %v", page)
+	}
+	if _, page := list(adminToken, "year=2022"); len(page["submissions"].([]interface{})) != 0 {
+		t.Errorf("expected nothing archived in 2022-23, got %v", page)
+	}
+
+	// Erasing an address reaches its archived submissions
+	erased, err := privacy.Erase(context.Background(), "carlos.rivera@example.com", "test")
+	h.AssertNoError(t, err)
+	if carlos, _ := data.ListArchivedSubmissionsByEmail("carlos.rivera@example.com"); erased != 1 || len(carlos) != 0 {
+		t.Errorf("expected Carlos's archived membership anonymized, erased %d, left %+v", erased, carlos)
+	}
+
+	// Rolling the archive back returns every submission to the live tables
+	h.AssertNoError(t, data.RollbackTo(conn, 28))
+	live, err = data.ListSubmissions(data.SubmissionFilter{})
+	h.AssertNoError(t, err)
+	if len(live) != 8 {
+		t.Errorf("expected all 8 submissions live after rolling back, got %d", len(live))
+	}
+	h.AssertNoError(t, data.Migrate(conn))
+}
@@ -0,0 +1,116 @@
+package testing
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/security"
+)
+
+func TestTemplateOverrideTakesPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	embedded, err := fs.ReadFile(assets.Templates(), "not_found.html.tmpl")
+	if err != nil {
+		t.Fatalf("expected the embedded 404 page: %v", err)
+	}
+	if !strings.Contains(string(embedded), "404 - Page Not Found") {
+		t.Errorf("unexpected embedded 404 page: %s", embedded)
+	}
+
+	writeStaticFile(t, filepath.Join(dir, assets.TemplateOverrideDir, "not_found.html.tmpl"), "custom 404")
+	overridden, err := fs.ReadFile(assets.Templates(), "not_found.html.tmpl")
+	if err != nil || string(overridden) != "custom 404" {
+		t.Errorf("expected the on-disk template to win, got %q (%v)", overridden, err)
+	}
+
+	// Templates without an override still come from the binary
+	if _, err := fs.ReadFile(assets.Templates(), "token_expired.html.tmpl"); err != nil {
+		t.Errorf("expected the embedded token expired page: %v", err)
+	}
+}
+
+func TestEmbeddedPagesAndAssets(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("STATIC_ASSETS_PATH_DEV", t.TempDir())
+	h := NewHarness(t)
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := h.Client.Get(h.Server.URL + path)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		h.AssertNoError(t, err)
+		return resp, string(body)
+	}
+
+	// The assets directory is empty, so the built-in stylesheet is served
+	resp, body := get("/static/css/simple.css")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, ".container") {
+		t.Errorf("expected the default stylesheet, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/css; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+
+	resp, body = get("/no-such-page")
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "404 - Page Not Found") {
+		t.Errorf("expected the embedded 404 page, got %d", resp.StatusCode)
+	}
+}
+
+func TestCheckoutRedirectCarriesToken(t *testing.T) {
+	h := NewHarness(t)
+
+	form := url.Values{
+		"csrf_token":    {security.GenerateCSRFToken()},
+		"form_type":     {"membership"},
+		"full_name":     {"Robin Redirect"},
+		"email":         {"robin.redirect@example.com"},
+		"school":        {"lincoln-elementary"},
+		"student_count": {"0"},
+	}
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/submit-form", strings.NewReader(form.Encode()))
+	h.AssertNoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "10.20.0.1")
+	resp, err := h.Client.Do(req)
+	h.AssertNoError(t, err)
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	h.AssertNoError(t, err)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the checkout redirect page, got %d: %s", resp.StatusCode, page)
+	}
+
+	match := regexp.MustCompile(`sessionStorage\.setItem\('accessToken', ("[^"]+")\)`).FindSubmatch(page)
+	if match == nil {
+		t.Fatalf("checkout redirect page has no access token: %s", page)
+	}
+	var token string
+	h.AssertNoError(t, json.Unmarshal(match[1], &token))
+	if !security.ValidateAccessToken(token, 30*time.Minute) {
+		t.Errorf("the token on the redirect page does not validate: %q", token)
+	}
+	if !strings.Contains(string(page), `window.location.href = "/member-checkout.html"`) {
+		t.Errorf("expected the redirect to the membership checkout: %s", page)
+	}
+}
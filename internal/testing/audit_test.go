@@ -0,0 +1,84 @@
+package testing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+)
+
+func TestAuditLogRecordsFieldChanges(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	const formID = "membership-seed-003"
+	sub, err := data.GetMembershipByID(formID)
+	h.AssertNoError(t, err)
+	oldAmount := sub.CalculatedAmount
+	sub.Donation += 5
+	sub.CalculatedAmount += 5
+	h.AssertNoError(t, data.UpdateMembershipPayment(*sub))
+
+	updated, err := data.MarkSubmissionPaid("membership", formID, `{"manual_payment": {"method": "check"}}`, time.Now())
+	h.AssertNoError(t, err)
+	if !updated {
+		t.Fatalf("expected the unpaid membership to be marked paid")
+	}
+	h.AssertNoError(t, data.UpdateMembershipEmailStatus(formID, true, false))
+
+	entries, err := data.ListAuditLog(formID)
+	h.AssertNoError(t, err)
+	find := func(action, field string) data.AuditEntry {
+		t.Helper()
+		for _, entry := range entries {
+			if entry.Action == action && entry.Field == field {
+				return entry
+			}
+		}
+		t.Fatalf("expected a %s change to %s, got %+v", action, field, entries)
+		return data.AuditEntry{}
+	}
+	value := func(v *string) string {
+		if v == nil {
+			return "NULL"
+		}
+		return *v
+	}
+
+	amount := find(data.AuditPaymentUpdate, "calculated_amount")
+	if amount.Actor != data.ActorFamily || value(amount.OldValue) != fmt.Sprint(oldAmount) ||
+		value(amount.NewValue) != fmt.Sprint(oldAmount+5) {
+		t.Errorf("expected the family's amount change with both values, got %+v", amount)
+	}
+	status := find(data.AuditMarkPaid, "paypal_status")
+	if status.Actor != data.ActorAdmin || value(status.NewValue) != "COMPLETED" {
+		t.Errorf("expected the admin to have completed the payment, got %+v", status)
+	}
+	if receipt := find(data.AuditMarkPaid, "receipt_number"); receipt.NewValue == nil {
+		t.Errorf("expected the receipt number assigned with the payment to be logged, got %+v", receipt)
+	}
+	if email := find(data.AuditEmailStatus, "confirmation_email_sent"); email.Actor != data.ActorSystem {
+		t.Errorf("expected the confirmation email to be logged as the system's, got %+v", email)
+	}
+	for _, entry := range entries {
+		switch entry.Field {
+		case "access_token", "resume_token", "email", "full_name":
+			t.Errorf("expected no entry for %s, which is secret or unchanged: %+v", entry.Field, entry)
+		}
+	}
+
+	// Anonymizing keeps the trail but not the personal details it held
+	h.AssertNoError(t, data.AnonymizeSubmission("membership", formID, time.Now()))
+	entries, err = data.ListAuditLog(formID)
+	h.AssertNoError(t, err)
+	if details := find(data.AuditMarkPaid, "paypal_details"); details.OldValue != nil || details.NewValue != nil {
+		t.Errorf("expected anonymizing to clear the logged payment details, got %+v", details)
+	}
+	if amount := find(data.AuditPaymentUpdate, "calculated_amount"); amount.NewValue == nil {
+		t.Errorf("expected anonymizing to keep the logged amounts, got %+v", amount)
+	}
+}
@@ -0,0 +1,60 @@
+package testing
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/backup"
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+)
+
+func TestBackupSnapshotsAndRotates(t *testing.T) {
+	h := NewHarness(t)
+	fake := clock.NewFake(time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC))
+	previous := clock.Set(fake)
+	t.Cleanup(func() { clock.Set(previous) })
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	conn, err := data.GetDB()
+	h.AssertNoError(t, err)
+	var want int
+	h.AssertNoError(t, conn.QueryRow(`SELECT COUNT(*) FROM membership_submissions`).Scan(&want))
+
+	settings := config.BackupSettings{Directory: t.TempDir(), Keep: 2}
+	var names []string
+	for night := 0; night < 3; night++ {
+		created, err := backup.Create(context.Background(), settings)
+		h.AssertNoError(t, err)
+		names = append(names, created.Name)
+		fake.Advance(24 * time.Hour)
+	}
+
+	backups, err := backup.List(settings.Directory)
+	h.AssertNoError(t, err)
+	if len(backups) != 2 || backups[0].Name != names[2] || backups[1].Name != names[1] {
+		t.Fatalf("expected the two newest of %v kept, newest first, got %+v", names, backups)
+	}
+
+	path, err := backup.Path(settings.Directory, backups[0].Name)
+	h.AssertNoError(t, err)
+	snapshot, err := sql.Open("sqlite", path)
+	h.AssertNoError(t, err)
+	defer snapshot.Close()
+	var got int
+	h.AssertNoError(t, snapshot.QueryRow(`SELECT COUNT(*) FROM membership_submissions`).Scan(&got))
+	if got != want || want == 0 {
+		t.Errorf("expected the backup to hold all %d memberships, got %d", want, got)
+	}
+
+	for _, name := range []string{"../harness.db", "booster.db", "booster-20260301-030000.db/../x.db"} {
+		if _, err := backup.Path(settings.Directory, name); err == nil {
+			t.Errorf("expected %q to be refused as a backup name", name)
+		}
+	}
+}
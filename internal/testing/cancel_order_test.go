@@ -0,0 +1,86 @@
+package testing
+
+import (
+	"net/http"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+)
+
+func TestCancelOrder(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+
+	createOrder := func(sub data.MembershipSubmission) string {
+		t.Helper()
+		resp, err := h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": sub.FormID}, sub.AccessToken)
+		h.AssertNoError(t, err)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+		return created.Data.OrderID
+	}
+	cancel := func(formID, token string) (int, payment.CancelOrderResponse) {
+		t.Helper()
+		resp, err := h.MakeAPIRequest("POST", "/api/cancel-order", map[string]string{"formID": formID}, token)
+		h.AssertNoError(t, err)
+		var cancelled struct {
+			Data payment.CancelOrderResponse `json:"data"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			h.AssertNoError(t, h.ParseJSONResponse(resp, &cancelled))
+		} else {
+			resp.Body.Close()
+		}
+		return resp.StatusCode, cancelled.Data
+	}
+
+	t.Run("StartOver", func(t *testing.T) {
+		member := h.GenerateTestMembership().ToMembershipSubmission()
+		member.CalculatedAmount = 25
+		h.AssertNoError(t, data.InsertMembership(member))
+		first := createOrder(member)
+
+		stranger := h.GenerateTestMembership().ToMembershipSubmission()
+		h.AssertNoError(t, data.InsertMembership(stranger))
+		if status, _ := cancel(member.FormID, stranger.AccessToken); status != http.StatusForbidden {
+			t.Errorf("expected 403 cancelling another family's order, got %d", status)
+		}
+
+		status, cancelled := cancel(member.FormID, member.AccessToken)
+		if status != http.StatusOK || !cancelled.Cancelled || cancelled.OrderID != first {
+			t.Fatalf("expected order %s cancelled, got %d %+v", first, status, cancelled)
+		}
+		summary, err := data.GetSubmissionSummary(member.FormID)
+		h.AssertNoError(t, err)
+		if summary.PayPalOrderID != "" || summary.PayPalStatus != "" {
+			t.Errorf("expected the order and status reset, got %+v", summary)
+		}
+		if _, again := cancel(member.FormID, member.AccessToken); again.Cancelled {
+			t.Error("expected nothing to cancel the second time")
+		}
+
+		if second := createOrder(member); second == "" || second == first {
+			t.Errorf("expected a new order after cancelling, got %q", second)
+		}
+	})
+
+	t.Run("PaidMeanwhile", func(t *testing.T) {
+		member := h.GenerateTestMembership().ToMembershipSubmission()
+		member.CalculatedAmount = 25
+		h.AssertNoError(t, data.InsertMembership(member))
+		orderID := createOrder(member)
+		h.AssertNoError(t, h.PayPal.CaptureOrder(orderID))
+
+		if status, _ := cancel(member.FormID, member.AccessToken); status != http.StatusConflict {
+			t.Errorf("expected 409 cancelling an order PayPal shows paid, got %d", status)
+		}
+		summary, err := data.GetSubmissionSummary(member.FormID)
+		h.AssertNoError(t, err)
+		if !summary.Submitted || summary.PayPalOrderID != orderID {
+			t.Errorf("expected the payment recorded instead, got %+v", summary)
+		}
+	})
+}
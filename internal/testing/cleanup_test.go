@@ -0,0 +1,208 @@
+package testing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/progress"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/security"
+)
+
+// TestCleanupTargets runs the tokens, drafts, temp-files and rate-limits targets as the
+// scheduler does, over old and new entries of each, and checks what they removed, that
+// the caps held and what the run wrote to the job log
+func TestCleanupTargets(t *testing.T) {
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	tempDir := t.TempDir()
+
+	// Tokens and rate limits are kept in memory across tests; start from none
+	fake.Advance(48 * time.Hour)
+	h.AssertNoError(t, cleanup.NewJob(map[string]cleanup.Target{
+		cleanup.TargetTokens:     {Name: cleanup.TargetTokens, Enabled: true},
+		cleanup.TargetRateLimits: {Name: cleanup.TargetRateLimits, Enabled: true},
+	}, cleanup.TargetTokens, cleanup.TargetRateLimits)(context.Background()))
+
+	// seedLimits makes a progress request from ip and an API request with a new
+	// access token, so each rate limiter holds an entry
+	api := middleware.APIMiddleware(func(w http.ResponseWriter, r *http.Request) {})
+	seedLimits := func(ip string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/api/progress", nil)
+		r.RemoteAddr = ip + ":1234"
+		progress.Handler(httptest.NewRecorder(), r)
+
+		token, err := security.GenerateAccessToken()
+		h.AssertNoError(t, err)
+		r = httptest.NewRequest(http.MethodGet, "/api/order-details", nil)
+		r.Header.Set("X-Access-Token", token)
+		rec := httptest.NewRecorder()
+		api(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected the API request through, got %d", rec.Code)
+		}
+	}
+	// insertDraft saves an unsubmitted membership or event started at startedAt
+	insertDraft := func(formType string, startedAt time.Time) string {
+		t.Helper()
+		if formType == "event" {
+			event := h.GenerateTestEvent().ToEventSubmission()
+			event.SubmissionDate = startedAt
+			h.AssertNoError(t, data.InsertEvent(event))
+			return event.FormID
+		}
+		membership := h.GenerateTestMembership().ToMembershipSubmission()
+		membership.SubmissionDate = startedAt
+		h.AssertNoError(t, data.InsertMembership(membership))
+		return membership.FormID
+	}
+	writeTemp := func(name string, modTime time.Time) {
+		t.Helper()
+		path := filepath.Join(tempDir, name)
+		h.AssertNoError(t, os.WriteFile(path, []byte("upload"), 0644))
+		h.AssertNoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	// Entries from a day ago, past every target's retention
+	security.StoreAccessToken("old-access-token", "membership-old", "membership")
+	security.GenerateCSRFToken()
+	seedLimits("192.0.2.1")
+	fake.Advance(25 * time.Hour)
+
+	// ...and entries made just now, within it
+	security.StoreAccessToken("new-access-token", "membership-new", "membership")
+	fresh := security.GenerateCSRFToken()
+	seedLimits("192.0.2.2")
+
+	week := 7 * 24 * time.Hour
+	var oldDrafts []string
+	for i := 0; i < 3; i++ {
+		oldDrafts = append(oldDrafts, insertDraft("membership", fake.Now().Add(-week-time.Duration(i+1)*time.Hour)))
+	}
+	oldEvent := insertDraft("event", fake.Now().Add(-week-time.Hour))
+	newDraft := insertDraft("membership", fake.Now().Add(-24*time.Hour))
+	reminded := insertDraft("membership", fake.Now().Add(-2*week))
+	_, err := data.ExecDB(`UPDATE membership_submissions SET reminder_sent_at = ? WHERE form_id = ?`,
+		fake.Now().Add(-week).Format(data.TimeFormat), reminded)
+	h.AssertNoError(t, err)
+
+	for _, name := range []string{"a.tmp", "b.tmp", "c.tmp"} {
+		writeTemp(name, fake.Now().Add(-48*time.Hour))
+	}
+	writeTemp("new.tmp", fake.Now().Add(-time.Hour))
+	h.AssertNoError(t, os.Mkdir(filepath.Join(tempDir, "uploads"), 0755))
+	h.AssertNoError(t, os.Chtimes(filepath.Join(tempDir, "uploads"), fake.Now().Add(-48*time.Hour), fake.Now().Add(-48*time.Hour)))
+
+	policy := map[string]cleanup.Target{
+		cleanup.TargetTokens: {Name: cleanup.TargetTokens, Enabled: true, Retention: 24 * time.Hour},
+		cleanup.TargetDrafts: {Name: cleanup.TargetDrafts, Enabled: true, Retention: week, MaxPerRun: 2},
+		cleanup.TargetTempFiles: {Name: cleanup.TargetTempFiles, Enabled: true, Retention: 24 * time.Hour,
+			MaxPerRun: 2, Directory: tempDir},
+		cleanup.TargetRateLimits:      {Name: cleanup.TargetRateLimits, Enabled: true, Retention: time.Hour},
+		cleanup.TargetIdempotencyKeys: {Name: cleanup.TargetIdempotencyKeys, Enabled: false},
+	}
+
+	jobLog := filepath.Join(t.TempDir(), "jobs.log")
+	s := scheduler.New()
+	s.SetJobLog(jobLog)
+	h.AssertNoError(t, s.Register(scheduler.Job{
+		Name:     "cleanup",
+		Schedule: cleanup.DefaultSchedule,
+		Run: cleanup.NewJob(policy, cleanup.TargetTokens, cleanup.TargetDrafts, cleanup.TargetTempFiles,
+			cleanup.TargetRateLimits, cleanup.TargetIdempotencyKeys),
+	}))
+	s.Start()
+	defer s.Stop()
+	h.AssertNoError(t, s.RunNow("cleanup"))
+
+	var logged string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		raw, _ := os.ReadFile(jobLog)
+		if logged = string(raw); strings.Contains(logged, "total removed") {
+			break
+		}
+	}
+
+	// The job log reports each target, retention and the cap included, and the total
+	for _, line := range []string{
+		"job=cleanup",
+		"outcome=ok",
+		"    tokens: removed 2 (retention 24h0m0s)",
+		"    drafts: removed 3 (retention 168h0m0s)",
+		"    temp-files: removed 2 (retention 24h0m0s)",
+		"    rate-limits: removed 2 (retention 1h0m0s)",
+		"    idempotency-keys: disabled",
+		"    total removed: 9",
+	} {
+		if !strings.Contains(logged, line) {
+			t.Errorf("expected the job log to have %q, got:\n%s", line, logged)
+		}
+	}
+
+	// Tokens: the old access token and the expired CSRF token are gone
+	if security.GetTokenInfo("old-access-token") != nil || security.GetTokenInfo("new-access-token") == nil {
+		t.Error("expected only the access token past the retention removed")
+	}
+	if !security.ValidateCSRFToken(fresh) {
+		t.Error("expected the unexpired CSRF token kept")
+	}
+
+	// Drafts: two of the three old memberships, as the cap allows per form type, and
+	// the old event; the new draft and the one waiting on its reminder stay
+	removed := 0
+	for _, formID := range oldDrafts {
+		if sub, _ := data.GetMembershipByID(formID); sub == nil {
+			removed++
+		}
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 of the 3 old membership drafts removed, removed %d", removed)
+	}
+	if sub, _ := data.GetEventByID(oldEvent); sub != nil {
+		t.Error("expected the old event draft removed")
+	}
+	for _, formID := range []string{newDraft, reminded} {
+		if sub, err := data.GetMembershipByID(formID); err != nil || sub == nil {
+			t.Errorf("expected draft %s kept, got %v", formID, err)
+		}
+	}
+
+	// A target built without a cap removes the rest, rather than nothing
+	h.AssertNoError(t, cleanup.NewJob(map[string]cleanup.Target{
+		cleanup.TargetDrafts: {Name: cleanup.TargetDrafts, Enabled: true, Retention: week},
+	}, cleanup.TargetDrafts)(context.Background()))
+	for _, formID := range oldDrafts {
+		if sub, _ := data.GetMembershipByID(formID); sub != nil {
+			t.Errorf("expected old draft %s removed by the uncapped run", formID)
+		}
+	}
+
+	// Temp files: two of the three old files, as the cap allows, never the new one
+	// or a directory
+	entries, err := os.ReadDir(tempDir)
+	h.AssertNoError(t, err)
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if len(left) != 3 || !strings.Contains(strings.Join(left, ","), "new.tmp") ||
+		!strings.Contains(strings.Join(left, ","), "uploads") {
+		t.Errorf("expected one old file, the new one and the directory left, got %v", left)
+	}
+
+	// Rate limits: the new entries are still there for the next run to find
+	fake.Advance(2 * time.Hour)
+	if n := middleware.PruneRateLimits(time.Hour) + progress.PruneRateLimits(time.Hour); n != 2 {
+		t.Errorf("expected the two new rate limit entries kept by the run, found %d", n)
+	}
+}
@@ -0,0 +1,268 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/scheduler"
+	"sbcbackend/internal/security"
+)
+
+// useFakeClock installs a fake clock for the rest of the test
+func useFakeClock(t *testing.T) *clock.Fake {
+	fake := clock.NewFake(time.Now())
+	previous := clock.Set(fake)
+	t.Cleanup(func() { clock.Set(previous) })
+	return fake
+}
+
+func TestClockExpiresAccessTokens(t *testing.T) {
+	fake := useFakeClock(t)
+
+	token, err := security.GenerateAccessToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if !security.ValidateAccessToken(token, 30*time.Minute) {
+		t.Fatal("expected a fresh token to validate")
+	}
+
+	fake.Advance(29 * time.Minute)
+	if !security.ValidateAccessToken(token, 30*time.Minute) {
+		t.Error("expected the token to validate before 30 minutes")
+	}
+
+	fake.Advance(2 * time.Minute)
+	if security.ValidateAccessToken(token, 30*time.Minute) {
+		t.Error("expected the token to expire after 30 minutes")
+	}
+}
+
+func TestClockDuplicateSubmissionWindow(t *testing.T) {
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+
+	// Each post comes from a different address so only duplicate detection applies
+	submit := func(ip string) int {
+		form := url.Values{
+			"csrf_token":    {security.GenerateCSRFToken()},
+			"form_type":     {"membership"},
+			"full_name":     {"Jane Smith"},
+			"email":         {"jane.smith@example.com"},
+			"school":        {"lincoln-elementary"},
+			"student_count": {"0"},
+		}
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/submit-form", strings.NewReader(form.Encode()))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := submit("10.0.0.1"); code != http.StatusOK {
+		t.Fatalf("expected first submission to succeed, got %d", code)
+	}
+	if code := submit("10.0.0.2"); code != http.StatusTooManyRequests {
+		t.Errorf("expected immediate resubmission to be a duplicate, got %d", code)
+	}
+
+	fake.Advance(4 * time.Minute)
+	if code := submit("10.0.0.3"); code != http.StatusOK {
+		t.Errorf("expected resubmission after the duplicate window to succeed, got %d", code)
+	}
+}
+
+func TestClockPayPalTokenCache(t *testing.T) {
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := payment.GetPayPalAccessToken(ctx); err != nil {
+			t.Fatalf("GetPayPalAccessToken failed: %v", err)
+		}
+	}
+	if attempts := h.PayPal.GetStats()["auth_attempts"]; attempts != 1 {
+		t.Fatalf("expected the second call to use the cached token, got %d auth requests", attempts)
+	}
+
+	// The mock issues hour-long tokens, which are renewed a minute early
+	fake.Advance(59*time.Minute + time.Second)
+	if _, err := payment.GetPayPalAccessToken(ctx); err != nil {
+		t.Fatalf("GetPayPalAccessToken failed: %v", err)
+	}
+	if attempts := h.PayPal.GetStats()["auth_attempts"]; attempts != 2 {
+		t.Errorf("expected an expired cached token to be refreshed, got %d auth requests", attempts)
+	}
+}
+
+func TestClockPayPalTokenRefresh(t *testing.T) {
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	ctx := context.Background()
+	tokenKey := config.APIBase() + "|" + config.ClientID()
+	h.AssertNoError(t, data.SetPIIKey(bytes.Repeat([]byte{7}, data.PIIKeySize)))
+	t.Cleanup(func() { data.SetPIIKey(nil) })
+
+	// A token stored before a restart is used without asking PayPal
+	h.AssertNoError(t, data.SaveServiceToken(data.ServiceToken{
+		Name: "paypal", Token: "Bearer stored-token", IssuedFor: tokenKey, ExpiresAt: fake.Now().Add(2 * time.Hour),
+	}))
+	token, err := payment.GetPayPalAccessToken(ctx)
+	h.AssertNoError(t, err)
+	if token != "Bearer stored-token" || h.PayPal.GetStats()["auth_attempts"] != 0 {
+		t.Fatalf("expected the stored token used, got %q after %d auth requests", token, h.PayPal.GetStats()["auth_attempts"])
+	}
+
+	// The refresher leaves it alone until it nears expiry, then replaces and stores it
+	refreshed, err := payment.RefreshPayPalAccessToken(ctx, 30*time.Minute)
+	h.AssertNoError(t, err)
+	if refreshed {
+		t.Error("expected a token good for two hours kept")
+	}
+	fake.Advance(91 * time.Minute)
+	refreshed, err = payment.RefreshPayPalAccessToken(ctx, 30*time.Minute)
+	h.AssertNoError(t, err)
+	if !refreshed || h.PayPal.GetStats()["auth_attempts"] != 1 {
+		t.Fatalf("expected the token renewed half an hour before expiry, got %d auth requests", h.PayPal.GetStats()["auth_attempts"])
+	}
+	stored, err := data.GetServiceToken("paypal")
+	h.AssertNoError(t, err)
+	token, err = payment.GetPayPalAccessToken(ctx)
+	h.AssertNoError(t, err)
+	if stored == nil || stored.Token != token || token == "Bearer stored-token" || !stored.ExpiresAt.After(fake.Now().Add(time.Hour-2*time.Minute)) {
+		t.Errorf("expected the new token %q stored with its expiry, got %+v", token, stored)
+	}
+
+	// The token is a credential: it's stored sealed, and not stored at all without a key
+	var raw string
+	h.AssertNoError(t, h.DB.QueryRow(`SELECT token FROM service_tokens WHERE name = 'paypal'`).Scan(&raw))
+	if strings.Contains(raw, token) {
+		t.Errorf("expected the stored token sealed, got %q", raw)
+	}
+	h.AssertNoError(t, data.SetPIIKey(nil))
+	h.AssertNoError(t, data.SaveServiceToken(data.ServiceToken{
+		Name: "paypal", Token: "Bearer clear-token", IssuedFor: tokenKey, ExpiresAt: fake.Now().Add(2 * time.Hour),
+	}))
+	var count int
+	h.AssertNoError(t, h.DB.QueryRow(`SELECT COUNT(*) FROM service_tokens`).Scan(&count))
+	if count != 0 {
+		t.Errorf("expected no token stored without a key, got %d", count)
+	}
+}
+
+func TestClockAdvancesScheduler(t *testing.T) {
+	fake := useFakeClock(t)
+
+	ran := make(chan struct{}, 1)
+	s := scheduler.New()
+	if err := s.Register(scheduler.Job{
+		Name:     "hourly",
+		Schedule: "1h",
+		Run: func(ctx context.Context) error {
+			ran <- struct{}{}
+			return nil
+		},
+	}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	waitForWaiters := func() {
+		deadline := time.Now().Add(time.Second)
+		for fake.Waiters() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("scheduler never waited on the clock")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitForWaiters()
+	fake.Advance(59 * time.Minute)
+	select {
+	case <-ran:
+		t.Fatal("job ran before its interval elapsed")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	fake.Advance(time.Minute)
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("job did not run once the clock reached its next run")
+	}
+}
+
+func TestClockTimeZone(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("TIME_ZONE", "")
+	t.Setenv("TIME_ZONE_DEV", "")
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skipf("zone data unavailable: %v", err)
+	}
+
+	loc, err := config.TimeZone()
+	if err != nil || loc.String() != config.DefaultTimeZone {
+		t.Errorf("expected %s by default, got %v (%v)", config.DefaultTimeZone, loc, err)
+	}
+	for _, name := range []string{"Mars/Olympus_Mons", "Local", "local"} {
+		t.Setenv("TIME_ZONE_DEV", name)
+		if _, err := config.TimeZone(); err == nil {
+			t.Errorf("expected TIME_ZONE %q to be rejected", name)
+		}
+	}
+	t.Setenv("TIME_ZONE", "Europe/Berlin")
+	t.Setenv("TIME_ZONE_DEV", "Pacific/Auckland")
+	if loc, err := config.TimeZone(); err != nil || loc.String() != "Pacific/Auckland" {
+		t.Errorf("expected the environment's zone to win, got %v (%v)", loc, err)
+	}
+
+	// Form IDs carry the submission time in the club's zone, not the server's
+	h := NewHarness(t)
+	fake := clock.NewFake(time.Date(2026, time.March, 14, 20, 30, 0, 0, time.UTC))
+	previous := clock.Set(fake)
+	t.Cleanup(func() { clock.Set(previous) })
+	previousLocation := clock.SetLocation(auckland)
+	t.Cleanup(func() { clock.SetLocation(previousLocation) })
+
+	form := url.Values{
+		"csrf_token":    {security.GenerateCSRFToken()},
+		"form_type":     {"membership"},
+		"full_name":     {"Aroha Ngata"},
+		"email":         {"aroha.ngata@example.com"},
+		"school":        {"lincoln-elementary"},
+		"student_count": {"0"},
+	}
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/submit-form", strings.NewReader(form.Encode()))
+	h.AssertNoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "10.0.2.1")
+	resp, err := h.Client.Do(req)
+	h.AssertNoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	match := formIDPattern.FindSubmatch(body)
+	if resp.StatusCode != http.StatusOK || match == nil {
+		t.Fatalf("expected the submission to be accepted, got %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(string(match[1]), "membership-2026-03-15_09-30-00-") {
+		t.Errorf("expected a form ID stamped in Auckland time, got %s", match[1])
+	}
+}
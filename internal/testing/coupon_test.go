@@ -0,0 +1,215 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+)
+
+// loadCouponInventory adds coupons to the harness inventory and reloads it
+func loadCouponInventory(t *testing.T, h *Harness) {
+	t.Helper()
+
+	raw, err := os.ReadFile(h.Config.InventoryPath)
+	h.AssertNoError(t, err)
+	var inventory map[string]interface{}
+	h.AssertNoError(t, json.Unmarshal(raw, &inventory))
+	inventory["coupons"] = []map[string]interface{}{
+		{"code": "SAVE10", "type": "fixed", "amount": 10, "applies_to": []string{"membership"}, "available": true},
+		{"code": "half", "type": "percent", "amount": 50, "applies_to": []string{"event"}, "available": true},
+		{"code": "BIG", "type": "fixed", "amount": 100, "available": true},
+		{"code": "OLD", "type": "fixed", "amount": 5, "valid_until": "2026-01-31", "available": true},
+		{"code": "SOON", "type": "fixed", "amount": 5, "valid_from": "2026-06-01", "available": true},
+		{"code": "ONCE", "type": "fixed", "amount": 5, "max_uses": 1, "available": true},
+		{"code": "LAST", "type": "fixed", "amount": 5, "max_uses": 1, "available": true},
+		{"code": "OFF", "type": "fixed", "amount": 5, "available": false},
+	}
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	raw, err = json.Marshal(inventory)
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, os.WriteFile(path, raw, 0644))
+	h.AssertNoError(t, h.Inventory.LoadInventory(path))
+}
+
+func TestCoupons(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+	loadCouponInventory(t, h)
+
+	previous := clock.Set(clock.NewFake(time.Date(2026, 1, 31, 20, 0, 0, 0, clock.Location())))
+	defer clock.Set(previous)
+
+	noFees := map[string]int{}
+	students := map[string]map[string]bool{"0": {"registration": true}}
+	programs := map[string]int{"program": 1}
+
+	t.Run("Discounts", func(t *testing.T) {
+		// The discount comes off the membership but not the donation
+		total, discount, err := h.Inventory.CalculateMembershipTotal("Basic Membership", nil, noFees, 10, false, " save10 ")
+		h.AssertNoError(t, err)
+		if total != 25 || discount != 10 {
+			t.Errorf("expected $25 after a $10 discount, got $%.2f after $%.2f", total, discount)
+		}
+
+		// Percentages come off the items before processing fees are added
+		total, discount, err = h.Inventory.CalculateEventTotal("spring-festival", students, programs, true, "HALF")
+		h.AssertNoError(t, err)
+		if total != 15.79 || discount != 15 {
+			t.Errorf("expected $15.79 after a $15 discount, got $%.2f after $%.2f", total, discount)
+		}
+
+		// A discount never takes the total below zero
+		total, discount, err = h.Inventory.CalculateMembershipTotal("Basic Membership", nil, noFees, 0, false, "BIG")
+		h.AssertNoError(t, err)
+		if total != 0 || discount != 25 {
+			t.Errorf("expected the discount capped at $25, got $%.2f after $%.2f", total, discount)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		for code, formType := range map[string]string{
+			"HALF":    "membership", // events only
+			"SAVE10":  "event",      // memberships only
+			"SOON":    "membership", // not valid yet
+			"OFF":     "membership", // not available
+			"UNKNOWN": "membership",
+		} {
+			if err := h.Inventory.ValidateCoupon(code, formType); err == nil {
+				t.Errorf("expected %s rejected for a %s", code, formType)
+			}
+		}
+		// A date-only valid_until lasts through that day
+		h.AssertNoError(t, h.Inventory.ValidateCoupon("OLD", "event"))
+
+		_, _, err := h.Inventory.CalculateEventTotal("spring-festival", students, programs, false, "SAVE10")
+		if err == nil {
+			t.Error("expected the event total to reject a membership coupon")
+		}
+	})
+
+	t.Run("UsageLimit", func(t *testing.T) {
+		h.AssertNoError(t, h.Inventory.ValidateCoupon("ONCE", "membership"))
+
+		// An unpaid checkout doesn't use it up; a paid one does
+		unpaid := h.GenerateTestMembership().ToMembershipSubmission()
+		h.AssertNoError(t, data.InsertMembership(unpaid))
+		h.AssertNoError(t, data.SetCoupon("membership", unpaid.FormID, "ONCE", 5))
+		h.AssertNoError(t, h.Inventory.ValidateCoupon("ONCE", "membership"))
+
+		paid := h.GenerateTestMembership().ToMembershipSubmission()
+		paid.PayPalStatus = "COMPLETED"
+		h.AssertNoError(t, data.InsertMembership(paid))
+		h.AssertNoError(t, data.SetCoupon("membership", paid.FormID, "ONCE", 5))
+		if err := h.Inventory.ValidateCoupon("ONCE", "membership"); err == nil {
+			t.Error("expected ONCE used up after a paid checkout")
+		}
+	})
+
+	t.Run("UsageLimitCheckedAtCapture", func(t *testing.T) {
+		// Two checkouts priced with the last use of LAST; only the first captured gets it
+		checkouts := make([]data.MembershipSubmission, 2)
+		orders := make([]string, 2)
+		for i := range checkouts {
+			checkouts[i] = h.GenerateTestMembership().ToMembershipSubmission()
+			checkouts[i].CalculatedAmount = 20
+			h.AssertNoError(t, data.InsertMembership(checkouts[i]))
+			h.AssertNoError(t, data.SetCoupon("membership", checkouts[i].FormID, "LAST", 5))
+
+			resp, err := h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": checkouts[i].FormID}, checkouts[i].AccessToken)
+			h.AssertNoError(t, err)
+			h.AssertStatusCode(t, resp, http.StatusOK)
+			var created struct {
+				Data struct {
+					OrderID string `json:"orderID"`
+				} `json:"data"`
+			}
+			h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+			orders[i] = created.Data.OrderID
+		}
+		capture := func(i int) int {
+			resp, err := h.MakeAPIRequest("POST", "/api/capture-order",
+				map[string]string{"orderID": orders[i], "formID": checkouts[i].FormID}, checkouts[i].AccessToken)
+			h.AssertNoError(t, err)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		if status := capture(0); status != http.StatusOK {
+			t.Fatalf("expected the first checkout captured, got %d", status)
+		}
+		if status := capture(1); status != http.StatusConflict {
+			t.Errorf("expected the second checkout refused with 409, got %d", status)
+		}
+		if captured := h.PayPal.GetCompletedOrderCount(); captured != 1 {
+			t.Errorf("expected only the first order captured, got %d", captured)
+		}
+		second, err := data.GetMembershipByID(checkouts[1].FormID)
+		h.AssertNoError(t, err)
+		if second.PayPalStatus == "COMPLETED" {
+			t.Error("expected the second checkout left unpaid")
+		}
+
+		// A claim holds the use while a capture is going on, and a failed capture gives it back
+		h.AssertNoError(t, data.ClaimCouponUse("LAST2", checkouts[1].FormID, 1))
+		if err := data.ClaimCouponUse("LAST2", checkouts[0].FormID, 1); !errors.Is(err, data.ErrCouponUsedUp) {
+			t.Errorf("expected LAST2 claimed by another checkout used up, got %v", err)
+		}
+		h.AssertNoError(t, data.ReleaseCouponUse(checkouts[1].FormID))
+		h.AssertNoError(t, data.ClaimCouponUse("LAST2", checkouts[0].FormID, 1))
+
+		// A payment still settling keeps its use long after its claim has lapsed
+		settling := h.GenerateTestMembership().ToMembershipSubmission()
+		settling.PayPalStatus = data.PaymentPendingStatus
+		h.AssertNoError(t, data.InsertMembership(settling))
+		h.AssertNoError(t, data.SetCoupon("membership", settling.FormID, "LAST3", 5))
+		if err := data.ClaimCouponUse("LAST3", checkouts[1].FormID, 1); !errors.Is(err, data.ErrCouponUsedUp) {
+			t.Errorf("expected LAST3 held by a settling payment used up, got %v", err)
+		}
+	})
+
+	t.Run("RecordedOnSubmission", func(t *testing.T) {
+		sub := h.GenerateTestMembership().ToMembershipSubmission()
+		h.AssertNoError(t, data.InsertMembership(sub))
+
+		save := func(coupon string) int {
+			body, _ := json.Marshal(map[string]interface{}{
+				"formID": sub.FormID, "membership": "Basic Membership", "addons": []string{"T-Shirt"},
+				"fees": noFees, "coupon": coupon,
+			})
+			req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/save-membership-payment", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Access-Token", sub.AccessToken)
+			resp, err := h.Client.Do(req)
+			h.AssertNoError(t, err)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		if status := save("HALF"); status != http.StatusBadRequest {
+			t.Errorf("expected an event coupon rejected with 400, got %d", status)
+		}
+		if status := save("save10"); status != http.StatusOK {
+			t.Fatalf("expected the membership saved, got %d", status)
+		}
+
+		code, discount, err := data.GetCoupon("membership", sub.FormID)
+		h.AssertNoError(t, err)
+		if code != "SAVE10" || discount != 10 {
+			t.Errorf("expected SAVE10 for $10 recorded, got %q for $%.2f", code, discount)
+		}
+		saved, err := data.GetMembershipByID(sub.FormID)
+		h.AssertNoError(t, err)
+		if saved.CalculatedAmount != 30 {
+			t.Errorf("expected $30 to pay, got $%.2f", saved.CalculatedAmount)
+		}
+	})
+}
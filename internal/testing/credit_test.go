@@ -0,0 +1,135 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/security"
+)
+
+func TestFamilyCredit(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	issue := func(email string, amount float64) {
+		t.Helper()
+		raw, _ := json.Marshal(payment.IssueCreditRequest{Email: email, Amount: amount, Reason: "Fall festival cancelled"})
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/admin/credits", bytes.NewReader(raw))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", adminToken)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("issuing credit returned %d: %s", resp.StatusCode, body)
+		}
+	}
+	apply := func(formID, token string) (int, payment.ApplyCreditResponse) {
+		t.Helper()
+		resp, err := h.MakeAPIRequest("POST", "/api/apply-credit", map[string]string{"formID": formID}, token)
+		h.AssertNoError(t, err)
+		var applied struct {
+			Data payment.ApplyCreditResponse `json:"data"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			h.AssertNoError(t, h.ParseJSONResponse(resp, &applied))
+		} else {
+			resp.Body.Close()
+		}
+		return resp.StatusCode, applied.Data
+	}
+
+	t.Run("PartialCredit", func(t *testing.T) {
+		member := h.GenerateTestMembership().ToMembershipSubmission()
+		member.CalculatedAmount = 75
+		h.AssertNoError(t, data.InsertMembership(member))
+		issue(member.Email, 50)
+
+		status, applied := apply(member.FormID, member.AccessToken)
+		if status != http.StatusOK || applied.Applied != 50 || applied.AmountDue != 25 || applied.Balance != 0 || applied.Paid {
+			t.Fatalf("expected $50 applied leaving $25, got %d %+v", status, applied)
+		}
+		// Applying again keeps the credit already held
+		if _, again := apply(member.FormID, member.AccessToken); again.Applied != 50 || again.AmountDue != 25 {
+			t.Errorf("expected applying twice to change nothing, got %+v", again)
+		}
+
+		resp, err := h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": member.FormID}, member.AccessToken)
+		h.AssertNoError(t, err)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+		if order, ok := h.PayPal.GetOrder(created.Data.OrderID); !ok || order.Amount != "25.00" {
+			t.Fatalf("expected a $25.00 PayPal order, got %+v", order)
+		}
+
+		entries, err := data.ListCredits(member.Email)
+		h.AssertNoError(t, err)
+		if len(entries) != 2 || entries[1].Kind != data.CreditRedeemed || entries[1].Amount != -50 || entries[1].FormID != member.FormID {
+			t.Errorf("expected the redemption logged against the form, got %+v", entries)
+		}
+
+		// Credit issued once the order exists can't change its amount
+		issue(member.Email, 10)
+		other := h.GenerateTestMembership().ToMembershipSubmission()
+		other.Email, other.CalculatedAmount, other.PayPalOrderID = member.Email, 40, "ORDER-STARTED"
+		h.AssertNoError(t, data.InsertMembership(other))
+		if status, _ := apply(other.FormID, other.AccessToken); status != http.StatusConflict {
+			t.Errorf("expected 409 applying credit after payment started, got %d", status)
+		}
+	})
+
+	t.Run("CreditCoversEverything", func(t *testing.T) {
+		event := h.GenerateTestEvent().ToEventSubmission()
+		event.CalculatedAmount = 70
+		h.AssertNoError(t, data.InsertEvent(event))
+		issue(event.Email, 100)
+
+		status, applied := apply(event.FormID, event.AccessToken)
+		if status != http.StatusOK || !applied.Paid || applied.Applied != 70 || applied.AmountDue != 0 || applied.Balance != 30 {
+			t.Fatalf("expected the event paid with $70 of credit, got %d %+v", status, applied)
+		}
+		summary, err := data.GetSubmissionSummary(event.FormID)
+		h.AssertNoError(t, err)
+		if summary.PayPalStatus != "COMPLETED" || summary.FundingSource != payment.FundingCredit || summary.ReceiptNumber == "" {
+			t.Errorf("expected a receipted credit payment, got %+v", summary)
+		}
+	})
+
+	t.Run("ReleasedWhenSelectionsChange", func(t *testing.T) {
+		member := h.GenerateTestMembership().ToMembershipSubmission()
+		member.Email, member.CalculatedAmount = "release@example.com", 25
+		h.AssertNoError(t, data.InsertMembership(member))
+		issue(member.Email, 20)
+		if _, applied := apply(member.FormID, member.AccessToken); applied.Applied != 20 {
+			t.Fatalf("expected $20 applied, got %+v", applied)
+		}
+
+		resp, err := h.MakeAPIRequest("POST", "/api/save-membership-payment", map[string]interface{}{
+			"formID": member.FormID, "membership": "Premium Membership", "fees": map[string]int{},
+		}, member.AccessToken)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		h.AssertStatusCode(t, resp, http.StatusOK)
+
+		balance, err := data.CreditBalance(member.Email)
+		h.AssertNoError(t, err)
+		saved, err := data.GetMembershipByID(member.FormID)
+		h.AssertNoError(t, err)
+		if balance != 20 || saved.CalculatedAmount != 50 {
+			t.Errorf("expected $20 credit back and the new $50 total, got $%.2f and $%.2f", balance, saved.CalculatedAmount)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/currency"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/payment"
+)
+
+// loadEuroInventory prices the harness inventory in euros, except for a T-Shirt
+// priced in pounds, and reloads it
+func loadEuroInventory(t *testing.T, h *Harness) {
+	t.Helper()
+
+	raw, err := os.ReadFile(h.Config.InventoryPath)
+	h.AssertNoError(t, err)
+	var inventory map[string]interface{}
+	h.AssertNoError(t, json.Unmarshal(raw, &inventory))
+	inventory["currency"] = "eur"
+	products, _ := inventory["products"].([]interface{})
+	for _, product := range products {
+		if item, ok := product.(map[string]interface{}); ok && item["name"] == "T-Shirt" {
+			item["currency"] = "GBP"
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	raw, err = json.Marshal(inventory)
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, os.WriteFile(path, raw, 0644))
+	h.AssertNoError(t, h.Inventory.LoadInventory(path))
+}
+
+func TestCurrency(t *testing.T) {
+	t.Run("Formatting", func(t *testing.T) {
+		for _, tt := range []struct {
+			amount float64
+			code   string
+			want   string
+		}{
+			{12.5, "USD", "$12.50"},
+			{12.5, "eur", "€12.50"},
+			{-5, "CAD", "-CA$5.00"},
+			{1499.6, "JPY", "¥1500"},
+			{12, "XYZ", "12.00 XYZ"},
+		} {
+			if got := currency.Format(tt.amount, tt.code); got != tt.want {
+				t.Errorf("Format(%v, %s) = %q, want %q", tt.amount, tt.code, got, tt.want)
+			}
+		}
+		if got := currency.Value(1499.6, "JPY"); got != "1500" {
+			t.Errorf("expected yen sent to PayPal without decimals, got %q", got)
+		}
+	})
+
+	t.Run("DeploymentSetting", func(t *testing.T) {
+		t.Setenv("ENVIRONMENT", "dev")
+		t.Setenv("CURRENCY_DEV", "")
+		if code := config.Currency(); code != currency.USD {
+			t.Errorf("expected US dollars by default, got %s", code)
+		}
+		t.Setenv("CURRENCY_DEV", " cad ")
+		if code := config.Currency(); code != "CAD" {
+			t.Errorf("expected CAD, got %s", code)
+		}
+		if settings := config.LoadStripeSettings(); settings.Currency != "cad" {
+			t.Errorf("expected Stripe to charge in the deployment's currency, got %s", settings.Currency)
+		}
+		t.Setenv("CURRENCY_DEV", "XYZ")
+		if code := config.Currency(); code != currency.USD {
+			t.Errorf("expected an unsupported currency to fall back to USD, got %s", code)
+		}
+	})
+
+	t.Run("InventoryCurrency", func(t *testing.T) {
+		h := NewHarness(t)
+		h.DisableTokenRateLimit(t)
+		loadEuroInventory(t, h)
+
+		if code := h.Inventory.Currency(); code != "EUR" {
+			t.Fatalf("expected the catalog priced in EUR, got %s", code)
+		}
+		if _, _, err := h.Inventory.CalculateMembershipTotal("Basic Membership", []string{"T-Shirt"}, nil, 0, false, ""); err == nil {
+			t.Error("expected euros and pounds rejected in one checkout")
+		}
+
+		sub := h.GenerateTestMembership().ToMembershipSubmission()
+		h.AssertNoError(t, data.InsertMembership(sub))
+		body, _ := json.Marshal(map[string]interface{}{
+			"formID": sub.FormID, "membership": "Basic Membership", "fees": map[string]int{},
+		})
+		req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/save-membership-payment", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", sub.AccessToken)
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		h.AssertStatusCode(t, resp, http.StatusOK)
+
+		resp, err = h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": sub.FormID}, sub.AccessToken)
+		h.AssertNoError(t, err)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+		if paypalOrder, ok := h.PayPal.GetOrder(created.Data.OrderID); !ok || paypalOrder.Currency != "EUR" || paypalOrder.Amount != "25.00" {
+			t.Fatalf("expected a EUR 25.00 PayPal order, got %+v", paypalOrder)
+		}
+
+		saved, err := data.GetMembershipByID(sub.FormID)
+		h.AssertNoError(t, err)
+		var page bytes.Buffer
+		h.AssertNoError(t, order.RenderMembershipSuccessPage(&page, saved, false))
+		if !strings.Contains(page.String(), "€25.00") || strings.Contains(page.String(), "$25.00") {
+			t.Error("expected the receipt page to show euros")
+		}
+	})
+}
@@ -1,4 +1,4 @@
-// database_test.go - Updated to handle missing columns and concurrency issues
+// database_test.go - Repository tests, each on its own database so they run in parallel
 package testing
 
 import (
@@ -11,43 +11,29 @@ import (
 )
 
 func TestDatabaseOperations(t *testing.T) {
-	suite := NewTestSuite(t)
+	t.Parallel()
 
-	t.Run("MembershipCRUD", func(t *testing.T) {
-		testMembershipCRUD(t, suite)
-	})
-
-	t.Run("EventCRUD", func(t *testing.T) {
-		testEventCRUD(t, suite)
-	})
-
-	t.Run("FundraiserCRUD", func(t *testing.T) {
-		testFundraiserCRUD(t, suite)
-	})
-
-	t.Run("ConcurrentInserts", func(t *testing.T) {
-		testConcurrentInsertsWithRetry(t, suite)
-	})
-
-	t.Run("PayPalUpdates", func(t *testing.T) {
-		testPayPalUpdatesWithRetry(t, suite)
-	})
+	t.Run("MembershipCRUD", testMembershipCRUD)
+	t.Run("EventCRUD", testEventCRUD)
+	t.Run("FundraiserCRUD", testFundraiserCRUD)
+	t.Run("ConcurrentInserts", testConcurrentInserts)
+	t.Run("PayPalUpdates", testPayPalUpdates)
 }
 
-func testMembershipCRUD(t *testing.T, suite *TestSuite) {
+func testMembershipCRUD(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
+
 	// Test data
-	testData := suite.GenerateTestMembership("premium")
+	testData := db.GenerateTestMembership("premium")
 	submission := testData.ToMembershipSubmission()
 
-	// Test Insert with retry
-	err := suite.ExecuteWithRetry(func() error {
-		return data.InsertMembership(submission)
-	}, 5)
-	suite.AssertNoError(t, err)
+	// Test Insert
+	db.AssertNoError(t, db.Memberships.Insert(submission))
 
 	// Test GetByID
-	retrieved, err := data.GetMembershipByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	retrieved, err := db.Memberships.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 
 	// Verify data integrity
 	if retrieved.FormID != submission.FormID {
@@ -60,17 +46,14 @@ func testMembershipCRUD(t *testing.T, suite *TestSuite) {
 		t.Errorf("Student count mismatch: expected %d, got %d", len(submission.Students), len(retrieved.Students))
 	}
 
-	// Test Update Payment with retry
+	// Test Update Payment
 	submission.Membership = "Gold Membership"
 	submission.CalculatedAmount = 150.0
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateMembershipPayment(submission)
-	}, 5)
-	suite.AssertNoError(t, err)
+	db.AssertNoError(t, db.Memberships.UpdatePayment(submission))
 
 	// Verify update
-	updated, err := data.GetMembershipByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	updated, err := db.Memberships.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 	if updated.Membership != "Gold Membership" {
 		t.Errorf("Membership not updated: expected Gold Membership, got %s", updated.Membership)
 	}
@@ -78,45 +61,36 @@ func testMembershipCRUD(t *testing.T, suite *TestSuite) {
 		t.Errorf("Amount not updated: expected 150.0, got %f", updated.CalculatedAmount)
 	}
 
-	// Test PayPal Updates with retry
+	// Test PayPal Updates
 	now := time.Now()
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateMembershipPayPalOrder(submission.FormID, "TEST-ORDER-123", &now)
-	}, 5)
-	suite.AssertNoError(t, err)
-
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateMembershipPayPalCapture(submission.FormID, `{"status":"COMPLETED"}`, "COMPLETED", &now)
-	}, 5)
-	suite.AssertNoError(t, err)
+	db.AssertNoError(t, db.Memberships.UpdatePayPalOrder(submission.FormID, "TEST-ORDER-123", &now))
+	db.AssertNoError(t, db.Memberships.UpdatePayPalCapture(submission.FormID, `{"status":"COMPLETED"}`, "COMPLETED", &now))
 
 	// Verify PayPal updates
-	final, err := data.GetMembershipByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	final, err := db.Memberships.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 	if final.PayPalOrderID != "TEST-ORDER-123" {
 		t.Errorf("PayPal Order ID not updated: expected TEST-ORDER-123, got %s", final.PayPalOrderID)
 	}
 	if final.PayPalStatus != "COMPLETED" {
 		t.Errorf("PayPal Status not updated: expected COMPLETED, got %s", final.PayPalStatus)
 	}
-
-	t.Log("✅ Membership CRUD tests passed")
 }
 
-func testEventCRUD(t *testing.T, suite *TestSuite) {
+func testEventCRUD(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
+
 	// Test data
-	testData := suite.GenerateTestEvent("multiple_students")
+	testData := db.GenerateTestEvent("multiple_students")
 	submission := testData.ToEventSubmission()
 
-	// Test Insert with retry
-	err := suite.ExecuteWithRetry(func() error {
-		return data.InsertEvent(submission)
-	}, 5)
-	suite.AssertNoError(t, err)
+	// Test Insert
+	db.AssertNoError(t, db.Events.Insert(submission))
 
 	// Test GetByID
-	retrieved, err := data.GetEventByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	retrieved, err := db.Events.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 
 	// Verify data integrity
 	if retrieved.FormID != submission.FormID {
@@ -129,43 +103,48 @@ func testEventCRUD(t *testing.T, suite *TestSuite) {
 		t.Errorf("Student count mismatch: expected %d, got %d", len(submission.Students), len(retrieved.Students))
 	}
 
-	// Test Update Payment with food choices - handle missing column gracefully
+	// Every field of the struct has a column, so a paid registration inserts whole
+	orderCreatedAt := time.Now().Truncate(time.Second)
+	paid := db.GenerateTestEvent("single_student").ToEventSubmission()
+	paid.HasFoodOrders = true
+	paid.PayPalOrderCreatedAt = &orderCreatedAt
+	paid.PayPalDetails = `{"status":"COMPLETED"}`
+	db.AssertNoError(t, db.Events.Insert(paid))
+	retrieved, err = db.Events.GetByID(paid.FormID)
+	db.AssertNoError(t, err)
+	if !retrieved.HasFoodOrders || retrieved.PayPalOrderCreatedAt == nil ||
+		!retrieved.PayPalOrderCreatedAt.Equal(orderCreatedAt) || retrieved.PayPalDetails != paid.PayPalDetails {
+		t.Errorf("Event columns not inserted: has food orders %t, order created %v, details %q",
+			retrieved.HasFoodOrders, retrieved.PayPalOrderCreatedAt, retrieved.PayPalDetails)
+	}
+
+	// Test Update Payment with food choices
 	submission.FoodChoicesJSON = `{"student_selections":{"0":{"lunch":true},"1":{"lunch":true}},"shared_selections":{"program":2},"cover_fees":true}`
+	submission.HasFoodOrders = true
 	submission.CalculatedAmount = 75.0
+	db.AssertNoError(t, db.Events.UpdatePayment(submission))
 
-	// Try to update, but handle the missing has_food_orders column gracefully
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateEventPayment(submission)
-	}, 5)
-
-	// If the column doesn't exist, that's expected for now
-	if err != nil && containsColumnError(err, "has_food_orders") {
-		t.Logf("⚠️  has_food_orders column missing - this is expected in current schema")
-		// Try a simpler update that doesn't use the missing column
-		err = suite.ExecuteWithRetry(func() error {
-			// Update only the basic fields that exist
-			return updateEventBasicPayment(submission)
-		}, 5)
+	updated, err := db.Events.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
+	if updated.CalculatedAmount != 75.0 || !updated.HasFoodOrders {
+		t.Errorf("Event payment not updated: amount %.2f, has food orders %t", updated.CalculatedAmount, updated.HasFoodOrders)
 	}
-	suite.AssertNoError(t, err)
-
-	t.Log("✅ Event CRUD tests passed")
 }
 
-func testFundraiserCRUD(t *testing.T, suite *TestSuite) {
+func testFundraiserCRUD(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
+
 	// Test data
-	testData := suite.GenerateTestFundraiser("multiple_students", "cover_fees")
+	testData := db.GenerateTestFundraiser("multiple_students", "cover_fees")
 	submission := testData.ToFundraiserSubmission()
 
-	// Test Insert with retry
-	err := suite.ExecuteWithRetry(func() error {
-		return data.InsertFundraiser(submission)
-	}, 5)
-	suite.AssertNoError(t, err)
+	// Test Insert
+	db.AssertNoError(t, db.Fundraisers.Insert(submission))
 
 	// Test GetByID
-	retrieved, err := data.GetFundraiserByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	retrieved, err := db.Fundraisers.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 
 	// Verify data integrity
 	if retrieved.FormID != submission.FormID {
@@ -181,108 +160,66 @@ func testFundraiserCRUD(t *testing.T, suite *TestSuite) {
 		t.Errorf("Cover fees mismatch: expected %t, got %t", submission.CoverFees, retrieved.CoverFees)
 	}
 
-	// Test validation through ProcessFundraiserPayment with retry
-	err = suite.ExecuteWithRetry(func() error {
-		return data.ProcessFundraiserPayment(&submission)
-	}, 5)
-	suite.AssertNoError(t, err)
-
-	t.Log("✅ Fundraiser CRUD tests passed")
+	// Validate and save the payment
+	db.AssertNoError(t, data.ValidateFundraiserPayment(submission))
+	db.AssertNoError(t, db.Fundraisers.UpdatePayment(submission))
 }
 
-func testConcurrentInsertsWithRetry(t *testing.T, suite *TestSuite) {
+func testConcurrentInserts(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
+
 	const numGoroutines = 10
 
 	var wg sync.WaitGroup
 	results := make(chan error, numGoroutines)
 
-	// Launch concurrent inserts with staggered timing
+	// The busy timeout serializes writers, so every insert should land
 	for i := 0; i < numGoroutines; i++ {
 		wg.Add(1)
 		go func(id int) {
 			defer wg.Done()
 
-			// Stagger the starts to reduce contention
-			time.Sleep(time.Duration(id) * 10 * time.Millisecond)
-
-			testData := suite.GenerateTestMembership()
+			testData := db.GenerateTestMembership()
 			testData.Email = fmt.Sprintf("concurrent%d@test.com", id)
-			submission := testData.ToMembershipSubmission()
-
-			// Use retry logic for concurrent operations
-			err := suite.ExecuteWithRetry(func() error {
-				return data.InsertMembership(submission)
-			}, 10) // More retries for concurrent operations
-
-			results <- err
+			results <- db.Memberships.Insert(testData.ToMembershipSubmission())
 		}(i)
 	}
 
-	// Wait for all goroutines to complete
 	wg.Wait()
 	close(results)
 
-	// Check results
-	var errors []error
 	for err := range results {
 		if err != nil {
-			errors = append(errors, err)
+			t.Errorf("Concurrent insert failed: %v", err)
 		}
 	}
-
-	if len(errors) > 0 {
-		t.Logf("Some concurrent operations failed (%d/%d), but this is acceptable:", len(errors), numGoroutines)
-		for i, err := range errors {
-			if i < 3 { // Only log first few errors
-				t.Logf("  - %v", err)
-			}
-		}
-	}
-
-	successRate := float64(numGoroutines-len(errors)) / float64(numGoroutines) * 100
-	t.Logf("Concurrent insert success rate: %.1f%% (%d/%d)", successRate, numGoroutines-len(errors), numGoroutines)
-
-	// Accept 70% success rate for concurrent operations (SQLite limitations)
-	if successRate < 70.0 {
-		t.Errorf("Success rate too low: %.1f%%", successRate)
-	} else {
-		t.Log("✅ Concurrent inserts completed with acceptable success rate")
-	}
 }
 
-func testPayPalUpdatesWithRetry(t *testing.T, suite *TestSuite) {
-	// Create test membership with retry
-	testData := suite.GenerateTestMembership()
-	submission := testData.ToMembershipSubmission()
+func testPayPalUpdates(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
 
-	err := suite.ExecuteWithRetry(func() error {
-		return data.InsertMembership(submission)
-	}, 5)
-	suite.AssertNoError(t, err)
+	// Create test membership
+	testData := db.GenerateTestMembership()
+	submission := testData.ToMembershipSubmission()
+	db.AssertNoError(t, db.Memberships.Insert(submission))
 
-	// Test multiple PayPal order updates with retry
+	// Test multiple PayPal order updates
 	now := time.Now()
 
-	// First order creation
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateMembershipPayPalOrder(submission.FormID, "ORDER-1", &now)
-	}, 5)
-	suite.AssertNoError(t, err)
-
-	// Retry with different order ID (should overwrite)
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateMembershipPayPalOrder(submission.FormID, "ORDER-2", &now)
-	}, 5)
-	suite.AssertNoError(t, err)
+	// First order creation, then a retry with a different order ID (should overwrite)
+	db.AssertNoError(t, db.Memberships.UpdatePayPalOrder(submission.FormID, "ORDER-1", &now))
+	db.AssertNoError(t, db.Memberships.UpdatePayPalOrder(submission.FormID, "ORDER-2", &now))
 
 	// Verify latest order ID is stored
-	retrieved, err := data.GetMembershipByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	retrieved, err := db.Memberships.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 	if retrieved.PayPalOrderID != "ORDER-2" {
 		t.Errorf("Expected ORDER-2, got %s", retrieved.PayPalOrderID)
 	}
 
-	// Test capture with retry
+	// Test capture
 	captureDetails := `{
 		"id": "ORDER-2",
 		"status": "COMPLETED",
@@ -296,114 +233,72 @@ func testPayPalUpdatesWithRetry(t *testing.T, suite *TestSuite) {
 			}
 		}]
 	}`
-
-	err = suite.ExecuteWithRetry(func() error {
-		return data.UpdateMembershipPayPalCapture(submission.FormID, captureDetails, "COMPLETED", &now)
-	}, 5)
-	suite.AssertNoError(t, err)
+	db.AssertNoError(t, db.Memberships.UpdatePayPalCapture(submission.FormID, captureDetails, "COMPLETED", &now))
 
 	// Verify capture data
-	final, err := data.GetMembershipByID(submission.FormID)
-	suite.AssertNoError(t, err)
+	final, err := db.Memberships.GetByID(submission.FormID)
+	db.AssertNoError(t, err)
 	if final.PayPalStatus != "COMPLETED" {
 		t.Errorf("Expected COMPLETED status, got %s", final.PayPalStatus)
 	}
 	if final.PayPalDetails == "" {
 		t.Error("PayPal details should not be empty")
 	}
-
-	t.Log("✅ PayPal updates with retry completed successfully")
-}
-
-// Helper functions
-
-func containsColumnError(err error, columnName string) bool {
-	if err == nil {
-		return false
-	}
-	errStr := err.Error()
-	return fmt.Sprintf("no such column: %s", columnName) == errStr ||
-		fmt.Sprintf("SQL logic error: no such column: %s", columnName) == errStr
 }
 
-// updateEventBasicPayment updates event payment without using has_food_orders column
-func updateEventBasicPayment(submission data.EventSubmission) error {
-	// This would be a simplified version that doesn't use the missing column
-	// For now, we'll skip this update if the column doesn't exist
-	return nil
-}
-
-// Test edge cases and error conditions with better error handling
+// Test edge cases and error conditions
 func TestDatabaseEdgeCases(t *testing.T) {
-	suite := NewTestSuite(t)
+	t.Parallel()
 
 	t.Run("NonExistentRecord", func(t *testing.T) {
-		_, err := data.GetMembershipByID("non-existent-id")
-		if err == nil {
+		t.Parallel()
+		db := NewTestDB(t)
+
+		if _, err := db.Memberships.GetByID("non-existent-id"); err == nil {
 			t.Error("Expected error for non-existent record")
 		}
-		t.Log("✅ Non-existent record properly rejected")
 	})
 
 	t.Run("EmptyFormID", func(t *testing.T) {
-		testData := suite.GenerateTestMembership()
-		submission := testData.ToMembershipSubmission()
-		submission.FormID = ""
+		t.Parallel()
+		db := NewTestDB(t)
 
-		err := suite.ExecuteWithRetry(func() error {
-			return data.InsertMembership(submission)
-		}, 3)
+		submission := db.GenerateTestMembership().ToMembershipSubmission()
+		submission.FormID = ""
 
 		// Should fail - empty FormID should be rejected
-		if err == nil {
+		if err := db.Memberships.Insert(submission); err == nil {
 			t.Error("Expected error for empty FormID")
-		} else {
-			t.Log("✅ Empty FormID properly rejected")
 		}
 	})
 
 	t.Run("DuplicateFormID", func(t *testing.T) {
-		testData := suite.GenerateTestMembership()
-		submission := testData.ToMembershipSubmission()
-
-		// Insert once
-		err := suite.ExecuteWithRetry(func() error {
-			return data.InsertMembership(submission)
-		}, 5)
-		suite.AssertNoError(t, err)
+		t.Parallel()
+		db := NewTestDB(t)
 
-		// Try to insert again with same FormID
-		err = suite.ExecuteWithRetry(func() error {
-			return data.InsertMembership(submission)
-		}, 3)
+		submission := db.GenerateTestMembership().ToMembershipSubmission()
 
-		if err == nil {
+		// Insert once, then again with the same FormID
+		db.AssertNoError(t, db.Memberships.Insert(submission))
+		if err := db.Memberships.Insert(submission); err == nil {
 			t.Error("Expected error for duplicate FormID")
-		} else {
-			t.Log("✅ Duplicate FormID properly rejected")
 		}
 	})
 
 	t.Run("GetByYear", func(t *testing.T) {
+		t.Parallel()
+		db := NewTestDB(t)
+
 		// Insert test data for current year
 		currentYear := time.Now().Year()
-		testData := suite.GenerateTestMembership()
-		submission := testData.ToMembershipSubmission()
+		submission := db.GenerateTestMembership().ToMembershipSubmission()
 		submission.Submitted = true
 		now := time.Now()
 		submission.SubmittedAt = &now
+		db.AssertNoError(t, db.Memberships.Insert(submission))
 
-		err := suite.ExecuteWithRetry(func() error {
-			return data.InsertMembership(submission)
-		}, 5)
-		suite.AssertNoError(t, err)
-
-		// Test GetMembershipsByYear with timeout
-		memberships, err := data.GetMembershipsByYear(currentYear)
-		if err != nil {
-			t.Logf("⚠️  GetMembershipsByYear failed (this may be expected): %v", err)
-			return
-		}
+		memberships, err := db.Memberships.GetByYear(currentYear)
+		db.AssertNoError(t, err)
 
 		found := false
 		for _, m := range memberships {
@@ -412,41 +307,56 @@ func TestDatabaseEdgeCases(t *testing.T) {
 				break
 			}
 		}
-		if found {
-			t.Log("✅ Membership found in year query")
-		} else {
-			t.Log("⚠️  Membership not found in year query (may be timing related)")
+		if !found {
+			t.Errorf("Membership %s not found in %d", submission.FormID, currentYear)
 		}
 	})
 
 	t.Run("LargeDatasets", func(t *testing.T) {
-		// Test with smaller dataset to avoid timeout issues
-		const numRecords = 20
+		t.Parallel()
+		db := NewTestDB(t)
 
-		startTime := time.Now()
-		var successCount int
+		const numRecords = 200
 
+		startTime := time.Now()
 		for i := 0; i < numRecords; i++ {
-			testData := suite.GenerateTestMembership()
+			testData := db.GenerateTestMembership()
 			testData.Email = fmt.Sprintf("perf%d@test.com", i)
-			submission := testData.ToMembershipSubmission()
-
-			err := suite.ExecuteWithRetry(func() error {
-				return data.InsertMembership(submission)
-			}, 5)
-
-			if err == nil {
-				successCount++
-			}
+			db.AssertNoError(t, db.Memberships.Insert(testData.ToMembershipSubmission()))
 		}
+		t.Logf("Inserted %d records in %v", numRecords, time.Since(startTime))
+	})
+}
 
-		insertDuration := time.Since(startTime)
-		t.Logf("Inserted %d/%d records in %v", successCount, numRecords, insertDuration)
-
-		if successCount < numRecords/2 {
-			t.Errorf("Too many insert failures: %d/%d", successCount, numRecords)
-		} else {
-			t.Log("✅ Large dataset test completed with acceptable success rate")
+func TestPostgresQueries(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct{ name, query, want string }{
+		{
+			"Placeholders",
+			`SELECT form_id FROM membership_submissions WHERE email = ? AND note != '?' LIMIT ?`,
+			`SELECT form_id FROM membership_submissions WHERE email = $1 AND note != '?' LIMIT $2`,
+		},
+		{
+			"CreateTable",
+			`CREATE TABLE IF NOT EXISTS credits (id INTEGER PRIMARY KEY AUTOINCREMENT, amount REAL NOT NULL, used BOOLEAN DEFAULT 0)`,
+			`CREATE TABLE IF NOT EXISTS credits (id BIGSERIAL PRIMARY KEY, amount DOUBLE PRECISION NOT NULL, used INTEGER DEFAULT 0)`,
+		},
+		{
+			"AddColumn",
+			`ALTER TABLE event_submissions ADD COLUMN round_up REAL DEFAULT 0`,
+			`ALTER TABLE event_submissions ADD COLUMN round_up DOUBLE PRECISION DEFAULT 0`,
+		},
+		{
+			// Only the schema's column types are translated, never a query's values
+			"Query",
+			`SELECT note FROM credits WHERE reason = 'REAL'`,
+			`SELECT note FROM credits WHERE reason = 'REAL'`,
+		},
+	}
+	for _, c := range cases {
+		if got := data.PostgresQuery(c.query); got != c.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", c.name, c.want, got)
 		}
-	})
+	}
 }
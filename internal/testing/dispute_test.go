@@ -0,0 +1,87 @@
+package testing
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+)
+
+func TestDisputes(t *testing.T) {
+	previous := config.UseMockWebhookVerification
+	config.UseMockWebhookVerification = true
+	t.Cleanup(func() { config.UseMockWebhookVerification = previous })
+
+	h := NewHarness(t)
+	seedCorpusSubmission(t, h, "membership", "COMPLETED")
+	board := email.LoadEmailConfig().AlertRecipient
+	disputeEmails := func() []SentEmail {
+		var sent []SentEmail
+		for _, message := range h.Mailer.SentTo(board) {
+			if strings.HasPrefix(message.Subject, "PayPal dispute") {
+				sent = append(sent, message)
+			}
+		}
+		return sent
+	}
+
+	// PayPal delivers the new dispute twice; the board hears about it once
+	for i := 0; i < 2; i++ {
+		if code := postWebhook(t, h, "dispute_created.json"); code != http.StatusOK {
+			t.Fatalf("expected the dispute webhook accepted, got %d", code)
+		}
+	}
+	if status, _ := webhookState(t, h, "membership", corpusMembershipID); status != data.DisputedStatus {
+		t.Errorf("expected the membership DISPUTED, got %q", status)
+	}
+	disputes, err := data.ListDisputes(corpusMembershipID)
+	h.AssertNoError(t, err)
+	if len(disputes) != 1 || disputes[0].DisputeID != "PP-D-4012" || disputes[0].Amount != 75 ||
+		disputes[0].Stage != "INQUIRY" || disputes[0].FormType != "membership" || disputes[0].ResolvedAt != nil {
+		t.Fatalf("expected the open dispute recorded once, got %+v", disputes)
+	}
+	sent := disputeEmails()
+	if len(sent) != 1 || !strings.Contains(sent[0].Subject, "opened") || !strings.Contains(sent[0].Body, "PP-D-4012") ||
+		!strings.Contains(sent[0].Body, "MERCHANDISE_OR_SERVICE_NOT_RECEIVED") {
+		t.Fatalf("expected one email about the opened dispute, got %+v", sent)
+	}
+
+	// Disputed submissions are still paid ones, not forms left before paying
+	unpaid, err := data.ListSubmissions(data.SubmissionFilter{Status: "unpaid"})
+	h.AssertNoError(t, err)
+	for _, sub := range unpaid {
+		if sub.FormID == corpusMembershipID {
+			t.Error("expected a disputed submission left out of unpaid ones")
+		}
+	}
+
+	// The club wins: the payment is back to COMPLETED
+	corpus, err := os.ReadFile(filepath.Join(webhookCorpusDir, "dispute_resolved_buyer.json"))
+	h.AssertNoError(t, err)
+	won := strings.NewReplacer(
+		"PP-D-4013", "PP-D-4012",
+		corpusFundraiserID, corpusMembershipID,
+		"RESOLVED_BUYER_FAVOUR", "RESOLVED_SELLER_FAVOUR",
+	)
+	resp, err := h.Client.Post(h.Server.URL+"/api/paypal-webhook", "application/json", strings.NewReader(won.Replace(string(corpus))))
+	h.AssertNoError(t, err)
+	resp.Body.Close()
+
+	if status, _ := webhookState(t, h, "membership", corpusMembershipID); status != "COMPLETED" {
+		t.Errorf("expected the membership COMPLETED after the club won, got %q", status)
+	}
+	disputes, err = data.ListDisputes("")
+	h.AssertNoError(t, err)
+	if len(disputes) != 1 || disputes[0].Outcome != "RESOLVED_SELLER_FAVOUR" || disputes[0].ResolvedAt == nil ||
+		disputes[0].Stage != "CHARGEBACK" {
+		t.Errorf("expected the dispute updated as resolved, got %+v", disputes)
+	}
+	if sent := disputeEmails(); len(sent) != 2 || !strings.Contains(sent[1].Subject, "resolved") {
+		t.Errorf("expected an email about the resolution, got %+v", sent)
+	}
+}
@@ -0,0 +1,91 @@
+package testing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/outbox"
+)
+
+func TestHarnessErrorEnvelopes(t *testing.T) {
+	h := NewHarness(t)
+
+	resp, err := h.Client.Get(h.Server.URL + "/healthz")
+	h.AssertNoError(t, err)
+	h.AssertStatusCode(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Protected API routes reject missing tokens with the standard error envelope
+	resp, err = h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": "membership-missing"}, "")
+	h.AssertNoError(t, err)
+	h.AssertStatusCode(t, resp, http.StatusUnauthorized)
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Error("expected X-Request-ID header from the RequestID middleware")
+	}
+	var apiErr middleware.APIError
+	h.AssertNoError(t, h.ParseJSONResponse(resp, &apiErr))
+	if apiErr.Code != "missing_token" || apiErr.RequestID == "" {
+		t.Errorf("unexpected error envelope: %+v", apiErr)
+	}
+
+	// Unknown paths fall through to the custom 404 page
+	resp, err = h.Client.Get(h.Server.URL + "/no-such-page")
+	h.AssertNoError(t, err)
+	h.AssertStatusCode(t, resp, http.StatusNotFound)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "404 - Page Not Found") {
+		t.Errorf("expected custom 404 page, got %q", string(body))
+	}
+}
+
+func TestHarnessMembershipCheckout(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+
+	submission := h.GenerateTestMembership().ToMembershipSubmission()
+	submission.CalculatedAmount = 75.00
+	h.AssertNoError(t, data.InsertMembership(submission))
+
+	resp, err := h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": submission.FormID}, submission.AccessToken)
+	h.AssertNoError(t, err)
+	h.AssertStatusCode(t, resp, http.StatusOK)
+	var created struct {
+		Success bool `json:"success"`
+		Data    struct {
+			OrderID string `json:"orderID"`
+		} `json:"data"`
+	}
+	h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+	if created.Data.OrderID == "" {
+		t.Fatal("expected an order ID from create-order")
+	}
+
+	resp, err = h.MakeAPIRequest("POST", "/api/capture-order",
+		map[string]string{"orderID": created.Data.OrderID, "formID": submission.FormID}, submission.AccessToken)
+	h.AssertNoError(t, err)
+	h.AssertStatusCode(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	retrieved, err := data.GetMembershipByID(submission.FormID)
+	h.AssertNoError(t, err)
+	if retrieved.PayPalStatus != "COMPLETED" {
+		t.Fatalf("expected COMPLETED after capture, got %q", retrieved.PayPalStatus)
+	}
+
+	// The capture queued its emails in the outbox; drain it and check the mailer
+	worker := outbox.NewWorker()
+	worker.Handle(outbox.KindConfirmationEmail, order.ConfirmationEmailTask)
+	worker.Handle(outbox.KindAdminNotification, order.AdminNotificationTask)
+	h.AssertNoError(t, worker.Run(context.Background()))
+
+	if sent := h.Mailer.SentTo(submission.Email); len(sent) != 1 {
+		t.Errorf("expected 1 confirmation email to %s, got %d", submission.Email, len(sent))
+	}
+}
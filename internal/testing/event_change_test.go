@@ -0,0 +1,230 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+)
+
+// eventChangeOptions is the event_options a family sends: registration for one student,
+// with or without lunch
+func eventChangeOptions(lunch bool) map[string]interface{} {
+	return map[string]interface{}{
+		"student_selections": map[string]map[string]bool{"0": {"registration": true, "lunch": lunch}},
+		"shared_selections":  map[string]int{},
+		"has_food_orders":    lunch,
+	}
+}
+
+// insertPaidEvent stores a registration paid $25 through capture CAPTURE-EVT-1
+func insertPaidEvent(t *testing.T, h *Harness) data.EventSubmission {
+	t.Helper()
+	event := h.GenerateTestEvent().ToEventSubmission()
+	event.Event = "spring-festival"
+	selections, _ := json.Marshal(eventChangeOptions(false))
+	event.FoodChoicesJSON = string(selections)
+	event.CalculatedAmount = 25
+	event.Submitted = true
+	event.PayPalStatus = "COMPLETED"
+	event.PayPalDetails = `{"id":"ORDER-EVT-1","status":"COMPLETED","purchase_units":[{"payments":{"captures":[` +
+		`{"id":"CAPTURE-EVT-1","status":"COMPLETED","amount":{"currency_code":"USD","value":"25.00"}}]}}]}`
+	h.AssertNoError(t, data.InsertEvent(event))
+	return event
+}
+
+func postEventChange(t *testing.T, handler http.HandlerFunc, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/change-event-order", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+type eventChangeResponse struct {
+	ChangeID int64  `json:"changeID"`
+	OrderID  string `json:"orderID"`
+	Amount   string `json:"amount"`
+	Total    string `json:"total"`
+	Status   string `json:"status"`
+}
+
+// startEventChange asks for event's order with or without lunch
+func startEventChange(t *testing.T, event data.EventSubmission, lunch bool) (int, eventChangeResponse) {
+	t.Helper()
+	rec := postEventChange(t, payment.ChangeEventOrderHandler, event.AccessToken, map[string]interface{}{
+		"formID": event.FormID, "event_options": eventChangeOptions(lunch),
+	})
+	var response eventChangeResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec.Code, response
+}
+
+func captureEventChange(t *testing.T, event data.EventSubmission, change eventChangeResponse) int {
+	t.Helper()
+	rec := postEventChange(t, payment.CaptureEventChangeHandler, event.AccessToken, map[string]interface{}{
+		"formID": event.FormID, "changeID": change.ChangeID, "orderID": change.OrderID,
+	})
+	return rec.Code
+}
+
+func TestEventChangeIncreaseAndDecrease(t *testing.T) {
+	h := NewHarness(t)
+	event := insertPaidEvent(t, h)
+
+	// Adding lunch owes the $10 difference, collected with an order for just that
+	code, change := startEventChange(t, event, true)
+	if code != http.StatusOK || change.Amount != "10.00" || change.Status != data.EventChangePendingPayment {
+		t.Fatalf("expected a $10 order for the change, got %d: %+v", code, change)
+	}
+	if stored, _ := data.GetEventByID(event.FormID); stored.CalculatedAmount != 25 {
+		t.Fatalf("expected the order unchanged until the difference is paid, got %.2f", stored.CalculatedAmount)
+	}
+	if code := captureEventChange(t, event, change); code != http.StatusOK {
+		t.Fatalf("expected the change captured, got %d", code)
+	}
+	stored, err := data.GetEventByID(event.FormID)
+	h.AssertNoError(t, err)
+	if stored.CalculatedAmount != 35 || !stored.HasFoodOrders || stored.FoodOrderID == "" {
+		t.Errorf("expected lunch added for $35 with a food order, got %.2f %v %q",
+			stored.CalculatedAmount, stored.HasFoodOrders, stored.FoodOrderID)
+	}
+	if applied, _ := data.GetEventOrderChangeByID(change.ChangeID); applied.Status != data.EventChangeApplied {
+		t.Errorf("expected the change applied, got %s", applied.Status)
+	}
+	// Capturing it again changes nothing more
+	if code := captureEventChange(t, event, change); code != http.StatusOK || len(h.PayPal.GetRefunds()) != 0 {
+		t.Errorf("expected a repeated capture answered as done, got %d", code)
+	}
+
+	// Dropping lunch again refunds the $10 against the original capture at once
+	code, change = startEventChange(t, event, false)
+	if code != http.StatusOK || change.Total != "25.00" || change.Status != data.EventChangeApplied {
+		t.Fatalf("expected the decrease applied, got %d: %+v", code, change)
+	}
+	refunds := h.PayPal.GetRefunds()
+	if len(refunds) != 1 || refunds[0].CaptureID != "CAPTURE-EVT-1" || refunds[0].Amount != "10.00" || refunds[0].Currency != "USD" {
+		t.Fatalf("expected $10 USD refunded on CAPTURE-EVT-1, got %+v", refunds)
+	}
+	if stored, _ := data.GetEventByID(event.FormID); stored.CalculatedAmount != 25 || stored.HasFoodOrders {
+		t.Errorf("expected lunch dropped for $25, got %.2f %v", stored.CalculatedAmount, stored.HasFoodOrders)
+	}
+
+	// A refund PayPal turns down leaves the order as it was
+	code, change = startEventChange(t, event, true)
+	if code != http.StatusOK {
+		t.Fatalf("expected lunch priced again, got %d", code)
+	}
+	if code := captureEventChange(t, event, change); code != http.StatusOK {
+		t.Fatalf("expected lunch added again, got %d", code)
+	}
+	h.PayPal.mu.Lock()
+	h.PayPal.ShouldFailRefund = true
+	h.PayPal.mu.Unlock()
+	if code, _ := startEventChange(t, event, false); code != http.StatusInternalServerError {
+		t.Errorf("expected a failed refund reported, got %d", code)
+	}
+	if stored, _ := data.GetEventByID(event.FormID); stored.CalculatedAmount != 35 || !stored.HasFoodOrders {
+		t.Errorf("expected the order kept at $35 with lunch after the refund failed, got %.2f %v",
+			stored.CalculatedAmount, stored.HasFoodOrders)
+	}
+}
+
+func TestConcurrentEventChangesConflict(t *testing.T) {
+	h := NewHarness(t)
+	event := insertPaidEvent(t, h)
+
+	// Two tabs price the same change; once one is paid the other can't be
+	code, first := startEventChange(t, event, true)
+	if code != http.StatusOK {
+		t.Fatalf("expected the first change priced, got %d", code)
+	}
+	code, second := startEventChange(t, event, true)
+	if code != http.StatusOK {
+		t.Fatalf("expected the second change priced, got %d", code)
+	}
+	if code := captureEventChange(t, event, first); code != http.StatusOK {
+		t.Fatalf("expected the first change captured, got %d", code)
+	}
+	captures := h.PayPal.GetCompletedOrderCount()
+	if code := captureEventChange(t, event, second); code != http.StatusConflict {
+		t.Errorf("expected the stale change refused, got %d", code)
+	}
+	if h.PayPal.GetCompletedOrderCount() != captures {
+		t.Error("expected the stale change refused before charging for it")
+	}
+
+	// A change landing while another's difference is captured wins; the money taken
+	// for the loser goes back
+	code, third := startEventChange(t, event, false)
+	if code != http.StatusOK || third.Status != data.EventChangeApplied {
+		t.Fatalf("expected lunch dropped, got %d: %+v", code, third)
+	}
+	code, fourth := startEventChange(t, event, true)
+	if code != http.StatusOK {
+		t.Fatalf("expected lunch priced again, got %d", code)
+	}
+	h.PayPal.mu.Lock()
+	h.PayPal.OnCapture = func(orderID string) {
+		racing, err := data.GetEventByID(event.FormID)
+		h.AssertNoError(t, err)
+		racing.CalculatedAmount = 30
+		h.AssertNoError(t, data.ApplyEventOrderChange(*racing, 25))
+	}
+	h.PayPal.mu.Unlock()
+	refundsBefore := len(h.PayPal.GetRefunds())
+	if code := captureEventChange(t, event, fourth); code != http.StatusConflict {
+		t.Errorf("expected the overtaken change refused, got %d", code)
+	}
+	refunds := h.PayPal.GetRefunds()
+	if len(refunds) != refundsBefore+1 || refunds[len(refunds)-1].CaptureID != "CAPTURE-"+fourth.OrderID ||
+		refunds[len(refunds)-1].Amount != "10.00" {
+		t.Errorf("expected the $10 collected for the overtaken change refunded, got %+v", refunds)
+	}
+	if change, _ := data.GetEventOrderChangeByID(fourth.ChangeID); change.Status != data.EventChangeFailed {
+		t.Errorf("expected the overtaken change failed, got %s", change.Status)
+	}
+	if stored, _ := data.GetEventByID(event.FormID); stored.CalculatedAmount != 30 {
+		t.Errorf("expected the racing change kept, got %.2f", stored.CalculatedAmount)
+	}
+
+	// Nor can a change priced from a total the order has moved on from
+	racing, err := data.GetEventByID(event.FormID)
+	h.AssertNoError(t, err)
+	if err := data.ApplyEventOrderChange(*racing, 35); err == nil {
+		t.Error("expected a change priced from another total to conflict")
+	}
+}
+
+// TestEventChangeCutoff checks the change deadline against the server's clock: open
+// through the cutoff day and closed from the morning after
+func TestEventChangeCutoff(t *testing.T) {
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	event := insertPaidEvent(t, h)
+
+	// spring-festival closes at the end of 2099-12-31
+	lastDay := time.Date(2099, 12, 31, 23, 0, 0, 0, clock.Location())
+	fake.Advance(lastDay.Sub(fake.Now()))
+	if code, change := startEventChange(t, event, true); code != http.StatusOK {
+		t.Fatalf("expected changes open on the cutoff day, got %d: %+v", code, change)
+	}
+
+	fake.Advance(2 * time.Hour)
+	rec := postEventChange(t, payment.ChangeEventOrderHandler, event.AccessToken, map[string]interface{}{
+		"formID": event.FormID, "event_options": eventChangeOptions(true),
+	})
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "deadline") {
+		t.Errorf("expected changes closed after the cutoff, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,163 @@
+package testing
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/security"
+	"sbcbackend/internal/xlsx"
+)
+
+func TestSubmissionCSVExport(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+
+	// export returns the CSV's rows keyed by column name, in file order
+	export := func(path, token string) (int, []map[string]string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/admin/export/"+path, nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+			t.Fatalf("expected a CSV, got %s", resp.Header.Get("Content-Type"))
+		}
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		h.AssertNoError(t, err)
+		var rows []map[string]string
+		for _, record := range records[1:] {
+			row := map[string]string{}
+			for i, column := range records[0] {
+				row[column] = record[i]
+			}
+			rows = append(rows, row)
+		}
+		return resp.StatusCode, rows
+	}
+	find := func(rows []map[string]string, email string) map[string]string {
+		t.Helper()
+		for _, row := range rows {
+			if row["email"] == email {
+				return row
+			}
+		}
+		t.Fatalf("expected a row for %s in %v", email, rows)
+		return nil
+	}
+
+	_, memberships := export("memberships.csv", adminToken)
+	if len(memberships) != 3 {
+		t.Fatalf("expected all 3 seeded memberships, got %d", len(memberships))
+	}
+	smith := find(memberships, "jane.smith@example.com")
+	if smith["student_2_name"] != "Liam Smith" || smith["student_2_grade"] != "5" || smith["fee_Spring Festival Fee"] != "1" ||
+		smith["addon_T-Shirt"] != "yes" || smith["addon_Sticker Pack"] != "" || smith["amount"] != "75.00" {
+		t.Errorf("expected students, fees and add-ons spread across columns, got %v", smith)
+	}
+	if rivera := find(memberships, "carlos.rivera@example.com"); rivera["student_2_name"] != "" || rivera["addon_Sticker Pack"] != "yes" {
+		t.Errorf("expected empty columns past a family's last student, got %v", rivera)
+	}
+
+	_, events := export("events.csv?status=paid&school=lincoln-elementary", adminToken)
+	if len(events) != 1 {
+		t.Fatalf("expected the one paid Lincoln registration, got %v", events)
+	}
+	if doe := events[0]; doe["student_1_lunch"] != "pizza" || doe["student_2_lunch"] != "turkey" || doe["student_2_name"] != "Bob Doe" {
+		t.Errorf("expected each student's lunch beside them, got %v", doe)
+	}
+
+	_, fundraisers := export("fundraisers.csv", adminToken)
+	if len(fundraisers) != 2 {
+		t.Errorf("expected both seeded fundraiser donations, got %d", len(fundraisers))
+	}
+
+	if status, _ := export("memberships.csv?year=soon", adminToken); status != http.StatusBadRequest {
+		t.Errorf("expected a bad year to be refused, got %d", status)
+	}
+	if status, _ := export("households.csv", adminToken); status != http.StatusNotFound {
+		t.Errorf("expected an unknown export to be not found, got %d", status)
+	}
+	if status, _ := export("memberships.csv", "not-a-token"); status != http.StatusForbidden {
+		t.Errorf("expected the export to need an admin token, got %d", status)
+	}
+}
+
+func TestYearEndWorkbook(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+
+	year := time.Now().AddDate(0, 0, -21).Year() // the year Jane Smith's seeded membership falls in
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/admin/export/workbook.xlsx?year=%d", h.Server.URL, year), nil)
+	h.AssertNoError(t, err)
+	req.Header.Set("X-Admin-Token", adminToken)
+	req.Header.Set("Referer", h.Server.URL+"/info")
+	resp, err := h.Client.Do(req)
+	h.AssertNoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	h.AssertNoError(t, err)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != xlsx.ContentType {
+		t.Fatalf("expected a workbook, got %d %s: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); !strings.Contains(disposition, fmt.Sprintf("workbook-%d.xlsx", year)) {
+		t.Errorf("expected the file named for the year, got %s", disposition)
+	}
+
+	// Every part must be well-formed XML for Excel to open the file
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	h.AssertNoError(t, err)
+	parts := map[string]string{}
+	for _, file := range archive.File {
+		rc, err := file.Open()
+		h.AssertNoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		h.AssertNoError(t, err)
+		decoder := xml.NewDecoder(bytes.NewReader(content))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed: %v", file.Name, err)
+			}
+		}
+		parts[file.Name] = string(content)
+	}
+
+	for _, sheet := range []string{"Memberships", "Fee Roster", "Add-on Purchases", "Fundraiser Donations", "Financial Summary"} {
+		if !strings.Contains(parts["xl/workbook.xml"], `name="`+sheet+`"`) {
+			t.Errorf("expected a %s sheet in %s", sheet, parts["xl/workbook.xml"])
+		}
+	}
+	if roster := parts["xl/worksheets/sheet2.xml"]; !strings.Contains(roster, "Spring Festival Fee") || !strings.Contains(roster, "Jane Smith") {
+		t.Errorf("expected the fee roster to list Jane Smith's fee, got %s", roster)
+	}
+	if summary := parts["xl/worksheets/sheet5.xml"]; !strings.Contains(summary, "Fundraiser donations") || !strings.Contains(summary, "Basic Membership") {
+		t.Errorf("expected totals and counts by level in the summary, got %s", summary)
+	}
+}
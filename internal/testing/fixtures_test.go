@@ -0,0 +1,35 @@
+package testing
+
+import (
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+)
+
+func TestSeedFixturesLoad(t *testing.T) {
+	h := NewHarness(t)
+
+	summary, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	if summary.Memberships == 0 || summary.Events == 0 || summary.Fundraisers == 0 {
+		t.Fatalf("expected every form type to be seeded, got %+v", summary)
+	}
+
+	membership, err := data.GetMembershipByID("membership-seed-001")
+	h.AssertNoError(t, err)
+	captureID := data.ExtractPayPalCaptureID(membership.PayPalDetails, membership.FormID)
+	if membership.PayPalStatus != "COMPLETED" || captureID == "" {
+		t.Errorf("expected a seeded capture, got status %q capture %q", membership.PayPalStatus, captureID)
+	}
+
+	// Seeding records captures without outbox tasks, so nothing is queued to send
+	counts, err := data.CountOutboxTasksByStatus()
+	h.AssertNoError(t, err)
+	if counts[data.OutboxPending] != 0 {
+		t.Errorf("expected no pending outbox tasks after seeding, got %d", counts[data.OutboxPending])
+	}
+	if len(h.Mailer.Sent()) != 0 {
+		t.Errorf("expected no email from seeding, got %d", len(h.Mailer.Sent()))
+	}
+}
@@ -0,0 +1,141 @@
+package testing
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/form"
+	"sbcbackend/internal/webhook"
+)
+
+// Run a target with, e.g.: go test ./internal/testing -run '^$' -fuzz FuzzParseMembershipSubmission
+
+var formSeeds = []string{
+	"full_name=Jane+Smith&email=Jane@Example.com&school=lincoln&student_count=2&student_1_name=Emma&student_1_grade=3&student_2_name=Liam&student_2_grade=5&membership=Basic&donation=10&calculated_amount=75&cover_fees=on&addons=T-Shirt,Sticker+Pack",
+	"full_name=John+Doe&email=john@example.com&event=spring-festival&student_count=1&student_1_name=Alice&lunch_0=pizza&meal_1=none",
+	"full_name=Mary+Johnson&email=mary@example.com&student_count=1&student_1_name=Noah&student_1_amount=50&cover_fees=true",
+	"student_count=999999999&student_1_name=x&student_1_amount=NaN&donation=Inf&calculated_amount=-1e309",
+	"student_count=-3&full_name=%20%20&email=%00",
+	"",
+}
+
+// fuzzFormRequest builds a parsed form post from a raw urlencoded body
+func fuzzFormRequest(body string) (*http.Request, bool) {
+	req := httptest.NewRequest(http.MethodPost, "/submit-form", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, req.ParseForm() == nil
+}
+
+func finite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func FuzzParseMembershipSubmission(f *testing.F) {
+	for _, seed := range formSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		req, ok := fuzzFormRequest(body)
+		if !ok {
+			return
+		}
+		sub, err := form.ParseMembershipSubmission(req, "membership-fuzz", "token", time.Now())
+		if err != nil {
+			return
+		}
+		if sub.StudentCount < 0 || len(sub.Students) > sub.StudentCount {
+			t.Errorf("student count %d with %d students", sub.StudentCount, len(sub.Students))
+		}
+		if !finite(sub.Donation) || !finite(sub.CalculatedAmount) {
+			t.Errorf("non-finite amounts: donation %v calculated %v", sub.Donation, sub.CalculatedAmount)
+		}
+		if sub.Email != strings.ToLower(strings.TrimSpace(sub.Email)) {
+			t.Errorf("email not normalized: %q", sub.Email)
+		}
+	})
+}
+
+func FuzzParseEventSubmission(f *testing.F) {
+	for _, seed := range formSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, body string) {
+		req, ok := fuzzFormRequest(body)
+		if !ok {
+			return
+		}
+		sub, err := form.ParseEventSubmission(req, "event-fuzz", "token", time.Now())
+		if err != nil {
+			return
+		}
+		if sub.StudentCount < 0 || len(sub.Students) > sub.StudentCount {
+			t.Errorf("student count %d with %d students", sub.StudentCount, len(sub.Students))
+		}
+		var choices map[string]string
+		if err := json.Unmarshal([]byte(sub.FoodChoicesJSON), &choices); err != nil {
+			t.Errorf("food choices JSON does not round-trip: %v", err)
+		}
+	})
+}
+
+func FuzzParseDonationItems(f *testing.F) {
+	for _, seed := range formSeeds {
+		f.Add(seed, 3)
+	}
+	f.Fuzz(func(t *testing.T, body string, studentCount int) {
+		req, ok := fuzzFormRequest(body)
+		if !ok || studentCount > 1000 {
+			return
+		}
+		items, total, err := form.ParseDonationItems(req, studentCount)
+		if err != nil {
+			return
+		}
+		if len(items) == 0 {
+			t.Fatal("no donation items returned without an error")
+		}
+		for _, item := range items {
+			if !(item.Amount > 0) || !finite(item.Amount) || item.StudentName == "" {
+				t.Errorf("accepted invalid donation item %+v", item)
+			}
+		}
+		if !finite(total) {
+			t.Errorf("non-finite donation total %v", total)
+		}
+	})
+}
+
+func FuzzParseWebhookEvent(f *testing.F) {
+	seeds := []string{
+		`{"event_type":"PAYMENT.CAPTURE.COMPLETED","resource":{"id":"CAP1","status":"COMPLETED","invoice_id":"membership-1"}}`,
+		`{"event_type":"CHECKOUT.ORDER.APPROVED","resource":{"purchase_units":[{"invoice_id":"event-2"}],"capture_response":{"status":"COMPLETED"}}}`,
+		`{"event_type":"PAYMENT.CAPTURE.REFUNDED","resource":{"purchase_units":[null,{"invoice_id":7}]}}`,
+		`{"resource":null}`,
+		`{"resource":[]}`,
+		`[]`,
+		`{`,
+	}
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		event, err := webhook.ParseWebhookEvent(payload)
+		if err != nil {
+			return
+		}
+		if event.ResourceJSON == "" {
+			if event.FormID != "" || event.Status != "" {
+				t.Errorf("form ID or status without a resource: %+v", event)
+			}
+			return
+		}
+		if !json.Valid([]byte(event.ResourceJSON)) {
+			t.Errorf("resource JSON is not valid: %q", event.ResourceJSON)
+		}
+	})
+}
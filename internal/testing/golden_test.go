@@ -0,0 +1,300 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/order"
+)
+
+// Golden files live in testdata/golden. After an intended template change, regenerate
+// them with: go test ./internal/testing -run Golden -update, and review the diff.
+
+const goldenDir = "testdata/golden"
+
+// assertGolden compares got with the named golden file, or rewrites it with -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	// Pages print the current year; pin it so goldens don't churn every January
+	got = bytes.ReplaceAll(got, []byte(strconv.Itoa(time.Now().Year())), []byte("YYYY"))
+
+	path := filepath.Join(goldenDir, name)
+	if *update {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatalf("Failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Missing golden file %s (run with -update to create it): %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not match its golden file; run with -update and review the diff if the change is intended\n--- got ---\n%s", name, got)
+	}
+}
+
+// goldenClock pins the zone the templates format local times in, as main does
+func goldenClock(t *testing.T) time.Time {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skipf("America/Chicago zone data unavailable: %v", err)
+	}
+	previous := clock.SetLocation(loc)
+	t.Cleanup(func() { clock.SetLocation(previous) })
+
+	return time.Date(2024, time.March, 14, 18, 30, 0, 0, loc)
+}
+
+// goldenCaptureDetails is a trimmed PayPal capture with a known fee
+const goldenCaptureDetails = `{"id":"5O190127TN364715T","status":"COMPLETED","payer":{"email_address":"payer@example.com"},` +
+	`"purchase_units":[{"payments":{"captures":[{"id":"3C679366HH908993F","status":"COMPLETED",` +
+	`"seller_receivable_breakdown":{"paypal_fee":{"currency_code":"USD","value":"1.98"}}}]}}]}`
+
+func goldenMembership(at time.Time) *data.MembershipSubmission {
+	createdAt, capturedAt, sentAt := at.Add(2*time.Minute), at.Add(3*time.Minute), at.Add(4*time.Minute)
+	return &data.MembershipSubmission{
+		FormID:                  "membership-20240314-golden",
+		SubmissionDate:          at,
+		FullName:                "jane smith",
+		FirstName:               "jane",
+		LastName:                "smith",
+		Email:                   "jane.smith@example.com",
+		School:                  "lincoln-elementary",
+		Membership:              "basic-membership",
+		MembershipStatus:        "returning",
+		Describe:                "parent",
+		StudentCount:            2,
+		Students:                []data.Student{{Name: "Emma Smith", Grade: "3"}, {Name: "Liam Smith", Grade: "5"}},
+		Fees:                    map[string]int{"Spring Festival Fee": 1},
+		Addons:                  []string{"T-Shirt", "Sticker Pack"},
+		Donation:                10,
+		CalculatedAmount:        75,
+		CoverFees:               true,
+		PayPalOrderID:           "5O190127TN364715T",
+		PayPalOrderCreatedAt:    &createdAt,
+		PayPalStatus:            "COMPLETED",
+		PayPalDetails:           goldenCaptureDetails,
+		Submitted:               true,
+		SubmittedAt:             &capturedAt,
+		ReceiptNumber:           "2024-000042",
+		ConfirmationEmailSent:   true,
+		ConfirmationEmailSentAt: &sentAt,
+	}
+}
+
+func goldenEvent(at time.Time) *data.EventSubmission {
+	capturedAt := at.Add(3 * time.Minute)
+	return &data.EventSubmission{
+		FormID:           "event-20240314-golden",
+		SubmissionDate:   at,
+		Event:            "spring-festival",
+		FullName:         "John Doe",
+		FirstName:        "John",
+		LastName:         "Doe",
+		Email:            "john.doe@example.com",
+		School:           "lincoln-elementary",
+		StudentCount:     2,
+		Students:         []data.Student{{Name: "Alice Doe", Grade: "4"}, {Name: "Bob Doe", Grade: "6"}},
+		Submitted:        true,
+		SubmittedAt:      &capturedAt,
+		HasFoodOrders:    true,
+		FoodOrderID:      "SF-0042",
+		OrderPageURL:     "/events/2024/spring-festival/SF-0042.html",
+		FoodChoicesJSON:  `{"student_selections":{"0":{"registration":true,"lunch":true},"1":{"registration":true}},"shared_selections":{"program":2},"cover_fees":false}`,
+		CalculatedAmount: 70,
+		PayPalOrderID:    "8MC585209K746392H",
+		PayPalStatus:     "COMPLETED",
+		ReceiptNumber:    "2024-000043",
+		DietaryNotes: map[string]data.DietaryNote{
+			"0": {StudentName: "Alice Doe", Allergy: true, Notes: "Peanuts"},
+		},
+	}
+}
+
+func goldenFundraiser(at time.Time) *data.FundraiserSubmission {
+	capturedAt := at.Add(3 * time.Minute)
+	return &data.FundraiserSubmission{
+		FormID:           "fundraiser-20240314-golden",
+		SubmissionDate:   at,
+		FullName:         "Mary Johnson",
+		FirstName:        "Mary",
+		LastName:         "Johnson",
+		Email:            "mary.johnson@example.com",
+		School:           "lincoln-elementary",
+		Describe:         "grandparent",
+		DonorStatus:      "returning",
+		StudentCount:     1,
+		Students:         []data.Student{{Name: "Noah Johnson", Grade: "5"}},
+		DonationItems:    []data.StudentDonation{{StudentName: "Noah Johnson", Amount: 50}},
+		TotalAmount:      50,
+		CoverFees:        true,
+		CalculatedAmount: 51.49,
+		PayPalOrderID:    "2GG279541U471931P",
+		PayPalStatus:     "COMPLETED",
+		ReceiptNumber:    "2024-000044",
+		Submitted:        true,
+		SubmittedAt:      &capturedAt,
+	}
+}
+
+// useGoldenEventOptions points event display at a fixed options file
+func useGoldenEventOptions(t *testing.T) {
+	options := map[string]interface{}{
+		"spring-festival": map[string]interface{}{
+			"per_student_options": map[string]interface{}{
+				"registration": map[string]interface{}{"label": "Festival Registration", "price": 25.0},
+				"lunch":        map[string]interface{}{"label": "Lunch", "price": 10.0},
+			},
+			"shared_options": map[string]interface{}{
+				"program": map[string]interface{}{"label": "Program Book", "price": 5.0},
+			},
+		},
+	}
+	raw, err := json.Marshal(options)
+	if err != nil {
+		t.Fatalf("Failed to marshal event options: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "event-purchases.json")
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		t.Fatalf("Failed to write event options: %v", err)
+	}
+	t.Setenv("ENVIRONMENT", "golden")
+	t.Setenv("EVENT_OPTIONS_PATH_GOLDEN", path)
+}
+
+func TestGoldenPages(t *testing.T) {
+	at := goldenClock(t)
+	useGoldenEventOptions(t)
+
+	pages := []struct {
+		name   string
+		render func(*bytes.Buffer) error
+	}{
+		{"membership_success.html", func(buf *bytes.Buffer) error {
+			return order.RenderMembershipSuccessPage(buf, goldenMembership(at), false)
+		}},
+		{"membership_success_admin.html", func(buf *bytes.Buffer) error {
+			return order.RenderMembershipSuccessPage(buf, goldenMembership(at), true)
+		}},
+		{"event_success.html", func(buf *bytes.Buffer) error {
+			return order.RenderEventSuccessPage(buf, goldenEvent(at), false)
+		}},
+		{"event_order_page.html", func(buf *bytes.Buffer) error {
+			return order.RenderEventOrderPage(buf, goldenEvent(at))
+		}},
+		{"fundraiser_success.html", func(buf *bytes.Buffer) error {
+			return order.RenderFundraiserSuccessPage(buf, goldenFundraiser(at), false)
+		}},
+	}
+
+	for _, page := range pages {
+		t.Run(page.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := page.render(&buf); err != nil {
+				t.Fatalf("render failed: %v", err)
+			}
+			assertGolden(t, page.name, buf.Bytes())
+		})
+	}
+}
+
+func TestGoldenEmails(t *testing.T) {
+	at := goldenClock(t)
+	t.Setenv("PUBLIC_BASE_URL", "https://booster.example.org")
+
+	mailer := &MockMailer{}
+	previous := email.SetSender(mailer.Send)
+	t.Cleanup(func() { email.SetSender(previous) })
+
+	config := email.EmailConfig{
+		AlertRecipient:     "admin@example.org",
+		AlertSender:        "alerts@example.org",
+		ConfirmationSender: "noreply@example.org",
+		SendConfirmations:  true,
+	}
+
+	membership := goldenMembership(at)
+	membershipData := email.MembershipConfirmationData{
+		FormID:           membership.FormID,
+		FullName:         "Jane Smith",
+		FirstName:        "Jane",
+		Email:            membership.Email,
+		School:           "Lincoln Elementary",
+		Membership:       "Basic Membership",
+		Students:         membership.Students,
+		Addons:           membership.Addons,
+		Fees:             membership.Fees,
+		Donation:         membership.Donation,
+		CalculatedAmount: membership.CalculatedAmount,
+		CoverFees:        membership.CoverFees,
+		PayPalOrderID:    membership.PayPalOrderID,
+		ReceiptNumber:    membership.ReceiptNumber,
+		SubmittedAt:      membership.SubmittedAt,
+		Year:             2024,
+	}
+
+	fundraiser := goldenFundraiser(at)
+	fundraiserData := email.FundraiserConfirmationData{
+		FormID:           fundraiser.FormID,
+		FullName:         fundraiser.FullName,
+		FirstName:        fundraiser.FirstName,
+		Email:            fundraiser.Email,
+		School:           "Lincoln Elementary",
+		Describe:         fundraiser.Describe,
+		DonorStatus:      fundraiser.DonorStatus,
+		Students:         fundraiser.Students,
+		DonationItems:    fundraiser.DonationItems,
+		TotalAmount:      fundraiser.TotalAmount,
+		CalculatedAmount: fundraiser.CalculatedAmount,
+		CoverFees:        fundraiser.CoverFees,
+		PayPalOrderID:    fundraiser.PayPalOrderID,
+		ReceiptNumber:    fundraiser.ReceiptNumber,
+		SubmittedAt:      fundraiser.SubmittedAt,
+		Year:             2024,
+	}
+
+	emails := []struct {
+		name string
+		send func() error
+	}{
+		{"membership_confirmation.txt", func() error { return email.SendMembershipConfirmation(config, membershipData) }},
+		{"membership_admin_notification.txt", func() error { return email.SendAdminNotification(config, membershipData) }},
+		{"fundraiser_confirmation.txt", func() error { return email.SendFundraiserConfirmation(config, fundraiserData) }},
+		{"fundraiser_admin_notification.txt", func() error { return email.SendFundraiserAdminNotification(config, fundraiserData) }},
+		{"event_confirmation.txt", func() error {
+			subject, body := order.RenderEventConfirmationEmail(goldenEvent(at))
+			return mailer.Send("john.doe@example.com", config.ConfirmationSender, subject, body)
+		}},
+	}
+
+	for _, e := range emails {
+		t.Run(e.name, func(t *testing.T) {
+			before := len(mailer.Sent())
+			if err := e.send(); err != nil {
+				t.Fatalf("send failed: %v", err)
+			}
+			sent := mailer.Sent()
+			if len(sent) != before+1 {
+				t.Fatalf("expected one email, got %d", len(sent)-before)
+			}
+			msg := sent[len(sent)-1]
+			rendered := fmt.Sprintf("To: %s\nFrom: %s\nSubject: %s\n\n%s", msg.To, msg.From, msg.Subject, msg.Body)
+			assertGolden(t, e.name, []byte(rendered))
+		})
+	}
+}
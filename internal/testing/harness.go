@@ -0,0 +1,149 @@
+// harness.go - Boots the real router and middleware against temp state for integration tests
+package testing
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/info"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/router"
+	"sbcbackend/internal/scheduler"
+)
+
+// SentEmail is one message captured by the MockMailer
+type SentEmail struct {
+	To      string
+	From    string
+	Subject string
+	Body    string
+}
+
+// MockMailer records outgoing email instead of calling sendmail
+type MockMailer struct {
+	mu   sync.Mutex
+	sent []SentEmail
+}
+
+// Send implements email.Sender
+func (m *MockMailer) Send(to, from, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, SentEmail{To: to, From: from, Subject: subject, Body: body})
+	return nil
+}
+
+// Sent returns a copy of every captured email
+func (m *MockMailer) Sent() []SentEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentEmail(nil), m.sent...)
+}
+
+// SentTo returns the captured emails addressed to the given recipient
+func (m *MockMailer) SentTo(to string) []SentEmail {
+	var matched []SentEmail
+	for _, msg := range m.Sent() {
+		if strings.EqualFold(msg.To, to) {
+			matched = append(matched, msg)
+		}
+	}
+	return matched
+}
+
+// Harness runs the production router and middleware chain over a temp database
+// built from the real schema, a temp inventory, a mock mailer and a mock PayPal server.
+type Harness struct {
+	*TestSuite
+	PayPal *MockPayPalService
+	Mailer *MockMailer
+	Jobs   *scheduler.Scheduler
+}
+
+// NewHarness boots the real routes for one test and restores global state afterwards.
+// The scheduler is created but not started; tests call RunNow or job functions directly.
+func NewHarness(t *testing.T) *Harness {
+	testDir := filepath.Join(os.TempDir(), fmt.Sprintf("sbcharness_%d_%d", time.Now().UnixNano(), os.Getpid()))
+	if err := os.MkdirAll(testDir, 0755); err != nil {
+		t.Fatalf("Failed to create harness directory: %v", err)
+	}
+
+	suite := &TestSuite{
+		Config: TestConfig{
+			DBPath:        filepath.Join(testDir, "harness.db"),
+			InventoryPath: filepath.Join(testDir, "test_inventory.json"),
+			LogLevel:      "ERROR",
+			TestDataDir:   testDir,
+		},
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+	t.Cleanup(suite.Cleanup)
+
+	// Use the production schema and migrations so handlers see the columns they expect
+	if err := data.InitDB(suite.Config.DBPath); err != nil {
+		t.Fatalf("Failed to init harness database: %v", err)
+	}
+	if err := data.CreateTables(); err != nil {
+		t.Fatalf("Failed to create harness tables: %v", err)
+	}
+	db, err := data.GetDB()
+	if err != nil {
+		t.Fatalf("Failed to get harness database: %v", err)
+	}
+	suite.DB = db
+
+	if err := createTestInventory(suite.Config.InventoryPath); err != nil {
+		t.Fatalf("Failed to create test inventory: %v", err)
+	}
+	suite.Inventory = inventory.NewService()
+	if err := suite.Inventory.LoadInventory(suite.Config.InventoryPath); err != nil {
+		t.Fatalf("Failed to load test inventory: %v", err)
+	}
+	suite.Inventory.SetCouponCounter(data.CountCouponUses)
+	payment.SetInventoryService(suite.Inventory)
+	order.SetInventoryService(suite.Inventory)
+	info.SetInventoryService(suite.Inventory)
+
+	h := &Harness{
+		TestSuite: suite,
+		PayPal:    NewMockPayPalService(),
+		Mailer:    &MockMailer{},
+		Jobs:      scheduler.New(),
+	}
+	t.Cleanup(h.PayPal.Close)
+
+	previousBase, previousID, previousSecret := config.APIBase(), config.ClientID(), config.ClientSecret()
+	config.SetPayPalAPI(h.PayPal.GetAPIBase(), "harness-client-id", "harness-client-secret")
+	previousSender := email.SetSender(h.Mailer.Send)
+	t.Cleanup(func() {
+		config.SetPayPalAPI(previousBase, previousID, previousSecret)
+		email.SetSender(previousSender)
+	})
+
+	suite.Server = httptest.NewServer(router.Handler(router.Routes(h.Jobs)))
+	t.Cleanup(suite.Server.Close)
+
+	return h
+}
+
+// DisableTokenRateLimit lets one access token make back-to-back requests, as a real
+// checkout does across create-order and capture-order, for the rest of the test
+func (h *Harness) DisableTokenRateLimit(t *testing.T) {
+	previous := middleware.SetTokenRateLimit(0)
+	t.Cleanup(func() {
+		middleware.SetTokenRateLimit(previous)
+	})
+}
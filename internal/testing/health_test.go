@@ -0,0 +1,174 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/health"
+	"sbcbackend/internal/security"
+)
+
+func TestLivenessAndReadiness(t *testing.T) {
+	h := NewHarness(t)
+
+	previousReady := health.SetReady(false)
+	var inventoryLoaded bool
+	previousCheck := health.Register("inventory", func() error {
+		if !inventoryLoaded {
+			return errors.New("inventory not loaded")
+		}
+		return nil
+	})
+	t.Cleanup(func() {
+		health.SetReady(previousReady)
+		health.Register("inventory", previousCheck)
+	})
+
+	ready := func() (int, health.Status) {
+		resp, err := h.Client.Get(h.Server.URL + "/readyz")
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		var status health.Status
+		h.AssertNoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		return resp.StatusCode, status
+	}
+
+	// Liveness never depends on startup or dependencies
+	resp, err := h.Client.Get(h.Server.URL + "/healthz")
+	h.AssertNoError(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected /healthz to be 200 during startup, got %d", resp.StatusCode)
+	}
+
+	code, status := ready()
+	if code != http.StatusServiceUnavailable || status.Ready || status.Checks["startup"] == "" {
+		t.Errorf("expected not ready during startup, got %d %+v", code, status)
+	}
+
+	health.SetReady(true)
+	code, status = ready()
+	if code != http.StatusServiceUnavailable || status.Checks["inventory"] != "inventory not loaded" {
+		t.Errorf("expected the failing inventory check to keep the instance out, got %d %+v", code, status)
+	}
+
+	inventoryLoaded = true
+	code, status = ready()
+	if code != http.StatusOK || !status.Ready || status.Checks["inventory"] != "ok" {
+		t.Errorf("expected ready once every check passes, got %d %+v", code, status)
+	}
+}
+
+func TestDatabaseStatsEndpoint(t *testing.T) {
+	h := NewHarness(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	stats := func(token string) (int, data.DatabaseStats) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/admin/db-stats", nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		var body struct {
+			Data data.DatabaseStats `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &body))
+		return resp.StatusCode, body.Data
+	}
+
+	if status, _ := stats("not-a-token"); status != http.StatusForbidden {
+		t.Errorf("expected database stats to need an admin token, got %d", status)
+	}
+	status, got := stats(adminToken)
+	if status != http.StatusOK {
+		t.Fatalf("expected the stats, got %d", status)
+	}
+	if got.Tables["membership_submissions"] != 3 || got.Tables["event_submissions"] != 3 {
+		t.Errorf("expected the seeded rows counted, got %v", got.Tables)
+	}
+	if got.Pool.MaxOpenConnections == 0 || got.Pool.OpenConnections == 0 {
+		t.Errorf("expected the connection pool's stats, got %+v", got.Pool)
+	}
+	if got.LastMigration == nil || got.LastMigration.Version != data.LatestSchemaVersion() || got.PendingMigrations != 0 {
+		t.Errorf("expected the latest migration applied, got %+v with %d pending", got.LastMigration, got.PendingMigrations)
+	}
+	if got.File == nil || got.File.Bytes == 0 || got.File.JournalMode != "wal" || got.File.PageCount == 0 {
+		t.Errorf("expected the SQLite file's size and journal mode, got %+v", got.File)
+	}
+}
+
+func TestDatabaseMaintenanceReclaimsFreePages(t *testing.T) {
+	h := NewHarness(t)
+	ctx := context.Background()
+
+	var autoVacuum int
+	h.AssertNoError(t, h.DB.QueryRow(`PRAGMA auto_vacuum`).Scan(&autoVacuum))
+	if autoVacuum != 2 {
+		t.Errorf("expected a new database to vacuum incrementally, got auto_vacuum %d", autoVacuum)
+	}
+
+	// Deleted rows leave free pages behind
+	churn := func() {
+		for i := 0; i < 200; i++ {
+			h.AssertNoError(t, data.InsertMembership(data.MembershipSubmission{
+				FormID:         fmt.Sprintf("membership-churn-%d", i),
+				SubmissionDate: time.Now(),
+				FullName:       "Churned Member",
+				Email:          "churn@example.com",
+				Describe:       strings.Repeat("x", 2000),
+			}))
+		}
+		_, err := h.DB.Exec(`DELETE FROM membership_submissions WHERE form_id LIKE 'membership-churn-%'`)
+		h.AssertNoError(t, err)
+	}
+	churn()
+
+	run, err := data.MaintainDatabase(ctx)
+	h.AssertNoError(t, err)
+	if run.Converted || run.FreePagesBefore == 0 || run.FreePagesAfter != 0 {
+		t.Errorf("expected the free pages given back incrementally, got %+v", run)
+	}
+	if run.CheckpointBusy {
+		t.Errorf("expected the write-ahead log truncated with nothing reading it, got %+v", run)
+	}
+	var analyzed int
+	h.AssertNoError(t, h.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_stat1`).Scan(&analyzed))
+	if analyzed == 0 {
+		t.Error("expected ANALYZE to have gathered statistics")
+	}
+	stats, err := data.GetDatabaseStats()
+	h.AssertNoError(t, err)
+	if stats.LastMaintenance == nil || !stats.LastMaintenance.StartedAt.Equal(run.StartedAt) || stats.File.WALBytes != 0 {
+		t.Errorf("expected the run in the stats and the log truncated, got %+v and %+v", stats.LastMaintenance, stats.File)
+	}
+
+	// A database from before incremental vacuuming is rebuilt once
+	conn, err := h.DB.Conn(ctx)
+	h.AssertNoError(t, err)
+	_, err = conn.ExecContext(ctx, `PRAGMA auto_vacuum = NONE`)
+	h.AssertNoError(t, err)
+	_, err = conn.ExecContext(ctx, `VACUUM`)
+	h.AssertNoError(t, err)
+	conn.Close()
+	churn()
+	run, err = data.MaintainDatabase(ctx)
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, h.DB.QueryRow(`PRAGMA auto_vacuum`).Scan(&autoVacuum))
+	if !run.Converted || run.FreePagesAfter != 0 || autoVacuum != 2 {
+		t.Errorf("expected the database converted to incremental vacuuming, got %+v with auto_vacuum %d", run, autoVacuum)
+	}
+}
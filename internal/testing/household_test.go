@@ -0,0 +1,145 @@
+package testing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/household"
+	"sbcbackend/internal/security"
+)
+
+var householdCodePattern = regexp.MustCompile(`/api/household\?code=([A-Za-z0-9_-]+)`)
+
+func TestHouseholds(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "https://booster.example.org")
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	seeded, err := data.GetMembershipByID("membership-seed-001")
+	h.AssertNoError(t, err)
+	jane, err := data.GetHouseholdByEmail("jane.smith@example.com")
+	h.AssertNoError(t, err)
+	if jane == nil {
+		t.Fatalf("expected Jane's seeded membership to create her household")
+	}
+
+	// Last year's donation, made with the address in another case, joins the same household
+	lastYear := seeded.SubmissionDate.AddDate(-1, 0, 0)
+	h.AssertNoError(t, data.InsertFundraiser(data.FundraiserSubmission{
+		FormID:           "fundraiser-household-001",
+		AccessToken:      "household-token-001",
+		SubmissionDate:   lastYear,
+		FullName:         "Jane Smith",
+		FirstName:        "Jane",
+		LastName:         "Smith",
+		Email:            " Jane.Smith@Example.com",
+		School:           "lincoln-elementary",
+		Students:         []data.Student{{Name: "Emma Smith", Grade: "2"}},
+		CalculatedAmount: 40,
+		PayPalStatus:     "COMPLETED",
+		Submitted:        true,
+		SubmittedAt:      &lastYear,
+	}))
+	orders, err := data.ListHouseholdOrders(jane.ID)
+	h.AssertNoError(t, err)
+	if len(orders) != 2 || orders[0].FormID != "fundraiser-household-001" || orders[1].FormID != seeded.FormID {
+		t.Fatalf("expected Jane's donation and membership, oldest first, got %+v", orders)
+	}
+
+	// Submissions from before households existed are linked by the migration
+	_, err = data.ExecDB(`UPDATE fundraiser_submissions SET household_id = NULL WHERE form_id = ?`, "fundraiser-household-001")
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, data.CreateTables())
+	if orders, err = data.ListHouseholdOrders(jane.ID); err != nil || len(orders) != 2 {
+		t.Fatalf("expected the migration to relink the donation, got %d (%v)", len(orders), err)
+	}
+
+	report, err := household.Report()
+	h.AssertNoError(t, err)
+	years := make(map[int]household.YearSummary)
+	for _, year := range report {
+		years[year.Year] = year
+	}
+	before, after := years[lastYear.In(clock.Location()).Year()], years[seeded.SubmissionDate.In(clock.Location()).Year()]
+	if before.Households != 1 || before.New != 1 || before.ByFormType["fundraiser"] != 40 {
+		t.Errorf("expected Jane's donation to be the only household last year, got %+v", before)
+	}
+	if after.Returning != 1 || after.Previous != 1 || after.Retention != 1 {
+		t.Errorf("expected Jane to count as a returning household, got %+v", after)
+	}
+
+	signIn := func(emailAddress string) int {
+		t.Helper()
+		resp, err := h.Client.PostForm(h.Server.URL+"/api/household/sign-in", url.Values{
+			"email":      {emailAddress},
+			"csrf_token": {security.GenerateCSRFToken()},
+		})
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := signIn("nobody@example.com"); code != http.StatusOK {
+		t.Errorf("expected an unknown address to get the usual answer, got %d", code)
+	}
+	if code := signIn("JANE.SMITH@example.com"); code != http.StatusOK {
+		t.Fatalf("expected the sign-in to be accepted, got %d", code)
+	}
+	signIn("jane.smith@example.com") // within the resend interval
+	sent := h.Mailer.SentTo("jane.smith@example.com")
+	if len(sent) != 1 || len(h.Mailer.SentTo("nobody@example.com")) != 0 {
+		t.Fatalf("expected one sign-in email to Jane only, got %d", len(sent))
+	}
+	match := householdCodePattern.FindStringSubmatch(sent[0].Body)
+	if match == nil || !strings.Contains(sent[0].Body, "https://booster.example.org/api/household?code=") {
+		t.Fatalf("expected a sign-in link, got %q", sent[0].Body)
+	}
+	code := match[1]
+
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := h.Client.Get(h.Server.URL + path)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("/api/household?code=" + code)
+	page := string(body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(page, "Basic Membership") ||
+		!strings.Contains(page, "/api/receipt?formID=fundraiser-household-001") {
+		t.Fatalf("expected the orders page to list both years, got %d: %s", resp.StatusCode, page)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the orders page to stay out of caches")
+	}
+	if jane, err = data.GetHouseholdByEmail("jane.smith@example.com"); err != nil || jane.VerifiedAt == nil {
+		t.Errorf("expected opening the link to verify Jane's household")
+	}
+
+	// The newest submission fills in the next form
+	resp, body = get("/api/household/prefill?code=" + code)
+	var prefill struct {
+		Data household.Prefill `json:"data"`
+	}
+	h.AssertNoError(t, json.Unmarshal(body, &prefill))
+	if resp.StatusCode != http.StatusOK || prefill.Data.FirstName != "Jane" || len(prefill.Data.Students) != 2 {
+		t.Errorf("expected the membership's details, got %d: %s", resp.StatusCode, body)
+	}
+
+	fake.Advance(8 * 24 * time.Hour)
+	if resp, _ := get("/api/household?code=" + code); resp.StatusCode != http.StatusGone {
+		t.Errorf("expected the link to expire after a week, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,98 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+
+	registration := h.GenerateTestEvent().ToEventSubmission()
+	registration.CalculatedAmount = 45
+	h.AssertNoError(t, data.InsertEvent(registration))
+
+	post := func(path, key string, body interface{}) (*http.Response, []byte) {
+		t.Helper()
+		payload, _ := json.Marshal(body)
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+path, bytes.NewReader(payload))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", registration.AccessToken)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp, respBody
+	}
+	createBody := map[string]interface{}{"formID": registration.FormID}
+
+	first, firstBody := post("/api/create-order", "create-1", createBody)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("create-order returned %d: %s", first.StatusCode, firstBody)
+	}
+	var created struct {
+		Data struct {
+			OrderID string `json:"orderID"`
+		} `json:"data"`
+	}
+	h.AssertNoError(t, json.Unmarshal(firstBody, &created))
+	if len(h.PayPal.RequestIDs) != 1 || !strings.HasPrefix(h.PayPal.RequestIDs[0], "order-") {
+		t.Errorf("expected the order created under a PayPal-Request-Id, got %v", h.PayPal.RequestIDs)
+	}
+
+	// A retry under the same key gets the first response without reaching PayPal
+	retry, retryBody := post("/api/create-order", "create-1", createBody)
+	if retry.StatusCode != http.StatusOK || retry.Header.Get("Idempotent-Replayed") != "true" ||
+		!bytes.Equal(retryBody, firstBody) {
+		t.Fatalf("expected the first response replayed, got %d %q: %s",
+			retry.StatusCode, retry.Header.Get("Idempotent-Replayed"), retryBody)
+	}
+	if count := h.PayPal.GetOrderCount(); count != 1 {
+		t.Errorf("expected one PayPal order, got %d", count)
+	}
+
+	// The key can't be reused for a different request
+	reused, _ := post("/api/create-order", "create-1",
+		map[string]interface{}{"formID": registration.FormID, "fundingSource": "venmo"})
+	if reused.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected a reused key refused, got %d", reused.StatusCode)
+	}
+
+	captureBody := map[string]string{"orderID": created.Data.OrderID, "formID": registration.FormID}
+	captured, capturedBody := post("/api/capture-order", "capture-1", captureBody)
+	if captured.StatusCode != http.StatusOK {
+		t.Fatalf("capture-order returned %d: %s", captured.StatusCode, capturedBody)
+	}
+	again, againBody := post("/api/capture-order", "capture-1", captureBody)
+	if again.Header.Get("Idempotent-Replayed") != "true" || !bytes.Equal(againBody, capturedBody) {
+		t.Errorf("expected the capture replayed, got %d: %s", again.StatusCode, againBody)
+	}
+	captures := 0
+	for _, requestID := range h.PayPal.RequestIDs {
+		if strings.HasPrefix(requestID, "capture-") {
+			captures++
+		}
+	}
+	if captures != 1 {
+		t.Errorf("expected one capture sent to PayPal under a PayPal-Request-Id, got %v", h.PayPal.RequestIDs)
+	}
+
+	// Cleanup forgets old keys
+	removed, err := data.PurgeIdempotencyKeys(time.Now().Add(time.Minute))
+	h.AssertNoError(t, err)
+	if removed != 2 {
+		t.Errorf("expected both keys purged, got %d", removed)
+	}
+}
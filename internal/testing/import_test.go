@@ -0,0 +1,110 @@
+package testing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/security"
+)
+
+func TestImportHistoricalRecords(t *testing.T) {
+	h := NewHarness(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	post := func(token, query, contentType, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/admin/import?"+query, strings.NewReader(body))
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		req.Header.Set("Content-Type", contentType)
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		var reply map[string]interface{}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &reply))
+		return resp.StatusCode, reply
+	}
+
+	// Columns as the CSV export writes them, with a spreadsheet's dates
+	memberships := "form_id,submission_date,full_name,email,school,amount,paypal_status,membership,interests,student_count," +
+		"student_1_name,student_1_grade,fee_orchestra,addon_tshirt,receipt_number\n" +
+		",9/15/2021,Ana Lopez,Ana.Lopez@example.com,Lincoln,45.00,COMPLETED,Family,Chaperone; Fundraising,1,Sofia,5,1,yes,R-0001\n" +
+		",2021-09-20,Ben Okafor,ben.okafor@example.com,Lincoln,$30.00,,Single,,0,,,,no,\n"
+
+	if status, _ := post("not-a-token", "type=membership", "text/csv", memberships); status != http.StatusForbidden {
+		t.Errorf("expected imports to need an admin token, got %d", status)
+	}
+
+	status, reply := post(adminToken, "type=membership&dry_run=true", "text/csv", memberships)
+	if status != http.StatusOK {
+		t.Fatalf("expected the dry run to pass, got %d %v", status, reply)
+	}
+	if found, _ := data.ListSubmissionsByEmail("ana.lopez@example.com"); len(found) != 0 {
+		t.Errorf("expected a dry run to import nothing, got %+v", found)
+	}
+
+	status, reply = post(adminToken, "type=membership", "text/csv", memberships)
+	if status != http.StatusOK {
+		t.Fatalf("expected the memberships imported, got %d %v", status, reply)
+	}
+	result := reply["data"].(map[string]interface{})
+	if result["imported"] != float64(2) {
+		t.Errorf("expected 2 memberships imported, got %v", result)
+	}
+	formID := result["form_ids"].([]interface{})[0].(string)
+	ana, err := data.GetMembershipByID(formID)
+	h.AssertNoError(t, err)
+	if ana.Email != "ana.lopez@example.com" || ana.SubmissionDate.Year() != 2021 || !ana.Submitted ||
+		ana.PayPalStatus != "COMPLETED" || len(ana.Students) != 1 || ana.Students[0].Name != "Sofia" ||
+		ana.Fees["orchestra"] != 1 || len(ana.Addons) != 1 || len(ana.Interests) != 2 || ana.ReceiptNumber != "" {
+		t.Errorf("expected Ana's membership as exported, got %+v", ana)
+	}
+	if household, err := data.GetHouseholdByEmail("ana.lopez@example.com"); err != nil || household == nil {
+		t.Errorf("expected Ana's import linked to a household, got %v", err)
+	}
+
+	// Importing the same file again is refused rather than doubling it
+	if status, _ := post(adminToken, "type=membership", "text/csv", memberships); status != http.StatusBadRequest {
+		t.Errorf("expected a second import of the same file refused, got %d", status)
+	}
+
+	// JSON works too, and one bad record keeps the whole batch out
+	donations := []map[string]interface{}{
+		{"submission_date": "2022-03-01", "full_name": "Cara Diaz", "email": "cara.diaz@example.com",
+			"donation_items": []map[string]interface{}{{"student_name": "Leo", "amount": 20}, {"student_name": "Max", "amount": 15}}},
+		{"submission_date": "2022-03-02", "full_name": "", "email": "nobody@example.com", "amount": 10},
+	}
+	body, err := json.Marshal(donations)
+	h.AssertNoError(t, err)
+	status, reply = post(adminToken, "type=fundraiser", "application/json", string(body))
+	if status != http.StatusBadRequest || !strings.Contains(reply["details"].(string), "record 2") {
+		t.Errorf("expected record 2 refused, got %d %v", status, reply)
+	}
+	if found, _ := data.ListSubmissionsByEmail("cara.diaz@example.com"); len(found) != 0 {
+		t.Errorf("expected nothing imported when a record is bad, got %+v", found)
+	}
+
+	body, err = json.Marshal(donations[:1])
+	h.AssertNoError(t, err)
+	status, reply = post(adminToken, "type=fundraiser", "application/json", string(body))
+	if status != http.StatusOK {
+		t.Fatalf("expected Cara's donation imported, got %d %v", status, reply)
+	}
+	cara, err := data.ListSubmissionsByEmail("cara.diaz@example.com")
+	h.AssertNoError(t, err)
+	if len(cara) != 1 || cara[0].FormType != "fundraiser" || cara[0].CalculatedAmount != 35 {
+		t.Errorf("expected Cara's $35 donation, got %+v", cara)
+	}
+
+	if status, _ := post(adminToken, "type=event", "application/json", `[{"submission_date":"2022-01-01","full_name":"A B","email":"a@example.com"}]`); status != http.StatusBadRequest {
+		t.Errorf("expected an event registration without an event refused, got %d", status)
+	}
+}
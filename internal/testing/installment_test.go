@@ -0,0 +1,137 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/security"
+)
+
+func TestInstallmentPlan(t *testing.T) {
+	h := NewHarness(t)
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("INSTALLMENTS_ENABLED_DEV", "true")
+	t.Setenv("INSTALLMENT_INTERVAL_DEV", "720h")
+	previous := middleware.SetTokenRateLimit(0)
+	t.Cleanup(func() { middleware.SetTokenRateLimit(previous) })
+
+	registration := h.GenerateTestEvent().ToEventSubmission()
+	registration.CalculatedAmount = 300.01
+	h.AssertNoError(t, data.InsertEvent(registration))
+	small := h.GenerateTestEvent().ToEventSubmission()
+	small.CalculatedAmount = 80
+	h.AssertNoError(t, data.InsertEvent(small))
+
+	token := registration.AccessToken
+	create := func(formID, token string, installments int) (int, payment.CreateOrderResponse) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"formID": formID, "installments": installments})
+		req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", token)
+		rec := httptest.NewRecorder()
+		middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		return rec.Code, created.Data
+	}
+	pay := func(orderID string) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"orderID": orderID, "formID": registration.FormID})
+		req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+		req.Header.Set("X-Access-Token", token)
+		rec := httptest.NewRecorder()
+		payment.CapturePayPalOrderHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("capture of %s returned %d: %s", orderID, rec.Code, rec.Body.String())
+		}
+	}
+	status := func() string {
+		t.Helper()
+		summary, err := data.GetSubmissionSummary(registration.FormID)
+		h.AssertNoError(t, err)
+		return summary.PayPalStatus
+	}
+
+	if code, _ := create(small.FormID, small.AccessToken, 2); code != http.StatusBadRequest {
+		t.Errorf("expected installments to be refused below the minimum, got %d", code)
+	}
+	if code, _ := create(registration.FormID, token, 9); code != http.StatusBadRequest {
+		t.Errorf("expected more installments than allowed to be refused, got %d", code)
+	}
+
+	_, first := create(registration.FormID, token, 3)
+	if first.Installment != 1 || first.Installments != 3 || first.Amount != 100.01 {
+		t.Fatalf("expected the first of 3 installments for $100.01, got %+v", first)
+	}
+	if order, ok := h.PayPal.GetOrder(first.OrderID); !ok || order.Amount != "100.01" || order.FormID != registration.FormID+"/1" {
+		t.Fatalf("expected an order for the first installment, got %+v", order)
+	}
+	if _, again := create(registration.FormID, token, 3); again.OrderID != first.OrderID {
+		t.Errorf("expected the first installment's order to be reused, got %s", again.OrderID)
+	}
+	pay(first.OrderID)
+	if got := status(); got != data.InstallmentsStatus {
+		t.Fatalf("expected the registration part way through its installments, got %s", got)
+	}
+
+	// The second installment is due in 30 days; a 45-day lead reminds of it but not the third
+	remind := cleanup.NewInstallmentReminderJob(45 * 24 * time.Hour)
+	h.AssertNoError(t, remind(context.Background()))
+	h.AssertNoError(t, remind(context.Background()))
+	sent := h.Mailer.SentTo(registration.Email)
+	if len(sent) != 1 || !strings.Contains(sent[0].Subject, "Installment 2 of 3") || !strings.Contains(sent[0].Body, "$100.00") {
+		t.Fatalf("expected one reminder of installment 2, got %+v", sent)
+	}
+
+	// The reminder's link brings the family back with a fresh access token
+	var resumeToken string
+	h.AssertNoError(t, h.DB.QueryRow(`SELECT resume_token FROM event_submissions WHERE form_id = ?`,
+		registration.FormID).Scan(&resumeToken))
+	if !strings.Contains(sent[0].Body, resumeToken) {
+		t.Errorf("expected the reminder to link back to checkout")
+	}
+	token, _ = security.GenerateAccessToken()
+	resumed, err := data.ResumeCheckout("event", registration.FormID, resumeToken, token)
+	h.AssertNoError(t, err)
+	if !resumed {
+		t.Fatalf("expected the reminder link to resume a registration paying installments")
+	}
+	security.StoreAccessToken(token, registration.FormID, "event")
+
+	_, second := create(registration.FormID, token, 0)
+	if second.Installment != 2 || second.Amount != 100.00 {
+		t.Fatalf("expected the second installment, got %+v", second)
+	}
+	pay(second.OrderID)
+	_, third := create(registration.FormID, token, 0)
+	pay(third.OrderID)
+	if got := status(); got != "COMPLETED" {
+		t.Errorf("expected the last installment to complete the registration, got %s", got)
+	}
+
+	plan, err := data.ListInstallments(registration.FormID)
+	h.AssertNoError(t, err)
+	total := 0.0
+	for _, installment := range plan {
+		if installment.Status != data.InstallmentPaid {
+			t.Errorf("expected installment %d paid, got %s", installment.Number, installment.Status)
+		}
+		total += installment.Amount
+	}
+	if len(plan) != 3 || total < 300.005 || total > 300.015 {
+		t.Errorf("expected 3 installments adding up to $300.01, got %+v", plan)
+	}
+}
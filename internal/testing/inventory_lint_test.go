@@ -0,0 +1,155 @@
+package testing
+
+import (
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/inventory"
+)
+
+const lintBaseInventory = `{
+	"memberships": [{"id": "basic", "name": "Basic Membership", "price": 25, "available": true}],
+	"products": [],
+	"fees": [{"id": "spring", "name": "Spring Festival Fee", "price": 25, "event": "spring-festival", "available": true}],
+	"events": {
+		"spring-festival": {
+			"per_student_options": {"lunch": {"label": "Lunch", "price": 10, "is_food": true}},
+			"shared_options": {"program": {"label": "Program Book", "price": 5, "max_quantity": 5}},
+			"change_cutoff": "2026-05-01"
+		}
+	},
+	"processing_fees": {"rate": 0.029, "fixed_fee": 0.30}
+}`
+
+func TestInventoryLint(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(string) string
+		wantError string
+	}{
+		{name: "Clean", edit: func(s string) string { return s }},
+		{
+			name: "MisspelledField",
+			edit: func(s string) string {
+				return strings.Replace(s, `"price": 25, "available"`, `"price": 25, "availabel"`, 1)
+			},
+			wantError: `unknown field "availabel"`,
+		},
+		{
+			name: "FeeForMissingEvent",
+			edit: func(s string) string {
+				return strings.Replace(s, `"event": "spring-festival"`, `"event": "fall-festival"`, 1)
+			},
+			wantError: `event "fall-festival" is not configured`,
+		},
+		{
+			name:      "BadCutoff",
+			edit:      func(s string) string { return strings.Replace(s, `"2026-05-01"`, `"May 1"`, 1) },
+			wantError: "change_cutoff",
+		},
+		{
+			name: "TemplateOutsideTemplateDir",
+			edit: func(s string) string {
+				return strings.Replace(s, `"change_cutoff": "2026-05-01"`, `"change_cutoff": "2026-05-01", "print_template": "../secrets.tmpl"`, 1)
+			},
+			wantError: "print_template",
+		},
+		{
+			name:      "NegativePrice",
+			edit:      func(s string) string { return strings.Replace(s, `"price": 10`, `"price": -10`, 1) },
+			wantError: "must be zero or more",
+		},
+		{
+			name: "DuplicateName",
+			edit: func(s string) string {
+				return strings.Replace(s, `"products": []`, `"products": [{"id": "a", "name": "Shirt", "price": 1, "available": true}, {"id": "b", "name": "Shirt", "price": 2, "available": true}]`, 1)
+			},
+			wantError: `name "Shirt" is also used`,
+		},
+		{
+			name: "AliasToMissingItem",
+			edit: func(s string) string {
+				return strings.Replace(s, `"processing_fees"`, `"aliases": {"Spring Fee": "Spring Festival Registration"}, "processing_fees"`, 1)
+			},
+			wantError: `no membership, product or fee is named "Spring Festival Registration"`,
+		},
+		{
+			name: "AliasShadowsItem",
+			edit: func(s string) string {
+				return strings.Replace(s, `"processing_fees"`, `"aliases": {"Spring Festival Fee": "Basic Membership"}, "processing_fees"`, 1)
+			},
+			wantError: `"Spring Festival Fee" is still an item name`,
+		},
+		{
+			name: "AliasLoop",
+			edit: func(s string) string {
+				return strings.Replace(s, `"processing_fees"`, `"aliases": {"A": "B", "B": "A"}, "processing_fees"`, 1)
+			},
+			wantError: "aliases loop back",
+		},
+		{
+			name: "CouponOverHundredPercent",
+			edit: func(s string) string {
+				return strings.Replace(s, `"processing_fees"`, `"coupons": [{"code": "ALL", "type": "percent", "amount": 150, "available": true}], "processing_fees"`, 1)
+			},
+			wantError: "at most 100",
+		},
+		{
+			name: "DuplicateCouponCode",
+			edit: func(s string) string {
+				return strings.Replace(s, `"processing_fees"`, `"coupons": [{"code": "save5", "type": "fixed", "amount": 5, "available": true}, {"code": "SAVE5", "type": "fixed", "amount": 5, "applies_to": ["fundraiser"], "available": true}], "processing_fees"`, 1)
+			},
+			wantError: `code "SAVE5" is also used by coupons[0]`,
+		},
+		{
+			name: "UnsupportedCurrency",
+			edit: func(s string) string {
+				return strings.Replace(s, `"price": 25, "available"`, `"price": 25, "currency": "XYZ", "available"`, 1)
+			},
+			wantError: `memberships[0].currency: currency "XYZ" is not supported`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, problems := inventory.Lint([]byte(tt.edit(lintBaseInventory)))
+
+			var errs []string
+			for _, problem := range problems {
+				if !problem.Warning {
+					errs = append(errs, problem.String())
+				}
+			}
+			if tt.wantError == "" {
+				if len(errs) > 0 {
+					t.Fatalf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if !strings.Contains(strings.Join(errs, "\n"), tt.wantError) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantError, errs)
+			}
+		})
+	}
+}
+
+func TestInventoryDiff(t *testing.T) {
+	current, _ := inventory.Lint([]byte(lintBaseInventory))
+	next, _ := inventory.Lint([]byte(strings.NewReplacer(
+		`"price": 25, "available": true}],
+	"products"`, `"price": 30, "available": true}],
+	"products"`,
+		`"program": {"label": "Program Book", "price": 5, "max_quantity": 5}`, `"dessert": {"label": "Dessert", "price": 3}`,
+	).Replace(lintBaseInventory)))
+
+	changes := strings.Join(inventory.Diff(current, next), "\n")
+	for _, want := range []string{
+		`~ membership "Basic Membership" price $25.00 -> $30.00`,
+		`- event "spring-festival" option "shared program"`,
+		`+ event "spring-festival" option "shared dessert" at $3.00`,
+	} {
+		if !strings.Contains(changes, want) {
+			t.Errorf("expected %q in the diff, got:\n%s", want, changes)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package testing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+)
+
+// TestKitchenReport checks the food totals /info/kitchen prints for an event: lunches
+// of paid registrations add up across students and families, other options and
+// unpaid orders aren't counted, and a year without food orders says so
+func TestKitchenReport(t *testing.T) {
+	h := NewHarness(t)
+	year := time.Now().Year()
+
+	insert := func(status string, submitted time.Time, lunches []bool, notes map[string]data.DietaryNote) {
+		t.Helper()
+		event := h.GenerateTestEvent("multiple_students").ToEventSubmission()
+		selections := map[string]interface{}{
+			"student_selections": map[string]map[string]bool{},
+			"shared_selections":  map[string]int{"program": 2},
+		}
+		for i, lunch := range lunches {
+			selections["student_selections"].(map[string]map[string]bool)[strconv.Itoa(i)] =
+				map[string]bool{"registration": true, "lunch": lunch}
+		}
+		raw, _ := json.Marshal(selections)
+		event.FoodChoicesJSON = string(raw)
+		event.HasFoodOrders = true
+		event.DietaryNotes = notes
+		event.SubmissionDate = submitted
+		event.Submitted, event.PayPalStatus = true, status
+		h.AssertNoError(t, data.InsertEvent(event))
+	}
+	now := time.Now()
+	insert("COMPLETED", now, []bool{true, true}, map[string]data.DietaryNote{
+		"0": {StudentName: "Alice Doe", Notes: "Peanut allergy", Allergy: true},
+	})
+	insert("COMPLETED", now, []bool{true, false}, nil)
+	insert("REFUNDED", now, []bool{true, true}, nil)
+	insert("COMPLETED", now.AddDate(-1, 0, 0), []bool{false, false}, nil)
+
+	report := func(query string) (int, string) {
+		t.Helper()
+		resp, err := h.Client.Get(h.Server.URL + "/info/kitchen?" + query)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		h.AssertNoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := report("event=spring-festival&year=" + strconv.Itoa(year))
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	for _, want := range []string{
+		"Paid food orders: 2",
+		"<tr><td>Lunch</td><td>3</td></tr>",
+		"1 ALLERGY FLAGGED",
+		"Peanut allergy",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the report to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "Program Book") {
+		t.Error("expected options that aren't food left out of the totals")
+	}
+
+	// Last year's only registration ordered no lunches
+	status, body = report("event=spring-festival&year=" + strconv.Itoa(year-1))
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if !strings.Contains(body, "No food has been ordered for this event yet.") || strings.Contains(body, "<td>Lunch</td>") {
+		t.Errorf("expected no food totals for a year without food orders, got:\n%s", body)
+	}
+	if strings.Contains(body, "FLAGGED") {
+		t.Error("expected no allergy alert without dietary notes")
+	}
+
+	if status, _ := report("year=" + strconv.Itoa(year)); status != http.StatusBadRequest {
+		t.Errorf("expected 400 without an event, got %d", status)
+	}
+	if status, _ := report("event=winter-gala"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown event, got %d", status)
+	}
+}
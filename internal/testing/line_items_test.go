@@ -0,0 +1,126 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/inventory"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypal"
+)
+
+func TestItemizedPayPalOrders(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+	loadCouponInventory(t, h)
+
+	t.Run("Membership", func(t *testing.T) {
+		sub := h.GenerateTestMembership().ToMembershipSubmission()
+		h.AssertNoError(t, data.InsertMembership(sub))
+		body, _ := json.Marshal(map[string]interface{}{
+			"formID": sub.FormID, "membership": "Basic Membership", "addons": []string{"T-Shirt"},
+			"fees": map[string]int{}, "donation": 10, "cover_fees": true, "coupon": "SAVE10",
+		})
+		req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/save-membership-payment", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", sub.AccessToken)
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		h.AssertStatusCode(t, resp, http.StatusOK)
+
+		resp, err = h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": sub.FormID}, sub.AccessToken)
+		h.AssertNoError(t, err)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+		order, ok := h.PayPal.GetOrder(created.Data.OrderID)
+		if !ok {
+			t.Fatalf("expected a PayPal order, got %+v", created.Data)
+		}
+
+		// $25 + $15 - $10 coupon + $10 donation, with fees on top
+		if order.Amount != "41.29" || order.Discount != "10.00" {
+			t.Errorf("expected $41.29 after a $10 discount, got $%s after $%s", order.Amount, order.Discount)
+		}
+		want := map[string]string{
+			"Basic Membership": "25.00", "T-Shirt": "15.00", "Extra Donation": "10.00", "Processing Fees": "1.29",
+		}
+		if len(order.Items) != len(want) {
+			t.Fatalf("expected %d items, got %+v", len(want), order.Items)
+		}
+		for _, item := range order.Items {
+			if want[item.Name] != item.UnitAmount.Value || item.Quantity != "1" {
+				t.Errorf("unexpected item %s x%s at %s", item.Name, item.Quantity, item.UnitAmount.Value)
+			}
+			if (item.Name == "Extra Donation") != (item.Category == paypal.CategoryDonation) {
+				t.Errorf("expected only the donation listed as one, got %s as %s", item.Name, item.Category)
+			}
+		}
+	})
+
+	t.Run("RoundUp", func(t *testing.T) {
+		sub := h.GenerateTestMembership().ToMembershipSubmission()
+		h.AssertNoError(t, data.InsertMembership(sub))
+		// The amount a client claims for the round-up is ignored; the server works it out
+		body, _ := json.Marshal(map[string]interface{}{
+			"formID": sub.FormID, "membership": "Basic Membership", "addons": []string{"T-Shirt"},
+			"fees": map[string]int{}, "donation": 10, "cover_fees": true, "coupon": "SAVE10",
+			"round_up": true, "round_up_amount": 50,
+		})
+		req, _ := http.NewRequest(http.MethodPost, h.Server.URL+"/api/save-membership-payment", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", sub.AccessToken)
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		h.AssertStatusCode(t, resp, http.StatusOK)
+
+		summary, err := data.GetSubmissionSummary(sub.FormID)
+		h.AssertNoError(t, err)
+		saved, err := data.GetMembershipByID(sub.FormID)
+		h.AssertNoError(t, err)
+		if summary.CalculatedAmount != 42 || summary.RoundUp != 0.71 || saved.Donation != 10 {
+			t.Fatalf("expected $41.29 rounded up to $42 apart from the $10 donation, got $%.2f with $%.2f round-up and $%.2f donation",
+				summary.CalculatedAmount, summary.RoundUp, saved.Donation)
+		}
+
+		resp, err = h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": sub.FormID}, sub.AccessToken)
+		h.AssertNoError(t, err)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+		order, ok := h.PayPal.GetOrder(created.Data.OrderID)
+		if !ok || order.Amount != "42.00" {
+			t.Fatalf("expected a $42 PayPal order, got %+v", order)
+		}
+		var roundUp *paypal.Item
+		for i := range order.Items {
+			if order.Items[i].Name == "Round-up for the Program" {
+				roundUp = &order.Items[i]
+			}
+		}
+		if roundUp == nil || roundUp.UnitAmount.Value != "0.71" || roundUp.Category != paypal.CategoryDonation {
+			t.Errorf("expected the round-up itemized as a $0.71 donation, got %+v", order.Items)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		// Items that no longer add up to what's charged are left off
+		items := []inventory.LineItem{{Name: "Basic Membership", Price: 30, Quantity: 1}}
+		orderData := payment.WithItems(payment.NewOrderRequest("form-1", "Membership", 25, "USD"), items, 0)
+		if unit := orderData.PurchaseUnits[0]; len(unit.Items) != 0 || unit.Amount.Breakdown != nil {
+			t.Errorf("expected the order unitemized, got %+v", unit)
+		}
+
+		orderData = payment.WithItems(payment.NewOrderRequest("form-1", "Membership", 25, "USD"), items, 5)
+		if unit := orderData.PurchaseUnits[0]; len(unit.Items) != 1 || unit.Amount.Breakdown.Discount.Value != "5.00" {
+			t.Errorf("expected the order itemized with a $5 discount, got %+v", unit)
+		}
+	})
+}
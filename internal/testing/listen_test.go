@@ -0,0 +1,163 @@
+package testing
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/restart"
+)
+
+// systemdRole makes the test binary act as a service systemd activated with a socket
+const systemdRole = "SBC_SYSTEMD_TEST_ROLE"
+
+func TestListenUnixSocket(t *testing.T) {
+	t.Setenv(restart.EnvListenFD, "")
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("SERVER_SOCKET_MODE", "")
+	path := filepath.Join(t.TempDir(), "server.sock")
+
+	// Without SERVER_SOCKET the server listens on TCP
+	ln, err := restart.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected a TCP listener, got %v", err)
+	}
+	if ln.Addr().Network() != "tcp" {
+		t.Errorf("expected a TCP listener, got %s", ln.Addr().Network())
+	}
+	ln.Close()
+
+	// SERVER_SOCKET listens there instead, readable by the proxy's group
+	t.Setenv("SERVER_SOCKET", path)
+	ln, err = restart.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected a unix socket at %s, got %v", path, err)
+	}
+	if ln.Addr().Network() != "unix" || ln.Addr().String() != path {
+		t.Errorf("expected a unix socket at %s, got %s %s", path, ln.Addr().Network(), ln.Addr())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("expected the socket to default to 0660, got %o", info.Mode().Perm())
+	}
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("expected to connect to the socket, got %v", err)
+	}
+	conn.Close()
+
+	// A socket another process is serving on is left alone
+	if _, err := restart.UnixListener(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected a live socket to be refused, got %v", err)
+	}
+
+	// A socket left by an unclean exit is replaced
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("expected the stale socket left behind, got %v", err)
+	}
+	t.Setenv("SERVER_SOCKET_MODE", "600")
+	ln, err = restart.UnixListener(path)
+	if err != nil {
+		t.Fatalf("expected the stale socket replaced, got %v", err)
+	}
+	defer ln.Close()
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("expected SERVER_SOCKET_MODE to set 0600, got %o", info.Mode().Perm())
+	}
+
+	// Anything but a socket at the path is never removed
+	file := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restart.UnixListener(file); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("expected a regular file to be refused, got %v", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("expected the file kept, got %v", err)
+	}
+
+	t.Setenv("SERVER_SOCKET_MODE", "rw-rw----")
+	if _, err := restart.UnixListener(filepath.Join(t.TempDir(), "bad-mode.sock")); err == nil {
+		t.Error("expected a mode that isn't octal to be refused")
+	}
+}
+
+// TestSystemdListener passes a listener to a copy of the test binary as systemd's
+// socket activation does, on file descriptor 3 with LISTEN_PID and LISTEN_FDS set
+func TestSystemdListener(t *testing.T) {
+	if os.Getenv(systemdRole) == "service" {
+		systemdService()
+		return
+	}
+
+	// Sockets meant for another process, as a child started without clearing the
+	// variables would see, aren't taken
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if ln, err := restart.SystemdListener(); ln != nil || err != nil {
+		t.Errorf("expected no listener for another process's sockets, got %v: %v", ln, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Error("expected another process's variables left alone")
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if ln, err := restart.SystemdListener(); ln != nil || err != nil {
+		t.Errorf("expected no listener without sockets, got %v: %v", ln, err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	socket, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	service := exec.Command(os.Args[0], "-test.run=^TestSystemdListener$")
+	service.Env = append(os.Environ(), systemdRole+"=service", "LISTEN_FDS=1", "LISTEN_PID=")
+	service.ExtraFiles = []*os.File{socket} // descriptor 3
+	out, err := service.CombinedOutput()
+	if err != nil {
+		t.Fatalf("service failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "listening "+ln.Addr().String()+" fds=\n") {
+		t.Errorf("expected the service on the passed socket with the variables cleared, got:\n%s", out)
+	}
+}
+
+// systemdService takes the socket systemd passed, as the server does at startup, and
+// reports where it listens and what's left of LISTEN_FDS
+func systemdService() {
+	// systemd sets LISTEN_PID to the service's own PID, which only it knows in advance
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	ln, err := restart.SystemdListener()
+	if err != nil || ln == nil {
+		fmt.Printf("no listener: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("listening %s fds=%s\n", ln.Addr(), os.Getenv("LISTEN_FDS"))
+	ln.Close()
+	os.Exit(0)
+}
@@ -15,8 +15,8 @@ import (
 
 var (
 	// Test configuration flags
-	runLoad     = flag.Bool("load", false, "Run load tests")
 	runPayPal   = flag.Bool("paypal", false, "Run tests against real PayPal (requires credentials)")
+	update      = flag.Bool("update", false, "Rewrite golden files in testdata/golden")
 	testTimeout = flag.Duration("timeout", 30*time.Second, "Test timeout duration")
 	verbose     = flag.Bool("v", false, "Verbose test output")
 	parallel    = flag.Int("parallel", 4, "Number of parallel test workers")
@@ -93,8 +93,8 @@ func testFullMembershipFlow(t *testing.T, suite *TestSuite, mockPayPal *MockPayP
 	t.Logf("✓ Form submitted (FormID: %s)", testData.FormID)
 
 	// 2. User configures payment options
-	total, err := suite.Inventory.CalculateMembershipTotal(
-		testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees,
+	total, _, err := suite.Inventory.CalculateMembershipTotal(
+		testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees, "",
 	)
 	suite.AssertNoError(t, err)
 	t.Logf("✓ Payment configured (Total: $%.2f)", total)
@@ -154,8 +154,8 @@ func testFullEventFlow(t *testing.T, suite *TestSuite, mockPayPal *MockPayPalSer
 	}
 	sharedSelections := map[string]int{"program": 1}
 
-	total, err := suite.Inventory.CalculateEventTotal(
-		testData.Event, studentSelections, sharedSelections, testData.CoverFees,
+	total, _, err := suite.Inventory.CalculateEventTotal(
+		testData.Event, studentSelections, sharedSelections, testData.CoverFees, "",
 	)
 	suite.AssertNoError(t, err)
 	t.Logf("✓ Event options configured (Total: $%.2f)", total)
@@ -292,198 +292,6 @@ func testErrorRecovery(t *testing.T, suite *TestSuite, mockPayPal *MockPayPalSer
 	t.Log("✅ Error recovery tests completed")
 }
 
-// Load tests (only run when -load flag is set)
-func TestLoadTesting(t *testing.T) {
-	if !*runLoad {
-		t.Skip("Load testing disabled (use -load flag to enable)")
-	}
-
-	suite := NewTestSuite(t)
-	mockPayPal := NewMockPayPalService()
-	defer mockPayPal.Close()
-
-	t.Run("ConcurrentFormSubmissions", func(t *testing.T) {
-		testConcurrentFormSubmissions(t, suite, 50)
-	})
-
-	t.Run("HighVolumePayments", func(t *testing.T) {
-		testHighVolumePayments(t, suite, mockPayPal, 100)
-	})
-
-	t.Run("DatabaseStress", func(t *testing.T) {
-		testDatabaseStress(t, suite, 200)
-	})
-}
-
-func testConcurrentFormSubmissions(t *testing.T, suite *TestSuite, numSubmissions int) {
-	start := time.Now()
-
-	results := make(chan error, numSubmissions)
-
-	// Launch concurrent submissions
-	for i := 0; i < numSubmissions; i++ {
-		go func(id int) {
-			testData := suite.GenerateTestMembership()
-			testData.Email = fmt.Sprintf("load%d@test.com", id)
-			submission := testData.ToMembershipSubmission()
-
-			results <- data.InsertMembership(submission)
-		}(i)
-	}
-
-	// Collect results
-	var errors []error
-	for i := 0; i < numSubmissions; i++ {
-		if err := <-results; err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	duration := time.Since(start)
-	successRate := float64(numSubmissions-len(errors)) / float64(numSubmissions) * 100
-
-	t.Logf("Concurrent submissions: %d total, %d errors, %.1f%% success rate, %v duration",
-		numSubmissions, len(errors), successRate, duration)
-
-	if successRate < 95.0 {
-		t.Errorf("Success rate too low: %.1f%%", successRate)
-	}
-}
-
-func testHighVolumePayments(t *testing.T, suite *TestSuite, mockPayPal *MockPayPalService, numPayments int) {
-	start := time.Now()
-
-	// Pre-create memberships
-	var testData []TestMembershipData
-	for i := 0; i < numPayments; i++ {
-		membershipData := suite.GenerateTestMembership() // Renamed to avoid confusion
-		membershipData.Email = fmt.Sprintf("payment%d@test.com", i)
-		submission := membershipData.ToMembershipSubmission()
-
-		if err := data.InsertMembership(submission); err != nil {
-			t.Fatalf("Failed to create test membership %d: %v", i, err)
-		}
-
-		testData = append(testData, membershipData)
-	}
-
-	setupDuration := time.Since(start)
-	t.Logf("Setup completed in %v", setupDuration)
-
-	// Run payment processing concurrently
-	start = time.Now()
-	results := make(chan error, numPayments)
-
-	for i, td := range testData {
-		go func(id int, membershipData TestMembershipData) {
-			// Create and capture order
-			order, err := mockPayPal.CreateOrder(membershipData.FormID, "100.00")
-			if err != nil {
-				results <- fmt.Errorf("order creation %d: %w", id, err)
-				return
-			}
-
-			if err := mockPayPal.CaptureOrder(order.ID); err != nil {
-				results <- fmt.Errorf("order capture %d: %w", id, err)
-				return
-			}
-
-			// Update database with PayPal order
-			now := time.Now()
-			if err := data.UpdateMembershipPayPalOrder(membershipData.FormID, order.ID, &now); err != nil {
-				results <- fmt.Errorf("db update %d: %w", id, err)
-				return
-			}
-
-			// Update with capture details
-			captureDetails := fmt.Sprintf(`{"id":"%s","status":"COMPLETED"}`, order.ID)
-			if err := data.UpdateMembershipPayPalCapture(membershipData.FormID, captureDetails, "COMPLETED", &now); err != nil {
-				results <- fmt.Errorf("capture update %d: %w", id, err)
-				return
-			}
-
-			results <- nil
-		}(i, td)
-	}
-
-	// Collect results
-	var errors []error
-	for i := 0; i < numPayments; i++ {
-		if err := <-results; err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	duration := time.Since(start)
-	throughput := float64(numPayments) / duration.Seconds()
-	successRate := float64(numPayments-len(errors)) / float64(numPayments) * 100
-
-	t.Logf("Payment processing: %d total, %d errors, %.1f%% success rate, %.1f payments/sec",
-		numPayments, len(errors), successRate, throughput)
-
-	if successRate < 95.0 {
-		t.Errorf("Payment success rate too low: %.1f%%", successRate)
-	}
-
-	if throughput < 10.0 {
-		t.Errorf("Throughput too low: %.1f payments/sec", throughput)
-	}
-}
-
-func testDatabaseStress(t *testing.T, suite *TestSuite, numOperations int) {
-	start := time.Now()
-
-	results := make(chan error, numOperations)
-
-	// Mix of different database operations
-	for i := 0; i < numOperations; i++ {
-		go func(id int) {
-			switch id % 4 {
-			case 0: // Insert membership
-				testData := suite.GenerateTestMembership()
-				testData.Email = fmt.Sprintf("stress%d@test.com", id)
-				submission := testData.ToMembershipSubmission()
-				results <- data.InsertMembership(submission)
-
-			case 1: // Insert event
-				testData := suite.GenerateTestEvent()
-				testData.Email = fmt.Sprintf("stress%d@test.com", id)
-				submission := testData.ToEventSubmission()
-				results <- data.InsertEvent(submission)
-
-			case 2: // Insert fundraiser
-				testData := suite.GenerateTestFundraiser()
-				testData.Email = fmt.Sprintf("stress%d@test.com", id)
-				submission := testData.ToFundraiserSubmission()
-				results <- data.InsertFundraiser(submission)
-
-			case 3: // Query operations
-				_, err := data.GetMembershipsByYear(time.Now().Year())
-				results <- err
-			}
-		}(i)
-	}
-
-	// Collect results
-	var errors []error
-	for i := 0; i < numOperations; i++ {
-		if err := <-results; err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	duration := time.Since(start)
-	opsPerSecond := float64(numOperations) / duration.Seconds()
-	successRate := float64(numOperations-len(errors)) / float64(numOperations) * 100
-
-	t.Logf("Database stress test: %d operations, %d errors, %.1f%% success rate, %.1f ops/sec",
-		numOperations, len(errors), successRate, opsPerSecond)
-
-	if successRate < 99.0 {
-		t.Errorf("Database success rate too low: %.1f%%", successRate)
-	}
-}
-
 // Benchmark tests
 func BenchmarkMembershipInsert(b *testing.B) {
 	suite := NewTestSuite(&testing.T{})
@@ -514,7 +322,7 @@ func BenchmarkInventoryCalculation(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		_, err := suite.Inventory.CalculateMembershipTotal(membership, addons, fees, donation, true)
+		_, _, err := suite.Inventory.CalculateMembershipTotal(membership, addons, fees, donation, true, "")
 		if err != nil {
 			b.Fatal(err)
 		}
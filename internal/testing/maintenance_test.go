@@ -0,0 +1,83 @@
+package testing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/security"
+)
+
+func TestMaintenanceModeBlocksPublicEndpoints(t *testing.T) {
+	h := NewHarness(t)
+	t.Cleanup(func() { middleware.SetMaintenance(false) })
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+
+	do := func(method, path string) (*http.Response, string) {
+		req, err := http.NewRequest(method, h.Server.URL+path, nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", adminToken)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		h.AssertNoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := do(http.MethodPost, "/api/admin/maintenance?enabled=true")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected maintenance to be switched on, got %d", resp.StatusCode)
+	}
+
+	resp, body := do(http.MethodGet, "/events/some-order.html")
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "Down for Maintenance") {
+		t.Errorf("expected the maintenance page, got %d: %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "300" {
+		t.Errorf("expected Retry-After 300, got %q", got)
+	}
+
+	resp, body = do(http.MethodGet, "/api/csrf-token")
+	var apiErr middleware.APIError
+	if resp.StatusCode != http.StatusServiceUnavailable || json.Unmarshal([]byte(body), &apiErr) != nil || apiErr.Code != "maintenance" {
+		t.Errorf("expected a JSON maintenance error from the API, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Health checks, the stylesheet and the admin API stay up
+	for _, path := range []string{"/healthz", "/static/css/simple.css", "/api/admin/maintenance"} {
+		if resp, _ := do(http.MethodGet, path); resp.StatusCode != http.StatusOK {
+			t.Errorf("expected %s to stay available, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, _ = do(http.MethodPost, "/api/admin/maintenance?enabled=false")
+	if resp.StatusCode != http.StatusOK || middleware.Maintenance().Enabled {
+		t.Fatalf("expected maintenance to be switched off, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodGet, "/api/csrf-token"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the API back after maintenance, got %d", resp.StatusCode)
+	}
+}
+
+func TestMaintenanceToggleRequiresAdminToken(t *testing.T) {
+	h := NewHarness(t)
+	t.Cleanup(func() { middleware.SetMaintenance(false) })
+
+	resp, err := h.Client.Post(h.Server.URL+"/api/admin/maintenance?enabled=true", "", nil)
+	h.AssertNoError(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 without an admin token, got %d", resp.StatusCode)
+	}
+	if middleware.Maintenance().Enabled {
+		t.Error("maintenance mode was switched on without an admin token")
+	}
+}
@@ -9,35 +9,94 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"sbcbackend/internal/paypal"
 )
 
 // MockPayPalService provides a mock PayPal API for testing
 type MockPayPalService struct {
 	Server          *httptest.Server
 	Orders          map[string]*MockOrder
+	Subscriptions   map[string]*MockSubscription
+	Transactions    []MockTransaction // served by the transaction search report
+	Refunds         []MockRefund
 	AccessTokens    map[string]*MockAccessToken
 	WebhookEndpoint string
 	mu              sync.RWMutex
 
+	// Webhook signature verification: signatures equal to ValidWebhookSignature verify,
+	// and each verification request is kept for inspection
+	ValidWebhookSignature string
+	Verifications         []map[string]interface{}
+
+	// PayPal-Request-Id idempotency: an order create repeating a request ID returns the
+	// order first created under it. Every request ID received is kept for inspection.
+	RequestIDs        []string
+	ordersByRequestID map[string]string
+
 	// Configuration for failure simulation
 	ShouldFailAuth        bool
 	ShouldFailOrderCreate bool
 	ShouldFailCapture     bool
+	ShouldFailRefund      bool
 	SimulateNetworkDelay  time.Duration
 
+	// OnCapture runs while a capture request is in flight, before PayPal answers it,
+	// to let a test change things underneath the caller
+	OnCapture func(orderID string)
+
+	// RequirePayerAction sends buyers confirming a card or wallet through 3-D Secure
+	RequirePayerAction bool
+
 	// Counters for tracking
 	AuthAttempts    int
 	OrderAttempts   int
 	CaptureAttempts int
 }
 
-type MockOrder struct {
+// MockSubscription is a billing subscription; it waits for approval until the test
+// approves it as the member would on PayPal's site
+type MockSubscription struct {
 	ID       string
 	Status   string
-	Amount   string
-	FormID   string
-	Created  time.Time
-	Captured *time.Time
+	PlanID   string
+	FormID   string // custom_id
+	Amount   string // the plan price override
+	Approved *time.Time
+}
+
+type MockOrder struct {
+	ID            string
+	Status        string
+	Amount        string
+	Currency      string // currency_code of the amount
+	FormID        string
+	FundingSource string          // the payment_source it was created with, paypal if none
+	PaymentSource json.RawMessage // details of a payment source confirmed for it
+	Items         []paypal.Item
+	Discount      string // breakdown discount, when the order is itemized
+	Created       time.Time
+	Captured      *time.Time
+}
+
+// MockTransaction is one entry of the transaction search report; refunds have a
+// negative amount
+type MockTransaction struct {
+	ID          string
+	EventCode   string
+	Status      string
+	InvoiceID   string
+	ReferenceID string
+	Amount      float64
+	Date        time.Time
+}
+
+// MockRefund is a refund made against a capture
+type MockRefund struct {
+	ID        string
+	CaptureID string
+	Amount    string
+	Currency  string
 }
 
 type MockAccessToken struct {
@@ -48,8 +107,11 @@ type MockAccessToken struct {
 // NewMockPayPalService creates a new mock PayPal service
 func NewMockPayPalService() *MockPayPalService {
 	mock := &MockPayPalService{
-		Orders:       make(map[string]*MockOrder),
-		AccessTokens: make(map[string]*MockAccessToken),
+		Orders:        make(map[string]*MockOrder),
+		Subscriptions: make(map[string]*MockSubscription),
+		AccessTokens:  make(map[string]*MockAccessToken),
+
+		ordersByRequestID: make(map[string]string),
 	}
 
 	// Create HTTP server with PayPal API endpoints
@@ -64,6 +126,19 @@ func NewMockPayPalService() *MockPayPalService {
 	// Order details endpoint (dynamic route)
 	mux.HandleFunc("/v2/checkout/orders/", mock.handleOrderDetails)
 
+	// Billing subscriptions for auto-renewing memberships
+	mux.HandleFunc("/v1/billing/subscriptions", mock.handleCreateSubscription)
+	mux.HandleFunc("/v1/billing/subscriptions/", mock.handleGetSubscription)
+
+	// Webhook signature verification
+	mux.HandleFunc("/v1/notifications/verify-webhook-signature", mock.handleVerifyWebhookSignature)
+
+	// Refunds against captures
+	mux.HandleFunc("/v2/payments/captures/", mock.handleRefundCapture)
+
+	// Transaction search report
+	mux.HandleFunc("/v1/reporting/transactions", mock.handleTransactionSearch)
+
 	mock.Server = httptest.NewServer(mux)
 	return mock
 }
@@ -96,11 +171,12 @@ func (m *MockPayPalService) CreateOrder(formID, amount string) (*MockOrder, erro
 
 	orderID := fmt.Sprintf("MOCK-ORDER-%d", time.Now().UnixNano())
 	order := &MockOrder{
-		ID:      orderID,
-		Status:  "CREATED",
-		Amount:  amount,
-		FormID:  formID,
-		Created: time.Now(),
+		ID:       orderID,
+		Status:   "CREATED",
+		Amount:   amount,
+		Currency: "USD",
+		FormID:   formID,
+		Created:  time.Now(),
 	}
 
 	m.Orders[orderID] = order
@@ -186,6 +262,53 @@ func (m *MockPayPalService) handleToken(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
+// AddTransaction adds an entry to the transaction search report
+func (m *MockPayPalService) AddTransaction(transaction MockTransaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Transactions = append(m.Transactions, transaction)
+}
+
+func (m *MockPayPalService) handleTransactionSearch(w http.ResponseWriter, r *http.Request) {
+	const layout = "2006-01-02T15:04:05-0700"
+	start, errStart := time.Parse(layout, r.URL.Query().Get("start_date"))
+	end, errEnd := time.Parse(layout, r.URL.Query().Get("end_date"))
+	if errStart != nil || errEnd != nil || end.Sub(start) > 31*24*time.Hour {
+		http.Error(w, `{"name": "INVALID_REQUEST"}`, http.StatusBadRequest)
+		return
+	}
+
+	m.mu.RLock()
+	var details []map[string]interface{}
+	for _, t := range m.Transactions {
+		if t.Date.Before(start) || !t.Date.Before(end) {
+			continue
+		}
+		details = append(details, map[string]interface{}{
+			"transaction_info": map[string]interface{}{
+				"transaction_id":              t.ID,
+				"transaction_event_code":      t.EventCode,
+				"transaction_status":          t.Status,
+				"invoice_id":                  t.InvoiceID,
+				"paypal_reference_id":         t.ReferenceID,
+				"transaction_initiation_date": t.Date.Format(layout),
+				"transaction_amount": map[string]interface{}{
+					"currency_code": "USD",
+					"value":         fmt.Sprintf("%.2f", t.Amount),
+				},
+			},
+		})
+	}
+	m.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transaction_details": details,
+		"page":                1,
+		"total_pages":         1,
+	})
+}
+
 func (m *MockPayPalService) handleOrders(w http.ResponseWriter, r *http.Request) {
 	m.mu.Lock()
 	delay := m.SimulateNetworkDelay
@@ -204,11 +327,23 @@ func (m *MockPayPalService) handleOrders(w http.ResponseWriter, r *http.Request)
 }
 
 func (m *MockPayPalService) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("PayPal-Request-Id")
 	m.mu.Lock()
 	m.OrderAttempts++
 	shouldFail := m.ShouldFailOrderCreate
+	if requestID != "" {
+		m.RequestIDs = append(m.RequestIDs, requestID)
+	}
+	repeated, isRepeat := m.Orders[m.ordersByRequestID[requestID]]
 	m.mu.Unlock()
 
+	if isRepeat && requestID != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": repeated.ID, "status": repeated.Status})
+		return
+	}
+
 	if shouldFail {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -239,14 +374,53 @@ func (m *MockPayPalService) handleCreateOrder(w http.ResponseWriter, r *http.Req
 
 	amount, _ := unit["amount"].(map[string]interface{})
 	value, _ := amount["value"].(string)
+	currencyCode, _ := amount["currency_code"].(string)
 	formID, _ := unit["invoice_id"].(string)
 
+	// Like PayPal, turn down items that don't add up to the amount
+	var items []paypal.Item
+	var breakdown paypal.AmountBreakdown
+	if _, ok := unit["items"]; ok {
+		var typed paypal.PurchaseUnit
+		encoded, _ := json.Marshal(unit)
+		json.Unmarshal(encoded, &typed)
+		items = typed.Items
+		if typed.Amount != nil && typed.Amount.Breakdown != nil {
+			breakdown = *typed.Amount.Breakdown
+		}
+		if err := typed.CheckBreakdown(); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "UNPROCESSABLE_ENTITY", "message": err.Error()})
+			return
+		}
+	}
+
 	// Create mock order
 	order, err := m.CreateOrder(formID, value)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	fundingSource := "paypal"
+	if sources, ok := orderRequest["payment_source"].(map[string]interface{}); ok {
+		for source := range sources {
+			fundingSource = source
+		}
+	}
+	m.mu.Lock()
+	order.FundingSource = fundingSource
+	order.Items = items
+	if breakdown.Discount != nil {
+		order.Discount = breakdown.Discount.Value
+	}
+	if currencyCode != "" {
+		order.Currency = currencyCode
+	}
+	if requestID != "" {
+		m.ordersByRequestID[requestID] = order.ID
+	}
+	m.mu.Unlock()
 
 	// Return PayPal-like response
 	response := map[string]interface{}{
@@ -278,6 +452,8 @@ func (m *MockPayPalService) handleOrderDetails(w http.ResponseWriter, r *http.Re
 	case "POST":
 		if len(pathParts) > 1 && pathParts[1] == "capture" {
 			m.handleCaptureOrder(w, r, orderID)
+		} else if len(pathParts) > 1 && pathParts[1] == "confirm-payment-source" {
+			m.handleConfirmPaymentSource(w, r, orderID)
 		} else {
 			http.Error(w, "Invalid endpoint", http.StatusNotFound)
 		}
@@ -300,7 +476,7 @@ func (m *MockPayPalService) handleGetOrder(w http.ResponseWriter, r *http.Reques
 			{
 				"invoice_id": order.FormID,
 				"amount": map[string]interface{}{
-					"currency_code": "USD",
+					"currency_code": order.Currency,
 					"value":         order.Amount,
 				},
 			},
@@ -311,16 +487,72 @@ func (m *MockPayPalService) handleGetOrder(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(response)
 }
 
+// mockCard is the card every confirmed payment source reports, PayPal's test Visa
+const mockCard = `{"name":"Test Buyer","last_digits":"1111","brand":"VISA","type":"CREDIT"}`
+
+// handleConfirmPaymentSource attaches the card or wallet a buyer chose to an order,
+// approving it unless RequirePayerAction asks for 3-D Secure first
+func (m *MockPayPalService) handleConfirmPaymentSource(w http.ResponseWriter, r *http.Request, orderID string) {
+	var request paypal.ConfirmPaymentSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.PaymentSource) != 1 {
+		http.Error(w, "Invalid payment source", http.StatusBadRequest)
+		return
+	}
+	var source string
+	for source = range request.PaymentSource {
+	}
+	details := json.RawMessage(mockCard)
+	if source != "card" {
+		details = json.RawMessage(`{"card":` + mockCard + `}`)
+	}
+
+	m.mu.Lock()
+	order, exists := m.Orders[orderID]
+	if !exists {
+		m.mu.Unlock()
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	order.FundingSource = source
+	order.PaymentSource = details
+	order.Status = "APPROVED"
+	links := []map[string]string{}
+	if m.RequirePayerAction {
+		order.Status = "PAYER_ACTION_REQUIRED"
+		links = append(links, map[string]string{
+			"href": fmt.Sprintf("https://www.sandbox.paypal.com/webapps/helios?action=verify&flow=3ds&cart_id=%s", order.ID),
+			"rel":  "payer-action",
+		})
+	}
+	status := order.Status
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":             orderID,
+		"status":         status,
+		"payment_source": map[string]interface{}{source: details},
+		"links":          links,
+	})
+}
+
 func (m *MockPayPalService) handleCaptureOrder(w http.ResponseWriter, r *http.Request, orderID string) {
 	m.mu.Lock()
 	m.CaptureAttempts++
+	if requestID := r.Header.Get("PayPal-Request-Id"); requestID != "" {
+		m.RequestIDs = append(m.RequestIDs, requestID)
+	}
 	shouldFail := m.ShouldFailCapture
 	delay := m.SimulateNetworkDelay
+	onCapture := m.OnCapture
 	m.mu.Unlock()
 
 	if delay > 0 {
 		time.Sleep(delay)
 	}
+	if onCapture != nil {
+		onCapture(orderID)
+	}
 
 	if shouldFail {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -337,10 +569,21 @@ func (m *MockPayPalService) handleCaptureOrder(w http.ResponseWriter, r *http.Re
 	}
 
 	order, _ := m.GetOrder(orderID)
+	fundingSource := order.FundingSource
+	if fundingSource == "" {
+		fundingSource = "paypal"
+	}
+	paymentSource := json.RawMessage(`{}`)
+	if order.PaymentSource != nil {
+		paymentSource = order.PaymentSource
+	}
 
 	response := map[string]interface{}{
 		"id":     order.ID,
 		"status": "COMPLETED",
+		"payment_source": map[string]interface{}{
+			fundingSource: paymentSource,
+		},
 		"purchase_units": []map[string]interface{}{
 			{
 				"payments": map[string]interface{}{
@@ -349,7 +592,7 @@ func (m *MockPayPalService) handleCaptureOrder(w http.ResponseWriter, r *http.Re
 							"id":     fmt.Sprintf("CAPTURE-%s", order.ID),
 							"status": "COMPLETED",
 							"amount": map[string]interface{}{
-								"currency_code": "USD",
+								"currency_code": order.Currency,
 								"value":         order.Amount,
 							},
 						},
@@ -364,6 +607,50 @@ func (m *MockPayPalService) handleCaptureOrder(w http.ResponseWriter, r *http.Re
 	json.NewEncoder(w).Encode(response)
 }
 
+func (m *MockPayPalService) handleRefundCapture(w http.ResponseWriter, r *http.Request) {
+	captureID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/payments/captures/"), "/")
+	if r.Method != http.MethodPost || action != "refund" {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
+	var request paypal.RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Amount == nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	if m.ShouldFailRefund {
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "UNPROCESSABLE_ENTITY", "message": "Refund failed"})
+		return
+	}
+	refund := MockRefund{
+		ID:        fmt.Sprintf("MOCK-REFUND-%d", len(m.Refunds)+1),
+		CaptureID: captureID,
+		Amount:    request.Amount.Value,
+		Currency:  request.Amount.CurrencyCode,
+	}
+	m.Refunds = append(m.Refunds, refund)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     refund.ID,
+		"status": "COMPLETED",
+		"amount": request.Amount,
+	})
+}
+
+// GetRefunds returns the refunds made so far
+func (m *MockPayPalService) GetRefunds() []MockRefund {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]MockRefund(nil), m.Refunds...)
+}
+
 // Test Utilities
 
 // SetFailureMode configures the mock to simulate various failure scenarios
@@ -426,12 +713,136 @@ func (m *MockPayPalService) Reset() {
 	defer m.mu.Unlock()
 
 	m.Orders = make(map[string]*MockOrder)
+	m.Subscriptions = make(map[string]*MockSubscription)
+	m.Transactions = nil
+	m.Refunds = nil
+	m.Verifications = nil
+	m.RequestIDs = nil
+	m.ordersByRequestID = make(map[string]string)
 	m.AccessTokens = make(map[string]*MockAccessToken)
 	m.ShouldFailAuth = false
 	m.ShouldFailOrderCreate = false
 	m.ShouldFailCapture = false
+	m.ShouldFailRefund = false
 	m.SimulateNetworkDelay = 0
 	m.AuthAttempts = 0
 	m.OrderAttempts = 0
 	m.CaptureAttempts = 0
 }
+
+// ApproveSubscription activates a subscription as the member approving it would
+func (m *MockPayPalService) ApproveSubscription(subscriptionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subscription, ok := m.Subscriptions[subscriptionID]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	subscription.Status = "ACTIVE"
+	subscription.Approved = &now
+	return true
+}
+
+// GetSubscription returns a copy of a subscription
+func (m *MockPayPalService) GetSubscription(subscriptionID string) (*MockSubscription, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	subscription, ok := m.Subscriptions[subscriptionID]
+	if !ok {
+		return nil, false
+	}
+	copy := *subscription
+	return &copy, true
+}
+
+func (m *MockPayPalService) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		PlanID   string `json:"plan_id"`
+		CustomID string `json:"custom_id"`
+		Plan     struct {
+			BillingCycles []struct {
+				PricingScheme struct {
+					FixedPrice struct {
+						Value string `json:"value"`
+					} `json:"fixed_price"`
+				} `json:"pricing_scheme"`
+			} `json:"billing_cycles"`
+		} `json:"plan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.PlanID == "" {
+		http.Error(w, "Invalid subscription request", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	subscription := &MockSubscription{
+		ID:     fmt.Sprintf("I-MOCK%08d", len(m.Subscriptions)+1),
+		Status: "APPROVAL_PENDING",
+		PlanID: request.PlanID,
+		FormID: request.CustomID,
+	}
+	if len(request.Plan.BillingCycles) > 0 {
+		subscription.Amount = request.Plan.BillingCycles[0].PricingScheme.FixedPrice.Value
+	}
+	m.Subscriptions[subscription.ID] = subscription
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(m.subscriptionResponse(subscription))
+}
+
+func (m *MockPayPalService) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := strings.TrimPrefix(r.URL.Path, "/v1/billing/subscriptions/")
+	subscription, ok := m.GetSubscription(subscriptionID)
+	if !ok {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.subscriptionResponse(subscription))
+}
+
+func (m *MockPayPalService) subscriptionResponse(subscription *MockSubscription) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        subscription.ID,
+		"status":    subscription.Status,
+		"plan_id":   subscription.PlanID,
+		"custom_id": subscription.FormID,
+		"links": []map[string]interface{}{
+			{
+				"href":   "https://www.sandbox.paypal.com/webapps/billing/subscriptions?ba_token=BA-" + subscription.ID,
+				"rel":    "approve",
+				"method": "GET",
+			},
+		},
+	}
+}
+
+func (m *MockPayPalService) handleVerifyWebhookSignature(w http.ResponseWriter, r *http.Request) {
+	var request map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	m.Verifications = append(m.Verifications, request)
+	valid := m.ValidWebhookSignature != "" && request["transmission_sig"] == m.ValidWebhookSignature
+	m.mu.Unlock()
+
+	status := "FAILURE"
+	if valid {
+		status = "SUCCESS"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"verification_status": status})
+}
@@ -0,0 +1,75 @@
+package testing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/preferences"
+)
+
+func TestNotificationRouter(t *testing.T) {
+	h := NewHarness(t)
+
+	var captured []string
+	capture := func(ctx context.Context, to notification.Recipient, msg notification.Message) error {
+		captured = append(captured, to.Channel+" "+to.Address+": "+msg.Body)
+		return nil
+	}
+	for _, channel := range []string{notification.ChannelChat, notification.ChannelPush} {
+		previous := notification.SetBackend(channel, capture)
+		t.Cleanup(func() { notification.SetBackend(channel, previous) })
+	}
+
+	greeting := notification.Template{
+		Name: "greeting",
+		Channels: map[string]notification.RenderFunc{
+			notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+				return notification.Message{Subject: "Hello", Body: "Hello " + d.(string)}, nil
+			},
+			notification.ChannelChat: func(d interface{}) (notification.Message, error) {
+				return notification.Message{Body: "hi " + d.(string)}, nil
+			},
+		},
+	}
+
+	const optedOut, optedIn = "quiet@example.com", "loud@example.com"
+	h.AssertNoError(t, preferences.Set(optedOut, preferences.Marketing, "none"))
+	h.AssertNoError(t, preferences.Set(optedIn, preferences.Marketing, "email"))
+
+	err := notification.Send(context.Background(), notification.Notification{
+		Template: greeting,
+		Data:     "there",
+		Category: preferences.Marketing,
+		Recipients: []notification.Recipient{
+			notification.Payer(optedOut),
+			notification.Payer(optedIn),
+			notification.Staff(),
+			notification.Chat("payment.completed"),
+			notification.Push("payment.completed"),
+			notification.PayerSMS("+15125550100", optedIn),
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "no sms rendering") {
+		t.Errorf("expected the SMS recipient to fail for a template without SMS, got %v", err)
+	}
+
+	config := email.LoadEmailConfig()
+	if sent := h.Mailer.SentTo(optedOut); len(sent) != 0 {
+		t.Errorf("expected no email to a contact who opted out, got %d", len(sent))
+	}
+	if sent := h.Mailer.SentTo(optedIn); len(sent) != 1 || sent[0].From != config.ConfirmationSender || sent[0].Body != "Hello there" {
+		t.Errorf("expected one email from the confirmation sender, got %+v", sent)
+	}
+	if sent := h.Mailer.SentTo(config.AlertRecipient); len(sent) != 1 || sent[0].From != config.AlertSender {
+		t.Errorf("expected one staff email from the alert sender, got %+v", sent)
+	}
+
+	// Push has no rendering of its own, so it uses the chat one
+	want := []string{"chat payment.completed: hi there", "push payment.completed: hi there"}
+	if strings.Join(captured, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, captured)
+	}
+}
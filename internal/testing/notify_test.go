@@ -0,0 +1,144 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/notify"
+)
+
+// chatRecorder is a stand-in Slack incoming webhook
+type chatRecorder struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func newChatRecorder(t *testing.T) (*chatRecorder, *httptest.Server) {
+	recorder := &chatRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		recorder.mu.Lock()
+		recorder.messages = append(recorder.messages, body["text"])
+		recorder.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return recorder, server
+}
+
+// waitFor returns the messages once there are at least n, or whatever arrived in time
+func (c *chatRecorder) waitFor(n int) []string {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mu.Lock()
+		messages := append([]string(nil), c.messages...)
+		c.mu.Unlock()
+		if len(messages) >= n || time.Now().After(deadline) {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChatNotifications(t *testing.T) {
+	payments, paymentsServer := newChatRecorder(t)
+	alerts, alertsServer := newChatRecorder(t)
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("CHAT_WEBHOOK_URL_DEV", alertsServer.URL)
+	t.Setenv("CHAT_WEBHOOK_URL_PAYMENT_COMPLETED_DEV", paymentsServer.URL)
+	t.Setenv("CHAT_WEBHOOK_URL_PAYMENT_REFUNDED_DEV", "off")
+	t.Setenv("CHAT_ERROR_RATE_THRESHOLD_DEV", "3")
+	h := NewHarness(t)
+
+	if config.ChatWebhookURL(notify.EventPaymentRefunded) != "" {
+		t.Errorf("expected refund notifications to be switched off")
+	}
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, notify.PaymentCompletedTask(context.Background(), data.OutboxTask{FormID: "membership-seed-001"}))
+	got := payments.waitFor(1)
+	if len(got) != 1 || !strings.Contains(got[0], "$75.00 for Basic Membership from Jane Smith") {
+		t.Errorf("expected the payment in the payments channel, got %q", got)
+	}
+
+	// Two server errors stay quiet; the third within the window alerts the default channel
+	monitor := notify.NewErrorRateMonitor()
+	failing := monitor.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	for i := 0; i < 3; i++ {
+		failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/capture-order", nil))
+		if i == 1 && len(alerts.waitFor(0)) != 0 {
+			t.Fatalf("expected no alert below the threshold")
+		}
+	}
+	got = alerts.waitFor(1)
+	if len(got) != 1 || !strings.Contains(got[0], "3 server errors") || !strings.Contains(got[0], "/api/capture-order") {
+		t.Errorf("expected one error-rate alert, got %q", got)
+	}
+	if len(payments.waitFor(2)) != 1 {
+		t.Errorf("expected the alert not to reach the payments channel")
+	}
+}
+
+func TestPushNotifications(t *testing.T) {
+	type pushed struct{ title, priority, auth, body string }
+	var mu sync.Mutex
+	var pushes []pushed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pushes = append(pushes, pushed{r.Header.Get("Title"), r.Header.Get("Priority"), r.Header.Get("Authorization"), string(body)})
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	received := func(n int) []pushed {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			mu.Lock()
+			got := append([]pushed(nil), pushes...)
+			mu.Unlock()
+			if len(got) >= n || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("PUSH_NTFY_URL_DEV", server.URL+"/booster-admins")
+	t.Setenv("PUSH_NTFY_TOKEN_DEV", "tk_test")
+	previous := config.UseMockWebhookVerification
+	config.UseMockWebhookVerification = true
+	t.Cleanup(func() { config.UseMockWebhookVerification = previous })
+	h := NewHarness(t)
+	seedCorpusSubmission(t, h, "membership", "COMPLETED")
+
+	// Payments aren't pushed by default; a dispute is
+	notify.Notify(notify.EventPaymentCompleted, "Payment received")
+	if code := postWebhook(t, h, "dispute_created.json"); code != http.StatusOK {
+		t.Fatalf("expected the dispute webhook to be accepted, got %d", code)
+	}
+
+	got := received(2)
+	if len(got) != 1 {
+		t.Fatalf("expected one push, got %+v", got)
+	}
+	if got[0].title != "PayPal dispute opened" || got[0].priority != "high" || got[0].auth != "Bearer tk_test" {
+		t.Errorf("unexpected push headers: %+v", got[0])
+	}
+	if !strings.Contains(got[0].body, corpusMembershipID) || !strings.Contains(got[0].body, "PP-D-4012") {
+		t.Errorf("expected the push to name the submission and case, got %q", got[0].body)
+	}
+}
@@ -0,0 +1,97 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/security"
+)
+
+func TestOfflinePayment(t *testing.T) {
+	h := NewHarness(t)
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	record := func(req payment.OfflinePaymentRequest) (int, payment.OfflinePaymentResponse) {
+		t.Helper()
+		raw, _ := json.Marshal(req)
+		httpReq, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/admin/offline-payment", bytes.NewReader(raw))
+		h.AssertNoError(t, err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("X-Admin-Token", adminToken)
+		httpReq.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(httpReq)
+		h.AssertNoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var recorded struct {
+			Data payment.OfflinePaymentResponse `json:"data"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			h.AssertNoError(t, json.Unmarshal(body, &recorded))
+		}
+		return resp.StatusCode, recorded.Data
+	}
+
+	member := h.GenerateTestMembership().ToMembershipSubmission()
+	member.Email, member.CalculatedAmount = "check.payer@example.com", 50
+	h.AssertNoError(t, data.InsertMembership(member))
+
+	for name, req := range map[string]payment.OfflinePaymentRequest{
+		"NoCheckNumber": {FormID: member.FormID, Method: "check"},
+		"UnknownMethod": {FormID: member.FormID, Method: "barter"},
+		"BadDate":       {FormID: member.FormID, Method: "cash", ReceivedDate: "last Tuesday"},
+		"FutureDate":    {FormID: member.FormID, Method: "cash", ReceivedDate: clock.Now().AddDate(0, 0, 2).Format("2006-01-02")},
+	} {
+		if status, _ := record(req); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, status)
+		}
+	}
+
+	status, recorded := record(payment.OfflinePaymentRequest{
+		FormID: member.FormID, Method: "Check", CheckNumber: " 1042 ", ReceivedDate: "2025-09-03",
+	})
+	if status != http.StatusOK || recorded.Method != payment.FundingCheck || recorded.Amount != 50 || recorded.ReceiptNumber == "" {
+		t.Fatalf("expected the check recorded with a receipt, got %d %+v", status, recorded)
+	}
+
+	summary, err := data.GetSubmissionSummary(member.FormID)
+	h.AssertNoError(t, err)
+	if !summary.Submitted || summary.PayPalStatus != "COMPLETED" || summary.FundingSource != payment.FundingCheck {
+		t.Errorf("expected the form paid by check, got %+v", summary)
+	}
+	if summary.SubmittedAt == nil || summary.SubmittedAt.In(clock.Location()).Format("2006-01-02") != "2025-09-03" {
+		t.Errorf("expected the payment dated when the check came in, got %v", summary.SubmittedAt)
+	}
+	paid, err := data.ListSubmissions(data.SubmissionFilter{Status: "paid", Funding: payment.FundingCheck})
+	h.AssertNoError(t, err)
+	if len(paid) != 1 || paid[0].FormID != member.FormID {
+		t.Errorf("expected the form among paid submissions, got %+v", paid)
+	}
+
+	// The family hears about it as if they had paid online
+	worker := outbox.NewWorker()
+	worker.Handle(outbox.KindConfirmationEmail, order.ConfirmationEmailTask)
+	worker.Handle(outbox.KindAdminNotification, order.AdminNotificationTask)
+	h.AssertNoError(t, worker.Run(context.Background()))
+	if sent := h.Mailer.SentTo(member.Email); len(sent) != 1 {
+		t.Errorf("expected 1 confirmation email, got %d", len(sent))
+	}
+
+	if status, _ := record(payment.OfflinePaymentRequest{FormID: member.FormID, Method: "cash"}); status != http.StatusConflict {
+		t.Errorf("expected 409 paying twice, got %d", status)
+	}
+	if status, _ := record(payment.OfflinePaymentRequest{FormID: "membership-missing", Method: "cash"}); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown form, got %d", status)
+	}
+}
@@ -0,0 +1,91 @@
+package testing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/paypal"
+)
+
+// TestOrderPageSweep runs the order-pages cleanup over pages written for registrations
+// in every state: pages of refunded, cancelled and deleted orders are archived, and
+// those of orders paid or still being paid stay
+func TestOrderPageSweep(t *testing.T) {
+	h := NewHarness(t)
+	pages, archive := t.TempDir(), t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+
+	// writePage writes a page and its print variant as the order page generator would
+	writePage := func(name string, modTime time.Time) {
+		t.Helper()
+		for _, file := range []string{name + ".html", name + ".print.html"} {
+			path := filepath.Join(pages, "2026", "spring-festival", file)
+			h.AssertNoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			h.AssertNoError(t, os.WriteFile(path, []byte("<html></html>"), 0644))
+			h.AssertNoError(t, os.Chtimes(path, modTime, modTime))
+		}
+	}
+	statuses := map[string]string{
+		"completed":    "COMPLETED",
+		"pending":      data.PaymentPendingStatus,
+		"installments": data.InstallmentsStatus,
+		"disputed":     data.DisputedStatus,
+		"refunded":     "REFUNDED",
+		"cancelled":    paypal.StatusVoided,
+	}
+	for name, status := range statuses {
+		event := h.GenerateTestEvent().ToEventSubmission()
+		event.Submitted = status == "COMPLETED"
+		event.PayPalStatus = status
+		event.OrderPageURL = "/events/2026/spring-festival/" + name + ".html"
+		h.AssertNoError(t, data.InsertEvent(event))
+		writePage(name, old)
+	}
+	writePage("deleted", old)      // its registration is gone
+	writePage("fresh", time.Now()) // orphaned, but within the retention
+
+	sweep := cleanup.NewJob(map[string]cleanup.Target{
+		cleanup.TargetOrderPages: {
+			Name: cleanup.TargetOrderPages, Enabled: true, Retention: 24 * time.Hour,
+			Directory: pages, ArchiveTo: archive,
+		},
+	}, cleanup.TargetOrderPages)
+	h.AssertNoError(t, sweep(context.Background()))
+
+	exists := func(dir, file string) bool {
+		_, err := os.Stat(filepath.Join(dir, "2026", "spring-festival", file))
+		return err == nil
+	}
+	for _, name := range []string{"completed", "pending", "installments", "disputed", "fresh"} {
+		for _, file := range []string{name + ".html", name + ".print.html"} {
+			if !exists(pages, file) || exists(archive, file) {
+				t.Errorf("expected %s kept", file)
+			}
+		}
+	}
+	for _, name := range []string{"refunded", "cancelled", "deleted"} {
+		for _, file := range []string{name + ".html", name + ".print.html"} {
+			if exists(pages, file) || !exists(archive, file) {
+				t.Errorf("expected %s archived", file)
+			}
+		}
+	}
+
+	// With archiving off, a page is removed outright
+	writePage("removed", old)
+	sweep = cleanup.NewJob(map[string]cleanup.Target{
+		cleanup.TargetOrderPages: {Name: cleanup.TargetOrderPages, Enabled: true, Retention: 24 * time.Hour, Directory: pages},
+	}, cleanup.TargetOrderPages)
+	h.AssertNoError(t, sweep(context.Background()))
+	if exists(pages, "removed.html") || exists(archive, "removed.html") {
+		t.Error("expected the orphaned page deleted when archiving is off")
+	}
+	if !exists(pages, "completed.html") {
+		t.Error("expected the completed order's page kept when archiving is off")
+	}
+}
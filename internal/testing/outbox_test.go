@@ -0,0 +1,398 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/payment"
+)
+
+func TestOutboxRecordsCaptureWithTasks(t *testing.T) {
+	suite := NewTestSuite(t)
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	now := time.Now()
+	tasks := []data.OutboxTask{{Kind: outbox.KindConfirmationEmail}, {Kind: outbox.KindAdminNotification}}
+	suite.AssertNoError(t, data.RecordPayPalCapture("membership", submission.FormID, `{"status":"COMPLETED"}`, "COMPLETED", &now, tasks, data.ActorSystem))
+
+	// Recording the same capture again (e.g. from recovery) must not queue duplicates
+	suite.AssertNoError(t, data.RecordPayPalCapture("membership", submission.FormID, `{"status":"COMPLETED"}`, "COMPLETED", &now, tasks, data.ActorSystem))
+
+	retrieved, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if retrieved.PayPalStatus != "COMPLETED" {
+		t.Errorf("Expected COMPLETED status, got %s", retrieved.PayPalStatus)
+	}
+
+	claimed, err := data.ClaimOutboxTasks(time.Now(), 100)
+	suite.AssertNoError(t, err)
+	if queued := tasksForForm(claimed, submission.FormID); len(queued) != 2 {
+		t.Errorf("Expected 2 queued tasks, got %d", len(queued))
+	}
+
+	// Unknown form types roll back without touching the outbox
+	if err := data.RecordPayPalCapture("unknown", "unknown-1", "{}", "COMPLETED", &now, tasks, data.ActorSystem); err == nil {
+		t.Error("Expected error for unknown form type")
+	}
+}
+
+func TestApplyCaptureIsAtomic(t *testing.T) {
+	suite := NewTestSuite(t)
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	suite.AssertNoError(t, data.InsertMembership(submission))
+	event := suite.GenerateTestEvent().ToEventSubmission()
+	suite.AssertNoError(t, data.InsertEvent(event))
+
+	// A flag the form type doesn't have fails the whole capture
+	now := time.Now()
+	tasks := []data.OutboxTask{{Kind: outbox.KindConfirmationEmail}}
+	if err := data.ApplyCapture("membership", submission.FormID, data.Capture{
+		Details: "{}", Status: "COMPLETED", SubmittedAt: &now, OrderPageURL: "/orders/x.html", Tasks: tasks,
+	}, data.ActorSystem); err == nil {
+		t.Error("Expected an order page on a membership to be refused")
+	}
+	if err := data.ApplyCapture("membership", "membership-missing", data.Capture{Status: "COMPLETED", SubmittedAt: &now}, data.ActorSystem); err == nil {
+		t.Error("Expected capturing a missing submission to fail")
+	}
+	retrieved, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if retrieved.PayPalStatus == "COMPLETED" || retrieved.ReceiptNumber != "" {
+		t.Errorf("Expected the refused capture to change nothing, got %s %q", retrieved.PayPalStatus, retrieved.ReceiptNumber)
+	}
+
+	// The capture and its flags land together
+	suite.AssertNoError(t, data.ApplyCapture("membership", submission.FormID, data.Capture{
+		Details: "{}", Status: "COMPLETED", SubmittedAt: &now, FundingSource: "check", ConfirmationEmailSent: true, Tasks: tasks,
+	}, data.ActorSystem))
+	retrieved, err = data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	emailsSent := func() (confirmation, adminNotification bool) {
+		t.Helper()
+		conn, err := data.GetDB()
+		suite.AssertNoError(t, err)
+		suite.AssertNoError(t, conn.QueryRow(`SELECT confirmation_email_sent, admin_notification_sent FROM membership_submissions WHERE form_id = ?`,
+			submission.FormID).Scan(&confirmation, &adminNotification))
+		return confirmation, adminNotification
+	}
+	if confirmation, adminNotification := emailsSent(); retrieved.PayPalStatus != "COMPLETED" || retrieved.ReceiptNumber == "" ||
+		!confirmation || adminNotification {
+		t.Errorf("Expected a receipted capture with only the confirmation marked sent, got %s %q %v %v",
+			retrieved.PayPalStatus, retrieved.ReceiptNumber, confirmation, adminNotification)
+	}
+	if source, err := data.GetFundingSource("membership", submission.FormID); err != nil || source != "check" {
+		t.Errorf("Expected funding source check, got %q: %v", source, err)
+	}
+
+	// Marking the admin notification keeps the confirmation marked
+	suite.AssertNoError(t, data.MarkEmailSent("membership", submission.FormID, false, true))
+	if confirmation, adminNotification := emailsSent(); !confirmation || !adminNotification {
+		t.Errorf("Expected both emails marked sent, got %v and %v", confirmation, adminNotification)
+	}
+
+	suite.AssertNoError(t, data.ApplyCapture("event", event.FormID, data.Capture{
+		Details: "{}", Status: "COMPLETED", SubmittedAt: &now, OrderPageURL: "/orders/test.html",
+	}, data.ActorSystem))
+	paidEvent, err := data.GetEventByID(event.FormID)
+	suite.AssertNoError(t, err)
+	if paidEvent.PayPalStatus != "COMPLETED" || paidEvent.OrderPageURL != "/orders/test.html" {
+		t.Errorf("Expected the event captured with its order page, got %s %q", paidEvent.PayPalStatus, paidEvent.OrderPageURL)
+	}
+}
+
+func TestStaleWritesConflictWithPaymentChanges(t *testing.T) {
+	suite := NewTestSuite(t)
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	suite.AssertNoError(t, data.InsertMembership(submission))
+	stale, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if stale.Version != 1 {
+		t.Fatalf("Expected a new membership at version 1, got %d", stale.Version)
+	}
+
+	// Changes outside the payment state don't move the version
+	suite.AssertNoError(t, data.SetCoupon("membership", submission.FormID, "SPRING", 5))
+	if version, err := data.GetSubmissionVersion(submission.FormID); err != nil || version != 1 {
+		t.Errorf("Expected a coupon to leave version 1, got %d: %v", version, err)
+	}
+
+	// The webhook records the payment while the capture handler waits on PayPal
+	applied, err := data.ApplyPayPalWebhook("membership", submission.FormID, "COMPLETED", `{"event_type":"PAYMENT.CAPTURE.COMPLETED"}`, 0, data.ActorWebhook)
+	suite.AssertNoError(t, err)
+	if version, _ := data.GetSubmissionVersion(submission.FormID); !applied || version != 2 {
+		t.Fatalf("Expected the webhook's payment at version 2, got %d", version)
+	}
+
+	now := time.Now()
+	err = data.ApplyCapture("membership", submission.FormID, data.Capture{
+		Details: `{"status":"COMPLETED"}`, Status: "COMPLETED", SubmittedAt: &now, Version: stale.Version,
+	}, data.ActorFamily)
+	if !errors.Is(err, data.ErrVersionConflict) {
+		t.Errorf("Expected the stale capture to conflict, got %v", err)
+	}
+
+	// Saving selections read before the payment can't mark it unpaid again
+	stale.Donation = 50
+	if err := data.UpdateMembershipPayment(*stale); !errors.Is(err, data.ErrVersionConflict) {
+		t.Errorf("Expected the stale selections to conflict, got %v", err)
+	}
+	paid, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if paid.PayPalStatus != "COMPLETED" || paid.Donation == 50 || paid.Version != 2 {
+		t.Errorf("Expected the webhook's payment kept, got %s donation %.2f version %d",
+			paid.PayPalStatus, paid.Donation, paid.Version)
+	}
+
+	// A writer that read the current version goes through
+	suite.AssertNoError(t, data.ApplyCapture("membership", submission.FormID, data.Capture{
+		Details: `{"status":"COMPLETED"}`, Status: "COMPLETED", SubmittedAt: &now, Version: paid.Version,
+	}, data.ActorFamily))
+
+	// Event registrations carry a version too
+	event := suite.GenerateTestEvent().ToEventSubmission()
+	suite.AssertNoError(t, data.InsertEvent(event))
+	staleEvent, err := data.GetEventByID(event.FormID)
+	suite.AssertNoError(t, err)
+	if staleEvent.Version != 1 {
+		t.Fatalf("Expected a new event at version 1, got %d", staleEvent.Version)
+	}
+	_, err = data.ApplyPayPalWebhook("event", event.FormID, "COMPLETED", `{"event_type":"PAYMENT.CAPTURE.COMPLETED"}`, 0, data.ActorWebhook)
+	suite.AssertNoError(t, err)
+	staleEvent.CalculatedAmount = 1
+	if err := data.UpdateEventPayment(*staleEvent); !errors.Is(err, data.ErrVersionConflict) {
+		t.Errorf("Expected the stale event selections to conflict, got %v", err)
+	}
+	paidEvent, err := data.GetEventByID(event.FormID)
+	suite.AssertNoError(t, err)
+	if paidEvent.CalculatedAmount == 1 || paidEvent.Version != 2 {
+		t.Errorf("Expected the event's payment kept at version 2, got %.2f version %d", paidEvent.CalculatedAmount, paidEvent.Version)
+	}
+	paidEvent.CalculatedAmount = 1
+	suite.AssertNoError(t, data.UpdateEventPayment(*paidEvent))
+
+	entries, err := data.ListAuditLog(submission.FormID)
+	suite.AssertNoError(t, err)
+	for _, entry := range entries {
+		if entry.Field == "version" {
+			t.Errorf("Expected versions kept out of the audit log, got %+v", entry)
+		}
+	}
+}
+
+func TestCaptureRecordedOverWebhookMidCapture(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := NewMockPayPalService()
+	defer mock.Close()
+	usePayPalMock(t, mock)
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	submission.CalculatedAmount = 40.00
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	body, _ := json.Marshal(map[string]string{"formID": submission.FormID})
+	req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec := httptest.NewRecorder()
+	middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+	var created struct {
+		Data payment.CreateOrderResponse `json:"data"`
+	}
+	suite.AssertNoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	// CHECKOUT.ORDER.APPROVED lands while PayPal captures the order, moving the version
+	// the handler read before it
+	mock.OnCapture = func(orderID string) {
+		applied, err := data.ApplyPayPalWebhook("membership", submission.FormID, "APPROVED",
+			`{"event_type":"CHECKOUT.ORDER.APPROVED"}`, 0, data.ActorWebhook)
+		if err != nil || !applied {
+			t.Errorf("expected the webhook applied mid-capture, got %v: %v", applied, err)
+		}
+	}
+	before, err := data.GetSubmissionVersion(submission.FormID)
+	suite.AssertNoError(t, err)
+
+	body, _ = json.Marshal(map[string]string{"orderID": created.Data.OrderID, "formID": submission.FormID})
+	req = httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec = httptest.NewRecorder()
+	payment.CapturePayPalOrderHandler(rec, req)
+	if rec.Code != http.StatusOK || mock.GetCompletedOrderCount() != 1 {
+		t.Fatalf("expected the order captured, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The capture is applied over the approval, with its receipt and follow-up tasks
+	retrieved, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if retrieved.PayPalStatus != "COMPLETED" || retrieved.ReceiptNumber == "" || retrieved.Version != before+2 {
+		t.Errorf("expected the capture recorded over the webhook with a receipt, got %q %q version %d",
+			retrieved.PayPalStatus, retrieved.ReceiptNumber, retrieved.Version)
+	}
+	claimed, err := data.ClaimOutboxTasks(time.Now().Add(time.Hour), 100)
+	suite.AssertNoError(t, err)
+	kinds := map[string]bool{}
+	for _, task := range tasksForForm(claimed, submission.FormID) {
+		kinds[task.Kind] = true
+	}
+	if !kinds[outbox.KindConfirmationEmail] || kinds[outbox.KindRecordCapture] {
+		t.Errorf("expected the receipt email queued without a capture retry, got %v", kinds)
+	}
+
+	// A webhook that completes the payment mid-capture is left as it recorded it
+	second := suite.GenerateTestMembership().ToMembershipSubmission()
+	second.CalculatedAmount = 40.00
+	suite.AssertNoError(t, data.InsertMembership(second))
+	order, err := mock.CreateOrder(second.FormID, "40.00")
+	suite.AssertNoError(t, err)
+	suite.AssertNoError(t, data.UpdateMembershipPayPalOrder(second.FormID, order.ID, nil))
+	secondBefore, err := data.GetSubmissionVersion(second.FormID)
+	suite.AssertNoError(t, err)
+	mock.OnCapture = func(orderID string) {
+		if _, err := data.ApplyPayPalWebhook("membership", second.FormID, "COMPLETED",
+			`{"event_type":"PAYMENT.CAPTURE.COMPLETED"}`, 0, data.ActorWebhook); err != nil {
+			t.Errorf("expected the webhook applied mid-capture: %v", err)
+		}
+	}
+	body, _ = json.Marshal(map[string]string{"orderID": order.ID, "formID": second.FormID})
+	req = httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", second.AccessToken)
+	rec = httptest.NewRecorder()
+	payment.CapturePayPalOrderHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the order captured, got %d: %s", rec.Code, rec.Body.String())
+	}
+	paid, err := data.GetMembershipByID(second.FormID)
+	suite.AssertNoError(t, err)
+	if paid.PayPalStatus != "COMPLETED" || paid.Version != secondBefore+1 {
+		t.Errorf("expected the webhook's payment kept, got %q version %d", paid.PayPalStatus, paid.Version)
+	}
+}
+
+func TestOutboxWorkerRetriesFailedTasks(t *testing.T) {
+	suite := NewTestSuite(t)
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	now := time.Now()
+	tasks := []data.OutboxTask{{Kind: "test_flaky"}}
+	suite.AssertNoError(t, data.RecordPayPalCapture("membership", submission.FormID, "{}", "COMPLETED", &now, tasks, data.ActorSystem))
+
+	calls := 0
+	worker := outbox.NewWorker()
+	worker.Handle("test_flaky", func(ctx context.Context, task data.OutboxTask) error {
+		calls++
+		if calls == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+
+	suite.AssertNoError(t, worker.Run(context.Background()))
+	if calls != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", calls)
+	}
+
+	// The failed task is rescheduled with backoff, not retried in the same run
+	claimed, err := data.ClaimOutboxTasks(time.Now().Add(time.Hour), 100)
+	suite.AssertNoError(t, err)
+	rescheduled := tasksForForm(claimed, submission.FormID)
+	if len(rescheduled) != 1 || rescheduled[0].Attempts != 2 || rescheduled[0].LastError != "temporary failure" {
+		t.Fatalf("Expected rescheduled task on second attempt, got %+v", rescheduled)
+	}
+}
+
+func TestOutboxRetriesFailedCaptureRecord(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := NewMockPayPalService()
+	defer mock.Close()
+	usePayPalMock(t, mock)
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	submission.CalculatedAmount = 40.00
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	body, _ := json.Marshal(map[string]string{"formID": submission.FormID})
+	req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec := httptest.NewRecorder()
+	middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+	var created struct {
+		Data payment.CreateOrderResponse `json:"data"`
+	}
+	suite.AssertNoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	// The capture succeeds at PayPal but recording it fails
+	_, err := data.ExecDB(`CREATE TRIGGER fail_capture BEFORE UPDATE OF paypal_status ON membership_submissions
+		BEGIN SELECT RAISE(ABORT, 'database is locked'); END`)
+	suite.AssertNoError(t, err)
+	body, _ = json.Marshal(map[string]string{"orderID": created.Data.OrderID, "formID": submission.FormID})
+	req = httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec = httptest.NewRecorder()
+	payment.CapturePayPalOrderHandler(rec, req)
+	if rec.Code != http.StatusOK || mock.GetCompletedOrderCount() != 1 {
+		t.Fatalf("expected the order captured, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if retrieved, _ := data.GetMembershipByID(submission.FormID); retrieved.PayPalStatus == "COMPLETED" {
+		t.Fatal("expected the capture left unrecorded")
+	}
+
+	// Once the database recovers, the worker records it with its receipt and follow-up tasks
+	worker := outbox.NewWorker()
+	worker.Handle(outbox.KindRecordCapture, payment.RecordCaptureTask)
+	if err := worker.Run(context.Background()); err != nil {
+		t.Fatalf("expected a failed retry rescheduled, got %v", err)
+	}
+	_, err = data.ExecDB(`DROP TRIGGER fail_capture`)
+	suite.AssertNoError(t, err)
+	worker.Handle(outbox.KindConfirmationEmail, func(ctx context.Context, task data.OutboxTask) error { return nil })
+	worker.Handle(outbox.KindAdminNotification, func(ctx context.Context, task data.OutboxTask) error { return nil })
+	claimed, err := data.ClaimOutboxTasks(time.Now().Add(time.Hour), 100)
+	suite.AssertNoError(t, err)
+	retry := tasksForForm(claimed, submission.FormID)
+	if len(retry) != 1 || retry[0].Kind != outbox.KindRecordCapture {
+		t.Fatalf("expected only the capture retry queued, got %+v", retry)
+	}
+	suite.AssertNoError(t, data.RetryOutboxTask(retry[0].ID, retry[0].LastError, time.Now()))
+	suite.AssertNoError(t, worker.Run(context.Background()))
+	// The tasks it queued go out on the next run
+	suite.AssertNoError(t, worker.Run(context.Background()))
+
+	retrieved, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if retrieved.PayPalStatus != "COMPLETED" || retrieved.ReceiptNumber == "" {
+		t.Errorf("expected the capture recorded with a receipt number, got %q %q", retrieved.PayPalStatus, retrieved.ReceiptNumber)
+	}
+	counts, err := data.CountOutboxTasksByStatus()
+	suite.AssertNoError(t, err)
+	if counts[data.OutboxDone] != 3 || counts[data.OutboxPending] != 0 {
+		t.Errorf("expected the retry and its confirmation and admin emails done, got %v", counts)
+	}
+}
+
+func tasksForForm(tasks []data.OutboxTask, formID string) []data.OutboxTask {
+	var matched []data.OutboxTask
+	for _, task := range tasks {
+		if task.FormID == formID {
+			matched = append(matched, task)
+		}
+	}
+	return matched
+}
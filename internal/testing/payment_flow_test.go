@@ -4,7 +4,6 @@ package testing
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
 	"testing"
 	"time"
 
@@ -80,8 +79,8 @@ func testMembershipCalculations(t *testing.T, suite *TestSuite) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			total, err := suite.Inventory.CalculateMembershipTotal(
-				tc.membership, tc.addons, tc.fees, tc.donation, tc.coverFees,
+			total, _, err := suite.Inventory.CalculateMembershipTotal(
+				tc.membership, tc.addons, tc.fees, tc.donation, tc.coverFees, "",
 			)
 			suite.AssertNoError(t, err)
 
@@ -145,8 +144,8 @@ func testEventCalculations(t *testing.T, suite *TestSuite) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			total, err := suite.Inventory.CalculateEventTotal(
-				tc.event, studentSelections, sharedSelections, tc.coverFees,
+			total, _, err := suite.Inventory.CalculateEventTotal(
+				tc.event, studentSelections, sharedSelections, tc.coverFees, "",
 			)
 			suite.AssertNoError(t, err)
 
@@ -168,26 +167,26 @@ func testEventCalculations(t *testing.T, suite *TestSuite) {
 
 func testTamperProtection(t *testing.T, suite *TestSuite) {
 	// Test invalid membership
-	_, err := suite.Inventory.CalculateMembershipTotal(
-		"Invalid Membership", []string{}, map[string]int{}, 0, false,
+	_, _, err := suite.Inventory.CalculateMembershipTotal(
+		"Invalid Membership", []string{}, map[string]int{}, 0, false, "",
 	)
 	suite.AssertError(t, err)
 
 	// Test invalid addon
-	_, err = suite.Inventory.CalculateMembershipTotal(
-		"Basic Membership", []string{"Invalid Addon"}, map[string]int{}, 0, false,
+	_, _, err = suite.Inventory.CalculateMembershipTotal(
+		"Basic Membership", []string{"Invalid Addon"}, map[string]int{}, 0, false, "",
 	)
 	suite.AssertError(t, err)
 
 	// Test invalid fee
-	_, err = suite.Inventory.CalculateMembershipTotal(
-		"Basic Membership", []string{}, map[string]int{"Invalid Fee": 1}, 0, false,
+	_, _, err = suite.Inventory.CalculateMembershipTotal(
+		"Basic Membership", []string{}, map[string]int{"Invalid Fee": 1}, 0, false, "",
 	)
 	suite.AssertError(t, err)
 
 	// Test invalid event
-	_, err = suite.Inventory.CalculateEventTotal(
-		"invalid-event", map[string]map[string]bool{}, map[string]int{}, false,
+	_, _, err = suite.Inventory.CalculateEventTotal(
+		"invalid-event", map[string]map[string]bool{}, map[string]int{}, false, "",
 	)
 	suite.AssertError(t, err)
 
@@ -232,8 +231,8 @@ func testMembershipPaymentFlowWithRetry(t *testing.T, suite *TestSuite, mockPayP
 	suite.AssertNoError(t, err)
 
 	// Step 2: Calculate expected total using inventory service
-	expectedTotal, err := suite.Inventory.CalculateMembershipTotal(
-		testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees,
+	expectedTotal, _, err := suite.Inventory.CalculateMembershipTotal(
+		testData.Membership, testData.Addons, testData.Fees, testData.Donation, testData.CoverFees, "",
 	)
 	suite.AssertNoError(t, err)
 
@@ -321,35 +320,27 @@ func testEventPaymentFlowWithRetry(t *testing.T, suite *TestSuite, mockPayPal *M
 	}
 
 	// Step 3: Calculate expected total
-	expectedTotal, err := suite.Inventory.CalculateEventTotal(
+	expectedTotal, _, err := suite.Inventory.CalculateEventTotal(
 		testData.Event,
 		eventSelections["student_selections"].(map[string]map[string]bool),
 		eventSelections["shared_selections"].(map[string]int),
-		testData.CoverFees,
+		testData.CoverFees, "",
 	)
 	suite.AssertNoError(t, err)
 
-	// Step 4: Update event with payment data - handle missing column gracefully
+	// Step 4: Update event with payment data
 	selectionsJSON, err := json.Marshal(eventSelections)
 	suite.AssertNoError(t, err)
 
 	submission.FoodChoicesJSON = string(selectionsJSON)
+	submission.HasFoodOrders = true
 	submission.CalculatedAmount = expectedTotal
 	submission.CoverFees = testData.CoverFees
 
-	// Try to update, handling missing column gracefully
 	err = suite.ExecuteWithRetry(func() error {
 		return data.UpdateEventPayment(submission)
 	}, 5)
-
-	if err != nil && containsColumnError(err, "has_food_orders") {
-		t.Logf("⚠️  has_food_orders column missing - skipping event payment update")
-		// Create a basic event payment record instead
-		submission.HasFoodOrders = false
-		submission.FoodOrderID = ""
-	} else {
-		suite.AssertNoError(t, err)
-	}
+	suite.AssertNoError(t, err)
 
 	// Step 5: Test PayPal flow
 	mockOrder, err := mockPayPal.CreateOrder(testData.FormID, fmt.Sprintf("%.2f", expectedTotal))
@@ -385,6 +376,12 @@ func testEventPaymentFlowWithRetry(t *testing.T, suite *TestSuite, mockPayPal *M
 	if final.PayPalStatus != "COMPLETED" {
 		t.Errorf("Expected COMPLETED status, got %s", final.PayPalStatus)
 	}
+	if !final.HasFoodOrders {
+		t.Errorf("Expected the food orders kept")
+	}
+	if final.PayPalOrderCreatedAt == nil || final.PayPalDetails == "" {
+		t.Errorf("Expected the PayPal order time and capture details kept, got %v %q", final.PayPalOrderCreatedAt, final.PayPalDetails)
+	}
 
 	t.Logf("✅ Event payment flow completed successfully (Amount: $%.2f)", expectedTotal)
 }
@@ -610,13 +607,3 @@ func testConcurrentPaymentsWithRetry(t *testing.T, suite *TestSuite, mockPayPal
 		t.Errorf("Success rate too low: %.1f%%", successRate)
 	}
 }
-
-// Helper function for column error detection
-func containsColumnError(err error, columnName string) bool {
-	if err == nil {
-		return false
-	}
-	errStr := err.Error()
-	return strings.Contains(errStr, fmt.Sprintf("no such column: %s", columnName)) ||
-		strings.Contains(errStr, fmt.Sprintf("SQL logic error: no such column: %s", columnName))
-}
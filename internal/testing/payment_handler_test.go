@@ -0,0 +1,782 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/security"
+)
+
+// usePayPalMock points the real payment handlers at the mock PayPal server for one test
+func usePayPalMock(t *testing.T, mock *MockPayPalService) {
+	previousBase, previousID, previousSecret := config.APIBase(), config.ClientID(), config.ClientSecret()
+	config.SetPayPalAPI(mock.GetAPIBase(), "test-client-id", "test-client-secret")
+	t.Cleanup(func() {
+		config.SetPayPalAPI(previousBase, previousID, previousSecret)
+	})
+}
+
+func TestPayPalHandlersAgainstMock(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := NewMockPayPalService()
+	defer mock.Close()
+	usePayPalMock(t, mock)
+
+	testData := suite.GenerateTestMembership()
+	submission := testData.ToMembershipSubmission()
+	submission.CalculatedAmount = 75.00
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	// Create order through the same middleware chain the router uses
+	body, _ := json.Marshal(map[string]string{"formID": submission.FormID})
+	req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec := httptest.NewRecorder()
+	middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create order returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var created struct {
+		Success bool                        `json:"success"`
+		Data    payment.CreateOrderResponse `json:"data"`
+	}
+	suite.AssertNoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	if !created.Success || created.Data.OrderID == "" {
+		t.Fatalf("expected an order ID, got %s", rec.Body.String())
+	}
+	if order, ok := mock.GetOrder(created.Data.OrderID); !ok || order.Amount != "75.00" || order.FormID != submission.FormID {
+		t.Fatalf("mock did not receive the expected order: %+v", order)
+	}
+
+	// Capture directly; going through the middleware again would trip the per-token rate limit
+	body, _ = json.Marshal(map[string]string{"orderID": created.Data.OrderID, "formID": submission.FormID})
+	req = httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec = httptest.NewRecorder()
+	payment.CapturePayPalOrderHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("capture returned %d: %s", rec.Code, rec.Body.String())
+	}
+	if mock.GetCompletedOrderCount() != 1 {
+		t.Errorf("expected 1 completed order in mock, got %d", mock.GetCompletedOrderCount())
+	}
+
+	retrieved, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if retrieved.PayPalStatus != "COMPLETED" || retrieved.PayPalOrderID != created.Data.OrderID {
+		t.Errorf("expected captured order %s, got status %q order %q",
+			created.Data.OrderID, retrieved.PayPalStatus, retrieved.PayPalOrderID)
+	}
+}
+
+func TestPayPalHandlerReportsAuthFailure(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := NewMockPayPalService()
+	defer mock.Close()
+	usePayPalMock(t, mock)
+	mock.SetFailureMode(true, false, false)
+
+	testData := suite.GenerateTestMembership()
+	submission := testData.ToMembershipSubmission()
+	submission.CalculatedAmount = 50.00
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	body, _ := json.Marshal(map[string]string{"formID": submission.FormID})
+	req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec := httptest.NewRecorder()
+	middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 when PayPal auth fails, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var apiErr middleware.APIError
+	suite.AssertNoError(t, json.Unmarshal(rec.Body.Bytes(), &apiErr))
+	if apiErr.Code != "paypal_error" {
+		t.Errorf("expected paypal_error code, got %q", apiErr.Code)
+	}
+	if mock.GetOrderCount() != 0 {
+		t.Errorf("expected no orders after auth failure, got %d", mock.GetOrderCount())
+	}
+}
+
+func TestVenmoFundingSource(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := NewMockPayPalService()
+	defer mock.Close()
+	usePayPalMock(t, mock)
+	previous := middleware.SetTokenRateLimit(0)
+	t.Cleanup(func() { middleware.SetTokenRateLimit(previous) })
+
+	submission := suite.GenerateTestMembership().ToMembershipSubmission()
+	submission.CalculatedAmount = 40.00
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	create := func(fundingSource string) (int, payment.CreateOrderResponse) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"formID": submission.FormID, "fundingSource": fundingSource})
+		req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", submission.AccessToken)
+		rec := httptest.NewRecorder()
+		middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		return rec.Code, created.Data
+	}
+
+	if code, _ := create("bitcoin"); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown funding source to be refused, got %d", code)
+	}
+
+	_, paypalOrder := create("paypal")
+	if order, ok := mock.GetOrder(paypalOrder.OrderID); !ok || order.FundingSource != "paypal" {
+		t.Fatalf("expected a PayPal order, got %+v", order)
+	}
+	// Switching to the Venmo button needs an order made for Venmo
+	_, venmoOrder := create("Venmo")
+	order, ok := mock.GetOrder(venmoOrder.OrderID)
+	if !ok || venmoOrder.OrderID == paypalOrder.OrderID || order.FundingSource != "venmo" {
+		t.Fatalf("expected a new order with a Venmo payment source, got %+v", order)
+	}
+	if _, again := create("venmo"); again.OrderID != venmoOrder.OrderID {
+		t.Errorf("expected the Venmo order to be reused, got %s", again.OrderID)
+	}
+
+	body, _ := json.Marshal(map[string]string{"orderID": venmoOrder.OrderID, "formID": submission.FormID})
+	req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec := httptest.NewRecorder()
+	payment.CapturePayPalOrderHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("capture returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	venmo, err := data.ListSubmissions(data.SubmissionFilter{Funding: "venmo"})
+	suite.AssertNoError(t, err)
+	if len(venmo) != 1 || venmo[0].FormID != submission.FormID || venmo[0].FundingSource != "venmo" {
+		t.Errorf("expected the payment listed as funded by Venmo, got %+v", venmo)
+	}
+}
+
+func TestAdvancedCheckoutPaymentSources(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := NewMockPayPalService()
+	defer mock.Close()
+	usePayPalMock(t, mock)
+	t.Setenv("ENVIRONMENT", "dev")
+	previous := middleware.SetTokenRateLimit(0)
+	t.Cleanup(func() { middleware.SetTokenRateLimit(previous) })
+
+	membership := func() data.MembershipSubmission {
+		submission := suite.GenerateTestMembership().ToMembershipSubmission()
+		submission.CalculatedAmount = 55.00
+		suite.AssertNoError(t, data.InsertMembership(submission))
+		return submission
+	}
+	post := func(handler http.HandlerFunc, path, accessToken string, body interface{}, into interface{}) int {
+		t.Helper()
+		encoded, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(encoded))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", accessToken)
+		rec := httptest.NewRecorder()
+		middleware.APIMiddleware(handler)(rec, req)
+		if into != nil {
+			json.Unmarshal(rec.Body.Bytes(), into)
+		}
+		return rec.Code
+	}
+	create := func(submission data.MembershipSubmission, fundingSource string) (int, payment.CreateOrderResponse) {
+		t.Helper()
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		code := post(payment.CreatePayPalOrderHandler, "/api/create-order", submission.AccessToken,
+			map[string]string{"formID": submission.FormID, "fundingSource": fundingSource}, &created)
+		return code, created.Data
+	}
+	confirm := func(submission data.MembershipSubmission, orderID, fundingSource string, source interface{}) (int, payment.ConfirmPaymentSourceResponse) {
+		t.Helper()
+		var confirmed struct {
+			Data payment.ConfirmPaymentSourceResponse `json:"data"`
+		}
+		code := post(payment.ConfirmPaymentSourceHandler, "/api/confirm-payment-source", submission.AccessToken,
+			map[string]interface{}{"formID": submission.FormID, "orderID": orderID, "fundingSource": fundingSource, "paymentSource": source},
+			&confirmed)
+		return code, confirmed.Data
+	}
+
+	walletPayer := membership()
+	if code, _ := create(walletPayer, "apple_pay"); code != http.StatusBadRequest {
+		t.Errorf("expected Apple Pay refused while advanced checkout is off, got %d", code)
+	}
+	t.Setenv("ADVANCED_CHECKOUT_ENABLED_DEV", "true")
+
+	t.Run("CardFieldsWith3DS", func(t *testing.T) {
+		submission := membership()
+		_, created := create(submission, "card")
+		if order, ok := mock.GetOrder(created.OrderID); !ok || order.FundingSource != "card" {
+			t.Fatalf("expected a card order for the hosted card fields, got %+v", order)
+		}
+
+		mock.mu.Lock()
+		mock.RequirePayerAction = true
+		mock.mu.Unlock()
+		defer func() {
+			mock.mu.Lock()
+			mock.RequirePayerAction = false
+			mock.mu.Unlock()
+		}()
+		code, confirmed := confirm(submission, created.OrderID, "card", nil)
+		if code != http.StatusOK || confirmed.Status != "PAYER_ACTION_REQUIRED" || confirmed.PayerActionURL == "" {
+			t.Fatalf("expected the card sent through 3-D Secure, got %d %+v", code, confirmed)
+		}
+		if confirmed.Instrument != "VISA ending 1111" {
+			t.Errorf("expected the card described, got %q", confirmed.Instrument)
+		}
+	})
+
+	t.Run("ApplePay", func(t *testing.T) {
+		_, created := create(walletPayer, "apple_pay")
+		if code, _ := confirm(walletPayer, "ORDER-NOT-THEIRS", "apple_pay", nil); code != http.StatusConflict {
+			t.Errorf("expected another order refused, got %d", code)
+		}
+		if code, _ := confirm(walletPayer, created.OrderID, "apple_pay", "not an object"); code != http.StatusBadRequest {
+			t.Errorf("expected a malformed payment source refused, got %d", code)
+		}
+
+		code, confirmed := confirm(walletPayer, created.OrderID, "apple_pay",
+			map[string]interface{}{"token": "apple-pay-token"})
+		if code != http.StatusOK || confirmed.Status != "APPROVED" || confirmed.Instrument != "Apple Pay VISA ending 1111" {
+			t.Fatalf("expected the Apple Pay card confirmed, got %d %+v", code, confirmed)
+		}
+		summary, err := data.GetSubmissionSummary(walletPayer.FormID)
+		suite.AssertNoError(t, err)
+		if summary.FundingSource != "apple_pay" || summary.Instrument != confirmed.Instrument {
+			t.Errorf("expected the chosen wallet stored, got %q %q", summary.FundingSource, summary.Instrument)
+		}
+
+		body, _ := json.Marshal(map[string]string{"orderID": created.OrderID, "formID": walletPayer.FormID})
+		req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+		req.Header.Set("X-Access-Token", walletPayer.AccessToken)
+		rec := httptest.NewRecorder()
+		payment.CapturePayPalOrderHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("capture returned %d: %s", rec.Code, rec.Body.String())
+		}
+		var status struct {
+			Data payment.PaymentStatusResponse `json:"data"`
+		}
+		post(payment.PaymentStatusHandler, "/api/payment-status", walletPayer.AccessToken,
+			map[string]string{"formID": walletPayer.FormID}, &status)
+		if !status.Data.Paid || status.Data.Instrument != "Apple Pay VISA ending 1111" {
+			t.Errorf("expected the paid status to name the wallet card, got %+v", status.Data)
+		}
+	})
+}
+
+// mockStripe serves the Checkout Session and refund endpoints checkout uses
+type mockStripe struct {
+	*httptest.Server
+	mu      sync.Mutex
+	created url.Values
+	paid    bool
+	intent  string // status of a bank transfer's payment until the session is paid
+	refund  url.Values
+}
+
+func newMockStripe(t *testing.T) *mockStripe {
+	mock := &mockStripe{}
+	session := func() map[string]interface{} {
+		status, paymentStatus := "open", "unpaid"
+		if mock.paid {
+			status, paymentStatus = "complete", "paid"
+		} else if mock.intent != "" {
+			status = "complete"
+		}
+		amount, _ := strconv.ParseInt(mock.created.Get("line_items[0][price_data][unit_amount]"), 10, 64)
+		return map[string]interface{}{
+			"id": "cs_test_001", "url": "https://checkout.stripe.test/c/cs_test_001",
+			"client_reference_id": mock.created.Get("client_reference_id"),
+			"status":              status, "payment_status": paymentStatus, "payment_intent": "pi_test_001",
+			"amount_total": amount, "currency": mock.created.Get("line_items[0][price_data][currency]"),
+		}
+	}
+	mock.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer sk_test_harness" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "Invalid API Key"}})
+			return
+		}
+		r.ParseForm()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/checkout/sessions":
+			mock.created = r.PostForm
+			json.NewEncoder(w).Encode(session())
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/checkout/sessions/cs_test_001":
+			json.NewEncoder(w).Encode(session())
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/payment_intents/pi_test_001":
+			json.NewEncoder(w).Encode(map[string]string{"id": "pi_test_001", "status": mock.intent})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/refunds":
+			mock.refund = r.PostForm
+			json.NewEncoder(w).Encode(map[string]string{"id": "re_test_001", "status": "succeeded"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(mock.Close)
+	return mock
+}
+
+func TestStripeCheckout(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := newMockStripe(t)
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("PAYMENT_PROVIDER_DEV", "stripe")
+	t.Setenv("STRIPE_SECRET_KEY_DEV", "sk_test_harness")
+	t.Setenv("STRIPE_API_BASE_DEV", mock.URL)
+	t.Setenv("PUBLIC_BASE_URL", "https://booster.example.org")
+
+	testData := suite.GenerateTestMembership()
+	submission := testData.ToMembershipSubmission()
+	submission.CalculatedAmount = 42.50
+	suite.AssertNoError(t, data.InsertMembership(submission))
+
+	body, _ := json.Marshal(map[string]string{"formID": submission.FormID})
+	req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Token", submission.AccessToken)
+	rec := httptest.NewRecorder()
+	middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+
+	var created struct {
+		Data payment.CreateOrderResponse `json:"data"`
+	}
+	suite.AssertNoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	if rec.Code != http.StatusOK || created.Data.OrderID != "cs_test_001" || created.Data.Provider != "stripe" ||
+		created.Data.ApproveURL != "https://checkout.stripe.test/c/cs_test_001" {
+		t.Fatalf("expected a Stripe checkout session to redirect to, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if mock.created.Get("line_items[0][price_data][unit_amount]") != "4250" ||
+		mock.created.Get("client_reference_id") != submission.FormID ||
+		mock.created.Get("success_url") != "https://booster.example.org/member-checkout.html?session_id={CHECKOUT_SESSION_ID}" {
+		t.Errorf("unexpected checkout session request: %v", mock.created)
+	}
+
+	capture := func() *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"orderID": created.Data.OrderID, "formID": submission.FormID})
+		req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", submission.AccessToken)
+		rec := httptest.NewRecorder()
+		payment.CapturePayPalOrderHandler(rec, req)
+		return rec
+	}
+
+	// Coming back without paying captures nothing
+	if rec := capture(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected an unpaid session to fail capture, got %d", rec.Code)
+	}
+
+	mock.mu.Lock()
+	mock.paid = true
+	mock.mu.Unlock()
+	if rec := capture(); rec.Code != http.StatusOK {
+		t.Fatalf("capture returned %d: %s", rec.Code, rec.Body.String())
+	}
+	paid, err := data.GetMembershipByID(submission.FormID)
+	suite.AssertNoError(t, err)
+	if paid.PayPalStatus != "COMPLETED" || paid.PayPalOrderID != "cs_test_001" || paid.ReceiptNumber == "" {
+		t.Fatalf("expected the session to be recorded as paid, got %q %q %q",
+			paid.PayPalStatus, paid.PayPalOrderID, paid.ReceiptNumber)
+	}
+
+	// The paid session can't be passed off as paying for another form the family holds,
+	// whether or not that form's stored order is the session
+	other := suite.GenerateTestMembership().ToMembershipSubmission()
+	other.CalculatedAmount = 90
+	suite.AssertNoError(t, data.InsertMembership(other))
+	captureOther := func() int {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"orderID": created.Data.OrderID, "formID": other.FormID})
+		req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+		req.Header.Set("X-Access-Token", other.AccessToken)
+		rec := httptest.NewRecorder()
+		payment.CapturePayPalOrderHandler(rec, req)
+		return rec.Code
+	}
+	if code := captureOther(); code != http.StatusConflict {
+		t.Errorf("expected another form's session refused, got %d", code)
+	}
+	suite.AssertNoError(t, data.UpdateMembershipPayPalOrder(other.FormID, created.Data.OrderID, nil))
+	if code := captureOther(); code != http.StatusConflict {
+		t.Errorf("expected a session made for another form refused, got %d", code)
+	}
+	if stolen, _ := data.GetMembershipByID(other.FormID); stolen.PayPalStatus == "COMPLETED" || stolen.Submitted {
+		t.Errorf("expected the other form left unpaid, got %q", stolen.PayPalStatus)
+	}
+	_, err = payment.Provider().CaptureOrder(context.Background(), payment.OrderCapture{
+		FormID: submission.FormID, OrderID: created.Data.OrderID, Amount: 90,
+	})
+	if !errors.Is(err, payment.ErrOrderMismatch) {
+		t.Errorf("expected a session for another amount refused, got %v", err)
+	}
+
+	// Refunds go against the session's payment
+	provider := payment.Provider()
+	captureID := provider.CaptureID(paid.PayPalDetails, paid.FormID)
+	if captureID != "pi_test_001" {
+		t.Fatalf("expected the payment intent to refund against, got %q", captureID)
+	}
+	refundID, err := provider.Refund(context.Background(), captureID, 10, "USD", "Test refund")
+	suite.AssertNoError(t, err)
+	if refundID != "re_test_001" || mock.refund.Get("amount") != "1000" || mock.refund.Get("payment_intent") != "pi_test_001" {
+		t.Errorf("unexpected refund %q: %v", refundID, mock.refund)
+	}
+}
+
+// fakeRefunds is a payment provider that only refunds, recording what it was asked to
+type fakeRefunds struct {
+	payment.PaymentProvider
+	refunds []float64
+}
+
+func (f *fakeRefunds) Name() string { return "fake" }
+
+func (f *fakeRefunds) CaptureID(details, formID string) string {
+	if strings.Contains(details, "CAPTURE-REFUND-001") {
+		return "CAPTURE-REFUND-001"
+	}
+	return ""
+}
+
+func (f *fakeRefunds) Refund(ctx context.Context, captureID string, amount float64, currencyCode, note string) (string, error) {
+	f.refunds = append(f.refunds, amount)
+	return fmt.Sprintf("REFUND-%d", len(f.refunds)), nil
+}
+
+func TestRefundOrder(t *testing.T) {
+	h := NewHarness(t)
+	provider := &fakeRefunds{}
+	previous := payment.SetProvider(provider)
+	t.Cleanup(func() { payment.SetProvider(previous) })
+
+	submission := NewTestSuite(t).GenerateTestMembership().ToMembershipSubmission()
+	submission.CalculatedAmount = 60
+	submission.PayPalStatus = "COMPLETED"
+	submission.PayPalDetails = `{"id": "ORDER-REFUND-001", "status": "COMPLETED", "capture_id": "CAPTURE-REFUND-001"}`
+	h.AssertNoError(t, data.InsertMembership(submission))
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+
+	refund := func(token string, amount float64) (int, payment.RefundOrderResponse, string) {
+		t.Helper()
+		body, _ := json.Marshal(payment.RefundOrderRequest{
+			FormID: submission.FormID, Amount: amount, Reason: fmt.Sprintf("refund %d", len(provider.refunds)+1),
+		})
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/refund-order", bytes.NewReader(body))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		var result struct {
+			Data payment.RefundOrderResponse `json:"data"`
+		}
+		json.Unmarshal(raw, &result)
+		return resp.StatusCode, result.Data, string(raw)
+	}
+
+	if code, _, _ := refund("not-an-admin", 10); code != http.StatusForbidden {
+		t.Fatalf("expected refunds to need an admin token, got %d", code)
+	}
+
+	// A partial refund leaves the order paid
+	code, result, body := refund(adminToken, 20)
+	if code != http.StatusOK || result.RefundID != "REFUND-1" || result.Status != "COMPLETED" ||
+		result.RefundedTotal != 20 || result.NetAmount != 40 {
+		t.Fatalf("expected a $20 partial refund, got %d: %s", code, body)
+	}
+	summary, err := data.GetSubmissionSummary(submission.FormID)
+	h.AssertNoError(t, err)
+	if summary.NetAmount != 40 || summary.CalculatedAmount != 60 {
+		t.Errorf("expected $40 net of the $60 paid, got %.2f of %.2f", summary.NetAmount, summary.CalculatedAmount)
+	}
+	if code, _, _ := refund(adminToken, 50); code != http.StatusBadRequest {
+		t.Errorf("expected a refund past what is left to be refused, got %d", code)
+	}
+
+	// Refunding the rest marks it refunded and keeps the capture alongside the refunds
+	code, result, body = refund(adminToken, 0)
+	if code != http.StatusOK || result.Amount != 40 || result.Status != "REFUNDED" {
+		t.Fatalf("expected the remaining $40 to be refunded, got %d: %s", code, body)
+	}
+	stored, err := data.GetMembershipByID(submission.FormID)
+	h.AssertNoError(t, err)
+	if stored.PayPalStatus != "REFUNDED" || !strings.Contains(stored.PayPalDetails, "REFUND-2") ||
+		!strings.Contains(stored.PayPalDetails, "ORDER-REFUND-001") {
+		t.Errorf("expected the refunds recorded with the capture, got %q: %s", stored.PayPalStatus, stored.PayPalDetails)
+	}
+	refunds, err := data.ListRefunds(submission.FormID)
+	h.AssertNoError(t, err)
+	if len(refunds) != 2 || refunds[0].Amount != 20 || refunds[0].Reason != "refund 1" || refunds[1].ID != "REFUND-2" {
+		t.Errorf("expected both refunds kept with their reasons, got %+v", refunds)
+	}
+
+	if code, _, _ := refund(adminToken, 0); code != http.StatusConflict || len(provider.refunds) != 2 {
+		t.Errorf("expected a refunded order not to be refunded again, got %d after %v", code, provider.refunds)
+	}
+}
+
+func TestBankTransferDonation(t *testing.T) {
+	suite := NewTestSuite(t)
+	mock := newMockStripe(t)
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("BANK_TRANSFER_ENABLED_DEV", "true")
+	t.Setenv("BANK_TRANSFER_MINIMUM_DEV", "100")
+	t.Setenv("STRIPE_SECRET_KEY_DEV", "sk_test_harness")
+	t.Setenv("STRIPE_API_BASE_DEV", mock.URL)
+	previous := middleware.SetTokenRateLimit(0)
+	t.Cleanup(func() { middleware.SetTokenRateLimit(previous) })
+
+	donation := func(amount float64) data.FundraiserSubmission {
+		submission := suite.GenerateTestFundraiser().ToFundraiserSubmission()
+		submission.CalculatedAmount = amount
+		suite.AssertNoError(t, data.InsertFundraiser(submission))
+		return submission
+	}
+	createOrder := func(submission data.FundraiserSubmission) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"formID": submission.FormID, "fundingSource": "bank"})
+		req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", submission.AccessToken)
+		rec := httptest.NewRecorder()
+		middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+		return rec
+	}
+	capture := func(submission data.FundraiserSubmission) int {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"orderID": "cs_test_001", "formID": submission.FormID})
+		req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+		req.Header.Set("X-Access-Token", submission.AccessToken)
+		rec := httptest.NewRecorder()
+		payment.CapturePayPalOrderHandler(rec, req)
+		return rec.Code
+	}
+	setIntent := func(status string) {
+		mock.mu.Lock()
+		mock.intent = status
+		mock.mu.Unlock()
+	}
+
+	if rec := createOrder(donation(60)); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected small donations not to be offered bank transfer, got %d", rec.Code)
+	}
+
+	large := donation(500)
+	rec := createOrder(large)
+	var created struct {
+		Data payment.CreateOrderResponse `json:"data"`
+	}
+	suite.AssertNoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	if rec.Code != http.StatusOK || created.Data.Provider != "bank_transfer" ||
+		mock.created.Get("payment_method_types[0]") != "us_bank_account" {
+		t.Fatalf("expected a Stripe bank debit session, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The family authorized the debit; it stays pending until the bank settles it
+	setIntent("processing")
+	if code := capture(large); code != http.StatusAccepted {
+		t.Fatalf("expected an authorized transfer to be accepted as pending, got %d", code)
+	}
+	pending, err := data.GetFundraiserByID(large.FormID)
+	suite.AssertNoError(t, err)
+	if pending.PayPalStatus != data.PaymentPendingStatus || pending.ReceiptNumber != "" {
+		t.Errorf("expected a pending donation without a receipt, got %q %q", pending.PayPalStatus, pending.ReceiptNumber)
+	}
+	if rec := createOrder(large); rec.Code != http.StatusConflict {
+		t.Errorf("expected no second payment while the transfer settles, got %d", rec.Code)
+	}
+	if settled, failed, err := payment.SettleBankTransfers(context.Background()); err != nil || settled+failed != 0 {
+		t.Errorf("expected a processing transfer to stay pending, got %d settled %d failed: %v", settled, failed, err)
+	}
+
+	setIntent("succeeded")
+	if settled, _, err := payment.SettleBankTransfers(context.Background()); err != nil || settled != 1 {
+		t.Fatalf("expected the transfer to settle, got %d: %v", settled, err)
+	}
+	paid, err := data.GetFundraiserByID(large.FormID)
+	suite.AssertNoError(t, err)
+	if paid.PayPalStatus != "COMPLETED" || paid.ReceiptNumber == "" {
+		t.Errorf("expected the settled donation paid with a receipt, got %q %q", paid.PayPalStatus, paid.ReceiptNumber)
+	}
+	if source, _ := data.GetFundingSource("fundraiser", large.FormID); source != payment.FundingBank {
+		t.Errorf("expected the donation recorded as a bank transfer, got %q", source)
+	}
+
+	// A returned transfer leaves the donation unpaid so the family can pay another way
+	returned := donation(300)
+	setIntent("")
+	if rec := createOrder(returned); rec.Code != http.StatusOK {
+		t.Fatalf("create order returned %d: %s", rec.Code, rec.Body.String())
+	}
+	setIntent("processing")
+	if code := capture(returned); code != http.StatusAccepted {
+		t.Fatalf("expected the second transfer pending, got %d", code)
+	}
+	setIntent("requires_payment_method")
+	if _, failed, err := payment.SettleBankTransfers(context.Background()); err != nil || failed != 1 {
+		t.Fatalf("expected the returned transfer to fail, got %d: %v", failed, err)
+	}
+	unpaid, err := data.GetFundraiserByID(returned.FormID)
+	suite.AssertNoError(t, err)
+	if unpaid.PayPalStatus != "FAILED" || unpaid.PayPalOrderID != "" || unpaid.Submitted {
+		t.Errorf("expected the returned donation unpaid, got %q %q submitted=%v",
+			unpaid.PayPalStatus, unpaid.PayPalOrderID, unpaid.Submitted)
+	}
+}
+
+func TestMembershipAutoRenewal(t *testing.T) {
+	h := NewHarness(t)
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("PAYPAL_MEMBERSHIP_PLAN_ID_DEV", "P-MEMBERSHIP")
+	previousVerification := config.UseMockWebhookVerification
+	config.UseMockWebhookVerification = true
+	t.Cleanup(func() { config.UseMockWebhookVerification = previousVerification })
+	previous := middleware.SetTokenRateLimit(0)
+	t.Cleanup(func() { middleware.SetTokenRateLimit(previous) })
+
+	member := h.GenerateTestMembership().ToMembershipSubmission()
+	member.CalculatedAmount = 60.00
+	h.AssertNoError(t, data.InsertMembership(member))
+	donor := h.GenerateTestFundraiser().ToFundraiserSubmission()
+	h.AssertNoError(t, data.InsertFundraiser(donor))
+
+	create := func(formID, token string) (int, payment.CreateOrderResponse) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"formID": formID, "autoRenew": true})
+		req := httptest.NewRequest(http.MethodPost, "/api/create-order", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Access-Token", token)
+		rec := httptest.NewRecorder()
+		middleware.APIMiddleware(payment.CreatePayPalOrderHandler)(rec, req)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		return rec.Code, created.Data
+	}
+
+	if code, _ := create(donor.FormID, donor.AccessToken); code != http.StatusBadRequest {
+		t.Errorf("expected auto-renewal to be refused for a donation, got %d", code)
+	}
+
+	code, created := create(member.FormID, member.AccessToken)
+	if code != http.StatusOK || !created.Subscription || created.ApproveURL == "" {
+		t.Fatalf("expected a subscription to approve, got %d %+v", code, created)
+	}
+	subscription, ok := h.PayPal.GetSubscription(created.OrderID)
+	if !ok || subscription.Amount != "60.00" || subscription.FormID != member.FormID || subscription.PlanID != "P-MEMBERSHIP" {
+		t.Fatalf("expected a $60 subscription to the membership plan, got %+v", subscription)
+	}
+
+	capture := func() int {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"orderID": created.OrderID, "formID": member.FormID})
+		req := httptest.NewRequest(http.MethodPost, "/api/capture-order", bytes.NewReader(body))
+		req.Header.Set("X-Access-Token", member.AccessToken)
+		rec := httptest.NewRecorder()
+		payment.CapturePayPalOrderHandler(rec, req)
+		return rec.Code
+	}
+	if code := capture(); code != http.StatusConflict {
+		t.Errorf("expected an unapproved subscription not to complete the membership, got %d", code)
+	}
+	h.PayPal.ApproveSubscription(created.OrderID)
+	if code := capture(); code != http.StatusOK {
+		t.Fatalf("expected the approved subscription to complete the membership, got %d", code)
+	}
+	summary, err := data.GetSubmissionSummary(member.FormID)
+	h.AssertNoError(t, err)
+	if summary.PayPalStatus != "COMPLETED" {
+		t.Errorf("expected the membership paid, got %s", summary.PayPalStatus)
+	}
+
+	webhook := func(eventType string, resource map[string]interface{}) {
+		t.Helper()
+		payload, _ := json.Marshal(map[string]interface{}{"event_type": eventType, "resource": resource})
+		resp, err := h.Client.Post(h.Server.URL+"/api/paypal-webhook", "application/json", bytes.NewReader(payload))
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s webhook returned %d", eventType, resp.StatusCode)
+		}
+	}
+	sale := func(id, paidAt string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":                   id,
+			"state":                "completed",
+			"amount":               map[string]interface{}{"total": "60.00", "currency": "USD"},
+			"billing_agreement_id": created.OrderID,
+			"custom":               member.FormID,
+			"create_time":          paidAt,
+		}
+	}
+	webhook("PAYMENT.SALE.COMPLETED", sale("SALE-1", "2026-09-01T12:00:00Z"))
+	webhook("PAYMENT.SALE.COMPLETED", sale("SALE-2", "2027-09-01T12:00:00Z"))
+	webhook("PAYMENT.SALE.COMPLETED", sale("SALE-2", "2027-09-01T12:00:00Z")) // redelivered
+
+	renewals, err := data.ListRenewals(member.FormID)
+	h.AssertNoError(t, err)
+	if len(renewals) != 2 || renewals[0].Cycle != 1 || renewals[1].Cycle != 2 || renewals[1].SaleID != "SALE-2" {
+		t.Fatalf("expected two yearly cycles, got %+v", renewals)
+	}
+	through, status, err := data.GetMembershipRenewal(member.FormID)
+	h.AssertNoError(t, err)
+	if through == nil || through.Format("2006-01-02") != "2028-09-01" || status != "ACTIVE" {
+		t.Errorf("expected the membership active through 2028-09-01, got %v %s", through, status)
+	}
+
+	webhook("BILLING.SUBSCRIPTION.CANCELLED", map[string]interface{}{
+		"id":        created.OrderID,
+		"status":    "CANCELLED",
+		"custom_id": member.FormID,
+	})
+	if _, status, _ := data.GetMembershipRenewal(member.FormID); status != "CANCELLED" {
+		t.Errorf("expected the cancelled subscription recorded, got %s", status)
+	}
+}
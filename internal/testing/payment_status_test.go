@@ -0,0 +1,83 @@
+package testing
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/payment"
+)
+
+func TestPaymentStatus(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+
+	status := func(method, formID, token string) (int, payment.PaymentStatusResponse) {
+		t.Helper()
+		path, body := "/api/payment-status", interface{}(map[string]string{"formID": formID})
+		if method == http.MethodGet {
+			path, body = path+"?formID="+url.QueryEscape(formID), nil
+		}
+		resp, err := h.MakeAPIRequest(method, path, body, token)
+		h.AssertNoError(t, err)
+		var result struct {
+			Data payment.PaymentStatusResponse `json:"data"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			h.AssertNoError(t, h.ParseJSONResponse(resp, &result))
+		} else {
+			resp.Body.Close()
+		}
+		return resp.StatusCode, result.Data
+	}
+
+	member := h.GenerateTestMembership().ToMembershipSubmission()
+	member.CalculatedAmount = 25
+	h.AssertNoError(t, data.InsertMembership(member))
+	event := h.GenerateTestEvent().ToEventSubmission()
+	event.CalculatedAmount = 18
+	h.AssertNoError(t, data.InsertEvent(event))
+	fundraiser := h.GenerateTestFundraiser().ToFundraiserSubmission()
+	fundraiser.CalculatedAmount = 50
+	h.AssertNoError(t, data.InsertFundraiser(fundraiser))
+
+	t.Run("Unpaid", func(t *testing.T) {
+		code, result := status(http.MethodGet, member.FormID, member.AccessToken)
+		if code != http.StatusOK || result.Paid || result.Status != "" || result.CapturedAt != nil {
+			t.Errorf("expected an unpaid membership, got %d %+v", code, result)
+		}
+		if result.Amount != 25 || result.Currency != "USD" || result.FormType != "membership" {
+			t.Errorf("expected $25.00 USD for the membership, got %+v", result)
+		}
+		if code, _ := status(http.MethodGet, member.FormID, fundraiser.AccessToken); code != http.StatusForbidden {
+			t.Errorf("expected 403 for another family's form, got %d", code)
+		}
+		if code, _ := status(http.MethodGet, "", member.AccessToken); code != http.StatusBadRequest {
+			t.Errorf("expected 400 without a form ID, got %d", code)
+		}
+	})
+
+	t.Run("Paid", func(t *testing.T) {
+		paidAt := time.Date(2025, 9, 3, 15, 4, 5, 0, time.UTC)
+		for _, sub := range []struct{ formType, formID, token string }{
+			{"membership", member.FormID, member.AccessToken},
+			{"event", event.FormID, event.AccessToken},
+			{"fundraiser", fundraiser.FormID, fundraiser.AccessToken},
+		} {
+			h.AssertNoError(t, data.RecordPayPalCapture(sub.formType, sub.formID, `{"status":"COMPLETED"}`, "COMPLETED",
+				&paidAt, []data.OutboxTask{{Kind: outbox.KindConfirmationEmail}}, data.ActorFamily))
+
+			code, result := status(http.MethodPost, sub.formID, sub.token)
+			if code != http.StatusOK || !result.Paid || result.Status != "COMPLETED" || result.FormType != sub.formType {
+				t.Errorf("expected %s paid, got %d %+v", sub.formID, code, result)
+				continue
+			}
+			if result.CapturedAt == nil || !result.CapturedAt.Equal(paidAt) || result.ReceiptNumber == "" {
+				t.Errorf("expected %s captured at %v with a receipt number, got %+v", sub.formID, paidAt, result)
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package testing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/payment"
+)
+
+// alertRecorder captures what the notification router sends on every channel
+type alertRecorder struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func newAlertRecorder(t *testing.T) *alertRecorder {
+	recorder := &alertRecorder{}
+	capture := func(ctx context.Context, to notification.Recipient, msg notification.Message) error {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		recorder.sent = append(recorder.sent, to.Channel+" "+to.Address+": "+msg.Subject+msg.Body)
+		return nil
+	}
+	for _, channel := range []string{notification.ChannelEmail, notification.ChannelSMS, notification.ChannelChat, notification.ChannelPush} {
+		previous := notification.SetBackend(channel, capture)
+		t.Cleanup(func() { notification.SetBackend(channel, previous) })
+	}
+	return recorder
+}
+
+// take waits for n messages, which are sent in the background, and clears them
+func (r *alertRecorder) take(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		got := append([]string(nil), r.sent...)
+		r.mu.Unlock()
+		if len(got) >= n || time.Now().After(deadline) {
+			// Give a stray extra message a moment to show up
+			time.Sleep(50 * time.Millisecond)
+			r.mu.Lock()
+			got = append([]string(nil), r.sent...)
+			r.sent = nil
+			r.mu.Unlock()
+			if len(got) != n {
+				t.Fatalf("expected %d alerts, got %d: %q", n, len(got), got)
+			}
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPayPalFailureEscalation(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("PAYPAL_ALERT_EMAIL_AFTER_DEV", "2")
+	t.Setenv("PAYPAL_ALERT_PAGE_AFTER_DEV", "4")
+	t.Setenv("PAYPAL_ALERT_COOLDOWN_DEV", "3h")
+	t.Setenv("PAYPAL_ALERT_SMS_TO_DEV", "(512) 555-0142, not a number")
+	t.Setenv("TWILIO_ACCOUNT_SID_DEV", "ACtest")
+	t.Setenv("TWILIO_AUTH_TOKEN_DEV", "secret")
+	t.Setenv("TWILIO_FROM_DEV", "+15125550199")
+	h := NewHarness(t)
+	fake := clock.NewFake(time.Now().Add(24 * time.Hour)) // past any cool-down earlier tests started
+	previous := clock.Set(fake)
+	t.Cleanup(func() { clock.Set(previous) })
+
+	// Start from a healthy endpoint, whatever earlier tests left behind
+	_, err := payment.GetPayPalAccessToken(context.Background())
+	h.AssertNoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	alerts := newAlertRecorder(t)
+
+	fetchToken := func() {
+		t.Helper()
+		if _, err := payment.GetPayPalAccessToken(context.Background()); err == nil {
+			t.Fatalf("expected the token request to fail")
+		}
+	}
+
+	fake.Advance(2 * time.Hour) // past the cached token's expiry
+	h.PayPal.SetFailureMode(true, false, false)
+
+	fetchToken()
+	alerts.take(t, 0)
+
+	fetchToken()
+	got := alerts.take(t, 1)
+	if !strings.HasPrefix(got[0], "email ") || !strings.Contains(got[0], "PayPal authentication failing: 2 consecutive failures") {
+		t.Errorf("expected an email to the alert mailbox, got %q", got[0])
+	}
+
+	fetchToken()
+	alerts.take(t, 0)
+
+	fetchToken()
+	got = alerts.take(t, 3)
+	joined := strings.Join(got, "\n")
+	for _, want := range []string{"chat paypal.failing", "push paypal.failing", "sms +15125550142"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected a page to %s, got %q", want, got)
+		}
+	}
+	if !strings.Contains(joined, "4 consecutive failures") || !strings.Contains(joined, "HTTP 401") {
+		t.Errorf("expected the page to say how bad it is, got %q", got)
+	}
+
+	fetchToken()
+	alerts.take(t, 0)
+
+	// Recovery is announced to everyone who was alerted
+	h.PayPal.SetFailureMode(false, false, false)
+	_, err = payment.GetPayPalAccessToken(context.Background())
+	h.AssertNoError(t, err)
+	got = alerts.take(t, 4)
+	if !strings.Contains(strings.Join(got, "\n"), "recovered after 5 consecutive failures") {
+		t.Errorf("expected a recovery notice, got %q", got)
+	}
+
+	// A new run within the cool-down doesn't email again, but one after it does
+	fake.Advance(2 * time.Hour)
+	h.PayPal.SetFailureMode(true, false, false)
+	fetchToken()
+	fetchToken()
+	alerts.take(t, 0)
+
+	fake.Advance(2 * time.Hour)
+	fetchToken()
+	got = alerts.take(t, 1)
+	if !strings.Contains(got[0], "3 consecutive failures") {
+		t.Errorf("expected an email once the cool-down passed, got %q", got[0])
+	}
+
+	// That run only reached the mailbox, so only the mailbox hears it recovered
+	h.PayPal.SetFailureMode(false, false, false)
+	_, err = payment.GetPayPalAccessToken(context.Background())
+	h.AssertNoError(t, err)
+	alerts.take(t, 1)
+}
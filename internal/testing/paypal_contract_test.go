@@ -0,0 +1,179 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypal"
+)
+
+// Contract tests run our real PayPal client code against the PayPal sandbox, so request
+// shapes are checked against the live API rather than the mock. They are opt-in:
+//
+//	PAYPAL_SANDBOX_CLIENT_ID=... PAYPAL_SANDBOX_CLIENT_SECRET=... go test ./internal/testing -run Contract -paypal
+//
+// Dedicated variables keep a developer's live PAYPAL_CLIENT_ID out of these tests.
+
+const paypalSandboxBase = "https://api.sandbox.paypal.com"
+
+// usePayPalSandbox skips unless -paypal is set, then points the payment package at the sandbox
+func usePayPalSandbox(t *testing.T) {
+	if !*runPayPal {
+		t.Skip("PayPal sandbox contract tests need -paypal")
+	}
+	id, secret := os.Getenv("PAYPAL_SANDBOX_CLIENT_ID"), os.Getenv("PAYPAL_SANDBOX_CLIENT_SECRET")
+	if id == "" || secret == "" {
+		t.Fatal("-paypal needs PAYPAL_SANDBOX_CLIENT_ID and PAYPAL_SANDBOX_CLIENT_SECRET")
+	}
+
+	previousBase, previousID, previousSecret := config.APIBase(), config.ClientID(), config.ClientSecret()
+	config.SetPayPalAPI(paypalSandboxBase, id, secret)
+	t.Cleanup(func() {
+		config.SetPayPalAPI(previousBase, previousID, previousSecret)
+	})
+}
+
+func TestPayPalContractCreateOrder(t *testing.T) {
+	usePayPalSandbox(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	token, err := payment.GetPayPalAccessToken(ctx)
+	if err != nil {
+		t.Fatalf("sandbox rejected our OAuth request: %v", err)
+	}
+
+	formID := fmt.Sprintf("contract-%d", time.Now().UnixNano())
+	created, err := payment.CreatePayPalOrder(token, payment.NewOrderRequest(formID, "Contract test order", 12.34, "USD"))
+	if err != nil {
+		t.Fatalf("sandbox rejected our create-order body: %v", err)
+	}
+	orderID := created.ID
+	if orderID == "" || created.Status != paypal.StatusCreated {
+		t.Fatalf("unexpected create-order response: %s", created.Raw)
+	}
+
+	// The fields recovery and webhooks depend on must round-trip
+	details, err := payment.GetPayPalOrderDetails(orderID, token)
+	if err != nil {
+		t.Fatalf("failed to fetch order details: %v", err)
+	}
+	if len(details.PurchaseUnits) == 0 {
+		t.Fatalf("order has no purchase units: %s", details.Raw)
+	}
+	if details.InvoiceID() != formID {
+		t.Errorf("expected invoice_id %s, got %s", formID, details.InvoiceID())
+	}
+	if amount := details.PurchaseUnits[0].Amount; amount == nil || amount.Value != "12.34" || amount.CurrencyCode != "USD" {
+		t.Errorf("unexpected amount: %+v", amount)
+	}
+
+	// An unapproved order must be refused, not captured
+	if _, err := payment.CapturePayPalOrder(ctx, orderID, token); err == nil {
+		t.Error("expected capture of an unapproved order to fail")
+	}
+}
+
+func TestPayPalContractCaptureAndRefund(t *testing.T) {
+	usePayPalSandbox(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	token, err := payment.GetPayPalAccessToken(ctx)
+	if err != nil {
+		t.Fatalf("sandbox rejected our OAuth request: %v", err)
+	}
+
+	// Approval normally happens in the buyer's browser; a sandbox card stands in for it
+	formID := fmt.Sprintf("contract-%d", time.Now().UnixNano())
+	orderID, status := createCardFundedOrder(t, token, formID, 20.00)
+
+	captureJSON := ""
+	if status == "APPROVED" {
+		captureJSON, err = payment.CapturePayPalOrder(ctx, orderID, token)
+		if err != nil {
+			t.Fatalf("sandbox rejected our capture request: %v", err)
+		}
+	} else {
+		details, err := payment.GetPayPalOrderDetails(orderID, token)
+		if err != nil {
+			t.Fatalf("failed to fetch order details: %v", err)
+		}
+		captureJSON = string(details.Raw)
+	}
+
+	captured, err := paypal.ParseOrder([]byte(captureJSON))
+	if err != nil {
+		t.Fatalf("capture response is not an order: %v", err)
+	}
+	capture := captured.FirstCapture()
+	if capture == nil {
+		t.Fatalf("no capture in response: %s", captureJSON)
+	}
+	if capture.ID == "" || capture.Status != paypal.StatusCompleted {
+		t.Fatalf("unexpected capture: %+v", capture)
+	}
+
+	// Partial refund, as the event order change flow issues
+	refund, err := payment.RefundPayPalCapture(token, capture.ID, 5.00, "USD", "Contract test refund")
+	if err != nil {
+		t.Fatalf("sandbox rejected our refund request: %v", err)
+	}
+	if refund.ID == "" || (refund.Status != "COMPLETED" && refund.Status != "PENDING") {
+		t.Errorf("unexpected refund response: %s", refund.Raw)
+	}
+}
+
+// createCardFundedOrder creates an order paid with a sandbox test card, using the same
+// purchase unit our checkout sends. It skips when the sandbox app can't process cards.
+func createCardFundedOrder(t *testing.T, token, formID string, amount float64) (string, string) {
+	body := payment.NewOrderRequest(formID, "Contract test order", amount, "USD")
+	body.PaymentSource = map[string]paypal.PaymentSource{
+		"card": {
+			Number: "4111111111111111",
+			Expiry: fmt.Sprintf("%d-12", time.Now().Year()+3),
+			Name:   "Contract Test",
+		},
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to marshal order: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, paypalSandboxBase+"/v2/checkout/orders", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to build order request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	req.Header.Set("PayPal-Request-Id", formID) // required when a payment source is supplied
+
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("card order request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusUnprocessableEntity && bytes.Contains(respBody, []byte("NOT_ENABLED")) {
+		t.Skipf("sandbox app is not enabled for card payments: %s", respBody)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		t.Fatalf("card order returned %d: %s", resp.StatusCode, respBody)
+	}
+
+	order, err := paypal.ParseOrder(respBody)
+	if err != nil || order.ID == "" {
+		t.Fatalf("unexpected card order response: %s", respBody)
+	}
+	return order.ID, order.Status
+}
@@ -0,0 +1,116 @@
+package testing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypalsim"
+)
+
+func TestPayPalSimulatorCheckout(t *testing.T) {
+	h := NewHarness(t)
+	h.DisableTokenRateLimit(t)
+
+	var sim *paypalsim.Simulator
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sim.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	previousWebhookID := config.PayPalWebhookID
+	config.PayPalWebhookID = "SIMULATOR"
+	t.Cleanup(func() { config.PayPalWebhookID = previousWebhookID })
+	sim = paypalsim.New(paypalsim.Options{
+		PublicURL:    server.URL,
+		WebhookDelay: 10 * time.Millisecond,
+		WebhookID:    config.PayPalWebhookID,
+		Webhooks:     h.Server.Config.Handler,
+	})
+	config.SetPayPalAPI(server.URL, "simulator", "simulator")
+
+	checkout := func(amount float64) (data.MembershipSubmission, payment.CreateOrderResponse) {
+		t.Helper()
+		submission := h.GenerateTestMembership().ToMembershipSubmission()
+		submission.CalculatedAmount = amount
+		h.AssertNoError(t, data.InsertMembership(submission))
+		resp, err := h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": submission.FormID}, submission.AccessToken)
+		h.AssertNoError(t, err)
+		var created struct {
+			Data payment.CreateOrderResponse `json:"data"`
+		}
+		h.AssertStatusCode(t, resp, http.StatusOK)
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+		if created.Data.OrderID == "" || !strings.Contains(created.Data.ApproveURL, "/checkoutnow?token="+created.Data.OrderID) {
+			t.Fatalf("expected a simulator order with its checkout page, got %+v", created.Data)
+		}
+		return submission, created.Data
+	}
+	capture := func(submission data.MembershipSubmission, orderID string) int {
+		t.Helper()
+		resp, err := h.MakeAPIRequest("POST", "/api/capture-order",
+			map[string]string{"formID": submission.FormID, "orderID": orderID}, submission.AccessToken)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	approve := func(created payment.CreateOrderResponse) {
+		t.Helper()
+		resp, err := http.PostForm(created.ApproveURL, url.Values{"action": {"approve"}})
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		h.AssertStatusCode(t, resp, http.StatusOK)
+	}
+
+	t.Run("CaptureAndWebhook", func(t *testing.T) {
+		submission, created := checkout(60)
+		approve(created)
+		if status := capture(submission, created.OrderID); status != http.StatusOK {
+			t.Fatalf("expected the approved order captured, got %d", status)
+		}
+		paid, err := data.GetMembershipByID(submission.FormID)
+		h.AssertNoError(t, err)
+		if paid.PayPalStatus != "COMPLETED" || !strings.Contains(paid.PayPalDetails, "SIMCAP") {
+			t.Fatalf("expected the simulator's capture recorded, got %q", paid.PayPalStatus)
+		}
+
+		// The capture webhook follows, signed so our handler's verification accepts it
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var delivered bool
+			for _, sent := range h.Mailer.Sent() {
+				delivered = delivered || (sent.Subject == "PayPal Webhook: PAYMENT.CAPTURE.COMPLETED" && strings.Contains(sent.Body, submission.FormID))
+			}
+			if delivered {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected the capture webhook verified and processed")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+
+	t.Run("LostCaptureResponse", func(t *testing.T) {
+		h.AssertNoError(t, sim.SetFailure(paypalsim.FailCaptureResponse, true))
+		defer sim.SetFailure(paypalsim.FailCaptureResponse, false)
+		if err := sim.SetFailure("meteor", true); err == nil {
+			t.Error("expected an unknown failure refused")
+		}
+
+		// The retried capture gets the response the first one lost, as from PayPal
+		submission, created := checkout(35)
+		approve(created)
+		if status := capture(submission, created.OrderID); status != http.StatusOK {
+			t.Fatalf("expected the capture retried to success, got %d", status)
+		}
+		if paid, _ := data.GetMembershipByID(submission.FormID); paid.PayPalStatus != "COMPLETED" {
+			t.Errorf("expected the membership paid, got %q", paid.PayPalStatus)
+		}
+	})
+}
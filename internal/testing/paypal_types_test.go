@@ -0,0 +1,92 @@
+package testing
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypal"
+)
+
+func TestPayPalTypes(t *testing.T) {
+	corpus := func(name string) []byte {
+		t.Helper()
+		raw, err := os.ReadFile(filepath.Join(webhookCorpusDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		var event struct {
+			Resource json.RawMessage `json:"resource"`
+		}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			t.Fatalf("failed to parse %s: %v", name, err)
+		}
+		return event.Resource
+	}
+
+	capture, err := paypal.ParseCapture(corpus("capture_completed.json"))
+	if err != nil {
+		t.Fatalf("failed to parse capture: %v", err)
+	}
+	if capture.ID != "3C679366HH908993F" || capture.Amount.Amount() != 75 || capture.Fee() != 1.98 ||
+		capture.OrderID() != "5O190127TN364715T" ||
+		capture.SelfURL() != "https://api.sandbox.paypal.com/v2/payments/captures/3C679366HH908993F" {
+		t.Errorf("unexpected capture: %+v", capture)
+	}
+
+	refund, err := paypal.ParseRefund(corpus("refund_partial.json"))
+	if err != nil {
+		t.Fatalf("failed to parse refund: %v", err)
+	}
+	if refund.ID != "5WR24939AH5913026" || refund.RefundedTotal() != 10 || len(refund.Raw) == 0 {
+		t.Errorf("unexpected refund: %+v", refund)
+	}
+
+	// A capture response as stored in paypal_details
+	details := `{"id": "5O190127TN364715T", "status": "COMPLETED",
+		"payer": {"email_address": "parent@example.com"},
+		"payment_source": {"venmo": {"email_address": "parent@example.com"}},
+		"purchase_units": [{"invoice_id": "membership-1", "payments": {"captures": [` +
+		string(corpus("capture_completed.json")) + `]}}]}`
+	order, err := paypal.ParseOrder([]byte(details))
+	if err != nil {
+		t.Fatalf("failed to parse order: %v", err)
+	}
+	if order.InvoiceID() != "membership-1" || order.PayerEmail() != "parent@example.com" ||
+		order.FundingSource() != "venmo" || order.FirstCapture() == nil || string(order.Raw) != details {
+		t.Errorf("unexpected order: %+v", order)
+	}
+	if captureID := data.ExtractPayPalCaptureID(details, "membership-1"); captureID != "3C679366HH908993F" {
+		t.Errorf("expected the capture ID from stored details, got %q", captureID)
+	}
+	if source := data.FundingSource(details); source != "venmo" {
+		t.Errorf("expected the venmo funding source, got %q", source)
+	}
+
+	// Missing pieces read as zero values rather than panicking
+	empty, err := paypal.ParseOrder([]byte(`{"id": "X"}`))
+	if err != nil {
+		t.Fatalf("failed to parse order: %v", err)
+	}
+	if empty.FirstCapture() != nil || empty.InvoiceID() != "" || empty.PayerEmail() != "" || empty.ApproveURL() != "" {
+		t.Errorf("expected an empty order to read as empty, got %+v", empty)
+	}
+	if _, err := paypal.ParseOrder([]byte(`{"purchase_units": "nope"}`)); err == nil {
+		t.Error("expected a malformed order rejected")
+	}
+
+	// Order requests serialize to the body PayPal documents
+	body, err := json.Marshal(payment.WithFundingSource(payment.NewOrderRequest("event-1", "Spring Festival", 45, "USD"), payment.FundingVenmo))
+	if err != nil {
+		t.Fatalf("failed to marshal order request: %v", err)
+	}
+	want := `{"intent":"CAPTURE","purchase_units":[{"description":"Spring Festival","invoice_id":"event-1",` +
+		`"amount":{"currency_code":"USD","value":"45.00"}}],` +
+		`"payment_source":{"venmo":{"experience_context":{"shipping_preference":"NO_SHIPPING"}}}}`
+	if string(body) != want {
+		t.Errorf("unexpected order request:\n got %s\nwant %s", body, want)
+	}
+}
@@ -0,0 +1,174 @@
+package testing
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+)
+
+func TestPersonalDetailsEncryptedAtRest(t *testing.T) {
+	h := NewHarness(t)
+
+	// Seeded before the key is set, as a deployment's existing rows are
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	_, err = data.IssueCredit("Jane.Smith@example.com", 20, "Cancelled concert")
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, data.SetNotificationPreference("jane.smith@example.com", "reminders", []string{"email"}, time.Now()))
+	_, err = data.CreatePrivacyRequest("jane.smith@example.com", "pii-request-token", time.Now(), time.Now().Add(time.Hour))
+	h.AssertNoError(t, err)
+
+	key := bytes.Repeat([]byte{7}, data.PIIKeySize)
+	h.AssertNoError(t, data.SetPIIKey(key))
+	t.Cleanup(func() { data.SetPIIKey(nil) })
+
+	conn, err := data.GetDB()
+	h.AssertNoError(t, err)
+	// plaintext counts the submission rows holding a detail in the clear
+	plaintext := func(detail string) int {
+		t.Helper()
+		var count int
+		h.AssertNoError(t, conn.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM membership_submissions WHERE full_name || email || students_json LIKE ?)
+				+ (SELECT COUNT(*) FROM event_submissions WHERE full_name || email || students_json LIKE ?)
+				+ (SELECT COUNT(*) FROM fundraiser_submissions WHERE full_name || email || students_json LIKE ?)`,
+			"%"+detail+"%", "%"+detail+"%", "%"+detail+"%").Scan(&count))
+		return count
+	}
+	// contactPlaintext counts the rows of the tables keeping a family's address that
+	// hold a detail in the clear, in the address or in what it's looked up by
+	contactPlaintext := func(detail string) int {
+		t.Helper()
+		var count int
+		h.AssertNoError(t, conn.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM households WHERE email || email_index LIKE ?1)
+				+ (SELECT COUNT(*) FROM credits WHERE email || email_index LIKE ?1)
+				+ (SELECT COUNT(*) FROM notification_preferences WHERE contact || contact_index LIKE ?1)
+				+ (SELECT COUNT(*) FROM privacy_requests WHERE email || email_index LIKE ?1)
+				+ (SELECT COUNT(*) FROM donations WHERE email LIKE ?1)`,
+			"%"+detail+"%").Scan(&count))
+		return count
+	}
+
+	changed, err := data.EncryptPersonalDetails()
+	h.AssertNoError(t, err)
+	if changed == 0 || plaintext("example.com") != 0 || plaintext("Emma") != 0 {
+		t.Fatalf("expected every seeded name, email and student encrypted, changed %d rows", changed)
+	}
+	if again, err := data.EncryptPersonalDetails(); err != nil || again != 0 {
+		t.Errorf("expected encrypting twice to change nothing, changed %d: %v", again, err)
+	}
+	if n := contactPlaintext("example.com"); n != 0 {
+		t.Errorf("expected every contact address encrypted, %d rows in the clear", n)
+	}
+
+	// The addresses are still found by the blind index and read back in the clear
+	if balance, err := data.CreditBalance("jane.smith@example.com"); err != nil || balance != 20 {
+		t.Errorf("expected the sealed credit found, got %.2f: %v", balance, err)
+	}
+	_, err = data.IssueCredit("JANE.SMITH@example.com", 5, "Gift certificate")
+	h.AssertNoError(t, err)
+	credits, err := data.ListCredits("jane.smith@example.com")
+	h.AssertNoError(t, err)
+	if len(credits) != 2 || credits[1].Email != "jane.smith@example.com" {
+		t.Errorf("expected both credits read back in plaintext, got %+v", credits)
+	}
+	h.AssertNoError(t, data.SetNotificationPreference(" Jane.Smith@example.com", "reminders", []string{"sms"}, time.Now()))
+	preference, err := data.GetNotificationPreference("jane.smith@example.com", "reminders")
+	h.AssertNoError(t, err)
+	if preference == nil || preference.Contact != "jane.smith@example.com" || strings.Join(preference.Channels, ",") != "sms" {
+		t.Errorf("expected the sealed preference replaced, got %+v", preference)
+	}
+	request, err := data.GetPrivacyRequestByToken("pii-request-token")
+	h.AssertNoError(t, err)
+	if request == nil || request.Email != "jane.smith@example.com" {
+		t.Errorf("expected the privacy request read back in plaintext, got %+v", request)
+	}
+	if latest, err := data.LatestPrivacyRequestAt("jane.smith@example.com"); err != nil || latest == nil {
+		t.Errorf("expected the sealed privacy request found, got %v: %v", latest, err)
+	}
+	h.AssertNoError(t, data.CreateDonation(data.Donation{
+		FormID: "donation-pii", Email: "jane.smith@example.com", Amount: 10, CreatedAt: time.Now(),
+	}))
+	if donation, err := data.GetDonation("donation-pii"); err != nil || donation.Email != "jane.smith@example.com" {
+		t.Errorf("expected the donation read back in plaintext, got %+v: %v", donation, err)
+	}
+	if n := contactPlaintext("example.com"); n != 0 {
+		t.Errorf("expected new contact addresses encrypted, %d rows in the clear", n)
+	}
+
+	// Reads, lookups and search see the details as they were
+	results, err := data.SearchSubmissions("emm", 0)
+	h.AssertNoError(t, err)
+	if len(results) != 1 || results[0].FullName != "Jane Smith" || results[0].Email != "jane.smith@example.com" {
+		t.Fatalf("expected Emma's student to find Jane Smith's membership, got %+v", results)
+	}
+	listed, err := data.ListSubmissions(data.SubmissionFilter{Search: "SMITH"})
+	h.AssertNoError(t, err)
+	if len(listed) != 1 {
+		t.Errorf("expected the name filter to match the sealed name, got %+v", listed)
+	}
+	byEmail, err := data.ListSubmissionsByEmail(" Jane.Smith@example.com")
+	h.AssertNoError(t, err)
+	if len(byEmail) != 1 {
+		t.Errorf("expected the email lookup to match the sealed email, got %+v", byEmail)
+	}
+
+	// A new submission is sealed as it's written and still joins its family's household
+	h.AssertNoError(t, data.InsertMembership(data.MembershipSubmission{
+		FormID:         "membership-pii-sibling",
+		SubmissionDate: time.Now(),
+		FullName:       "Janet Smith",
+		Email:          "jane.smith@example.com",
+		Students:       []data.Student{{Name: "Olivia Smith", Grade: "3"}},
+	}))
+	if plaintext("Olivia") != 0 || plaintext("Janet") != 0 {
+		t.Error("expected the new membership's details encrypted")
+	}
+	sibling, err := data.GetMembershipByID("membership-pii-sibling")
+	h.AssertNoError(t, err)
+	if sibling.FullName != "Janet Smith" || len(sibling.Students) != 1 || sibling.Students[0].Name != "Olivia Smith" {
+		t.Errorf("expected the membership to read back in plaintext, got %+v", sibling)
+	}
+	household, err := data.GetHouseholdByEmail("jane.smith@example.com")
+	h.AssertNoError(t, err)
+	if household == nil || household.Email != "jane.smith@example.com" {
+		t.Fatalf("expected the Smiths' household found by its sealed address, got %+v", household)
+	}
+	orders, err := data.ListHouseholdOrders(household.ID)
+	h.AssertNoError(t, err)
+	if len(orders) != 2 {
+		t.Errorf("expected both of the Smiths' memberships in their household, got %+v", orders)
+	}
+	if results, _ := data.SearchSubmissions("olivia", 0); len(results) != 1 {
+		t.Errorf("expected the new membership indexed for search, got %+v", results)
+	}
+
+	// Without the key the details can't be read
+	h.AssertNoError(t, data.SetPIIKey(nil))
+	if _, err := data.GetMembershipByID("membership-pii-sibling"); !errors.Is(err, data.ErrPIIKeyMissing) {
+		t.Errorf("expected reading without the key to fail, got %v", err)
+	}
+	h.AssertNoError(t, data.SetPIIKey(key))
+
+	// Decrypting puts everything back in the clear and stops encrypting
+	_, err = data.DecryptPersonalDetails()
+	h.AssertNoError(t, err)
+	if data.PIIEncryptionEnabled() || plaintext("Olivia") != 1 || plaintext("example.com") == 0 {
+		t.Error("expected every detail decrypted and encryption off")
+	}
+	if results, _ := data.SearchSubmissions("olivia smith", 0); len(results) != 1 || !strings.HasPrefix(results[0].FormID, "membership-pii") {
+		t.Errorf("expected the plaintext index rebuilt, got %+v", results)
+	}
+	if balance, err := data.CreditBalance("jane.smith@example.com"); err != nil || balance != 25 {
+		t.Errorf("expected the credits found by their plaintext index, got %.2f: %v", balance, err)
+	}
+	if household, err := data.GetHouseholdByEmail("jane.smith@example.com"); err != nil || household == nil {
+		t.Errorf("expected the household found by its plaintext index, got %+v: %v", household, err)
+	}
+}
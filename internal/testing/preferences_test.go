@@ -0,0 +1,64 @@
+package testing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/preferences"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	h := NewHarness(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	const jane, priya = "jane.smith@example.com", "priya.patel@example.com"
+	if !preferences.Allows(jane, preferences.Receipts, preferences.Email) {
+		t.Errorf("expected emailed receipts by default")
+	}
+	if preferences.Allows(jane, preferences.Marketing, preferences.Email) {
+		t.Errorf("expected marketing to be opt-in")
+	}
+	if err := preferences.Set(jane, preferences.Receipts, "fax"); err == nil {
+		t.Errorf("expected an unknown channel to be rejected")
+	}
+
+	// Preferences are keyed by the address however it was typed
+	h.AssertNoError(t, preferences.Set("Jane.Smith@Example.com ", preferences.Receipts, "sms"))
+	h.AssertNoError(t, order.ResendConfirmationEmail("membership-seed-001"))
+	if sent := h.Mailer.SentTo(jane); len(sent) != 0 {
+		t.Errorf("expected no receipt email after opting out, got %d", len(sent))
+	}
+
+	h.AssertNoError(t, preferences.Set(jane, preferences.Receipts, "default"))
+	h.AssertNoError(t, order.ResendConfirmationEmail("membership-seed-001"))
+	if sent := h.Mailer.SentTo(jane); len(sent) != 1 {
+		t.Errorf("expected the receipt email once the default is back, got %d", len(sent))
+	}
+
+	// A payer with reminders off is still marked reminded, so abandonment stays on schedule
+	unpaid := h.GenerateTestMembership().ToMembershipSubmission()
+	unpaid.FormID, unpaid.Email, unpaid.Submitted, unpaid.PayPalStatus = "membership-unpaid-prefs", priya, false, ""
+	unpaid.SubmissionDate = time.Now().Add(-48 * time.Hour)
+	h.AssertNoError(t, data.InsertMembership(unpaid))
+	h.AssertNoError(t, preferences.Set(priya, preferences.Reminders, "none"))
+	job := cleanup.NewAbandonedCheckoutJob(cleanup.AbandonedCheckoutPolicy{ReminderAge: 0, AbandonAfter: 24 * time.Hour})
+	h.AssertNoError(t, job(context.Background()))
+	if sent := h.Mailer.SentTo(priya); len(sent) != 0 {
+		t.Errorf("expected no reminder after opting out, got %d", len(sent))
+	}
+	toAbandon, err := data.GetCheckoutsToAbandon(time.Now().Add(time.Minute), 50)
+	h.AssertNoError(t, err)
+	found := false
+	for _, checkout := range toAbandon {
+		found = found || checkout.FormID == unpaid.FormID
+	}
+	if !found {
+		t.Errorf("expected the opted-out checkout to be recorded as reminded")
+	}
+}
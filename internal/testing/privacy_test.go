@@ -0,0 +1,314 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/email"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/privacy"
+	"sbcbackend/internal/security"
+)
+
+var privacyCodePattern = regexp.MustCompile(`/api/privacy\?code=([A-Za-z0-9_-]+)`)
+
+func TestPrivacyRequests(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "https://booster.example.org")
+	h := NewHarness(t)
+	fake := useFakeClock(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	// Jane has a paid seeded membership; give her an unpaid one too, in another case
+	form := url.Values{
+		"csrf_token":    {security.GenerateCSRFToken()},
+		"form_type":     {"membership"},
+		"full_name":     {"Jane Q. Smith"},
+		"email":         {"Jane.Smith@Example.com"},
+		"school":        {"lincoln-elementary"},
+		"student_count": {"0"},
+	}
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/submit-form", strings.NewReader(form.Encode()))
+	h.AssertNoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "10.0.7.1")
+	resp, err := h.Client.Do(req)
+	h.AssertNoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	match := formIDPattern.FindSubmatch(body)
+	if match == nil {
+		t.Fatalf("expected the submission to be accepted, got %d: %s", resp.StatusCode, body)
+	}
+	unpaidID := string(match[1])
+
+	paid, err := data.ListSubmissions(data.SubmissionFilter{Search: "jane.smith@example.com", Status: "paid"})
+	h.AssertNoError(t, err)
+	if len(paid) != 1 {
+		t.Fatalf("expected Jane's seeded membership, got %+v", paid)
+	}
+	paidID := paid[0].FormID
+	paidBefore, err := data.GetMembershipByID(paidID)
+	h.AssertNoError(t, err)
+
+	request := func(emailAddress string, withCSRF bool) int {
+		t.Helper()
+		form := url.Values{"email": {emailAddress}}
+		if withCSRF {
+			form.Set("csrf_token", security.GenerateCSRFToken())
+		}
+		resp, err := h.Client.PostForm(h.Server.URL+"/api/privacy-request", form)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := request("jane.smith@example.com", false); code != http.StatusForbidden {
+		t.Errorf("expected a request without a CSRF token to be refused, got %d", code)
+	}
+	if code := request("not an address", true); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid address to be refused, got %d", code)
+	}
+	if code := request("nobody@example.com", true); code != http.StatusOK {
+		t.Errorf("expected an unknown address to get the usual answer, got %d", code)
+	}
+	if sent := h.Mailer.SentTo("nobody@example.com"); len(sent) != 0 {
+		t.Errorf("expected no link for an address without submissions, got %d", len(sent))
+	}
+
+	if code := request(" JANE.SMITH@example.com ", true); code != http.StatusOK {
+		t.Fatalf("expected the request to be accepted, got %d", code)
+	}
+	request("jane.smith@example.com", true) // within the resend interval
+	sent := h.Mailer.SentTo("jane.smith@example.com")
+	if len(sent) != 1 {
+		t.Fatalf("expected one verification email, got %d", len(sent))
+	}
+	match = privacyCodePattern.FindSubmatch([]byte(sent[0].Body))
+	if match == nil || !strings.Contains(sent[0].Body, "https://booster.example.org/api/privacy?code=") {
+		t.Fatalf("expected a verification link, got %q", sent[0].Body)
+	}
+	code := string(match[1])
+
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := h.Client.Get(h.Server.URL + path)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body = get("/api/privacy?code=" + code)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Basic Membership") {
+		t.Fatalf("expected the privacy page to list Jane's submissions, got %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the privacy page to stay out of caches")
+	}
+
+	resp, body = get("/api/privacy/export?format=json&code=" + code)
+	var export privacy.Export
+	h.AssertNoError(t, json.Unmarshal(body, &export))
+	if resp.StatusCode != http.StatusOK || len(export.Submissions) != 2 || export.Email != "jane.smith@example.com" {
+		t.Fatalf("expected both of Jane's submissions in the export, got %d: %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), paidBefore.AccessToken) {
+		t.Errorf("expected the export to leave out access tokens")
+	}
+	if !strings.Contains(string(body), "Emma Smith") {
+		t.Errorf("expected the export to include Jane's students")
+	}
+
+	resp, body = get("/api/privacy/export?format=pdf&code=" + code)
+	if !strings.HasPrefix(string(body), "%PDF-") || !strings.Contains(string(body), "Student: Emma Smith, grade 3") ||
+		resp.Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("expected a PDF of the export, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	// Deleting anonymizes the unpaid submission at once and holds the paid one for review
+	deleteData := func() string {
+		t.Helper()
+		resp, err := h.Client.PostForm(h.Server.URL+"/api/privacy", url.Values{
+			"code":       {code},
+			"csrf_token": {security.GenerateCSRFToken()},
+		})
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected the deletion page, got %d: %s", resp.StatusCode, body)
+		}
+		return string(body)
+	}
+	if page := deleteData(); !strings.Contains(page, "A board member will review the rest") {
+		t.Errorf("expected the page to say the paid submission waits for review, got %s", page)
+	}
+	unpaid, err := data.GetMembershipByID(unpaidID)
+	h.AssertNoError(t, err)
+	if strings.Contains(unpaid.Email, "@") || unpaid.FullName != "Anonymized" {
+		t.Errorf("expected the unpaid submission to be anonymized, got %q <%s>", unpaid.FullName, unpaid.Email)
+	}
+	held, err := data.GetMembershipByID(paidID)
+	h.AssertNoError(t, err)
+	if held.Email != paidBefore.Email {
+		t.Errorf("expected the paid submission to wait for review")
+	}
+	review := h.Mailer.SentTo(email.LoadEmailConfig().AlertRecipient)
+	if len(review) != 1 || !strings.Contains(review[0].Body, paidID) || !strings.Contains(review[0].Body, "boosterctl privacy approve") {
+		t.Fatalf("expected admins to be asked to review %s, got %+v", paidID, review)
+	}
+	if page := deleteData(); !strings.Contains(page, "You already asked") {
+		t.Errorf("expected a second deletion to be refused, got %s", page)
+	}
+
+	pending, err := data.ListPrivacyRequests(data.PrivacyPendingReview)
+	h.AssertNoError(t, err)
+	if len(pending) != 1 {
+		t.Fatalf("expected one request waiting for review, got %+v", pending)
+	}
+	h.AssertNoError(t, privacy.Approve(context.Background(), pending[0].ID, "tester"))
+
+	anonymized, err := data.GetMembershipByID(paidID)
+	h.AssertNoError(t, err)
+	if strings.Contains(anonymized.Email, "@") || len(anonymized.Students) != len(paidBefore.Students) ||
+		anonymized.Students[0].Name != "" || anonymized.Students[0].Grade != paidBefore.Students[0].Grade ||
+		strings.Contains(anonymized.PayPalDetails, `"payer"`) {
+		t.Errorf("expected the paid submission to be anonymized, got %+v", anonymized)
+	}
+	if anonymized.Email != unpaid.Email {
+		t.Errorf("expected both of Jane's submissions to keep the same hash of her email, got %q and %q", anonymized.Email, unpaid.Email)
+	}
+	if anonymized.ReceiptNumber != paidBefore.ReceiptNumber || anonymized.CalculatedAmount != paidBefore.CalculatedAmount ||
+		data.ExtractPayPalCaptureID(anonymized.PayPalDetails, paidID) != data.ExtractPayPalCaptureID(paidBefore.PayPalDetails, paidID) {
+		t.Errorf("expected the books to keep the amount, receipt number and capture")
+	}
+	if sent := h.Mailer.SentTo("jane.smith@example.com"); len(sent) != 2 || !strings.Contains(sent[1].Subject, "deleted") {
+		t.Errorf("expected Jane to hear her data was deleted, got %+v", sent)
+	}
+	if err := privacy.Approve(context.Background(), pending[0].ID, "tester"); err == nil {
+		t.Errorf("expected a decided request not to be approved again")
+	}
+
+	fake.Advance(25 * time.Hour)
+	if resp, _ := get("/api/privacy?code=" + code); resp.StatusCode != http.StatusGone {
+		t.Errorf("expected the link to expire after a day, got %d", resp.StatusCode)
+	}
+}
+
+func TestDataRetention(t *testing.T) {
+	t.Setenv("CLEANUP_PERSONAL_DETAILS_ENABLED_DEV", "true")
+	t.Setenv("CLEANUP_PERSONAL_DETAILS_RETENTION_DEV", "360h")
+	h := NewHarness(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	conn, err := data.GetDB()
+	h.AssertNoError(t, err)
+	// totals are what the books show: the amount and number of paid submissions
+	totals := func() (float64, int) {
+		t.Helper()
+		var amount float64
+		var count int
+		h.AssertNoError(t, conn.QueryRow(`SELECT COALESCE(SUM(calculated_amount), 0), COUNT(*) FROM submissions
+			WHERE paypal_status = 'COMPLETED'`).Scan(&amount, &count))
+		return amount, count
+	}
+	amountBefore, countBefore := totals()
+	sweep := cleanup.NewJob(cleanup.LoadPolicy(), cleanup.TargetPersonalDetails)
+
+	// Jane's membership and Mary's donation are older than the 15 days kept, but
+	// Mary's payment is disputed and stays until the dispute is settled
+	_, err = conn.Exec(`UPDATE fundraiser_submissions SET paypal_status = ? WHERE email = ?`,
+		data.DisputedStatus, "mary.johnson@example.com")
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, sweep(context.Background()))
+	if jane, _ := data.ListSubmissionsByEmail("jane.smith@example.com"); len(jane) != 0 {
+		t.Errorf("expected Jane's membership anonymized, got %+v", jane)
+	}
+	if mary, _ := data.ListSubmissionsByEmail("mary.johnson@example.com"); len(mary) != 1 {
+		t.Errorf("expected Mary's disputed donation kept, got %+v", mary)
+	}
+	if doe, _ := data.ListSubmissionsByEmail("john.doe@example.com"); len(doe) != 1 {
+		t.Errorf("expected the 14 day old registration kept, got %+v", doe)
+	}
+
+	_, err = conn.Exec(`UPDATE fundraiser_submissions SET paypal_status = 'COMPLETED' WHERE email = ?`, "mary.johnson@example.com")
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, sweep(context.Background()))
+	donations, err := data.ListSubmissions(data.SubmissionFilter{FormType: "fundraiser", Status: "paid"})
+	h.AssertNoError(t, err)
+	anonymized := 0
+	for _, summary := range donations {
+		if summary.FullName != "Anonymized" {
+			continue
+		}
+		anonymized++
+		donation, err := data.GetFundraiserByID(summary.FormID)
+		h.AssertNoError(t, err)
+		if len(donation.DonationItems) != 1 || donation.DonationItems[0].StudentName != "" || donation.DonationItems[0].Amount != 50 ||
+			len(donation.Students) != 1 || donation.Students[0].Name != "" || donation.Students[0].Grade != "5" {
+			t.Errorf("expected Mary's donation kept without names, got %+v", donation)
+		}
+	}
+	if anonymized != 1 {
+		t.Errorf("expected Mary's donation anonymized once its dispute was settled, got %d", anonymized)
+	}
+	if amount, count := totals(); amount != amountBefore || count != countBefore {
+		t.Errorf("expected the totals kept at $%.2f over %d, got $%.2f over %d", amountBefore, countBefore, amount, count)
+	}
+
+	// An admin can erase an address at once, paid submissions included
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	erase := func(method, token, payload string) (int, map[string]interface{}) {
+		t.Helper()
+		target := h.Server.URL + "/api/admin/privacy/erase"
+		if method == http.MethodGet {
+			target += "?email=" + url.QueryEscape(payload)
+			payload = ""
+		}
+		req, err := http.NewRequest(method, target, strings.NewReader(payload))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &body))
+		return resp.StatusCode, body.Data
+	}
+	if status, listed := erase(http.MethodGet, adminToken, "Carlos.Rivera@example.com"); status != http.StatusOK ||
+		len(listed["submissions"].([]interface{})) != 1 {
+		t.Errorf("expected Carlos's membership listed, got %d %v", status, listed)
+	}
+	if status, _ := erase(http.MethodPost, "not-a-token", `{"email": "carlos.rivera@example.com"}`); status != http.StatusForbidden {
+		t.Errorf("expected erasing to need an admin token, got %d", status)
+	}
+	if status, _ := erase(http.MethodPost, adminToken, `{"email": "carlos"}`); status != http.StatusBadRequest {
+		t.Errorf("expected an address that isn't one to be refused, got %d", status)
+	}
+	if status, erased := erase(http.MethodPost, adminToken, `{"email": " Carlos.Rivera@example.com"}`); status != http.StatusOK ||
+		erased["anonymized"] != float64(1) {
+		t.Errorf("expected Carlos's membership anonymized, got %d %v", status, erased)
+	}
+	if carlos, _ := data.ListSubmissionsByEmail("carlos.rivera@example.com"); len(carlos) != 0 {
+		t.Errorf("expected nothing left under Carlos's address, got %+v", carlos)
+	}
+	if amount, count := totals(); amount != amountBefore || count != countBefore {
+		t.Errorf("expected erasing to keep the totals, got $%.2f over %d", amount, count)
+	}
+}
@@ -0,0 +1,120 @@
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/progress"
+)
+
+func TestPublicProgress(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("FUNDRAISER_NAME_DEV", "Spring Strings Drive")
+	t.Setenv("FUNDRAISER_GOAL_DEV", "200")
+	t.Setenv("FUNDRAISER_START_DEV", "2031-03-01")
+	t.Setenv("FUNDRAISER_END_DEV", "2031-03-31")
+	t.Setenv("PROGRESS_RATE_LIMIT_DEV", "3")
+	h := NewHarness(t)
+	fake := clock.NewFake(time.Date(2031, time.March, 15, 12, 0, 0, 0, clock.Location()))
+	previous := clock.Set(fake)
+	t.Cleanup(func() { clock.Set(previous) })
+
+	during := time.Date(2031, time.March, 31, 20, 0, 0, 0, clock.Location())
+	for i, donation := range []struct {
+		at     time.Time
+		amount float64
+		status string
+	}{
+		{during, 50, "COMPLETED"},
+		{during.AddDate(0, 0, -20), 30, "COMPLETED"},
+		{during, 500, "CREATED"},                   // never paid
+		{during.AddDate(0, 0, 2), 70, "COMPLETED"}, // after the drive
+	} {
+		h.AssertNoError(t, data.InsertFundraiser(data.FundraiserSubmission{
+			FormID:           fmt.Sprintf("fundraiser-progress-%d", i),
+			SubmissionDate:   donation.at,
+			FullName:         "Private Donor",
+			Email:            "donor@example.com",
+			TotalAmount:      donation.amount,
+			CalculatedAmount: donation.amount + 1.75, // covered fees aren't raised
+			PayPalStatus:     donation.status,
+		}))
+	}
+	for i, level := range []string{"Basic Membership", "Basic Membership", "Gold Membership"} {
+		h.AssertNoError(t, data.InsertMembership(data.MembershipSubmission{
+			FormID:         fmt.Sprintf("membership-progress-%d", i),
+			SubmissionDate: fake.Now().AddDate(0, -1, 0),
+			FullName:       "Private Member",
+			Email:          "member@example.com",
+			Membership:     level,
+			PayPalStatus:   "COMPLETED",
+		}))
+	}
+
+	get := func(etag string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/progress", nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Forwarded-For", "10.0.9.1")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the progress numbers, got %d: %s", resp.StatusCode, body)
+	}
+	var snapshot progress.Snapshot
+	h.AssertNoError(t, json.Unmarshal(body, &snapshot))
+	if snapshot.Fundraiser == nil || snapshot.Fundraiser.Raised != 80 || snapshot.Fundraiser.Donations != 2 ||
+		snapshot.Fundraiser.Percent != 40 || snapshot.Fundraiser.Name != "Spring Strings Drive" {
+		t.Errorf("expected $80 of $200 raised by two donations, got %+v", snapshot.Fundraiser)
+	}
+	if snapshot.Memberships.Year != 2031 || snapshot.Memberships.Total != 3 || len(snapshot.Memberships.ByLevel) != 2 {
+		t.Errorf("expected three memberships at two levels, got %+v", snapshot.Memberships)
+	}
+	for _, private := range []string{"Private", "example.com"} {
+		if strings.Contains(string(body), private) {
+			t.Errorf("expected no personal details in the public numbers, found %q", private)
+		}
+	}
+	if resp.Header.Get("Cache-Control") != "public, max-age=300" || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected a publicly cacheable response, got %q / %q",
+			resp.Header.Get("Cache-Control"), resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	// Numbers are reused until the cache runs out
+	h.AssertNoError(t, data.InsertFundraiser(data.FundraiserSubmission{
+		FormID: "fundraiser-progress-late", SubmissionDate: during, Email: "late@example.com",
+		TotalAmount: 20, PayPalStatus: "COMPLETED",
+	}))
+	if resp, _ := get(resp.Header.Get("ETag")); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected the cached numbers to be unchanged, got %d", resp.StatusCode)
+	}
+	if resp, _ := get(""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the third request in the minute to be allowed, got %d", resp.StatusCode)
+	}
+	if resp, _ := get(""); resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected the fourth request in a minute to be limited, got %d", resp.StatusCode)
+	}
+
+	fake.Advance(6 * time.Minute)
+	resp, body = get("")
+	h.AssertNoError(t, json.Unmarshal(body, &snapshot))
+	if resp.StatusCode != http.StatusOK || snapshot.Fundraiser.Raised != 100 {
+		t.Errorf("expected fresh numbers after the cache expired, got %d: %s", resp.StatusCode, body)
+	}
+}
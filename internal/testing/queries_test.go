@@ -0,0 +1,47 @@
+// queries_test.go - Keeps the generated query layer in step with the migrations
+package testing
+
+import (
+	"os"
+	"testing"
+
+	"sbcbackend/internal/data"
+)
+
+const querySchemaPath = "../data/queries/schema.sql"
+
+// TestQuerySchemaIsCurrent checks the schema dump cmd/querygen types queries against is
+// the migrated schema; after a migration, rewrite it with -update and go generate
+func TestQuerySchemaIsCurrent(t *testing.T) {
+	db := NewTestDB(t)
+	schema, err := data.SchemaSQL(db.DB)
+	db.AssertNoError(t, err)
+
+	if *update {
+		if err := os.WriteFile(querySchemaPath, []byte(schema), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", querySchemaPath, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(querySchemaPath)
+	if err != nil {
+		t.Fatalf("Missing %s (run with -update to create it): %v", querySchemaPath, err)
+	}
+	if string(want) != schema {
+		t.Errorf("%s is out of date; run go test ./internal/testing -run QuerySchema -update, then go generate ./internal/data", querySchemaPath)
+	}
+}
+
+func TestGeneratedQueriesPrepare(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
+
+	for name, query := range data.GeneratedQueries() {
+		stmt, err := db.DB.Prepare(query)
+		if err != nil {
+			t.Errorf("%s doesn't match the migrated schema: %v", name, err)
+			continue
+		}
+		stmt.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package testing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypal"
+	"sbcbackend/internal/security"
+)
+
+func TestQuickDonate(t *testing.T) {
+	h := NewHarness(t)
+
+	donate := func(req payment.QuickDonateRequest) (int, payment.QuickDonateResponse) {
+		t.Helper()
+		resp, err := h.MakeAPIRequest("POST", "/api/quick-donate", req, "")
+		h.AssertNoError(t, err)
+		var body struct {
+			Data payment.QuickDonateResponse `json:"data"`
+		}
+		h.ParseJSONResponse(resp, &body)
+		return resp.StatusCode, body.Data
+	}
+	capture := func(req payment.QuickDonateCaptureRequest) (int, payment.QuickDonateCaptureResponse) {
+		t.Helper()
+		resp, err := h.MakeAPIRequest("POST", "/api/quick-donate/capture", req, "")
+		h.AssertNoError(t, err)
+		var body struct {
+			Data payment.QuickDonateCaptureResponse `json:"data"`
+		}
+		h.ParseJSONResponse(resp, &body)
+		return resp.StatusCode, body.Data
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		for _, tt := range []struct {
+			name string
+			req  payment.QuickDonateRequest
+			want int
+		}{
+			{"no CSRF token", payment.QuickDonateRequest{Amount: 20, Email: "donor@example.com"}, http.StatusForbidden},
+			{"bad email", payment.QuickDonateRequest{Amount: 20, Email: "not an email", CSRFToken: security.GenerateCSRFToken()}, http.StatusBadRequest},
+			{"no amount", payment.QuickDonateRequest{Email: "donor@example.com", CSRFToken: security.GenerateCSRFToken()}, http.StatusBadRequest},
+			{"too large", payment.QuickDonateRequest{Amount: 50000, Email: "donor@example.com", CSRFToken: security.GenerateCSRFToken()}, http.StatusBadRequest},
+		} {
+			if status, _ := donate(tt.req); status != tt.want {
+				t.Errorf("%s: expected %d, got %d", tt.name, tt.want, status)
+			}
+		}
+		if count := h.PayPal.GetOrderCount(); count != 0 {
+			t.Errorf("expected no PayPal orders for rejected donations, got %d", count)
+		}
+	})
+
+	t.Run("DonateAndCapture", func(t *testing.T) {
+		status, created := donate(payment.QuickDonateRequest{
+			Amount: 40, Email: " Donor@Example.com ", Note: "In memory of Ms. Suzuki", CSRFToken: security.GenerateCSRFToken(),
+		})
+		if status != http.StatusOK || created.OrderID == "" {
+			t.Fatalf("expected a PayPal order, got %d %+v", status, created)
+		}
+		paypalOrder, ok := h.PayPal.GetOrder(created.OrderID)
+		if !ok || paypalOrder.Amount != "40.00" || paypalOrder.FormID != created.DonationID {
+			t.Fatalf("expected a $40.00 order invoiced to the donation, got %+v", paypalOrder)
+		}
+		if len(paypalOrder.Items) != 1 || paypalOrder.Items[0].Category != paypal.CategoryDonation {
+			t.Errorf("expected the order listed as one donation, got %+v", paypalOrder.Items)
+		}
+
+		if status, _ := capture(payment.QuickDonateCaptureRequest{DonationID: created.DonationID, OrderID: "OTHER"}); status != http.StatusBadRequest {
+			t.Errorf("expected another order rejected with 400, got %d", status)
+		}
+		status, captured := capture(payment.QuickDonateCaptureRequest{DonationID: created.DonationID, OrderID: created.OrderID})
+		if status != http.StatusOK || captured.Status != "COMPLETED" || captured.ReceiptNumber == "" {
+			t.Fatalf("expected the donation captured with a receipt number, got %d %+v", status, captured)
+		}
+
+		donation, err := data.GetDonation(created.DonationID)
+		h.AssertNoError(t, err)
+		if donation.Email != "donor@example.com" || donation.PayPalStatus != "COMPLETED" || donation.CapturedAt == nil {
+			t.Errorf("expected the donation recorded as paid, got %+v", donation)
+		}
+
+		// Capturing again reports the same donation without charging it twice
+		attempts := h.PayPal.CaptureAttempts
+		status, again := capture(payment.QuickDonateCaptureRequest{DonationID: created.DonationID, OrderID: created.OrderID})
+		if status != http.StatusOK || again.ReceiptNumber != captured.ReceiptNumber || h.PayPal.CaptureAttempts != attempts {
+			t.Errorf("expected the paid donation reported again, got %d %+v", status, again)
+		}
+
+		worker := outbox.NewWorker()
+		worker.Handle(outbox.KindConfirmationEmail, order.ConfirmationEmailTask)
+		h.AssertNoError(t, worker.Run(context.Background()))
+		h.AssertNoError(t, worker.Run(context.Background()))
+		sent := h.Mailer.SentTo("donor@example.com")
+		if len(sent) != 1 || sent[0].Subject != "Thank you for your donation" {
+			t.Fatalf("expected one thank-you email, got %+v", sent)
+		}
+		for _, want := range []string{"$40.00", "In memory of Ms. Suzuki", captured.ReceiptNumber} {
+			if !strings.Contains(sent[0].Body, want) {
+				t.Errorf("expected the thank-you to mention %q, got:\n%s", want, sent[0].Body)
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Setenv("ENVIRONMENT", "dev")
+		t.Setenv("QUICK_DONATIONS_ENABLED_DEV", "false")
+		status, _ := donate(payment.QuickDonateRequest{Amount: 20, Email: "donor@example.com", CSRFToken: security.GenerateCSRFToken()})
+		if status != http.StatusNotFound {
+			t.Errorf("expected 404 with quick donations off, got %d", status)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package testing
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/order"
+)
+
+var receiptNumberPattern = regexp.MustCompile(`^\d{4}-\d{6}$`)
+
+func TestReceiptNumbers(t *testing.T) {
+	h := NewHarness(t)
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	paid, err := data.ListSubmissions(data.SubmissionFilter{Status: "paid"})
+	h.AssertNoError(t, err)
+	seen := make(map[string]bool)
+	for _, sub := range paid {
+		if !receiptNumberPattern.MatchString(sub.ReceiptNumber) {
+			t.Fatalf("expected %s to have a receipt number, got %q", sub.FormID, sub.ReceiptNumber)
+		}
+		if seen[sub.ReceiptNumber] {
+			t.Fatalf("receipt number %s issued twice", sub.ReceiptNumber)
+		}
+		seen[sub.ReceiptNumber] = true
+	}
+
+	unpaid, err := data.GetMembershipByID("membership-seed-003")
+	h.AssertNoError(t, err)
+	if unpaid.ReceiptNumber != "" {
+		t.Fatalf("expected an unpaid membership to have no receipt number, got %q", unpaid.ReceiptNumber)
+	}
+
+	// Paying by check takes the next number for the year it was paid in
+	paidAt := time.Date(2031, time.March, 2, 12, 0, 0, 0, time.UTC)
+	_, err = data.MarkSubmissionPaid("membership", "membership-seed-003", `{"manual_payment": {"method": "check"}}`, paidAt)
+	h.AssertNoError(t, err)
+	sub, err := data.GetMembershipByID("membership-seed-003")
+	h.AssertNoError(t, err)
+	if sub.ReceiptNumber != data.FormatReceiptNumber(2031, 1) {
+		t.Fatalf("expected the first 2031 receipt, got %q", sub.ReceiptNumber)
+	}
+
+	// A submission keeps its number
+	number, err := data.AssignReceiptNumber("membership", "membership-seed-003", paidAt.AddDate(1, 0, 0))
+	h.AssertNoError(t, err)
+	if number != sub.ReceiptNumber {
+		t.Errorf("expected the receipt number to stay %s, got %s", sub.ReceiptNumber, number)
+	}
+
+	formID, err := data.FormIDForReceiptNumber(" " + sub.ReceiptNumber + " ")
+	h.AssertNoError(t, err)
+	if formID != "membership-seed-003" {
+		t.Errorf("expected the receipt to look up membership-seed-003, got %s", formID)
+	}
+	found, err := data.ListSubmissions(data.SubmissionFilter{Search: sub.ReceiptNumber})
+	h.AssertNoError(t, err)
+	if len(found) != 1 || found[0].FormID != "membership-seed-003" {
+		t.Errorf("expected searching by receipt number to find the membership, got %+v", found)
+	}
+	if _, err := data.FormIDForReceiptNumber("1999-000001"); err == nil {
+		t.Errorf("expected an unknown receipt number to fail")
+	}
+}
+
+func TestReceiptAfterCatalogRename(t *testing.T) {
+	h := NewHarness(t)
+	raw, err := os.ReadFile(h.Config.InventoryPath)
+	h.AssertNoError(t, err)
+	renamed := strings.Replace(string(raw), `"Spring Festival Fee"`, `"Spring Festival Registration"`, 1)
+	renamed = strings.Replace(renamed, `"fees"`, `"aliases": {"Spring Festival Fee": "Spring Festival Registration"}, "fees"`, 1)
+	writeStaticFile(t, h.Config.InventoryPath, renamed)
+	h.AssertNoError(t, h.Inventory.LoadInventory(h.Config.InventoryPath))
+
+	if h.Inventory.ValidateFee("Spring Festival Fee") {
+		t.Errorf("expected new orders to need the current fee name")
+	}
+	if price, ok := h.Inventory.GetFeePrice("Spring Festival Fee"); !ok || price != 25 {
+		t.Errorf("expected the old fee name to keep its price, got %v (%v)", price, ok)
+	}
+
+	// Last year's membership still shows its festival fee under the new name
+	sub := goldenMembership(time.Date(2024, time.March, 14, 10, 30, 0, 0, time.UTC))
+	var buf bytes.Buffer
+	h.AssertNoError(t, order.RenderMembershipSuccessPage(&buf, sub, false))
+	page := buf.String()
+	if !strings.Contains(page, "Spring Festival Registration") || strings.Contains(page, "Spring Festival Fee") {
+		t.Errorf("expected the receipt to name the fee as the catalog does now, got:\n%s", page)
+	}
+	if sub.Fees["Spring Festival Fee"] != 1 {
+		t.Errorf("expected rendering to leave the stored fee name alone, got %v", sub.Fees)
+	}
+}
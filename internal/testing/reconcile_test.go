@@ -0,0 +1,89 @@
+package testing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/reconcile"
+)
+
+func TestReconcile(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	paid, err := data.ListSubmissions(data.SubmissionFilter{Status: "paid"})
+	h.AssertNoError(t, err)
+	if len(paid) < 3 {
+		t.Fatalf("expected at least three paid seeded submissions, got %d", len(paid))
+	}
+
+	// PayPal agrees with the database except for the first three submissions
+	missing, wrongAmount, refunded := paid[0], paid[1], paid[2]
+	for _, sub := range paid {
+		if sub.FormID == missing.FormID {
+			continue
+		}
+		amount := sub.CalculatedAmount
+		if sub.FormID == wrongAmount.FormID {
+			amount -= 5
+		}
+		h.PayPal.AddTransaction(MockTransaction{
+			ID: "TX" + sub.FormID, EventCode: "T0006", Status: "S",
+			InvoiceID: sub.FormID, Amount: amount, Date: *sub.SubmittedAt,
+		})
+		if sub.FormID == refunded.FormID {
+			h.PayPal.AddTransaction(MockTransaction{
+				ID: "RF" + sub.FormID, EventCode: "T1107", Status: "S", ReferenceID: "TX" + sub.FormID,
+				Amount: -sub.CalculatedAmount, Date: sub.SubmittedAt.Add(time.Hour),
+			})
+		}
+	}
+	h.PayPal.AddTransaction(MockTransaction{
+		ID: "TXUNPAID", EventCode: "T0006", Status: "S",
+		InvoiceID: "membership-seed-003", Amount: 125, Date: time.Now().Add(-time.Hour),
+	})
+	h.PayPal.AddTransaction(MockTransaction{
+		ID: "TXSTRAY", EventCode: "T0006", Status: "S",
+		InvoiceID: "membership-from-elsewhere", Amount: 20, Date: time.Now().Add(-time.Hour),
+	})
+
+	// Longer than one transaction search window, so the range is split
+	to := time.Now().Add(time.Minute)
+	report, err := reconcile.Run(context.Background(), to.AddDate(0, 0, -45), to)
+	h.AssertNoError(t, err)
+
+	got := make(map[string]string)
+	for _, mismatch := range report.Mismatches {
+		got[mismatch.FormID] = mismatch.Kind
+	}
+	want := map[string]string{
+		missing.FormID:              reconcile.MissingInPayPal,
+		wrongAmount.FormID:          reconcile.AmountMismatch,
+		refunded.FormID:             reconcile.StatusMismatch,
+		"membership-seed-003":       reconcile.UnpaidInDB,
+		"membership-from-elsewhere": reconcile.MissingInDB,
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d mismatches, got %+v", len(want), report.Mismatches)
+	}
+	for formID, kind := range want {
+		if got[formID] != kind {
+			t.Errorf("expected a %s mismatch for %s, got %q", kind, formID, got[formID])
+		}
+	}
+
+	// A range that only holds the refund checks it against the submission paid earlier
+	refundedAt := refunded.SubmittedAt.Add(30 * time.Minute)
+	report, err = reconcile.Run(context.Background(), refundedAt, refundedAt.Add(24*time.Hour))
+	h.AssertNoError(t, err)
+	for _, mismatch := range report.Mismatches {
+		if mismatch.Kind == reconcile.MissingInPayPal && mismatch.FormID == refunded.FormID {
+			t.Errorf("expected a submission paid before the range not to be reported missing")
+		}
+	}
+}
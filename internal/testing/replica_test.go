@@ -0,0 +1,115 @@
+package testing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/replica"
+)
+
+func TestReplicaRestoresEveryCopiedCommit(t *testing.T) {
+	h := NewHarness(t)
+	fake := clock.NewFake(time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC))
+	previous := clock.Set(fake)
+	t.Cleanup(func() { clock.Set(previous) })
+	ctx := context.Background()
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	store := replica.DirStore(t.TempDir())
+	replicator := replica.New(h.Config.DBPath, store, config.ReplicaSettings{SnapshotInterval: 24 * time.Hour, Retain: 2})
+	t.Cleanup(func() { replicator.Close() })
+	h.AssertNoError(t, replicator.Sync(ctx))
+	first := replicator.Generation()
+	if first == "" {
+		t.Fatal("expected the first sync to start a generation")
+	}
+
+	insert := func(from, to int) {
+		for i := from; i < to; i++ {
+			h.AssertNoError(t, data.InsertMembership(data.MembershipSubmission{
+				FormID:         fmt.Sprintf("membership-replica-%d", i),
+				SubmissionDate: fake.Now(),
+				FullName:       "Replica Member",
+				Email:          "replica@example.com",
+			}))
+		}
+	}
+	count := func(conn *sql.DB) int {
+		var n int
+		h.AssertNoError(t, conn.QueryRow(`SELECT COUNT(*) FROM membership_submissions`).Scan(&n))
+		return n
+	}
+	restore := func(name string) int {
+		path := filepath.Join(t.TempDir(), "restored.db")
+		restored, err := replica.Restore(ctx, store, path, name)
+		h.AssertNoError(t, err)
+		conn, err := sql.Open("sqlite", path)
+		h.AssertNoError(t, err)
+		defer conn.Close()
+		if _, err := replica.Restore(ctx, store, path, ""); err == nil {
+			t.Error("expected a restore over an existing database to be refused")
+		}
+		if name != "" && restored.Generation != name {
+			t.Errorf("expected generation %s restored, got %s", name, restored.Generation)
+		}
+		return count(conn)
+	}
+
+	insert(0, 5)
+	h.AssertNoError(t, replicator.Sync(ctx))
+	insert(5, 10)
+	h.AssertNoError(t, replicator.Sync(ctx))
+	if replicator.Generation() != first {
+		t.Fatalf("expected syncs within the snapshot interval to stay in generation %s, got %s", first, replicator.Generation())
+	}
+	want := count(h.DB)
+	if got := restore(""); got != want {
+		t.Errorf("expected the restore to hold all %d memberships, got %d", want, got)
+	}
+
+	// Commits not yet synced aren't in the replica until the next sync
+	insert(10, 11)
+	if got := restore(""); got != want {
+		t.Errorf("expected an unsynced commit left out, restoring %d memberships, got %d", want, got)
+	}
+
+	// A day on, each sync starts a new generation, and only the newest two are kept
+	for day := 1; day <= 2; day++ {
+		fake.Advance(25 * time.Hour)
+		insert(100*day, 100*day+3)
+		h.AssertNoError(t, replicator.Sync(ctx))
+	}
+	if replicator.Generation() == first {
+		t.Fatal("expected a new generation once the snapshot interval passed")
+	}
+	if _, err := replica.Restore(ctx, store, filepath.Join(t.TempDir(), "old.db"), first); err == nil {
+		t.Errorf("expected generation %s deleted once two newer ones were kept", first)
+	}
+
+	// Past the checkpoint threshold the replicator checkpoints the log, which then
+	// restarts without losing the generation
+	generation := replicator.Generation()
+	insert(1000, 1000+replica.CheckpointFrames)
+	h.AssertNoError(t, replicator.Sync(ctx))
+	insert(3000, 3005)
+	h.AssertNoError(t, replicator.Sync(ctx))
+	if replicator.Generation() != generation {
+		t.Errorf("expected generation %s to go on after a checkpoint, got %s", generation, replicator.Generation())
+	}
+
+	insert(300, 302)
+	h.AssertNoError(t, replicator.Close())
+	if got, want := restore(replicator.Generation()), count(h.DB); got != want {
+		t.Errorf("expected the commits before close restored, %d memberships, got %d", want, got)
+	}
+}
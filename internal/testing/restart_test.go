@@ -0,0 +1,158 @@
+package testing
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/restart"
+)
+
+// restartRole makes the test binary act as the process handing off its listener
+const restartRole = "SBC_RESTART_TEST_ROLE"
+
+// TestRestartHandoff runs the handoff protocol between two copies of the test binary:
+// the parent passes its listener and waits for the child to say it's ready, and the
+// child starts work only one process may do once the parent has exited
+func TestRestartHandoff(t *testing.T) {
+	if os.Getenv(restart.EnvListenFD) != "" {
+		restartChild()
+		return
+	}
+	if os.Getenv(restartRole) == "parent" {
+		restartParent()
+		return
+	}
+
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdoutR.Close()
+	parent := exec.Command(os.Args[0], "-test.run=^TestRestartHandoff$")
+	parent.Env = append(os.Environ(), restartRole+"=parent")
+	parent.Stdout = stdoutW
+	parent.Stderr = os.Stderr
+	stdin, err := parent.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.Start(); err != nil {
+		t.Fatal(err)
+	}
+	stdoutW.Close()
+	defer parent.Process.Kill()
+
+	// The parent prints its address, then hands off and stops accepting
+	lines := bufio.NewScanner(stdoutR)
+	expect := func(prefix string) string {
+		t.Helper()
+		for lines.Scan() {
+			if value, ok := strings.CutPrefix(lines.Text(), prefix); ok {
+				return value
+			}
+		}
+		t.Fatalf("expected %q from the parent", prefix)
+		return ""
+	}
+	addr := expect("listening ")
+	parentPID := expect("handed off ")
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	defer get("/quit")
+
+	// The child serves on the socket the parent opened, holding its jobs while the
+	// parent is still running
+	if served := get("/"); served == parentPID || served == "" {
+		t.Errorf("expected the child to serve after the handoff, got %q from parent %s", served, parentPID)
+	}
+	if jobs := get("/jobs"); jobs != "waiting" {
+		t.Errorf("expected the child's jobs held while the parent runs, got %q", jobs)
+	}
+
+	// Once the parent exits, the child starts them
+	stdin.Close()
+	if err := parent.Wait(); err != nil {
+		t.Fatalf("parent failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for get("/jobs") != "started" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the child's jobs started after the parent exited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// restartParent listens, hands the listener to a child and stops accepting, then
+// exits once the test closes its stdin
+func restartParent() {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("listening %s\n", ln.Addr())
+	if err := restart.Handoff(ln, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ln.Close()
+	fmt.Printf("handed off %d\n", os.Getpid())
+	io.Copy(io.Discard, os.Stdin)
+	os.Exit(0)
+}
+
+// restartChild serves its PID and whether its parent has exited on the inherited
+// listener until asked to quit
+func restartChild() {
+	ln, err := restart.InheritedListener(restart.EnvListenFD)
+	if err != nil || ln == nil {
+		fmt.Fprintln(os.Stderr, "no inherited listener:", err)
+		os.Exit(1)
+	}
+	exited := restart.ParentExited()
+
+	quit := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strconv.Itoa(os.Getpid()))
+	})
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-exited:
+			io.WriteString(w, "started")
+		default:
+			io.WriteString(w, "waiting")
+		}
+	})
+	mux.HandleFunc("/quit", func(w http.ResponseWriter, r *http.Request) {
+		close(quit)
+	})
+	go http.Serve(ln, mux)
+	restart.SignalReady()
+
+	select {
+	case <-quit:
+		time.Sleep(10 * time.Millisecond)
+	case <-time.After(time.Minute):
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,178 @@
+package testing
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/scheduler"
+)
+
+func TestSchedulerRejectsInvalidSchedules(t *testing.T) {
+	s := scheduler.New()
+	noop := func(ctx context.Context) error { return nil }
+
+	invalid := []string{"", "0 2 * *", "61 * * * *", "*/0 * * * *", "-5m"}
+	for i, schedule := range invalid {
+		job := scheduler.Job{Name: "job-" + string(rune('a'+i)), Schedule: schedule, Run: noop}
+		if err := s.Register(job); err == nil {
+			t.Errorf("expected schedule %q to be rejected", schedule)
+		}
+	}
+
+	if err := s.Register(scheduler.Job{Name: "nightly", Schedule: "0 2 * * 1-5", Run: noop}); err != nil {
+		t.Errorf("expected cron schedule to be accepted: %v", err)
+	}
+	if err := s.Register(scheduler.Job{Name: "nightly", Schedule: "5m", Run: noop}); err == nil {
+		t.Error("expected duplicate job name to be rejected")
+	}
+}
+
+func TestSchedulerIsolatesPanics(t *testing.T) {
+	s := scheduler.New()
+	var runs int32
+
+	if err := s.Register(scheduler.Job{
+		Name:     "panicky",
+		Schedule: "10ms",
+		Run: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			panic("boom")
+		},
+	}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	s.Start()
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	if atomic.LoadInt32(&runs) < 2 {
+		t.Errorf("expected job to keep running after a panic, ran %d times", runs)
+	}
+}
+
+func TestSchedulerRunNowUpdatesStatus(t *testing.T) {
+	s := scheduler.New()
+	done := make(chan struct{}, 1)
+
+	if err := s.Register(scheduler.Job{
+		Name:     "daily",
+		Schedule: "0 3 * * *",
+		Run: func(ctx context.Context) error {
+			done <- struct{}{}
+			return nil
+		},
+	}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	if err := s.RunNow("missing"); err != scheduler.ErrJobNotFound {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+
+	s.Start()
+	defer s.Stop()
+
+	if err := s.RunNow("daily"); err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("manual run did not happen")
+	}
+
+	// Give the scheduler a moment to record the outcome
+	time.Sleep(20 * time.Millisecond)
+
+	status := s.Status()
+	if len(status) != 1 || status[0].RunCount != 1 || status[0].LastOutcome != "ok" {
+		t.Errorf("unexpected status after manual run: %+v", status)
+	}
+	if status[0].NextRun == nil {
+		t.Error("expected next run to be scheduled")
+	}
+}
+
+func TestSchedulerShutdownWaitsForRunningJob(t *testing.T) {
+	s := scheduler.New()
+	started := make(chan struct{})
+	var finished, cancelled int32
+
+	if err := s.Register(scheduler.Job{
+		Name:     "slow",
+		Schedule: "0 3 * * *",
+		Run: func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-time.After(50 * time.Millisecond):
+				atomic.StoreInt32(&finished, 1)
+			case <-ctx.Done():
+				atomic.StoreInt32(&cancelled, 1)
+			}
+			return nil
+		},
+	}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	s.Start()
+	if err := s.RunNow("slow"); err != nil {
+		t.Fatalf("RunNow failed: %v", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+
+	if atomic.LoadInt32(&finished) != 1 || atomic.LoadInt32(&cancelled) != 0 {
+		t.Error("expected in-flight job to finish before shutdown returned")
+	}
+}
+
+func TestSchedulerDefersRunsOutOfBlackout(t *testing.T) {
+	s := scheduler.New()
+	noop := func(ctx context.Context) error { return nil }
+
+	for _, blackout := range []string{"18:00", "25:00-26:00", "09:00-09:00", "00:00-12:00,12:00-00:00"} {
+		job := scheduler.Job{Name: "bad-" + blackout, Schedule: "1m", Blackout: blackout, Run: noop}
+		if err := s.Register(job); err == nil {
+			t.Errorf("expected blackout %q to be rejected", blackout)
+		}
+	}
+
+	// A window from a minute ago to two hours from now must push the next run past its end
+	now := time.Now()
+	start := now.Add(-time.Minute)
+	end := now.Add(2 * time.Hour)
+	blackout := start.Format("15:04") + "-" + end.Format("15:04")
+	if err := s.Register(scheduler.Job{Name: "deferred", Schedule: "1m", Blackout: blackout, Run: noop}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	s.Start()
+	defer s.Stop()
+
+	var status scheduler.JobStatus
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		status = s.Status()[0]
+		if status.NextRun != nil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if status.Blackout != blackout {
+		t.Errorf("expected blackout %q in status, got %q", blackout, status.Blackout)
+	}
+	if status.NextRun == nil || status.NextRun.Before(end.Truncate(time.Minute)) {
+		t.Errorf("expected next run after %s, got %v", end.Format("15:04"), status.NextRun)
+	}
+}
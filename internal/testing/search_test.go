@@ -0,0 +1,163 @@
+package testing
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/security"
+)
+
+func TestSubmissionSearch(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	search := func(query string) []data.SubmissionSummary {
+		t.Helper()
+		results, err := data.SearchSubmissions(query, 0)
+		h.AssertNoError(t, err)
+		return results
+	}
+	names := func(results []data.SubmissionSummary) []string {
+		var found []string
+		for _, result := range results {
+			found = append(found, result.FormType+":"+result.FullName)
+		}
+		return found
+	}
+
+	// Students are found by their own names and by the start of them
+	if results := search("Emm"); len(results) != 1 || results[0].FullName != "Jane Smith" || results[0].FormType != "membership" {
+		t.Errorf("expected Emma Smith's student to find Jane Smith's membership, got %v", names(results))
+	}
+	if results := search("grace kim"); len(results) != 1 || results[0].FormType != "fundraiser" {
+		t.Errorf("expected Grace Kim to find the Kims' donation, got %v", names(results))
+	}
+	if results := search("john.doe@example.com"); len(results) != 1 || results[0].FormType != "event" {
+		t.Errorf("expected an email to find its registration, got %v", names(results))
+	}
+
+	// A school matches across form types, and every word must match
+	lincoln := search("lincoln")
+	types := map[string]bool{}
+	for _, result := range lincoln {
+		types[result.FormType] = true
+	}
+	if len(types) != 3 {
+		t.Errorf("expected Lincoln submissions of every form type, got %v", names(lincoln))
+	}
+	if results := search("lincoln johnson"); len(results) != 1 || results[0].FullName != "Mary Johnson" {
+		t.Errorf("expected only the Johnsons at Lincoln, got %v", names(results))
+	}
+
+	// Search syntax is taken as text, not as FTS5 operators
+	for _, query := range []string{`"smith`, "smith OR kim", "NEAR(smith", "*", "email:jane"} {
+		if _, err := data.SearchSubmissions(query, 0); err != nil {
+			t.Errorf("expected %q to search as plain words, got %v", query, err)
+		}
+	}
+
+	// Anonymizing a submission takes its details out of the index
+	jane := search("jane smith")
+	if len(jane) != 1 {
+		t.Fatalf("expected Jane Smith's membership, got %v", names(jane))
+	}
+	h.AssertNoError(t, data.AnonymizeSubmission("membership", jane[0].FormID, time.Now()))
+	if results := search("emma"); len(results) != 0 {
+		t.Errorf("expected the anonymized membership out of the index, got %v", names(results))
+	}
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/admin/search?q="+url.QueryEscape("kim"), nil)
+	h.AssertNoError(t, err)
+	req.Header.Set("X-Admin-Token", adminToken)
+	req.Header.Set("Referer", h.Server.URL+"/info")
+	resp, err := h.Client.Do(req)
+	h.AssertNoError(t, err)
+	var body struct {
+		Data struct {
+			Results []data.SubmissionSummary `json:"results"`
+		} `json:"data"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("expected the search endpoint to answer, got %d", resp.StatusCode)
+	}
+	h.AssertNoError(t, h.ParseJSONResponse(resp, &body))
+	if len(body.Data.Results) != 1 || body.Data.Results[0].FullName != "David Kim" {
+		t.Errorf("expected the endpoint to find David Kim, got %+v", body.Data.Results)
+	}
+}
+
+func TestSubmissionHistoryByEmail(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	// The Smiths also registered for the festival, paying by bank transfer, and were
+	// refunded $25 of their membership
+	conn, err := data.GetDB()
+	h.AssertNoError(t, err)
+	_, err = conn.Exec(`UPDATE event_submissions SET email = ?, paypal_status = ? WHERE email = ?`,
+		"jane.smith@example.com", data.PaymentPendingStatus, "john.doe@example.com")
+	h.AssertNoError(t, err)
+	jane, err := data.ListSubmissions(data.SubmissionFilter{FormType: "membership", Search: "jane"})
+	h.AssertNoError(t, err)
+	if len(jane) != 1 {
+		t.Fatalf("expected Jane's membership, got %+v", jane)
+	}
+	_, _, err = data.RecordRefund("membership", jane[0].FormID, data.Refund{ID: "REFUND-1", Amount: 25, RefundedAt: time.Now()})
+	h.AssertNoError(t, err)
+
+	history, err := data.GetSubmissionsByEmail(" Jane.Smith@example.com")
+	h.AssertNoError(t, err)
+	states := map[string]string{}
+	for _, entry := range history.Submissions {
+		states[entry.FormType] = entry.PaymentState
+	}
+	if len(history.Submissions) != 2 || states["membership"] != data.PaymentStatePartlyRefunded || states["event"] != data.PaymentStatePending {
+		t.Errorf("expected a partly refunded membership and a pending registration, got %+v", history.Submissions)
+	}
+	if history.Paid != 50 || history.Refunded != 25 || history.Due != 70 {
+		t.Errorf("expected $50 paid, $25 refunded and $70 due, got %+v", history)
+	}
+	if nobody, err := data.GetSubmissionsByEmail("nobody@example.com"); err != nil || len(nobody.Submissions) != 0 {
+		t.Errorf("expected no history for an unknown address, got %+v: %v", nobody, err)
+	}
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	lookup := func(token, query string) (int, data.SubmissionHistory) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+"/api/admin/history?"+query, nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", token)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		var body struct {
+			Data data.SubmissionHistory `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &body))
+		return resp.StatusCode, body.Data
+	}
+	if status, _ := lookup("not-a-token", "email=jane.smith%40example.com"); status != http.StatusForbidden {
+		t.Errorf("expected the history to need an admin token, got %d", status)
+	}
+	if status, _ := lookup(adminToken, ""); status != http.StatusBadRequest {
+		t.Errorf("expected an email to be required, got %d", status)
+	}
+	if status, found := lookup(adminToken, "email="+url.QueryEscape("jane.smith@example.com")); status != http.StatusOK ||
+		len(found.Submissions) != 2 || found.Due != 70 {
+		t.Errorf("expected the endpoint to return the Smiths' history, got %d %+v", status, found)
+	}
+}
@@ -0,0 +1,70 @@
+package testing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/router"
+)
+
+func TestLoadServerSettings(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("SERVER_READ_TIMEOUT_DEV", "2m")
+	t.Setenv("SERVER_WRITE_TIMEOUT_DEV", "150s")
+	t.Setenv("SERVER_MAX_HEADER_BYTES_DEV", "65536")
+	t.Setenv("SERVER_HTTP2_DEV", "false")
+	t.Setenv("SERVER_IDLE_TIMEOUT_DEV", "not-a-duration")
+	t.Setenv("SERVER_SHUTDOWN_TIMEOUT_DEV", "45s")
+
+	settings := config.LoadServerSettings()
+	if settings.ReadTimeout != 2*time.Minute || settings.WriteTimeout != 150*time.Second {
+		t.Errorf("unexpected read/write timeouts %v/%v", settings.ReadTimeout, settings.WriteTimeout)
+	}
+	if settings.MaxHeaderBytes != 65536 {
+		t.Errorf("expected MaxHeaderBytes 65536, got %d", settings.MaxHeaderBytes)
+	}
+	if settings.HTTP2 || !settings.KeepAlives {
+		t.Errorf("expected HTTP/2 off and keep-alives on, got %v and %v", settings.HTTP2, settings.KeepAlives)
+	}
+	if settings.IdleTimeout != 60*time.Second {
+		t.Errorf("expected an invalid idle timeout to fall back to 60s, got %v", settings.IdleTimeout)
+	}
+	if settings.RequestTimeout != 15*time.Second {
+		t.Errorf("expected the default 15s request timeout, got %v", settings.RequestTimeout)
+	}
+	if settings.ShutdownTimeout != 45*time.Second {
+		t.Errorf("expected a 45s shutdown timeout, got %v", settings.ShutdownTimeout)
+	}
+
+	t.Setenv("SERVER_SHUTDOWN_TIMEOUT_DEV", "0s")
+	if got := config.LoadServerSettings().ShutdownTimeout; got != 30*time.Second {
+		t.Errorf("expected a zero shutdown timeout to fall back to 30s, got %v", got)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	previous := router.SetRequestTimeout(50 * time.Millisecond)
+	t.Cleanup(func() { router.SetRequestTimeout(previous) })
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(router.Handler(slow))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the request to time out with 503, got %d", resp.StatusCode)
+	}
+}
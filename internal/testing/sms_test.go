@@ -0,0 +1,180 @@
+package testing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/outbox"
+	"sbcbackend/internal/security"
+	"sbcbackend/internal/sms"
+)
+
+// twilioRecorder is a stand-in for Twilio's Messages API
+type twilioRecorder struct {
+	mu       sync.Mutex
+	fail     bool
+	messages []url.Values
+}
+
+func newTwilioRecorder(t *testing.T) *twilioRecorder {
+	recorder := &twilioRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sid, token, ok := r.BasicAuth(); !ok || sid != "ACtest" || token != "secret" ||
+			r.URL.Path != "/2010-04-01/Accounts/ACtest/Messages.json" {
+			http.Error(w, `{"code": 20003, "message": "Authenticate"}`, http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		if recorder.fail {
+			http.Error(w, `{"code": 20500, "message": "Internal Server Error"}`, http.StatusInternalServerError)
+			return
+		}
+		recorder.messages = append(recorder.messages, r.PostForm)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"sid": "SM123", "status": "queued"}`)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := sms.SetAPIBase(server.URL)
+	t.Cleanup(func() { sms.SetAPIBase(previous) })
+	return recorder
+}
+
+func (r *twilioRecorder) sent() []url.Values {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]url.Values(nil), r.messages...)
+}
+
+func (r *twilioRecorder) setFail(fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = fail
+}
+
+var formIDPattern = regexp.MustCompile(`setItem\('formID', "([^"]+)"\)`)
+
+func TestSMSConfirmation(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("TWILIO_ACCOUNT_SID_DEV", "ACtest")
+	t.Setenv("TWILIO_AUTH_TOKEN_DEV", "secret")
+	t.Setenv("TWILIO_FROM_DEV", "+15125550199")
+	t.Setenv("PUBLIC_BASE_URL", "https://booster.example.org")
+	twilio := newTwilioRecorder(t)
+	h := NewHarness(t)
+
+	submit := func(ip, phone string) (int, string) {
+		form := url.Values{
+			"csrf_token":    {security.GenerateCSRFToken()},
+			"form_type":     {"membership"},
+			"full_name":     {"Pat Jones"},
+			"email":         {"pat." + strings.ReplaceAll(ip, ".", "") + "@example.com"},
+			"school":        {"lincoln-elementary"},
+			"student_count": {"0"},
+			"phone":         {phone},
+			"sms_consent":   {"on"},
+		}
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/submit-form", strings.NewReader(form.Encode()))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		formID := ""
+		if match := formIDPattern.FindSubmatch(body); match != nil {
+			formID = string(match[1])
+		}
+		return resp.StatusCode, formID
+	}
+
+	if code, _ := submit("10.0.1.1", "555-0100"); code != http.StatusBadRequest {
+		t.Errorf("expected an incomplete phone number to be rejected, got %d", code)
+	}
+	code, formID := submit("10.0.1.2", "(512) 555-0100")
+	if code != http.StatusOK || formID == "" {
+		t.Fatalf("expected the submission to be accepted, got %d (form ID %q)", code, formID)
+	}
+	recipient, err := data.GetSMSRecipient(formID)
+	h.AssertNoError(t, err)
+	if recipient == nil || recipient.Phone != "+15125550100" {
+		t.Fatalf("expected consent for +15125550100, got %+v", recipient)
+	}
+
+	// Nothing is texted before the payment is captured
+	h.AssertNoError(t, order.SMSConfirmationTask(context.Background(), data.OutboxTask{FormID: formID}))
+	if got := twilio.sent(); len(got) != 0 {
+		t.Fatalf("expected no text for an unpaid submission, got %v", got)
+	}
+
+	_, err = fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, data.RecordSMSConsent("membership", "membership-seed-001", "+15125550101", time.Now()))
+
+	foundTask := false
+	for _, task := range outbox.CaptureTasks("membership", "membership-seed-001", time.Now()) {
+		foundTask = foundTask || task.Kind == outbox.KindSMSConfirmation
+	}
+	if !foundTask {
+		t.Errorf("expected an SMS confirmation task to be queued on capture")
+	}
+
+	// A Twilio outage releases the claim so the retry can send
+	twilio.setFail(true)
+	if err := order.SMSConfirmationTask(context.Background(), data.OutboxTask{FormID: "membership-seed-001"}); err == nil {
+		t.Fatalf("expected the task to fail while Twilio is down")
+	}
+	twilio.setFail(false)
+
+	for i := 0; i < 2; i++ {
+		h.AssertNoError(t, order.SMSConfirmationTask(context.Background(), data.OutboxTask{FormID: "membership-seed-001"}))
+	}
+	got := twilio.sent()
+	if len(got) != 1 {
+		t.Fatalf("expected exactly one text, got %d", len(got))
+	}
+	if got[0].Get("To") != "+15125550101" || got[0].Get("From") != "+15125550199" {
+		t.Errorf("unexpected recipient or sender: %v", got[0])
+	}
+	body := got[0].Get("Body")
+	if !strings.Contains(body, "$75.00 Basic Membership") || !strings.Contains(body, "Reply STOP") {
+		t.Errorf("unexpected text: %q", body)
+	}
+
+	// The receipt link opens the success page without the checkout session
+	link := regexp.MustCompile(`https://booster\.example\.org(\S+)`).FindStringSubmatch(body)
+	if link == nil {
+		t.Fatalf("expected a receipt link in %q", body)
+	}
+	resp, err := h.Client.Get(h.Server.URL + link[1])
+	h.AssertNoError(t, err)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Jane") {
+		t.Errorf("expected the receipt page, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the receipt not to be cached, got %q", resp.Header.Get("Cache-Control"))
+	}
+
+	resp, err = h.Client.Get(h.Server.URL + "/api/receipt?formID=membership-seed-001&token=wrong")
+	h.AssertNoError(t, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a wrong token to be refused, got %d", resp.StatusCode)
+	}
+}
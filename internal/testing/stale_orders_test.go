@@ -0,0 +1,73 @@
+package testing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+)
+
+func TestStaleOrderWatchdog(t *testing.T) {
+	h := NewHarness(t)
+
+	orderFor := func(status string, createdAgo time.Duration) (data.MembershipSubmission, string) {
+		t.Helper()
+		member := h.GenerateTestMembership().ToMembershipSubmission()
+		member.CalculatedAmount = 25
+		h.AssertNoError(t, data.InsertMembership(member))
+		orderID := "ORDER-GONE-" + member.FormID
+		if status != "" {
+			order, err := h.PayPal.CreateOrder(member.FormID, "25.00")
+			h.AssertNoError(t, err)
+			order.Status = status
+			orderID = order.ID
+		}
+		createdAt := time.Now().Add(-createdAgo)
+		h.AssertNoError(t, data.UpdateMembershipPayPalOrder(member.FormID, orderID, &createdAt))
+		return member, orderID
+	}
+
+	abandoned, abandonedOrder := orderFor("CREATED", 30*time.Hour)
+	approved, _ := orderFor("APPROVED", 30*time.Hour)
+	purged, _ := orderFor("", 30*time.Hour)
+	recent, recentOrder := orderFor("CREATED", time.Hour)
+
+	result, err := payment.CheckStaleOrders(context.Background(), 24*time.Hour)
+	h.AssertNoError(t, err)
+	if result.Checked != 3 || result.Recovered != 1 || result.Expired != 2 {
+		t.Fatalf("expected 3 orders checked, 1 recovered and 2 freed, got %+v", result)
+	}
+
+	for _, member := range []data.MembershipSubmission{abandoned, purged} {
+		summary, err := data.GetSubmissionSummary(member.FormID)
+		h.AssertNoError(t, err)
+		if summary.PayPalOrderID != "" || summary.Submitted {
+			t.Errorf("expected %s freed for a new checkout, got %+v", member.FormID, summary)
+		}
+	}
+
+	summary, err := data.GetSubmissionSummary(approved.FormID)
+	h.AssertNoError(t, err)
+	if !summary.Submitted || summary.PayPalStatus != "COMPLETED" {
+		t.Errorf("expected the approved order captured, got %+v", summary)
+	}
+
+	summary, err = data.GetSubmissionSummary(recent.FormID)
+	h.AssertNoError(t, err)
+	if summary.PayPalOrderID != recentOrder {
+		t.Errorf("expected an order younger than the cutoff kept, got %q", summary.PayPalOrderID)
+	}
+
+	// A freed form checks out with a brand new order
+	resp, err := h.MakeAPIRequest("POST", "/api/create-order", map[string]string{"formID": abandoned.FormID}, abandoned.AccessToken)
+	h.AssertNoError(t, err)
+	var created struct {
+		Data payment.CreateOrderResponse `json:"data"`
+	}
+	h.AssertNoError(t, h.ParseJSONResponse(resp, &created))
+	if created.Data.OrderID == "" || created.Data.OrderID == abandonedOrder {
+		t.Errorf("expected a fresh order, got %q", created.Data.OrderID)
+	}
+}
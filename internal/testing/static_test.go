@@ -0,0 +1,232 @@
+package testing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/assets"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/order"
+	"sbcbackend/internal/static"
+)
+
+func writeStaticFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	ordersDir, assetsDir := t.TempDir(), t.TempDir()
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("EVENT_ORDERS_PATH_DEV", ordersDir)
+	t.Setenv("STATIC_ASSETS_PATH_DEV", assetsDir)
+	writeStaticFile(t, filepath.Join(ordersDir, "2024", "spring-festival", "SF-0042.html"), "<html>order</html>")
+	writeStaticFile(t, filepath.Join(assetsDir, "css", "simple.css"), "body{}")
+
+	h := NewHarness(t)
+
+	get := func(path string, header map[string]string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, h.Server.URL+path, nil)
+		h.AssertNoError(t, err)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	page := get("/events/2024/spring-festival/SF-0042.html", nil)
+	if page.StatusCode != http.StatusOK {
+		t.Fatalf("expected the order page, got %d", page.StatusCode)
+	}
+	if got := page.Header.Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("unexpected order page Cache-Control %q", got)
+	}
+	if page.Header.Get("X-Robots-Tag") == "" {
+		t.Error("expected order pages to be kept out of search engines")
+	}
+
+	// A revalidation with the ETag is answered without the body
+	revalidated := get("/events/2024/spring-festival/SF-0042.html", map[string]string{"If-None-Match": page.Header.Get("ETag")})
+	if revalidated.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", revalidated.StatusCode)
+	}
+
+	asset := get("/static/css/simple.css", nil)
+	if asset.StatusCode != http.StatusOK {
+		t.Fatalf("expected the stylesheet, got %d", asset.StatusCode)
+	}
+	if got := asset.Header.Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("unexpected asset Cache-Control %q", got)
+	}
+	if got := asset.Header.Get("Content-Type"); got != "text/css; charset=utf-8" {
+		t.Errorf("unexpected asset Content-Type %q", got)
+	}
+
+	if missing := get("/events/2024/spring-festival/SF-9999.html", nil); missing.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing page, got %d", missing.StatusCode)
+	}
+}
+
+func TestStaticHandlerRefusesEscapes(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeStaticFile(t, filepath.Join(root, "page.html"), "ok")
+	writeStaticFile(t, filepath.Join(root, ".env"), "SECRET=1")
+	writeStaticFile(t, filepath.Join(root, "sub", "index.html"), "ok")
+	writeStaticFile(t, filepath.Join(outside, "secret.txt"), "secret")
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	handler := static.Handler(root, static.Options{})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"RegularFile", http.MethodGet, "/page.html", http.StatusOK},
+		{"Head", http.MethodHead, "/page.html", http.StatusOK},
+		{"Post", http.MethodPost, "/page.html", http.StatusMethodNotAllowed},
+		{"DotDot", http.MethodGet, "/../" + filepath.Base(outside) + "/secret.txt", http.StatusNotFound},
+		{"EncodedDotDot", http.MethodGet, "/..%2f..%2fetc%2fpasswd", http.StatusNotFound},
+		{"Backslash", http.MethodGet, "/..%5c..%5csecret.txt", http.StatusNotFound},
+		{"HiddenFile", http.MethodGet, "/.env", http.StatusNotFound},
+		{"SymlinkOutside", http.MethodGet, "/link.txt", http.StatusNotFound},
+		{"Directory", http.MethodGet, "/sub/", http.StatusNotFound},
+		{"Root", http.MethodGet, "/", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Errorf("%s %s: expected %d, got %d", tt.method, tt.target, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRebuildEventOrderPage(t *testing.T) {
+	ordersDir := t.TempDir()
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("EVENT_ORDERS_PATH_DEV", ordersDir)
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	// A page from an earlier year with an old template
+	pagePath := filepath.Join(ordersDir, "2024", "spring-festival", "SF-0001.html")
+	writeStaticFile(t, pagePath, "<html>old branding</html>")
+	_, err = data.ExecDB(`UPDATE event_submissions SET food_order_id = 'SF-0001',
+		order_page_url = '/events/2024/spring-festival/SF-0001.html' WHERE form_id = 'event-seed-001'`)
+	h.AssertNoError(t, err)
+
+	sub, err := data.GetEventByID("event-seed-001")
+	h.AssertNoError(t, err)
+	url, err := order.RebuildEventOrderPage(sub)
+	h.AssertNoError(t, err)
+	if url != "/events/2024/spring-festival/SF-0001.html" {
+		t.Errorf("expected the page to keep its emailed URL, got %q", url)
+	}
+	page, err := os.ReadFile(pagePath)
+	h.AssertNoError(t, err)
+	if !strings.Contains(string(page), "Thank you for your registration") {
+		t.Errorf("expected the page to be rendered with the current template, got %q", page)
+	}
+
+	unpaid, err := data.GetEventByID("event-seed-003")
+	h.AssertNoError(t, err)
+	if _, err := order.RebuildEventOrderPage(unpaid); err == nil {
+		t.Errorf("expected an unpaid registration to have no page to rebuild")
+	}
+}
+
+func TestEventOrderPageTemplates(t *testing.T) {
+	ordersDir, workDir := t.TempDir(), t.TempDir()
+	t.Setenv("ENVIRONMENT", "dev")
+	t.Setenv("EVENT_ORDERS_PATH_DEV", ordersDir)
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	// The festival names its own page and print templates, found in the override directory
+	raw, err := os.ReadFile(h.Config.InventoryPath)
+	h.AssertNoError(t, err)
+	var inv map[string]interface{}
+	h.AssertNoError(t, json.Unmarshal(raw, &inv))
+	festival := inv["events"].(map[string]interface{})["spring-festival"].(map[string]interface{})
+	festival["order_page_template"] = "festival_order.html.tmpl"
+	festival["print_template"] = "festival_ticket.html.tmpl"
+	raw, err = json.Marshal(inv)
+	h.AssertNoError(t, err)
+	writeStaticFile(t, h.Config.InventoryPath, string(raw))
+	h.AssertNoError(t, h.Inventory.LoadInventory(h.Config.InventoryPath))
+
+	wd, err := os.Getwd()
+	h.AssertNoError(t, err)
+	h.AssertNoError(t, os.Chdir(workDir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	writeStaticFile(t, filepath.Join(workDir, assets.TemplateOverrideDir, "festival_order.html.tmpl"),
+		`<h1>{{.Event}} order {{.FoodOrderID}}</h1> {{formatCurrency .CalculatedAmount}} <a href="{{.PrintURL}}">print</a>`)
+	writeStaticFile(t, filepath.Join(workDir, assets.TemplateOverrideDir, "festival_ticket.html.tmpl"),
+		`<h1>Ticket {{.FoodOrderID}}</h1>{{range .Students}}<p>{{.Name}}</p>{{end}}`)
+
+	_, err = data.ExecDB(`UPDATE event_submissions SET food_order_id = 'SF-0001', order_page_url = ''
+		WHERE form_id = 'event-seed-001'`)
+	h.AssertNoError(t, err)
+	sub, err := data.GetEventByID("event-seed-001")
+	h.AssertNoError(t, err)
+	url, err := order.RebuildEventOrderPage(sub)
+	h.AssertNoError(t, err)
+
+	pagePath := filepath.Join(ordersDir, filepath.FromSlash(strings.TrimPrefix(url, "/events/")))
+	page, err := os.ReadFile(pagePath)
+	h.AssertNoError(t, err)
+	if !strings.Contains(string(page), "order SF-0001") || !strings.Contains(string(page), `href="SF-0001.print.html"`) {
+		t.Errorf("expected the festival's own page linking its print variant, got %q", page)
+	}
+	ticket, err := os.ReadFile(strings.TrimSuffix(pagePath, ".html") + ".print.html")
+	h.AssertNoError(t, err)
+	if !strings.Contains(string(ticket), "Ticket SF-0001") || !strings.Contains(string(ticket), "Student 1") {
+		t.Errorf("expected the print-friendly ticket, got %q", ticket)
+	}
+	// The pages are public files, so templates only get the students' places
+	if strings.Contains(string(ticket), sub.Students[0].Name) {
+		t.Errorf("expected the ticket to leave off the students' names, got %q", ticket)
+	}
+
+	// Dropping the templates brings back the built-in page and removes the print page
+	delete(festival, "order_page_template")
+	delete(festival, "print_template")
+	raw, err = json.Marshal(inv)
+	h.AssertNoError(t, err)
+	writeStaticFile(t, h.Config.InventoryPath, string(raw))
+	h.AssertNoError(t, h.Inventory.LoadInventory(h.Config.InventoryPath))
+	_, err = order.RebuildEventOrderPage(sub)
+	h.AssertNoError(t, err)
+	if page, _ := os.ReadFile(pagePath); !strings.Contains(string(page), "Thank you for your registration") {
+		t.Errorf("expected the built-in page, got %q", page)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(pagePath, ".html") + ".print.html"); !os.IsNotExist(err) {
+		t.Errorf("expected the print page to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package testing
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/fixtures"
+	"sbcbackend/internal/order"
+)
+
+func TestListAndMarkSubmissionsPaid(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	unpaid, err := data.ListSubmissions(data.SubmissionFilter{FormType: "membership", Status: "unpaid"})
+	h.AssertNoError(t, err)
+	if len(unpaid) != 1 || unpaid[0].FormID != "membership-seed-003" || unpaid[0].Item == "" {
+		t.Fatalf("expected the one unpaid seeded membership, got %+v", unpaid)
+	}
+
+	all, err := data.ListSubmissions(data.SubmissionFilter{})
+	h.AssertNoError(t, err)
+	for i := 1; i < len(all); i++ {
+		if all[i].SubmissionDate.Before(all[i-1].SubmissionDate) {
+			t.Fatalf("expected submissions oldest first across form types")
+		}
+	}
+	latest, err := data.ListSubmissions(data.SubmissionFilter{Limit: 2})
+	h.AssertNoError(t, err)
+	if len(latest) != 2 || latest[1].FormID != all[len(all)-1].FormID {
+		t.Errorf("expected the limit to keep the most recent submissions, got %+v", latest)
+	}
+
+	details := `{"manual_payment": {"method": "check", "reference": "1042"}}`
+	updated, err := data.MarkSubmissionPaid("membership", "membership-seed-003", details, time.Now())
+	h.AssertNoError(t, err)
+	if !updated {
+		t.Fatalf("expected the unpaid membership to be marked paid")
+	}
+	sub, err := data.GetMembershipByID("membership-seed-003")
+	h.AssertNoError(t, err)
+	if sub.PayPalStatus != "COMPLETED" || !strings.Contains(sub.PayPalDetails, "1042") {
+		t.Errorf("expected a completed manual payment, got %q %q", sub.PayPalStatus, sub.PayPalDetails)
+	}
+
+	// A paid submission is left alone, PayPal details and all
+	updated, err = data.MarkSubmissionPaid("membership", "membership-seed-001", details, time.Now())
+	h.AssertNoError(t, err)
+	if updated {
+		t.Errorf("expected a PayPal-paid membership not to be marked again")
+	}
+	summary, err := data.GetSubmissionSummary("membership-seed-001")
+	h.AssertNoError(t, err)
+	if summary.FormType != "membership" || summary.PayPalStatus != "COMPLETED" {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	if _, err := data.GetSubmissionSummary("event-missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing submission, got %v", err)
+	}
+	if _, err := data.FormTypeFromID("raffle-2026"); err == nil {
+		t.Errorf("expected an unknown form type to be rejected")
+	}
+}
+
+func TestListFiltersAndPages(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	formIDs := func(t *testing.T, filter data.SubmissionFilter) string {
+		t.Helper()
+		memberships, err := data.ListMemberships(filter)
+		h.AssertNoError(t, err)
+		var ids []string
+		for _, membership := range memberships {
+			ids = append(ids, membership.FormID)
+		}
+		return strings.Join(ids, ",")
+	}
+	for _, tc := range []struct {
+		name   string
+		filter data.SubmissionFilter
+		want   string
+	}{
+		{"school", data.SubmissionFilter{School: "lincoln-elementary"}, "membership-seed-001,membership-seed-003"},
+		{"paid at a school", data.SubmissionFilter{School: "lincoln-elementary", Status: "paid"}, "membership-seed-001"},
+		{"unpaid", data.SubmissionFilter{Status: "unpaid"}, "membership-seed-003"},
+		{"date range", data.SubmissionFilter{From: time.Now().AddDate(0, 0, -10), To: time.Now().AddDate(0, 0, -3)}, "membership-seed-002"},
+		{"first page", data.SubmissionFilter{Limit: 2}, "membership-seed-002,membership-seed-003"},
+		{"second page", data.SubmissionFilter{Limit: 2, Offset: 2}, "membership-seed-001"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formIDs(t, tc.filter); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	events, err := data.ListEvents(data.SubmissionFilter{School: "washington-middle", Status: "paid"})
+	h.AssertNoError(t, err)
+	if len(events) != 1 || events[0].FormID != "event-seed-002" {
+		t.Errorf("expected the paid Washington registration, got %+v", events)
+	}
+
+	// Pages of the merged list line up with the whole of it
+	all, err := data.ListSubmissions(data.SubmissionFilter{})
+	h.AssertNoError(t, err)
+	page, err := data.ListSubmissions(data.SubmissionFilter{Limit: 2, Offset: 1})
+	h.AssertNoError(t, err)
+	if len(page) != 2 || page[0].FormID != all[len(all)-3].FormID || page[1].FormID != all[len(all)-2].FormID {
+		t.Errorf("expected the two submissions before the most recent, got %+v", page)
+	}
+}
+
+func TestSubmissionsViewCoversEveryFormType(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	for _, formType := range []string{"membership", "event", "fundraiser"} {
+		formID := formType + "-seed-001"
+		summary, err := data.GetSubmissionSummary(formID)
+		h.AssertNoError(t, err)
+		if summary.FormType != formType || summary.AccessToken == "" || summary.PayPalOrderID == "" {
+			t.Errorf("expected %s's shared fields from the view, got %+v", formID, summary)
+		}
+	}
+
+	fundraisers, err := data.ListSubmissions(data.SubmissionFilter{FormType: "fundraiser"})
+	h.AssertNoError(t, err)
+	if len(fundraisers) != 2 {
+		t.Errorf("expected the two seeded fundraisers, got %d", len(fundraisers))
+	}
+	for _, sub := range fundraisers {
+		if sub.FormType != "fundraiser" || sub.Item != "" {
+			t.Errorf("unexpected fundraiser summary %+v", sub)
+		}
+	}
+}
+
+func TestGetSubmissionByFormID(t *testing.T) {
+	suite := NewTestSuite(t)
+
+	membership := suite.GenerateTestMembership().ToMembershipSubmission()
+	event := suite.GenerateTestEvent().ToEventSubmission()
+	fundraiser := suite.GenerateTestFundraiser().ToFundraiserSubmission()
+	suite.AssertNoError(t, data.InsertMembership(membership))
+	suite.AssertNoError(t, data.InsertEvent(event))
+	suite.AssertNoError(t, data.InsertFundraiser(fundraiser))
+
+	for formID, email := range map[string]string{
+		membership.FormID: membership.Email,
+		event.FormID:      event.Email,
+		fundraiser.FormID: fundraiser.Email,
+	} {
+		sub, err := data.GetSubmissionByFormID(formID)
+		suite.AssertNoError(t, err)
+		fields := sub.Fields()
+		if fields.FormID != formID || fields.Email != email || !strings.HasPrefix(formID, sub.FormType()+"-") {
+			t.Errorf("%s: loaded the wrong submission: %s %+v", formID, sub.FormType(), fields)
+		}
+	}
+	if sub, _ := data.GetSubmissionByFormID(event.FormID); sub.(*data.EventSubmission).Event != event.Event {
+		t.Errorf("Expected the event registration with its event, got %+v", sub)
+	}
+
+	// A failed load returns a nil interface, not a nil pointer in one
+	for _, formID := range []string{"membership-missing", "donation-1", "nonsense"} {
+		if sub, err := data.GetSubmissionByFormID(formID); err == nil || sub != nil {
+			t.Errorf("%s: expected an error and no submission, got %v %v", formID, sub, err)
+		}
+	}
+}
+
+func TestResendEmails(t *testing.T) {
+	h := NewHarness(t)
+
+	_, err := fixtures.Load("../../testdata/seed")
+	h.AssertNoError(t, err)
+
+	// A resend goes out even when the confirmation was already sent
+	for i := 0; i < 2; i++ {
+		h.AssertNoError(t, order.ResendConfirmationEmail("event-seed-001"))
+	}
+	if sent := h.Mailer.SentTo("john.doe@example.com"); len(sent) != 2 {
+		t.Errorf("expected the event confirmation to be sent twice, got %d", len(sent))
+	}
+
+	h.AssertNoError(t, order.ResendAdminNotification("fundraiser-seed-001"))
+	last := h.Mailer.Sent()[len(h.Mailer.Sent())-1]
+	if !strings.Contains(last.Subject, "Fundraiser") {
+		t.Errorf("expected a fundraiser admin notification, got %q", last.Subject)
+	}
+
+	if err := order.ResendAdminNotification("event-seed-001"); err == nil {
+		t.Errorf("expected events to have no admin notification")
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	t.Parallel()
+	db := NewTestDB(t)
+
+	pending, err := data.PendingMigrations(db.DB)
+	db.AssertNoError(t, err)
+	if len(pending) != 0 {
+		t.Fatalf("expected a migrated database to have nothing pending, got %v", pending)
+	}
+
+	// Roll back to before the outbox and resume links existed
+	db.AssertNoError(t, data.RollbackTo(db.DB, 2))
+	pending, err = data.PendingMigrations(db.DB)
+	db.AssertNoError(t, err)
+	if len(pending) != data.LatestSchemaVersion()-2 || pending[0] != "3 outbox" {
+		t.Errorf("expected every migration after 2 pending, got %v", pending)
+	}
+	var tables int
+	db.AssertNoError(t, db.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'outbox_tasks'`).Scan(&tables))
+	if tables != 0 {
+		t.Errorf("expected rolling back to drop the outbox table")
+	}
+	var columns int
+	db.AssertNoError(t, db.DB.QueryRow(
+		`SELECT COUNT(*) FROM pragma_table_info('membership_submissions') WHERE name = 'resume_token'`).Scan(&columns))
+	if columns != 0 {
+		t.Errorf("expected rolling back to drop the resume_token column")
+	}
+
+	if err := data.RollbackTo(db.DB, 0); !errors.Is(err, data.ErrIrreversibleMigration) {
+		t.Errorf("expected the initial schema to refuse a rollback, got %v", err)
+	}
+
+	db.AssertNoError(t, data.Migrate(db.DB))
+	pending, err = data.PendingMigrations(db.DB)
+	db.AssertNoError(t, err)
+	if len(pending) != 0 {
+		t.Errorf("expected nothing pending after migrating, got %v", pending)
+	}
+
+	// A database migrated before migrations were numbered has them all pending, and
+	// applying them keeps its data
+	sub := db.GenerateTestMembership().ToMembershipSubmission()
+	db.AssertNoError(t, db.Memberships.Insert(sub))
+	_, err = db.DB.Exec(`DROP TABLE schema_migrations`)
+	db.AssertNoError(t, err)
+	pending, err = data.PendingMigrations(db.DB)
+	db.AssertNoError(t, err)
+	if len(pending) != data.LatestSchemaVersion() {
+		t.Errorf("expected every migration pending on an unversioned database, got %v", pending)
+	}
+	db.AssertNoError(t, data.Migrate(db.DB))
+	if _, err := db.Memberships.GetByID(sub.FormID); err != nil {
+		t.Errorf("expected the membership kept through migrating, got %v", err)
+	}
+}
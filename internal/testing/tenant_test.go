@@ -0,0 +1,179 @@
+package testing
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/tenant"
+)
+
+func TestLoadTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{
+			name: "HostsAndPrefix",
+			file: `{"tenants": [
+				{"id": "lincoln", "hosts": ["Lincoln.Example.org:443"]},
+				{"id": "roosevelt", "path_prefix": "roosevelt/"}
+			]}`,
+		},
+		{name: "Empty", file: `{"tenants": []}`, wantErr: "no tenants"},
+		{name: "BadID", file: `{"tenants": [{"id": "Lincoln Club", "hosts": ["a.org"]}]}`, wantErr: "lowercase"},
+		{
+			name:    "DuplicateID",
+			file:    `{"tenants": [{"id": "a", "hosts": ["a.org"]}, {"id": "a", "hosts": ["b.org"]}]}`,
+			wantErr: "listed twice",
+		},
+		{
+			name:    "SharedHost",
+			file:    `{"tenants": [{"id": "a", "hosts": ["a.org"]}, {"id": "b", "hosts": ["A.org."]}]}`,
+			wantErr: "both claim host",
+		},
+		{
+			name:    "SharedPrefix",
+			file:    `{"tenants": [{"id": "a", "path_prefix": "/x"}, {"id": "b", "path_prefix": "x/"}]}`,
+			wantErr: "both claim path prefix",
+		},
+		{name: "Unroutable", file: `{"tenants": [{"id": "a"}]}`, wantErr: "host or a path prefix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.json")
+			writeStaticFile(t, path, tt.file)
+
+			tenants, err := tenant.Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if got := tenants[0].Hosts[0]; got != "lincoln.example.org" {
+				t.Errorf("expected a normalized host, got %q", got)
+			}
+			if got := tenants[1].PathPrefix; got != "/roosevelt" {
+				t.Errorf("expected a normalized prefix, got %q", got)
+			}
+			if want := filepath.Join(filepath.Dir(path), "tenants", "roosevelt"); tenants[1].Dir != want {
+				t.Errorf("expected the default directory %s, got %s", want, tenants[1].Dir)
+			}
+		})
+	}
+}
+
+func TestTenantEnvPartitionsPaths(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "prod")
+	tn := tenant.Tenant{
+		ID:       "lincoln",
+		Dir:      "/srv/tenants/lincoln",
+		Settings: map[string]string{"PAYPAL_CLIENT_ID": "lincoln-client", "EVENT_ORDERS_PATH_PROD": "/srv/www/lincoln/events"},
+	}
+
+	env := make(map[string]string)
+	for _, kv := range tn.Env([]string{"PAYPAL_CLIENT_ID=shared-client", "LOGS_DIRECTORY_PROD=/var/log/sbc", "TIME_ZONE=America/Chicago"}) {
+		key, value, _ := strings.Cut(kv, "=")
+		env[key] = value
+	}
+
+	expect := map[string]string{
+		"TENANT_ID":                "lincoln",
+		"PAYPAL_CLIENT_ID":         "lincoln-client",
+		"LOGS_DIRECTORY_PROD":      "/srv/tenants/lincoln/logs",
+		"INVENTORY_JSON_PATH_PROD": "/srv/tenants/lincoln/inventory.json",
+		"EVENT_ORDERS_PATH_PROD":   "/srv/www/lincoln/events",
+		"TIME_ZONE":                "America/Chicago",
+	}
+	for key, want := range expect {
+		if env[key] != want {
+			t.Errorf("expected %s=%s, got %q", key, want, env[key])
+		}
+	}
+}
+
+func TestTenantRouterProxiesToBackends(t *testing.T) {
+	dir := t.TempDir()
+	tenants := []tenant.Tenant{
+		{ID: "lincoln", Hosts: []string{"lincoln.example.org"}},
+		{ID: "roosevelt", PathPrefix: "/roosevelt"},
+		{ID: "roosevelt-band", PathPrefix: "/roosevelt/band"},
+	}
+
+	// Each backend echoes what it received
+	sockets := make(map[string]string)
+	for _, tn := range tenants {
+		socket := filepath.Join(dir, tn.ID+".sock")
+		ln, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatalf("Failed to listen on %s: %v", socket, err)
+		}
+		id := tn.ID
+		backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s prefix=%q", id, r.Host, r.URL.Path, r.Header.Get("X-Forwarded-Prefix"))
+		}))
+		backend.Listener = ln
+		backend.Start()
+		t.Cleanup(backend.Close)
+		sockets[tn.ID] = socket
+	}
+
+	front := httptest.NewServer(tenant.NewRouter(tenants, sockets))
+	t.Cleanup(front.Close)
+
+	get := func(host, path string, header ...string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		host, path string
+		header     []string
+		want       string
+	}{
+		{"LINCOLN.example.org", "/api/csrf-token", nil, `lincoln LINCOLN.example.org /api/csrf-token prefix=""`},
+		// A client cannot claim a prefix the router did not strip
+		{"lincoln.example.org", "/healthz", []string{"X-Forwarded-Prefix", "/evil"}, `prefix=""`},
+		{"", "/roosevelt/api/csrf-token", nil, `roosevelt 127.0.0.1:`},
+		{"", "/roosevelt/api/csrf-token", nil, `/api/csrf-token prefix="/roosevelt"`},
+		{"", "/roosevelt", nil, `roosevelt`},
+		{"", "/roosevelt/band/events/x.html", nil, `roosevelt-band 127.0.0.1:`},
+		{"", "/roosevelt/band/events/x.html", nil, `/events/x.html prefix="/roosevelt/band"`},
+	}
+	for _, tt := range tests {
+		status, body := get(tt.host, tt.path, tt.header...)
+		if status != http.StatusOK || !strings.Contains(body, tt.want) {
+			t.Errorf("%s%s: expected %q, got %d %q", tt.host, tt.path, tt.want, status, body)
+		}
+	}
+
+	if status, _ := get("", "/rooseveltx/api"); status != http.StatusNotFound {
+		t.Errorf("expected 404 for a path no tenant claims, got %d", status)
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -112,122 +115,14 @@ func (ts *TestSuite) InitDatabase() error {
 		return fmt.Errorf("database ping failed: %w", err)
 	}
 
-	// Create all required tables with proper schema
-	if err := ts.createTestSchema(ctx); err != nil {
+	// Apply the production schema and migrations
+	if err := data.Migrate(db); err != nil {
 		return fmt.Errorf("failed to create test schema: %w", err)
 	}
 
 	return nil
 }
 
-// createTestSchema creates the complete database schema for testing
-func (ts *TestSuite) createTestSchema(ctx context.Context) error {
-	schemas := []string{
-		// Membership submissions table with all required columns
-		`CREATE TABLE IF NOT EXISTS membership_submissions (
-			form_id TEXT PRIMARY KEY,
-			access_token TEXT NOT NULL,
-			submission_date DATETIME NOT NULL,
-			full_name TEXT NOT NULL,
-			first_name TEXT,
-			last_name TEXT,
-			email TEXT NOT NULL,
-			school TEXT,
-			membership TEXT,
-			membership_status TEXT,
-			describe TEXT,
-			student_count INTEGER DEFAULT 0,
-			students_json TEXT,
-			interests_json TEXT,
-			addons_json TEXT,
-			fees_json TEXT,
-			donation REAL DEFAULT 0,
-			calculated_amount REAL DEFAULT 0,
-			cover_fees BOOLEAN DEFAULT 0,
-			paypal_order_id TEXT,
-			paypal_order_created_at DATETIME,
-			paypal_status TEXT,
-			paypal_details TEXT,
-			submitted BOOLEAN DEFAULT 0,
-			submitted_at DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Event submissions table with has_food_orders column
-		`CREATE TABLE IF NOT EXISTS event_submissions (
-			form_id TEXT PRIMARY KEY,
-			access_token TEXT NOT NULL,
-			submission_date DATETIME NOT NULL,
-			event TEXT NOT NULL,
-			full_name TEXT NOT NULL,
-			first_name TEXT,
-			last_name TEXT,
-			email TEXT NOT NULL,
-			school TEXT,
-			student_count INTEGER DEFAULT 0,
-			students_json TEXT,
-			food_choices_json TEXT,
-			has_food_orders BOOLEAN DEFAULT 0,
-			food_order_id TEXT,
-			calculated_amount REAL DEFAULT 0,
-			cover_fees BOOLEAN DEFAULT 0,
-			paypal_order_id TEXT,
-			paypal_order_created_at DATETIME,
-			paypal_status TEXT,
-			paypal_details TEXT,
-			submitted BOOLEAN DEFAULT 0,
-			submitted_at DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Fundraiser submissions table
-		`CREATE TABLE IF NOT EXISTS fundraiser_submissions (
-			form_id TEXT PRIMARY KEY,
-			access_token TEXT NOT NULL,
-			submission_date DATETIME NOT NULL,
-			full_name TEXT NOT NULL,
-			first_name TEXT,
-			last_name TEXT,
-			email TEXT NOT NULL,
-			school TEXT,
-			describe TEXT,
-			donor_status TEXT,
-			student_count INTEGER DEFAULT 0,
-			students_json TEXT,
-			donation_items_json TEXT,
-			total_amount REAL DEFAULT 0,
-			calculated_amount REAL DEFAULT 0,
-			cover_fees BOOLEAN DEFAULT 0,
-			paypal_order_id TEXT,
-			paypal_order_created_at DATETIME,
-			paypal_status TEXT,
-			paypal_details TEXT,
-			submitted BOOLEAN DEFAULT 0,
-			submitted_at DATETIME,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		// Create indexes for better performance
-		`CREATE INDEX IF NOT EXISTS idx_membership_email ON membership_submissions(email)`,
-		`CREATE INDEX IF NOT EXISTS idx_membership_submitted_at ON membership_submissions(submitted_at)`,
-		`CREATE INDEX IF NOT EXISTS idx_event_email ON event_submissions(email)`,
-		`CREATE INDEX IF NOT EXISTS idx_event_submitted_at ON event_submissions(submitted_at)`,
-		`CREATE INDEX IF NOT EXISTS idx_fundraiser_email ON fundraiser_submissions(email)`,
-		`CREATE INDEX IF NOT EXISTS idx_fundraiser_submitted_at ON fundraiser_submissions(submitted_at)`,
-	}
-
-	for _, schema := range schemas {
-		if _, err := ts.DB.ExecContext(ctx, schema); err != nil {
-			return fmt.Errorf("failed to create schema: %w", err)
-		}
-	}
-
-	return nil
-}
-
 // Cleanup removes temporary test files and closes database
 func (ts *TestSuite) Cleanup() {
 	// Close database connection first
@@ -324,6 +219,36 @@ func (ts *TestSuite) MakeAPIRequest(method, path string, body interface{}, token
 	return ts.Client.Do(req)
 }
 
+// MakeFormRequest submits fields form-encoded, as an HTML form would; a []string value
+// sends the field once per item
+func (ts *TestSuite) MakeFormRequest(method, path string, fields map[string]interface{}) (*http.Response, error) {
+	form := url.Values{}
+	for name, value := range fields {
+		switch v := value.(type) {
+		case []string:
+			for _, item := range v {
+				form.Add(name, item)
+			}
+		default:
+			form.Set(name, fmt.Sprint(v))
+		}
+	}
+
+	req, err := http.NewRequest(method, ts.Server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return ts.Client.Do(req)
+}
+
+// ReadResponseBody reads and closes a response's body, returning it as a string
+func (ts *TestSuite) ReadResponseBody(resp *http.Response) string {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
 // ParseJSONResponse parses a JSON response into the provided interface
 func (ts *TestSuite) ParseJSONResponse(resp *http.Response, dest interface{}) error {
 	defer resp.Body.Close()
@@ -384,6 +309,7 @@ func createTestInventory(path string) error {
 		},
 		"events": map[string]interface{}{
 			"spring-festival": map[string]interface{}{
+				"change_cutoff": "2099-12-31",
 				"per_student_options": map[string]interface{}{
 					"registration": map[string]interface{}{
 						"label": "Festival Registration", "price": 25.0, "required": true,
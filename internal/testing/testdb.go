@@ -0,0 +1,49 @@
+// testdb.go - Gives each test its own migrated SQLite database
+package testing
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"sbcbackend/internal/data"
+)
+
+// TestDB is a private database for one test, with repositories bound to it. Nothing
+// else shares it, so tests using it can call t.Parallel(). The embedded TestSuite
+// supplies the test data generators and assertions; its DB is the private handle.
+type TestDB struct {
+	*TestSuite
+	Memberships *data.MembershipRepository
+	Events      *data.EventRepository
+	Fundraisers *data.FundraiserRepository
+	Outbox      *data.OutboxRepository
+}
+
+// NewTestDB opens a fresh SQLite file in the test's temp directory and applies the
+// production schema. A file is used rather than :memory: because every pooled
+// connection to :memory: would see a different, empty database. The busy timeout
+// makes concurrent writers wait for the lock instead of failing with SQLITE_BUSY.
+func NewTestDB(t *testing.T) *TestDB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := data.Migrate(conn); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	return &TestDB{
+		TestSuite:   &TestSuite{DB: conn},
+		Memberships: data.NewMembershipRepositoryWithDB(conn),
+		Events:      data.NewEventRepositoryWithDB(conn),
+		Fundraisers: data.NewFundraiserRepositoryWithDB(conn),
+		Outbox:      data.NewOutboxRepositoryWithDB(conn),
+	}
+}
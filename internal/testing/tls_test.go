@@ -0,0 +1,79 @@
+package testing
+
+import (
+	"testing"
+
+	"sbcbackend/internal/config"
+)
+
+func TestLoadTLSSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		enabled bool
+		wantErr bool
+	}{
+		{name: "PlainHTTP", env: map[string]string{}},
+		{
+			name:    "ManualCertificate",
+			env:     map[string]string{"TLS_CERT_FILE_DEV": "cert.pem", "TLS_KEY_FILE_DEV": "key.pem"},
+			enabled: true,
+		},
+		{
+			name:    "CertificateWithoutKey",
+			env:     map[string]string{"TLS_CERT_FILE_DEV": "cert.pem"},
+			wantErr: true,
+		},
+		{
+			name:    "Autocert",
+			env:     map[string]string{"TLS_AUTOCERT_DOMAINS_DEV": "Pay.Example.org, www.example.org"},
+			enabled: true,
+		},
+		{
+			name: "ManualAndAutocert",
+			env: map[string]string{
+				"TLS_CERT_FILE_DEV":        "cert.pem",
+				"TLS_KEY_FILE_DEV":         "key.pem",
+				"TLS_AUTOCERT_DOMAINS_DEV": "pay.example.org",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENVIRONMENT", "dev")
+			for _, key := range []string{"TLS_CERT_FILE_DEV", "TLS_KEY_FILE_DEV", "TLS_AUTOCERT_DOMAINS_DEV", "TLS_AUTOCERT_CACHE_DEV", "TLS_HTTP_ADDR_DEV"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			settings, err := config.LoadTLSSettings()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTLSSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if settings.Enabled() != tt.enabled {
+				t.Errorf("Enabled() = %v, want %v", settings.Enabled(), tt.enabled)
+			}
+		})
+	}
+
+	t.Run("AutocertDefaults", func(t *testing.T) {
+		t.Setenv("ENVIRONMENT", "dev")
+		t.Setenv("TLS_AUTOCERT_DOMAINS_DEV", "Pay.Example.org, www.example.org")
+		t.Setenv("TLS_HTTP_ADDR_DEV", "")
+
+		settings, err := config.LoadTLSSettings()
+		if err != nil {
+			t.Fatalf("LoadTLSSettings() error = %v", err)
+		}
+		if len(settings.AutocertDomains) != 2 || settings.AutocertDomains[0] != "pay.example.org" {
+			t.Errorf("unexpected domains %v", settings.AutocertDomains)
+		}
+		if settings.HTTPAddr != ":80" || settings.AutocertCacheDir == "" {
+			t.Errorf("expected the challenge listener on :80 and a cache directory, got %q and %q", settings.HTTPAddr, settings.AutocertCacheDir)
+		}
+	})
+}
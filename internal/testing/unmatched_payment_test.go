@@ -0,0 +1,108 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/payment"
+	"sbcbackend/internal/security"
+)
+
+func TestUnmatchedPayments(t *testing.T) {
+	previous := config.UseMockWebhookVerification
+	config.UseMockWebhookVerification = true
+	t.Cleanup(func() { config.UseMockWebhookVerification = previous })
+
+	h := NewHarness(t)
+	member := h.GenerateTestMembership().ToMembershipSubmission()
+	member.CalculatedAmount = 75
+	h.AssertNoError(t, data.InsertMembership(member))
+
+	// A $75 capture for an invoice checkout never created, delivered twice
+	corpus, err := os.ReadFile(filepath.Join(webhookCorpusDir, "capture_completed.json"))
+	h.AssertNoError(t, err)
+	payload := bytes.ReplaceAll(corpus, []byte("membership-20240314-corpus"), []byte("DONATE-BUTTON-17"))
+	for i := 0; i < 2; i++ {
+		resp, err := h.Client.Post(h.Server.URL+"/api/paypal-webhook", "application/json", bytes.NewReader(payload))
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected the webhook acknowledged, got %d", resp.StatusCode)
+		}
+	}
+
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+	admin := func(method, query string, body interface{}) (int, []byte) {
+		t.Helper()
+		var reader io.Reader
+		if body != nil {
+			raw, _ := json.Marshal(body)
+			reader = bytes.NewReader(raw)
+		}
+		req, err := http.NewRequest(method, h.Server.URL+"/api/admin/unmatched-payments"+query, reader)
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Token", adminToken)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, raw
+	}
+
+	_, raw := admin(http.MethodGet, "", nil)
+	var listed struct {
+		Data struct {
+			Payments []data.UnmatchedPayment `json:"payments"`
+		} `json:"data"`
+	}
+	h.AssertNoError(t, json.Unmarshal(raw, &listed))
+	payments := listed.Data.Payments
+	if len(payments) != 1 || payments[0].CaptureID != "3C679366HH908993F" || payments[0].Amount != 75 ||
+		payments[0].InvoiceID != "DONATE-BUTTON-17" || payments[0].OrderID != "5O190127TN364715T" {
+		t.Fatalf("expected the capture recorded once as unmatched, got %+v", payments)
+	}
+	id := payments[0].ID
+
+	if code, raw := admin(http.MethodGet, "?id="+strconv.FormatInt(id, 10), nil); code != http.StatusOK ||
+		!strings.Contains(string(raw), "seller_receivable_breakdown") {
+		t.Errorf("expected the payment's PayPal details, got %d: %s", code, raw)
+	}
+
+	link := payment.LinkUnmatchedPaymentRequest{ID: id, FormID: member.FormID}
+	if code, raw := admin(http.MethodPost, "", link); code != http.StatusOK {
+		t.Fatalf("link returned %d: %s", code, raw)
+	}
+	summary, err := data.GetSubmissionSummary(member.FormID)
+	h.AssertNoError(t, err)
+	if summary.PayPalStatus != "COMPLETED" || summary.ReceiptNumber == "" {
+		t.Errorf("expected the membership paid with a receipt, got %+v", summary)
+	}
+	details, err := data.GetPaymentDetails("membership", member.FormID)
+	h.AssertNoError(t, err)
+	if captureID := data.ExtractPayPalCaptureID(details, member.FormID); captureID != "3C679366HH908993F" {
+		t.Errorf("expected the linked capture to be refundable, got capture %q", captureID)
+	}
+
+	if code, _ := admin(http.MethodPost, "", link); code != http.StatusConflict {
+		t.Errorf("expected a second link refused, got %d", code)
+	}
+	if _, raw := admin(http.MethodGet, "", nil); strings.Contains(string(raw), "3C679366HH908993F") {
+		t.Errorf("expected linked payments left out of the list, got %s", raw)
+	}
+	if _, raw := admin(http.MethodGet, "?all=true", nil); !strings.Contains(string(raw), member.FormID) {
+		t.Errorf("expected all=true to include the linked payment, got %s", raw)
+	}
+}
@@ -0,0 +1,236 @@
+package testing
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/security"
+)
+
+// The corpus in testdata/webhooks holds sanitized payloads as PayPal sends them.
+// Each one names its submission through invoice_id, or invoice_number for disputes.
+const webhookCorpusDir = "testdata/webhooks"
+
+const (
+	corpusMembershipID = "membership-20240314-corpus"
+	corpusEventID      = "event-20240314-corpus"
+	corpusFundraiserID = "fundraiser-20240314-corpus"
+)
+
+// seedCorpusSubmission inserts the submission a corpus payload refers to, in the given status
+func seedCorpusSubmission(t *testing.T, h *Harness, formType, status string) {
+	t.Helper()
+
+	var err error
+	switch formType {
+	case "membership":
+		sub := h.GenerateTestMembership().ToMembershipSubmission()
+		sub.FormID, sub.CalculatedAmount, sub.PayPalStatus = corpusMembershipID, 75, status
+		err = data.InsertMembership(sub)
+	case "event":
+		sub := h.GenerateTestEvent().ToEventSubmission()
+		sub.FormID, sub.CalculatedAmount, sub.PayPalStatus = corpusEventID, 70, status
+		err = data.InsertEvent(sub)
+	case "fundraiser":
+		sub := h.GenerateTestFundraiser().ToFundraiserSubmission()
+		sub.FormID, sub.CalculatedAmount, sub.PayPalStatus = corpusFundraiserID, 51.49, status
+		err = data.InsertFundraiser(sub)
+	default:
+		t.Fatalf("unknown form type %s", formType)
+	}
+	h.AssertNoError(t, err)
+}
+
+// postWebhook sends a corpus file to the webhook endpoint and returns the status code
+func postWebhook(t *testing.T, h *Harness, file string) int {
+	t.Helper()
+
+	payload, err := os.ReadFile(filepath.Join(webhookCorpusDir, file))
+	if err != nil {
+		t.Fatalf("Failed to read corpus file %s: %v", file, err)
+	}
+	resp, err := h.Client.Post(h.Server.URL+"/api/paypal-webhook", "application/json", bytes.NewReader(payload))
+	h.AssertNoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// webhookState reads back the status and recorded webhook resource for a submission
+func webhookState(t *testing.T, h *Harness, formType, formID string) (string, string) {
+	t.Helper()
+
+	tables := map[string]string{
+		"membership": "membership_submissions",
+		"event":      "event_submissions",
+		"fundraiser": "fundraiser_submissions",
+	}
+	var status, webhook sql.NullString
+	err := h.DB.QueryRow(fmt.Sprintf("SELECT paypal_status, paypal_webhook FROM %s WHERE form_id = ?", tables[formType]), formID).
+		Scan(&status, &webhook)
+	h.AssertNoError(t, err)
+	return status.String, webhook.String
+}
+
+func TestWebhookCorpus(t *testing.T) {
+	previous := config.UseMockWebhookVerification
+	config.UseMockWebhookVerification = true
+	t.Cleanup(func() { config.UseMockWebhookVerification = previous })
+
+	cases := []struct {
+		name        string
+		formType    string
+		formID      string
+		startStatus string
+		files       []string // delivered in order
+		wantCode    int
+		wantStatus  string
+		wantWebhook bool // whether the resource is recorded on the submission
+		wantAlerts  int
+	}{
+		{"capture completed", "membership", corpusMembershipID, "CREATED",
+			[]string{"capture_completed.json"}, http.StatusOK, "COMPLETED", true, 1},
+		{"capture denied", "membership", corpusMembershipID, "PENDING",
+			[]string{"capture_denied.json"}, http.StatusOK, "DECLINED", true, 1},
+		{"full refund", "event", corpusEventID, "COMPLETED",
+			[]string{"refund_full.json"}, http.StatusOK, "REFUNDED", true, 1},
+		{"partial refund leaves order paid", "event", corpusEventID, "COMPLETED",
+			[]string{"refund_partial.json"}, http.StatusOK, "COMPLETED", true, 1},
+		{"partial then full refund", "event", corpusEventID, "COMPLETED",
+			[]string{"refund_partial.json", "refund_full.json"}, http.StatusOK, "REFUNDED", true, 2},
+		{"late capture after refund", "membership", corpusMembershipID, "REFUNDED",
+			[]string{"capture_completed.json"}, http.StatusOK, "REFUNDED", true, 1},
+		{"dispute opened marks the order disputed", "membership", corpusMembershipID, "COMPLETED",
+			[]string{"dispute_created.json"}, http.StatusOK, "DISPUTED", true, 2},
+		{"dispute on a refunded order", "membership", corpusMembershipID, "REFUNDED",
+			[]string{"dispute_created.json"}, http.StatusOK, "REFUNDED", true, 2},
+		{"late capture while disputed", "membership", corpusMembershipID, "DISPUTED",
+			[]string{"capture_completed.json"}, http.StatusOK, "DISPUTED", true, 1},
+		{"dispute resolved for buyer", "fundraiser", corpusFundraiserID, "COMPLETED",
+			[]string{"dispute_resolved_buyer.json"}, http.StatusOK, "REFUNDED", true, 2},
+		{"event without invoice is ignored", "membership", corpusMembershipID, "CREATED",
+			[]string{"no_invoice.json"}, http.StatusOK, "CREATED", false, 0},
+		{"malformed payload is rejected", "membership", corpusMembershipID, "CREATED",
+			[]string{"malformed.json"}, http.StatusBadRequest, "CREATED", false, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHarness(t)
+			seedCorpusSubmission(t, h, tc.formType, tc.startStatus)
+
+			for _, file := range tc.files {
+				if code := postWebhook(t, h, file); code != tc.wantCode {
+					t.Fatalf("%s: expected HTTP %d, got %d", file, tc.wantCode, code)
+				}
+			}
+
+			status, webhook := webhookState(t, h, tc.formType, tc.formID)
+			if status != tc.wantStatus {
+				t.Errorf("expected paypal_status %q, got %q", tc.wantStatus, status)
+			}
+			if recorded := webhook != ""; recorded != tc.wantWebhook {
+				t.Errorf("expected webhook recorded=%v, got %q", tc.wantWebhook, webhook)
+			}
+			if alerts := len(h.Mailer.Sent()); alerts != tc.wantAlerts {
+				t.Errorf("expected %d alert emails, got %d", tc.wantAlerts, alerts)
+			}
+		})
+	}
+}
+
+func TestWebhookEventsRecordedAndReplayed(t *testing.T) {
+	previous := config.UseMockWebhookVerification
+	config.UseMockWebhookVerification = true
+	t.Cleanup(func() { config.UseMockWebhookVerification = previous })
+
+	h := NewHarness(t)
+	seedCorpusSubmission(t, h, "membership", "CREATED")
+	adminToken, err := security.GenerateAccessToken()
+	h.AssertNoError(t, err)
+	security.StoreAccessToken(adminToken, "ADMIN", "admin_access")
+
+	admin := func(method, query string) (int, data.WebhookEvent, []data.WebhookEvent) {
+		t.Helper()
+		req, err := http.NewRequest(method, h.Server.URL+"/api/admin/webhook-events?"+query, nil)
+		h.AssertNoError(t, err)
+		req.Header.Set("X-Admin-Token", adminToken)
+		req.Header.Set("Referer", h.Server.URL+"/info")
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		var body struct {
+			Data json.RawMessage `json:"data"`
+		}
+		h.AssertNoError(t, h.ParseJSONResponse(resp, &body))
+		var event data.WebhookEvent
+		var list struct {
+			Events []data.WebhookEvent `json:"events"`
+		}
+		json.Unmarshal(body.Data, &event)
+		json.Unmarshal(body.Data, &list)
+		return resp.StatusCode, event, list.Events
+	}
+	events := func(filter data.WebhookEventFilter) []data.WebhookEvent {
+		t.Helper()
+		list, err := data.ListWebhookEvents(filter)
+		h.AssertNoError(t, err)
+		return list
+	}
+
+	// A redelivery of a processed event is counted but not applied again
+	for i := 0; i < 2; i++ {
+		if code := postWebhook(t, h, "capture_completed.json"); code != http.StatusOK {
+			t.Fatalf("expected the capture accepted, got %d", code)
+		}
+	}
+	captures := events(data.WebhookEventFilter{EventType: "PAYMENT.CAPTURE.COMPLETED"})
+	if len(captures) != 1 || captures[0].Result != data.WebhookProcessed || captures[0].Deliveries != 2 ||
+		captures[0].FormID != corpusMembershipID || captures[0].EventID != "WH-2WR32451HC0233532-67976317FL4543714" {
+		t.Fatalf("expected one processed capture delivered twice, got %+v", captures)
+	}
+	if alerts := len(h.Mailer.Sent()); alerts != 1 {
+		t.Errorf("expected the redelivery skipped with one alert email, got %d", alerts)
+	}
+
+	postWebhook(t, h, "no_invoice.json")
+	if ignored := events(data.WebhookEventFilter{Result: data.WebhookIgnored}); len(ignored) != 1 {
+		t.Errorf("expected the event without an invoice recorded as ignored, got %+v", ignored)
+	}
+
+	// A refund for an order not in the database yet is kept, then replayed once it is
+	postWebhook(t, h, "refund_full.json")
+	unmatched := events(data.WebhookEventFilter{Result: data.WebhookUnmatched})
+	if len(unmatched) != 1 || unmatched[0].FormID != corpusEventID {
+		t.Fatalf("expected the refund for the unknown event unmatched, got %+v", unmatched)
+	}
+	seedCorpusSubmission(t, h, "event", "COMPLETED")
+	id := strconv.FormatInt(unmatched[0].ID, 10)
+	code, replayed, _ := admin(http.MethodPost, "id="+id)
+	if code != http.StatusOK || replayed.Result != data.WebhookProcessed || replayed.Replays != 1 || replayed.ProcessedAt == nil {
+		t.Fatalf("expected the replay to apply the refund, got %d %+v", code, replayed)
+	}
+	if status, _ := webhookState(t, h, "event", corpusEventID); status != "REFUNDED" {
+		t.Errorf("expected the replayed refund to mark the event REFUNDED, got %q", status)
+	}
+
+	code, one, _ := admin(http.MethodGet, "id="+id)
+	if code != http.StatusOK || one.EventType != "PAYMENT.CAPTURE.REFUNDED" || !strings.Contains(one.Payload, `"resource"`) {
+		t.Errorf("expected the event with its payload, got %d %+v", code, one)
+	}
+	code, _, listed := admin(http.MethodGet, "formID="+corpusMembershipID)
+	if code != http.StatusOK || len(listed) != 1 || listed[0].Payload != "" {
+		t.Errorf("expected the membership's one event listed without its payload, got %d %+v", code, listed)
+	}
+	if code, _, _ := admin(http.MethodPost, "id=999999"); code != http.StatusNotFound {
+		t.Errorf("expected replaying an unknown event to be 404, got %d", code)
+	}
+}
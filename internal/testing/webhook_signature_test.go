@@ -0,0 +1,89 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sbcbackend/internal/config"
+)
+
+func TestWebhookSignatureVerification(t *testing.T) {
+	h := NewHarness(t)
+	previousMock, previousID := config.UseMockWebhookVerification, config.PayPalWebhookID
+	config.UseMockWebhookVerification = false
+	config.PayPalWebhookID = "WH-TEST-HOOK"
+	t.Cleanup(func() {
+		config.UseMockWebhookVerification, config.PayPalWebhookID = previousMock, previousID
+	})
+	h.PayPal.ValidWebhookSignature = "signed-by-paypal"
+
+	seedCorpusSubmission(t, h, "membership", "CREATED")
+	payload, err := os.ReadFile(filepath.Join(webhookCorpusDir, "capture_completed.json"))
+	h.AssertNoError(t, err)
+
+	post := func(headers map[string]string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/paypal-webhook", bytes.NewReader(payload))
+		h.AssertNoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := h.Client.Do(req)
+		h.AssertNoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	signed := func(signature string) map[string]string {
+		return map[string]string{
+			"Paypal-Transmission-Id":   "69cd13f0-d67a-11e5-baa3-778b53f4ae55",
+			"Paypal-Transmission-Sig":  signature,
+			"Paypal-Transmission-Time": "2024-03-14T16:02:41Z",
+			"Paypal-Cert-Url":          "https://api.sandbox.paypal.com/v1/notifications/certs/CERT-360caa42-fca2a594-1d93a270",
+			"Paypal-Auth-Algo":         "SHA256withRSA",
+		}
+	}
+
+	if code := post(nil); code != http.StatusUnauthorized {
+		t.Errorf("expected an unsigned webhook to be rejected, got %d", code)
+	}
+	if len(h.PayPal.Verifications) != 0 {
+		t.Errorf("expected an unsigned webhook to be rejected without asking PayPal")
+	}
+	if code := post(signed("forged")); code != http.StatusUnauthorized {
+		t.Errorf("expected a forged signature to be rejected, got %d", code)
+	}
+	if status, _ := webhookState(t, h, "membership", corpusMembershipID); status != "CREATED" {
+		t.Fatalf("expected rejected webhooks to leave the membership alone, got %s", status)
+	}
+
+	if code := post(signed("signed-by-paypal")); code != http.StatusOK {
+		t.Fatalf("expected a verified webhook to be accepted, got %d", code)
+	}
+	if status, _ := webhookState(t, h, "membership", corpusMembershipID); status != "COMPLETED" {
+		t.Errorf("expected the verified webhook recorded, got %s", status)
+	}
+
+	// PayPal is asked with our webhook ID and the event exactly as delivered
+	verification := h.PayPal.Verifications[len(h.PayPal.Verifications)-1]
+	event, _ := json.Marshal(verification["webhook_event"])
+	var sent, delivered interface{}
+	json.Unmarshal(event, &sent)
+	json.Unmarshal(payload, &delivered)
+	sentJSON, _ := json.Marshal(sent)
+	deliveredJSON, _ := json.Marshal(delivered)
+	if verification["webhook_id"] != "WH-TEST-HOOK" || verification["auth_algo"] != "SHA256withRSA" ||
+		!bytes.Equal(sentJSON, deliveredJSON) {
+		t.Errorf("expected the delivered event verified against our webhook ID, got %v", verification)
+	}
+
+	// Without a webhook ID nothing can be verified, so everything is rejected
+	config.PayPalWebhookID = ""
+	if code := post(signed("signed-by-paypal")); code != http.StatusUnauthorized {
+		t.Errorf("expected webhooks rejected without a configured webhook ID, got %d", code)
+	}
+}
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/middleware"
+)
+
+// eventsPageSize is how many webhook events a list request returns by default
+const eventsPageSize = 100
+
+// AdminEventsHandler lets an admin look through the PayPal webhooks received. GET lists
+// them newest first without their payloads, filtered by ?type=, ?formID= and ?result=
+// and a page at a time (limit, offset, and from/to days received), or shows one with
+// its payload (?id=<id>); POST ?id=<id> processes one again and returns its new result.
+func AdminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.LogHTTPRequest(r)
+
+	var id int64
+	if idParam := r.URL.Query().Get("id"); idParam != "" {
+		var err error
+		if id, err = strconv.ParseInt(idParam, 10, 64); err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_id", "The id parameter must be a number", "")
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if id != 0 {
+			event, err := data.GetWebhookEvent(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Webhook event not found", "")
+				return
+			}
+			if err != nil {
+				logger.LogError("Failed to load webhook event %d: %v", id, err)
+				middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to load the webhook event", "")
+				return
+			}
+			middleware.WriteAPISuccess(w, r, event)
+			return
+		}
+
+		list, err := middleware.ParseListQuery(r, eventsPageSize)
+		if err != nil {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "invalid_query", err.Error(), "")
+			return
+		}
+		query := r.URL.Query()
+		events, err := data.ListWebhookEvents(data.WebhookEventFilter{
+			EventType: query.Get("type"),
+			FormID:    query.Get("formID"),
+			Result:    query.Get("result"),
+			From:      list.From,
+			To:        list.To,
+			Limit:     list.Limit,
+			Offset:    list.Offset,
+		})
+		if err != nil {
+			logger.LogError("Failed to list webhook events: %v", err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list webhook events", "")
+			return
+		}
+		middleware.WriteAPISuccess(w, r, map[string]interface{}{
+			"events": events,
+			"limit":  list.Limit,
+			"offset": list.Offset,
+		})
+
+	case http.MethodPost:
+		if id == 0 {
+			middleware.WriteAPIError(w, r, http.StatusBadRequest, "missing_id", "Missing the id of the webhook event to replay", "")
+			return
+		}
+		event, err := ReplayEvent(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			middleware.WriteAPIError(w, r, http.StatusNotFound, "not_found", "Webhook event not found", "")
+			return
+		}
+		if err != nil {
+			logger.LogError("Failed to replay webhook event %d: %v", id, err)
+			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "internal_error", "Failed to replay the webhook event", "")
+			return
+		}
+		logger.LogInfo("Admin replayed webhook event %d: %s", id, event.Result)
+		middleware.WriteAPISuccess(w, r, event)
+
+	default:
+		middleware.WriteAPIError(w, r, http.StatusMethodNotAllowed, "method_not_allowed",
+			"Only GET and POST requests are supported", "")
+	}
+}
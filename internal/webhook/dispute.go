@@ -0,0 +1,155 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sbcbackend/internal/data"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notification"
+	"sbcbackend/internal/notify"
+	"sbcbackend/internal/paypal"
+)
+
+// recordDispute keeps a CUSTOMER.DISPUTE.* event against the submission it matched and
+// emails the board when a dispute opens or is resolved, so a charge reversal is never a
+// surprise. A dispute the club wins puts the payment back to COMPLETED. It returns what
+// couldn't be recorded.
+func recordDispute(ctx context.Context, event WebhookEvent, formType, formID string) error {
+	resource, err := paypal.ParseDispute([]byte(event.ResourceJSON))
+	if err != nil || resource.DisputeID == "" {
+		logger.LogWarn("%s webhook for %s has no dispute ID; not recorded", event.EventType, formID)
+		return fmt.Errorf("%s webhook for %s has no dispute ID", event.EventType, formID)
+	}
+
+	now := time.Now()
+	dispute := data.Dispute{
+		DisputeID: resource.DisputeID,
+		FormID:    formID,
+		FormType:  formType,
+		Reason:    resource.Reason,
+		Status:    resource.Status,
+		Stage:     resource.DisputeLifeCycleStage,
+		Outcome:   resource.Outcome(),
+		Amount:    resource.DisputeAmount.Amount(),
+		RespondBy: resource.SellerResponseDueDate,
+		Details:   event.ResourceJSON,
+		OpenedAt:  disputeTime(resource.CreateTime, now),
+		UpdatedAt: disputeTime(resource.UpdateTime, now),
+	}
+	if resource.DisputeAmount != nil {
+		dispute.Currency = resource.DisputeAmount.CurrencyCode
+	}
+	if dispute.Outcome != "" {
+		resolvedAt := dispute.UpdatedAt
+		dispute.ResolvedAt = &resolvedAt
+	}
+
+	opened, err := data.RecordDispute(dispute)
+	failed := err
+	if err != nil {
+		logger.LogError("Failed to record dispute %s for %s: %v", dispute.DisputeID, formID, err)
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal dispute %s for %s could not be recorded: %v",
+			dispute.DisputeID, formID, err))
+	}
+
+	resolved := event.EventType == "CUSTOMER.DISPUTE.RESOLVED"
+	if resolved && dispute.Outcome != paypal.OutcomeBuyerFavour {
+		if _, err := data.RestoreDisputedPayment(formType, formID); err != nil {
+			logger.LogError("Failed to restore payment of %s after dispute %s: %v", formID, dispute.DisputeID, err)
+			failed = errors.Join(failed, err)
+		}
+	}
+
+	// PayPal redelivers webhooks, so only a dispute seen for the first time is announced
+	switch {
+	case event.EventType == "CUSTOMER.DISPUTE.CREATED" && (opened || err != nil):
+		logger.LogWarn("PayPal dispute %s opened for %s: %s, $%.2f", dispute.DisputeID, formID, dispute.Reason, dispute.Amount)
+		notify.DisputeOpened(formID, event.Summary)
+	case resolved:
+		logger.LogInfo("PayPal dispute %s for %s resolved: %s", dispute.DisputeID, formID, dispute.Outcome)
+	default:
+		return failed
+	}
+
+	notice := disputeNoticeData{Dispute: dispute, Resolved: resolved}
+	if summary, err := data.GetSubmissionSummary(formID); err == nil {
+		notice.Submission = summary
+	}
+	if err := notification.Send(ctx, notification.Notification{
+		Template:   disputeNotice,
+		Data:       notice,
+		Recipients: []notification.Recipient{notification.Staff()},
+	}); err != nil {
+		logger.LogWarn("Failed to email the board about dispute %s: %v", dispute.DisputeID, err)
+	}
+	return failed
+}
+
+// disputeTime parses a dispute timestamp, falling back when PayPal left it out
+func disputeTime(value string, fallback time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	return fallback
+}
+
+type disputeNoticeData struct {
+	Dispute    data.Dispute
+	Submission *data.SubmissionSummary // nil when it couldn't be loaded
+	Resolved   bool
+}
+
+// disputeNotice tells the board about a dispute opening, with what they need to respond
+// in PayPal before the deadline, or about how it was resolved
+var disputeNotice = notification.Template{
+	Name: "dispute notice",
+	Channels: map[string]notification.RenderFunc{
+		notification.ChannelEmail: func(d interface{}) (notification.Message, error) {
+			notice := d.(disputeNoticeData)
+			dispute := notice.Dispute
+
+			who := dispute.FormID
+			if notice.Submission != nil {
+				who = fmt.Sprintf("%s (%s)", notice.Submission.FullName, dispute.FormID)
+			}
+			subject := fmt.Sprintf("PayPal dispute opened: %s", who)
+			if notice.Resolved {
+				subject = fmt.Sprintf("PayPal dispute resolved: %s", who)
+			}
+
+			var body strings.Builder
+			if notice.Resolved {
+				fmt.Fprintf(&body, "PayPal resolved dispute %s against the payment for %s.\n\n", dispute.DisputeID, who)
+			} else {
+				fmt.Fprintf(&body, "A PayPal dispute was opened against the payment for %s. The submission is marked DISPUTED until it is resolved.\n\n", who)
+			}
+			fmt.Fprintf(&body, "Case: %s\n", dispute.DisputeID)
+			if notice.Submission != nil {
+				fmt.Fprintf(&body, "Submission: %s, $%.2f, %s\n", notice.Submission.FormType, notice.Submission.CalculatedAmount, notice.Submission.Email)
+			}
+			fmt.Fprintf(&body, "Disputed amount: $%.2f %s\n", dispute.Amount, dispute.Currency)
+			if dispute.Reason != "" {
+				fmt.Fprintf(&body, "Reason: %s\n", dispute.Reason)
+			}
+			if dispute.Stage != "" {
+				fmt.Fprintf(&body, "Stage: %s\n", dispute.Stage)
+			}
+			switch {
+			case dispute.Outcome == paypal.OutcomeBuyerFavour:
+				body.WriteString("Outcome: resolved in the buyer's favour; the submission is marked REFUNDED\n")
+			case dispute.Outcome != "":
+				fmt.Fprintf(&body, "Outcome: %s; the submission is marked paid again\n", dispute.Outcome)
+			case dispute.RespondBy != "":
+				fmt.Fprintf(&body, "Respond by: %s\n", dispute.RespondBy)
+			}
+			if !notice.Resolved {
+				body.WriteString("\nRespond in the PayPal Resolution Center: https://www.paypal.com/resolutioncenter\n")
+			}
+			return notification.Message{Subject: subject, Body: body.String()}, nil
+		},
+	},
+}
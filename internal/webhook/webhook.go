@@ -5,14 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"sbcbackend/internal/config"
 	"sbcbackend/internal/data"
 	"sbcbackend/internal/email"
 	"sbcbackend/internal/logger"
+	"sbcbackend/internal/notify"
 	"sbcbackend/internal/payment"
+	"sbcbackend/internal/paypal"
 )
 
 // PayPalWebhookHandler processes incoming PayPal webhook POSTs.
@@ -36,6 +41,7 @@ func PayPalWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	logger.LogInfo("Verifying webhook transmission ID: %s", transmissionID)
 
 	if !verifyPayPalWebhookSignature(
+		r.Context(),
 		transmissionID,
 		r.Header.Get("Paypal-Transmission-Sig"),
 		r.Header.Get("Paypal-Transmission-Time"),
@@ -44,72 +50,235 @@ func PayPalWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		payloadBytes,
 	) {
 		logger.LogError("Invalid PayPal webhook signature")
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal webhook rejected: invalid signature (transmission %s)", transmissionID))
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
 	}
 
-	// Parse incoming webhook event
-	var event map[string]interface{}
-	if err := json.Unmarshal(payloadBytes, &event); err != nil {
+	event, err := ParseWebhookEvent(payloadBytes)
+	if err != nil {
 		logger.LogHTTPError(r, http.StatusBadRequest, err)
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal webhook rejected: %v", err))
 		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
 		return
 	}
+	logger.LogInfo("Webhook event type: %s", event.EventType)
 
-	eventType, _ := event["event_type"].(string)
-	logger.LogInfo("Webhook event type: %s", eventType)
-
-	resource, _ := event["resource"].(map[string]interface{})
-	if resource == nil {
-		logger.LogInfo("No resource in event, ignoring")
+	// Kept before it is processed, so an event that fails is there to replay. PayPal
+	// redelivers events it thinks went unacknowledged; one already processed is skipped.
+	record, isNew, err := data.RecordWebhookEvent(data.WebhookEvent{
+		EventID:        event.ID,
+		TransmissionID: transmissionID,
+		EventType:      event.EventType,
+		FormID:         event.FormID,
+		Payload:        string(payloadBytes),
+		ReceivedAt:     time.Now(),
+	})
+	if err != nil {
+		logger.LogWarn("Failed to record webhook event %s: %v", event.ID, err)
+	} else if !isNew && record.Done() {
+		logger.LogInfo("Webhook event %s was already %s; ignoring the redelivery", event.ID, record.Result)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	formID := extractFormIDFromResource(resource)
+	result, err := processEvent(r.Context(), event, payloadBytes)
+	if record != nil {
+		finishEvent(record.ID, result, err, false)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReplayEvent processes a recorded webhook event again, as when a fix makes one that
+// failed go through, and returns it with the new result
+func ReplayEvent(ctx context.Context, id int64) (*data.WebhookEvent, error) {
+	record, err := data.GetWebhookEvent(id)
+	if err != nil {
+		return nil, err
+	}
+	event, err := ParseWebhookEvent([]byte(record.Payload))
+	if err != nil {
+		return nil, err
+	}
+	logger.LogInfo("Replaying webhook event %d (%s %s)", record.ID, record.EventID, record.EventType)
+	result, err := processEvent(ctx, event, []byte(record.Payload))
+	finishEvent(record.ID, result, err, true)
+	return data.GetWebhookEvent(id)
+}
+
+// finishEvent records what processing a webhook event did; a processing error fails it
+func finishEvent(id int64, result string, processErr error, replay bool) {
+	if processErr != nil {
+		result = data.WebhookFailed
+	}
+	if err := data.FinishWebhookEvent(id, result, processErr, replay); err != nil {
+		logger.LogWarn("%v", err)
+	}
+}
+
+// processEvent applies a verified webhook event to what it is about, returning what it
+// did as a data.Webhook* result and the error that kept it from being applied
+func processEvent(ctx context.Context, event WebhookEvent, payload []byte) (string, error) {
+	if event.ResourceJSON == "" {
+		logger.LogInfo("No resource in event, ignoring")
+		return data.WebhookIgnored, nil
+	}
+
+	if event.SubscriptionID != "" {
+		return recordSubscriptionEvent(event)
+	}
+
+	formID := event.FormID
 	if formID == "" {
+		if event.EventType == "PAYMENT.CAPTURE.COMPLETED" {
+			return data.WebhookUnmatched, recordUnmatchedPayment(event)
+		}
 		logger.LogInfo("No form ID (invoice_id) found, ignoring webhook")
-		w.WriteHeader(http.StatusOK)
-		return
+		return data.WebhookIgnored, nil
 	}
 
-	// --- DB-native reconciliation ---
-	// Extract status (try "status" at top level, or in resource/capture_response)
-	payPalStatus := ""
-	if status, ok := resource["status"].(string); ok {
-		payPalStatus = status
-	} else if capture, ok := resource["capture_response"].(map[string]interface{}); ok {
-		if s, ok := capture["status"].(string); ok {
-			payPalStatus = s
+	formID, installment := data.ParseInstallmentInvoiceID(formID)
+	formType := formTypeFromID(formID)
+	if installment > 0 && event.EventType == "PAYMENT.CAPTURE.COMPLETED" {
+		// Catches installments whose capture response never reached us
+		if _, err := payment.RecordInstallmentCapture(formType, formID, installment, event.ResourceJSON, data.ActorWebhook); err != nil {
+			logger.LogWarn("Failed to record installment %d of %s from webhook: %v", installment, formID, err)
+			notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal %s webhook for installment %d of %s could not be recorded: %v",
+				event.EventType, installment, formID, err))
+			return data.WebhookFailed, err
 		}
-	} else {
-		payPalStatus = eventType // fallback for rare cases
+		return data.WebhookProcessed, nil
 	}
-
-	// Save the entire resource JSON for audit and reporting
-	resourceJSON, err := json.Marshal(resource)
-	if err != nil {
-		logger.LogWarn("Failed to marshal resource JSON for formID %s: %v", formID, err)
-		resourceJSON = []byte("{}")
+	if formType == "donation" {
+		return recordDonationEvent(event, formID)
 	}
-
-	if err := data.UpdateMembershipPayPalDetails(formID, payPalStatus, string(resourceJSON)); err != nil {
+	// An invoice ID from outside checkout, like a PayPal button on the club's site, is
+	// simply a form nobody knows
+	matched, err := false, error(nil)
+	if _, typeErr := data.FormTypeFromID(formID); typeErr == nil {
+		matched, err = data.ApplyPayPalWebhook(formType, formID, event.Status, event.ResourceJSON, event.RefundedTotal, data.ActorWebhook)
+	}
+	result := data.WebhookProcessed
+	if err != nil {
 		logger.LogWarn("Failed to update PayPal webhook for %s: %v", formID, err)
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal %s webhook for %s could not be recorded: %v", event.EventType, formID, err))
+	} else if !matched {
+		logger.LogWarn("PayPal webhook for unknown form %s", formID)
+		result = data.WebhookUnmatched
+		if event.EventType == "PAYMENT.CAPTURE.COMPLETED" {
+			err = recordUnmatchedPayment(event)
+		} else {
+			notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal %s webhook for unknown form %s", event.EventType, formID))
+		}
+	} else if event.EventType == "PAYMENT.CAPTURE.REFUNDED" {
+		notify.PaymentRefunded(formID, event.RefundedTotal)
+	} else if strings.HasPrefix(event.EventType, "CUSTOMER.DISPUTE.") {
+		err = recordDispute(ctx, event, formType, formID)
 	}
 
 	// Optional: email alert for ops/monitoring
-	subject := fmt.Sprintf("PayPal Webhook: %s", eventType)
-	body := fmt.Sprintf("Received PayPal webhook for formID %s:\n\n%s%s", formID, string(payloadBytes), config.WebhookMockNotice())
+	subject := fmt.Sprintf("PayPal Webhook: %s", event.EventType)
+	body := fmt.Sprintf("Received PayPal webhook for formID %s:\n\n%s%s", formID, string(payload), config.WebhookMockNotice())
 	if err := email.SendAlertEmail(subject, body); err != nil {
 		logger.LogWarn("Failed to send email alert: %v", err)
 	}
 
-	logger.LogInfo("Webhook for form %s processed successfully.", formID)
-	w.WriteHeader(http.StatusOK)
+	if err == nil {
+		logger.LogInfo("Webhook for form %s processed successfully.", formID)
+	}
+	return result, err
+}
+
+// WebhookEvent is the part of a PayPal webhook payload the handler acts on
+type WebhookEvent struct {
+	ID            string // PayPal's event ID, the same on every delivery of the event
+	EventType     string
+	FormID        string // invoice_id set at order creation
+	Status        string // status to move the submission to; empty to only record the event
+	ResourceJSON  string // the full resource, saved for audit and reporting; empty when absent
+	Summary       string // PayPal's one-line description of the event
+	RefundedTotal float64
+
+	// Auto-renewing memberships: the subscription an event is about and, for a
+	// subscription payment, the sale that paid it
+	SubscriptionID string
+	SaleID         string
+	Amount         float64
+	PaidAt         time.Time
+}
+
+// ParseWebhookEvent extracts the event type, form ID and status from an untrusted
+// webhook body. A payload without a resource parses to an event with no ResourceJSON.
+func ParseWebhookEvent(payload []byte) (WebhookEvent, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return WebhookEvent{}, fmt.Errorf("invalid webhook JSON: %w", err)
+	}
+
+	event := WebhookEvent{}
+	event.ID, _ = raw["id"].(string)
+	event.EventType, _ = raw["event_type"].(string)
+	event.Summary, _ = raw["summary"].(string)
+
+	resource, _ := raw["resource"].(map[string]interface{})
+	if resource == nil {
+		return event, nil
+	}
+
+	event.FormID = extractFormIDFromResource(resource)
+
+	switch {
+	case event.EventType == "PAYMENT.CAPTURE.REFUNDED":
+		// The resource is the refund, whose own status says nothing about the order
+		event.Status = "REFUNDED"
+		event.RefundedTotal = refundedTotal(resource)
+	case event.EventType == "PAYMENT.CAPTURE.REVERSED":
+		event.Status = "REVERSED"
+	case strings.HasPrefix(event.EventType, "BILLING.SUBSCRIPTION."):
+		event.SubscriptionID, _ = resource["id"].(string)
+		event.FormID, _ = resource["custom_id"].(string)
+		event.Status, _ = resource["status"].(string)
+	case event.EventType == "PAYMENT.SALE.COMPLETED" && resource["billing_agreement_id"] != nil:
+		event.SubscriptionID, _ = resource["billing_agreement_id"].(string)
+		event.SaleID, _ = resource["id"].(string)
+		event.FormID, _ = resource["custom"].(string)
+		event.Status, _ = resource["state"].(string)
+		event.Amount, event.PaidAt = saleAmount(resource)
+	case strings.HasPrefix(event.EventType, "CUSTOMER.DISPUTE."):
+		event.FormID = extractFormIDFromDispute(resource)
+		event.Status = disputeStatus(event.EventType, resource)
+	default:
+		// Extract status (try "status" at top level, or in resource/capture_response)
+		if status, ok := resource["status"].(string); ok {
+			event.Status = status
+		} else if capture, ok := resource["capture_response"].(map[string]interface{}); ok {
+			if s, ok := capture["status"].(string); ok {
+				event.Status = s
+			}
+		} else {
+			event.Status = event.EventType // fallback for rare cases
+		}
+	}
+
+	resourceJSON, err := json.Marshal(resource)
+	if err != nil {
+		logger.LogWarn("Failed to marshal resource JSON for formID %s: %v", event.FormID, err)
+		resourceJSON = []byte("{}")
+	}
+	event.ResourceJSON = string(resourceJSON)
+
+	return event, nil
 }
 
-// verifyPayPalWebhookSignature verifies the authenticity of the webhook.
+// verificationTimeout bounds the call asking PayPal to verify a webhook, so a slow
+// PayPal can't tie up the handler; PayPal retries webhooks that aren't acknowledged
+const verificationTimeout = 15 * time.Second
+
+// verifyPayPalWebhookSignature has PayPal verify that a webhook came from it: PayPal
+// checks the transmission signature against its certificate chain and our webhook ID.
+// Anything short of a SUCCESS verdict, including failing to ask, rejects the webhook.
 func verifyPayPalWebhookSignature(
+	ctx context.Context,
 	transmissionID, transmissionSig, transmissionTime, certURL, authAlgo string,
 	payload []byte,
 ) bool {
@@ -118,7 +287,13 @@ func verifyPayPalWebhookSignature(
 		return true
 	}
 
-	ctx := context.Background()
+	if transmissionID == "" || transmissionSig == "" || transmissionTime == "" || certURL == "" || authAlgo == "" {
+		logger.LogWarn("Webhook is missing PayPal transmission headers; rejecting without verification")
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, verificationTimeout)
+	defer cancel()
 
 	if config.PayPalWebhookID == "" {
 		logger.LogWarn("Missing PAYPAL_WEBHOOK_ID; signature verification will fail")
@@ -147,20 +322,25 @@ func verifyPayPalWebhookSignature(
 		return false
 	}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/notifications/verify-webhook-signature", config.APIBase()), strings.NewReader(string(bodyBytes)))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/v1/notifications/verify-webhook-signature", config.APIBase()), strings.NewReader(string(bodyBytes)))
 	if err != nil {
 		logger.LogError("Failed to create webhook verification request: %v", err)
 		return false
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Authorization", accessToken) // already "Bearer ..."
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := payment.NewPayPalClient(verificationTimeout).Do(req)
 	if err != nil {
 		logger.LogError("Webhook verification request failed: %v", err)
 		return false
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		logger.LogError("Webhook verification returned HTTP %d: %s", resp.StatusCode, body)
+		return false
+	}
 
 	var result struct {
 		VerificationStatus string `json:"verification_status"`
@@ -188,3 +368,167 @@ func extractFormIDFromResource(resource map[string]interface{}) string {
 	}
 	return ""
 }
+
+// formTypeFromID reads the form type prefix ("membership-...", "event-...") from a form ID
+func formTypeFromID(formID string) string {
+	if i := strings.Index(formID, "-"); i > 0 {
+		return formID[:i]
+	}
+	return formID
+}
+
+// refundedTotal reads the running total refunded on the capture from a refund resource,
+// or 0 when PayPal didn't include the breakdown
+func refundedTotal(resource map[string]interface{}) float64 {
+	breakdown, _ := resource["seller_payable_breakdown"].(map[string]interface{})
+	total, _ := breakdown["total_refunded_amount"].(map[string]interface{})
+	value, _ := total["value"].(string)
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0
+	}
+	return amount
+}
+
+// saleAmount reads what a subscription payment collected and when, defaulting to now
+// when PayPal leaves the time out
+func saleAmount(resource map[string]interface{}) (float64, time.Time) {
+	paidAt := time.Now()
+	if created, _ := resource["create_time"].(string); created != "" {
+		if t, err := time.Parse(time.RFC3339, created); err == nil {
+			paidAt = t
+		}
+	}
+	amount, _ := resource["amount"].(map[string]interface{})
+	total, _ := amount["total"].(string)
+	value, err := strconv.ParseFloat(total, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, paidAt
+	}
+	return value, paidAt
+}
+
+// recordSubscriptionEvent keeps an auto-renewing membership in step with its PayPal
+// subscription: each yearly payment renews it, and status changes (cancelled,
+// suspended after failed payments) are recorded for the board to follow up
+func recordSubscriptionEvent(event WebhookEvent) (string, error) {
+	if event.SaleID != "" {
+		renewal, err := data.RecordRenewal(event.SubscriptionID, event.SaleID, event.Amount, event.PaidAt)
+		if err != nil {
+			logger.LogWarn("Failed to record renewal %s of subscription %s: %v", event.SaleID, event.SubscriptionID, err)
+			notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal %s webhook for subscription %s could not be recorded: %v",
+				event.EventType, event.SubscriptionID, err))
+			return data.WebhookFailed, err
+		}
+		if renewal == nil {
+			logger.LogInfo("Renewal %s of subscription %s already recorded", event.SaleID, event.SubscriptionID)
+			return data.WebhookProcessed, nil
+		}
+		logger.LogInfo("Membership %s renewed (cycle %d, $%.2f) through %s", renewal.FormID, renewal.Cycle,
+			renewal.Amount, renewal.CoversThrough.Format("2006-01-02"))
+		notify.MembershipRenewed(*renewal)
+		return data.WebhookProcessed, nil
+	}
+
+	if event.Status == "" {
+		return data.WebhookIgnored, nil
+	}
+	matched, err := data.UpdateSubscriptionStatus(event.SubscriptionID, event.Status)
+	if err != nil {
+		logger.LogWarn("Failed to update subscription %s: %v", event.SubscriptionID, err)
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal %s webhook for subscription %s could not be recorded: %v",
+			event.EventType, event.SubscriptionID, err))
+		return data.WebhookFailed, err
+	}
+	if !matched {
+		logger.LogWarn("PayPal webhook for unknown subscription %s", event.SubscriptionID)
+		return data.WebhookUnmatched, nil
+	}
+	logger.LogInfo("Subscription %s of %s is now %s", event.SubscriptionID, event.FormID, event.Status)
+	if event.Status == "SUSPENDED" || event.Status == "CANCELLED" {
+		notify.Notify(notify.EventMembershipRenewed, fmt.Sprintf("Membership auto-renewal %s for %s", strings.ToLower(event.Status), event.FormID))
+	}
+	return data.WebhookProcessed, nil
+}
+
+// recordDonationEvent records a quick donation's capture whose response never reached
+// the capture handler. Quick donations have no other state to keep in step.
+func recordDonationEvent(event WebhookEvent, donationID string) (string, error) {
+	if event.EventType != "PAYMENT.CAPTURE.COMPLETED" {
+		logger.LogInfo("Ignoring %s webhook for donation %s", event.EventType, donationID)
+		return data.WebhookIgnored, nil
+	}
+	recorded, err := payment.RecordDonationCapture(donationID, event.ResourceJSON, data.ActorWebhook)
+	if err != nil {
+		logger.LogWarn("Failed to record donation %s from webhook: %v", donationID, err)
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal %s webhook for donation %s could not be recorded: %v",
+			event.EventType, donationID, err))
+		return data.WebhookFailed, err
+	}
+	if !recorded {
+		logger.LogInfo("Donation %s already recorded or unknown", donationID)
+	}
+	return data.WebhookProcessed, nil
+}
+
+// recordUnmatchedPayment keeps a completed capture that no submission claimed, so an
+// admin can link it to the form it paid for instead of the money going unnoticed. It
+// returns the error that kept it from being recorded.
+func recordUnmatchedPayment(event WebhookEvent) error {
+	capture, err := paypal.ParseCapture([]byte(event.ResourceJSON))
+	if err != nil || capture.ID == "" {
+		logger.LogWarn("Unmatched %s webhook has no capture ID; not recorded", event.EventType)
+		return nil
+	}
+	amount, currency := capture.Amount.Amount(), ""
+	if capture.Amount != nil {
+		currency = capture.Amount.CurrencyCode
+	}
+
+	recorded, err := data.RecordUnmatchedPayment(data.UnmatchedPayment{
+		CaptureID:  capture.ID,
+		OrderID:    capture.OrderID(),
+		InvoiceID:  event.FormID,
+		EventType:  event.EventType,
+		Status:     capture.Status,
+		Amount:     amount,
+		Currency:   currency,
+		Details:    event.ResourceJSON,
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		logger.LogError("Failed to record unmatched payment %s: %v", capture.ID, err)
+		return err
+	}
+	if recorded {
+		logger.LogWarn("Recorded unmatched PayPal capture %s ($%.2f, invoice %q)", capture.ID, amount, event.FormID)
+		notify.Notify(notify.EventWebhookFailed, fmt.Sprintf("PayPal capture %s for $%.2f matched no submission (invoice %q); link it from the admin API",
+			capture.ID, amount, event.FormID))
+	}
+	return nil
+}
+
+// extractFormIDFromDispute finds our invoice ID on the first disputed transaction
+func extractFormIDFromDispute(resource map[string]interface{}) string {
+	transactions, _ := resource["disputed_transactions"].([]interface{})
+	if len(transactions) == 0 {
+		return ""
+	}
+	transaction, _ := transactions[0].(map[string]interface{})
+	invoiceID, _ := transaction["invoice_number"].(string)
+	return invoiceID
+}
+
+// disputeStatus marks the order DISPUTED when a dispute opens and REFUNDED when the buyer
+// wins it. Updates leave the status alone, as does a dispute the club wins, which
+// recordDispute undoes.
+func disputeStatus(eventType string, resource map[string]interface{}) string {
+	outcome, _ := resource["dispute_outcome"].(map[string]interface{})
+	if code, _ := outcome["outcome_code"].(string); code == paypal.OutcomeBuyerFavour {
+		return "REFUNDED"
+	}
+	if eventType == "CUSTOMER.DISPUTE.CREATED" {
+		return data.DisputedStatus
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+// Package xlsx writes simple Excel workbooks: named sheets of rows holding text,
+// numbers and dollar amounts, with a bold header row frozen at the top of each.
+// It covers what reports need without a spreadsheet library; there are no formulas,
+// merged cells or shared strings.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of an xlsx workbook
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// maxSheetName is the longest sheet name Excel opens
+const maxSheetName = 31
+
+// Money is a dollar amount, shown with a dollar sign and cents
+type Money float64
+
+// Cell styles, as indexes into the cellXfs of styles.xml
+const (
+	styleDefault = 0
+	styleHeader  = 1
+	styleMoney   = 2
+)
+
+// Workbook is a set of sheets written out together
+type Workbook struct {
+	sheets []*Sheet
+}
+
+// Sheet is one tab of a workbook
+type Sheet struct {
+	name   string
+	header bool
+	rows   [][]interface{}
+}
+
+// New returns an empty workbook
+func New() *Workbook {
+	return &Workbook{}
+}
+
+// AddSheet appends a sheet named name, dropping characters Excel refuses in sheet
+// names and cutting it to the length Excel allows
+func (wb *Workbook) AddSheet(name string) *Sheet {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxSheetName {
+		name = string(runes[:maxSheetName])
+	}
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", len(wb.sheets)+1)
+	}
+	sheet := &Sheet{name: name}
+	wb.sheets = append(wb.sheets, sheet)
+	return sheet
+}
+
+// Header sets the sheet's first row, shown bold and kept in view while scrolling
+func (s *Sheet) Header(titles ...string) {
+	row := make([]interface{}, len(titles))
+	for i, title := range titles {
+		row[i] = title
+	}
+	if s.header {
+		s.rows[0] = row
+		return
+	}
+	s.rows = append([][]interface{}{row}, s.rows...)
+	s.header = true
+}
+
+// Row appends a row. Cells may be strings, ints, float64s, Money or nil for an empty
+// cell; anything else is written as its fmt.Sprint text.
+func (s *Sheet) Row(cells ...interface{}) {
+	s.rows = append(s.rows, cells)
+}
+
+// Write writes the workbook to w as an xlsx file
+func (wb *Workbook) Write(w io.Writer) error {
+	if len(wb.sheets) == 0 {
+		wb.AddSheet("")
+	}
+	type part struct {
+		name  string
+		write func(io.Writer) error
+	}
+	parts := []part{
+		{"[Content_Types].xml", wb.writeContentTypes},
+		{"_rels/.rels", writeConst(rootRels)},
+		{"xl/workbook.xml", wb.writeWorkbook},
+		{"xl/_rels/workbook.xml.rels", wb.writeWorkbookRels},
+		{"xl/styles.xml", writeConst(styles)},
+	}
+	for i, sheet := range wb.sheets {
+		parts = append(parts, part{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.write})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range parts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		bw := bufio.NewWriter(pw)
+		if err := part.write(bw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeConst(content string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}
+}
+
+func (wb *Workbook) writeContentTypes(w io.Writer) error {
+	fmt.Fprint(w, xml.Header+`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := range wb.sheets {
+		fmt.Fprintf(w, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	_, err := fmt.Fprint(w, `</Types>`)
+	return err
+}
+
+func (wb *Workbook) writeWorkbook(w io.Writer) error {
+	fmt.Fprint(w, xml.Header+`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" `+
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, sheet := range wb.sheets {
+		fmt.Fprintf(w, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(sheet.name), i+1, i+1)
+	}
+	_, err := fmt.Fprint(w, `</sheets></workbook>`)
+	return err
+}
+
+func (wb *Workbook) writeWorkbookRels(w io.Writer) error {
+	fmt.Fprint(w, xml.Header+`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := range wb.sheets {
+		fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	// Styles come after the sheets so sheet n keeps relationship rIdn
+	fmt.Fprintf(w, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(wb.sheets)+1)
+	_, err := fmt.Fprint(w, `</Relationships>`)
+	return err
+}
+
+func (s *Sheet) write(w io.Writer) error {
+	fmt.Fprint(w, xml.Header+`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if s.header {
+		fmt.Fprint(w, `<sheetViews><sheetView workbookViewId="0">`+
+			`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`+
+			`</sheetView></sheetViews>`)
+	}
+	fmt.Fprint(w, `<sheetData>`)
+	for r, row := range s.rows {
+		fmt.Fprintf(w, `<row r="%d">`, r+1)
+		for c, value := range row {
+			ref := columnName(c) + strconv.Itoa(r+1)
+			style := styleDefault
+			if s.header && r == 0 {
+				style = styleHeader
+			}
+			writeCell(w, ref, style, value)
+		}
+		fmt.Fprint(w, `</row>`)
+	}
+	_, err := fmt.Fprint(w, `</sheetData></worksheet>`)
+	return err
+}
+
+func writeCell(w io.Writer, ref string, style int, value interface{}) {
+	var number string
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+		fmt.Fprintf(w, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(v))
+		return
+	case int:
+		number = strconv.Itoa(v)
+	case int64:
+		number = strconv.FormatInt(v, 10)
+	case float64:
+		number = strconv.FormatFloat(v, 'f', -1, 64)
+	case Money:
+		number = strconv.FormatFloat(float64(v), 'f', 2, 64)
+		style = styleMoney
+	default:
+		writeCell(w, ref, style, fmt.Sprint(v))
+		return
+	}
+	fmt.Fprintf(w, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, number)
+}
+
+// columnName returns the letters naming the zero-based column i: A, B, ... Z, AA, AB...
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// escape escapes text for XML, replacing characters XML can't hold
+func escape(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+const rootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// styles defines the cell styles: default, bold header and dollar amount
+const styles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="&quot;$&quot;#,##0.00"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -3,43 +3,54 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	_ "modernc.org/sqlite"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
 
+	"sbcbackend/internal/backup"
 	"sbcbackend/internal/cleanup"
+	"sbcbackend/internal/clock"
 	"sbcbackend/internal/config"
 	"sbcbackend/internal/data"
-	"sbcbackend/internal/email"
-	"sbcbackend/internal/form"
+	"sbcbackend/internal/health"
 	"sbcbackend/internal/info"
 	"sbcbackend/internal/inventory"
 	"sbcbackend/internal/logger"
 	"sbcbackend/internal/middleware"
+	"sbcbackend/internal/notify"
 	"sbcbackend/internal/order"
+	"sbcbackend/internal/outbox"
 	"sbcbackend/internal/payment"
-	"sbcbackend/internal/security"
-	"sbcbackend/internal/webhook"
+	"sbcbackend/internal/paypalsim"
+	"sbcbackend/internal/reconcile"
+	"sbcbackend/internal/replica"
+	"sbcbackend/internal/restart"
+	"sbcbackend/internal/router"
+	"sbcbackend/internal/scheduler"
 )
 
 type App struct {
-	addr          string
-	mux           *http.ServeMux
-	connections   sync.WaitGroup
-	totalRequests int64
-}
-
-func init() {
-	loc, err := time.LoadLocation("America/Chicago")
-	if err == nil {
-		time.Local = loc // This affects the standard log package
-	}
+	addr      string
+	tls       config.TLSSettings
+	server    config.ServerSettings
+	mux       *http.ServeMux
+	scheduler *scheduler.Scheduler
+	tenants   *tenantSupervisor // set when routing to per-tenant backends instead
+	requests  requestTracker
+	pending   pendingConns
+	errorRate *notify.ErrorRateMonitor // alerts chat when server errors pile up; nil to skip
+
+	jobsMu      sync.Mutex
+	jobsStopped bool // shutdown has begun; jobs not started yet never will be
 }
 
 // Global inventory service for handlers to access
@@ -50,6 +61,15 @@ func main() {
 	config.LoadEnv()
 	config.ConfigurePaths()
 
+	// Every date shown to people or cut at midnight is in the club's zone; setting
+	// time.Local covers the standard log package and times formatted without one
+	loc, err := config.TimeZone()
+	if err != nil {
+		log.Fatalf("Failed to load time zone: %v", err)
+	}
+	clock.SetLocation(loc)
+	time.Local = loc
+
 	// Step 2: Setup logging
 	loggerConfig := config.LoggerConfig()
 	if err := logger.SetupLogger(loggerConfig); err != nil {
@@ -58,23 +78,85 @@ func main() {
 
 	logger.LogInfo("Environment and paths loaded. Logger ready.")
 
-	// Step 3: Initialize SQLite database
-	dbPath := "./booster/data/booster.db"
-	if err := data.InitDB(dbPath); err != nil {
-		logger.LogFatal("Failed to initialize SQLite DB: %v", err)
+	// A multi-tenant deployment runs one backend process per tenant and routes to them
+	if file := config.TenantsFile(); file != "" && config.TenantID() == "" {
+		runTenants(file)
+		return
+	}
+	if id := config.TenantID(); id != "" {
+		logger.LogInfo("Serving tenant %s", id)
+	}
+
+	// Step 3: Initialize the database: SQLite unless DB_DRIVER picks PostgreSQL
+	dbSettings, err := config.LoadDatabaseSettings()
+	if err != nil {
+		logger.LogFatal("Invalid database settings: %v", err)
+	}
+	encryptionKey, err := config.LoadEncryptionKey()
+	if err == nil {
+		err = data.SetPIIKey(encryptionKey)
+	}
+	if err != nil {
+		logger.LogFatal("Invalid encryption key: %v", err)
+	}
+	var replicator *replica.Replicator
+	if dbSettings.Driver == config.DatabasePostgres {
+		if err := data.InitDatabase(data.DriverPostgres, dbSettings.URL); err != nil {
+			logger.LogFatal("Failed to initialize PostgreSQL DB: %v", err)
+		}
+	} else {
+		dbPath := "./booster/data/booster.db"
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0750); err != nil {
+			logger.LogFatal("Failed to create database directory: %v", err)
+		}
+		if err := data.InitDB(dbPath); err != nil {
+			logger.LogFatal("Failed to initialize SQLite DB: %v", err)
+		}
+		replicator = newReplicator(dbPath)
 	}
 	defer func() {
 		if err := data.CloseDB(); err != nil {
 			logger.LogError("Error closing DB: %v", err)
 		}
 	}()
-	if err := data.CreateTables(); err != nil {
-		logger.LogFatal("Failed to create tables: %v", err)
+	// Runs before the database closes, copying the last commits off the host
+	if replicator != nil {
+		defer func() {
+			if err := replicator.Close(); err != nil {
+				logger.LogError("Failed to copy the last commits to the replica: %v", err)
+			}
+		}()
+	}
+	if config.AutoMigrate() {
+		if err := data.CreateTables(); err != nil {
+			logger.LogFatal("Failed to create tables: %v", err)
+		}
+	} else if pending, err := data.PendingSchemaMigrations(); err != nil {
+		logger.LogFatal("Failed to check the database schema: %v", err)
+	} else if len(pending) > 0 {
+		logger.LogFatal("Database schema is out of date (%s); run boosterctl db migrate first",
+			strings.Join(pending, "; "))
+	}
+
+	// Step 4: Load payment provider configuration; PayPal is only required when
+	// checkout goes through it, though its webhooks and reports still use it if set
+	switch config.PaymentProvider() {
+	case config.PaymentProviderStripe:
+		if config.LoadStripeSettings().SecretKey == "" {
+			logger.LogFatal("PAYMENT_PROVIDER is stripe but %s is not set", config.EnvSettingName("STRIPE_SECRET_KEY"))
+		}
+		if err := config.LoadPayPalConfig(); err != nil {
+			logger.LogInfo("PayPal is not configured: %v", err)
+		}
+		logger.LogInfo("Taking checkout payments through Stripe")
+	default:
+		if err := config.LoadPayPalConfig(); err != nil {
+			logger.LogFatal("Failed to load PayPal config: %v", err)
+		}
 	}
 
-	// Step 4: Load PayPal configuration
-	if err := config.LoadPayPalConfig(); err != nil {
-		logger.LogFatal("Failed to load PayPal config: %v", err)
+	if config.LoadBankTransferSettings().Enabled && config.LoadStripeSettings().SecretKey == "" {
+		logger.LogFatal("BANK_TRANSFER_ENABLED needs %s for Stripe's ACH debits", config.EnvSettingName("STRIPE_SECRET_KEY"))
 	}
 
 	// Step 4b: log .env setting
@@ -119,28 +201,265 @@ func main() {
 	}
 
 	logger.LogInfo("Inventory service initialized with %v cache", inventoryService.CacheAge())
+	// Limited coupons count their paid uses
+	inventoryService.SetCouponCounter(data.CountCouponUses)
 
 	// Store globally for handlers to access
 	globalInventoryService = inventoryService
 
 	payment.SetInventoryService(inventoryService)
 	order.SetInventoryService(inventoryService)
+	info.SetInventoryService(inventoryService)
+
+	// Step 4d: Readiness checks behind /readyz
+	registerReadinessChecks(inventoryService)
 
 	// Step 5: Setup app
+	tlsSettings, err := config.LoadTLSSettings()
+	if err != nil {
+		logger.LogFatal("Invalid TLS configuration: %v", err)
+	}
+
+	serverSettings := config.LoadServerSettings()
+	router.SetRequestTimeout(serverSettings.RequestTimeout)
+
+	middleware.ConfigureMaintenance(config.MaintenanceMessage(), config.MaintenanceRetryAfter())
+	if config.MaintenanceMode() {
+		middleware.SetMaintenance(true)
+		logger.LogWarn("Starting in maintenance mode; public endpoints return 503 until it is turned off")
+	}
+
+	jobs := scheduler.New()
 	app := &App{
-		addr: serverAddress(),
-		mux:  routes(),
+		addr:      serverAddress(),
+		tls:       tlsSettings,
+		server:    serverSettings,
+		mux:       router.Routes(jobs),
+		scheduler: jobs,
+	}
+	if notify.Enabled(notify.EventErrorRate) {
+		app.errorRate = notify.NewErrorRateMonitor()
 	}
 
-	// Step 6: Start background tasks (if any remain, like token cleanup)
-	go security.CleanExpiredTokens()
-	cleanup.StartCleanupRoutine()
-	// go data.StartMembershipAggregator() // REMOVE if now obsolete
+	// The PayPal simulator stands in for PayPal, sending its webhooks through our routes
+	if config.PayPalSimulatorEnabled() {
+		if _, err := paypalsim.Start(config.LoadPayPalSimulatorSettings(), app.mux); err != nil {
+			logger.LogFatal("Failed to start the PayPal simulator: %v", err)
+		}
+	}
 
-	// Step 7: Run server
+	// Step 6: Register and start background jobs
+	app.scheduler.SetJobLog(filepath.Join(loggerConfig.LogsDirectory, "jobs.log"))
+	if err := registerJobs(app.scheduler, replicator); err != nil {
+		logger.LogFatal("Failed to register background jobs: %v", err)
+	}
+	app.startJobs()
+
+	// Step 7: Run server; /readyz reports ready from here on
+	health.SetReady(true)
 	app.Run()
 }
 
+// registerReadinessChecks makes /readyz fail while a dependency the handlers rely on
+// is unusable, so a supervisor or load balancer keeps traffic away from the instance
+func registerReadinessChecks(inventoryService *inventory.Service) {
+	health.Register("database", func() error {
+		_, err := data.GetDB()
+		return err
+	})
+	health.Register("inventory", func() error {
+		if !inventoryService.Loaded() {
+			return errors.New("inventory not loaded")
+		}
+		return nil
+	})
+	// Only the selected provider's credentials are required
+	health.Register("payment provider", func() error {
+		if config.PaymentProvider() == config.PaymentProviderStripe {
+			if config.LoadStripeSettings().SecretKey == "" {
+				return errors.New("Stripe secret key not configured")
+			}
+			return nil
+		}
+		if config.ClientID() == "" || config.ClientSecret() == "" || config.APIBase() == "" {
+			return errors.New("PayPal credentials not configured")
+		}
+		return nil
+	})
+}
+
+// newReplicator returns the replicator of the SQLite database at dbPath, or nil when no
+// replica is configured
+func newReplicator(dbPath string) *replica.Replicator {
+	settings, err := config.LoadReplicaSettings()
+	if err != nil {
+		logger.LogFatal("Invalid replica settings: %v", err)
+	}
+	if !settings.Enabled() {
+		return nil
+	}
+	store, err := replica.OpenStore(settings)
+	if err != nil {
+		logger.LogFatal("Failed to open the database replica: %v", err)
+	}
+	logger.LogInfo("Replicating the database to %s", settings.URL)
+	return replica.New(dbPath, store, settings)
+}
+
+// registerJobs adds all periodic work to the scheduler. Schedules, jitter and blackout
+// windows can be set per job with JOB_<NAME>_SCHEDULE, JOB_<NAME>_JITTER and
+// JOB_<NAME>_BLACKOUT settings. The replication job runs when replicator isn't nil.
+func registerJobs(s *scheduler.Scheduler, replicator *replica.Replicator) error {
+	cleanupPolicy := cleanup.LoadPolicy()
+	logger.LogInfo("Cleanup policy: %s", cleanup.DescribePolicy(cleanupPolicy))
+
+	jobs := []scheduler.Job{
+		{
+			// In-memory state is cheap to sweep, so it runs often
+			Name:     "token-cleanup",
+			Schedule: config.JobSchedule("token-cleanup", "5m"),
+			Jitter:   config.JobJitter("token-cleanup", 0),
+			Blackout: config.JobBlackout("token-cleanup", ""),
+			Run:      cleanup.NewJob(cleanupPolicy, cleanup.TargetTokens, cleanup.TargetRateLimits),
+		},
+		{
+			Name:     "submission-cleanup",
+			Schedule: config.JobSchedule("submission-cleanup", cleanup.DefaultSchedule),
+			Jitter:   config.JobJitter("submission-cleanup", 5*time.Minute),
+			Blackout: config.JobBlackout("submission-cleanup", ""),
+			Run:      cleanup.NewJob(cleanupPolicy, cleanup.TargetDrafts, cleanup.TargetTempFiles, cleanup.TargetOrderPages, cleanup.TargetIdempotencyKeys),
+		},
+		{
+			// Anonymizes submissions closed longer than the personal-details retention
+			Name:     "data-retention",
+			Schedule: config.JobSchedule("data-retention", cleanup.DefaultSchedule),
+			Jitter:   config.JobJitter("data-retention", 5*time.Minute),
+			Blackout: config.JobBlackout("data-retention", ""),
+			Run:      cleanup.NewJob(cleanupPolicy, cleanup.TargetPersonalDetails),
+		},
+		{
+			// Sends the emails, order pages and webhooks queued with each capture
+			Name:     "outbox",
+			Schedule: config.JobSchedule("outbox", outbox.DefaultSchedule),
+			Jitter:   config.JobJitter("outbox", 0),
+			Blackout: config.JobBlackout("outbox", ""),
+			Run:      newOutboxWorker().Run,
+		},
+		{
+			Name:     "abandoned-checkout",
+			Schedule: config.JobSchedule("abandoned-checkout", cleanup.AbandonedCheckoutSchedule),
+			Jitter:   config.JobJitter("abandoned-checkout", time.Minute),
+			Blackout: config.JobBlackout("abandoned-checkout", ""),
+			Run:      cleanup.NewAbandonedCheckoutJob(cleanup.LoadAbandonedCheckoutPolicy()),
+		},
+		{
+			// Compares yesterday's PayPal transaction report with the database;
+			// boosterctl reconcile runs the same check for any date range
+			Name:     "paypal-reconcile",
+			Schedule: config.JobSchedule("paypal-reconcile", reconcile.DefaultSchedule),
+			Jitter:   config.JobJitter("paypal-reconcile", 5*time.Minute),
+			Blackout: config.JobBlackout("paypal-reconcile", ""),
+			Run:      reconcile.NewJob(),
+		},
+		{
+			Name:     "installment-reminders",
+			Schedule: config.JobSchedule("installment-reminders", cleanup.InstallmentReminderSchedule),
+			Jitter:   config.JobJitter("installment-reminders", 5*time.Minute),
+			Blackout: config.JobBlackout("installment-reminders", ""),
+			Run:      cleanup.NewInstallmentReminderJob(config.LoadInstallmentSettings().ReminderLead),
+		},
+		{
+			// Completes donations paid by bank transfer once the debit settles
+			Name:     "bank-transfer-settlement",
+			Schedule: config.JobSchedule("bank-transfer-settlement", "1h"),
+			Jitter:   config.JobJitter("bank-transfer-settlement", 5*time.Minute),
+			Blackout: config.JobBlackout("bank-transfer-settlement", ""),
+			Run:      payment.NewSettlementJob(),
+		},
+		{
+			// Checks PayPal orders families never finished and frees their forms
+			Name:     "stale-paypal-orders",
+			Schedule: config.JobSchedule("stale-paypal-orders", "1h"),
+			Jitter:   config.JobJitter("stale-paypal-orders", 5*time.Minute),
+			Blackout: config.JobBlackout("stale-paypal-orders", ""),
+			Run:      payment.NewStaleOrderJob(config.StaleOrderAge()),
+		},
+		{
+			// Renews the PayPal access token before it expires so checkout never waits on it
+			Name:     "paypal-token-refresh",
+			Schedule: config.JobSchedule("paypal-token-refresh", "10m"),
+			Jitter:   config.JobJitter("paypal-token-refresh", 0),
+			Blackout: config.JobBlackout("paypal-token-refresh", ""),
+			Run:      payment.NewPayPalTokenJob(config.PayPalTokenRefreshLead()),
+		},
+	}
+
+	// PostgreSQL is backed up and vacuumed by its own tools; SQLite does both nightly
+	if dbSettings, err := config.LoadDatabaseSettings(); err == nil && dbSettings.Driver != config.DatabasePostgres {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "database-backup",
+			Schedule: config.JobSchedule("database-backup", backup.DefaultSchedule),
+			Jitter:   config.JobJitter("database-backup", 5*time.Minute),
+			Blackout: config.JobBlackout("database-backup", ""),
+			Run:      backup.NewJob(config.LoadBackupSettings()),
+		}, scheduler.Job{
+			// ANALYZE, an incremental vacuum and a WAL truncation, after the backup
+			Name:     "database-maintenance",
+			Schedule: config.JobSchedule("database-maintenance", "30 3 * * *"),
+			Jitter:   config.JobJitter("database-maintenance", 5*time.Minute),
+			Blackout: config.JobBlackout("database-maintenance", ""),
+			Run:      maintainDatabase,
+		})
+	}
+
+	// Copies each commit off the host within seconds, on top of the nightly snapshots
+	if replicator != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "database-replica",
+			Schedule: config.JobSchedule("database-replica", replica.DefaultSchedule),
+			Jitter:   config.JobJitter("database-replica", 0),
+			Run:      replicator.Sync,
+		})
+	}
+
+	for _, job := range jobs {
+		if err := s.Register(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maintainDatabase runs the nightly database maintenance, reporting what it did
+func maintainDatabase(ctx context.Context) error {
+	run, err := data.MaintainDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	logger.LogInfo("Database maintenance took %dms: free pages %d -> %d, checkpointed %d of %d WAL frames",
+		run.DurationMS, run.FreePagesBefore, run.FreePagesAfter, run.Checkpointed, run.WALFrames)
+	if run.CheckpointBusy {
+		logger.LogWarn("Database maintenance couldn't truncate the write-ahead log while it was being read")
+	}
+	scheduler.Report(ctx, "analyzed the database, reclaimed %d free pages", run.FreePagesBefore-run.FreePagesAfter)
+	return nil
+}
+
+// newOutboxWorker registers a handler for every task kind queued on capture
+func newOutboxWorker() *outbox.Worker {
+	worker := outbox.NewWorker()
+	worker.Handle(outbox.KindConfirmationEmail, order.ConfirmationEmailTask)
+	worker.Handle(outbox.KindAdminNotification, order.AdminNotificationTask)
+	worker.Handle(outbox.KindOrderPage, order.OrderPageTask)
+	worker.Handle(outbox.KindChatNotification, notify.PaymentCompletedTask)
+	worker.Handle(outbox.KindSMSConfirmation, order.SMSConfirmationTask)
+	worker.Handle(outbox.KindRecordCapture, payment.RecordCaptureTask)
+	if url := config.OutboundWebhookURL(); url != "" {
+		worker.Handle(outbox.KindWebhook, outbox.NewWebhookHandler(url))
+	}
+	return worker
+}
+
 // serverAddress builds the server address from environment variables
 func serverAddress() string {
 	host := os.Getenv("SERVER_HOST")
@@ -154,183 +473,189 @@ func serverAddress() string {
 	return host + ":" + port
 }
 
-// routes sets up all API routes with appropriate middleware
-func routes() *http.ServeMux {
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
-	apiMux := http.NewServeMux()
-
-	// Protected endpoints - require full API middleware (token validation, rate limiting, etc.)
-	apiMux.Handle("/order-details", middleware.APIMiddleware(order.GetPaymentDetailsHandler))
-	apiMux.Handle("/save-event-payment", middleware.APIMiddleware(payment.SaveEventPaymentHandler))
-	apiMux.Handle("/save-membership-payment", middleware.APIMiddleware(payment.SaveMembershipPaymentHandler))
-	apiMux.Handle("/create-order", middleware.APIMiddleware(payment.CreatePayPalOrderHandler))
-	apiMux.Handle("/capture-order", middleware.APIMiddleware(payment.CapturePayPalOrderHandler))
-	apiMux.Handle("/success", middleware.APIMiddleware(order.GetSuccessPageHandler))
-	apiMux.Handle("/token-info", middleware.APIMiddleware(security.AccessTokenInfoHandler))
-
-	// Special endpoints - keep existing behavior
-	apiMux.HandleFunc("/submit-form", form.SubmitFormHandler)          // Has its own validation
-	apiMux.HandleFunc("/paypal-webhook", webhook.PayPalWebhookHandler) // External webhook
-	apiMux.HandleFunc("/csrf-token", security.CSRFTokenHandler)        // Public endpoint
-
-	// Test endpoint with basic middleware (no token required)
-	apiMux.Handle("/test-email", middleware.RequestID(middleware.Logging(func(w http.ResponseWriter, r *http.Request) {
-		if err := email.TestEmailFunctionality(); err != nil {
-			middleware.WriteAPIError(w, r, http.StatusInternalServerError, "email_test_failed",
-				"Email test failed", err.Error())
-			return
-		}
-		middleware.WriteAPISuccess(w, r, map[string]string{
-			"message": "✅ Email tests completed successfully! Check your application logs to see the mock emails.",
-		})
-	})))
-
-	mux.Handle("/api/", http.StripPrefix("/api", apiMux))
-	mux.HandleFunc("/info", info.InfoPageHandler)
-
-	return mux
-}
-
 // Run starts the HTTP server
 
 func (a *App) Run() {
 	server := &http.Server{
-		Addr:         a.addr,
-		Handler:      a.Handler(),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              a.addr,
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: a.server.ReadHeaderTimeout,
+		ReadTimeout:       a.server.ReadTimeout,
+		WriteTimeout:      a.server.WriteTimeout,
+		IdleTimeout:       a.server.IdleTimeout,
+		MaxHeaderBytes:    a.server.MaxHeaderBytes,
+	}
+	server.SetKeepAlivesEnabled(a.server.KeepAlives)
+	server.ConnState = a.pending.track
+
+	// Serve HTTPS directly when configured, for deployments without a fronting proxy
+	var httpServer *http.Server
+	if a.tls.Enabled() {
+		var err error
+		if httpServer, err = configureTLS(server, a.tls); err != nil {
+			logger.LogFatal("Failed to configure TLS: %v", err)
+		}
+		if !a.server.HTTP2 {
+			disableHTTP2(server)
+		}
 	}
 
-	// Channel to listen for shutdown signals
+	// Channel to listen for shutdown signals; SIGHUP hands the sockets to a new binary
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Open the listeners up front so a bad address or socket fails startup
+	ln, err := restart.Listen(a.addr)
+	if err != nil {
+		logger.LogFatal("Failed to listen: %v", err)
+	}
+	ln = &onceCloseListener{Listener: ln}
+	var httpLn net.Listener
+	if httpServer != nil {
+		if httpLn, err = restart.ListenRedirect(httpServer.Addr); err != nil {
+			logger.LogFatal("Failed to listen for HTTP redirects: %v", err)
+		}
+		httpLn = &onceCloseListener{Listener: httpLn}
+		httpServer.ConnState = a.pending.track
+	}
 
 	// Start server in a separate goroutine
 	go func() {
-		logger.LogInfo("Starting server on %s", a.addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if a.tls.Enabled() {
+			logger.LogInfo("Starting HTTPS server on %s", ln.Addr())
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			logger.LogInfo("Starting server on %s", ln.Addr())
+			err = server.Serve(ln)
+		}
+		// The listener is closed directly once a restart has taken over
+		if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
 			logger.LogFatal("Server failed: %v", err)
 		}
 	}()
 
-	// Wait for a shutdown signal
-	<-stop
-	logger.LogInfo("Shutdown signal received")
+	if httpServer != nil {
+		go func() {
+			logger.LogInfo("Redirecting HTTP on %s to HTTPS", httpServer.Addr)
+			if err := httpServer.Serve(httpLn); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				logger.LogFatal("HTTP redirect server failed: %v", err)
+			}
+		}()
+	}
+
+	// A process started by a restart lets its parent drain now
+	restart.SignalReady()
+
+	// Wait for a shutdown signal, or a restart that another process has taken over
+	for sig := range stop {
+		if sig != syscall.SIGHUP {
+			logger.LogInfo("Shutdown signal received")
+			health.SetReady(false)
+			break
+		}
+		logger.LogInfo("Restart signal received")
+		if err := restart.Handoff(unwrapListener(ln), unwrapListener(httpLn)); err != nil {
+			logger.LogError("Restart failed, still serving: %v", err)
+			continue
+		}
+		logger.LogInfo("Draining for restart")
+		health.SetReady(false)
+		a.stopAccepting(ln, httpLn)
+		break
+	}
 
-	// Create context with timeout for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Everything below shares one deadline, so the process exits within
+	// SERVER_SHUTDOWN_TIMEOUT however many requests are still running
+	ctx, cancel := context.WithTimeout(context.Background(), a.server.ShutdownTimeout)
 	defer cancel()
 
+	// Stop scheduling new background runs right away; in-flight jobs get the same
+	// deadline as HTTP requests before they are cancelled and checkpoint
+	a.jobsMu.Lock()
+	a.jobsStopped = true
+	a.jobsMu.Unlock()
+	jobsDone := make(chan error, 1)
+	go func() {
+		if a.scheduler == nil {
+			jobsDone <- nil
+			return
+		}
+		jobsDone <- a.scheduler.Shutdown(ctx)
+	}()
+
 	// Shutdown the server gracefully
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logger.LogError("HTTP redirect server shutdown error: %v", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		logger.LogError("Server shutdown error: %v", err)
 	} else {
-		logger.LogInfo("Server shut down gracefully")
+		logger.LogInfo("HTTP server closed")
 	}
 
-	// Wait for active connections to finish
-	logger.LogInfo("Shutdown signal received")
-	logger.LogInfo("Waiting for active connections to finish...")
-	a.connections.Wait()
-	logger.LogInfo("All connections closed. Total requests handled: %d", atomic.LoadInt64(&a.totalRequests))
-	logger.LogInfo("Server shut down gracefully")
-}
-
-// Handler assembles all middleware around the main mux
-func (a *App) Handler() http.Handler {
-	var handler http.Handler = a.mux
-	handler = security.AddCORSHeaders(handler)
-	handler = withCustom404(handler)
-	handler = a.trackConnections(handler)
-	handler = logRequests(handler)
-	handler = withTimeout(handler, 15*time.Second)
-
-	return handler
-}
-
-// Middleware: timeout handler
-func withTimeout(h http.Handler, timeout time.Duration) http.Handler {
-	return http.TimeoutHandler(h, timeout, "Request timed out")
-}
-
-// Middleware: log requests
-func logRequests(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		h.ServeHTTP(w, r)
-
-		duration := time.Since(start)
-		logger.LogInfo("%s %s took %v", r.Method, r.URL.Path, duration)
-	})
-}
+	// Handlers keep running after the timeout handler has replied, so wait for them
+	// too; captures in flight get the rest of the shutdown deadline
+	a.drain(ctx)
 
-// Middleware: track active connections and total requests
-func (a *App) trackConnections(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		a.connections.Add(1)
-		atomic.AddInt64(&a.totalRequests, 1)
-		defer a.connections.Done()
+	// Background jobs must be finished before main closes the database
+	if err := <-jobsDone; err != nil {
+		logger.LogWarn("Background jobs did not finish before shutdown deadline: %v", err)
+	}
 
-		h.ServeHTTP(w, r)
-	})
+	// Tenant backends drain last, once nothing is being proxied to them
+	if a.tenants != nil {
+		a.tenants.shutdown()
+	}
+	logger.LogInfo("Server shut down gracefully")
 }
 
-// Middleware: custom 404 page
-func withCustom404(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Use a custom response writer to capture the status code
-		crw := &captureResponseWriter{
-			ResponseWriter: w,
-			statusCode:     http.StatusOK,
+// startJobs starts the background jobs and has a PayPal token ready, stored or new,
+// before the first checkout. A process started by a restart starts them once the
+// process it replaced has exited, so no job, such as the outbox, runs in both at once.
+func (a *App) startJobs() {
+	start := func() {
+		a.jobsMu.Lock()
+		defer a.jobsMu.Unlock()
+		if a.jobsStopped {
+			return
 		}
-
-		// Let the handler chain process the request
-		h.ServeHTTP(crw, r)
-
-		// Check if a 404 was encountered
-		if crw.statusCode == http.StatusNotFound {
-			logger.LogInfo("404 not found: %s", r.URL.Path)
-
-			// Reset headers to avoid conflicts
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(`
-				<html><body>
-					<h1>404 - Page Not Found</h1>
-					<p>Sorry, the page you requested was not found.</p>
-					<a href="/membership.html">Return to Membership Page</a>
-				</body></html>
-			`))
+		a.scheduler.Start()
+		if err := a.scheduler.RunNow("paypal-token-refresh"); err != nil {
+			logger.LogWarn("Failed to queue PayPal token refresh: %v", err)
 		}
-	})
-}
-
-// captureResponseWriter tracks status code without writing to the underlying response writer
-type captureResponseWriter struct {
-	http.ResponseWriter
-	statusCode int
-	written    bool
-}
+	}
 
-func (crw *captureResponseWriter) WriteHeader(code int) {
-	if !crw.written {
-		crw.statusCode = code
-		crw.written = true
-		crw.ResponseWriter.WriteHeader(code)
+	exited := restart.ParentExited()
+	if exited == nil {
+		start()
+		return
 	}
+	logger.LogInfo("Background jobs will start once the previous process exits")
+	go func() {
+		<-exited
+		logger.LogInfo("Previous process exited, starting background jobs")
+		start()
+	}()
 }
 
-func (crw *captureResponseWriter) Write(b []byte) (int, error) {
-	if !crw.written {
-		crw.WriteHeader(http.StatusOK)
+// Handler assembles all middleware around the main mux
+func (a *App) Handler() http.Handler {
+	if a.tenants != nil {
+		handler := a.requests.track(a.tenants.router)
+		if a.tls.Enabled() {
+			handler = strictTransportSecurity(handler)
+		}
+		return handler
+	}
+	extra := []func(http.Handler) http.Handler{}
+	if a.errorRate != nil {
+		extra = append(extra, a.errorRate.Middleware)
+	}
+	if a.tls.Enabled() {
+		extra = append(extra, strictTransportSecurity)
 	}
-	return crw.ResponseWriter.Write(b)
+	return router.Handler(a.mux, append(extra, a.requests.track)...)
 }
@@ -0,0 +1,87 @@
+// restart.go
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"sbcbackend/internal/logger"
+)
+
+// handoffGrace bounds how long a process that handed off its sockets waits for the
+// connections it already accepted to send their first request
+const handoffGrace = 5 * time.Second
+
+// pendingConns tracks accepted connections that have not sent a request yet. Once
+// Shutdown starts, net/http closes such a connection without replying, so after a
+// handoff the old process stops accepting and waits for them before draining.
+type pendingConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// track is an http.Server ConnState hook
+func (p *pendingConns) track(conn net.Conn, state http.ConnState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state == http.StateNew {
+		if p.conns == nil {
+			p.conns = make(map[net.Conn]struct{})
+		}
+		p.conns[conn] = struct{}{}
+		return
+	}
+	delete(p.conns, conn)
+}
+
+// wait returns once no accepted connection is waiting for its first request, or
+// false if some still are after timeout
+func (p *pendingConns) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		p.mu.Lock()
+		pending := len(p.conns)
+		p.mu.Unlock()
+		if pending == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stopAccepting closes the listeners a handoff passed on, so only the new process
+// accepts, and lets connections accepted here reach a handler before Shutdown
+func (a *App) stopAccepting(listeners ...net.Listener) {
+	for _, ln := range listeners {
+		if ln != nil {
+			ln.Close()
+		}
+	}
+	if !a.pending.wait(handoffGrace) {
+		logger.LogWarn("Connections without a request after %v will be closed", handoffGrace)
+	}
+}
+
+// onceCloseListener lets the server and a restart both close a listener
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() { l.err = l.Listener.Close() })
+	return l.err
+}
+
+func unwrapListener(ln net.Listener) net.Listener {
+	if once, ok := ln.(*onceCloseListener); ok {
+		return once.Listener
+	}
+	return ln
+}
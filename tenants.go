@@ -0,0 +1,289 @@
+// tenants.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/health"
+	"sbcbackend/internal/logger"
+	"sbcbackend/internal/restart"
+	"sbcbackend/internal/tenant"
+)
+
+// tenantStopSlack is how much longer than their own shutdown deadline tenant backends
+// get before they are killed
+const tenantStopSlack = 5 * time.Second
+
+// tenantSupervisor runs one backend process per tenant, each listening on a unix
+// socket in its tenant directory, and restarts any that exit
+type tenantSupervisor struct {
+	exe       string
+	timeout   time.Duration // how long backends get to stop
+	processes []*tenantProcess
+	router    *tenant.Router
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type tenantProcess struct {
+	tenant tenant.Tenant
+	socket string
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	probe *http.Client
+}
+
+// runTenants serves a multi-tenant deployment: the tenants' backends are started
+// first, then this process terminates HTTPS if configured and routes each request.
+func runTenants(file string) {
+	tenants, err := tenant.Load(file)
+	if err != nil {
+		logger.LogFatal("Invalid tenants file: %v", err)
+	}
+	tlsSettings, err := config.LoadTLSSettings()
+	if err != nil {
+		logger.LogFatal("Invalid TLS configuration: %v", err)
+	}
+
+	serverSettings := config.LoadServerSettings()
+
+	supervisor, err := newTenantSupervisor(tenants)
+	if err != nil {
+		logger.LogFatal("Failed to start tenants: %v", err)
+	}
+	// Backends read the same SERVER_SHUTDOWN_TIMEOUT unless a tenant overrides it
+	supervisor.timeout = serverSettings.ShutdownTimeout + tenantStopSlack
+	supervisor.start()
+	supervisor.waitReady(restart.Timeout)
+
+	app := &App{
+		addr:    serverAddress(),
+		tls:     tlsSettings,
+		server:  serverSettings,
+		tenants: supervisor,
+	}
+	app.Run()
+}
+
+func newTenantSupervisor(tenants []tenant.Tenant) (*tenantSupervisor, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	s := &tenantSupervisor{exe: exe, stop: make(chan struct{})}
+	sockets := make(map[string]string, len(tenants))
+	for _, t := range tenants {
+		if err := os.MkdirAll(t.Dir, 0750); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+		// Named after this process so a restarted supervisor's backends never
+		// take over the sockets the draining one is still proxying to
+		socket := filepath.Join(t.Dir, fmt.Sprintf("backend-%d.sock", os.Getpid()))
+		sockets[t.ID] = socket
+		s.processes = append(s.processes, &tenantProcess{tenant: t, socket: socket})
+
+		if shared := t.SharedSettings(); len(shared) > 0 {
+			logger.LogWarn("Tenant %s uses the shared %s; set them in its settings to keep its payments and email separate",
+				t.ID, strings.Join(shared, ", "))
+		}
+		logger.LogInfo("Tenant %s (%s) in %s", t.ID, t.Name, t.Dir)
+	}
+
+	s.router = tenant.NewRouter(tenants, sockets)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.LiveHandler)
+	mux.HandleFunc("/readyz", s.readyHandler)
+	s.router.NotFound = mux
+	return s, nil
+}
+
+func (s *tenantSupervisor) start() {
+	for _, p := range s.processes {
+		s.wg.Add(1)
+		go func(p *tenantProcess) {
+			defer s.wg.Done()
+			p.supervise(s.exe, s.stop)
+		}(p)
+	}
+}
+
+// waitReady gives every backend up to timeout to report ready. A backend that is
+// still not ready is logged and keeps being restarted; its requests get a 502 meanwhile.
+func (s *tenantSupervisor) waitReady(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, p := range s.processes {
+		for !p.ready() {
+			if time.Now().After(deadline) {
+				logger.LogError("Tenant %s backend is not ready after %v", p.tenant.ID, timeout)
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// shutdown asks every backend to drain and waits for them, killing any that overrun
+func (s *tenantSupervisor) shutdown() {
+	close(s.stop)
+	for _, p := range s.processes {
+		p.signal(syscall.SIGTERM)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.LogInfo("All tenant backends stopped")
+	case <-time.After(s.timeout):
+		logger.LogWarn("Tenant backends did not stop within %v, killing them", s.timeout)
+		for _, p := range s.processes {
+			p.signal(syscall.SIGKILL)
+		}
+		<-done
+	}
+}
+
+// readyHandler reports ready once every tenant backend does
+func (s *tenantSupervisor) readyHandler(w http.ResponseWriter, r *http.Request) {
+	var down []string
+	for _, p := range s.processes {
+		if !p.ready() {
+			down = append(down, p.tenant.ID)
+		}
+	}
+	if len(down) > 0 {
+		http.Error(w, "Tenants not ready: "+strings.Join(down, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// supervise runs the tenant's backend until stop is closed, restarting it with
+// exponential backoff whenever it exits
+func (p *tenantProcess) supervise(exe string, stop <-chan struct{}) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		if err := p.run(exe); err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			logger.LogError("Tenant %s backend exited: %v", p.tenant.ID, err)
+		}
+
+		// A backend that stayed up for a while earns a quick restart again
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// run starts the backend and waits for it to exit
+func (p *tenantProcess) run(exe string) error {
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Dir = p.tenant.Dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = p.env()
+	// Terminal signals go to the supervisor alone, which stops backends in order
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	p.mu.Lock()
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	p.cmd = cmd
+	p.mu.Unlock()
+	logger.LogInfo("Started tenant %s backend as process %d", p.tenant.ID, cmd.Process.Pid)
+
+	err := cmd.Wait()
+
+	p.mu.Lock()
+	p.cmd = nil
+	p.mu.Unlock()
+	if err == nil {
+		err = fmt.Errorf("exited without being asked to")
+	}
+	return err
+}
+
+// env is the shared environment with the tenant's settings applied. The backend
+// always serves plain HTTP on its socket; TLS is terminated here.
+func (p *tenantProcess) env() []string {
+	env := p.tenant.Env(restart.Env())
+	// Later entries win, so these cannot be overridden by tenant settings
+	env = append(env,
+		"SERVER_SOCKET="+p.socket,
+		"LISTEN_PID=",
+		"LISTEN_FDS=",
+		config.EnvSettingName("TENANTS_FILE")+"=",
+		config.EnvSettingName("TLS_CERT_FILE")+"=",
+		config.EnvSettingName("TLS_KEY_FILE")+"=",
+		config.EnvSettingName("TLS_AUTOCERT_DOMAINS")+"=",
+	)
+	return env
+}
+
+func (p *tenantProcess) signal(sig os.Signal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil && p.cmd.Process != nil {
+		p.cmd.Process.Signal(sig)
+	}
+}
+
+// ready reports whether the backend answers its readiness check
+func (p *tenantProcess) ready() bool {
+	resp, err := p.client().Get("http://" + p.tenant.ID + "/readyz")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (p *tenantProcess) client() *http.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.probe == nil {
+		socket := p.socket
+		p.probe = &http.Client{
+			Timeout: 3 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	}
+	return p.probe
+}
@@ -0,0 +1,155 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"sbcbackend/internal/clock"
+	"sbcbackend/internal/config"
+	"sbcbackend/internal/logger"
+)
+
+// certCheckInterval is how often a manually configured certificate is checked for renewal
+const certCheckInterval = time.Minute
+
+// configureTLS sets server up to serve HTTPS as described by settings. It returns the
+// plain HTTP server that answers ACME challenges and redirects to HTTPS, or nil when
+// no HTTP listener is configured.
+func configureTLS(server *http.Server, settings config.TLSSettings) (*http.Server, error) {
+	_, port, err := net.SplitHostPort(server.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address %q: %w", server.Addr, err)
+	}
+	redirect := redirectToHTTPS(port)
+
+	if settings.Autocert() {
+		if port != "443" {
+			logger.LogWarn("Autocert is enabled but the server is on port %s; Let's Encrypt only validates on 443 and 80", port)
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(settings.AutocertDomains...),
+			Cache:      autocert.DirCache(settings.AutocertCacheDir),
+			Email:      settings.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = manager.HTTPHandler(redirect)
+		logger.LogInfo("TLS certificates for %v from Let's Encrypt, cached in %s", settings.AutocertDomains, settings.AutocertCacheDir)
+	} else {
+		certs := &certReloader{certFile: settings.CertFile, keyFile: settings.KeyFile}
+		if _, err := certs.GetCertificate(nil); err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
+	if settings.HTTPAddr == "" {
+		return nil, nil
+	}
+	return &http.Server{
+		Addr:              settings.HTTPAddr,
+		Handler:           redirect,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}, nil
+}
+
+// disableHTTP2 keeps server on HTTP/1.1: it stops offering h2 during the TLS
+// handshake and leaves no handler to upgrade to it
+func disableHTTP2(server *http.Server) {
+	server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	var protos []string
+	for _, proto := range server.TLSConfig.NextProtos {
+		if proto != "h2" {
+			protos = append(protos, proto)
+		}
+	}
+	server.TLSConfig.NextProtos = protos
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host and path over HTTPS on port
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// Middleware: tell browsers to stay on HTTPS once they have reached it
+func strictTransportSecurity(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// certReloader serves a certificate from disk and picks up renewals (certbot and
+// similar tools rewrite the files in place) without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && clock.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = clock.Now()
+
+	if err := c.reload(); err != nil {
+		if c.cert == nil {
+			return nil, err
+		}
+		// A renewal caught half-written must not take the site down
+		logger.LogWarn("Keeping current TLS certificate: %v", err)
+	}
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil && !info.ModTime().After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	logger.LogInfo("Loaded TLS certificate from %s", c.certFile)
+	return nil
+}